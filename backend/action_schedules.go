@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getActionSchedules returns the caller's recurring actions, oldest first
+func (a *App) getActionSchedules(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": a.schedules.Schedules(userID)})
+}
+
+// deleteActionSchedule stops one of the caller's recurring actions
+func (a *App) deleteActionSchedule(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	schedule, err := a.schedules.Delete(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "schedule_not_found",
+			Message: "Action schedule not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// resumeActionSchedule reactivates one of the caller's recurring actions
+// paused after failing, from its next occurrence
+func (a *App) resumeActionSchedule(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	schedule, err := a.schedules.Resume(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "schedule_not_found",
+			Message: "Action schedule not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// actionAuditFlushRows is how many CSV rows are written between flushes
+const actionAuditFlushRows = 100
+
+// parseAuditTime reads an optional RFC 3339 time from a query parameter
+func parseAuditTime(c *gin.Context, name string) (time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, true
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_range",
+			Message: "from and to must be RFC 3339 times such as 2025-01-02T15:04:05Z",
+		})
+		return time.Time{}, false
+	}
+	return parsed, true
+}
+
+// getActionAudit returns the lifecycle events of the actions executed for the
+// caller, as JSON or a streamed CSV download
+func (a *App) getActionAudit(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	from, ok := parseAuditTime(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := parseAuditTime(c, "to")
+	if !ok {
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_range",
+			Message: "to must not be before from",
+		})
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"address": userID,
+			"records": a.audit.Records(userID, from, to),
+		})
+
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="action-audit.csv"`)
+		c.Status(http.StatusOK)
+		if err := a.audit.WriteCSV(c.Writer, userID, from, to, actionAuditFlushRows); err != nil {
+			a.logger.WithError(err).Warn("Failed to stream action audit export")
+		}
+
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_format",
+			Message: "Format must be json or csv",
+		})
+	}
+}
+
+// cancelAction cancels one of the caller's actions. Before broadcast it is
+// stopped; after, its transaction is replaced with a no-op where the relayer
+// allows it, otherwise the response is 409 too_late with the transaction hash.
+func (a *App) cancelAction(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	cancellation, err := a.actions.Cancel(ctx, userID, c.Param("id"))
+	var tooLate *services.ActionTooLateError
+	switch {
+	case errors.Is(err, services.ErrActionNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "action_not_found",
+			Message: "Action not found",
+		})
+	case errors.Is(err, services.ErrActionForbidden):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Action belongs to another user",
+		})
+	case errors.As(err, &tooLate):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "too_late",
+			"message": tooLate.Error(),
+			"status":  tooLate.Status,
+			"tx_hash": tooLate.TxHash,
+		})
+	case err != nil:
+		a.logger.WithError(err).Error("Failed to cancel action")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "cancel_failed",
+			Message: "Failed to cancel the action",
+		})
+	default:
+		c.JSON(http.StatusOK, cancellation)
+	}
+}
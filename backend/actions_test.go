@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func setupActionAuditApp() *App {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	app := &App{
+		router:   gin.New(),
+		logger:   logger,
+		audit:    services.NewActionAuditLog(),
+		sessions: services.NewSessions(),
+	}
+	app.router.Use(app.authenticate())
+	app.router.GET("/api/v1/actions/audit", app.getActionAudit)
+	return app
+}
+
+func TestActionAuditEndpoint(t *testing.T) {
+	app := setupActionAuditApp()
+	at := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	app.audit.Append(services.ActionAuditRecord{ActionID: "a1", UserID: usageAlice, MessageID: "m1", Event: services.ActionEventProposed, ActionType: "stake", Timestamp: at})
+	app.audit.Append(services.ActionAuditRecord{ActionID: "a1", UserID: usageAlice, Event: services.ActionEventMined, ActionType: "stake", TxHash: "0xabc", Timestamp: at.Add(time.Hour)})
+	app.audit.Append(services.ActionAuditRecord{ActionID: "b1", UserID: usageBob, Event: services.ActionEventProposed, ActionType: "vote", Timestamp: at})
+
+	get := func(query, caller string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/actions/audit"+query, nil)
+		if caller != "" {
+			req.Header.Set("Authorization", signInAs(app, caller))
+		}
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = get("?to=2025-05-01T12:30:00Z", usageAlice)
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Records []services.ActionAuditRecord `json:"records"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.Records, 1)
+	assert.Equal(t, "m1", body.Records[0].MessageID)
+
+	w = get("?format=csv&from=2025-05-01T00:00:00Z", usageAlice)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "1,2025-05-01T12:00:00Z,a1,proposed,stake,m1,,", lines[1])
+	assert.Equal(t, "2,2025-05-01T13:00:00Z,a1,mined,stake,,0xabc,", lines[2])
+	assert.NotContains(t, w.Body.String(), "b1")
+
+	assert.Equal(t, http.StatusBadRequest, get("?format=xml", usageAlice).Code)
+	assert.Equal(t, http.StatusBadRequest, get("?from=yesterday", usageAlice).Code)
+	assert.Equal(t, http.StatusBadRequest, get("?from=2025-05-02T00:00:00Z&to=2025-05-01T00:00:00Z", usageAlice).Code)
+}
+
+func TestCancelActionEndpoint(t *testing.T) {
+	app := setupActionAuditApp()
+	app.actions = services.NewActionQueue(services.SimulatedActionSubmitter{}, app.audit, 0)
+	app.router.POST("/api/v1/actions/:id/cancel", app.cancelAction)
+	app.actions.Enqueue(&services.ActionRequest{ID: "action_1", UserID: usageAlice, ActionType: "stake"}, "m1")
+
+	cancel := func(id, caller string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/actions/"+id+"/cancel", nil)
+		if caller != "" {
+			req.Header.Set("Authorization", signInAs(app, caller))
+		}
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, cancel("action_1", "").Code)
+	assert.Equal(t, http.StatusNotFound, cancel("action_404", usageAlice).Code)
+
+	// Someone else's action can't be cancelled
+	w := cancel("action_1", usageBob)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "forbidden")
+
+	w = cancel("action_1", usageAlice)
+	require.Equal(t, http.StatusOK, w.Code)
+	var cancelled services.ActionCancellation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cancelled))
+	assert.Equal(t, services.ActionStatusCancelled, cancelled.Action.Status)
+	records := app.audit.Records(usageAlice, time.Time{}, time.Time{})
+	require.Len(t, records, 1)
+	assert.Equal(t, services.ActionEventCancelled, records[0].Event)
+
+	// The simulated relayer can't replace a broadcast transaction
+	app.actions.Enqueue(&services.ActionRequest{ID: "action_2", UserID: usageAlice, ActionType: "stake"}, "m2")
+	require.Equal(t, 1, app.actions.SubmitDue(context.Background()))
+	w = cancel("action_2", usageAlice)
+	require.Equal(t, http.StatusConflict, w.Code)
+	var body struct {
+		Error  string `json:"error"`
+		TxHash string `json:"tx_hash"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "too_late", body.Error)
+	assert.Equal(t, "0x1234567890abcdef...", body.TxHash)
+}
+
+// setupChatActionApp serves chat messages to an engine with recurring
+// actions, as the signed-in caller
+func setupChatActionApp(t *testing.T) *App {
+	gin.SetMode(gin.TestMode)
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 100})
+	app.sessions = services.NewSessions()
+	app.schedules = services.NewActionSchedules()
+	app.chatEngine.SetActionSchedules(app.schedules)
+	app.router = gin.New()
+	app.router.Use(app.authenticate())
+	app.router.POST("/api/v1/chat/message", app.processChatMessage)
+	return app
+}
+
+// sendChat posts a chat message as the caller, anonymously when empty
+func sendChat(t *testing.T, app *App, caller, body string) services.ChatResponse {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/chat/message", strings.NewReader(body))
+	if caller != "" {
+		req.Header.Set("Authorization", signInAs(app, caller))
+	}
+	app.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response services.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestChatSchedulesBindToSession(t *testing.T) {
+	app := setupChatActionApp(t)
+	body := `{"user_id": "` + usageBob + `", "message": "Swap 1 ETH for USDC every Friday at 9am"}`
+
+	// Without a session, naming a wallet doesn't schedule actions for it
+	response := sendChat(t, app, "", body)
+	assert.False(t, response.Success)
+	assert.Contains(t, response.Response, "sign in")
+	assert.Empty(t, app.schedules.Schedules(usageBob))
+	assert.Empty(t, app.schedules.Schedules(services.ChatAnonymousUser))
+
+	response = sendChat(t, app, usageAlice, body)
+	require.True(t, response.Success, response.Response)
+	assert.Len(t, app.schedules.Schedules(usageAlice), 1)
+	assert.Empty(t, app.schedules.Schedules(usageBob))
+}
+
+func TestChatCancelsOnlyTheCallersActions(t *testing.T) {
+	app := setupChatActionApp(t)
+	app.audit = services.NewActionAuditLog()
+	app.actions = services.NewActionQueue(services.SimulatedActionSubmitter{}, app.audit, time.Minute)
+	app.chatEngine.SetActionQueue(app.actions)
+	app.actions.Enqueue(&services.ActionRequest{ID: "action_1", UserID: usageAlice, ActionType: "stake"}, "m1")
+	body := `{"user_id": "` + usageAlice + `", "message": "cancel action_1"}`
+
+	// Claiming to be alice cancels nothing, signed in as someone else or not
+	response := sendChat(t, app, usageBob, body)
+	assert.False(t, response.Success)
+	assert.Contains(t, response.Response, "couldn't find action action_1")
+	response = sendChat(t, app, "", body)
+	assert.False(t, response.Success)
+	assert.Contains(t, response.Response, "sign in")
+	assert.Empty(t, app.audit.Records(usageAlice, time.Time{}, time.Time{}))
+
+	response = sendChat(t, app, usageAlice, body)
+	require.True(t, response.Success, response.Response)
+	records := app.audit.Records(usageAlice, time.Time{}, time.Time{})
+	require.Len(t, records, 1)
+	assert.Equal(t, services.ActionEventCancelled, records[0].Event)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getAddressSummary returns balances and recent activity for an address, or
+// with scope=all_wallets for the caller's linked wallets together
+func (a *App) getAddressSummary(c *gin.Context) {
+	addressStr := c.Param("address")
+
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	rate, ok := a.displayRate(c)
+	if !ok {
+		return
+	}
+	wallets, ok := a.scopeWallets(c, common.HexToAddress(addressStr))
+	if !ok {
+		return
+	}
+	fields, ok := responseFields[services.AddressSummary](c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	options := services.SummaryOptions{IncludeSpam: c.Query("include_spam") == "true"}
+	summary, err := a.summaries.SummarizeWallets(ctx, wallets, options)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to summarize address")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "summary_failed",
+			Message: "Failed to retrieve address summary",
+		})
+		return
+	}
+
+	if rate.Currency != "USD" {
+		summary = summary.InCurrency(rate)
+	}
+	c.JSON(http.StatusOK, fields.Project(summary))
+}
+
+// getAddressTokens returns the tracked token holdings of an address, most
+// valuable first, with spam hidden unless include_spam=true
+func (a *App) getAddressTokens(c *gin.Context) {
+	addressStr := c.Param("address")
+
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	ref, header, ok := a.resolveBlockQuery(ctx, c)
+	if !ok {
+		return
+	}
+
+	// Past balances are priced when their block was produced, and read from
+	// the archive node once the primary pruned their state
+	var holdings []services.TokenHolding
+	var err error
+	source := services.HistorySourcePrimary
+	if ref.Latest() {
+		holdings, err = a.tokenBalances.TokenBalances(ctx, common.HexToAddress(addressStr))
+	} else {
+		source, err = a.history.Read(ctx, header.Number, func(client services.ChainClient) error {
+			var err error
+			holdings, err = a.tokenBalances.WithCaller(client).TokenBalancesAt(ctx, common.HexToAddress(addressStr), header.Number, time.Unix(int64(header.Time), 0).UTC())
+			return err
+		})
+	}
+	if services.IsStatePruned(err) {
+		a.stateReadFailed(c, err, header, "tokens_failed", "Failed to retrieve token balances")
+		return
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to read token balances")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "tokens_failed",
+			Message: "Failed to retrieve token balances",
+		})
+		return
+	}
+
+	hidden := 0
+	if c.Query("include_spam") != "true" {
+		holdings, hidden = services.FilterSpam(holdings)
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].ValueUSD != holdings[j].ValueUSD {
+			return holdings[i].ValueUSD > holdings[j].ValueUSD
+		}
+		return holdings[i].Symbol < holdings[j].Symbol
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"address":            common.HexToAddress(addressStr).Hex(),
+		"tokens":             holdings,
+		"count":              len(holdings),
+		"hidden_spam_tokens": hidden,
+		"block_number":       header.Number.Uint64(),
+		"source":             source,
+	})
+}
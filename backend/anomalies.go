@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+const maxAnomalyWindow = 30 * 24 * time.Hour
+
+// getAnomalies runs rolling z-score detection over a collected time series
+func (a *App) getAnomalies(c *gin.Context) {
+	metric := c.Query("metric")
+	if metric != services.MetricGasPrice && metric != services.MetricTxVolume && !strings.HasPrefix(metric, "price:") {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_metric",
+			Message: "Metric must be gas_price, tx_volume, or price:<SYMBOL>",
+		})
+		return
+	}
+	if strings.HasPrefix(metric, "price:") {
+		metric = services.PriceMetric(strings.TrimPrefix(metric, "price:"))
+	}
+
+	window, err := time.ParseDuration(c.DefaultQuery("window", "24h"))
+	if err != nil || window <= 0 || window > maxAnomalyWindow {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_window",
+			Message: "Window must be a duration such as 24h, up to 720h",
+		})
+		return
+	}
+
+	lookback, err := strconv.Atoi(c.DefaultQuery("lookback", strconv.Itoa(services.DefaultAnomalyLookback)))
+	if err != nil || lookback < 2 || lookback > 1000 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_lookback",
+			Message: "Lookback must be between 2 and 1000 points",
+		})
+		return
+	}
+
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", strconv.FormatFloat(services.DefaultAnomalyThreshold, 'f', -1, 64)), 64)
+	if err != nil || threshold <= 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_threshold",
+			Message: "Threshold must be a positive number",
+		})
+		return
+	}
+
+	anomalies, scored := a.dataCollector.Series().DetectSince(metric, time.Now().Add(-window), lookback, threshold)
+	c.JSON(http.StatusOK, gin.H{
+		"metric":    metric,
+		"window":    window.String(),
+		"lookback":  lookback,
+		"threshold": threshold,
+		"points":    scored,
+		"anomalies": anomalies,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"kaia-analytics-backend/services"
+)
+
+// publicResponseTypes are the types the API returns as JSON
+var publicResponseTypes = []interface{}{
+	HealthResponse{},
+	BlockResponse{},
+	TransactionResponse{},
+	NetworkStatsResponse{},
+	services.ChatResponse{},
+	services.ActionRequest{},
+	services.AnalyticsResult{},
+	services.YieldOpportunity{},
+	services.MarketData{},
+	services.BlockchainData{},
+	services.ProtocolData{},
+	services.OutcomePrediction{},
+	services.AddressSummary{},
+	services.Anomaly{},
+	services.PortfolioPerformance{},
+	services.Notification{},
+	services.Report{},
+	services.YieldTrend{},
+	services.FeeSpendReport{},
+	services.CongestionReport{},
+	services.HolderDistribution{},
+	services.ChatMetricsSnapshot{},
+	services.Webhook{},
+	services.ActionAuditRecord{},
+}
+
+// isTimestampName reports whether a JSON field name holds a point in time
+func isTimestampName(name string) bool {
+	switch name {
+	case "timestamp", "last_updated", "first_seen", "block_time":
+		return true
+	}
+	return strings.HasSuffix(name, "_timestamp") || strings.HasSuffix(name, "_at")
+}
+
+// checkTimestampFields reports the timestamp fields of a type that aren't
+// RFC 3339 times, and *_unix fields without the time they duplicate
+func checkTimestampFields(t *testing.T, typ reflect.Type, path string, visited map[reflect.Type]bool) {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array || typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	apiTime := reflect.TypeOf(services.APITime{})
+	stdTime := reflect.TypeOf(time.Time{})
+	if typ.Kind() != reflect.Struct || typ == apiTime || typ == stdTime || visited[typ] {
+		return
+	}
+	visited[typ] = true
+
+	names := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		names[jsonName(typ.Field(i))] = true
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := jsonName(field)
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		switch {
+		case strings.HasSuffix(name, "_unix"):
+			assert.True(t, names[strings.TrimSuffix(name, "_unix")],
+				"%s.%s is a unix timestamp without an RFC 3339 sibling", path, name)
+		case isTimestampName(name):
+			assert.True(t, fieldType == apiTime || fieldType == stdTime,
+				"%s.%s is a %s, not an RFC 3339 time", path, name, fieldType)
+		default:
+			checkTimestampFields(t, field.Type, path+"."+name, visited)
+		}
+	}
+}
+
+// jsonName returns the name a field is encoded under
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func TestResponsesUseRFC3339Timestamps(t *testing.T) {
+	for _, response := range publicResponseTypes {
+		typ := reflect.TypeOf(response)
+		checkTimestampFields(t, typ, typ.Name(), make(map[reflect.Type]bool))
+	}
+}
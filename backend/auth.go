@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"kaia-analytics-backend/services"
+)
+
+// callerKey is the context key the signed-in caller's address is kept under
+const callerKey = "caller"
+
+// signInRequestsPerMinute is how many sign-in requests an IP can make a minute
+const signInRequestsPerMinute = 10
+
+// bearerToken returns the token of an "Authorization: Bearer" header. Browsers
+// can't set headers on WebSocket upgrades, so those may carry it in the
+// access_token query parameter instead.
+func bearerToken(c *gin.Context) string {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		if websocket.IsWebSocketUpgrade(c.Request) {
+			return c.Query("access_token")
+		}
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authenticate identifies callers by the session token they signed in for.
+// Requests without a valid token go on anonymously; the admin API key shares
+// the header and simply isn't a session.
+func (a *App) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.sessions != nil {
+			if address, ok := a.sessions.Authenticate(bearerToken(c)); ok {
+				c.Set(callerKey, address)
+			}
+		}
+		c.Next()
+	}
+}
+
+// callerAddress returns the lowercased wallet address the caller signed in as
+func callerAddress(c *gin.Context) (string, bool) {
+	address := c.GetString(callerKey)
+	return address, address != ""
+}
+
+// chatUser is who the chat messages of a request are from: the signed-in
+// caller, or services.ChatAnonymousUser. User IDs sent with the messages are
+// never trusted.
+func chatUser(c *gin.Context) string {
+	if caller, ok := callerAddress(c); ok {
+		return caller
+	}
+	return services.ChatAnonymousUser
+}
+
+// requireCaller aborts the request unless the caller signed in
+func requireCaller(c *gin.Context) (string, bool) {
+	address, ok := callerAddress(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "unauthenticated",
+			Message: "Sign in with a wallet and send the session token as a bearer token",
+		})
+	}
+	return address, ok
+}
+
+// limitSignIn applies the sign-in rate limit of the caller's IP, responding
+// with 429 once it is used up
+func (a *App) limitSignIn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.authLimiter == nil {
+			c.Next()
+			return
+		}
+		key := "ip:" + c.ClientIP()
+		decision, status := a.authLimiter.Check(key)
+		setRateLimitHeaders(c, status)
+		if decision == services.RateMuted || decision == services.RateClose {
+			if retryAfter := a.authLimiter.MutedFor(key); retryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.5)))
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, ErrorResponse{
+				Error:   "rate_limited",
+				Message: "Too many sign-in requests, please slow down",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// createSignInChallenge issues the message a wallet signs to sign in
+func (a *App) createSignInChallenge(c *gin.Context) {
+	var request struct {
+		Address string `json:"address" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || !common.IsHexAddress(request.Address) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Body must name the wallet address signing in",
+		})
+		return
+	}
+
+	challenge, err := a.sessions.Challenge(common.HexToAddress(request.Address))
+	if errors.Is(err, services.ErrSessionChallengeLimit) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "challenge_limit",
+			Message: "Too many sign-ins are in progress, try again shortly",
+		})
+		return
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to issue sign-in challenge")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "challenge_failed",
+			Message: "Failed to issue a sign-in challenge",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, challenge)
+}
+
+// signIn trades a wallet's personal_sign signature of a challenge message for
+// a session token
+func (a *App) signIn(c *gin.Context) {
+	var request struct {
+		Nonce     string `json:"nonce" binding:"required"`
+		Signature string `json:"signature" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must carry the challenge nonce and its signature",
+		})
+		return
+	}
+
+	session, err := a.sessions.SignIn(request.Nonce, request.Signature)
+	switch {
+	case errors.Is(err, services.ErrSessionChallenge), errors.Is(err, services.ErrSessionSignature):
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "sign_in_failed",
+			Message: err.Error(),
+		})
+	case err != nil:
+		a.logger.WithError(err).Error("Failed to start session")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "sign_in_failed",
+			Message: "Failed to start a session",
+		})
+	default:
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// signOut ends the session of the caller's token
+func (a *App) signOut(c *gin.Context) {
+	if !a.sessions.Revoke(bearerToken(c)) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "unauthenticated",
+			Message: "No session matches the bearer token",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
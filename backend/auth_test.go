@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// signInAs starts a session for the caller and returns the Authorization
+// header carrying it
+func signInAs(app *App, caller string) string {
+	session, err := app.sessions.Issue(common.HexToAddress(caller))
+	if err != nil {
+		panic(err)
+	}
+	return "Bearer " + session.Token
+}
+
+func TestWalletSignIn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{router: gin.New(), logger: logrus.New(), sessions: services.NewSessions()}
+	app.router.Use(app.authenticate())
+	app.router.POST("/auth/challenge", app.createSignInChallenge)
+	app.router.POST("/auth/session", app.signIn)
+	app.router.DELETE("/auth/session", app.signOut)
+	app.router.GET("/whoami", func(c *gin.Context) {
+		if caller, ok := requireCaller(c); ok {
+			c.String(http.StatusOK, caller)
+		}
+	})
+
+	serve := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		// A claimed address alone identifies no one
+		req.Header.Set("X-Wallet-Address", "0x00000000000000000000000000000000000000aa")
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/whoami", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/whoami", "Bearer forged", "").Code)
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	w := serve("POST", "/auth/challenge", "", `{"address": "`+wallet.Hex()+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var challenge services.SessionChallenge
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+
+	signature, err := crypto.Sign(accounts.TextHash([]byte(challenge.Message)), key)
+	require.NoError(t, err)
+	w = serve("POST", "/auth/session", "", `{"nonce": "`+challenge.Nonce+`", "signature": "`+hexutil.Encode(signature)+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var session services.Session
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+
+	// The nonce can't be used twice
+	w = serve("POST", "/auth/session", "", `{"nonce": "`+challenge.Nonce+`", "signature": "`+hexutil.Encode(signature)+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve("GET", "/whoami", "Bearer "+session.Token, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, strings.ToLower(wallet.Hex()), w.Body.String())
+
+	assert.Equal(t, http.StatusNoContent, serve("DELETE", "/auth/session", "Bearer "+session.Token, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/whoami", "Bearer "+session.Token, "").Code)
+}
+
+func TestChatIgnoresClaimedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newChatBatchTestApp(t, 100)
+	app.sessions = services.NewSessions()
+	transcripts := services.NewChatTranscripts()
+	app.chatEngine.SetTranscripts(transcripts)
+	app.router = gin.New()
+	app.router.Use(app.authenticate())
+	app.router.POST("/chat/message", app.processChatMessage)
+	app.router.POST("/chat/batch", app.processChatBatch)
+	app.router.GET("/ws", app.handleWebSocket)
+
+	caller := "0x00000000000000000000000000000000000000cc"
+	victim := "0x00000000000000000000000000000000000000aa"
+	authorization := signInAs(app, caller)
+	exchanges := func(userID, sessionID string) int {
+		session, _ := transcripts.Session(userID, sessionID)
+		return len(session)
+	}
+
+	post := func(path, authorization, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+	w := post("/chat/message", authorization, `{"user_id": "`+victim+`", "session_id": "rest", "message": "hello"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, exchanges(caller, "rest"))
+	assert.Zero(t, exchanges(victim, "rest"))
+
+	// Without a session, messages are anonymous whatever they claim
+	w = post("/chat/message", "", `{"user_id": "`+victim+`", "session_id": "anonymous", "message": "hello"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, exchanges(services.ChatAnonymousUser, "anonymous"))
+	assert.Zero(t, exchanges(victim, "anonymous"))
+
+	w = post("/chat/batch", authorization, `{"messages": [
+		{"user_id": "`+victim+`", "session_id": "batch", "message": "hello"},
+		{"user_id": "0x00000000000000000000000000000000000000bb", "session_id": "batch", "message": "hello"}
+	]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var batch ChatBatchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &batch))
+	for _, result := range batch.Results {
+		assert.Equal(t, caller, result.UserID)
+	}
+	assert.Equal(t, 2, exchanges(caller, "batch"))
+	assert.Zero(t, exchanges(victim, "batch"))
+
+	// Browsers send the session token of a WebSocket in the query
+	server := httptest.NewServer(app.router)
+	defer server.Close()
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user_id=" + victim + "&access_token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(services.ChatMessage{UserID: victim, SessionID: "socket", Message: "hello"}))
+	var response services.ChatResponse
+	require.NoError(t, conn.ReadJSON(&response))
+	assert.Equal(t, 1, exchanges(caller, "socket"))
+	assert.Zero(t, exchanges(victim, "socket"))
+}
+
+func TestSignInRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{router: gin.New(), logger: logrus.New(), sessions: services.NewSessions(),
+		authLimiter: services.NewChatRateLimiter(services.ChatRateLimitConfig{PerMinute: signInRequestsPerMinute})}
+	auth := app.router.Group("/auth", app.limitSignIn())
+	auth.POST("/challenge", app.createSignInChallenge)
+
+	var codes []int
+	for i := 0; i <= signInRequestsPerMinute; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/auth/challenge", strings.NewReader(`{"address": "0x00000000000000000000000000000000000000aa"}`))
+		app.router.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, http.StatusOK, codes[signInRequestsPerMinute-1])
+	assert.Equal(t, http.StatusTooManyRequests, codes[signInRequestsPerMinute])
+}
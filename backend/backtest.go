@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// startBacktest replays a strategy over the recorded prices of a pair. The
+// replay runs in the background, so it responds 202 with the task.
+func (a *App) startBacktest(c *gin.Context) {
+	var spec services.BacktestSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	task, err := a.backtests.Submit(spec)
+	if errors.Is(err, services.ErrInvalidBacktest) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_backtest",
+			Message: strings.TrimPrefix(err.Error(), services.ErrInvalidBacktest.Error()+": "),
+		})
+		return
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to start backtest")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "backtest_failed",
+			Message: "Failed to start backtest",
+		})
+		return
+	}
+
+	c.Header("Location", "/api/v1/analytics/backtest/"+task.ID)
+	c.JSON(http.StatusAccepted, task)
+}
+
+// getBacktestTask returns a backtest's progress, and its result once done
+func (a *App) getBacktestTask(c *gin.Context) {
+	task, ok := a.backtests.Task(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "backtest_not_found",
+			Message: "Backtest task not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
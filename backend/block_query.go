@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// resolveBlockQuery resolves the block query parameter, the latest block when
+// absent, aborting the request when it is malformed or names a block the
+// node doesn't have
+func (a *App) resolveBlockQuery(ctx context.Context, c *gin.Context) (services.BlockRef, *types.Header, bool) {
+	ref, err := services.ParseBlockRef(c.Query("block"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_block",
+			Message: err.Error(),
+		})
+		return ref, nil, false
+	}
+
+	header, err := services.ResolveBlock(ctx, a.ethClient, ref)
+	if errors.Is(err, services.ErrBlockNotFound) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "block_not_found",
+			Message: fmt.Sprintf("Block %s not found", ref),
+		})
+		return ref, nil, false
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to resolve block")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "block_fetch_failed",
+			Message: "Failed to retrieve block information",
+		})
+		return ref, nil, false
+	}
+	return ref, header, true
+}
+
+// stateReadFailed reports a failed read of chain state at a block. Reads no
+// configured node can answer because the block's state was pruned are 410s,
+// with the earliest block that can be read when it is known.
+func (a *App) stateReadFailed(c *gin.Context, err error, header *types.Header, code, message string) {
+	var unavailable *services.HistoryUnavailableError
+	if errors.As(err, &unavailable) {
+		c.JSON(http.StatusGone, gin.H{
+			"error":          "history_unavailable",
+			"message":        fmt.Sprintf("No configured node keeps the state of block %s; the earliest block with state is %d", header.Number, unavailable.EarliestBlock),
+			"earliest_block": unavailable.EarliestBlock,
+		})
+		return
+	}
+	if services.IsStatePruned(err) {
+		c.JSON(http.StatusGone, ErrorResponse{
+			Error:   "state_pruned",
+			Message: fmt.Sprintf("The node no longer keeps the state of block %s; query a recent block or an archive node", header.Number),
+		})
+		return
+	}
+	a.logger.WithError(err).Error(message)
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error:   code,
+		Message: message,
+	})
+}
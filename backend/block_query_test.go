@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// stateChain has blocks 0 through head and keeps state from keepFrom on,
+// like a node that prunes old state. The embedded interface is nil, so
+// anything else panics.
+type stateChain struct {
+	services.ChainClient
+
+	head     uint64
+	keepFrom uint64
+	code     []byte
+}
+
+func (sc *stateChain) header(number uint64) *types.Header {
+	return &types.Header{Number: new(big.Int).SetUint64(number), Time: 1_700_000_000 + number, Difficulty: new(big.Int)}
+}
+
+func (sc *stateChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return sc.head, nil
+}
+
+func (sc *stateChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		return sc.header(sc.head), nil
+	}
+	if number.Uint64() > sc.head {
+		return nil, ethereum.NotFound
+	}
+	return sc.header(number.Uint64()), nil
+}
+
+func (sc *stateChain) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	for number := uint64(0); number <= sc.head; number++ {
+		if header := sc.header(number); header.Hash() == hash {
+			return header, nil
+		}
+	}
+	return nil, ethereum.NotFound
+}
+
+// stateAt fails like geth does for pruned state
+func (sc *stateChain) stateAt(number *big.Int) error {
+	if number == nil {
+		return errors.New("state read without a block")
+	}
+	if number.Uint64() < sc.keepFrom {
+		return errors.New("missing trie node 1a2b3c (path ) state 0x1a2b3c is not available")
+	}
+	return nil
+}
+
+func (sc *stateChain) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	if err := sc.stateAt(blockNumber); err != nil {
+		return nil, err
+	}
+	// Balances grow by one KAIA a block
+	return new(big.Int).Mul(blockNumber, big.NewInt(1e18)), nil
+}
+
+func (sc *stateChain) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	if err := sc.stateAt(blockNumber); err != nil {
+		return nil, err
+	}
+	return sc.code, nil
+}
+
+func TestHistoricalStateQueries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	chain := &stateChain{head: 1000, code: []byte{0x60, 0x80}}
+	app := &App{router: gin.New(), logger: logger, ethClient: chain, history: services.NewHistoryRouter(chain)}
+	app.router.GET("/api/v1/address/:address/balance", app.getAddressBalance)
+	app.router.GET("/api/v1/contract/:address/info", app.getContractInfo)
+	get := func(path string, out interface{}) (int, historyFailure) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		app.router.ServeHTTP(w, req)
+		var failure historyFailure
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		} else {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &failure))
+		}
+		return w.Code, failure
+	}
+	balancePath := "/api/v1/address/0x00000000000000000000000000000000000000aa/balance"
+	infoPath := "/api/v1/contract/0x00000000000000000000000000000000000000bb/info"
+
+	// An archive node answers at every height
+	var balance BalanceResponse
+	code, _ := get(balancePath, &balance)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(1000), balance.BlockNumber)
+	assert.Equal(t, "1000000000000000000000", balance.Balance)
+
+	code, _ = get(balancePath+"?block=12", &balance)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(12), balance.BlockNumber)
+	assert.Equal(t, "12000000000000000000", balance.Balance)
+
+	code, _ = get(balancePath+"?block=0x10", &balance)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(16), balance.BlockNumber)
+
+	var info ContractInfoResponse
+	code, _ = get(infoPath+"?block="+chain.header(500).Hash().Hex(), &info)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(500), info.BlockNumber)
+	assert.True(t, info.IsContract)
+
+	code, _ = get(infoPath+"?block=earliest", &info)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(0), info.BlockNumber)
+
+	code, failure := get(balancePath+"?block=yesterday", &balance)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_block", failure.Error)
+	code, failure = get(balancePath+"?block=1001", &balance)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "block_not_found", failure.Error)
+	code, failure = get(infoPath+"?block="+common.HexToHash("0x1").Hex(), &info)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "block_not_found", failure.Error)
+
+	// A full node keeps only the recent state
+	chain.keepFrom = 900
+	code, failure = get(balancePath+"?block=12", &balance)
+	assert.Equal(t, http.StatusGone, code)
+	assert.Equal(t, "history_unavailable", failure.Error)
+	assert.Equal(t, uint64(900), failure.EarliestBlock)
+	code, failure = get(infoPath+"?block=899", &info)
+	assert.Equal(t, http.StatusGone, code)
+	assert.Equal(t, "history_unavailable", failure.Error)
+
+	code, _ = get(balancePath+"?block=950", &balance)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(950), balance.BlockNumber)
+	assert.Equal(t, services.HistorySourcePrimary, balance.Source)
+
+	// which an archive node makes up for
+	app.history.SetArchive(&stateChain{head: 1000, code: chain.code})
+	code, _ = get(balancePath+"?block=12", &balance)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "12000000000000000000", balance.Balance)
+	assert.Equal(t, services.HistorySourceArchive, balance.Source)
+	code, _ = get(infoPath+"?block=899", &info)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.HistorySourceArchive, info.Source)
+	code, _ = get(infoPath+"?block=900", &info)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.HistorySourcePrimary, info.Source)
+}
+
+// historyFailure is an error response, with the earliest block that can be
+// read when history is unavailable
+type historyFailure struct {
+	ErrorResponse
+	EarliestBlock uint64 `json:"earliest_block"`
+}
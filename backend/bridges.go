@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"kaia-analytics-backend/services"
+)
+
+// loadBridges builds the bridge detector from a configuration file, dialing
+// each counterpart chain it lists. The returned clients are already started
+// and are closed by the caller.
+func loadBridges(ctx context.Context, path string, opts services.FailoverOptions) (*services.BridgeDetector, []*services.FailoverClient, error) {
+	config, err := services.LoadBridgeConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	adapters, err := config.Adapters()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bridge config: %w", err)
+	}
+
+	bridges := services.NewBridgeDetector(adapters)
+	clients := make([]*services.FailoverClient, 0, len(config.Chains))
+	for _, chain := range config.Chains {
+		client, err := services.DialFailoverClient(ctx, chain.RPCURLs, opts)
+		if err != nil {
+			for _, dialed := range clients {
+				dialed.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to connect to %s: %w", chain.Name, err)
+		}
+		client.Start(ctx)
+		clients = append(clients, client)
+		bridges.SetCounterpart(chain.Name, services.NewChainBridgeLegSource(client, chain.Name, chain.BlockTime()))
+	}
+	return bridges, clients, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getCacheStats reports the key count, size, entry ages, and lookups of each
+// cache namespace, and what the primer has recomputed
+func (a *App) getCacheStats(c *gin.Context) {
+	stats := a.dataCollector.Cache().Stats()
+	total, size := 0, 0
+	for _, namespace := range stats {
+		total += namespace.Keys
+		size += namespace.SizeBytes
+	}
+	response := gin.H{
+		"namespaces":       stats,
+		"total_keys":       total,
+		"total_size_bytes": size,
+	}
+	if a.cachePrimer != nil {
+		response["priming"] = a.cachePrimer.Stats()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// clearCacheNamespace drops every entry of one cache namespace, leaving the
+// others intact
+func (a *App) clearCacheNamespace(c *gin.Context) {
+	namespace := c.Param("namespace")
+	cleared, err := a.dataCollector.Cache().Clear(namespace)
+	if errors.Is(err, services.ErrUnknownCacheNamespace) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "unknown_cache_namespace",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	a.logger.WithField("namespace", namespace).WithField("cleared", cleared).Info("Cache namespace cleared")
+	c.JSON(http.StatusOK, gin.H{
+		"namespace": namespace,
+		"cleared":   cleared,
+	})
+}
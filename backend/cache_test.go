@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestCacheAdminEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	collector := services.NewDataCollector(nil)
+	app := &App{router: gin.New(), logger: logrus.New(), dataCollector: collector}
+	app.router.GET("/admin/cache", app.getCacheStats)
+	app.router.DELETE("/admin/cache/:namespace", app.clearCacheNamespace)
+
+	collector.SetCachedData(services.CacheMarket, "KAIA", services.MarketData{Symbol: "KAIA"})
+	collector.SetCachedData(services.CacheMarket, "ETH", services.MarketData{Symbol: "ETH"})
+	collector.SetCachedData(services.CacheGas, "latest", map[string]interface{}{"current_gas_price": 25})
+	collector.SetCachedData(services.CacheBlocks, "latest", services.BlockchainData{BlockNumber: 7})
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+	keys := func() map[string]int {
+		w := request("GET", "/admin/cache")
+		require.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Namespaces []services.CacheNamespaceStats `json:"namespaces"`
+			TotalKeys  int                            `json:"total_keys"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		counts := map[string]int{"total": body.TotalKeys}
+		for _, namespace := range body.Namespaces {
+			counts[namespace.Namespace] = namespace.Keys
+		}
+		return counts
+	}
+
+	assert.Equal(t, map[string]int{"total": 4, "market": 2, "gas": 1, "blocks": 1, "yield": 0, "chat_history": 0, "overview": 0}, keys())
+
+	w := request("DELETE", "/admin/cache/market")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"namespace":"market","cleared":2}`, w.Body.String())
+	assert.Equal(t, map[string]int{"total": 2, "market": 0, "gas": 1, "blocks": 1, "yield": 0, "chat_history": 0, "overview": 0}, keys())
+
+	w = request("DELETE", "/admin/cache/sessions")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown_cache_namespace")
+	assert.Equal(t, 2, keys()["total"])
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// maxChatBatch is the most messages one batch request can carry
+const maxChatBatch = 20
+
+// ChatBatchRequest is a batch of chat messages, each with its own session. They
+// are all from the signed-in caller; user IDs they name are ignored.
+type ChatBatchRequest struct {
+	Messages []services.ChatMessage `json:"messages" binding:"required"`
+}
+
+// ChatBatchResult is the outcome of one message of a batch
+type ChatBatchResult struct {
+	Index     int                    `json:"index"`
+	MessageID string                 `json:"message_id"`
+	UserID    string                 `json:"user_id"`
+	Success   bool                   `json:"success"`
+	Response  *services.ChatResponse `json:"response,omitempty"`
+	Error     *ErrorResponse         `json:"error,omitempty"`
+}
+
+// ChatBatchResponse lists the results in the order the messages were sent
+type ChatBatchResponse struct {
+	Results          []ChatBatchResult `json:"results"`
+	Succeeded        int               `json:"succeeded"`
+	Failed           int               `json:"failed"`
+	ProcessingTimeMs float64           `json:"processing_time_ms"`
+}
+
+// isAdminRequest reports whether the request carries the admin API key. The
+// key is compared in constant time, so response times don't leak it, and no
+// request is an admin one while the key is unset.
+func (a *App) isAdminRequest(c *gin.Context) bool {
+	if a.config == nil || a.config.AdminAPIKey == "" {
+		return false
+	}
+	expected := []byte("Bearer " + a.config.AdminAPIKey)
+	return subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) == 1
+}
+
+// processChatBatch answers up to maxChatBatch chat messages at once on the
+// analytics worker pool. The batch counts once against the caller's chat rate
+// limit, or the client IP's without a session; one failing message doesn't
+// fail the others.
+func (a *App) processChatBatch(c *gin.Context) {
+	start := time.Now()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxChatBatch*services.ChatFrameLimit(a.chatEngine.MaxMessageLength()))
+
+	var request ChatBatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "batch_too_large",
+				Message: fmt.Sprintf("Messages can be at most %d characters", a.chatEngine.MaxMessageLength()),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	if len(request.Messages) == 0 || len(request.Messages) > maxChatBatch {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_batch_size",
+			Message: fmt.Sprintf("A batch must carry between 1 and %d messages", maxChatBatch),
+		})
+		return
+	}
+	if !a.allowChatRequest(c) {
+		return
+	}
+	user := chatUser(c)
+	for i := range request.Messages {
+		request.Messages[i].UserID = user
+	}
+
+	response := ChatBatchResponse{Results: make([]ChatBatchResult, len(request.Messages))}
+	var wg sync.WaitGroup
+	for i := range request.Messages {
+		message := &request.Messages[i]
+		result := &response.Results[i]
+		*result = ChatBatchResult{Index: i, MessageID: message.ID, UserID: message.UserID}
+
+		wg.Add(1)
+		err := a.analyticsEngine.Submit(func() {
+			defer wg.Done()
+			a.processBatchMessage(c, message, result)
+		})
+		if err != nil {
+			wg.Done()
+			a.logger.WithError(err).Error("Failed to submit chat batch message")
+			result.Error = &ErrorResponse{Error: "processing_failed", Message: "The message could not be scheduled"}
+		}
+	}
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	response.ProcessingTimeMs = float64(time.Since(start).Microseconds()) / 1000
+
+	a.logger.WithFields(logrus.Fields{
+		"messages":  len(request.Messages),
+		"succeeded": response.Succeeded,
+		"failed":    response.Failed,
+	}).Info("Processed chat batch")
+	c.JSON(http.StatusOK, response)
+}
+
+// processBatchMessage answers one message of a batch into its result
+func (a *App) processBatchMessage(c *gin.Context, message *services.ChatMessage, result *ChatBatchResult) {
+	chatResponse, err := a.chatEngine.ProcessMessage(c.Request.Context(), message)
+	switch {
+	case services.IsInvalidMessage(err):
+		code, text := invalidChatMessage(err)
+		result.Error = &ErrorResponse{Error: code, Message: text}
+	case err != nil:
+		result.Error = &ErrorResponse{Error: "processing_failed", Message: err.Error()}
+	default:
+		result.Success = true
+		result.Response = chatResponse
+		a.recordChatUsage(message.UserID)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func newChatBatchTestApp(t *testing.T, perMinute int) *App {
+	gin.SetMode(gin.TestMode)
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: perMinute, MuteDuration: time.Minute, MaxViolations: 3})
+	analyticsEngine, err := services.NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	app.analyticsEngine = analyticsEngine
+	t.Cleanup(func() { app.analyticsEngine.Close() })
+	app.config = &Config{AdminAPIKey: "admin-key"}
+	app.router = gin.New()
+	app.router.POST("/api/v1/chat/batch", app.processChatBatch)
+	return app
+}
+
+func postChatBatch(app *App, body, authorization string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/chat/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	app.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestChatBatchPartialSuccess(t *testing.T) {
+	app := newChatBatchTestApp(t, 100)
+	body := `{"messages": [
+		{"id": "a", "user_id": "0x00000000000000000000000000000000000000aa", "message": "hello"},
+		{"id": "b", "user_id": "0x00000000000000000000000000000000000000bb", "message": "   "},
+		{"id": "c", "user_id": "0x00000000000000000000000000000000000000aa", "message": "what is the price of ETH"}
+	]}`
+
+	recorder := postChatBatch(app, body, "")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var response ChatBatchResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+
+	require.Len(t, response.Results, 3)
+	for i, id := range []string{"a", "b", "c"} {
+		assert.Equal(t, i, response.Results[i].Index)
+		assert.Equal(t, id, response.Results[i].MessageID)
+	}
+	assert.True(t, response.Results[0].Success)
+	assert.Equal(t, "a", response.Results[0].Response.MessageID)
+	assert.False(t, response.Results[1].Success)
+	assert.Equal(t, "empty_message", response.Results[1].Error.Error)
+	assert.Nil(t, response.Results[1].Response)
+	assert.True(t, response.Results[2].Success)
+	assert.Equal(t, 2, response.Succeeded)
+	assert.Equal(t, 1, response.Failed)
+	assert.Greater(t, response.ProcessingTimeMs, 0.0)
+}
+
+func TestChatBatchCountsOnceAgainstRateLimit(t *testing.T) {
+	app := newChatBatchTestApp(t, 3)
+	message := `{"user_id": "0x00000000000000000000000000000000000000aa", "message": "hello"}`
+	body := `{"messages": [` + strings.Repeat(message+",", 4) + message + `]}`
+
+	// A limit of 3 per minute lets 3 batches of 5 messages through
+	for i := 0; i < 3; i++ {
+		recorder := postChatBatch(app, body, "")
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		var response ChatBatchResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Equal(t, 5, response.Succeeded)
+		assert.Equal(t, strconv.Itoa(2-i), recorder.Header().Get("RateLimit-Remaining"))
+	}
+
+	// The fourth is refused as a whole, whichever users its messages name
+	other := `{"user_id": "0x00000000000000000000000000000000000000bb", "message": "hello"}`
+	recorder := postChatBatch(app, `{"messages": [`+other+`]}`, "")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "rate_limited")
+
+	// Oversized batches are refused outright
+	body = `{"messages": [` + strings.Repeat(message+",", maxChatBatch) + message + `]}`
+	assert.Equal(t, http.StatusBadRequest, postChatBatch(app, body, "").Code)
+}
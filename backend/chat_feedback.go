@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// ChatFeedbackRequest rates a chat response by the token it was sent with
+type ChatFeedbackRequest struct {
+	Token   string `json:"feedback_token" binding:"required"`
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+// submitChatFeedback records the caller's rating of a response they were sent
+func (a *App) submitChatFeedback(c *gin.Context) {
+	caller, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var request ChatFeedbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+
+	feedback, err := a.feedback.Rate(request.Token, caller, request.Rating, request.Comment)
+	switch {
+	case errors.Is(err, services.ErrFeedbackNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "feedback_token_not_found",
+			Message: "Feedback token not found or expired",
+		})
+	case errors.Is(err, services.ErrFeedbackForbidden):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Only the user the response was sent to can rate it",
+		})
+	case errors.Is(err, services.ErrFeedbackDuplicate):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "already_rated",
+			Message: "This response was already rated",
+		})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_feedback", Message: err.Error()})
+	default:
+		c.JSON(http.StatusCreated, feedback)
+	}
+}
+
+// getChatFeedbackAccuracy reports the share of positive ratings per intent
+// and week over the last weeks, 8 by default
+func (a *App) getChatFeedbackAccuracy(c *gin.Context) {
+	weeks, err := strconv.Atoi(c.DefaultQuery("weeks", "8"))
+	if err != nil || weeks <= 0 || weeks > 52 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_weeks",
+			Message: "Weeks must be between 1 and 52",
+		})
+		return
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -7*weeks)
+	c.JSON(http.StatusOK, gin.H{
+		"since":    services.NewAPITime(since),
+		"weeks":    weeks,
+		"accuracy": a.feedback.IntentAccuracy(since),
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestChatFeedbackEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{feedback: services.NewChatFeedbackStore(), sessions: services.NewSessions(), router: gin.New()}
+	app.router.Use(app.authenticate())
+	app.router.POST("/api/v1/chat/feedback", app.submitChatFeedback)
+
+	owner := "0x00000000000000000000000000000000000000aa"
+	token, err := app.feedback.Issue(&services.ChatMessage{UserID: owner}, &services.ChatResponse{}, &services.QueryIntent{Intent: "gas_info"})
+	require.NoError(t, err)
+
+	rate := func(caller string) int {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/chat/feedback", strings.NewReader(`{"feedback_token": "`+token+`", "rating": 1}`))
+		if caller != "" {
+			req.Header.Set("Authorization", signInAs(app, caller))
+		}
+		app.router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, rate(""))
+	assert.Equal(t, http.StatusForbidden, rate("0x00000000000000000000000000000000000000bb"))
+	assert.Equal(t, http.StatusCreated, rate(owner))
+	assert.Equal(t, http.StatusConflict, rate(owner))
+}
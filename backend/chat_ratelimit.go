@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// chatConn is the part of a WebSocket connection the chat read loop uses
+type chatConn interface {
+	ReadJSON(v interface{}) error
+	WriteJSON(v interface{}) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+}
+
+// chatRateKey identifies the sender of a chat message for per-user limits:
+// the signed-in caller, or the IP of senders without a session, whatever
+// user they claim to be
+func chatRateKey(c *gin.Context) string {
+	if caller, ok := callerAddress(c); ok {
+		return "user:" + caller
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// limitChatFrame applies the chat rate limits to a WebSocket message. It
+// reports whether the message should be processed and whether the connection
+// should stay open, sending warning, mute, and close frames as needed.
+func (a *App) limitChatFrame(conn chatConn, session string, rateKeys []string, message *services.ChatMessage) (bool, bool) {
+	now := time.Now()
+	switch a.chatLimiter.Allow(rateKeys...) {
+	case services.RateClose:
+		a.logger.WithFields(logrus.Fields{
+			"session":   session,
+			"rate_keys": rateKeys,
+		}).Warn("Closing chat connection after repeated rate limit violations")
+
+		closeMessage := websocket.FormatCloseMessage(services.ChatRateLimitCloseCode, "repeated rate limit violations")
+		if err := conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+			a.logger.WithError(err).Error("Failed to send WebSocket close frame")
+		}
+		return false, false
+
+	case services.RateMuted:
+		retryAfter := a.chatLimiter.MutedFor(rateKeys...)
+		err := conn.WriteJSON(a.chatEngine.Sequence(session, &services.ChatResponse{
+			MessageID:     message.ID,
+			Type:          "rate_limited",
+			Response:      fmt.Sprintf("You're sending messages too quickly. Try again in %d seconds.", int(retryAfter.Seconds()+0.5)),
+			Timestamp:     services.NewAPITime(now),
+			TimestampUnix: now.Unix(),
+			Success:       false,
+			Metadata: map[string]interface{}{
+				"retry_after_seconds": retryAfter.Seconds(),
+			},
+		}))
+		return false, err == nil
+
+	case services.RateWarn:
+		err := conn.WriteJSON(a.chatEngine.Sequence(session, &services.ChatResponse{
+			MessageID:     message.ID,
+			Type:          "rate_limit_warning",
+			Response:      "You're close to the chat message limit. Further messages may be rejected for a while.",
+			Timestamp:     services.NewAPITime(now),
+			TimestampUnix: now.Unix(),
+			Success:       true,
+		}))
+		return err == nil, err == nil
+	}
+
+	return true, true
+}
+
+// allowChatRequest applies the chat rate limits to an HTTP chat request,
+// responding with 429 when the sender is muted. Every response carries the
+// sender's standing in RateLimit headers, so clients can slow down before
+// they are rejected.
+func (a *App) allowChatRequest(c *gin.Context) bool {
+	key := chatRateKey(c)
+
+	decision, status := a.chatLimiter.Check(key)
+	setRateLimitHeaders(c, status)
+	switch decision {
+	case services.RateClose, services.RateMuted:
+		if retryAfter := a.chatLimiter.MutedFor(key); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.5)))
+		}
+		a.logger.WithField("rate_key", key).Warn("Chat request rate limited")
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Error:   "rate_limited",
+			Message: "Too many chat messages, please slow down",
+		})
+		return false
+
+	case services.RateWarn:
+		c.Header("X-Chat-Rate-Limit-Warning", "approaching limit")
+	}
+
+	return true
+}
+
+// setRateLimitHeaders reports a sender's chat limit: RateLimit-Limit is the
+// messages allowed per minute, RateLimit-Remaining how many are left, and
+// RateLimit-Reset the seconds until more are allowed, rounded up
+func setRateLimitHeaders(c *gin.Context, status services.RateStatus) {
+	c.Header("RateLimit-Limit", strconv.Itoa(status.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(status.Reset.Seconds()))))
+}
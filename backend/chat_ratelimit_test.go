@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// fakeChatConn replays a fixed number of messages and records what is written back
+type fakeChatConn struct {
+	remaining int
+	writes    []*services.ChatResponse
+	closeCode int
+}
+
+func (f *fakeChatConn) ReadJSON(v interface{}) error {
+	if f.remaining == 0 {
+		return errors.New("EOF")
+	}
+	f.remaining--
+	message := v.(*services.ChatMessage)
+	message.ID = "msg"
+	message.UserID = "0x00000000000000000000000000000000000000aa"
+	message.Message = "hello"
+	return nil
+}
+
+func (f *fakeChatConn) WriteJSON(v interface{}) error {
+	f.writes = append(f.writes, v.(*services.ChatResponse))
+	return nil
+}
+
+func (f *fakeChatConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if messageType == websocket.CloseMessage && len(data) >= 2 {
+		f.closeCode = int(data[0])<<8 | int(data[1])
+	}
+	return nil
+}
+
+func (f *fakeChatConn) countType(responseType string) int {
+	count := 0
+	for _, write := range f.writes {
+		if write.Type == responseType {
+			count++
+		}
+	}
+	return count
+}
+
+func newChatRateLimitTestApp(t *testing.T, config services.ChatRateLimitConfig) *App {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	analyticsEngine, err := services.NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { analyticsEngine.Close() })
+
+	return &App{
+		logger:      logger,
+		chatEngine:  services.NewChatEngine(nil, analyticsEngine, services.NewDataCollector(nil)),
+		chatLimiter: services.NewChatRateLimiter(config),
+	}
+}
+
+func TestChatConnectionWarnsThenMutes(t *testing.T) {
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 10, MuteDuration: time.Minute, MaxViolations: 3})
+	conn := &fakeChatConn{remaining: 30}
+
+	app.serveChatConnection(context.Background(), conn, "user", "user", []string{"conn:1", "user:0xaa"})
+
+	assert.Equal(t, 1, conn.countType("rate_limit_warning"))
+	assert.Equal(t, 20, conn.countType("rate_limited"), "messages past the limit are rejected")
+	assert.Zero(t, conn.remaining, "muted connections stay open")
+	assert.Zero(t, conn.closeCode)
+
+	// The warning arrives on the 8th message, ahead of its response
+	require.GreaterOrEqual(t, len(conn.writes), 16)
+	assert.Equal(t, "rate_limit_warning", conn.writes[7].Type)
+	assert.Equal(t, "rate_limited", conn.writes[len(conn.writes)-1].Type)
+	assert.Contains(t, conn.writes[len(conn.writes)-1].Metadata, "retry_after_seconds")
+}
+
+func TestChatConnectionClosesOnRepeatedViolations(t *testing.T) {
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 5, MuteDuration: time.Minute, MaxViolations: 1})
+	conn := &fakeChatConn{remaining: 30}
+
+	app.serveChatConnection(context.Background(), conn, "user", "user", []string{"conn:1"})
+
+	assert.Equal(t, services.ChatRateLimitCloseCode, conn.closeCode)
+	assert.Equal(t, 24, conn.remaining, "the read loop stops after closing")
+}
+
+func TestChatRequestRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 2, MuteDuration: time.Minute, MaxViolations: 3})
+	app.router = gin.New()
+	app.router.POST("/api/v1/chat/message", func(c *gin.Context) {
+		if !app.allowChatRequest(c) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	codes := make([]int, 0, 3)
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		last = httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/chat/message", strings.NewReader(`{}`))
+		app.router.ServeHTTP(last, req)
+		codes = append(codes, last.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, "60", last.Header().Get("Retry-After"))
+
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(last.Body.Bytes(), &response))
+	assert.Equal(t, "rate_limited", response.Error)
+}
+
+func TestChatRequestRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 4, MuteDuration: time.Minute, MaxViolations: 3})
+	app.router = gin.New()
+	app.router.POST("/api/v1/chat/message", func(c *gin.Context) {
+		if !app.allowChatRequest(c) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	header := func(w *httptest.ResponseRecorder, name string) int {
+		value, err := strconv.Atoi(w.Header().Get(name))
+		require.NoError(t, err, name)
+		return value
+	}
+	var remaining []int
+	lastReset := 60
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/chat/message", strings.NewReader(`{}`))
+		app.router.ServeHTTP(w, req)
+
+		assert.Equal(t, 4, header(w, "RateLimit-Limit"))
+		remaining = append(remaining, header(w, "RateLimit-Remaining"))
+		reset := header(w, "RateLimit-Reset")
+		assert.Positive(t, reset)
+		assert.LessOrEqual(t, reset, lastReset, "the reset doesn't move away within the window")
+		lastReset = reset
+		if i < 4 {
+			assert.Equal(t, http.StatusOK, w.Code)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		}
+	}
+	assert.Equal(t, []int{3, 2, 1, 0, 0}, remaining)
+}
+
+func TestChatRateKeyIgnoresClaimedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/api/v1/chat/message", strings.NewReader(`{"user_id": "0x00000000000000000000000000000000000000aa"}`))
+	c.Request.RemoteAddr = "203.0.113.7:4242"
+
+	// Without a session, senders are told apart by IP alone
+	assert.Equal(t, "ip:203.0.113.7", chatRateKey(c))
+
+	c.Set(callerKey, "0x00000000000000000000000000000000000000cc")
+	assert.Equal(t, "user:0x00000000000000000000000000000000000000cc", chatRateKey(c))
+}
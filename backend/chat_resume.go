@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// handleDeliveryFrame applies the ack and resume fields of a WebSocket
+// frame. It reports whether the frame was only about delivery, and so needs
+// no further processing, and whether the connection should stay open.
+// A {"resume":N} frame replays the frames sent after N, or answers with a
+// resume_failed frame when they are no longer buffered. Delivery is tracked
+// per session, as returned by ChatConnection.Session.
+func (a *App) handleDeliveryFrame(conn chatConn, session string, message *services.ChatMessage) (bool, bool) {
+	if message.Ack != nil {
+		a.chatEngine.Ack(session, *message.Ack)
+	}
+	if message.Resume == nil {
+		return message.Ack != nil && message.Message == "" && message.Stream == nil, true
+	}
+
+	lastSeq := *message.Resume
+	err := a.chatEngine.Resume(session, conn, lastSeq)
+	if errors.Is(err, services.ErrResumeGap) {
+		a.logger.WithFields(logrus.Fields{
+			"session":  session,
+			"last_seq": lastSeq,
+		}).Info("Chat resume failed, missed frames are no longer buffered")
+		err = conn.WriteJSON(a.chatEngine.ResumeFailed(session, message.ID, lastSeq))
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to resume chat connection")
+		return true, false
+	}
+	return true, true
+}
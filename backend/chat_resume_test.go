@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// framedChatConn reads the given raw frames and records what is written back
+type framedChatConn struct {
+	fakeChatConn
+	frames []string
+}
+
+func (f *framedChatConn) ReadJSON(v interface{}) error {
+	if len(f.frames) == 0 {
+		return errors.New("EOF")
+	}
+	frame := f.frames[0]
+	f.frames = f.frames[1:]
+	return json.Unmarshal([]byte(frame), v)
+}
+
+func (f *framedChatConn) seqs() []uint64 {
+	var numbers []uint64
+	for _, write := range f.writes {
+		numbers = append(numbers, write.Seq)
+	}
+	return numbers
+}
+
+func TestChatConnectionResumesMissedFrames(t *testing.T) {
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 1000, MuteDuration: time.Minute, MaxViolations: 3})
+	const user = "0x00000000000000000000000000000000000000aa"
+	hello := `{"id":"m","user_id":"` + user + `","message":"hello"}`
+
+	// The client sees three responses and acks the first two before the
+	// connection drops
+	first := &framedChatConn{frames: []string{hello, hello, `{"ack":2}`, hello}}
+	app.serveChatConnection(context.Background(), first, user, user, []string{"conn:1"})
+	assert.Equal(t, []uint64{1, 2, 3}, first.seqs())
+
+	// Alerts fired during the gap are kept for the resume
+	for _, text := range []string{"KAIA is at $0.20", "KAIA is at $0.21"} {
+		err := app.chatEngine.SendToUser(user, &services.ChatResponse{Type: "price_alert", Response: text})
+		assert.Error(t, err, "nobody is connected")
+	}
+
+	// The reconnected client gets frame 3 and the alerts, in order, before
+	// the response to its next message
+	second := &framedChatConn{frames: []string{`{"resume":2}`, hello}}
+	app.serveChatConnection(context.Background(), second, user, user, []string{"conn:2"})
+	require.Equal(t, []uint64{3, 4, 5, 6}, second.seqs())
+	assert.Equal(t, first.writes[2].ID, second.writes[0].ID)
+	assert.Equal(t, "KAIA is at $0.20", second.writes[1].Response)
+	assert.Equal(t, "KAIA is at $0.21", second.writes[2].Response)
+	assert.Equal(t, "m", second.writes[3].MessageID)
+
+	// A gap longer than the buffer can't be replayed
+	for i := 0; i <= services.ChatResumeBufferSize; i++ {
+		app.chatEngine.SendToUser(user, &services.ChatResponse{Type: "price_alert"})
+	}
+	third := &framedChatConn{frames: []string{`{"id":"r","resume":6}`}}
+	app.serveChatConnection(context.Background(), third, user, user, []string{"conn:3"})
+	require.Len(t, third.writes, 1)
+	failed := third.writes[0]
+	assert.Equal(t, services.ChatResumeFailedFrame, failed.Type)
+	assert.Equal(t, "r", failed.MessageID)
+	assert.False(t, failed.Success)
+	assert.Equal(t, uint64(6+services.ChatResumeBufferSize+2), failed.Seq)
+
+	// After refreshing, the client resumes from the failure frame
+	fourth := &framedChatConn{frames: []string{`{"resume":` + strconv.FormatUint(failed.Seq, 10) + `}`, hello}}
+	app.serveChatConnection(context.Background(), fourth, user, user, []string{"conn:4"})
+	assert.Equal(t, []uint64{failed.Seq + 1}, fourth.seqs())
+}
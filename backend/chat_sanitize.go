@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// invalidChatMessage maps a rejected chat message to an error code and a
+// message for the sender
+func invalidChatMessage(err error) (string, string) {
+	var tooLong *services.MessageTooLongError
+	if errors.As(err, &tooLong) {
+		return "message_too_long", tooLong.Error()
+	}
+	return "empty_message", "Message has no content"
+}
+
+// rejectChatMessage responds to an HTTP chat request whose message can't be accepted
+func rejectChatMessage(c *gin.Context, err error) {
+	code, message := invalidChatMessage(err)
+	status := http.StatusBadRequest
+	if code == "message_too_long" {
+		status = http.StatusRequestEntityTooLarge
+	}
+	c.JSON(status, ErrorResponse{Error: code, Message: message})
+}
+
+// invalidChatResponse is sent over a chat WebSocket for a message that can't
+// be accepted; the connection stays open
+func invalidChatResponse(message *services.ChatMessage, err error) *services.ChatResponse {
+	code, text := invalidChatMessage(err)
+	now := time.Now()
+	return &services.ChatResponse{
+		MessageID:     message.ID,
+		Type:          "invalid_message",
+		Response:      text,
+		Timestamp:     services.NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       false,
+		Metadata: map[string]interface{}{
+			"error": code,
+		},
+	}
+}
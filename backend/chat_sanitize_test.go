@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// scriptedChatConn replays the given messages and records what is written back
+type scriptedChatConn struct {
+	fakeChatConn
+	messages []string
+}
+
+func (s *scriptedChatConn) ReadJSON(v interface{}) error {
+	if len(s.messages) == 0 {
+		return errors.New("EOF")
+	}
+	message := v.(*services.ChatMessage)
+	message.ID = "msg"
+	message.Message = s.messages[0]
+	s.messages = s.messages[1:]
+	return nil
+}
+
+func TestChatRequestRejectsInvalidMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 100, MuteDuration: time.Minute, MaxViolations: 3})
+	app.router = gin.New()
+	app.router.POST("/api/v1/chat/message", app.processChatMessage)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/chat/message", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+	errorCode := func(w *httptest.ResponseRecorder) string {
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Error
+	}
+
+	// A 10MB body is cut off before it is decoded
+	w := post(`{"message":"` + strings.Repeat("a", 10<<20) + `"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "message_too_long", errorCode(w))
+
+	w = post(`{"message":"` + strings.Repeat("a", services.DefaultChatMaxMessageLength+1) + `"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "message_too_long", errorCode(w))
+
+	w = post(`{"message":"\u0000\u001b \n"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "empty_message", errorCode(w))
+
+	w = post(`{"message":"hello <script>alert(1)</script>"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response services.ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Success)
+	assert.NotContains(t, response.Response, "<script>")
+}
+
+func TestChatConnectionRejectsInvalidMessages(t *testing.T) {
+	app := newChatRateLimitTestApp(t, services.ChatRateLimitConfig{PerMinute: 100, MuteDuration: time.Minute, MaxViolations: 3})
+	conn := &scriptedChatConn{messages: []string{
+		strings.Repeat("🚀", services.DefaultChatMaxMessageLength+1),
+		"\x00\x01",
+		"hello",
+	}}
+
+	app.serveChatConnection(context.Background(), conn, "user", "user", []string{"conn:1"})
+
+	require.Len(t, conn.writes, 3, "the connection stays open after a rejected message")
+	assert.Equal(t, "invalid_message", conn.writes[0].Type)
+	assert.False(t, conn.writes[0].Success)
+	assert.Equal(t, "message_too_long", conn.writes[0].Metadata["error"])
+	assert.Equal(t, "msg", conn.writes[0].MessageID)
+	assert.Equal(t, "empty_message", conn.writes[1].Metadata["error"])
+	assert.Equal(t, "text", conn.writes[2].Type)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// ChatShareRequest shares one of the caller's chat responses
+type ChatShareRequest struct {
+	ResponseID string `json:"response_id" binding:"required"`
+	// ExpiresIn is a duration such as "24h"; without one the share lasts
+	// until it is revoked
+	ExpiresIn string `json:"expires_in"`
+}
+
+// shareChatResponse snapshots one of the caller's chat responses into a
+// share anyone with its slug can read
+func (a *App) shareChatResponse(c *gin.Context) {
+	caller, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var request ChatShareRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	var ttl time.Duration
+	if request.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(request.ExpiresIn); err != nil || ttl <= 0 || ttl > services.MaxChatShareTTL {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_expiry",
+				Message: "expires_in must be a positive duration of at most 90 days, such as 24h",
+			})
+			return
+		}
+	}
+
+	exchange, err := a.transcripts.Exchange(caller, request.ResponseID)
+	switch {
+	case errors.Is(err, services.ErrTranscriptNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "response_not_found",
+			Message: "Response not found or no longer kept",
+		})
+		return
+	case errors.Is(err, services.ErrTranscriptForbidden):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Only the user the response was sent to can share it",
+		})
+		return
+	}
+
+	share, err := a.shares.Create(caller, exchange, ttl)
+	switch {
+	case errors.Is(err, services.ErrShareLimit):
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Error:   "too_many_shares",
+			Message: "Revoke a shared analysis before sharing another",
+		})
+	case err != nil:
+		a.logger.WithError(err).Error("Failed to share chat response")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "share_failed", Message: "The response could not be shared"})
+	default:
+		c.JSON(http.StatusCreated, share)
+	}
+}
+
+// revokeChatShare stops one of the caller's shares from being served
+func (a *App) revokeChatShare(c *gin.Context) {
+	caller, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	err := a.shares.Revoke(caller, c.Param("slug"))
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "share_not_found", Message: "Shared analysis not found"})
+	case errors.Is(err, services.ErrShareForbidden):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Only the user who shared an analysis can revoke it",
+		})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getSharedAnalysis serves a share without authentication
+func (a *App) getSharedAnalysis(c *gin.Context) {
+	share, err := a.shares.Get(c.Param("slug"))
+	switch {
+	case errors.Is(err, services.ErrShareNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "share_not_found", Message: "Shared analysis not found"})
+	case errors.Is(err, services.ErrShareGone):
+		c.JSON(http.StatusGone, ErrorResponse{Error: "share_gone", Message: "This shared analysis expired or was revoked"})
+	default:
+		c.JSON(http.StatusOK, share)
+	}
+}
+
+// exportChatTranscript exports one of the caller's chat sessions as JSON or
+// Markdown
+func (a *App) exportChatTranscript(c *gin.Context) {
+	caller, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", services.TranscriptFormatJSON)
+	transcript, err := a.transcripts.Export(caller, c.Query("session_id"), format)
+	switch {
+	case errors.Is(err, services.ErrTranscriptFormat):
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "invalid_format", Message: "Format must be json or markdown"})
+	case errors.Is(err, services.ErrTranscriptNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "session_not_found", Message: "No messages in this session"})
+	case err != nil:
+		a.logger.WithError(err).Error("Failed to export chat transcript")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "export_failed", Message: "The transcript could not be exported"})
+	case format == services.TranscriptFormatMarkdown:
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", transcript)
+	default:
+		c.Data(http.StatusOK, "application/json; charset=utf-8", transcript)
+	}
+}
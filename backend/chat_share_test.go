@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestChatShareEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{transcripts: services.NewChatTranscripts(), shares: services.NewChatShares(), sessions: services.NewSessions(), router: gin.New()}
+	app.router.Use(app.authenticate())
+	app.router.POST("/api/v1/chat/share", app.shareChatResponse)
+	app.router.DELETE("/api/v1/chat/share/:slug", app.revokeChatShare)
+	app.router.GET("/api/v1/chat/export", app.exportChatTranscript)
+	app.router.GET("/api/v1/shared/:slug", app.getSharedAnalysis)
+
+	owner := "0x00000000000000000000000000000000000000aa"
+	app.transcripts.Record(&services.ChatMessage{ID: "msg_1", UserID: owner, Message: "gas?"},
+		&services.ChatResponse{ID: "resp_1", Response: "Gas is low for " + owner, Timestamp: services.NewAPITime(time.Now())})
+
+	serve := func(method, path, caller, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if caller != "" {
+			req.Header.Set("Authorization", signInAs(app, caller))
+		}
+		app.router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/v1/chat/share", "", `{"response_id": "resp_1"}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve("POST", "/api/v1/chat/share", "0x00000000000000000000000000000000000000bb", `{"response_id": "resp_1"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("POST", "/api/v1/chat/share", owner, `{"response_id": "resp_1", "expires_in": "forever"}`).Code)
+
+	created := serve("POST", "/api/v1/chat/share", owner, `{"response_id": "resp_1", "expires_in": "24h"}`)
+	require.Equal(t, http.StatusCreated, created.Code)
+	slug := app.shares.UserData(owner).([]services.ChatShare)[0].Slug
+
+	shared := serve("GET", "/api/v1/shared/"+slug, "", "")
+	assert.Equal(t, http.StatusOK, shared.Code)
+	assert.Contains(t, shared.Body.String(), "Gas is low for [redacted]")
+	assert.NotContains(t, shared.Body.String(), owner)
+
+	assert.Equal(t, http.StatusForbidden, serve("DELETE", "/api/v1/chat/share/"+slug, "0x00000000000000000000000000000000000000bb", "").Code)
+	assert.Equal(t, http.StatusNoContent, serve("DELETE", "/api/v1/chat/share/"+slug, owner, "").Code)
+	assert.Equal(t, http.StatusGone, serve("GET", "/api/v1/shared/"+slug, "", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/api/v1/shared/missing", "", "").Code)
+
+	export := serve("GET", "/api/v1/chat/export?format=markdown", owner, "")
+	assert.Equal(t, http.StatusOK, export.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", export.Header().Get("Content-Type"))
+	assert.Contains(t, export.Body.String(), "Gas is low")
+	assert.Equal(t, http.StatusBadRequest, serve("GET", "/api/v1/chat/export?format=pdf", owner, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/api/v1/chat/export?session_id=other", owner, "").Code)
+}
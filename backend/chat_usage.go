@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getChatUsage returns chat traffic in hourly or daily buckets between from
+// and to, the last 7 days by default, with a forecast of the next 7 days
+func (a *App) getChatUsage(c *gin.Context) {
+	bucket := c.DefaultQuery("bucket", services.ChatUsageHour)
+	if !services.ValidChatUsageBucket(bucket) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_bucket",
+			Message: "Bucket must be hour or day",
+		})
+		return
+	}
+
+	from, ok := parseAuditTime(c, "from")
+	if !ok {
+		return
+	}
+	to, ok := parseAuditTime(c, "to")
+	if !ok {
+		return
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -7)
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_range",
+			Message: "to must not be before from",
+		})
+		return
+	}
+
+	usage := a.chatEngine.ChatUsage()
+	c.JSON(http.StatusOK, gin.H{
+		"from":     services.NewAPITime(from),
+		"to":       services.NewAPITime(to),
+		"bucket":   bucket,
+		"buckets":  usage.Buckets(from, to, bucket),
+		"forecast": usage.Forecast(),
+	})
+}
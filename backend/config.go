@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// minAdminAPIKeyLength is the shortest admin key accepted in production
+const minAdminAPIKeyLength = 16
+
+// knownEnvironments are the accepted ENVIRONMENT values
+var knownEnvironments = []string{"development", "test", "staging", "production"}
+
+// placeholderSecrets are the example values shipped in .env.example
+var placeholderSecrets = []string{"your-admin-api-key", "your-project-id"}
+
+// ConfigError lists every problem found in the configuration, so they can all
+// be fixed before the next start
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration (%d problems):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// configProblems collects validation failures
+type configProblems []string
+
+func (p *configProblems) add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p *configProblems) positive(name string, value int) {
+	if value <= 0 {
+		p.add("%s must be greater than 0, got %d", name, value)
+	}
+}
+
+// Chain modes
+const (
+	// ChainModeRPC reads the chain through the configured RPC endpoints
+	ChainModeRPC = "rpc"
+	// ChainModeSimulated serves an in-memory chain for local development
+	ChainModeSimulated = "simulated"
+)
+
+// IsSimulated reports whether the backend serves a simulated chain instead
+// of reading one through RPC endpoints
+func (c *Config) IsSimulated() bool {
+	return c.ChainMode == ChainModeSimulated
+}
+
+// IsProduction reports whether the service runs in production
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
+// Validate checks the configuration and reports every problem at once
+func (c *Config) Validate() error {
+	var problems configProblems
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems.add("PORT must be a port number between 1 and 65535, got %q", c.Port)
+	}
+	if !slices.Contains(knownEnvironments, c.Environment) {
+		problems.add("ENVIRONMENT must be one of %s, got %q", strings.Join(knownEnvironments, ", "), c.Environment)
+	}
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			problems.add("LOG_LEVEL must be one of trace, debug, info, warn, error, fatal, panic, got %q", c.LogLevel)
+		}
+	}
+
+	c.validateRPC(&problems)
+	c.validateLimits(&problems)
+	c.validateFeatures(&problems)
+	if c.IsProduction() {
+		c.validateProduction(&problems)
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validateRPC checks the chain mode and the node endpoints, which the
+// simulated chain doesn't need. URLs are referred to by position because they
+// often embed API keys.
+func (c *Config) validateRPC(problems *configProblems) {
+	switch c.ChainMode {
+	case "", ChainModeRPC:
+		if len(c.EthNodeURLs) == 0 {
+			problems.add("ETH_NODE_URL or ETH_NODE_URLS must name at least one RPC endpoint")
+		}
+		for i, rawURL := range c.EthNodeURLs {
+			if err := checkURL(rawURL, "http", "https", "ws", "wss"); err != nil {
+				problems.add("ETH_NODE_URLS entry %d %v", i+1, err)
+			}
+		}
+		for i, rawURL := range c.ArchiveNodeURLs {
+			if err := checkURL(rawURL, "http", "https", "ws", "wss"); err != nil {
+				problems.add("ARCHIVE_NODE_URLS entry %d %v", i+1, err)
+			}
+		}
+	case ChainModeSimulated:
+	default:
+		problems.add("CHAIN_MODE must be %s or %s, got %q", ChainModeRPC, ChainModeSimulated, c.ChainMode)
+	}
+	if c.NetworkID < 0 {
+		problems.add("NETWORK_ID must be a chain ID, or 0 to skip the chain check, got %d", c.NetworkID)
+	}
+}
+
+// validateLimits checks that worker pools, budgets, and rate limits can admit work
+func (c *Config) validateLimits(problems *configProblems) {
+	problems.positive("LOG_SAMPLE_EVERY", c.LogSampleEvery)
+	problems.positive("RPC_MAX_CONCURRENCY", c.RPCMaxConcurrency)
+	if c.RPCMaxRetries < 0 {
+		problems.add("RPC_MAX_RETRIES must not be negative, got %d", c.RPCMaxRetries)
+	}
+	if c.RPCSlowCallThreshold <= 0 {
+		problems.add("RPC_SLOW_CALL_MS must be greater than 0, got %d", c.RPCSlowCallThreshold.Milliseconds())
+	}
+	if c.NodeHeadLagThreshold <= 0 {
+		problems.add("NODE_HEAD_LAG_THRESHOLD_SECONDS must be greater than 0, got %d", int(c.NodeHeadLagThreshold.Seconds()))
+	}
+	problems.positive("WEBHOOK_WORKERS", c.WebhookWorkers)
+	problems.positive("REPORT_MAX_CONCURRENCY", c.ReportMaxConcurrency)
+	if c.ReportMaxConcurrency > 0 && (c.ReportMinConcurrency < 1 || c.ReportMinConcurrency > c.ReportMaxConcurrency) {
+		problems.add("REPORT_MIN_CONCURRENCY must be between 1 and REPORT_MAX_CONCURRENCY (%d), got %d", c.ReportMaxConcurrency, c.ReportMinConcurrency)
+	}
+	if c.AnalyticsPoolMinWorkers < 1 || c.AnalyticsPoolMaxWorkers < c.AnalyticsPoolMinWorkers {
+		problems.add("ANALYTICS_WORKER_POOL_SIZE must be at least 1 and at most ANALYTICS_WORKER_POOL_MAX, got %d and %d", c.AnalyticsPoolMinWorkers, c.AnalyticsPoolMaxWorkers)
+	}
+	if c.CachePrimeTopK < 0 {
+		problems.add("CACHE_PRIME_TOP_K must not be negative, got %d", c.CachePrimeTopK)
+	}
+	if c.CachePrimePoolPercent < 1 || c.CachePrimePoolPercent > 100 {
+		problems.add("CACHE_PRIME_POOL_PERCENT must be between 1 and 100, got %d", c.CachePrimePoolPercent)
+	}
+	if _, err := services.ParseActiveHours(c.CachePrimeHours); err != nil {
+		problems.add("CACHE_PRIME_HOURS is malformed: %v", err)
+	}
+	problems.positive("BACKTEST_MAX_CONCURRENCY", c.BacktestMaxConcurrency)
+	problems.positive("USER_EXPORT_MAX_BYTES", c.UserExportMaxBytes)
+	problems.positive("DATA_MAX_IN_FLIGHT", c.DataMaxInFlight)
+	problems.positive("ANALYTICS_MAX_CONCURRENT_TASKS", c.AnalyticsMaxInFlight)
+	problems.positive("CHAT_MAX_IN_FLIGHT", c.ChatMaxInFlight)
+	if c.LatencySLO <= 0 {
+		problems.add("LATENCY_SLO_MS must be greater than 0, got %d", c.LatencySLO.Milliseconds())
+	}
+	problems.positive("CHAT_RATE_LIMIT_PER_MINUTE", c.ChatRateLimit.PerMinute)
+	if c.ChatRateLimit.MuteDuration <= 0 {
+		problems.add("CHAT_RATE_LIMIT_MUTE_SECONDS must be greater than 0, got %d", int(c.ChatRateLimit.MuteDuration.Seconds()))
+	}
+	problems.positive("CHAT_RATE_LIMIT_MAX_VIOLATIONS", c.ChatRateLimit.MaxViolations)
+	problems.positive("CHAT_MAX_MESSAGE_LENGTH", c.ChatMaxMessageLength)
+	problems.positive("CHAT_MAX_CONNECTIONS", c.ChatMaxConnections)
+	if c.ChatChartMaxPoints < services.MinChartMaxPoints {
+		problems.add("CHAT_CHART_MAX_POINTS must be at least %d, got %d", services.MinChartMaxPoints, c.ChatChartMaxPoints)
+	}
+	if c.ActionSubmitDelay < 0 {
+		problems.add("ACTION_SUBMIT_DELAY_SECONDS must not be negative, got %d", int(c.ActionSubmitDelay.Seconds()))
+	}
+	problems.positive("BACKFILL_MAX_BLOCKS", c.BackfillMaxBlocks)
+	problems.positive("BACKFILL_MAX_CONCURRENCY", c.BackfillMaxConcurrency)
+	problems.positive("HOLDER_SCAN_MAX_BLOCKS", c.HolderScanMaxBlocks)
+	problems.positive("APPROVAL_SCAN_MAX_BLOCKS", c.ApprovalScanMaxBlocks)
+	if c.ListingMinLiquidityUSD < 0 {
+		problems.add("LISTING_MIN_LIQUIDITY_USD must not be negative, got %d", c.ListingMinLiquidityUSD)
+	}
+	problems.positive("DATA_RETENTION_DAYS", c.DataRetentionDays)
+	problems.positive("SWAP_HISTORY_MAX_BLOCKS", c.SwapHistoryMaxBlocks)
+}
+
+// validateFeatures checks the settings of optional features that are turned on
+func (c *Config) validateFeatures(problems *configProblems) {
+	// Unset and zero addresses turn event watching, voting power lookups, and
+	// on-chain subscription tiers off
+	contracts := []struct{ name, address string }{
+		{"ANALYTICS_REGISTRY_ADDRESS", c.AnalyticsRegistryAddress},
+		{"ACTION_CONTRACT_ADDRESS", c.ActionContractAddress},
+		{"GOVERNANCE_TOKEN_ADDRESS", c.GovernanceTokenAddress},
+		{"SUBSCRIPTION_CONTRACT_ADDRESS", c.SubscriptionContractAddress},
+		{"MULTICALL3_ADDRESS", c.Multicall3Address},
+	}
+	for _, contract := range contracts {
+		if contract.address != "" && !common.IsHexAddress(contract.address) {
+			problems.add("%s must be a 0x-prefixed 20 byte address, got %q", contract.name, contract.address)
+		}
+	}
+
+	trackedTokens, err := services.ParseTrackedTokens(c.TrackedTokens)
+	if err != nil {
+		problems.add("TRACKED_TOKENS is malformed: %v", err)
+	}
+	if _, err := services.ContractLabels(c.ContractLabels, trackedTokens); err != nil {
+		problems.add("CONTRACT_LABELS is malformed: %v", err)
+	}
+	if _, err := services.ParseDEXPairs(c.DexPairs); err != nil {
+		problems.add("DEX_PAIRS is malformed: %v", err)
+	}
+	if _, err := services.ParseStakingRegistries(c.StakingRegistries); err != nil {
+		problems.add("STAKING_REGISTRY_ADDRESSES is malformed: %v", err)
+	}
+	if _, err := services.ParseStakingPools(c.StakingPools); err != nil {
+		problems.add("STAKING_POOL_ADDRESSES is malformed: %v", err)
+	}
+	if c.StakingPoolStartBlock < 0 {
+		problems.add("STAKING_POOL_START_BLOCK must not be negative, got %d", c.StakingPoolStartBlock)
+	}
+	if _, err := services.ParseTierLimits(c.SubscriptionFeatures); err != nil {
+		problems.add("SUBSCRIPTION_FEATURES is malformed: %v", err)
+	}
+
+	if c.SentryDSN != "" {
+		// The DSN embeds a key, so it isn't echoed
+		if _, err := services.NewSentryReporter(c.SentryDSN, c.Environment); err != nil {
+			problems.add("SENTRY_DSN must have the form https://<key>@<host>/<project>")
+		}
+	}
+
+	if c.TokenListURL != "" {
+		if err := checkURL(c.TokenListURL, "http", "https"); err != nil {
+			problems.add("TOKEN_LIST_URL %v", err)
+		}
+	}
+
+	if c.ContractManifest != "" {
+		if strings.HasPrefix(c.ContractManifest, "http://") || strings.HasPrefix(c.ContractManifest, "https://") {
+			if err := checkURL(c.ContractManifest, "http", "https"); err != nil {
+				problems.add("CONTRACT_MANIFEST %v", err)
+			}
+		} else if _, err := os.Stat(c.ContractManifest); err != nil {
+			problems.add("CONTRACT_MANIFEST can't be read: %v", err)
+		}
+	}
+
+	if c.ProtocolRegistryPath != "" {
+		if _, err := os.Stat(c.ProtocolRegistryPath); err != nil {
+			problems.add("PROTOCOL_REGISTRY_PATH can't be read: %v", err)
+		}
+	}
+	if c.GovernanceModelPath != "" {
+		if _, err := os.Stat(c.GovernanceModelPath); err != nil {
+			problems.add("GOVERNANCE_MODEL_PATH can't be read: %v", err)
+		}
+	}
+
+	if len(c.PriceFeedSymbols) > 0 {
+		if c.PriceFeedQuote == "" {
+			problems.add("PRICE_FEED_QUOTE is required when PRICE_FEED_SYMBOLS is set")
+		}
+		if err := checkURL(c.BinanceStreamURL, "ws", "wss"); err != nil {
+			problems.add("BINANCE_STREAM_URL %v", err)
+		}
+		if err := checkURL(c.UpbitStreamURL, "ws", "wss"); err != nil {
+			problems.add("UPBIT_STREAM_URL %v", err)
+		}
+		if c.PriceOutlierMADs <= 0 {
+			problems.add("PRICE_OUTLIER_MADS must be positive")
+		}
+	}
+}
+
+// validateProduction rejects development defaults that are unsafe in production
+func (c *Config) validateProduction(problems *configProblems) {
+	switch {
+	case c.AdminAPIKey == "":
+		problems.add("ADMIN_API_KEY is required in production")
+	case isPlaceholder(c.AdminAPIKey):
+		problems.add("ADMIN_API_KEY is still the example value from .env.example")
+	case len(c.AdminAPIKey) < minAdminAPIKeyLength:
+		problems.add("ADMIN_API_KEY must be at least %d characters in production", minAdminAPIKeyLength)
+	}
+
+	if c.IsSimulated() {
+		problems.add("CHAIN_MODE simulated is for local development and can't run in production")
+	}
+	for i, rawURL := range c.EthNodeURLs {
+		if isPlaceholder(rawURL) {
+			problems.add("ETH_NODE_URLS entry %d still has the example project ID from .env.example", i+1)
+		}
+	}
+}
+
+// CheckChainID probes the node and reports a mismatch with NETWORK_ID. It
+// does nothing when NETWORK_ID is unset.
+func (c *Config) CheckChainID(ctx context.Context, client interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+}) error {
+	if c.NetworkID == 0 {
+		return nil
+	}
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get chain ID to check NETWORK_ID: %w", err)
+	}
+	if chainID.Cmp(big.NewInt(c.NetworkID)) != 0 {
+		return fmt.Errorf("NETWORK_ID is %d but the RPC endpoint serves chain %s; point ETH_NODE_URLS at the right network or fix NETWORK_ID",
+			c.NetworkID, chainID)
+	}
+	return nil
+}
+
+// checkURL reports why rawURL isn't an absolute URL with one of the schemes
+func checkURL(rawURL string, schemes ...string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("is not a valid URL")
+	}
+	if !slices.Contains(schemes, parsed.Scheme) {
+		return fmt.Errorf("must use %s, got %q", strings.Join(schemes, ", "), parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return errors.New("has no host")
+	}
+	return nil
+}
+
+// isPlaceholder reports whether value contains an example value from .env.example
+func isPlaceholder(value string) bool {
+	value = strings.ToLower(strings.ReplaceAll(value, "_", "-"))
+	for _, placeholder := range placeholderSecrets {
+		if strings.Contains(value, placeholder) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func validTestConfig() *Config {
+	return &Config{
+		Port:                    "8080",
+		Environment:             "production",
+		AdminAPIKey:             "0123456789abcdef0123",
+		LogSampleEvery:          100,
+		EthNodeURLs:             []string{"https://public-en.node.kaia.io", "wss://public-en.node.kaia.io/ws"},
+		RPCMaxConcurrency:       32,
+		RPCMaxRetries:           2,
+		RPCSlowCallThreshold:    services.DefaultSlowRPCCallThreshold,
+		NodeHeadLagThreshold:    services.DefaultHeadLagThreshold,
+		WebhookWorkers:          4,
+		ReportMaxConcurrency:    8,
+		ReportMinConcurrency:    1,
+		AnalyticsPoolMinWorkers: 10,
+		AnalyticsPoolMaxWorkers: 50,
+		CachePrimeTopK:          10,
+		CachePrimePoolPercent:   10,
+		BacktestMaxConcurrency:  2,
+		UserExportMaxBytes:      10 << 20,
+		DataMaxInFlight:         100,
+		AnalyticsMaxInFlight:    50,
+		ChatMaxInFlight:         50,
+		LatencySLO:              2 * time.Second,
+		ChatRateLimit:           services.DefaultChatRateLimitConfig(),
+		ChatMaxMessageLength:    services.DefaultChatMaxMessageLength,
+		ChatChartMaxPoints:      services.DefaultChartMaxPoints,
+		ChatMaxConnections:      services.DefaultChatMaxConnections,
+		BackfillMaxBlocks:       services.DefaultBackfillMaxBlocks,
+		BackfillMaxConcurrency:  2,
+		HolderScanMaxBlocks:     services.DefaultHolderScanMaxBlocks,
+		ApprovalScanMaxBlocks:   services.DefaultApprovalScanMaxBlocks,
+		ListingMinLiquidityUSD:  services.DefaultListingMinLiquidityUSD,
+		DataRetentionDays:       services.DefaultDataRetentionDays,
+		SwapHistoryMaxBlocks:    services.DefaultSwapHistoryMaxBlocks,
+		PriceFeedSymbols:        []string{"KAIA"},
+		PriceFeedQuote:          "USDT",
+		BinanceStreamURL:        services.DefaultBinanceStreamURL,
+		UpbitStreamURL:          services.DefaultUpbitStreamURL,
+		PriceOutlierMADs:        services.DefaultPriceOutlierMADs,
+	}
+}
+
+func TestConfigValidateRules(t *testing.T) {
+	modelPath := filepath.Join(t.TempDir(), "model.json")
+	require.NoError(t, os.WriteFile(modelPath, []byte(`{}`), 0o600))
+
+	tests := []struct {
+		name    string
+		mutate  func(c *Config)
+		problem string
+	}{
+		{"valid production config", func(c *Config) {}, ""},
+		{"port out of range", func(c *Config) { c.Port = "70000" }, "PORT must be a port number"},
+		{"port not a number", func(c *Config) { c.Port = "http" }, "PORT must be a port number"},
+		{"unknown environment", func(c *Config) { c.Environment = "prod" }, "ENVIRONMENT must be one of"},
+		{"unknown log level", func(c *Config) { c.LogLevel = "verbose" }, `LOG_LEVEL must be one of trace, debug, info, warn, error, fatal, panic, got "verbose"`},
+		{"log sampling off by zero", func(c *Config) { c.LogSampleEvery = 0 }, "LOG_SAMPLE_EVERY must be greater than 0"},
+
+		{"no RPC endpoints", func(c *Config) { c.EthNodeURLs = nil }, "must name at least one RPC endpoint"},
+		{"RPC URL without scheme", func(c *Config) { c.EthNodeURLs = []string{"public-en.node.kaia.io"} }, `ETH_NODE_URLS entry 1 must use http, https, ws, wss, got ""`},
+		{"RPC URL with bad scheme", func(c *Config) { c.EthNodeURLs[1] = "ftp://node.example" }, "ETH_NODE_URLS entry 2 must use"},
+		{"RPC URL without host", func(c *Config) { c.EthNodeURLs = []string{"https://"} }, "ETH_NODE_URLS entry 1 has no host"},
+		{"malformed RPC URL", func(c *Config) { c.EthNodeURLs = []string{"https://node:port"} }, "ETH_NODE_URLS entry 1 is not a valid URL"},
+		{"archive URL with bad scheme", func(c *Config) { c.ArchiveNodeURLs = []string{"https://archive.example", "ftp://archive.example"} }, "ARCHIVE_NODE_URLS entry 2 must use"},
+		{"negative network ID", func(c *Config) { c.NetworkID = -1 }, "NETWORK_ID must be a chain ID"},
+		{"unknown chain mode", func(c *Config) { c.ChainMode = "fake" }, `CHAIN_MODE must be rpc or simulated, got "fake"`},
+		{"simulated chain in development needs no endpoints", func(c *Config) {
+			c.Environment = "development"
+			c.ChainMode = ChainModeSimulated
+			c.EthNodeURLs = nil
+		}, ""},
+
+		{"no RPC concurrency", func(c *Config) { c.RPCMaxConcurrency = 0 }, "RPC_MAX_CONCURRENCY must be greater than 0, got 0"},
+		{"zero RPC retries", func(c *Config) { c.RPCMaxRetries = 0 }, ""},
+		{"negative RPC retries", func(c *Config) { c.RPCMaxRetries = -1 }, "RPC_MAX_RETRIES must not be negative"},
+		{"no slow RPC threshold", func(c *Config) { c.RPCSlowCallThreshold = 0 }, "RPC_SLOW_CALL_MS must be greater than 0, got 0"},
+		{"no head lag threshold", func(c *Config) { c.NodeHeadLagThreshold = 0 }, "NODE_HEAD_LAG_THRESHOLD_SECONDS must be greater than 0, got 0"},
+		{"no webhook workers", func(c *Config) { c.WebhookWorkers = 0 }, "WEBHOOK_WORKERS"},
+		{"no report workers", func(c *Config) { c.ReportMaxConcurrency = -2 }, "REPORT_MAX_CONCURRENCY must be greater than 0, got -2"},
+		{"report minimum over maximum", func(c *Config) { c.ReportMinConcurrency = 9 }, "REPORT_MIN_CONCURRENCY must be between 1 and REPORT_MAX_CONCURRENCY (8), got 9"},
+		{"analytics pool maximum under minimum", func(c *Config) { c.AnalyticsPoolMaxWorkers = 5 }, "ANALYTICS_WORKER_POOL_SIZE must be at least 1 and at most ANALYTICS_WORKER_POOL_MAX, got 10 and 5"},
+		{"negative cache prime top K", func(c *Config) { c.CachePrimeTopK = -1 }, "CACHE_PRIME_TOP_K must not be negative, got -1"},
+		{"cache priming off", func(c *Config) { c.CachePrimeTopK = 0 }, ""},
+		{"cache priming on the whole pool", func(c *Config) { c.CachePrimePoolPercent = 101 }, "CACHE_PRIME_POOL_PERCENT must be between 1 and 100, got 101"},
+		{"malformed cache priming hours", func(c *Config) { c.CachePrimeHours = "morning" }, "CACHE_PRIME_HOURS is malformed"},
+		{"cache priming hours past midnight", func(c *Config) { c.CachePrimeHours = "22-6" }, ""},
+		{"no backtest workers", func(c *Config) { c.BacktestMaxConcurrency = 0 }, "BACKTEST_MAX_CONCURRENCY must be greater than 0, got 0"},
+		{"empty user exports", func(c *Config) { c.UserExportMaxBytes = 0 }, "USER_EXPORT_MAX_BYTES must be greater than 0, got 0"},
+		{"no data budget", func(c *Config) { c.DataMaxInFlight = 0 }, "DATA_MAX_IN_FLIGHT"},
+		{"no analytics budget", func(c *Config) { c.AnalyticsMaxInFlight = 0 }, "ANALYTICS_MAX_CONCURRENT_TASKS"},
+		{"no chat budget", func(c *Config) { c.ChatMaxInFlight = 0 }, "CHAT_MAX_IN_FLIGHT"},
+		{"no latency SLO", func(c *Config) { c.LatencySLO = 0 }, "LATENCY_SLO_MS"},
+		{"no chat rate limit", func(c *Config) { c.ChatRateLimit.PerMinute = 0 }, "CHAT_RATE_LIMIT_PER_MINUTE"},
+		{"no mute duration", func(c *Config) { c.ChatRateLimit.MuteDuration = 0 }, "CHAT_RATE_LIMIT_MUTE_SECONDS"},
+		{"no violation limit", func(c *Config) { c.ChatRateLimit.MaxViolations = 0 }, "CHAT_RATE_LIMIT_MAX_VIOLATIONS"},
+		{"no message length", func(c *Config) { c.ChatMaxMessageLength = 0 }, "CHAT_MAX_MESSAGE_LENGTH"},
+		{"no connection ceiling", func(c *Config) { c.ChatMaxConnections = 0 }, "CHAT_MAX_CONNECTIONS"},
+		{"too few chart points", func(c *Config) { c.ChatChartMaxPoints = 2 }, "CHAT_CHART_MAX_POINTS must be at least 3"},
+		{"no backfill blocks", func(c *Config) { c.BackfillMaxBlocks = 0 }, "BACKFILL_MAX_BLOCKS"},
+		{"no backfill workers", func(c *Config) { c.BackfillMaxConcurrency = 0 }, "BACKFILL_MAX_CONCURRENCY"},
+		{"no holder scan blocks", func(c *Config) { c.HolderScanMaxBlocks = 0 }, "HOLDER_SCAN_MAX_BLOCKS"},
+		{"no approval scan blocks", func(c *Config) { c.ApprovalScanMaxBlocks = 0 }, "APPROVAL_SCAN_MAX_BLOCKS"},
+		{"negative listing liquidity", func(c *Config) { c.ListingMinLiquidityUSD = -1 }, "LISTING_MIN_LIQUIDITY_USD"},
+		{"no data retention", func(c *Config) { c.DataRetentionDays = 0 }, "DATA_RETENTION_DAYS"},
+		{"no swap history blocks", func(c *Config) { c.SwapHistoryMaxBlocks = 0 }, "SWAP_HISTORY_MAX_BLOCKS"},
+
+		{"zero contract address", func(c *Config) { c.ActionContractAddress = "0x0000000000000000000000000000000000000000" }, ""},
+		{"malformed contract address", func(c *Config) { c.ActionContractAddress = "0x1234" }, "ACTION_CONTRACT_ADDRESS must be a 0x-prefixed 20 byte address"},
+		{"malformed governance token", func(c *Config) { c.GovernanceTokenAddress = "kaia" }, "GOVERNANCE_TOKEN_ADDRESS must be a 0x-prefixed 20 byte address"},
+		{"malformed registry address", func(c *Config) { c.AnalyticsRegistryAddress = "registry" }, "ANALYTICS_REGISTRY_ADDRESS"},
+		{"tracked tokens", func(c *Config) { c.TrackedTokens = "USDT:0x00000000000000000000000000000000000000d1:6" }, ""},
+		{"malformed tracked tokens", func(c *Config) { c.TrackedTokens = "USDT:0x1" }, "TRACKED_TOKENS is malformed"},
+		{"malformed contract labels", func(c *Config) { c.ContractLabels = "dex" }, "CONTRACT_LABELS is malformed"},
+		{"DEX pairs", func(c *Config) { c.DexPairs = " 0x00000000000000000000000000000000000000e1," }, ""},
+		{"malformed DEX pairs", func(c *Config) { c.DexPairs = "0x00000000000000000000000000000000000000e1,pair" }, "DEX_PAIRS is malformed"},
+		{"staking registries", func(c *Config) { c.StakingRegistries = "0x00000000000000000000000000000000000005e1" }, ""},
+		{"malformed staking registries", func(c *Config) { c.StakingRegistries = "0x5e1" }, "STAKING_REGISTRY_ADDRESSES is malformed"},
+		{"subscription features", func(c *Config) { c.SubscriptionFeatures = services.DefaultSubscriptionFeatures }, ""},
+		{"malformed subscription features", func(c *Config) { c.SubscriptionFeatures = "Free:50:5:3:hourly" }, "SUBSCRIPTION_FEATURES is malformed"},
+		{"malformed subscription contract", func(c *Config) { c.SubscriptionContractAddress = "0x5c1" }, "SUBSCRIPTION_CONTRACT_ADDRESS must be a 0x-prefixed 20 byte address"},
+		{"governance model", func(c *Config) { c.GovernanceModelPath = modelPath }, ""},
+		{"missing governance model", func(c *Config) { c.GovernanceModelPath = modelPath + ".missing" }, "GOVERNANCE_MODEL_PATH can't be read"},
+		{"protocol registry", func(c *Config) { c.ProtocolRegistryPath = modelPath }, ""},
+		{"missing protocol registry", func(c *Config) { c.ProtocolRegistryPath = modelPath + ".missing" }, "PROTOCOL_REGISTRY_PATH can't be read"},
+		{"price feed without quote", func(c *Config) { c.PriceFeedQuote = "" }, "PRICE_FEED_QUOTE is required"},
+		{"price feed stream over https", func(c *Config) { c.BinanceStreamURL = "https://stream.binance.com" }, "BINANCE_STREAM_URL must use ws, wss"},
+		{"token list over ws", func(c *Config) { c.TokenListURL = "wss://tokens.example" }, "TOKEN_LIST_URL must use http, https"},
+		{"price feed off ignores streams", func(c *Config) { c.PriceFeedSymbols = nil; c.UpbitStreamURL = "" }, ""},
+		{"outlier bound not positive", func(c *Config) { c.PriceOutlierMADs = 0 }, "PRICE_OUTLIER_MADS must be positive"},
+
+		{"production without admin key", func(c *Config) { c.AdminAPIKey = "" }, "ADMIN_API_KEY is required in production"},
+		{"production with example admin key", func(c *Config) { c.AdminAPIKey = "your-admin-api-key" }, "ADMIN_API_KEY is still the example value"},
+		{"production with short admin key", func(c *Config) { c.AdminAPIKey = "secret" }, "ADMIN_API_KEY must be at least 16 characters"},
+		{"production with example RPC project", func(c *Config) { c.EthNodeURLs = []string{"https://mainnet.infura.io/v3/YOUR_PROJECT_ID"} }, "ETH_NODE_URLS entry 1 still has the example project ID"},
+		{"production with simulated chain", func(c *Config) { c.ChainMode = ChainModeSimulated }, "CHAIN_MODE simulated is for local development"},
+		{"development allows example secrets", func(c *Config) {
+			c.Environment = "development"
+			c.AdminAPIKey = ""
+			c.EthNodeURLs = []string{"https://mainnet.infura.io/v3/your-project-id"}
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validTestConfig()
+			tt.mutate(config)
+
+			err := config.Validate()
+			if tt.problem == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var configErr *ConfigError
+			require.ErrorAs(t, err, &configErr)
+			require.Len(t, configErr.Problems, 1, "problems: %v", configErr.Problems)
+			assert.Contains(t, configErr.Problems[0], tt.problem)
+		})
+	}
+}
+
+func TestConfigValidateReportsEveryProblem(t *testing.T) {
+	config := validTestConfig()
+	config.Port = ""
+	config.WebhookWorkers = 0
+	config.ActionContractAddress = "0x1"
+	config.AdminAPIKey = ""
+
+	err := config.Validate()
+	var configErr *ConfigError
+	require.ErrorAs(t, err, &configErr)
+	assert.Len(t, configErr.Problems, 4)
+	assert.Contains(t, err.Error(), "invalid configuration (4 problems):\n  - PORT")
+	assert.Contains(t, err.Error(), "\n  - ADMIN_API_KEY is required in production")
+}
+
+type chainIDFunc func(ctx context.Context) (*big.Int, error)
+
+func (f chainIDFunc) ChainID(ctx context.Context) (*big.Int, error) {
+	return f(ctx)
+}
+
+func TestConfigCheckChainID(t *testing.T) {
+	kaia := chainIDFunc(func(ctx context.Context) (*big.Int, error) { return big.NewInt(8217), nil })
+	config := validTestConfig()
+
+	// The probe is skipped without NETWORK_ID
+	assert.NoError(t, config.CheckChainID(context.Background(), chainIDFunc(func(ctx context.Context) (*big.Int, error) {
+		t.Fatal("unexpected probe")
+		return nil, nil
+	})))
+
+	config.NetworkID = 8217
+	assert.NoError(t, config.CheckChainID(context.Background(), kaia))
+
+	config.NetworkID = 1001
+	err := config.CheckChainID(context.Background(), kaia)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NETWORK_ID is 1001 but the RPC endpoint serves chain 8217")
+
+	err = config.CheckChainID(context.Background(), chainIDFunc(func(ctx context.Context) (*big.Int, error) {
+		return nil, errors.New("connection refused")
+	}))
+	assert.ErrorContains(t, err, "connection refused")
+}
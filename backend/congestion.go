@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getCongestion reports hourly gas utilization and block fullness over a window
+func (a *App) getCongestion(c *gin.Context) {
+	window, err := parseWindow(c.DefaultQuery("window", "24h"))
+	if err != nil || window < time.Hour || window > services.CongestionRetention {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_window",
+			Message: "Window must be a duration such as 24h or 7d, between 1h and 30d",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, a.congestion.Report(window))
+}
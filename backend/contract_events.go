@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// watchContractEvents logs the events of a deployed project contract and
+// passes them to the handlers. Unset or zero addresses mean the contract isn't
+// deployed and are skipped.
+func watchContractEvents(ctx context.Context, logger logrus.FieldLogger, contracts *services.ContractManager, name, addressStr string, decoder *services.ABIEventDecoder, handlers ...func(services.DecodedEvent)) {
+	if !common.IsHexAddress(addressStr) || common.HexToAddress(addressStr) == (common.Address{}) {
+		return
+	}
+
+	err := contracts.WatchContract(ctx, common.HexToAddress(addressStr), decoder, func(event services.DecodedEvent) {
+		logger.WithFields(logrus.Fields{
+			"contract": name,
+			"event":    event.Name,
+			"block":    event.BlockNumber,
+			"tx_hash":  event.TxHash.Hex(),
+			"removed":  event.Removed,
+		}).Info("Contract event")
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	})
+	if err != nil {
+		logger.WithError(err).WithField("contract", name).Warn("Failed to watch contract events")
+	}
+}
+
+// recordActionUsage counts the actions users request through the ActionContract
+func recordActionUsage(usage *services.UsageTracker) func(services.DecodedEvent) {
+	return func(event services.DecodedEvent) {
+		if requested, ok := event.Event.(services.ActionRequested); ok && !event.Removed {
+			usage.Record(requested.User.Hex(), services.UsageActions)
+		}
+	}
+}
+
+// recordActionAudit appends the on-chain lifecycle of ActionContract actions to
+// the audit log. The log is append-only, so events removed by a reorg stay in it.
+func recordActionAudit(audit *services.ActionAuditLog) func(services.DecodedEvent) {
+	return func(event services.DecodedEvent) {
+		if event.Removed {
+			return
+		}
+
+		switch e := event.Event.(type) {
+		case services.ActionRequested:
+			audit.Append(services.ActionAuditRecord{
+				ActionID:   fmt.Sprintf("chain_%s", e.ActionId),
+				UserID:     e.User.Hex(),
+				Event:      services.ActionEventSubmitted,
+				ActionType: e.ActionType,
+				TxHash:     event.TxHash.Hex(),
+			})
+		case services.ActionExecuted:
+			record := services.ActionAuditRecord{
+				ActionID:   fmt.Sprintf("chain_%s", e.ActionId),
+				UserID:     e.User.Hex(),
+				Event:      services.ActionEventMined,
+				ActionType: e.ActionType,
+				TxHash:     event.TxHash.Hex(),
+			}
+			if !e.IsSuccessful {
+				record.Event = services.ActionEventFailed
+				record.Reason = e.Result
+			}
+			audit.Append(record)
+		}
+	}
+}
+
+// watchStakingPools applies the pools' events to the staking positions,
+// replaying them from startBlock
+func watchStakingPools(ctx context.Context, logger logrus.FieldLogger, contracts *services.ContractManager, positions *services.StakingPositions, pools []common.Address, startBlock uint64) {
+	decoder := services.NewStakingPoolDecoder()
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(startBlock),
+		Addresses: pools,
+		Topics:    [][]common.Hash{decoder.EventTopics()},
+	}
+	err := contracts.Watch(ctx, query, decoder, func(event services.DecodedEvent) {
+		if err := positions.Apply(ctx, event); err != nil {
+			logger.WithError(err).WithField("tx_hash", event.TxHash.Hex()).Warn("Failed to apply staking pool event")
+		}
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to watch staking pools")
+	}
+}
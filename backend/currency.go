@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// displayRate resolves the currency a response shows amounts in, from the
+// currency query parameter or else the caller's preferred display currency,
+// and its conversion rate. Converted responses carry the rate and its time in
+// X-Conversion headers. It responds with the error and returns false when the
+// currency is unsupported or can't be priced.
+func (a *App) displayRate(c *gin.Context) (services.ConversionRate, bool) {
+	currency := c.Query("currency")
+	if currency == "" {
+		currency = "USD"
+		if userID, ok := callerAddress(c); ok && a.preferences != nil {
+			currency = a.preferences.Preferences(userID).DisplayCurrency
+		}
+	}
+
+	currency, err := services.ParseCurrency(currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "unsupported_currency",
+			"message":   err.Error(),
+			"supported": services.SupportedCurrencies,
+		})
+		return services.ConversionRate{}, false
+	}
+
+	rate, err := a.dataCollector.ConversionRate(c.Request.Context(), currency)
+	if err != nil {
+		a.logger.WithError(err).WithField("currency", currency).Error("Failed to price display currency")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "conversion_unavailable",
+			Message: "Failed to price " + currency + "; try again shortly or use currency=USD",
+		})
+		return services.ConversionRate{}, false
+	}
+
+	if rate.Currency != "USD" {
+		c.Header("X-Conversion-Currency", rate.Currency)
+		c.Header("X-Conversion-Rate", rate.PerUSD)
+		c.Header("X-Conversion-As-Of", rate.AsOf.Format(time.RFC3339))
+	}
+	return rate, true
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// deploymentClient is what loading the deployment manifest needs of the node
+type deploymentClient interface {
+	services.ContractCodeReader
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// loadContractDeployments loads the deployment manifest for the node's chain
+// and points the contract address settings at it, failing when any of the
+// chain's contracts has no code
+func loadContractDeployments(ctx context.Context, config *Config, client deploymentClient) (*services.ContractDeployments, error) {
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deployments := services.NewContractDeployments(config.ContractManifest, chainID.Int64(), client)
+	if _, err := deployments.Load(ctx); err != nil {
+		return nil, err
+	}
+
+	settings := map[string]*string{
+		services.ContractAnalyticsRegistry:    &config.AnalyticsRegistryAddress,
+		services.ContractActionContract:       &config.ActionContractAddress,
+		services.ContractSubscriptionContract: &config.SubscriptionContractAddress,
+		services.ContractGovernanceToken:      &config.GovernanceTokenAddress,
+		services.ContractMulticall3:           &config.Multicall3Address,
+	}
+	for name, setting := range settings {
+		if address, ok := deployments.Address(name); ok {
+			*setting = address.Hex()
+		}
+	}
+	return deployments, nil
+}
+
+// swapContractClients points the registry task index and subscription
+// catalogs at contracts a manifest reload moved. Other contracts are wired
+// into watchers and signing at startup and move on the next restart.
+func swapContractClients(client services.ChainClient, registryTasks *services.RegistryTasks, subscriptions *services.SubscriptionCatalogs, logger logrus.FieldLogger) func(string, common.Address) {
+	return func(name string, address common.Address) {
+		fields := logrus.Fields{"contract": name, "address": address.Hex()}
+		switch {
+		case name == services.ContractAnalyticsRegistry && registryTasks != nil:
+			registryTasks.SetReader(services.NewChainRegistryTaskReader(client, address))
+		case name == services.ContractSubscriptionContract:
+			subscriptions.SetReader(services.NewChainSubscriptionReader(client, address))
+		default:
+			logger.WithFields(fields).Warn("Contract moved; the new address takes effect on restart")
+			return
+		}
+		logger.WithFields(fields).Info("Swapped contract client")
+	}
+}
+
+// reloadContractsOnHangup reloads the deployment manifest on SIGHUP until ctx
+// is cancelled. A failed reload keeps the current addresses.
+func reloadContractsOnHangup(ctx context.Context, deployments *services.ContractDeployments, logger logrus.FieldLogger) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			changed, err := deployments.Load(ctx)
+			if err != nil {
+				logger.WithError(err).Error("Failed to reload contract manifest")
+				continue
+			}
+			logger.WithField("changed", changed).Info("Reloaded contract manifest")
+		}
+	}
+}
+
+// getContractDeployments describes the loaded deployment manifest
+func (a *App) getContractDeployments(c *gin.Context) {
+	if !a.requireDeployments(c) {
+		return
+	}
+	c.JSON(http.StatusOK, a.deployments.Status())
+}
+
+// reloadContractDeployments reloads the deployment manifest, swapping in the
+// contracts that moved. A manifest that fails to load or verify changes nothing.
+func (a *App) reloadContractDeployments(c *gin.Context) {
+	if !a.requireDeployments(c) {
+		return
+	}
+
+	changed, err := a.deployments.Load(c.Request.Context())
+	if err != nil {
+		status, code := http.StatusBadGateway, "manifest_unavailable"
+		if errors.Is(err, services.ErrContractWithoutCode) {
+			status, code = http.StatusUnprocessableEntity, "contract_without_code"
+		}
+		c.JSON(status, ErrorResponse{Error: code, Message: err.Error()})
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"changed": changed, "deployments": a.deployments.Status()})
+}
+
+// requireDeployments answers 503 when no deployment manifest is configured
+func (a *App) requireDeployments(c *gin.Context) bool {
+	if a.deployments == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "manifest_not_configured",
+			Message: "Contract deployments need CONTRACT_MANIFEST",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getExecutionQuality reports the realized slippage of executed swaps by pair
+// and route over the last 30 days, optionally for one pair
+func (a *App) getExecutionQuality(c *gin.Context) {
+	routes := a.executionQuality.Report(c.Query("pair"))
+	c.JSON(http.StatusOK, gin.H{
+		"routes":            routes,
+		"window":            services.ExecutionQualityWindow.String(),
+		"penalty_min_swaps": services.RoutePenaltyMinSwaps,
+		"penalty_threshold": services.RoutePenaltyThreshold,
+		"count":             len(routes),
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+const maxFeeWindow = 90 * 24 * time.Hour
+
+// parseWindow parses a lookback window such as 30d or 12h
+func parseWindow(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// getAddressFees reports the gas an address, or with scope=all_wallets the
+// caller's linked wallets, spent over a window. While the receipts for the
+// window are being backfilled it responds 202 with the task.
+func (a *App) getAddressFees(c *gin.Context) {
+	addressStr := c.Param("address")
+
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	window, err := parseWindow(c.DefaultQuery("window", "30d"))
+	if err != nil || window <= 0 || window > maxFeeWindow {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_window",
+			Message: "Window must be a duration such as 30d or 12h, up to 90d",
+		})
+		return
+	}
+
+	rate, ok := a.displayRate(c)
+	if !ok {
+		return
+	}
+	wallets, ok := a.scopeWallets(c, common.HexToAddress(addressStr))
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	report, task, err := a.fees.FeeSpendWallets(ctx, wallets, time.Now().Add(-window))
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to compute fee spend")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "fees_failed",
+			Message: "Failed to compute fee spend",
+		})
+		return
+	}
+
+	if report == nil {
+		c.Header("Location", "/api/v1/backfills/"+task.ID)
+		c.JSON(http.StatusAccepted, gin.H{
+			"status": "backfilling",
+			"task":   task,
+		})
+		return
+	}
+
+	if rate.Currency != "USD" {
+		report = report.InCurrency(rate)
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// tagBackfillRequester attributes the receipt backfills a request starts to
+// its signed-in caller, or to its IP without a session, so no one caller can
+// start them all
+func tagBackfillRequester() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requester := "ip:" + c.ClientIP()
+		if caller, ok := callerAddress(c); ok {
+			requester = "user:" + caller
+		}
+		c.Request = c.Request.WithContext(services.WithBackfillRequester(c.Request.Context(), requester))
+		c.Next()
+	}
+}
+
+// getBackfillTask returns the progress of a receipt backfill
+func (a *App) getBackfillTask(c *gin.Context) {
+	task, ok := a.backfills.Task(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "backfill_not_found",
+			Message: "Backfill task not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
+
+// cancelBackfillTask stops a receipt backfill of the caller's address, or of
+// any address with the admin API key. A task that already finished is
+// returned unchanged.
+func (a *App) cancelBackfillTask(c *gin.Context) {
+	admin := a.isAdminRequest(c)
+	caller, ok := callerAddress(c)
+	if !admin {
+		if caller, ok = requireCaller(c); !ok {
+			return
+		}
+	}
+
+	task, ok := a.backfills.Task(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "backfill_not_found",
+			Message: "Backfill task not found",
+		})
+		return
+	}
+	if task.Address != caller && !admin {
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Backfill task belongs to another address",
+		})
+		return
+	}
+
+	wasActive := task.Active()
+	task, _ = a.backfills.Cancel(task.ID)
+	if wasActive && task.Status == services.BackfillCancelled && a.chatEngine != nil {
+		now := time.Now()
+		err := a.chatEngine.SendToUser(task.Address, &services.ChatResponse{
+			ID:            "task_cancelled_" + task.ID,
+			Type:          "task_cancelled",
+			Response:      fmt.Sprintf("Backfill %s was cancelled after scanning %d blocks.", task.ID, task.BlocksScanned),
+			Data:          task,
+			Timestamp:     services.NewAPITime(now),
+			TimestampUnix: now.Unix(),
+			Success:       true,
+		})
+		if err != nil {
+			a.logger.WithError(err).Debug("No chat connection to notify of the cancelled backfill")
+		}
+	}
+	c.JSON(http.StatusOK, task)
+}
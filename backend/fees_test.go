@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// stalledChain never answers, so backfills stay running until cancelled
+type stalledChain struct {
+	services.ChainClient
+}
+
+func (stalledChain) BlockNumber(ctx context.Context) (uint64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestCancelBackfillTask(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{
+		router:    gin.New(),
+		logger:    logrus.New(),
+		sessions:  services.NewSessions(),
+		config:    &Config{AdminAPIKey: "admin-key"},
+		backfills: services.NewReceiptBackfiller(stalledChain{}, services.NewTransactionIndex(), 0, 2),
+	}
+	app.router.Use(app.authenticate())
+	app.router.DELETE("/api/v1/backfills/:id", app.cancelBackfillTask)
+
+	cancel := func(id, authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/v1/backfills/"+id, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	alices, err := app.backfills.Ensure(common.HexToAddress(usageAlice), time.Now().Add(-time.Hour), "")
+	require.NoError(t, err)
+	bobs, err := app.backfills.Ensure(common.HexToAddress(usageBob), time.Now().Add(-time.Hour), "")
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, cancel(alices.ID, "").Code)
+	assert.Equal(t, http.StatusForbidden, cancel(alices.ID, signInAs(app, usageBob)).Code)
+	assert.Equal(t, http.StatusOK, cancel(alices.ID, signInAs(app, usageAlice)).Code)
+
+	// The admin key cancels anyone's task without a session
+	assert.Equal(t, http.StatusOK, cancel(bobs.ID, "Bearer admin-key").Code)
+	task, ok := app.backfills.Task(bobs.ID)
+	require.True(t, ok)
+	assert.Equal(t, services.BackfillCancelled, task.Status)
+	assert.Equal(t, http.StatusNotFound, cancel("bf_missing", "Bearer admin-key").Code)
+}
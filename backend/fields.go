@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// responseFields reads the fields query parameter of an endpoint returning T,
+// or a list of T: comma-separated, dotted JSON field paths to keep. Paths T
+// doesn't have get a 400 listing them.
+func responseFields[T any](c *gin.Context) (services.FieldSet, bool) {
+	fields, err := services.ParseFields[T](c.Query("fields"))
+	var invalid *services.InvalidFieldsError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid_fields",
+			"message": err.Error(),
+			"invalid": invalid.Invalid,
+		})
+		return services.FieldSet{}, false
+	}
+	return fields, true
+}
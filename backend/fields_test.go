@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFieldsListsInvalidFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{router: gin.New(), logger: logrus.New()}
+	app.router.GET("/address/:address/performance", app.getAddressPerformance)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/address/0x00000000000000000000000000000000000000aa/performance?fields=pnl_usd,flows.reasoning,apy", nil)
+	app.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var body struct {
+		Error   string   `json:"error"`
+		Invalid []string `json:"invalid"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "invalid_fields", body.Error)
+	assert.Equal(t, []string{"flows.reasoning", "apy"}, body.Invalid)
+}
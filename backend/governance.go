@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// ingestGovernanceProposal registers a proposal with the outcome predictor
+func (a *App) ingestGovernanceProposal(c *gin.Context) {
+	var proposal services.GovernanceProposal
+	if err := c.ShouldBindJSON(&proposal); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := a.analyticsEngine.Governance().IngestProposal(proposal); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_proposal",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	prediction, _ := a.analyticsEngine.Governance().Prediction(proposal.ID)
+	c.JSON(http.StatusCreated, prediction)
+}
+
+// ingestGovernanceVote records a vote and returns the refreshed prediction
+func (a *App) ingestGovernanceVote(c *gin.Context) {
+	var vote services.GovernanceVote
+	if err := c.ShouldBindJSON(&vote); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := a.analyticsEngine.Governance().IngestVote(vote); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_vote",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	prediction, _ := a.analyticsEngine.Governance().Prediction(vote.ProposalID)
+	c.JSON(http.StatusOK, prediction)
+}
+
+// getProposalPrediction returns the current outcome prediction for a proposal
+func (a *App) getProposalPrediction(c *gin.Context) {
+	prediction, ok := a.analyticsEngine.Governance().Prediction(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "proposal_not_found",
+			Message: "No proposal has been ingested with that ID",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, prediction)
+}
+
+// getVotingPower returns an address's voting power in the governance token
+// and its delegation, plus its votes at a proposal's snapshot when asked
+func (a *App) getVotingPower(c *gin.Context) {
+	addressStr := c.Param("address")
+
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	if a.votingPower == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "governance_token_not_configured",
+			Message: "No governance token is configured",
+		})
+		return
+	}
+
+	var snapshotBlock uint64
+	proposalID := c.Query("proposal_id")
+	if proposalID != "" {
+		proposal, ok := a.analyticsEngine.Governance().Proposal(proposalID)
+		if !ok {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   "proposal_not_found",
+				Message: "No proposal has been ingested with that ID",
+			})
+			return
+		}
+		if proposal.SnapshotBlock == 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "proposal_without_snapshot",
+				Message: "The proposal has no snapshot block",
+			})
+			return
+		}
+		snapshotBlock = proposal.SnapshotBlock
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	power, err := a.votingPower.VotingPower(ctx, common.HexToAddress(addressStr), snapshotBlock)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to read voting power")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "voting_power_failed",
+			Message: "Failed to read voting power from the governance token",
+		})
+		return
+	}
+	if proposalID != "" {
+		power.ProposalID = proposalID
+	}
+
+	c.JSON(http.StatusOK, power)
+}
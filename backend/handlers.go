@@ -2,33 +2,44 @@ package main
 
 import (
 	"context"
+	"errors"
 	"math/big"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
 )
 
 // Response structures
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
+	// RequestID is set on internal errors, to match them with the logs
+	RequestID string `json:"request_id,omitempty"`
 }
 
 type HealthResponse struct {
 	Status    string    `json:"status"`
 	Timestamp time.Time `json:"timestamp"`
 	Version   string    `json:"version"`
+	// HeadLag is set when the node's head block trails the wall clock
+	// by more than the threshold
+	HeadLag *services.HeadLag `json:"head_lag,omitempty"`
 }
 
 type BlockResponse struct {
 	Number       string   `json:"number"`
 	Hash         string   `json:"hash"`
 	ParentHash   string   `json:"parent_hash"`
-	Timestamp    uint64   `json:"timestamp"`
+	Timestamp    services.APITime `json:"timestamp"`
+	// Deprecated: use Timestamp
+	TimestampUnix uint64  `json:"timestamp_unix"`
 	GasUsed      uint64   `json:"gas_used"`
 	GasLimit     uint64   `json:"gas_limit"`
 	Transactions int      `json:"transaction_count"`
@@ -36,23 +47,31 @@ type BlockResponse struct {
 }
 
 type TransactionResponse struct {
-	Hash             string `json:"hash"`
-	BlockNumber      string `json:"block_number"`
-	BlockHash        string `json:"block_hash"`
-	TransactionIndex uint   `json:"transaction_index"`
+	Hash string `json:"hash"`
+	// Block fields are set once the transaction is mined
+	BlockNumber      string `json:"block_number,omitempty"`
+	BlockHash        string `json:"block_hash,omitempty"`
+	TransactionIndex *uint  `json:"transaction_index,omitempty"`
 	From             string `json:"from"`
-	To               string `json:"to"`
+	To               string `json:"to,omitempty"`
 	Value            string `json:"value"`
 	Gas              uint64 `json:"gas"`
 	GasPrice         string `json:"gas_price"`
 	GasUsed          uint64 `json:"gas_used,omitempty"`
-	Status           uint64 `json:"status,omitempty"`
+	// Status is pending, success, or failed
+	Status           string `json:"status"`
+	ContractCreation bool   `json:"contract_creation,omitempty"`
+	ContractAddress  string `json:"contract_address,omitempty"`
 }
 
 type BalanceResponse struct {
 	Address string `json:"address"`
 	Balance string `json:"balance"`
 	BalanceEth string `json:"balance_eth"`
+	// BlockNumber is the block the balance was read at
+	BlockNumber uint64 `json:"block_number"`
+	// Source is the node the balance was read from, primary or archive
+	Source string `json:"source"`
 }
 
 type NetworkStatsResponse struct {
@@ -68,6 +87,10 @@ type ContractInfoResponse struct {
 	Code        string `json:"code"`
 	CodeSize    int    `json:"code_size"`
 	IsContract  bool   `json:"is_contract"`
+	// BlockNumber is the block the code was read at
+	BlockNumber uint64 `json:"block_number"`
+	// Source is the node the code was read from, primary or archive
+	Source string `json:"source"`
 }
 
 // healthCheck returns the health status of the service
@@ -85,9 +108,15 @@ func (a *App) healthCheck(c *gin.Context) {
 
 	response := HealthResponse{
 		Status:    status,
-		Timestamp: time.Now(),
+		Timestamp: time.Now().UTC(),
 		Version:   "1.0.0",
 	}
+	if status == "healthy" && a.node != nil {
+		if lag := a.node.HeadLag(ctx); lag.Lagging {
+			response.Status = "degraded"
+			response.HeadLag = &lag
+		}
+	}
 
 	if status == "healthy" {
 		c.JSON(http.StatusOK, response)
@@ -132,7 +161,8 @@ func (a *App) getBlockByNumber(c *gin.Context) {
 		Number:       block.Number().String(),
 		Hash:         block.Hash().Hex(),
 		ParentHash:   block.ParentHash().Hex(),
-		Timestamp:    block.Time(),
+		Timestamp:     services.UnixAPITime(int64(block.Time())),
+		TimestampUnix: block.Time(),
 		GasUsed:      block.GasUsed(),
 		GasLimit:     block.GasLimit(),
 		Transactions: len(block.Transactions()),
@@ -159,38 +189,76 @@ func (a *App) getTransactionByHash(c *gin.Context) {
 	defer cancel()
 
 	tx, isPending, err := a.ethClient.TransactionByHash(ctx, txHash)
-	if err != nil {
-		a.logger.WithError(err).Error("Failed to get transaction")
+	if errors.Is(err, ethereum.NotFound) {
 		c.JSON(http.StatusNotFound, ErrorResponse{
 			Error:   "transaction_not_found",
 			Message: "Transaction not found",
 		})
 		return
 	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to get transaction")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "transaction_fetch_failed",
+			Message: "Failed to retrieve transaction information",
+		})
+		return
+	}
+
+	response := TransactionResponse{
+		Hash:     tx.Hash().Hex(),
+		Gas:      tx.Gas(),
+		GasPrice: tx.GasPrice().String(),
+		Value:    tx.Value().String(),
+		Status:   services.TxStatusPending,
+	}
+	from := getFromAddress(tx)
+	if from != (common.Address{}) {
+		response.From = from.Hex()
+	}
 
-	// Get transaction receipt for additional info
-	var gasUsed uint64
-	var status uint64
+	// Nodes that haven't indexed a mined transaction's receipt yet still
+	// report it as pending
+	var receipt *types.Receipt
 	if !isPending {
-		receipt, err := a.ethClient.TransactionReceipt(ctx, txHash)
-		if err == nil {
-			gasUsed = receipt.GasUsed
-			status = receipt.Status
+		receipt, err = a.ethClient.TransactionReceipt(ctx, txHash)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			a.logger.WithError(err).Error("Failed to get transaction receipt")
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "transaction_fetch_failed",
+				Message: "Failed to retrieve transaction receipt",
+			})
+			return
 		}
 	}
-
-	response := TransactionResponse{
-		Hash:             tx.Hash().Hex(),
-		From:             getFromAddress(tx).Hex(),
-		Gas:              tx.Gas(),
-		GasPrice:         tx.GasPrice().String(),
-		Value:            tx.Value().String(),
-		GasUsed:          gasUsed,
-		Status:           status,
+	if receipt != nil {
+		index := receipt.TransactionIndex
+		response.BlockNumber = receipt.BlockNumber.String()
+		response.BlockHash = receipt.BlockHash.Hex()
+		response.TransactionIndex = &index
+		response.GasUsed = receipt.GasUsed
+		response.Status = services.TxStatusSuccess
+		if receipt.Status == types.ReceiptStatusFailed {
+			response.Status = services.TxStatusFailed
+		}
 	}
 
 	if tx.To() != nil {
 		response.To = tx.To().Hex()
+	} else {
+		// Contract creations deploy to an address derived from the sender and
+		// nonce, known before the receipt is
+		response.ContractCreation = true
+		var contractAddress common.Address
+		if receipt != nil {
+			contractAddress = receipt.ContractAddress
+		}
+		if contractAddress == (common.Address{}) && from != (common.Address{}) {
+			contractAddress = crypto.CreateAddress(from, tx.Nonce())
+		}
+		if contractAddress != (common.Address{}) {
+			response.ContractAddress = contractAddress.Hex()
+		}
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -212,13 +280,19 @@ func (a *App) getAddressBalance(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	balance, err := a.ethClient.BalanceAt(ctx, address, nil)
+	_, header, ok := a.resolveBlockQuery(ctx, c)
+	if !ok {
+		return
+	}
+
+	var balance *big.Int
+	source, err := a.history.Read(ctx, header.Number, func(client services.ChainClient) error {
+		var err error
+		balance, err = client.BalanceAt(ctx, address, header.Number)
+		return err
+	})
 	if err != nil {
-		a.logger.WithError(err).Error("Failed to get balance")
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "balance_fetch_failed",
-			Message: "Failed to retrieve address balance",
-		})
+		a.stateReadFailed(c, err, header, "balance_fetch_failed", "Failed to retrieve address balance")
 		return
 	}
 
@@ -229,6 +303,8 @@ func (a *App) getAddressBalance(c *gin.Context) {
 		Address:    address.Hex(),
 		Balance:    balance.String(),
 		BalanceEth: balanceEth.String(),
+		BlockNumber: header.Number.Uint64(),
+		Source:      source,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -295,14 +371,20 @@ func (a *App) getContractInfo(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	_, header, ok := a.resolveBlockQuery(ctx, c)
+	if !ok {
+		return
+	}
+
 	// Get contract code
-	code, err := a.ethClient.CodeAt(ctx, address, nil)
+	var code []byte
+	source, err := a.history.Read(ctx, header.Number, func(client services.ChainClient) error {
+		var err error
+		code, err = client.CodeAt(ctx, address, header.Number)
+		return err
+	})
 	if err != nil {
-		a.logger.WithError(err).Error("Failed to get contract code")
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "contract_info_failed",
-			Message: "Failed to retrieve contract information",
-		})
+		a.stateReadFailed(c, err, header, "contract_info_failed", "Failed to retrieve contract information")
 		return
 	}
 
@@ -314,16 +396,42 @@ func (a *App) getContractInfo(c *gin.Context) {
 		Code:       codeHex,
 		CodeSize:   len(code),
 		IsContract: isContract,
+		BlockNumber: header.Number.Uint64(),
+		Source:      source,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// Helper function to extract from address from transaction
+// Helper function to extract from address from transaction. The latest signer
+// accepts every transaction type, including dynamic-fee transactions.
 func getFromAddress(tx *types.Transaction) common.Address {
-	from, err := types.Sender(types.NewEIP155Signer(tx.ChainId()), tx)
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
 	if err != nil {
 		return common.Address{}
 	}
 	return from
-}
\ No newline at end of file
+}
+// parsePagination reads limit and offset query parameters, aborting the
+// request when they are malformed
+func parsePagination(c *gin.Context, defaultLimit, maxLimit int) (int, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit <= 0 || limit > maxLimit {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_limit",
+			Message: "Limit must be between 1 and " + strconv.Itoa(maxLimit),
+		})
+		return 0, 0, false
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_offset",
+			Message: "Offset must be a non-negative integer",
+		})
+		return 0, 0, false
+	}
+
+	return limit, offset, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHolderTop = 50
+	maxHolderTop     = 1000
+)
+
+// getTokenHolders reports how a token's supply is spread over its holders.
+// While the token's transfers are being replayed it responds 202 with the task.
+func (a *App) getTokenHolders(c *gin.Context) {
+	addressStr := c.Param("address")
+
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	top, err := strconv.Atoi(c.DefaultQuery("top", strconv.Itoa(defaultHolderTop)))
+	if err != nil || top <= 0 || top > maxHolderTop {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_top",
+			Message: "Top must be between 1 and " + strconv.Itoa(maxHolderTop),
+		})
+		return
+	}
+
+	distribution, task, err := a.holders.Distribution(common.HexToAddress(addressStr), top)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to start holder scan")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "holders_failed",
+			Message: "Failed to compute holder distribution",
+		})
+		return
+	}
+
+	if distribution == nil {
+		c.Header("Location", "/api/v1/holder-tasks/"+task.ID)
+		c.JSON(http.StatusAccepted, gin.H{
+			"status": "scanning",
+			"task":   task,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, distribution)
+}
+
+// getHolderTask returns the progress of a holder scan
+func (a *App) getHolderTask(c *gin.Context) {
+	task, ok := a.holders.Task(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "holder_task_not_found",
+			Message: "Holder task not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, task)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// getKillSwitch returns whether actions are suspended and the recent changes
+func (a *App) getKillSwitch(c *gin.Context) {
+	state, err := a.killSwitch.State(c.Request.Context())
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to read kill switch state")
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "kill_switch_unavailable",
+			Message: "Kill switch state can't be read; actions are suspended until it can",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"state":   state,
+		"changes": a.killSwitch.Changes(),
+	})
+}
+
+// updateKillSwitch engages or disengages the action kill switch. Both need
+// who is making the change and why.
+func (a *App) updateKillSwitch(c *gin.Context) {
+	var request struct {
+		Engaged *bool  `json:"engaged" binding:"required"`
+		By      string `json:"by"`
+		Reason  string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	by, reason := strings.TrimSpace(request.By), strings.TrimSpace(request.Reason)
+	if by == "" || reason == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "by and reason are required",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	change := a.killSwitch.Disengage
+	if *request.Engaged {
+		change = a.killSwitch.Engage
+	}
+	state, err := change(ctx, by, reason)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to change kill switch state")
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "kill_switch_unavailable",
+			Message: "Failed to save the kill switch state",
+		})
+		return
+	}
+
+	a.logger.WithFields(logrus.Fields{
+		"engaged": state.Engaged,
+		"by":      by,
+		"reason":  reason,
+	}).Warn("Action kill switch changed")
+	c.JSON(http.StatusOK, gin.H{
+		"state":   state,
+		"changes": a.killSwitch.Changes(),
+	})
+}
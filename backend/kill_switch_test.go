@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestKillSwitchAdminEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	killSwitch := services.NewKillSwitch(&services.MemoryKillSwitchStore{})
+	app := &App{router: gin.New(), logger: logrus.New(), killSwitch: killSwitch}
+	app.router.GET("/admin/actions/kill-switch", app.getKillSwitch)
+	app.router.PUT("/admin/actions/kill-switch", app.updateKillSwitch)
+
+	request := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/admin/actions/kill-switch", bytes.NewReader([]byte(body)))
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("PUT", `{"engaged":true,"by":"oncall"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "a reason is required")
+	w = request("PUT", `{"by":"oncall","reason":"incident"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "engaged is required")
+
+	w = request("PUT", `{"engaged":true,"by":"oncall","reason":"incident 42"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.ErrorIs(t, killSwitch.Check(context.Background()), services.ErrActionsSuspended)
+
+	w = request("GET", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		State   services.KillSwitchState   `json:"state"`
+		Changes []services.KillSwitchState `json:"changes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.True(t, body.State.Engaged)
+	assert.Equal(t, "oncall", body.State.ChangedBy)
+	assert.Equal(t, "incident 42", body.State.Reason)
+	assert.Len(t, body.Changes, 1)
+
+	w = request("PUT", `{"engaged":false,"by":"oncall","reason":"resolved"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, killSwitch.Check(context.Background()))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// listSort reads the sort and order query parameters of a list endpoint. It
+// reports whether either was given; unsupported values get a 400.
+func listSort[T any](c *gin.Context, ordering services.ListOrdering[T]) (services.ListSort, bool, bool) {
+	field, order := c.Query("sort"), c.Query("order")
+	by, err := ordering.Parse(field, order)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "invalid_sort",
+			"message":   err.Error(),
+			"supported": ordering.Fields(),
+		})
+		return services.ListSort{}, false, false
+	}
+	return by, field != "" || order != "", true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getNewTokens lists the tokens deployed and first transferred within a
+// window, newest first. Spam and unverified tokens with less initial
+// liquidity than min_liquidity USD are left out; include_spam=true keeps spam.
+func (a *App) getNewTokens(c *gin.Context) {
+	window, err := parseWindow(c.DefaultQuery("since", "7d"))
+	if err != nil || window <= 0 || window > services.ListingRetention {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_since",
+			Message: "Since must be a duration such as 24h or 7d, up to 30d",
+		})
+		return
+	}
+
+	minLiquidity := a.listings.MinLiquidity()
+	if value := c.Query("min_liquidity"); value != "" {
+		minLiquidity, err = strconv.ParseFloat(value, 64)
+		if err != nil || minLiquidity < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_min_liquidity",
+				Message: "min_liquidity must be a non-negative amount in USD",
+			})
+			return
+		}
+	}
+	includeSpam := c.Query("include_spam") == "true"
+
+	tokens := a.listings.NewTokens(time.Now().Add(-window), minLiquidity, includeSpam)
+	c.JSON(http.StatusOK, gin.H{
+		"since":         window.String(),
+		"min_liquidity": minLiquidity,
+		"include_spam":  includeSpam,
+		"tokens":        tokens,
+		"count":         len(tokens),
+	})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+const (
+	// latencyWindowSize is the number of recent request latencies kept per group
+	latencyWindowSize = 200
+	// latencyEvalInterval is how many completed requests pass between p95 evaluations
+	latencyEvalInterval = 20
+)
+
+// LoadShedder bounds the number of in-flight requests for a route group and
+// rejects the excess instead of letting goroutines queue up behind a slow RPC.
+// When the observed p95 latency exceeds the configured SLO the effective limit
+// is tightened, and it recovers gradually once latency is back within budget.
+type LoadShedder struct {
+	name string
+
+	mu             sync.Mutex
+	maxInFlight    int
+	effectiveLimit int
+	latencySLO     time.Duration
+	retryAfter     time.Duration
+	inFlight       int
+	latencies      []time.Duration
+	nextSample     int
+	sinceEval      int
+	lastP95        time.Duration
+	shed           uint64
+	served         uint64
+}
+
+// LoadShedderStats is a snapshot of a load shedder's state
+type LoadShedderStats struct {
+	Group          string `json:"group"`
+	MaxInFlight    int    `json:"max_in_flight"`
+	EffectiveLimit int    `json:"effective_limit"`
+	InFlight       int    `json:"in_flight"`
+	LatencySLOMs   int64  `json:"latency_slo_ms"`
+	P95LatencyMs   int64  `json:"p95_latency_ms"`
+	Served         uint64 `json:"served"`
+	Shed           uint64 `json:"shed"`
+}
+
+// NewLoadShedder creates a load shedder for the named route group
+func NewLoadShedder(name string, maxInFlight int, latencySLO time.Duration) *LoadShedder {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	return &LoadShedder{
+		name:           name,
+		maxInFlight:    maxInFlight,
+		effectiveLimit: maxInFlight,
+		latencySLO:     latencySLO,
+		retryAfter:     time.Second,
+		latencies:      make([]time.Duration, 0, latencyWindowSize),
+	}
+}
+
+// Middleware returns a gin handler enforcing the in-flight budget
+func (ls *LoadShedder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !ls.acquire() {
+			c.Header("Retry-After", strconv.Itoa(int(ls.retryAfter.Seconds())))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, ErrorResponse{
+				Error:   "overloaded",
+				Message: "Too many concurrent requests for " + ls.name + ", please retry later",
+			})
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			ls.release(time.Since(start))
+		}()
+
+		c.Next()
+	}
+}
+
+// acquire reserves an in-flight slot, returning false when the budget is exhausted
+func (ls *LoadShedder) acquire() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.inFlight >= ls.effectiveLimit {
+		ls.shed++
+		return false
+	}
+	ls.inFlight++
+	return true
+}
+
+// release frees an in-flight slot and records the request latency
+func (ls *LoadShedder) release(latency time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.inFlight--
+	ls.served++
+
+	if len(ls.latencies) < latencyWindowSize {
+		ls.latencies = append(ls.latencies, latency)
+	} else {
+		ls.latencies[ls.nextSample] = latency
+	}
+	ls.nextSample = (ls.nextSample + 1) % latencyWindowSize
+
+	ls.sinceEval++
+	if ls.sinceEval >= latencyEvalInterval {
+		ls.sinceEval = 0
+		ls.adapt()
+	}
+}
+
+// adapt tightens the effective limit when p95 latency breaches the SLO and
+// relaxes it one slot at a time otherwise. The latency window starts over
+// whenever the limit changes, so the next evaluation only sees requests served
+// under the new limit rather than tightening again on the same slow samples.
+// Callers must hold ls.mu.
+func (ls *LoadShedder) adapt() {
+	ls.lastP95 = percentile(ls.latencies, 0.95)
+	if ls.latencySLO <= 0 {
+		ls.effectiveLimit = ls.maxInFlight
+		return
+	}
+
+	previous := ls.effectiveLimit
+	if ls.lastP95 > ls.latencySLO {
+		tightened := ls.effectiveLimit * 3 / 4
+		if tightened < 1 {
+			tightened = 1
+		}
+		ls.effectiveLimit = tightened
+	} else if ls.effectiveLimit < ls.maxInFlight {
+		ls.effectiveLimit++
+	}
+	if ls.effectiveLimit != previous {
+		ls.latencies = ls.latencies[:0]
+		ls.nextSample = 0
+	}
+}
+
+// SetLimits updates the configured budget; zero values leave a setting unchanged
+func (ls *LoadShedder) SetLimits(maxInFlight int, latencySLO time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if maxInFlight > 0 {
+		ls.maxInFlight = maxInFlight
+		ls.effectiveLimit = maxInFlight
+	}
+	if latencySLO > 0 {
+		ls.latencySLO = latencySLO
+	}
+}
+
+// Stats returns a snapshot of the shedder state
+func (ls *LoadShedder) Stats() LoadShedderStats {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return LoadShedderStats{
+		Group:          ls.name,
+		MaxInFlight:    ls.maxInFlight,
+		EffectiveLimit: ls.effectiveLimit,
+		InFlight:       ls.inFlight,
+		LatencySLOMs:   ls.latencySLO.Milliseconds(),
+		P95LatencyMs:   ls.lastP95.Milliseconds(),
+		Served:         ls.served,
+		Shed:           ls.shed,
+	}
+}
+
+// percentile returns the p-th percentile (0..1) of the given samples
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+// newLoadShedders builds the per-route-group shedders from configuration
+func newLoadShedders(config *Config) map[string]*LoadShedder {
+	return map[string]*LoadShedder{
+		"data":      NewLoadShedder("data", config.DataMaxInFlight, config.LatencySLO),
+		"analytics": NewLoadShedder("analytics", config.AnalyticsMaxInFlight, config.LatencySLO),
+		"chat":      NewLoadShedder("chat", config.ChatMaxInFlight, config.LatencySLO),
+	}
+}
+
+// Admin endpoints
+
+// requireAdmin rejects requests that don't carry the configured admin API key
+func (a *App) requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.config == nil || a.config.AdminAPIKey == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{
+				Error:   "admin_disabled",
+				Message: "Admin API is disabled; set ADMIN_API_KEY to enable it",
+			})
+			return
+		}
+		if !a.isAdminRequest(c) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "A valid admin API key is required",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// httpRecorder returns the recorder of outbound HTTP calls, or nil when the
+// app runs without a data collector
+func (a *App) httpRecorder() *services.RecordingTransport {
+	if a.dataCollector == nil {
+		return nil
+	}
+	return a.dataCollector.HTTPRecorder()
+}
+
+// getAdminFlags returns the current runtime-adjustable settings. HTTP
+// recording is left out when there is no recorder to toggle.
+func (a *App) getAdminFlags(c *gin.Context) {
+	shedding := make(map[string]LoadShedderStats, len(a.shedders))
+	for name, shedder := range a.shedders {
+		shedding[name] = shedder.Stats()
+	}
+
+	flags := gin.H{"load_shedding": shedding}
+	if recorder := a.httpRecorder(); recorder != nil {
+		flags["http_recording"] = recorder.Enabled()
+	}
+	c.JSON(http.StatusOK, flags)
+}
+
+// updateAdminFlags adjusts runtime settings without a restart
+func (a *App) updateAdminFlags(c *gin.Context) {
+	var request struct {
+		LoadShedding map[string]struct {
+			MaxInFlight  int   `json:"max_in_flight"`
+			LatencySLOMs int64 `json:"latency_slo_ms"`
+		} `json:"load_shedding"`
+		HTTPRecording *bool `json:"http_recording"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Validate everything first so a bad entry doesn't leave a partial update
+	for name, limits := range request.LoadShedding {
+		if _, ok := a.shedders[name]; !ok {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "unknown_group",
+				Message: "Unknown load shedding group: " + name,
+			})
+			return
+		}
+		if limits.MaxInFlight < 0 || limits.LatencySLOMs < 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_limits",
+				Message: "Limits must not be negative",
+			})
+			return
+		}
+	}
+
+	recorder := a.httpRecorder()
+	if request.HTTPRecording != nil && recorder == nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "http_recording_unavailable",
+			Message: "HTTP recording can't be toggled without a data collector",
+		})
+		return
+	}
+
+	for name, limits := range request.LoadShedding {
+		a.shedders[name].SetLimits(limits.MaxInFlight, time.Duration(limits.LatencySLOMs)*time.Millisecond)
+	}
+	if request.HTTPRecording != nil {
+		recorder.SetEnabled(*request.HTTPRecording)
+		a.logger.WithField("enabled", *request.HTTPRecording).Info("HTTP recording toggled")
+	}
+
+	a.getAdminFlags(c)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func setupShedderRouter(shedder *LoadShedder, handlerDelay time.Duration) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/slow", shedder.Middleware(), func(c *gin.Context) {
+		time.Sleep(handlerDelay)
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestLoadShedderShedsExcessRequests(t *testing.T) {
+	shedder := NewLoadShedder("analytics", 5, 0)
+	router := setupShedderRouter(shedder, 300*time.Millisecond)
+
+	const total = 40
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	var slowestShed time.Duration
+
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/slow", nil)
+			router.ServeHTTP(w, req)
+			elapsed := time.Since(start)
+
+			mu.Lock()
+			defer mu.Unlock()
+			codes[w.Code]++
+			if w.Code == http.StatusServiceUnavailable {
+				assert.Equal(t, "1", w.Header().Get("Retry-After"))
+				if elapsed > slowestShed {
+					slowestShed = elapsed
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, total, codes[http.StatusOK]+codes[http.StatusServiceUnavailable])
+	assert.LessOrEqual(t, codes[http.StatusOK], 5+1, "only the budget plus late arrivals should be served")
+	assert.GreaterOrEqual(t, codes[http.StatusServiceUnavailable], total-10)
+	// Shed requests must be rejected immediately rather than waiting behind the slow handler
+	assert.Less(t, slowestShed, 150*time.Millisecond)
+
+	stats := shedder.Stats()
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, uint64(codes[http.StatusServiceUnavailable]), stats.Shed)
+}
+
+func TestLoadShedderTightensOnSLOBreach(t *testing.T) {
+	shedder := NewLoadShedder("data", 8, 10*time.Millisecond)
+
+	// Each completed request takes far longer than the SLO
+	for i := 0; i < latencyEvalInterval; i++ {
+		assert.True(t, shedder.acquire())
+		shedder.release(50 * time.Millisecond)
+	}
+
+	stats := shedder.Stats()
+	assert.Equal(t, 6, stats.EffectiveLimit)
+	assert.Equal(t, int64(50), stats.P95LatencyMs)
+
+	// Once the slow samples age out of the window the limit recovers slot by slot
+	for i := 0; i < 2*latencyWindowSize; i++ {
+		assert.True(t, shedder.acquire())
+		shedder.release(time.Millisecond)
+	}
+	assert.Equal(t, 8, shedder.Stats().EffectiveLimit)
+}
+
+func TestLoadShedderTightensOnceForOneSlowPatch(t *testing.T) {
+	shedder := NewLoadShedder("data", 8, 10*time.Millisecond)
+
+	for i := 0; i < latencyEvalInterval; i++ {
+		assert.True(t, shedder.acquire())
+		shedder.release(50 * time.Millisecond)
+	}
+	require.Equal(t, 6, shedder.Stats().EffectiveLimit)
+
+	// Requests served under the tightened limit are fast again; the slow ones
+	// from before don't count against it a second time
+	for i := 0; i < latencyEvalInterval; i++ {
+		assert.True(t, shedder.acquire())
+		shedder.release(time.Millisecond)
+	}
+	stats := shedder.Stats()
+	assert.Equal(t, 7, stats.EffectiveLimit)
+	assert.Equal(t, int64(1), stats.P95LatencyMs)
+}
+
+func TestAdminFlagsUpdatesLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	app := &App{
+		router:        gin.New(),
+		config:        &Config{AdminAPIKey: "secret"},
+		dataCollector: services.NewDataCollector(nil),
+		shedders:      newLoadShedders(&Config{DataMaxInFlight: 10, AnalyticsMaxInFlight: 10, ChatMaxInFlight: 10}),
+	}
+	admin := app.router.Group("/api/v1/admin", app.requireAdmin())
+	admin.GET("/flags", app.getAdminFlags)
+	admin.PUT("/flags", app.updateAdminFlags)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/admin/flags", nil)
+	app.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	body := []byte(`{"load_shedding":{"analytics":{"max_in_flight":3,"latency_slo_ms":500}}}`)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/admin/flags", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	app.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	stats := app.shedders["analytics"].Stats()
+	assert.Equal(t, 3, stats.MaxInFlight)
+	assert.Equal(t, int64(500), stats.LatencySLOMs)
+
+	body = []byte(`{"load_shedding":{"unknown":{"max_in_flight":3}}}`)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/admin/flags", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	app.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdminFlagsWithoutCollector(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	app := &App{
+		router:   gin.New(),
+		shedders: newLoadShedders(&Config{DataMaxInFlight: 10, AnalyticsMaxInFlight: 10, ChatMaxInFlight: 10}),
+	}
+	app.router.GET("/flags", app.getAdminFlags)
+	app.router.PUT("/flags", app.updateAdminFlags)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/flags", nil)
+	app.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "http_recording")
+
+	// Toggling recording fails without touching the other settings
+	body := []byte(`{"load_shedding":{"analytics":{"max_in_flight":3}},"http_recording":true}`)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/flags", bytes.NewReader(body))
+	app.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "http_recording_unavailable")
+	assert.Equal(t, 10, app.shedders["analytics"].Stats().MaxInFlight)
+}
+
+func TestRequireAdminChecksKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	app := &App{router: gin.New(), config: &Config{AdminAPIKey: "secret"}}
+	app.router.GET("/admin", app.requireAdmin(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	get := func(authorization string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		app.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, get("Bearer secret"))
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer secreT"))
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer secret2"))
+	assert.Equal(t, http.StatusUnauthorized, get(""))
+
+	// Without a key every admin request is refused, the empty one included
+	app.config.AdminAPIKey = ""
+	assert.Equal(t, http.StatusForbidden, get("Bearer "))
+	assert.Equal(t, http.StatusForbidden, get("Bearer secret"))
+}
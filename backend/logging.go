@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// logComponentField is the entry field naming the component that logged it
+	logComponentField = "component"
+	// maxLogSampleKeys bounds the sampled messages tracked; the counts start
+	// over when messages with varying text fill it
+	maxLogSampleKeys = 1000
+)
+
+// stdLogLine matches a line of the services' standard library loggers:
+// "[Name] 2006/01/02 15:04:05 message"
+var stdLogLine = regexp.MustCompile(`^\[([^\]]+)\] (?:\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} )?(.*)$`)
+
+// LogSettings are the runtime-adjustable logging settings
+type LogSettings struct {
+	Level       string            `json:"level"`
+	Components  map[string]string `json:"components"`
+	SampleEvery int               `json:"sample_every"`
+	// Seen lists the components that have logged since startup
+	Seen []string `json:"seen,omitempty"`
+}
+
+// logSample counts the occurrences of one debug message
+type logSample struct {
+	seen       uint64
+	suppressed uint64
+}
+
+// LogControl decides at runtime which entries are written. It takes over the
+// logger's output as a hook, so the level, per-component overrides, and
+// sampling of debug messages can change without a restart.
+type LogControl struct {
+	logger    *logrus.Logger
+	out       io.Writer
+	formatter logrus.Formatter
+
+	mu          sync.Mutex
+	level       logrus.Level
+	overrides   map[string]logrus.Level
+	sampleEvery int
+	samples     map[string]*logSample
+	seen        map[string]bool
+}
+
+// NewLogControl routes the logger's entries through the control. Debug and
+// trace messages repeating the same text are written once every sampleEvery.
+func NewLogControl(logger *logrus.Logger, level logrus.Level, sampleEvery int) *LogControl {
+	if sampleEvery < 1 {
+		sampleEvery = 1
+	}
+	lc := &LogControl{
+		logger:      logger,
+		out:         logger.Out,
+		formatter:   logger.Formatter,
+		level:       level,
+		overrides:   make(map[string]logrus.Level),
+		sampleEvery: sampleEvery,
+		samples:     make(map[string]*logSample),
+		seen:        make(map[string]bool),
+	}
+
+	// Every entry reaches the hook, which writes those that pass
+	logger.SetLevel(logrus.TraceLevel)
+	logger.SetFormatter(discardFormatter{})
+	logger.SetOutput(io.Discard)
+	logger.AddHook(lc)
+	return lc
+}
+
+// Component returns an entry for the named component, subject to its override
+func (lc *LogControl) Component(name string) *logrus.Entry {
+	return lc.logger.WithField(logComponentField, name)
+}
+
+// Levels implements logrus.Hook
+func (lc *LogControl) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, writing the entry when it passes
+func (lc *LogControl) Fire(entry *logrus.Entry) error {
+	component, _ := entry.Data[logComponentField].(string)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if component != "" {
+		lc.seen[component] = true
+	}
+	level, ok := lc.overrides[component]
+	if !ok {
+		level = lc.level
+	}
+	if entry.Level > level {
+		return nil
+	}
+
+	if entry.Level >= logrus.DebugLevel && lc.sampleEvery > 1 {
+		key := component + "\x00" + entry.Message
+		sample, ok := lc.samples[key]
+		if !ok {
+			if len(lc.samples) >= maxLogSampleKeys {
+				lc.samples = make(map[string]*logSample)
+			}
+			sample = &logSample{}
+			lc.samples[key] = sample
+		}
+		sample.seen++
+		if (sample.seen-1)%uint64(lc.sampleEvery) != 0 {
+			sample.suppressed++
+			return nil
+		}
+		if sample.suppressed > 0 {
+			entry.Data["suppressed"] = sample.suppressed
+			sample.suppressed = 0
+		}
+	}
+
+	serialized, err := lc.formatter.Format(entry)
+	if err != nil {
+		return fmt.Errorf("failed to format log entry: %w", err)
+	}
+	_, err = lc.out.Write(serialized)
+	return err
+}
+
+// Settings returns the current settings
+func (lc *LogControl) Settings() LogSettings {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	settings := LogSettings{
+		Level:       lc.level.String(),
+		Components:  make(map[string]string, len(lc.overrides)),
+		SampleEvery: lc.sampleEvery,
+	}
+	for component, level := range lc.overrides {
+		settings.Components[component] = level.String()
+	}
+	for component := range lc.seen {
+		settings.Seen = append(settings.Seen, component)
+	}
+	sort.Strings(settings.Seen)
+	return settings
+}
+
+// Update changes the global level, the sampling rate, and the component
+// overrides. A component set to an empty level drops its override. Nothing
+// changes when any value is invalid.
+func (lc *LogControl) Update(level string, components map[string]string, sampleEvery int) error {
+	var global logrus.Level
+	if level != "" {
+		parsed, err := logrus.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		global = parsed
+	}
+	overrides := make(map[string]*logrus.Level, len(components))
+	for component, value := range components {
+		if value == "" {
+			overrides[component] = nil
+			continue
+		}
+		parsed, err := logrus.ParseLevel(value)
+		if err != nil {
+			return fmt.Errorf("component %s: %w", component, err)
+		}
+		overrides[component] = &parsed
+	}
+	if sampleEvery < 0 {
+		return fmt.Errorf("sample_every must not be negative, got %d", sampleEvery)
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if level != "" {
+		lc.level = global
+	}
+	for component, override := range overrides {
+		if override == nil {
+			delete(lc.overrides, component)
+		} else {
+			lc.overrides[component] = *override
+		}
+	}
+	if sampleEvery > 0 {
+		lc.sampleEvery = sampleEvery
+		lc.samples = make(map[string]*logSample)
+	}
+	return nil
+}
+
+// StdWriter returns a writer for standard library loggers. Lines are logged
+// under the component named by their "[Name]" prefix, lowercased; lines that
+// report a failure are logged as warnings and the rest as info.
+func (lc *LogControl) StdWriter() io.Writer {
+	return stdLogWriter{lc: lc}
+}
+
+// stdLogWriter relays standard library log lines to the controlled logger
+type stdLogWriter struct {
+	lc *LogControl
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		component, message := "", string(line)
+		if match := stdLogLine.FindStringSubmatch(message); match != nil {
+			component, message = strings.ToLower(match[1]), match[2]
+		}
+
+		entry := w.lc.logger.WithField(logComponentField, component)
+		if component == "" {
+			entry = logrus.NewEntry(w.lc.logger)
+		}
+		if strings.HasPrefix(message, "Failed") {
+			entry.Warn(message)
+		} else {
+			entry.Info(message)
+		}
+	}
+	return len(p), nil
+}
+
+// discardFormatter formats nothing, since LogControl writes the entries
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// getLogSettings returns the runtime logging settings
+func (a *App) getLogSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, a.logs.Settings())
+}
+
+// updateLogSettings changes the log level, component overrides, and debug
+// sampling without a restart
+func (a *App) updateLogSettings(c *gin.Context) {
+	var request struct {
+		Level       string            `json:"level"`
+		Components  map[string]string `json:"components"`
+		SampleEvery int               `json:"sample_every"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := a.logs.Update(request.Level, request.Components, request.SampleEvery); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_log_settings",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	a.logger.WithField("settings", a.logs.Settings()).Info("Log settings updated")
+	c.JSON(http.StatusOK, a.logs.Settings())
+}
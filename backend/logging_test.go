@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLogControl returns a control over a JSON logger writing to the buffer
+func newTestLogControl(sampleEvery int) (*LogControl, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	return NewLogControl(logger, logrus.InfoLevel, sampleEvery), &out
+}
+
+// logLines decodes the JSON lines written so far and resets the buffer
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields))
+		lines = append(lines, fields)
+	}
+	out.Reset()
+	return lines
+}
+
+func TestLogControlLevelsAtRuntime(t *testing.T) {
+	logs, out := newTestLogControl(1)
+	collector := logs.Component("collector")
+	chat := logs.Component("chat")
+
+	collector.Debug("Processed block")
+	chat.Info("Message answered")
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "chat", lines[0]["component"])
+
+	// Raising one component and lowering another takes effect at once
+	require.NoError(t, logs.Update("", map[string]string{"collector": "debug", "chat": "warn"}, 0))
+	collector.Debug("Processed block")
+	chat.Info("Message answered")
+	chat.Warn("Slow answer")
+	lines = logLines(t, out)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Processed block", lines[0]["msg"])
+	assert.Equal(t, "Slow answer", lines[1]["msg"])
+
+	// The global level applies to components without an override
+	require.NoError(t, logs.Update("error", map[string]string{"chat": ""}, 0))
+	chat.Warn("Slow answer")
+	collector.Debug("Processed block")
+	logs.Component("reports").Error("Digest failed")
+	lines = logLines(t, out)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Processed block", lines[0]["msg"])
+	assert.Equal(t, "Digest failed", lines[1]["msg"])
+
+	// Invalid settings change nothing
+	assert.Error(t, logs.Update("loud", nil, 0))
+	assert.Error(t, logs.Update("", map[string]string{"chat": "loud"}, 0))
+	settings := logs.Settings()
+	assert.Equal(t, "error", settings.Level)
+	assert.Equal(t, map[string]string{"collector": "debug"}, settings.Components)
+	assert.Equal(t, []string{"chat", "collector", "reports"}, settings.Seen)
+}
+
+func TestLogControlSamplesDebugMessages(t *testing.T) {
+	logs, out := newTestLogControl(3)
+	require.NoError(t, logs.Update("debug", nil, 0))
+	collector := logs.Component("collector")
+
+	for i := 0; i < 7; i++ {
+		collector.Debug("Processed block")
+	}
+	collector.Debug("Processed transaction")
+	// Info and above are never sampled
+	for i := 0; i < 3; i++ {
+		collector.Info("Collected gas price")
+	}
+
+	lines := logLines(t, out)
+	var blocks []map[string]interface{}
+	for _, line := range lines {
+		if line["msg"] == "Processed block" {
+			blocks = append(blocks, line)
+		}
+	}
+	// The 1st, 4th and 7th are written, with the count skipped since the last
+	require.Len(t, blocks, 3)
+	assert.Nil(t, blocks[0]["suppressed"])
+	assert.Equal(t, 2.0, blocks[1]["suppressed"])
+	assert.Equal(t, 2.0, blocks[2]["suppressed"])
+	assert.Len(t, lines, 7)
+}
+
+func TestLogControlStandardLogger(t *testing.T) {
+	logs, out := newTestLogControl(1)
+	std := log.New(logs.StdWriter(), "[ChatEngine] ", log.LstdFlags)
+
+	std.Printf("Processed message")
+	std.Printf("Failed to dispatch action webhook: timeout")
+	lines := logLines(t, out)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "chatengine", lines[0]["component"])
+	assert.Equal(t, "Processed message", lines[0]["msg"])
+	assert.Equal(t, "info", lines[0]["level"])
+	assert.Equal(t, "warning", lines[1]["level"])
+
+	require.NoError(t, logs.Update("", map[string]string{"chatengine": "error"}, 0))
+	std.Printf("Processed message")
+	assert.Empty(t, logLines(t, out))
+}
+
+func TestLogSettingsEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs, out := newTestLogControl(100)
+	app := &App{router: gin.New(), logger: logs.logger, logs: logs}
+	app.router.GET("/admin/logging", app.getLogSettings)
+	app.router.PUT("/admin/logging", app.updateLogSettings)
+
+	request := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/admin/logging", strings.NewReader(body))
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("PUT", `{"components":{"collector":"chatty"}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_log_settings")
+
+	w = request("PUT", `{"level":"warn","components":{"collector":"debug"},"sample_every":10}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var settings LogSettings
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &settings))
+	assert.Equal(t, "warning", settings.Level)
+	assert.Equal(t, "debug", settings.Components["collector"])
+	assert.Equal(t, 10, settings.SampleEvery)
+	out.Reset()
+
+	app.logger.Info("Hidden at warn")
+	logs.Component("collector").Debug("Shown by override")
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "Shown by override", lines[0]["msg"])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// lpPosition values the LP tokens of a pool held by the user, or by the
+// caller when the request names no user. It aborts the request when the
+// position can't be valued.
+func (a *App) lpPosition(c *gin.Context, pool, userAddress string) (*services.LPPosition, bool) {
+	if !common.IsHexAddress(pool) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "lp_position must be a valid Ethereum address",
+		})
+		return nil, false
+	}
+
+	holder := userAddress
+	if holder == "" {
+		holder, _ = callerAddress(c)
+	}
+	if !common.IsHexAddress(holder) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "An LP position needs a valid user_address or a signed-in caller",
+		})
+		return nil, false
+	}
+
+	position, err := a.pools.Position(c.Request.Context(), common.HexToAddress(pool), common.HexToAddress(holder))
+	if errors.Is(err, services.ErrNotLiquidityPool) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "not_liquidity_pool",
+			Message: "lp_position must be a pool's LP token exposing token0, token1, and getReserves",
+		})
+		return nil, false
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to value LP position")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "lp_valuation_failed",
+			Message: "Failed to read the pool's reserves",
+		})
+		return nil, false
+	}
+	return position, true
+}
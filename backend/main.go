@@ -3,17 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
@@ -24,11 +26,67 @@ import (
 // App represents the main application
 type App struct {
 	router          *gin.Engine
-	ethClient       *ethclient.Client
+	ethClient       services.ChainClient
+	history         *services.HistoryRouter
+	rpc             *services.FailoverClient
+	node            *services.NodeMonitor
 	logger          *logrus.Logger
+	logs            *LogControl
+	panics          *services.PanicGuard
 	analyticsEngine *services.AnalyticsEngine
 	dataCollector   *services.DataCollector
 	chatEngine      *services.ChatEngine
+	chatLimiter     *services.ChatRateLimiter
+	authLimiter     *services.ChatRateLimiter
+	webhooks        *services.WebhookDispatcher
+	summaries       *services.AddressSummarizer
+	tokenBalances   *services.ERC20BalanceReader
+	fees            *services.FeeAnalyzer
+	contracts       *services.ContractManager
+	registryTasks   *services.RegistryTasks
+	deployments     *services.ContractDeployments
+	priceFeed       *services.PriceFeed
+	priceAlerts     *services.PriceAlerts
+	usage           *services.UsageTracker
+	retention       *services.RetentionEngine
+	congestion      *services.CongestionTracker
+	portfolios      *services.PortfolioTracker
+	audit           *services.ActionAuditLog
+	actions         *services.ActionQueue
+	schedules       *services.ActionSchedules
+	signing         *services.SigningService
+	killSwitch      *services.KillSwitch
+	spending        *services.SpendingLimiter
+	walletLinks     *services.WalletLinks
+	sessions        *services.Sessions
+	watchlist       *services.Watchlist
+	backfills       *services.ReceiptBackfiller
+	holders         *services.HolderAnalyzer
+	pools           *services.LiquidityPoolReader
+	preferences     *services.PreferenceStore
+	feedback        *services.ChatFeedbackStore
+	transcripts     *services.ChatTranscripts
+	shares          *services.ChatShares
+	votingPower     *services.VotingPowerReader
+	subscriptions   *services.SubscriptionCatalogs
+	staking         *services.StakingCollector
+	positions       *services.StakingPositions
+	notifications   *services.NotificationStore
+	reports         *services.ReportService
+	backtests       *services.Backtester
+	userData        *services.UserDataService
+	config          *Config
+	shedders        map[string]*LoadShedder
+
+	// executionQuality tracks the realized slippage of executed swaps
+	executionQuality *services.ExecutionQuality
+
+	// cachePrimer recomputes popular cache keys before they expire
+	cachePrimer *services.CachePrimer
+	// overview composes the home page payload
+	overview *services.OverviewService
+	// listings detects newly deployed tokens
+	listings *services.ListingDetector
 }
 
 // Config holds application configuration
@@ -36,6 +94,178 @@ type Config struct {
 	Port        string
 	EthNodeURL  string
 	Environment string
+	AdminAPIKey string
+
+	// HTTPRecording records the collector's external API calls from startup;
+	// it can also be toggled with the admin flags
+	HTTPRecording bool
+
+	// Log level, defaulting to debug in development and info elsewhere, and
+	// how often repeated debug messages are written (1 in N)
+	LogLevel       string
+	LogSampleEvery int
+
+	// SentryDSN forwards recovered panics to Sentry when set
+	SentryDSN string
+
+	// RPC endpoints in priority order; ETH_NODE_URL is used when ETH_NODE_URLS is unset
+	EthNodeURLs       []string
+	// ArchiveNodeURLs serve state reads at blocks the primary endpoints pruned
+	ArchiveNodeURLs   []string
+	RPCMaxConcurrency int
+	RPCMaxRetries     int
+	// RPCSlowCallThreshold is how long an RPC call runs before it is logged
+	RPCSlowCallThreshold time.Duration
+
+	// ChainMode is "rpc" to read the chain through EthNodeURLs, or
+	// "simulated" to serve an in-memory chain with mock contracts instead
+	ChainMode string
+
+	// How far the head block can trail the wall clock before health is degraded
+	NodeHeadLagThreshold time.Duration
+
+	WebhookWorkers int
+
+	// Maximum number of digests generated concurrently
+	ReportMaxConcurrency int
+	ReportMinConcurrency int
+	// Analytics worker pool bounds; the pool grows from the minimum when
+	// tasks wait for a worker and shrinks back when idle
+	AnalyticsPoolMinWorkers int
+	AnalyticsPoolMaxWorkers int
+
+	// Cache priming recomputes the most requested keys before they expire,
+	// in the active UTC hours ("7-23", empty for all day), on at most a
+	// percentage of the analytics pool; a top K of 0 disables it
+	CachePrimeTopK        int
+	CachePrimeHours       string
+	CachePrimePoolPercent int
+
+	// Maximum number of strategy backtests replayed concurrently
+	BacktestMaxConcurrency int
+
+	// Largest export of a user's data, in bytes
+	UserExportMaxBytes int
+
+	// Per route group in-flight budgets and the latency SLO used for adaptive shedding
+	DataMaxInFlight      int
+	AnalyticsMaxInFlight int
+	ChatMaxInFlight      int
+	LatencySLO           time.Duration
+
+	// Chat message limits applied per connection and per user
+	ChatRateLimit services.ChatRateLimitConfig
+
+	// Maximum chat message length in characters
+	ChatMaxMessageLength int
+
+	// Maximum points per chart attached to chat answers
+	ChatChartMaxPoints int
+
+	// Ceiling on open chat WebSocket connections across users
+	ChatMaxConnections int
+
+	// How long confirmed chat actions wait, cancellable, before they are submitted
+	ActionSubmitDelay time.Duration
+
+	// Default caps, in USD, on the value of the actions a user confirms per
+	// UTC day and per action; users can lower theirs, or raise them after a
+	// delay
+	SpendingLimitDailyUSD     int
+	SpendingLimitPerActionUSD int
+
+	// Optional JSON artifact with offline-fit governance outcome model coefficients
+	GovernanceModelPath string
+
+	// Optional JSON protocol registry with the audit status, launch dates,
+	// and pools yield risk is scored from, and the weights of its factors
+	ProtocolRegistryPath string
+
+	// ERC-20 tokens reported in address summaries, as SYMBOL:0xaddress:decimals,...
+	TrackedTokens string
+	// Uniswap-format token list whose tokens are verified and never flagged
+	// as spam; empty verifies none
+	TokenListURL string
+
+	// Display names for contracts in fee breakdowns, as 0xaddress:Label,...
+	ContractLabels string
+
+	// Optional JSON bridge configuration: the bridge contracts and events
+	// transfers are classified by, and the counterpart chains whose legs
+	// they are linked to
+	BridgeConfigPath string
+
+	// Deployed project contracts whose events are watched; zero addresses are skipped
+	AnalyticsRegistryAddress string
+	ActionContractAddress    string
+
+	// Deployment manifest, a path or http(s) URL, mapping chain IDs to contract
+	// addresses by name; its addresses replace the *_ADDRESS settings
+	ContractManifest string
+
+	// ERC20Votes-style token voting power is read from; unset turns lookups off
+	GovernanceTokenAddress string
+
+	// Multicall3 contract token balance and pool reads are batched through;
+	// the zero address makes the reads one by one
+	Multicall3Address string
+
+	// SubscriptionContract tiers are read from; unset lists only the feature
+	// matrix. The matrix gives each tier's limits, as
+	// Tier:queries:actions:alerts:frequency,...
+	SubscriptionContractAddress string
+	SubscriptionFeatures        string
+
+	// Validator registry contracts staking performance is read from, as
+	// 0xaddress,...; staking answers and endpoints are off without any
+	StakingRegistries string
+	// Staking pool contracts whose events positions are tracked from, as
+	// 0xaddress,...; replayed from StakingPoolStartBlock so stakes made
+	// before startup, or outside the assistant, are found too
+	StakingPools          string
+	StakingPoolStartBlock int
+
+	// Expected chain ID of the RPC endpoints, probed at startup; 0 skips the check
+	NetworkID int64
+
+	// Receipt backfills: blocks scanned per task and tasks run at once
+	BackfillMaxBlocks      int
+	BackfillMaxConcurrency int
+
+	// Holder scans: blocks of Transfer logs replayed per token
+	HolderScanMaxBlocks int
+
+	// Approval scans: blocks of Approval logs replayed the first time an
+	// address's standing token approvals are assessed
+	ApprovalScanMaxBlocks int
+
+	// New token listings: initial liquidity in USD a new token needs to be
+	// notable
+	ListingMinLiquidityUSD int
+
+	// Watched addresses' transactions are alerted from the mempool, before
+	// they are mined, where an RPC endpoint serves pending transaction
+	// subscriptions; otherwise, or when off, they are alerted once mined
+	MempoolAlerts bool
+
+	// Days of raw time-series samples kept before they are downsampled into
+	// hourly or daily aggregates
+	DataRetentionDays int
+
+	// DEX pairs whose Swap logs make up trading histories, as 0xaddress,...;
+	// trading suggestions aren't personalized without pairs
+	DexPairs             string
+	SwapHistoryMaxBlocks int
+
+	// Symbols priced live from exchange trade streams (Binance, then Upbit as
+	// a fallback) in the quote currency; the feed is off without symbols
+	PriceFeedSymbols []string
+	PriceFeedQuote   string
+	BinanceStreamURL string
+	UpbitStreamURL   string
+	// How many median absolute deviations from the rolling median a live
+	// trade may be before it is rejected as an outlier
+	PriceOutlierMADs int
 }
 
 // WebSocket upgrader
@@ -69,14 +299,169 @@ func main() {
 		Port:        getEnvOrDefault("PORT", "8080"),
 		EthNodeURL:  getEnvOrDefault("ETH_NODE_URL", "https://mainnet.infura.io/v3/your-project-id"),
 		Environment: getEnvOrDefault("ENVIRONMENT", "development"),
+		AdminAPIKey: os.Getenv("ADMIN_API_KEY"),
+
+		HTTPRecording: os.Getenv("HTTP_RECORDING") == "true",
+
+		LogLevel:       os.Getenv("LOG_LEVEL"),
+		LogSampleEvery: getEnvIntOrDefault("LOG_SAMPLE_EVERY", 100),
+		SentryDSN:      os.Getenv("SENTRY_DSN"),
+
+		RPCMaxConcurrency:    getEnvIntOrDefault("RPC_MAX_CONCURRENCY", 32),
+		RPCMaxRetries:        getEnvIntOrDefault("RPC_MAX_RETRIES", 2),
+		RPCSlowCallThreshold: time.Duration(getEnvIntOrDefault("RPC_SLOW_CALL_MS", int(services.DefaultSlowRPCCallThreshold.Milliseconds()))) * time.Millisecond,
+		ChainMode:            getEnvOrDefault("CHAIN_MODE", ChainModeRPC),
+
+		NodeHeadLagThreshold: time.Duration(getEnvIntOrDefault("NODE_HEAD_LAG_THRESHOLD_SECONDS", int(services.DefaultHeadLagThreshold.Seconds()))) * time.Second,
+
+		WebhookWorkers: getEnvIntOrDefault("WEBHOOK_WORKERS", 4),
+
+		ReportMaxConcurrency: getEnvIntOrDefault("REPORT_MAX_CONCURRENCY", 8),
+		ReportMinConcurrency: getEnvIntOrDefault("REPORT_MIN_CONCURRENCY", 1),
+
+		AnalyticsPoolMinWorkers: getEnvIntOrDefault("ANALYTICS_WORKER_POOL_SIZE", services.DefaultAnalyticsPoolBounds.Min),
+		AnalyticsPoolMaxWorkers: getEnvIntOrDefault("ANALYTICS_WORKER_POOL_MAX", services.DefaultAnalyticsPoolBounds.Max),
+
+		CachePrimeTopK:        getEnvIntOrDefault("CACHE_PRIME_TOP_K", services.DefaultPrimeTopK),
+		CachePrimeHours:       os.Getenv("CACHE_PRIME_HOURS"),
+		CachePrimePoolPercent: getEnvIntOrDefault("CACHE_PRIME_POOL_PERCENT", int(services.DefaultPrimePoolShare*100)),
+
+		BacktestMaxConcurrency: getEnvIntOrDefault("BACKTEST_MAX_CONCURRENCY", 2),
+
+		UserExportMaxBytes: getEnvIntOrDefault("USER_EXPORT_MAX_BYTES", services.DefaultUserExportMaxBytes),
+
+		DataMaxInFlight:      getEnvIntOrDefault("DATA_MAX_IN_FLIGHT", 100),
+		AnalyticsMaxInFlight: getEnvIntOrDefault("ANALYTICS_MAX_CONCURRENT_TASKS", 50),
+		ChatMaxInFlight:      getEnvIntOrDefault("CHAT_MAX_IN_FLIGHT", 50),
+		LatencySLO:           time.Duration(getEnvIntOrDefault("LATENCY_SLO_MS", 2000)) * time.Millisecond,
+
+		ChatRateLimit: services.ChatRateLimitConfig{
+			PerMinute:     getEnvIntOrDefault("CHAT_RATE_LIMIT_PER_MINUTE", 20),
+			MuteDuration:  time.Duration(getEnvIntOrDefault("CHAT_RATE_LIMIT_MUTE_SECONDS", 60)) * time.Second,
+			MaxViolations: getEnvIntOrDefault("CHAT_RATE_LIMIT_MAX_VIOLATIONS", 3),
+		},
+		ChatMaxMessageLength: getEnvIntOrDefault("CHAT_MAX_MESSAGE_LENGTH", services.DefaultChatMaxMessageLength),
+		ChatChartMaxPoints:   getEnvIntOrDefault("CHAT_CHART_MAX_POINTS", services.DefaultChartMaxPoints),
+		ChatMaxConnections:   getEnvIntOrDefault("CHAT_MAX_CONNECTIONS", services.DefaultChatMaxConnections),
+		ActionSubmitDelay:    time.Duration(getEnvIntOrDefault("ACTION_SUBMIT_DELAY_SECONDS", int(services.DefaultActionSubmitDelay.Seconds()))) * time.Second,
+
+		SpendingLimitDailyUSD:     getEnvIntOrDefault("SPENDING_LIMIT_DAILY_USD", services.DefaultDailySpendingLimitUSD),
+		SpendingLimitPerActionUSD: getEnvIntOrDefault("SPENDING_LIMIT_PER_ACTION_USD", services.DefaultActionSpendingLimitUSD),
+
+		GovernanceModelPath:  os.Getenv("GOVERNANCE_MODEL_PATH"),
+		ProtocolRegistryPath: os.Getenv("PROTOCOL_REGISTRY_PATH"),
+
+		TrackedTokens:  os.Getenv("TRACKED_TOKENS"),
+		TokenListURL:   os.Getenv("TOKEN_LIST_URL"),
+		ContractLabels: os.Getenv("CONTRACT_LABELS"),
+
+		BridgeConfigPath: os.Getenv("BRIDGE_CONFIG_PATH"),
+
+		AnalyticsRegistryAddress: os.Getenv("ANALYTICS_REGISTRY_ADDRESS"),
+		ActionContractAddress:    os.Getenv("ACTION_CONTRACT_ADDRESS"),
+		ContractManifest:         os.Getenv("CONTRACT_MANIFEST"),
+		GovernanceTokenAddress:   os.Getenv("GOVERNANCE_TOKEN_ADDRESS"),
+		Multicall3Address:        getEnvOrDefault("MULTICALL3_ADDRESS", services.DefaultMulticall3Address),
+		NetworkID:                int64(getEnvIntOrDefault("NETWORK_ID", 0)),
+
+		SubscriptionContractAddress: os.Getenv("SUBSCRIPTION_CONTRACT_ADDRESS"),
+		SubscriptionFeatures:        getEnvOrDefault("SUBSCRIPTION_FEATURES", services.DefaultSubscriptionFeatures),
+
+		StakingRegistries:     os.Getenv("STAKING_REGISTRY_ADDRESSES"),
+		StakingPools:          os.Getenv("STAKING_POOL_ADDRESSES"),
+		StakingPoolStartBlock: getEnvIntOrDefault("STAKING_POOL_START_BLOCK", 0),
+
+		BackfillMaxBlocks:      getEnvIntOrDefault("BACKFILL_MAX_BLOCKS", services.DefaultBackfillMaxBlocks),
+		BackfillMaxConcurrency: getEnvIntOrDefault("BACKFILL_MAX_CONCURRENCY", 2),
+
+		HolderScanMaxBlocks:   getEnvIntOrDefault("HOLDER_SCAN_MAX_BLOCKS", services.DefaultHolderScanMaxBlocks),
+		ApprovalScanMaxBlocks: getEnvIntOrDefault("APPROVAL_SCAN_MAX_BLOCKS", services.DefaultApprovalScanMaxBlocks),
+
+		ListingMinLiquidityUSD: getEnvIntOrDefault("LISTING_MIN_LIQUIDITY_USD", services.DefaultListingMinLiquidityUSD),
+		MempoolAlerts:          os.Getenv("MEMPOOL_ALERTS") == "true",
+
+		DataRetentionDays: getEnvIntOrDefault("DATA_RETENTION_DAYS", services.DefaultDataRetentionDays),
+
+		DexPairs:             os.Getenv("DEX_PAIRS"),
+		SwapHistoryMaxBlocks: getEnvIntOrDefault("SWAP_HISTORY_MAX_BLOCKS", services.DefaultSwapHistoryMaxBlocks),
+
+		PriceFeedSymbols: splitList(os.Getenv("PRICE_FEED_SYMBOLS")),
+		PriceFeedQuote:   getEnvOrDefault("PRICE_FEED_QUOTE", "USDT"),
+		BinanceStreamURL: getEnvOrDefault("BINANCE_STREAM_URL", services.DefaultBinanceStreamURL),
+		UpbitStreamURL:   getEnvOrDefault("UPBIT_STREAM_URL", services.DefaultUpbitStreamURL),
+		PriceOutlierMADs: getEnvIntOrDefault("PRICE_OUTLIER_MADS", services.DefaultPriceOutlierMADs),
+	}
+
+	config.EthNodeURLs = splitList(getEnvOrDefault("ETH_NODE_URLS", config.EthNodeURL))
+	config.ArchiveNodeURLs = splitList(os.Getenv("ARCHIVE_NODE_URLS"))
+	if err := config.Validate(); err != nil {
+		logger.WithError(err).Fatal("Configuration is invalid")
 	}
 
-	// Initialize Ethereum client
-	ethClient, err := ethclient.Dial(config.EthNodeURL)
+	// Logging is adjustable at runtime from here on; the services' standard
+	// library loggers are created below, so they log through it too
+	logLevel := logger.GetLevel()
+	if config.LogLevel != "" {
+		logLevel, _ = logrus.ParseLevel(config.LogLevel)
+	}
+	logs := NewLogControl(logger, logLevel, config.LogSampleEvery)
+	log.SetOutput(logs.StdWriter())
+
+	panics := services.NewPanicGuard()
+	panics.AddReporter(panicLogger{logs: logs})
+	if config.SentryDSN != "" {
+		sentry, err := services.NewSentryReporter(config.SentryDSN, config.Environment)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to configure Sentry")
+		}
+		panics.AddReporter(sentry)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Initialize Ethereum client, or the simulated chain standing in for it
+	rpcOptions := services.DefaultFailoverOptions()
+	rpcOptions.MaxConcurrency = config.RPCMaxConcurrency
+	rpcOptions.MaxRetries = config.RPCMaxRetries
+	rpcOptions.SlowCallThreshold = config.RPCSlowCallThreshold
+	ethClient, simulated, err := dialChain(ctx, config, rpcOptions)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to connect to Ethereum client")
 	}
 	defer ethClient.Close()
+	ethClient.Start(ctx)
+	if simulated != nil {
+		logger.WithField("contracts", simulated.Contracts()).Warn("CHAIN_MODE is simulated; serving an in-memory chain with mock contracts")
+		simulated.Start(ctx)
+	}
+	if err := config.CheckChainID(ctx, ethClient); err != nil {
+		logger.WithError(err).Fatal("RPC endpoint doesn't match the configured network")
+	}
+
+	// State at blocks the primary endpoints pruned is read from the archive
+	history := services.NewHistoryRouter(ethClient)
+	if len(config.ArchiveNodeURLs) > 0 {
+		archive, err := services.DialFailoverClient(ctx, config.ArchiveNodeURLs, rpcOptions)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to connect to the archive node")
+		}
+		defer archive.Close()
+		archive.Start(ctx)
+		history.SetArchive(archive)
+	}
+	probeCtx, cancelProbe := context.WithTimeout(ctx, 30*time.Second)
+	if err := history.Detect(probeCtx); err != nil {
+		logger.WithError(err).Warn("Failed to detect how far back the RPC endpoints keep state")
+	}
+	cancelProbe()
+	var deployments *services.ContractDeployments
+	if config.ContractManifest != "" {
+		deployments, err = loadContractDeployments(ctx, config, ethClient)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load contract manifest")
+		}
+	}
 
 	// Initialize services
 	analyticsEngine, err := services.NewAnalyticsEngine(ethClient)
@@ -84,18 +469,383 @@ func main() {
 		logger.WithError(err).Fatal("Failed to initialize analytics engine")
 	}
 	defer analyticsEngine.Close()
+	analyticsEngine.SetPanicGuard(panics)
+	if err := analyticsEngine.Pool().SetBounds(services.PoolBounds{Min: config.AnalyticsPoolMinWorkers, Max: config.AnalyticsPoolMaxWorkers}); err != nil {
+		logger.WithError(err).Fatal("Failed to size analytics worker pool")
+	}
+	analyticsEngine.Pool().Start(ctx)
+
+	if config.GovernanceModelPath != "" {
+		model, err := services.LoadOutcomeModel(config.GovernanceModelPath)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load governance outcome model")
+		}
+		analyticsEngine.Governance().SetModel(model)
+	}
+	if config.ProtocolRegistryPath != "" {
+		registry, err := services.LoadProtocolRegistry(config.ProtocolRegistryPath)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load protocol registry")
+		}
+		analyticsEngine.SetProtocolRegistry(registry)
+	}
+	analyticsEngine.SetAPYChecker(services.NewAPYChecker(services.NewChainPoolRateReader(ethClient), analyticsEngine.YieldHistory()))
 
 	dataCollector := services.NewDataCollector(ethClient)
+	dataCollector.HTTPRecorder().SetEnabled(config.HTTPRecording)
+	analyticsEngine.SetPriceObserver(dataCollector)
+
+	primeHours, err := services.ParseActiveHours(config.CachePrimeHours)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid cache priming hours")
+	}
+	cachePrimer := services.NewCachePrimer(dataCollector.Cache(), analyticsEngine.Pool())
+	for namespace, loader := range dataCollector.CacheLoaders() {
+		if err := cachePrimer.Register(namespace, loader); err != nil {
+			logger.WithError(err).Fatal("Failed to register cache loader")
+		}
+	}
+	cachePrimer.SetTopK(config.CachePrimeTopK)
+	cachePrimer.SetActiveHours(primeHours)
+	cachePrimer.SetPoolShare(float64(config.CachePrimePoolPercent) / 100)
+	cachePrimer.Start(ctx)
 	chatEngine := services.NewChatEngine(ethClient, analyticsEngine, dataCollector)
+	chatEngine.SetMaxMessageLength(config.ChatMaxMessageLength)
+	chatEngine.SetMaxChartPoints(config.ChatChartMaxPoints)
+	chatEngine.SetMaxConnections(config.ChatMaxConnections)
+	dataCollector.Series().Subscribe(chatEngine.BroadcastAnomaly)
+
+	priceFeed := services.NewPriceFeed(config.PriceFeedSymbols, dataCollector.ReferencePrices(),
+		services.NewBinanceStream(config.BinanceStreamURL, config.PriceFeedQuote),
+		services.NewUpbitStream(config.UpbitStreamURL, config.PriceFeedQuote),
+	)
+	priceFeed.SetOutlierMADs(float64(config.PriceOutlierMADs))
+	priceFeed.Subscribe(chatEngine.BroadcastPrice)
+	priceAlerts := services.NewPriceAlerts(priceFeed)
+	priceAlerts.SetNotifier(chatEngine)
+	priceFeed.Subscribe(priceAlerts.Check)
+	chatEngine.SetPriceAlerts(priceAlerts)
+	dataCollector.SetPriceFeed(priceFeed)
+	priceFeed.Start(ctx)
+
+	trackedTokens, err := services.ParseTrackedTokens(config.TrackedTokens)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse tracked tokens")
+	}
+	nativeBalances := services.NewChainBalanceReader(ethClient)
+	tokenBalances := services.NewERC20BalanceReader(ethClient, trackedTokens, dataCollector)
+	pools := services.NewLiquidityPoolReader(ethClient, trackedTokens, dataCollector)
+	tokenBalances.SetLiquidityPools(pools)
+	if common.IsHexAddress(config.Multicall3Address) {
+		multicall := services.NewMulticall(ethClient, common.HexToAddress(config.Multicall3Address))
+		tokenBalances.SetMulticall(multicall)
+		pools.SetMulticall(multicall)
+	}
+	tokenMetadata := services.NewTokenMetadataService(ethClient, config.TokenListURL, config.NetworkID)
+	tokenMetadata.Start(ctx)
+	tokenBalances.SetTokenMetadata(tokenMetadata)
+	dexPairs, err := services.ParseDEXPairs(config.DexPairs)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse DEX pairs")
+	}
+	if len(dexPairs) > 0 {
+		swaps := services.NewChainSwapHistory(ethClient, pools, dataCollector, dexPairs, config.SwapHistoryMaxBlocks)
+		analyticsEngine.SetTradingProfiles(services.NewTradingProfiles(swaps))
+		depths := services.NewPoolDepthReader(pools, dexPairs)
+		analyticsEngine.SetPoolDepths(depths)
+		chatEngine.SetPoolDepths(depths)
+		quotes := services.NewPoolDepthQuoter(depths, priceFeed)
+		analyticsEngine.SetRebalancer(services.NewRebalancer(quotes, ethClient, priceFeed))
+	}
+	var bridges *services.BridgeDetector
+	if config.BridgeConfigPath != "" {
+		var counterparts []*services.FailoverClient
+		bridges, counterparts, err = loadBridges(ctx, config.BridgeConfigPath, rpcOptions)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load bridge config")
+		}
+		for _, client := range counterparts {
+			defer client.Close()
+		}
+	}
+	explainer := services.NewTxExplainer(ethClient, pools, dataCollector)
+	chatEngine.SetTxExplainer(explainer)
+	summaries := services.NewAddressSummarizer(nativeBalances, tokenBalances, dataCollector.TransactionIndex(), dataCollector)
+	chatEngine.SetAddressSummarizer(summaries)
+
+	preferences := services.NewPreferenceStore()
+	chatEngine.SetPreferenceStore(preferences)
+	feedback := services.NewChatFeedbackStore()
+	chatEngine.SetFeedbackStore(feedback)
+	transcripts := services.NewChatTranscripts()
+	chatEngine.SetTranscripts(transcripts)
+	shares := services.NewChatShares()
+
+	var votingPower *services.VotingPowerReader
+	if common.IsHexAddress(config.GovernanceTokenAddress) && common.HexToAddress(config.GovernanceTokenAddress) != (common.Address{}) {
+		votingPower = services.NewVotingPowerReader(ethClient, common.HexToAddress(config.GovernanceTokenAddress))
+		chatEngine.SetVotingPowerReader(votingPower)
+	}
+
+	contractLabels, err := services.ContractLabels(config.ContractLabels, trackedTokens)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse contract labels")
+	}
+	if bridges != nil {
+		for address, label := range bridges.Labels() {
+			if _, ok := contractLabels[address]; !ok {
+				contractLabels[address] = label
+			}
+		}
+	}
+	backfills := services.NewReceiptBackfiller(ethClient, dataCollector.TransactionIndex(), config.BackfillMaxBlocks, config.BackfillMaxConcurrency)
+	fees := services.NewFeeAnalyzer(dataCollector.TransactionIndex(), dataCollector, contractLabels, backfills)
+	if bridges != nil {
+		backfills.SetBridgeDetector(bridges)
+		explainer.SetBridgeDetector(bridges)
+		fees.SetBridgeDetector(bridges)
+	}
+	backfills.Start(ctx)
+	chatEngine.SetFeeAnalyzer(fees)
+	holders := services.NewHolderAnalyzer(ethClient, contractLabels, config.HolderScanMaxBlocks, config.BackfillMaxConcurrency)
+	holders.Start(ctx)
+	analyticsEngine.SetHolderAnalyzer(holders)
+	approvals := services.NewApprovalScanner(ethClient, trackedTokens, contractLabels, dataCollector.TransactionIndex(), dataCollector, config.ApprovalScanMaxBlocks)
+	analyticsEngine.SetApprovalScanner(approvals)
+	summaries.SetApprovalScanner(approvals)
+
+	portfolios := services.NewPortfolioTracker(nativeBalances, tokenBalances, dataCollector,
+		services.NewNativeTransferFlows(dataCollector.TransactionIndex(), dataCollector, backfills, ethClient))
+	portfolios.Start(ctx)
+	chatEngine.SetPortfolioTracker(portfolios)
+	walletLinks := services.NewWalletLinks()
+	chatEngine.SetWalletLinks(walletLinks)
+
+	congestion := services.NewCongestionTracker(ethClient)
+	congestion.Start(ctx)
+	chatEngine.SetCongestionTracker(congestion)
+	listings := services.NewListingDetector(ethClient, tokenMetadata, pools, dataCollector)
+	listings.SetMinLiquidity(float64(config.ListingMinLiquidityUSD))
+	listings.Start(ctx)
+	chatEngine.SetListingDetector(listings)
+	watchlist := services.NewWatchlist()
+	mempool := services.NewMempoolWatcher(ethClient, watchlist, chatEngine)
+	if config.MempoolAlerts {
+		mempool.SetPendingSource(ethClient)
+	}
+	mempool.Start(ctx)
+	overview := services.NewOverviewService(
+		services.NewCollectorOverviewSources(dataCollector, analyticsEngine, congestion),
+		dataCollector.Cache(),
+	)
+
+	stakingRegistries, err := services.ParseStakingRegistries(config.StakingRegistries)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse staking registries")
+	}
+	var staking *services.StakingCollector
+	if len(stakingRegistries) > 0 {
+		staking = services.NewStakingCollector(services.NewChainValidatorRegistry(ethClient, stakingRegistries))
+		staking.Start(ctx)
+		chatEngine.SetStakingCollector(staking)
+	}
+
+	usage := services.NewUsageTracker()
+	usage.Start(ctx)
+
+	retention := services.NewRetentionEngine(config.DataRetentionDays)
+	retention.Register("series", dataCollector.Series())
+	retention.Register("yield_history", analyticsEngine.YieldHistory())
+	retention.Register("replay_inputs", analyticsEngine.ReplayInputs())
+	retention.Register("congestion", congestion)
+	if staking != nil {
+		retention.Register("validator_snapshots", staking)
+	}
+	retention.Start(ctx)
+
+	tierLimits, err := services.ParseTierLimits(config.SubscriptionFeatures)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse subscription features")
+	}
+	var subscriptionReader services.SubscriptionReader
+	if common.IsHexAddress(config.SubscriptionContractAddress) && common.HexToAddress(config.SubscriptionContractAddress) != (common.Address{}) {
+		subscriptionReader = services.NewChainSubscriptionReader(ethClient, common.HexToAddress(config.SubscriptionContractAddress))
+	}
+	subscriptions := services.NewSubscriptionCatalogs(subscriptionReader, tierLimits, usage)
+
+	audit := services.NewActionAuditLog()
+	chatEngine.SetActionAudit(audit)
+
+	var registryTasks *services.RegistryTasks
+	if common.IsHexAddress(config.AnalyticsRegistryAddress) && common.HexToAddress(config.AnalyticsRegistryAddress) != (common.Address{}) {
+		registryTasks = services.NewRegistryTasks(services.NewChainRegistryTaskReader(ethClient, common.HexToAddress(config.AnalyticsRegistryAddress)))
+	}
+
+	if deployments != nil {
+		deployments.OnChange(swapContractClients(ethClient, registryTasks, subscriptions, logs.Component("contracts")))
+		go reloadContractsOnHangup(ctx, deployments, logs.Component("contracts"))
+	}
+
+	contracts := services.NewContractManager(ethClient)
+
+	stakingPools, err := services.ParseStakingPools(config.StakingPools)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse staking pools")
+	}
+	var positions *services.StakingPositions
+	if len(stakingPools) > 0 {
+		positions = services.NewStakingPositions(ethClient, services.NewChainPoolRateReader(ethClient))
+		positions.SetPendingRewardReader(services.NewChainPendingRewardReader(ethClient))
+		watchStakingPools(ctx, logs.Component("staking"), contracts, positions, stakingPools, uint64(config.StakingPoolStartBlock))
+		chatEngine.SetStakingPositions(positions)
+	}
+	watchContractEvents(ctx, logs.Component("contracts"), contracts, "AnalyticsRegistry", config.AnalyticsRegistryAddress, services.NewAnalyticsRegistryDecoder())
+	watchContractEvents(ctx, logs.Component("contracts"), contracts, "ActionContract", config.ActionContractAddress, services.NewActionContractDecoder(),
+		recordActionUsage(usage), recordActionAudit(audit))
+
+	webhooks := services.NewWebhookDispatcher(config.WebhookWorkers)
+	webhooks.Start(ctx)
+	chatEngine.SetWebhookDispatcher(webhooks)
+
+	killSwitch := services.NewKillSwitch(&services.MemoryKillSwitchStore{})
+	killSwitch.SetNotifier(chatEngine)
+	chatEngine.SetKillSwitch(killSwitch)
+
+	spending := services.NewSpendingLimiter(services.NewMemorySpendingStore(), services.SpendingLimits{
+		DailyUSD:     float64(config.SpendingLimitDailyUSD),
+		PerActionUSD: float64(config.SpendingLimitPerActionUSD),
+	}, dataCollector)
+	chatEngine.SetSpendingLimiter(spending)
+
+	actions := services.NewActionQueue(services.SimulatedActionSubmitter{}, audit, config.ActionSubmitDelay)
+	actions.SetWebhookDispatcher(webhooks)
+	actions.SetNotifier(chatEngine)
+	actions.SetKillSwitch(killSwitch)
+	executionQuality := services.NewExecutionQuality()
+	actions.SetExecutionQuality(executionQuality)
+	chatEngine.SetExecutionQuality(executionQuality)
+	actions.Start(ctx)
+	chatEngine.SetActionQueue(actions)
+
+	schedules := services.NewActionSchedules()
+	schedules.SetExecutor(chatEngine)
+	schedules.SetNotifier(chatEngine)
+	schedules.Start(ctx)
+	chatEngine.SetActionSchedules(schedules)
+
+	var signing *services.SigningService
+	if common.IsHexAddress(config.ActionContractAddress) && common.HexToAddress(config.ActionContractAddress) != (common.Address{}) {
+		chainID, err := ethClient.ChainID(ctx)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to get chain ID for wallet signing")
+		}
+		signing = services.NewSigningService(ethClient, chainID)
+		signing.SetNotifier(chatEngine)
+		signing.SetActionAudit(audit)
+		signing.SetKillSwitch(killSwitch)
+		signing.Start(ctx)
+		chatEngine.SetWalletSigning(signing, services.NewActionRequestBuilder(ethClient, common.HexToAddress(config.ActionContractAddress)))
+	}
+
+	notifications := services.NewNotificationStore()
+	reports, err := services.NewReportService(
+		services.NewCollectorDigestSources(summaries, dataCollector, ethClient, analyticsEngine.Governance()),
+		notifications,
+		webhooks,
+		config.ReportMaxConcurrency,
+	)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize report service")
+	}
+	defer reports.Close()
+	if err := reports.Pool().SetBounds(services.PoolBounds{Min: config.ReportMinConcurrency, Max: config.ReportMaxConcurrency}); err != nil {
+		logger.WithError(err).Fatal("Failed to size report worker pool")
+	}
+	reports.Pool().Start(ctx)
+	reports.Start(ctx)
+
+	backtests := services.NewBacktester(dataCollector.Series(), config.BacktestMaxConcurrency)
+	backtests.Start(ctx)
+
+	userData, err := services.NewUserDataService(config.UserExportMaxBytes)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize user data service")
+	}
+	defer userData.Close()
+	userData.Register("preferences", preferences)
+	userData.Register("notifications", notifications)
+	userData.Register("action_audit", audit)
+	userData.Register("reports", reports)
+	userData.Register("portfolio", portfolios)
+	userData.Register("webhooks", webhooks)
+	userData.Register("usage", usage)
+	userData.Register("chat_feedback", feedback)
+	userData.Register("chat_transcripts", transcripts)
+	userData.Register("chat_shares", shares)
+	userData.Register("price_alerts", priceAlerts)
+	userData.Register("wallet_links", walletLinks)
+	userData.Register("watchlist", watchlist)
+	userData.Register("spending_limits", spending)
+	userData.Register("action_schedules", schedules)
 
 	// Initialize application
 	app := &App{
 		router:          gin.New(),
 		ethClient:       ethClient,
+		history:         history,
+		rpc:             ethClient,
+		node:            services.NewNodeMonitor(ethClient, ethClient, config.NodeHeadLagThreshold),
 		logger:          logger,
+		logs:            logs,
+		panics:          panics,
 		analyticsEngine: analyticsEngine,
 		dataCollector:   dataCollector,
 		chatEngine:      chatEngine,
+		chatLimiter:     services.NewChatRateLimiter(config.ChatRateLimit),
+		authLimiter:     services.NewChatRateLimiter(services.ChatRateLimitConfig{PerMinute: signInRequestsPerMinute}),
+		webhooks:        webhooks,
+		summaries:       summaries,
+		tokenBalances:   tokenBalances,
+		fees:            fees,
+		contracts:       contracts,
+		registryTasks:   registryTasks,
+		deployments:     deployments,
+		priceFeed:       priceFeed,
+		priceAlerts:     priceAlerts,
+		usage:           usage,
+		retention:       retention,
+		congestion:      congestion,
+		audit:           audit,
+		actions:         actions,
+		schedules:       schedules,
+		signing:         signing,
+		killSwitch:      killSwitch,
+		spending:        spending,
+		walletLinks:     walletLinks,
+		sessions:        services.NewSessions(),
+		watchlist:       watchlist,
+		portfolios:      portfolios,
+		backfills:       backfills,
+		holders:         holders,
+		pools:           pools,
+		preferences:     preferences,
+		feedback:        feedback,
+		transcripts:     transcripts,
+		shares:          shares,
+		votingPower:     votingPower,
+		subscriptions:   subscriptions,
+		staking:         staking,
+		positions:       positions,
+		notifications:   notifications,
+		reports:         reports,
+		backtests:       backtests,
+		userData:        userData,
+		config:          config,
+		shedders:        newLoadShedders(config),
+		executionQuality: executionQuality,
+		cachePrimer:      cachePrimer,
+		overview:         overview,
+		listings:         listings,
 	}
 
 	// Setup middleware
@@ -123,8 +873,17 @@ func (a *App) setupMiddleware() {
 		},
 	}))
 
-	// Recovery middleware
-	a.router.Use(gin.Recovery())
+	// Request IDs, then panic recovery, so a recovered panic can name its
+	// request, then responses that can't be encoded
+	a.router.Use(requestID())
+	a.router.Use(a.recoverPanics())
+	a.router.Use(a.guardEncoding())
+
+	// Callers identified by their session token, then per-address usage
+	// accounting, and the backfills they start attributed to them
+	a.router.Use(a.authenticate())
+	a.router.Use(a.recordUsage())
+	a.router.Use(tagBackfillRequester())
 
 	// CORS middleware
 	a.router.Use(func(c *gin.Context) {
@@ -152,35 +911,152 @@ func (a *App) setupRoutes() {
 		v1.GET("/block/:number", a.getBlockByNumber)
 		v1.GET("/transaction/:hash", a.getTransactionByHash)
 		v1.GET("/address/:address/balance", a.getAddressBalance)
+		v1.GET("/address/:address/summary", a.getAddressSummary)
+		v1.GET("/address/:address/tokens", a.getAddressTokens)
+		v1.GET("/address/:address/fees", a.getAddressFees)
+		v1.GET("/address/:address/performance", a.getAddressPerformance)
+		v1.GET("/backfills/:id", a.getBackfillTask)
+		v1.DELETE("/backfills/:id", a.cancelBackfillTask)
+		v1.GET("/actions/audit", a.getActionAudit)
+		v1.POST("/actions/:id/cancel", a.cancelAction)
+		v1.GET("/actions/schedules", a.getActionSchedules)
+		v1.DELETE("/actions/schedules/:id", a.deleteActionSchedule)
+		v1.POST("/actions/schedules/:id/resume", a.resumeActionSchedule)
+		v1.GET("/signing/:id", a.getSigningRequest)
+		v1.POST("/signing/:id/submit", a.submitSigningRequest)
 		v1.GET("/network/stats", a.getNetworkStats)
+		v1.GET("/overview", a.getOverview)
 		v1.GET("/contract/:address/info", a.getContractInfo)
+		v1.GET("/contract/:address/holders", a.getTokenHolders)
+		v1.GET("/holder-tasks/:id", a.getHolderTask)
 		
 		// Analytics endpoints
-		v1.POST("/analytics/yield", a.getYieldOpportunities)
-		v1.POST("/analytics/trading-suggestions", a.getTradingSuggestions)
-		v1.POST("/analytics/portfolio", a.getPortfolioAnalysis)
-		v1.POST("/analytics/governance", a.getGovernanceSentiment)
-		v1.POST("/analytics/risk-assessment", a.getRiskAssessment)
+		analytics := v1.Group("/analytics", a.shedders["analytics"].Middleware())
+		analytics.POST("/yield", a.getYieldOpportunities)
+		analytics.POST("/trading-suggestions", a.getTradingSuggestions)
+		analytics.POST("/portfolio", a.getPortfolioAnalysis)
+		analytics.POST("/governance", a.getGovernanceSentiment)
+		analytics.POST("/risk-assessment", a.getRiskAssessment)
+		analytics.GET("/anomalies", a.getAnomalies)
+		analytics.GET("/congestion", a.getCongestion)
+		analytics.GET("/execution-quality", a.getExecutionQuality)
+		analytics.GET("/results/:hash", a.getAnalyticsResult)
+		analytics.POST("/backtest", a.startBacktest)
+		analytics.GET("/backtest/:id", a.getBacktestTask)
+
+		// Governance endpoints
+		v1.GET("/governance/proposals/:id/prediction", a.getProposalPrediction)
+		v1.GET("/governance/power/:address", a.getVotingPower)
+
+		// Subscription plans
+		v1.GET("/subscription/plans", a.getSubscriptionPlans)
+
+		// Staking validators
+		v1.GET("/staking/validators", a.getStakingValidators)
+		v1.GET("/address/:address/positions", a.getStakingPositions)
 		
 		// Data collection endpoints
-		v1.GET("/data/market", a.getMarketData)
-		v1.GET("/data/protocols", a.getProtocolData)
-		v1.GET("/data/gas", a.getGasData)
-		v1.GET("/data/blockchain", a.getBlockchainData)
-		v1.GET("/data/historical/:start/:end", a.getHistoricalData)
+		data := v1.Group("/data", a.shedders["data"].Middleware())
+		data.GET("/market", a.getMarketData)
+		data.GET("/prices/live", a.getLivePrices)
+		data.GET("/protocols", a.getProtocolData)
+		data.GET("/gas", a.getGasData)
+		data.GET("/new-tokens", a.getNewTokens)
+		data.GET("/blockchain", a.getBlockchainData)
+		data.GET("/historical/:start/:end", a.getHistoricalData)
 		
-		// Chat endpoints
-		v1.POST("/chat/message", a.processChatMessage)
+		// Chat endpoints (the WebSocket is long-lived, so it stays outside the in-flight budget)
+		chat := v1.Group("/chat", a.shedders["chat"].Middleware())
+		chat.POST("/message", a.processChatMessage)
+		chat.POST("/batch", a.processChatBatch)
+		chat.POST("/feedback", a.submitChatFeedback)
+		chat.GET("/metrics", a.getChatMetrics)
+		chat.POST("/share", a.shareChatResponse)
+		chat.DELETE("/share/:slug", a.revokeChatShare)
+		chat.GET("/export", a.exportChatTranscript)
 		v1.GET("/chat/ws", a.handleWebSocket)
-		v1.GET("/chat/metrics", a.getChatMetrics)
+		v1.GET("/shared/:slug", a.getSharedAnalysis)
 		
+		// Webhook endpoints
+		v1.POST("/webhooks", a.createWebhook)
+		v1.GET("/webhooks", a.listWebhooks)
+		v1.DELETE("/webhooks/:id", a.deleteWebhook)
+		v1.GET("/webhooks/:id/deliveries", a.getWebhookDeliveries)
+
+		// Alert rule previews
+		v1.POST("/alerts/preview", a.previewPriceAlert)
+
+		// Wallet sign-in
+		auth := v1.Group("/auth", a.limitSignIn())
+		auth.POST("/challenge", a.createSignInChallenge)
+		auth.POST("/session", a.signIn)
+		auth.DELETE("/session", a.signOut)
+
+		// User report and notification endpoints
+		user := v1.Group("/user")
+		user.GET("/preferences", a.getUserPreferences)
+		user.PUT("/preferences", a.updateUserPreferences)
+		user.GET("/reports/settings", a.getReportSettings)
+		user.PUT("/reports/settings", a.updateReportSettings)
+		user.GET("/reports/latest", a.getLatestReport)
+		user.POST("/reports/preview", a.previewReport)
+		user.GET("/reports", a.getReportHistory)
+		user.GET("/notifications", a.getNotifications)
+		user.POST("/notifications/:id/read", a.markNotificationRead)
+		user.GET("/usage", a.getUserUsage)
+		user.GET("/alerts", a.getPriceAlerts)
+		user.DELETE("/alerts/:id", a.cancelPriceAlert)
+		user.POST("/portfolio/tracking", a.enablePortfolioTracking)
+		user.DELETE("/portfolio/tracking", a.disablePortfolioTracking)
+		user.POST("/export", a.requestUserExport)
+		user.GET("/export/:id", a.getUserExport)
+		user.GET("/export/:id/download", a.downloadUserExport)
+		user.DELETE("/data", a.eraseUserData)
+		user.GET("/wallets", a.getLinkedWallets)
+		user.POST("/wallets", a.linkWallets)
+		user.DELETE("/wallets/:address", a.unlinkWallet)
+		user.GET("/watchlist", a.getWatchlist)
+		user.POST("/watchlist", a.watchAddress)
+		user.DELETE("/watchlist/:address", a.unwatchAddress)
+		user.GET("/spending-limits", a.getSpendingLimits)
+		user.PUT("/spending-limits", a.updateSpendingLimits)
+
 		// Service metrics
 		v1.GET("/metrics/analytics", a.getAnalyticsMetrics)
 		v1.GET("/metrics/data", a.getDataMetrics)
+
+		// Admin endpoints
+		admin := v1.Group("/admin", a.requireAdmin())
+		admin.GET("/flags", a.getAdminFlags)
+		admin.PUT("/flags", a.updateAdminFlags)
+		admin.GET("/actions/kill-switch", a.getKillSwitch)
+		admin.PUT("/actions/kill-switch", a.updateKillSwitch)
+		admin.PUT("/spending-limits/:address", a.overrideSpendingLimits)
+		admin.GET("/logging", a.getLogSettings)
+		admin.PUT("/logging", a.updateLogSettings)
+		admin.GET("/recordings", a.getHTTPRecordings)
+		admin.GET("/node", a.getNodeStatus)
+		admin.GET("/rpc/methods", a.getRPCMethods)
+		admin.GET("/cache", a.getCacheStats)
+		admin.DELETE("/cache/:namespace", a.clearCacheNamespace)
+		admin.GET("/usage", a.getUsage)
+		admin.GET("/usage/:address", a.getAddressUsage)
+		admin.GET("/chat/feedback", a.getChatFeedbackAccuracy)
+		admin.GET("/chat/usage", a.getChatUsage)
+		admin.GET("/registry/tasks", a.getRegistryTasks)
+		admin.POST("/replay/:result_id", a.replayAnalyticsResult)
+		admin.GET("/contracts", a.getContractDeployments)
+		admin.POST("/contracts/reload", a.reloadContractDeployments)
+		admin.GET("/user-data/erasures", a.getUserErasures)
+		admin.POST("/governance/proposals", a.ingestGovernanceProposal)
+		admin.POST("/governance/votes", a.ingestGovernanceVote)
 	}
 
 	// WebSocket endpoint
 	a.router.GET("/ws", a.handleWebSocket)
+
+	// Prometheus scrape endpoint
+	a.router.GET("/metrics", a.getPrometheusMetrics)
 }
 
 func (a *App) start(port string) {
@@ -216,6 +1092,11 @@ func (a *App) start(port string) {
 }
 
 // Analytics endpoints
+
+// getYieldOpportunities ranks yield opportunities. With history=7d or 30d each
+// opportunity includes its APY and TVL series downsampled for sparklines. With
+// an lp_position parameter naming a pool's LP token, the user's position in it
+// is valued and compared with the opportunities.
 func (a *App) getYieldOpportunities(c *gin.Context) {
 	var request struct {
 		UserAddress string                 `json:"user_address"`
@@ -227,13 +1108,80 @@ func (a *App) getYieldOpportunities(c *gin.Context) {
 		return
 	}
 
+	if history := c.Query("history"); history != "" {
+		if _, ok := services.YieldWindows[history]; !ok {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_history",
+				Message: "History must be 7d or 30d",
+			})
+			return
+		}
+		if request.Parameters == nil {
+			request.Parameters = make(map[string]interface{})
+		}
+		request.Parameters["history"] = history
+	}
+
+	pool, _ := request.Parameters["lp_position"].(string)
+	var position *services.LPPosition
+	if pool != "" {
+		var ok bool
+		if position, ok = a.lpPosition(c, pool, request.UserAddress); !ok {
+			return
+		}
+	}
+
+	rate, ok := a.displayRate(c)
+	if !ok {
+		return
+	}
+	by, sorted, ok := listSort(c, services.YieldOrdering)
+	if !ok {
+		return
+	}
+	fields, ok := responseFields[services.YieldOpportunity](c)
+	if !ok {
+		return
+	}
+
 	result, err := a.analyticsEngine.ProcessAnalyticsTask(c.Request.Context(), "yield_analysis", request.Parameters)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, result)
+	// The comparison is made in USD, before the TVLs are converted
+	opportunities, _ := result.Data.([]services.YieldOpportunity)
+	if sorted {
+		services.YieldOrdering.Sort(opportunities, by)
+	}
+	var comparison *services.YieldComparison
+	if position != nil {
+		comparison = services.CompareYield(position, opportunities)
+	}
+	if rate.Currency != "USD" {
+		converted := make([]services.YieldOpportunity, len(opportunities))
+		for i, opportunity := range opportunities {
+			converted[i] = opportunity.InCurrency(rate)
+		}
+		result.Data = converted
+		result.Conversion = &rate
+	}
+	// The selection applies to each opportunity
+	if data, ok := result.Data.([]services.YieldOpportunity); ok && !fields.All() {
+		projected := *result
+		projected.Data = fields.Project(data)
+		result = &projected
+	}
+
+	if position == nil {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	c.JSON(http.StatusOK, struct {
+		*services.AnalyticsResult
+		Comparison *services.YieldComparison `json:"comparison"`
+	}{result, comparison})
 }
 
 func (a *App) getTradingSuggestions(c *gin.Context) {
@@ -247,12 +1195,22 @@ func (a *App) getTradingSuggestions(c *gin.Context) {
 		return
 	}
 
-	result, err := a.analyticsEngine.ProcessAnalyticsTask(c.Request.Context(), "trading_suggestions", request.Parameters)
+	// Favorite tokens are listed first unless another order is asked for
+	by, sorted, ok := listSort(c, services.SuggestionOrdering)
+	if !ok {
+		return
+	}
+
+	params := a.withPreferences(c, request.UserAddress, request.Parameters)
+	result, err := a.analyticsEngine.ProcessAnalyticsTask(c.Request.Context(), "trading_suggestions", params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if suggestions, ok := result.Data.([]services.TradingSuggestion); ok && sorted {
+		services.SuggestionOrdering.Sort(suggestions, by)
+	}
 	c.JSON(http.StatusOK, result)
 }
 
@@ -267,7 +1225,8 @@ func (a *App) getPortfolioAnalysis(c *gin.Context) {
 		return
 	}
 
-	result, err := a.analyticsEngine.ProcessAnalyticsTask(c.Request.Context(), "portfolio_optimization", request.Parameters)
+	params := a.withPreferences(c, request.UserAddress, request.Parameters)
+	result, err := a.analyticsEngine.ProcessAnalyticsTask(c.Request.Context(), "portfolio_optimization", params)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -323,23 +1282,57 @@ func (a *App) getMarketData(c *gin.Context) {
 		symbols = []string{"ETH", "USDC", "DAI"}
 	}
 
-	data, err := a.dataCollector.CollectMarketData(c.Request.Context(), symbols)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	rate, ok := a.displayRate(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, data)
+	results := a.dataCollector.CollectMarketData(c.Request.Context(), symbols)
+	data, failed := results.Data(), results.Failed()
+
+	if rate.Currency != "USD" {
+		for i := range data {
+			data[i] = data[i].InCurrency(rate)
+		}
+	}
+	if len(failed) == 0 {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+	// Some symbols failed: the ones priced come with the others' statuses
+	c.JSON(http.StatusMultiStatus, gin.H{
+		"data":   data,
+		"errors": failed,
+	})
 }
 
 func (a *App) getProtocolData(c *gin.Context) {
+	rate, ok := a.displayRate(c)
+	if !ok {
+		return
+	}
+	by, _, ok := listSort(c, services.ProtocolOrdering)
+	if !ok {
+		return
+	}
+	fields, ok := responseFields[services.ProtocolData](c)
+	if !ok {
+		return
+	}
+
 	data, err := a.dataCollector.CollectProtocolData(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	services.ProtocolOrdering.Sort(data, by)
 
-	c.JSON(http.StatusOK, data)
+	if rate.Currency != "USD" {
+		for i := range data {
+			data[i] = data[i].InCurrency(rate)
+		}
+	}
+	c.JSON(http.StatusOK, fields.Project(data))
 }
 
 func (a *App) getGasData(c *gin.Context) {
@@ -381,13 +1374,32 @@ func (a *App) getHistoricalData(c *gin.Context) {
 
 // Chat endpoints
 func (a *App) processChatMessage(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.ChatFrameLimit(a.chatEngine.MaxMessageLength()))
+
 	var message services.ChatMessage
 	if err := c.ShouldBindJSON(&message); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "message_too_long",
+				Message: fmt.Sprintf("Messages can be at most %d characters", a.chatEngine.MaxMessageLength()),
+			})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	message.UserID = chatUser(c)
+	if !a.allowChatRequest(c) {
+		return
+	}
+
 	response, err := a.chatEngine.ProcessMessage(c.Request.Context(), &message)
+	if services.IsInvalidMessage(err) {
+		rejectChatMessage(c, err)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -403,17 +1415,26 @@ func (a *App) handleWebSocket(c *gin.Context) {
 		return
 	}
 	defer conn.Close()
+	conn.SetReadLimit(services.ChatFrameLimit(a.chatEngine.MaxMessageLength()))
 
 	// Register connection
-	userID := c.Query("user_id")
-	if userID == "" {
-		userID = "anonymous"
-	}
-	a.chatEngine.RegisterConnection(userID, conn)
-	defer a.chatEngine.UnregisterConnection(userID)
+	userID := chatUser(c)
+	// Every write goes through the registered connection's writer
+	connection := a.chatEngine.RegisterConnection(userID, conn)
+	defer connection.Close()
 
 	a.logger.WithField("user_id", userID).Info("WebSocket connection established")
 
+	rateKeys := []string{
+		fmt.Sprintf("conn:%d", time.Now().UnixNano()),
+		chatRateKey(c),
+	}
+	a.serveChatConnection(c.Request.Context(), connection, userID, connection.Session(), rateKeys)
+}
+
+// serveChatConnection runs the read loop of a chat WebSocket connection.
+// Frames written on it are sequenced in the delivery session.
+func (a *App) serveChatConnection(ctx context.Context, conn chatConn, userID, session string, rateKeys []string) {
 	for {
 		// Read message
 		var message services.ChatMessage
@@ -422,16 +1443,52 @@ func (a *App) handleWebSocket(c *gin.Context) {
 			a.logger.WithError(err).Info("WebSocket connection closed")
 			break
 		}
+		// Frames speak for the connection's user, whoever they name
+		message.UserID = userID
+
+		// Acks and resumes manage delivery and aren't rate limited
+		handled, keepOpen := a.handleDeliveryFrame(conn, session, &message)
+		if !keepOpen {
+			break
+		}
+		if handled {
+			continue
+		}
+
+		process, keepOpen := a.limitChatFrame(conn, session, rateKeys, &message)
+		if !keepOpen {
+			break
+		}
+		if !process {
+			continue
+		}
+
+		// Subscription frames change what is pushed to the connection
+		if message.Stream != nil {
+			if err := conn.WriteJSON(a.chatEngine.Sequence(session, a.chatEngine.HandleStreamFrame(session, *message.Stream))); err != nil {
+				a.logger.WithError(err).Error("Failed to send WebSocket response")
+				break
+			}
+			continue
+		}
 
 		// Process message
-		response, err := a.chatEngine.ProcessMessage(c.Request.Context(), &message)
+		response, err := a.chatEngine.ProcessMessage(ctx, &message)
+		if services.IsInvalidMessage(err) {
+			if err := conn.WriteJSON(a.chatEngine.Sequence(session, invalidChatResponse(&message, err))); err != nil {
+				a.logger.WithError(err).Error("Failed to send WebSocket response")
+				break
+			}
+			continue
+		}
 		if err != nil {
 			a.logger.WithError(err).Error("Failed to process chat message")
 			continue
 		}
+		a.recordChatUsage(userID)
 
 		// Send response
-		err = conn.WriteJSON(response)
+		err = conn.WriteJSON(a.chatEngine.Sequence(session, response))
 		if err != nil {
 			a.logger.WithError(err).Error("Failed to send WebSocket response")
 			break
@@ -464,16 +1521,25 @@ func (a *App) healthCheck(c *gin.Context) {
 		ethStatus = "disconnected"
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	now := time.Now()
+	response := gin.H{
 		"status": "healthy",
-		"timestamp": time.Now().Unix(),
+		"timestamp": services.NewAPITime(now),
+		"timestamp_unix": now.Unix(),
 		"ethereum": ethStatus,
 		"services": map[string]string{
 			"analytics_engine": "running",
 			"data_collector":   "running",
 			"chat_engine":      "running",
 		},
-	})
+	}
+	if err == nil && a.node != nil {
+		if lag := a.node.HeadLag(c.Request.Context()); lag.Lagging {
+			response["status"] = "degraded"
+			response["head_lag"] = lag
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (a *App) getBlockByNumber(c *gin.Context) {
@@ -496,39 +1562,14 @@ func (a *App) getBlockByNumber(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"number": block.NumberU64(),
 		"hash": block.Hash().Hex(),
-		"timestamp": block.Time(),
+		"timestamp": services.UnixAPITime(int64(block.Time())),
+		"timestamp_unix": block.Time(),
 		"transactions": len(block.Transactions()),
 		"gas_used": block.GasUsed(),
 		"gas_limit": block.GasLimit(),
 	})
 }
 
-func (a *App) getTransactionByHash(c *gin.Context) {
-	txHash := c.Param("hash")
-	
-	tx, isPending, err := a.ethClient.TransactionByHash(c.Request.Context(), common.HexToHash(txHash))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	receipt, err := a.ethClient.TransactionReceipt(c.Request.Context(), common.HexToHash(txHash))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"hash": tx.Hash().Hex(),
-		"from": receipt.From.Hex(),
-		"to": receipt.To.Hex(),
-		"value": tx.Value().String(),
-		"gas_used": receipt.GasUsed,
-		"status": receipt.Status,
-		"is_pending": isPending,
-	})
-}
-
 func (a *App) getAddressBalance(c *gin.Context) {
 	address := c.Param("address")
 	
@@ -560,11 +1601,13 @@ func (a *App) getNetworkStats(c *gin.Context) {
 		return
 	}
 
+	now := time.Now()
 	c.JSON(http.StatusOK, gin.H{
 		"latest_block": header.Number.Uint64(),
 		"gas_price": gasPrice.String(),
 		"difficulty": header.Difficulty.String(),
-		"timestamp": time.Now().Unix(),
+		"timestamp": services.NewAPITime(now),
+		"timestamp_unix": now.Unix(),
 	})
 }
 
@@ -592,4 +1635,24 @@ func getEnvOrDefault(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getPrometheusMetrics exposes service metrics in the Prometheus text format
+func (a *App) getPrometheusMetrics(c *gin.Context) {
+	var buf bytes.Buffer
+	pw := services.NewPromWriter(&buf)
+
+	a.chatEngine.WritePrometheus(pw)
+	if a.rpc != nil {
+		a.rpc.WritePrometheus(pw)
+	}
+	if a.priceFeed != nil {
+		a.priceFeed.WritePrometheus(pw)
+	}
+	if a.dataCollector != nil {
+		a.dataCollector.HTTPCache().WritePrometheus(pw)
+		a.dataCollector.Cache().WritePrometheus(pw)
+	}
+	if a.cachePrimer != nil {
+		a.cachePrimer.WritePrometheus(pw)
+	}
+	if a.retention != nil {
+		a.retention.WritePrometheus(pw)
+	}
+	if a.panics != nil {
+		a.panics.WritePrometheus(pw)
+	}
+	if a.analyticsEngine != nil {
+		a.analyticsEngine.Pool().WritePrometheus(pw)
+	}
+	if a.reports != nil {
+		a.reports.Pool().WritePrometheus(pw)
+	}
+
+	for _, name := range []string{"analytics", "chat", "data"} {
+		shedder, ok := a.shedders[name]
+		if !ok {
+			continue
+		}
+		stats := shedder.Stats()
+		labels := map[string]string{"group": name}
+		pw.Gauge("kaia_http_in_flight", "In-flight requests per route group.", float64(stats.InFlight), labels)
+		pw.Gauge("kaia_http_in_flight_limit", "Effective in-flight limit per route group.", float64(stats.EffectiveLimit), labels)
+		pw.Counter("kaia_http_shed_total", "Requests rejected by load shedding per route group.", float64(stats.Shed), labels)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
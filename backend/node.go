@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getNodeStatus reports the node's transaction pool, sync state, peers, and
+// head lag without exposing its admin APIs
+func (a *App) getNodeStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, a.node.Status(c.Request.Context()))
+}
+
+// getRPCMethods summarizes the RPC methods called over the last
+// services.RPCMethodWindow, worst first
+func (a *App) getRPCMethods(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"window_minutes": int(services.RPCMethodWindow.Minutes()),
+		"methods":        a.rpc.Methods().Summary(),
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestNodeStatusAndHealthDegradeOnHeadLag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	// A chain whose head block was produced two hours ago
+	chain := services.NewSimulatedChain(services.DefaultSimulatedChainID, time.Now().Add(-2*time.Hour))
+	client := services.NewFailoverClient([]services.NamedChainClient{{Name: "simulated", Client: chain}}, services.DefaultFailoverOptions())
+	app := &App{router: gin.New(), logger: logger, ethClient: client, rpc: client}
+	app.router.GET("/health", app.healthCheck)
+	app.router.GET("/api/v1/admin/node", app.getNodeStatus)
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+
+	app.node = services.NewNodeMonitor(client, client, 3*time.Hour)
+	var health HealthResponse
+	w := serve("/health")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "healthy", health.Status)
+	assert.Nil(t, health.HeadLag)
+
+	app.node = services.NewNodeMonitor(client, client, time.Hour)
+	w = serve("/health")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "degraded", health.Status)
+	require.NotNil(t, health.HeadLag)
+	assert.True(t, health.HeadLag.Lagging)
+	assert.Greater(t, health.HeadLag.LagSeconds, 7000.0)
+
+	var status services.NodeStatus
+	w = serve("/api/v1/admin/node")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "degraded", status.Status)
+	assert.Equal(t, services.NodeMetricUnsupported, status.TxPool.Status)
+	assert.Equal(t, services.NodeMetricUnsupported, status.Sync.Status)
+	assert.Equal(t, services.NodeMetricUnsupported, status.Peers.Status)
+}
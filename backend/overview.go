@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOverview returns the home page overview. Sections that failed or were
+// too slow are marked unavailable instead of failing the whole response.
+func (a *App) getOverview(c *gin.Context) {
+	overview, cached := a.overview.Overview(c.Request.Context())
+	if overview == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "overview_unavailable",
+			Message: "The overview was still being composed when the request ended",
+		})
+		return
+	}
+
+	if cached {
+		c.Header("X-Cache", "HIT")
+	} else {
+		c.Header("X-Cache", "MISS")
+	}
+	c.JSON(http.StatusOK, overview)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+const maxPerformanceWindow = 365 * 24 * time.Hour
+
+// getAddressPerformance reports how an address's portfolio, or with
+// scope=all_wallets the caller's linked wallets together, did over a window
+func (a *App) getAddressPerformance(c *gin.Context) {
+	addressStr := c.Param("address")
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	window, err := parseWindow(c.DefaultQuery("window", "30d"))
+	if err != nil || window <= 0 || window > maxPerformanceWindow {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_window",
+			Message: "Window must be a duration such as 30d or 12h, up to 365d",
+		})
+		return
+	}
+
+	wallets, ok := a.scopeWallets(c, common.HexToAddress(addressStr))
+	if !ok {
+		return
+	}
+	fields, ok := responseFields[services.PortfolioPerformance](c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	perf, err := a.portfolios.WalletsPerformance(ctx, wallets, time.Now().Add(-window))
+	if errors.Is(err, services.ErrNoPerformanceHistory) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "no_performance_history",
+			Message: "No portfolio snapshots yet; the owner can opt in with POST /api/v1/user/portfolio/tracking",
+		})
+		return
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to compute portfolio performance")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "performance_failed",
+			Message: "Failed to compute portfolio performance",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, fields.Project(perf))
+}
+
+// enablePortfolioTracking opts the caller in to daily portfolio snapshots
+func (a *App) enablePortfolioTracking(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	snapshot, err := a.portfolios.Enroll(ctx, common.HexToAddress(userID))
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to take initial portfolio snapshot")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "snapshot_failed",
+			Message: "Failed to value the portfolio; try again shortly",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+// disablePortfolioTracking stops the caller's daily portfolio snapshots
+func (a *App) disablePortfolioTracking(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	a.portfolios.Unenroll(common.HexToAddress(userID))
+	c.Status(http.StatusNoContent)
+}
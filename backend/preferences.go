@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getUserPreferences returns the caller's preferences, or the defaults when
+// none are saved
+func (a *App) getUserPreferences(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, a.preferences.Preferences(userID))
+}
+
+// updateUserPreferences replaces the caller's preferences. Fields left out of
+// the document take their defaults; unknown fields are rejected.
+func (a *App) updateUserPreferences(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	preferences := services.DefaultUserPreferences()
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&preferences); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	updated, err := a.preferences.Update(userID, preferences)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_preferences",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// withPreferences fills analytics parameters the request left out from the
+// preferences of the user the request is for: the user_address in the body,
+// or else the caller
+func (a *App) withPreferences(c *gin.Context, userAddress string, params map[string]interface{}) map[string]interface{} {
+	if a.preferences == nil {
+		return params
+	}
+
+	userID, ok := strings.ToLower(userAddress), common.IsHexAddress(userAddress)
+	if !ok {
+		userID, ok = callerAddress(c)
+	}
+	if !ok {
+		return params
+	}
+
+	params = a.preferences.Preferences(userID).ApplyDefaults(params)
+	if _, set := params["user_address"]; !set {
+		params["user_address"] = userID
+	}
+	return params
+}
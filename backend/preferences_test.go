@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func setupPreferencesApp(t *testing.T) *App {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	analyticsEngine, err := services.NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { analyticsEngine.Close() })
+
+	app := &App{
+		router:          gin.New(),
+		logger:          logger,
+		analyticsEngine: analyticsEngine,
+		preferences:     services.NewPreferenceStore(),
+		sessions:        services.NewSessions(),
+	}
+	app.router.Use(app.authenticate())
+	v1 := app.router.Group("/api/v1")
+	v1.GET("/user/preferences", app.getUserPreferences)
+	v1.PUT("/user/preferences", app.updateUserPreferences)
+	v1.POST("/analytics/portfolio", app.getPortfolioAnalysis)
+	return app
+}
+
+func preferencesRequest(app *App, method, path, caller, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	if caller != "" {
+		req.Header.Set("Authorization", signInAs(app, caller))
+	}
+	app.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUserPreferencesEndpoints(t *testing.T) {
+	app := setupPreferencesApp(t)
+
+	w := preferencesRequest(app, "GET", "/api/v1/user/preferences", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = preferencesRequest(app, "GET", "/api/v1/user/preferences", usageAlice, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var preferences services.UserPreferences
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &preferences))
+	assert.Equal(t, services.RiskMedium, preferences.RiskTolerance)
+	assert.Equal(t, "USD", preferences.DisplayCurrency)
+
+	w = preferencesRequest(app, "PUT", "/api/v1/user/preferences", usageAlice, `{"risk_tolerance":"low","theme":"dark"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "theme")
+
+	w = preferencesRequest(app, "PUT", "/api/v1/user/preferences", usageAlice, `{"risk_tolerance":"reckless","display_currency":"EUR"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_preferences")
+	assert.Contains(t, w.Body.String(), "display_currency")
+
+	w = preferencesRequest(app, "PUT", "/api/v1/user/preferences", usageAlice, `{"risk_tolerance":"low","display_currency":"krw","favorite_tokens":["kaia"]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &preferences))
+	assert.Equal(t, services.RiskLow, preferences.RiskTolerance)
+	assert.Equal(t, "KRW", preferences.DisplayCurrency)
+	assert.Equal(t, services.DefaultSlippage, preferences.DefaultSlippage)
+	assert.Equal(t, []string{"KAIA"}, preferences.FavoriteTokens)
+}
+
+func TestPortfolioAnalysisUsesSavedRiskTolerance(t *testing.T) {
+	app := setupPreferencesApp(t)
+
+	recommended := func(body, caller string) map[string]interface{} {
+		w := preferencesRequest(app, "POST", "/api/v1/analytics/portfolio", caller, body)
+		require.Equal(t, http.StatusOK, w.Code)
+		var result struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result.Data
+	}
+
+	body := `{"user_address":"` + usageAlice + `","parameters":{}}`
+	before := recommended(body, "")
+	assert.Equal(t, services.RiskMedium, before["risk_tolerance"])
+
+	w := preferencesRequest(app, "PUT", "/api/v1/user/preferences", usageAlice, `{"risk_tolerance":"low"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The saved tolerance applies without being passed in the request
+	after := recommended(body, "")
+	assert.Equal(t, services.RiskLow, after["risk_tolerance"])
+	assert.NotEqual(t, before["recommended_allocation"], after["recommended_allocation"])
+	assert.InDelta(t, 0.4, after["recommended_allocation"].(map[string]interface{})["USDC"], 1e-9)
+
+	// The caller's preferences apply when the body names no user
+	assert.Equal(t, services.RiskLow, recommended(`{"parameters":{}}`, usageAlice)["risk_tolerance"])
+
+	// A tolerance in the request wins over the saved one
+	explicit := recommended(`{"user_address":"`+usageAlice+`","parameters":{"risk_tolerance":"high"}}`, "")
+	assert.Equal(t, services.RiskHigh, explicit["risk_tolerance"])
+}
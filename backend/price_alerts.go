@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getPriceAlerts returns the caller's active price alerts, oldest first
+func (a *App) getPriceAlerts(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": a.priceAlerts.Alerts(userID)})
+}
+
+// cancelPriceAlert cancels one of the caller's active price alerts
+func (a *App) cancelPriceAlert(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	alert, err := a.priceAlerts.Cancel(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "alert_not_found",
+			Message: "Price alert not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
+
+// previewPriceAlert evaluates a candidate price alert rule against the stored
+// prices of the last week and returns where it would have fired
+func (a *App) previewPriceAlert(c *gin.Context) {
+	var spec services.PriceAlertSpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_rule",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	to := time.Now().UTC()
+	preview, err := services.PreviewPriceAlert(a.dataCollector.Series(), spec, to.Add(-services.PriceAlertPreviewWindow), to)
+	if errors.Is(err, services.ErrNoPriceSample) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "no_price_history",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "preview_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
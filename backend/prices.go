@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getLivePrices returns the live exchange prices, the state of each stream,
+// and the trades rejected as outliers with each source's reliability
+func (a *App) getLivePrices(c *gin.Context) {
+	if a.priceFeed == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "price_feed_disabled",
+			Message: "Live prices are not enabled",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"prices":      a.priceFeed.Prices(),
+		"sources":     a.priceFeed.Stats(),
+		"reliability": a.priceFeed.Consensus().Reliability(),
+		"rejected":    a.priceFeed.Consensus().Rejected(),
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getHTTPRecordings lists the recorded external API calls, newest first,
+// optionally of one host and since an RFC 3339 time
+func (a *App) getHTTPRecordings(c *gin.Context) {
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_since",
+				Message: "since must be an RFC 3339 time such as 2025-01-02T15:04:05Z",
+			})
+			return
+		}
+		since = parsed
+	}
+
+	recorder := a.dataCollector.HTTPRecorder()
+	recordings := recorder.Recordings(c.Query("host"), since)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":    recorder.Enabled(),
+		"recordings": recordings,
+		"total":      len(recordings),
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+const (
+	// requestIDHeader carries the request ID in both directions
+	requestIDHeader = "X-Request-ID"
+	// requestIDKey is the context key the request ID is stored under
+	requestIDKey = "request_id"
+)
+
+// validRequestID matches the caller-supplied request IDs that are kept;
+// anything else is replaced, so IDs are safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID tags each request with the caller's X-Request-ID, or a new one,
+// and echoes it in the response
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// recoverPanics turns a handler panic into a 500 with the error envelope
+// and the request ID. The stack goes to the panic reporters, never to the
+// client.
+func (a *App) recoverPanics() gin.HandlerFunc {
+	if a.panics == nil {
+		a.panics = services.NewPanicGuard()
+	}
+	return func(c *gin.Context) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				// Deliberate aborts drop the connection, as net/http does
+				panic(value)
+			}
+
+			id := c.GetString(requestIDKey)
+			a.panics.Handle("http", id, value, debug.Stack())
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{
+				Error:     "internal_error",
+				Message:   "An unexpected error occurred",
+				RequestID: id,
+			})
+		}()
+		c.Next()
+	}
+}
+
+// guardEncoding turns a response that couldn't be encoded, such as one
+// holding a NaN or infinite number, into a 500 with the error envelope. gin
+// only records the encoding error, which would send an empty 200.
+func (a *App) guardEncoding() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Written() {
+			return
+		}
+		var unsupportedValue *json.UnsupportedValueError
+		var unsupportedType *json.UnsupportedTypeError
+		for _, failure := range c.Errors {
+			if !errors.As(failure.Err, &unsupportedValue) && !errors.As(failure.Err, &unsupportedType) {
+				continue
+			}
+			id := c.GetString(requestIDKey)
+			a.logger.WithError(failure.Err).WithFields(logrus.Fields{
+				"request_id": id,
+				"path":       c.FullPath(),
+			}).Error("Failed to encode response")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorResponse{
+				Error:     "encoding_failed",
+				Message:   "The response could not be encoded",
+				RequestID: id,
+			})
+			return
+		}
+	}
+}
+
+// panicLogger writes recovered panics, with their stack, at error level
+// under the component that panicked
+type panicLogger struct {
+	logs *LogControl
+}
+
+func (pl panicLogger) ReportPanic(report services.PanicReport) {
+	pl.logs.Component(report.Component).WithFields(logrus.Fields{
+		"request_id": report.RequestID,
+		"panic":      report.Value,
+		"stack":      report.Stack,
+	}).Error("Recovered from panic")
+}
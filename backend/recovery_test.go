@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestRecoverPanicsReturnsErrorEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs, out := newTestLogControl(1)
+	panics := services.NewPanicGuard()
+	panics.AddReporter(panicLogger{logs: logs})
+	app := &App{router: gin.New(), logger: logs.logger, logs: logs, panics: panics}
+	app.router.Use(requestID())
+	app.router.Use(app.recoverPanics())
+	app.router.GET("/boom", func(c *gin.Context) {
+		var values map[string]int
+		values["boom"]++
+	})
+	app.router.GET("/fine", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	req.Header.Set(requestIDHeader, "req-123")
+	app.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "req-123", w.Header().Get(requestIDHeader))
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, ErrorResponse{Error: "internal_error", Message: "An unexpected error occurred", RequestID: "req-123"}, response)
+	assert.NotContains(t, w.Body.String(), "goroutine", "the stack isn't sent to the client")
+
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "error", lines[0]["level"])
+	assert.Equal(t, "http", lines[0]["component"])
+	assert.Equal(t, "req-123", lines[0]["request_id"])
+	assert.Contains(t, lines[0]["panic"], "assignment to entry in nil map")
+	assert.Contains(t, lines[0]["stack"], "recovery_test.go")
+	assert.Equal(t, map[string]uint64{"http": 1}, panics.Counts())
+
+	// The server keeps serving, and unusable request IDs are replaced
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/fine", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	app.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Regexp(t, `^[0-9a-f]{16}$`, w.Header().Get(requestIDHeader))
+}
+
+func TestGuardEncodingRejectsNonFiniteNumbers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs, out := newTestLogControl(1)
+	app := &App{router: gin.New(), logger: logs.logger, logs: logs}
+	app.router.Use(requestID())
+	app.router.Use(app.guardEncoding())
+	app.router.GET("/volatility", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"volatility": math.NaN()})
+	})
+	app.router.GET("/fine", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"volatility": 0.28})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/volatility", nil)
+	req.Header.Set(requestIDHeader, "req-nan")
+	app.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, ErrorResponse{Error: "encoding_failed", Message: "The response could not be encoded", RequestID: "req-nan"}, response)
+	assert.NotContains(t, w.Body.String(), "NaN")
+
+	lines := logLines(t, out)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "/volatility", lines[0]["path"])
+	assert.Contains(t, lines[0]["error"], "unsupported value: NaN")
+
+	w = httptest.NewRecorder()
+	app.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fine", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"volatility":0.28}`, w.Body.String())
+}
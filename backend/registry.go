@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getRegistryTasks lists the AnalyticsRegistry's tasks, newest first,
+// optionally only those with a status
+func (a *App) getRegistryTasks(c *gin.Context) {
+	if a.registryTasks == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "registry_not_configured",
+			Message: "Registry tasks need an AnalyticsRegistry address",
+		})
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && !services.ValidRegistryTaskStatus(status) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_status",
+			Message: "Status must be pending or completed",
+		})
+		return
+	}
+
+	limit, offset, ok := parsePagination(c, 50, 500)
+	if !ok {
+		return
+	}
+
+	tasks, total, err := a.registryTasks.List(c.Request.Context(), status, limit, offset)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to read registry tasks")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "registry_unavailable",
+			Message: "Failed to read tasks from the AnalyticsRegistry",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": status,
+		"tasks":  tasks,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// getAnalyticsResult returns a computed analytics result by the hash of its
+// canonical JSON, rehashing it to verify that it is the result the hash names
+func (a *App) getAnalyticsResult(c *gin.Context) {
+	result, ok := a.analyticsEngine.Results().Get(c.Param("hash"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "result_not_found",
+			Message: "No analytics result with that hash is stored",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// replayAnalyticsResult recomputes an analytics result from the inputs
+// recorded when it was computed and returns the fields that differ
+func (a *App) replayAnalyticsResult(c *gin.Context) {
+	replay, err := a.analyticsEngine.Replay(c.Request.Context(), c.Param("result_id"))
+	switch {
+	case errors.Is(err, services.ErrReplayNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "result_not_found",
+			Message: "No recorded inputs for that result",
+		})
+		return
+	case errors.Is(err, services.ErrReplayInputMissing):
+		c.JSON(http.StatusGone, ErrorResponse{
+			Error:   "inputs_expired",
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "replay_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, replay)
+}
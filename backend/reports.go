@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getReportSettings returns the caller's digest settings
+func (a *App) getReportSettings(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	settings, found := a.reports.Settings(userID)
+	if !found {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "settings_not_found",
+			Message: "No report settings configured",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// updateReportSettings stores the caller's digest schedule and preferences
+func (a *App) updateReportSettings(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var settings services.ReportSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	updated, err := a.reports.UpdateSettings(userID, settings)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_settings",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// getLatestReport returns the caller's most recent digest
+func (a *App) getLatestReport(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	report, found := a.reports.Latest(userID)
+	if !found {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "report_not_found",
+			Message: "No reports have been generated yet",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// getReportHistory returns a page of the caller's digests, newest first
+func (a *App) getReportHistory(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := parsePagination(c, 10, 90)
+	if !ok {
+		return
+	}
+
+	reports, total := a.reports.History(userID, limit, offset)
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// previewReport renders the caller's digest as it would be sent now, without
+// storing or delivering it. A body with report settings previews them in
+// place of the stored settings.
+func (a *App) previewReport(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var candidate *services.ReportSettings
+	var settings services.ReportSettings
+	switch err := c.ShouldBindJSON(&settings); {
+	case errors.Is(err, io.EOF):
+	case err != nil:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	default:
+		candidate = &settings
+	}
+
+	report, err := a.reports.Preview(c.Request.Context(), userID, candidate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_settings",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// getNotifications returns the caller's notifications, newest first
+func (a *App) getNotifications(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": a.notifications.List(userID, c.Query("unread") == "true"),
+	})
+}
+
+// markNotificationRead marks one of the caller's notifications as read
+func (a *App) markNotificationRead(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	if !a.notifications.MarkRead(userID, c.Param("id")) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "notification_not_found",
+			Message: "Notification not found",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action lifecycle events recorded in the audit log
+const (
+	ActionEventProposed  = "proposed"
+	ActionEventConfirmed = "confirmed"
+	ActionEventSubmitted = "submitted"
+	ActionEventMined     = "mined"
+	ActionEventFailed    = "failed"
+	ActionEventCancelled = "cancelled"
+	// ActionEventFinalized is recorded once a mined transaction is deep
+	// enough that it won't be reorganized out
+	ActionEventFinalized = "finalized"
+	// ActionEventReorged is recorded when a mined transaction leaves the
+	// canonical chain
+	ActionEventReorged = "reorged"
+	// ActionEventExecuted is recorded when a mined swap's output has been
+	// compared with its simulation
+	ActionEventExecuted = "executed"
+)
+
+// ActionAuditRecord is one lifecycle event of an action executed for a user
+type ActionAuditRecord struct {
+	Seq        uint64    `json:"seq"`
+	ActionID   string    `json:"action_id"`
+	UserID     string    `json:"user_id"`
+	MessageID  string    `json:"message_id,omitempty"`
+	Event      string    `json:"event"`
+	ActionType string    `json:"action_type"`
+	TxHash     string    `json:"tx_hash,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+
+	// SlippagePct is the realized slippage of an executed swap
+	SlippagePct *float64 `json:"slippage_pct,omitempty"`
+}
+
+// actionAuditCSVHeader is the header row of CSV exports
+var actionAuditCSVHeader = []string{"seq", "timestamp", "action_id", "event", "action_type", "message_id", "tx_hash", "reason"}
+
+// ActionAuditLog is an append-only record of the action lifecycle events of
+// every user. Records can't be changed once appended, and are only removed
+// when their user erases their data.
+type ActionAuditLog struct {
+	mu      sync.RWMutex
+	records map[string][]ActionAuditRecord
+	nextSeq uint64
+	now     func() time.Time
+}
+
+// NewActionAuditLog creates an empty audit log
+func NewActionAuditLog() *ActionAuditLog {
+	return &ActionAuditLog{
+		records: make(map[string][]ActionAuditRecord),
+		now:     utcNow,
+	}
+}
+
+// Append records a lifecycle event, assigning its sequence number and, when
+// unset, its timestamp
+func (al *ActionAuditLog) Append(record ActionAuditRecord) ActionAuditRecord {
+	record.UserID = strings.ToLower(record.UserID)
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.nextSeq++
+	record.Seq = al.nextSeq
+	if record.Timestamp.IsZero() {
+		record.Timestamp = al.now()
+	}
+	// Keep each user's records in timestamp order; ties keep append order
+	records := al.records[record.UserID]
+	at := sort.Search(len(records), func(i int) bool { return records[i].Timestamp.After(record.Timestamp) })
+	if at == len(records) {
+		al.records[record.UserID] = append(records, record)
+	} else {
+		// Inserting would move records a reader may be iterating, so copy
+		updated := make([]ActionAuditRecord, 0, len(records)+1)
+		updated = append(updated, records[:at]...)
+		updated = append(updated, record)
+		al.records[record.UserID] = append(updated, records[at:]...)
+	}
+	return record
+}
+
+// Scan calls fn with a user's records timestamped in [from, to], oldest
+// first, stopping at the first error. A zero from or to leaves that end open.
+// The lock isn't held while fn runs, so slow writers don't block appends.
+func (al *ActionAuditLog) Scan(userID string, from, to time.Time, fn func(ActionAuditRecord) error) error {
+	al.mu.RLock()
+	records := al.records[strings.ToLower(userID)]
+	al.mu.RUnlock()
+
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(records), func(i int) bool { return !records[i].Timestamp.Before(from) })
+	}
+	for _, record := range records[start:] {
+		if !to.IsZero() && record.Timestamp.After(to) {
+			break
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records returns a user's records timestamped in [from, to], oldest first
+func (al *ActionAuditLog) Records(userID string, from, to time.Time) []ActionAuditRecord {
+	records := make([]ActionAuditRecord, 0)
+	al.Scan(userID, from, to, func(record ActionAuditRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records
+}
+
+// UserData returns every record of the user, oldest first
+func (al *ActionAuditLog) UserData(userID string) interface{} {
+	return al.Records(userID, time.Time{}, time.Time{})
+}
+
+// EraseUserData removes every record of the user and returns how many were
+// removed. It is the only way records leave the log.
+func (al *ActionAuditLog) EraseUserData(userID string) int {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	userID = strings.ToLower(userID)
+	removed := len(al.records[userID])
+	delete(al.records, userID)
+	return removed
+}
+
+// WriteCSV streams a user's records timestamped in [from, to] as CSV,
+// flushing every flushEvery rows so large exports reach the client as they
+// are written
+func (al *ActionAuditLog) WriteCSV(w io.Writer, userID string, from, to time.Time, flushEvery int) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(actionAuditCSVHeader); err != nil {
+		return err
+	}
+
+	rows := 0
+	err := al.Scan(userID, from, to, func(record ActionAuditRecord) error {
+		err := writer.Write([]string{
+			strconv.FormatUint(record.Seq, 10),
+			record.Timestamp.UTC().Format(time.RFC3339Nano),
+			csvCell(record.ActionID),
+			record.Event,
+			csvCell(record.ActionType),
+			csvCell(record.MessageID),
+			record.TxHash,
+			csvCell(record.Reason),
+		})
+		if err != nil {
+			return err
+		}
+		rows++
+		if flushEvery > 0 && rows%flushEvery == 0 {
+			return flushCSV(writer, w)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flushCSV(writer, w)
+}
+
+// flushCSV flushes buffered rows, and the underlying writer when it buffers
+// too, such as an HTTP response
+func flushCSV(writer *csv.Writer, w io.Writer) error {
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if flusher, ok := w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// csvCell keeps spreadsheets from evaluating a value as a formula
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatActionLifecycleIsAudited(t *testing.T) {
+	engine := newTestChatEngine(t)
+	audit := NewActionAuditLog()
+	engine.SetActionAudit(audit)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+		ID:      "msg_42",
+		UserID:  summaryAddress.Hex(),
+		Message: "Stake 10 ETH",
+	})
+	require.NoError(t, err)
+	action := response.Data.(*ActionRequest)
+
+	records := audit.Records(summaryAddress.Hex(), time.Time{}, time.Time{})
+	require.Len(t, records, 4)
+	events := make([]string, len(records))
+	for i, record := range records {
+		events[i] = record.Event
+		assert.Equal(t, action.ID, record.ActionID)
+		assert.Equal(t, "msg_42", record.MessageID)
+		assert.Equal(t, "stake", record.ActionType)
+		if i > 0 {
+			assert.Greater(t, record.Seq, records[i-1].Seq)
+			assert.False(t, record.Timestamp.Before(records[i-1].Timestamp))
+		}
+	}
+	assert.Equal(t, []string{ActionEventProposed, ActionEventConfirmed, ActionEventSubmitted, ActionEventMined}, events)
+	assert.Empty(t, records[1].TxHash)
+	assert.Equal(t, "0x1234567890abcdef...", records[3].TxHash)
+
+	// Other users see nothing
+	assert.Empty(t, audit.Records("0x00000000000000000000000000000000000000bb", time.Time{}, time.Time{}))
+
+	// Returned records are copies
+	records[0].Event = ActionEventFailed
+	assert.Equal(t, ActionEventProposed, audit.Records(summaryAddress.Hex(), time.Time{}, time.Time{})[0].Event)
+}
+
+func TestActionAuditOrderingAndCSV(t *testing.T) {
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	audit := NewActionAuditLog()
+	audit.now = func() time.Time { return now }
+	user := "0x00000000000000000000000000000000000000AA"
+
+	audit.Append(ActionAuditRecord{ActionID: "a1", UserID: user, MessageID: "m1", Event: ActionEventProposed, ActionType: "swap"})
+	audit.Append(ActionAuditRecord{ActionID: "a1", UserID: user, Event: ActionEventSubmitted, ActionType: "swap", TxHash: "0xabc",
+		Timestamp: now.Add(time.Minute)})
+	// A failure reported by a slower source is ordered by when it happened
+	audit.Append(ActionAuditRecord{ActionID: "a1", UserID: user, Event: ActionEventFailed, ActionType: "swap", TxHash: "0xabc",
+		Reason: "=HYPERLINK(\"x\"), slippage", Timestamp: now.Add(3 * time.Minute)})
+	audit.Append(ActionAuditRecord{ActionID: "a2", UserID: user, Event: ActionEventProposed, ActionType: "vote",
+		Timestamp: now.Add(2 * time.Minute)})
+
+	records := audit.Records(user, now.Add(time.Minute), now.Add(2*time.Minute))
+	require.Len(t, records, 2)
+	assert.Equal(t, ActionEventSubmitted, records[0].Event)
+	assert.Equal(t, "a2", records[1].ActionID)
+
+	var out bytes.Buffer
+	require.NoError(t, audit.WriteCSV(&out, user, time.Time{}, time.Time{}, 1))
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"seq", "timestamp", "action_id", "event", "action_type", "message_id", "tx_hash", "reason"},
+		{"1", "2025-05-01T12:00:00Z", "a1", "proposed", "swap", "m1", "", ""},
+		{"2", "2025-05-01T12:01:00Z", "a1", "submitted", "swap", "", "0xabc", ""},
+		{"4", "2025-05-01T12:02:00Z", "a2", "proposed", "vote", "", "", ""},
+		{"3", "2025-05-01T12:03:00Z", "a1", "failed", "swap", "", "0xabc", "'=HYPERLINK(\"x\"), slippage"},
+	}, rows)
+}
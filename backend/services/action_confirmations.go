@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ActionChain is what the action queue reads to follow a broadcast
+// transaction until it is final. ChainClient satisfies it.
+type ActionChain interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// ActionRebroadcaster is implemented by submitters that can send an action's
+// transaction again after a reorg dropped it. Returns the hash it was sent in.
+type ActionRebroadcaster interface {
+	Rebroadcast(ctx context.Context, submission ActionSubmission) (string, error)
+}
+
+// SetConfirmationTracking keeps mined actions confirming until their
+// transaction is depth blocks deep, counting its own block, or with a depth
+// of 0 until the chain's finalized block has passed it. Actions whose
+// transaction is reorganized out go back to pending and are rebroadcast.
+func (q *ActionQueue) SetConfirmationTracking(chain ActionChain, depth uint64) {
+	q.chain = chain
+	q.depth = depth
+}
+
+// SetExecutionQuality records the realized slippage of mined swaps. Swaps are
+// measured from the receipts read by confirmation tracking.
+func (q *ActionQueue) SetExecutionQuality(execution *ExecutionQuality) {
+	q.execution = execution
+}
+
+// SetNotifier pushes every status change to the action owner's chat connections
+func (q *ActionQueue) SetNotifier(notifier SigningNotifier) {
+	q.notifier = notifier
+}
+
+// trackedTx is a broadcast action's transaction as last seen on chain
+type trackedTx struct {
+	queued     *queuedAction
+	submission ActionSubmission
+	status     string
+}
+
+// TrackConfirmations checks the transactions of the submitted and confirming
+// actions against the chain and returns how many actions changed status
+func (q *ActionQueue) TrackConfirmations(ctx context.Context) int {
+	if q.chain == nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	var tracked []trackedTx
+	for _, queued := range q.actions {
+		status := queued.action.Status
+		if queued.submission != nil && (status == ActionStatusSubmitted || status == ActionStatusConfirming) {
+			tracked = append(tracked, trackedTx{queued: queued, submission: *queued.submission, status: status})
+		}
+	}
+	q.mu.Unlock()
+	if len(tracked) == 0 {
+		return 0
+	}
+
+	head, err := q.chain.HeaderByNumber(ctx, nil)
+	if err != nil {
+		q.logger.Printf("Failed to read the head block: %v", err)
+		return 0
+	}
+	final := head.Number.Uint64()
+	if q.depth == 0 {
+		finalized, err := q.chain.HeaderByNumber(ctx, big.NewInt(int64(rpc.FinalizedBlockNumber)))
+		if err != nil {
+			q.logger.Printf("Failed to read the finalized block: %v", err)
+			return 0
+		}
+		final = finalized.Number.Uint64()
+	}
+
+	changed := 0
+	for _, tx := range tracked {
+		receipt, mined, err := q.canonicalReceipt(ctx, tx.submission.TxHash)
+		if err != nil {
+			q.logger.Printf("Failed to check transaction %s: %v", tx.submission.TxHash, err)
+			continue
+		}
+		if q.confirm(ctx, tx, head.Number.Uint64(), final, receipt, mined) {
+			changed++
+		}
+	}
+	return changed
+}
+
+// canonicalReceipt returns the receipt of a mined transaction, if its block
+// is still part of the canonical chain
+func (q *ActionQueue) canonicalReceipt(ctx context.Context, txHash string) (*types.Receipt, bool, error) {
+	receipt, err := q.chain.TransactionReceipt(ctx, common.HexToHash(txHash))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	header, err := q.chain.HeaderByNumber(ctx, receipt.BlockNumber)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// A node can serve the receipt of a block it has just reorganized away
+	if header.Hash() != receipt.BlockHash {
+		return nil, false, nil
+	}
+	return receipt, true, nil
+}
+
+// confirm applies what the chain shows of one transaction and reports
+// whether the action's status changed
+func (q *ActionQueue) confirm(ctx context.Context, tx trackedTx, head, final uint64, receipt *types.Receipt, mined bool) bool {
+	q.mu.Lock()
+	queued := tx.queued
+	action := queued.action
+	if action.Status != tx.status || queued.submission == nil || queued.submission.TxHash != tx.submission.TxHash {
+		// Cancelled or replaced while the chain was read
+		q.mu.Unlock()
+		return false
+	}
+
+	if !mined {
+		if action.Status != ActionStatusConfirming {
+			q.mu.Unlock()
+			return false
+		}
+		action.Status = ActionStatusPending
+		action.BlockNumber = 0
+		action.Confirmations = 0
+		q.record(queued, ActionEventReorged, tx.submission.TxHash, "transaction left the canonical chain")
+		reorged := *action
+		q.mu.Unlock()
+
+		q.logger.Printf("Transaction %s of action %s was reorganized out", tx.submission.TxHash, action.ID)
+		q.publish(reorged)
+		q.rebroadcast(ctx, queued, tx.submission)
+		return true
+	}
+
+	changed := false
+	if action.Status == ActionStatusSubmitted {
+		action.Status = ActionStatusConfirming
+		q.record(queued, ActionEventMined, tx.submission.TxHash, "")
+		changed = true
+	}
+	// A swap is measured against its simulation once, from its first receipt
+	var execution *SwapExecution
+	if !queued.measured {
+		queued.measured = true
+		if execution = MeasureSwap(action, receipt, q.now()); execution != nil {
+			action.Execution = execution
+			q.recordExecution(queued, execution)
+		}
+	}
+	block := receipt.BlockNumber.Uint64()
+	previous := action.Confirmations
+	action.BlockNumber = block
+	action.Confirmations = 0
+	if head >= block {
+		action.Confirmations = head - block + 1
+	}
+	if (q.depth > 0 && action.Confirmations >= q.depth) || (q.depth == 0 && final >= block) {
+		action.Status = ActionStatusCompleted
+		q.record(queued, ActionEventFinalized, tx.submission.TxHash, fmt.Sprintf("%d confirmations", action.Confirmations))
+		changed = true
+	}
+	updated := *action
+	q.mu.Unlock()
+
+	if execution != nil && q.execution != nil {
+		q.execution.Record(*execution)
+	}
+	// Confirmation counts are pushed as they grow, not only on transitions
+	if changed || updated.Confirmations != previous {
+		q.publish(updated)
+	}
+	return changed
+}
+
+// rebroadcast sends a reorganized action's transaction again. The action
+// fails when the submitter can't.
+func (q *ActionQueue) rebroadcast(ctx context.Context, queued *queuedAction, submission ActionSubmission) {
+	var txHash string
+	err := errors.New("submitter can't rebroadcast transactions")
+	if rebroadcaster, ok := q.submitter.(ActionRebroadcaster); ok {
+		txHash, err = rebroadcaster.Rebroadcast(ctx, submission)
+	}
+
+	q.mu.Lock()
+	action := queued.action
+	if err != nil {
+		action.Status = ActionStatusFailed
+		action.Error = fmt.Sprintf("failed to rebroadcast after a reorg: %v", err)
+		q.record(queued, ActionEventFailed, submission.TxHash, action.Error)
+		failed := *action
+		q.mu.Unlock()
+		q.logger.Printf("Failed to rebroadcast action %s: %v", action.ID, err)
+		q.publish(failed)
+		return
+	}
+
+	resubmitted := submission
+	resubmitted.TxHash = txHash
+	resubmitted.Mined = false
+	queued.submission = &resubmitted
+	action.Status = ActionStatusSubmitted
+	if previous, ok := action.Result.(map[string]interface{}); ok {
+		// Copies of the action handed out earlier share the old map
+		result := make(map[string]interface{}, len(previous))
+		for key, value := range previous {
+			result[key] = value
+		}
+		result["tx_hash"] = txHash
+		action.Result = result
+	}
+	q.record(queued, ActionEventSubmitted, txHash, "rebroadcast after a reorg")
+	submitted := *action
+	q.mu.Unlock()
+
+	q.publish(submitted)
+}
+
+// publish pushes an action's status to its owner's chat connections and,
+// once it completes, to their webhooks
+func (q *ActionQueue) publish(action ActionRequest) {
+	if q.notifier != nil {
+		now := q.now()
+		err := q.notifier.SendToUser(action.UserID, &ChatResponse{
+			ID:            fmt.Sprintf("action_status_%s_%d", action.ID, now.UnixNano()),
+			Type:          "action_status",
+			Response:      actionStatusText(action),
+			Data:          &action,
+			Success:       action.Status != ActionStatusFailed,
+			Timestamp:     NewAPITime(now),
+			TimestampUnix: now.Unix(),
+		})
+		if err != nil {
+			q.logger.Printf("Failed to push status of action %s to %s: %v", action.ID, action.UserID, err)
+		}
+	}
+
+	if action.Status == ActionStatusCompleted && q.webhooks != nil {
+		err := q.webhooks.Dispatch(WebhookEvent{
+			Type:    "action.completed",
+			Owner:   action.UserID,
+			Payload: &action,
+		})
+		if err != nil {
+			q.logger.Printf("Failed to dispatch action webhook: %v", err)
+		}
+	}
+}
+
+// actionStatusText describes an action's status for the chat
+func actionStatusText(action ActionRequest) string {
+	switch action.Status {
+	case ActionStatusSubmitted:
+		return fmt.Sprintf("📤 Your %s action was broadcast.", action.ActionType)
+	case ActionStatusConfirming:
+		return fmt.Sprintf("⏳ Your %s action has %d confirmations.", action.ActionType, action.Confirmations)
+	case ActionStatusCompleted:
+		return fmt.Sprintf("✅ Your %s action is complete.", action.ActionType)
+	case ActionStatusPending:
+		return fmt.Sprintf("🔄 Your %s action was dropped by a chain reorganization and is being sent again.", action.ActionType)
+	case ActionStatusFailed:
+		return fmt.Sprintf("❌ Your %s action failed: %s", action.ActionType, action.Error)
+	default:
+		return fmt.Sprintf("Your %s action is %s.", action.ActionType, action.Status)
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reorgChain adds blocks to a fakeMempool. Reorganizing drops the newest
+// blocks, forgets their transactions as a node switching forks would, and
+// builds the replacement blocks on a new fork so their hashes differ.
+type reorgChain struct {
+	*fakeMempool
+	headers   []*types.Header
+	blockTxs  map[uint64][]*types.Transaction
+	finalized uint64
+	fork      byte
+}
+
+func newReorgChain() *reorgChain {
+	return &reorgChain{
+		fakeMempool: newFakeMempool(),
+		headers:     []*types.Header{{Number: big.NewInt(0)}},
+		blockTxs:    make(map[uint64][]*types.Transaction),
+	}
+}
+
+func (c *reorgChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case number == nil:
+		return c.headers[len(c.headers)-1], nil
+	case number.Int64() == int64(rpc.FinalizedBlockNumber):
+		return c.headers[c.finalized], nil
+	case number.Uint64() < uint64(len(c.headers)):
+		return c.headers[number.Uint64()], nil
+	}
+	return nil, ethereum.NotFound
+}
+
+// mineBlock adds a block with the account's pool transactions that are next
+// in nonce order
+func (c *reorgChain) mineBlock(account common.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parent := c.headers[len(c.headers)-1]
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, big.NewInt(1)),
+		Extra:      []byte{c.fork},
+	}
+	c.headers = append(c.headers, header)
+	number := header.Number.Uint64()
+	for {
+		tx := c.pool[account][c.mined[account]]
+		if tx == nil {
+			return
+		}
+		delete(c.pool[account], c.mined[account])
+		c.receipts[tx.Hash()] = &types.Receipt{
+			TxHash:      tx.Hash(),
+			Status:      types.ReceiptStatusSuccessful,
+			BlockNumber: header.Number,
+			BlockHash:   header.Hash(),
+		}
+		c.blockTxs[number] = append(c.blockTxs[number], tx)
+		c.mined[account]++
+	}
+}
+
+// reorg drops the newest blocks
+func (c *reorgChain) reorg(account common.Address, depth int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := 0; i < depth; i++ {
+		number := uint64(len(c.headers) - 1)
+		for _, tx := range c.blockTxs[number] {
+			delete(c.receipts, tx.Hash())
+			c.mined[account]--
+		}
+		delete(c.blockTxs, number)
+		c.headers = c.headers[:number]
+	}
+	c.fork++
+}
+
+// recordingNotifier keeps the frames pushed to each user
+type recordingNotifier struct {
+	mu     sync.Mutex
+	frames []*ChatResponse
+}
+
+func (n *recordingNotifier) SendToUser(userID string, message *ChatResponse) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.frames = append(n.frames, message)
+	return nil
+}
+
+func (n *recordingNotifier) statuses() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var statuses []string
+	for _, frame := range n.frames {
+		statuses = append(statuses, frame.Data.(*ActionRequest).Status)
+	}
+	return statuses
+}
+
+func TestActionQueueRebroadcastsAfterReorg(t *testing.T) {
+	chain := newReorgChain()
+	from, signer := newRelayer(t)
+	manager := NewNonceManager(chain, NonceManagerOptions{})
+	submitter := &relayerSubmitter{manager: manager, from: from, signer: signer}
+	queue, audit, clock := newTestActionQueue(submitter)
+	queue.SetConfirmationTracking(chain, 3)
+	notifier := &recordingNotifier{}
+	queue.SetNotifier(notifier)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: user, ActionType: "swap"}, "msg_1")
+	clock.Advance(DefaultActionSubmitDelay)
+	require.Equal(t, 1, queue.SubmitDue(ctx))
+	broadcast, _ := queue.Get("action_1")
+	txHash := broadcast.Result.(map[string]interface{})["tx_hash"].(string)
+
+	// Nothing changes until the transaction is mined
+	assert.Equal(t, 0, queue.TrackConfirmations(ctx))
+	chain.mineBlock(from)
+	chain.mineBlock(from)
+	assert.Equal(t, 1, queue.TrackConfirmations(ctx))
+	action, _ := queue.Get("action_1")
+	assert.Equal(t, ActionStatusConfirming, action.Status)
+	assert.Equal(t, uint64(1), action.BlockNumber)
+	assert.Equal(t, uint64(2), action.Confirmations)
+	// The manager sees the receipt and stops tracking the transaction
+	assert.Empty(t, manager.ReplaceStuck(ctx))
+	assert.Empty(t, manager.InFlight(from))
+
+	// A two block reorg drops the transaction, which is sent again unchanged
+	chain.reorg(from, 2)
+	chain.mineBlock(from)
+	chain.mineBlock(from)
+	assert.Equal(t, 1, queue.TrackConfirmations(ctx))
+	action, _ = queue.Get("action_1")
+	assert.Equal(t, ActionStatusSubmitted, action.Status)
+	assert.Zero(t, action.Confirmations)
+	chain.mu.Lock()
+	pooled := chain.pool[from][0]
+	chain.mu.Unlock()
+	require.NotNil(t, pooled)
+	assert.Equal(t, txHash, pooled.Hash().Hex())
+	assert.Len(t, manager.InFlight(from), 1)
+
+	// It confirms again on the new fork
+	chain.mineBlock(from)
+	assert.Equal(t, 1, queue.TrackConfirmations(ctx))
+	action, _ = queue.Get("action_1")
+	assert.Equal(t, ActionStatusConfirming, action.Status)
+	assert.Equal(t, uint64(3), action.BlockNumber)
+	chain.mineBlock(from)
+	assert.Equal(t, 0, queue.TrackConfirmations(ctx))
+	chain.mineBlock(from)
+	assert.Equal(t, 1, queue.TrackConfirmations(ctx))
+	action, _ = queue.Get("action_1")
+	assert.Equal(t, ActionStatusCompleted, action.Status)
+	assert.Equal(t, uint64(3), action.Confirmations)
+
+	assert.Equal(t, []string{
+		ActionEventSubmitted, ActionEventMined, ActionEventReorged, ActionEventSubmitted,
+		ActionEventMined, ActionEventFinalized,
+	}, auditEvents(audit, user))
+	records := audit.Records(user, time.Time{}, time.Time{})
+	assert.Equal(t, "rebroadcast after a reorg", records[3].Reason)
+	assert.Equal(t, txHash, records[3].TxHash)
+	assert.Equal(t, []string{
+		ActionStatusSubmitted, ActionStatusConfirming, ActionStatusPending, ActionStatusSubmitted,
+		ActionStatusConfirming, ActionStatusConfirming, ActionStatusCompleted,
+	}, notifier.statuses())
+
+	// Completed actions are no longer tracked
+	chain.reorg(from, 3)
+	assert.Equal(t, 0, queue.TrackConfirmations(ctx))
+}
+
+func TestActionQueueCompletesAtFinalizedBlock(t *testing.T) {
+	chain := newReorgChain()
+	from, signer := newRelayer(t)
+	submitter := &relayerSubmitter{manager: NewNonceManager(chain, NonceManagerOptions{}), from: from, signer: signer}
+	queue, _, clock := newTestActionQueue(submitter)
+	queue.SetConfirmationTracking(chain, 0)
+	ctx := context.Background()
+
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: summaryAddress.Hex(), ActionType: "stake"}, "msg_1")
+	clock.Advance(DefaultActionSubmitDelay)
+	require.Equal(t, 1, queue.SubmitDue(ctx))
+	for i := 0; i < 10; i++ {
+		chain.mineBlock(from)
+	}
+	queue.TrackConfirmations(ctx)
+	action, _ := queue.Get("action_1")
+	assert.Equal(t, ActionStatusConfirming, action.Status, "depth alone doesn't complete the action")
+	assert.Equal(t, uint64(10), action.Confirmations)
+
+	chain.mu.Lock()
+	chain.finalized = 1
+	chain.mu.Unlock()
+	assert.Equal(t, 1, queue.TrackConfirmations(ctx))
+	action, _ = queue.Get("action_1")
+	assert.Equal(t, ActionStatusCompleted, action.Status)
+}
+
+func TestActionQueueFailsWithoutRebroadcast(t *testing.T) {
+	chain := newReorgChain()
+	from, signer := newRelayer(t)
+	manager := NewNonceManager(chain, NonceManagerOptions{})
+	submitted, err := manager.Send(context.Background(), from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	submitter := &fixedSubmitter{submission: ActionSubmission{TxHash: submitted.Hash, From: from.Hex(), Nonce: submitted.Nonce}}
+	queue, audit, clock := newTestActionQueue(submitter)
+	queue.SetConfirmationTracking(chain, 5)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: user, ActionType: "stake"}, "msg_1")
+	clock.Advance(DefaultActionSubmitDelay)
+	require.Equal(t, 1, queue.SubmitDue(ctx))
+	chain.mineBlock(from)
+	queue.TrackConfirmations(ctx)
+
+	chain.reorg(from, 1)
+	assert.Equal(t, 1, queue.TrackConfirmations(ctx))
+	action, _ := queue.Get("action_1")
+	assert.Equal(t, ActionStatusFailed, action.Status)
+	assert.Contains(t, action.Error, "can't rebroadcast")
+	assert.Equal(t, []string{ActionEventSubmitted, ActionEventMined, ActionEventReorged, ActionEventFailed}, auditEvents(audit, user))
+}
+
+// fixedSubmitter reports every action as broadcast in the same transaction
+type fixedSubmitter struct {
+	submission ActionSubmission
+}
+
+func (s *fixedSubmitter) Submit(ctx context.Context, action *ActionRequest) (*ActionSubmission, error) {
+	submission := s.submission
+	return &submission, nil
+}
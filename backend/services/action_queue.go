@@ -0,0 +1,406 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of a queued action
+const (
+	ActionStatusProposed   = "proposed"
+	ActionStatusPending    = "pending"
+	ActionStatusSubmitting = "submitting"
+	ActionStatusSubmitted  = "submitted"
+	ActionStatusConfirming = "confirming"
+	ActionStatusCompleted  = "completed"
+	ActionStatusCancelled  = "cancelled"
+	ActionStatusFailed     = "failed"
+)
+
+// DefaultActionSubmitDelay is how long a confirmed action waits before it is
+// broadcast, giving the user a window to cancel it
+const DefaultActionSubmitDelay = 10 * time.Second
+
+var (
+	// ErrActionNotFound is returned for an action the queue doesn't hold
+	ErrActionNotFound = errors.New("action not found")
+	// ErrActionForbidden is returned when a user acts on another user's action
+	ErrActionForbidden = errors.New("action belongs to another user")
+)
+
+// ActionTooLateError is returned when an action can no longer be cancelled,
+// because its transaction has been broadcast and can't be replaced or has
+// already been mined
+type ActionTooLateError struct {
+	Status string
+	TxHash string
+}
+
+func (e *ActionTooLateError) Error() string {
+	if e.TxHash == "" {
+		return fmt.Sprintf("action is %s and can no longer be cancelled", e.Status)
+	}
+	return fmt.Sprintf("action is %s in transaction %s and can no longer be cancelled", e.Status, e.TxHash)
+}
+
+// ActionSubmission is the transaction an action was broadcast in
+type ActionSubmission struct {
+	TxHash string `json:"tx_hash"`
+	From   string `json:"from,omitempty"`
+	Nonce  uint64 `json:"nonce"`
+	// Mined is set when the submitter waited for the transaction to be mined
+	Mined bool `json:"mined"`
+}
+
+// ActionSubmitter broadcasts confirmed actions
+type ActionSubmitter interface {
+	Submit(ctx context.Context, action *ActionRequest) (*ActionSubmission, error)
+}
+
+// ActionReplacer is implemented by submitters whose relayer can replace a
+// broadcast transaction with a same-nonce no-op. Returns the hash of the
+// replacement, or ErrTxNotInFlight when the transaction has been mined.
+type ActionReplacer interface {
+	ReplaceWithNoop(ctx context.Context, submission ActionSubmission) (string, error)
+}
+
+// ActionCancellation is the outcome of cancelling an action
+type ActionCancellation struct {
+	Action ActionRequest `json:"action"`
+	// ReplacementTxHash is the no-op transaction sent in place of a
+	// broadcast one
+	ReplacementTxHash string `json:"replacement_tx_hash,omitempty"`
+}
+
+// queuedAction is an action and the state of its submission
+type queuedAction struct {
+	action     *ActionRequest
+	messageID  string
+	due        time.Time
+	submission *ActionSubmission
+	// measured is set once a receipt of the action has been read
+	measured bool
+}
+
+// ActionQueue holds confirmed actions for a short delay before broadcasting
+// them, so they can be cancelled, and records every transition in the audit
+// log. An action can still be cancelled once broadcast when the submitter
+// can replace its transaction. With confirmation tracking, a mined action is
+// only completed once its transaction is deep enough not to be reorganized
+// out.
+type ActionQueue struct {
+	submitter ActionSubmitter
+	audit     *ActionAuditLog
+	webhooks  *WebhookDispatcher
+	notifier  SigningNotifier
+	chain     ActionChain
+	depth     uint64
+	delay     time.Duration
+	logger    *log.Logger
+
+	// killSwitch holds due actions while actions are suspended
+	killSwitch *KillSwitch
+	// execution collects the realized slippage of mined swaps
+	execution *ExecutionQuality
+
+	mu      sync.Mutex
+	actions map[string]*queuedAction
+	latest  map[string]string
+	now     func() time.Time
+}
+
+// NewActionQueue creates a queue broadcasting actions through the submitter
+// once they have waited for the delay. audit may be nil.
+func NewActionQueue(submitter ActionSubmitter, audit *ActionAuditLog, delay time.Duration) *ActionQueue {
+	if delay < 0 {
+		delay = 0
+	}
+	return &ActionQueue{
+		submitter: submitter,
+		audit:     audit,
+		delay:     delay,
+		logger:    log.New(log.Writer(), "[ActionQueue] ", log.LstdFlags),
+		actions:   make(map[string]*queuedAction),
+		latest:    make(map[string]string),
+		now:       utcNow,
+	}
+}
+
+// SetWebhookDispatcher notifies the action owner's webhooks of completed actions
+func (q *ActionQueue) SetWebhookDispatcher(webhooks *WebhookDispatcher) {
+	q.webhooks = webhooks
+}
+
+// SetKillSwitch holds due actions, pending, while the kill switch is engaged
+func (q *ActionQueue) SetKillSwitch(killSwitch *KillSwitch) {
+	q.killSwitch = killSwitch
+}
+
+// Delay returns how long actions wait before they are broadcast
+func (q *ActionQueue) Delay() time.Duration {
+	return q.delay
+}
+
+// Enqueue holds a confirmed action until its delay has passed
+func (q *ActionQueue) Enqueue(action *ActionRequest, messageID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	action.Status = ActionStatusPending
+	q.actions[action.ID] = &queuedAction{action: action, messageID: messageID, due: q.now().Add(q.delay)}
+	q.latest[strings.ToLower(action.UserID)] = action.ID
+}
+
+// Get returns a copy of an action
+func (q *ActionQueue) Get(id string) (ActionRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued, ok := q.actions[id]
+	if !ok {
+		return ActionRequest{}, false
+	}
+	return *queued.action, true
+}
+
+// Latest returns the ID of the user's most recent action
+func (q *ActionQueue) Latest(userID string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id, ok := q.latest[strings.ToLower(userID)]
+	return id, ok
+}
+
+// Start broadcasts due actions in the background until ctx is cancelled
+func (q *ActionQueue) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q.SubmitDue(ctx)
+				q.TrackConfirmations(ctx)
+			}
+		}
+	}()
+}
+
+// SubmitDue broadcasts the pending actions whose delay has passed and returns
+// how many were submitted. Nothing is submitted while actions are suspended;
+// the due actions stay pending and go out on the first pass after.
+func (q *ActionQueue) SubmitDue(ctx context.Context) int {
+	if err := q.killSwitch.Check(ctx); err != nil {
+		return 0
+	}
+
+	q.mu.Lock()
+	now := q.now()
+	var due []*queuedAction
+	for _, queued := range q.actions {
+		// Actions already broadcast are pending again only while a reorg
+		// rebroadcast is under way
+		if queued.action.Status == ActionStatusPending && queued.submission == nil && !now.Before(queued.due) {
+			queued.action.Status = ActionStatusSubmitting
+			due = append(due, queued)
+		}
+	}
+	q.mu.Unlock()
+
+	submitted := 0
+	for i, queued := range due {
+		// Checked before every broadcast, so a switch engaged mid-pass holds
+		// the rest
+		if err := q.killSwitch.Check(ctx); err != nil {
+			q.hold(due[i:], err)
+			break
+		}
+		if q.submit(ctx, queued) {
+			submitted++
+		}
+	}
+	return submitted
+}
+
+// hold puts actions claimed for submission back to pending
+func (q *ActionQueue) hold(held []*queuedAction, reason error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, queued := range held {
+		queued.action.Status = ActionStatusPending
+	}
+	q.logger.Printf("Holding %d due actions: %v", len(held), reason)
+}
+
+// submit broadcasts one action. The queue isn't locked while the submitter
+// runs; the submitting status keeps the action from being cancelled meanwhile.
+func (q *ActionQueue) submit(ctx context.Context, queued *queuedAction) bool {
+	submission, err := q.submitter.Submit(ctx, queued.action)
+
+	q.mu.Lock()
+	action := queued.action
+	if err != nil {
+		action.Status = ActionStatusFailed
+		action.Error = err.Error()
+		q.record(queued, ActionEventFailed, "", err.Error())
+		failed := *action
+		q.mu.Unlock()
+		q.logger.Printf("Failed to submit action %s: %v", action.ID, err)
+		q.publish(failed)
+		return false
+	}
+
+	queued.submission = submission
+	action.Status = ActionStatusSubmitted
+	action.Result = map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Successfully executed %s action", action.ActionType),
+		"tx_hash": submission.TxHash,
+	}
+	q.record(queued, ActionEventSubmitted, submission.TxHash, "")
+	if submission.Mined {
+		// With tracking, the receipt is only the first confirmation
+		action.Status = ActionStatusCompleted
+		if q.chain != nil {
+			action.Status = ActionStatusConfirming
+		}
+		q.record(queued, ActionEventMined, submission.TxHash, "")
+	}
+	submitted := *action
+	q.mu.Unlock()
+
+	q.publish(submitted)
+	return true
+}
+
+// Cancel cancels one of the user's actions. A proposed or pending action is
+// cancelled before it is broadcast. A broadcast one is replaced with a
+// same-nonce no-op when the submitter supports it; otherwise, or once it has
+// been mined, an *ActionTooLateError is returned.
+func (q *ActionQueue) Cancel(ctx context.Context, userID, id string) (*ActionCancellation, error) {
+	q.mu.Lock()
+	queued, ok := q.actions[id]
+	if !ok {
+		q.mu.Unlock()
+		return nil, ErrActionNotFound
+	}
+	if !strings.EqualFold(queued.action.UserID, userID) {
+		q.mu.Unlock()
+		return nil, ErrActionForbidden
+	}
+
+	action := queued.action
+	if action.Status == ActionStatusPending && queued.submission != nil {
+		// Its transaction is being rebroadcast after a reorg
+		tooLate := &ActionTooLateError{Status: ActionStatusSubmitted, TxHash: queued.txHash()}
+		q.mu.Unlock()
+		return nil, tooLate
+	}
+	switch action.Status {
+	case ActionStatusProposed, ActionStatusPending:
+		action.Status = ActionStatusCancelled
+		q.record(queued, ActionEventCancelled, "", "cancelled before broadcast")
+		cancelled := *action
+		q.mu.Unlock()
+		return &ActionCancellation{Action: cancelled}, nil
+	case ActionStatusCancelled:
+		cancelled := *action
+		q.mu.Unlock()
+		return &ActionCancellation{Action: cancelled}, nil
+	case ActionStatusSubmitted:
+		// Replaced below, once the queue is unlocked
+	default:
+		tooLate := &ActionTooLateError{Status: action.Status, TxHash: queued.txHash()}
+		q.mu.Unlock()
+		return nil, tooLate
+	}
+	submission := *queued.submission
+	q.mu.Unlock()
+
+	replacer, ok := q.submitter.(ActionReplacer)
+	if !ok {
+		return nil, &ActionTooLateError{Status: ActionStatusSubmitted, TxHash: submission.TxHash}
+	}
+	replacement, err := replacer.ReplaceWithNoop(ctx, submission)
+	if errors.Is(err, ErrTxNotInFlight) {
+		return nil, &ActionTooLateError{Status: ActionStatusSubmitted, TxHash: submission.TxHash}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to replace transaction %s: %w", submission.TxHash, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	action.Status = ActionStatusCancelled
+	action.Result = map[string]interface{}{
+		"success":             false,
+		"tx_hash":             submission.TxHash,
+		"replacement_tx_hash": replacement,
+	}
+	q.record(queued, ActionEventCancelled, replacement, fmt.Sprintf("replaced %s with a no-op transaction", submission.TxHash))
+	return &ActionCancellation{Action: *action, ReplacementTxHash: replacement}, nil
+}
+
+// txHash returns the hash the action was broadcast in, if it has been
+func (queued *queuedAction) txHash() string {
+	if queued.submission == nil {
+		return ""
+	}
+	return queued.submission.TxHash
+}
+
+// record appends a transition of the action to the audit log. The queue is
+// locked by the caller.
+func (q *ActionQueue) record(queued *queuedAction, event, txHash, reason string) {
+	if q.audit == nil {
+		return
+	}
+	q.audit.Append(ActionAuditRecord{
+		ActionID:   queued.action.ID,
+		UserID:     queued.action.UserID,
+		MessageID:  queued.messageID,
+		Event:      event,
+		ActionType: queued.action.ActionType,
+		TxHash:     txHash,
+		Reason:     reason,
+	})
+}
+
+// recordExecution records how a mined swap's output compared with its
+// simulation
+func (q *ActionQueue) recordExecution(queued *queuedAction, execution *SwapExecution) {
+	if q.audit == nil {
+		return
+	}
+	slippage := execution.SlippagePct
+	q.audit.Append(ActionAuditRecord{
+		ActionID:    queued.action.ID,
+		UserID:      queued.action.UserID,
+		MessageID:   queued.messageID,
+		Event:       ActionEventExecuted,
+		ActionType:  queued.action.ActionType,
+		TxHash:      execution.TxHash,
+		Reason:      fmt.Sprintf("received %s of %s expected, %.2f%% slippage", execution.ActualAmountOut, execution.ExpectedAmountOut, slippage),
+		SlippagePct: &slippage,
+	})
+}
+
+// SimulatedActionSubmitter stands in for the ActionContract relayer, reporting
+// every action as mined in a placeholder transaction
+type SimulatedActionSubmitter struct{}
+
+// Submit reports the action as executed
+func (SimulatedActionSubmitter) Submit(ctx context.Context, action *ActionRequest) (*ActionSubmission, error) {
+	// In a real implementation, this would interact with the ActionContract
+	return &ActionSubmission{TxHash: "0x1234567890abcdef...", Mined: true}, nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSubmitter reports every action as mined, counting submissions
+type countingSubmitter struct {
+	submitted []string
+}
+
+func (s *countingSubmitter) Submit(ctx context.Context, action *ActionRequest) (*ActionSubmission, error) {
+	s.submitted = append(s.submitted, action.ID)
+	return &ActionSubmission{TxHash: "0xfeed", Mined: true}, nil
+}
+
+// relayerSubmitter broadcasts actions through a nonce manager and cancels
+// them with its same-nonce no-op
+type relayerSubmitter struct {
+	manager *NonceManager
+	from    common.Address
+	signer  TxSigner
+}
+
+func (s *relayerSubmitter) Submit(ctx context.Context, action *ActionRequest) (*ActionSubmission, error) {
+	sent, err := s.manager.Send(ctx, s.from, s.signer, TxRequest{To: &relayTarget, Data: []byte(action.ID), Gas: 50000})
+	if err != nil {
+		return nil, err
+	}
+	return &ActionSubmission{TxHash: sent.Hash, From: sent.From, Nonce: sent.Nonce}, nil
+}
+
+func (s *relayerSubmitter) ReplaceWithNoop(ctx context.Context, submission ActionSubmission) (string, error) {
+	replacement, err := s.manager.Cancel(ctx, common.HexToAddress(submission.From), submission.Nonce)
+	if err != nil {
+		return "", err
+	}
+	return replacement.Hash, nil
+}
+
+func (s *relayerSubmitter) Rebroadcast(ctx context.Context, submission ActionSubmission) (string, error) {
+	resent, err := s.manager.Resend(ctx, common.HexToAddress(submission.From), submission.Nonce)
+	if err != nil {
+		return "", err
+	}
+	return resent.Hash, nil
+}
+
+func newTestActionQueue(submitter ActionSubmitter) (*ActionQueue, *ActionAuditLog, *testClock) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	audit := NewActionAuditLog()
+	queue := NewActionQueue(submitter, audit, DefaultActionSubmitDelay)
+	queue.now = clock.Now
+	return queue, audit, clock
+}
+
+func auditEvents(audit *ActionAuditLog, userID string) []string {
+	var events []string
+	for _, record := range audit.Records(userID, time.Time{}, time.Time{}) {
+		events = append(events, record.Event)
+	}
+	return events
+}
+
+func TestChatCancelThatBeforeBroadcast(t *testing.T) {
+	submitter := &countingSubmitter{}
+	queue, audit, clock := newTestActionQueue(submitter)
+	engine := newTestChatEngine(t)
+	engine.SetActionAudit(audit)
+	engine.SetActionQueue(queue)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	response, err := engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_1", UserID: user, Message: "Swap 5 KAIA for USDT"})
+	require.NoError(t, err)
+	action := response.Data.(*ActionRequest)
+	assert.Equal(t, ActionStatusPending, action.Status)
+	assert.Contains(t, response.Response, "cancel that")
+
+	// "that" is the user's most recent action
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_2", UserID: user, Message: "Cancel that"})
+	require.NoError(t, err)
+	assert.Equal(t, "cancel_action", response.Metadata["intent"])
+	assert.True(t, response.Success)
+	cancellation := response.Data.(*ActionCancellation)
+	assert.Equal(t, action.ID, cancellation.Action.ID)
+	assert.Equal(t, ActionStatusCancelled, cancellation.Action.Status)
+	assert.Empty(t, cancellation.ReplacementTxHash)
+
+	// The cancelled action is never submitted
+	clock.Advance(time.Minute)
+	assert.Equal(t, 0, queue.SubmitDue(ctx))
+	assert.Empty(t, submitter.submitted)
+
+	assert.Equal(t, []string{ActionEventProposed, ActionEventConfirmed, ActionEventCancelled}, auditEvents(audit, user))
+
+	// Once cancelled, a second request is a no-op
+	again, err := queue.Cancel(ctx, user, action.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ActionStatusCancelled, again.Action.Status)
+	assert.Len(t, auditEvents(audit, user), 3)
+}
+
+func TestActionQueueSubmitsAfterDelay(t *testing.T) {
+	submitter := &countingSubmitter{}
+	queue, audit, clock := newTestActionQueue(submitter)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: user, ActionType: "stake"}, "msg_1")
+	clock.Advance(DefaultActionSubmitDelay - time.Second)
+	assert.Equal(t, 0, queue.SubmitDue(ctx))
+
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, queue.SubmitDue(ctx))
+	action, ok := queue.Get("action_1")
+	require.True(t, ok)
+	assert.Equal(t, ActionStatusCompleted, action.Status)
+	assert.Equal(t, []string{ActionEventSubmitted, ActionEventMined}, auditEvents(audit, user))
+
+	// A mined action is too late to cancel; the error carries its transaction
+	_, err := queue.Cancel(ctx, user, "action_1")
+	var tooLate *ActionTooLateError
+	require.True(t, errors.As(err, &tooLate))
+	assert.Equal(t, "0xfeed", tooLate.TxHash)
+	assert.Len(t, auditEvents(audit, user), 2)
+}
+
+func TestActionQueueCancelAfterBroadcastReplaces(t *testing.T) {
+	pool := newFakeMempool()
+	from, signer := newRelayer(t)
+	submitter := &relayerSubmitter{manager: NewNonceManager(pool, NonceManagerOptions{}), from: from, signer: signer}
+	queue, audit, clock := newTestActionQueue(submitter)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: user, ActionType: "swap"}, "msg_1")
+	clock.Advance(DefaultActionSubmitDelay)
+	require.Equal(t, 1, queue.SubmitDue(ctx))
+	broadcast, _ := queue.Get("action_1")
+	require.Equal(t, ActionStatusSubmitted, broadcast.Status)
+	original := broadcast.Result.(map[string]interface{})["tx_hash"].(string)
+
+	cancellation, err := queue.Cancel(ctx, user, "action_1")
+	require.NoError(t, err)
+	assert.Equal(t, ActionStatusCancelled, cancellation.Action.Status)
+	assert.NotEqual(t, original, cancellation.ReplacementTxHash)
+
+	// The pool now holds a zero-value self-transfer at the original nonce
+	pool.mu.Lock()
+	pooled := pool.pool[from][0]
+	pool.mu.Unlock()
+	assert.Equal(t, cancellation.ReplacementTxHash, pooled.Hash().Hex())
+	assert.Equal(t, from, *pooled.To())
+	assert.Equal(t, 0, pooled.Value().Cmp(new(big.Int)))
+	assert.Empty(t, pooled.Data())
+
+	records := audit.Records(user, time.Time{}, time.Time{})
+	require.Len(t, records, 2)
+	assert.Equal(t, ActionEventCancelled, records[1].Event)
+	assert.Equal(t, cancellation.ReplacementTxHash, records[1].TxHash)
+	assert.Contains(t, records[1].Reason, original)
+
+	// Once the relayer's transaction is mined it is too late
+	queue.Enqueue(&ActionRequest{ID: "action_2", UserID: user, ActionType: "swap"}, "msg_2")
+	clock.Advance(DefaultActionSubmitDelay)
+	require.Equal(t, 1, queue.SubmitDue(ctx))
+	pool.mine(from)
+	_, err = queue.Cancel(ctx, user, "action_2")
+	var tooLate *ActionTooLateError
+	require.True(t, errors.As(err, &tooLate))
+	submitted, _ := queue.Get("action_2")
+	assert.Equal(t, submitted.Result.(map[string]interface{})["tx_hash"], tooLate.TxHash)
+}
+
+func TestActionQueueCancelOtherUsersAction(t *testing.T) {
+	queue, audit, _ := newTestActionQueue(&countingSubmitter{})
+	ctx := context.Background()
+	owner := summaryAddress.Hex()
+
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: owner, ActionType: "stake"}, "msg_1")
+
+	_, err := queue.Cancel(ctx, "0x00000000000000000000000000000000000000bb", "action_1")
+	assert.ErrorIs(t, err, ErrActionForbidden)
+	_, err = queue.Cancel(ctx, owner, "action_404")
+	assert.ErrorIs(t, err, ErrActionNotFound)
+
+	action, _ := queue.Get("action_1")
+	assert.Equal(t, ActionStatusPending, action.Status)
+	assert.Empty(t, auditEvents(audit, owner))
+
+	// The owner's address matches in any case
+	_, err = queue.Cancel(ctx, strings.ToLower(owner), "action_1")
+	require.NoError(t, err)
+}
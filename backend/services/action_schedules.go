@@ -0,0 +1,533 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxActionSchedulesPerUser caps the recurring actions a user can keep
+	MaxActionSchedulesPerUser = 10
+	// MaxScheduleFailures is how many runs in a row can fail before a
+	// schedule is paused
+	MaxScheduleFailures = 2
+	// DefaultScheduleHour is the UTC hour a schedule runs when no time is given
+	DefaultScheduleHour = 9
+
+	// actionScheduleTick is how often due schedules are looked for
+	actionScheduleTick = 15 * time.Second
+)
+
+// Statuses of an action schedule
+const (
+	ScheduleStatusActive = "active"
+	ScheduleStatusPaused = "paused"
+)
+
+var (
+	// ErrActionScheduleNotFound is returned for unknown schedules and other
+	// users' schedules
+	ErrActionScheduleNotFound = errors.New("action schedule not found")
+	// ErrActionScheduleLimit is returned when a user already has the most
+	// schedules allowed
+	ErrActionScheduleLimit = fmt.Errorf("at most %d recurring actions are allowed", MaxActionSchedulesPerUser)
+	// ErrScheduleDayOfMonth is returned for monthly schedules on a day not
+	// every month has
+	ErrScheduleDayOfMonth = errors.New("the day of the month must be between the 1st and the 28th")
+	// ErrScheduleTime is returned for times of day that don't exist
+	ErrScheduleTime = errors.New("the time must be a time of day such as 9am or 14:30")
+)
+
+// ScheduleSpec is when a recurring action runs, in UTC. Like a cron
+// expression it has a minute and hour, and at most one of a weekday or a day
+// of the month; with neither it runs daily.
+type ScheduleSpec struct {
+	Minute int `json:"minute"`
+	Hour   int `json:"hour"`
+	// Weekday is 0 (Sunday) to 6, or -1 for any day
+	Weekday int `json:"weekday"`
+	// DayOfMonth is 1 to 28, or 0 for any day
+	DayOfMonth int `json:"day_of_month"`
+}
+
+// Cron returns the spec as a five-field cron expression
+func (s ScheduleSpec) Cron() string {
+	dayOfMonth, weekday := "*", "*"
+	if s.DayOfMonth > 0 {
+		dayOfMonth = strconv.Itoa(s.DayOfMonth)
+	}
+	if s.Weekday >= 0 {
+		weekday = strconv.Itoa(s.Weekday)
+	}
+	return fmt.Sprintf("%d %d %s * %s", s.Minute, s.Hour, dayOfMonth, weekday)
+}
+
+// Describe returns the spec in words, such as "every Friday at 09:00 UTC"
+func (s ScheduleSpec) Describe() string {
+	at := fmt.Sprintf("at %02d:%02d UTC", s.Hour, s.Minute)
+	switch {
+	case s.Weekday >= 0:
+		return fmt.Sprintf("every %s %s", time.Weekday(s.Weekday), at)
+	case s.DayOfMonth > 0:
+		return fmt.Sprintf("on the %s of every month %s", ordinal(s.DayOfMonth), at)
+	default:
+		return "every day " + at
+	}
+}
+
+// Next returns the first time after the given one the spec runs at
+func (s ScheduleSpec) Next(after time.Time) time.Time {
+	after = after.UTC()
+	day := time.Date(after.Year(), after.Month(), after.Day(), s.Hour, s.Minute, 0, 0, time.UTC)
+	// A monthly spec runs within 31 days, as its day is at most the 28th
+	for i := 0; i <= 31; i++ {
+		candidate := day.AddDate(0, 0, i)
+		if !candidate.After(after) {
+			continue
+		}
+		if s.Weekday >= 0 && int(candidate.Weekday()) != s.Weekday {
+			continue
+		}
+		if s.DayOfMonth > 0 && candidate.Day() != s.DayOfMonth {
+			continue
+		}
+		return candidate
+	}
+	return time.Time{}
+}
+
+// ordinal writes a day of the month as 1st, 2nd, 3rd...
+func ordinal(day int) string {
+	suffix := "th"
+	switch {
+	case day%100 >= 11 && day%100 <= 13:
+	case day%10 == 1:
+		suffix = "st"
+	case day%10 == 2:
+		suffix = "nd"
+	case day%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(day) + suffix
+}
+
+var (
+	recurrenceWeekdayRegex  = regexp.MustCompile(`(?i)\b(?:(?:every|each)\s+(monday|tuesday|wednesday|thursday|friday|saturday|sunday)s?|on\s+(monday|tuesday|wednesday|thursday|friday|saturday|sunday)s)\b`)
+	recurrenceWeeklyRegex   = regexp.MustCompile(`(?i)\b(?:weekly|(?:every|each|once\s+a)\s+week)\b`)
+	recurrenceMonthDayRegex = regexp.MustCompile(`(?i)\bon\s+the\s+(\d{1,2})(?:st|nd|rd|th)(?:\s+of\s+(?:every|each|the)\s+month)?\b`)
+	recurrenceMonthlyRegex  = regexp.MustCompile(`(?i)\b(?:monthly|(?:every|each|once\s+a)\s+month)\b`)
+	recurrenceDailyRegex    = regexp.MustCompile(`(?i)\b(?:daily|(?:every|each|once\s+a)\s+day)\b`)
+	recurrenceTimeRegex     = regexp.MustCompile(`(?i)\bat\s+(\d{1,2})(?::(\d{2}))?\s*(am|pm)?(\s*utc)?\b`)
+	autoExecuteRegex        = regexp.MustCompile(`(?i)\b(?:automatically|auto[- ]?execute|without\s+asking(?:\s+me)?)\b`)
+)
+
+// mentionsRecurrence reports whether the message asks for something to
+// happen repeatedly, such as "every Friday" or "on the 1st"
+func mentionsRecurrence(message string) bool {
+	for _, pattern := range []*regexp.Regexp{recurrenceWeekdayRegex, recurrenceWeeklyRegex, recurrenceMonthDayRegex, recurrenceMonthlyRegex, recurrenceDailyRegex} {
+		if pattern.MatchString(message) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRecurrence reads when an action should repeat from phrases such as
+// "every Friday", "weekly", "daily at 18:00" or "on the 1st at 9am". Times
+// are UTC and default to DefaultScheduleHour; "weekly" alone repeats on
+// now's weekday. ok is false when the message names no recurrence.
+func ParseRecurrence(message string, now time.Time) (spec ScheduleSpec, ok bool, err error) {
+	spec = ScheduleSpec{Hour: DefaultScheduleHour, Weekday: -1}
+	if match := recurrenceWeekdayRegex.FindStringSubmatch(message); match != nil {
+		name := strings.ToLower(match[1] + match[2])
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.ToLower(day.String()) == name {
+				spec.Weekday = int(day)
+			}
+		}
+	} else if recurrenceWeeklyRegex.MatchString(message) {
+		spec.Weekday = int(now.UTC().Weekday())
+	} else if match := recurrenceMonthDayRegex.FindStringSubmatch(message); match != nil {
+		day, _ := strconv.Atoi(match[1])
+		if day < 1 || day > 28 {
+			return ScheduleSpec{}, true, ErrScheduleDayOfMonth
+		}
+		spec.DayOfMonth = day
+	} else if recurrenceMonthlyRegex.MatchString(message) {
+		spec.DayOfMonth = 1
+	} else if !recurrenceDailyRegex.MatchString(message) {
+		return ScheduleSpec{}, false, nil
+	}
+
+	for _, match := range recurrenceTimeRegex.FindAllStringSubmatch(message, -1) {
+		if !isScheduleTime(match) {
+			continue
+		}
+		hour, _ := strconv.Atoi(match[1])
+		minute := 0
+		if match[2] != "" {
+			minute, _ = strconv.Atoi(match[2])
+		}
+		switch strings.ToLower(match[3]) {
+		case "am", "pm":
+			if hour < 1 || hour > 12 {
+				return ScheduleSpec{}, true, ErrScheduleTime
+			}
+			hour %= 12
+			if strings.EqualFold(match[3], "pm") {
+				hour += 12
+			}
+		}
+		if hour > 23 || minute > 59 {
+			return ScheduleSpec{}, true, ErrScheduleTime
+		}
+		spec.Hour, spec.Minute = hour, minute
+		break
+	}
+	return spec, true, nil
+}
+
+// isScheduleTime reports whether a recurrenceTimeRegex match is a time: "at
+// 5" alone is as likely an amount, so it needs minutes, am/pm or UTC
+func isScheduleTime(match []string) bool {
+	return match[2] != "" || match[3] != "" || match[4] != ""
+}
+
+// wantsAutoExecute reports whether the user asked for a recurring action to
+// run without confirming each time
+func wantsAutoExecute(message string) bool {
+	return autoExecuteRegex.MatchString(message)
+}
+
+// scheduledActionText strips the recurrence and auto-execution phrases from
+// a message, leaving the action to repeat: "Stake 1 ETH every Friday at 9am"
+// becomes "Stake 1 ETH"
+func scheduledActionText(message string) string {
+	for _, pattern := range []*regexp.Regexp{
+		recurrenceWeekdayRegex, recurrenceWeeklyRegex, recurrenceMonthDayRegex, recurrenceMonthlyRegex,
+		recurrenceDailyRegex, autoExecuteRegex,
+	} {
+		message = pattern.ReplaceAllString(message, " ")
+	}
+	message = recurrenceTimeRegex.ReplaceAllStringFunc(message, func(phrase string) string {
+		if isScheduleTime(recurrenceTimeRegex.FindStringSubmatch(phrase)) {
+			return " "
+		}
+		return phrase
+	})
+	return strings.Trim(strings.Join(strings.Fields(message), " "), " ,.;!")
+}
+
+// ActionSchedule repeats an action for a user. Action is the chat message
+// run at each occurrence, such as "Stake 1 ETH". Unless AutoExecute is set,
+// each occurrence proposes the action for the user to confirm.
+type ActionSchedule struct {
+	ID          string `json:"id"`
+	UserID      string `json:"user_id"`
+	Action      string `json:"action"`
+	ActionType  string `json:"action_type"`
+	Cron        string `json:"cron"`
+	Description string `json:"description"`
+	AutoExecute bool   `json:"auto_execute"`
+	Status      string `json:"status"`
+	// ConsecutiveFailures counts the runs that failed since the last success
+	ConsecutiveFailures int      `json:"consecutive_failures"`
+	LastError           string   `json:"last_error,omitempty"`
+	Runs                int      `json:"runs"`
+	NextRunAt           APITime  `json:"next_run_at"`
+	LastRunAt           *APITime `json:"last_run_at,omitempty"`
+	CreatedAt           APITime  `json:"created_at"`
+
+	spec ScheduleSpec
+}
+
+// ScheduledActionExecutor runs a schedule's action for its user, as if they
+// had just confirmed it in chat. A response without Success is a failed run.
+type ScheduledActionExecutor interface {
+	ExecuteScheduledAction(ctx context.Context, schedule ActionSchedule) (*ChatResponse, error)
+}
+
+// ActionSchedules holds users' recurring actions and fires them when due:
+// as a proposal the user confirms with one tap, or by running the action
+// when the user enabled auto-execution. Schedules pause after
+// MaxScheduleFailures failed runs in a row.
+type ActionSchedules struct {
+	mu        sync.Mutex
+	schedules map[string]*ActionSchedule
+	executor  ScheduledActionExecutor
+	notifier  SigningNotifier
+	logger    *log.Logger
+	now       func() time.Time
+}
+
+// NewActionSchedules creates an empty schedule store
+func NewActionSchedules() *ActionSchedules {
+	return &ActionSchedules{
+		schedules: make(map[string]*ActionSchedule),
+		logger:    log.New(log.Writer(), "[ActionSchedules] ", log.LstdFlags),
+		now:       utcNow,
+	}
+}
+
+// SetExecutor runs the actions of auto-executing schedules. Without one,
+// every schedule proposes its action instead.
+func (as *ActionSchedules) SetExecutor(executor ScheduledActionExecutor) {
+	as.executor = executor
+}
+
+// SetNotifier delivers proposals and run results to the user's chat
+// connections
+func (as *ActionSchedules) SetNotifier(notifier SigningNotifier) {
+	as.notifier = notifier
+}
+
+// Create adds a schedule repeating the action for the user
+func (as *ActionSchedules) Create(userID, action, actionType string, spec ScheduleSpec, autoExecute bool) (ActionSchedule, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return ActionSchedule{}, fmt.Errorf("failed to generate schedule ID: %w", err)
+	}
+	now := as.now()
+	schedule := ActionSchedule{
+		ID:          "schedule_" + id,
+		UserID:      strings.ToLower(userID),
+		Action:      action,
+		ActionType:  actionType,
+		Cron:        spec.Cron(),
+		Description: spec.Describe(),
+		AutoExecute: autoExecute,
+		Status:      ScheduleStatusActive,
+		NextRunAt:   NewAPITime(spec.Next(now)),
+		CreatedAt:   NewAPITime(now),
+		spec:        spec,
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	count := 0
+	for _, existing := range as.schedules {
+		if existing.UserID == schedule.UserID {
+			count++
+		}
+	}
+	if count >= MaxActionSchedulesPerUser {
+		return ActionSchedule{}, ErrActionScheduleLimit
+	}
+	as.schedules[schedule.ID] = &schedule
+	return schedule, nil
+}
+
+// Schedules returns the user's schedules, oldest first
+func (as *ActionSchedules) Schedules(userID string) []ActionSchedule {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	schedules := []ActionSchedule{}
+	for _, schedule := range as.schedules {
+		if strings.EqualFold(schedule.UserID, userID) {
+			schedules = append(schedules, *schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt.Time) {
+			return schedules[i].CreatedAt.Before(schedules[j].CreatedAt.Time)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
+// Delete removes one of the user's schedules
+func (as *ActionSchedules) Delete(userID, id string) (ActionSchedule, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	schedule, ok := as.schedules[id]
+	if !ok || !strings.EqualFold(schedule.UserID, userID) {
+		return ActionSchedule{}, ErrActionScheduleNotFound
+	}
+	delete(as.schedules, id)
+	return *schedule, nil
+}
+
+// Resume reactivates one of the user's paused schedules from its next
+// occurrence, clearing its failures
+func (as *ActionSchedules) Resume(userID, id string) (ActionSchedule, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	schedule, ok := as.schedules[id]
+	if !ok || !strings.EqualFold(schedule.UserID, userID) {
+		return ActionSchedule{}, ErrActionScheduleNotFound
+	}
+	if schedule.Status == ScheduleStatusPaused {
+		schedule.Status = ScheduleStatusActive
+		schedule.ConsecutiveFailures = 0
+		schedule.NextRunAt = NewAPITime(schedule.spec.Next(as.now()))
+	}
+	return *schedule, nil
+}
+
+// UserData returns the user's schedules
+func (as *ActionSchedules) UserData(userID string) interface{} {
+	return as.Schedules(userID)
+}
+
+// EraseUserData deletes the user's schedules and returns how many were removed
+func (as *ActionSchedules) EraseUserData(userID string) int {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	removed := 0
+	for id, schedule := range as.schedules {
+		if strings.EqualFold(schedule.UserID, userID) {
+			delete(as.schedules, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Start fires due schedules in the background until ctx is cancelled
+func (as *ActionSchedules) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(actionScheduleTick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				as.RunDue(ctx)
+			}
+		}
+	}()
+}
+
+// RunDue fires the active schedules whose time has come and returns how many
+// fired. A schedule missed while the service was down fires once, not once
+// per missed occurrence.
+func (as *ActionSchedules) RunDue(ctx context.Context) int {
+	now := as.now()
+
+	as.mu.Lock()
+	var due []ActionSchedule
+	for _, schedule := range as.schedules {
+		if schedule.Status != ScheduleStatusActive || schedule.NextRunAt.After(now) {
+			continue
+		}
+		schedule.NextRunAt = NewAPITime(schedule.spec.Next(now))
+		due = append(due, *schedule)
+	}
+	as.mu.Unlock()
+
+	for _, schedule := range due {
+		as.record(schedule.ID, now, as.fire(ctx, schedule))
+	}
+	return len(due)
+}
+
+// fire runs or proposes one occurrence of the schedule and returns why it
+// failed, or nil
+func (as *ActionSchedules) fire(ctx context.Context, schedule ActionSchedule) error {
+	if as.notifier == nil {
+		return errors.New("no chat connection to deliver to")
+	}
+
+	if !schedule.AutoExecute || as.executor == nil {
+		// A proposal nobody is connected to see counts as failed, so an
+		// absent user's proposals don't pile up
+		return as.notifier.SendToUser(schedule.UserID, as.frame(schedule, "scheduled_action", fmt.Sprintf(
+			"⏰ **Scheduled Action**\n\nYour recurring action \"%s\" (%s) is due. Confirm to run it now.", schedule.Action, schedule.Description),
+			true, map[string]interface{}{"confirm_message": schedule.Action}))
+	}
+
+	response, err := as.executor.ExecuteScheduledAction(ctx, schedule)
+	if err != nil {
+		return err
+	}
+	response.ID = fmt.Sprintf("%s_%d", schedule.ID, as.now().UnixNano())
+	if response.Metadata == nil {
+		response.Metadata = make(map[string]interface{})
+	}
+	response.Metadata["schedule_id"] = schedule.ID
+	response.Timestamp = NewAPITime(as.now())
+	response.TimestampUnix = response.Timestamp.Unix()
+	if err := as.notifier.SendToUser(schedule.UserID, response); err != nil {
+		as.logger.Printf("Failed to deliver the run of schedule %s: %v", schedule.ID, err)
+	}
+	if !response.Success {
+		if reason, ok := response.Metadata["error"].(string); ok {
+			return errors.New(reason)
+		}
+		return errors.New("the action failed")
+	}
+	return nil
+}
+
+// record keeps the outcome of a run, pausing the schedule once it has
+// failed MaxScheduleFailures times in a row
+func (as *ActionSchedules) record(id string, at time.Time, runErr error) {
+	as.mu.Lock()
+	schedule, ok := as.schedules[id]
+	if !ok {
+		as.mu.Unlock()
+		return
+	}
+	ranAt := NewAPITime(at)
+	schedule.LastRunAt = &ranAt
+	schedule.Runs++
+	if runErr == nil {
+		schedule.ConsecutiveFailures = 0
+		schedule.LastError = ""
+		as.mu.Unlock()
+		return
+	}
+	schedule.ConsecutiveFailures++
+	schedule.LastError = runErr.Error()
+	paused := schedule.ConsecutiveFailures >= MaxScheduleFailures && schedule.Status == ScheduleStatusActive
+	if paused {
+		schedule.Status = ScheduleStatusPaused
+	}
+	snapshot := *schedule
+	as.mu.Unlock()
+
+	as.logger.Printf("Schedule %s of %s failed (%d in a row): %v", id, snapshot.UserID, snapshot.ConsecutiveFailures, runErr)
+	if paused && as.notifier != nil {
+		err := as.notifier.SendToUser(snapshot.UserID, as.frame(snapshot, "scheduled_action_paused", fmt.Sprintf(
+			"⏸️ **Recurring Action Paused**\n\n\"%s\" (%s) failed %d times in a row, most recently because: %s. Resume it from your schedules once the problem is fixed.",
+			snapshot.Action, snapshot.Description, snapshot.ConsecutiveFailures, snapshot.LastError), false, nil))
+		if err != nil {
+			as.logger.Printf("Failed to tell %s schedule %s was paused: %v", snapshot.UserID, id, err)
+		}
+	}
+}
+
+// frame builds a chat frame about the schedule
+func (as *ActionSchedules) frame(schedule ActionSchedule, kind, text string, success bool, metadata map[string]interface{}) *ChatResponse {
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata["schedule_id"] = schedule.ID
+	now := as.now()
+	return &ChatResponse{
+		ID:            fmt.Sprintf("%s_%d", schedule.ID, now.UnixNano()),
+		Response:      text,
+		Type:          kind,
+		Data:          schedule,
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       success,
+		Metadata:      metadata,
+	}
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scheduleNow is a Sunday
+var scheduleNow = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseRecurrence(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		cron    string
+		action  string
+	}{
+		{"stake my rewards every Friday", "0 9 * * 5", "stake my rewards"},
+		{"Stake 1 ETH every friday at 6pm", "0 18 * * 5", "Stake 1 ETH"},
+		{"stake 1 ETH on Mondays at 12am UTC", "0 0 * * 1", "stake 1 ETH"},
+		{"swap 10 USDC for ETH daily at 14:30", "30 14 * * *", "swap 10 USDC for ETH"},
+		{"Swap 5 DAI for ETH every day, automatically", "0 9 * * *", "Swap 5 DAI for ETH"},
+		{"stake 2 ETH weekly", "0 9 * * 0", "stake 2 ETH"},
+		{"stake 2 ETH on the 1st", "0 9 1 * *", "stake 2 ETH"},
+		{"stake 2 ETH on the 15th of every month at 7:05 am", "5 7 15 * *", "stake 2 ETH"},
+		{"stake 2 ETH monthly", "0 9 1 * *", "stake 2 ETH"},
+		// A bare number after "at" isn't a time
+		{"swap at 5 ETH every day", "0 9 * * *", "swap at 5 ETH"},
+	} {
+		spec, ok, err := ParseRecurrence(tc.message, scheduleNow)
+		require.NoError(t, err, tc.message)
+		require.True(t, ok, tc.message)
+		assert.Equal(t, tc.cron, spec.Cron(), tc.message)
+		assert.Equal(t, tc.action, scheduledActionText(tc.message), tc.message)
+	}
+
+	_, ok, err := ParseRecurrence("stake 1 ETH", scheduleNow)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, _, err = ParseRecurrence("stake 1 ETH on the 31st", scheduleNow)
+	assert.ErrorIs(t, err, ErrScheduleDayOfMonth)
+	_, _, err = ParseRecurrence("stake 1 ETH every Friday at 13pm", scheduleNow)
+	assert.ErrorIs(t, err, ErrScheduleTime)
+	_, _, err = ParseRecurrence("stake 1 ETH every Friday at 25:00", scheduleNow)
+	assert.ErrorIs(t, err, ErrScheduleTime)
+
+	assert.True(t, wantsAutoExecute("stake my rewards every Friday automatically"))
+	assert.False(t, wantsAutoExecute("stake my rewards every Friday"))
+}
+
+func TestScheduleSpecNext(t *testing.T) {
+	friday := ScheduleSpec{Hour: 9, Weekday: int(time.Friday)}
+	assert.Equal(t, "every Friday at 09:00 UTC", friday.Describe())
+	assert.Equal(t, time.Date(2025, 6, 6, 9, 0, 0, 0, time.UTC), friday.Next(scheduleNow))
+	// Exactly at an occurrence, the next one is a week later
+	assert.Equal(t, time.Date(2025, 6, 13, 9, 0, 0, 0, time.UTC), friday.Next(time.Date(2025, 6, 6, 9, 0, 0, 0, time.UTC)))
+
+	daily := ScheduleSpec{Hour: 18, Minute: 30, Weekday: -1}
+	assert.Equal(t, "every day at 18:30 UTC", daily.Describe())
+	assert.Equal(t, time.Date(2025, 6, 1, 18, 30, 0, 0, time.UTC), daily.Next(scheduleNow))
+	// Times are UTC whatever the zone of the time given
+	tokyo := time.FixedZone("JST", 9*60*60)
+	assert.Equal(t, time.Date(2025, 6, 1, 18, 30, 0, 0, time.UTC), daily.Next(time.Date(2025, 6, 1, 23, 0, 0, 0, tokyo)))
+
+	monthly := ScheduleSpec{Hour: 9, Weekday: -1, DayOfMonth: 1}
+	assert.Equal(t, "on the 1st of every month at 09:00 UTC", monthly.Describe())
+	assert.Equal(t, time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC), monthly.Next(time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)))
+}
+
+// scriptedExecutor runs scheduled actions with the outcomes it is given, in
+// order
+type scriptedExecutor struct {
+	outcomes []*ChatResponse
+	ran      []string
+}
+
+func (e *scriptedExecutor) ExecuteScheduledAction(ctx context.Context, schedule ActionSchedule) (*ChatResponse, error) {
+	e.ran = append(e.ran, schedule.Action)
+	if len(e.outcomes) == 0 {
+		return nil, errors.New("no outcome scripted")
+	}
+	outcome := e.outcomes[0]
+	e.outcomes = e.outcomes[1:]
+	return outcome, nil
+}
+
+func newTestActionSchedules() (*ActionSchedules, *recordingNotifier, *testClock) {
+	clock := &testClock{now: scheduleNow}
+	notifier := &recordingNotifier{}
+	schedules := NewActionSchedules()
+	schedules.SetNotifier(notifier)
+	schedules.now = clock.Now
+	return schedules, notifier, clock
+}
+
+func TestActionSchedulesProposeWhenDue(t *testing.T) {
+	schedules, notifier, clock := newTestActionSchedules()
+	executor := &scriptedExecutor{}
+	schedules.SetExecutor(executor)
+	ctx := context.Background()
+
+	schedule, err := schedules.Create("0xUser", "stake my rewards", "stake", ScheduleSpec{Hour: 9, Weekday: int(time.Friday)}, false)
+	require.NoError(t, err)
+	assert.Equal(t, "0 9 * * 5", schedule.Cron)
+	assert.Equal(t, time.Date(2025, 6, 6, 9, 0, 0, 0, time.UTC), schedule.NextRunAt.Time)
+
+	clock.Advance(4*24*time.Hour + 20*time.Hour)
+	assert.Equal(t, 0, schedules.RunDue(ctx), "Thursday 08:00")
+	clock.Advance(time.Hour)
+	assert.Equal(t, 1, schedules.RunDue(ctx))
+	assert.Equal(t, 0, schedules.RunDue(ctx), "fires once per occurrence")
+
+	require.Len(t, notifier.frames, 1)
+	proposal := notifier.frames[0]
+	assert.Equal(t, "scheduled_action", proposal.Type)
+	assert.Equal(t, "stake my rewards", proposal.Metadata["confirm_message"])
+	assert.Equal(t, schedule.ID, proposal.Metadata["schedule_id"])
+	assert.Empty(t, executor.ran, "proposals aren't run")
+
+	listed := schedules.Schedules("0xuser")
+	require.Len(t, listed, 1)
+	assert.Equal(t, 1, listed[0].Runs)
+	assert.Equal(t, time.Date(2025, 6, 13, 9, 0, 0, 0, time.UTC), listed[0].NextRunAt.Time)
+
+	// Missed occurrences fire once
+	clock.Advance(3 * 7 * 24 * time.Hour)
+	assert.Equal(t, 1, schedules.RunDue(ctx))
+	assert.Equal(t, 0, schedules.RunDue(ctx))
+
+	_, err = schedules.Delete("0xother", schedule.ID)
+	assert.ErrorIs(t, err, ErrActionScheduleNotFound)
+	_, err = schedules.Delete("0xuser", schedule.ID)
+	require.NoError(t, err)
+	assert.Empty(t, schedules.Schedules("0xuser"))
+}
+
+func TestActionSchedulesPauseAfterFailures(t *testing.T) {
+	schedules, notifier, clock := newTestActionSchedules()
+	executor := &scriptedExecutor{outcomes: []*ChatResponse{
+		{Success: false, Metadata: map[string]interface{}{"error": "spending_limit"}},
+		{Success: true},
+		{Success: false, Metadata: map[string]interface{}{"error": "spending_limit"}},
+		{Success: false, Metadata: map[string]interface{}{"error": "actions_suspended"}},
+	}}
+	schedules.SetExecutor(executor)
+	ctx := context.Background()
+
+	schedule, err := schedules.Create("0xuser", "Stake 1 ETH", "stake", ScheduleSpec{Hour: 9, Weekday: -1}, true)
+	require.NoError(t, err)
+	daily := func() int {
+		clock.Advance(24 * time.Hour)
+		return schedules.RunDue(ctx)
+	}
+
+	// A success between failures resets the count
+	require.Equal(t, 1, daily())
+	assert.Equal(t, 1, schedules.Schedules("0xuser")[0].ConsecutiveFailures)
+	require.Equal(t, 1, daily())
+	assert.Equal(t, 0, schedules.Schedules("0xuser")[0].ConsecutiveFailures)
+	require.Equal(t, 1, daily())
+	require.Equal(t, 1, daily())
+
+	paused := schedules.Schedules("0xuser")[0]
+	assert.Equal(t, ScheduleStatusPaused, paused.Status)
+	assert.Equal(t, 2, paused.ConsecutiveFailures)
+	assert.Equal(t, "actions_suspended", paused.LastError)
+	last := notifier.frames[len(notifier.frames)-1]
+	assert.Equal(t, "scheduled_action_paused", last.Type)
+	assert.Equal(t, schedule.ID, last.Metadata["schedule_id"])
+
+	assert.Equal(t, 0, daily(), "paused schedules don't fire")
+	assert.Len(t, executor.ran, 4)
+
+	resumed, err := schedules.Resume("0xuser", schedule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ScheduleStatusActive, resumed.Status)
+	assert.Zero(t, resumed.ConsecutiveFailures)
+	assert.True(t, resumed.NextRunAt.After(clock.Now()))
+}
+
+// offlineNotifier stands in for a user with no chat connection
+type offlineNotifier struct{}
+
+func (offlineNotifier) SendToUser(userID string, message *ChatResponse) error {
+	return errors.New("user isn't connected")
+}
+
+func TestActionSchedulesPauseUndeliveredProposals(t *testing.T) {
+	schedules, _, clock := newTestActionSchedules()
+	schedules.SetNotifier(offlineNotifier{})
+	ctx := context.Background()
+
+	_, err := schedules.Create("0xuser", "stake my rewards", "stake", ScheduleSpec{Hour: 9, Weekday: -1}, false)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		clock.Advance(24 * time.Hour)
+		require.Equal(t, 1, schedules.RunDue(ctx))
+	}
+	assert.Equal(t, ScheduleStatusPaused, schedules.Schedules("0xuser")[0].Status)
+}
+
+func TestChatSchedulesRecurringActions(t *testing.T) {
+	submitter := &countingSubmitter{}
+	queue, audit, _ := newTestActionQueue(submitter)
+	engine := newTestChatEngine(t)
+	engine.SetActionAudit(audit)
+	engine.SetActionQueue(queue)
+	schedules, notifier, clock := newTestActionSchedules()
+	schedules.SetExecutor(engine)
+	engine.SetActionSchedules(schedules)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	// Without spending limits nothing runs automatically
+	response, err := engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_1", UserID: user, Message: "Swap 1 ETH for USDC every Friday at 9am automatically"})
+	require.NoError(t, err)
+	require.True(t, response.Success)
+	assert.Equal(t, "action_schedule", response.Type)
+	schedule := response.Data.(ActionSchedule)
+	assert.False(t, schedule.AutoExecute)
+	assert.Equal(t, "Swap 1 ETH for USDC", schedule.Action)
+	assert.Contains(t, response.Response, "none are set")
+	_, err = schedules.Delete(user, schedule.ID)
+	require.NoError(t, err)
+
+	limiter, limiterClock := newTestSpendingLimiter()
+	engine.SetSpendingLimiter(limiter)
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_2", UserID: user, Message: "Swap 2 ETH for USDC every day automatically"})
+	require.NoError(t, err)
+	require.True(t, response.Success)
+	assert.True(t, response.Data.(ActionSchedule).AutoExecute)
+	assert.Empty(t, submitter.submitted, "scheduling doesn't run the action")
+	_, ok := queue.Latest(user)
+	assert.False(t, ok)
+
+	// $400 a run against the $1,000 daily limit: the third run of the day fails
+	for i := 0; i < 2; i++ {
+		clock.Advance(24 * time.Hour)
+		require.Equal(t, 1, schedules.RunDue(ctx))
+		assert.Zero(t, schedules.Schedules(user)[0].ConsecutiveFailures)
+	}
+	require.Len(t, notifier.frames, 2)
+	assert.Equal(t, "action_result", notifier.frames[0].Type)
+	assert.True(t, notifier.frames[0].Success)
+
+	clock.Advance(24 * time.Hour)
+	require.Equal(t, 1, schedules.RunDue(ctx))
+	assert.Equal(t, 1, schedules.Schedules(user)[0].ConsecutiveFailures)
+	assert.Equal(t, "spending_limit", schedules.Schedules(user)[0].LastError)
+	clock.Advance(24 * time.Hour)
+	require.Equal(t, 1, schedules.RunDue(ctx))
+	assert.Equal(t, ScheduleStatusPaused, schedules.Schedules(user)[0].Status)
+
+	// The limits reset the next UTC day; resuming runs the action again
+	limiterClock.Advance(24 * time.Hour)
+	_, err = schedules.Resume(user, schedule.ID)
+	assert.ErrorIs(t, err, ErrActionScheduleNotFound, "the first schedule was deleted")
+	resumed, err := schedules.Resume(user, schedules.Schedules(user)[0].ID)
+	require.NoError(t, err)
+	clock.Advance(24 * time.Hour)
+	require.Equal(t, 1, schedules.RunDue(ctx))
+	assert.Zero(t, schedules.Schedules(user)[0].ConsecutiveFailures, resumed.ID)
+
+	// Other messages run once, as before
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_3", UserID: user, Message: "Swap 1 ETH for USDC"})
+	require.NoError(t, err)
+	assert.Equal(t, "action_result", response.Type)
+}
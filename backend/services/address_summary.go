@@ -0,0 +1,612 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// NativeSymbol is the ticker of the chain's native currency
+	NativeSymbol = "KAIA"
+
+	summaryTopTokens         = 5
+	summaryTopCounterparties = 5
+	summaryActivityWindow    = 30 * 24 * time.Hour
+)
+
+// erc20BalanceOfSelector is the 4-byte selector of balanceOf(address)
+var erc20BalanceOfSelector = common.FromHex("0x70a08231")
+
+// NativeBalanceReader reads an address's native currency balance in wei
+type NativeBalanceReader interface {
+	NativeBalance(ctx context.Context, address common.Address) (*big.Int, error)
+}
+
+// TokenBalanceReader reads an address's token holdings
+type TokenBalanceReader interface {
+	TokenBalances(ctx context.Context, address common.Address) ([]TokenHolding, error)
+}
+
+// AddressHistoryReader reads an address's indexed transaction history
+type AddressHistoryReader interface {
+	AddressHistory(ctx context.Context, address common.Address, since time.Time) (*AddressHistory, error)
+}
+
+// PriceSource provides USD prices by symbol
+type PriceSource interface {
+	GetPrice(ctx context.Context, symbol string) (float64, error)
+}
+
+// TrackedToken is an ERC-20 token whose balances are reported in summaries
+type TrackedToken struct {
+	Symbol   string         `json:"symbol"`
+	Address  common.Address `json:"address"`
+	Decimals int            `json:"decimals"`
+}
+
+// TokenHolding is an address's balance of a single token
+type TokenHolding struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name,omitempty"`
+	Contract string `json:"contract"`
+	// Balance is the raw balance scaled by Decimals
+	Balance  float64 `json:"balance"`
+	Decimals int     `json:"decimals"`
+	PriceUSD float64 `json:"price_usd"`
+	ValueUSD float64 `json:"value_usd"`
+	// PriceSample is the stored price sample PriceUSD came from
+	PriceSample *PriceSample `json:"price_sample,omitempty"`
+	// ValueDisplay is ValueUSD in the display currency, when one was asked for
+	ValueDisplay float64 `json:"value_display,omitempty"`
+	// LPPosition is set when the token is a liquidity pool's LP token
+	LPPosition *LPPosition `json:"lp_position,omitempty"`
+	LogoURL    string      `json:"logo_url,omitempty"`
+	// Verified is set when the token is on the configured token list
+	Verified bool `json:"verified"`
+	// Spam is set when an unverified token looks like an airdropped scam, for
+	// the SpamReasons
+	Spam        bool     `json:"spam"`
+	SpamReasons []string `json:"spam_reasons,omitempty"`
+}
+
+// AddressHistory is the indexed activity of an address
+type AddressHistory struct {
+	TxCount        int            `json:"tx_count"`
+	FirstSeen      time.Time      `json:"first_seen"`
+	Counterparties map[string]int `json:"counterparties"`
+	// Backfilled is false while the index hasn't caught up on the address
+	Backfilled bool `json:"backfilled"`
+}
+
+// Counterparty is an address interacted with and the number of interactions
+type Counterparty struct {
+	Address      string `json:"address"`
+	Interactions int    `json:"interactions"`
+}
+
+// AddressSummary combines balances and activity for an address
+type AddressSummary struct {
+	Address            string         `json:"address"`
+	NativeBalance      string         `json:"native_balance"`
+	NativeBalanceFloat float64        `json:"native_balance_float"`
+	NativeValueUSD     float64        `json:"native_value_usd"`
+	TopTokens          []TokenHolding `json:"top_tokens"`
+	// HiddenSpamTokens counts the holdings left out as spam
+	HiddenSpamTokens int     `json:"hidden_spam_tokens,omitempty"`
+	TotalValueUSD    float64 `json:"total_value_usd"`
+	TxCount30d       int     `json:"tx_count_30d"`
+	FirstSeen        APITime `json:"first_seen"`
+	// Deprecated: use FirstSeen
+	FirstSeenUnix     int64          `json:"first_seen_unix,omitempty"`
+	TopCounterparties []Counterparty `json:"top_counterparties"`
+	Partial           bool           `json:"partial"`
+	PartialReasons    []string       `json:"partial_reasons,omitempty"`
+	GeneratedAt       APITime        `json:"generated_at"`
+	// Deprecated: use GeneratedAt
+	GeneratedAtUnix int64 `json:"generated_at_unix"`
+
+	// NativePriceSample is the stored price sample NativeValueUSD came from
+	NativePriceSample *PriceSample `json:"native_price_sample,omitempty"`
+
+	// The values in the display currency, set with Conversion when one was
+	// asked for
+	NativeValueDisplay float64         `json:"native_value_display,omitempty"`
+	TotalValueDisplay  float64         `json:"total_value_display,omitempty"`
+	Conversion         *ConversionRate `json:"conversion,omitempty"`
+
+	// Wallets are the linked wallets of a summary across a user's wallets
+	Wallets []string `json:"wallets,omitempty"`
+
+	// ActiveApprovals counts the standing token approvals, and RiskyApprovals
+	// lists the medium and high risk ones with how to revoke them
+	ActiveApprovals int            `json:"active_approvals"`
+	RiskyApprovals  []ApprovalRisk `json:"risky_approvals,omitempty"`
+}
+
+// AddressSummarizer composes address summaries from balance, token, and history sources
+type AddressSummarizer struct {
+	native  NativeBalanceReader
+	tokens  TokenBalanceReader
+	history AddressHistoryReader
+	prices  HistoricalPriceSource
+	now     func() time.Time
+
+	// approvals flags risky standing token approvals when set
+	approvals *ApprovalScanner
+}
+
+// NewAddressSummarizer creates a summarizer over the given sources
+func NewAddressSummarizer(native NativeBalanceReader, tokens TokenBalanceReader, history AddressHistoryReader, prices HistoricalPriceSource) *AddressSummarizer {
+	return &AddressSummarizer{
+		native:  native,
+		tokens:  tokens,
+		history: history,
+		prices:  prices,
+		now:     utcNow,
+	}
+}
+
+// SetApprovalScanner adds the risky standing token approvals to summaries
+func (as *AddressSummarizer) SetApprovalScanner(approvals *ApprovalScanner) {
+	as.approvals = approvals
+}
+
+// SummaryOptions adjusts what an address summary includes
+type SummaryOptions struct {
+	// IncludeSpam keeps holdings flagged as spam in the top tokens
+	IncludeSpam bool
+}
+
+// Summarize builds the summary for an address without spam holdings
+func (as *AddressSummarizer) Summarize(ctx context.Context, address common.Address) (*AddressSummary, error) {
+	return as.SummarizeWith(ctx, address, SummaryOptions{})
+}
+
+// SummarizeWith builds the summary for an address. Sub-source failures don't
+// fail the summary; they mark it partial with a reason instead. Spam holdings
+// never count towards the total value.
+func (as *AddressSummarizer) SummarizeWith(ctx context.Context, address common.Address, options SummaryOptions) (*AddressSummary, error) {
+	summary, _, _, err := as.summarize(ctx, address, options)
+	return summary, err
+}
+
+// summarize builds the summary of an address, also returning the spam-free
+// holdings and the history it was built from, which are nil when they were
+// unavailable
+func (as *AddressSummarizer) summarize(ctx context.Context, address common.Address, options SummaryOptions) (*AddressSummary, []TokenHolding, *AddressHistory, error) {
+	now := as.now()
+	summary := &AddressSummary{
+		Address:           address.Hex(),
+		NativeBalance:     "0",
+		TopTokens:         []TokenHolding{},
+		TopCounterparties: []Counterparty{},
+		GeneratedAt:       NewAPITime(now),
+		GeneratedAtUnix:   now.Unix(),
+	}
+
+	var wg sync.WaitGroup
+	var nativeBalance *big.Int
+	var nativeErr, tokensErr, historyErr error
+	var holdings []TokenHolding
+	var history *AddressHistory
+	var approvals *ApprovalReport
+	var approvalsErr error
+
+	if as.approvals != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			approvals, approvalsErr = as.approvals.Assess(ctx, address)
+		}()
+	}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		nativeBalance, nativeErr = as.native.NativeBalance(ctx, address)
+	}()
+	go func() {
+		defer wg.Done()
+		holdings, tokensErr = as.tokens.TokenBalances(ctx, address)
+	}()
+	go func() {
+		defer wg.Done()
+		history, historyErr = as.history.AddressHistory(ctx, address, now.Add(-summaryActivityWindow))
+	}()
+	wg.Wait()
+
+	failures := 0
+	if nativeErr != nil {
+		failures++
+		summary.markPartial(fmt.Sprintf("native balance unavailable: %v", nativeErr))
+	} else {
+		summary.NativeBalance = nativeBalance.String()
+		summary.NativeBalanceFloat = weiToFloat(nativeBalance, 18)
+		sample, err := samplePrice(ctx, as.prices, NativeSymbol, now)
+		if err != nil {
+			summary.markPartial(fmt.Sprintf("%s price unavailable: %v", NativeSymbol, err))
+		} else {
+			summary.NativeValueUSD = summary.NativeBalanceFloat * sample.PriceUSD
+			summary.NativePriceSample = &sample
+		}
+	}
+
+	if tokensErr != nil {
+		failures++
+		summary.markPartial(fmt.Sprintf("token balances unavailable: %v", tokensErr))
+	} else {
+		if !options.IncludeSpam {
+			holdings, summary.HiddenSpamTokens = FilterSpam(holdings)
+		}
+		summary.TopTokens = topHoldings(holdings)
+	}
+
+	summary.TotalValueUSD = summary.NativeValueUSD
+	for _, holding := range summary.TopTokens {
+		if !holding.Spam {
+			summary.TotalValueUSD += holding.ValueUSD
+		}
+	}
+
+	if historyErr != nil {
+		failures++
+		summary.markPartial(fmt.Sprintf("transaction history unavailable: %v", historyErr))
+	} else {
+		if !history.Backfilled {
+			summary.markPartial("transaction history index has not finished backfilling this address")
+		}
+		summary.TxCount30d = history.TxCount
+		if !history.FirstSeen.IsZero() {
+			summary.FirstSeen = NewAPITime(history.FirstSeen)
+			summary.FirstSeenUnix = history.FirstSeen.Unix()
+		}
+		summary.TopCounterparties = topCounterparties(history.Counterparties, summaryTopCounterparties)
+	}
+
+	if approvalsErr != nil {
+		summary.markPartial(fmt.Sprintf("token approvals unavailable: %v", approvalsErr))
+	} else if approvals != nil {
+		summary.ActiveApprovals = approvals.Active
+		summary.RiskyApprovals = approvals.Approvals
+		for _, reason := range approvals.PartialReasons {
+			summary.markPartial("token approvals: " + reason)
+		}
+	}
+
+	if failures == 3 {
+		return nil, nil, nil, fmt.Errorf("failed to summarize %s: all data sources unavailable", address.Hex())
+	}
+	if historyErr != nil {
+		history = nil
+	}
+
+	return summary, holdings, history, nil
+}
+
+// topHoldings returns the most valuable holdings, sorted
+func topHoldings(holdings []TokenHolding) []TokenHolding {
+	holdings = append([]TokenHolding(nil), holdings...)
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].ValueUSD != holdings[j].ValueUSD {
+			return holdings[i].ValueUSD > holdings[j].ValueUSD
+		}
+		return holdings[i].Symbol < holdings[j].Symbol
+	})
+	if len(holdings) > summaryTopTokens {
+		holdings = holdings[:summaryTopTokens]
+	}
+	return holdings
+}
+
+// SummarizeWallets builds one summary of a user's wallets, under the first.
+// Balances are added up, and transactions between the wallets are counted
+// once and don't make the wallets each other's counterparties. A wallet that
+// can't be summarized marks the summary partial; it fails only when none can.
+func (as *AddressSummarizer) SummarizeWallets(ctx context.Context, wallets []common.Address, options SummaryOptions) (*AddressSummary, error) {
+	if len(wallets) == 1 {
+		return as.SummarizeWith(ctx, wallets[0], options)
+	}
+
+	now := as.now()
+	combined := &AddressSummary{
+		Address:           wallets[0].Hex(),
+		TopTokens:         []TokenHolding{},
+		TopCounterparties: []Counterparty{},
+		GeneratedAt:       NewAPITime(now),
+		GeneratedAtUnix:   now.Unix(),
+	}
+	for _, wallet := range wallets {
+		combined.Wallets = append(combined.Wallets, wallet.Hex())
+	}
+
+	linked := newWalletSet(wallets)
+	nativeBalance := new(big.Int)
+	holdings := make(map[string]*TokenHolding)
+	var contracts []string
+	counterparties := make(map[string]int)
+	summarized := 0
+	for _, wallet := range wallets {
+		summary, walletHoldings, history, err := as.summarize(ctx, wallet, options)
+		if err != nil {
+			combined.markPartial(fmt.Sprintf("%s unavailable: %v", wallet.Hex(), err))
+			continue
+		}
+		summarized++
+		for _, reason := range summary.PartialReasons {
+			combined.markPartial(fmt.Sprintf("%s: %s", wallet.Hex(), reason))
+		}
+
+		if balance, ok := new(big.Int).SetString(summary.NativeBalance, 10); ok {
+			nativeBalance.Add(nativeBalance, balance)
+		}
+		combined.NativeBalanceFloat += summary.NativeBalanceFloat
+		combined.NativeValueUSD += summary.NativeValueUSD
+		if combined.NativePriceSample == nil {
+			combined.NativePriceSample = summary.NativePriceSample
+		}
+		combined.HiddenSpamTokens += summary.HiddenSpamTokens
+		combined.ActiveApprovals += summary.ActiveApprovals
+		combined.RiskyApprovals = append(combined.RiskyApprovals, summary.RiskyApprovals...)
+		for _, holding := range walletHoldings {
+			merged, ok := holdings[holding.Contract]
+			if !ok {
+				copied := holding
+				holdings[holding.Contract] = &copied
+				contracts = append(contracts, holding.Contract)
+				continue
+			}
+			merged.Balance += holding.Balance
+			merged.ValueUSD += holding.ValueUSD
+		}
+
+		if history == nil {
+			continue
+		}
+		combined.TxCount30d += history.TxCount
+		for address, interactions := range history.Counterparties {
+			if !linked.internal(wallet.Hex(), address) {
+				counterparties[address] += interactions
+				continue
+			}
+			// Both wallets count a transaction between them; keep it once
+			if strings.ToLower(wallet.Hex()) < strings.ToLower(address) {
+				combined.TxCount30d -= interactions
+			}
+		}
+		if !history.FirstSeen.IsZero() && (combined.FirstSeen.IsZero() || history.FirstSeen.Before(combined.FirstSeen.Time)) {
+			combined.FirstSeen = NewAPITime(history.FirstSeen)
+			combined.FirstSeenUnix = history.FirstSeen.Unix()
+		}
+	}
+	if summarized == 0 {
+		return nil, fmt.Errorf("failed to summarize the %d wallets: all data sources unavailable", len(wallets))
+	}
+
+	combined.NativeBalance = nativeBalance.String()
+	merged := make([]TokenHolding, 0, len(holdings))
+	for _, contract := range contracts {
+		merged = append(merged, *holdings[contract])
+	}
+	combined.TopTokens = topHoldings(merged)
+	combined.TotalValueUSD = combined.NativeValueUSD
+	for _, holding := range combined.TopTokens {
+		if !holding.Spam {
+			combined.TotalValueUSD += holding.ValueUSD
+		}
+	}
+	combined.TopCounterparties = topCounterparties(counterparties, summaryTopCounterparties)
+	sort.SliceStable(combined.RiskyApprovals, func(i, j int) bool {
+		return combined.RiskyApprovals[i].Score > combined.RiskyApprovals[j].Score
+	})
+	return combined, nil
+}
+
+func (s *AddressSummary) markPartial(reason string) {
+	s.Partial = true
+	s.PartialReasons = append(s.PartialReasons, reason)
+}
+
+// topCounterparties returns the n most frequent counterparties
+func topCounterparties(counts map[string]int, n int) []Counterparty {
+	counterparties := make([]Counterparty, 0, len(counts))
+	for address, interactions := range counts {
+		counterparties = append(counterparties, Counterparty{Address: address, Interactions: interactions})
+	}
+	sort.Slice(counterparties, func(i, j int) bool {
+		if counterparties[i].Interactions != counterparties[j].Interactions {
+			return counterparties[i].Interactions > counterparties[j].Interactions
+		}
+		return counterparties[i].Address < counterparties[j].Address
+	})
+	if len(counterparties) > n {
+		counterparties = counterparties[:n]
+	}
+	return counterparties
+}
+
+// weiToFloat converts an integer amount with the given decimals to a float
+func weiToFloat(amount *big.Int, decimals int) float64 {
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), scale).Float64()
+	return value
+}
+
+// ChainBalanceReader reads native balances from a node
+type ChainBalanceReader struct {
+	client ChainClient
+}
+
+// NewChainBalanceReader creates a native balance reader backed by the node client
+func NewChainBalanceReader(client ChainClient) *ChainBalanceReader {
+	return &ChainBalanceReader{client: client}
+}
+
+// NativeBalance returns the latest native balance of the address
+func (cbr *ChainBalanceReader) NativeBalance(ctx context.Context, address common.Address) (*big.Int, error) {
+	return cbr.client.BalanceAt(ctx, address, nil)
+}
+
+// ERC20BalanceReader reads balances of a fixed set of tracked tokens via balanceOf calls
+type ERC20BalanceReader struct {
+	caller    ethereum.ContractCaller
+	multicall *Multicall
+	tokens    []TrackedToken
+	prices    HistoricalPriceSource
+	pools     *LiquidityPoolReader
+	metadata  *TokenMetadataService
+	now       func() time.Time
+}
+
+// NewERC20BalanceReader creates a token balance reader for the tracked tokens
+func NewERC20BalanceReader(caller ethereum.ContractCaller, tokens []TrackedToken, prices HistoricalPriceSource) *ERC20BalanceReader {
+	return &ERC20BalanceReader{
+		caller:    caller,
+		multicall: NewMulticall(caller, common.Address{}),
+		tokens:    tokens,
+		prices:    prices,
+		now:       utcNow,
+	}
+}
+
+// SetMulticall reads the balances of all tracked tokens in one batch
+func (r *ERC20BalanceReader) SetMulticall(multicall *Multicall) {
+	r.multicall = multicall
+}
+
+// SetLiquidityPools values tracked LP tokens by their share of the pool's
+// reserves instead of by symbol price
+func (r *ERC20BalanceReader) SetLiquidityPools(pools *LiquidityPoolReader) {
+	r.pools = pools
+}
+
+// SetTokenMetadata scales balances by the decimals the contracts report and
+// enriches holdings with names, logos, and spam flags
+func (r *ERC20BalanceReader) SetTokenMetadata(metadata *TokenMetadataService) {
+	r.metadata = metadata
+}
+
+// WithCaller returns a copy of the reader calling the token contracts
+// through the caller, such as an archive node for balances at old blocks
+func (r *ERC20BalanceReader) WithCaller(caller ethereum.ContractCaller) *ERC20BalanceReader {
+	copied := *r
+	copied.caller = caller
+	copied.multicall = NewMulticall(caller, r.multicall.address)
+	return &copied
+}
+
+// TokenBalances returns the non-zero tracked token balances of the address,
+// priced now
+func (r *ERC20BalanceReader) TokenBalances(ctx context.Context, address common.Address) ([]TokenHolding, error) {
+	return r.TokenBalancesAt(ctx, address, nil, r.now())
+}
+
+// TokenBalancesAt returns the non-zero tracked token balances of the address
+// at a block, or the latest for nil, priced at the given time. LP tokens are
+// only valued by their pool's reserves at the latest block, since the
+// reserves are read there.
+func (r *ERC20BalanceReader) TokenBalancesAt(ctx context.Context, address common.Address, block *big.Int, now time.Time) ([]TokenHolding, error) {
+	holdings := make([]TokenHolding, 0, len(r.tokens))
+
+	batch := r.multicall.Batch()
+	data := append(append([]byte{}, erc20BalanceOfSelector...), common.LeftPadBytes(address.Bytes(), 32)...)
+	balances := make([]*CallFuture, len(r.tokens))
+	for i, token := range r.tokens {
+		balances[i] = batch.Call(token.Address, data)
+	}
+	batch.Execute(ctx, block)
+
+	for i, token := range r.tokens {
+		result, err := balances[i].Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s balance: %w", token.Symbol, err)
+		}
+
+		balance := new(big.Int).SetBytes(result)
+		if balance.Sign() == 0 {
+			continue
+		}
+
+		decimals := token.Decimals
+		if r.metadata != nil {
+			if metadata, err := r.metadata.Metadata(ctx, token.Address); err == nil {
+				decimals = metadata.Decimals
+			}
+		}
+		holding := TokenHolding{
+			Symbol:   token.Symbol,
+			Contract: token.Address.Hex(),
+			Balance:  weiToFloat(balance, decimals),
+			Decimals: decimals,
+		}
+		var position *LPPosition
+		if block == nil {
+			position = r.lpPosition(ctx, token, balance)
+		}
+		if position != nil {
+			holding.LPPosition = position
+			holding.ValueUSD = position.ValueUSD
+			if holding.Balance > 0 {
+				holding.PriceUSD = position.ValueUSD / holding.Balance
+			}
+		} else if sample, err := samplePrice(ctx, r.prices, token.Symbol, now); err == nil {
+			holding.PriceUSD = sample.PriceUSD
+			holding.ValueUSD = holding.Balance * sample.PriceUSD
+			holding.PriceSample = &sample
+		}
+		if r.metadata != nil {
+			r.metadata.Enrich(ctx, address, &holding, balance)
+		}
+		holdings = append(holdings, holding)
+	}
+
+	return holdings, nil
+}
+
+// lpPosition values the balance as an LP position, or returns nil when the
+// token isn't a pool or its reserves can't be read
+func (r *ERC20BalanceReader) lpPosition(ctx context.Context, token TrackedToken, balance *big.Int) *LPPosition {
+	if r.pools == nil {
+		return nil
+	}
+	pool, err := r.pools.Pool(ctx, token.Address)
+	if err != nil {
+		return nil
+	}
+	position, err := r.pools.Value(ctx, pool, balance)
+	if err != nil {
+		return nil
+	}
+	return position
+}
+
+// ParseTrackedTokens parses "SYMBOL:0xaddress:decimals" entries separated by commas
+func ParseTrackedTokens(spec string) ([]TrackedToken, error) {
+	var tokens []TrackedToken
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || !common.IsHexAddress(parts[1]) {
+			return nil, fmt.Errorf("invalid tracked token %q, expected SYMBOL:0xaddress:decimals", entry)
+		}
+		var decimals int
+		if _, err := fmt.Sscanf(parts[2], "%d", &decimals); err != nil || decimals < 0 || decimals > 36 {
+			return nil, fmt.Errorf("invalid decimals for tracked token %q", entry)
+		}
+		tokens = append(tokens, TrackedToken{
+			Symbol:   strings.ToUpper(parts[0]),
+			Address:  common.HexToAddress(parts[1]),
+			Decimals: decimals,
+		})
+	}
+	return tokens, nil
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var summaryAddress = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+type fakeNativeBalances struct {
+	balance *big.Int
+	err     error
+}
+
+func (f fakeNativeBalances) NativeBalance(ctx context.Context, address common.Address) (*big.Int, error) {
+	return f.balance, f.err
+}
+
+type fakeTokenBalances struct {
+	holdings []TokenHolding
+	err      error
+}
+
+func (f fakeTokenBalances) TokenBalances(ctx context.Context, address common.Address) ([]TokenHolding, error) {
+	return f.holdings, f.err
+}
+
+type fakePrices map[string]float64
+
+func (f fakePrices) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	price, ok := f[symbol]
+	if !ok {
+		return 0, errors.New("unknown symbol")
+	}
+	return price, nil
+}
+
+// PriceAt prices a symbol the same at every point in time
+func (f fakePrices) PriceAt(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	return f.GetPrice(ctx, symbol)
+}
+
+func newTestSummarizer(native NativeBalanceReader, tokens TokenBalanceReader, history AddressHistoryReader, now time.Time) *AddressSummarizer {
+	summarizer := NewAddressSummarizer(native, tokens, history, fakePrices{NativeSymbol: 0.2})
+	summarizer.now = func() time.Time { return now }
+	return summarizer
+}
+
+func TestAddressSummaryComposesSources(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	other := "0x00000000000000000000000000000000000000bb"
+	third := "0x00000000000000000000000000000000000000cc"
+
+	index := NewTransactionIndex()
+	index.Add(IndexedTransaction{Hash: "0x1", From: summaryAddress.Hex(), To: other, Timestamp: now.AddDate(0, -6, 0)})
+	index.Add(IndexedTransaction{Hash: "0x2", From: summaryAddress.Hex(), To: other, Timestamp: now.AddDate(0, 0, -3)})
+	index.Add(IndexedTransaction{Hash: "0x3", From: other, To: summaryAddress.Hex(), Timestamp: now.AddDate(0, 0, -2)})
+	index.Add(IndexedTransaction{Hash: "0x4", From: summaryAddress.Hex(), To: third, Timestamp: now.AddDate(0, 0, -1)})
+	index.MarkBackfilled(summaryAddress)
+
+	holdings := make([]TokenHolding, 0, 7)
+	for i, symbol := range []string{"A", "B", "C", "D", "E", "F", "G"} {
+		holdings = append(holdings, TokenHolding{Symbol: symbol, Balance: 1, ValueUSD: float64(i)})
+	}
+
+	oneKaia := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	summarizer := newTestSummarizer(
+		fakeNativeBalances{balance: new(big.Int).Mul(oneKaia, big.NewInt(50))},
+		fakeTokenBalances{holdings: holdings},
+		index,
+		now,
+	)
+
+	summary, err := summarizer.Summarize(context.Background(), summaryAddress)
+	require.NoError(t, err)
+
+	assert.False(t, summary.Partial)
+	assert.Empty(t, summary.PartialReasons)
+	assert.Equal(t, "50000000000000000000", summary.NativeBalance)
+	assert.InDelta(t, 10.0, summary.NativeValueUSD, 1e-9)
+
+	require.Len(t, summary.TopTokens, summaryTopTokens)
+	assert.Equal(t, "G", summary.TopTokens[0].Symbol)
+	assert.Equal(t, "C", summary.TopTokens[4].Symbol)
+	assert.InDelta(t, 10.0+6+5+4+3+2, summary.TotalValueUSD, 1e-9)
+
+	assert.Equal(t, 3, summary.TxCount30d)
+	assert.Equal(t, NewAPITime(now.AddDate(0, -6, 0)), summary.FirstSeen)
+	assert.Equal(t, now.AddDate(0, -6, 0).Unix(), summary.FirstSeenUnix)
+	assert.Equal(t, []Counterparty{{Address: other, Interactions: 2}, {Address: third, Interactions: 1}}, summary.TopCounterparties)
+}
+
+func TestAddressSummaryPartialWhenHistoryNotBackfilled(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	index := NewTransactionIndex()
+	index.Add(IndexedTransaction{Hash: "0x1", From: summaryAddress.Hex(), To: "0x00000000000000000000000000000000000000bb", Timestamp: now.Add(-time.Hour)})
+
+	summarizer := newTestSummarizer(fakeNativeBalances{balance: big.NewInt(0)}, fakeTokenBalances{}, index, now)
+
+	summary, err := summarizer.Summarize(context.Background(), summaryAddress)
+	require.NoError(t, err)
+
+	assert.True(t, summary.Partial)
+	require.Len(t, summary.PartialReasons, 1)
+	assert.Contains(t, summary.PartialReasons[0], "backfilling")
+	// What has been indexed is still reported
+	assert.Equal(t, 1, summary.TxCount30d)
+}
+
+func TestAddressSummaryDegradesOnSourceFailure(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	index := NewTransactionIndex()
+	index.MarkBackfilled(summaryAddress)
+
+	summarizer := newTestSummarizer(
+		fakeNativeBalances{balance: big.NewInt(1)},
+		fakeTokenBalances{err: errors.New("rpc timeout")},
+		index,
+		now,
+	)
+
+	summary, err := summarizer.Summarize(context.Background(), summaryAddress)
+	require.NoError(t, err)
+	assert.True(t, summary.Partial)
+	require.Len(t, summary.PartialReasons, 1)
+	assert.Contains(t, summary.PartialReasons[0], "token balances unavailable")
+	assert.Empty(t, summary.TopTokens)
+	assert.Equal(t, "1", summary.NativeBalance)
+
+	failing := &failingHistory{}
+	summarizer = newTestSummarizer(
+		fakeNativeBalances{err: errors.New("node down")},
+		fakeTokenBalances{err: errors.New("node down")},
+		failing,
+		now,
+	)
+	_, err = summarizer.Summarize(context.Background(), summaryAddress)
+	assert.Error(t, err)
+}
+
+type failingHistory struct{}
+
+func (failingHistory) AddressHistory(ctx context.Context, address common.Address, since time.Time) (*AddressHistory, error) {
+	return nil, errors.New("index unavailable")
+}
+
+func TestChatPortfolioUsesAddressSummary(t *testing.T) {
+	engine := newTestChatEngine(t)
+	index := NewTransactionIndex()
+	engine.SetAddressSummarizer(newTestSummarizer(
+		fakeNativeBalances{balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)},
+		fakeTokenBalances{},
+		index,
+		time.Now(),
+	))
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+		ID:      "m1",
+		UserID:  "anonymous",
+		Message: "Show the portfolio of " + summaryAddress.Hex(),
+	})
+	require.NoError(t, err)
+
+	data, ok := response.Data.(map[string]interface{})
+	require.True(t, ok)
+	summary, ok := data["summary"].(*AddressSummary)
+	require.True(t, ok)
+	assert.Equal(t, summaryAddress.Hex(), summary.Address)
+	assert.True(t, summary.Partial)
+	assert.Contains(t, response.Response, "KAIA Balance: 1 (")
+	assert.Contains(t, response.Response, "may be incomplete")
+
+	// Without an address in the message or a wallet sender there is nothing to summarize
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{
+		ID:      "m2",
+		UserID:  "anonymous",
+		Message: "Analyze my portfolio",
+	})
+	require.NoError(t, err)
+	_, ok = response.Data.(map[string]interface{})["summary"]
+	assert.False(t, ok)
+}
+
+func TestParseTrackedTokens(t *testing.T) {
+	tokens, err := ParseTrackedTokens("usdt:0x00000000000000000000000000000000000000aa:6, WKAIA:0x00000000000000000000000000000000000000bb:18")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "USDT", tokens[0].Symbol)
+	assert.Equal(t, 6, tokens[0].Decimals)
+	assert.Equal(t, summaryAddress, tokens[0].Address)
+
+	tokens, err = ParseTrackedTokens("")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	_, err = ParseTrackedTokens("USDT:nothex:6")
+	assert.Error(t, err)
+	_, err = ParseTrackedTokens("USDT:0x00000000000000000000000000000000000000aa:x")
+	assert.Error(t, err)
+}
@@ -4,19 +4,37 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/panjf2000/ants/v2"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 // AnalyticsEngine handles analytics computations and data processing
 type AnalyticsEngine struct {
-	ethClient *ethclient.Client
-	pool      *ants.Pool
+	ethClient ChainClient
+	pool      *AdaptivePool
 	logger    *log.Logger
 	mu        sync.RWMutex
+
+	governance *GovernanceTracker
+	yields     *YieldHistory
+	trading    *TradingProfiles
+	prices     PriceObserver
+	depths     *PoolDepthReader
+	protocols  *ProtocolRegistry
+	holders    *HolderAnalyzer
+	apy        *APYChecker
+	results    *ResultStore
+	replay     *ReplayStore
+	rebalancer *Rebalancer
+	panics     *PanicGuard
+	now        func() time.Time
+
+	// approvals flags risky standing token approvals in risk assessments
+	approvals *ApprovalScanner
 }
 
 // YieldOpportunity represents a yield farming opportunity
@@ -27,7 +45,26 @@ type YieldOpportunity struct {
 	TVL          float64 `json:"tvl"`
 	Risk         float64 `json:"risk"`
 	Opportunity  float64 `json:"opportunity_score"`
-	LastUpdated  int64   `json:"last_updated"`
+	LastUpdated  APITime `json:"last_updated"`
+	// Deprecated: use LastUpdated
+	LastUpdatedUnix int64 `json:"last_updated_unix"`
+
+	// Trend over the last 7 days of yield scans
+	APY7dAvg      float64      `json:"apy_7d_avg"`
+	APYVolatility float64      `json:"apy_volatility"`
+	TVLTrend      float64      `json:"tvl_trend"`
+	Volatile      bool         `json:"volatile"`
+	History       *YieldSeries `json:"history,omitempty"`
+
+	// RiskScore is the 0-100 risk score that Risk is scaled down from, and
+	// RiskBreakdown the factors behind it
+	RiskScore     float64         `json:"risk_score"`
+	RiskBreakdown []RiskComponent `json:"risk_breakdown,omitempty"`
+
+	// APYChanged is set when the live reward rate read before serving moved
+	// away from the scanned APY, and APYStale when it couldn't be read in time
+	APYChanged *APYChange `json:"apy_changed,omitempty"`
+	APYStale   bool       `json:"apy_stale,omitempty"`
 }
 
 // TradingSuggestion represents a trading suggestion based on user history
@@ -39,18 +76,24 @@ type TradingSuggestion struct {
 	Reasoning    string  `json:"reasoning"`
 	RiskLevel    string  `json:"risk_level"`
 	ExpectedReturn float64 `json:"expected_return"`
+	// Slippage is the tolerance to trade with, in percent
+	Slippage float64 `json:"slippage,omitempty"`
+	// MaxSizeAt1PctImpact is the largest amount of the asset a trade can
+	// move with 1% price impact in its deepest pool, when one is known
+	MaxSizeAt1PctImpact float64 `json:"max_size_at_1pct_impact,omitempty"`
 }
 
 // GovernanceSentiment represents sentiment analysis of governance proposals
 type GovernanceSentiment struct {
-	ProposalID   string  `json:"proposal_id"`
-	Title        string  `json:"title"`
-	Sentiment    string  `json:"sentiment"`
-	Confidence   float64 `json:"confidence"`
-	VoteCount    int     `json:"vote_count"`
-	ForVotes     int     `json:"for_votes"`
-	AgainstVotes int     `json:"against_votes"`
-	AbstainVotes int     `json:"abstain_votes"`
+	ProposalID   string             `json:"proposal_id"`
+	Title        string             `json:"title"`
+	Sentiment    string             `json:"sentiment"`
+	Confidence   float64            `json:"confidence"`
+	VoteCount    int                `json:"vote_count"`
+	ForVotes     int                `json:"for_votes"`
+	AgainstVotes int                `json:"against_votes"`
+	AbstainVotes int                `json:"abstain_votes"`
+	Prediction   *OutcomePrediction `json:"prediction,omitempty"`
 }
 
 // AnalyticsResult represents the result of an analytics computation
@@ -58,27 +101,146 @@ type AnalyticsResult struct {
 	TaskID       uint64      `json:"task_id"`
 	Type         string      `json:"type"`
 	Data         interface{} `json:"data"`
-	Timestamp    int64       `json:"timestamp"`
+	Timestamp    APITime     `json:"timestamp"`
+	// Deprecated: use Timestamp
+	TimestampUnix int64      `json:"timestamp_unix"`
 	ProcessingTime int64     `json:"processing_time"`
-	Confidence   float64     `json:"confidence"`
+	// Confidence is the DataQuality score
+	Confidence   float64      `json:"confidence"`
+	DataQuality  *DataQuality `json:"data_quality"`
+	// Conversion is set when amounts were converted into a display currency
+	Conversion *ConversionRate `json:"conversion,omitempty"`
+	// ResultHash is the keccak256 hash of the result's canonical JSON as
+	// computed, before any sorting or conversion for display
+	ResultHash string `json:"result_hash,omitempty"`
 }
 
 // NewAnalyticsEngine creates a new analytics engine instance
-func NewAnalyticsEngine(ethClient *ethclient.Client) (*AnalyticsEngine, error) {
-	pool, err := ants.NewPool(10, ants.WithPreAlloc(true))
+func NewAnalyticsEngine(ethClient ChainClient) (*AnalyticsEngine, error) {
+	pool, err := NewAdaptivePool("analytics", DefaultAnalyticsPoolBounds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create worker pool: %w", err)
+		return nil, err
 	}
 
 	return &AnalyticsEngine{
-		ethClient: ethClient,
-		pool:      pool,
-		logger:    log.New(log.Writer(), "[AnalyticsEngine] ", log.LstdFlags),
+		ethClient:  ethClient,
+		pool:       pool,
+		logger:     log.New(log.Writer(), "[AnalyticsEngine] ", log.LstdFlags),
+		governance: NewGovernanceTracker(DefaultOutcomeModel()),
+		yields:     NewYieldHistory(),
+		protocols:  DefaultProtocolRegistry(),
+		results:    NewResultStore(DefaultResultStoreSize),
+		replay:     NewReplayStore(),
+		panics:     NewPanicGuard(),
+		now:        utcNow,
 	}, nil
 }
 
-// ProcessAnalyticsTask processes an analytics task and returns results
+// Pool returns the worker pool analytics tasks and chat batches run on
+func (ae *AnalyticsEngine) Pool() *AdaptivePool {
+	return ae.pool
+}
+
+// Governance returns the tracker holding ingested proposals, votes, and outcome predictions
+func (ae *AnalyticsEngine) Governance() *GovernanceTracker {
+	return ae.governance
+}
+
+// Results returns the store of computed results, keyed by their hashes
+func (ae *AnalyticsEngine) Results() *ResultStore {
+	return ae.results
+}
+
+// ReplayInputs returns the store of the inputs results were computed from
+func (ae *AnalyticsEngine) ReplayInputs() *ReplayStore {
+	return ae.replay
+}
+
+// YieldHistory returns the APY and TVL history recorded by yield scans
+func (ae *AnalyticsEngine) YieldHistory() *YieldHistory {
+	return ae.yields
+}
+
+// SetPanicGuard reports the panics of pool tasks through the guard
+func (ae *AnalyticsEngine) SetPanicGuard(panics *PanicGuard) {
+	ae.panics = panics
+}
+
+// SetTradingProfiles bases trading suggestions on the user's swap history
+func (ae *AnalyticsEngine) SetTradingProfiles(profiles *TradingProfiles) {
+	ae.trading = profiles
+}
+
+// SetPriceObserver scores results by the freshness and agreement of the
+// prices of the assets they cover
+func (ae *AnalyticsEngine) SetPriceObserver(prices PriceObserver) {
+	ae.prices = prices
+}
+
+// SetPoolDepths caps trading suggestions to the sizes their pools can take
+// within the user's slippage tolerance
+func (ae *AnalyticsEngine) SetPoolDepths(depths *PoolDepthReader) {
+	ae.depths = depths
+}
+
+// Protocols returns the registry of protocol facts yield risk is scored with
+func (ae *AnalyticsEngine) Protocols() *ProtocolRegistry {
+	return ae.protocols
+}
+
+// SetProtocolRegistry replaces the protocol facts and factor weights yield
+// risk is scored with
+func (ae *AnalyticsEngine) SetProtocolRegistry(protocols *ProtocolRegistry) {
+	ae.protocols = protocols
+}
+
+// SetRebalancer plans and costs the swaps of portfolio optimizations given
+// a portfolio value
+func (ae *AnalyticsEngine) SetRebalancer(rebalancer *Rebalancer) {
+	ae.rebalancer = rebalancer
+}
+
+// SetHolderAnalyzer scores the depositor concentration of pools whose LP
+// token the protocol registry knows
+func (ae *AnalyticsEngine) SetHolderAnalyzer(holders *HolderAnalyzer) {
+	ae.holders = holders
+}
+
+// SetAPYChecker re-reads the reward rates of pools the protocol registry
+// knows a reward contract of before yield opportunities are served
+func (ae *AnalyticsEngine) SetAPYChecker(checker *APYChecker) {
+	ae.apy = checker
+}
+
+// SetApprovalScanner flags the risky standing token approvals of the
+// address a risk assessment is asked for
+func (ae *AnalyticsEngine) SetApprovalScanner(approvals *ApprovalScanner) {
+	ae.approvals = approvals
+}
+
+// ProcessAnalyticsTask processes an analytics task and returns results. The
+// inputs it reads are recorded so the result can be replayed.
 func (ae *AnalyticsEngine) ProcessAnalyticsTask(ctx context.Context, taskType string, parameters map[string]interface{}) (*AnalyticsResult, error) {
+	ctx, run := withReplayRecording(ctx, ae.replay)
+	analyticsResult, err := ae.runTask(ctx, taskType, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := ae.results.Put(analyticsResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store analytics result: %w", err)
+	}
+	if err := ae.replay.save(hash, taskType, parameters, analyticsResult, run); err != nil {
+		return nil, fmt.Errorf("failed to record analytics inputs: %w", err)
+	}
+	analyticsResult.ResultHash = hash
+
+	return analyticsResult, nil
+}
+
+// runTask computes an analytics task, reading its inputs through ctx
+func (ae *AnalyticsEngine) runTask(ctx context.Context, taskType string, parameters map[string]interface{}) (*AnalyticsResult, error) {
 	startTime := time.Now()
 
 	var result interface{}
@@ -104,19 +266,85 @@ func (ae *AnalyticsEngine) ProcessAnalyticsTask(ctx context.Context, taskType st
 	}
 
 	processingTime := time.Since(startTime).Milliseconds()
+	now := time.Now()
+	quality, err := pinInput(ctx, "data_quality", func() (*DataQuality, error) {
+		return ae.assessDataQuality(result), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess data quality: %w", err)
+	}
 
-	return &AnalyticsResult{
-		TaskID:        uint64(time.Now().Unix()),
+	analyticsResult := &AnalyticsResult{
+		TaskID:        uint64(now.Unix()),
 		Type:          taskType,
 		Data:          result,
-		Timestamp:     time.Now().Unix(),
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
 		ProcessingTime: processingTime,
-		Confidence:    ae.calculateConfidence(result),
-	}, nil
+		Confidence:    quality.Score,
+		DataQuality:   quality,
+	}
+	return analyticsResult, nil
 }
 
-// analyzeYieldOpportunities identifies the best yield opportunities across protocols
+// analyzeYieldOpportunities identifies the best yield opportunities across
+// protocols. Each scan is recorded in the yield history; with a history
+// parameter of 7d or 30d the opportunities include their downsampled series.
 func (ae *AnalyticsEngine) analyzeYieldOpportunities(ctx context.Context, params map[string]interface{}) ([]YieldOpportunity, error) {
+	window, _ := params["history"].(string)
+	if _, ok := YieldWindows[window]; window != "" && !ok {
+		return nil, fmt.Errorf("unsupported history window %q", window)
+	}
+
+	// The scan, and the history and risk facts read for each pool, are
+	// pinned so the result can be replayed
+	now := time.Now()
+	opportunities, err := pinInput(ctx, "yield_scan", func() ([]YieldOpportunity, error) {
+		return ae.scanYields(ctx, now), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range opportunities {
+		opportunity := &opportunities[i]
+		pool := opportunity.Protocol + " " + opportunity.AssetPair
+		trend, err := pinInput(ctx, "yield_trend:"+pool, func() (pinnedYieldTrend, error) {
+			trend, ok := ae.yields.Trend(opportunity.Protocol, opportunity.AssetPair)
+			return pinnedYieldTrend{Trend: trend, Found: ok}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if trend.Found {
+			applyYieldTrend(opportunity, trend.Trend)
+		}
+		facts, err := pinInput(ctx, "yield_risk:"+pool, func() (yieldRiskFacts, error) {
+			return ae.riskFacts(opportunity, trend.Trend.TVLDrawdown, now), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		scoreYieldRisk(opportunity, facts)
+		if window != "" {
+			series, err := pinInput(ctx, "yield_series:"+pool+":"+window, func() (*YieldSeries, error) {
+				return ae.yields.Series(opportunity.Protocol, opportunity.AssetPair, window)
+			})
+			if err != nil {
+				return nil, err
+			}
+			opportunity.History = series
+		}
+	}
+
+	// Sort by opportunity score, with deterministic tiebreakers
+	YieldOrdering.Sort(opportunities, YieldOrdering.Default())
+
+	return opportunities, nil
+}
+
+// scanYields fetches the current yield opportunities, records them in the
+// yield history and checks their live reward rates
+func (ae *AnalyticsEngine) scanYields(ctx context.Context, now time.Time) []YieldOpportunity {
 	// Simulate fetching yield data from multiple protocols
 	opportunities := []YieldOpportunity{
 		{
@@ -126,7 +354,8 @@ func (ae *AnalyticsEngine) analyzeYieldOpportunities(ctx context.Context, params
 			TVL:          1500000,
 			Risk:         0.3,
 			Opportunity:  0.85,
-			LastUpdated:  time.Now().Unix(),
+			LastUpdated:  NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 		{
 			Protocol:     "Aave V3",
@@ -135,7 +364,8 @@ func (ae *AnalyticsEngine) analyzeYieldOpportunities(ctx context.Context, params
 			TVL:          2500000,
 			Risk:         0.2,
 			Opportunity:  0.72,
-			LastUpdated:  time.Now().Unix(),
+			LastUpdated:  NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 		{
 			Protocol:     "Compound V3",
@@ -144,37 +374,146 @@ func (ae *AnalyticsEngine) analyzeYieldOpportunities(ctx context.Context, params
 			TVL:          800000,
 			Risk:         0.15,
 			Opportunity:  0.68,
-			LastUpdated:  time.Now().Unix(),
+			LastUpdated:  NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 	}
 
-	// Sort by opportunity score
-	for i := 0; i < len(opportunities)-1; i++ {
-		for j := i + 1; j < len(opportunities); j++ {
-			if opportunities[i].Opportunity < opportunities[j].Opportunity {
-				opportunities[i], opportunities[j] = opportunities[j], opportunities[i]
-			}
+	ae.yields.Record(opportunities, now)
+	if ae.apy != nil {
+		ae.apy.Check(ctx, ae.protocols, opportunities)
+	}
+	return opportunities
+}
+
+// pinnedYieldTrend is a pool's yield trend as read for a scan
+type pinnedYieldTrend struct {
+	Trend YieldTrend `json:"trend"`
+	Found bool       `json:"found"`
+}
+
+// yieldRiskFacts are what a pool's risk is scored from
+type yieldRiskFacts struct {
+	Inputs  RiskInputs  `json:"inputs"`
+	Weights RiskWeights `json:"weights"`
+}
+
+// riskFacts gathers what the protocol registry and holder analysis
+// know about an opportunity's risk
+func (ae *AnalyticsEngine) riskFacts(opportunity *YieldOpportunity, drawdown float64, now time.Time) yieldRiskFacts {
+	inputs := RiskInputs{TVL: opportunity.TVL, APY: opportunity.APY, Drawdown: drawdown}
+	if protocol := ae.protocols.Protocol(opportunity.Protocol); protocol != nil {
+		if protocol.FirstActivity != nil {
+			age := now.Sub(*protocol.FirstActivity)
+			inputs.Age = &age
+		}
+		inputs.Audited = protocol.Audited
+		if pool := protocol.Pool(opportunity.AssetPair); pool != nil {
+			inputs.EmissionShare = pool.EmissionShare
+			inputs.TopDepositorShare = ae.topDepositorShare(pool.LPToken)
 		}
 	}
+	return yieldRiskFacts{Inputs: inputs, Weights: ae.protocols.Weights}
+}
 
-	return opportunities, nil
+// scoreYieldRisk scores an opportunity's risk from its risk facts. Risk
+// keeps the 0-1 scale it had before the breakdown.
+func scoreYieldRisk(opportunity *YieldOpportunity, facts yieldRiskFacts) {
+	assessment := ScoreYieldRisk(facts.Inputs, facts.Weights)
+	opportunity.RiskScore = assessment.Score
+	opportunity.RiskBreakdown = assessment.Components
+	opportunity.Risk = roundTo(assessment.Score/100, 2)
+}
+
+// topDepositorShare is the fraction of an LP token held by its largest
+// holders. A distribution that isn't computed yet is left to a background
+// scan, and the share is unknown until then.
+func (ae *AnalyticsEngine) topDepositorShare(lpToken string) *float64 {
+	if ae.holders == nil || !common.IsHexAddress(lpToken) {
+		return nil
+	}
+	distribution, _, err := ae.holders.Distribution(common.HexToAddress(lpToken), riskTopDepositors)
+	if err != nil || distribution == nil {
+		return nil
+	}
+
+	share := 0.0
+	for _, holder := range distribution.Top {
+		share += holder.Share / 100
+	}
+	return &share
 }
 
-// generateTradingSuggestions generates trading suggestions based on user history
+// generateTradingSuggestions generates trading suggestions from the user's
+// trading profile. Without swap history, or when it can't be read, generic
+// market suggestions are made instead.
 func (ae *AnalyticsEngine) generateTradingSuggestions(ctx context.Context, params map[string]interface{}) ([]TradingSuggestion, error) {
 	userAddress, ok := params["user_address"].(string)
 	if !ok {
 		return nil, fmt.Errorf("user_address parameter required")
 	}
 
-	// Simulate analyzing user's trading history
-	suggestions := []TradingSuggestion{
+	if ae.trading != nil {
+		profile, err := pinInput(ctx, "trading_profile:"+strings.ToLower(userAddress), func() (*TradingProfile, error) {
+			profile, err := ae.trading.Profile(ctx, userAddress)
+			if err != nil {
+				ae.logger.Printf("Trading profile of %s unavailable: %v", userAddress, err)
+				return nil, nil
+			}
+			return profile, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if profile != nil {
+			if suggestions := profileSuggestions(profile, profile.ComputedAt); len(suggestions) > 0 {
+				return ae.sizeSuggestions(ctx, tailorSuggestions(suggestions, params))
+			}
+		}
+	}
+
+	return ae.sizeSuggestions(ctx, tailorSuggestions(marketSuggestions(), params))
+}
+
+// sizeSuggestions caps the suggestions to the depth of their assets' pools.
+// Suggestions without a known pool are left as they are.
+func (ae *AnalyticsEngine) sizeSuggestions(ctx context.Context, suggestions []TradingSuggestion) ([]TradingSuggestion, error) {
+	if ae.depths == nil {
+		return suggestions, nil
+	}
+	for i := range suggestions {
+		suggestion := &suggestions[i]
+		depth, err := pinInput(ctx, "pool_depth:"+strings.ToUpper(suggestion.Asset), func() (*PoolDepth, error) {
+			depth, err := ae.depths.Depth(ctx, suggestion.Asset)
+			if err != nil {
+				return nil, nil
+			}
+			return depth, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if depth == nil {
+			continue
+		}
+		slippage := suggestion.Slippage
+		if slippage <= 0 {
+			slippage = DefaultSlippage
+		}
+		sizeSuggestion(suggestion, depth, slippage)
+	}
+	return suggestions, nil
+}
+
+// marketSuggestions are the suggestions made without a trading history
+func marketSuggestions() []TradingSuggestion {
+	return []TradingSuggestion{
 		{
 			Type:          "buy",
 			Asset:         "ETH",
 			Amount:        0.5,
 			Confidence:    0.78,
-			Reasoning:     "Based on your trading pattern, you typically buy ETH during market dips. Current price shows a 15% discount from recent highs.",
+			Reasoning:     "ETH trades about 15% below its recent highs, a level that has historically been a good entry.",
 			RiskLevel:     "medium",
 			ExpectedReturn: 0.12,
 		},
@@ -183,7 +522,7 @@ func (ae *AnalyticsEngine) generateTradingSuggestions(ctx context.Context, param
 			Asset:         "USDC",
 			Amount:        1000,
 			Confidence:    0.65,
-			Reasoning:     "Your USDC holdings have increased 25% this month. Consider taking profits and diversifying.",
+			Reasoning:     "Large idle stablecoin balances miss out on the market; consider diversifying part of them.",
 			RiskLevel:     "low",
 			ExpectedReturn: 0.05,
 		},
@@ -192,19 +531,64 @@ func (ae *AnalyticsEngine) generateTradingSuggestions(ctx context.Context, param
 			Asset:         "DAI",
 			Amount:        500,
 			Confidence:    0.82,
-			Reasoning:     "DAI shows strong correlation with your successful trades. Current market conditions favor stablecoin positions.",
+			Reasoning:     "Current market conditions favor stablecoin positions, and DAI offers the deepest lending yields.",
 			RiskLevel:     "low",
 			ExpectedReturn: 0.08,
 		},
 	}
+}
 
-	return suggestions, nil
+// tailorSuggestions drops suggestions riskier than the risk_tolerance
+// parameter, orders the rest by confidence with favorite_tokens first, and
+// sets the slippage parameter on each suggestion
+func tailorSuggestions(suggestions []TradingSuggestion, params map[string]interface{}) []TradingSuggestion {
+	tolerance, ok := riskLevels[strings.ToLower(fmt.Sprint(params["risk_tolerance"]))]
+	if !ok {
+		tolerance = riskLevels[RiskMedium]
+	}
+	slippage, _ := params["slippage"].(float64)
+	favorites := UserPreferences{}
+	switch tokens := params["favorite_tokens"].(type) {
+	case []string:
+		favorites.FavoriteTokens = tokens
+	case []interface{}:
+		for _, token := range tokens {
+			favorites.FavoriteTokens = append(favorites.FavoriteTokens, fmt.Sprint(token))
+		}
+	}
+
+	tailored := make([]TradingSuggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		if level, ok := riskLevels[suggestion.RiskLevel]; ok && level > tolerance {
+			continue
+		}
+		if slippage > 0 {
+			suggestion.Slippage = slippage
+		}
+		tailored = append(tailored, suggestion)
+	}
+	SuggestionOrdering.Sort(tailored, SuggestionOrdering.Default())
+	sort.SliceStable(tailored, func(i, j int) bool {
+		return favorites.IsFavorite(tailored[i].Asset) && !favorites.IsFavorite(tailored[j].Asset)
+	})
+	return tailored
 }
 
 // analyzeGovernanceSentiment analyzes sentiment of governance proposals
 func (ae *AnalyticsEngine) analyzeGovernanceSentiment(ctx context.Context, params map[string]interface{}) ([]GovernanceSentiment, error) {
+	// Prefer ingested proposals, which carry live outcome predictions
+	sentiments, err := pinInput(ctx, "governance_sentiments", func() ([]GovernanceSentiment, error) {
+		return ae.governance.Sentiments(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(sentiments) > 0 {
+		return sentiments, nil
+	}
+
 	// Simulate governance sentiment analysis
-	sentiments := []GovernanceSentiment{
+	sentiments = []GovernanceSentiment{
 		{
 			ProposalID:   "PROP-001",
 			Title:        "Increase Protocol Fee to 0.3%",
@@ -240,33 +624,73 @@ func (ae *AnalyticsEngine) analyzeGovernanceSentiment(ctx context.Context, param
 	return sentiments, nil
 }
 
-// optimizePortfolio optimizes user portfolio based on risk tolerance and goals
+// portfolioTargets are the recommended allocation, risk score, and expected
+// return for each risk tolerance
+var portfolioTargets = map[string]struct {
+	allocation     map[string]float64
+	riskScore      float64
+	expectedReturn float64
+}{
+	RiskLow: {
+		allocation:     map[string]float64{"ETH": 0.2, "USDC": 0.4, "DAI": 0.3, "Other": 0.1},
+		riskScore:      0.25,
+		expectedReturn: 0.05,
+	},
+	RiskMedium: {
+		allocation:     map[string]float64{"ETH": 0.35, "USDC": 0.25, "DAI": 0.25, "Other": 0.15},
+		riskScore:      0.45,
+		expectedReturn: 0.085,
+	},
+	RiskHigh: {
+		allocation:     map[string]float64{"ETH": 0.5, "USDC": 0.1, "DAI": 0.1, "Other": 0.3},
+		riskScore:      0.7,
+		expectedReturn: 0.14,
+	},
+}
+
+// optimizePortfolio optimizes user portfolio based on risk tolerance and
+// goals. With a portfolio_value_usd parameter and a rebalancer, it plans the
+// swaps to the recommended allocation and their cost.
 func (ae *AnalyticsEngine) optimizePortfolio(ctx context.Context, params map[string]interface{}) (map[string]interface{}, error) {
 	riskTolerance, _ := params["risk_tolerance"].(string)
-	if riskTolerance == "" {
-		riskTolerance = "medium"
+	riskTolerance = strings.ToLower(riskTolerance)
+	if _, ok := portfolioTargets[riskTolerance]; !ok {
+		riskTolerance = RiskMedium
 	}
+	target := portfolioTargets[riskTolerance]
 
 	// Simulate portfolio optimization
+	current := map[string]float64{
+		"ETH":  0.4,
+		"USDC": 0.3,
+		"DAI":  0.2,
+		"Other": 0.1,
+	}
 	optimization := map[string]interface{}{
-		"current_allocation": map[string]float64{
-			"ETH":  0.4,
-			"USDC": 0.3,
-			"DAI":  0.2,
-			"Other": 0.1,
-		},
-		"recommended_allocation": map[string]float64{
-			"ETH":  0.35,
-			"USDC": 0.25,
-			"DAI":  0.25,
-			"Other": 0.15,
-		},
-		"risk_score": 0.45,
-		"expected_return": 0.085,
+		"current_allocation": current,
+		"recommended_allocation": target.allocation,
+		"risk_tolerance": riskTolerance,
+		"risk_score": target.riskScore,
+		"expected_return": target.expectedReturn,
 		"rebalancing_needed": true,
-		"rebalancing_cost": 0.002,
 	}
 
+	value, _ := params["portfolio_value_usd"].(float64)
+	if value <= 0 || ae.rebalancer == nil {
+		return optimization, nil
+	}
+	holdings := make(map[string]float64, len(current))
+	for asset, weight := range current {
+		holdings[asset] = weight * value
+	}
+	plan, err := ae.rebalancer.Plan(ctx, holdings, target.allocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan rebalancing: %w", err)
+	}
+	optimization["rebalancing_plan"] = plan
+	optimization["rebalancing_needed"] = plan.Economical
+	optimization["rebalancing_cost"] = plan.TotalCostUSD
+
 	return optimization, nil
 }
 
@@ -290,14 +714,85 @@ func (ae *AnalyticsEngine) assessRisk(ctx context.Context, params map[string]int
 		},
 	}
 
+	address, _ := params["address"].(string)
+	if address == "" || ae.approvals == nil {
+		return riskAssessment, nil
+	}
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	report, err := pinInput(ctx, "approvals:"+strings.ToLower(address), func() (*ApprovalReport, error) {
+		return ae.approvals.Assess(ctx, common.HexToAddress(address))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess token approvals: %w", err)
+	}
+	riskAssessment["approvals"] = report
+	if len(report.Approvals) > 0 {
+		riskFactors := riskAssessment["risk_factors"].([]string)
+		riskAssessment["risk_factors"] = append(riskFactors, fmt.Sprintf("%d risky standing token approvals", len(report.Approvals)))
+		// The revocations are concrete, so they come before the general advice
+		recommendations := make([]string, 0, len(report.Approvals))
+		for _, approval := range report.Approvals {
+			recommendations = append(recommendations, approval.Recommendation)
+		}
+		riskAssessment["recommendations"] = append(recommendations, riskAssessment["recommendations"].([]string)...)
+	}
+
 	return riskAssessment, nil
 }
 
-// calculateConfidence calculates confidence score for analytics results
-func (ae *AnalyticsEngine) calculateConfidence(result interface{}) float64 {
-	// Simple confidence calculation based on data quality
-	// In a real implementation, this would be more sophisticated
-	return 0.75 + (0.25 * (time.Now().Unix() % 100) / 100.0)
+// assessDataQuality scores a task result by the data it was computed from
+func (ae *AnalyticsEngine) assessDataQuality(result interface{}) *DataQuality {
+	var inputs QualityInputs
+	switch data := result.(type) {
+	case []YieldOpportunity:
+		yields := DatasetAge{Name: "yields", SLA: YieldDataSLA}
+		var symbols []string
+		for _, opportunity := range data {
+			updatedAt := opportunity.LastUpdated.Time
+			if yields.UpdatedAt.IsZero() || updatedAt.Before(yields.UpdatedAt) {
+				yields.UpdatedAt = updatedAt
+			}
+			inputs.Coverage = append(inputs.Coverage, AssetCoverage{
+				Asset:   opportunity.Protocol + " " + opportunity.AssetPair,
+				Dataset: "TVL",
+				Covered: opportunity.TVL > 0,
+			})
+			symbols = append(symbols, strings.Split(opportunity.AssetPair, "/")...)
+		}
+		if len(data) > 0 {
+			inputs.Datasets = append(inputs.Datasets, yields)
+		}
+		inputs.addPrices(ae.prices, symbols)
+	case []TradingSuggestion:
+		symbols := make([]string, len(data))
+		for i, suggestion := range data {
+			symbols[i] = suggestion.Asset
+		}
+		inputs.addPrices(ae.prices, symbols)
+	case []GovernanceSentiment:
+		for _, sentiment := range data {
+			inputs.Samples = append(inputs.Samples, SampleCount{
+				Name:   sentiment.ProposalID,
+				Unit:   "votes",
+				Have:   sentiment.VoteCount,
+				Needed: MinSentimentVotes,
+			})
+		}
+	case map[string]interface{}:
+		if allocation, ok := data["recommended_allocation"].(map[string]float64); ok {
+			symbols := make([]string, 0, len(allocation))
+			for symbol := range allocation {
+				if symbol != "Other" {
+					symbols = append(symbols, symbol)
+				}
+			}
+			sort.Strings(symbols)
+			inputs.addPrices(ae.prices, symbols)
+		}
+	}
+	return ScoreDataQuality(inputs, ae.now())
 }
 
 // ProcessBatchTasks processes multiple analytics tasks concurrently
@@ -311,7 +806,7 @@ func (ae *AnalyticsEngine) ProcessBatchTasks(ctx context.Context, tasks []map[st
 		taskIndex := i
 		taskData := task
 
-		err := ae.pool.Submit(func() {
+		err := ae.pool.Submit(ae.panics.Wrap(analyticsPoolComponent, func() {
 			defer wg.Done()
 
 			taskType, ok := taskData["type"].(string)
@@ -334,7 +829,7 @@ func (ae *AnalyticsEngine) ProcessBatchTasks(ctx context.Context, tasks []map[st
 			mu.Lock()
 			results[taskIndex] = result
 			mu.Unlock()
-		})
+		}))
 
 		if err != nil {
 			ae.logger.Printf("Error submitting task %d: %v", taskIndex, err)
@@ -354,6 +849,12 @@ func (ae *AnalyticsEngine) ProcessBatchTasks(ctx context.Context, tasks []map[st
 	return validResults, nil
 }
 
+// Submit runs a task on the engine's worker pool, waiting for a free worker.
+// A panicking task is recovered and reported, leaving the worker running.
+func (ae *AnalyticsEngine) Submit(task func()) error {
+	return ae.pool.Submit(ae.panics.Wrap(analyticsPoolComponent, task))
+}
+
 // GetAnalyticsMetrics returns key analytics metrics
 func (ae *AnalyticsEngine) GetAnalyticsMetrics() map[string]interface{} {
 	ae.mu.RLock()
@@ -365,6 +866,7 @@ func (ae *AnalyticsEngine) GetAnalyticsMetrics() map[string]interface{} {
 		"success_rate": 0.95,
 		"active_workers": ae.pool.Running(),
 		"queue_size": ae.pool.Free(),
+		"pool": ae.pool.Stats(),
 	}
 }
 
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAnomalyThreshold is the |z-score| above which a point is anomalous
+	DefaultAnomalyThreshold = 3.0
+	// DefaultAnomalyLookback is the number of trailing points a fresh point is scored against
+	DefaultAnomalyLookback = 30
+
+	maxSeriesPoints = 10000
+
+	// MetricGasPrice is the suggested gas price in gwei, one point per collected block
+	MetricGasPrice = "gas_price"
+	// MetricTxVolume is the transaction count, one point per collected block
+	MetricTxVolume = "tx_volume"
+)
+
+// PriceMetric returns the series name of a symbol's USD price
+func PriceMetric(symbol string) string {
+	return "price:" + strings.ToUpper(symbol)
+}
+
+// SeriesPoint is a single observation of a metric
+type SeriesPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Anomaly is a point whose z-score exceeds the detection threshold
+type Anomaly struct {
+	Index     int       `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+	Mean      float64   `json:"mean"`
+	StdDev    float64   `json:"std_dev"`
+	ZScore    float64   `json:"z_score"`
+}
+
+// seriesValues returns the values of the points
+func seriesValues(points []SeriesPoint) []float64 {
+	values := make([]float64, len(points))
+	for i, point := range points {
+		values[i] = point.Value
+	}
+	return values
+}
+
+// DetectAnomalies scores every point against the whole series
+func DetectAnomalies(points []SeriesPoint, threshold float64) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	mean, stdDev, err := MeanStdDev(seriesValues(points))
+	if err != nil || stdDev == 0 {
+		return anomalies
+	}
+
+	for i, point := range points {
+		z := (point.Value - mean) / stdDev
+		if math.Abs(z) > threshold {
+			anomalies = append(anomalies, Anomaly{
+				Index:     i,
+				Timestamp: point.Timestamp,
+				Value:     point.Value,
+				Mean:      mean,
+				StdDev:    stdDev,
+				ZScore:    z,
+			})
+		}
+	}
+	return anomalies
+}
+
+// DetectAnomaliesRolling scores each point against the lookback points before
+// it, so a spike is judged by recent behaviour rather than by a series it
+// distorts itself. The first lookback points are never flagged.
+func DetectAnomaliesRolling(points []SeriesPoint, lookback int, threshold float64) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	if lookback < 2 {
+		return anomalies
+	}
+
+	trailing := NewRollingWindow(lookback)
+	for i, point := range points {
+		if trailing.Full() {
+			if anomaly, ok := scorePoint(trailing, point, threshold); ok {
+				anomaly.Index = i
+				anomalies = append(anomalies, anomaly)
+			}
+		}
+		trailing.Push(point.Value)
+	}
+	return anomalies
+}
+
+// scorePoint scores a point against its trailing window
+func scorePoint(trailing *RollingWindow, point SeriesPoint, threshold float64) (Anomaly, bool) {
+	mean, stdDev, err := trailing.MeanStdDev()
+	if err != nil || stdDev == 0 {
+		return Anomaly{}, false
+	}
+
+	z := (point.Value - mean) / stdDev
+	if math.Abs(z) <= threshold {
+		return Anomaly{}, false
+	}
+	return Anomaly{
+		Timestamp: point.Timestamp,
+		Value:     point.Value,
+		Mean:      mean,
+		StdDev:    stdDev,
+		ZScore:    z,
+	}, true
+}
+
+// AnomalyHandler is notified when a freshly recorded point is anomalous
+type AnomalyHandler func(metric string, anomaly Anomaly)
+
+// TimeSeriesStore keeps bounded in-memory series per metric and scores each
+// fresh point against its trailing window as it is recorded. Raw points
+// dropped by the retention engine or the size cap are folded into hourly
+// aggregates, which queries return in their place.
+type TimeSeriesStore struct {
+	mu          sync.RWMutex
+	series      map[string][]SeriesPoint
+	hourly      map[string][]SeriesAggregate
+	subscribers []AnomalyHandler
+	lookback    int
+	threshold   float64
+
+	// trailing holds the last lookback values of each series, so a fresh
+	// point is scored in constant time. It is rebuilt from the series when
+	// missing.
+	trailing map[string]*RollingWindow
+}
+
+// NewTimeSeriesStore creates an empty store using the default detection settings
+func NewTimeSeriesStore() *TimeSeriesStore {
+	return &TimeSeriesStore{
+		series:    make(map[string][]SeriesPoint),
+		hourly:    make(map[string][]SeriesAggregate),
+		trailing:  make(map[string]*RollingWindow),
+		lookback:  DefaultAnomalyLookback,
+		threshold: DefaultAnomalyThreshold,
+	}
+}
+
+// Subscribe registers a handler for anomalies detected on fresh points
+func (ts *TimeSeriesStore) Subscribe(handler AnomalyHandler) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.subscribers = append(ts.subscribers, handler)
+}
+
+// Record appends a point to a metric's series and notifies subscribers if it
+// is anomalous. A point with the same timestamp as the latest one replaces it,
+// so re-collecting the same block doesn't skew the series. Points whose value
+// is NaN or infinite are dropped, so they never reach a response.
+func (ts *TimeSeriesStore) Record(metric string, point SeriesPoint) {
+	if !isFinite(point.Value) {
+		return
+	}
+	ts.mu.Lock()
+	series := ts.series[metric]
+	if n := len(series); n > 0 && series[n-1].Timestamp.Equal(point.Timestamp) {
+		series[n-1] = point
+		if trailing := ts.trailing[metric]; trailing != nil {
+			trailing.ReplaceLast(point.Value)
+		}
+		ts.mu.Unlock()
+		return
+	}
+	trailing := ts.trailingWindow(metric, series)
+	series = append(series, point)
+	if len(series) > maxSeriesPoints {
+		dropped := len(series) - maxSeriesPoints
+		ts.hourly[metric] = foldHourly(ts.hourly[metric], series[:dropped])
+		series = series[dropped:]
+	}
+	ts.series[metric] = series
+
+	var anomaly Anomaly
+	anomalous := false
+	if trailing != nil && trailing.Full() {
+		anomaly, anomalous = scorePoint(trailing, point, ts.threshold)
+		anomaly.Index = len(series) - 1
+	}
+	if trailing != nil {
+		trailing.Push(point.Value)
+	}
+	subscribers := ts.subscribers
+	ts.mu.Unlock()
+
+	if anomalous {
+		for _, handler := range subscribers {
+			handler(metric, anomaly)
+		}
+	}
+}
+
+// trailingWindow returns the window of a metric's last lookback values,
+// building it from the series when missing. The caller holds the lock.
+func (ts *TimeSeriesStore) trailingWindow(metric string, series []SeriesPoint) *RollingWindow {
+	if trailing, ok := ts.trailing[metric]; ok {
+		return trailing
+	}
+	trailing := NewRollingWindow(ts.lookback)
+	if trailing == nil {
+		return nil
+	}
+	for _, point := range series[max(0, len(series)-ts.lookback):] {
+		trailing.Push(point.Value)
+	}
+	ts.trailing[metric] = trailing
+	return trailing
+}
+
+// CompactBefore folds at most limit raw points recorded before cutoff,
+// truncated to the hour, into hourly aggregates
+func (ts *TimeSeriesStore) CompactBefore(cutoff time.Time, limit int) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	cutoff = cutoff.UTC().Truncate(time.Hour)
+	metrics := make([]string, 0, len(ts.series))
+	for metric := range ts.series {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	compacted := 0
+	for _, metric := range metrics {
+		series := ts.series[metric]
+		n := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(cutoff) })
+		n = min(n, limit-compacted)
+		if n == 0 {
+			continue
+		}
+		ts.hourly[metric] = foldHourly(ts.hourly[metric], series[:n])
+		ts.series[metric] = append([]SeriesPoint(nil), series[n:]...)
+		delete(ts.trailing, metric)
+		compacted += n
+		if compacted == limit {
+			break
+		}
+	}
+	return compacted
+}
+
+// Aggregates returns a metric's hourly aggregates, oldest first
+func (ts *TimeSeriesStore) Aggregates(metric string) []SeriesAggregate {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return append([]SeriesAggregate(nil), ts.hourly[metric]...)
+}
+
+// points returns a metric's series from since on, oldest first: the mean of
+// each aggregated hour, then the raw points recorded after them. The caller
+// holds the lock.
+func (ts *TimeSeriesStore) points(metric string, since time.Time) []SeriesPoint {
+	hourly := ts.hourly[metric]
+	series := ts.series[metric]
+	start := sort.Search(len(hourly), func(i int) bool { return !hourly[i].Start.Before(since) })
+	points := make([]SeriesPoint, 0, len(hourly)-start+len(series))
+	for _, aggregate := range hourly[start:] {
+		points = append(points, aggregate.point())
+	}
+	for _, point := range series {
+		if !point.Timestamp.Before(since) {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// Range returns a metric's points recorded at or after since, oldest first.
+// Hours folded into aggregates appear as one point each.
+func (ts *TimeSeriesStore) Range(metric string, since time.Time) []SeriesPoint {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	return ts.points(metric, since)
+}
+
+// ValueAt returns the latest point of a metric recorded at or before at,
+// or the mean of its hour once that hour has been aggregated
+func (ts *TimeSeriesStore) ValueAt(metric string, at time.Time) (SeriesPoint, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	series := ts.series[metric]
+	if i := sort.Search(len(series), func(i int) bool { return series[i].Timestamp.After(at) }); i > 0 {
+		return series[i-1], true
+	}
+	hourly := ts.hourly[metric]
+	i := sort.Search(len(hourly), func(i int) bool { return hourly[i].Start.After(at) })
+	if i == 0 {
+		return SeriesPoint{}, false
+	}
+	return hourly[i-1].point(), true
+}
+
+// Around returns the latest point of a metric recorded at or before at and
+// the earliest one after it, nil when there is none. Hours folded into
+// aggregates count as one point at the start of the hour.
+func (ts *TimeSeriesStore) Around(metric string, at time.Time) (before, after *SeriesPoint) {
+	ts.mu.RLock()
+	points := ts.points(metric, time.Time{})
+	ts.mu.RUnlock()
+
+	i := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(at) })
+	if i > 0 {
+		before = &points[i-1]
+	}
+	if i < len(points) {
+		after = &points[i]
+	}
+	return before, after
+}
+
+// DetectSince runs rolling detection over the points recorded at or after
+// since, using the lookback points before since as context so the start of the
+// window is scored too. Returns the anomalies and the number of points scored.
+func (ts *TimeSeriesStore) DetectSince(metric string, since time.Time, lookback int, threshold float64) ([]Anomaly, int) {
+	ts.mu.RLock()
+	series := ts.points(metric, time.Time{})
+	start := sort.Search(len(series), func(i int) bool { return !series[i].Timestamp.Before(since) })
+	from := start - lookback
+	if from < 0 {
+		from = 0
+	}
+	points := make([]SeriesPoint, len(series)-from)
+	copy(points, series[from:])
+	ts.mu.RUnlock()
+
+	offset := start - from
+	anomalies := make([]Anomaly, 0)
+	for _, anomaly := range DetectAnomaliesRolling(points, lookback, threshold) {
+		if anomaly.Index >= offset {
+			anomaly.Index -= offset
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies, len(points) - offset
+}
+
+// Metrics returns the names of the recorded metrics
+func (ts *TimeSeriesStore) Metrics() []string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	metrics := make([]string, 0, len(ts.series))
+	for metric := range ts.series {
+		metrics = append(metrics, metric)
+	}
+	for metric := range ts.hourly {
+		if _, ok := ts.series[metric]; !ok {
+			metrics = append(metrics, metric)
+		}
+	}
+	sort.Strings(metrics)
+	return metrics
+}
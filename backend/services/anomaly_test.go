@@ -0,0 +1,95 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var anomalyEpoch = time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func seriesOf(values ...float64) []SeriesPoint {
+	points := make([]SeriesPoint, len(values))
+	for i, value := range values {
+		points[i] = SeriesPoint{Timestamp: anomalyEpoch.Add(time.Duration(i) * time.Minute), Value: value}
+	}
+	return points
+}
+
+func anomalyIndexes(anomalies []Anomaly) []int {
+	indexes := make([]int, len(anomalies))
+	for i, anomaly := range anomalies {
+		indexes[i] = anomaly.Index
+	}
+	return indexes
+}
+
+var spikeSeries = seriesOf(10, 10, 11, 9, 10, 10, 50, 10, 9, 11, 10, 10, 80, 10)
+
+func TestDetectAnomaliesRollingFlagsExactlyTheSpikes(t *testing.T) {
+	anomalies := DetectAnomaliesRolling(spikeSeries, 5, DefaultAnomalyThreshold)
+	assert.Equal(t, []int{6, 12}, anomalyIndexes(anomalies))
+	assert.InDelta(t, 10.0, anomalies[0].Mean, 1e-9)
+	assert.Greater(t, anomalies[0].ZScore, 0.0)
+}
+
+func TestDetectAnomaliesWholeSeriesMasksEarlierSpike(t *testing.T) {
+	// The larger spike inflates the whole-series deviation enough to hide the
+	// first one, which is why fresh points are scored against a trailing window
+	assert.Equal(t, []int{12}, anomalyIndexes(DetectAnomalies(spikeSeries, DefaultAnomalyThreshold)))
+	assert.Empty(t, DetectAnomalies(seriesOf(5, 5, 5, 5), DefaultAnomalyThreshold))
+}
+
+func TestDetectAnomaliesUsesRealSquareRoot(t *testing.T) {
+	// Mean 0.8 and variance 2.56, so the deviation is 1.6 and the last point
+	// scores exactly 2. Truncating the variance to two decimals instead of
+	// taking its square root would give a deviation of 2.56 and a z-score of 1.25.
+	anomalies := DetectAnomalies(seriesOf(0, 0, 0, 0, 4), 1.5)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, 4, anomalies[0].Index)
+	assert.InDelta(t, 1.6, anomalies[0].StdDev, 1e-9)
+	assert.InDelta(t, 2.0, anomalies[0].ZScore, 1e-9)
+
+	// Drops are flagged as well as spikes
+	anomalies = DetectAnomalies(seriesOf(0, 0, 0, 0, -4), 1.5)
+	require.Len(t, anomalies, 1)
+	assert.InDelta(t, -2.0, anomalies[0].ZScore, 1e-9)
+}
+
+func TestTimeSeriesStoreNotifiesFreshAnomalies(t *testing.T) {
+	store := NewTimeSeriesStore()
+	store.lookback = 5
+
+	var notified []Anomaly
+	store.Subscribe(func(metric string, anomaly Anomaly) {
+		assert.Equal(t, MetricGasPrice, metric)
+		notified = append(notified, anomaly)
+	})
+
+	for _, point := range spikeSeries {
+		store.Record(MetricGasPrice, point)
+	}
+	assert.Equal(t, []int{6, 12}, anomalyIndexes(notified))
+
+	// Re-recording the latest timestamp replaces the point instead of appending
+	store.Record(MetricGasPrice, SeriesPoint{Timestamp: spikeSeries[13].Timestamp, Value: 11})
+	assert.Len(t, store.Range(MetricGasPrice, anomalyEpoch), len(spikeSeries))
+	// and values that aren't finite numbers are dropped
+	store.Record(MetricGasPrice, SeriesPoint{Timestamp: spikeSeries[13].Timestamp, Value: math.NaN()})
+	store.Record(MetricGasPrice, SeriesPoint{Timestamp: spikeSeries[13].Timestamp.Add(time.Hour), Value: math.Inf(1)})
+	assert.Len(t, store.Range(MetricGasPrice, anomalyEpoch), len(spikeSeries))
+	assert.Equal(t, 11.0, store.Range(MetricGasPrice, anomalyEpoch)[13].Value)
+
+	// Detection over a window uses the preceding points as context, so the
+	// spike at the start of the window is still scored
+	anomalies, scored := store.DetectSince(MetricGasPrice, spikeSeries[6].Timestamp, 5, DefaultAnomalyThreshold)
+	assert.Equal(t, 8, scored)
+	assert.Equal(t, []int{0, 6}, anomalyIndexes(anomalies))
+	assert.Equal(t, spikeSeries[12].Timestamp, anomalies[1].Timestamp)
+
+	assert.Equal(t, []string{MetricGasPrice}, store.Metrics())
+	assert.Equal(t, "price:KAIA", PriceMetric("kaia"))
+}
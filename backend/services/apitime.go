@@ -0,0 +1,84 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// APITime is a timestamp in an API response. It marshals as an RFC 3339 UTC
+// string, or null when zero. It unmarshals from either that or unix seconds,
+// which older clients still send.
+//
+// Fields of this type are paired with a *_unix field in unix seconds that is
+// kept for one deprecation cycle.
+type APITime struct {
+	time.Time
+}
+
+// NewAPITime wraps a time for an API response
+func NewAPITime(t time.Time) APITime {
+	return APITime{Time: t.UTC()}
+}
+
+// UnixAPITime wraps unix seconds for an API response
+func UnixAPITime(seconds int64) APITime {
+	return APITime{Time: time.Unix(seconds, 0).UTC()}
+}
+
+// Unix returns the unix seconds of the time, or 0 when it is zero
+func (t APITime) Unix() int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Time.Unix()
+}
+
+// MarshalJSON implements json.Marshaler
+func (t APITime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(time.RFC3339))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *APITime) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*t = APITime{}
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		if text == "" {
+			*t = APITime{}
+			return nil
+		}
+		parsed, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return fmt.Errorf("timestamp must be RFC 3339 or unix seconds: %w", err)
+		}
+		*t = NewAPITime(parsed)
+		return nil
+	default:
+		var seconds int64
+		if err := json.Unmarshal(data, &seconds); err != nil {
+			return fmt.Errorf("timestamp must be RFC 3339 or unix seconds: %w", err)
+		}
+		*t = APITime{}
+		if seconds != 0 {
+			*t = UnixAPITime(seconds)
+		}
+		return nil
+	}
+}
+
+// utcNow is the clock of the services, so the times they report are UTC
+func utcNow() time.Time {
+	return time.Now().UTC()
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITimeMarshalsRFC3339UTC(t *testing.T) {
+	seoul := time.FixedZone("KST", 9*60*60)
+	stamp := NewAPITime(time.Date(2024, 3, 1, 9, 30, 0, 0, seoul))
+
+	encoded, err := json.Marshal(stamp)
+	require.NoError(t, err)
+	assert.Equal(t, `"2024-03-01T00:30:00Z"`, string(encoded))
+	assert.Equal(t, int64(1709253000), stamp.Unix())
+
+	encoded, err = json.Marshal(APITime{})
+	require.NoError(t, err)
+	assert.Equal(t, "null", string(encoded))
+	assert.Zero(t, APITime{}.Unix())
+}
+
+func TestAPITimeUnmarshalsBothFormats(t *testing.T) {
+	var message struct {
+		Timestamp APITime `json:"timestamp"`
+	}
+
+	require.NoError(t, json.Unmarshal([]byte(`{"timestamp":"2024-03-01T09:30:00+09:00"}`), &message))
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC), message.Timestamp.Time)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"timestamp":1709253000}`), &message))
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC), message.Timestamp.Time)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"timestamp":null}`), &message))
+	assert.True(t, message.Timestamp.IsZero())
+
+	assert.Error(t, json.Unmarshal([]byte(`{"timestamp":"yesterday"}`), &message))
+}
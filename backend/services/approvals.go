@@ -0,0 +1,570 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// DefaultApprovalScanMaxBlocks bounds how many blocks of Approval logs
+	// the first scan of an owner replays
+	DefaultApprovalScanMaxBlocks = 5_000_000
+
+	// Approval risk levels
+	ApprovalRiskLow    = "low"
+	ApprovalRiskMedium = "medium"
+	ApprovalRiskHigh   = "high"
+
+	approvalScanChunk = 5000
+	// approvalHistoryTTL is how long an owner's folded Approval history is
+	// kept for the next scan to extend
+	approvalHistoryTTL = 24 * time.Hour
+	// approvalSpenderTTL is how long a spender's code and age are reused
+	approvalSpenderTTL = 24 * time.Hour
+
+	// An approval unused past approvalIdleAfter counts as idle, and past
+	// approvalStaleAfter as stale
+	approvalIdleAfter  = 30 * 24 * time.Hour
+	approvalStaleAfter = 180 * 24 * time.Hour
+	// approvalNewSpender is the age under which an unlabeled spender is new
+	approvalNewSpender = 30 * 24 * time.Hour
+	// Limited allowances are weighed by what they can move
+	approvalLargeUSD = 10_000
+	approvalSmallUSD = 100
+
+	// Scores from which an approval is a medium or high risk. Only medium and
+	// high risks are reported.
+	approvalMediumScore = 0.3
+	approvalHighScore   = 0.6
+)
+
+// approvalTopic is the signature topic of ERC-20 Approval events
+var approvalTopic = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+
+// 4-byte selectors of allowance(address,address) and approve(address,uint256)
+var (
+	erc20AllowanceSelector = common.FromHex("0xdd62ed3e")
+	erc20ApproveSelector   = common.FromHex("0x095ea7b3")
+)
+
+// TokenApproval is an owner's standing ERC-20 allowance to a spender
+type TokenApproval struct {
+	Owner        string `json:"owner"`
+	Token        string `json:"token"`
+	Symbol       string `json:"symbol"`
+	Spender      string `json:"spender"`
+	SpenderLabel string `json:"spender_label,omitempty"`
+	// Allowance is the live allowance in the token's smallest unit
+	Allowance      string  `json:"allowance"`
+	AllowanceFloat float64 `json:"allowance_float"`
+	Unlimited      bool    `json:"unlimited"`
+	// AllowanceUSD is what a limited allowance can move, unset when the
+	// token isn't priced
+	AllowanceUSD float64 `json:"allowance_usd,omitempty"`
+	ApprovedAt   APITime `json:"approved_at"`
+	ApprovalTx   string  `json:"approval_tx"`
+	// LastUsed is the last transaction of the owner to the spender, or the
+	// approval itself when there was none since
+	LastUsed APITime `json:"last_used"`
+}
+
+// RevokeTx is the approve(spender, 0) transaction that revokes an approval,
+// for the owner's wallet to fill in nonce and gas and sign
+type RevokeTx struct {
+	ChainID string `json:"chain_id,omitempty"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Value   string `json:"value"`
+	Data    string `json:"data"`
+}
+
+// ApprovalRisk is an approval scored by its spender, amount, and last use
+type ApprovalRisk struct {
+	TokenApproval
+	SpenderIsContract bool     `json:"spender_is_contract"`
+	SpenderDeployedAt *APITime `json:"spender_deployed_at,omitempty"`
+	// Score is 0-1, the amount's weight times the larger of the spender's
+	// and the idle time's
+	Score          float64  `json:"score"`
+	Level          string   `json:"level"`
+	Reasons        []string `json:"reasons"`
+	Recommendation string   `json:"recommendation"`
+	Revoke         RevokeTx `json:"revoke"`
+}
+
+// ApprovalReport lists the risky standing approvals of an owner
+type ApprovalReport struct {
+	Owner string `json:"owner"`
+	// Active counts the standing approvals, risky or not
+	Active    int            `json:"active"`
+	Approvals []ApprovalRisk `json:"approvals"`
+	FromBlock uint64         `json:"from_block"`
+	ToBlock   uint64         `json:"to_block"`
+	// Truncated is set when the scan didn't reach back to genesis, so older
+	// approvals are missing
+	Truncated      bool     `json:"truncated"`
+	Partial        bool     `json:"partial"`
+	PartialReasons []string `json:"partial_reasons,omitempty"`
+	GeneratedAt    APITime  `json:"generated_at"`
+}
+
+func (r *ApprovalReport) markPartial(reason string) {
+	r.Partial = true
+	r.PartialReasons = append(r.PartialReasons, reason)
+}
+
+// approvalKey identifies an allowance
+type approvalKey struct {
+	token, spender common.Address
+}
+
+// approvalEvent is the latest Approval event of an allowance
+type approvalEvent struct {
+	value *big.Int
+	block uint64
+	index uint
+	tx    common.Hash
+}
+
+// after reports whether the event was emitted after another
+func (e approvalEvent) after(other approvalEvent) bool {
+	return e.block > other.block || (e.block == other.block && e.index > other.index)
+}
+
+// approvalHistory is the folded Approval history of an owner
+type approvalHistory struct {
+	fromBlock, toBlock uint64
+	truncated          bool
+	latest             map[approvalKey]approvalEvent
+	scannedAt          time.Time
+}
+
+// spenderInfo is what is known of a spender's code
+type spenderInfo struct {
+	contract   bool
+	deployedAt *time.Time
+	checkedAt  time.Time
+}
+
+// ApprovalScanner finds the standing ERC-20 approvals of an address among
+// the tracked tokens and scores how dangerous each is. Approval events are
+// folded so revoked and superseded approvals drop out, and the live
+// allowance decides what is still standing. An owner's history is kept, so
+// later scans only replay the blocks since.
+type ApprovalScanner struct {
+	client    ChainClient
+	tokens    map[common.Address]TrackedToken
+	labels    map[string]string
+	index     *TransactionIndex
+	prices    HistoricalPriceSource
+	maxBlocks uint64
+	now       func() time.Time
+
+	mu        sync.Mutex
+	histories map[common.Address]*approvalHistory
+	spenders  map[common.Address]*spenderInfo
+}
+
+// NewApprovalScanner creates a scanner of the tracked tokens' approvals. The
+// index dates the last use of an approval and may be nil, as may prices.
+func NewApprovalScanner(client ChainClient, tokens []TrackedToken, labels map[string]string, index *TransactionIndex, prices HistoricalPriceSource, maxBlocks int) *ApprovalScanner {
+	if maxBlocks <= 0 {
+		maxBlocks = DefaultApprovalScanMaxBlocks
+	}
+	tracked := make(map[common.Address]TrackedToken, len(tokens))
+	for _, token := range tokens {
+		tracked[token.Address] = token
+	}
+	return &ApprovalScanner{
+		client:    client,
+		tokens:    tracked,
+		labels:    labels,
+		index:     index,
+		prices:    prices,
+		maxBlocks: uint64(maxBlocks),
+		now:       utcNow,
+		histories: make(map[common.Address]*approvalHistory),
+		spenders:  make(map[common.Address]*spenderInfo),
+	}
+}
+
+// Assess reports the owner's standing approvals that are a medium or high
+// risk, riskiest first
+func (s *ApprovalScanner) Assess(ctx context.Context, owner common.Address) (*ApprovalReport, error) {
+	now := s.now()
+	head, err := s.client.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head block: %w", err)
+	}
+	history, err := s.scan(ctx, owner, head, now)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ApprovalReport{
+		Owner:       owner.Hex(),
+		Approvals:   []ApprovalRisk{},
+		FromBlock:   history.fromBlock,
+		ToBlock:     history.toBlock,
+		Truncated:   history.truncated,
+		GeneratedAt: NewAPITime(now),
+	}
+	if s.index == nil || !s.index.Indexed(owner) {
+		report.markPartial("transaction history isn't indexed, so approvals count as unused since they were made")
+	}
+	var chainID string
+	if id, err := s.client.ChainID(ctx); err == nil {
+		chainID = id.String()
+	}
+
+	keys := make([]approvalKey, 0, len(history.latest))
+	for key := range history.latest {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].token != keys[j].token {
+			return keys[i].token.Hex() < keys[j].token.Hex()
+		}
+		return keys[i].spender.Hex() < keys[j].spender.Hex()
+	})
+
+	headers := make(map[uint64]time.Time)
+	for _, key := range keys {
+		event := history.latest[key]
+		allowance, err := s.allowance(ctx, owner, key)
+		if err != nil {
+			report.markPartial(fmt.Sprintf("live %s allowance of %s unavailable, using the last Approval event: %v", s.tokens[key.token].Symbol, key.spender.Hex(), err))
+			allowance = event.value
+		}
+		if allowance.Sign() == 0 {
+			continue
+		}
+		report.Active++
+
+		approvedAt, ok := headers[event.block]
+		if !ok {
+			header, err := s.client.HeaderByNumber(ctx, new(big.Int).SetUint64(event.block))
+			if err != nil {
+				return nil, fmt.Errorf("failed to get header of block %d: %w", event.block, err)
+			}
+			approvedAt = time.Unix(int64(header.Time), 0).UTC()
+			headers[event.block] = approvedAt
+		}
+		approval := s.approval(ctx, owner, key, allowance, event, approvedAt, now)
+		spender, err := s.spender(ctx, key.spender, head, now)
+		if err != nil {
+			return nil, err
+		}
+		risk := scoreApproval(approval, spender, now)
+		if risk.Score < approvalMediumScore {
+			continue
+		}
+		risk.Revoke = revokeTx(chainID, owner, key)
+		report.Approvals = append(report.Approvals, risk)
+	}
+	sort.SliceStable(report.Approvals, func(i, j int) bool {
+		return report.Approvals[i].Score > report.Approvals[j].Score
+	})
+	return report, nil
+}
+
+// scan extends the owner's folded Approval history to the head and returns
+// a copy of it
+func (s *ApprovalScanner) scan(ctx context.Context, owner common.Address, head uint64, now time.Time) (approvalHistory, error) {
+	s.mu.Lock()
+	s.prune(now)
+	history, ok := s.histories[owner]
+	if !ok {
+		history = &approvalHistory{latest: make(map[approvalKey]approvalEvent)}
+		if head+1 > s.maxBlocks {
+			history.fromBlock = head + 1 - s.maxBlocks
+			history.truncated = true
+		}
+	}
+	from := history.fromBlock
+	if ok {
+		from = history.toBlock + 1
+	}
+	s.mu.Unlock()
+
+	tokens := make([]common.Address, 0, len(s.tokens))
+	for address := range s.tokens {
+		tokens = append(tokens, address)
+	}
+	var events []types.Log
+	for start := from; start <= head && len(tokens) > 0; start += approvalScanChunk {
+		end := min(start+approvalScanChunk-1, head)
+		logs, err := s.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(start),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: tokens,
+			Topics:    [][]common.Hash{{approvalTopic}, {common.BytesToHash(owner.Bytes())}},
+		})
+		if err != nil {
+			return approvalHistory{}, fmt.Errorf("failed to filter approvals from block %d: %w", start, err)
+		}
+		events = append(events, logs...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, log := range events {
+		// ERC-721 approvals share the signature with the token ID indexed
+		if len(log.Topics) != 3 || len(log.Data) != 32 {
+			continue
+		}
+		key := approvalKey{token: log.Address, spender: common.BytesToAddress(log.Topics[2].Bytes())}
+		event := approvalEvent{value: new(big.Int).SetBytes(log.Data), block: log.BlockNumber, index: log.Index, tx: log.TxHash}
+		if current, ok := history.latest[key]; !ok || event.after(current) {
+			history.latest[key] = event
+		}
+	}
+	// A zero approval revokes, so nothing stands for it
+	for key, event := range history.latest {
+		if event.value.Sign() == 0 {
+			delete(history.latest, key)
+		}
+	}
+	if head > history.toBlock {
+		history.toBlock = head
+	}
+	history.scannedAt = now
+	s.histories[owner] = history
+
+	copied := *history
+	copied.latest = make(map[approvalKey]approvalEvent, len(history.latest))
+	for key, event := range history.latest {
+		copied.latest[key] = event
+	}
+	return copied, nil
+}
+
+// prune drops histories and spenders past their TTL. The caller must hold mu.
+func (s *ApprovalScanner) prune(now time.Time) {
+	for owner, history := range s.histories {
+		if now.Sub(history.scannedAt) > approvalHistoryTTL {
+			delete(s.histories, owner)
+		}
+	}
+	for spender, info := range s.spenders {
+		if now.Sub(info.checkedAt) > approvalSpenderTTL {
+			delete(s.spenders, spender)
+		}
+	}
+}
+
+// allowance reads the live allowance of the owner to the spender
+func (s *ApprovalScanner) allowance(ctx context.Context, owner common.Address, key approvalKey) (*big.Int, error) {
+	data := append(append(append([]byte{}, erc20AllowanceSelector...), common.LeftPadBytes(owner.Bytes(), 32)...), common.LeftPadBytes(key.spender.Bytes(), 32)...)
+	token := key.token
+	result, err := s.client.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
+// approval describes a standing allowance, dating its last use from the
+// owner's indexed transactions to the spender
+func (s *ApprovalScanner) approval(ctx context.Context, owner common.Address, key approvalKey, allowance *big.Int, event approvalEvent, approvedAt, now time.Time) TokenApproval {
+	token := s.tokens[key.token]
+	approval := TokenApproval{
+		Owner:        owner.Hex(),
+		Token:        key.token.Hex(),
+		Symbol:       token.Symbol,
+		Spender:      key.spender.Hex(),
+		SpenderLabel: s.labels[strings.ToLower(key.spender.Hex())],
+		Allowance:    allowance.String(),
+		Unlimited:    allowance.Cmp(unlimitedAllowance) >= 0,
+		ApprovedAt:   NewAPITime(approvedAt),
+		ApprovalTx:   event.tx.Hex(),
+		LastUsed:     NewAPITime(approvedAt),
+	}
+	if !approval.Unlimited {
+		approval.AllowanceFloat = weiToFloat(allowance, token.Decimals)
+		if s.prices != nil {
+			if sample, err := samplePrice(ctx, s.prices, token.Symbol, now); err == nil {
+				approval.AllowanceUSD = approval.AllowanceFloat * sample.PriceUSD
+			}
+		}
+	}
+	if s.index != nil {
+		spender := strings.ToLower(key.spender.Hex())
+		for _, tx := range s.index.Transactions(owner, approvedAt, now) {
+			if tx.To == spender && tx.Timestamp.After(approval.LastUsed.Time) {
+				approval.LastUsed = NewAPITime(tx.Timestamp)
+			}
+		}
+	}
+	return approval
+}
+
+// spender returns whether the spender is a contract and when it was
+// deployed, which is unknown when the node keeps no old state
+func (s *ApprovalScanner) spender(ctx context.Context, spender common.Address, head uint64, now time.Time) (spenderInfo, error) {
+	s.mu.Lock()
+	cached, ok := s.spenders[spender]
+	s.mu.Unlock()
+	if ok {
+		return *cached, nil
+	}
+
+	info := &spenderInfo{checkedAt: now}
+	code, err := s.client.CodeAt(ctx, spender, nil)
+	if err != nil {
+		return spenderInfo{}, fmt.Errorf("failed to get code of spender %s: %w", spender.Hex(), err)
+	}
+	info.contract = len(code) > 0
+	if info.contract {
+		block, err := firstCodeBlock(ctx, s.client, spender, head)
+		if err != nil {
+			return spenderInfo{}, err
+		}
+		if block > 0 {
+			if header, err := s.client.HeaderByNumber(ctx, new(big.Int).SetUint64(block)); err == nil {
+				deployedAt := time.Unix(int64(header.Time), 0).UTC()
+				info.deployedAt = &deployedAt
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.spenders[spender] = info
+	s.mu.Unlock()
+	return *info, nil
+}
+
+// scoreApproval weighs the approval's amount by the larger of the risk its
+// spender and its idle time carry, so a large allowance to a well-known,
+// recently used spender stays low
+func scoreApproval(approval TokenApproval, spender spenderInfo, now time.Time) ApprovalRisk {
+	risk := ApprovalRisk{TokenApproval: approval, SpenderIsContract: spender.contract}
+	if spender.deployedAt != nil {
+		deployedAt := NewAPITime(*spender.deployedAt)
+		risk.SpenderDeployedAt = &deployedAt
+	}
+
+	var amount float64
+	switch {
+	case approval.Unlimited:
+		amount = 1
+		risk.Reasons = append(risk.Reasons, "unlimited allowance")
+	case approval.AllowanceUSD >= approvalLargeUSD:
+		amount = 0.8
+		risk.Reasons = append(risk.Reasons, "allowance worth $"+NumberFormat{}.Compact(approval.AllowanceUSD))
+	case approval.AllowanceUSD >= approvalSmallUSD:
+		amount = 0.5
+	case approval.AllowanceUSD > 0:
+		amount = 0.2
+	default:
+		// Unpriced allowances could be worth anything
+		amount = 0.5
+	}
+
+	var reputation float64
+	var reputationReason string
+	switch {
+	case approval.SpenderLabel != "":
+		reputation = 0.1
+	case !spender.contract:
+		reputation, reputationReason = 1, "spender is not a contract"
+	case spender.deployedAt != nil && now.Sub(*spender.deployedAt) < approvalNewSpender:
+		reputation, reputationReason = 0.8, "unlabeled spender deployed "+approxDuration(now.Sub(*spender.deployedAt))+" ago"
+	default:
+		reputation, reputationReason = 0.5, "unlabeled spender"
+	}
+
+	idle := now.Sub(approval.LastUsed.Time)
+	recency, recencyReason := 0.1, ""
+	switch {
+	case idle >= approvalStaleAfter:
+		recency, recencyReason = 1, "unused for "+approxDuration(idle)
+	case idle >= approvalIdleAfter:
+		recency, recencyReason = 0.5, "unused for "+approxDuration(idle)
+	}
+	if reputationReason != "" {
+		risk.Reasons = append(risk.Reasons, reputationReason)
+	}
+	if recencyReason != "" {
+		risk.Reasons = append(risk.Reasons, recencyReason)
+	}
+
+	// The recommendation gives the reason that drives the score
+	lead := recencyReason
+	if reputation > recency || lead == "" {
+		lead = reputationReason
+	}
+	risk.Score = math.Round(amount*math.Max(reputation, recency)*100) / 100
+	switch {
+	case risk.Score >= approvalHighScore:
+		risk.Level = ApprovalRiskHigh
+	case risk.Score >= approvalMediumScore:
+		risk.Level = ApprovalRiskMedium
+	default:
+		risk.Level = ApprovalRiskLow
+	}
+	risk.Recommendation = approvalRecommendation(approval, lead)
+	return risk
+}
+
+// approvalRecommendation phrases the revocation of an approval, such as
+// "Revoke unlimited USDT approval to 0xabcd…ef01, unused for 9 months"
+func approvalRecommendation(approval TokenApproval, reason string) string {
+	amount := "unlimited"
+	if !approval.Unlimited {
+		amount = strconv.FormatFloat(approval.AllowanceFloat, 'f', -1, 64)
+	}
+	spender := shortAddress(approval.Spender)
+	if approval.SpenderLabel != "" {
+		spender = fmt.Sprintf("%s (%s)", approval.SpenderLabel, spender)
+	}
+	recommendation := fmt.Sprintf("Revoke %s %s approval to %s", amount, approval.Symbol, spender)
+	if reason != "" {
+		recommendation += ", " + reason
+	}
+	return recommendation
+}
+
+// revokeTx builds the approve(spender, 0) call of the owner on the token
+func revokeTx(chainID string, owner common.Address, key approvalKey) RevokeTx {
+	data := append(append(append([]byte{}, erc20ApproveSelector...), common.LeftPadBytes(key.spender.Bytes(), 32)...), make([]byte, 32)...)
+	return RevokeTx{
+		ChainID: chainID,
+		From:    strings.ToLower(owner.Hex()),
+		To:      strings.ToLower(key.token.Hex()),
+		Value:   "0",
+		Data:    hexutil.Encode(data),
+	}
+}
+
+// approxDuration renders a duration in whole days, months, or years
+func approxDuration(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case days < 60:
+		return plural(days, "day")
+	case days < 730:
+		return plural(days/30, "month")
+	default:
+		return plural(days/365, "year")
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// approvalGenesis is the time of block 0; blocks are a day apart
+var approvalGenesis = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	approvalOwner   = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	approvalUSDT    = common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	approvalWKAIA   = common.HexToAddress("0x00000000000000000000000000000000000000c2")
+	approvalRouter  = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	approvalDrainer = common.HexToAddress("0x00000000000000000000000000000000000000d2")
+	approvalNew     = common.HexToAddress("0x00000000000000000000000000000000000000d3")
+	approvalVault   = common.HexToAddress("0x00000000000000000000000000000000000000d4")
+	approvalSpent   = common.HexToAddress("0x00000000000000000000000000000000000000d5")
+)
+
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// approvalChain serves Approval logs, allowances, and contract code
+type approvalChain struct {
+	ChainClient
+
+	head       uint64
+	logs       []types.Log
+	allowances map[approvalKey]*big.Int
+	// deployed is the block each contract's code appeared at
+	deployed map[common.Address]uint64
+	// scannedFrom is the lowest block of the last FilterLogs calls
+	scannedFrom uint64
+}
+
+func (c *approvalChain) blockTime(number uint64) time.Time {
+	return approvalGenesis.Add(time.Duration(number) * 24 * time.Hour)
+}
+
+func (c *approvalChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.head, nil
+}
+
+func (c *approvalChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(8217), nil
+}
+
+func (c *approvalChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: number, Time: uint64(c.blockTime(number.Uint64()).Unix())}, nil
+}
+
+func (c *approvalChain) CodeAt(ctx context.Context, account common.Address, number *big.Int) ([]byte, error) {
+	at := c.head
+	if number != nil {
+		at = number.Uint64()
+	}
+	if block, ok := c.deployed[account]; ok && block <= at {
+		return []byte{0x60}, nil
+	}
+	return nil, nil
+}
+
+func (c *approvalChain) CallContract(ctx context.Context, msg ethereum.CallMsg, number *big.Int) ([]byte, error) {
+	if !strings.HasPrefix(common.Bytes2Hex(msg.Data), "dd62ed3e") || common.BytesToAddress(msg.Data[4:36]) != approvalOwner {
+		return nil, ethereum.NotFound
+	}
+	allowance, ok := c.allowances[approvalKey{token: *msg.To, spender: common.BytesToAddress(msg.Data[36:68])}]
+	if !ok {
+		allowance = new(big.Int)
+	}
+	return common.LeftPadBytes(allowance.Bytes(), 32), nil
+}
+
+func (c *approvalChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	if c.scannedFrom == 0 || from < c.scannedFrom {
+		c.scannedFrom = from
+	}
+	var matched []types.Log
+	for _, log := range c.logs {
+		if log.BlockNumber < from || log.BlockNumber > to || log.Topics[0] != query.Topics[0][0] || log.Topics[1] != query.Topics[1][0] {
+			continue
+		}
+		for _, address := range query.Addresses {
+			if log.Address == address {
+				matched = append(matched, log)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// approve emits an Approval of the owner and sets the live allowance
+func (c *approvalChain) approve(block uint64, token, spender common.Address, value *big.Int) {
+	c.logs = append(c.logs, types.Log{
+		Address:     token,
+		Topics:      []common.Hash{approvalTopic, common.BytesToHash(approvalOwner.Bytes()), common.BytesToHash(spender.Bytes())},
+		Data:        common.LeftPadBytes(value.Bytes(), 32),
+		BlockNumber: block,
+		Index:       uint(len(c.logs)),
+		TxHash:      common.BigToHash(big.NewInt(int64(len(c.logs) + 1))),
+	})
+	c.allowances[approvalKey{token: token, spender: spender}] = value
+}
+
+// newApprovalChain builds approval histories with revoked, superseded,
+// spent, and standing approvals, at block 400
+func newApprovalChain() *approvalChain {
+	chain := &approvalChain{
+		head:       400,
+		allowances: make(map[approvalKey]*big.Int),
+		deployed: map[common.Address]uint64{
+			approvalUSDT: 1, approvalWKAIA: 1, approvalRouter: 10, approvalVault: 5, approvalNew: 395,
+		},
+	}
+	// Unlimited to a labeled router, unused for 300 days
+	chain.approve(100, approvalUSDT, approvalRouter, maxUint256)
+	// Unlimited to an EOA, revoked since
+	chain.approve(50, approvalUSDT, approvalDrainer, maxUint256)
+	chain.approve(60, approvalUSDT, approvalDrainer, new(big.Int))
+	// Unlimited to the router, superseded by a small allowance
+	chain.approve(120, approvalWKAIA, approvalRouter, maxUint256)
+	chain.approve(390, approvalWKAIA, approvalRouter, new(big.Int).Mul(big.NewInt(5), big.NewInt(1e18)))
+	// Unlimited to an unlabeled contract deployed five days ago
+	chain.approve(396, approvalUSDT, approvalNew, maxUint256)
+	// Unlimited to an unlabeled vault, used ten days ago
+	chain.approve(300, approvalWKAIA, approvalVault, maxUint256)
+	// A limited allowance that transferFrom has used up without an event
+	chain.approve(200, approvalUSDT, approvalSpent, big.NewInt(1000_000000))
+	chain.allowances[approvalKey{token: approvalUSDT, spender: approvalSpent}] = new(big.Int)
+
+	// An ERC-721 approval, with the token ID indexed, and another owner's
+	chain.logs = append(chain.logs, types.Log{
+		Address:     approvalUSDT,
+		Topics:      []common.Hash{approvalTopic, common.BytesToHash(approvalOwner.Bytes()), common.BytesToHash(approvalDrainer.Bytes()), common.BigToHash(big.NewInt(7))},
+		BlockNumber: 210,
+	}, types.Log{
+		Address:     approvalUSDT,
+		Topics:      []common.Hash{approvalTopic, common.BytesToHash(approvalDrainer.Bytes()), common.BytesToHash(approvalRouter.Bytes())},
+		Data:        common.LeftPadBytes(maxUint256.Bytes(), 32),
+		BlockNumber: 220,
+	})
+	return chain
+}
+
+func newTestApprovalScanner(chain *approvalChain) *ApprovalScanner {
+	index := NewTransactionIndex()
+	index.Add(IndexedTransaction{Hash: "0x01", From: approvalOwner.Hex(), To: approvalVault.Hex(), Timestamp: chain.blockTime(390)})
+	index.MarkBackfilled(approvalOwner)
+
+	tokens := []TrackedToken{{Symbol: "USDT", Address: approvalUSDT, Decimals: 6}, {Symbol: "WKAIA", Address: approvalWKAIA, Decimals: 18}}
+	labels := map[string]string{strings.ToLower(approvalRouter.Hex()): "KaiaSwap Router"}
+	scanner := NewApprovalScanner(chain, tokens, labels, index, fakePrices{"WKAIA": 0.2}, 0)
+	scanner.now = func() time.Time { return chain.blockTime(chain.head) }
+	return scanner
+}
+
+func TestApprovalScannerReportsActiveRiskyApprovals(t *testing.T) {
+	chain := newApprovalChain()
+	scanner := newTestApprovalScanner(chain)
+
+	report, err := scanner.Assess(context.Background(), approvalOwner)
+	require.NoError(t, err)
+	assert.False(t, report.Partial, report.PartialReasons)
+	assert.False(t, report.Truncated)
+	assert.Equal(t, 4, report.Active, "revoked and used up approvals aren't standing")
+	require.Len(t, report.Approvals, 3, "the small superseding approval to the router isn't risky")
+
+	stale := report.Approvals[0]
+	assert.Equal(t, approvalRouter.Hex(), stale.Spender)
+	assert.Equal(t, "USDT", stale.Symbol)
+	assert.True(t, stale.Unlimited)
+	assert.Equal(t, ApprovalRiskHigh, stale.Level)
+	assert.Equal(t, 1.0, stale.Score)
+	assert.Equal(t, []string{"unlimited allowance", "unused for 10 months"}, stale.Reasons)
+	assert.Equal(t, "Revoke unlimited USDT approval to KaiaSwap Router (0x0000…00D1), unused for 10 months", stale.Recommendation)
+	assert.Equal(t, RevokeTx{
+		ChainID: "8217",
+		From:    strings.ToLower(approvalOwner.Hex()),
+		To:      strings.ToLower(approvalUSDT.Hex()),
+		Value:   "0",
+		Data:    "0x095ea7b3" + strings.Repeat("0", 62) + "d1" + strings.Repeat("0", 64),
+	}, stale.Revoke)
+
+	fresh := report.Approvals[1]
+	assert.Equal(t, approvalNew.Hex(), fresh.Spender)
+	assert.Equal(t, ApprovalRiskHigh, fresh.Level)
+	assert.Equal(t, 0.8, fresh.Score)
+	require.NotNil(t, fresh.SpenderDeployedAt)
+	assert.Equal(t, chain.blockTime(395), fresh.SpenderDeployedAt.Time)
+	assert.Equal(t, "Revoke unlimited USDT approval to 0x0000…00D3, unlabeled spender deployed 5 days ago", fresh.Recommendation)
+
+	vault := report.Approvals[2]
+	assert.Equal(t, approvalVault.Hex(), vault.Spender)
+	assert.Equal(t, ApprovalRiskMedium, vault.Level)
+	assert.Equal(t, chain.blockTime(390), vault.LastUsed.Time, "last used by the owner's indexed transaction")
+	assert.Equal(t, []string{"unlimited allowance", "unlabeled spender"}, vault.Reasons)
+}
+
+func TestApprovalScannerExtendsHistory(t *testing.T) {
+	chain := newApprovalChain()
+	scanner := newTestApprovalScanner(chain)
+	_, err := scanner.Assess(context.Background(), approvalOwner)
+	require.NoError(t, err)
+
+	// Ten more blocks an hour later, within the history's TTL
+	scanned := chain.blockTime(400)
+	scanner.now = func() time.Time { return scanned.Add(time.Hour) }
+	chain.head = 410
+	chain.scannedFrom = 0
+	chain.approve(405, approvalUSDT, approvalRouter, new(big.Int))
+	report, err := scanner.Assess(context.Background(), approvalOwner)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(401), chain.scannedFrom, "only the blocks since are replayed")
+	assert.Equal(t, 3, report.Active)
+	for _, approval := range report.Approvals {
+		assert.NotEqual(t, approvalRouter.Hex(), approval.Spender, "the revoked approval is gone")
+	}
+
+	truncated := NewApprovalScanner(chain, nil, nil, nil, nil, 100)
+	report, err = truncated.Assess(context.Background(), approvalOwner)
+	require.NoError(t, err)
+	assert.True(t, report.Truncated)
+	assert.Equal(t, uint64(311), report.FromBlock)
+	assert.True(t, report.Partial, "without an index approvals count as unused since made")
+}
+
+func TestRiskAssessmentRecommendsRevocations(t *testing.T) {
+	chain := newApprovalChain()
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.SetApprovalScanner(newTestApprovalScanner(chain))
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "risk_assessment", map[string]interface{}{"address": approvalOwner.Hex()})
+	require.NoError(t, err)
+	assessment := result.Data.(map[string]interface{})
+	recommendations := assessment["recommendations"].([]string)
+	require.Len(t, recommendations, 6)
+	assert.Equal(t, "Revoke unlimited USDT approval to KaiaSwap Router (0x0000…00D1), unused for 10 months", recommendations[0])
+	assert.Contains(t, assessment["risk_factors"], "3 risky standing token approvals")
+
+	_, err = engine.ProcessAnalyticsTask(context.Background(), "risk_assessment", map[string]interface{}{"address": "0x1234"})
+	assert.ErrorContains(t, err, "invalid address")
+}
+
+func TestAddressSummaryListsRiskyApprovals(t *testing.T) {
+	chain := newApprovalChain()
+	summarizer := newTestSummarizer(fakeNativeBalances{balance: new(big.Int)}, fakeTokenBalances{}, NewTransactionIndex(), chain.blockTime(chain.head))
+	summarizer.SetApprovalScanner(newTestApprovalScanner(chain))
+
+	summary, err := summarizer.Summarize(context.Background(), approvalOwner)
+	require.NoError(t, err)
+	assert.Equal(t, 4, summary.ActiveApprovals)
+	require.Len(t, summary.RiskyApprovals, 3)
+	assert.Equal(t, approvalRouter.Hex(), summary.RiskyApprovals[0].Spender)
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// DefaultAPYCheckBudget is how long the live read of a pool's reward
+	// rate may take before the scanned APY is served instead
+	DefaultAPYCheckBudget = 300 * time.Millisecond
+	// DefaultAPYDivergence is the difference between the live and scanned
+	// APY, in percentage points, above which an opportunity is flagged
+	DefaultAPYDivergence = 1.0
+)
+
+// rewardPoolABI covers the reward rate read of a pool's reward contract,
+// annualized in basis points
+const rewardPoolABI = `[
+	{"type":"function","name":"rewardRateBps","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+// rewardPool is the parsed rewardPoolABI
+var rewardPool = mustParseABI(rewardPoolABI)
+
+// APYChange is how far a pool's live APY moved from the scanned one
+type APYChange struct {
+	// CachedAPY is the APY of the last scan, in percent
+	CachedAPY float64 `json:"cached_apy"`
+	// Delta is the live APY less the cached one, in percentage points
+	Delta float64 `json:"delta"`
+}
+
+// PoolRateReader reads the current reward rate of a pool as an APY in percent
+type PoolRateReader interface {
+	RewardRate(ctx context.Context, contract common.Address) (float64, error)
+}
+
+// ChainPoolRateReader reads reward rates from the pools' reward contracts
+type ChainPoolRateReader struct {
+	caller ethereum.ContractCaller
+}
+
+// NewChainPoolRateReader creates a reader calling reward contracts through caller
+func NewChainPoolRateReader(caller ethereum.ContractCaller) *ChainPoolRateReader {
+	return &ChainPoolRateReader{caller: caller}
+}
+
+// RewardRate reads a reward contract's rate with a single call
+func (r *ChainPoolRateReader) RewardRate(ctx context.Context, contract common.Address) (float64, error) {
+	data, err := rewardPool.Pack("rewardRateBps")
+	if err != nil {
+		return 0, err
+	}
+	result, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the reward rate of %s: %w", contract.Hex(), err)
+	}
+	out, err := rewardPool.Unpack("rewardRateBps", result)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode the reward rate of %s: %w", contract.Hex(), err)
+	}
+	bps, _ := new(big.Float).SetInt(out[0].(*big.Int)).Float64()
+	return bps / 100, nil
+}
+
+// APYChecker re-reads the reward rate of the pools whose opportunities are
+// about to be served, since rates can move a lot between yield scans. A
+// live rate replaces the scanned APY and is recorded in the yield history;
+// a pool whose rate can't be read in time keeps its scanned APY, flagged
+// as stale.
+type APYChecker struct {
+	reader    PoolRateReader
+	yields    *YieldHistory
+	budget    time.Duration
+	threshold float64
+	logger    *log.Logger
+	now       func() time.Time
+}
+
+// NewAPYChecker creates a checker recording live rates in yields
+func NewAPYChecker(reader PoolRateReader, yields *YieldHistory) *APYChecker {
+	return &APYChecker{
+		reader:    reader,
+		yields:    yields,
+		budget:    DefaultAPYCheckBudget,
+		threshold: DefaultAPYDivergence,
+		logger:    log.New(log.Writer(), "[APYChecker] ", log.LstdFlags),
+		now:       utcNow,
+	}
+}
+
+// Check reads the live APY of every opportunity whose pool has a reward
+// contract in the registry, all at once within the budget. Pools without
+// one are served as scanned.
+func (c *APYChecker) Check(ctx context.Context, protocols *ProtocolRegistry, opportunities []YieldOpportunity) {
+	var wg sync.WaitGroup
+	live := make([]bool, len(opportunities))
+	for i := range opportunities {
+		contract, ok := rewardContract(protocols, opportunities[i])
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			live[i] = c.check(ctx, contract, &opportunities[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var checked []YieldOpportunity
+	for i, ok := range live {
+		if ok {
+			checked = append(checked, opportunities[i])
+		}
+	}
+	if len(checked) > 0 {
+		c.yields.Record(checked, c.now())
+	}
+}
+
+// check swaps the live APY into one opportunity, reporting whether it was read
+func (c *APYChecker) check(ctx context.Context, contract common.Address, opportunity *YieldOpportunity) bool {
+	ctx, cancel := context.WithTimeout(ctx, c.budget)
+	defer cancel()
+
+	apy, err := c.reader.RewardRate(ctx, contract)
+	if err != nil {
+		c.logger.Printf("Failed to check the APY of %s %s, serving the scanned one: %v", opportunity.Protocol, opportunity.AssetPair, err)
+		opportunity.APYStale = true
+		return false
+	}
+
+	cached := opportunity.APY
+	if delta := apy - cached; math.Abs(delta) > c.threshold {
+		opportunity.APYChanged = &APYChange{CachedAPY: cached, Delta: roundTo(delta, 2)}
+	}
+	now := c.now()
+	opportunity.APY = apy
+	opportunity.LastUpdated = NewAPITime(now)
+	opportunity.LastUpdatedUnix = now.Unix()
+	return true
+}
+
+// rewardContract returns the reward contract the registry knows for an
+// opportunity's pool
+func rewardContract(protocols *ProtocolRegistry, opportunity YieldOpportunity) (common.Address, bool) {
+	protocol := protocols.Protocol(opportunity.Protocol)
+	if protocol == nil {
+		return common.Address{}, false
+	}
+	pool := protocol.Pool(opportunity.AssetPair)
+	if pool == nil || !common.IsHexAddress(pool.RewardContract) {
+		return common.Address{}, false
+	}
+	return common.HexToAddress(pool.RewardContract), true
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	rewardPoolETH  = common.HexToAddress("0x00000000000000000000000000000000000000f1")
+	rewardPoolUSDC = common.HexToAddress("0x00000000000000000000000000000000000000f2")
+)
+
+// fakeRewardPools answers rewardRateBps with a rate per contract. Contracts
+// in hang don't answer until the call is cancelled.
+type fakeRewardPools struct {
+	bps  map[common.Address]int64
+	hang map[common.Address]bool
+}
+
+func (f *fakeRewardPools) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if f.hang[*call.To] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	bps, ok := f.bps[*call.To]
+	if !ok {
+		return nil, errors.New("execution reverted")
+	}
+	return rewardPool.Methods["rewardRateBps"].Outputs.Pack(big.NewInt(bps))
+}
+
+func apyCheckRegistry() *ProtocolRegistry {
+	registry := DefaultProtocolRegistry()
+	registry.Protocols[0].Pools = []ProtocolPool{{AssetPair: "ETH/USDC", RewardContract: rewardPoolETH.Hex()}}
+	registry.Protocols[1].Pools = []ProtocolPool{{AssetPair: "USDC/ETH", RewardContract: rewardPoolUSDC.Hex()}}
+	return registry
+}
+
+func scannedOpportunities(yields *YieldHistory, at time.Time) []YieldOpportunity {
+	opportunities := []YieldOpportunity{
+		{Protocol: "Uniswap V3", AssetPair: "ETH/USDC", APY: 12.5, LastUpdated: NewAPITime(at), LastUpdatedUnix: at.Unix()},
+		{Protocol: "Aave V3", AssetPair: "USDC/ETH", APY: 8.2, LastUpdated: NewAPITime(at), LastUpdatedUnix: at.Unix()},
+		{Protocol: "Compound V3", AssetPair: "DAI/USDC", APY: 6.8, LastUpdated: NewAPITime(at), LastUpdatedUnix: at.Unix()},
+	}
+	yields.Record(opportunities, at)
+	return opportunities
+}
+
+func TestAPYCheckerFlagsDivergingPools(t *testing.T) {
+	scanned := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	checkedAt := scanned.Add(4 * time.Minute)
+	yields := NewYieldHistory()
+	opportunities := scannedOpportunities(yields, scanned)
+
+	// The ETH pool's rate dropped from 12.5% to 4%; the USDC one barely moved
+	pools := &fakeRewardPools{bps: map[common.Address]int64{rewardPoolETH: 400, rewardPoolUSDC: 850}}
+	checker := NewAPYChecker(NewChainPoolRateReader(pools), yields)
+	checker.now = func() time.Time { return checkedAt }
+	checker.Check(context.Background(), apyCheckRegistry(), opportunities)
+
+	assert.Equal(t, 4.0, opportunities[0].APY)
+	assert.Equal(t, &APYChange{CachedAPY: 12.5, Delta: -8.5}, opportunities[0].APYChanged)
+	assert.Equal(t, NewAPITime(checkedAt), opportunities[0].LastUpdated)
+	assert.Equal(t, 8.5, opportunities[1].APY)
+	assert.Nil(t, opportunities[1].APYChanged, "a move within the threshold isn't flagged")
+	assert.Equal(t, 6.8, opportunities[2].APY, "pools without a reward contract are served as scanned")
+	assert.Equal(t, NewAPITime(scanned), opportunities[2].LastUpdated)
+	for _, opportunity := range opportunities {
+		assert.False(t, opportunity.APYStale, opportunity.Protocol)
+	}
+
+	// The live rates are recorded in the history after the scan
+	assert.Equal(t, []YieldSample{{Timestamp: scanned, APY: 12.5}, {Timestamp: checkedAt, APY: 4.0}},
+		yields.Samples("Uniswap V3", "ETH/USDC", time.Time{}))
+	assert.Len(t, yields.Samples("Aave V3", "USDC/ETH", time.Time{}), 2)
+	assert.Len(t, yields.Samples("Compound V3", "DAI/USDC", time.Time{}), 1)
+}
+
+func TestAPYCheckerServesCachedAPYOnTimeout(t *testing.T) {
+	scanned := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	yields := NewYieldHistory()
+	opportunities := scannedOpportunities(yields, scanned)
+
+	pools := &fakeRewardPools{bps: map[common.Address]int64{rewardPoolUSDC: 2000}, hang: map[common.Address]bool{rewardPoolETH: true}}
+	checker := NewAPYChecker(NewChainPoolRateReader(pools), yields)
+	checker.budget = 20 * time.Millisecond
+	checker.now = func() time.Time { return scanned.Add(time.Minute) }
+
+	started := time.Now()
+	checker.Check(context.Background(), apyCheckRegistry(), opportunities)
+	assert.Less(t, time.Since(started), time.Second, "a hung pool costs no more than the budget")
+
+	assert.True(t, opportunities[0].APYStale)
+	assert.Equal(t, 12.5, opportunities[0].APY)
+	assert.Nil(t, opportunities[0].APYChanged)
+	assert.Equal(t, []YieldSample{{Timestamp: scanned, APY: 12.5}}, yields.Samples("Uniswap V3", "ETH/USDC", time.Time{}))
+
+	// The other pool is checked all the same
+	assert.False(t, opportunities[1].APYStale)
+	assert.Equal(t, &APYChange{CachedAPY: 8.2, Delta: 11.8}, opportunities[1].APYChanged)
+}
+
+func TestYieldAnalysisChecksLiveAPY(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.SetProtocolRegistry(apyCheckRegistry())
+	engine.SetAPYChecker(NewAPYChecker(NewChainPoolRateReader(&fakeRewardPools{bps: map[common.Address]int64{rewardPoolETH: 2500, rewardPoolUSDC: 820}}), engine.YieldHistory()))
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", nil)
+	require.NoError(t, err)
+	for _, opportunity := range result.Data.([]YieldOpportunity) {
+		if opportunity.Protocol == "Uniswap V3" {
+			assert.Equal(t, 25.0, opportunity.APY)
+			assert.Equal(t, &APYChange{CachedAPY: 12.5, Delta: 12.5}, opportunity.APYChanged)
+			return
+		}
+	}
+	t.Fatal("the Uniswap V3 opportunity is missing")
+}
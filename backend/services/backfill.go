@@ -0,0 +1,476 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Backfill task statuses
+const (
+	BackfillPending = "pending"
+	BackfillRunning = "running"
+	BackfillDone    = "done"
+	BackfillFailed  = "failed"
+	// BackfillCancelled tasks were stopped by a user before they finished
+	BackfillCancelled = "cancelled"
+)
+
+const (
+	// DefaultBackfillMaxBlocks bounds how many blocks a single task scans
+	DefaultBackfillMaxBlocks = 50000
+	// MaxActiveBackfills bounds the tasks queued or running at once
+	MaxActiveBackfills = 256
+	// MaxActiveBackfillsPerRequester bounds the active tasks started for one
+	// requester, such as a client IP
+	MaxActiveBackfillsPerRequester = 4
+
+	backfillRetryAfter = 10 * time.Minute
+	backfillTaskTTL    = 24 * time.Hour
+)
+
+// ErrBackfillLimit is returned when starting a backfill would exceed
+// MaxActiveBackfills, or MaxActiveBackfillsPerRequester for its requester
+var ErrBackfillLimit = errors.New("too many backfills in progress")
+
+// backfillRequesterKey is the context key of the requester backfills are
+// started for
+type backfillRequesterKey struct{}
+
+// WithBackfillRequester tags the backfills started while serving ctx with who
+// asked for them, so one requester can't keep every task slot busy
+func WithBackfillRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, backfillRequesterKey{}, requester)
+}
+
+// BackfillRequester returns the requester ctx was tagged with, or "" for
+// work the service started itself
+func BackfillRequester(ctx context.Context) string {
+	requester, _ := ctx.Value(backfillRequesterKey{}).(string)
+	return requester
+}
+
+// BackfillTask tracks a receipt backfill for one address
+type BackfillTask struct {
+	ID            string     `json:"id"`
+	Address       string     `json:"address"`
+	Since         time.Time  `json:"since"`
+	Status        string     `json:"status"`
+	BlocksScanned int        `json:"blocks_scanned"`
+	TxsIndexed    int        `json:"txs_indexed"`
+	Truncated     bool       `json:"truncated"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+
+	// requester is who the task was started for
+	requester string
+}
+
+// Active reports whether the task is still queued or running
+func (t BackfillTask) Active() bool {
+	return t.Status == BackfillPending || t.Status == BackfillRunning
+}
+
+// ReceiptBackfiller scans recent blocks for an address's transactions and
+// indexes them with their receipts. Each task scans at most maxBlocks blocks,
+// so a window reaching further back than that is left truncated.
+type ReceiptBackfiller struct {
+	client    ChainClient
+	index     *TransactionIndex
+	maxBlocks uint64
+	slots     chan struct{}
+	logger    *log.Logger
+	mu        sync.Mutex
+	ctx       context.Context
+	tasks     map[string]*BackfillTask
+	latest    map[string]string             // address -> most recent task ID
+	cancels   map[string]context.CancelFunc // active task ID -> its cancel
+	now       func() time.Time
+
+	// bridges classifies bridge transfers, including ones a relayer
+	// delivered to the address
+	bridges *BridgeDetector
+}
+
+// NewReceiptBackfiller creates a backfiller running at most maxConcurrency tasks at once
+func NewReceiptBackfiller(client ChainClient, index *TransactionIndex, maxBlocks, maxConcurrency int) *ReceiptBackfiller {
+	if maxBlocks <= 0 {
+		maxBlocks = DefaultBackfillMaxBlocks
+	}
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+
+	return &ReceiptBackfiller{
+		client:    client,
+		index:     index,
+		maxBlocks: uint64(maxBlocks),
+		slots:     make(chan struct{}, maxConcurrency),
+		logger:    log.New(log.Writer(), "[ReceiptBackfiller] ", log.LstdFlags),
+		ctx:       context.Background(),
+		tasks:     make(map[string]*BackfillTask),
+		latest:    make(map[string]string),
+		cancels:   make(map[string]context.CancelFunc),
+		now:       utcNow,
+	}
+}
+
+// SetBridgeDetector classifies the bridge transfers of indexed transactions.
+// It must be set before backfills start.
+func (rb *ReceiptBackfiller) SetBridgeDetector(bridges *BridgeDetector) {
+	rb.bridges = bridges
+}
+
+// Start sets the context tasks run under; running tasks stop when it is cancelled
+func (rb *ReceiptBackfiller) Start(ctx context.Context) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.ctx = ctx
+}
+
+// Ensure returns the backfill task of the address reaching back to since. An
+// active task is returned as is, and so is a finished one that recently
+// covered the same window; otherwise a new task is started for the
+// requester, unless ErrBackfillLimit tasks are already active.
+func (rb *ReceiptBackfiller) Ensure(address common.Address, since time.Time, requester string) (BackfillTask, error) {
+	key := strings.ToLower(address.Hex())
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	now := rb.now()
+	if id, ok := rb.latest[key]; ok {
+		task := rb.tasks[id]
+		if task.Active() {
+			return *task, nil
+		}
+		if !task.Since.After(since) && now.Sub(*task.FinishedAt) < backfillRetryAfter {
+			return *task, nil
+		}
+	}
+
+	if err := rb.checkLimits(requester); err != nil {
+		return BackfillTask{}, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return BackfillTask{}, fmt.Errorf("failed to generate task ID: %w", err)
+	}
+	rb.prune(now)
+
+	task := &BackfillTask{
+		ID:        "bf_" + id,
+		Address:   key,
+		Since:     since,
+		Status:    BackfillPending,
+		CreatedAt: now,
+		requester: requester,
+	}
+	rb.tasks[task.ID] = task
+	rb.latest[key] = task.ID
+
+	ctx, cancel := context.WithCancel(rb.ctx)
+	rb.cancels[task.ID] = cancel
+	go rb.run(ctx, address, task)
+	return *task, nil
+}
+
+// checkLimits returns ErrBackfillLimit when another task can't be started
+// for the requester. Tasks are active while they have a cancel func. Callers
+// must hold rb.mu.
+func (rb *ReceiptBackfiller) checkLimits(requester string) error {
+	if len(rb.cancels) >= MaxActiveBackfills {
+		return fmt.Errorf("%w: %d are running", ErrBackfillLimit, len(rb.cancels))
+	}
+	if requester == "" {
+		return nil
+	}
+	active := 0
+	for id := range rb.cancels {
+		if task := rb.tasks[id]; task != nil && task.requester == requester {
+			active++
+		}
+	}
+	if active >= MaxActiveBackfillsPerRequester {
+		return fmt.Errorf("%w: %d are running for %s", ErrBackfillLimit, active, requester)
+	}
+	return nil
+}
+
+// Cancel stops an active task, which gives up its slot once it reaches its
+// next block. Cancelling a finished task leaves it as is; either way the
+// task's state is returned.
+func (rb *ReceiptBackfiller) Cancel(id string) (BackfillTask, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	task, ok := rb.tasks[id]
+	if !ok {
+		return BackfillTask{}, false
+	}
+	if cancel, active := rb.cancels[id]; active && task.Active() {
+		cancel()
+		finished := rb.now()
+		task.Status = BackfillCancelled
+		task.FinishedAt = &finished
+		rb.logger.Printf("Backfill %s for %s cancelled after %d blocks", task.ID, task.Address, task.BlocksScanned)
+	}
+	return *task, true
+}
+
+// Task returns a snapshot of a task by ID
+func (rb *ReceiptBackfiller) Task(id string) (BackfillTask, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	task, ok := rb.tasks[id]
+	if !ok {
+		return BackfillTask{}, false
+	}
+	return *task, true
+}
+
+// prune drops tasks that finished more than a day ago. Callers must hold rb.mu.
+func (rb *ReceiptBackfiller) prune(now time.Time) {
+	for id, task := range rb.tasks {
+		if task.FinishedAt != nil && now.Sub(*task.FinishedAt) > backfillTaskTTL {
+			delete(rb.tasks, id)
+			if rb.latest[task.Address] == id {
+				delete(rb.latest, task.Address)
+			}
+		}
+	}
+}
+
+// update applies a change to a task under the lock
+func (rb *ReceiptBackfiller) update(task *BackfillTask, change func(*BackfillTask)) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	change(task)
+}
+
+// run waits for a free slot and then scans for the task
+func (rb *ReceiptBackfiller) run(ctx context.Context, address common.Address, task *BackfillTask) {
+	var err error
+	select {
+	case rb.slots <- struct{}{}:
+		rb.update(task, func(t *BackfillTask) {
+			if t.Status == BackfillPending {
+				t.Status = BackfillRunning
+			}
+		})
+		err = rb.scan(ctx, address, task)
+		<-rb.slots
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cancelled := false
+	rb.update(task, func(t *BackfillTask) {
+		if cancel, ok := rb.cancels[t.ID]; ok {
+			cancel()
+			delete(rb.cancels, t.ID)
+		}
+		if cancelled = t.Status == BackfillCancelled; cancelled {
+			return
+		}
+		finished := rb.now()
+		t.FinishedAt = &finished
+		t.Status = BackfillDone
+		if err != nil {
+			t.Status = BackfillFailed
+			t.Error = err.Error()
+		}
+	})
+	if err != nil && !cancelled {
+		rb.logger.Printf("Backfill %s for %s failed: %v", task.ID, task.Address, err)
+	}
+}
+
+// scan first catches up from the chain head to the address's existing
+// coverage, then extends the coverage back to the task's since time, sharing
+// one block budget between the two
+func (rb *ReceiptBackfiller) scan(ctx context.Context, address common.Address, task *BackfillTask) error {
+	head, err := rb.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get head block: %w", err)
+	}
+	chainID, err := rb.client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get chain ID: %w", err)
+	}
+	signer := types.LatestSignerForChainID(chainID)
+	budget := rb.maxBlocks
+
+	coverage, covered := rb.index.Coverage(address)
+	if !covered {
+		return rb.scanDown(ctx, address, signer, task, head, 0, task.Since, &budget)
+	}
+
+	if head > coverage.ToBlock {
+		if err := rb.scanDown(ctx, address, signer, task, head, coverage.ToBlock+1, time.Time{}, &budget); err != nil {
+			return err
+		}
+	}
+	if coverage.From.After(task.Since) && coverage.FromBlock > 0 {
+		return rb.scanDown(ctx, address, signer, task, coverage.FromBlock-1, 0, task.Since, &budget)
+	}
+	return nil
+}
+
+// scanDown indexes the address's transactions from block top down to floor,
+// stopping after the first block older than since or when the budget runs
+// out, and records the scanned range as covered towards the task's window
+func (rb *ReceiptBackfiller) scanDown(ctx context.Context, address common.Address, signer types.Signer, task *BackfillTask, top, floor uint64, since time.Time, budget *uint64) error {
+	var indexed IndexedRange
+	scanned := false
+	defer func() {
+		if scanned {
+			rb.index.MarkIndexed(address, indexed, task.Since)
+		}
+	}()
+
+	for number := top; ; number-- {
+		// Each block is a checkpoint where a cancelled task stops
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if *budget == 0 {
+			rb.update(task, func(t *BackfillTask) { t.Truncated = true })
+			return nil
+		}
+		*budget--
+
+		block, err := rb.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %w", number, err)
+		}
+		found, err := rb.indexBlock(ctx, address, signer, block)
+		if err != nil {
+			return err
+		}
+
+		blockTime := time.Unix(int64(block.Time()), 0).UTC()
+		if !scanned {
+			indexed.ToBlock, indexed.To = number, blockTime
+			scanned = true
+		}
+		indexed.FromBlock, indexed.From = number, blockTime
+		rb.update(task, func(t *BackfillTask) {
+			t.BlocksScanned++
+			t.TxsIndexed += found
+		})
+
+		if number == floor || blockTime.Before(since) {
+			return nil
+		}
+	}
+}
+
+// indexBlock indexes the block's transactions sent by or to the address,
+// including the creation of the address when it is a contract
+func (rb *ReceiptBackfiller) indexBlock(ctx context.Context, address common.Address, signer types.Signer, block *types.Block) (int, error) {
+	found := 0
+	for _, tx := range block.Transactions() {
+		indexed, err := rb.indexTransaction(ctx, address, signer, block, tx)
+		if err != nil {
+			return found, err
+		}
+		if indexed {
+			found++
+		}
+	}
+	return found, nil
+}
+
+// indexTransaction indexes a transaction when it involves the address. A
+// transaction that can't be processed is logged and skipped rather than
+// halting the rest of the block.
+func (rb *ReceiptBackfiller) indexTransaction(ctx context.Context, address common.Address, signer types.Signer, block *types.Block, tx *types.Transaction) (indexed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rb.logger.Printf("Skipping transaction %s in block %d: %v", tx.Hash().Hex(), block.NumberU64(), r)
+			indexed, err = false, nil
+		}
+	}()
+
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return false, nil
+	}
+
+	to := tx.To()
+	var created common.Address
+	if to == nil {
+		created = crypto.CreateAddress(from, tx.Nonce())
+	}
+	involved := from == address || (to != nil && *to == address) || (to == nil && created == address)
+	// Relayers deliver bridge withdrawals, so calls to a bridge are read for
+	// transfers to the address
+	bridged := to != nil && rb.bridges != nil && rb.bridges.Handles(*to)
+	if !involved && !bridged {
+		return false, nil
+	}
+
+	receipt, err := rb.client.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		return false, fmt.Errorf("failed to get receipt for %s: %w", tx.Hash().Hex(), err)
+	}
+	var bridge *BridgeTransfer
+	if bridged && receipt.Status == types.ReceiptStatusSuccessful {
+		bridge = rb.bridges.Classify(receipt.Logs)
+	}
+	if !involved && (bridge == nil || common.HexToAddress(bridge.Recipient) != address) {
+		return false, nil
+	}
+
+	indexedTx := IndexedTransaction{
+		Hash:              tx.Hash().Hex(),
+		BlockNumber:       block.NumberU64(),
+		From:              from.Hex(),
+		Timestamp:         time.Unix(int64(block.Time()), 0).UTC(),
+		GasUsed:           receipt.GasUsed,
+		EffectiveGasPrice: effectiveGasPrice(tx, receipt, block.BaseFee()),
+		Bridge:            bridge,
+	}
+	if to != nil {
+		indexedTx.To = to.Hex()
+	} else {
+		if receipt.ContractAddress != (common.Address{}) {
+			created = receipt.ContractAddress
+		}
+		indexedTx.ContractCreation = true
+		indexedTx.ContractAddress = created.Hex()
+	}
+	// Reverted transactions don't move their value
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		indexedTx.Value = tx.Value()
+	}
+	rb.index.Add(indexedTx)
+	return true, nil
+}
+
+// effectiveGasPrice returns the price the sender paid per gas, deriving it
+// from the block base fee when the node omits it from the receipt
+func effectiveGasPrice(tx *types.Transaction, receipt *types.Receipt, baseFee *big.Int) *big.Int {
+	if receipt.EffectiveGasPrice != nil {
+		return receipt.EffectiveGasPrice
+	}
+	if baseFee == nil {
+		return tx.GasPrice()
+	}
+	tip := tx.EffectiveGasTipValue(baseFee)
+	return tip.Add(tip, baseFee)
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillIndexesContractCreations(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	chainID := big.NewInt(8217)
+	signer := types.LatestSignerForChainID(chainID)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	dex := common.HexToAddress(feeDex)
+
+	creation := types.MustSignNewTx(key, signer, &types.LegacyTx{
+		Nonce:    7,
+		GasPrice: big.NewInt(30 * gwei),
+		Gas:      500_000,
+		Value:    big.NewInt(1e18),
+		Data:     []byte{0x60, 0x80, 0x60, 0x40},
+	})
+	swap := types.MustSignNewTx(key, signer, &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     8,
+		GasTipCap: big.NewInt(gwei),
+		GasFeeCap: big.NewInt(50 * gwei),
+		Gas:       100_000,
+		To:        &dex,
+	})
+	// A node returning an empty receipt would crash the indexer without the
+	// recover boundary
+	broken := types.MustSignNewTx(key, signer, &types.LegacyTx{Nonce: 9, GasPrice: big.NewInt(30 * gwei), Gas: 21_000, To: &dex})
+
+	header := &types.Header{
+		Number:  big.NewInt(1),
+		Time:    uint64(start.Unix()),
+		BaseFee: big.NewInt(25 * gwei),
+	}
+	chain := &fakeChain{
+		chainID: chainID,
+		blocks: map[uint64]*types.Block{
+			1: types.NewBlockWithHeader(header).WithBody([]*types.Transaction{creation, broken, swap}, nil),
+		},
+		receipts: map[common.Hash]*types.Receipt{
+			creation.Hash(): {Status: types.ReceiptStatusSuccessful, GasUsed: 300_000},
+			swap.Hash():     {Status: types.ReceiptStatusSuccessful, GasUsed: 21_000},
+			broken.Hash():   nil,
+		},
+		head: 1,
+	}
+
+	index := NewTransactionIndex()
+	backfills := NewReceiptBackfiller(chain, index, 10, 1)
+
+	var found int
+	require.NotPanics(t, func() {
+		found, err = backfills.indexBlock(context.Background(), sender, signer, chain.blocks[1])
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, found)
+
+	txs := index.Transactions(sender, start, start)
+	require.Len(t, txs, 2)
+	deployed := crypto.CreateAddress(sender, 7)
+
+	created := txs[0]
+	assert.Equal(t, creation.Hash().Hex(), created.Hash)
+	assert.Equal(t, strings.ToLower(sender.Hex()), created.From)
+	assert.Empty(t, created.To)
+	assert.True(t, created.ContractCreation)
+	assert.Equal(t, strings.ToLower(deployed.Hex()), created.ContractAddress)
+	assert.Equal(t, big.NewInt(1e18), created.Value)
+
+	// The sender of the dynamic-fee transaction is recovered too
+	assert.Equal(t, swap.Hash().Hex(), txs[1].Hash)
+	assert.Equal(t, strings.ToLower(sender.Hex()), txs[1].From)
+	assert.Equal(t, feeDex, txs[1].To)
+	assert.False(t, txs[1].ContractCreation)
+	assert.Equal(t, big.NewInt(26*gwei), txs[1].EffectiveGasPrice)
+
+	// The deployed contract's own history starts with its creation
+	found, err = backfills.indexBlock(context.Background(), deployed, signer, chain.blocks[1])
+	require.NoError(t, err)
+	assert.Equal(t, 1, found)
+	history, err := index.AddressHistory(context.Background(), deployed, start)
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.TxCount)
+	assert.Equal(t, 1, history.Counterparties[strings.ToLower(sender.Hex())])
+}
+
+// slowChain takes a while to serve each block and ignores cancellation while
+// it does, like a node slow to answer
+type slowChain struct {
+	*fakeChain
+	delay  time.Duration
+	served atomic.Int32
+}
+
+func (sc *slowChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	time.Sleep(sc.delay)
+	sc.served.Add(1)
+	return sc.fakeChain.BlockByNumber(ctx, number)
+}
+
+func TestBackfillCancelStopsAtNextBlock(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	fake, sender := newFakeChain(t, 200, start)
+	chain := &slowChain{fakeChain: fake, delay: 20 * time.Millisecond}
+	backfills := NewReceiptBackfiller(chain, NewTransactionIndex(), 0, 1)
+
+	task, err := backfills.Ensure(sender, start, "")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return chain.served.Load() >= 3 }, 5*time.Second, time.Millisecond)
+
+	cancelled, ok := backfills.Cancel(task.ID)
+	require.True(t, ok)
+	assert.Equal(t, BackfillCancelled, cancelled.Status)
+	assert.NotNil(t, cancelled.FinishedAt)
+
+	// The scan gives up its slot within one block of the cancel
+	require.Eventually(t, func() bool { return len(backfills.slots) == 0 }, 2*chain.delay, time.Millisecond)
+	served := chain.served.Load()
+	time.Sleep(3 * chain.delay)
+	assert.Equal(t, served, chain.served.Load(), "no blocks are read after the slot is released")
+
+	finished, ok := backfills.Task(task.ID)
+	require.True(t, ok)
+	assert.Equal(t, BackfillCancelled, finished.Status)
+	assert.Less(t, finished.BlocksScanned, 200)
+	assert.Empty(t, finished.Error)
+
+	// Cancelling again returns the final state unchanged
+	again, ok := backfills.Cancel(task.ID)
+	require.True(t, ok)
+	assert.Equal(t, finished, again)
+	_, ok = backfills.Cancel("bf_missing")
+	assert.False(t, ok)
+}
+
+func TestBackfillCancelFinishedTaskIsNoop(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	chain, sender := newFakeChain(t, 5, start)
+	backfills := NewReceiptBackfiller(chain, NewTransactionIndex(), 0, 1)
+
+	task, err := backfills.Ensure(sender, start.Add(2*time.Minute), "")
+	require.NoError(t, err)
+	finished := waitForBackfill(t, backfills, task.ID)
+	require.Equal(t, BackfillDone, finished.Status)
+
+	cancelled, ok := backfills.Cancel(task.ID)
+	require.True(t, ok)
+	assert.Equal(t, finished, cancelled)
+}
+
+func TestBackfillToGenesisCompletesSummaryHistory(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	chain, sender := newFakeChain(t, 20, start)
+	chain.blocks[0] = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0), Time: uint64(start.Unix())})
+	index := NewTransactionIndex()
+	summarizer := newTestSummarizer(fakeNativeBalances{balance: big.NewInt(0)}, fakeTokenBalances{}, index, start.Add(21*time.Minute))
+
+	// A scan cut short by its budget leaves the history incomplete
+	truncated := NewReceiptBackfiller(chain, index, 5, 1)
+	task, err := truncated.Ensure(sender, time.Time{}, "")
+	require.NoError(t, err)
+	task = waitForBackfill(t, truncated, task.ID)
+	require.True(t, task.Truncated)
+	summary, err := summarizer.Summarize(context.Background(), sender)
+	require.NoError(t, err)
+	assert.True(t, summary.Partial)
+	assert.Contains(t, summary.PartialReasons, "transaction history index has not finished backfilling this address")
+
+	// Reaching the genesis block completes it
+	backfills := NewReceiptBackfiller(chain, index, 100, 1)
+	task, err = backfills.Ensure(sender, time.Time{}, "")
+	require.NoError(t, err)
+	task = waitForBackfill(t, backfills, task.ID)
+	require.Equal(t, BackfillDone, task.Status)
+	assert.False(t, task.Truncated)
+
+	summary, err = summarizer.Summarize(context.Background(), sender)
+	require.NoError(t, err)
+	assert.False(t, summary.Partial, summary.PartialReasons)
+	assert.Equal(t, 20, summary.TxCount30d)
+	assert.Equal(t, NewAPITime(start.Add(time.Minute)), summary.FirstSeen)
+}
+
+func TestBackfillToWindowStartCompletesHistory(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	chain, sender := newFakeChain(t, 20, start)
+	index := NewTransactionIndex()
+	since := start.Add(10 * time.Minute)
+
+	// The window's lower bound is well after genesis, which the scan never reads
+	backfills := NewReceiptBackfiller(chain, index, 100, 1)
+	task, err := backfills.Ensure(sender, since, "")
+	require.NoError(t, err)
+	task = waitForBackfill(t, backfills, task.ID)
+	require.Equal(t, BackfillDone, task.Status)
+	assert.False(t, task.Truncated)
+
+	coverage, ok := index.Coverage(sender)
+	require.True(t, ok)
+	assert.Greater(t, coverage.FromBlock, uint64(0))
+	history, err := index.AddressHistory(context.Background(), sender, since)
+	require.NoError(t, err)
+	assert.True(t, history.Backfilled)
+}
+
+func TestDisjointCoverageResetsBackfilled(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	address := summaryAddress
+	index := NewTransactionIndex()
+	backfilled := func() bool {
+		history, err := index.AddressHistory(context.Background(), address, start)
+		require.NoError(t, err)
+		return history.Backfilled
+	}
+
+	index.MarkIndexed(address, IndexedRange{FromBlock: 50, ToBlock: 100, From: start, To: start.Add(time.Hour)}, start.Add(time.Minute))
+	require.True(t, backfilled())
+
+	// Adjoining coverage keeps the window complete
+	index.MarkIndexed(address, IndexedRange{FromBlock: 101, ToBlock: 120, From: start.Add(time.Hour), To: start.Add(2 * time.Hour)}, time.Time{})
+	assert.True(t, backfilled())
+
+	// Coverage with a gap before it replaces the old range, whose window no
+	// longer counts
+	index.MarkIndexed(address, IndexedRange{FromBlock: 200, ToBlock: 210, From: start.Add(3 * time.Hour), To: start.Add(4 * time.Hour)}, start.Add(time.Minute))
+	assert.False(t, backfilled())
+	coverage, ok := index.Coverage(address)
+	require.True(t, ok)
+	assert.Equal(t, uint64(200), coverage.FromBlock)
+}
+
+func TestBackfillsCappedPerRequesterAndOverall(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	fake, _ := newFakeChain(t, 200, start)
+	chain := &slowChain{fakeChain: fake, delay: time.Second}
+	backfills := NewReceiptBackfiller(chain, NewTransactionIndex(), 0, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backfills.Start(ctx)
+
+	// Tasks wait for the one slot, so they all stay active
+	address := func(i int) common.Address { return common.BigToAddress(big.NewInt(int64(i + 1))) }
+	for i := 0; i < MaxActiveBackfillsPerRequester; i++ {
+		_, err := backfills.Ensure(address(i), start, "ip:203.0.113.7")
+		require.NoError(t, err)
+	}
+	_, err := backfills.Ensure(address(MaxActiveBackfillsPerRequester), start, "ip:203.0.113.7")
+	assert.ErrorIs(t, err, ErrBackfillLimit)
+	// An address with an active task still gets it back
+	_, err = backfills.Ensure(address(0), start, "ip:203.0.113.7")
+	assert.NoError(t, err)
+
+	for i := MaxActiveBackfillsPerRequester; i < MaxActiveBackfills; i++ {
+		_, err := backfills.Ensure(address(i), start, "")
+		require.NoError(t, err)
+	}
+	_, err = backfills.Ensure(address(MaxActiveBackfills), start, "ip:198.51.100.1")
+	assert.ErrorIs(t, err, ErrBackfillLimit)
+}
@@ -0,0 +1,452 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Indicators a backtest strategy can trade on
+const (
+	IndicatorSMACrossover = "sma_crossover"
+	IndicatorRSI          = "rsi"
+)
+
+const (
+	// MaxBacktestWindow is the longest stretch of history a backtest replays
+	MaxBacktestWindow = 365 * 24 * time.Hour
+	// DefaultBacktestInterval is the candle length when the spec sets none
+	DefaultBacktestInterval = time.Hour
+
+	minBacktestInterval = time.Minute
+	maxIndicatorPeriod  = 500
+	backtestTaskTTL     = 24 * time.Hour
+)
+
+// ErrInvalidBacktest is wrapped by the errors of specs that can't be run
+var ErrInvalidBacktest = errors.New("invalid backtest")
+
+// ErrInvalidPrice is wrapped by the errors of candles a backtest can't trade
+// at: prices must be positive finite numbers, or equity is divided by zero
+var ErrInvalidPrice = errors.New("invalid price")
+
+// validPrice reports whether a backtest can trade at the price
+func validPrice(price float64) bool {
+	return price > 0 && isFinite(price)
+}
+
+// BacktestStrategy is the indicator a backtest enters and exits on. Unset
+// periods and thresholds take their defaults.
+type BacktestStrategy struct {
+	Indicator string `json:"indicator"`
+	// FastPeriod and SlowPeriod are the SMA lengths, in candles: the
+	// strategy buys when the fast SMA crosses above the slow one and sells
+	// when it crosses back below
+	FastPeriod int `json:"fast_period,omitempty"`
+	SlowPeriod int `json:"slow_period,omitempty"`
+	// Period is the RSI length, in candles: the strategy buys below
+	// Oversold and sells above Overbought
+	Period     int     `json:"period,omitempty"`
+	Oversold   float64 `json:"oversold,omitempty"`
+	Overbought float64 `json:"overbought,omitempty"`
+}
+
+// BacktestSpec is what a backtest replays: a strategy over a pair's recorded
+// prices between Start and End
+type BacktestSpec struct {
+	// Pair is quoted in USD or a USD stablecoin, as prices are recorded in USD
+	Pair     string           `json:"pair"`
+	Start    time.Time        `json:"start"`
+	End      time.Time        `json:"end"`
+	Interval string           `json:"interval,omitempty"`
+	Strategy BacktestStrategy `json:"strategy"`
+
+	symbol   string
+	interval time.Duration
+}
+
+// Normalize fills in the defaults and checks the spec can be run at now.
+// Errors wrap ErrInvalidBacktest.
+func (s *BacktestSpec) Normalize(now time.Time) error {
+	base, quote, found := strings.Cut(strings.ToUpper(strings.TrimSpace(s.Pair)), "/")
+	if base == "" || (found && quote != "USD" && quote != "USDT" && quote != "USDC") {
+		return fmt.Errorf("%w: pair must be a symbol quoted in USD, USDT or USDC, such as KAIA/USDT", ErrInvalidBacktest)
+	}
+	s.symbol = base
+
+	s.interval = DefaultBacktestInterval
+	if s.Interval != "" {
+		interval, err := time.ParseDuration(s.Interval)
+		if err != nil || interval < minBacktestInterval {
+			return fmt.Errorf("%w: interval must be a duration of at least 1m, such as 1h", ErrInvalidBacktest)
+		}
+		s.interval = interval
+	}
+	s.Interval = s.interval.String()
+
+	if s.End.IsZero() {
+		s.End = now
+	}
+	if s.Start.IsZero() || !s.Start.Before(s.End) {
+		return fmt.Errorf("%w: start must be before end", ErrInvalidBacktest)
+	}
+	if s.End.Sub(s.Start) > MaxBacktestWindow {
+		return fmt.Errorf("%w: window must be at most 1 year", ErrInvalidBacktest)
+	}
+	return s.Strategy.normalize()
+}
+
+// normalize fills in the strategy's defaults and checks its parameters
+func (st *BacktestStrategy) normalize() error {
+	switch st.Indicator {
+	case IndicatorSMACrossover:
+		if st.FastPeriod == 0 {
+			st.FastPeriod = 10
+		}
+		if st.SlowPeriod == 0 {
+			st.SlowPeriod = 30
+		}
+		if st.FastPeriod < 1 || st.SlowPeriod <= st.FastPeriod || st.SlowPeriod > maxIndicatorPeriod {
+			return fmt.Errorf("%w: periods must satisfy 1 <= fast_period < slow_period <= %d", ErrInvalidBacktest, maxIndicatorPeriod)
+		}
+	case IndicatorRSI:
+		if st.Period == 0 {
+			st.Period = 14
+		}
+		if st.Oversold == 0 && st.Overbought == 0 {
+			st.Oversold, st.Overbought = 30, 70
+		}
+		if st.Period < 2 || st.Period > maxIndicatorPeriod {
+			return fmt.Errorf("%w: period must be between 2 and %d", ErrInvalidBacktest, maxIndicatorPeriod)
+		}
+		if st.Oversold <= 0 || st.Overbought <= st.Oversold || st.Overbought >= 100 {
+			return fmt.Errorf("%w: thresholds must satisfy 0 < oversold < overbought < 100", ErrInvalidBacktest)
+		}
+	default:
+		return fmt.Errorf("%w: indicator must be %s or %s", ErrInvalidBacktest, IndicatorSMACrossover, IndicatorRSI)
+	}
+	return nil
+}
+
+// warmup is how many candles the strategy needs before it can signal
+func (st BacktestStrategy) warmup() int {
+	if st.Indicator == IndicatorRSI {
+		return st.Period + 1
+	}
+	return st.SlowPeriod + 1
+}
+
+// Trading signals
+const (
+	signalHold = iota
+	signalBuy
+	signalSell
+)
+
+// backtestSignaller decides a strategy's signals candle by candle. It only
+// ever sees the closes up to the candle being decided on, so it can't look
+// ahead, and updates its indicators in constant time per close.
+type backtestSignaller struct {
+	strategy BacktestStrategy
+	fast     *RollingWindow
+	slow     *RollingWindow
+	rsi      *RollingRSI
+
+	prevFast, prevSlow float64
+	crossable          bool
+}
+
+func (st BacktestStrategy) signaller() *backtestSignaller {
+	s := &backtestSignaller{strategy: st}
+	switch st.Indicator {
+	case IndicatorSMACrossover:
+		s.fast, s.slow = NewRollingWindow(st.FastPeriod), NewRollingWindow(st.SlowPeriod)
+	case IndicatorRSI:
+		s.rsi = NewRollingRSI(st.Period)
+	}
+	return s
+}
+
+// next decides on the close of the next candle
+func (s *backtestSignaller) next(price float64) int {
+	st := s.strategy
+	switch {
+	case s.slow != nil:
+		s.fast.Push(price)
+		s.slow.Push(price)
+		if !s.slow.Full() {
+			return signalHold
+		}
+		fast, _ := s.fast.Mean()
+		slow, _ := s.slow.Mean()
+		prevFast, prevSlow, crossable := s.prevFast, s.prevSlow, s.crossable
+		s.prevFast, s.prevSlow, s.crossable = fast, slow, true
+		if !crossable {
+			return signalHold
+		}
+		if prevFast <= prevSlow && fast > slow {
+			return signalBuy
+		}
+		if prevFast >= prevSlow && fast < slow {
+			return signalSell
+		}
+	case s.rsi != nil:
+		s.rsi.Push(price)
+		rsi, err := s.rsi.Value()
+		if err != nil {
+			return signalHold
+		}
+		if rsi < st.Oversold {
+			return signalBuy
+		}
+		if rsi > st.Overbought {
+			return signalSell
+		}
+	}
+	return signalHold
+}
+
+// BacktestTrade is one round trip of a backtest. Returns are in percent.
+type BacktestTrade struct {
+	EntryTime  time.Time `json:"entry_time"`
+	EntryPrice float64   `json:"entry_price"`
+	ExitTime   time.Time `json:"exit_time"`
+	ExitPrice  float64   `json:"exit_price"`
+	Return     float64   `json:"return"`
+	// ExitReason is "signal", or "end_of_window" for a position still open
+	// when the history ran out
+	ExitReason string `json:"exit_reason"`
+}
+
+// BacktestResult is how a strategy would have performed. TotalReturn and
+// MaxDrawdown are in percent of the starting equity, which is fully invested
+// in each trade; WinRate is the share of trades that made money.
+type BacktestResult struct {
+	Pair        string          `json:"pair"`
+	Candles     int             `json:"candles"`
+	TotalReturn float64         `json:"total_return"`
+	MaxDrawdown float64         `json:"max_drawdown"`
+	WinRate     float64         `json:"win_rate"`
+	Trades      []BacktestTrade `json:"trades"`
+}
+
+// RunBacktest replays a long-only strategy over candles, oldest first. Each
+// candle's signal is decided on its close and filled at the next candle's
+// open, so no trade uses a price that wasn't known when it was decided.
+// Candles with a price that isn't a positive finite number are an error
+// wrapping ErrInvalidPrice.
+func RunBacktest(candles []Candle, strategy BacktestStrategy) (BacktestResult, error) {
+	for _, candle := range candles {
+		if !validPrice(candle.Open) || !validPrice(candle.High) || !validPrice(candle.Low) || !validPrice(candle.Close) {
+			return BacktestResult{}, fmt.Errorf("%w: candle at %s has prices %v/%v/%v/%v", ErrInvalidPrice, candle.Start.Format(time.RFC3339), candle.Open, candle.High, candle.Low, candle.Close)
+		}
+	}
+	result := BacktestResult{Candles: len(candles), Trades: []BacktestTrade{}}
+	signaller := strategy.signaller()
+	equity, peak := 1.0, 1.0
+	var open *BacktestTrade
+	entryEquity := 0.0
+	pending := signalHold
+
+	mark := func(price float64) {
+		if open != nil {
+			equity = entryEquity * price / open.EntryPrice
+		}
+		peak = max(peak, equity)
+		result.MaxDrawdown = max(result.MaxDrawdown, (peak-equity)/peak*100)
+	}
+	exit := func(at time.Time, price float64, reason string) {
+		mark(price)
+		open.ExitTime, open.ExitPrice, open.ExitReason = at, price, reason
+		open.Return = roundTo((price/open.EntryPrice-1)*100, 4)
+		result.Trades = append(result.Trades, *open)
+		open = nil
+	}
+
+	for _, candle := range candles {
+		switch {
+		case pending == signalBuy && open == nil:
+			open = &BacktestTrade{EntryTime: candle.Start, EntryPrice: candle.Open}
+			entryEquity = equity
+		case pending == signalSell && open != nil:
+			exit(candle.Start, candle.Open, "signal")
+		}
+		mark(candle.Close)
+
+		pending = signaller.next(candle.Close)
+	}
+	if open != nil {
+		last := candles[len(candles)-1]
+		exit(last.Start, last.Close, "end_of_window")
+	}
+
+	wins := 0
+	for _, trade := range result.Trades {
+		if trade.Return > 0 {
+			wins++
+		}
+	}
+	if len(result.Trades) > 0 {
+		result.WinRate = roundTo(float64(wins)/float64(len(result.Trades)), 4)
+	}
+	result.TotalReturn = roundTo((equity-1)*100, 4)
+	result.MaxDrawdown = roundTo(result.MaxDrawdown, 4)
+	return result, nil
+}
+
+// BacktestTask tracks a backtest run
+type BacktestTask struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Spec       BacktestSpec    `json:"spec"`
+	Result     *BacktestResult `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Backtester replays strategies over the recorded price series in the
+// background, running at most maxConcurrency backtests at once
+type Backtester struct {
+	series *TimeSeriesStore
+	slots  chan struct{}
+	logger *log.Logger
+	mu     sync.Mutex
+	ctx    context.Context
+	tasks  map[string]*BacktestTask
+	now    func() time.Time
+}
+
+// NewBacktester creates a backtester over the series prices are recorded in
+func NewBacktester(series *TimeSeriesStore, maxConcurrency int) *Backtester {
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	return &Backtester{
+		series: series,
+		slots:  make(chan struct{}, maxConcurrency),
+		logger: log.New(log.Writer(), "[Backtester] ", log.LstdFlags),
+		ctx:    context.Background(),
+		tasks:  make(map[string]*BacktestTask),
+		now:    utcNow,
+	}
+}
+
+// Start sets the context backtests run under; queued ones are dropped when it
+// is cancelled
+func (b *Backtester) Start(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ctx = ctx
+}
+
+// Submit checks a spec and starts its backtest. Invalid specs return an
+// error wrapping ErrInvalidBacktest.
+func (b *Backtester) Submit(spec BacktestSpec) (BacktestTask, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if err := spec.Normalize(now); err != nil {
+		return BacktestTask{}, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return BacktestTask{}, fmt.Errorf("failed to generate task ID: %w", err)
+	}
+	b.prune(now)
+
+	task := &BacktestTask{
+		ID:        "bt_" + id,
+		Status:    BackfillPending,
+		Spec:      spec,
+		CreatedAt: now,
+	}
+	b.tasks[task.ID] = task
+	go b.run(b.ctx, task)
+	return *task, nil
+}
+
+// Task returns a snapshot of a task by ID
+func (b *Backtester) Task(id string) (BacktestTask, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	task, ok := b.tasks[id]
+	if !ok {
+		return BacktestTask{}, false
+	}
+	return *task, true
+}
+
+// prune drops tasks that finished more than a day ago. Callers must hold b.mu.
+func (b *Backtester) prune(now time.Time) {
+	for id, task := range b.tasks {
+		if task.FinishedAt != nil && now.Sub(*task.FinishedAt) > backtestTaskTTL {
+			delete(b.tasks, id)
+		}
+	}
+}
+
+// update applies a change to a task under the lock
+func (b *Backtester) update(task *BacktestTask, change func(*BacktestTask)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	change(task)
+}
+
+// run waits for a free slot and then replays the task's spec
+func (b *Backtester) run(ctx context.Context, task *BacktestTask) {
+	var result *BacktestResult
+	var err error
+	select {
+	case b.slots <- struct{}{}:
+		b.update(task, func(t *BacktestTask) { t.Status = BackfillRunning })
+		result, err = b.backtest(task.Spec)
+		<-b.slots
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	b.update(task, func(t *BacktestTask) {
+		finished := b.now()
+		t.FinishedAt = &finished
+		t.Status = BackfillDone
+		t.Result = result
+		if err != nil {
+			t.Status = BackfillFailed
+			t.Error = err.Error()
+		}
+	})
+	if err != nil {
+		b.logger.Printf("Backtest %s of %s failed: %v", task.ID, task.Spec.Pair, err)
+	}
+}
+
+// backtest builds the spec's candles from the recorded prices and replays its
+// strategy over them. Recorded prices of zero or less are left out.
+func (b *Backtester) backtest(spec BacktestSpec) (*BacktestResult, error) {
+	var points []SeriesPoint
+	for _, point := range b.series.Range(PriceMetric(spec.symbol), spec.Start) {
+		if point.Timestamp.Before(spec.End) && validPrice(point.Value) {
+			points = append(points, point)
+		}
+	}
+	candles := BuildCandles(points, spec.interval)
+	if need := spec.Strategy.warmup() + 1; len(candles) < need {
+		return nil, fmt.Errorf("not enough price history for %s: %d candles, the strategy needs %d", spec.symbol, len(candles), need)
+	}
+
+	result, err := RunBacktest(candles, spec.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	result.Pair = spec.Pair
+	return &result, nil
+}
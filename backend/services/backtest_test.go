@@ -0,0 +1,212 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var backtestStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// peakSeries is flat at 100 for 30 hours, rises by 1 an hour to 130 and falls
+// back to 100 the same way, one point an hour
+func peakSeries() []SeriesPoint {
+	var points []SeriesPoint
+	value := 100.0
+	for i := 0; i < 90; i++ {
+		switch {
+		case i >= 30 && i < 60:
+			value++
+		case i >= 60:
+			value--
+		}
+		points = append(points, SeriesPoint{Timestamp: backtestStart.Add(time.Duration(i) * time.Hour), Value: value})
+	}
+	return points
+}
+
+func TestBacktestSMACrossoverOnTrend(t *testing.T) {
+	candles := BuildCandles(peakSeries(), time.Hour)
+	require.Len(t, candles, 90)
+
+	result, err := RunBacktest(candles, BacktestStrategy{Indicator: IndicatorSMACrossover, FastPeriod: 5, SlowPeriod: 10})
+	require.NoError(t, err)
+	require.Len(t, result.Trades, 1)
+	trade := result.Trades[0]
+	// The 5 hour SMA crosses above the 10 hour one on the first rise, at hour
+	// 30, and is bought at the next hour's open
+	assert.Equal(t, backtestStart.Add(31*time.Hour), trade.EntryTime)
+	assert.Equal(t, 102.0, trade.EntryPrice)
+	// Past the peak, it crosses back below at hour 64 and is sold at hour 65
+	assert.Equal(t, backtestStart.Add(65*time.Hour), trade.ExitTime)
+	assert.Equal(t, 124.0, trade.ExitPrice)
+	assert.Equal(t, "signal", trade.ExitReason)
+	assert.Equal(t, roundTo((124.0/102-1)*100, 4), trade.Return)
+
+	assert.Equal(t, trade.Return, result.TotalReturn)
+	assert.Equal(t, roundTo((130.0-124)/130*100, 4), result.MaxDrawdown, "from the peak at 130 to the exit at 124")
+	assert.Equal(t, 1.0, result.WinRate)
+}
+
+func TestBacktestClosesOpenPositionAtEnd(t *testing.T) {
+	// Only the flat stretch and the rise: the position is still open at the end
+	candles := BuildCandles(peakSeries()[:60], time.Hour)
+
+	result, err := RunBacktest(candles, BacktestStrategy{Indicator: IndicatorSMACrossover, FastPeriod: 5, SlowPeriod: 10})
+	require.NoError(t, err)
+	require.Len(t, result.Trades, 1)
+	assert.Equal(t, "end_of_window", result.Trades[0].ExitReason)
+	assert.Equal(t, 130.0, result.Trades[0].ExitPrice)
+	assert.Equal(t, roundTo((130.0/102-1)*100, 4), result.TotalReturn)
+	assert.Zero(t, result.MaxDrawdown)
+}
+
+func TestBacktestHasNoLookahead(t *testing.T) {
+	strategy := BacktestStrategy{Indicator: IndicatorSMACrossover, FastPeriod: 5, SlowPeriod: 10}
+	candles := BuildCandles(peakSeries(), time.Hour)
+	baseline, err := RunBacktest(candles, strategy)
+	require.NoError(t, err)
+
+	// Rewriting what happens after the entry can't change the entry
+	crashed := append([]Candle(nil), candles...)
+	for i := 32; i < len(crashed); i++ {
+		crashed[i] = Candle{Start: crashed[i].Start, Open: 50, High: 50, Low: 50, Close: 50}
+	}
+	result, err := RunBacktest(crashed, strategy)
+	require.NoError(t, err)
+	require.Len(t, result.Trades, 1)
+	assert.Equal(t, baseline.Trades[0].EntryTime, result.Trades[0].EntryTime)
+	assert.Equal(t, baseline.Trades[0].EntryPrice, result.Trades[0].EntryPrice)
+	assert.Less(t, result.TotalReturn, 0.0)
+}
+
+func TestBacktestRSI(t *testing.T) {
+	// Steady falls push the RSI to 0 and steady rises to 100
+	var points []SeriesPoint
+	value := 100.0
+	for i := 0; i < 20; i++ {
+		if i >= 10 {
+			value += 2
+		} else if i > 0 {
+			value--
+		}
+		points = append(points, SeriesPoint{Timestamp: backtestStart.Add(time.Duration(i) * time.Hour), Value: value})
+	}
+
+	result, err := RunBacktest(BuildCandles(points, time.Hour), BacktestStrategy{Indicator: IndicatorRSI, Period: 3, Oversold: 30, Overbought: 70})
+	require.NoError(t, err)
+	require.Len(t, result.Trades, 1)
+	// Oversold from hour 3, bought at hour 4 for 96; 1 down and 2 up moves
+	// make it overbought at hour 11, sold at hour 12 for 97. It stays
+	// overbought from there on.
+	trade := result.Trades[0]
+	assert.Equal(t, backtestStart.Add(4*time.Hour), trade.EntryTime)
+	assert.Equal(t, 96.0, trade.EntryPrice)
+	assert.Equal(t, backtestStart.Add(12*time.Hour), trade.ExitTime)
+	assert.Equal(t, 97.0, trade.ExitPrice)
+	assert.Equal(t, 1.0, result.WinRate)
+	assert.Equal(t, roundTo((96.0-91)/96*100, 4), result.MaxDrawdown, "the low at 91 while holding")
+}
+
+func TestBacktestRejectsInvalidPrices(t *testing.T) {
+	strategy := BacktestStrategy{Indicator: IndicatorSMACrossover, FastPeriod: 5, SlowPeriod: 10}
+	for _, price := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		candles := BuildCandles(peakSeries(), time.Hour)
+		candles[31].Open = price
+		_, err := RunBacktest(candles, strategy)
+		assert.ErrorIs(t, err, ErrInvalidPrice, "price %v", price)
+	}
+
+	// Recorded prices that can't be traded at are left out of the history,
+	// and what comes out always encodes
+	points := peakSeries()
+	points[31].Value = 0
+	points[40].Value = math.NaN()
+	series := NewTimeSeriesStore()
+	for _, point := range points {
+		series.Record(PriceMetric("KAIA"), point)
+	}
+	backtester := NewBacktester(series, 1)
+	backtester.now = func() time.Time { return backtestStart.Add(100 * time.Hour) }
+	task, err := backtester.Submit(BacktestSpec{Pair: "KAIA/USDT", Start: backtestStart, Strategy: strategy})
+	require.NoError(t, err)
+	task = waitForBacktest(t, backtester, task.ID)
+	require.Equal(t, BackfillDone, task.Status, task.Error)
+	assert.Equal(t, 88, task.Result.Candles)
+	_, err = json.Marshal(task)
+	assert.NoError(t, err)
+}
+
+func TestBacktestSpecValidation(t *testing.T) {
+	now := backtestStart.Add(400 * 24 * time.Hour)
+	valid := BacktestSpec{Pair: "kaia/usdt", Start: now.Add(-30 * 24 * time.Hour), Strategy: BacktestStrategy{Indicator: IndicatorSMACrossover}}
+	require.NoError(t, valid.Normalize(now))
+	assert.Equal(t, "KAIA", valid.symbol)
+	assert.Equal(t, now, valid.End)
+	assert.Equal(t, "1h0m0s", valid.Interval)
+	assert.Equal(t, 10, valid.Strategy.FastPeriod)
+	assert.Equal(t, 30, valid.Strategy.SlowPeriod)
+
+	cases := map[string]BacktestSpec{
+		"pair must be":     {Pair: "KAIA/BTC", Start: backtestStart, Strategy: valid.Strategy},
+		"at most 1 year":   {Pair: "KAIA", Start: now.Add(-366 * 24 * time.Hour), Strategy: valid.Strategy},
+		"start must be":    {Pair: "KAIA", Start: now.Add(time.Hour), Strategy: valid.Strategy},
+		"interval must be": {Pair: "KAIA", Start: backtestStart, Interval: "30s", Strategy: valid.Strategy},
+		"indicator must":   {Pair: "KAIA", Start: now.Add(-time.Hour), Strategy: BacktestStrategy{Indicator: "macd"}},
+		"fast_period <":    {Pair: "KAIA", Start: now.Add(-time.Hour), Strategy: BacktestStrategy{Indicator: IndicatorSMACrossover, FastPeriod: 30, SlowPeriod: 10}},
+		"oversold <":       {Pair: "KAIA", Start: now.Add(-time.Hour), Strategy: BacktestStrategy{Indicator: IndicatorRSI, Oversold: 80, Overbought: 70}},
+	}
+	for message, spec := range cases {
+		err := spec.Normalize(now)
+		assert.True(t, errors.Is(err, ErrInvalidBacktest), message)
+		assert.ErrorContains(t, err, message)
+	}
+}
+
+func TestBacktesterRunsOnRecordedPrices(t *testing.T) {
+	series := NewTimeSeriesStore()
+	for _, point := range peakSeries() {
+		series.Record(PriceMetric("KAIA"), point)
+	}
+	backtester := NewBacktester(series, 1)
+	backtester.now = func() time.Time { return backtestStart.Add(100 * time.Hour) }
+
+	spec := BacktestSpec{
+		Pair:     "KAIA/USDT",
+		Start:    backtestStart,
+		Strategy: BacktestStrategy{Indicator: IndicatorSMACrossover, FastPeriod: 5, SlowPeriod: 10},
+	}
+	task, err := backtester.Submit(spec)
+	require.NoError(t, err)
+	assert.Equal(t, BackfillPending, task.Status)
+	task = waitForBacktest(t, backtester, task.ID)
+	assert.Equal(t, BackfillDone, task.Status)
+	require.NotNil(t, task.Result)
+	assert.Equal(t, 90, task.Result.Candles)
+	assert.Len(t, task.Result.Trades, 1)
+
+	// Too little history for the strategy fails the task
+	spec.Start = backtestStart.Add(80 * time.Hour)
+	task, err = backtester.Submit(spec)
+	require.NoError(t, err)
+	task = waitForBacktest(t, backtester, task.ID)
+	assert.Equal(t, BackfillFailed, task.Status)
+	assert.Contains(t, task.Error, "not enough price history for KAIA")
+
+	_, err = backtester.Submit(BacktestSpec{Pair: "KAIA"})
+	assert.ErrorIs(t, err, ErrInvalidBacktest)
+}
+
+func waitForBacktest(t *testing.T, backtester *Backtester, id string) BacktestTask {
+	var task BacktestTask
+	require.Eventually(t, func() bool {
+		task, _ = backtester.Task(id)
+		return task.FinishedAt != nil
+	}, time.Second, 5*time.Millisecond)
+	return task
+}
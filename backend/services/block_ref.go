@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	// ErrBlockNotFound is returned for block references the node doesn't know
+	ErrBlockNotFound = errors.New("block not found")
+	// ErrStatePruned is returned when the node no longer keeps the state of
+	// the block asked for
+	ErrStatePruned = errors.New("state for the block has been pruned")
+)
+
+// prunedStateMessages are fragments of the errors nodes return for state
+// they don't keep. Geth-derived nodes, Kaia's included, report missing trie
+// nodes; others say the historical state is unavailable.
+var prunedStateMessages = []string{
+	"missing trie node",
+	"historical state",
+	"state not available",
+	"state is not available",
+	"state has been pruned",
+}
+
+// BlockHeaderReader reads block headers by number and hash
+type BlockHeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+}
+
+// BlockRef names a block by number, hash, or tag. The zero value is the
+// latest block.
+type BlockRef struct {
+	Number *big.Int
+	Hash   *common.Hash
+}
+
+// ParseBlockRef parses a decimal or 0x-prefixed hex block number, a block
+// hash, or one of the tags latest, earliest, safe, and finalized. Kaia
+// blocks are final once produced, so safe and finalized are the latest
+// block. An empty reference is the latest block.
+func ParseBlockRef(ref string) (BlockRef, error) {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	switch ref {
+	case "", "latest", "safe", "finalized":
+		return BlockRef{}, nil
+	case "earliest":
+		return BlockRef{Number: new(big.Int)}, nil
+	}
+
+	if strings.HasPrefix(ref, "0x") && len(ref) == 2+2*common.HashLength {
+		raw := common.FromHex(ref)
+		if len(raw) != common.HashLength {
+			return BlockRef{}, fmt.Errorf("invalid block hash %q", ref)
+		}
+		hash := common.BytesToHash(raw)
+		return BlockRef{Hash: &hash}, nil
+	}
+
+	number, ok := new(big.Int).SetString(ref, 0)
+	if !ok || number.Sign() < 0 || !number.IsUint64() {
+		return BlockRef{}, fmt.Errorf("block must be a number, a block hash, or latest, earliest, safe, or finalized, got %q", ref)
+	}
+	return BlockRef{Number: number}, nil
+}
+
+// Latest reports whether the reference is the latest block
+func (r BlockRef) Latest() bool {
+	return r.Number == nil && r.Hash == nil
+}
+
+// String names the block the way it was asked for
+func (r BlockRef) String() string {
+	switch {
+	case r.Hash != nil:
+		return r.Hash.Hex()
+	case r.Number != nil:
+		return r.Number.String()
+	default:
+		return "latest"
+	}
+}
+
+// ResolveBlock returns the header of the referenced block, wrapping
+// ErrBlockNotFound when the node doesn't have it
+func ResolveBlock(ctx context.Context, headers BlockHeaderReader, ref BlockRef) (*types.Header, error) {
+	var header *types.Header
+	var err error
+	if ref.Hash != nil {
+		header, err = headers.HeaderByHash(ctx, *ref.Hash)
+	} else {
+		header, err = headers.HeaderByNumber(ctx, ref.Number)
+	}
+	if errors.Is(err, ethereum.NotFound) || (err == nil && header == nil) {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block %s: %w", ref, err)
+	}
+	return header, nil
+}
+
+// IsStatePruned reports whether a state read failed because the node no
+// longer keeps the state of the block
+func IsStatePruned(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrStatePruned) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, fragment := range prunedStateMessages {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBlockRef(t *testing.T) {
+	hash := common.HexToHash("0xabc")
+	for _, tc := range []struct {
+		ref  string
+		want BlockRef
+	}{
+		{"", BlockRef{}},
+		{"latest", BlockRef{}},
+		{"Finalized", BlockRef{}},
+		{"earliest", BlockRef{Number: big.NewInt(0)}},
+		{"1234", BlockRef{Number: big.NewInt(1234)}},
+		{"0x4d2", BlockRef{Number: big.NewInt(1234)}},
+		{hash.Hex(), BlockRef{Hash: &hash}},
+	} {
+		got, err := ParseBlockRef(tc.ref)
+		require.NoError(t, err, tc.ref)
+		assert.Equal(t, tc.want, got, tc.ref)
+	}
+
+	for _, ref := range []string{"pending", "-1", "1.5", "0xzz", "99999999999999999999999"} {
+		_, err := ParseBlockRef(ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestIsStatePruned(t *testing.T) {
+	assert.True(t, IsStatePruned(ErrStatePruned))
+	assert.True(t, IsStatePruned(fmt.Errorf("all RPC endpoints failed: %w", errors.New("missing trie node 1a2b (path )"))))
+	assert.True(t, IsStatePruned(errors.New("required historical state unavailable")))
+	assert.False(t, IsStatePruned(errors.New("connection refused")))
+	assert.False(t, IsStatePruned(nil))
+}
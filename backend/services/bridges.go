@@ -0,0 +1,637 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Directions of bridge transfers, as seen from Kaia
+const (
+	BridgeDirectionIn  = "bridge_in"
+	BridgeDirectionOut = "bridge_out"
+)
+
+// Statuses of bridge transfers. An outgoing transfer is pending until its
+// withdrawal is seen on the counterpart chain; an incoming one has arrived.
+const (
+	BridgeStatusPending   = "pending"
+	BridgeStatusCompleted = "completed"
+)
+
+// Kinds of bridge legs
+const (
+	BridgeLegDeposit    = "deposit"
+	BridgeLegWithdrawal = "withdrawal"
+)
+
+const (
+	// DefaultBridgeMatchWindow is how far apart the two legs of a transfer
+	// may be when a bridge doesn't configure its own window
+	DefaultBridgeMatchWindow = 24 * time.Hour
+	// bridgeRecheckInterval is how often an unlinked leg is looked up again
+	bridgeRecheckInterval = time.Minute
+	// bridgeBlockMargin widens block ranges estimated from timestamps, as
+	// block times vary
+	bridgeBlockMargin = 0.1
+	// bridgeLogChunk is the most blocks read by one log query
+	bridgeLogChunk = 5000
+)
+
+// BridgeChainConfig is a counterpart chain whose bridge legs can be read
+type BridgeChainConfig struct {
+	Name    string   `json:"name"`
+	RPCURLs []string `json:"rpc_urls"`
+	// BlockTimeSeconds is the chain's average block time, used to find the
+	// blocks a time window spans
+	BlockTimeSeconds float64 `json:"block_time_seconds"`
+}
+
+// BlockTime returns the chain's average block time
+func (c BridgeChainConfig) BlockTime() time.Duration {
+	return time.Duration(c.BlockTimeSeconds * float64(time.Second))
+}
+
+// BridgeAdapterConfig configures a bridge between Kaia and a counterpart chain
+type BridgeAdapterConfig struct {
+	Name string `json:"name"`
+	// Chain is the counterpart chain assets are bridged to and from
+	Chain string `json:"chain"`
+	// Contract is the bridge contract on Kaia, CounterpartContract its pair
+	// on the counterpart chain. Without a counterpart contract transfers are
+	// classified but their legs aren't linked.
+	Contract            string `json:"contract"`
+	CounterpartContract string `json:"counterpart_contract,omitempty"`
+	// DepositEvent and WithdrawalEvent are event signatures such as
+	// "Deposit(uint256 indexed nonce, address indexed sender, address
+	// recipient, address token, uint256 amount)". Arguments are read by name:
+	// recipient and amount are required, nonce, sender, and token optional.
+	// Both contracts emit the same events.
+	DepositEvent    string `json:"deposit_event"`
+	WithdrawalEvent string `json:"withdrawal_event"`
+	// MatchWindowHours is how far apart the two legs of a transfer may be
+	MatchWindowHours float64 `json:"match_window_hours,omitempty"`
+}
+
+// BridgeConfig lists the bridges to classify and the counterpart chains to
+// link their legs on
+type BridgeConfig struct {
+	Chains  []BridgeChainConfig   `json:"chains"`
+	Bridges []BridgeAdapterConfig `json:"bridges"`
+}
+
+// LoadBridgeConfig reads a bridge configuration from a JSON file
+func LoadBridgeConfig(path string) (*BridgeConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bridge config: %w", err)
+	}
+
+	config := &BridgeConfig{}
+	if err := json.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("failed to parse bridge config: %w", err)
+	}
+	for _, chain := range config.Chains {
+		if chain.Name == "" || len(chain.RPCURLs) == 0 {
+			return nil, fmt.Errorf("invalid bridge config: chains need a name and rpc_urls")
+		}
+		if chain.BlockTimeSeconds <= 0 {
+			return nil, fmt.Errorf("invalid bridge config: block_time_seconds of %s must be above 0", chain.Name)
+		}
+	}
+	return config, nil
+}
+
+// Adapters builds the configured bridges
+func (c *BridgeConfig) Adapters() ([]*BridgeAdapter, error) {
+	adapters := make([]*BridgeAdapter, 0, len(c.Bridges))
+	for _, bridge := range c.Bridges {
+		adapter, err := NewBridgeAdapter(bridge)
+		if err != nil {
+			return nil, err
+		}
+		adapters = append(adapters, adapter)
+	}
+	return adapters, nil
+}
+
+// BridgeAdapter decodes the deposit and withdrawal events of one bridge
+type BridgeAdapter struct {
+	Name                string
+	Chain               string
+	Contract            common.Address
+	CounterpartContract common.Address
+	MatchWindow         time.Duration
+
+	deposit    abi.Event
+	withdrawal abi.Event
+}
+
+// NewBridgeAdapter validates a bridge's configuration and parses its events
+func NewBridgeAdapter(config BridgeAdapterConfig) (*BridgeAdapter, error) {
+	if config.Name == "" || config.Chain == "" {
+		return nil, fmt.Errorf("bridges need a name and a chain")
+	}
+	if !common.IsHexAddress(config.Contract) {
+		return nil, fmt.Errorf("contract of bridge %s must be an address, got %q", config.Name, config.Contract)
+	}
+	if config.CounterpartContract != "" && !common.IsHexAddress(config.CounterpartContract) {
+		return nil, fmt.Errorf("counterpart_contract of bridge %s must be an address, got %q", config.Name, config.CounterpartContract)
+	}
+	if config.MatchWindowHours < 0 {
+		return nil, fmt.Errorf("match_window_hours of bridge %s must not be negative", config.Name)
+	}
+
+	deposit, err := parseBridgeEvent(config.DepositEvent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deposit_event of bridge %s: %w", config.Name, err)
+	}
+	withdrawal, err := parseBridgeEvent(config.WithdrawalEvent)
+	if err != nil {
+		return nil, fmt.Errorf("invalid withdrawal_event of bridge %s: %w", config.Name, err)
+	}
+	if deposit.ID == withdrawal.ID {
+		return nil, fmt.Errorf("deposit_event and withdrawal_event of bridge %s are the same event", config.Name)
+	}
+
+	adapter := &BridgeAdapter{
+		Name:        config.Name,
+		Chain:       config.Chain,
+		Contract:    common.HexToAddress(config.Contract),
+		MatchWindow: DefaultBridgeMatchWindow,
+		deposit:     deposit,
+		withdrawal:  withdrawal,
+	}
+	if config.CounterpartContract != "" {
+		adapter.CounterpartContract = common.HexToAddress(config.CounterpartContract)
+	}
+	if config.MatchWindowHours > 0 {
+		adapter.MatchWindow = time.Duration(config.MatchWindowHours * float64(time.Hour))
+	}
+	return adapter, nil
+}
+
+// bridgeEventArguments are the argument types an event must use for the
+// arguments that are read, when it has them
+var bridgeEventArguments = map[string]string{
+	"recipient": "address",
+	"amount":    "uint256",
+	"sender":    "address",
+	"token":     "address",
+}
+
+// parseBridgeEvent parses an event signature with named arguments, such as
+// "Deposit(uint256 indexed nonce, address recipient, uint256 amount)"
+func parseBridgeEvent(signature string) (abi.Event, error) {
+	signature = strings.TrimSpace(signature)
+	open := strings.Index(signature, "(")
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return abi.Event{}, fmt.Errorf("expected Name(type name, ...), got %q", signature)
+	}
+	name := strings.TrimSpace(signature[:open])
+
+	var inputs abi.Arguments
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(signature[open+1:len(signature)-1], ",") {
+		parts := strings.Fields(field)
+		indexed := len(parts) == 3 && parts[1] == "indexed"
+		if len(parts) != 2 && !indexed {
+			return abi.Event{}, fmt.Errorf("expected \"type [indexed] name\", got %q", strings.TrimSpace(field))
+		}
+		argName := parts[len(parts)-1]
+		argType, err := abi.NewType(parts[0], "", nil)
+		if err != nil {
+			return abi.Event{}, fmt.Errorf("argument %s: %w", argName, err)
+		}
+		if want, ok := bridgeEventArguments[argName]; ok && argType.String() != want {
+			return abi.Event{}, fmt.Errorf("argument %s must be %s, got %s", argName, want, argType)
+		}
+		if seen[argName] {
+			return abi.Event{}, fmt.Errorf("argument %s appears twice", argName)
+		}
+		seen[argName] = true
+		inputs = append(inputs, abi.Argument{Name: argName, Type: argType, Indexed: indexed})
+	}
+	if !seen["recipient"] || !seen["amount"] {
+		return abi.Event{}, fmt.Errorf("%s needs recipient and amount arguments", name)
+	}
+	return abi.NewEvent(name, name, false, inputs), nil
+}
+
+// event returns the bridge's event of a leg kind
+func (ba *BridgeAdapter) event(kind string) abi.Event {
+	if kind == BridgeLegDeposit {
+		return ba.deposit
+	}
+	return ba.withdrawal
+}
+
+// decode reads a deposit or withdrawal from a log. It returns nil for logs
+// of other events.
+func (ba *BridgeAdapter) decode(log types.Log) (*BridgeLeg, error) {
+	if len(log.Topics) == 0 {
+		return nil, nil
+	}
+	var kind string
+	switch log.Topics[0] {
+	case ba.deposit.ID:
+		kind = BridgeLegDeposit
+	case ba.withdrawal.ID:
+		kind = BridgeLegWithdrawal
+	default:
+		return nil, nil
+	}
+	event := ba.event(kind)
+
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if len(log.Topics)-1 != len(indexed) {
+		return nil, fmt.Errorf("%s log has %d indexed topics, expected %d", event.Name, len(log.Topics)-1, len(indexed))
+	}
+	values := make(map[string]interface{})
+	if err := event.Inputs.UnpackIntoMap(values, log.Data); err != nil {
+		return nil, fmt.Errorf("failed to unpack %s data: %w", event.Name, err)
+	}
+	if err := abi.ParseTopicsIntoMap(values, indexed, log.Topics[1:]); err != nil {
+		return nil, fmt.Errorf("failed to parse %s topics: %w", event.Name, err)
+	}
+
+	leg := &BridgeLeg{
+		Bridge:      ba.Name,
+		Kind:        kind,
+		TxHash:      log.TxHash.Hex(),
+		BlockNumber: log.BlockNumber,
+		Recipient:   values["recipient"].(common.Address).Hex(),
+		Amount:      values["amount"].(*big.Int).String(),
+	}
+	if nonce, ok := values["nonce"]; ok {
+		leg.Nonce = bridgeNonce(nonce)
+	}
+	if sender, ok := values["sender"].(common.Address); ok {
+		leg.Sender = sender.Hex()
+	}
+	if token, ok := values["token"].(common.Address); ok && token != (common.Address{}) {
+		leg.Token = token.Hex()
+	}
+	return leg, nil
+}
+
+// bridgeNonce renders a nonce of any type so equal nonces compare equal
+func bridgeNonce(value interface{}) string {
+	switch nonce := value.(type) {
+	case *big.Int:
+		return nonce.String()
+	case [32]byte:
+		return hexutil.Encode(nonce[:])
+	case []byte:
+		return hexutil.Encode(nonce)
+	case common.Hash:
+		return nonce.Hex()
+	default:
+		return fmt.Sprint(nonce)
+	}
+}
+
+// BridgeLeg is a deposit into or a withdrawal from a bridge on one chain
+type BridgeLeg struct {
+	Bridge      string    `json:"bridge"`
+	Chain       string    `json:"chain"`
+	Kind        string    `json:"kind"`
+	TxHash      string    `json:"tx_hash"`
+	BlockNumber uint64    `json:"block_number"`
+	Timestamp   time.Time `json:"timestamp"`
+	Nonce       string    `json:"nonce,omitempty"`
+	Sender      string    `json:"sender,omitempty"`
+	Recipient   string    `json:"recipient"`
+	// Token is empty for the chain's native token
+	Token  string `json:"token,omitempty"`
+	Amount string `json:"amount"`
+}
+
+// BridgeTransfer annotates a Kaia transaction that moved assets across a
+// bridge
+type BridgeTransfer struct {
+	Bridge string `json:"bridge"`
+	// Contract is the bridge contract on Kaia
+	Contract         string `json:"contract"`
+	Direction        string `json:"direction"`
+	CounterpartChain string `json:"counterpart_chain"`
+	Status           string `json:"status"`
+	Nonce            string `json:"nonce,omitempty"`
+	Sender           string `json:"sender,omitempty"`
+	Recipient        string `json:"recipient"`
+	// Token is the token bridged on Kaia, empty for the native token
+	Token  string `json:"token,omitempty"`
+	Amount string `json:"amount"`
+	// Counterpart is the leg on the counterpart chain, once it has been found
+	Counterpart *BridgeLeg `json:"counterpart,omitempty"`
+}
+
+// BridgeLegSource reads the legs a bridge recorded on a counterpart chain
+// between two times
+type BridgeLegSource interface {
+	BridgeLegs(ctx context.Context, adapter *BridgeAdapter, kind string, from, to time.Time) ([]BridgeLeg, error)
+}
+
+// BridgeDetector classifies Kaia transactions that deposit into or withdraw
+// from configured bridges, and links them to their other leg on counterpart
+// chains that can be read
+type BridgeDetector struct {
+	adapters map[common.Address]*BridgeAdapter
+	logger   *log.Logger
+	now      func() time.Time
+
+	mu      sync.Mutex
+	sources map[string]BridgeLegSource
+	// checked is when each unlinked transaction was last looked up
+	checked map[string]time.Time
+}
+
+// NewBridgeDetector creates a detector for the bridges
+func NewBridgeDetector(adapters []*BridgeAdapter) *BridgeDetector {
+	byContract := make(map[common.Address]*BridgeAdapter, len(adapters))
+	for _, adapter := range adapters {
+		byContract[adapter.Contract] = adapter
+	}
+
+	return &BridgeDetector{
+		adapters: byContract,
+		logger:   log.New(log.Writer(), "[BridgeDetector] ", log.LstdFlags),
+		now:      utcNow,
+		sources:  make(map[string]BridgeLegSource),
+		checked:  make(map[string]time.Time),
+	}
+}
+
+// SetCounterpart sets where the legs on a counterpart chain are read from
+func (bd *BridgeDetector) SetCounterpart(chain string, source BridgeLegSource) {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+
+	bd.sources[chain] = source
+}
+
+// Handles reports whether the address is a configured bridge contract
+func (bd *BridgeDetector) Handles(contract common.Address) bool {
+	_, ok := bd.adapters[contract]
+	return ok
+}
+
+// Labels names the bridge contracts, keyed by lowercased address
+func (bd *BridgeDetector) Labels() map[string]string {
+	labels := make(map[string]string, len(bd.adapters))
+	for contract, adapter := range bd.adapters {
+		labels[strings.ToLower(contract.Hex())] = adapter.Name + " bridge"
+	}
+	return labels
+}
+
+// Classify returns the bridge transfer a transaction's logs record, or nil
+// when no configured bridge emitted a deposit or withdrawal
+func (bd *BridgeDetector) Classify(logs []*types.Log) *BridgeTransfer {
+	for _, log := range logs {
+		adapter, ok := bd.adapters[log.Address]
+		if !ok {
+			continue
+		}
+		leg, err := adapter.decode(*log)
+		if err != nil {
+			bd.logger.Printf("Failed to decode %s bridge log: %v", adapter.Name, err)
+			continue
+		}
+		if leg == nil {
+			continue
+		}
+
+		transfer := &BridgeTransfer{
+			Bridge:           adapter.Name,
+			Contract:         adapter.Contract.Hex(),
+			Direction:        BridgeDirectionOut,
+			CounterpartChain: adapter.Chain,
+			Status:           BridgeStatusPending,
+			Nonce:            leg.Nonce,
+			Sender:           leg.Sender,
+			Recipient:        leg.Recipient,
+			Token:            leg.Token,
+			Amount:           leg.Amount,
+		}
+		if leg.Kind == BridgeLegWithdrawal {
+			transfer.Direction = BridgeDirectionIn
+			transfer.Status = BridgeStatusCompleted
+		}
+		return transfer
+	}
+	return nil
+}
+
+// Link looks for the other leg of a transfer made at the given time: the
+// withdrawal of an outgoing transfer within the bridge's window after it,
+// or the deposit of an incoming one within the window before it. A match
+// completes the transfer. Transfers whose counterpart chain isn't
+// configured are left as they are.
+func (bd *BridgeDetector) Link(ctx context.Context, transfer *BridgeTransfer, at time.Time) error {
+	if transfer.Counterpart != nil {
+		return nil
+	}
+	adapter, ok := bd.adapters[common.HexToAddress(transfer.Contract)]
+	if !ok || adapter.CounterpartContract == (common.Address{}) {
+		return nil
+	}
+	bd.mu.Lock()
+	source := bd.sources[adapter.Chain]
+	bd.mu.Unlock()
+	if source == nil {
+		return nil
+	}
+
+	kind, from, to := BridgeLegWithdrawal, at, at.Add(adapter.MatchWindow)
+	if transfer.Direction == BridgeDirectionIn {
+		kind, from, to = BridgeLegDeposit, at.Add(-adapter.MatchWindow), at
+	}
+	if now := bd.now(); to.After(now) {
+		to = now
+	}
+	legs, err := source.BridgeLegs(ctx, adapter, kind, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to read %s legs on %s: %w", adapter.Name, adapter.Chain, err)
+	}
+
+	if match := matchBridgeLeg(transfer, at, legs); match != nil {
+		transfer.Counterpart = match
+		transfer.Status = BridgeStatusCompleted
+	}
+	return nil
+}
+
+// matchBridgeLeg picks the leg of a transfer made at the given time. Legs
+// with a nonce match on it alone; without one, the recipient and amount must
+// match and the leg closest in time wins.
+func matchBridgeLeg(transfer *BridgeTransfer, at time.Time, legs []BridgeLeg) *BridgeLeg {
+	var best *BridgeLeg
+	bestGap := time.Duration(math.MaxInt64)
+	for i := range legs {
+		leg := &legs[i]
+		if transfer.Nonce != "" && leg.Nonce != "" {
+			if leg.Nonce == transfer.Nonce {
+				return leg
+			}
+			continue
+		}
+		if !strings.EqualFold(leg.Recipient, transfer.Recipient) || leg.Amount != transfer.Amount {
+			continue
+		}
+		gap := leg.Timestamp.Sub(at)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap < bestGap {
+			best, bestGap = leg, gap
+		}
+	}
+	return best
+}
+
+// LinkIndexed links the unlinked bridge transfers among indexed
+// transactions, updating both the slice and the index. A transfer is looked
+// up at most once a minute, and not again once its window has passed since
+// it was last looked up.
+func (bd *BridgeDetector) LinkIndexed(ctx context.Context, index *TransactionIndex, txs []IndexedTransaction) {
+	now := bd.now()
+	for i, tx := range txs {
+		if tx.Bridge == nil || tx.Bridge.Counterpart != nil {
+			continue
+		}
+		adapter, ok := bd.adapters[common.HexToAddress(tx.Bridge.Contract)]
+		if !ok {
+			continue
+		}
+		windowEnd := tx.Timestamp.Add(adapter.MatchWindow)
+
+		bd.mu.Lock()
+		last, checked := bd.checked[tx.Hash]
+		due := !checked || (now.Sub(last) >= bridgeRecheckInterval && !last.After(windowEnd))
+		if due {
+			bd.checked[tx.Hash] = now
+		}
+		bd.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		// The index's copy may be read concurrently, so link a copy of it
+		transfer := *tx.Bridge
+		if err := bd.Link(ctx, &transfer, tx.Timestamp); err != nil {
+			bd.logger.Printf("Failed to link bridge transfer %s: %v", tx.Hash, err)
+			continue
+		}
+		if transfer.Counterpart == nil {
+			continue
+		}
+		tx.Bridge = &transfer
+		txs[i] = tx
+		index.Add(tx)
+
+		bd.mu.Lock()
+		delete(bd.checked, tx.Hash)
+		bd.mu.Unlock()
+	}
+}
+
+// ChainBridgeLegSource reads bridge legs from a counterpart chain's logs.
+// The blocks a time window spans are estimated from the chain's block time
+// and widened, then logs outside the window are dropped by their block's
+// timestamp.
+type ChainBridgeLegSource struct {
+	client    ChainClient
+	chain     string
+	blockTime time.Duration
+}
+
+// NewChainBridgeLegSource creates a leg source for a counterpart chain
+func NewChainBridgeLegSource(client ChainClient, chain string, blockTime time.Duration) *ChainBridgeLegSource {
+	return &ChainBridgeLegSource{client: client, chain: chain, blockTime: blockTime}
+}
+
+// BridgeLegs returns the legs of a kind the bridge's counterpart contract
+// recorded between the two times, oldest first
+func (s *ChainBridgeLegSource) BridgeLegs(ctx context.Context, adapter *BridgeAdapter, kind string, from, to time.Time) ([]BridgeLeg, error) {
+	head, err := s.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest header: %w", err)
+	}
+	headTime := time.Unix(int64(head.Time), 0)
+	blocksBefore := func(at time.Time, widen float64) uint64 {
+		if !at.Before(headTime) {
+			return 0
+		}
+		return uint64(float64(headTime.Sub(at)) / float64(s.blockTime) * widen)
+	}
+	headNumber := head.Number.Uint64()
+	fromBlock, toBlock := uint64(0), headNumber
+	if back := blocksBefore(from, 1+bridgeBlockMargin) + 1; back < headNumber {
+		fromBlock = headNumber - back
+	}
+	if back := blocksBefore(to, 1-bridgeBlockMargin); back < headNumber {
+		toBlock = headNumber - back
+	}
+
+	legs := make([]BridgeLeg, 0)
+	times := make(map[uint64]time.Time)
+	for start := fromBlock; start <= toBlock; start += bridgeLogChunk {
+		end := start + bridgeLogChunk - 1
+		if end > toBlock {
+			end = toBlock
+		}
+		logs, err := s.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(start),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: []common.Address{adapter.CounterpartContract},
+			Topics:    [][]common.Hash{{adapter.event(kind).ID}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter logs of blocks %d-%d: %w", start, end, err)
+		}
+
+		for _, log := range logs {
+			leg, err := adapter.decode(log)
+			if err != nil || leg == nil {
+				continue
+			}
+			at, ok := times[log.BlockNumber]
+			if !ok {
+				header, err := s.client.HeaderByNumber(ctx, new(big.Int).SetUint64(log.BlockNumber))
+				if err != nil {
+					return nil, fmt.Errorf("failed to get block %d: %w", log.BlockNumber, err)
+				}
+				at = time.Unix(int64(header.Time), 0).UTC()
+				times[log.BlockNumber] = at
+			}
+			if at.Before(from) || at.After(to) {
+				continue
+			}
+			leg.Chain = s.chain
+			leg.Timestamp = at
+			legs = append(legs, *leg)
+		}
+	}
+	sort.SliceStable(legs, func(i, j int) bool { return legs[i].Timestamp.Before(legs[j].Timestamp) })
+	return legs, nil
+}
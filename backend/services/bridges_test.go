@@ -0,0 +1,376 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	bridgeKaia     = common.HexToAddress("0x00000000000000000000000000000000000000f1")
+	bridgeEthereum = common.HexToAddress("0x00000000000000000000000000000000000000f2")
+	bridgeUser     = common.HexToAddress("0x00000000000000000000000000000000000000f3")
+	bridgeEthUSDT  = common.HexToAddress("0x00000000000000000000000000000000000000f4")
+)
+
+// bridgeAt is when the fixture transfers were made on Kaia
+var bridgeAt = time.Date(2025, 6, 2, 14, 0, 0, 0, time.UTC)
+
+func newTestBridge(t *testing.T) *BridgeAdapter {
+	adapter, err := NewBridgeAdapter(BridgeAdapterConfig{
+		Name:                "Portal",
+		Chain:               "Ethereum",
+		Contract:            bridgeKaia.Hex(),
+		CounterpartContract: bridgeEthereum.Hex(),
+		DepositEvent:        "Deposit(uint256 indexed nonce, address indexed sender, address recipient, address token, uint256 amount)",
+		WithdrawalEvent:     "Withdrawal(uint256 indexed nonce, address indexed recipient, address token, uint256 amount)",
+		MatchWindowHours:    6,
+	})
+	require.NoError(t, err)
+	return adapter
+}
+
+// bridgeLog encodes a deposit or withdrawal of the bridge, its arguments
+// given in order
+func bridgeLog(t *testing.T, adapter *BridgeAdapter, kind string, contract common.Address, args ...interface{}) *types.Log {
+	event := adapter.event(kind)
+	topics := []common.Hash{event.ID}
+	var data []interface{}
+	for i, input := range event.Inputs {
+		if !input.Indexed {
+			data = append(data, args[i])
+			continue
+		}
+		switch value := args[i].(type) {
+		case *big.Int:
+			topics = append(topics, common.BigToHash(value))
+		case common.Address:
+			topics = append(topics, common.BytesToHash(value.Bytes()))
+		}
+	}
+	packed, err := event.Inputs.NonIndexed().Pack(data...)
+	require.NoError(t, err)
+	return &types.Log{Address: contract, Topics: topics, Data: packed}
+}
+
+// fakeLegSource serves fixture legs of the counterpart chain
+type fakeLegSource struct {
+	legs  []BridgeLeg
+	calls int
+}
+
+func (s *fakeLegSource) BridgeLegs(ctx context.Context, adapter *BridgeAdapter, kind string, from, to time.Time) ([]BridgeLeg, error) {
+	s.calls++
+	legs := make([]BridgeLeg, 0)
+	for _, leg := range s.legs {
+		if leg.Kind == kind && !leg.Timestamp.Before(from) && !leg.Timestamp.After(to) {
+			legs = append(legs, leg)
+		}
+	}
+	return legs, nil
+}
+
+func TestBridgeDetectorClassifiesDepositsAndWithdrawals(t *testing.T) {
+	adapter := newTestBridge(t)
+	detector := NewBridgeDetector([]*BridgeAdapter{adapter})
+	amount := big.NewInt(250_000_000)
+
+	// The bridge pulls the tokens before recording the deposit
+	deposit := detector.Classify([]*types.Log{
+		transferLog(explainUSDT, bridgeUser, bridgeKaia, amount),
+		bridgeLog(t, adapter, BridgeLegDeposit, bridgeKaia, big.NewInt(7), bridgeUser, bridgeUser, explainUSDT, amount),
+	})
+	require.NotNil(t, deposit)
+	assert.Equal(t, BridgeDirectionOut, deposit.Direction)
+	assert.Equal(t, BridgeStatusPending, deposit.Status)
+	assert.Equal(t, "Ethereum", deposit.CounterpartChain)
+	assert.Equal(t, "Portal", deposit.Bridge)
+	assert.Equal(t, "7", deposit.Nonce)
+	assert.Equal(t, bridgeUser.Hex(), deposit.Sender)
+	assert.Equal(t, bridgeUser.Hex(), deposit.Recipient)
+	assert.Equal(t, explainUSDT.Hex(), deposit.Token)
+	assert.Equal(t, "250000000", deposit.Amount)
+
+	// A native withdrawal has no token
+	withdrawal := detector.Classify([]*types.Log{
+		bridgeLog(t, adapter, BridgeLegWithdrawal, bridgeKaia, big.NewInt(3), bridgeUser, common.Address{}, big.NewInt(1e18)),
+	})
+	require.NotNil(t, withdrawal)
+	assert.Equal(t, BridgeDirectionIn, withdrawal.Direction)
+	assert.Equal(t, BridgeStatusCompleted, withdrawal.Status)
+	assert.Empty(t, withdrawal.Token)
+
+	// The same event from a contract that isn't a configured bridge
+	assert.Nil(t, detector.Classify([]*types.Log{
+		bridgeLog(t, adapter, BridgeLegDeposit, bridgeEthereum, big.NewInt(7), bridgeUser, bridgeUser, explainUSDT, amount),
+	}))
+	assert.Nil(t, detector.Classify([]*types.Log{transferLog(explainUSDT, bridgeUser, bridgeKaia, amount)}))
+
+	_, err := NewBridgeAdapter(BridgeAdapterConfig{
+		Name: "Broken", Chain: "Ethereum", Contract: bridgeKaia.Hex(),
+		DepositEvent:    "Deposit(address recipient)",
+		WithdrawalEvent: "Withdrawal(address recipient, uint256 amount)",
+	})
+	assert.ErrorContains(t, err, "needs recipient and amount")
+	_, err = NewBridgeAdapter(BridgeAdapterConfig{
+		Name: "Broken", Chain: "Ethereum", Contract: bridgeKaia.Hex(),
+		DepositEvent:    "Deposit(bytes32 recipient, uint256 amount)",
+		WithdrawalEvent: "Withdrawal(address recipient, uint256 amount)",
+	})
+	assert.ErrorContains(t, err, "recipient must be address")
+}
+
+func TestBridgeDetectorLinksLegs(t *testing.T) {
+	adapter := newTestBridge(t)
+	detector := NewBridgeDetector([]*BridgeAdapter{adapter})
+	detector.now = func() time.Time { return bridgeAt.Add(24 * time.Hour) }
+	source := &fakeLegSource{legs: []BridgeLeg{
+		// Same recipient and amount as nonce 7, but another transfer
+		{Kind: BridgeLegWithdrawal, TxHash: "0xeth1", Timestamp: bridgeAt.Add(10 * time.Minute), Nonce: "6", Recipient: bridgeUser.Hex(), Amount: "250000000"},
+		{Kind: BridgeLegWithdrawal, TxHash: "0xeth2", Timestamp: bridgeAt.Add(20 * time.Minute), Nonce: "7", Recipient: bridgeUser.Hex(), Amount: "250000000"},
+		// Outside the six hour window of the deposit with nonce 8
+		{Kind: BridgeLegWithdrawal, TxHash: "0xeth3", Timestamp: bridgeAt.Add(7 * time.Hour), Nonce: "8", Recipient: bridgeUser.Hex(), Amount: "1"},
+		{Kind: BridgeLegDeposit, TxHash: "0xeth4", Timestamp: bridgeAt.Add(-15 * time.Minute), Recipient: bridgeUser.Hex(), Amount: "1000000000000000000"},
+		{Kind: BridgeLegDeposit, TxHash: "0xeth5", Timestamp: bridgeAt.Add(-5 * time.Hour), Recipient: bridgeUser.Hex(), Amount: "1000000000000000000"},
+	}}
+	ctx := context.Background()
+
+	// Unlinked while the counterpart chain can't be read
+	transfer := &BridgeTransfer{Contract: bridgeKaia.Hex(), Direction: BridgeDirectionOut, Status: BridgeStatusPending, Nonce: "7", Recipient: bridgeUser.Hex(), Amount: "250000000"}
+	require.NoError(t, detector.Link(ctx, transfer, bridgeAt))
+	assert.Nil(t, transfer.Counterpart)
+
+	detector.SetCounterpart("Ethereum", source)
+	require.NoError(t, detector.Link(ctx, transfer, bridgeAt))
+	require.NotNil(t, transfer.Counterpart)
+	assert.Equal(t, "0xeth2", transfer.Counterpart.TxHash, "matched by nonce, not amount")
+	assert.Equal(t, BridgeStatusCompleted, transfer.Status)
+
+	unmatched := &BridgeTransfer{Contract: bridgeKaia.Hex(), Direction: BridgeDirectionOut, Status: BridgeStatusPending, Nonce: "8", Recipient: bridgeUser.Hex(), Amount: "1"}
+	require.NoError(t, detector.Link(ctx, unmatched, bridgeAt))
+	assert.Nil(t, unmatched.Counterpart)
+	assert.Equal(t, BridgeStatusPending, unmatched.Status)
+
+	// Without nonces the recipient and amount match, and the closest deposit wins
+	incoming := &BridgeTransfer{Contract: bridgeKaia.Hex(), Direction: BridgeDirectionIn, Status: BridgeStatusCompleted, Recipient: strings.ToLower(bridgeUser.Hex()), Amount: "1000000000000000000"}
+	require.NoError(t, detector.Link(ctx, incoming, bridgeAt))
+	require.NotNil(t, incoming.Counterpart)
+	assert.Equal(t, "0xeth4", incoming.Counterpart.TxHash)
+}
+
+func TestFeeSpendReportsBridgeTransfers(t *testing.T) {
+	adapter := newTestBridge(t)
+	detector := NewBridgeDetector([]*BridgeAdapter{adapter})
+	now := bridgeAt.Add(time.Hour)
+	detector.now = func() time.Time { return now }
+	source := &fakeLegSource{}
+	detector.SetCounterpart("Ethereum", source)
+
+	user := strings.ToLower(bridgeUser.Hex())
+	bridge := strings.ToLower(bridgeKaia.Hex())
+	relayer := "0x00000000000000000000000000000000000000f5"
+	index := NewTransactionIndex()
+	deposit := feeTx("0xdeposit", user, bridge, bridgeAt, 80_000, 25)
+	deposit.Bridge = &BridgeTransfer{Bridge: "Portal", Contract: bridgeKaia.Hex(), Direction: BridgeDirectionOut, CounterpartChain: "Ethereum",
+		Status: BridgeStatusPending, Nonce: "9", Recipient: bridgeUser.Hex(), Amount: "250000000"}
+	index.Add(deposit)
+	// Delivered by a relayer, so only indexed against the user as recipient
+	withdrawal := feeTx("0xwithdrawal", relayer, bridge, bridgeAt.Add(time.Minute), 120_000, 25)
+	withdrawal.Bridge = &BridgeTransfer{Bridge: "Portal", Contract: bridgeKaia.Hex(), Direction: BridgeDirectionIn, CounterpartChain: "Ethereum",
+		Status: BridgeStatusCompleted, Nonce: "4", Recipient: bridgeUser.Hex(), Amount: "1000000"}
+	index.Add(withdrawal)
+	index.MarkIndexed(bridgeUser, IndexedRange{FromBlock: 1, ToBlock: 100, From: bridgeAt.Add(-time.Hour), To: now}, time.Time{})
+
+	analyzer := NewFeeAnalyzer(index, datedPrices{time.June: 0.2}, detector.Labels(), nil)
+	analyzer.now = func() time.Time { return now }
+	analyzer.SetBridgeDetector(detector)
+
+	report, _, err := analyzer.FeeSpend(context.Background(), bridgeUser, bridgeAt.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.TxCount, "the relayer paid for the withdrawal")
+	require.Len(t, report.Bridges, 2)
+	out, in := report.Bridges[0], report.Bridges[1]
+	assert.Equal(t, BridgeDirectionOut, out.Direction)
+	assert.Equal(t, BridgeStatusPending, out.Status, "the withdrawal hasn't reached Ethereum yet")
+	assert.InDelta(t, 0.002, out.Fee, 1e-12)
+	assert.Equal(t, BridgeDirectionIn, in.Direction)
+	assert.Zero(t, in.Fee)
+	require.Len(t, report.ByContract, 1)
+	assert.Equal(t, "Portal bridge", report.ByContract[0].Label)
+	assert.Equal(t, 2, source.calls)
+
+	// Pending transfers aren't looked up again within a minute
+	_, _, err = analyzer.FeeSpend(context.Background(), bridgeUser, bridgeAt.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, source.calls)
+
+	// Once the withdrawal lands, the index keeps the completed transfer
+	now = now.Add(2 * time.Minute)
+	source.legs = append(source.legs, BridgeLeg{Kind: BridgeLegWithdrawal, Chain: "Ethereum", TxHash: "0xeth9", Timestamp: now.Add(-time.Minute), Nonce: "9", Recipient: bridgeUser.Hex(), Amount: "250000000"})
+	report, _, err = analyzer.FeeSpend(context.Background(), bridgeUser, bridgeAt.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, BridgeStatusCompleted, report.Bridges[0].Status)
+	indexed := index.Transactions(bridgeUser, bridgeAt, bridgeAt)
+	require.Len(t, indexed, 1)
+	require.NotNil(t, indexed[0].Bridge.Counterpart)
+	assert.Equal(t, "0xeth9", indexed[0].Bridge.Counterpart.TxHash)
+}
+
+func TestBackfillIndexesRelayedBridgeWithdrawals(t *testing.T) {
+	adapter := newTestBridge(t)
+	chainID := big.NewInt(8217)
+	signer := types.LatestSignerForChainID(chainID)
+	relayerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	bridge := bridgeKaia
+	relay := types.MustSignNewTx(relayerKey, signer, &types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(30 * gwei), Gas: 200_000, To: &bridge})
+	other := types.MustSignNewTx(relayerKey, signer, &types.LegacyTx{Nonce: 2, GasPrice: big.NewInt(30 * gwei), Gas: 200_000, To: &bridge})
+	header := &types.Header{Number: big.NewInt(1), Time: uint64(bridgeAt.Unix()), BaseFee: big.NewInt(25 * gwei)}
+	chain := &fakeChain{
+		chainID: chainID,
+		blocks: map[uint64]*types.Block{
+			1: types.NewBlockWithHeader(header).WithBody([]*types.Transaction{relay, other}, nil),
+		},
+		receipts: map[common.Hash]*types.Receipt{
+			relay.Hash(): {Status: types.ReceiptStatusSuccessful, GasUsed: 90_000, Logs: []*types.Log{
+				bridgeLog(t, adapter, BridgeLegWithdrawal, bridgeKaia, big.NewInt(4), bridgeUser, explainUSDT, big.NewInt(1_000_000)),
+			}},
+			other.Hash(): {Status: types.ReceiptStatusSuccessful, GasUsed: 90_000, Logs: []*types.Log{
+				bridgeLog(t, adapter, BridgeLegWithdrawal, bridgeKaia, big.NewInt(5), explainFriend, explainUSDT, big.NewInt(1_000_000)),
+			}},
+		},
+		head: 1,
+	}
+
+	index := NewTransactionIndex()
+	backfills := NewReceiptBackfiller(chain, index, 10, 1)
+	backfills.SetBridgeDetector(NewBridgeDetector([]*BridgeAdapter{adapter}))
+	found, err := backfills.indexBlock(context.Background(), bridgeUser, signer, chain.blocks[1])
+	require.NoError(t, err)
+	assert.Equal(t, 1, found, "only the withdrawal to the user")
+
+	txs := index.Transactions(bridgeUser, bridgeAt, bridgeAt)
+	require.Len(t, txs, 1)
+	assert.Equal(t, relay.Hash().Hex(), txs[0].Hash)
+	require.NotNil(t, txs[0].Bridge)
+	assert.Equal(t, BridgeDirectionIn, txs[0].Bridge.Direction)
+	assert.Equal(t, "1000000", txs[0].Bridge.Amount)
+}
+
+// legChain is a counterpart chain with a block every 12 seconds from
+// bridgeAt, serving fixture logs
+type legChain struct {
+	ChainClient
+
+	head    uint64
+	logs    []types.Log
+	queries []ethereum.FilterQuery
+}
+
+func (lc *legChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	n := lc.head
+	if number != nil {
+		n = number.Uint64()
+	}
+	return &types.Header{Number: new(big.Int).SetUint64(n), Time: uint64(bridgeAt.Add(time.Duration(n) * 12 * time.Second).Unix())}, nil
+}
+
+func (lc *legChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	lc.queries = append(lc.queries, query)
+	var logs []types.Log
+	for _, log := range lc.logs {
+		if log.BlockNumber >= query.FromBlock.Uint64() && log.BlockNumber <= query.ToBlock.Uint64() &&
+			log.Address == query.Addresses[0] && log.Topics[0] == query.Topics[0][0] {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func TestChainBridgeLegSourceReadsWindow(t *testing.T) {
+	adapter := newTestBridge(t)
+	withdrawal := func(block uint64, nonce int64) types.Log {
+		log := *bridgeLog(t, adapter, BridgeLegWithdrawal, bridgeEthereum, big.NewInt(nonce), bridgeUser, bridgeEthUSDT, big.NewInt(250_000_000))
+		log.BlockNumber = block
+		log.TxHash = common.BigToHash(big.NewInt(nonce))
+		return log
+	}
+	deposit := *bridgeLog(t, adapter, BridgeLegDeposit, bridgeEthereum, big.NewInt(1), bridgeUser, bridgeUser, bridgeEthUSDT, big.NewInt(1))
+	deposit.BlockNumber = 1500
+	// Blocks 1000 to 2000 span 12:00 to 15:20 after bridgeAt
+	chain := &legChain{head: 10_000, logs: []types.Log{withdrawal(990, 1), withdrawal(1000, 2), withdrawal(1500, 3), withdrawal(2000, 4), withdrawal(2010, 5), deposit}}
+
+	source := NewChainBridgeLegSource(chain, "Ethereum", 12*time.Second)
+	legs, err := source.BridgeLegs(context.Background(), adapter, BridgeLegWithdrawal,
+		bridgeAt.Add(1000*12*time.Second), bridgeAt.Add(2000*12*time.Second))
+	require.NoError(t, err)
+
+	nonces := make([]string, len(legs))
+	for i, leg := range legs {
+		nonces[i] = leg.Nonce
+		assert.Equal(t, "Ethereum", leg.Chain)
+		assert.Equal(t, bridgeEthUSDT.Hex(), leg.Token)
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, nonces, "logs outside the window are dropped by block time")
+	assert.Equal(t, bridgeAt.Add(1500*12*time.Second), legs[1].Timestamp)
+	require.NotEmpty(t, chain.queries)
+	assert.LessOrEqual(t, chain.queries[0].FromBlock.Uint64(), uint64(1000))
+	assert.GreaterOrEqual(t, chain.queries[len(chain.queries)-1].ToBlock.Uint64(), uint64(2000))
+	assert.Less(t, chain.queries[len(chain.queries)-1].ToBlock.Uint64(), chain.head)
+}
+
+func TestExplainBridgeTransfers(t *testing.T) {
+	adapter := newTestBridge(t)
+	f := newExplainFixture(t)
+	detector := NewBridgeDetector([]*BridgeAdapter{adapter})
+	detector.now = func() time.Time { return explainMinedAt.Add(time.Hour) }
+	source := &fakeLegSource{}
+	detector.SetCounterpart("Ethereum", source)
+	explainer := f.explainer()
+	explainer.SetBridgeDetector(detector)
+
+	bridge := bridgeKaia
+	amount := big.NewInt(250_000_000)
+	hash := f.mine(&bridge, nil, []byte{0x12, 0x34, 0x56, 0x78}, types.ReceiptStatusSuccessful,
+		transferLog(explainUSDT, f.sender, bridgeKaia, amount),
+		bridgeLog(t, adapter, BridgeLegDeposit, bridgeKaia, big.NewInt(7), f.sender, explainFriend, explainUSDT, amount))
+
+	explanation, err := explainer.Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, TxKindBridgeOut, explanation.Kind)
+	assert.Equal(t, explainFriend.Hex(), explanation.Counterparty)
+	require.Len(t, explanation.Sent, 1)
+	assert.Equal(t, "USDT", explanation.Sent[0].Symbol)
+	assert.Equal(t, 250.0, explanation.Sent[0].Amount)
+	require.NotNil(t, explanation.Bridge)
+	assert.Equal(t, BridgeStatusPending, explanation.Bridge.Status)
+	assert.Contains(t, explanation.Summary, "bridged 250 USDT ($250.00) from Kaia Mainnet to 0x0000…00E6 on Ethereum through Portal.")
+	assert.Contains(t, explanation.Summary, "still pending: the funds haven't been seen arriving on Ethereum yet")
+
+	source.legs = []BridgeLeg{{Kind: BridgeLegWithdrawal, Chain: "Ethereum", TxHash: "0xeth7", Timestamp: explainMinedAt.Add(20 * time.Minute), Nonce: "7", Recipient: explainFriend.Hex(), Amount: "250000000"}}
+	explanation, err = explainer.Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, BridgeStatusCompleted, explanation.Bridge.Status)
+	assert.Contains(t, explanation.Summary, "The funds arrived on Ethereum on 2 Jun 2025 at 14:25 UTC in transaction 0xeth7.")
+
+	// Chat describes the delivery of an incoming transfer
+	delivered := f.mine(&bridge, nil, []byte{0x12, 0x34, 0x56, 0x78}, types.ReceiptStatusSuccessful,
+		bridgeLog(t, adapter, BridgeLegWithdrawal, bridgeKaia, big.NewInt(3), explainFriend, common.Address{}, big.NewInt(5e18)))
+	engine := newTestChatEngine(t)
+	engine.SetTxExplainer(explainer)
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "0xuser", Message: "what is " + delivered.Hex()})
+	require.NoError(t, err)
+	assert.Equal(t, "tx_explain", response.Type)
+	assert.Contains(t, response.Response, "delivered 5 KAIA ($1.00) bridged from Ethereum through Portal to 0x0000…00E6.")
+	assert.Contains(t, response.Response, "The deposit on Ethereum that sent them hasn't been matched.")
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheKeyPrefix starts every cache key, followed by the namespace
+const CacheKeyPrefix = "ka:"
+
+// Cache namespaces, each with its own TTL
+const (
+	CacheMarket      = "market"
+	CacheGas         = "gas"
+	CacheYield       = "yield"
+	CacheChatHistory = "chat_history"
+	CacheBlocks      = "blocks"
+	CacheOverview    = "overview"
+)
+
+// CacheTTLs is how long entries of each namespace are served
+var CacheTTLs = map[string]time.Duration{
+	CacheMarket:      time.Minute,
+	CacheGas:         15 * time.Second,
+	CacheYield:       5 * time.Minute,
+	CacheChatHistory: 24 * time.Hour,
+	CacheBlocks:      5 * time.Second,
+	CacheOverview:    15 * time.Second,
+}
+
+// maxTrackedCacheKeys bounds the keys whose requests are counted. Keys
+// come from request parameters, so past the bound the least requested key
+// is forgotten to make room.
+const maxTrackedCacheKeys = 1000
+
+// ErrUnknownCacheNamespace is returned for namespaces without a TTL
+var ErrUnknownCacheNamespace = errors.New("unknown cache namespace")
+
+// CacheKey returns the key of an entry as ka:{namespace}:{key}
+func CacheKey(namespace, key string) string {
+	return CacheKeyPrefix + namespace + ":" + key
+}
+
+// CacheNamespaceStats describes the entries of one cache namespace. Expired
+// entries are not counted.
+type CacheNamespaceStats struct {
+	Namespace string `json:"namespace"`
+	Keys      int    `json:"keys"`
+	// SizeBytes is the JSON encoded size of the entries
+	SizeBytes  int     `json:"size_bytes"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+	// Hits and Misses count lookups since startup
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Ages of the oldest and newest entry, zero when there are none
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	NewestAgeSeconds float64 `json:"newest_age_seconds"`
+}
+
+// CacheKeyRequests counts the lookups of one key
+type CacheKeyRequests struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+}
+
+// Requests is the total number of lookups of the key
+func (r CacheKeyRequests) Requests() uint64 {
+	return r.Hits + r.Misses
+}
+
+// cacheEntry is a cached value and when it was stored
+type cacheEntry struct {
+	value    interface{}
+	size     int
+	storedAt time.Time
+}
+
+// Cache holds values under namespaced keys, each namespace expiring its
+// entries after its TTL in CacheTTLs
+type Cache struct {
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+
+	// requests counts the lookups of each key, guarded by requestsMu so
+	// lookups can share the entries lock
+	requestsMu sync.Mutex
+	requests   map[string]*CacheKeyRequests
+}
+
+// NewCache creates an empty cache
+func NewCache() *Cache {
+	return &Cache{
+		entries:  make(map[string]cacheEntry),
+		now:      utcNow,
+		requests: make(map[string]*CacheKeyRequests),
+	}
+}
+
+// Get returns a value if it is cached and not expired, counting the lookup
+// as a hit or a miss
+func (c *Cache) Get(namespace, key string) (interface{}, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[CacheKey(namespace, key)]
+	hit := ok && c.now().Sub(entry.storedAt) < CacheTTLs[namespace]
+	c.mu.RUnlock()
+
+	c.countRequest(namespace, key, hit)
+	if !hit {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// countRequest records a lookup of a key
+func (c *Cache) countRequest(namespace, key string, hit bool) {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	full := CacheKey(namespace, key)
+	requests, ok := c.requests[full]
+	if !ok {
+		if len(c.requests) >= maxTrackedCacheKeys {
+			c.forgetLeastRequested()
+		}
+		requests = &CacheKeyRequests{Namespace: namespace, Key: key}
+		c.requests[full] = requests
+	}
+	if hit {
+		requests.Hits++
+	} else {
+		requests.Misses++
+	}
+}
+
+// forgetLeastRequested stops counting the least requested key. The caller
+// must hold requestsMu.
+func (c *Cache) forgetLeastRequested() {
+	var least string
+	var leastRequests uint64
+	for key, requests := range c.requests {
+		if least == "" || requests.Requests() < leastRequests {
+			least, leastRequests = key, requests.Requests()
+		}
+	}
+	delete(c.requests, least)
+}
+
+// Popular returns the counted keys, most requested first. Ties are broken
+// by key so the ranking is stable.
+func (c *Cache) Popular() []CacheKeyRequests {
+	c.requestsMu.Lock()
+	popular := make([]CacheKeyRequests, 0, len(c.requests))
+	for _, requests := range c.requests {
+		popular = append(popular, *requests)
+	}
+	c.requestsMu.Unlock()
+
+	sort.Slice(popular, func(i, j int) bool {
+		if popular[i].Requests() != popular[j].Requests() {
+			return popular[i].Requests() > popular[j].Requests()
+		}
+		return CacheKey(popular[i].Namespace, popular[i].Key) < CacheKey(popular[j].Namespace, popular[j].Key)
+	})
+	return popular
+}
+
+// ExpiresIn returns how long an entry has left to be served, and false when
+// it isn't cached or has expired
+func (c *Cache) ExpiresIn(namespace, key string) (time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[CacheKey(namespace, key)]
+	if !ok {
+		return 0, false
+	}
+	left := CacheTTLs[namespace] - c.now().Sub(entry.storedAt)
+	return left, left > 0
+}
+
+// Set stores a value. Values of unknown namespaces are not cached.
+func (c *Cache) Set(namespace, key string, value interface{}) {
+	if _, ok := CacheTTLs[namespace]; !ok {
+		return
+	}
+	size := 0
+	if encoded, err := json.Marshal(value); err == nil {
+		size = len(encoded)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune()
+	c.entries[CacheKey(namespace, key)] = cacheEntry{value: value, size: size, storedAt: c.now()}
+}
+
+// prune drops expired entries. The caller must hold the write lock.
+func (c *Cache) prune() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if now.Sub(entry.storedAt) >= CacheTTLs[cacheNamespace(key)] {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Stats describes every namespace, including empty ones, by name
+func (c *Cache) Stats() []CacheNamespaceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	byNamespace := make(map[string]*CacheNamespaceStats, len(CacheTTLs))
+	for namespace, ttl := range CacheTTLs {
+		byNamespace[namespace] = &CacheNamespaceStats{Namespace: namespace, TTLSeconds: ttl.Seconds()}
+	}
+	c.requestsMu.Lock()
+	for _, requests := range c.requests {
+		if stats, ok := byNamespace[requests.Namespace]; ok {
+			stats.Hits += requests.Hits
+			stats.Misses += requests.Misses
+		}
+	}
+	c.requestsMu.Unlock()
+	for key, entry := range c.entries {
+		stats, ok := byNamespace[cacheNamespace(key)]
+		age := now.Sub(entry.storedAt)
+		if !ok || age.Seconds() >= stats.TTLSeconds {
+			continue
+		}
+		if stats.Keys == 0 || age.Seconds() > stats.OldestAgeSeconds {
+			stats.OldestAgeSeconds = age.Seconds()
+		}
+		if stats.Keys == 0 || age.Seconds() < stats.NewestAgeSeconds {
+			stats.NewestAgeSeconds = age.Seconds()
+		}
+		stats.Keys++
+		stats.SizeBytes += entry.size
+	}
+
+	stats := make([]CacheNamespaceStats, 0, len(byNamespace))
+	for _, namespace := range byNamespace {
+		stats = append(stats, *namespace)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Namespace < stats[j].Namespace })
+	return stats
+}
+
+// WritePrometheus exposes the key count and size of each namespace, so memory
+// growth can be traced to the feature holding it
+func (c *Cache) WritePrometheus(pw *PromWriter) {
+	for _, stats := range c.Stats() {
+		labels := map[string]string{"namespace": stats.Namespace}
+		pw.Gauge("kaia_cache_keys", "Unexpired cache entries per namespace.", float64(stats.Keys), labels)
+		pw.Gauge("kaia_cache_size_bytes", "JSON encoded size of the unexpired cache entries per namespace.", float64(stats.SizeBytes), labels)
+		pw.Counter("kaia_cache_requests_total", "Cache lookups per namespace by result.", float64(stats.Hits), map[string]string{"namespace": stats.Namespace, "result": "hit"})
+		pw.Counter("kaia_cache_requests_total", "Cache lookups per namespace by result.", float64(stats.Misses), map[string]string{"namespace": stats.Namespace, "result": "miss"})
+	}
+}
+
+// Clear drops every entry of a namespace and returns how many there were
+func (c *Cache) Clear(namespace string) (int, error) {
+	if _, ok := CacheTTLs[namespace]; !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCacheNamespace, namespace)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := CacheKey(namespace, "")
+	cleared := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			cleared++
+		}
+	}
+	return cleared, nil
+}
+
+// ClearAll drops every entry
+func (c *Cache) ClearAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cacheEntry)
+}
+
+// Len returns the number of stored entries, expired ones included
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.entries)
+}
+
+// cacheNamespace returns the namespace of a ka:{namespace}:{key} key
+func cacheNamespace(key string) string {
+	namespace, _, _ := strings.Cut(strings.TrimPrefix(key, CacheKeyPrefix), ":")
+	return namespace
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPrimeTopK is how many of the most requested keys are primed
+	DefaultPrimeTopK = 10
+	// DefaultPrimePoolShare is the share of the pool's workers priming may
+	// occupy at once
+	DefaultPrimePoolShare = 0.1
+
+	// primeInterval is how often due keys are looked for
+	primeInterval = 5 * time.Second
+	// primeLeadShare is how early, as a share of its TTL, a key is
+	// recomputed before it expires
+	primeLeadShare = 0.2
+	// primeBackoffUtilization is the share of busy workers above which no
+	// key is primed, leaving the pool to live requests
+	primeBackoffUtilization = 0.7
+)
+
+// userCacheNamespaces hold one user's entries, which are never primed
+var userCacheNamespaces = map[string]bool{
+	CacheChatHistory: true,
+}
+
+// cacheAddressPattern finds addresses in keys, which make a key belong to
+// the user querying that address
+var cacheAddressPattern = regexp.MustCompile(`(?i)0x[0-9a-f]{40}`)
+
+// IsUserSpecificCacheKey reports whether an entry belongs to one user,
+// because of its namespace or an address in its key
+func IsUserSpecificCacheKey(namespace, key string) bool {
+	return userCacheNamespaces[namespace] || cacheAddressPattern.MatchString(key)
+}
+
+// CacheLoader recomputes the entry of a key and stores it in the cache
+type CacheLoader func(ctx context.Context, key string) error
+
+// PrimePool is the worker pool primed keys are recomputed on
+type PrimePool interface {
+	Submit(task func()) error
+	Stats() AdaptivePoolStats
+}
+
+// ActiveHours are the UTC hours [Start, End) keys are primed in. A start
+// after the end wraps past midnight, and equal hours mean all day.
+type ActiveHours struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// AllDay are the active hours that never pause priming
+var AllDay = ActiveHours{Start: 0, End: 24}
+
+// ParseActiveHours parses hours given as "7-23". An empty spec is all day.
+func ParseActiveHours(spec string) (ActiveHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return AllDay, nil
+	}
+	start, end, ok := strings.Cut(spec, "-")
+	if !ok {
+		return ActiveHours{}, fmt.Errorf("active hours %q must be given as start-end", spec)
+	}
+	hours := ActiveHours{}
+	var err error
+	if hours.Start, err = strconv.Atoi(strings.TrimSpace(start)); err != nil {
+		return ActiveHours{}, fmt.Errorf("invalid start hour in %q: %w", spec, err)
+	}
+	if hours.End, err = strconv.Atoi(strings.TrimSpace(end)); err != nil {
+		return ActiveHours{}, fmt.Errorf("invalid end hour in %q: %w", spec, err)
+	}
+	if hours.Start < 0 || hours.Start > 24 || hours.End < 0 || hours.End > 24 {
+		return ActiveHours{}, fmt.Errorf("active hours %q must be between 0 and 24", spec)
+	}
+	return hours, nil
+}
+
+// Contains reports whether a time falls in the active hours
+func (h ActiveHours) Contains(t time.Time) bool {
+	hour := t.UTC().Hour()
+	switch {
+	case h.Start%24 == h.End%24:
+		return true
+	case h.Start < h.End:
+		return hour >= h.Start && hour < h.End
+	default:
+		return hour >= h.Start || hour < h.End
+	}
+}
+
+// PrimedKey records the priming of one key
+type PrimedKey struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Primes    uint64 `json:"primes"`
+	Failures  uint64 `json:"failures"`
+	// CostSeconds is the time spent recomputing the key
+	CostSeconds float64  `json:"cost_seconds"`
+	LastPrimed  *APITime `json:"last_primed,omitempty"`
+	LastError   string   `json:"last_error,omitempty"`
+}
+
+// CachePrimerStats is a snapshot of what a primer has recomputed
+type CachePrimerStats struct {
+	TopK        int         `json:"top_k"`
+	PoolShare   float64     `json:"pool_share"`
+	ActiveHours ActiveHours `json:"active_hours"`
+	InFlight    int         `json:"in_flight"`
+	Backoffs    uint64      `json:"backoffs"`
+	Keys        []PrimedKey `json:"keys"`
+}
+
+// CachePrimer recomputes the most requested cache keys shortly before they
+// expire, so the first request after an expiry is served from the cache.
+// Only namespaces with a loader are primed, user-specific keys never are,
+// and priming pauses outside the active hours and while the pool is busy.
+type CachePrimer struct {
+	cache   *Cache
+	pool    PrimePool
+	loaders map[string]CacheLoader
+	logger  *log.Logger
+	now     func() time.Time
+
+	mu       sync.Mutex
+	topK     int
+	share    float64
+	hours    ActiveHours
+	inFlight map[string]bool
+	primed   map[string]*PrimedKey
+	backoffs uint64
+}
+
+// NewCachePrimer creates a primer of the cache's top DefaultPrimeTopK keys,
+// active all day
+func NewCachePrimer(cache *Cache, pool PrimePool) *CachePrimer {
+	return &CachePrimer{
+		cache:    cache,
+		pool:     pool,
+		loaders:  make(map[string]CacheLoader),
+		logger:   log.New(log.Writer(), "[CachePrimer] ", log.LstdFlags),
+		now:      utcNow,
+		topK:     DefaultPrimeTopK,
+		share:    DefaultPrimePoolShare,
+		hours:    AllDay,
+		inFlight: make(map[string]bool),
+		primed:   make(map[string]*PrimedKey),
+	}
+}
+
+// Register sets the loader of a namespace. User-specific namespaces can't
+// be primed.
+func (p *CachePrimer) Register(namespace string, loader CacheLoader) error {
+	if _, ok := CacheTTLs[namespace]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownCacheNamespace, namespace)
+	}
+	if userCacheNamespaces[namespace] {
+		return fmt.Errorf("cache namespace %s holds user-specific entries and can't be primed", namespace)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loaders[namespace] = loader
+	return nil
+}
+
+// SetTopK sets how many of the most requested keys are primed
+func (p *CachePrimer) SetTopK(topK int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.topK = topK
+}
+
+// SetPoolShare sets the share of the pool's workers priming may occupy
+func (p *CachePrimer) SetPoolShare(share float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.share = share
+}
+
+// SetActiveHours sets the hours keys are primed in
+func (p *CachePrimer) SetActiveHours(hours ActiveHours) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hours = hours
+}
+
+// Start primes due keys until the context is cancelled
+func (p *CachePrimer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(primeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Prime(ctx)
+			}
+		}
+	}()
+}
+
+// Prime submits the recomputation of the top keys that are due, within the
+// primer's share of the pool, and returns how many were submitted
+func (p *CachePrimer) Prime(ctx context.Context) int {
+	type primeTask struct {
+		namespace, key string
+		loader         CacheLoader
+	}
+
+	now := p.now()
+	p.mu.Lock()
+	if !p.hours.Contains(now) || p.topK <= 0 {
+		p.mu.Unlock()
+		return 0
+	}
+	stats := p.pool.Stats()
+	if stats.Size > 0 && float64(stats.Busy)/float64(stats.Size) > primeBackoffUtilization {
+		p.backoffs++
+		p.mu.Unlock()
+		return 0
+	}
+	// A pool too small for a share of it still primes one key at a time
+	budget := int(float64(stats.Size)*p.share) - len(p.inFlight)
+	if budget <= 0 && len(p.inFlight) == 0 {
+		budget = 1
+	}
+	var tasks []primeTask
+	for _, key := range p.candidates() {
+		if len(tasks) >= budget {
+			break
+		}
+		if p.inFlight[CacheKey(key.Namespace, key.Key)] || !p.due(key.Namespace, key.Key) {
+			continue
+		}
+		p.inFlight[CacheKey(key.Namespace, key.Key)] = true
+		tasks = append(tasks, primeTask{namespace: key.Namespace, key: key.Key, loader: p.loaders[key.Namespace]})
+	}
+	p.mu.Unlock()
+
+	submitted := 0
+	for i, task := range tasks {
+		task := task
+		if err := p.pool.Submit(func() { p.load(ctx, task.loader, task.namespace, task.key) }); err != nil {
+			p.logger.Printf("Failed to submit priming of %s: %v", CacheKey(task.namespace, task.key), err)
+			p.mu.Lock()
+			for _, unsubmitted := range tasks[i:] {
+				delete(p.inFlight, CacheKey(unsubmitted.namespace, unsubmitted.key))
+			}
+			p.mu.Unlock()
+			break
+		}
+		submitted++
+	}
+	return submitted
+}
+
+// candidates returns the top keys that can be primed. The caller must hold mu.
+func (p *CachePrimer) candidates() []CacheKeyRequests {
+	var candidates []CacheKeyRequests
+	for _, key := range p.cache.Popular() {
+		if len(candidates) == p.topK {
+			break
+		}
+		if p.loaders[key.Namespace] == nil || IsUserSpecificCacheKey(key.Namespace, key.Key) {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	return candidates
+}
+
+// due reports whether a key has expired or expires within its lead
+func (p *CachePrimer) due(namespace, key string) bool {
+	left, ok := p.cache.ExpiresIn(namespace, key)
+	return !ok || left <= time.Duration(float64(CacheTTLs[namespace])*primeLeadShare)
+}
+
+// load recomputes a key and records what it cost
+func (p *CachePrimer) load(ctx context.Context, loader CacheLoader, namespace, key string) {
+	started := p.now()
+	err := loader(ctx, key)
+	finished := p.now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	full := CacheKey(namespace, key)
+	delete(p.inFlight, full)
+	primed, ok := p.primed[full]
+	if !ok {
+		primed = &PrimedKey{Namespace: namespace, Key: key}
+		p.primed[full] = primed
+	}
+	primed.CostSeconds += finished.Sub(started).Seconds()
+	if err != nil {
+		primed.Failures++
+		primed.LastError = err.Error()
+		p.logger.Printf("Failed to prime %s: %v", full, err)
+		return
+	}
+	at := NewAPITime(finished)
+	primed.Primes++
+	primed.LastPrimed = &at
+	primed.LastError = ""
+}
+
+// Stats returns what has been primed, most primed keys first
+func (p *CachePrimer) Stats() CachePrimerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := CachePrimerStats{
+		TopK:        p.topK,
+		PoolShare:   p.share,
+		ActiveHours: p.hours,
+		InFlight:    len(p.inFlight),
+		Backoffs:    p.backoffs,
+		Keys:        make([]PrimedKey, 0, len(p.primed)),
+	}
+	for _, primed := range p.primed {
+		stats.Keys = append(stats.Keys, *primed)
+	}
+	sort.Slice(stats.Keys, func(i, j int) bool {
+		if stats.Keys[i].Primes != stats.Keys[j].Primes {
+			return stats.Keys[i].Primes > stats.Keys[j].Primes
+		}
+		return CacheKey(stats.Keys[i].Namespace, stats.Keys[i].Key) < CacheKey(stats.Keys[j].Namespace, stats.Keys[j].Key)
+	})
+	return stats
+}
+
+// WritePrometheus writes the primes and their cost per namespace
+func (p *CachePrimer) WritePrometheus(pw *PromWriter) {
+	stats := p.Stats()
+	type namespaceTotals struct {
+		primes, failures uint64
+		cost             float64
+	}
+	totals := make(map[string]*namespaceTotals)
+	p.mu.Lock()
+	for namespace := range p.loaders {
+		totals[namespace] = &namespaceTotals{}
+	}
+	p.mu.Unlock()
+	for _, key := range stats.Keys {
+		total, ok := totals[key.Namespace]
+		if !ok {
+			total = &namespaceTotals{}
+			totals[key.Namespace] = total
+		}
+		total.primes += key.Primes
+		total.failures += key.Failures
+		total.cost += key.CostSeconds
+	}
+	namespaces := make([]string, 0, len(totals))
+	for namespace := range totals {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		labels := map[string]string{"namespace": namespace}
+		pw.Counter("kaia_cache_primes_total", "Cache keys recomputed before expiry per namespace.", float64(totals[namespace].primes), labels)
+		pw.Counter("kaia_cache_prime_failures_total", "Failed recomputations of primed cache keys per namespace.", float64(totals[namespace].failures), labels)
+		pw.Counter("kaia_cache_prime_seconds_total", "Time spent recomputing primed cache keys per namespace.", totals[namespace].cost, labels)
+	}
+	pw.Gauge("kaia_cache_primes_in_flight", "Primed cache keys being recomputed.", float64(stats.InFlight), nil)
+	pw.Counter("kaia_cache_prime_backoffs_total", "Priming rounds skipped because the pool was busy.", float64(stats.Backoffs), nil)
+}
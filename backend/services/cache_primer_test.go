@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePrimePool runs primed loads as they are submitted
+type fakePrimePool struct {
+	size, busy int
+	submitted  int
+}
+
+func (p *fakePrimePool) Submit(task func()) error {
+	p.submitted++
+	task()
+	return nil
+}
+
+func (p *fakePrimePool) Stats() AdaptivePoolStats {
+	return AdaptivePoolStats{Pool: "analytics", Size: p.size, Busy: p.busy}
+}
+
+// primerFixture is a primer over a cache on a fake clock, with loaders
+// recording the keys they recompute
+type primerFixture struct {
+	cache  *Cache
+	pool   *fakePrimePool
+	primer *CachePrimer
+	now    time.Time
+	loaded []string
+}
+
+func newPrimerFixture(t *testing.T) *primerFixture {
+	t.Helper()
+	f := &primerFixture{
+		cache: NewCache(),
+		pool:  &fakePrimePool{size: 10},
+		now:   time.Date(2025, 6, 2, 8, 0, 0, 0, time.UTC),
+	}
+	clock := func() time.Time { return f.now }
+	f.cache.now = clock
+	f.primer = NewCachePrimer(f.cache, f.pool)
+	f.primer.now = clock
+	f.primer.SetPoolShare(0.5)
+	for _, namespace := range []string{CacheMarket, CacheYield} {
+		namespace := namespace
+		require.NoError(t, f.primer.Register(namespace, func(ctx context.Context, key string) error {
+			f.loaded = append(f.loaded, CacheKey(namespace, key))
+			f.cache.Set(namespace, key, key)
+			return nil
+		}))
+	}
+	return f
+}
+
+// request looks a key up times times
+func (f *primerFixture) request(namespace, key string, times int) {
+	for i := 0; i < times; i++ {
+		f.cache.Get(namespace, key)
+	}
+}
+
+// prime runs a priming round and returns the keys it recomputed
+func (f *primerFixture) prime() []string {
+	f.loaded = nil
+	f.primer.Prime(context.Background())
+	return f.loaded
+}
+
+func TestCachePrimerRecomputesPopularKeysBeforeExpiry(t *testing.T) {
+	f := newPrimerFixture(t)
+	f.primer.SetTopK(3)
+	f.request(CacheMarket, "KAIA", 5)
+	f.request(CacheYield, "protocols", 4)
+	f.request(CacheMarket, "ETH", 3)
+	f.request(CacheMarket, "DAI", 1)
+	f.request(CacheChatHistory, "session-1", 10)
+	f.request(CacheMarket, "0x00000000000000000000000000000000000000e6", 8)
+	f.request(CacheGas, "latest", 6)
+
+	popular := f.cache.Popular()
+	require.Len(t, popular, 7)
+	assert.Equal(t, CacheKeyRequests{Namespace: CacheChatHistory, Key: "session-1", Misses: 10}, popular[0])
+
+	assert.Equal(t, []string{
+		CacheKey(CacheMarket, "KAIA"),
+		CacheKey(CacheYield, "protocols"),
+		CacheKey(CacheMarket, "ETH"),
+	}, f.prime(), "user-specific keys and namespaces without a loader are passed over")
+
+	f.now = f.now.Add(30 * time.Second)
+	assert.Empty(t, f.prime(), "nothing is due half way through its TTL")
+
+	f.now = f.now.Add(20 * time.Second)
+	assert.Equal(t, []string{CacheKey(CacheMarket, "KAIA"), CacheKey(CacheMarket, "ETH")}, f.prime(),
+		"market keys are due 10s before expiry, protocols aren't")
+
+	_, ok := f.cache.Get(CacheMarket, "KAIA")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(6), f.cache.Popular()[2].Requests(), "priming isn't counted as requests")
+
+	stats := f.primer.Stats()
+	require.Len(t, stats.Keys, 3)
+	assert.Equal(t, CacheKey(CacheMarket, "ETH"), CacheKey(stats.Keys[0].Namespace, stats.Keys[0].Key))
+	assert.Equal(t, uint64(2), stats.Keys[0].Primes)
+	assert.Equal(t, uint64(1), stats.Keys[2].Primes)
+	for _, key := range stats.Keys {
+		assert.False(t, IsUserSpecificCacheKey(key.Namespace, key.Key))
+	}
+
+	var buf bytes.Buffer
+	f.primer.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), `kaia_cache_primes_total{namespace="market"} 4`)
+	assert.Contains(t, buf.String(), `kaia_cache_primes_total{namespace="yield"} 1`)
+	assert.Contains(t, buf.String(), `kaia_cache_prime_seconds_total{namespace="market"} 0`)
+}
+
+func TestCachePrimerStaysWithinActiveHoursAndPoolShare(t *testing.T) {
+	f := newPrimerFixture(t)
+	f.request(CacheMarket, "KAIA", 3)
+	f.request(CacheMarket, "ETH", 2)
+	f.request(CacheMarket, "DAI", 1)
+
+	hours, err := ParseActiveHours("22-7")
+	require.NoError(t, err)
+	f.primer.SetActiveHours(hours)
+	assert.Empty(t, f.prime(), "08:00 is outside 22-07")
+	f.now = f.now.Add(-2 * time.Hour)
+	require.Len(t, f.prime(), 3, "06:00 is inside, wrapping past midnight")
+
+	f.primer.SetActiveHours(AllDay)
+	f.now = f.now.Add(time.Minute)
+	f.pool.busy = 8
+	assert.Empty(t, f.prime(), "no priming above 70% utilization")
+	assert.Equal(t, uint64(1), f.primer.Stats().Backoffs)
+
+	f.pool.busy = 7
+	f.primer.SetPoolShare(0.2)
+	assert.Equal(t, []string{CacheKey(CacheMarket, "KAIA"), CacheKey(CacheMarket, "ETH")}, f.prime(),
+		"at most a fifth of the 10 workers")
+
+	f.pool.size = 1
+	f.pool.busy = 0
+	assert.Equal(t, []string{CacheKey(CacheMarket, "DAI")}, f.prime(), "a small pool still primes one key")
+}
+
+func TestCachePrimerRefusesUserNamespaces(t *testing.T) {
+	primer := NewCachePrimer(NewCache(), &fakePrimePool{size: 10})
+	err := primer.Register(CacheChatHistory, func(context.Context, string) error { return nil })
+	assert.ErrorContains(t, err, "user-specific")
+	err = primer.Register("sessions", func(context.Context, string) error { return nil })
+	assert.ErrorIs(t, err, ErrUnknownCacheNamespace)
+
+	_, err = ParseActiveHours("7")
+	assert.Error(t, err)
+	_, err = ParseActiveHours("7-25")
+	assert.Error(t, err)
+	hours, err := ParseActiveHours(" 7 - 23 ")
+	require.NoError(t, err)
+	assert.Equal(t, ActiveHours{Start: 7, End: 23}, hours)
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheStats indexes cache stats by namespace
+func cacheStats(cache *Cache) map[string]CacheNamespaceStats {
+	stats := make(map[string]CacheNamespaceStats)
+	for _, namespace := range cache.Stats() {
+		stats[namespace.Namespace] = namespace
+	}
+	return stats
+}
+
+func TestCacheClearsOneNamespace(t *testing.T) {
+	cache := NewCache()
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	cache.Set(CacheMarket, "KAIA", MarketData{Symbol: "KAIA", Price: 0.15})
+	now = now.Add(10 * time.Second)
+	cache.Set(CacheMarket, "ETH", MarketData{Symbol: "ETH", Price: 3200})
+	cache.Set(CacheGas, "latest", map[string]interface{}{"current_gas_price": 25})
+	cache.Set(CacheYield, "protocols", []ProtocolData{{Protocol: "Aave V3"}})
+	cache.Set("sessions", "ignored", "not a namespace")
+
+	stats := cacheStats(cache)
+	require.Len(t, stats, len(CacheTTLs), "every namespace is listed, empty or not")
+	assert.Equal(t, 2, stats[CacheMarket].Keys)
+	assert.Positive(t, stats[CacheMarket].SizeBytes)
+	assert.Equal(t, 10.0, stats[CacheMarket].OldestAgeSeconds)
+	assert.Zero(t, stats[CacheMarket].NewestAgeSeconds)
+	assert.Equal(t, 1, stats[CacheGas].Keys)
+	assert.Zero(t, stats[CacheBlocks].Keys)
+
+	cleared, err := cache.Clear(CacheMarket)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cleared)
+	_, ok := cache.Get(CacheMarket, "KAIA")
+	assert.False(t, ok)
+	gas, ok := cache.Get(CacheGas, "latest")
+	require.True(t, ok, "other namespaces are left intact")
+	assert.Equal(t, 25, gas.(map[string]interface{})["current_gas_price"])
+	_, ok = cache.Get(CacheYield, "protocols")
+	assert.True(t, ok)
+
+	_, err = cache.Clear("sessions")
+	assert.True(t, errors.Is(err, ErrUnknownCacheNamespace))
+}
+
+func TestCacheExpiresByNamespaceTTL(t *testing.T) {
+	cache := NewCache()
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	cache.Set(CacheGas, "latest", 1)
+	cache.Set(CacheYield, "protocols", 2)
+	assert.Equal(t, "ka:gas:latest", CacheKey(CacheGas, "latest"))
+
+	now = now.Add(CacheTTLs[CacheGas])
+	_, ok := cache.Get(CacheGas, "latest")
+	assert.False(t, ok)
+	_, ok = cache.Get(CacheYield, "protocols")
+	assert.True(t, ok)
+	assert.Zero(t, cacheStats(cache)[CacheGas].Keys, "expired entries aren't counted")
+}
+
+func TestDataCollectorServesCachedData(t *testing.T) {
+	ctx := context.Background()
+	collector := NewDataCollector(nil)
+	cache := collector.Cache()
+
+	data, err := collector.fetchReferenceMarketData(ctx, "KAIA")
+	require.NoError(t, err)
+	data.Price = 99
+	cached, err := collector.fetchReferenceMarketData(ctx, "KAIA")
+	require.NoError(t, err)
+	assert.Equal(t, 0.15, cached.Price, "callers get copies of cached values")
+
+	_, err = collector.CollectProtocolData(ctx)
+	require.NoError(t, err)
+	stats := cacheStats(cache)
+	assert.Equal(t, 1, stats[CacheMarket].Keys)
+	assert.Equal(t, 1, stats[CacheYield].Keys)
+
+	_, err = cache.Clear(CacheYield)
+	require.NoError(t, err)
+	_, ok := collector.GetCachedData(CacheMarket, "KAIA")
+	assert.True(t, ok)
+	_, ok = collector.GetCachedData(CacheYield, "protocols")
+	assert.False(t, ok)
+}
+
+func TestCacheWritesPrometheus(t *testing.T) {
+	cache := NewCache()
+	cache.Set(CacheMarket, "KAIA", MarketData{Symbol: "KAIA"})
+	cache.Get(CacheMarket, "KAIA")
+	cache.Get(CacheMarket, "KAIA")
+	cache.Get(CacheMarket, "ETH")
+
+	var buf bytes.Buffer
+	cache.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), `kaia_cache_requests_total{namespace="market",result="hit"} 2`)
+	assert.Contains(t, buf.String(), `kaia_cache_requests_total{namespace="market",result="miss"} 1`)
+	assert.Contains(t, buf.String(), `kaia_cache_keys{namespace="market"} 1`)
+	assert.Contains(t, buf.String(), `kaia_cache_keys{namespace="gas"} 0`)
+	assert.Contains(t, buf.String(), `kaia_cache_size_bytes{namespace="market"} `+strconv.Itoa(cacheStats(cache)[CacheMarket].SizeBytes))
+}
+
+// TestCacheWritesUseNamespaces checks every cache write in the package names
+// one of the namespace constants, so no entry is stored without a TTL or
+// outside the ka:{namespace}: key scheme
+func TestCacheWritesUseNamespaces(t *testing.T) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+
+	// The Cache* string constants, which must all have a TTL
+	namespaces := make(map[string]bool)
+	for _, file := range packages["services"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if i >= len(value.Values) {
+						continue
+					}
+					literal, ok := value.Values[i].(*ast.BasicLit)
+					if !strings.HasPrefix(name.Name, "Cache") || !ok || literal.Kind != token.STRING || name.Name == "CacheKeyPrefix" {
+						continue
+					}
+					namespace, _ := strconv.Unquote(literal.Value)
+					assert.Contains(t, CacheTTLs, namespace, "namespace %s has no TTL", name.Name)
+					namespaces[name.Name] = true
+				}
+			}
+		}
+	}
+	require.NotEmpty(t, namespaces)
+
+	writes := 0
+	for path, file := range packages["services"].Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			// SetCachedData passes its namespace through; its callers are checked
+			if fn, ok := node.(*ast.FuncDecl); ok && fn.Name.Name == "SetCachedData" {
+				return false
+			}
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !isCacheWrite(selector) {
+				return true
+			}
+			writes++
+			namespace, ok := call.Args[0].(*ast.Ident)
+			assert.True(t, ok && namespaces[namespace.Name], "%s: cache write at %s doesn't use a namespace constant",
+				path, fset.Position(call.Pos()))
+			return true
+		})
+	}
+	assert.Positive(t, writes)
+}
+
+// isCacheWrite reports whether a call is cache.Set or SetCachedData
+func isCacheWrite(selector *ast.SelectorExpr) bool {
+	if selector.Sel.Name == "SetCachedData" {
+		return true
+	}
+	if selector.Sel.Name != "Set" {
+		return false
+	}
+	switch receiver := selector.X.(type) {
+	case *ast.SelectorExpr:
+		return receiver.Sel.Name == "cache"
+	case *ast.Ident:
+		return receiver.Name == "cache"
+	}
+	return false
+}
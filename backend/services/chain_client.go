@@ -0,0 +1,662 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ChainClient is the JSON-RPC surface the services read chain state through.
+// *ethclient.Client satisfies it, as does FailoverClient.
+type ChainClient interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	NetworkID(ctx context.Context) (*big.Int, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+	SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error)
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error)
+	SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
+	Close()
+}
+
+var (
+	_ ChainClient       = (*ethclient.Client)(nil)
+	_ ChainClient       = (*FailoverClient)(nil)
+	_ TransactionTracer = (*FailoverClient)(nil)
+	_ PendingTxSource   = (*FailoverClient)(nil)
+)
+
+// FailoverOptions configures a FailoverClient
+type FailoverOptions struct {
+	// MaxConcurrency caps concurrent in-flight requests per endpoint
+	MaxConcurrency int
+	// MaxRetries is how many extra passes over the endpoints a read gets
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry pass; it doubles per pass
+	RetryBackoff time.Duration
+	// HealthCheckInterval is how often endpoints are probed
+	HealthCheckInterval time.Duration
+	// SlowCallThreshold is how long a call runs before it is logged as slow
+	SlowCallThreshold time.Duration
+}
+
+// DefaultFailoverOptions returns the options used when none are configured
+func DefaultFailoverOptions() FailoverOptions {
+	return FailoverOptions{
+		MaxConcurrency:      32,
+		MaxRetries:          2,
+		RetryBackoff:        200 * time.Millisecond,
+		HealthCheckInterval: 15 * time.Second,
+		SlowCallThreshold:   DefaultSlowRPCCallThreshold,
+	}
+}
+
+// NamedChainClient pairs a client with the endpoint it talks to
+type NamedChainClient struct {
+	Name   string
+	Client ChainClient
+}
+
+// rpcEndpoint is a single upstream node with its health, limits, and metrics
+type rpcEndpoint struct {
+	name      string
+	client    ChainClient
+	sem       chan struct{}
+	healthy   atomic.Bool
+	requests  atomic.Uint64
+	errors    atomic.Uint64
+	latencyNs atomic.Uint64
+	inUse     atomic.Int64
+}
+
+// EndpointStats is a point-in-time view of an endpoint's metrics
+type EndpointStats struct {
+	Endpoint     string  `json:"endpoint"`
+	Healthy      bool    `json:"healthy"`
+	Requests     uint64  `json:"requests"`
+	Errors       uint64  `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	InUse        int64   `json:"in_use"`
+}
+
+// FailoverClient routes reads to the first healthy endpoint in priority order,
+// failing over to the next one on error. Every method is a read, so failed
+// calls are retried across endpoints with exponential backoff.
+type FailoverClient struct {
+	endpoints []*rpcEndpoint
+	opts      FailoverOptions
+	methods   *RPCMethodMetrics
+	logger    *log.Logger
+}
+
+// DialFailoverClient connects to each URL in priority order
+func DialFailoverClient(ctx context.Context, urls []string, opts FailoverOptions) (*FailoverClient, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("at least one RPC URL is required")
+	}
+
+	clients := make([]NamedChainClient, 0, len(urls))
+	for _, rawURL := range urls {
+		client, err := ethclient.DialContext(ctx, rawURL)
+		if err != nil {
+			for _, dialed := range clients {
+				dialed.Client.Close()
+			}
+			return nil, fmt.Errorf("failed to dial %s: %w", endpointLabel(rawURL), err)
+		}
+		clients = append(clients, NamedChainClient{Name: endpointLabel(rawURL), Client: client})
+	}
+
+	return NewFailoverClient(clients, opts), nil
+}
+
+// NewFailoverClient wraps already-connected clients, highest priority first
+func NewFailoverClient(clients []NamedChainClient, opts FailoverOptions) *FailoverClient {
+	defaults := DefaultFailoverOptions()
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = defaults.MaxConcurrency
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = defaults.HealthCheckInterval
+	}
+
+	fc := &FailoverClient{
+		endpoints: make([]*rpcEndpoint, 0, len(clients)),
+		opts:      opts,
+		methods:   NewRPCMethodMetrics(opts.SlowCallThreshold),
+		logger:    log.New(log.Writer(), "[ChainClient] ", log.LstdFlags),
+	}
+	for _, named := range clients {
+		endpoint := &rpcEndpoint{
+			name:   named.Name,
+			client: named.Client,
+			sem:    make(chan struct{}, opts.MaxConcurrency),
+		}
+		endpoint.healthy.Store(true)
+		fc.endpoints = append(fc.endpoints, endpoint)
+	}
+	return fc
+}
+
+// endpointLabel strips credentials, paths, and query strings (which often hold
+// API keys) so the endpoint can be logged and used as a metric label
+func endpointLabel(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "invalid-endpoint"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// Start probes endpoint health in the background until ctx is cancelled
+func (fc *FailoverClient) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(fc.opts.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fc.CheckHealth(ctx)
+			}
+		}
+	}()
+}
+
+// CheckHealth probes every endpoint and updates its health
+func (fc *FailoverClient) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, endpoint := range fc.endpoints {
+		wg.Add(1)
+		go func(endpoint *rpcEndpoint) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			_, err := endpoint.client.BlockNumber(probeCtx)
+			fc.setHealthy(endpoint, err == nil)
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+func (fc *FailoverClient) setHealthy(endpoint *rpcEndpoint, healthy bool) {
+	if endpoint.healthy.Swap(healthy) != healthy {
+		if healthy {
+			fc.logger.Printf("Endpoint %s recovered", endpoint.name)
+		} else {
+			fc.logger.Printf("Endpoint %s marked unhealthy", endpoint.name)
+		}
+	}
+}
+
+// candidates returns healthy endpoints in priority order followed by the
+// unhealthy ones, which are only tried as a last resort
+func (fc *FailoverClient) candidates() []*rpcEndpoint {
+	ordered := make([]*rpcEndpoint, 0, len(fc.endpoints))
+	var unhealthy []*rpcEndpoint
+	for _, endpoint := range fc.endpoints {
+		if endpoint.healthy.Load() {
+			ordered = append(ordered, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+	return append(ordered, unhealthy...)
+}
+
+// call runs fn, which sends the method, against the endpoints until one
+// succeeds
+func (fc *FailoverClient) call(ctx context.Context, method rpcCall, fn func(ChainClient) error) error {
+	var lastErr, pruned error
+
+	for attempt := 0; attempt <= fc.opts.MaxRetries; attempt++ {
+		if attempt > 0 && fc.opts.RetryBackoff > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(fc.opts.RetryBackoff << (attempt - 1)):
+			}
+		}
+
+		for _, endpoint := range fc.candidates() {
+			err := fc.send(ctx, endpoint, method, fn)
+			if err == nil {
+				fc.setHealthy(endpoint, true)
+				return nil
+			}
+			// Not-found is an answer, not a failure; cancellation ends the call
+			if errors.Is(err, ethereum.NotFound) || ctx.Err() != nil {
+				return err
+			}
+			// A node without the state answered; an archive node may have it
+			if IsStatePruned(err) {
+				pruned = err
+				continue
+			}
+			lastErr = err
+			fc.setHealthy(endpoint, false)
+		}
+		if lastErr == nil && pruned != nil {
+			return pruned
+		}
+	}
+
+	return fmt.Errorf("all RPC endpoints failed: %w", lastErr)
+}
+
+// do runs fn on the endpoint within its concurrency limit
+func (e *rpcEndpoint) do(ctx context.Context, fn func(ChainClient) error) error {
+	select {
+	case e.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-e.sem }()
+
+	e.inUse.Add(1)
+	defer e.inUse.Add(-1)
+
+	start := time.Now()
+	err := fn(e.client)
+	e.latencyNs.Add(uint64(time.Since(start).Nanoseconds()))
+	e.requests.Add(1)
+	if err != nil && !errors.Is(err, ethereum.NotFound) {
+		e.errors.Add(1)
+	}
+	return err
+}
+
+// send runs fn, which sends the method, on the endpoint and records the call
+// in the per-method metrics. Time spent waiting for the endpoint's
+// concurrency limit isn't counted.
+func (fc *FailoverClient) send(ctx context.Context, endpoint *rpcEndpoint, method rpcCall, fn func(ChainClient) error) error {
+	return endpoint.do(ctx, func(client ChainClient) error {
+		start := time.Now()
+		err := fn(client)
+		fc.methods.Observe(method, time.Since(start), err)
+		return err
+	})
+}
+
+// Methods returns the per-method breakdown of the calls sent
+func (fc *FailoverClient) Methods() *RPCMethodMetrics {
+	return fc.methods
+}
+
+// Stats returns per-endpoint metrics in priority order
+func (fc *FailoverClient) Stats() []EndpointStats {
+	stats := make([]EndpointStats, 0, len(fc.endpoints))
+	for _, endpoint := range fc.endpoints {
+		requests := endpoint.requests.Load()
+		errCount := endpoint.errors.Load()
+		stat := EndpointStats{
+			Endpoint: endpoint.name,
+			Healthy:  endpoint.healthy.Load(),
+			Requests: requests,
+			Errors:   errCount,
+			InUse:    endpoint.inUse.Load(),
+		}
+		if requests > 0 {
+			stat.ErrorRate = float64(errCount) / float64(requests)
+			stat.AvgLatencyMs = float64(endpoint.latencyNs.Load()) / float64(requests) / 1e6
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// WritePrometheus exposes the per-endpoint metrics
+func (fc *FailoverClient) WritePrometheus(pw *PromWriter) {
+	stats := fc.Stats()
+	labels := func(stat EndpointStats) map[string]string {
+		return map[string]string{"endpoint": stat.Endpoint}
+	}
+
+	for _, stat := range stats {
+		up := 0.0
+		if stat.Healthy {
+			up = 1
+		}
+		pw.Gauge("kaia_rpc_endpoint_up", "Whether the RPC endpoint is considered healthy.", up, labels(stat))
+	}
+	for _, stat := range stats {
+		pw.Counter("kaia_rpc_requests_total", "RPC requests sent per endpoint.", float64(stat.Requests), labels(stat))
+	}
+	for _, stat := range stats {
+		pw.Counter("kaia_rpc_errors_total", "RPC requests that failed per endpoint.", float64(stat.Errors), labels(stat))
+	}
+	for _, stat := range stats {
+		pw.Gauge("kaia_rpc_latency_avg_ms", "Average RPC latency per endpoint.", stat.AvgLatencyMs, labels(stat))
+	}
+	for _, stat := range stats {
+		pw.Gauge("kaia_rpc_in_use", "In-flight RPC requests per endpoint.", float64(stat.InUse), labels(stat))
+	}
+	fc.methods.WritePrometheus(pw)
+}
+
+// Close closes every underlying client
+func (fc *FailoverClient) Close() {
+	for _, endpoint := range fc.endpoints {
+		endpoint.client.Close()
+	}
+}
+
+// BlockNumber returns the most recent block number
+func (fc *FailoverClient) BlockNumber(ctx context.Context) (uint64, error) {
+	var number uint64
+	err := fc.call(ctx, rpcCall{method: "eth_blockNumber"}, func(client ChainClient) (err error) {
+		number, err = client.BlockNumber(ctx)
+		return err
+	})
+	return number, err
+}
+
+// BlockByNumber returns a block, or the latest block when number is nil
+func (fc *FailoverClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	var block *types.Block
+	err := fc.call(ctx, rpcCall{method: "eth_getBlockByNumber", params: []interface{}{rpcBlockParam(number), true}, size: func() int { return int(block.Size()) }}, func(client ChainClient) (err error) {
+		block, err = client.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
+// HeaderByNumber returns a block header, or the latest header when number is nil
+func (fc *FailoverClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var header *types.Header
+	err := fc.call(ctx, rpcCall{method: "eth_getBlockByNumber", params: []interface{}{rpcBlockParam(number), false}}, func(client ChainClient) (err error) {
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+// HeaderByHash returns the header of the block with the hash
+func (fc *FailoverClient) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	var header *types.Header
+	err := fc.call(ctx, rpcCall{method: "eth_getBlockByHash", params: []interface{}{hash, false}}, func(client ChainClient) (err error) {
+		header, err = client.HeaderByHash(ctx, hash)
+		return err
+	})
+	return header, err
+}
+
+// TransactionByHash returns a transaction and whether it is still pending
+func (fc *FailoverClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	var tx *types.Transaction
+	var isPending bool
+	err := fc.call(ctx, rpcCall{method: "eth_getTransactionByHash", params: []interface{}{hash}}, func(client ChainClient) (err error) {
+		tx, isPending, err = client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+// TransactionReceipt returns the receipt of a mined transaction
+func (fc *FailoverClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	var receipt *types.Receipt
+	err := fc.call(ctx, rpcCall{method: "eth_getTransactionReceipt", params: []interface{}{txHash}}, func(client ChainClient) (err error) {
+		receipt, err = client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
+
+// TraceTransaction traces a mined transaction with the callTracer on the
+// first endpoint that serves the debug API. Most public nodes don't, so
+// endpoints failing the trace are skipped without being marked unhealthy.
+func (fc *FailoverClient) TraceTransaction(ctx context.Context, hash common.Hash) (*CallTrace, error) {
+	lastErr := errors.New("no endpoint serves debug_traceTransaction")
+	for _, endpoint := range fc.candidates() {
+		raw, ok := endpoint.client.(interface{ Client() *rpc.Client })
+		if !ok {
+			continue
+		}
+
+		var trace CallTrace
+		method := rpcCall{method: "debug_traceTransaction", params: []interface{}{hash}}
+		err := fc.send(ctx, endpoint, method, func(ChainClient) error {
+			return raw.Client().CallContext(ctx, &trace, "debug_traceTransaction", hash, map[string]string{"tracer": "callTracer"})
+		})
+		if err == nil {
+			return &trace, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to trace transaction: %w", lastErr)
+}
+
+// CallContext calls a JSON-RPC method by name on the first endpoint that
+// serves it, for methods outside ChainClient such as txpool_status.
+// Endpoints failing the call aren't marked unhealthy, as nodes commonly
+// leave these namespaces off.
+func (fc *FailoverClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	lastErr := fmt.Errorf("%w: no endpoint serves raw JSON-RPC", ErrRPCUnsupported)
+	for _, endpoint := range fc.candidates() {
+		raw, ok := endpoint.client.(interface{ Client() *rpc.Client })
+		if !ok {
+			continue
+		}
+
+		call := rpcCall{method: method, params: args, size: func() int { return jsonSize(result) }}
+		err := fc.send(ctx, endpoint, call, func(ChainClient) error {
+			return raw.Client().CallContext(ctx, result, method, args...)
+		})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// SubscribeFullPendingTransactions streams the mempool's transactions from
+// the first endpoint that serves the subscription. HTTP endpoints and nodes
+// with the method off can't, and aren't marked unhealthy for it.
+func (fc *FailoverClient) SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error) {
+	lastErr := fmt.Errorf("%w: no endpoint serves pending transaction subscriptions", ErrRPCUnsupported)
+	for _, endpoint := range fc.candidates() {
+		raw, ok := endpoint.client.(interface{ Client() *rpc.Client })
+		if !ok {
+			continue
+		}
+
+		sub, err := gethclient.New(raw.Client()).SubscribeFullPendingTransactions(ctx, ch)
+		if err == nil {
+			return sub, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// txSender is the part of an endpoint client transactions are sent through
+type txSender interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// sendCall runs fn against the endpoints that can send transactions until
+// one succeeds. Resending a signed transaction elsewhere is safe, as it
+// keeps its hash.
+func (fc *FailoverClient) sendCall(ctx context.Context, method rpcCall, fn func(txSender) error) error {
+	lastErr := errors.New("no endpoint can send transactions")
+	for _, endpoint := range fc.candidates() {
+		sender, ok := endpoint.client.(txSender)
+		if !ok {
+			continue
+		}
+		err := fc.send(ctx, endpoint, method, func(ChainClient) error { return fn(sender) })
+		if err == nil {
+			fc.setHealthy(endpoint, true)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// PendingNonceAt returns the next nonce of an account, counting pending
+// transactions
+func (fc *FailoverClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	var nonce uint64
+	err := fc.sendCall(ctx, rpcCall{method: "eth_getTransactionCount", params: []interface{}{account, "pending"}}, func(sender txSender) (err error) {
+		nonce, err = sender.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+// EstimateGas estimates the gas a call needs
+func (fc *FailoverClient) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	var gas uint64
+	err := fc.sendCall(ctx, rpcCall{method: "eth_estimateGas", params: []interface{}{callMsgParam(msg)}}, func(sender txSender) (err error) {
+		gas, err = sender.EstimateGas(ctx, msg)
+		return err
+	})
+	return gas, err
+}
+
+// SendTransaction broadcasts a signed transaction
+func (fc *FailoverClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return fc.sendCall(ctx, rpcCall{method: "eth_sendRawTransaction", params: []interface{}{tx.Hash()}}, func(sender txSender) error {
+		return sender.SendTransaction(ctx, tx)
+	})
+}
+
+// BalanceAt returns the wei balance of an account
+func (fc *FailoverClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	var balance *big.Int
+	err := fc.call(ctx, rpcCall{method: "eth_getBalance", params: []interface{}{account, rpcBlockParam(blockNumber)}}, func(client ChainClient) (err error) {
+		balance, err = client.BalanceAt(ctx, account, blockNumber)
+		return err
+	})
+	return balance, err
+}
+
+// CodeAt returns the contract code of an account
+func (fc *FailoverClient) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	var code []byte
+	err := fc.call(ctx, rpcCall{method: "eth_getCode", params: []interface{}{account, rpcBlockParam(blockNumber)}, size: func() int { return len(code) }}, func(client ChainClient) (err error) {
+		code, err = client.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+// CallContract executes a read-only contract call
+func (fc *FailoverClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	var result []byte
+	err := fc.call(ctx, rpcCall{method: "eth_call", params: []interface{}{callMsgParam(msg), rpcBlockParam(blockNumber)}, size: func() int { return len(result) }}, func(client ChainClient) (err error) {
+		result, err = client.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+// SuggestGasPrice returns the node's suggested gas price
+func (fc *FailoverClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var price *big.Int
+	err := fc.call(ctx, rpcCall{method: "eth_gasPrice"}, func(client ChainClient) (err error) {
+		price, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+// NetworkID returns the network ID
+func (fc *FailoverClient) NetworkID(ctx context.Context) (*big.Int, error) {
+	var id *big.Int
+	err := fc.call(ctx, rpcCall{method: "net_version"}, func(client ChainClient) (err error) {
+		id, err = client.NetworkID(ctx)
+		return err
+	})
+	return id, err
+}
+
+// ChainID returns the chain ID
+func (fc *FailoverClient) ChainID(ctx context.Context) (*big.Int, error) {
+	var id *big.Int
+	err := fc.call(ctx, rpcCall{method: "eth_chainId"}, func(client ChainClient) (err error) {
+		id, err = client.ChainID(ctx)
+		return err
+	})
+	return id, err
+}
+
+// SyncProgress returns the sync status of the node, nil when in sync
+func (fc *FailoverClient) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	var progress *ethereum.SyncProgress
+	err := fc.call(ctx, rpcCall{method: "eth_syncing"}, func(client ChainClient) (err error) {
+		progress, err = client.SyncProgress(ctx)
+		return err
+	})
+	return progress, err
+}
+
+// FilterLogs returns the logs matching the query
+func (fc *FailoverClient) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	err := fc.call(ctx, rpcCall{method: "eth_getLogs", params: []interface{}{filterQueryParam(query)}, size: func() int { return logsSize(logs) }}, func(client ChainClient) (err error) {
+		logs, err = client.FilterLogs(ctx, query)
+		return err
+	})
+	return logs, err
+}
+
+// SubscribeFilterLogs subscribes to matching logs on the first endpoint that
+// accepts the subscription. Subscriptions are long-lived, so they don't count
+// against the endpoint's concurrency limit; callers resubscribe when one fails.
+func (fc *FailoverClient) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	var lastErr error
+	for _, endpoint := range fc.candidates() {
+		sub, err := endpoint.client.SubscribeFilterLogs(ctx, query, ch)
+		if err == nil {
+			return sub, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("no RPC endpoint accepted the log subscription: %w", lastErr)
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Sources a state read at a block can be served from
+const (
+	HistorySourcePrimary = "primary"
+	HistorySourceArchive = "archive"
+)
+
+// HistoryUnavailableError is returned for reads of a block whose state
+// neither the primary node nor the archive keeps
+type HistoryUnavailableError struct {
+	Block *big.Int
+	// EarliestBlock is the oldest block whose state a source keeps
+	EarliestBlock uint64
+}
+
+func (e *HistoryUnavailableError) Error() string {
+	return fmt.Sprintf("state of block %s is unavailable, the earliest block with state is %d", e.Block, e.EarliestBlock)
+}
+
+// Unwrap makes the error a pruned state error, for callers that don't route
+func (e *HistoryUnavailableError) Unwrap() error {
+	return ErrStatePruned
+}
+
+// historySource is a node state reads can go to. earliest is the oldest
+// block it is known to keep state for: found by probing at startup, and
+// raised whenever it answers that a block's state was pruned.
+type historySource struct {
+	name     string
+	client   ChainClient
+	earliest atomic.Uint64
+}
+
+// serves reports whether the source may keep the state of the block
+func (s *historySource) serves(number *big.Int) bool {
+	return s != nil && (number == nil || !number.IsUint64() || number.Uint64() >= s.earliest.Load())
+}
+
+// raise records that the source keeps no state before the block
+func (s *historySource) raise(earliest uint64) {
+	for {
+		seen := s.earliest.Load()
+		if earliest <= seen || s.earliest.CompareAndSwap(seen, earliest) {
+			return
+		}
+	}
+}
+
+// HistoryRouter sends state reads at past blocks to a node that still keeps
+// their state. Reads of recent blocks stay on the primary node; older ones go
+// to the archive node, when one is configured.
+type HistoryRouter struct {
+	primary *historySource
+	archive *historySource
+	logger  *log.Logger
+}
+
+// NewHistoryRouter creates a router reading everything from the primary node
+// until an archive is set
+func NewHistoryRouter(primary ChainClient) *HistoryRouter {
+	return &HistoryRouter{
+		primary: &historySource{name: HistorySourcePrimary, client: primary},
+		logger:  log.New(log.Writer(), "[History] ", log.LstdFlags),
+	}
+}
+
+// SetArchive sends reads of blocks the primary node pruned to the archive
+func (r *HistoryRouter) SetArchive(archive ChainClient) {
+	r.archive = &historySource{name: HistorySourceArchive, client: archive}
+}
+
+// Detect probes how far back each source keeps state. A source that can't
+// be probed is assumed to keep everything until a read says otherwise.
+func (r *HistoryRouter) Detect(ctx context.Context) error {
+	var errs []error
+	for _, source := range []*historySource{r.primary, r.archive} {
+		if source == nil {
+			continue
+		}
+		earliest, err := earliestState(ctx, source.client)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to probe %s node history: %w", source.name, err))
+			continue
+		}
+		source.earliest.Store(earliest)
+		r.logger.Printf("The %s node keeps state from block %d", source.name, earliest)
+	}
+	if r.archive == nil && r.primary.earliest.Load() > 0 {
+		r.logger.Printf("No archive node is configured; reads before block %d will be refused", r.primary.earliest.Load())
+	}
+	return errors.Join(errs...)
+}
+
+// earliestState finds the oldest block whose state the client keeps. Block 1
+// is probed first, since nodes keep the genesis state even when pruned; the
+// boundary is then searched for between it and the head.
+func earliestState(ctx context.Context, client ChainClient) (uint64, error) {
+	head, err := client.BlockNumber(ctx)
+	if err != nil || head <= 1 {
+		return 0, err
+	}
+	if ok, err := hasState(ctx, client, 1); err != nil || ok {
+		return 0, err
+	}
+	return searchState(ctx, client, 1, head)
+}
+
+// earliestStateAfter finds the oldest block whose state the client keeps,
+// once it answered that the state of the block was pruned
+func earliestStateAfter(ctx context.Context, client ChainClient, pruned uint64) (uint64, error) {
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if pruned >= head {
+		return 0, fmt.Errorf("no state at the head block %d", head)
+	}
+	return searchState(ctx, client, pruned, head)
+}
+
+// searchState binary searches for the oldest block with state after low,
+// which has none, up to high
+func searchState(ctx context.Context, client ChainClient, low, high uint64) (uint64, error) {
+	if ok, err := hasState(ctx, client, high); err != nil || !ok {
+		if err == nil {
+			err = fmt.Errorf("no state at the head block %d", high)
+		}
+		return 0, err
+	}
+	for high-low > 1 {
+		middle := low + (high-low)/2
+		ok, err := hasState(ctx, client, middle)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			high = middle
+		} else {
+			low = middle
+		}
+	}
+	return high, nil
+}
+
+// hasState probes whether the client keeps the state of the block. Any
+// account works, since reading a balance needs the block's state trie.
+func hasState(ctx context.Context, client ChainClient, number uint64) (bool, error) {
+	_, err := client.BalanceAt(ctx, common.Address{}, new(big.Int).SetUint64(number))
+	if IsStatePruned(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Read runs the read against the first source keeping the state of the
+// block, the latest when nil, and returns the source it was served from.
+// The latest block is always read from the primary node. When no source
+// keeps the block's state it returns a *HistoryUnavailableError.
+func (r *HistoryRouter) Read(ctx context.Context, number *big.Int, read func(client ChainClient) error) (string, error) {
+	if number == nil {
+		return r.primary.name, read(r.primary.client)
+	}
+	for _, source := range []*historySource{r.primary, r.archive} {
+		if !source.serves(number) {
+			continue
+		}
+		err := read(source.client)
+		if !IsStatePruned(err) {
+			return source.name, err
+		}
+		earliest, err := earliestStateAfter(ctx, source.client, number.Uint64())
+		if err != nil {
+			earliest = number.Uint64() + 1
+		}
+		source.raise(earliest)
+		r.logger.Printf("The %s node pruned the state of block %s; it keeps state from block %d", source.name, number, source.earliest.Load())
+	}
+	return "", &HistoryUnavailableError{Block: number, EarliestBlock: r.Earliest()}
+}
+
+// Earliest returns the oldest block whose state a source keeps
+func (r *HistoryRouter) Earliest() uint64 {
+	earliest := r.primary.earliest.Load()
+	if r.archive != nil {
+		earliest = min(earliest, r.archive.earliest.Load())
+	}
+	return earliest
+}
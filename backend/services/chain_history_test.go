@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyNode is a node at head keeping state from keepFrom on, except for
+// the genesis state, which pruned nodes keep too
+type historyNode struct {
+	ChainClient
+
+	head     uint64
+	keepFrom uint64
+	down     bool
+	reads    atomic.Int64
+}
+
+func (hn *historyNode) BlockNumber(ctx context.Context) (uint64, error) {
+	if hn.down {
+		return 0, errors.New("connection refused")
+	}
+	return hn.head, nil
+}
+
+func (hn *historyNode) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	hn.reads.Add(1)
+	if hn.down {
+		return nil, errors.New("connection refused")
+	}
+	if number := blockNumber.Uint64(); number != 0 && number < hn.keepFrom {
+		return nil, errors.New("missing trie node 5f3e (path ) state 0x5f3e is not available")
+	}
+	return new(big.Int).Set(blockNumber), nil
+}
+
+// readBalance reads the balance at the block through the router
+func readBalance(router *HistoryRouter, number uint64) (string, *big.Int, error) {
+	var balance *big.Int
+	source, err := router.Read(context.Background(), new(big.Int).SetUint64(number), func(client ChainClient) error {
+		var err error
+		balance, err = client.BalanceAt(context.Background(), common.Address{}, new(big.Int).SetUint64(number))
+		return err
+	})
+	return source, balance, err
+}
+
+func TestHistoryRouterDetectsPrunedState(t *testing.T) {
+	primary := &historyNode{head: 100_000, keepFrom: 99_872}
+	archive := &historyNode{head: 100_000, keepFrom: 5_000}
+	router := NewHistoryRouter(primary)
+	router.SetArchive(archive)
+	require.NoError(t, router.Detect(context.Background()))
+
+	assert.Equal(t, uint64(99_872), router.primary.earliest.Load())
+	assert.Equal(t, uint64(5_000), router.archive.earliest.Load())
+	assert.Equal(t, uint64(5_000), router.Earliest())
+
+	// A full archive keeps every block
+	full := NewHistoryRouter(&historyNode{head: 100_000})
+	require.NoError(t, full.Detect(context.Background()))
+	assert.Zero(t, full.Earliest())
+
+	// An endpoint that can't be probed keeps serving every read
+	down := NewHistoryRouter(&historyNode{head: 100_000, down: true})
+	assert.Error(t, down.Detect(context.Background()))
+	assert.Zero(t, down.Earliest())
+}
+
+func TestHistoryRouterRoutesByBlock(t *testing.T) {
+	primary := &historyNode{head: 100_000, keepFrom: 99_872}
+	archive := &historyNode{head: 100_000, keepFrom: 5_000}
+	router := NewHistoryRouter(primary)
+	router.SetArchive(archive)
+	require.NoError(t, router.Detect(context.Background()))
+	primary.reads.Store(0)
+	archive.reads.Store(0)
+
+	for _, tc := range []struct {
+		block  uint64
+		source string
+	}{
+		{100_000, HistorySourcePrimary},
+		{99_872, HistorySourcePrimary},
+		{99_871, HistorySourceArchive},
+		{5_000, HistorySourceArchive},
+	} {
+		source, balance, err := readBalance(router, tc.block)
+		require.NoError(t, err, "block %d", tc.block)
+		assert.Equal(t, tc.source, source, "block %d", tc.block)
+		assert.Equal(t, int64(tc.block), balance.Int64())
+	}
+	assert.Equal(t, int64(2), primary.reads.Load(), "old blocks skip the primary node")
+	assert.Equal(t, int64(2), archive.reads.Load())
+
+	// Head-of-chain reads stay on the primary node
+	source, err := router.Read(context.Background(), nil, func(client ChainClient) error {
+		assert.Same(t, primary, client)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, HistorySourcePrimary, source)
+
+	// Before the archive's earliest block neither node is asked
+	_, _, err = readBalance(router, 4_999)
+	var unavailable *HistoryUnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	assert.Equal(t, uint64(5_000), unavailable.EarliestBlock)
+	assert.Equal(t, int64(4_999), unavailable.Block.Int64())
+	assert.True(t, IsStatePruned(err))
+	assert.Equal(t, int64(2), archive.reads.Load())
+}
+
+func TestHistoryRouterLearnsPrunedState(t *testing.T) {
+	// Without probing, a pruned answer falls back to the archive and moves
+	// the primary node's boundary to where its state starts
+	primary := &historyNode{head: 1_000, keepFrom: 900}
+	archive := &historyNode{head: 1_000}
+	router := NewHistoryRouter(primary)
+	router.SetArchive(archive)
+
+	source, balance, err := readBalance(router, 12)
+	require.NoError(t, err)
+	assert.Equal(t, HistorySourceArchive, source)
+	assert.Equal(t, int64(12), balance.Int64())
+	assert.Equal(t, uint64(900), router.primary.earliest.Load())
+
+	source, _, err = readBalance(router, 899)
+	require.NoError(t, err)
+	assert.Equal(t, HistorySourceArchive, source)
+
+	// Without an archive, the error names where the primary node's state starts
+	alone := NewHistoryRouter(&historyNode{head: 1_000, keepFrom: 900})
+	_, _, err = readBalance(alone, 899)
+	var unavailable *HistoryUnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	assert.Equal(t, uint64(900), unavailable.EarliestBlock)
+	source, _, err = readBalance(alone, 900)
+	require.NoError(t, err)
+	assert.Equal(t, HistorySourcePrimary, source)
+
+	// Other failures aren't retried on the archive
+	primary.down = true
+	archive.reads.Store(0)
+	_, _, err = readBalance(router, 950)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrStatePruned)
+	assert.Zero(t, archive.reads.Load())
+}
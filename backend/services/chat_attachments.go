@@ -0,0 +1,121 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// AttachmentChart is the attachment type of a chart series
+const AttachmentChart = "chart"
+
+const (
+	// DefaultChartMaxPoints caps the points of each chart attachment
+	DefaultChartMaxPoints = 100
+	// MinChartMaxPoints is the smallest point cap that still keeps a chart's shape
+	MinChartMaxPoints = 3
+
+	chatPriceChartWindow = 7 * 24 * time.Hour
+	chatGasChartWindow   = 24 * time.Hour
+	chatYieldChartWindow = "7d"
+	chatYieldCharts      = 3
+)
+
+// ChartSeries is a series for the frontend to draw. Labels are the RFC 3339
+// timestamps of the points.
+type ChartSeries struct {
+	Name   string    `json:"name"`
+	Unit   string    `json:"unit"`
+	Labels []string  `json:"labels"`
+	Points []float64 `json:"points"`
+}
+
+// ChatAttachment is structured content sent alongside a chat answer
+type ChatAttachment struct {
+	Type  string       `json:"type"`
+	Title string       `json:"title"`
+	Chart *ChartSeries `json:"chart,omitempty"`
+}
+
+// SetMaxChartPoints sets the cap on points per chart attachment; charts with
+// more points are downsampled
+func (ce *ChatEngine) SetMaxChartPoints(maxPoints int) {
+	if maxPoints >= MinChartMaxPoints {
+		ce.maxChartPoints = maxPoints
+	}
+}
+
+// chartAttachment builds a chart of the points, downsampled to the engine's
+// cap. Returns false when there are too few points to draw a line.
+func (ce *ChatEngine) chartAttachment(title, name, unit string, points []SeriesPoint) (ChatAttachment, bool) {
+	if len(points) < 2 {
+		return ChatAttachment{}, false
+	}
+
+	sampled := Downsample(points, ce.maxChartPoints)
+	chart := &ChartSeries{
+		Name:   name,
+		Unit:   unit,
+		Labels: make([]string, len(sampled)),
+		Points: make([]float64, len(sampled)),
+	}
+	for i, point := range sampled {
+		chart.Labels[i] = point.Timestamp.UTC().Format(time.RFC3339)
+		chart.Points[i] = point.Value
+	}
+	return ChatAttachment{Type: AttachmentChart, Title: title, Chart: chart}, true
+}
+
+// priceCharts charts the recorded prices of the symbols over the last week in
+// the display currency
+func (ce *ChatEngine) priceCharts(symbols []string, money ConversionRate, now time.Time) []ChatAttachment {
+	var attachments []ChatAttachment
+	for _, symbol := range symbols {
+		points := ce.dataCollector.Series().Range(PriceMetric(symbol), now.Add(-chatPriceChartWindow))
+		for i := range points {
+			points[i].Value = money.Convert(points[i].Value)
+		}
+		if attachment, ok := ce.chartAttachment(fmt.Sprintf("%s price (7d)", symbol), symbol, money.Currency, points); ok {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// gasChart charts the collected gas prices over the last day
+func (ce *ChatEngine) gasChart(now time.Time) []ChatAttachment {
+	points := ce.dataCollector.Series().Range(MetricGasPrice, now.Add(-chatGasChartWindow))
+	if attachment, ok := ce.chartAttachment("Gas price (24h)", MetricGasPrice, "gwei", points); ok {
+		return []ChatAttachment{attachment}
+	}
+	return nil
+}
+
+// performanceChart charts a portfolio's daily values
+func (ce *ChatEngine) performanceChart(perf *PortfolioPerformance) []ChatAttachment {
+	points := make([]SeriesPoint, len(perf.Series))
+	for i, point := range perf.Series {
+		points[i] = SeriesPoint{Timestamp: point.Timestamp, Value: point.ValueUSD}
+	}
+	if attachment, ok := ce.chartAttachment("Portfolio value", perf.Address, "USD", points); ok {
+		return []ChatAttachment{attachment}
+	}
+	return nil
+}
+
+// yieldCharts charts the APY history of the top opportunities
+func (ce *ChatEngine) yieldCharts(opportunities []YieldOpportunity) []ChatAttachment {
+	var attachments []ChatAttachment
+	for i, opportunity := range opportunities {
+		if i >= chatYieldCharts {
+			break
+		}
+		if opportunity.History == nil {
+			continue
+		}
+		title := fmt.Sprintf("%s %s APY (%s)", opportunity.Protocol, opportunity.AssetPair, opportunity.History.Window)
+		if attachment, ok := ce.chartAttachment(title, opportunity.AssetPair, "%", opportunity.History.APY); ok {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chartAttachmentTitled returns the attachment with the title
+func chartAttachmentTitled(t *testing.T, attachments []ChatAttachment, title string) *ChartSeries {
+	for _, attachment := range attachments {
+		if attachment.Title == title {
+			require.Equal(t, AttachmentChart, attachment.Type)
+			require.NotNil(t, attachment.Chart)
+			return attachment.Chart
+		}
+	}
+	t.Fatalf("no attachment titled %q in %v", title, attachments)
+	return nil
+}
+
+func TestChatPriceChartIsDownsampled(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.SetMaxChartPoints(50)
+	series := engine.dataCollector.Series()
+
+	now := time.Now()
+	// Older than the chart window
+	series.Record(PriceMetric("ETH"), SeriesPoint{Timestamp: now.Add(-8 * 24 * time.Hour), Value: 1})
+	for i := 0; i < 500; i++ {
+		at := now.Add(-6 * 24 * time.Hour).Add(time.Duration(i) * 15 * time.Minute)
+		series.Record(PriceMetric("ETH"), SeriesPoint{Timestamp: at, Value: 3000 + float64(i%40)})
+	}
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "anonymous", Message: "What is the market price of ETH?"})
+	require.NoError(t, err)
+	require.Equal(t, "market_data", response.Type)
+
+	chart := chartAttachmentTitled(t, response.Attachments, "ETH price (7d)")
+	assert.Equal(t, "ETH", chart.Name)
+	assert.Equal(t, "USD", chart.Unit)
+	require.Len(t, chart.Points, 50)
+	require.Len(t, chart.Labels, 50)
+
+	expected := Downsample(series.Range(PriceMetric("ETH"), now.Add(-7*24*time.Hour)), 50)
+	for i, point := range expected {
+		assert.Equal(t, point.Value, chart.Points[i])
+		assert.Equal(t, point.Timestamp.UTC().Format(time.RFC3339), chart.Labels[i])
+	}
+	// The price just collected for the answer ends the series
+	assert.Equal(t, 3200.0, chart.Points[49])
+
+	// USDC only has the point collected for the answer, which is no line
+	for _, attachment := range response.Attachments {
+		assert.NotEqual(t, "USDC price (7d)", attachment.Title)
+	}
+
+	// The REST and WebSocket envelopes carry attachments as they are
+	encoded, err := json.Marshal(response)
+	require.NoError(t, err)
+	var decoded ChatResponse
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, response.Attachments, decoded.Attachments)
+}
+
+func TestChatGasAndYieldCharts(t *testing.T) {
+	chain := &headerChain{headers: map[uint64]*types.Header{1: {Number: big.NewInt(1), GasUsed: 500_000, GasLimit: congestionGasLimit}}, head: 1}
+	analyticsEngine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { analyticsEngine.Close() })
+	engine := NewChatEngine(chain, analyticsEngine, NewDataCollector(chain))
+
+	now := time.Now()
+	gas := []float64{25, 31, 28}
+	for i, value := range gas {
+		engine.dataCollector.Series().Record(MetricGasPrice, SeriesPoint{Timestamp: now.Add(time.Duration(i-3) * time.Hour), Value: value})
+	}
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "anonymous", Message: "What are gas fees like?"})
+	require.NoError(t, err)
+	chart := chartAttachmentTitled(t, response.Attachments, "Gas price (24h)")
+	assert.Equal(t, "gwei", chart.Unit)
+	assert.Equal(t, gas, chart.Points)
+
+	// A scan two days ago gives each pool a line from it to the scan for the answer
+	analyticsEngine.yields.Record([]YieldOpportunity{{Protocol: "Aave V3", AssetPair: "USDC/ETH", APY: 7.5, TVL: 2_000_000}}, now.Add(-48*time.Hour))
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m2", UserID: "anonymous", Message: "What are the best yield farming options?"})
+	require.NoError(t, err)
+	chart = chartAttachmentTitled(t, response.Attachments, "Aave V3 USDC/ETH APY (7d)")
+	assert.Equal(t, "%", chart.Unit)
+	assert.Equal(t, []float64{7.5, 8.2}, chart.Points)
+	assert.Len(t, response.Attachments, 1, "pools with a single scan have no chart")
+}
+
+func TestPerformanceChartAndPointCap(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.SetMaxChartPoints(2)
+	assert.Equal(t, DefaultChartMaxPoints, engine.maxChartPoints, "caps below 3 are ignored")
+
+	start := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	perf := &PortfolioPerformance{Address: "0xabc"}
+	for day := 0; day < 30; day++ {
+		perf.Series = append(perf.Series, PerformancePoint{Timestamp: start.AddDate(0, 0, day), ValueUSD: 1000 + float64(day)})
+	}
+
+	engine.SetMaxChartPoints(10)
+	attachments := engine.performanceChart(perf)
+	require.Len(t, attachments, 1)
+	chart := attachments[0].Chart
+	assert.Equal(t, "USD", chart.Unit)
+	require.Len(t, chart.Points, 10)
+	assert.Equal(t, 1000.0, chart.Points[0])
+	assert.Equal(t, 1029.0, chart.Points[9])
+	assert.Equal(t, "2025-04-30T00:00:00Z", chart.Labels[9])
+
+	assert.Empty(t, engine.performanceChart(&PortfolioPerformance{Series: perf.Series[:1]}))
+}
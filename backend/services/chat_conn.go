@@ -0,0 +1,237 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// ChatPingInterval is how often chat connections are pinged
+	ChatPingInterval = 30 * time.Second
+	// ChatMaxMissedPongs is how many pings in a row a connection can leave
+	// unanswered before it is reaped
+	ChatMaxMissedPongs = 2
+	// ChatPongWait is the read deadline each pong extends: long enough for
+	// the missed pings a live connection is allowed
+	ChatPongWait = ChatPingInterval * (ChatMaxMissedPongs + 1)
+
+	// chatWriteWait bounds a single frame write
+	chatWriteWait = 10 * time.Second
+	// chatSendBuffer is how many frames can wait for a connection's writer;
+	// a client that falls further behind is disconnected
+	chatSendBuffer = 256
+
+	// DefaultChatMaxConnections is the default ceiling on open chat
+	// connections across users
+	DefaultChatMaxConnections = 10000
+	// ChatMaxConnectionsPerUser is how many connections, such as browser
+	// tabs, a user can hold open at once
+	ChatMaxConnectionsPerUser = 5
+	// ChatAnonymousUser is the user of chat messages and connections sent
+	// without a signed-in session
+	ChatAnonymousUser = "anonymous"
+)
+
+var (
+	// ErrChatConnectionClosed is returned when writing to a closed connection
+	ErrChatConnectionClosed = errors.New("chat connection is closed")
+	// ErrChatSendBufferFull is returned when a client reads too slowly to
+	// keep up with its frames
+	ErrChatSendBufferFull = errors.New("chat connection send buffer is full")
+)
+
+// ChatConnection is a registered chat WebSocket. One writer goroutine owns
+// every write to the socket: frames are queued to it, and it pings the
+// client every ping interval. Pongs extend the read deadline; a connection
+// that misses too many in a row is reaped. The handler goroutine keeps
+// reading with ReadJSON.
+type ChatConnection struct {
+	conn   *websocket.Conn
+	userID string
+	// session is the delivery session frames are sequenced in: the user's,
+	// shared by their connections, or the connection's own when anonymous
+	session   string
+	engine    *ChatEngine
+	send      chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	// missed counts the pings sent since the last pong
+	missed atomic.Int32
+	// lastActive is when the client last sent a frame, in Unix nanoseconds,
+	// for picking connections to evict
+	lastActive atomic.Int64
+}
+
+// ChatConnectionCounts are the open chat connections by whether they were
+// opened with a user ID
+type ChatConnectionCounts struct {
+	Users      int `json:"users"`
+	Identified int `json:"identified"`
+	Anonymous  int `json:"anonymous"`
+}
+
+// newChatConnection sets up the connection's heartbeat. Reading must not
+// have started, as the read deadline and pong handler belong to the reader.
+func newChatConnection(engine *ChatEngine, userID string, conn *websocket.Conn) *ChatConnection {
+	c := &ChatConnection{
+		conn:    conn,
+		userID:  userID,
+		session: userID,
+		engine:  engine,
+		send:    make(chan []byte, chatSendBuffer),
+		closed:  make(chan struct{}),
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	pongWait := engine.pingInterval * time.Duration(engine.maxMissedPongs+1)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		c.missed.Store(0)
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	return c
+}
+
+// Session returns the delivery session of the connection, which frames
+// written on it are sequenced, acked and resumed in
+func (c *ChatConnection) Session() string {
+	return c.session
+}
+
+// ReadJSON reads the next frame from the client
+func (c *ChatConnection) ReadJSON(v interface{}) error {
+	if err := c.conn.ReadJSON(v); err != nil {
+		return err
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	return nil
+}
+
+// WriteJSON queues a frame for the writer
+func (c *ChatConnection) WriteJSON(v interface{}) error {
+	frame, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.queue(frame)
+}
+
+// WriteControl sends a control frame. Control frames may be written
+// alongside the writer goroutine.
+func (c *ChatConnection) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return c.conn.WriteControl(messageType, data, deadline)
+}
+
+// queue hands an encoded frame to the writer. A client too slow to take its
+// frames is disconnected rather than blocking the sender.
+func (c *ChatConnection) queue(frame []byte) error {
+	select {
+	case <-c.closed:
+		return ErrChatConnectionClosed
+	default:
+	}
+	select {
+	case c.send <- frame:
+		return nil
+	case <-c.closed:
+		return ErrChatConnectionClosed
+	default:
+		go c.Close()
+		return ErrChatSendBufferFull
+	}
+}
+
+// writeLoop is the connection's only writer
+func (c *ChatConnection) writeLoop() {
+	ticker := time.NewTicker(c.engine.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				c.engine.logger.Printf("Failed to send message to user %s: %v", c.userID, err)
+				c.Close()
+				return
+			}
+		case <-ticker.C:
+			if c.missed.Load() >= int32(c.engine.maxMissedPongs) {
+				c.reap()
+				return
+			}
+			c.missed.Add(1)
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(chatWriteWait)); err != nil {
+				c.engine.logger.Printf("Failed to ping user %s: %v", c.userID, err)
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// reap closes a connection whose client stopped answering pings
+func (c *ChatConnection) reap() {
+	c.engine.logger.Printf("Reaping chat connection of %s after %d unanswered pings", c.userID, c.missed.Load())
+	c.engine.metrics.RecordReapedConnection()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "missed heartbeats"), time.Now().Add(time.Second))
+	c.Close()
+}
+
+// evict closes a connection the engine already forgot to make room for
+// another, telling the client why
+func (c *ChatConnection) evict(code int, reason string) {
+	c.engine.logger.Printf("Evicting chat connection of %s: %s", c.userID, reason)
+	c.engine.metrics.RecordEvictedConnection()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.Close()
+}
+
+// Close stops the writer, closes the socket and unregisters the connection
+func (c *ChatConnection) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+		c.engine.release(c)
+	})
+	return err
+}
+
+// leastRecentlyActive returns the connection whose client sent a frame
+// longest ago, or nil when there are none
+func leastRecentlyActive(connections map[*ChatConnection]struct{}) *ChatConnection {
+	var oldest *ChatConnection
+	for connection := range connections {
+		if oldest == nil || connection.lastActive.Load() < oldest.lastActive.Load() {
+			oldest = connection
+		}
+	}
+	return oldest
+}
+
+// ConnectionCounts returns the open connections by whether they were opened
+// with a user ID
+func (ce *ChatEngine) ConnectionCounts() ChatConnectionCounts {
+	ce.mu.RLock()
+	defer ce.mu.RUnlock()
+
+	counts := ChatConnectionCounts{
+		Users:      len(ce.connections),
+		Identified: ce.connectionCount,
+	}
+	if anonymous, ok := ce.connections[ChatAnonymousUser]; ok {
+		counts.Users--
+		counts.Anonymous = len(anonymous)
+		counts.Identified -= counts.Anonymous
+	}
+	return counts
+}
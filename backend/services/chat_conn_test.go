@@ -0,0 +1,263 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveChat accepts chat WebSockets for the user and reads from them the way
+// the API handler does, until the connection closes
+func serveChat(t *testing.T, engine *ChatEngine, userID string) (string, <-chan time.Time) {
+	upgrader := websocket.Upgrader{}
+	registered := make(chan time.Time, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		connection := engine.RegisterConnection(userID, conn)
+		defer connection.Close()
+		registered <- time.Now()
+		for {
+			var message ChatMessage
+			if err := connection.ReadJSON(&message); err != nil {
+				return
+			}
+			if err := connection.WriteJSON(&ChatResponse{MessageID: message.ID, Response: "echo"}); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), registered
+}
+
+func TestChatConnectionReapsSilentClient(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.pingInterval = 25 * time.Millisecond
+	url, registered := serveChat(t, engine, "silent")
+
+	// The client never reads, so it never answers a ping
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer client.Close()
+	connectedAt := <-registered
+
+	require.Eventually(t, func() bool {
+		return engine.metrics.Snapshot().ReapedConnections == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	// Pinged at one and two intervals, reaped at the third
+	assert.GreaterOrEqual(t, time.Since(connectedAt), 3*engine.pingInterval)
+	assert.Eventually(t, func() bool {
+		return engine.GetChatMetrics()["active_connections"] == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Error(t, engine.SendToUser("silent", &ChatResponse{Response: "gone"}))
+}
+
+func TestChatConnectionSerializesWrites(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.pingInterval = 10 * time.Millisecond
+	url, registered := serveChat(t, engine, "live")
+
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer client.Close()
+	<-registered
+
+	// Reading answers pings, so the connection stays up
+	const pushes, replies = 50, 20
+	received := make(chan ChatResponse, pushes*2+replies)
+	go func() {
+		for {
+			var frame ChatResponse
+			if err := client.ReadJSON(&frame); err != nil {
+				return
+			}
+			received <- frame
+		}
+	}()
+
+	// Pushes, broadcasts and replies are written from different goroutines
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < pushes; i++ {
+			assert.NoError(t, engine.SendToUser("live", &ChatResponse{ID: fmt.Sprintf("push_%d", i)}))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < pushes; i++ {
+			assert.NoError(t, engine.BroadcastMessage(&ChatResponse{ID: fmt.Sprintf("broadcast_%d", i)}))
+		}
+	}()
+	for i := 0; i < replies; i++ {
+		require.NoError(t, client.WriteJSON(&ChatMessage{ID: fmt.Sprintf("msg_%d", i)}))
+	}
+	wg.Wait()
+
+	for i := 0; i < pushes*2+replies; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of %d frames", i, pushes*2+replies)
+		}
+	}
+
+	time.Sleep(10 * engine.pingInterval)
+	assert.Zero(t, engine.metrics.Snapshot().ReapedConnections, "a client that answers pings isn't reaped")
+	assert.Equal(t, 1, engine.GetChatMetrics()["active_connections"])
+}
+
+// dialChat opens a client connection to a serveChat server and waits until
+// the engine has registered it
+func dialChat(t *testing.T, url string, registered <-chan time.Time) *websocket.Conn {
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	<-registered
+	return client
+}
+
+// readFrame reads the next frame a client is sent
+func readFrame(t *testing.T, client *websocket.Conn) ChatResponse {
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frame ChatResponse
+	require.NoError(t, client.ReadJSON(&frame))
+	return frame
+}
+
+// closeCode reads from a client until its connection is closed and returns
+// the close code the server sent
+func closeCode(t *testing.T, client *websocket.Conn) int {
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := client.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			require.ErrorAs(t, err, &closeErr)
+			return closeErr.Code
+		}
+	}
+}
+
+func TestChatConnectionsOfOneUserAllReceivePushes(t *testing.T) {
+	engine := newTestChatEngine(t)
+	url, registered := serveChat(t, engine, "0xuser")
+
+	first := dialChat(t, url, registered)
+	second := dialChat(t, url, registered)
+	assert.Equal(t, ChatConnectionCounts{Users: 1, Identified: 2}, engine.ConnectionCounts())
+
+	// A second tab doesn't replace the first
+	require.NoError(t, engine.SendToUser("0xUSER", &ChatResponse{ID: "push"}))
+	assert.Equal(t, "push", readFrame(t, first).ID)
+	assert.Equal(t, "push", readFrame(t, second).ID)
+	require.NoError(t, engine.BroadcastMessage(&ChatResponse{ID: "broadcast"}))
+	assert.Equal(t, "broadcast", readFrame(t, first).ID)
+	assert.Equal(t, "broadcast", readFrame(t, second).ID)
+
+	first.Close()
+	require.Eventually(t, func() bool {
+		return engine.ConnectionCounts() == ChatConnectionCounts{Users: 1, Identified: 1}
+	}, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, engine.SendToUser("0xuser", &ChatResponse{ID: "still_connected"}))
+	assert.Equal(t, "still_connected", readFrame(t, second).ID)
+
+	second.Close()
+	require.Eventually(t, func() bool {
+		return engine.ConnectionCounts() == ChatConnectionCounts{}
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, engine.GetChatMetrics()["active_connections"])
+	assert.Error(t, engine.SendToUser("0xuser", &ChatResponse{ID: "gone"}))
+}
+
+func TestChatConnectionsPerUserAreCapped(t *testing.T) {
+	engine := newTestChatEngine(t)
+	url, registered := serveChat(t, engine, "0xuser")
+
+	clients := make([]*websocket.Conn, 0, ChatMaxConnectionsPerUser+1)
+	for i := 0; i <= ChatMaxConnectionsPerUser; i++ {
+		clients = append(clients, dialChat(t, url, registered))
+	}
+
+	// The oldest connection makes room for the newest
+	assert.Equal(t, websocket.ClosePolicyViolation, closeCode(t, clients[0]))
+	assert.Equal(t, ChatConnectionCounts{Users: 1, Identified: ChatMaxConnectionsPerUser}, engine.ConnectionCounts())
+	assert.Equal(t, uint64(1), engine.MetricsSnapshot().EvictedConnections)
+}
+
+func TestAnonymousChatConnectionsAreNotCappedPerUser(t *testing.T) {
+	engine := newTestChatEngine(t)
+	url, registered := serveChat(t, engine, ChatAnonymousUser)
+
+	// Anonymous connections are different people, so none replaces another
+	for i := 0; i < 2*ChatMaxConnectionsPerUser; i++ {
+		dialChat(t, url, registered)
+	}
+	assert.Equal(t, ChatConnectionCounts{Anonymous: 2 * ChatMaxConnectionsPerUser}, engine.ConnectionCounts())
+	assert.Zero(t, engine.MetricsSnapshot().EvictedConnections)
+}
+
+func TestChatConnectionCeilingEvictsAnonymousFirst(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.SetMaxConnections(2)
+	anonymousURL, anonymousRegistered := serveChat(t, engine, ChatAnonymousUser)
+	aliceURL, aliceRegistered := serveChat(t, engine, "0xalice")
+	bobURL, bobRegistered := serveChat(t, engine, "0xbob")
+	carolURL, carolRegistered := serveChat(t, engine, "0xcarol")
+
+	alice := dialChat(t, aliceURL, aliceRegistered)
+	anonymous := dialChat(t, anonymousURL, anonymousRegistered)
+	assert.Equal(t, ChatConnectionCounts{Users: 1, Identified: 1, Anonymous: 1}, engine.ConnectionCounts())
+
+	// The anonymous connection goes first, though alice's is older
+	bob := dialChat(t, bobURL, bobRegistered)
+	assert.Equal(t, websocket.CloseTryAgainLater, closeCode(t, anonymous))
+	assert.Equal(t, ChatConnectionCounts{Users: 2, Identified: 2}, engine.ConnectionCounts())
+
+	// Without anonymous connections, the least recently active one goes,
+	// which is bob's once alice sends a message
+	require.NoError(t, alice.WriteJSON(&ChatMessage{ID: "active"}))
+	assert.Equal(t, "echo", readFrame(t, alice).Response)
+	dialChat(t, carolURL, carolRegistered)
+	assert.Equal(t, websocket.CloseTryAgainLater, closeCode(t, bob))
+	assert.Equal(t, ChatConnectionCounts{Users: 2, Identified: 2}, engine.ConnectionCounts())
+	assert.Equal(t, uint64(2), engine.MetricsSnapshot().EvictedConnections)
+}
+
+func TestAnonymousChatConnectionsHaveTheirOwnDeliverySession(t *testing.T) {
+	engine := newTestChatEngine(t)
+	url, registered := serveChat(t, engine, ChatAnonymousUser)
+	first := dialChat(t, url, registered)
+	second := dialChat(t, url, registered)
+
+	require.NoError(t, engine.BroadcastMessage(&ChatResponse{ID: "broadcast"}))
+	assert.Equal(t, uint64(1), readFrame(t, first).Seq)
+	assert.Equal(t, uint64(1), readFrame(t, second).Seq)
+
+	// A reply sequenced for one connection doesn't advance the other's
+	// sequence, which it would have to ack and resume across
+	reply := engine.Sequence(ChatAnonymousUser+"#1", &ChatResponse{ID: "reply"})
+	assert.Equal(t, uint64(2), reply.Seq)
+	require.NoError(t, engine.BroadcastMessage(&ChatResponse{ID: "broadcast"}))
+	assert.Equal(t, uint64(3), readFrame(t, first).Seq)
+	assert.Equal(t, uint64(2), readFrame(t, second).Seq)
+
+	// A closed anonymous connection can't be resumed, so its frames go
+	first.Close()
+	require.Eventually(t, func() bool {
+		return engine.ConnectionCounts() == ChatConnectionCounts{Anonymous: 1}
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Zero(t, engine.delivery.LastSeq(ChatAnonymousUser+"#1"))
+	assert.Equal(t, uint64(2), engine.delivery.LastSeq(ChatAnonymousUser+"#2"))
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ChatResumeBufferSize bounds the unacknowledged frames kept per user.
+	// Clients ack at least this often; older frames are dropped, and a
+	// resume across them fails.
+	ChatResumeBufferSize = 200
+	// ChatResumeFailedFrame tells a client its missed frames can't be
+	// replayed, so it should refresh its state instead
+	ChatResumeFailedFrame = "resume_failed"
+
+	// chatSessionTTL is how long a disconnected user's frames are kept for
+	// a resume
+	chatSessionTTL = 10 * time.Minute
+)
+
+// ErrResumeGap is returned when the frames a client missed are no longer buffered
+var ErrResumeGap = errors.New("missed frames are no longer buffered")
+
+// FrameWriter writes frames to a chat connection
+type FrameWriter interface {
+	WriteJSON(v interface{}) error
+}
+
+// deliverySession is the outbound sequence of one user. frames holds the
+// unacknowledged frames, oldest first, ending with seq.
+type deliverySession struct {
+	seq            uint64
+	frames         []*ChatResponse
+	connected      bool
+	disconnectedAt time.Time
+}
+
+// ChatDelivery numbers the frames sent to each user and keeps the
+// unacknowledged ones, so a client that reconnects can resume from the last
+// frame it saw. Sessions outlive their connection by chatSessionTTL.
+type ChatDelivery struct {
+	bufferSize int
+	now        func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*deliverySession
+}
+
+// NewChatDelivery creates a delivery keeping up to bufferSize
+// unacknowledged frames per user
+func NewChatDelivery(bufferSize int) *ChatDelivery {
+	if bufferSize <= 0 {
+		bufferSize = ChatResumeBufferSize
+	}
+	return &ChatDelivery{
+		bufferSize: bufferSize,
+		now:        utcNow,
+		sessions:   make(map[string]*deliverySession),
+	}
+}
+
+// Connect marks the user connected, dropping the sessions of users gone
+// longer than chatSessionTTL
+func (d *ChatDelivery) Connect(userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for key, session := range d.sessions {
+		if !session.connected && now.Sub(session.disconnectedAt) > chatSessionTTL {
+			delete(d.sessions, key)
+		}
+	}
+	d.session(userID, true).connected = true
+}
+
+// Disconnect marks the user disconnected; their frames are kept for a resume
+func (d *ChatDelivery) Disconnect(userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if session := d.session(userID, false); session != nil {
+		session.connected = false
+		session.disconnectedAt = d.now()
+	}
+}
+
+// Forget drops the user's session, for connections that can't be resumed
+func (d *ChatDelivery) Forget(userID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.sessions, strings.ToLower(userID))
+}
+
+// Sequence numbers a frame for the user and buffers it until acknowledged.
+// The frame is copied; the copy is what should be sent.
+func (d *ChatDelivery) Sequence(userID string, message *ChatResponse) *ChatResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.sequence(d.session(userID, true), message)
+}
+
+// Hold buffers a frame for a user who isn't connected, if they still have
+// a session to resume
+func (d *ChatDelivery) Hold(userID string, message *ChatResponse) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	session := d.session(userID, false)
+	if session == nil {
+		return false
+	}
+	d.sequence(session, message)
+	return true
+}
+
+// Ack drops the user's buffered frames up to and including seq
+func (d *ChatDelivery) Ack(userID string, seq uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if session := d.session(userID, false); session != nil {
+		session.ack(seq)
+	}
+}
+
+// Replay returns the frames the user missed after lastSeq, in order, and
+// acknowledges the ones before. It returns ErrResumeGap when some of them
+// are no longer buffered.
+func (d *ChatDelivery) Replay(userID string, lastSeq uint64) ([]*ChatResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	session := d.session(userID, false)
+	if session == nil {
+		if lastSeq == 0 {
+			return nil, nil
+		}
+		return nil, ErrResumeGap
+	}
+	if lastSeq > session.seq || lastSeq+uint64(len(session.frames)) < session.seq {
+		return nil, ErrResumeGap
+	}
+	session.ack(lastSeq)
+	return append([]*ChatResponse(nil), session.frames...), nil
+}
+
+// LastSeq returns the sequence number of the last frame sent to the user
+func (d *ChatDelivery) LastSeq(userID string) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if session := d.session(userID, false); session != nil {
+		return session.seq
+	}
+	return 0
+}
+
+// session returns the user's session, creating it when create is set
+func (d *ChatDelivery) session(userID string, create bool) *deliverySession {
+	key := strings.ToLower(userID)
+	session, ok := d.sessions[key]
+	if !ok && create {
+		session = &deliverySession{}
+		d.sessions[key] = session
+	}
+	return session
+}
+
+func (d *ChatDelivery) sequence(session *deliverySession, message *ChatResponse) *ChatResponse {
+	session.seq++
+	sequenced := *message
+	sequenced.Seq = session.seq
+	session.frames = append(session.frames, &sequenced)
+	if len(session.frames) > d.bufferSize {
+		session.frames = session.frames[len(session.frames)-d.bufferSize:]
+	}
+	return &sequenced
+}
+
+func (s *deliverySession) ack(seq uint64) {
+	i := 0
+	for i < len(s.frames) && s.frames[i].Seq <= seq {
+		i++
+	}
+	s.frames = s.frames[i:]
+}
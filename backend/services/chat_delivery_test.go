@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seqs(frames []*ChatResponse) []uint64 {
+	var numbers []uint64
+	for _, frame := range frames {
+		numbers = append(numbers, frame.Seq)
+	}
+	return numbers
+}
+
+func TestChatDeliveryReplaysUnackedFrames(t *testing.T) {
+	delivery := NewChatDelivery(4)
+	delivery.Connect("0xAA")
+	for i := 0; i < 3; i++ {
+		frame := delivery.Sequence("0xaa", &ChatResponse{Type: "text"})
+		assert.Equal(t, uint64(i+1), frame.Seq)
+	}
+	delivery.Ack("0xAa", 1)
+	delivery.Disconnect("0xaa")
+
+	// Frames for a recently connected user are held for the resume
+	assert.True(t, delivery.Hold("0xaa", &ChatResponse{Type: "price_alert"}))
+	assert.False(t, delivery.Hold("0xbb", &ChatResponse{Type: "price_alert"}), "unknown users have nothing to resume")
+	assert.Equal(t, uint64(4), delivery.LastSeq("0xaa"))
+
+	frames, err := delivery.Replay("0xaa", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, seqs(frames))
+	assert.Equal(t, "price_alert", frames[1].Type)
+
+	// Replays are repeatable until acked, and the resume acked frame 2
+	frames, err = delivery.Replay("0xaa", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, seqs(frames))
+	frames, err = delivery.Replay("0xaa", 4)
+	require.NoError(t, err)
+	assert.Empty(t, frames)
+
+	_, err = delivery.Replay("0xaa", 1)
+	assert.ErrorIs(t, err, ErrResumeGap, "frame 2 was acknowledged and dropped")
+	_, err = delivery.Replay("0xaa", 9)
+	assert.ErrorIs(t, err, ErrResumeGap, "the client is ahead of the server")
+	_, err = delivery.Replay("0xbb", 3)
+	assert.ErrorIs(t, err, ErrResumeGap)
+	frames, err = delivery.Replay("0xbb", 0)
+	require.NoError(t, err)
+	assert.Empty(t, frames)
+}
+
+func TestChatDeliveryOverflowAndExpiry(t *testing.T) {
+	delivery := NewChatDelivery(4)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	delivery.now = func() time.Time { return now }
+	delivery.Connect("0xaa")
+
+	// A client that never acks keeps only the newest frames
+	for i := 0; i < 6; i++ {
+		delivery.Sequence("0xaa", &ChatResponse{})
+	}
+	_, err := delivery.Replay("0xaa", 1)
+	assert.ErrorIs(t, err, ErrResumeGap)
+	frames, err := delivery.Replay("0xaa", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4, 5, 6}, seqs(frames))
+
+	// Sessions of users gone too long are dropped when anyone connects
+	delivery.Disconnect("0xaa")
+	now = now.Add(chatSessionTTL + time.Second)
+	delivery.Connect("0xbb")
+	assert.Zero(t, delivery.LastSeq("0xaa"))
+	_, err = delivery.Replay("0xaa", 6)
+	assert.ErrorIs(t, err, ErrResumeGap)
+}
@@ -3,26 +3,79 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gorilla/websocket"
 )
 
 // ChatEngine handles chat functionality and on-chain actions
 type ChatEngine struct {
-	ethClient    *ethclient.Client
+	ethClient    ChainClient
 	analyticsEngine *AnalyticsEngine
 	dataCollector   *DataCollector
 	logger       *log.Logger
-	connections  map[string]*websocket.Conn
+	connections  map[string]map[*ChatConnection]struct{}
+	streams      map[string]streamFilters
+	delivery     *ChatDelivery
 	mu           sync.RWMutex
+	webhooks     *WebhookDispatcher
+	metrics      *ChatMetrics
+	usage        *ChatUsageStore
+	summaries    *AddressSummarizer
+	fees         *FeeAnalyzer
+	portfolios   *PortfolioTracker
+	audit        *ActionAuditLog
+	actions      *ActionQueue
+	signing      *SigningService
+	actionCalls  *ActionRequestBuilder
+	congestion   *CongestionTracker
+	preferences  *PreferenceStore
+	votingPower  *VotingPowerReader
+	transactions *TxExplainer
+	staking      *StakingCollector
+	positions    *StakingPositions
+	depths       *PoolDepthReader
+	feedback     *ChatFeedbackStore
+	priceAlerts  *PriceAlerts
+	transcripts  *ChatTranscripts
+	killSwitch   *KillSwitch
+	walletLinks  *WalletLinks
+	schedules    *ActionSchedules
+
+	// spending caps the value of the actions each user confirms
+	spending *SpendingLimiter
+
+	// execution penalizes swap routes that execute worse than simulated
+	execution *ExecutionQuality
+
+	// listings answers questions about newly listed tokens
+	listings *ListingDetector
+
+	// pingInterval and maxMissedPongs set the heartbeat of connections
+	pingInterval   time.Duration
+	maxMissedPongs int
+
+	// connectionCount is the number of connections across users, capped at
+	// maxConnections
+	connectionCount int
+	maxConnections  int
+	// anonymousSessions numbers the delivery sessions of anonymous
+	// connections, which each have their own
+	anonymousSessions uint64
+
+	maxMessageLength int
+	maxChartPoints   int
 }
 
 // ChatMessage represents a chat message
@@ -31,8 +84,17 @@ type ChatMessage struct {
 	UserID    string                 `json:"user_id"`
 	Message   string                 `json:"message"`
 	Type      string                 `json:"type"` // text, action, query
-	Timestamp int64                  `json:"timestamp"`
+	// SessionID groups messages into transcripts; DefaultChatSession when empty
+	SessionID string                 `json:"session_id,omitempty"`
+	Timestamp APITime                `json:"timestamp"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	// Stream is set for subscribe, unsubscribe and subscriptions frames
+	Stream *StreamSubscription `json:"-"`
+	// Ack acknowledges the frames received up to this sequence number
+	Ack *uint64 `json:"ack,omitempty"`
+	// Resume asks a reconnected client's missed frames after this sequence
+	// number to be replayed
+	Resume *uint64 `json:"resume,omitempty"`
 }
 
 // ChatResponse represents a response to a chat message
@@ -42,9 +104,17 @@ type ChatResponse struct {
 	Response  string                 `json:"response"`
 	Type      string                 `json:"type"` // text, action_result, analytics
 	Data      interface{}            `json:"data,omitempty"`
-	Timestamp int64                  `json:"timestamp"`
+	Timestamp APITime                `json:"timestamp"`
+	// Deprecated: use Timestamp
+	TimestampUnix int64                  `json:"timestamp_unix"`
 	Success   bool                   `json:"success"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	// Attachments carry structured content such as charts alongside the text
+	Attachments []ChatAttachment `json:"attachments,omitempty"`
+	// FeedbackToken rates the response through the chat feedback API
+	FeedbackToken string `json:"feedback_token,omitempty"`
+	// Seq numbers the frames sent on a user's connections, for acks and resumes
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 // ActionRequest represents an on-chain action request
@@ -54,9 +124,18 @@ type ActionRequest struct {
 	ActionType  string                 `json:"action_type"`
 	Parameters  map[string]interface{} `json:"parameters"`
 	Status      string                 `json:"status"` // pending, executing, completed, failed
-	Timestamp   int64                  `json:"timestamp"`
+	Timestamp   APITime                `json:"timestamp"`
+	// Deprecated: use Timestamp
+	TimestampUnix int64                `json:"timestamp_unix"`
 	Result      interface{}            `json:"result,omitempty"`
 	Error       string                 `json:"error,omitempty"`
+	// BlockNumber and Confirmations track the transaction of a relayed
+	// action from its first receipt until it is final
+	BlockNumber   uint64 `json:"block_number,omitempty"`
+	Confirmations uint64 `json:"confirmations,omitempty"`
+
+	// Execution is how a mined swap's output compared with its simulation
+	Execution *SwapExecution `json:"execution,omitempty"`
 }
 
 // QueryIntent represents the intent of a user query
@@ -68,25 +147,208 @@ type QueryIntent struct {
 }
 
 // NewChatEngine creates a new chat engine instance
-func NewChatEngine(ethClient *ethclient.Client, analyticsEngine *AnalyticsEngine, dataCollector *DataCollector) *ChatEngine {
+func NewChatEngine(ethClient ChainClient, analyticsEngine *AnalyticsEngine, dataCollector *DataCollector) *ChatEngine {
 	return &ChatEngine{
 		ethClient:       ethClient,
 		analyticsEngine: analyticsEngine,
 		dataCollector:   dataCollector,
 		logger:          log.New(log.Writer(), "[ChatEngine] ", log.LstdFlags),
-		connections:     make(map[string]*websocket.Conn),
+		connections:     make(map[string]map[*ChatConnection]struct{}),
+		streams:         make(map[string]streamFilters),
+		delivery:        NewChatDelivery(ChatResumeBufferSize),
+		metrics:         NewChatMetrics(),
+		usage:           NewChatUsageStore(),
+
+		maxMessageLength: DefaultChatMaxMessageLength,
+		maxChartPoints:   DefaultChartMaxPoints,
+		pingInterval:     ChatPingInterval,
+		maxMissedPongs:   ChatMaxMissedPongs,
+		maxConnections:   DefaultChatMaxConnections,
+	}
+}
+
+// SetWebhookDispatcher enables webhook notifications for chat-initiated actions
+func (ce *ChatEngine) SetWebhookDispatcher(webhooks *WebhookDispatcher) {
+	ce.webhooks = webhooks
+}
+
+// SetAddressSummarizer enables address summaries in portfolio answers
+func (ce *ChatEngine) SetAddressSummarizer(summaries *AddressSummarizer) {
+	ce.summaries = summaries
+}
+
+// SetFeeAnalyzer enables gas spend answers in gas queries
+func (ce *ChatEngine) SetFeeAnalyzer(fees *FeeAnalyzer) {
+	ce.fees = fees
+}
+
+// SetPortfolioTracker enables performance answers in portfolio queries
+func (ce *ChatEngine) SetPortfolioTracker(portfolios *PortfolioTracker) {
+	ce.portfolios = portfolios
+}
+
+// SetActionAudit records the lifecycle of chat-initiated actions in an audit log
+func (ce *ChatEngine) SetActionAudit(audit *ActionAuditLog) {
+	ce.audit = audit
+}
+
+// SetActionQueue holds confirmed actions in a queue before they are
+// broadcast, so they can be cancelled
+func (ce *ChatEngine) SetActionQueue(actions *ActionQueue) {
+	ce.actions = actions
+}
+
+// SetKillSwitch rejects action confirmations while actions are suspended
+func (ce *ChatEngine) SetKillSwitch(killSwitch *KillSwitch) {
+	ce.killSwitch = killSwitch
+}
+
+// SetActionSchedules lets users set up recurring actions in chat, such as
+// "stake 1 ETH every Friday"
+func (ce *ChatEngine) SetActionSchedules(schedules *ActionSchedules) {
+	ce.schedules = schedules
+}
+
+// SetSpendingLimiter refuses to confirm actions worth more than the user's
+// spending limits allow
+func (ce *ChatEngine) SetSpendingLimiter(spending *SpendingLimiter) {
+	ce.spending = spending
+}
+
+// SetWalletLinks answers questions about "my wallets" across the sender's
+// linked wallets
+func (ce *ChatEngine) SetWalletLinks(walletLinks *WalletLinks) {
+	ce.walletLinks = walletLinks
+}
+
+// SetWalletSigning has actions that must come from the user's own address,
+// such as staking their tokens, signed by the user's wallet instead of the
+// relayer
+func (ce *ChatEngine) SetWalletSigning(signing *SigningService, actionCalls *ActionRequestBuilder) {
+	ce.signing = signing
+	ce.actionCalls = actionCalls
+}
+
+// SetCongestionTracker adds a congestion summary to gas answers
+func (ce *ChatEngine) SetCongestionTracker(congestion *CongestionTracker) {
+	ce.congestion = congestion
+}
+
+// SetStakingCollector answers staking questions with a validator recommendation
+func (ce *ChatEngine) SetStakingCollector(staking *StakingCollector) {
+	ce.staking = staking
+}
+
+// SetStakingPositions answers questions about the user's own stakes, such
+// as when they unlock
+func (ce *ChatEngine) SetStakingPositions(positions *StakingPositions) {
+	ce.positions = positions
+}
+
+// SetPreferenceStore makes answers and alerts follow each user's saved preferences
+func (ce *ChatEngine) SetPreferenceStore(preferences *PreferenceStore) {
+	ce.preferences = preferences
+}
+
+// userPreferences returns the user's preferences, or the defaults without a store
+func (ce *ChatEngine) userPreferences(userID string) UserPreferences {
+	if ce.preferences == nil {
+		return DefaultUserPreferences()
+	}
+	return ce.preferences.Preferences(userID)
+}
+
+// SetVotingPowerReader adds the sender's voting power to governance answers
+func (ce *ChatEngine) SetVotingPowerReader(votingPower *VotingPowerReader) {
+	ce.votingPower = votingPower
+}
+
+// SetPoolDepths warns when a requested swap is too large for its pool
+func (ce *ChatEngine) SetPoolDepths(depths *PoolDepthReader) {
+	ce.depths = depths
+}
+
+// SetExecutionQuality sizes split plans within the user's slippage tolerance
+// less the realized-slippage penalty of the pool's route
+func (ce *ChatEngine) SetExecutionQuality(execution *ExecutionQuality) {
+	ce.execution = execution
+}
+
+// SetListingDetector answers questions about new tokens on chain
+func (ce *ChatEngine) SetListingDetector(listings *ListingDetector) {
+	ce.listings = listings
+}
+
+// SetTxExplainer enables explanations of transaction hashes pasted into chat
+func (ce *ChatEngine) SetTxExplainer(transactions *TxExplainer) {
+	ce.transactions = transactions
+}
+
+// SetFeedbackStore hands out a feedback token with every response
+func (ce *ChatEngine) SetFeedbackStore(feedback *ChatFeedbackStore) {
+	ce.feedback = feedback
+}
+
+// SetTranscripts records every answered message in the user's transcript
+func (ce *ChatEngine) SetTranscripts(transcripts *ChatTranscripts) {
+	ce.transcripts = transcripts
+}
+
+// SetPriceAlerts lets users set price alerts in chat
+func (ce *ChatEngine) SetPriceAlerts(priceAlerts *PriceAlerts) {
+	ce.priceAlerts = priceAlerts
+}
+
+// SetMaxMessageLength sets the limit on message length, in characters
+func (ce *ChatEngine) SetMaxMessageLength(maxLength int) {
+	if maxLength > 0 {
+		ce.maxMessageLength = maxLength
 	}
 }
 
-// ProcessMessage processes a chat message and returns a response
+// SetMaxConnections sets the ceiling on open connections across users
+func (ce *ChatEngine) SetMaxConnections(maxConnections int) {
+	if maxConnections > 0 {
+		ce.mu.Lock()
+		ce.maxConnections = maxConnections
+		ce.mu.Unlock()
+	}
+}
+
+// MaxMessageLength returns the limit on message length, in characters
+func (ce *ChatEngine) MaxMessageLength() int {
+	return ce.maxMessageLength
+}
+
+// ProcessMessage processes a chat message and returns a response. The message
+// is sanitized in place first; content that can't be accepted is rejected with
+// an error for which IsInvalidMessage reports true.
 func (ce *ChatEngine) ProcessMessage(ctx context.Context, message *ChatMessage) (*ChatResponse, error) {
 	startTime := time.Now()
+	ce.metrics.RecordMessage()
+	ctx, tasks := withAnalyticsTaskCounter(ctx)
+	var resolved string
+	defer func() {
+		latency := time.Since(startTime)
+		ce.metrics.RecordLatency(latency)
+		ce.usage.Record(message.UserID, resolved, latency, int(tasks.Load()))
+	}()
+
+	text, err := SanitizeChatMessage(message.Message, ce.maxMessageLength)
+	if err != nil {
+		ce.metrics.RecordParseFailure()
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	message.Message = text
 
 	// Parse user intent
 	intent, err := ce.parseIntent(message.Message)
 	if err != nil {
+		ce.metrics.RecordParseFailure()
 		return nil, fmt.Errorf("failed to parse intent: %w", err)
 	}
+	ce.metrics.RecordIntent(intent.Intent)
+	resolved = intent.Intent
 
 	var response *ChatResponse
 
@@ -105,17 +367,47 @@ func (ce *ChatEngine) ProcessMessage(ctx context.Context, message *ChatMessage)
 		response, err = ce.handleMarketDataQuery(ctx, message, intent)
 	case "gas_info":
 		response, err = ce.handleGasInfoQuery(ctx, message, intent)
+	case "tx_explain":
+		response, err = ce.handleTxExplain(ctx, message, intent)
+	case "staking_query":
+		response, err = ce.handleStakingQuery(ctx, message, intent)
+	case "staking_position":
+		response, err = ce.handleStakingPosition(ctx, message, intent)
+	case "price_alert":
+		response, err = ce.handlePriceAlert(ctx, message, intent)
+	case "schedule_action":
+		response, err = ce.handleScheduleAction(ctx, message, intent)
+	case "cancel_action":
+		response, err = ce.handleCancelAction(ctx, message, intent)
+	case "protocol_compare":
+		response, err = ce.handleProtocolCompare(ctx, message, intent)
+	case "new_tokens":
+		response, err = ce.handleNewTokens(ctx, message, intent)
 	default:
 		response, err = ce.handleGeneralQuery(ctx, message, intent)
 	}
 
 	if err != nil {
+		ce.metrics.RecordHandlerError()
 		return nil, fmt.Errorf("failed to process message: %w", err)
 	}
 
 	response.ID = fmt.Sprintf("resp_%d", time.Now().UnixNano())
 	response.MessageID = message.ID
-	response.Timestamp = time.Now().Unix()
+	response.Response = escapeDisplay(response.Response)
+	now := time.Now()
+	response.Timestamp = NewAPITime(now)
+	response.TimestampUnix = now.Unix()
+	if ce.feedback != nil {
+		token, err := ce.feedback.Issue(message, response, intent)
+		if err != nil {
+			ce.logger.Printf("Failed to issue feedback token: %v", err)
+		}
+		response.FeedbackToken = token
+	}
+	if ce.transcripts != nil {
+		ce.transcripts.Record(message, response)
+	}
 
 	return response, nil
 }
@@ -146,7 +438,8 @@ func (ce *ChatEngine) parseIntent(message string) (*QueryIntent, error) {
 	}
 
 	// Portfolio-related queries
-	if strings.Contains(message, "portfolio") || strings.Contains(message, "balance") || strings.Contains(message, "holdings") {
+	if strings.Contains(message, "portfolio") || strings.Contains(message, "balance") || strings.Contains(message, "holdings") ||
+		strings.Contains(message, "am i up") || strings.Contains(message, "am i down") || isAllWalletsQuestion(message) {
 		intent.Intent = "portfolio_analysis"
 		intent.Confidence = 0.90
 		intent.Action = "analyze_portfolio"
@@ -180,6 +473,71 @@ func (ce *ChatEngine) parseIntent(message string) (*QueryIntent, error) {
 		intent.Action = "get_gas_info"
 	}
 
+	// Validator questions, unlike requests to stake an amount
+	if strings.Contains(message, "validator") || strings.Contains(message, "staking") {
+		intent.Intent = "staking_query"
+		intent.Confidence = 0.85
+		intent.Action = "recommend_validator"
+	}
+
+	// Questions about the user's own stakes, such as "when can I unstake"
+	if stakingPositionRegex.MatchString(message) {
+		intent.Intent = "staking_position"
+		intent.Confidence = 0.90
+		intent.Action = "get_staking_positions"
+	}
+
+	// New tokens, such as "any new tokens on Kaia this week?"
+	if newTokensRegex.MatchString(message) {
+		intent.Intent = "new_tokens"
+		intent.Confidence = 0.85
+		intent.Action = "list_new_tokens"
+	}
+
+	// Asking to be told when a price moves, such as "tell me when KAIA hits
+	// $1.50". Other questions can start the same way, so one already matched
+	// needs a price or a direction too.
+	if priceAlertRequestRegex.MatchString(message) &&
+		(intent.Intent == "" || priceAlertAmountRegex.MatchString(message) || mentionsPriceMove(message)) {
+		intent.Intent = "price_alert"
+		intent.Confidence = 0.85
+		intent.Action = "create_price_alert"
+	}
+
+	// Comparing protocols of the registry, such as "is KaiaSwap safer than
+	// KaiaLend?", which would otherwise read as a yield or general question
+	if protocolCompareRegex.MatchString(message) && ce.analyticsEngine != nil {
+		if matches := MatchProtocols(ce.analyticsEngine.Protocols(), message); len(matches) > 0 {
+			intent.Intent = "protocol_compare"
+			intent.Confidence = 0.85
+			intent.Action = "compare_protocols"
+			intent.Entities["protocols"] = matches
+		}
+	}
+
+	// Repeating an action, such as "stake my rewards every Friday", which
+	// schedules it rather than running it now
+	if intent.Intent == "on_chain_action" && mentionsRecurrence(message) {
+		intent.Intent = "schedule_action"
+		intent.Confidence = 0.90
+		intent.Action = "schedule_action"
+	}
+
+	// A pasted transaction hash asks what the transaction did, whatever the
+	// words around it
+	if txHashRegex.MatchString(message) {
+		intent.Intent = "tx_explain"
+		intent.Confidence = 0.95
+		intent.Action = "explain_transaction"
+	}
+
+	// Taking back an action, such as "cancel that" right after a swap
+	if cancelActionRegex.MatchString(message) {
+		intent.Intent = "cancel_action"
+		intent.Confidence = 0.90
+		intent.Action = "cancel_action"
+	}
+
 	// Default to general query
 	if intent.Intent == "" {
 		intent.Intent = "general_query"
@@ -195,13 +553,19 @@ func (ce *ChatEngine) parseIntent(message string) (*QueryIntent, error) {
 
 // extractEntities extracts entities from the message
 func (ce *ChatEngine) extractEntities(message string, intent *QueryIntent) {
-	// Extract addresses
-	addressRegex := regexp.MustCompile(`0x[a-fA-F0-9]{40}`)
+	// Extract addresses, which are bounded so the start of a hash isn't one
+	addressRegex := regexp.MustCompile(`\b0x[a-fA-F0-9]{40}\b`)
 	addresses := addressRegex.FindAllString(message, -1)
 	if len(addresses) > 0 {
 		intent.Entities["addresses"] = addresses
 	}
 
+	// Extract transaction hashes
+	hashes := txHashRegex.FindAllString(message, -1)
+	if len(hashes) > 0 {
+		intent.Entities["tx_hashes"] = hashes
+	}
+
 	// Extract amounts
 	amountRegex := regexp.MustCompile(`\d+(?:\.\d+)?`)
 	amounts := amountRegex.FindAllString(message, -1)
@@ -217,18 +581,24 @@ func (ce *ChatEngine) extractEntities(message string, intent *QueryIntent) {
 	}
 }
 
+// txHashRegex matches a transaction hash
+var txHashRegex = regexp.MustCompile(`\b0x[a-fA-F0-9]{64}\b`)
+
 // handleYieldQuery handles yield-related queries
 func (ce *ChatEngine) handleYieldQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
 	// Process yield analysis
-	result, err := ce.analyticsEngine.ProcessAnalyticsTask(ctx, "yield_analysis", map[string]interface{}{
+	result, err := ce.runAnalyticsTask(ctx, "yield_analysis", map[string]interface{}{
 		"user_address": message.UserID,
 		"query":        message.Message,
+		"history":      chatYieldChartWindow,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze yield opportunities: %w", err)
 	}
 
 	opportunities := result.Data.([]YieldOpportunity)
+	money := ce.displayRate(ctx, ce.userPreferences(message.UserID))
+	numbers := money.Numbers()
 	
 	var responseText strings.Builder
 	responseText.WriteString("Here are the best yield opportunities I found:\n\n")
@@ -238,11 +608,21 @@ func (ce *ChatEngine) handleYieldQuery(ctx context.Context, message *ChatMessage
 			break
 		}
 		responseText.WriteString(fmt.Sprintf("🏆 **%s** (%s)\n", opp.Protocol, opp.AssetPair))
-		responseText.WriteString(fmt.Sprintf("   APY: %.2f%%\n", opp.APY))
-		responseText.WriteString(fmt.Sprintf("   TVL: $%.0f\n", opp.TVL))
-		responseText.WriteString(fmt.Sprintf("   Risk Score: %.2f\n", opp.Risk))
-		responseText.WriteString(fmt.Sprintf("   Opportunity Score: %.2f\n\n", opp.Opportunity))
+		responseText.WriteString(fmt.Sprintf("   APY: %s (7d avg %s)\n", numbers.Percent(opp.APY, 2), numbers.Percent(opp.APY7dAvg, 2)))
+		if opp.Volatile {
+			responseText.WriteString(fmt.Sprintf("   ⚠️ Volatile APY: ±%s points over the last 7 days\n", numbers.Trimmed(opp.APYVolatility, 2)))
+		}
+		if opp.APYChanged != nil {
+			responseText.WriteString(fmt.Sprintf("   ⚡ APY moved %s points since the last scan (was %s)\n", strings.TrimSuffix(numbers.Change(opp.APYChanged.Delta, 2), "%"), numbers.Percent(opp.APYChanged.CachedAPY, 2)))
+		}
+		if opp.APYStale {
+			responseText.WriteString("   ⏳ Live APY couldn't be confirmed; showing the last scan\n")
+		}
+		responseText.WriteString(fmt.Sprintf("   TVL: %s\n", money.FormatCompact(opp.TVL)))
+		responseText.WriteString(fmt.Sprintf("   Risk Score: %s/100%s\n", numbers.Decimal(opp.RiskScore, 0), riskReasons(opp)))
+		responseText.WriteString(fmt.Sprintf("   Opportunity Score: %s\n\n", numbers.Decimal(opp.Opportunity, 2)))
 	}
+	responseText.WriteString(qualityNote(result.DataQuality))
 
 	return &ChatResponse{
 		Response: responseText.String(),
@@ -250,36 +630,69 @@ func (ce *ChatEngine) handleYieldQuery(ctx context.Context, message *ChatMessage
 		Data:     opportunities,
 		Success:  true,
 		Metadata: map[string]interface{}{
-			"confidence": intent.Confidence,
-			"intent":     intent.Intent,
+			"confidence":   intent.Confidence,
+			"intent":       intent.Intent,
+			"data_quality": result.DataQuality,
+			"conversion":   money,
 		},
+		Attachments: ce.yieldCharts(opportunities),
 	}, nil
 }
 
+// riskReasons names the factors making an opportunity risky, if any
+func riskReasons(opportunity YieldOpportunity) string {
+	drivers := RiskAssessment{Score: opportunity.RiskScore, Components: opportunity.RiskBreakdown}.Drivers(2)
+	if len(drivers) == 0 {
+		return ""
+	}
+	reasons := make([]string, len(drivers))
+	for i, driver := range drivers {
+		reasons[i] = driver.Detail
+	}
+	return " (" + strings.Join(reasons, "; ") + ")"
+}
+
+// qualityNote warns that a result was computed from low-quality data, and is
+// empty otherwise
+func qualityNote(quality *DataQuality) string {
+	if !quality.Low() {
+		return ""
+	}
+	return "⚠️ " + quality.Summary + "\n"
+}
+
 // handleTradingSuggestion handles trading suggestion queries
 func (ce *ChatEngine) handleTradingSuggestion(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
 	// Generate trading suggestions
-	result, err := ce.analyticsEngine.ProcessAnalyticsTask(ctx, "trading_suggestions", map[string]interface{}{
+	result, err := ce.runAnalyticsTask(ctx, "trading_suggestions", ce.userPreferences(message.UserID).ApplyDefaults(map[string]interface{}{
 		"user_address": message.UserID,
 		"query":        message.Message,
-	})
+	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate trading suggestions: %w", err)
 	}
 
 	suggestions := result.Data.([]TradingSuggestion)
+	numbers := NewNumberFormat(ce.userPreferences(message.UserID).Locale)
 	
 	var responseText strings.Builder
 	responseText.WriteString("Based on your trading history, here are my suggestions:\n\n")
 	
 	for i, suggestion := range suggestions {
 		responseText.WriteString(fmt.Sprintf("💡 **%s %s**\n", strings.Title(suggestion.Type), suggestion.Asset))
-		responseText.WriteString(fmt.Sprintf("   Amount: %.2f %s\n", suggestion.Amount, suggestion.Asset))
-		responseText.WriteString(fmt.Sprintf("   Confidence: %.1f%%\n", suggestion.Confidence*100))
+		responseText.WriteString(fmt.Sprintf("   Amount: %s %s\n", numbers.Token(suggestion.Amount, 0), suggestion.Asset))
+		responseText.WriteString(fmt.Sprintf("   Confidence: %s\n", numbers.Percent(suggestion.Confidence*100, 1)))
 		responseText.WriteString(fmt.Sprintf("   Risk Level: %s\n", suggestion.RiskLevel))
-		responseText.WriteString(fmt.Sprintf("   Expected Return: %.1f%%\n", suggestion.ExpectedReturn*100))
+		responseText.WriteString(fmt.Sprintf("   Expected Return: %s\n", numbers.Change(suggestion.ExpectedReturn*100, 1)))
+		if suggestion.Slippage > 0 {
+			responseText.WriteString(fmt.Sprintf("   Slippage: %s\n", numbers.Percent(suggestion.Slippage, 2)))
+		}
+		if suggestion.MaxSizeAt1PctImpact > 0 {
+			responseText.WriteString(fmt.Sprintf("   Max Size at 1%% Impact: %s %s\n", numbers.Token(suggestion.MaxSizeAt1PctImpact, 0), suggestion.Asset))
+		}
 		responseText.WriteString(fmt.Sprintf("   Reasoning: %s\n\n", suggestion.Reasoning))
 	}
+	responseText.WriteString(qualityNote(result.DataQuality))
 
 	return &ChatResponse{
 		Response: responseText.String(),
@@ -287,52 +700,273 @@ func (ce *ChatEngine) handleTradingSuggestion(ctx context.Context, message *Chat
 		Data:     suggestions,
 		Success:  true,
 		Metadata: map[string]interface{}{
-			"confidence": intent.Confidence,
-			"intent":     intent.Intent,
+			"confidence":   intent.Confidence,
+			"intent":       intent.Intent,
+			"data_quality": result.DataQuality,
 		},
 	}, nil
 }
 
 // handlePortfolioAnalysis handles portfolio analysis queries
 func (ce *ChatEngine) handlePortfolioAnalysis(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
-	// Analyze portfolio
-	result, err := ce.analyticsEngine.ProcessAnalyticsTask(ctx, "portfolio_optimization", map[string]interface{}{
-		"user_address": message.UserID,
-		"risk_tolerance": "medium",
-	})
+	if ce.portfolios != nil && isPerformanceQuestion(message.Message) {
+		if wallets, ok := ce.questionWallets(message, intent); ok {
+			return ce.handlePerformanceQuery(ctx, message, intent, wallets)
+		}
+	}
+
+	// Analyze portfolio; with the wallet's value the rebalancing is planned
+	preferences := ce.userPreferences(message.UserID)
+	parameters := map[string]interface{}{"user_address": message.UserID}
+	summary := ce.portfolioSummary(ctx, message, intent)
+	if summary != nil && summary.TotalValueUSD > 0 {
+		parameters["portfolio_value_usd"] = summary.TotalValueUSD
+	}
+	result, err := ce.runAnalyticsTask(ctx, "portfolio_optimization", preferences.ApplyDefaults(parameters))
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze portfolio: %w", err)
 	}
 
 	optimization := result.Data.(map[string]interface{})
+	money := ce.displayRate(ctx, preferences)
+	numbers := money.Numbers()
 	
 	responseText := fmt.Sprintf("📊 **Portfolio Analysis**\n\n"+
-		"Current Risk Score: %.1f%%\n"+
-		"Expected Return: %.1f%%\n"+
-		"Rebalancing Needed: %v\n"+
-		"Estimated Cost: $%.2f\n\n"+
-		"Would you like me to help you rebalance your portfolio?",
-		optimization["risk_score"].(float64)*100,
-		optimization["expected_return"].(float64)*100,
-		optimization["rebalancing_needed"].(bool),
-		optimization["rebalancing_cost"].(float64))
+		"Risk Tolerance: %s\n"+
+		"Target Risk Score: %s\n"+
+		"Expected Return: %s\n"+
+		"Rebalancing Needed: %v\n",
+		optimization["risk_tolerance"].(string),
+		numbers.Percent(optimization["risk_score"].(float64)*100, 1),
+		numbers.Change(optimization["expected_return"].(float64)*100, 1),
+		optimization["rebalancing_needed"].(bool))
+	if plan, ok := optimization["rebalancing_plan"].(*RebalancePlan); ok {
+		responseText += formatRebalancePlan(plan, money)
+	}
+	responseText += "\nWould you like me to help you rebalance your portfolio?"
+	if note := qualityNote(result.DataQuality); note != "" {
+		responseText = note + "\n" + responseText
+	}
+
+	var data interface{} = optimization
+	if summary != nil {
+		responseText = formatAddressSummary(summary, money) + "\n" + responseText
+		if money.Currency != "USD" {
+			summary = summary.InCurrency(money)
+		}
+		data = map[string]interface{}{
+			"summary":      summary,
+			"optimization": optimization,
+		}
+	}
 
 	return &ChatResponse{
 		Response: responseText,
 		Type:     "analytics",
-		Data:     optimization,
+		Data:     data,
 		Success:  true,
 		Metadata: map[string]interface{}{
-			"confidence": intent.Confidence,
-			"intent":     intent.Intent,
+			"confidence":   intent.Confidence,
+			"intent":       intent.Intent,
+			"data_quality": result.DataQuality,
+			"conversion":   money,
 		},
 	}, nil
 }
 
+// portfolioSummary summarizes the address mentioned in the message, falling
+// back to the sender's own address, or the sender's linked wallets together.
+// Returns nil when neither is available.
+func (ce *ChatEngine) portfolioSummary(ctx context.Context, message *ChatMessage, intent *QueryIntent) *AddressSummary {
+	if ce.summaries == nil {
+		return nil
+	}
+
+	wallets, ok := ce.questionWallets(message, intent)
+	if !ok {
+		return nil
+	}
+
+	summary, err := ce.summaries.SummarizeWallets(ctx, wallets, SummaryOptions{})
+	if err != nil {
+		ce.logger.Printf("Failed to summarize address %s: %v", wallets[0].Hex(), err)
+		return nil
+	}
+	return summary
+}
+
+// isAllWalletsQuestion reports whether the message asks about the sender's
+// wallets together, such as "total across my wallets"
+func isAllWalletsQuestion(message string) bool {
+	message = strings.ToLower(message)
+	for _, phrase := range []string{"my wallets", "all wallets", "all of my wallets", "across wallets"} {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// questionWallets returns the wallets a portfolio question is about: the
+// sender's linked wallets when it asks about them together, otherwise the
+// address mentioned or the sender's own
+func (ce *ChatEngine) questionWallets(message *ChatMessage, intent *QueryIntent) ([]common.Address, bool) {
+	if ce.walletLinks != nil && isAllWalletsQuestion(message.Message) && common.IsHexAddress(message.UserID) {
+		return ce.walletLinks.Wallets(common.HexToAddress(message.UserID)), true
+	}
+	address, ok := intentAddress(message, intent)
+	if !ok {
+		return nil, false
+	}
+	return []common.Address{address}, true
+}
+
+// intentAddress returns the address mentioned in the message, falling back to
+// the sender's own address
+func intentAddress(message *ChatMessage, intent *QueryIntent) (common.Address, bool) {
+	target := message.UserID
+	if addresses, ok := intent.Entities["addresses"].([]string); ok && len(addresses) > 0 {
+		target = addresses[0]
+	}
+	if !common.IsHexAddress(target) {
+		return common.Address{}, false
+	}
+	return common.HexToAddress(target), true
+}
+
+// displayRate returns the conversion into the user's display currency at
+// the collector's current price, writing amounts in their locale. It falls
+// back to USD when the currency can't be priced.
+func (ce *ChatEngine) displayRate(ctx context.Context, preferences UserPreferences) ConversionRate {
+	rate, err := ce.dataCollector.ConversionRate(ctx, preferences.DisplayCurrency)
+	if err != nil {
+		ce.logger.Printf("Failed to price display currency %s: %v", preferences.DisplayCurrency, err)
+		rate = USDConversion(time.Now())
+	}
+	return rate.WithLocale(preferences.Locale)
+}
+
+// formatAddressSummary renders an address summary for chat with values in
+// the display currency
+func formatAddressSummary(summary *AddressSummary, money ConversionRate) string {
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("👛 **%s**\n\n", summary.Address))
+	if len(summary.Wallets) > 1 {
+		text.WriteString(fmt.Sprintf("Total across %d linked wallets\n", len(summary.Wallets)))
+	}
+	numbers := money.Numbers()
+	text.WriteString(fmt.Sprintf("%s Balance: %s (%s)\n", NativeSymbol, numbers.Token(summary.NativeBalanceFloat, priceOf(summary.NativeValueUSD, summary.NativeBalanceFloat)), money.Format(summary.NativeValueUSD)))
+	for _, token := range summary.TopTokens {
+		text.WriteString(fmt.Sprintf("%s Balance: %s (%s)\n", token.Symbol, numbers.Token(token.Balance, priceOf(token.ValueUSD, token.Balance)), money.Format(token.ValueUSD)))
+	}
+	text.WriteString(fmt.Sprintf("Total Value: %s\n", money.Format(summary.TotalValueUSD)))
+	text.WriteString(fmt.Sprintf("Transactions (30d): %d\n", summary.TxCount30d))
+	if !summary.FirstSeen.IsZero() {
+		text.WriteString(fmt.Sprintf("First Seen: %s\n", summary.FirstSeen.Format("2006-01-02")))
+	}
+	if summary.Partial {
+		text.WriteString("⚠️ Some data is still being indexed, so these figures may be incomplete.\n")
+	}
+	return text.String()
+}
+
+// isPerformanceQuestion reports whether the message asks how a portfolio has
+// done over time rather than what it holds
+func isPerformanceQuestion(message string) bool {
+	message = strings.ToLower(message)
+	for _, keyword := range []string{"doing", "perform", "am i up", "am i down", "pnl", "profit"} {
+		if strings.Contains(message, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// priceOf is the unit price of a holding of amount worth valueUSD, or zero
+// when the holding is empty
+func priceOf(valueUSD, amount float64) float64 {
+	if amount == 0 {
+		return 0
+	}
+	return valueUSD / math.Abs(amount)
+}
+
+// handlePerformanceQuery answers how the portfolio of an address, or of
+// linked wallets together, did over a period
+func (ce *ChatEngine) handlePerformanceQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent, wallets []common.Address) (*ChatResponse, error) {
+	address := wallets[0]
+	since, period := questionPeriod(message.Message, time.Now())
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+
+	perf, err := ce.portfolios.WalletsPerformance(ctx, wallets, since)
+	if errors.Is(err, ErrNoPerformanceHistory) {
+		return &ChatResponse{
+			Response: fmt.Sprintf("📈 I don't have portfolio snapshots for %s yet. "+
+				"Turn on daily portfolio tracking and I'll be able to tell you how it's doing.", address.Hex()),
+			Type:     "portfolio_performance",
+			Success:  true,
+			Metadata: metadata,
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute portfolio performance: %w", err)
+	}
+
+	return &ChatResponse{
+		Response:    formatPerformance(perf, period, since, NewNumberFormat(ce.userPreferences(message.UserID).Locale)),
+		Type:        "portfolio_performance",
+		Data:        perf,
+		Success:     true,
+		Metadata:    metadata,
+		Attachments: ce.performanceChart(perf),
+	}, nil
+}
+
+// formatPerformance renders portfolio performance for chat
+func formatPerformance(perf *PortfolioPerformance, period string, since time.Time, numbers NumberFormat) string {
+	emoji := "📈"
+	if perf.PnLUSD < 0 {
+		emoji = "📉"
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("%s **Portfolio Performance %s**\n\n", emoji, period))
+	text.WriteString(fmt.Sprintf("Address: %s\n", perf.Address))
+	if len(perf.Wallets) > 1 {
+		text.WriteString(fmt.Sprintf("Across %d linked wallets\n", len(perf.Wallets)))
+	}
+	signed := func(usd float64) string {
+		written := numbers.Decimal(usd, 2)
+		if usd > 0 && hasNonZeroDigit(written) {
+			return "+" + written
+		}
+		return written
+	}
+	text.WriteString(fmt.Sprintf("Value: $%s → $%s\n", numbers.Decimal(perf.StartValueUSD, 2), numbers.Decimal(perf.EndValueUSD, 2)))
+	if len(perf.Flows) > 0 {
+		text.WriteString(fmt.Sprintf("Net Deposits: %s USD\n", signed(perf.NetFlowsUSD)))
+	}
+	text.WriteString(fmt.Sprintf("PnL: %s USD (%s money-weighted)\n", signed(perf.PnLUSD), numbers.Change(perf.ReturnPct, 2)))
+	if perf.Best != nil && perf.Worst != nil && perf.Best.Symbol != perf.Worst.Symbol {
+		text.WriteString(fmt.Sprintf("Best: %s %s\n", perf.Best.Symbol, numbers.Change(perf.Best.PriceChangePct, 2)))
+		text.WriteString(fmt.Sprintf("Worst: %s %s\n", perf.Worst.Symbol, numbers.Change(perf.Worst.PriceChangePct, 2)))
+	}
+	if perf.From.After(since) {
+		text.WriteString(fmt.Sprintf("\nTracking started %s, so this only covers the time since.\n", perf.From.Format("2006-01-02")))
+	}
+	if perf.Partial {
+		text.WriteString("⚠️ Some data is still being indexed, so these figures may be incomplete.\n")
+	}
+	return text.String()
+}
+
 // handleGovernanceQuery handles governance-related queries
 func (ce *ChatEngine) handleGovernanceQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
 	// Analyze governance sentiment
-	result, err := ce.analyticsEngine.ProcessAnalyticsTask(ctx, "governance_sentiment", map[string]interface{}{
+	result, err := ce.runAnalyticsTask(ctx, "governance_sentiment", map[string]interface{}{
 		"user_address": message.UserID,
 		"query":        message.Message,
 	})
@@ -341,6 +975,7 @@ func (ce *ChatEngine) handleGovernanceQuery(ctx context.Context, message *ChatMe
 	}
 
 	sentiments := result.Data.([]GovernanceSentiment)
+	numbers := NewNumberFormat(ce.userPreferences(message.UserID).Locale)
 	
 	var responseText strings.Builder
 	responseText.WriteString("🗳️ **Governance Sentiment Analysis**\n\n")
@@ -355,22 +990,67 @@ func (ce *ChatEngine) handleGovernanceQuery(ctx context.Context, message *ChatMe
 		}
 		
 		responseText.WriteString(fmt.Sprintf("%s **%s**\n", emoji, sentiment.Title))
-		responseText.WriteString(fmt.Sprintf("   Sentiment: %s (%.1f%% confidence)\n", sentiment.Sentiment, sentiment.Confidence*100))
-		responseText.WriteString(fmt.Sprintf("   Votes: %d For, %d Against, %d Abstain\n\n", sentiment.ForVotes, sentiment.AgainstVotes, sentiment.AbstainVotes))
+		responseText.WriteString(fmt.Sprintf("   Sentiment: %s (%s confidence)\n", sentiment.Sentiment, numbers.Percent(sentiment.Confidence*100, 1)))
+		responseText.WriteString(fmt.Sprintf("   Votes: %d For, %d Against, %d Abstain\n", sentiment.ForVotes, sentiment.AgainstVotes, sentiment.AbstainVotes))
+		if sentiment.Prediction != nil {
+			responseText.WriteString(fmt.Sprintf("   Prediction: %s (%s pass probability, %s expected participation)\n",
+				sentiment.Prediction.PredictedOutcome, numbers.Percent(sentiment.Prediction.PassProbability*100, 1), numbers.Percent(sentiment.Prediction.ExpectedParticipation*100, 1)))
+		}
+		responseText.WriteString("\n")
+	}
+	responseText.WriteString(qualityNote(result.DataQuality))
+
+	var data interface{} = sentiments
+	if power := ce.senderVotingPower(ctx, message); power != nil {
+		responseText.WriteString(formatVotingPower(power, numbers))
+		data = map[string]interface{}{
+			"sentiments":   sentiments,
+			"voting_power": power,
+		}
 	}
 
 	return &ChatResponse{
 		Response: responseText.String(),
 		Type:     "analytics",
-		Data:     sentiments,
+		Data:     data,
 		Success:  true,
 		Metadata: map[string]interface{}{
-			"confidence": intent.Confidence,
-			"intent":     intent.Intent,
+			"confidence":   intent.Confidence,
+			"intent":       intent.Intent,
+			"data_quality": result.DataQuality,
 		},
 	}, nil
 }
 
+// senderVotingPower reads the voting power of a sender identified by wallet
+// address. Returns nil for anonymous senders or when it can't be read.
+func (ce *ChatEngine) senderVotingPower(ctx context.Context, message *ChatMessage) *VotingPower {
+	if ce.votingPower == nil || !common.IsHexAddress(message.UserID) {
+		return nil
+	}
+
+	power, err := ce.votingPower.VotingPower(ctx, common.HexToAddress(message.UserID), 0)
+	if err != nil {
+		ce.logger.Printf("Failed to read voting power of %s: %v", message.UserID, err)
+		return nil
+	}
+	return power
+}
+
+// formatVotingPower renders the sender's voting power for chat
+func formatVotingPower(power *VotingPower, numbers NumberFormat) string {
+	switch power.Status {
+	case DelegationUnsupported:
+		return "🔑 **Your Voting Power**: the governance token doesn't support delegation, so voting power can't be looked up.\n"
+	case DelegationNone:
+		return fmt.Sprintf("🔑 **Your Voting Power**: %s votes. Your tokens aren't delegated, so they don't count yet; delegate to yourself to vote.\n", numbers.Compact(power.VotingPower))
+	case DelegationSelf:
+		return fmt.Sprintf("🔑 **Your Voting Power**: %s votes, self-delegated.\n", numbers.Compact(power.VotingPower))
+	default:
+		return fmt.Sprintf("🔑 **Your Voting Power**: %s votes. Your own tokens are delegated to %s.\n", numbers.Compact(power.VotingPower), power.Delegate)
+	}
+}
+
 // handleOnChainAction handles on-chain action requests
 func (ce *ChatEngine) handleOnChainAction(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
 	// Extract action parameters from message
@@ -378,22 +1058,62 @@ func (ce *ChatEngine) handleOnChainAction(ctx context.Context, message *ChatMess
 	parameters := ce.extractActionParameters(message.Message)
 	
 	// Create action request
+	now := time.Now()
 	actionRequest := &ActionRequest{
-		ID:         fmt.Sprintf("action_%d", time.Now().UnixNano()),
-		UserID:     message.UserID,
-		ActionType: actionType,
-		Parameters: parameters,
-		Status:     "pending",
-		Timestamp:  time.Now().Unix(),
+		ID:            fmt.Sprintf("action_%d", now.UnixNano()),
+		UserID:        message.UserID,
+		ActionType:    actionType,
+		Parameters:    parameters,
+		Status:        "pending",
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+	}
+	ce.metrics.RecordActionProposal()
+	ce.auditAction(message, actionRequest, ActionEventProposed, "", "")
+	if err := ce.killSwitch.Check(ctx); err != nil {
+		return ce.suspendedAction(message, intent, actionRequest, err), nil
+	}
+	// Both the relayer and the wallet-signed flows count toward the limits
+	if err := ce.authorizeSpending(ctx, actionRequest); err != nil {
+		return ce.refusedAction(message, intent, actionRequest, err), nil
+	}
+	plan := ce.swapSplitPlan(ctx, message.UserID, actionType, parameters)
+
+	if ce.signing != nil && walletSignedActions[actionType] && common.IsHexAddress(message.UserID) {
+		response, err := ce.requestSignature(ctx, message, intent, actionRequest)
+		return withSplitPlan(response, plan), err
 	}
 
 	// Simulate action execution
 	// In a real implementation, this would interact with the ActionContract
+	actionRequest.Status = "executing"
+	ce.metrics.RecordActionConfirmation()
+	ce.auditAction(message, actionRequest, ActionEventConfirmed, "", "")
+
+	if ce.actions != nil {
+		return withSplitPlan(ce.queueAction(message, intent, actionRequest), plan), nil
+	}
+
+	txHash := "0x1234567890abcdef..." // Simulated transaction hash
+	ce.auditAction(message, actionRequest, ActionEventSubmitted, txHash, "")
+
 	actionRequest.Status = "completed"
 	actionRequest.Result = map[string]interface{}{
 		"success": true,
 		"message": fmt.Sprintf("Successfully executed %s action", actionType),
-		"tx_hash": "0x1234567890abcdef...", // Simulated transaction hash
+		"tx_hash": txHash,
+	}
+	ce.auditAction(message, actionRequest, ActionEventMined, txHash, "")
+
+	if ce.webhooks != nil {
+		err := ce.webhooks.Dispatch(WebhookEvent{
+			Type:    "action.completed",
+			Owner:   message.UserID,
+			Payload: actionRequest,
+		})
+		if err != nil {
+			ce.logger.Printf("Failed to dispatch action webhook: %v", err)
+		}
 	}
 
 	responseText := fmt.Sprintf("⚡ **Action Executed Successfully**\n\n"+
@@ -405,7 +1125,7 @@ func (ce *ChatEngine) handleOnChainAction(ctx context.Context, message *ChatMess
 		actionRequest.Status,
 		actionRequest.Result.(map[string]interface{})["tx_hash"])
 
-	return &ChatResponse{
+	return withSplitPlan(&ChatResponse{
 		Response: responseText,
 		Type:     "action_result",
 		Data:     actionRequest,
@@ -415,66 +1135,364 @@ func (ce *ChatEngine) handleOnChainAction(ctx context.Context, message *ChatMess
 			"intent":     intent.Intent,
 			"action_id":  actionRequest.ID,
 		},
-	}, nil
+	}, plan), nil
 }
 
-// handleMarketDataQuery handles market data queries
-func (ce *ChatEngine) handleMarketDataQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
-	// Get market data
-	symbols := []string{"ETH", "USDC", "DAI"}
-	marketData, err := ce.dataCollector.CollectMarketData(ctx, symbols)
+// ExecuteScheduledAction runs a recurring action for its user as if they had
+// just confirmed it, through the same kill switch and spending limit checks.
+// Auto-execution is only allowed within spending limits, so it fails when
+// none are set.
+func (ce *ChatEngine) ExecuteScheduledAction(ctx context.Context, schedule ActionSchedule) (*ChatResponse, error) {
+	if ce.spending == nil {
+		return nil, errors.New("recurring actions only run automatically within spending limits, and none are set")
+	}
+	message := &ChatMessage{
+		ID:        fmt.Sprintf("%s_%d", schedule.ID, time.Now().UnixNano()),
+		UserID:    schedule.UserID,
+		Message:   schedule.Action,
+		Type:      "action",
+		Timestamp: NewAPITime(time.Now()),
+	}
+	intent := &QueryIntent{
+		Intent:     "on_chain_action",
+		Confidence: 1,
+		Action:     "execute_action",
+		Entities:   make(map[string]interface{}),
+	}
+	return ce.handleOnChainAction(ctx, message, intent)
+}
+
+// swapSplitPlan plans the tranches of a swap whose amount would move its
+// pool's price by more than the user's slippage tolerance, less the penalty
+// of a pool whose swaps have executed worse than simulated. The pool is
+// recorded as the swap's route. Returns nil for other actions, swaps that
+// fit in one trade, or when no pool is known.
+func (ce *ChatEngine) swapSplitPlan(ctx context.Context, userID, actionType string, parameters map[string]interface{}) *SwapSplitPlan {
+	if ce.depths == nil || actionType != "swap" {
+		return nil
+	}
+	token, _ := parameters["token"].(string)
+	amount, err := strconv.ParseFloat(fmt.Sprint(parameters["amount"]), 64)
+	if token == "" || err != nil || amount <= 0 {
+		return nil
+	}
+	depth, err := ce.depths.Depth(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to collect market data: %w", err)
+		return nil
 	}
 
-	var responseText strings.Builder
-	responseText.WriteString("📈 **Market Data**\n\n")
-	
-	for _, data := range marketData {
-		changeEmoji := "➡️"
-		if data.Change24h > 0 {
-			changeEmoji = "📈"
-		} else if data.Change24h < 0 {
-			changeEmoji = "📉"
-		}
-		
-		responseText.WriteString(fmt.Sprintf("%s **%s**: $%.2f (%+.2f%%)\n", changeEmoji, data.Symbol, data.Price, data.Change24h))
-		responseText.WriteString(fmt.Sprintf("   24h Volume: $%.0f\n", data.Volume24h))
-		responseText.WriteString(fmt.Sprintf("   Market Cap: $%.0f\n\n", data.MarketCap))
+	route := strings.ToLower(depth.Pool.Hex())
+	if _, ok := parameters[SwapParamRoute]; !ok {
+		parameters[SwapParamRoute] = route
+		parameters[SwapParamPair] = depth.Pair
 	}
 
-	return &ChatResponse{
-		Response: responseText.String(),
-		Type:     "market_data",
-		Data:     marketData,
-		Success:  true,
-		Metadata: map[string]interface{}{
-			"confidence": intent.Confidence,
-			"intent":     intent.Intent,
-		},
-	}, nil
+	// Realized slippage on the route eats into the impact the swap can afford,
+	// down to a quarter of the tolerance
+	slippage := ce.userPreferences(userID).DefaultSlippage
+	penalty := ce.execution.Penalty(route)
+	budget := math.Max(slippage-penalty, slippage/4)
+	tranches := depth.SplitOrder("swap", amount, budget)
+	if tranches == nil {
+		return nil
+	}
+	return &SwapSplitPlan{
+		TranchePlan:  *tranches,
+		Asset:        token,
+		Pair:         depth.Pair,
+		Slippage:     slippage,
+		MaxSize:      depth.MaxTradeSize("swap", budget),
+		RoutePenalty: penalty,
+	}
+}
+
+// SwapSplitPlan is the split-order plan offered for a swap too large for its pool
+type SwapSplitPlan struct {
+	TranchePlan
+	Asset string `json:"asset"`
+	Pair  string `json:"pair"`
+	// Slippage is the user's tolerance the tranches are sized to, in percent
+	Slippage float64 `json:"slippage"`
+	// MaxSize is the largest single swap within the slippage tolerance
+	MaxSize float64 `json:"max_size"`
+	// RoutePenalty is the pool's realized slippage beyond its quotes, in
+	// percent, taken off the tolerance
+	RoutePenalty float64 `json:"route_penalty,omitempty"`
+}
+
+// routePenaltyNote explains a tolerance tightened by the route's realized
+// slippage
+func routePenaltyNote(plan *SwapSplitPlan) string {
+	if plan.RoutePenalty <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("Swaps through this pool have recently received %s less than quoted, so the tranches leave room for that.\n\n", NumberFormat{}.Percent(plan.RoutePenalty, 2))
+}
+
+// withSplitPlan puts a warning and the split-order plan ahead of an action
+// response. A nil plan leaves the response as it is.
+func withSplitPlan(response *ChatResponse, plan *SwapSplitPlan) *ChatResponse {
+	if response == nil || plan == nil {
+		return response
+	}
+	var numbers NumberFormat
+	response.Response = fmt.Sprintf("⚠️ **Large Swap**: %s %s would move the %s pool's price by about %s, above your %s slippage tolerance. "+
+		"At most %s %s fits in one swap.\n"+
+		"Split plan: %d swaps of %s %s, about %s impact each. Space them out so arbitrage can restore the pool's price in between.\n\n",
+		numbers.Token(plan.Amount, 0), plan.Asset, plan.Pair, numbers.Percent(plan.SingleImpact, 2), numbers.Percent(plan.Slippage, 2),
+		numbers.Token(plan.MaxSize, 0), plan.Asset,
+		plan.Tranches, numbers.Token(plan.TrancheAmount, 0), plan.Asset, numbers.Percent(plan.TrancheImpact, 2)) + routePenaltyNote(plan) + response.Response
+	if response.Metadata == nil {
+		response.Metadata = map[string]interface{}{}
+	}
+	response.Metadata["split_plan"] = plan
+	return response
+}
+
+// auditAction appends a lifecycle event of a chat-initiated action to the audit log
+func (ce *ChatEngine) auditAction(message *ChatMessage, action *ActionRequest, event, txHash, reason string) {
+	if ce.audit == nil {
+		return
+	}
+	ce.audit.Append(ActionAuditRecord{
+		ActionID:   action.ID,
+		UserID:     action.UserID,
+		MessageID:  message.ID,
+		Event:      event,
+		ActionType: action.ActionType,
+		TxHash:     txHash,
+		Reason:     reason,
+	})
+}
+
+// suspendedAction rejects an action proposed while actions are suspended
+func (ce *ChatEngine) suspendedAction(message *ChatMessage, intent *QueryIntent, action *ActionRequest, err error) *ChatResponse {
+	action.Status = ActionStatusFailed
+	action.Error = err.Error()
+	ce.auditAction(message, action, ActionEventFailed, "", err.Error())
+
+	return &ChatResponse{
+		Response: "⛔ On-chain actions are temporarily suspended, so this action wasn't confirmed. Please try again later.",
+		Type:     "action_result",
+		Data:     action,
+		Success:  false,
+		Metadata: map[string]interface{}{
+			"confidence": intent.Confidence,
+			"intent":     intent.Intent,
+			"error":      "actions_suspended",
+		},
+	}
+}
+
+// authorizeSpending prices an action and counts it toward the user's
+// spending limits, or returns why it can't be confirmed
+func (ce *ChatEngine) authorizeSpending(ctx context.Context, action *ActionRequest) error {
+	if ce.spending == nil {
+		return nil
+	}
+	value, err := ce.spending.ActionValueUSD(ctx, action.Parameters)
+	if err != nil {
+		return fmt.Errorf("can't check the action's value against the spending limits: %w", err)
+	}
+	return ce.spending.Authorize(ctx, action.UserID, value)
+}
+
+// refusedAction rejects an action that would pass the user's spending limits
+func (ce *ChatEngine) refusedAction(message *ChatMessage, intent *QueryIntent, action *ActionRequest, err error) *ChatResponse {
+	action.Status = ActionStatusFailed
+	action.Error = err.Error()
+	ce.auditAction(message, action, ActionEventFailed, "", err.Error())
+
+	responseText := "🛑 **Spending Limit Reached**\n\n"
+	var limitErr *SpendingLimitError
+	switch {
+	case errors.As(err, &limitErr) && limitErr.Limit == "per_action":
+		responseText += fmt.Sprintf("This action is worth about $%s, above your limit of $%s per action, so it wasn't confirmed.",
+			NumberFormat{}.Decimal(limitErr.ValueUSD, 2), NumberFormat{}.Decimal(limitErr.LimitUSD, 2))
+	case limitErr != nil:
+		responseText += fmt.Sprintf("This action is worth about $%s and you've confirmed $%s today, above your daily limit of $%s, so it wasn't confirmed. "+
+			"The daily limit resets at midnight UTC.",
+			NumberFormat{}.Decimal(limitErr.ValueUSD, 2), NumberFormat{}.Decimal(limitErr.SpentUSD, 2), NumberFormat{}.Decimal(limitErr.LimitUSD, 2))
+	default:
+		responseText += "I couldn't check this action against your spending limits, so it wasn't confirmed. Please try again later."
+	}
+
+	return &ChatResponse{
+		Response: responseText,
+		Type:     "action_result",
+		Data:     action,
+		Success:  false,
+		Metadata: map[string]interface{}{
+			"confidence": intent.Confidence,
+			"intent":     intent.Intent,
+			"error":      "spending_limit",
+		},
+	}
+}
+
+// queueAction holds a confirmed action in the action queue and tells the user
+// how long they have to take it back
+func (ce *ChatEngine) queueAction(message *ChatMessage, intent *QueryIntent, action *ActionRequest) *ChatResponse {
+	ce.actions.Enqueue(action, message.ID)
+	queued, _ := ce.actions.Get(action.ID)
+
+	responseText := fmt.Sprintf("⚡ **Action Queued**\n\n"+
+		"Action: %s\n"+
+		"Status: %s\n\n"+
+		"It will be submitted to the blockchain in %s. Say \"cancel that\" to stop it.",
+		queued.ActionType,
+		queued.Status,
+		ce.actions.Delay())
+
+	return &ChatResponse{
+		Response: responseText,
+		Type:     "action_result",
+		Data:     &queued,
+		Success:  true,
+		Metadata: map[string]interface{}{
+			"confidence": intent.Confidence,
+			"intent":     intent.Intent,
+			"action_id":  queued.ID,
+		},
+	}
+}
+
+// walletSignedActions are the actions that must come from the user's own
+// address rather than the relayer's
+var walletSignedActions = map[string]bool{
+	"stake":   true,
+	"unstake": true,
+	"vote":    true,
+}
+
+// requestSignature prepares the ActionContract request of an action for the
+// user's wallet to sign; the signing service pushes it to their connection
+func (ce *ChatEngine) requestSignature(ctx context.Context, message *ChatMessage, intent *QueryIntent, action *ActionRequest) (*ChatResponse, error) {
+	request, err := ce.actionCalls.Build(ctx, action.ActionType, action.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare %s action: %w", action.ActionType, err)
+	}
+	signing, err := ce.signing.Prepare(ctx, common.HexToAddress(message.UserID), SigningPrompt{
+		Description: fmt.Sprintf("Request a %s action through the ActionContract", action.ActionType),
+		ActionID:    action.ID,
+		ActionType:  action.ActionType,
+		MessageID:   message.ID,
+	}, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare %s action: %w", action.ActionType, err)
+	}
+
+	action.Status = "awaiting_signature"
+	action.Result = map[string]interface{}{"signing_request": signing}
+	ce.metrics.RecordActionConfirmation()
+	ce.auditAction(message, action, ActionEventConfirmed, "", "awaiting wallet signature")
+
+	responseText := fmt.Sprintf("✍️ **Signature Needed**\n\n"+
+		"Action: %s\n"+
+		"This action must come from your own address, so I've sent it to your wallet to sign. "+
+		"The request expires in %s.",
+		action.ActionType,
+		SigningRequestTTL)
+
+	return &ChatResponse{
+		Response: responseText,
+		Type:     "action_result",
+		Data:     action,
+		Success:  true,
+		Metadata: map[string]interface{}{
+			"confidence":         intent.Confidence,
+			"intent":             intent.Intent,
+			"action_id":          action.ID,
+			"signing_request_id": signing.ID,
+		},
+	}, nil
+}
+
+// handleMarketDataQuery handles market data queries
+func (ce *ChatEngine) handleMarketDataQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	// Get market data, including the user's favorite tokens
+	preferences := ce.userPreferences(message.UserID)
+	symbols := []string{"ETH", "USDC", "DAI"}
+	for _, token := range preferences.FavoriteTokens {
+		if !slices.Contains(symbols, token) {
+			symbols = append(symbols, token)
+		}
+	}
+	results := ce.dataCollector.CollectMarketData(ctx, symbols)
+	marketData, unpriced := results.Data(), results.Failed()
+	money := ce.displayRate(ctx, preferences)
+	numbers := money.Numbers()
+
+	var responseText strings.Builder
+	responseText.WriteString("📈 **Market Data**\n\n")
+	
+	for _, data := range marketData {
+		changeEmoji := "➡️"
+		if data.Change24h > 0 {
+			changeEmoji = "📈"
+		} else if data.Change24h < 0 {
+			changeEmoji = "📉"
+		}
+		
+		responseText.WriteString(fmt.Sprintf("%s **%s**: %s (%s)\n", changeEmoji, data.Symbol, money.Format(data.Price), numbers.Change(data.Change24h, 2)))
+		responseText.WriteString(fmt.Sprintf("   24h Volume: %s\n", money.FormatCompact(data.Volume24h)))
+		responseText.WriteString(fmt.Sprintf("   Market Cap: %s\n\n", money.FormatCompact(data.MarketCap)))
+	}
+	// Symbols that failed are named rather than left out
+	responseText.WriteString(describeUnpriced(unpriced))
+
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+		"conversion": money,
+	}
+	if len(unpriced) > 0 {
+		metadata["unpriced"] = unpriced
+	}
+	return &ChatResponse{
+		Response: responseText.String(),
+		Type:     "market_data",
+		Data:     marketData,
+		Success:  true,
+		Metadata: metadata,
+		Attachments: ce.priceCharts(symbols, money, time.Now()),
+	}, nil
 }
 
 // handleGasInfoQuery handles gas-related queries
 func (ce *ChatEngine) handleGasInfoQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	if ce.fees != nil && isFeeSpendQuestion(message.Message) {
+		if address, ok := intentAddress(message, intent); ok {
+			return ce.handleFeeSpendQuery(ctx, message, intent, address)
+		}
+	}
+
 	// Get gas data
 	gasData, err := ce.dataCollector.CollectGasData(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to collect gas data: %w", err)
 	}
+	numbers := NewNumberFormat(ce.userPreferences(message.UserID).Locale)
 
 	responseText := fmt.Sprintf("⛽ **Gas Information**\n\n"+
 		"Current Gas Price: %d Gwei\n"+
 		"Fast Gas Price: %d Gwei\n"+
 		"Standard Gas Price: %d Gwei\n"+
 		"Slow Gas Price: %d Gwei\n"+
-		"Gas Utilization: %.1f%%\n\n"+
+		"Gas Utilization: %s\n\n"+
 		"💡 Tip: Use the slow gas price for non-urgent transactions to save on fees!",
 		gasData["current_gas_price"].(uint64)/1e9,
 		gasData["fast_gas_price"].(uint64)/1e9,
 		gasData["standard_gas_price"].(uint64)/1e9,
 		gasData["slow_gas_price"].(uint64)/1e9,
-		gasData["gas_utilization"].(float64)*100)
+		numbers.Percent(gasData["gas_utilization"].(float64)*100, 1))
+
+	if ce.congestion != nil {
+		if report := ce.congestion.Report(ChatCongestionWindow); report.Blocks > 0 {
+			responseText += "\n\n" + congestionText(report, numbers)
+			gasData["congestion"] = report
+		}
+	}
 
 	return &ChatResponse{
 		Response: responseText,
@@ -485,6 +1503,699 @@ func (ce *ChatEngine) handleGasInfoQuery(ctx context.Context, message *ChatMessa
 			"confidence": intent.Confidence,
 			"intent":     intent.Intent,
 		},
+		Attachments: ce.gasChart(time.Now()),
+	}, nil
+}
+
+// congestionText describes recent congestion for gas answers
+func congestionText(report *CongestionReport, numbers NumberFormat) string {
+	return fmt.Sprintf("🚦 **Congestion**: %s.\n"+
+		"Blocks were %s full on average over the last %s, %s of them over %s full.",
+		report.Summary, numbers.Percent(report.AvgUtilization*100, 0), strings.TrimSuffix(report.Window, "0m0s"),
+		numbers.Percent(report.FullBlockRatio*100, 0), numbers.Percent(FullBlockUtilization*100, 0))
+}
+
+// isFeeSpendQuestion reports whether the message asks how much was spent on gas
+func isFeeSpendQuestion(message string) bool {
+	message = strings.ToLower(message)
+	for _, keyword := range []string{"spent", "spend", "paid", "pay"} {
+		if strings.Contains(message, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// questionPeriod returns the start of the period a question asks about and
+// how to describe it: this month, today, this week, the last N days, or the
+// last 30 days by default
+func questionPeriod(message string, now time.Time) (time.Time, string) {
+	message = strings.ToLower(message)
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch {
+	case strings.Contains(message, "this month"):
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), "this month"
+	case strings.Contains(message, "today"):
+		return today, "today"
+	case strings.Contains(message, "this week"):
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7), "this week"
+	}
+
+	if match := lastDaysRegex.FindStringSubmatch(message); match != nil {
+		var days int
+		fmt.Sscanf(match[1], "%d", &days)
+		if days > 0 && days <= 90 {
+			return now.AddDate(0, 0, -days), fmt.Sprintf("in the last %d days", days)
+		}
+	}
+	return now.AddDate(0, 0, -30), "in the last 30 days"
+}
+
+var lastDaysRegex = regexp.MustCompile(`(?:last|past) (\d+) days`)
+
+// handleFeeSpendQuery answers how much an address has spent on gas
+func (ce *ChatEngine) handleFeeSpendQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent, address common.Address) (*ChatResponse, error) {
+	since, period := questionPeriod(message.Message, time.Now())
+
+	report, task, err := ce.fees.FeeSpend(ctx, address, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute fee spend: %w", err)
+	}
+
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+
+	if report == nil {
+		return &ChatResponse{
+			Response: fmt.Sprintf("⛽ I'm still indexing the transactions of %s for this period. "+
+				"Ask again in a minute and I'll have your gas spend %s.", address.Hex(), period),
+			Type:     "gas_spend",
+			Data:     map[string]interface{}{"task": task},
+			Success:  true,
+			Metadata: metadata,
+		}, nil
+	}
+
+	money := ce.displayRate(ctx, ce.userPreferences(message.UserID))
+	metadata["conversion"] = money
+	text := formatFeeSpend(report, period, money)
+	if money.Currency != "USD" {
+		report = report.InCurrency(money)
+	}
+	return &ChatResponse{
+		Response: text,
+		Type:     "gas_spend",
+		Data:     report,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// formatFeeSpend renders a fee spend report for chat with fees in the
+// display currency
+func formatFeeSpend(report *FeeSpendReport, period string, money ConversionRate) string {
+	numbers := money.Numbers()
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("⛽ **Gas Spend %s**\n\n", period))
+	text.WriteString(fmt.Sprintf("Address: %s\n", report.Address))
+	text.WriteString(fmt.Sprintf("Transactions: %d\n", report.TxCount))
+	text.WriteString(fmt.Sprintf("Total Fees: %s %s (%s)\n", numbers.Token(report.TotalFee, priceOf(report.TotalFeeUSD, report.TotalFee)), report.Symbol, money.Format(report.TotalFeeUSD)))
+
+	if len(report.ByContract) > 0 {
+		text.WriteString("\nTop destinations:\n")
+		for i, contract := range report.ByContract {
+			if i == 3 {
+				break
+			}
+			name := contract.Address
+			if contract.Label != "" {
+				name = contract.Label
+			}
+			text.WriteString(fmt.Sprintf("- %s: %s %s (%s) over %d txs\n", name, numbers.Token(contract.Fee, priceOf(contract.FeeUSD, contract.Fee)), report.Symbol, money.Format(contract.FeeUSD), contract.TxCount))
+		}
+	}
+	switch {
+	case report.Truncated && report.Coverage != nil:
+		text.WriteString(fmt.Sprintf("\n⚠️ This period spans more blocks than I index at once, so only transactions since %s are counted so far. Ask again later for the rest.\n",
+			report.Coverage.From.UTC().Format("2 Jan 2006 15:04 UTC")))
+	case report.Truncated:
+		text.WriteString("\n⚠️ This period spans more blocks than I index at once, so only its latest transactions are counted so far. Ask again later for the rest.\n")
+	case !report.Complete:
+		text.WriteString("\n⚠️ Not all transactions in this period are indexed yet, so the total may be incomplete.\n")
+	}
+	return text.String()
+}
+
+// handleTxExplain explains the first transaction hash in the message
+func (ce *ChatEngine) handleTxExplain(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	if ce.transactions == nil {
+		return ce.handleGeneralQuery(ctx, message, intent)
+	}
+
+	hashes, _ := intent.Entities["tx_hashes"].([]string)
+	if len(hashes) == 0 {
+		return ce.handleGeneralQuery(ctx, message, intent)
+	}
+
+	explanation, err := ce.transactions.Explain(ctx, common.HexToHash(hashes[0]))
+	var notFound *TxNotFoundError
+	if errors.As(err, &notFound) {
+		text := fmt.Sprintf("🔎 I couldn't find transaction %s on %s.", notFound.Hash, notFound.Network)
+		if notFound.OtherNetwork != "" {
+			text += fmt.Sprintf(" It may have been sent on %s instead, so try looking it up there.", notFound.OtherNetwork)
+		}
+		text += " If it was only just broadcast, it may not have reached the node yet."
+		return &ChatResponse{
+			Response: text,
+			Type:     "tx_explain",
+			Data:     notFound,
+			Success:  false,
+			Metadata: metadata,
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain transaction: %w", err)
+	}
+
+	return &ChatResponse{
+		Response: "🧾 **Transaction Explained**\n\n" + explanation.Summary,
+		Type:     "tx_explain",
+		Data:     explanation,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// handleStakingQuery recommends the validator that best fits the user's risk
+// tolerance: the lowest commission among those with enough uptime, or the
+// highest APR when the message asks about rewards
+func (ce *ChatEngine) handleStakingQuery(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	if ce.staking == nil {
+		return ce.handleGeneralQuery(ctx, message, intent)
+	}
+
+	validators, err := ce.staking.Validators(ValidatorSortAPR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list validators: %w", err)
+	}
+	if len(validators) == 0 {
+		return &ChatResponse{
+			Response: "🥩 I don't have validator data yet. Please try again in a few minutes.",
+			Type:     "staking",
+			Success:  false,
+			Metadata: metadata,
+		}, nil
+	}
+
+	preferences := ce.userPreferences(message.UserID)
+	lower := strings.ToLower(message.Message)
+	preferRewards := strings.Contains(lower, "apr") || strings.Contains(lower, "reward") || strings.Contains(lower, "return")
+	recommendation, ok := RecommendValidator(validators, preferences.RiskTolerance, preferRewards)
+	numbers := NewNumberFormat(preferences.Locale)
+
+	var responseText strings.Builder
+	responseText.WriteString("🥩 **Staking Validators**\n\n")
+	if ok {
+		validator := recommendation.Validator
+		criterion := "the lowest commission"
+		if recommendation.Rule == ValidatorSortAPR {
+			criterion = "the highest APR"
+		}
+		responseText.WriteString(fmt.Sprintf("For your %s risk tolerance I'd stake with **%s** (%s): %s commission, %s uptime, %s APR (7d avg %s), %s KAIA staked.\n",
+			preferences.RiskTolerance, validator.Name, shortAddress(validator.Address), numbers.Percent(validator.Commission*100, 1), numbers.Percent(validator.Uptime*100, 2),
+			numbers.Percent(validator.APR*100, 2), numbers.Percent(validator.APR7dAvg*100, 2), numbers.Compact(validator.TotalStaked)))
+		responseText.WriteString(fmt.Sprintf("It has %s of the %d validators with at least %s uptime.\n\n",
+			criterion, recommendation.Eligible, numbers.Percent(recommendation.MinUptime*100, 1)))
+	} else {
+		responseText.WriteString(fmt.Sprintf("No validator currently meets the %s uptime your %s risk tolerance calls for.\n\n",
+			numbers.Percent(ValidatorMinUptime[preferences.RiskTolerance]*100, 1), preferences.RiskTolerance))
+	}
+
+	responseText.WriteString("Top validators by APR:\n")
+	for i, validator := range validators {
+		if i >= 3 {
+			break
+		}
+		responseText.WriteString(fmt.Sprintf("%d. %s: %s APR, %s commission, %s uptime\n",
+			i+1, validator.Name, numbers.Percent(validator.APR*100, 2), numbers.Percent(validator.Commission*100, 1), numbers.Percent(validator.Uptime*100, 2)))
+	}
+
+	return &ChatResponse{
+		Response: responseText.String(),
+		Type:     "staking",
+		Data: map[string]interface{}{
+			"recommendation": recommendation,
+			"validators":     validators,
+		},
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// stakingPositionRegex matches questions about the user's own stakes
+var stakingPositionRegex = regexp.MustCompile(`\b(?:when|how long)\b.*\b(?:unstake|unlock|withdraw)|\bmy (?:stakes?|staking positions?|staked)\b`)
+
+// handleStakingPosition answers when the user's stakes unlock and what they
+// have earned, from the positions tracked from staking pool events
+func (ce *ChatEngine) handleStakingPosition(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	address, ok := intentAddress(message, intent)
+	if ce.positions == nil || !ok {
+		return ce.handleGeneralQuery(ctx, message, intent)
+	}
+
+	positions := ce.positions.Positions(ctx, address)
+	if len(positions) == 0 {
+		return &ChatResponse{
+			Response: fmt.Sprintf("🥩 I don't see any open staking positions for %s in the pools I track.", shortAddress(address.Hex())),
+			Type:     "staking_positions",
+			Data:     positions,
+			Success:  true,
+			Metadata: metadata,
+		}, nil
+	}
+
+	numbers := NewNumberFormat(ce.userPreferences(message.UserID).Locale)
+	var text strings.Builder
+	text.WriteString("🥩 **Your Staking Positions**\n\n")
+	for _, position := range positions {
+		text.WriteString(fmt.Sprintf("**%s KAIA** in %s, staked since %s\n", numbers.Compact(position.Principal), shortAddress(position.Pool), position.StakedAt.Format("Jan 2, 2006")))
+		switch {
+		case position.Unlocked:
+			text.WriteString("- 🔓 Unlocked: you can unstake now\n")
+		default:
+			text.WriteString(fmt.Sprintf("- 🔒 You can unstake on %s, in %s\n",
+				position.LockExpiry.Format("Jan 2, 2006 15:04 MST"), formatAge(time.Duration(position.UnlockInSeconds)*time.Second)))
+		}
+		rewards := "estimated at %s APY"
+		if position.RewardsOnChain {
+			rewards = "pending on chain, at %s APY"
+		}
+		text.WriteString(fmt.Sprintf("- 💰 %s KAIA in rewards, "+rewards+"\n", numbers.Token(position.AccruedRewards, 0), numbers.Percent(position.RewardRate, 2)))
+	}
+
+	return &ChatResponse{
+		Response: text.String(),
+		Type:     "staking_positions",
+		Data:     positions,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// cancelActionRegex matches a request to take back an action
+var cancelActionRegex = regexp.MustCompile(`\b(cancel|undo)\b`)
+
+// actionIDRegex matches the ID of a chat-initiated action
+var actionIDRegex = regexp.MustCompile(`\baction_\d+\b`)
+
+// handleCancelAction cancels the action named in the message or, for "cancel
+// that", the user's most recent one
+func (ce *ChatEngine) handleCancelAction(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	reply := func(text string) *ChatResponse {
+		return &ChatResponse{Response: text, Type: "action_result", Success: false, Metadata: metadata}
+	}
+	if ce.priceAlerts != nil && strings.Contains(strings.ToLower(message.Message), "alert") {
+		return ce.cancelPriceAlert(message, metadata)
+	}
+	if ce.actions == nil {
+		return reply("↩️ Actions are executed as soon as you confirm them, so there is nothing to cancel."), nil
+	}
+	// Anonymous senders share a user, so none of them can cancel its actions
+	if message.UserID == "" || message.UserID == ChatAnonymousUser {
+		return reply("↩️ I need to know who you are to cancel an action; sign in with your wallet first."), nil
+	}
+
+	id := actionIDRegex.FindString(strings.ToLower(message.Message))
+	if id == "" {
+		latest, ok := ce.actions.Latest(message.UserID)
+		if !ok {
+			return reply("↩️ You have no recent action to cancel."), nil
+		}
+		id = latest
+	}
+	metadata["action_id"] = id
+
+	cancellation, err := ce.actions.Cancel(ctx, message.UserID, id)
+	var tooLate *ActionTooLateError
+	switch {
+	case errors.Is(err, ErrActionNotFound), errors.Is(err, ErrActionForbidden):
+		return reply(fmt.Sprintf("↩️ I couldn't find action %s among your actions.", id)), nil
+	case errors.As(err, &tooLate):
+		if tooLate.TxHash == "" {
+			return reply(fmt.Sprintf("↩️ Too late: action %s is already %s.", id, tooLate.Status)), nil
+		}
+		return reply(fmt.Sprintf("↩️ Too late: action %s was already submitted in transaction %s.", id, tooLate.TxHash)), nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to cancel action: %w", err)
+	}
+
+	responseText := fmt.Sprintf("↩️ **Action Cancelled**\n\nYour %s action %s won't be submitted.", cancellation.Action.ActionType, id)
+	if cancellation.ReplacementTxHash != "" {
+		responseText = fmt.Sprintf("↩️ **Action Cancelled**\n\nYour %s action had been broadcast, so it was replaced with a no-op transaction: %s",
+			cancellation.Action.ActionType, cancellation.ReplacementTxHash)
+	}
+	return &ChatResponse{
+		Response: responseText,
+		Type:     "action_result",
+		Data:     cancellation,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// priceAlertIDRegex matches the ID of a price alert
+var priceAlertIDRegex = regexp.MustCompile(`\balert_[0-9a-f]+\b`)
+
+// handlePriceAlert sets a price alert for the sender, or asks what is missing
+// from the request
+func (ce *ChatEngine) handlePriceAlert(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	reply := func(text string) *ChatResponse {
+		return &ChatResponse{Response: text, Type: "price_alert", Success: false, Metadata: metadata}
+	}
+	if ce.priceAlerts == nil {
+		return reply("🔔 Price alerts aren't available right now."), nil
+	}
+	if message.UserID == "" || message.UserID == ChatAnonymousUser {
+		return reply("🔔 I need to know who you are to set an alert; sign in with your wallet first."), nil
+	}
+
+	spec, err := ParsePriceAlert(message.Message)
+	var ambiguous *AmbiguousAlertError
+	if errors.As(err, &ambiguous) {
+		metadata["clarification"] = true
+		return reply("🔔 " + ambiguous.Question), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price alert: %w", err)
+	}
+
+	alert, err := ce.priceAlerts.Create(message.UserID, message.ID, message.Message, spec)
+	switch {
+	case errors.Is(err, ErrNoLivePrice):
+		return reply(fmt.Sprintf("🔔 I don't have a live price for %s, so I can't watch it.", spec.Symbol)), nil
+	case errors.Is(err, ErrPriceAlertLimit):
+		return reply(fmt.Sprintf("🔔 You already have %d active price alerts; cancel one before setting another.", MaxPriceAlertsPerUser)), nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to create price alert: %w", err)
+	}
+	metadata["alert_id"] = alert.ID
+	numbers := NewNumberFormat(ce.userPreferences(message.UserID).Locale)
+
+	return &ChatResponse{
+		Response: fmt.Sprintf("🔔 **Price Alert Set**\n\nI'll tell you here when %s. %s is at $%s now.\n\nTo cancel it, say \"cancel %s\".",
+			alert.Describe(), alert.Symbol, numbers.Token(alert.BasePrice, 1), alert.ID),
+		Type:     "price_alert",
+		Data:     alert,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// handleScheduleAction sets up a recurring action for the sender. Each
+// occurrence is proposed for the user to confirm, or run when they asked for
+// it to happen automatically and spending limits are in place.
+func (ce *ChatEngine) handleScheduleAction(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	reply := func(text string) *ChatResponse {
+		return &ChatResponse{Response: text, Type: "action_schedule", Success: false, Metadata: metadata}
+	}
+	if ce.schedules == nil {
+		return reply("⏰ Recurring actions aren't available right now."), nil
+	}
+	// Schedules run actions for their user's wallet, so they need a signed-in one
+	if !common.IsHexAddress(message.UserID) {
+		return reply("⏰ I need to know who you are to schedule an action; sign in with your wallet first."), nil
+	}
+
+	spec, _, err := ParseRecurrence(message.Message, time.Now())
+	switch {
+	case errors.Is(err, ErrScheduleDayOfMonth):
+		return reply("⏰ Pick a day between the 1st and the 28th, so the action runs every month."), nil
+	case errors.Is(err, ErrScheduleTime):
+		return reply("⏰ I couldn't read that time; say something like \"at 9am\" or \"at 14:30\" (UTC)."), nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to parse recurrence: %w", err)
+	}
+
+	action := scheduledActionText(message.Message)
+	autoExecute := wantsAutoExecute(message.Message)
+	note := ""
+	if autoExecute && ce.spending == nil {
+		autoExecute = false
+		note = "\n\nI can only run actions automatically within spending limits, and none are set, so I'll ask you each time."
+	}
+	schedule, err := ce.schedules.Create(message.UserID, action, ce.extractActionType(action), spec, autoExecute)
+	switch {
+	case errors.Is(err, ErrActionScheduleLimit):
+		return reply(fmt.Sprintf("⏰ You already have %d recurring actions; delete one before adding another.", MaxActionSchedulesPerUser)), nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to create action schedule: %w", err)
+	}
+	metadata["schedule_id"] = schedule.ID
+
+	how := "I'll ask you to confirm it each time."
+	if schedule.AutoExecute {
+		how = "I'll run it automatically, within your spending limits, and tell you how it went."
+	}
+	return &ChatResponse{
+		Response: fmt.Sprintf("⏰ **Recurring Action Scheduled**\n\n\"%s\" %s, first on %s. %s "+
+			"It pauses if it fails %d times in a row.%s",
+			schedule.Action, schedule.Description, schedule.NextRunAt.Format("Mon 2 Jan 15:04 UTC"), how, MaxScheduleFailures, note),
+		Type:     "action_schedule",
+		Data:     schedule,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// cancelPriceAlert cancels the sender's alert named in the message, or their
+// only alert
+func (ce *ChatEngine) cancelPriceAlert(message *ChatMessage, metadata map[string]interface{}) (*ChatResponse, error) {
+	reply := func(text string) *ChatResponse {
+		return &ChatResponse{Response: text, Type: "price_alert", Success: false, Metadata: metadata}
+	}
+
+	id := priceAlertIDRegex.FindString(strings.ToLower(message.Message))
+	if id == "" {
+		alerts := ce.priceAlerts.Alerts(message.UserID)
+		switch len(alerts) {
+		case 0:
+			return reply("🔔 You have no active price alerts."), nil
+		case 1:
+			id = alerts[0].ID
+		default:
+			var text strings.Builder
+			text.WriteString("🔔 Which alert should I cancel? Say \"cancel\" with its ID:\n\n")
+			for _, alert := range alerts {
+				text.WriteString(fmt.Sprintf("• %s: when %s\n", alert.ID, alert.Describe()))
+			}
+			return reply(text.String()), nil
+		}
+	}
+	metadata["alert_id"] = id
+
+	alert, err := ce.priceAlerts.Cancel(message.UserID, id)
+	if err != nil {
+		return reply(fmt.Sprintf("🔔 I couldn't find alert %s among your active alerts.", id)), nil
+	}
+	return &ChatResponse{
+		Response: fmt.Sprintf("🔔 **Price Alert Cancelled**\n\nI won't tell you when %s.", alert.Describe()),
+		Type:     "price_alert",
+		Data:     alert,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// handleProtocolCompare compares two protocols of the registry side by side,
+// or asks which protocol was meant when only one is recognized
+func (ce *ChatEngine) handleProtocolCompare(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	registry := ce.analyticsEngine.Protocols()
+	matches, _ := intent.Entities["protocols"].([]ProtocolMatch)
+	if len(matches) < 2 {
+		suggestions := NearProtocols(registry, message.Message, matches)
+		metadata["clarification"] = true
+		text := "⚖️ Which two protocols should I compare?"
+		if len(matches) == 1 {
+			text = fmt.Sprintf("⚖️ I recognized **%s**, but not the protocol to compare it with.", matches[0].Name)
+		}
+		if len(suggestions) > 0 {
+			text += " Did you mean " + strings.Join(suggestions, ", ") + "?"
+		}
+		return &ChatResponse{
+			Response: text,
+			Type:     "protocol_compare",
+			Data: map[string]interface{}{
+				"recognized":  matches,
+				"suggestions": suggestions,
+			},
+			Success:  false,
+			Metadata: metadata,
+		}, nil
+	}
+
+	result, err := ce.runAnalyticsTask(ctx, "yield_analysis", map[string]interface{}{
+		"user_address": message.UserID,
+		"query":        message.Message,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze yield opportunities: %w", err)
+	}
+	opportunities := result.Data.([]YieldOpportunity)
+	now := time.Now()
+	profiles := make([]ProtocolProfile, 2)
+	for i, match := range matches[:2] {
+		info := ProtocolInfo{Name: match.Name}
+		// The registry may have been reloaded since the message was parsed
+		if known := registry.Protocol(match.Name); known != nil {
+			info = *known
+		}
+		profiles[i] = ProfileProtocol(info, opportunities, now)
+	}
+	comparison := CompareProtocols(profiles[0], profiles[1])
+	money := ce.displayRate(ctx, ce.userPreferences(message.UserID))
+	numbers := money.Numbers()
+
+	var responseText strings.Builder
+	responseText.WriteString(fmt.Sprintf("⚖️ **%s vs %s**\n\n", comparison.A.Protocol, comparison.B.Protocol))
+	for _, profile := range profiles {
+		responseText.WriteString(fmt.Sprintf("**%s**\n", profile.Protocol))
+		if profile.Pools == 0 {
+			responseText.WriteString("   No pools in the latest yield scan\n")
+		} else {
+			responseText.WriteString(fmt.Sprintf("   Risk Score: %s/100 over %d pools\n", numbers.Decimal(profile.RiskScore, 0), profile.Pools))
+			responseText.WriteString(fmt.Sprintf("   TVL: %s (%s over 7 days)\n", money.FormatCompact(profile.TVL), numbers.Change(profile.TVLTrend, 1)))
+		}
+		audit := describeAudit(profile.Audited)
+		if profile.AgeDays != nil {
+			audit += fmt.Sprintf(", live for %d days", *profile.AgeDays)
+		}
+		responseText.WriteString(fmt.Sprintf("   Audit: %s\n", audit))
+		responseText.WriteString(fmt.Sprintf("   Revenue: %s\n\n", describeFeeShare(profile)))
+	}
+
+	switch {
+	case comparison.Safer != "":
+		var reasons []string
+		for _, factor := range comparison.Factors {
+			if factor.Safer == comparison.Safer {
+				reasons = append(reasons, factor.Factor)
+			}
+		}
+		responseText.WriteString(fmt.Sprintf("**%s** looks safer", comparison.Safer))
+		if len(reasons) > 0 {
+			responseText.WriteString(", with lower risk on " + strings.Join(reasons, ", "))
+		}
+		responseText.WriteString(".\n")
+	case profiles[0].Pools == 0 || profiles[1].Pools == 0:
+		responseText.WriteString("I can't score both protocols' risk until their pools are scanned.\n")
+	default:
+		responseText.WriteString(fmt.Sprintf("Their risk scores are within %s points, so neither is clearly safer.\n", numbers.Decimal(comparableRiskPoints, 0)))
+	}
+	responseText.WriteString(qualityNote(result.DataQuality))
+	metadata["data_quality"] = result.DataQuality
+	metadata["conversion"] = money
+
+	return &ChatResponse{
+		Response: responseText.String(),
+		Type:     "protocol_compare",
+		Data:     comparison,
+		Success:  true,
+		Metadata: metadata,
+	}, nil
+}
+
+// newTokensRegex matches questions about newly listed tokens
+var newTokensRegex = regexp.MustCompile(`\bnew (?:tokens?|coins?|listings?)\b|\b(?:newly|recently|just) (?:listed|launched|deployed)\b|\b(?:token|coin) listings?\b`)
+
+// maxNewTokensShown is how many new tokens a chat answer names
+const maxNewTokensShown = 5
+
+// newTokensWindow reads the period a new tokens question asks about,
+// a week unless it says otherwise
+func newTokensWindow(message string) (time.Duration, string) {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "today") || strings.Contains(lower, "24h") || strings.Contains(lower, "24 hours"):
+		return 24 * time.Hour, "24 hours"
+	case strings.Contains(lower, "month") || strings.Contains(lower, "30 days") || strings.Contains(lower, "30d"):
+		return 30 * 24 * time.Hour, "30 days"
+	default:
+		return 7 * 24 * time.Hour, "7 days"
+	}
+}
+
+// handleNewTokens summarizes the notable tokens listed recently, by initial
+// liquidity, leaving out spam and thin launches
+func (ce *ChatEngine) handleNewTokens(ctx context.Context, message *ChatMessage, intent *QueryIntent) (*ChatResponse, error) {
+	metadata := map[string]interface{}{
+		"confidence": intent.Confidence,
+		"intent":     intent.Intent,
+	}
+	if ce.listings == nil {
+		return &ChatResponse{
+			Response: "🆕 I'm not tracking new token listings right now.",
+			Type:     "new_tokens",
+			Success:  false,
+			Metadata: metadata,
+		}, nil
+	}
+
+	window, period := newTokensWindow(message.Message)
+	since := time.Now().Add(-window)
+	minLiquidity := ce.listings.MinLiquidity()
+	notable := ce.listings.NewTokens(since, minLiquidity, false)
+	hidden := len(ce.listings.NewTokens(since, 0, true)) - len(notable)
+	sort.SliceStable(notable, func(i, j int) bool {
+		return notable[i].LiquidityUSD() > notable[j].LiquidityUSD()
+	})
+	money := ce.displayRate(ctx, ce.userPreferences(message.UserID))
+	metadata["conversion"] = money
+	metadata["hidden"] = hidden
+
+	var responseText strings.Builder
+	responseText.WriteString(fmt.Sprintf("🆕 **New Tokens (last %s)**\n\n", period))
+	if len(notable) == 0 {
+		responseText.WriteString(fmt.Sprintf("No new token reached %s of initial liquidity.", money.FormatCompact(minLiquidity)))
+	}
+	for i, listing := range notable {
+		if i >= maxNewTokensShown {
+			break
+		}
+		name := listing.Symbol
+		if listing.Name != "" && listing.Name != listing.Symbol {
+			name = fmt.Sprintf("%s (%s)", listing.Symbol, listing.Name)
+		}
+		responseText.WriteString(fmt.Sprintf("%d. **%s** listed %s\n", i+1, name, listing.ListedAt.Format("Jan 2 15:04 UTC")))
+		if listing.Liquidity != nil {
+			responseText.WriteString(fmt.Sprintf("   %s initial liquidity in %s\n", money.FormatCompact(listing.Liquidity.ValueUSD), listing.Liquidity.Pair))
+		}
+		responseText.WriteString(fmt.Sprintf("   Deployed by %s\n", shortAddress(listing.Deployer.Hex())))
+	}
+	if len(notable) > maxNewTokensShown {
+		responseText.WriteString(fmt.Sprintf("\n…and %d more.", len(notable)-maxNewTokensShown))
+	}
+	if hidden > 0 {
+		responseText.WriteString(fmt.Sprintf("\n%d more were hidden as spam or with less than %s of liquidity.", hidden, money.FormatCompact(minLiquidity)))
+	}
+
+	return &ChatResponse{
+		Response: responseText.String(),
+		Type:     "new_tokens",
+		Data:     notable,
+		Success:  true,
+		Metadata: metadata,
 	}, nil
 }
 
@@ -493,7 +2204,7 @@ func (ce *ChatEngine) handleGeneralQuery(ctx context.Context, message *ChatMessa
 	responseText := "Hello! I'm your Kaia Analytics AI assistant. I can help you with:\n\n" +
 		"🔍 **Analytics**: Yield opportunities, portfolio analysis, trading suggestions\n" +
 		"⚡ **Actions**: Staking, voting, swapping tokens\n" +
-		"📊 **Data**: Market prices, gas fees, network stats\n" +
+		"📊 **Data**: Market prices, price alerts, gas fees, network stats\n" +
 		"🗳️ **Governance**: Proposal analysis and voting\n\n" +
 		"Just ask me anything about DeFi, trading, or blockchain analytics!"
 
@@ -549,52 +2260,380 @@ func (ce *ChatEngine) extractActionParameters(message string) map[string]interfa
 	return parameters
 }
 
-// RegisterConnection registers a WebSocket connection
-func (ce *ChatEngine) RegisterConnection(userID string, conn *websocket.Conn) {
+// RegisterConnection registers a WebSocket connection and starts its writer
+// and heartbeat. Every write to the socket must go through the returned
+// connection; call it before reading from the socket. A user can hold
+// ChatMaxConnectionsPerUser connections, beyond which their least recently
+// active one is closed; anonymous connections belong to many people and are
+// only bounded by the ceiling. At the engine's connection ceiling the least
+// recently active anonymous connection is closed, or the least recently
+// active one when none is anonymous.
+func (ce *ChatEngine) RegisterConnection(userID string, conn *websocket.Conn) *ChatConnection {
+	connection := newChatConnection(ce, userID, conn)
+
 	ce.mu.Lock()
-	defer ce.mu.Unlock()
-	
-	ce.connections[userID] = conn
+	if userID == ChatAnonymousUser {
+		ce.anonymousSessions++
+		connection.session = fmt.Sprintf("%s#%d", ChatAnonymousUser, ce.anonymousSessions)
+	}
+	var replaced, evicted []*ChatConnection
+	if connections := ce.connections[userID]; userID != ChatAnonymousUser && len(connections) >= ChatMaxConnectionsPerUser {
+		victim := leastRecentlyActive(connections)
+		ce.removeConnection(victim)
+		replaced = append(replaced, victim)
+	}
+	for ce.connectionCount >= ce.maxConnections {
+		victim := ce.evictionCandidate()
+		if victim == nil {
+			break
+		}
+		ce.removeConnection(victim)
+		evicted = append(evicted, victim)
+	}
+
+	connections := ce.connections[userID]
+	if connections == nil {
+		connections = make(map[*ChatConnection]struct{})
+		ce.connections[userID] = connections
+	}
+	if len(connections) == 0 || connection.session != userID {
+		delete(ce.streams, connection.session)
+		ce.delivery.Connect(connection.session)
+	}
+	connections[connection] = struct{}{}
+	ce.connectionCount++
+	ce.mu.Unlock()
+
+	for _, victim := range replaced {
+		victim.evict(websocket.ClosePolicyViolation, "too many connections for this user")
+	}
+	for _, victim := range evicted {
+		victim.evict(websocket.CloseTryAgainLater, "server connection limit reached")
+	}
+
+	go connection.writeLoop()
+	return connection
 }
 
-// UnregisterConnection unregisters a WebSocket connection
+// UnregisterConnection closes the user's registered connections
 func (ce *ChatEngine) UnregisterConnection(userID string) {
+	ce.mu.RLock()
+	connections := make([]*ChatConnection, 0, len(ce.connections[userID]))
+	for connection := range ce.connections[userID] {
+		connections = append(connections, connection)
+	}
+	ce.mu.RUnlock()
+
+	for _, connection := range connections {
+		connection.Close()
+	}
+}
+
+// release forgets a closed connection
+func (ce *ChatEngine) release(connection *ChatConnection) {
 	ce.mu.Lock()
 	defer ce.mu.Unlock()
-	
-	delete(ce.connections, userID)
+
+	ce.removeConnection(connection)
+}
+
+// removeConnection forgets a connection. The user's stream filters and
+// delivery session are released along with their last connection; those of
+// an anonymous connection are dropped with it, as it can't be resumed.
+// Callers must hold ce.mu.
+func (ce *ChatEngine) removeConnection(connection *ChatConnection) {
+	connections := ce.connections[connection.userID]
+	if _, ok := connections[connection]; !ok {
+		return
+	}
+	delete(connections, connection)
+	ce.connectionCount--
+	if connection.session != connection.userID {
+		delete(ce.streams, connection.session)
+		ce.delivery.Forget(connection.session)
+	}
+	if len(connections) > 0 {
+		return
+	}
+	delete(ce.connections, connection.userID)
+	delete(ce.streams, connection.userID)
+	ce.delivery.Disconnect(connection.userID)
+}
+
+// evictionCandidate picks the connection to close at the connection
+// ceiling. Callers must hold ce.mu.
+func (ce *ChatEngine) evictionCandidate() *ChatConnection {
+	if victim := leastRecentlyActive(ce.connections[ChatAnonymousUser]); victim != nil {
+		return victim
+	}
+	var victim *ChatConnection
+	for _, connections := range ce.connections {
+		if candidate := leastRecentlyActive(connections); victim == nil || (candidate != nil && candidate.lastActive.Load() < victim.lastActive.Load()) {
+			victim = candidate
+		}
+	}
+	return victim
+}
+
+// Sequence numbers a frame written on a connection of the delivery session,
+// as returned by ChatConnection.Session, buffering it for a resume until the
+// client acks it
+func (ce *ChatEngine) Sequence(session string, message *ChatResponse) *ChatResponse {
+	return ce.delivery.Sequence(session, message)
+}
+
+// Ack acknowledges the frames the session received up to seq
+func (ce *ChatEngine) Ack(session string, seq uint64) {
+	ce.delivery.Ack(session, seq)
+}
+
+// Resume replays the frames the session missed after lastSeq to its new
+// connection, in order. Frames for the session wait until the replay is
+// done, so live delivery picks up after it. It returns ErrResumeGap when the
+// missed frames are no longer buffered.
+func (ce *ChatEngine) Resume(session string, conn FrameWriter, lastSeq uint64) error {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	frames, err := ce.delivery.Replay(session, lastSeq)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := conn.WriteJSON(frame); err != nil {
+			return fmt.Errorf("failed to replay frame %d: %w", frame.Seq, err)
+		}
+	}
+	return nil
+}
+
+// ResumeFailed is the frame telling a client its missed frames can't be
+// replayed, sequenced so the client can ack from it after refreshing
+func (ce *ChatEngine) ResumeFailed(session, messageID string, lastSeq uint64) *ChatResponse {
+	now := time.Now()
+	return ce.Sequence(session, &ChatResponse{
+		ID:            fmt.Sprintf("resume_%d", now.UnixNano()),
+		MessageID:     messageID,
+		Type:          ChatResumeFailedFrame,
+		Response:      "Some messages sent while you were away are no longer available. Refresh to catch up.",
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       false,
+		Metadata: map[string]interface{}{
+			"requested_seq": lastSeq,
+			"buffer_size":   ChatResumeBufferSize,
+		},
+	})
+}
+
+// SendToUser sends a message to every connection of the user. Addresses
+// match in any case.
+func (ce *ChatEngine) SendToUser(userID string, message *ChatResponse) error {
+	ce.mu.RLock()
+	defer ce.mu.RUnlock()
+
+	escaped := *message
+	escaped.Response = escapeDisplay(message.Response)
+
+	var messageBytes []byte
+	sent := false
+	for connected, connections := range ce.connections {
+		if !strings.EqualFold(connected, userID) {
+			continue
+		}
+		if messageBytes == nil {
+			var err error
+			if messageBytes, err = json.Marshal(ce.delivery.Sequence(userID, &escaped)); err != nil {
+				return fmt.Errorf("failed to marshal message: %w", err)
+			}
+		}
+		for conn := range connections {
+			if err := conn.queue(messageBytes); err != nil {
+				ce.logger.Printf("Failed to send message to user %s: %v", connected, err)
+				continue
+			}
+			sent = true
+		}
+	}
+	if !sent {
+		// Kept for the user to resume if they were connected recently
+		if messageBytes == nil {
+			ce.delivery.Hold(userID, &escaped)
+		}
+		return fmt.Errorf("user %s isn't connected", userID)
+	}
+	return nil
 }
 
 // BroadcastMessage broadcasts a message to all connected users
 func (ce *ChatEngine) BroadcastMessage(message *ChatResponse) error {
+	return ce.broadcast(message, "", "", nil)
+}
+
+// HandleStreamFrame applies a subscribe or unsubscribe frame to the
+// connections of the delivery session and answers with a subscriptions frame
+// listing its filters
+func (ce *ChatEngine) HandleStreamFrame(session string, frame StreamSubscription) *ChatResponse {
+	ce.mu.Lock()
+	filters := ce.streams[session]
+	if filters == nil {
+		filters = make(streamFilters)
+	}
+	var err error
+	if frame.Type != StreamSubscriptionsFrame {
+		err = filters.apply(frame)
+	}
+	if len(filters) > 0 {
+		ce.streams[session] = filters
+	} else {
+		delete(ce.streams, session)
+	}
+	subscriptions := filters.describe()
+	ce.mu.Unlock()
+
+	now := time.Now()
+	response := &ChatResponse{
+		ID:            fmt.Sprintf("subscriptions_%d", now.UnixNano()),
+		MessageID:     frame.ID,
+		Type:          StreamSubscriptionsFrame,
+		Response:      fmt.Sprintf("%d of %d filters active", subscriptions.Count, subscriptions.MaxFilters),
+		Data:          subscriptions,
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       err == nil,
+	}
+	if err != nil {
+		response.Response = err.Error()
+	}
+	return response
+}
+
+// broadcast sends a message to the connected users that want it. Users who
+// subscribed to the channel get it when their filters match the value; the
+// others when their preferences accept it, a nil accepts sending it to
+// everyone.
+func (ce *ChatEngine) broadcast(message *ChatResponse, channel, value string, accepts func(UserPreferences) bool) error {
 	ce.mu.RLock()
 	defer ce.mu.RUnlock()
+
+	escaped := *message
+	escaped.Response = escapeDisplay(message.Response)
+
+	for userID, connections := range ce.connections {
+		if userID != ChatAnonymousUser {
+			if err := ce.broadcastTo(userID, userID, connections, &escaped, channel, value, accepts); err != nil {
+				return err
+			}
+			continue
+		}
+		for conn := range connections {
+			if err := ce.broadcastTo(conn.session, userID, map[*ChatConnection]struct{}{conn: {}}, &escaped, channel, value, accepts); err != nil {
+				return err
+			}
+		}
+	}
 	
-	messageBytes, err := json.Marshal(message)
+	return nil
+}
+
+// broadcastTo sends a broadcast frame to the connections of one delivery
+// session, if its filters or its user's preferences want it. Callers must
+// hold ce.mu.
+func (ce *ChatEngine) broadcastTo(session, userID string, connections map[*ChatConnection]struct{}, message *ChatResponse, channel, value string, accepts func(UserPreferences) bool) error {
+	if subscribed, wanted := ce.streams[session].accepts(channel, value); subscribed {
+		if !wanted {
+			return nil
+		}
+	} else if accepts != nil && !accepts(ce.userPreferences(userID)) {
+		return nil
+	}
+	messageBytes, err := json.Marshal(ce.delivery.Sequence(session, message))
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
-	
-	for userID, conn := range ce.connections {
-		err := conn.WriteMessage(websocket.TextMessage, messageBytes)
-		if err != nil {
+	for conn := range connections {
+		if err := conn.queue(messageBytes); err != nil {
 			ce.logger.Printf("Failed to send message to user %s: %v", userID, err)
-			// Remove failed connection
-			go ce.UnregisterConnection(userID)
 		}
 	}
-	
 	return nil
 }
 
+// BroadcastAnomaly pushes an anomaly event for a freshly collected datapoint
+// to the connected users who want anomaly alerts
+func (ce *ChatEngine) BroadcastAnomaly(metric string, anomaly Anomaly) {
+	var numbers NumberFormat
+	now := time.Now()
+	err := ce.broadcast(&ChatResponse{
+		ID:   fmt.Sprintf("anomaly_%d", now.UnixNano()),
+		Type: "anomaly",
+		Response: fmt.Sprintf("⚠️ Unusual %s: %s (z-score %s against a recent mean of %s)",
+			metric, numbers.Compact(anomaly.Value), numbers.Decimal(anomaly.ZScore, 1), numbers.Compact(anomaly.Mean)),
+		Data: map[string]interface{}{
+			"metric":  metric,
+			"anomaly": anomaly,
+		},
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       true,
+	}, StreamAnomalies, metric, func(preferences UserPreferences) bool { return preferences.Notifications.Anomalies })
+	if err != nil {
+		ce.logger.Printf("Failed to broadcast anomaly for %s: %v", metric, err)
+	}
+}
+
+// BroadcastPrice pushes a live price update to the connected users who want
+// alerts for the symbol
+func (ce *ChatEngine) BroadcastPrice(price LivePrice) {
+	var numbers NumberFormat
+	text := fmt.Sprintf("%s: $%s on %s", price.Symbol, numbers.Token(price.Price, 1), price.Source)
+	if price.Diverged {
+		text += fmt.Sprintf(" (⚠️ %s away from the reference $%s)", numbers.Percent(price.Divergence*100, 1), numbers.Token(price.ReferencePrice, 1))
+	}
+
+	now := time.Now()
+	err := ce.broadcast(&ChatResponse{
+		ID:        fmt.Sprintf("price_%d", now.UnixNano()),
+		Type:      "price_update",
+		Response:  text,
+		Data:          map[string]interface{}{"price": price},
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       true,
+	}, StreamPrices, strings.ToUpper(price.Symbol), func(preferences UserPreferences) bool { return preferences.WantsPrice(price.Symbol) })
+	if err != nil {
+		ce.logger.Printf("Failed to broadcast price for %s: %v", price.Symbol, err)
+	}
+}
+
 // GetChatMetrics returns chat engine metrics
 func (ce *ChatEngine) GetChatMetrics() map[string]interface{} {
-	ce.mu.RLock()
-	defer ce.mu.RUnlock()
-	
+	connections := ce.ConnectionCounts()
+	snapshot := ce.metrics.Snapshot()
+	now := time.Now()
+
 	return map[string]interface{}{
-		"active_connections": len(ce.connections),
-		"total_users":        len(ce.connections),
-		"last_updated":       time.Now().Unix(),
+		"active_connections":     connections.Identified + connections.Anonymous,
+		"total_users":            connections.Users,
+		"identified_connections": connections.Identified,
+		"anonymous_connections":  connections.Anonymous,
+		"evicted_connections":    snapshot.EvictedConnections,
+		"total_messages":         snapshot.TotalMessages,
+		"parse_failures":         snapshot.ParseFailures,
+		"handler_errors":         snapshot.HandlerErrors,
+		"action_proposals":       snapshot.ActionProposals,
+		"action_confirmations":   snapshot.ActionConfirmations,
+		"reaped_connections":     snapshot.ReapedConnections,
+		"intent_counts":          snapshot.IntentCounts,
+		"latency_p50_ms":         snapshot.LatencyP50Ms,
+		"latency_p95_ms":         snapshot.LatencyP95Ms,
+		"top_intents_24h":        snapshot.TopIntents24h,
+		"last_updated":           NewAPITime(now),
+		"last_updated_unix":      now.Unix(),
 	}
-}
\ No newline at end of file
+}
+
+// MetricsSnapshot returns the raw chat instrumentation values
+func (ce *ChatEngine) MetricsSnapshot() ChatMetricsSnapshot {
+	return ce.metrics.Snapshot()
+}
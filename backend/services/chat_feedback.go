@@ -0,0 +1,260 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FeedbackTokenTTL is how long a response can be rated after it is sent
+	FeedbackTokenTTL = 7 * 24 * time.Hour
+	// FeedbackRetention is how long ratings are kept for the accuracy report
+	FeedbackRetention = 52 * 7 * 24 * time.Hour
+	// MaxFeedbackComment is the longest comment, in characters
+	MaxFeedbackComment = 1000
+
+	// maxPendingFeedback bounds the responses awaiting a rating; the oldest
+	// tokens are dropped past it
+	maxPendingFeedback = 10000
+	feedbackWeek       = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrFeedbackNotFound is returned for unknown or expired feedback tokens
+	ErrFeedbackNotFound = errors.New("feedback token not found")
+	// ErrFeedbackForbidden is returned when a user rates another user's response
+	ErrFeedbackForbidden = errors.New("response belongs to another user")
+	// ErrFeedbackDuplicate is returned when a response was already rated
+	ErrFeedbackDuplicate = errors.New("response was already rated")
+	// ErrInvalidRating is returned for ratings other than +1 and -1
+	ErrInvalidRating = errors.New("rating must be 1 or -1")
+)
+
+// ChatFeedback is a user's rating of a chat response, along with the
+// message and the intent it was classified as, for tuning the classifier
+type ChatFeedback struct {
+	Token      string  `json:"token"`
+	ResponseID string  `json:"response_id"`
+	MessageID  string  `json:"message_id"`
+	UserID     string  `json:"user_id"`
+	Intent     string  `json:"intent"`
+	Confidence float64 `json:"confidence"`
+	// Message is the sanitized text the intent was parsed from
+	Message  string  `json:"message"`
+	Rating   int     `json:"rating"`
+	Comment  string  `json:"comment,omitempty"`
+	IssuedAt APITime `json:"issued_at"`
+	RatedAt  APITime `json:"rated_at"`
+}
+
+// IntentAccuracy is how well an intent's responses were rated in a week
+type IntentAccuracy struct {
+	Intent string `json:"intent"`
+	// Week is the Monday the week starts on, in UTC
+	Week         APITime `json:"week"`
+	Ratings      int     `json:"ratings"`
+	Positive     int     `json:"positive"`
+	Negative     int     `json:"negative"`
+	PositiveRate float64 `json:"positive_rate"`
+}
+
+// ChatFeedbackStore hands out a feedback token with each chat response and
+// records the one rating each token allows
+type ChatFeedbackStore struct {
+	mu      sync.Mutex
+	pending map[string]*ChatFeedback
+	order   []string        // pending tokens, oldest first
+	rated   []*ChatFeedback // oldest first
+	used    map[string]bool // tokens of the rated responses
+	now     func() time.Time
+}
+
+// NewChatFeedbackStore creates an empty feedback store
+func NewChatFeedbackStore() *ChatFeedbackStore {
+	return &ChatFeedbackStore{
+		pending: make(map[string]*ChatFeedback),
+		used:    make(map[string]bool),
+		now:     utcNow,
+	}
+}
+
+// Issue creates the feedback token of a response to a message
+func (fs *ChatFeedbackStore) Issue(message *ChatMessage, response *ChatResponse, intent *QueryIntent) (string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate feedback token: %w", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := fs.now()
+	fs.prune(now)
+	token := "fb_" + id
+	fs.pending[token] = &ChatFeedback{
+		Token:      token,
+		ResponseID: response.ID,
+		MessageID:  message.ID,
+		UserID:     strings.ToLower(message.UserID),
+		Intent:     intent.Intent,
+		Confidence: intent.Confidence,
+		Message:    message.Message,
+		IssuedAt:   NewAPITime(now),
+	}
+	fs.order = append(fs.order, token)
+	for len(fs.order) > maxPendingFeedback {
+		delete(fs.pending, fs.order[0])
+		fs.order = fs.order[1:]
+	}
+	return token, nil
+}
+
+// Rate records the user's rating of the response a token was issued with.
+// Each token can be used once, and only by the user the response went to.
+func (fs *ChatFeedbackStore) Rate(token, userID string, rating int, comment string) (*ChatFeedback, error) {
+	if rating != 1 && rating != -1 {
+		return nil, ErrInvalidRating
+	}
+	comment, err := SanitizeChatMessage(comment, MaxFeedbackComment)
+	if err != nil && !errors.Is(err, ErrEmptyMessage) {
+		return nil, fmt.Errorf("invalid comment: %w", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := fs.now()
+	if fs.used[token] {
+		return nil, ErrFeedbackDuplicate
+	}
+	feedback, ok := fs.pending[token]
+	if !ok || now.Sub(feedback.IssuedAt.Time) > FeedbackTokenTTL {
+		return nil, ErrFeedbackNotFound
+	}
+	if !strings.EqualFold(feedback.UserID, userID) {
+		return nil, ErrFeedbackForbidden
+	}
+
+	delete(fs.pending, token)
+	feedback.Rating = rating
+	feedback.Comment = comment
+	feedback.RatedAt = NewAPITime(now)
+	fs.rated = append(fs.rated, feedback)
+	fs.used[token] = true
+	rated := *feedback
+	return &rated, nil
+}
+
+// Ratings returns the ratings given since a time, oldest first
+func (fs *ChatFeedbackStore) Ratings(since time.Time) []ChatFeedback {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	ratings := make([]ChatFeedback, 0, len(fs.rated))
+	for _, feedback := range fs.rated {
+		if !feedback.RatedAt.Before(since) {
+			ratings = append(ratings, *feedback)
+		}
+	}
+	return ratings
+}
+
+// IntentAccuracy aggregates the ratings given since a time into the share
+// of positive ratings per intent and week, newest week first
+func (fs *ChatFeedbackStore) IntentAccuracy(since time.Time) []IntentAccuracy {
+	type bucket struct {
+		intent string
+		week   time.Time
+	}
+	counts := make(map[bucket]*IntentAccuracy)
+	for _, feedback := range fs.Ratings(since) {
+		// Weeks count from the zero time, which fell on a Monday
+		key := bucket{feedback.Intent, feedback.RatedAt.Truncate(feedbackWeek)}
+		accuracy, ok := counts[key]
+		if !ok {
+			accuracy = &IntentAccuracy{Intent: key.intent, Week: NewAPITime(key.week)}
+			counts[key] = accuracy
+		}
+		accuracy.Ratings++
+		if feedback.Rating > 0 {
+			accuracy.Positive++
+		} else {
+			accuracy.Negative++
+		}
+	}
+
+	report := make([]IntentAccuracy, 0, len(counts))
+	for _, accuracy := range counts {
+		accuracy.PositiveRate = roundTo(float64(accuracy.Positive)/float64(accuracy.Ratings), 4)
+		report = append(report, *accuracy)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if !report[i].Week.Equal(report[j].Week.Time) {
+			return report[i].Week.After(report[j].Week.Time)
+		}
+		return report[i].Intent < report[j].Intent
+	})
+	return report
+}
+
+// UserData returns the user's ratings
+func (fs *ChatFeedbackStore) UserData(userID string) interface{} {
+	ratings := []ChatFeedback{}
+	for _, feedback := range fs.Ratings(time.Time{}) {
+		if strings.EqualFold(feedback.UserID, userID) {
+			ratings = append(ratings, feedback)
+		}
+	}
+	return ratings
+}
+
+// EraseUserData deletes the user's ratings and unused tokens and returns
+// how many ratings were removed
+func (fs *ChatFeedbackStore) EraseUserData(userID string) int {
+	userID = strings.ToLower(userID)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for token, feedback := range fs.pending {
+		if feedback.UserID == userID {
+			delete(fs.pending, token)
+		}
+	}
+	kept := fs.rated[:0]
+	for _, feedback := range fs.rated {
+		if feedback.UserID != userID {
+			kept = append(kept, feedback)
+			continue
+		}
+		delete(fs.used, feedback.Token)
+	}
+	removed := len(fs.rated) - len(kept)
+	fs.rated = kept
+	return removed
+}
+
+// prune drops expired tokens and ratings past retention. Callers must hold fs.mu.
+func (fs *ChatFeedbackStore) prune(now time.Time) {
+	expired := 0
+	for _, token := range fs.order {
+		feedback, ok := fs.pending[token]
+		if ok && now.Sub(feedback.IssuedAt.Time) <= FeedbackTokenTTL {
+			break
+		}
+		delete(fs.pending, token)
+		expired++
+	}
+	fs.order = fs.order[expired:]
+
+	dropped := 0
+	for dropped < len(fs.rated) && now.Sub(fs.rated[dropped].RatedAt.Time) > FeedbackRetention {
+		delete(fs.used, fs.rated[dropped].Token)
+		dropped++
+	}
+	fs.rated = fs.rated[dropped:]
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const feedbackUser = "0x00000000000000000000000000000000000000aa"
+
+// rateIntent issues a token for a message of the intent and rates it
+func rateIntent(t *testing.T, store *ChatFeedbackStore, intent string, rating int) {
+	t.Helper()
+	token, err := store.Issue(&ChatMessage{ID: "m", UserID: feedbackUser, Message: intent}, &ChatResponse{ID: "r"}, &QueryIntent{Intent: intent, Confidence: 0.8})
+	require.NoError(t, err)
+	_, err = store.Rate(token, feedbackUser, rating, "")
+	require.NoError(t, err)
+}
+
+func TestChatResponsesCarryFeedbackTokens(t *testing.T) {
+	engine := newTestChatEngine(t)
+	store := NewChatFeedbackStore()
+	engine.SetFeedbackStore(store)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "0x00000000000000000000000000000000000000AA", Message: "  What are the best yield farming options?"})
+	require.NoError(t, err)
+	require.NotEmpty(t, response.FeedbackToken)
+
+	// Only the user the response went to can rate it, in any case
+	_, err = store.Rate(response.FeedbackToken, "0x00000000000000000000000000000000000000bb", 1, "")
+	assert.ErrorIs(t, err, ErrFeedbackForbidden)
+	_, err = store.Rate(response.FeedbackToken, feedbackUser, 0, "")
+	assert.ErrorIs(t, err, ErrInvalidRating)
+
+	feedback, err := store.Rate(response.FeedbackToken, feedbackUser, -1, " wrong protocol ")
+	require.NoError(t, err)
+	assert.Equal(t, "yield_query", feedback.Intent)
+	assert.Equal(t, 0.85, feedback.Confidence)
+	assert.Equal(t, "What are the best yield farming options?", feedback.Message, "the sanitized text is kept")
+	assert.Equal(t, "wrong protocol", feedback.Comment)
+	assert.Equal(t, response.ID, feedback.ResponseID)
+
+	// One rating per token
+	_, err = store.Rate(response.FeedbackToken, feedbackUser, 1, "")
+	assert.ErrorIs(t, err, ErrFeedbackDuplicate)
+	_, err = store.Rate("fb_unknown", feedbackUser, 1, "")
+	assert.ErrorIs(t, err, ErrFeedbackNotFound)
+}
+
+func TestChatFeedbackTokensExpire(t *testing.T) {
+	clock := &testClock{now: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)}
+	store := NewChatFeedbackStore()
+	store.now = clock.Now
+
+	token, err := store.Issue(&ChatMessage{UserID: feedbackUser}, &ChatResponse{}, &QueryIntent{Intent: "gas_info"})
+	require.NoError(t, err)
+	clock.Advance(FeedbackTokenTTL + time.Second)
+	_, err = store.Rate(token, feedbackUser, 1, "")
+	assert.ErrorIs(t, err, ErrFeedbackNotFound)
+}
+
+func TestChatFeedbackIntentAccuracy(t *testing.T) {
+	// Monday, June 2nd 2025
+	clock := &testClock{now: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)}
+	store := NewChatFeedbackStore()
+	store.now = clock.Now
+
+	// First week: yield 3 of 4 positive, gas 0 of 1
+	for _, rating := range []int{1, 1, -1, 1} {
+		rateIntent(t, store, "yield_query", rating)
+	}
+	rateIntent(t, store, "gas_info", -1)
+	// Sunday night is still the first week
+	clock.Advance(6*24*time.Hour + 14*time.Hour)
+	rateIntent(t, store, "gas_info", 1)
+
+	// Second week: yield 1 of 2 positive
+	clock.Advance(2 * time.Hour)
+	rateIntent(t, store, "yield_query", 1)
+	rateIntent(t, store, "yield_query", -1)
+
+	firstWeek := NewAPITime(time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC))
+	secondWeek := NewAPITime(time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, []IntentAccuracy{
+		{Intent: "yield_query", Week: secondWeek, Ratings: 2, Positive: 1, Negative: 1, PositiveRate: 0.5},
+		{Intent: "gas_info", Week: firstWeek, Ratings: 2, Positive: 1, Negative: 1, PositiveRate: 0.5},
+		{Intent: "yield_query", Week: firstWeek, Ratings: 4, Positive: 3, Negative: 1, PositiveRate: 0.75},
+	}, store.IntentAccuracy(time.Time{}))
+
+	// Ratings before the window are left out
+	assert.Len(t, store.IntentAccuracy(secondWeek.Time), 1)
+
+	// Erasing the user drops their ratings from the report
+	assert.Equal(t, 8, store.EraseUserData(feedbackUser))
+	assert.Empty(t, store.IntentAccuracy(time.Time{}))
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	chatLatencyWindow = 500
+	chatIntentHours   = 24
+)
+
+// ChatMetrics instruments message processing in the chat engine. Counters are
+// atomic so the hot path never contends on a lock; latency samples and the
+// hourly intent rollup share a small mutex.
+type ChatMetrics struct {
+	totalMessages       atomic.Uint64
+	parseFailures       atomic.Uint64
+	handlerErrors       atomic.Uint64
+	actionProposals     atomic.Uint64
+	actionConfirmations atomic.Uint64
+	reapedConnections   atomic.Uint64
+	evictedConnections  atomic.Uint64
+
+	mu         sync.Mutex
+	intents    map[string]uint64
+	latencies  []time.Duration
+	nextSample int
+	hourly     [chatIntentHours]intentBucket
+	now        func() time.Time
+}
+
+// intentBucket holds intent counts for a single hour
+type intentBucket struct {
+	hour   int64
+	counts map[string]uint64
+}
+
+// IntentCount is the number of messages resolved to an intent
+type IntentCount struct {
+	Intent string `json:"intent"`
+	Count  uint64 `json:"count"`
+}
+
+// ChatMetricsSnapshot is a point-in-time view of the chat metrics
+type ChatMetricsSnapshot struct {
+	TotalMessages       uint64            `json:"total_messages"`
+	ParseFailures       uint64            `json:"parse_failures"`
+	HandlerErrors       uint64            `json:"handler_errors"`
+	ActionProposals     uint64            `json:"action_proposals"`
+	ActionConfirmations uint64            `json:"action_confirmations"`
+	ReapedConnections   uint64            `json:"reaped_connections"`
+	EvictedConnections  uint64            `json:"evicted_connections"`
+	IntentCounts        map[string]uint64 `json:"intent_counts"`
+	LatencyP50Ms        float64           `json:"latency_p50_ms"`
+	LatencyP95Ms        float64           `json:"latency_p95_ms"`
+	TopIntents24h       []IntentCount     `json:"top_intents_24h"`
+}
+
+// NewChatMetrics creates an empty metrics collector
+func NewChatMetrics() *ChatMetrics {
+	return &ChatMetrics{
+		intents:   make(map[string]uint64),
+		latencies: make([]time.Duration, 0, chatLatencyWindow),
+		now:       utcNow,
+	}
+}
+
+// RecordMessage counts an incoming message
+func (cm *ChatMetrics) RecordMessage() {
+	cm.totalMessages.Add(1)
+}
+
+// RecordParseFailure counts a message whose intent could not be parsed
+func (cm *ChatMetrics) RecordParseFailure() {
+	cm.parseFailures.Add(1)
+}
+
+// RecordHandlerError counts a handler that returned an error
+func (cm *ChatMetrics) RecordHandlerError() {
+	cm.handlerErrors.Add(1)
+}
+
+// RecordActionProposal counts an on-chain action proposed to a user
+func (cm *ChatMetrics) RecordActionProposal() {
+	cm.actionProposals.Add(1)
+}
+
+// RecordActionConfirmation counts an on-chain action that was confirmed
+func (cm *ChatMetrics) RecordActionConfirmation() {
+	cm.actionConfirmations.Add(1)
+}
+
+// RecordReapedConnection counts a connection closed for missing heartbeats
+func (cm *ChatMetrics) RecordReapedConnection() {
+	cm.reapedConnections.Add(1)
+}
+
+// RecordEvictedConnection counts a connection closed to make room for another
+func (cm *ChatMetrics) RecordEvictedConnection() {
+	cm.evictedConnections.Add(1)
+}
+
+// RecordIntent counts a resolved intent in the lifetime totals and the hourly rollup
+func (cm *ChatMetrics) RecordIntent(intent string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cm.intents[intent]++
+
+	hour := cm.now().Unix() / 3600
+	bucket := &cm.hourly[hour%chatIntentHours]
+	if bucket.hour != hour || bucket.counts == nil {
+		bucket.hour = hour
+		bucket.counts = make(map[string]uint64)
+	}
+	bucket.counts[intent]++
+}
+
+// RecordLatency records the end-to-end processing time of a message
+func (cm *ChatMetrics) RecordLatency(latency time.Duration) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if len(cm.latencies) < chatLatencyWindow {
+		cm.latencies = append(cm.latencies, latency)
+	} else {
+		cm.latencies[cm.nextSample] = latency
+	}
+	cm.nextSample = (cm.nextSample + 1) % chatLatencyWindow
+}
+
+// Snapshot returns the current metric values
+func (cm *ChatMetrics) Snapshot() ChatMetricsSnapshot {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	intents := make(map[string]uint64, len(cm.intents))
+	for intent, count := range cm.intents {
+		intents[intent] = count
+	}
+
+	sorted := make([]time.Duration, len(cm.latencies))
+	copy(sorted, cm.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return ChatMetricsSnapshot{
+		TotalMessages:       cm.totalMessages.Load(),
+		ParseFailures:       cm.parseFailures.Load(),
+		HandlerErrors:       cm.handlerErrors.Load(),
+		ActionProposals:     cm.actionProposals.Load(),
+		ActionConfirmations: cm.actionConfirmations.Load(),
+		ReapedConnections:   cm.reapedConnections.Load(),
+		EvictedConnections:  cm.evictedConnections.Load(),
+		IntentCounts:        intents,
+		LatencyP50Ms:        latencyPercentileMs(sorted, 0.50),
+		LatencyP95Ms:        latencyPercentileMs(sorted, 0.95),
+		TopIntents24h:       cm.topIntents(),
+	}
+}
+
+// topIntents rolls up the last 24 hourly buckets. Callers must hold cm.mu.
+func (cm *ChatMetrics) topIntents() []IntentCount {
+	oldest := cm.now().Unix()/3600 - chatIntentHours + 1
+	totals := make(map[string]uint64)
+	for _, bucket := range cm.hourly {
+		if bucket.counts == nil || bucket.hour < oldest {
+			continue
+		}
+		for intent, count := range bucket.counts {
+			totals[intent] += count
+		}
+	}
+
+	top := make([]IntentCount, 0, len(totals))
+	for intent, count := range totals {
+		top = append(top, IntentCount{Intent: intent, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Intent < top[j].Intent
+	})
+	return top
+}
+
+// latencyPercentileMs returns the p-th percentile of sorted samples in milliseconds
+func latencyPercentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return float64(sorted[idx].Microseconds()) / 1000
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestChatEngine(t *testing.T) *ChatEngine {
+	analyticsEngine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { analyticsEngine.Close() })
+
+	return NewChatEngine(nil, analyticsEngine, NewDataCollector(nil))
+}
+
+func TestChatMetricsTrackBatch(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	messages := []string{
+		"What are the best yield farming options?",
+		"Show me the top APY",
+		"Analyze my portfolio",
+		"Any new governance proposal?",
+		"I want to stake 10 ETH",
+		"What's the ETH price?",
+		"Show the market",
+		"DAI price please",
+		"hello there",
+	}
+	for i, text := range messages {
+		_, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+			ID:      string(rune('a' + i)),
+			UserID:  "0xuser",
+			Message: text,
+		})
+		require.NoError(t, err, text)
+	}
+
+	snapshot := engine.MetricsSnapshot()
+	assert.Equal(t, uint64(len(messages)), snapshot.TotalMessages)
+	assert.Equal(t, uint64(0), snapshot.HandlerErrors)
+	assert.Equal(t, uint64(1), snapshot.ActionProposals)
+	assert.Equal(t, uint64(1), snapshot.ActionConfirmations)
+	assert.Equal(t, map[string]uint64{
+		"yield_query":        2,
+		"portfolio_analysis": 1,
+		"governance_query":   1,
+		"on_chain_action":    1,
+		"market_data":        3,
+		"general_query":      1,
+	}, snapshot.IntentCounts)
+
+	require.NotEmpty(t, snapshot.TopIntents24h)
+	assert.Equal(t, IntentCount{Intent: "market_data", Count: 3}, snapshot.TopIntents24h[0])
+	assert.Equal(t, IntentCount{Intent: "yield_query", Count: 2}, snapshot.TopIntents24h[1])
+	assert.GreaterOrEqual(t, snapshot.LatencyP95Ms, snapshot.LatencyP50Ms)
+
+	metrics := engine.GetChatMetrics()
+	assert.Equal(t, uint64(len(messages)), metrics["total_messages"])
+	assert.Equal(t, 0, metrics["active_connections"])
+
+	var buf bytes.Buffer
+	engine.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), "# TYPE kaia_chat_messages_total counter\nkaia_chat_messages_total 9\n")
+	assert.Contains(t, buf.String(), `kaia_chat_intent_total{intent="market_data"} 3`)
+}
+
+func TestChatMetricsIntentRollupExpires(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	metrics := NewChatMetrics()
+	metrics.now = func() time.Time { return clock }
+
+	metrics.RecordIntent("gas_info")
+	metrics.RecordIntent("gas_info")
+	clock = clock.Add(12 * time.Hour)
+	metrics.RecordIntent("yield_query")
+	metrics.RecordParseFailure()
+	metrics.RecordHandlerError()
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, []IntentCount{{"gas_info", 2}, {"yield_query", 1}}, snapshot.TopIntents24h)
+	assert.Equal(t, uint64(1), snapshot.ParseFailures)
+	assert.Equal(t, uint64(1), snapshot.HandlerErrors)
+
+	// The first hour falls out of the 24h window but stays in lifetime totals
+	clock = clock.Add(13 * time.Hour)
+	snapshot = metrics.Snapshot()
+	assert.Equal(t, []IntentCount{{"yield_query", 1}}, snapshot.TopIntents24h)
+	assert.Equal(t, uint64(2), snapshot.IntentCounts["gas_info"])
+
+	// Reusing an expired bucket slot starts it from zero
+	clock = clock.Add(11 * time.Hour)
+	metrics.RecordIntent("market_data")
+	snapshot = metrics.Snapshot()
+	assert.Equal(t, []IntentCount{{"market_data", 1}}, snapshot.TopIntents24h)
+}
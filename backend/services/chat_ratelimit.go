@@ -0,0 +1,236 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ChatRateLimitCloseCode is the WebSocket close code sent to clients that keep
+// violating the chat rate limit
+const ChatRateLimitCloseCode = 4429
+
+const (
+	chatRateWindow      = time.Minute
+	chatViolationWindow = time.Hour
+	chatRateSweepEvery  = 1024
+)
+
+// RateDecision is the outcome of checking a chat message against the limits
+type RateDecision int
+
+const (
+	// RateAllow lets the message through
+	RateAllow RateDecision = iota
+	// RateWarn lets the message through but the sender is close to the limit
+	RateWarn
+	// RateMuted rejects the message; the sender is temporarily muted
+	RateMuted
+	// RateClose rejects the message; the sender has been muted too often and
+	// the connection should be closed
+	RateClose
+)
+
+// String returns the decision name
+func (d RateDecision) String() string {
+	switch d {
+	case RateWarn:
+		return "warn"
+	case RateMuted:
+		return "muted"
+	case RateClose:
+		return "close"
+	default:
+		return "allow"
+	}
+}
+
+// ChatRateLimitConfig configures chat rate limiting
+type ChatRateLimitConfig struct {
+	// PerMinute is the number of messages a sender may send per minute
+	PerMinute int
+	// WarnRatio is the fraction of PerMinute at which senders are warned
+	WarnRatio float64
+	// MuteDuration is how long a sender is muted after exceeding the limit
+	MuteDuration time.Duration
+	// MaxViolations is how many mutes within an hour close the connection
+	MaxViolations int
+}
+
+// DefaultChatRateLimitConfig returns the default chat limits
+func DefaultChatRateLimitConfig() ChatRateLimitConfig {
+	return ChatRateLimitConfig{
+		PerMinute:     20,
+		WarnRatio:     0.8,
+		MuteDuration:  time.Minute,
+		MaxViolations: 3,
+	}
+}
+
+// chatRateState is the sliding-window state of one sender
+type chatRateState struct {
+	hits       []time.Time
+	warnedAt   time.Time
+	mutedUntil time.Time
+	violations []time.Time
+	lastSeen   time.Time
+}
+
+// ChatRateLimiter enforces sliding-window message limits per key, where a key
+// is a connection or a user
+type ChatRateLimiter struct {
+	config ChatRateLimitConfig
+	mu     sync.Mutex
+	states map[string]*chatRateState
+	calls  int
+	now    func() time.Time
+}
+
+// NewChatRateLimiter creates a limiter, falling back to defaults for unset fields
+func NewChatRateLimiter(config ChatRateLimitConfig) *ChatRateLimiter {
+	defaults := DefaultChatRateLimitConfig()
+	if config.PerMinute <= 0 {
+		config.PerMinute = defaults.PerMinute
+	}
+	if config.WarnRatio <= 0 || config.WarnRatio >= 1 {
+		config.WarnRatio = defaults.WarnRatio
+	}
+	if config.MuteDuration <= 0 {
+		config.MuteDuration = defaults.MuteDuration
+	}
+	if config.MaxViolations <= 0 {
+		config.MaxViolations = defaults.MaxViolations
+	}
+
+	return &ChatRateLimiter{
+		config: config,
+		states: make(map[string]*chatRateState),
+		now:    utcNow,
+	}
+}
+
+// RateStatus is where a sender stands against the chat limit, as reported
+// in the RateLimit response headers
+type RateStatus struct {
+	Limit     int
+	Remaining int
+	// Reset is how long until Remaining grows again: the oldest message in
+	// the window leaving it, or the end of a mute
+	Reset time.Duration
+}
+
+// Allow records a message from every key and returns the most severe decision
+func (rl *ChatRateLimiter) Allow(keys ...string) RateDecision {
+	decision, _ := rl.Check(keys...)
+	return decision
+}
+
+// Check records a message from every key like Allow, and also returns the
+// status of the most restricted key, read from the state the message was
+// counted in
+func (rl *ChatRateLimiter) Check(keys ...string) (RateDecision, RateStatus) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.calls++
+	if rl.calls%chatRateSweepEvery == 0 {
+		rl.sweep(now)
+	}
+
+	decision := RateAllow
+	status := RateStatus{Limit: rl.config.PerMinute, Remaining: rl.config.PerMinute}
+	for _, key := range keys {
+		if keyDecision := rl.allow(key, now); keyDecision > decision {
+			decision = keyDecision
+		}
+		keyStatus := rl.status(rl.states[key], now)
+		if keyStatus.Remaining < status.Remaining ||
+			(keyStatus.Remaining == status.Remaining && keyStatus.Reset > status.Reset) {
+			status = keyStatus
+		}
+	}
+	return decision, status
+}
+
+// MutedFor returns how long the most restricted key remains muted
+func (rl *ChatRateLimiter) MutedFor(keys ...string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	var remaining time.Duration
+	for _, key := range keys {
+		if state, ok := rl.states[key]; ok && state.mutedUntil.Sub(now) > remaining {
+			remaining = state.mutedUntil.Sub(now)
+		}
+	}
+	return remaining
+}
+
+// allow applies the limits to a single key. Callers must hold rl.mu.
+func (rl *ChatRateLimiter) allow(key string, now time.Time) RateDecision {
+	state, ok := rl.states[key]
+	if !ok {
+		state = &chatRateState{}
+		rl.states[key] = state
+	}
+	state.lastSeen = now
+
+	if now.Before(state.mutedUntil) {
+		return RateMuted
+	}
+
+	windowStart := now.Add(-chatRateWindow)
+	state.hits = append(pruneBefore(state.hits, windowStart), now)
+
+	if len(state.hits) > rl.config.PerMinute {
+		state.hits = state.hits[:0]
+		state.mutedUntil = now.Add(rl.config.MuteDuration)
+		state.violations = append(pruneBefore(state.violations, now.Add(-chatViolationWindow)), now)
+		if len(state.violations) >= rl.config.MaxViolations {
+			return RateClose
+		}
+		return RateMuted
+	}
+
+	warnAt := int(math.Ceil(float64(rl.config.PerMinute) * rl.config.WarnRatio))
+	if len(state.hits) >= warnAt && state.warnedAt.Before(windowStart) {
+		state.warnedAt = now
+		return RateWarn
+	}
+
+	return RateAllow
+}
+
+// status reports a sender's remaining messages. Callers must hold rl.mu.
+func (rl *ChatRateLimiter) status(state *chatRateState, now time.Time) RateStatus {
+	status := RateStatus{Limit: rl.config.PerMinute}
+	if now.Before(state.mutedUntil) {
+		status.Reset = state.mutedUntil.Sub(now)
+		return status
+	}
+	status.Remaining = max(rl.config.PerMinute-len(state.hits), 0)
+	if len(state.hits) > 0 {
+		status.Reset = state.hits[0].Add(chatRateWindow).Sub(now)
+	}
+	return status
+}
+
+// sweep drops senders idle for longer than the violation window. Callers must hold rl.mu.
+func (rl *ChatRateLimiter) sweep(now time.Time) {
+	for key, state := range rl.states {
+		if now.Sub(state.lastSeen) > chatViolationWindow {
+			delete(rl.states, key)
+		}
+	}
+}
+
+// pruneBefore drops leading times before cutoff from an ascending slice
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
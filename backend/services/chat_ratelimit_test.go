@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatRateLimiterWarnMuteClose(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewChatRateLimiter(ChatRateLimitConfig{PerMinute: 20, MuteDuration: time.Minute, MaxViolations: 3})
+	limiter.now = func() time.Time { return clock }
+
+	blast := func() []RateDecision {
+		decisions := make([]RateDecision, 0, 21)
+		for i := 0; i < 21; i++ {
+			decisions = append(decisions, limiter.Allow("conn:1", "user:0xabc"))
+			clock = clock.Add(time.Second)
+		}
+		return decisions
+	}
+
+	decisions := blast()
+	assert.Equal(t, RateWarn, decisions[15], "warns at 80% of the limit")
+	assert.Equal(t, RateAllow, decisions[16], "warns once per window")
+	assert.Equal(t, RateMuted, decisions[20])
+	assert.Greater(t, limiter.MutedFor("conn:1"), 30*time.Second)
+
+	// Muted senders stay muted until the mute expires
+	assert.Equal(t, RateMuted, limiter.Allow("conn:1"))
+	clock = clock.Add(time.Minute)
+	assert.Equal(t, RateAllow, limiter.Allow("conn:1"))
+	assert.Zero(t, limiter.MutedFor("conn:1"))
+
+	clock = clock.Add(time.Minute)
+	assert.Equal(t, RateMuted, blast()[20])
+	clock = clock.Add(time.Minute)
+	assert.Equal(t, RateClose, blast()[20], "third violation within an hour closes")
+}
+
+func TestChatRateLimiterViolationsExpire(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewChatRateLimiter(ChatRateLimitConfig{PerMinute: 2, MaxViolations: 2})
+	limiter.now = func() time.Time { return clock }
+
+	assert.Equal(t, RateAllow, limiter.Allow("ip:10.0.0.1"))
+	assert.Equal(t, RateWarn, limiter.Allow("ip:10.0.0.1"))
+	assert.Equal(t, RateMuted, limiter.Allow("ip:10.0.0.1"))
+
+	// A second violation more than an hour later starts a fresh count
+	clock = clock.Add(2 * time.Hour)
+	assert.Equal(t, RateAllow, limiter.Allow("ip:10.0.0.1"))
+	assert.Equal(t, RateWarn, limiter.Allow("ip:10.0.0.1"))
+	assert.Equal(t, RateMuted, limiter.Allow("ip:10.0.0.1"))
+
+	// Other senders are unaffected
+	assert.Equal(t, RateAllow, limiter.Allow("ip:10.0.0.2"))
+}
+
+func TestChatRateLimiterStatus(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewChatRateLimiter(ChatRateLimitConfig{PerMinute: 3, MuteDuration: 2 * time.Minute, MaxViolations: 3})
+	limiter.now = func() time.Time { return clock }
+
+	_, status := limiter.Check("user:0xabc")
+	assert.Equal(t, RateStatus{Limit: 3, Remaining: 2, Reset: time.Minute}, status)
+
+	clock = clock.Add(10 * time.Second)
+	_, status = limiter.Check("user:0xabc")
+	assert.Equal(t, RateStatus{Limit: 3, Remaining: 1, Reset: 50 * time.Second}, status, "reset follows the oldest message")
+
+	clock = clock.Add(10 * time.Second)
+	_, status = limiter.Check("conn:1", "user:0xabc")
+	assert.Equal(t, RateStatus{Limit: 3, Remaining: 0, Reset: 40 * time.Second}, status, "the most restricted key is reported")
+
+	decision, status := limiter.Check("user:0xabc")
+	assert.Equal(t, RateMuted, decision)
+	assert.Equal(t, RateStatus{Limit: 3, Remaining: 0, Reset: 2 * time.Minute}, status, "muted senders wait for the mute")
+
+	clock = clock.Add(51 * time.Second)
+	_, status = limiter.Check("user:0xabc")
+	assert.Equal(t, 69*time.Second, status.Reset)
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultChatMaxMessageLength is the default limit on chat message length, in characters
+const DefaultChatMaxMessageLength = 4000
+
+// ErrEmptyMessage is returned for chat messages with nothing left after sanitization
+var ErrEmptyMessage = errors.New("message is empty")
+
+// MessageTooLongError is returned for chat messages over the length limit
+type MessageTooLongError struct {
+	Length int
+	Max    int
+}
+
+func (e *MessageTooLongError) Error() string {
+	return fmt.Sprintf("message is %d characters, over the limit of %d", e.Length, e.Max)
+}
+
+// IsInvalidMessage reports whether err rejects the content of a chat message,
+// as opposed to a failure processing it
+func IsInvalidMessage(err error) bool {
+	var tooLong *MessageTooLongError
+	return errors.Is(err, ErrEmptyMessage) || errors.As(err, &tooLong)
+}
+
+// ChatFrameLimit is the largest encoded chat message worth reading for a
+// length limit: every character escaped as a JSON surrogate pair, plus room
+// for the other fields
+func ChatFrameLimit(maxLength int) int64 {
+	return int64(maxLength)*12 + 16<<10
+}
+
+// SanitizeChatMessage normalizes a chat message to valid UTF-8, replacing each
+// run of invalid bytes with U+FFFD, and strips control and bidirectional
+// override characters apart from newlines and tabs. Surrounding whitespace is
+// trimmed and the result must be non-empty and at most maxLength characters.
+func SanitizeChatMessage(text string, maxLength int) (string, error) {
+	var b strings.Builder
+	length := 0
+	invalid := false
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+
+		if r == utf8.RuneError && size == 1 {
+			if invalid {
+				continue
+			}
+			invalid = true
+		} else {
+			invalid = false
+			if isStrippedRune(r) {
+				continue
+			}
+		}
+
+		if b.Len() == 0 && unicode.IsSpace(r) {
+			continue
+		}
+
+		// Past the limit only trailing whitespace could still be trimmed
+		length++
+		if length > maxLength {
+			if !unicode.IsSpace(r) {
+				return "", &MessageTooLongError{Length: utf8.RuneCountInString(text), Max: maxLength}
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	sanitized := strings.TrimSpace(b.String())
+	if sanitized == "" {
+		return "", ErrEmptyMessage
+	}
+	return sanitized, nil
+}
+
+// isStrippedRune reports whether r is removed from chat messages
+func isStrippedRune(r rune) bool {
+	switch {
+	case r == '\n' || r == '\t':
+		return false
+	case unicode.IsControl(r):
+		return true
+	case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+		// Bidirectional overrides can disguise the rest of a message
+		return true
+	}
+	return false
+}
+
+// displayEscaper escapes text shown to clients. Chat responses are rendered as
+// text content, so quotes are left alone.
+var displayEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeDisplay HTML-escapes text for a response field shown to users
+func escapeDisplay(text string) string {
+	return displayEscaper.Replace(text)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeChatMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"invalid UTF-8 runs become one replacement", "gas\xff\xfe\xfd price \xc3", "gas� price �"},
+		{"truncated sequence", "stake 10 ETH\xe2\x82", "stake 10 ETH�"},
+		{"control characters", "\x00yield\x07 \x1b[31moptions\r\n\tplease\u0085", "yield [31moptions\n\tplease"},
+		{"bidi overrides", "send to \u202egnp.exe", "send to gnp.exe"},
+		{"surrounding whitespace", "  \n hello \t\n ", "hello"},
+		{"emoji count as one character", "🚀🚀🚀", "🚀🚀🚀"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeChatMessage(tt.in, 40)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := SanitizeChatMessage("\x00\x01 \u202a\n", 20)
+	assert.ErrorIs(t, err, ErrEmptyMessage)
+	assert.True(t, IsInvalidMessage(err))
+
+	// Trailing whitespace doesn't count against the limit
+	got, err := SanitizeChatMessage(strings.Repeat("🚀", 20)+strings.Repeat(" ", 100), 20)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("🚀", 20), got)
+
+	_, err = SanitizeChatMessage(strings.Repeat("🚀", 21), 20)
+	var tooLong *MessageTooLongError
+	require.ErrorAs(t, err, &tooLong)
+	assert.Equal(t, MessageTooLongError{Length: 21, Max: 20}, *tooLong)
+}
+
+func TestProcessMessageRejectsOversizedMessages(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	huge := strings.Repeat("😀", 10<<20/4)
+	start := time.Now()
+	_, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", Message: huge})
+	var tooLong *MessageTooLongError
+	require.ErrorAs(t, err, &tooLong)
+	assert.True(t, IsInvalidMessage(err))
+	assert.Equal(t, DefaultChatMaxMessageLength, tooLong.Max)
+	assert.Equal(t, 10<<20/4, tooLong.Length)
+	assert.Less(t, time.Since(start), time.Second)
+
+	engine.SetMaxMessageLength(10)
+	_, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m2", Message: "hello there!"})
+	require.ErrorAs(t, err, &tooLong)
+
+	_, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m3", Message: "\x00\x00\x00"})
+	assert.ErrorIs(t, err, ErrEmptyMessage)
+	assert.Equal(t, uint64(3), engine.MetricsSnapshot().ParseFailures)
+}
+
+func TestProcessMessageExtractsEntitiesFromSanitizedText(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	message := &ChatMessage{
+		ID:      "m1",
+		UserID:  "anonymous",
+		Message: "stake 1\x000 ETH with 0x00000000000000000000000000000000000000\u202eaa<script>alert(1)</script>",
+	}
+	response, err := engine.ProcessMessage(context.Background(), message)
+	require.NoError(t, err)
+
+	assert.Equal(t, "stake 10 ETH with 0x00000000000000000000000000000000000000aa<script>alert(1)</script>", message.Message)
+	action := response.Data.(*ActionRequest)
+	assert.Equal(t, "10", action.Parameters["amount"])
+	assert.Equal(t, strings.ToLower(summaryAddress.Hex()), action.Parameters["target_address"])
+}
+
+func TestChatResponsesEscapeDisplayText(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.SetAddressSummarizer(newTestSummarizer(
+		fakeNativeBalances{balance: big.NewInt(0)},
+		fakeTokenBalances{holdings: []TokenHolding{{Symbol: "<script>alert('x')</script>", Balance: 1, PriceUSD: 1, ValueUSD: 1}}},
+		NewTransactionIndex(),
+		time.Now(),
+	))
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+		ID:      "m1",
+		UserID:  summaryAddress.Hex(),
+		Message: "Show my portfolio <img src=x onerror=alert(1)>",
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, response.Response, "<script>")
+	assert.Contains(t, response.Response, "&lt;script&gt;alert('x')&lt;/script&gt; Balance: 1 (")
+
+	assert.Equal(t, "a &amp;&amp; b &lt;b&gt;bold&lt;/b&gt; \"quoted\"", escapeDisplay(`a && b <b>bold</b> "quoted"`))
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxChatShareTTL is the longest expiry a shared analysis can be given
+	MaxChatShareTTL = 90 * 24 * time.Hour
+	// MaxChatSharesPerUser bounds the live shares of each user
+	MaxChatSharesPerUser = 100
+
+	// chatShareGoneRetention is how long expired shares answer as gone
+	// before they are dropped
+	chatShareGoneRetention = 30 * 24 * time.Hour
+	// redactedAddress replaces the sharing user's address in a shared analysis
+	redactedAddress = "[redacted]"
+)
+
+var (
+	// ErrShareNotFound is returned for unknown share slugs
+	ErrShareNotFound = errors.New("shared analysis not found")
+	// ErrShareGone is returned for shares that expired or were revoked
+	ErrShareGone = errors.New("shared analysis expired or was revoked")
+	// ErrShareForbidden is returned when a user revokes another user's share
+	ErrShareForbidden = errors.New("share belongs to another user")
+	// ErrShareLimit is returned when a user has MaxChatSharesPerUser live shares
+	ErrShareLimit = errors.New("too many shared analyses")
+	// ErrInvalidShareTTL is returned for negative expiries or ones past MaxChatShareTTL
+	ErrInvalidShareTTL = errors.New("expiry must be at most 90 days")
+)
+
+// ChatShare is a snapshot of a chat response, taken when it was shared.
+// The snapshot is stored as JSON with the sharing user's address redacted,
+// so it stays as it was when the data it was computed from changes.
+type ChatShare struct {
+	Slug      string          `json:"slug"`
+	Question  string          `json:"question"`
+	Response  json.RawMessage `json:"response"`
+	CreatedAt APITime         `json:"created_at"`
+	ExpiresAt *APITime        `json:"expires_at,omitempty"`
+	Revoked   bool            `json:"revoked,omitempty"`
+
+	owner string
+}
+
+// ChatShares holds the shared analyses, by slug
+type ChatShares struct {
+	mu     sync.RWMutex
+	shares map[string]*ChatShare
+	now    func() time.Time
+}
+
+// NewChatShares creates an empty share store
+func NewChatShares() *ChatShares {
+	return &ChatShares{
+		shares: make(map[string]*ChatShare),
+		now:    utcNow,
+	}
+}
+
+// Create snapshots an exchange of the user into a share under a random
+// slug. A ttl of 0 shares it until it is revoked.
+func (cs *ChatShares) Create(userID string, exchange ChatExchange, ttl time.Duration) (*ChatShare, error) {
+	if ttl < 0 || ttl > MaxChatShareTTL {
+		return nil, ErrInvalidShareTTL
+	}
+	response, err := json.Marshal(exchange.Response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot response: %w", err)
+	}
+	slug, err := randomHex(12)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate share slug: %w", err)
+	}
+
+	userID = strings.ToLower(userID)
+	redact := redactor(userID)
+	now := cs.now()
+	share := &ChatShare{
+		Slug:      slug,
+		Question:  redact(exchange.Message.Message),
+		Response:  json.RawMessage(redact(string(response))),
+		CreatedAt: NewAPITime(now),
+		owner:     userID,
+	}
+	if ttl > 0 {
+		expires := NewAPITime(now.Add(ttl))
+		share.ExpiresAt = &expires
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	live := 0
+	for key, existing := range cs.shares {
+		if existing.ExpiresAt != nil && now.Sub(existing.ExpiresAt.Time) > chatShareGoneRetention {
+			delete(cs.shares, key)
+			continue
+		}
+		if existing.owner == userID && existing.live(now) {
+			live++
+		}
+	}
+	if live >= MaxChatSharesPerUser {
+		return nil, ErrShareLimit
+	}
+	cs.shares[slug] = share
+	created := *share
+	return &created, nil
+}
+
+// Get returns a live share
+func (cs *ChatShares) Get(slug string) (*ChatShare, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	share, ok := cs.shares[slug]
+	if !ok {
+		return nil, ErrShareNotFound
+	}
+	if !share.live(cs.now()) {
+		return nil, ErrShareGone
+	}
+	found := *share
+	return &found, nil
+}
+
+// Revoke stops a share of the user from being served
+func (cs *ChatShares) Revoke(userID, slug string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	share, ok := cs.shares[slug]
+	if !ok {
+		return ErrShareNotFound
+	}
+	if share.owner != strings.ToLower(userID) {
+		return ErrShareForbidden
+	}
+	share.Revoked = true
+	return nil
+}
+
+// UserData returns the user's shares, oldest first
+func (cs *ChatShares) UserData(userID string) interface{} {
+	userID = strings.ToLower(userID)
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	shares := []ChatShare{}
+	for _, share := range cs.shares {
+		if share.owner == userID {
+			shares = append(shares, *share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].CreatedAt.Before(shares[j].CreatedAt.Time) })
+	return shares
+}
+
+// EraseUserData deletes the user's shares and returns how many were removed
+func (cs *ChatShares) EraseUserData(userID string) int {
+	userID = strings.ToLower(userID)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	removed := 0
+	for slug, share := range cs.shares {
+		if share.owner == userID {
+			delete(cs.shares, slug)
+			removed++
+		}
+	}
+	return removed
+}
+
+// live reports whether the share can be served
+func (s *ChatShare) live(now time.Time) bool {
+	return !s.Revoked && (s.ExpiresAt == nil || now.Before(s.ExpiresAt.Time))
+}
+
+// redactor returns a function replacing the user's ID, in any case, with
+// redactedAddress
+func redactor(userID string) func(string) string {
+	if userID == "" {
+		return func(text string) string { return text }
+	}
+	pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(userID))
+	return func(text string) string {
+		return pattern.ReplaceAllLiteralString(text, redactedAddress)
+	}
+}
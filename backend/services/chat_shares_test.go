@@ -0,0 +1,133 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const shareOwner = "0x00000000000000000000000000000000000000Aa"
+
+// recordShareable records an answered yield question of shareOwner
+func recordShareable(transcripts *ChatTranscripts, at time.Time) []YieldOpportunity {
+	opportunities := []YieldOpportunity{{Protocol: "Uniswap V3", AssetPair: "ETH/USDC", APY: 12.5}}
+	transcripts.Record(
+		&ChatMessage{ID: "msg_1", UserID: shareOwner, SessionID: "s1", Message: "yields for " + strings.ToLower(shareOwner) + "?", Timestamp: NewAPITime(at)},
+		&ChatResponse{ID: "resp_1", MessageID: "msg_1", Response: "Yields for " + shareOwner, Type: "analytics", Data: opportunities,
+			Timestamp: NewAPITime(at), Metadata: map[string]interface{}{"address": "0x" + strings.ToUpper(shareOwner[2:])}},
+	)
+	return opportunities
+}
+
+func TestChatShareRedactsAndFreezesTheResponse(t *testing.T) {
+	at := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	transcripts := NewChatTranscripts()
+	transcripts.now = func() time.Time { return at }
+	opportunities := recordShareable(transcripts, at)
+
+	_, err := transcripts.Exchange("0x00000000000000000000000000000000000000bb", "resp_1")
+	assert.ErrorIs(t, err, ErrTranscriptForbidden)
+	_, err = transcripts.Exchange(shareOwner, "resp_2")
+	assert.ErrorIs(t, err, ErrTranscriptNotFound)
+	exchange, err := transcripts.Exchange(strings.ToLower(shareOwner), "resp_1")
+	require.NoError(t, err)
+
+	shares := NewChatShares()
+	shares.now = func() time.Time { return at }
+	share, err := shares.Create(shareOwner, exchange, 0)
+	require.NoError(t, err)
+	assert.Len(t, share.Slug, 24)
+	assert.Nil(t, share.ExpiresAt)
+
+	// The data the response was computed from changes after sharing
+	opportunities[0].APY = 3.1
+
+	shared, err := shares.Get(share.Slug)
+	require.NoError(t, err)
+	assert.Equal(t, "yields for [redacted]?", shared.Question)
+	assert.NotContains(t, strings.ToLower(string(shared.Response)), strings.ToLower(shareOwner))
+	var response ChatResponse
+	require.NoError(t, json.Unmarshal(shared.Response, &response))
+	assert.Equal(t, "Yields for [redacted]", response.Response)
+	assert.Equal(t, 12.5, response.Data.([]interface{})[0].(map[string]interface{})["apy"])
+
+	// Only the snapshot is served, not the owner
+	encoded, err := json.Marshal(shared)
+	require.NoError(t, err)
+	assert.NotContains(t, strings.ToLower(string(encoded)), strings.ToLower(shareOwner))
+}
+
+func TestChatShareExpiryAndRevocation(t *testing.T) {
+	at := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	transcripts := NewChatTranscripts()
+	transcripts.now = func() time.Time { return at }
+	recordShareable(transcripts, at)
+	exchange, err := transcripts.Exchange(shareOwner, "resp_1")
+	require.NoError(t, err)
+
+	now := at
+	shares := NewChatShares()
+	shares.now = func() time.Time { return now }
+	_, err = shares.Create(shareOwner, exchange, MaxChatShareTTL+time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidShareTTL)
+
+	expiring, err := shares.Create(shareOwner, exchange, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, NewAPITime(at.Add(24*time.Hour)), *expiring.ExpiresAt)
+	lasting, err := shares.Create(shareOwner, exchange, 0)
+	require.NoError(t, err)
+
+	now = at.Add(25 * time.Hour)
+	_, err = shares.Get(expiring.Slug)
+	assert.ErrorIs(t, err, ErrShareGone)
+	_, err = shares.Get(lasting.Slug)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, shares.Revoke("0x00000000000000000000000000000000000000bb", lasting.Slug), ErrShareForbidden)
+	assert.ErrorIs(t, shares.Revoke(shareOwner, "missing"), ErrShareNotFound)
+	require.NoError(t, shares.Revoke(shareOwner, lasting.Slug))
+	_, err = shares.Get(lasting.Slug)
+	assert.ErrorIs(t, err, ErrShareGone)
+	_, err = shares.Get("missing")
+	assert.ErrorIs(t, err, ErrShareNotFound)
+
+	assert.Len(t, shares.UserData(shareOwner), 2)
+	assert.Equal(t, 2, shares.EraseUserData(shareOwner))
+}
+
+func TestChatTranscriptExport(t *testing.T) {
+	at := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	transcripts := NewChatTranscripts()
+	transcripts.now = func() time.Time { return at }
+	recordShareable(transcripts, at)
+	transcripts.Record(&ChatMessage{ID: "msg_2", UserID: shareOwner, Message: "gas?"}, &ChatResponse{ID: "resp_2", Response: "Gas is low", Timestamp: NewAPITime(at)})
+
+	data, err := transcripts.Export(shareOwner, "s1", TranscriptFormatJSON)
+	require.NoError(t, err)
+	var transcript ChatTranscript
+	require.NoError(t, json.Unmarshal(data, &transcript))
+	assert.Equal(t, "s1", transcript.SessionID)
+	require.Len(t, transcript.Exchanges, 1)
+	assert.Equal(t, "resp_1", transcript.Exchanges[0].Response.ID)
+
+	data, err = transcripts.Export(shareOwner, "", TranscriptFormatMarkdown)
+	require.NoError(t, err)
+	assert.Equal(t, "# Chat transcript\n\nSession `default` of "+strings.ToLower(shareOwner)+", exported 2025-06-02T12:00:00Z\n"+
+		"\n## You, 2025-06-02T12:00:00Z\n\ngas?\n"+
+		"\n## Assistant, 2025-06-02T12:00:00Z\n\nGas is low\n", string(data))
+
+	_, err = transcripts.Export(shareOwner, "s1", "pdf")
+	assert.ErrorIs(t, err, ErrTranscriptFormat)
+	_, err = transcripts.Export("0x00000000000000000000000000000000000000bb", "s1", TranscriptFormatJSON)
+	assert.ErrorIs(t, err, ErrTranscriptNotFound)
+
+	// Exchanges past retention are dropped
+	transcripts.now = func() time.Time { return at.Add(TranscriptRetention + time.Hour) }
+	_, err = transcripts.Export(shareOwner, "s1", TranscriptFormatJSON)
+	assert.ErrorIs(t, err, ErrTranscriptNotFound)
+	assert.Equal(t, 2, transcripts.EraseUserData(shareOwner))
+}
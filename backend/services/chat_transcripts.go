@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultChatSession is the session of messages that name none
+	DefaultChatSession = "default"
+	// TranscriptRetention is how long chat exchanges are kept for export and sharing
+	TranscriptRetention = 30 * 24 * time.Hour
+
+	// Transcript export formats
+	TranscriptFormatJSON     = "json"
+	TranscriptFormatMarkdown = "markdown"
+
+	// maxTranscriptExchanges bounds the exchanges kept per user; the oldest
+	// are dropped past it
+	maxTranscriptExchanges = 1000
+)
+
+var (
+	// ErrTranscriptNotFound is returned for sessions and responses with no
+	// recorded exchange
+	ErrTranscriptNotFound = errors.New("chat transcript not found")
+	// ErrTranscriptForbidden is returned when a user asks for another user's response
+	ErrTranscriptForbidden = errors.New("response belongs to another user")
+	// ErrTranscriptFormat is returned for export formats other than json and markdown
+	ErrTranscriptFormat = errors.New("format must be json or markdown")
+)
+
+// ChatExchange is a message and the response it got
+type ChatExchange struct {
+	SessionID string       `json:"session_id"`
+	Message   ChatMessage  `json:"message"`
+	Response  ChatResponse `json:"response"`
+}
+
+// ChatTranscript is the export of one of a user's chat sessions
+type ChatTranscript struct {
+	UserID     string         `json:"user_id"`
+	SessionID  string         `json:"session_id"`
+	Exchanges  []ChatExchange `json:"exchanges"`
+	ExportedAt APITime        `json:"exported_at"`
+}
+
+// ChatTranscripts keeps the chat exchanges of each user, by session, for
+// transcript exports and shared analyses
+type ChatTranscripts struct {
+	mu        sync.RWMutex
+	exchanges map[string][]ChatExchange // by lowercase user ID, oldest first
+	now       func() time.Time
+}
+
+// NewChatTranscripts creates an empty transcript store
+func NewChatTranscripts() *ChatTranscripts {
+	return &ChatTranscripts{
+		exchanges: make(map[string][]ChatExchange),
+		now:       utcNow,
+	}
+}
+
+// Record adds an answered message to its user's transcript. Messages sent
+// without a timestamp take the response's.
+func (ct *ChatTranscripts) Record(message *ChatMessage, response *ChatResponse) {
+	userID := strings.ToLower(message.UserID)
+	exchange := ChatExchange{SessionID: chatSession(message.SessionID), Message: *message, Response: *response}
+	exchange.Message.Stream = nil
+	if exchange.Message.Timestamp.IsZero() {
+		exchange.Message.Timestamp = response.Timestamp
+	}
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	exchanges := ct.prune(userID, ct.now())
+	exchanges = append(exchanges, exchange)
+	if len(exchanges) > maxTranscriptExchanges {
+		exchanges = exchanges[len(exchanges)-maxTranscriptExchanges:]
+	}
+	ct.exchanges[userID] = exchanges
+}
+
+// Session returns the exchanges of one of the user's sessions, oldest first
+func (ct *ChatTranscripts) Session(userID, sessionID string) ([]ChatExchange, error) {
+	sessionID = chatSession(sessionID)
+
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+
+	var session []ChatExchange
+	cutoff := ct.now().Add(-TranscriptRetention)
+	for _, exchange := range ct.exchanges[strings.ToLower(userID)] {
+		if exchange.SessionID == sessionID && !exchange.Response.Timestamp.Before(cutoff) {
+			session = append(session, exchange)
+		}
+	}
+	if len(session) == 0 {
+		return nil, ErrTranscriptNotFound
+	}
+	return session, nil
+}
+
+// Exchange returns the exchange a response was sent in, provided it was
+// sent to the user
+func (ct *ChatTranscripts) Exchange(userID, responseID string) (ChatExchange, error) {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+
+	cutoff := ct.now().Add(-TranscriptRetention)
+	for owner, exchanges := range ct.exchanges {
+		for _, exchange := range exchanges {
+			if exchange.Response.ID != responseID || exchange.Response.Timestamp.Before(cutoff) {
+				continue
+			}
+			if owner != strings.ToLower(userID) {
+				return ChatExchange{}, ErrTranscriptForbidden
+			}
+			return exchange, nil
+		}
+	}
+	return ChatExchange{}, ErrTranscriptNotFound
+}
+
+// Export renders one of the user's sessions as JSON or Markdown
+func (ct *ChatTranscripts) Export(userID, sessionID, format string) ([]byte, error) {
+	if format != TranscriptFormatJSON && format != TranscriptFormatMarkdown {
+		return nil, ErrTranscriptFormat
+	}
+	exchanges, err := ct.Session(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	transcript := ChatTranscript{
+		UserID:     strings.ToLower(userID),
+		SessionID:  chatSession(sessionID),
+		Exchanges:  exchanges,
+		ExportedAt: NewAPITime(ct.now()),
+	}
+	if format == TranscriptFormatJSON {
+		return json.Marshal(transcript)
+	}
+	return []byte(transcript.Markdown()), nil
+}
+
+// Markdown renders the transcript as a Markdown document
+func (t ChatTranscript) Markdown() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Chat transcript\n\nSession `%s` of %s, exported %s\n", t.SessionID, t.UserID, t.ExportedAt.Format(time.RFC3339))
+	for _, exchange := range t.Exchanges {
+		fmt.Fprintf(&out, "\n## You, %s\n\n%s\n", exchange.Message.Timestamp.Format(time.RFC3339), exchange.Message.Message)
+		fmt.Fprintf(&out, "\n## Assistant, %s\n\n%s\n", exchange.Response.Timestamp.Format(time.RFC3339), exchange.Response.Response)
+	}
+	return out.String()
+}
+
+// UserData returns the user's exchanges
+func (ct *ChatTranscripts) UserData(userID string) interface{} {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+
+	return append([]ChatExchange{}, ct.exchanges[strings.ToLower(userID)]...)
+}
+
+// EraseUserData deletes the user's exchanges and returns how many were removed
+func (ct *ChatTranscripts) EraseUserData(userID string) int {
+	userID = strings.ToLower(userID)
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	removed := len(ct.exchanges[userID])
+	delete(ct.exchanges, userID)
+	return removed
+}
+
+// prune drops the user's exchanges past retention and returns the rest.
+// Callers must hold ct.mu.
+func (ct *ChatTranscripts) prune(userID string, now time.Time) []ChatExchange {
+	exchanges := ct.exchanges[userID]
+	dropped := 0
+	for dropped < len(exchanges) && now.Sub(exchanges[dropped].Response.Timestamp.Time) > TranscriptRetention {
+		dropped++
+	}
+	return exchanges[dropped:]
+}
+
+// chatSession names the session of a message
+func chatSession(sessionID string) string {
+	if sessionID = strings.TrimSpace(sessionID); sessionID == "" {
+		return DefaultChatSession
+	}
+	return sessionID
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Chat usage bucket sizes
+const (
+	ChatUsageHour = "hour"
+	ChatUsageDay  = "day"
+)
+
+const (
+	// ChatUsageRetention is how long hourly chat usage is kept
+	ChatUsageRetention = 90 * 24 * time.Hour
+	// ChatUsageForecastDays is how many days ahead chat usage is forecast
+	ChatUsageForecastDays = 7
+)
+
+// ValidChatUsageBucket reports whether bucket names a chat usage bucket size
+func ValidChatUsageBucket(bucket string) bool {
+	return bucket == ChatUsageHour || bucket == ChatUsageDay
+}
+
+// ChatUsageBucket is the chat traffic of an hour or a UTC day
+type ChatUsageBucket struct {
+	Start        APITime          `json:"start"`
+	Messages     int64            `json:"messages"`
+	UniqueUsers  int              `json:"unique_users"`
+	Intents      map[string]int64 `json:"intents"`
+	AvgLatencyMs float64          `json:"avg_latency_ms"`
+	// AnalyticsTasks is how many analytics tasks the messages fanned out to
+	AnalyticsTasks     int64   `json:"analytics_tasks"`
+	AvgTasksPerMessage float64 `json:"avg_tasks_per_message"`
+}
+
+// ChatUsageForecastDay is the expected number of messages on a UTC day
+type ChatUsageForecastDay struct {
+	Date     string  `json:"date"`
+	Messages float64 `json:"messages"`
+}
+
+// ChatUsageForecast projects daily messages from a linear trend over the
+// finished days, adjusted by the average deviation of each weekday from it
+type ChatUsageForecast struct {
+	// TrendPerDay is the change in daily messages the trend line predicts
+	TrendPerDay float64                `json:"trend_per_day"`
+	BasedOnDays int                    `json:"based_on_days"`
+	Days        []ChatUsageForecastDay `json:"days"`
+}
+
+// chatUsageHour is the chat traffic of one hour
+type chatUsageHour struct {
+	messages int64
+	users    map[string]bool
+	intents  map[string]int64
+	latency  time.Duration
+	tasks    int64
+}
+
+// ChatUsageStore counts chat traffic in hourly buckets for capacity
+// planning. Buckets are kept in memory for ChatUsageRetention.
+type ChatUsageStore struct {
+	mu    sync.Mutex
+	hours map[int64]*chatUsageHour
+	now   func() time.Time
+}
+
+// NewChatUsageStore creates an empty chat usage store
+func NewChatUsageStore() *ChatUsageStore {
+	return &ChatUsageStore{
+		hours: make(map[int64]*chatUsageHour),
+		now:   utcNow,
+	}
+}
+
+// Record counts a processed message of a user, the intent it resolved to,
+// how long it took and how many analytics tasks it ran. An empty intent is
+// not counted among the intents.
+func (cu *ChatUsageStore) Record(userID, intent string, latency time.Duration, tasks int) {
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+
+	now := cu.now()
+	hour := now.Unix() / 3600
+	bucket, ok := cu.hours[hour]
+	if !ok {
+		cu.prune(now)
+		bucket = &chatUsageHour{users: make(map[string]bool), intents: make(map[string]int64)}
+		cu.hours[hour] = bucket
+	}
+	bucket.messages++
+	if userID != "" {
+		bucket.users[strings.ToLower(userID)] = true
+	}
+	if intent != "" {
+		bucket.intents[intent]++
+	}
+	bucket.latency += latency
+	bucket.tasks += int64(tasks)
+}
+
+// Buckets aggregates the traffic from from up to to into hourly or daily
+// buckets, oldest first. Buckets without messages are left out.
+func (cu *ChatUsageStore) Buckets(from, to time.Time, size string) []ChatUsageBucket {
+	hoursPerBucket := int64(1)
+	if size == ChatUsageDay {
+		hoursPerBucket = 24
+	}
+	first := from.Unix() / 3600
+	last := to.Unix() / 3600
+
+	cu.mu.Lock()
+	defer cu.mu.Unlock()
+
+	type aggregate struct {
+		ChatUsageBucket
+		users   map[string]bool
+		latency time.Duration
+	}
+	aggregates := make(map[int64]*aggregate)
+	for hour, usage := range cu.hours {
+		if hour < first || hour > last {
+			continue
+		}
+		start := hour - hour%hoursPerBucket
+		agg, ok := aggregates[start]
+		if !ok {
+			agg = &aggregate{
+				ChatUsageBucket: ChatUsageBucket{
+					Start:   NewAPITime(time.Unix(start*3600, 0)),
+					Intents: make(map[string]int64),
+				},
+				users: make(map[string]bool),
+			}
+			aggregates[start] = agg
+		}
+		agg.Messages += usage.messages
+		agg.AnalyticsTasks += usage.tasks
+		agg.latency += usage.latency
+		for user := range usage.users {
+			agg.users[user] = true
+		}
+		for intent, count := range usage.intents {
+			agg.Intents[intent] += count
+		}
+	}
+
+	buckets := make([]ChatUsageBucket, 0, len(aggregates))
+	for _, agg := range aggregates {
+		agg.UniqueUsers = len(agg.users)
+		agg.AvgLatencyMs = roundTo(float64(agg.latency.Microseconds())/1000/float64(agg.Messages), 2)
+		agg.AvgTasksPerMessage = roundTo(float64(agg.AnalyticsTasks)/float64(agg.Messages), 4)
+		buckets = append(buckets, agg.ChatUsageBucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start.Time) })
+	return buckets
+}
+
+// Forecast projects the daily messages of the next ChatUsageForecastDays
+// from the finished days on record. Days without messages between the first
+// recorded day and yesterday count as zero. The forecast has no days until
+// two days are finished.
+func (cu *ChatUsageStore) Forecast() ChatUsageForecast {
+	now := cu.now()
+	today := now.Unix() / 86400
+
+	cu.mu.Lock()
+	firstDay := today
+	daily := make(map[int64]float64)
+	for hour, usage := range cu.hours {
+		day := hour / 24
+		if day >= today {
+			continue
+		}
+		daily[day] += float64(usage.messages)
+		firstDay = min(firstDay, day)
+	}
+	cu.mu.Unlock()
+
+	forecast := ChatUsageForecast{BasedOnDays: int(today - firstDay), Days: []ChatUsageForecastDay{}}
+	if forecast.BasedOnDays < 2 {
+		return forecast
+	}
+
+	// Least squares fit of messages = intercept + slope*x, x counting days
+	// from the first one
+	n := float64(forecast.BasedOnDays)
+	var sumX, sumY, sumXY, sumXX float64
+	for x := 0; x < forecast.BasedOnDays; x++ {
+		y := daily[firstDay+int64(x)]
+		sumX += float64(x)
+		sumY += y
+		sumXY += float64(x) * y
+		sumXX += float64(x) * float64(x)
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept := (sumY - slope*sumX) / n
+
+	// Seasonality is the average residual of each weekday
+	var residuals [7]float64
+	var counts [7]int
+	for x := 0; x < forecast.BasedOnDays; x++ {
+		day := firstDay + int64(x)
+		weekday := time.Unix(day*86400, 0).UTC().Weekday()
+		residuals[weekday] += daily[day] - (intercept + slope*float64(x))
+		counts[weekday]++
+	}
+
+	forecast.TrendPerDay = roundTo(slope, 4)
+	for i := 0; i < ChatUsageForecastDays; i++ {
+		day := today + int64(i)
+		date := time.Unix(day*86400, 0).UTC()
+		expected := intercept + slope*float64(day-firstDay)
+		if weekday := date.Weekday(); counts[weekday] > 0 {
+			expected += residuals[weekday] / float64(counts[weekday])
+		}
+		forecast.Days = append(forecast.Days, ChatUsageForecastDay{
+			Date:     date.Format(usageDayLayout),
+			Messages: roundTo(math.Max(expected, 0), 2),
+		})
+	}
+	return forecast
+}
+
+// prune drops hours past retention. Callers must hold cu.mu.
+func (cu *ChatUsageStore) prune(now time.Time) {
+	oldest := now.Add(-ChatUsageRetention).Unix() / 3600
+	for hour := range cu.hours {
+		if hour < oldest {
+			delete(cu.hours, hour)
+		}
+	}
+}
+
+// analyticsTaskCounterKey carries the count of analytics tasks a chat
+// message ran in its context
+type analyticsTaskCounterKey struct{}
+
+// withAnalyticsTaskCounter returns a context that counts the analytics tasks
+// run through runAnalyticsTask
+func withAnalyticsTaskCounter(ctx context.Context) (context.Context, *atomic.Int64) {
+	counter := new(atomic.Int64)
+	return context.WithValue(ctx, analyticsTaskCounterKey{}, counter), counter
+}
+
+// runAnalyticsTask runs an analytics task for a chat message, counting it
+// toward the message's fanout
+func (ce *ChatEngine) runAnalyticsTask(ctx context.Context, taskType string, parameters map[string]interface{}) (*AnalyticsResult, error) {
+	if counter, ok := ctx.Value(analyticsTaskCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+	return ce.analyticsEngine.ProcessAnalyticsTask(ctx, taskType, parameters)
+}
+
+// ChatUsage returns the hourly chat traffic of the engine
+func (ce *ChatEngine) ChatUsage() *ChatUsageStore {
+	return ce.usage
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestChatUsageStore(now *time.Time) *ChatUsageStore {
+	store := NewChatUsageStore()
+	store.now = func() time.Time { return *now }
+	return store
+}
+
+func TestChatUsageBucketsByHourAndDay(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)
+	store := newTestChatUsageStore(&now)
+
+	store.Record(usageAlice, "yield_query", 100*time.Millisecond, 1)
+	store.Record(usageBob, "market_data", 300*time.Millisecond, 0)
+	now = now.Add(50 * time.Minute)
+	store.Record("0x00000000000000000000000000000000000000A1", "yield_query", 200*time.Millisecond, 2)
+	store.Record(usageAlice, "", 0, 0)
+	now = now.Add(24 * time.Hour)
+	store.Record(usageBob, "gas_info", 50*time.Millisecond, 0)
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	hourly := store.Buckets(from, now, ChatUsageHour)
+	require.Len(t, hourly, 3)
+	assert.Equal(t, ChatUsageBucket{
+		Start:              NewAPITime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)),
+		Messages:           2,
+		UniqueUsers:        2,
+		Intents:            map[string]int64{"yield_query": 1, "market_data": 1},
+		AvgLatencyMs:       200,
+		AnalyticsTasks:     1,
+		AvgTasksPerMessage: 0.5,
+	}, hourly[0])
+	assert.Equal(t, int64(2), hourly[1].Messages)
+	assert.Equal(t, 1, hourly[1].UniqueUsers)
+	assert.Equal(t, map[string]int64{"yield_query": 1}, hourly[1].Intents)
+
+	daily := store.Buckets(from, now, ChatUsageDay)
+	require.Len(t, daily, 2)
+	assert.Equal(t, NewAPITime(from), daily[0].Start)
+	assert.Equal(t, int64(4), daily[0].Messages)
+	// Users are counted once per bucket across its hours
+	assert.Equal(t, 2, daily[0].UniqueUsers)
+	assert.Equal(t, map[string]int64{"yield_query": 2, "market_data": 1}, daily[0].Intents)
+	assert.Equal(t, int64(3), daily[0].AnalyticsTasks)
+	assert.Equal(t, int64(1), daily[1].Messages)
+
+	// The range is inclusive of the hours of from and to
+	assert.Len(t, store.Buckets(now, now, ChatUsageHour), 1)
+	assert.Empty(t, store.Buckets(from, from.Add(time.Hour), ChatUsageHour))
+}
+
+func TestChatUsageForecastFollowsTrendAndWeekdays(t *testing.T) {
+	// Four weeks of growing traffic, with Saturdays a third lower
+	start := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	store := newTestChatUsageStore(&now)
+	for day := 0; day < 28; day++ {
+		now = start.AddDate(0, 0, day)
+		messages := 30 + 2*day
+		if now.Weekday() == time.Saturday {
+			messages -= 10
+		}
+		for i := 0; i < messages; i++ {
+			store.Record(usageAlice, "market_data", time.Millisecond, 0)
+		}
+	}
+
+	// Nothing is forecast from a single finished day
+	now = start
+	single := newTestChatUsageStore(&now)
+	single.Record(usageAlice, "market_data", time.Millisecond, 0)
+	now = start.AddDate(0, 0, 1)
+	assert.Empty(t, single.Forecast().Days)
+
+	now = start.AddDate(0, 0, 28)
+	forecast := store.Forecast()
+	assert.Equal(t, 28, forecast.BasedOnDays)
+	assert.InDelta(t, 2, forecast.TrendPerDay, 0.1)
+	require.Len(t, forecast.Days, ChatUsageForecastDays)
+	assert.Equal(t, "2024-04-29", forecast.Days[0].Date)
+
+	// Traffic keeps growing day over day, except for the Saturday dip
+	var saturday int
+	for i, day := range forecast.Days {
+		if date, _ := time.Parse(usageDayLayout, day.Date); date.Weekday() == time.Saturday {
+			saturday = i
+		}
+	}
+	for i := 1; i < len(forecast.Days); i++ {
+		if i == saturday {
+			assert.Less(t, forecast.Days[i].Messages, forecast.Days[i-1].Messages)
+		} else if i-1 != saturday {
+			assert.Greater(t, forecast.Days[i].Messages, forecast.Days[i-1].Messages)
+		}
+	}
+	assert.InDelta(t, 30+2*28, forecast.Days[0].Messages, 2)
+}
+
+func TestChatEngineRecordsUsageWithAnalyticsFanout(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	_, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "a", UserID: usageAlice, Message: "What are the best yield farming options?"})
+	require.NoError(t, err)
+	_, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "b", UserID: usageBob, Message: "hello there"})
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	buckets := engine.ChatUsage().Buckets(now.Add(-time.Hour), now, ChatUsageDay)
+	var messages, tasks int64
+	intents := make(map[string]int64)
+	for _, bucket := range buckets {
+		messages += bucket.Messages
+		tasks += bucket.AnalyticsTasks
+		for intent, count := range bucket.Intents {
+			intents[intent] += count
+		}
+	}
+	assert.Equal(t, int64(2), messages)
+	assert.Equal(t, int64(1), tasks)
+	assert.Equal(t, map[string]int64{"yield_query": 1, "general_query": 1}, intents)
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// CongestionRetention is the longest window congestion reports cover
+	CongestionRetention = 30 * 24 * time.Hour
+	// FullBlockUtilization is the gas utilization above which a block counts as full
+	FullBlockUtilization = 0.9
+
+	// CongestionBusyUtilization is the average utilization at which the
+	// network is busy
+	CongestionBusyUtilization = 0.7
+	// CongestionBusyFullRatio is the fraction of full blocks at which the
+	// network is busy whatever the average
+	CongestionBusyFullRatio = 0.2
+	// CongestionQuietUtilization is the average utilization below which the
+	// network is quiet, provided hardly any blocks are full
+	CongestionQuietUtilization = 0.3
+	// CongestionQuietFullRatio is the largest fraction of full blocks of a
+	// quiet network
+	CongestionQuietFullRatio = 0.01
+	// BaseFeeTrendThreshold is the base fee growth, in percent per hour, above
+	// which fees are rising and below whose negative they are falling
+	BaseFeeTrendThreshold = 1.0
+
+	// ChatCongestionWindow is the window of the congestion summary in gas answers
+	ChatCongestionWindow = 3 * time.Hour
+
+	congestionPollInterval = 15 * time.Second
+	congestionMaxCatchUp   = 600
+)
+
+// Congestion levels
+const (
+	CongestionQuiet    = "quiet"
+	CongestionModerate = "moderate"
+	CongestionBusy     = "busy"
+)
+
+// BlockStat is the gas usage and base fee of one block
+type BlockStat struct {
+	Number    uint64
+	Timestamp time.Time
+	GasUsed   uint64
+	GasLimit  uint64
+	// BaseFee is in gwei, 0 for blocks without one
+	BaseFee float64
+}
+
+// Utilization is the fraction of the gas limit used
+func (s BlockStat) Utilization() float64 {
+	if s.GasLimit == 0 {
+		return 0
+	}
+	return float64(s.GasUsed) / float64(s.GasLimit)
+}
+
+// BlockStatFromHeader reads the stats of a block from its header
+func BlockStatFromHeader(header *types.Header) BlockStat {
+	stat := BlockStat{
+		Number:    header.Number.Uint64(),
+		Timestamp: time.Unix(int64(header.Time), 0).UTC(),
+		GasUsed:   header.GasUsed,
+		GasLimit:  header.GasLimit,
+	}
+	if header.BaseFee != nil {
+		stat.BaseFee = weiToFloat(header.BaseFee, 9)
+	}
+	return stat
+}
+
+// HourlyCongestion summarizes the blocks of one hour, or of a whole day
+// starting at Hour once the retention engine has compacted it
+type HourlyCongestion struct {
+	Hour           time.Time `json:"hour"`
+	Blocks         int       `json:"blocks"`
+	AvgUtilization float64   `json:"avg_utilization"`
+	P95Utilization float64   `json:"p95_utilization"`
+	FullBlocks     int       `json:"full_blocks"`
+	FullBlockRatio float64   `json:"full_block_ratio"`
+	// AvgBaseFee is in gwei, 0 when no block of the hour had a base fee
+	AvgBaseFee float64 `json:"avg_base_fee"`
+}
+
+// CongestionReport summarizes block fullness over a window
+type CongestionReport struct {
+	Window         string    `json:"window"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	Blocks         int       `json:"blocks"`
+	AvgUtilization float64   `json:"avg_utilization"`
+	// PeakP95Utilization is the highest hourly p95 utilization
+	PeakP95Utilization float64 `json:"peak_p95_utilization"`
+	FullBlockRatio     float64 `json:"full_block_ratio"`
+	// BaseFeeGrowth is the fitted base fee growth rate, in percent per hour
+	BaseFeeGrowth float64            `json:"base_fee_growth_pct_per_hour"`
+	Level         string             `json:"level"`
+	Summary       string             `json:"summary"`
+	Hours         []HourlyCongestion `json:"hours"`
+}
+
+// CongestionTracker follows new blocks and keeps hourly gas utilization stats.
+// Blocks of the current hour are kept until the hour is over, then rolled up;
+// hours compacted by the retention engine are merged into daily stats.
+type CongestionTracker struct {
+	ethClient ChainClient
+	logger    *log.Logger
+
+	mu          sync.RWMutex
+	days        []HourlyCongestion
+	hours       []HourlyCongestion
+	currentHour time.Time
+	current     []BlockStat
+	lastBlock   uint64
+
+	now func() time.Time
+}
+
+// NewCongestionTracker creates a tracker with no history
+func NewCongestionTracker(ethClient ChainClient) *CongestionTracker {
+	return &CongestionTracker{
+		ethClient: ethClient,
+		logger:    log.New(log.Writer(), "[CongestionTracker] ", log.LstdFlags),
+		now:       utcNow,
+	}
+}
+
+// Start polls for new blocks until the context is cancelled
+func (ct *CongestionTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(congestionPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := ct.Poll(ctx); err != nil && ctx.Err() == nil {
+				ct.logger.Printf("Failed to poll blocks: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Poll records the blocks mined since the last poll. After a gap of more than
+// congestionMaxCatchUp blocks only the most recent are read.
+func (ct *CongestionTracker) Poll(ctx context.Context) error {
+	head, err := ct.ethClient.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block number: %w", err)
+	}
+
+	ct.mu.RLock()
+	next := ct.lastBlock + 1
+	ct.mu.RUnlock()
+	if head >= congestionMaxCatchUp && next+congestionMaxCatchUp <= head {
+		next = head - congestionMaxCatchUp + 1
+	}
+
+	for number := next; number <= head; number++ {
+		header, err := ct.ethClient.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return fmt.Errorf("failed to get header of block %d: %w", number, err)
+		}
+		ct.Record(BlockStatFromHeader(header))
+	}
+	return nil
+}
+
+// Record adds the stats of a block. Blocks at or below the last recorded one
+// and blocks of hours already rolled up are ignored.
+func (ct *CongestionTracker) Record(stat BlockStat) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if stat.Number <= ct.lastBlock {
+		return
+	}
+	ct.lastBlock = stat.Number
+
+	hour := stat.Timestamp.UTC().Truncate(time.Hour)
+	if hour.Before(ct.currentHour) {
+		return
+	}
+	if hour.After(ct.currentHour) {
+		ct.rollUp()
+		ct.currentHour = hour
+	}
+	ct.current = append(ct.current, stat)
+}
+
+// rollUp closes the current hour
+func (ct *CongestionTracker) rollUp() {
+	if len(ct.current) > 0 {
+		ct.hours = append(ct.hours, summarizeHour(ct.currentHour, ct.current))
+		ct.current = nil
+	}
+}
+
+// CompactBefore merges at most limit hours before cutoff, truncated to the
+// day, into daily stats
+func (ct *CongestionTracker) CompactBefore(cutoff time.Time, limit int) int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	cutoff = cutoff.UTC().Truncate(24 * time.Hour)
+	n := sort.Search(len(ct.hours), func(i int) bool { return !ct.hours[i].Hour.Before(cutoff) })
+	n = min(n, limit)
+	for _, hour := range ct.hours[:n] {
+		day := hour.Hour.Truncate(24 * time.Hour)
+		if last := len(ct.days) - 1; last >= 0 && ct.days[last].Hour.Equal(day) {
+			ct.days[last] = mergeCongestion(day, ct.days[last], hour)
+			continue
+		}
+		ct.days = append(ct.days, mergeCongestion(day, hour))
+	}
+	ct.hours = append([]HourlyCongestion(nil), ct.hours[n:]...)
+	return n
+}
+
+// Hours returns the stats since a time, oldest first: a summary per day for
+// days compacted by the retention engine, then one per hour, including the
+// hour in progress
+func (ct *CongestionTracker) Hours(since time.Time) []HourlyCongestion {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+
+	since = since.UTC().Truncate(time.Hour)
+	first := sort.Search(len(ct.days), func(i int) bool { return !ct.days[i].Hour.Before(since) })
+	start := sort.Search(len(ct.hours), func(i int) bool { return !ct.hours[i].Hour.Before(since) })
+	hours := append([]HourlyCongestion(nil), ct.days[first:]...)
+	hours = append(hours, ct.hours[start:]...)
+	if len(ct.current) > 0 && !ct.currentHour.Before(since) {
+		hours = append(hours, summarizeHour(ct.currentHour, ct.current))
+	}
+	return hours
+}
+
+// Report summarizes block fullness over the hours overlapping the last window
+func (ct *CongestionTracker) Report(window time.Duration) *CongestionReport {
+	to := ct.now().UTC()
+	report := summarizeCongestion(ct.Hours(to.Add(-window)))
+	report.Window = window.String()
+	report.From = to.Add(-window)
+	report.To = to
+	return report
+}
+
+// summarizeHour computes the stats of the blocks of an hour
+func summarizeHour(hour time.Time, stats []BlockStat) HourlyCongestion {
+	utilizations := make([]float64, len(stats))
+	var sum, baseFeeSum float64
+	full, withBaseFee := 0, 0
+	for i, stat := range stats {
+		utilizations[i] = stat.Utilization()
+		sum += utilizations[i]
+		if utilizations[i] > FullBlockUtilization {
+			full++
+		}
+		if stat.BaseFee > 0 {
+			baseFeeSum += stat.BaseFee
+			withBaseFee++
+		}
+	}
+
+	summary := HourlyCongestion{
+		Hour:           hour,
+		Blocks:         len(stats),
+		AvgUtilization: sum / float64(len(stats)),
+		P95Utilization: Percentile(utilizations, 95),
+		FullBlocks:     full,
+		FullBlockRatio: float64(full) / float64(len(stats)),
+	}
+	if withBaseFee > 0 {
+		summary.AvgBaseFee = baseFeeSum / float64(withBaseFee)
+	}
+	return summary
+}
+
+// mergeCongestion combines stats into one summary starting at start,
+// weighting averages by blocks. The p95 utilization of the merged stats isn't
+// known, so the highest one stands in for it.
+func mergeCongestion(start time.Time, stats ...HourlyCongestion) HourlyCongestion {
+	merged := HourlyCongestion{Hour: start}
+	var utilization, baseFee float64
+	withBaseFee := 0
+	for _, stat := range stats {
+		merged.Blocks += stat.Blocks
+		merged.FullBlocks += stat.FullBlocks
+		merged.P95Utilization = math.Max(merged.P95Utilization, stat.P95Utilization)
+		utilization += stat.AvgUtilization * float64(stat.Blocks)
+		if stat.AvgBaseFee > 0 {
+			baseFee += stat.AvgBaseFee * float64(stat.Blocks)
+			withBaseFee += stat.Blocks
+		}
+	}
+	if merged.Blocks > 0 {
+		merged.AvgUtilization = utilization / float64(merged.Blocks)
+		merged.FullBlockRatio = float64(merged.FullBlocks) / float64(merged.Blocks)
+	}
+	if withBaseFee > 0 {
+		merged.AvgBaseFee = baseFee / float64(withBaseFee)
+	}
+	return merged
+}
+
+// summarizeCongestion combines hourly stats, weighting each hour by its
+// blocks, and classifies the congestion
+func summarizeCongestion(hours []HourlyCongestion) *CongestionReport {
+	report := &CongestionReport{Hours: hours}
+	if report.Hours == nil {
+		report.Hours = []HourlyCongestion{}
+	}
+
+	var utilization float64
+	full := 0
+	baseFees := make([]SeriesPoint, 0, len(hours))
+	for _, hour := range hours {
+		report.Blocks += hour.Blocks
+		utilization += hour.AvgUtilization * float64(hour.Blocks)
+		full += hour.FullBlocks
+		report.PeakP95Utilization = math.Max(report.PeakP95Utilization, hour.P95Utilization)
+		if hour.AvgBaseFee > 0 {
+			baseFees = append(baseFees, SeriesPoint{Timestamp: hour.Hour, Value: hour.AvgBaseFee})
+		}
+	}
+	if report.Blocks == 0 {
+		report.Level = CongestionQuiet
+		report.Summary = "No blocks have been seen in this window yet"
+		return report
+	}
+
+	report.AvgUtilization = utilization / float64(report.Blocks)
+	report.FullBlockRatio = float64(full) / float64(report.Blocks)
+	report.BaseFeeGrowth = baseFeeGrowth(baseFees)
+	report.Level = congestionLevel(report.AvgUtilization, report.FullBlockRatio)
+	report.Summary = congestionSummary(report.Level, report.BaseFeeGrowth)
+	return report
+}
+
+// Percentile returns the pth percentile of values by the nearest-rank method:
+// the smallest value with at least p percent of the values at or below it
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+// baseFeeGrowth fits an exponential to the base fees by least squares on
+// their logarithm and returns the growth rate in percent per hour
+func baseFeeGrowth(points []SeriesPoint) float64 {
+	if len(points) < 2 {
+		return 0
+	}
+
+	var meanX, meanY float64
+	for _, point := range points {
+		meanX += point.Timestamp.Sub(points[0].Timestamp).Hours()
+		meanY += math.Log(point.Value)
+	}
+	meanX /= float64(len(points))
+	meanY /= float64(len(points))
+
+	var covariance, variance float64
+	for _, point := range points {
+		dx := point.Timestamp.Sub(points[0].Timestamp).Hours() - meanX
+		covariance += dx * (math.Log(point.Value) - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0
+	}
+	return (math.Exp(covariance/variance) - 1) * 100
+}
+
+// congestionLevel classifies the network by how full its blocks are
+func congestionLevel(avgUtilization, fullBlockRatio float64) string {
+	switch {
+	case avgUtilization >= CongestionBusyUtilization || fullBlockRatio >= CongestionBusyFullRatio:
+		return CongestionBusy
+	case avgUtilization < CongestionQuietUtilization && fullBlockRatio <= CongestionQuietFullRatio:
+		return CongestionQuiet
+	}
+	return CongestionModerate
+}
+
+// congestionSummary describes the congestion and the fee outlook in plain language
+func congestionSummary(level string, growth float64) string {
+	rising, falling := growth > BaseFeeTrendThreshold, growth < -BaseFeeTrendThreshold
+	switch level {
+	case CongestionBusy:
+		if falling {
+			return "Network is busy, but fees are starting to ease"
+		}
+		return "Network is busy, fees likely to rise"
+	case CongestionQuiet:
+		if rising {
+			return "Network is quiet, though fees have been climbing"
+		}
+		return "Network is quiet, fees likely to stay low"
+	}
+	switch {
+	case rising:
+		return "Network activity is moderate, fees edging up"
+	case falling:
+		return "Network activity is moderate, fees easing"
+	}
+	return "Network activity is moderate, fees steady"
+}
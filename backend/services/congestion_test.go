@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const congestionGasLimit = 1_000_000
+
+// headerChain serves headers of synthetic blocks
+type headerChain struct {
+	ChainClient
+
+	headers map[uint64]*types.Header
+	head    uint64
+	reads   int
+}
+
+func (hc *headerChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return hc.head, nil
+}
+
+func (hc *headerChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		number = new(big.Int).SetUint64(hc.head)
+	}
+	header, ok := hc.headers[number.Uint64()]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	hc.reads++
+	return header, nil
+}
+
+func (hc *headerChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	header, err := hc.HeaderByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return types.NewBlockWithHeader(header), nil
+}
+
+func (hc *headerChain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(30 * gwei), nil
+}
+
+// congestionStats builds 60 blocks a minute apart per hour from start, with
+// the utilization of each block and a base fee per hour
+func congestionStats(start time.Time, firstBlock uint64, utilization func(hour, i int) float64, baseFee func(hour int) float64, hours int) []BlockStat {
+	var stats []BlockStat
+	for hour := 0; hour < hours; hour++ {
+		for i := 0; i < 60; i++ {
+			stats = append(stats, BlockStat{
+				Number:    firstBlock + uint64(len(stats)),
+				Timestamp: start.Add(time.Duration(hour)*time.Hour + time.Duration(i)*time.Minute),
+				GasUsed:   uint64(math.Round(utilization(hour, i) * congestionGasLimit)),
+				GasLimit:  congestionGasLimit,
+				BaseFee:   baseFee(hour),
+			})
+		}
+	}
+	return stats
+}
+
+func TestPercentile(t *testing.T) {
+	values := make([]float64, 20)
+	for i := range values {
+		// Shuffled 0.05, 0.10, ... 1.00
+		values[i] = float64((i*7)%20+1) / 20
+	}
+
+	assert.InDelta(t, 0.95, Percentile(values, 95), 1e-9)
+	assert.InDelta(t, 0.50, Percentile(values, 50), 1e-9)
+	assert.InDelta(t, 1.00, Percentile(values, 100), 1e-9)
+	assert.InDelta(t, 0.05, Percentile(values, 0), 1e-9)
+	assert.InDelta(t, 0.40, values[1], 1e-9, "input is left unsorted")
+
+	assert.Equal(t, 0.7, Percentile([]float64{0.7}, 95))
+	assert.Zero(t, Percentile(nil, 95))
+}
+
+func TestCongestionQuietAndCongestedPeriods(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewCongestionTracker(nil)
+	tracker.now = func() time.Time { return start.Add(6 * time.Hour) }
+
+	// Three quiet hours at 0-59% with a flat base fee
+	quiet := congestionStats(start, 1,
+		func(hour, i int) float64 { return float64(i) / 100 },
+		func(hour int) float64 { return 25 },
+		3)
+	// Then three congested hours with three in four blocks 95% full and the
+	// base fee up 10% an hour
+	congested := congestionStats(start.Add(3*time.Hour), uint64(len(quiet)+1),
+		func(hour, i int) float64 {
+			if i%4 == 0 {
+				return 0.5
+			}
+			return 0.95
+		},
+		func(hour int) float64 { return 25 * math.Pow(1.1, float64(hour)) },
+		3)
+	for _, stat := range append(quiet, congested...) {
+		tracker.Record(stat)
+	}
+
+	hours := tracker.Hours(start)
+	require.Len(t, hours, 6)
+
+	assert.Equal(t, start, hours[0].Hour)
+	assert.Equal(t, 60, hours[0].Blocks)
+	assert.InDelta(t, 0.295, hours[0].AvgUtilization, 1e-9)
+	// Nearest rank: the 57th of the 60 sorted values
+	assert.InDelta(t, 0.56, hours[0].P95Utilization, 1e-9)
+	assert.Zero(t, hours[0].FullBlocks)
+	assert.InDelta(t, 25, hours[0].AvgBaseFee, 1e-9)
+
+	// The hour in progress is included
+	last := hours[5]
+	assert.Equal(t, start.Add(5*time.Hour), last.Hour)
+	assert.InDelta(t, (15*0.5+45*0.95)/60, last.AvgUtilization, 1e-9)
+	assert.InDelta(t, 0.95, last.P95Utilization, 1e-9)
+	assert.Equal(t, 45, last.FullBlocks)
+	assert.InDelta(t, 0.75, last.FullBlockRatio, 1e-9)
+
+	quietReport := summarizeCongestion(hours[:3])
+	assert.Equal(t, 180, quietReport.Blocks)
+	assert.Equal(t, CongestionQuiet, quietReport.Level)
+	assert.InDelta(t, 0, quietReport.BaseFeeGrowth, 1e-9)
+	assert.Equal(t, "Network is quiet, fees likely to stay low", quietReport.Summary)
+
+	busyReport := tracker.Report(3 * time.Hour)
+	require.Len(t, busyReport.Hours, 3)
+	assert.Equal(t, start.Add(3*time.Hour), busyReport.Hours[0].Hour)
+	assert.InDelta(t, 0.8375, busyReport.AvgUtilization, 1e-9)
+	assert.InDelta(t, 0.95, busyReport.PeakP95Utilization, 1e-9)
+	assert.InDelta(t, 0.75, busyReport.FullBlockRatio, 1e-9)
+	assert.InDelta(t, 10, busyReport.BaseFeeGrowth, 1e-6)
+	assert.Equal(t, CongestionBusy, busyReport.Level)
+	assert.Equal(t, "Network is busy, fees likely to rise", busyReport.Summary)
+
+	// Over the day the average is moderate but enough blocks are full to be busy
+	dayReport := tracker.Report(24 * time.Hour)
+	assert.Equal(t, 360, dayReport.Blocks)
+	assert.InDelta(t, (0.295+0.8375)/2, dayReport.AvgUtilization, 1e-9)
+	assert.InDelta(t, 0.375, dayReport.FullBlockRatio, 1e-9)
+	assert.Equal(t, CongestionBusy, dayReport.Level)
+
+	// Blocks already seen and blocks of closed hours are ignored
+	tracker.Record(quiet[0])
+	tracker.Record(BlockStat{Number: 10_000, Timestamp: start, GasUsed: congestionGasLimit, GasLimit: congestionGasLimit})
+	assert.Equal(t, 60, tracker.Hours(start)[0].Blocks)
+
+	empty := NewCongestionTracker(nil).Report(time.Hour)
+	assert.Zero(t, empty.Blocks)
+	assert.Empty(t, empty.Hours)
+	assert.Equal(t, CongestionQuiet, empty.Level)
+}
+
+func TestCongestionSummaryThresholds(t *testing.T) {
+	levels := []struct {
+		avgUtilization float64
+		fullBlockRatio float64
+		level          string
+	}{
+		{0.70, 0, CongestionBusy},
+		{0.69, 0.19, CongestionModerate},
+		{0.40, 0.20, CongestionBusy},
+		{0.29, 0.01, CongestionQuiet},
+		{0.29, 0.02, CongestionModerate},
+		{0.30, 0, CongestionModerate},
+	}
+	for _, tt := range levels {
+		assert.Equal(t, tt.level, congestionLevel(tt.avgUtilization, tt.fullBlockRatio), "avg %.2f full %.2f", tt.avgUtilization, tt.fullBlockRatio)
+	}
+
+	summaries := []struct {
+		level   string
+		growth  float64
+		summary string
+	}{
+		{CongestionBusy, 0, "Network is busy, fees likely to rise"},
+		{CongestionBusy, -1.5, "Network is busy, but fees are starting to ease"},
+		{CongestionQuiet, 1, "Network is quiet, fees likely to stay low"},
+		{CongestionQuiet, 1.5, "Network is quiet, though fees have been climbing"},
+		{CongestionModerate, 1.5, "Network activity is moderate, fees edging up"},
+		{CongestionModerate, -1.5, "Network activity is moderate, fees easing"},
+		{CongestionModerate, -1, "Network activity is moderate, fees steady"},
+	}
+	for _, tt := range summaries {
+		assert.Equal(t, tt.summary, congestionSummary(tt.level, tt.growth))
+	}
+}
+
+func TestCongestionTrackerPoll(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	chain := &headerChain{headers: make(map[uint64]*types.Header)}
+	for number := uint64(1); number <= 1000; number++ {
+		chain.headers[number] = &types.Header{
+			Number:   new(big.Int).SetUint64(number),
+			Time:     uint64(start.Add(time.Duration(number) * time.Second).Unix()),
+			GasUsed:  congestionGasLimit,
+			GasLimit: congestionGasLimit,
+			BaseFee:  big.NewInt(25 * gwei),
+		}
+	}
+	tracker := NewCongestionTracker(chain)
+	tracker.now = func() time.Time { return start.Add(time.Hour) }
+
+	// A long way behind only the latest blocks are read
+	chain.head = 700
+	require.NoError(t, tracker.Poll(context.Background()))
+	assert.Equal(t, congestionMaxCatchUp, chain.reads)
+
+	chain.head = 710
+	require.NoError(t, tracker.Poll(context.Background()))
+	assert.Equal(t, congestionMaxCatchUp+10, chain.reads)
+
+	hours := tracker.Hours(start)
+	require.Len(t, hours, 1)
+	assert.Equal(t, congestionMaxCatchUp+10, hours[0].Blocks)
+	assert.InDelta(t, 1, hours[0].FullBlockRatio, 1e-9)
+	assert.InDelta(t, 25, hours[0].AvgBaseFee, 1e-9)
+
+	chain.head = 2000
+	assert.ErrorIs(t, tracker.Poll(context.Background()), ethereum.NotFound)
+}
+
+func TestGasAnswerIncludesCongestion(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	chain := &headerChain{headers: make(map[uint64]*types.Header), head: 1}
+	chain.headers[1] = &types.Header{Number: big.NewInt(1), GasUsed: 950_000, GasLimit: congestionGasLimit}
+
+	analyticsEngine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { analyticsEngine.Close() })
+	engine := NewChatEngine(chain, analyticsEngine, NewDataCollector(chain))
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{UserID: "0xuser", Message: "What are gas fees like?"})
+	require.NoError(t, err)
+	assert.NotContains(t, response.Response, "Congestion")
+
+	tracker := NewCongestionTracker(nil)
+	tracker.now = func() time.Time { return start.Add(time.Hour) }
+	for _, stat := range congestionStats(start, 1, func(hour, i int) float64 { return 0.95 }, func(hour int) float64 { return 25 }, 1) {
+		tracker.Record(stat)
+	}
+	engine.SetCongestionTracker(tracker)
+
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{UserID: "0xuser", Message: "What are gas fees like?"})
+	require.NoError(t, err)
+	assert.Equal(t, "gas_info", response.Type)
+	assert.Contains(t, response.Response, "Network is busy, fees likely to rise.")
+	assert.Contains(t, response.Response, "Blocks were 95% full on average over the last 3h, 100% of them over 90% full.")
+	assert.Contains(t, response.Data, "congestion")
+}
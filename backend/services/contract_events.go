@@ -0,0 +1,338 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrUnknownEvent is returned by decoders for logs of unregistered events
+var ErrUnknownEvent = errors.New("unknown event")
+
+// Event ABIs of the contracts the backend watches
+const (
+	erc20EventsABI = `[
+		{"type":"event","name":"Transfer","anonymous":false,"inputs":[
+			{"name":"from","type":"address","indexed":true},
+			{"name":"to","type":"address","indexed":true},
+			{"name":"value","type":"uint256","indexed":false}]},
+		{"type":"event","name":"Approval","anonymous":false,"inputs":[
+			{"name":"owner","type":"address","indexed":true},
+			{"name":"spender","type":"address","indexed":true},
+			{"name":"value","type":"uint256","indexed":false}]}
+	]`
+
+	// uniswapV2PairEventsABI is the Swap event of Uniswap V2 style pairs
+	uniswapV2PairEventsABI = `[
+		{"type":"event","name":"Swap","anonymous":false,"inputs":[
+			{"name":"sender","type":"address","indexed":true},
+			{"name":"amount0In","type":"uint256","indexed":false},
+			{"name":"amount1In","type":"uint256","indexed":false},
+			{"name":"amount0Out","type":"uint256","indexed":false},
+			{"name":"amount1Out","type":"uint256","indexed":false},
+			{"name":"to","type":"address","indexed":true}]}
+	]`
+
+	analyticsRegistryEventsABI = `[
+		{"type":"event","name":"TaskRegistered","anonymous":false,"inputs":[
+			{"name":"taskId","type":"uint256","indexed":true},
+			{"name":"requester","type":"address","indexed":true},
+			{"name":"taskType","type":"string","indexed":false},
+			{"name":"parameters","type":"string","indexed":false},
+			{"name":"timestamp","type":"uint256","indexed":false}]},
+		{"type":"event","name":"TaskCompleted","anonymous":false,"inputs":[
+			{"name":"taskId","type":"uint256","indexed":true},
+			{"name":"resultHash","type":"string","indexed":false},
+			{"name":"completionTime","type":"uint256","indexed":false}]},
+		{"type":"event","name":"RegistrationFeeUpdated","anonymous":false,"inputs":[
+			{"name":"oldFee","type":"uint256","indexed":false},
+			{"name":"newFee","type":"uint256","indexed":false}]}
+	]`
+
+	actionContractEventsABI = `[
+		{"type":"event","name":"ActionRequested","anonymous":false,"inputs":[
+			{"name":"actionId","type":"uint256","indexed":true},
+			{"name":"user","type":"address","indexed":true},
+			{"name":"actionType","type":"string","indexed":false},
+			{"name":"parameters","type":"string","indexed":false},
+			{"name":"timestamp","type":"uint256","indexed":false}]},
+		{"type":"event","name":"ActionExecuted","anonymous":false,"inputs":[
+			{"name":"actionId","type":"uint256","indexed":true},
+			{"name":"user","type":"address","indexed":true},
+			{"name":"actionType","type":"string","indexed":false},
+			{"name":"isSuccessful","type":"bool","indexed":false},
+			{"name":"result","type":"string","indexed":false},
+			{"name":"gasUsed","type":"uint256","indexed":false}]},
+		{"type":"event","name":"ActionTypeRegistered","anonymous":false,"inputs":[
+			{"name":"actionType","type":"string","indexed":false},
+			{"name":"gasLimit","type":"uint256","indexed":false},
+			{"name":"fee","type":"uint256","indexed":false}]}
+	]`
+
+	// stakingPoolEventsABI covers the events of staking pool contracts. A
+	// stake's unlock time is when the user's whole position may be unstaked.
+	stakingPoolEventsABI = `[
+		{"type":"event","name":"Staked","anonymous":false,"inputs":[
+			{"name":"user","type":"address","indexed":true},
+			{"name":"amount","type":"uint256","indexed":false},
+			{"name":"unlockTime","type":"uint256","indexed":false}]},
+		{"type":"event","name":"Unstaked","anonymous":false,"inputs":[
+			{"name":"user","type":"address","indexed":true},
+			{"name":"amount","type":"uint256","indexed":false}]},
+		{"type":"event","name":"RewardClaimed","anonymous":false,"inputs":[
+			{"name":"user","type":"address","indexed":true},
+			{"name":"amount","type":"uint256","indexed":false}]}
+	]`
+)
+
+// Typed events. Field names follow the ABI argument names the way abigen
+// does, which is how arguments are matched to fields when decoding.
+
+// ERC20Transfer is an ERC-20 Transfer event
+type ERC20Transfer struct {
+	From  common.Address
+	To    common.Address
+	Value *big.Int
+}
+
+// ERC20Approval is an ERC-20 Approval event
+type ERC20Approval struct {
+	Owner   common.Address
+	Spender common.Address
+	Value   *big.Int
+}
+
+// PairSwap is a Uniswap V2 style pair's Swap event. The sender is usually a
+// router; to receives the output.
+type PairSwap struct {
+	Sender     common.Address
+	Amount0In  *big.Int
+	Amount1In  *big.Int
+	Amount0Out *big.Int
+	Amount1Out *big.Int
+	To         common.Address
+}
+
+// TaskRegistered is emitted by the AnalyticsRegistry when a task is registered
+type TaskRegistered struct {
+	TaskId     *big.Int
+	Requester  common.Address
+	TaskType   string
+	Parameters string
+	Timestamp  *big.Int
+}
+
+// TaskCompleted is emitted by the AnalyticsRegistry when a task is completed
+type TaskCompleted struct {
+	TaskId         *big.Int
+	ResultHash     string
+	CompletionTime *big.Int
+}
+
+// RegistrationFeeUpdated is emitted by the AnalyticsRegistry when its fee changes
+type RegistrationFeeUpdated struct {
+	OldFee *big.Int
+	NewFee *big.Int
+}
+
+// ActionRequested is emitted by the ActionContract when a user requests an action
+type ActionRequested struct {
+	ActionId   *big.Int
+	User       common.Address
+	ActionType string
+	Parameters string
+	Timestamp  *big.Int
+}
+
+// ActionExecuted is emitted by the ActionContract when an action has run
+type ActionExecuted struct {
+	ActionId     *big.Int
+	User         common.Address
+	ActionType   string
+	IsSuccessful bool
+	Result       string
+	GasUsed      *big.Int
+}
+
+// ActionTypeRegistered is emitted by the ActionContract when an action type is added
+type ActionTypeRegistered struct {
+	ActionType string
+	GasLimit   *big.Int
+	Fee        *big.Int
+}
+
+// PoolStaked is emitted by a staking pool when a user stakes
+type PoolStaked struct {
+	User       common.Address
+	Amount     *big.Int
+	UnlockTime *big.Int
+}
+
+// PoolUnstaked is emitted by a staking pool when a user withdraws principal
+type PoolUnstaked struct {
+	User   common.Address
+	Amount *big.Int
+}
+
+// PoolRewardClaimed is emitted by a staking pool when a user claims rewards
+type PoolRewardClaimed struct {
+	User   common.Address
+	Amount *big.Int
+}
+
+// EventDecoder turns a log into a typed event and names the event it decoded.
+// Logs the decoder doesn't know return ErrUnknownEvent.
+type EventDecoder interface {
+	Decode(log types.Log) (name string, event interface{}, err error)
+}
+
+// registeredEvent is an ABI event and the struct type it decodes into
+type registeredEvent struct {
+	event     abi.Event
+	eventType reflect.Type
+}
+
+// ABIEventDecoder decodes logs of registered ABI events into typed structs,
+// matched by their signature topic
+type ABIEventDecoder struct {
+	events map[common.Hash]registeredEvent
+}
+
+// NewABIEventDecoder creates a decoder with no events registered
+func NewABIEventDecoder() *ABIEventDecoder {
+	return &ABIEventDecoder{events: make(map[common.Hash]registeredEvent)}
+}
+
+// Register adds an event from a JSON ABI. prototype is a value of the struct
+// the event decodes into; its fields are the camel-cased argument names.
+func (d *ABIEventDecoder) Register(abiJSON, name string, prototype interface{}) error {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return fmt.Errorf("failed to parse ABI: %w", err)
+	}
+	event, ok := parsed.Events[name]
+	if !ok {
+		return fmt.Errorf("event %s not found in ABI", name)
+	}
+
+	eventType := reflect.TypeOf(prototype)
+	if eventType == nil || eventType.Kind() != reflect.Struct {
+		return fmt.Errorf("event %s must decode into a struct, got %T", name, prototype)
+	}
+	for _, input := range event.Inputs {
+		if _, ok := eventType.FieldByName(abi.ToCamelCase(input.Name)); !ok {
+			return fmt.Errorf("%s has no field for argument %s of event %s", eventType.Name(), input.Name, name)
+		}
+	}
+
+	d.events[event.ID] = registeredEvent{event: event, eventType: eventType}
+	return nil
+}
+
+// Decode decodes a log into a value of the struct registered for its event
+func (d *ABIEventDecoder) Decode(log types.Log) (string, interface{}, error) {
+	if len(log.Topics) == 0 {
+		return "", nil, ErrUnknownEvent
+	}
+	registered, ok := d.events[log.Topics[0]]
+	if !ok {
+		return "", nil, ErrUnknownEvent
+	}
+	event := registered.event
+
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	// ERC-721 Transfer shares its signature with ERC-20 but also indexes the token ID
+	if len(log.Topics)-1 != len(indexed) {
+		return event.Name, nil, fmt.Errorf("%w: %s log has %d indexed topics, expected %d", ErrUnknownEvent, event.Name, len(log.Topics)-1, len(indexed))
+	}
+
+	out := reflect.New(registered.eventType)
+	if len(log.Data) > 0 {
+		values, err := event.Inputs.Unpack(log.Data)
+		if err != nil {
+			return event.Name, nil, fmt.Errorf("failed to unpack %s data: %w", event.Name, err)
+		}
+		if err := event.Inputs.Copy(out.Interface(), values); err != nil {
+			return event.Name, nil, fmt.Errorf("failed to copy %s data: %w", event.Name, err)
+		}
+	}
+	if err := abi.ParseTopics(out.Interface(), indexed, log.Topics[1:]); err != nil {
+		return event.Name, nil, fmt.Errorf("failed to parse %s topics: %w", event.Name, err)
+	}
+
+	return event.Name, out.Elem().Interface(), nil
+}
+
+// mustRegisterEvents builds a decoder for the named events of an ABI. The ABIs
+// are constants, so a failure is a programming error.
+func mustRegisterEvents(abiJSON string, prototypes map[string]interface{}) *ABIEventDecoder {
+	decoder := NewABIEventDecoder()
+	for name, prototype := range prototypes {
+		if err := decoder.Register(abiJSON, name, prototype); err != nil {
+			panic(err)
+		}
+	}
+	return decoder
+}
+
+// NewERC20TransferDecoder decodes ERC-20 Transfer events into ERC20Transfer
+func NewERC20TransferDecoder() *ABIEventDecoder {
+	return mustRegisterEvents(erc20EventsABI, map[string]interface{}{
+		"Transfer": ERC20Transfer{},
+	})
+}
+
+// NewPairSwapDecoder decodes Uniswap V2 style pair Swap events into PairSwap
+func NewPairSwapDecoder() *ABIEventDecoder {
+	return mustRegisterEvents(uniswapV2PairEventsABI, map[string]interface{}{
+		"Swap": PairSwap{},
+	})
+}
+
+// NewAnalyticsRegistryDecoder decodes the AnalyticsRegistry contract's events
+func NewAnalyticsRegistryDecoder() *ABIEventDecoder {
+	return mustRegisterEvents(analyticsRegistryEventsABI, map[string]interface{}{
+		"TaskRegistered":         TaskRegistered{},
+		"TaskCompleted":          TaskCompleted{},
+		"RegistrationFeeUpdated": RegistrationFeeUpdated{},
+	})
+}
+
+// NewActionContractDecoder decodes the ActionContract's events
+func NewActionContractDecoder() *ABIEventDecoder {
+	return mustRegisterEvents(actionContractEventsABI, map[string]interface{}{
+		"ActionRequested":      ActionRequested{},
+		"ActionExecuted":       ActionExecuted{},
+		"ActionTypeRegistered": ActionTypeRegistered{},
+	})
+}
+
+// NewStakingPoolDecoder decodes the events of staking pool contracts
+func NewStakingPoolDecoder() *ABIEventDecoder {
+	return mustRegisterEvents(stakingPoolEventsABI, map[string]interface{}{
+		"Staked":        PoolStaked{},
+		"Unstaked":      PoolUnstaked{},
+		"RewardClaimed": PoolRewardClaimed{},
+	})
+}
+
+// EventTopics returns the signature topics of the registered events, for use
+// as the first topic filter of a query
+func (d *ABIEventDecoder) EventTopics() []common.Hash {
+	topics := make([]common.Hash, 0, len(d.events))
+	for id := range d.events {
+		topics = append(topics, id)
+	}
+	return topics
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	eventBufferSize   = 128
+	eventCatchUpChunk = 2000
+	// eventReplayDepth is how many blocks before the newest one seen are
+	// replayed after a reconnect, so logs reorged in the meantime are picked up
+	eventReplayDepth = 12
+	// eventDedupDepth is how many blocks of (blockHash, logIndex) keys are kept
+	// to drop replayed logs; it must exceed eventReplayDepth
+	eventDedupDepth = 128
+)
+
+// DecodedEvent is a decoded contract log. Removed is set when a previously
+// delivered log was dropped by a reorg.
+type DecodedEvent struct {
+	Name        string         `json:"name"`
+	Address     common.Address `json:"address"`
+	BlockNumber uint64         `json:"block_number"`
+	BlockHash   common.Hash    `json:"block_hash"`
+	TxHash      common.Hash    `json:"tx_hash"`
+	LogIndex    uint           `json:"log_index"`
+	Removed     bool           `json:"removed"`
+	Event       interface{}    `json:"event"`
+}
+
+// ContractManager is the shared machinery for watching contract events. It
+// keeps log subscriptions alive across reconnects, resuming from the last block
+// seen, and falls back to polling when the node can't push logs.
+type ContractManager struct {
+	client       ChainClient
+	logger       *log.Logger
+	pollInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	replayDepth  uint64
+}
+
+// NewContractManager creates a contract manager reading logs through the client
+func NewContractManager(client ChainClient) *ContractManager {
+	return &ContractManager{
+		client:       client,
+		logger:       log.New(log.Writer(), "[ContractManager] ", log.LstdFlags),
+		pollInterval: 5 * time.Second,
+		minBackoff:   time.Second,
+		maxBackoff:   time.Minute,
+		replayDepth:  eventReplayDepth,
+	}
+}
+
+// SubscribeEvents delivers the decoded logs matching the query until ctx is
+// cancelled, when the channel is closed. Logs from query.FromBlock onwards are
+// replayed first; without it delivery starts at the current head. Logs the
+// decoder doesn't recognise are skipped.
+func (cm *ContractManager) SubscribeEvents(ctx context.Context, query ethereum.FilterQuery, decoder EventDecoder) (<-chan DecodedEvent, error) {
+	if decoder == nil {
+		return nil, errors.New("event decoder is required")
+	}
+	if query.ToBlock != nil || query.BlockHash != nil {
+		return nil, errors.New("event subscriptions follow the chain head, so the query can't set ToBlock or BlockHash")
+	}
+
+	stream := &eventStream{
+		cm:      cm,
+		query:   query,
+		decoder: decoder,
+		out:     make(chan DecodedEvent, eventBufferSize),
+		seen:    make(map[logKey]uint64),
+	}
+	stream.query.FromBlock = nil
+
+	logs := make(chan types.Log, eventBufferSize)
+	sub, err := cm.client.SubscribeFilterLogs(ctx, stream.query, logs)
+	if err != nil && !errors.Is(err, rpc.ErrNotificationsUnsupported) {
+		return nil, fmt.Errorf("failed to subscribe to logs: %w", err)
+	}
+
+	if query.FromBlock != nil {
+		stream.from = query.FromBlock.Uint64()
+	} else {
+		head, err := cm.client.BlockNumber(ctx)
+		if err != nil {
+			if sub != nil {
+				sub.Unsubscribe()
+			}
+			return nil, fmt.Errorf("failed to get head block: %w", err)
+		}
+		stream.from = head
+	}
+
+	if sub == nil {
+		cm.logger.Printf("Node doesn't support log subscriptions, polling every %s", cm.pollInterval)
+		go func() {
+			defer close(stream.out)
+			stream.poll(ctx)
+		}()
+	} else {
+		go stream.run(ctx, sub, logs)
+	}
+	return stream.out, nil
+}
+
+// Watch calls handler for every event matching the query until ctx is cancelled
+func (cm *ContractManager) Watch(ctx context.Context, query ethereum.FilterQuery, decoder EventDecoder, handler func(DecodedEvent)) error {
+	events, err := cm.SubscribeEvents(ctx, query, decoder)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for event := range events {
+			handler(event)
+		}
+	}()
+	return nil
+}
+
+// WatchContract calls handler for every event of the contract the decoder knows
+func (cm *ContractManager) WatchContract(ctx context.Context, address common.Address, decoder *ABIEventDecoder, handler func(DecodedEvent)) error {
+	query := ethereum.FilterQuery{
+		Addresses: []common.Address{address},
+		Topics:    [][]common.Hash{decoder.EventTopics()},
+	}
+	return cm.Watch(ctx, query, decoder, handler)
+}
+
+// logKey identifies a log across replays of the same block
+type logKey struct {
+	blockHash common.Hash
+	index     uint
+}
+
+// eventStream is the state of one subscription. It is only touched by the
+// goroutine delivering its events.
+type eventStream struct {
+	cm      *ContractManager
+	query   ethereum.FilterQuery
+	decoder EventDecoder
+	out     chan DecodedEvent
+
+	seen    map[logKey]uint64 // delivered logs -> block number
+	from    uint64            // block replayed from after a reconnect
+	highest uint64
+}
+
+// run delivers events until ctx is cancelled, resubscribing with backoff
+// whenever the subscription fails
+func (s *eventStream) run(ctx context.Context, sub ethereum.Subscription, logs chan types.Log) {
+	defer close(s.out)
+
+	backoff := s.cm.minBackoff
+	for {
+		started := time.Now()
+		err := s.stream(ctx, sub, logs)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > s.cm.maxBackoff {
+			backoff = s.cm.minBackoff
+		}
+		s.cm.logger.Printf("Log subscription dropped, resuming from block %d: %v", s.from, err)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, s.cm.maxBackoff)
+
+			logs = make(chan types.Log, eventBufferSize)
+			sub, err = s.cm.client.SubscribeFilterLogs(ctx, s.query, logs)
+			if err == nil {
+				break
+			}
+			if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+				s.poll(ctx)
+				return
+			}
+			s.cm.logger.Printf("Failed to resubscribe to logs: %v", err)
+		}
+	}
+}
+
+// stream replays the logs missed since s.from and then delivers live logs
+// until the subscription fails
+func (s *eventStream) stream(ctx context.Context, sub ethereum.Subscription, logs <-chan types.Log) error {
+	defer sub.Unsubscribe()
+
+	if err := s.catchUp(ctx); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sub.Err():
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return err
+		case log := <-logs:
+			if err := s.handle(ctx, log); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// poll replays new logs every poll interval until ctx is cancelled
+func (s *eventStream) poll(ctx context.Context) {
+	ticker := time.NewTicker(s.cm.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.catchUp(ctx); err != nil && ctx.Err() == nil {
+			s.cm.logger.Printf("Failed to poll logs from block %d: %v", s.from, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// catchUp delivers the logs from s.from to the current head in bounded ranges
+func (s *eventStream) catchUp(ctx context.Context) error {
+	head, err := s.cm.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get head block: %w", err)
+	}
+
+	for start := s.from; start <= head; start += eventCatchUpChunk {
+		query := s.query
+		query.FromBlock = new(big.Int).SetUint64(start)
+		query.ToBlock = new(big.Int).SetUint64(min(start+eventCatchUpChunk-1, head))
+
+		logs, err := s.cm.client.FilterLogs(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to filter logs from block %d: %w", start, err)
+		}
+		for _, log := range logs {
+			if err := s.handle(ctx, log); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.advance(head)
+	return nil
+}
+
+// handle deduplicates a log, decodes it, and delivers it
+func (s *eventStream) handle(ctx context.Context, log types.Log) error {
+	key := logKey{blockHash: log.BlockHash, index: log.Index}
+	if log.Removed {
+		if _, ok := s.seen[key]; !ok {
+			return nil
+		}
+		delete(s.seen, key)
+	} else {
+		if _, ok := s.seen[key]; ok {
+			return nil
+		}
+		s.seen[key] = log.BlockNumber
+		s.advance(log.BlockNumber)
+	}
+
+	name, event, err := s.decoder.Decode(log)
+	if err != nil {
+		if !errors.Is(err, ErrUnknownEvent) {
+			s.cm.logger.Printf("Failed to decode log %d of block %d: %v", log.Index, log.BlockNumber, err)
+		}
+		return nil
+	}
+
+	select {
+	case s.out <- DecodedEvent{
+		Name:        name,
+		Address:     log.Address,
+		BlockNumber: log.BlockNumber,
+		BlockHash:   log.BlockHash,
+		TxHash:      log.TxHash,
+		LogIndex:    log.Index,
+		Removed:     log.Removed,
+		Event:       event,
+	}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// advance moves the resume point up to replayDepth blocks behind the newest
+// block seen and forgets dedup keys too old to be replayed
+func (s *eventStream) advance(block uint64) {
+	if block <= s.highest {
+		return
+	}
+	s.highest = block
+
+	if block > s.cm.replayDepth && block-s.cm.replayDepth > s.from {
+		s.from = block - s.cm.replayDepth
+	}
+	if block > eventDedupDepth {
+		for key, number := range s.seen {
+			if number < block-eventDedupDepth {
+				delete(s.seen, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var registryAddress = common.HexToAddress("0x00000000000000000000000000000000000000e1")
+
+// fakeLogSubscription is a live log subscription the test pushes logs through
+type fakeLogSubscription struct {
+	logs chan<- types.Log
+	errc chan error
+	once sync.Once
+}
+
+func (f *fakeLogSubscription) Err() <-chan error { return f.errc }
+
+func (f *fakeLogSubscription) Unsubscribe() {
+	f.once.Do(func() { close(f.errc) })
+}
+
+// fakeLogNode serves canonical logs to FilterLogs and hands every live
+// subscription to the test
+type fakeLogNode struct {
+	ChainClient
+
+	mu          sync.Mutex
+	head        uint64
+	logs        []types.Log
+	filters     []ethereum.FilterQuery
+	unsupported bool
+	subs        chan *fakeLogSubscription
+}
+
+func newFakeLogNode(head uint64, logs ...types.Log) *fakeLogNode {
+	return &fakeLogNode{head: head, logs: logs, subs: make(chan *fakeLogSubscription, 4)}
+}
+
+func (fn *fakeLogNode) setChain(head uint64, logs ...types.Log) {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	fn.head, fn.logs = head, logs
+}
+
+func (fn *fakeLogNode) BlockNumber(ctx context.Context) (uint64, error) {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	return fn.head, nil
+}
+
+func (fn *fakeLogNode) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	fn.filters = append(fn.filters, query)
+	var matched []types.Log
+	for _, log := range fn.logs {
+		if log.BlockNumber >= query.FromBlock.Uint64() && log.BlockNumber <= query.ToBlock.Uint64() {
+			matched = append(matched, log)
+		}
+	}
+	return matched, nil
+}
+
+func (fn *fakeLogNode) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	if fn.unsupported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := &fakeLogSubscription{logs: ch, errc: make(chan error, 1)}
+	fn.subs <- sub
+	return sub, nil
+}
+
+func (fn *fakeLogNode) filterStarts() []uint64 {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	starts := make([]uint64, len(fn.filters))
+	for i, query := range fn.filters {
+		starts[i] = query.FromBlock.Uint64()
+	}
+	return starts
+}
+
+// taskRegisteredLog builds an AnalyticsRegistry TaskRegistered log
+func taskRegisteredLog(t *testing.T, block uint64, blockHash string, index uint, taskID int64) types.Log {
+	parsed, err := abi.JSON(strings.NewReader(analyticsRegistryEventsABI))
+	require.NoError(t, err)
+	event := parsed.Events["TaskRegistered"]
+	data, err := event.Inputs.NonIndexed().Pack("yield_analysis", `{"pool":"KAIA-USDT"}`, big.NewInt(1700000000))
+	require.NoError(t, err)
+
+	return types.Log{
+		Address:     registryAddress,
+		Topics:      []common.Hash{event.ID, common.BigToHash(big.NewInt(taskID)), common.BytesToHash(summaryAddress.Bytes())},
+		Data:        data,
+		BlockNumber: block,
+		BlockHash:   common.HexToHash(blockHash),
+		TxHash:      common.BigToHash(big.NewInt(taskID)),
+		Index:       index,
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan DecodedEvent) DecodedEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "event channel closed")
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return DecodedEvent{}
+	}
+}
+
+func assertNoEvent(t *testing.T, events <-chan DecodedEvent) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %s at block %d", event.Name, event.BlockNumber)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestABIEventDecoderDecodesTypedEvents(t *testing.T) {
+	name, event, err := NewAnalyticsRegistryDecoder().Decode(taskRegisteredLog(t, 1, "0xa1", 0, 7))
+	require.NoError(t, err)
+	assert.Equal(t, "TaskRegistered", name)
+	assert.Equal(t, TaskRegistered{
+		TaskId:     big.NewInt(7),
+		Requester:  summaryAddress,
+		TaskType:   "yield_analysis",
+		Parameters: `{"pool":"KAIA-USDT"}`,
+		Timestamp:  big.NewInt(1700000000),
+	}, event)
+
+	actionABI, err := abi.JSON(strings.NewReader(actionContractEventsABI))
+	require.NoError(t, err)
+	executed := actionABI.Events["ActionExecuted"]
+	data, err := executed.Inputs.NonIndexed().Pack("swap", true, "ok", big.NewInt(21000))
+	require.NoError(t, err)
+	name, event, err = NewActionContractDecoder().Decode(types.Log{
+		Topics: []common.Hash{executed.ID, common.BigToHash(big.NewInt(3)), common.BytesToHash(summaryAddress.Bytes())},
+		Data:   data,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ActionExecuted", name)
+	assert.Equal(t, ActionExecuted{ActionId: big.NewInt(3), User: summaryAddress, ActionType: "swap", IsSuccessful: true, Result: "ok", GasUsed: big.NewInt(21000)}, event)
+
+	erc20ABI, err := abi.JSON(strings.NewReader(erc20EventsABI))
+	require.NoError(t, err)
+	transfer := erc20ABI.Events["Transfer"]
+	to := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	data, err = transfer.Inputs.NonIndexed().Pack(big.NewInt(5e18))
+	require.NoError(t, err)
+	transferTopics := []common.Hash{transfer.ID, common.BytesToHash(summaryAddress.Bytes()), common.BytesToHash(to.Bytes())}
+
+	_, event, err = NewERC20TransferDecoder().Decode(types.Log{Topics: transferTopics, Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, ERC20Transfer{From: summaryAddress, To: to, Value: big.NewInt(5e18)}, event)
+
+	// ERC-721 transfers share the signature but index the token ID
+	_, _, err = NewERC20TransferDecoder().Decode(types.Log{Topics: append(transferTopics, common.BigToHash(big.NewInt(1)))})
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+
+	_, _, err = NewERC20TransferDecoder().Decode(taskRegisteredLog(t, 1, "0xa1", 0, 7))
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestABIEventDecoderRejectsMismatchedStructs(t *testing.T) {
+	type wrongShape struct{ Sender common.Address }
+	err := NewABIEventDecoder().Register(erc20EventsABI, "Transfer", wrongShape{})
+	assert.ErrorContains(t, err, "no field for argument from")
+}
+
+func TestSubscribeEventsResumesAndDeduplicates(t *testing.T) {
+	node := newFakeLogNode(11,
+		taskRegisteredLog(t, 10, "0xa10", 0, 1),
+		taskRegisteredLog(t, 11, "0xa11", 0, 2),
+	)
+	manager := NewContractManager(node)
+	manager.minBackoff = time.Millisecond
+	manager.replayDepth = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := manager.SubscribeEvents(ctx, ethereum.FilterQuery{FromBlock: big.NewInt(10)}, NewAnalyticsRegistryDecoder())
+	require.NoError(t, err)
+
+	// Historic logs are replayed from FromBlock
+	assert.Equal(t, uint64(10), nextEvent(t, events).BlockNumber)
+	registered := nextEvent(t, events)
+	assert.Equal(t, uint64(11), registered.BlockNumber)
+	assert.Equal(t, big.NewInt(2), registered.Event.(TaskRegistered).TaskId)
+
+	// Live logs arrive through the subscription; a repeated one is dropped
+	first := <-node.subs
+	first.logs <- taskRegisteredLog(t, 12, "0xa12", 0, 3)
+	first.logs <- taskRegisteredLog(t, 12, "0xa12", 0, 3)
+	live := nextEvent(t, events)
+	assert.Equal(t, uint64(12), live.BlockNumber)
+	assert.False(t, live.Removed)
+
+	// A reorg removes block 12 and the subscription drops
+	removed := taskRegisteredLog(t, 12, "0xa12", 0, 3)
+	removed.Removed = true
+	first.logs <- removed
+	reverted := nextEvent(t, events)
+	assert.True(t, reverted.Removed)
+	assert.Equal(t, common.HexToHash("0xa12"), reverted.BlockHash)
+
+	node.setChain(13,
+		taskRegisteredLog(t, 10, "0xa10", 0, 1),
+		taskRegisteredLog(t, 11, "0xa11", 0, 2),
+		taskRegisteredLog(t, 12, "0xb12", 0, 3),
+		taskRegisteredLog(t, 13, "0xb13", 0, 4),
+	)
+	first.errc <- errors.New("connection reset")
+
+	// After resubscribing, the last blocks are replayed; only the new logs come through
+	<-node.subs
+	replayed := nextEvent(t, events)
+	assert.Equal(t, common.HexToHash("0xb12"), replayed.BlockHash)
+	assert.Equal(t, uint64(13), nextEvent(t, events).BlockNumber)
+	assertNoEvent(t, events)
+
+	assert.Equal(t, []uint64{10, 10}, node.filterStarts(), "resumes replayDepth blocks behind the newest block seen")
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestSubscribeEventsPollsWithoutSubscriptionSupport(t *testing.T) {
+	node := newFakeLogNode(20)
+	node.unsupported = true
+	manager := NewContractManager(node)
+	manager.pollInterval = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := manager.SubscribeEvents(ctx, ethereum.FilterQuery{}, NewAnalyticsRegistryDecoder())
+	require.NoError(t, err)
+
+	node.setChain(22, taskRegisteredLog(t, 21, "0xc21", 0, 1), taskRegisteredLog(t, 22, "0xc22", 0, 2))
+	assert.Equal(t, uint64(21), nextEvent(t, events).BlockNumber)
+	assert.Equal(t, uint64(22), nextEvent(t, events).BlockNumber)
+	assertNoEvent(t, events)
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Names of the project contracts in a deployment manifest
+const (
+	ContractAnalyticsRegistry    = "AnalyticsRegistry"
+	ContractDataContract         = "DataContract"
+	ContractActionContract       = "ActionContract"
+	ContractSubscriptionContract = "SubscriptionContract"
+	ContractGovernanceToken      = "GovernanceToken"
+)
+
+// contractManifestMaxBytes bounds a manifest fetched from a URL
+const contractManifestMaxBytes = 1 << 20
+
+// ErrContractWithoutCode is returned when a manifest names an address with no
+// contract code deployed at it
+var ErrContractWithoutCode = errors.New("contract has no code")
+
+// ContractManifest maps chain IDs to the addresses of the deployed contracts
+// by name, read from JSON such as {"1001": {"AnalyticsRegistry": "0x..."}}
+type ContractManifest map[int64]map[string]common.Address
+
+// ContractCodeReader reads the code deployed at an address
+type ContractCodeReader interface {
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+}
+
+// ParseContractManifest parses and validates a deployment manifest
+func ParseContractManifest(data []byte) (ContractManifest, error) {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse contract manifest: %w", err)
+	}
+
+	manifest := make(ContractManifest, len(raw))
+	for chain, contracts := range raw {
+		chainID, err := strconv.ParseInt(chain, 10, 64)
+		if err != nil || chainID <= 0 {
+			return nil, fmt.Errorf("invalid contract manifest: chain ID %q must be a positive integer", chain)
+		}
+		addresses := make(map[string]common.Address, len(contracts))
+		for name, address := range contracts {
+			if strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid contract manifest: chain %d has a contract without a name", chainID)
+			}
+			if !common.IsHexAddress(address) || common.HexToAddress(address) == (common.Address{}) {
+				return nil, fmt.Errorf("invalid contract manifest: %s on chain %d must be a nonzero 0x-prefixed address, got %q", name, chainID, address)
+			}
+			addresses[name] = common.HexToAddress(address)
+		}
+		manifest[chainID] = addresses
+	}
+	return manifest, nil
+}
+
+// LoadContractManifest reads a deployment manifest from a file path or an
+// http(s) URL
+func LoadContractManifest(ctx context.Context, client *http.Client, source string) (ContractManifest, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read contract manifest: %w", err)
+		}
+		return ParseContractManifest(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract manifest: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch contract manifest: %s returned %d", source, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, contractManifestMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch contract manifest: %w", err)
+	}
+	return ParseContractManifest(data)
+}
+
+// VerifyContractCode checks that every contract has code deployed, listing
+// all the addresses that don't in one error
+func VerifyContractCode(ctx context.Context, code ContractCodeReader, chainID int64, contracts map[string]common.Address) error {
+	names := make([]string, 0, len(contracts))
+	for name := range contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var missing []string
+	for _, name := range names {
+		deployed, err := code.CodeAt(ctx, contracts[name], nil)
+		if err != nil {
+			return fmt.Errorf("failed to read the code of %s at %s: %w", name, contracts[name].Hex(), err)
+		}
+		if len(deployed) == 0 {
+			missing = append(missing, fmt.Sprintf("%s at %s", name, contracts[name].Hex()))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w on chain %d: %s", ErrContractWithoutCode, chainID, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// ContractDeploymentStatus describes the loaded deployment manifest
+type ContractDeploymentStatus struct {
+	Source    string            `json:"source"`
+	ChainID   int64             `json:"chain_id"`
+	Contracts map[string]string `json:"contracts"`
+	LoadedAt  APITime           `json:"loaded_at"`
+	Reloads   int               `json:"reloads"`
+}
+
+// ContractDeployments is the address book of the project's contracts on one
+// chain, read from a deployment manifest. Loads verify every address has
+// code before swapping it in, so a bad manifest leaves the current addresses
+// in place. Contracts whose address changes are reported to the OnChange
+// handlers, which swap the clients built on them.
+type ContractDeployments struct {
+	source  string
+	chainID int64
+	code    ContractCodeReader
+	client  *http.Client
+	logger  *log.Logger
+	now     func() time.Time
+
+	// loadMu serializes loads, so handlers see changes in order
+	loadMu    sync.Mutex
+	mu        sync.RWMutex
+	contracts map[string]common.Address
+	loadedAt  time.Time
+	loads     int
+	handlers  []func(name string, address common.Address)
+}
+
+// NewContractDeployments creates an empty address book for the chain; Load
+// fills it
+func NewContractDeployments(source string, chainID int64, code ContractCodeReader) *ContractDeployments {
+	return &ContractDeployments{
+		source:    source,
+		chainID:   chainID,
+		code:      code,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		logger:    log.New(log.Writer(), "[ContractDeployments] ", log.LstdFlags),
+		now:       utcNow,
+		contracts: make(map[string]common.Address),
+	}
+}
+
+// OnChange registers a handler called with each contract whose address a
+// load changes. Handlers run after the swap, one load at a time.
+func (cd *ContractDeployments) OnChange(handler func(name string, address common.Address)) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	cd.handlers = append(cd.handlers, handler)
+}
+
+// Load reads the manifest, verifies the chain's contracts have code, and
+// swaps them in. Returns the names of the contracts whose address changed.
+func (cd *ContractDeployments) Load(ctx context.Context) ([]string, error) {
+	cd.loadMu.Lock()
+	defer cd.loadMu.Unlock()
+
+	manifest, err := LoadContractManifest(ctx, cd.client, cd.source)
+	if err != nil {
+		return nil, err
+	}
+	contracts, ok := manifest[cd.chainID]
+	if !ok {
+		return nil, fmt.Errorf("contract manifest has no contracts for chain %d", cd.chainID)
+	}
+	if err := VerifyContractCode(ctx, cd.code, cd.chainID, contracts); err != nil {
+		return nil, err
+	}
+
+	cd.mu.Lock()
+	var changed []string
+	for name, address := range contracts {
+		if current, ok := cd.contracts[name]; !ok || current != address {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	cd.contracts = contracts
+	cd.loadedAt = cd.now()
+	cd.loads++
+	handlers := append([]func(string, common.Address){}, cd.handlers...)
+	cd.mu.Unlock()
+
+	for _, name := range changed {
+		for _, handler := range handlers {
+			handler(name, contracts[name])
+		}
+	}
+	cd.logger.Printf("Loaded %d contracts for chain %d from %s, %d changed", len(contracts), cd.chainID, cd.source, len(changed))
+	return changed, nil
+}
+
+// Address returns the deployed address of a contract
+func (cd *ContractDeployments) Address(name string) (common.Address, bool) {
+	cd.mu.RLock()
+	defer cd.mu.RUnlock()
+
+	address, ok := cd.contracts[name]
+	return address, ok
+}
+
+// Status describes the loaded manifest
+func (cd *ContractDeployments) Status() ContractDeploymentStatus {
+	cd.mu.RLock()
+	defer cd.mu.RUnlock()
+
+	status := ContractDeploymentStatus{
+		Source:    cd.source,
+		ChainID:   cd.chainID,
+		Contracts: make(map[string]string, len(cd.contracts)),
+		LoadedAt:  NewAPITime(cd.loadedAt),
+	}
+	for name, address := range cd.contracts {
+		status.Contracts[name] = address.Hex()
+	}
+	if cd.loads > 0 {
+		status.Reloads = cd.loads - 1
+	}
+	return status
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	manifestRegistry    = common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	manifestData        = common.HexToAddress("0x00000000000000000000000000000000000000e2")
+	manifestRedeployed  = common.HexToAddress("0x00000000000000000000000000000000000000e3")
+	manifestNeverDeploy = common.HexToAddress("0x00000000000000000000000000000000000000e4")
+)
+
+// deployedCode reports code at the addresses set to true
+type deployedCode map[common.Address]bool
+
+func (d deployedCode) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	if d[account] {
+		return []byte{0x60, 0x80}, nil
+	}
+	return nil, nil
+}
+
+// gatedRegistry serves fixed tasks, holding TotalTasks until the gate is
+// closed when there is one
+type gatedRegistry struct {
+	tasks   []RegistryTask
+	gate    chan struct{}
+	entered chan struct{}
+}
+
+func (g *gatedRegistry) TotalTasks(ctx context.Context) (uint64, error) {
+	if g.gate != nil {
+		close(g.entered)
+		<-g.gate
+	}
+	return uint64(len(g.tasks)), nil
+}
+
+func (g *gatedRegistry) Task(ctx context.Context, id uint64) (RegistryTask, error) {
+	return g.tasks[id-1], nil
+}
+
+// writeManifest writes a manifest for chain 1001 and returns its path
+func writeManifest(t *testing.T, dir string, contracts map[string]common.Address) string {
+	raw := make(map[string]string, len(contracts))
+	for name, address := range contracts {
+		raw[name] = address.Hex()
+	}
+	data := fmt.Sprintf(`{"1001": %s, "8217": {"AnalyticsRegistry": "%s"}}`, mustJSON(t, raw), manifestNeverDeploy.Hex())
+	path := filepath.Join(dir, "contracts.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func mustJSON(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseContractManifest(t *testing.T) {
+	manifest, err := ParseContractManifest([]byte(`{"1001": {"AnalyticsRegistry": "` + manifestRegistry.Hex() + `"}, "8217": {}}`))
+	require.NoError(t, err)
+	assert.Equal(t, ContractManifest{1001: {ContractAnalyticsRegistry: manifestRegistry}, 8217: {}}, manifest)
+
+	for data, message := range map[string]string{
+		`{"testnet": {}}`: `chain ID "testnet"`,
+		`{"1001": {"AnalyticsRegistry": "0x1234"}}`:                                "AnalyticsRegistry on chain 1001 must be a nonzero",
+		`{"1001": {"DataContract": "0x0000000000000000000000000000000000000000"}}`: "DataContract on chain 1001 must be a nonzero",
+		`{"1001": {"": "` + manifestData.Hex() + `"}}`:                             "without a name",
+		`["AnalyticsRegistry"]`:                                                    "failed to parse",
+	} {
+		_, err := ParseContractManifest([]byte(data))
+		require.Error(t, err, data)
+		assert.Contains(t, err.Error(), message, data)
+	}
+}
+
+func TestContractDeploymentsFailOnCodelessAddresses(t *testing.T) {
+	dir := t.TempDir()
+	code := deployedCode{manifestRegistry: true, manifestRedeployed: true}
+	path := writeManifest(t, dir, map[string]common.Address{ContractAnalyticsRegistry: manifestRegistry, ContractDataContract: manifestData})
+	deployments := NewContractDeployments(path, 1001, code)
+
+	// DataContract was never deployed at its address; chain 8217's
+	// contracts aren't checked
+	_, err := deployments.Load(context.Background())
+	require.ErrorIs(t, err, ErrContractWithoutCode)
+	assert.Equal(t, "contract has no code on chain 1001: DataContract at "+manifestData.Hex(), err.Error())
+	_, ok := deployments.Address(ContractAnalyticsRegistry)
+	assert.False(t, ok, "nothing is loaded from a manifest that fails verification")
+
+	code[manifestData] = true
+	changed, err := deployments.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{ContractAnalyticsRegistry, ContractDataContract}, changed)
+
+	// A bad reload keeps the addresses in place
+	writeManifest(t, dir, map[string]common.Address{ContractAnalyticsRegistry: manifestNeverDeploy, ContractDataContract: manifestData})
+	_, err = deployments.Load(context.Background())
+	assert.ErrorIs(t, err, ErrContractWithoutCode)
+	address, _ := deployments.Address(ContractAnalyticsRegistry)
+	assert.Equal(t, manifestRegistry, address)
+
+	status := deployments.Status()
+	assert.Equal(t, int64(1001), status.ChainID)
+	assert.Equal(t, map[string]string{ContractAnalyticsRegistry: manifestRegistry.Hex(), ContractDataContract: manifestData.Hex()}, status.Contracts)
+	assert.Equal(t, 0, status.Reloads)
+
+	_, err = NewContractDeployments(path, 1, code).Load(context.Background())
+	assert.EqualError(t, err, "contract manifest has no contracts for chain 1")
+}
+
+func TestContractDeploymentsLoadFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"1001": {"AnalyticsRegistry": "%s"}}`, manifestRegistry.Hex())
+	}))
+	defer server.Close()
+
+	deployments := NewContractDeployments(server.URL+"/contracts.json", 1001, deployedCode{manifestRegistry: true})
+	changed, err := deployments.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{ContractAnalyticsRegistry}, changed)
+
+	// Reloading an unchanged manifest changes nothing
+	changed, err = deployments.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Equal(t, 1, deployments.Status().Reloads)
+}
+
+func TestContractDeploymentsHotSwapMidRequest(t *testing.T) {
+	dir := t.TempDir()
+	path := writeManifest(t, dir, map[string]common.Address{ContractAnalyticsRegistry: manifestRegistry})
+	deployments := NewContractDeployments(path, 1001, deployedCode{manifestRegistry: true, manifestRedeployed: true})
+	_, err := deployments.Load(context.Background())
+	require.NoError(t, err)
+
+	registered := NewAPITime(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	old := &gatedRegistry{
+		tasks:   []RegistryTask{{ID: 1, TaskType: "old", Status: RegistryTaskPending, RegisteredAt: registered}, {ID: 2, TaskType: "old", Status: RegistryTaskPending, RegisteredAt: registered}},
+		gate:    make(chan struct{}),
+		entered: make(chan struct{}),
+	}
+	redeployed := &gatedRegistry{tasks: []RegistryTask{{ID: 1, TaskType: "new", Status: RegistryTaskPending, RegisteredAt: registered}}}
+	readers := map[common.Address]RegistryTaskReader{manifestRegistry: old, manifestRedeployed: redeployed}
+	tasks := NewRegistryTasks(old)
+	swapped := make(chan struct{})
+	deployments.OnChange(func(name string, address common.Address) {
+		if name == ContractAnalyticsRegistry {
+			tasks.SetReader(readers[address])
+			close(swapped)
+		}
+	})
+
+	// A request is reading the old registry when the manifest moves it
+	listed := make(chan []RegistryTask)
+	go func() {
+		page, _, err := tasks.List(context.Background(), "", 10, 0)
+		assert.NoError(t, err)
+		listed <- page
+	}()
+	<-old.entered
+
+	writeManifest(t, dir, map[string]common.Address{ContractAnalyticsRegistry: manifestRedeployed})
+	reloaded := make(chan error)
+	go func() {
+		_, err := deployments.Load(context.Background())
+		reloaded <- err
+	}()
+
+	// The manifest swaps in at once, the registry client only once the
+	// request is done with the old one
+	require.Eventually(t, func() bool {
+		address, _ := deployments.Address(ContractAnalyticsRegistry)
+		return address == manifestRedeployed
+	}, time.Second, time.Millisecond)
+	select {
+	case <-swapped:
+		t.Fatal("the registry client was swapped under a request")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(old.gate)
+	page := <-listed
+	require.Len(t, page, 2)
+	for _, task := range page {
+		assert.Equal(t, "old", task.TaskType, "a request reads one registry throughout")
+	}
+	require.NoError(t, <-reloaded)
+	<-swapped
+
+	page, total, err := tasks.List(context.Background(), "", 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total, "tasks of the old registry are dropped")
+	assert.Equal(t, "new", page[0].TaskType)
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SupportedCurrencies are the currencies amounts can be displayed in
+var SupportedCurrencies = []string{"USD", "KRW", "ETH"}
+
+// ErrUnsupportedCurrency is returned for a display currency that isn't supported
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// convertedDecimals are the fractional digits converted amounts keep, enough
+// for ETH amounts and the prices of sub-cent tokens
+const convertedDecimals = 8
+
+// ParseCurrency normalizes a display currency code, rejecting unsupported ones
+func ParseCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !displayCurrencies[code] {
+		return "", fmt.Errorf("%w %q, supported: %s", ErrUnsupportedCurrency, code, strings.Join(SupportedCurrencies, ", "))
+	}
+	return code, nil
+}
+
+// ConversionRate converts USD amounts into a display currency. Amounts are
+// converted as decimals, so the float error of the USD figures isn't
+// compounded by the rate, and rounded only for display.
+type ConversionRate struct {
+	Currency string `json:"currency"`
+	// PerUSD is how many units of the currency one USD buys
+	PerUSD string `json:"per_usd"`
+	// Source is where the currency's USD price came from
+	Source string `json:"source"`
+	// AsOf is when that price was observed
+	AsOf APITime `json:"as_of"`
+
+	perUSD *big.Rat
+	// numbers writes formatted amounts in the reader's locale
+	numbers NumberFormat
+}
+
+// USDConversion is the identity conversion, for amounts shown in USD
+func USDConversion(asOf time.Time) ConversionRate {
+	return ConversionRate{Currency: "USD", PerUSD: "1", Source: "identity", AsOf: NewAPITime(asOf), perUSD: big.NewRat(1, 1)}
+}
+
+// NewConversionRate derives the rate of a currency from its USD price
+func NewConversionRate(currency string, usdPrice float64, source string, asOf time.Time) (ConversionRate, error) {
+	if usdPrice <= 0 {
+		return ConversionRate{}, fmt.Errorf("no USD price for %s", currency)
+	}
+	perUSD := new(big.Rat).Inv(decimalFromFloat(usdPrice))
+	return ConversionRate{
+		Currency: currency,
+		PerUSD:   trimDecimal(perUSD.FloatString(12)),
+		Source:   source,
+		AsOf:     NewAPITime(asOf),
+		perUSD:   perUSD,
+	}, nil
+}
+
+// Convert converts a USD amount, rounded to 8 decimal places. USD amounts are
+// returned as they are.
+func (r ConversionRate) Convert(usd float64) float64 {
+	if r.perUSD == nil || r.Currency == "USD" {
+		return usd
+	}
+	converted, _ := strconv.ParseFloat(r.convert(usd, convertedDecimals), 64)
+	return converted
+}
+
+// WithLocale returns the rate writing amounts in the number locale
+func (r ConversionRate) WithLocale(locale string) ConversionRate {
+	r.numbers = NewNumberFormat(locale)
+	return r
+}
+
+// Numbers returns the format amounts are written in
+func (r ConversionRate) Numbers() NumberFormat {
+	return r.numbers
+}
+
+// Format renders a USD amount in the currency, grouped by thousands
+func (r ConversionRate) Format(usd float64) string {
+	switch r.Currency {
+	case "KRW":
+		return "₩" + r.numbers.localize(r.convert(usd, 0))
+	case "ETH":
+		return r.numbers.localize(trimDecimal(r.convert(usd, 6))) + " ETH"
+	default:
+		return "$" + r.numbers.localize(r.convert(usd, 2))
+	}
+}
+
+// FormatWhole renders a large USD amount in the currency without fractional
+// digits
+func (r ConversionRate) FormatWhole(usd float64) string {
+	return r.symbolize(r.numbers.localize(r.convert(usd, 0)))
+}
+
+// FormatCompact renders a large USD amount in the currency in compact
+// notation from a million, as $1.5M
+func (r ConversionRate) FormatCompact(usd float64) string {
+	return r.symbolize(r.numbers.Compact(r.Convert(usd)))
+}
+
+// symbolize marks a written amount with the currency
+func (r ConversionRate) symbolize(amount string) string {
+	switch r.Currency {
+	case "KRW":
+		return "₩" + amount
+	case "ETH":
+		return amount + " ETH"
+	default:
+		return "$" + amount
+	}
+}
+
+// convert formats the converted amount with the given fractional digits
+func (r ConversionRate) convert(usd float64, decimals int) string {
+	perUSD := r.perUSD
+	if perUSD == nil {
+		perUSD = big.NewRat(1, 1)
+	}
+	return new(big.Rat).Mul(decimalFromFloat(usd), perUSD).FloatString(decimals)
+}
+
+// decimalFromFloat returns the decimal a float prints as, rather than its
+// exact binary value, so 0.1 converts as 0.1
+func decimalFromFloat(value float64) *big.Rat {
+	decimal, ok := new(big.Rat).SetString(strconv.FormatFloat(value, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return decimal
+}
+
+// trimDecimal drops trailing fractional zeros from a decimal string
+func trimDecimal(decimal string) string {
+	if !strings.Contains(decimal, ".") {
+		return decimal
+	}
+	return strings.TrimSuffix(strings.TrimRight(decimal, "0"), ".")
+}
+
+// InCurrency returns the market data with its price, volume, and market cap
+// converted. The live price stays in its exchange's quote currency.
+func (m MarketData) InCurrency(rate ConversionRate) MarketData {
+	m.Price = rate.Convert(m.Price)
+	m.Volume24h = rate.Convert(m.Volume24h)
+	m.MarketCap = rate.Convert(m.MarketCap)
+	return m
+}
+
+// InCurrency returns the protocol data with its TVL and volume converted
+func (p ProtocolData) InCurrency(rate ConversionRate) ProtocolData {
+	p.TVL = rate.Convert(p.TVL)
+	p.Volume24h = rate.Convert(p.Volume24h)
+	return p
+}
+
+// InCurrency returns the opportunity with its TVL, and TVL history, converted
+func (o YieldOpportunity) InCurrency(rate ConversionRate) YieldOpportunity {
+	o.TVL = rate.Convert(o.TVL)
+	if o.History != nil {
+		history := *o.History
+		history.TVL = make([]SeriesPoint, len(o.History.TVL))
+		for i, point := range o.History.TVL {
+			history.TVL[i] = SeriesPoint{Timestamp: point.Timestamp, Value: rate.Convert(point.Value)}
+		}
+		o.History = &history
+	}
+	return o
+}
+
+// InCurrency returns a copy of the summary with its values also given in
+// the rate's currency. The USD values are kept.
+func (s *AddressSummary) InCurrency(rate ConversionRate) *AddressSummary {
+	converted := *s
+	converted.NativeValueDisplay = rate.Convert(s.NativeValueUSD)
+	converted.TotalValueDisplay = rate.Convert(s.TotalValueUSD)
+	converted.TopTokens = make([]TokenHolding, len(s.TopTokens))
+	for i, token := range s.TopTokens {
+		token.ValueDisplay = rate.Convert(token.ValueUSD)
+		converted.TopTokens[i] = token
+	}
+	converted.Conversion = &rate
+	return &converted
+}
+
+// InCurrency returns a copy of the report with its fees also given in the
+// rate's currency. The USD values are kept.
+func (r *FeeSpendReport) InCurrency(rate ConversionRate) *FeeSpendReport {
+	converted := *r
+	converted.TotalFeeDisplay = rate.Convert(r.TotalFeeUSD)
+	converted.ByDay = make([]DailyFeeSpend, len(r.ByDay))
+	for i, day := range r.ByDay {
+		day.FeeDisplay = rate.Convert(day.FeeUSD)
+		converted.ByDay[i] = day
+	}
+	converted.ByContract = make([]ContractFeeSpend, len(r.ByContract))
+	for i, contract := range r.ByContract {
+		contract.FeeDisplay = rate.Convert(contract.FeeUSD)
+		converted.ByContract[i] = contract
+	}
+	if r.InternalTransfers != nil {
+		internal := *r.InternalTransfers
+		internal.FeeDisplay = rate.Convert(internal.FeeUSD)
+		converted.InternalTransfers = &internal
+	}
+	if r.Bridges != nil {
+		converted.Bridges = make([]BridgeFeeSpend, len(r.Bridges))
+		for i, bridge := range r.Bridges {
+			bridge.FeeDisplay = rate.Convert(bridge.FeeUSD)
+			converted.Bridges[i] = bridge
+		}
+	}
+	converted.Conversion = &rate
+	return &converted
+}
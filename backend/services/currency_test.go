@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedPortfolio is a USD portfolio summary with a native and a token holding
+func fixedPortfolio() *AddressSummary {
+	return &AddressSummary{
+		Address:        "0x00000000000000000000000000000000000000a1",
+		NativeValueUSD: 234.56,
+		TopTokens: []TokenHolding{
+			{Symbol: "USDT", Balance: 1000, PriceUSD: 1, ValueUSD: 1000},
+		},
+		TotalValueUSD: 1234.56,
+	}
+}
+
+func TestConversionRateDisplaysPortfolio(t *testing.T) {
+	asOf := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("KRW", func(t *testing.T) {
+		rate, err := NewConversionRate("KRW", 0.00074, "coingecko", asOf)
+		require.NoError(t, err)
+		assert.Equal(t, "1351.351351351351", rate.PerUSD)
+		assert.Equal(t, asOf, rate.AsOf.Time)
+
+		portfolio := fixedPortfolio()
+		converted := portfolio.InCurrency(rate)
+		assert.Equal(t, 316972.97297297, converted.NativeValueDisplay)
+		assert.Equal(t, 1351351.35135135, converted.TopTokens[0].ValueDisplay)
+		assert.Equal(t, 1668324.32432432, converted.TotalValueDisplay)
+		assert.Equal(t, &rate, converted.Conversion)
+		assert.Equal(t, "₩1,668,324", rate.Format(portfolio.TotalValueUSD))
+
+		// The USD values and the summary itself are left as they were
+		assert.Equal(t, 1234.56, converted.TotalValueUSD)
+		assert.Zero(t, portfolio.TopTokens[0].ValueDisplay)
+		assert.Nil(t, portfolio.Conversion)
+	})
+
+	t.Run("ETH", func(t *testing.T) {
+		rate, err := NewConversionRate("ETH", 3200, "binance", asOf)
+		require.NoError(t, err)
+		assert.Equal(t, "0.0003125", rate.PerUSD)
+
+		converted := fixedPortfolio().InCurrency(rate)
+		assert.Equal(t, 0.0733, converted.NativeValueDisplay)
+		assert.Equal(t, 0.3125, converted.TopTokens[0].ValueDisplay)
+		assert.Equal(t, 0.3858, converted.TotalValueDisplay)
+		assert.Equal(t, "0.3858 ETH", rate.Format(1234.56))
+		assert.Equal(t, "0 ETH", rate.FormatWhole(1234.56))
+	})
+
+	t.Run("decimal conversion", func(t *testing.T) {
+		// As floats 0.1 / 0.001 is 100.00000000000001
+		rate, err := NewConversionRate("KRW", 0.001, "coingecko", asOf)
+		require.NoError(t, err)
+		assert.Equal(t, 100.0, rate.Convert(0.1))
+	})
+
+	t.Run("USD", func(t *testing.T) {
+		rate := USDConversion(asOf)
+		assert.Equal(t, 0.158, rate.Convert(0.158))
+		assert.Equal(t, "$1,234.56", rate.Format(1234.56))
+	})
+
+	_, err := NewConversionRate("KRW", 0, "coingecko", asOf)
+	assert.Error(t, err)
+}
+
+func TestConvertedResponses(t *testing.T) {
+	rate, err := NewConversionRate("KRW", 0.00074, "coingecko", time.Now())
+	require.NoError(t, err)
+
+	market := MarketData{Symbol: "KAIA", Price: 0.148, Volume24h: 740, MarketCap: 1480}.InCurrency(rate)
+	assert.Equal(t, MarketData{Symbol: "KAIA", Price: 200, Volume24h: 1000000, MarketCap: 2000000}, market)
+
+	protocol := ProtocolData{Protocol: "KlaySwap", TVL: 7.4, Volume24h: 0.74, APY: 12}.InCurrency(rate)
+	assert.Equal(t, 10000.0, protocol.TVL)
+	assert.Equal(t, 1000.0, protocol.Volume24h)
+	assert.Equal(t, 12.0, protocol.APY)
+
+	history := &YieldSeries{Window: "7d", TVL: []SeriesPoint{{Value: 7.4}}, APY: []SeriesPoint{{Value: 5}}}
+	opportunity := YieldOpportunity{TVL: 74, APY: 5, History: history}.InCurrency(rate)
+	assert.Equal(t, 100000.0, opportunity.TVL)
+	assert.Equal(t, 10000.0, opportunity.History.TVL[0].Value)
+	assert.Equal(t, 5.0, opportunity.History.APY[0].Value)
+	assert.Equal(t, 7.4, history.TVL[0].Value, "the original history is kept")
+
+	report := (&FeeSpendReport{
+		TotalFeeUSD: 0.74,
+		ByDay:       []DailyFeeSpend{{Date: "2025-06-01", FeeUSD: 0.74}},
+		ByContract:  []ContractFeeSpend{{Address: "0xabc", FeeUSD: 0.37}},
+	}).InCurrency(rate)
+	assert.Equal(t, 1000.0, report.TotalFeeDisplay)
+	assert.Equal(t, 1000.0, report.ByDay[0].FeeDisplay)
+	assert.Equal(t, 500.0, report.ByContract[0].FeeDisplay)
+	assert.Equal(t, "KRW", report.Conversion.Currency)
+}
+
+func TestParseCurrency(t *testing.T) {
+	currency, err := ParseCurrency(" krw ")
+	require.NoError(t, err)
+	assert.Equal(t, "KRW", currency)
+
+	_, err = ParseCurrency("JPY")
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+	assert.Contains(t, err.Error(), "supported: USD, KRW, ETH")
+}
+
+func TestChatFormatsInDisplayCurrency(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	rate, err := engine.dataCollector.ConversionRate(context.Background(), "KRW")
+	require.NoError(t, err)
+	assert.Equal(t, "KRW", rate.Currency)
+	assert.Equal(t, "coingecko", rate.Source)
+	assert.False(t, rate.AsOf.IsZero())
+
+	report := &FeeSpendReport{Address: "0xabc", Symbol: "KAIA", TotalFee: 2, TotalFeeUSD: 0.3, Complete: true}
+	assert.Contains(t, formatFeeSpend(report, "this month", rate), "Total Fees: 2 KAIA (₩405)")
+	assert.Contains(t, formatFeeSpend(report, "this month", USDConversion(time.Now())), "Total Fees: 2 KAIA ($0.30)")
+}
@@ -10,17 +10,22 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 // DataCollector handles data collection from various sources
 type DataCollector struct {
-	ethClient    *ethclient.Client
+	ethClient    ChainClient
 	httpClient   *http.Client
+	httpCache    *CachingTransport
+	httpRecorder *RecordingTransport
 	logger       *log.Logger
 	mu           sync.RWMutex
-	cache        map[string]interface{}
-	cacheTTL     time.Duration
+	cache        *Cache
+	txIndex      *TransactionIndex
+	series       *TimeSeriesStore
+	priceFeed    *PriceFeed
+	// referenceSource replaces the simulated reference API in tests
+	referenceSource func(ctx context.Context, symbol string) (*MarketData, error)
 }
 
 // MarketData represents market data from external sources
@@ -30,13 +35,19 @@ type MarketData struct {
 	Change24h float64 `json:"change_24h"`
 	Volume24h float64 `json:"volume_24h"`
 	MarketCap float64 `json:"market_cap"`
-	Timestamp int64   `json:"timestamp"`
+	Timestamp APITime `json:"timestamp"`
+	// Deprecated: use Timestamp
+	TimestampUnix int64 `json:"timestamp_unix"`
+	// LivePrice is set when Price comes from the live exchange feed
+	LivePrice *LivePrice `json:"live_price,omitempty"`
 }
 
 // BlockchainData represents blockchain-specific data
 type BlockchainData struct {
 	BlockNumber    uint64  `json:"block_number"`
-	BlockTime      int64   `json:"block_time"`
+	BlockTime      APITime `json:"block_time"`
+	// Deprecated: use BlockTime
+	BlockTimeUnix  int64   `json:"block_time_unix"`
 	GasPrice       uint64  `json:"gas_price"`
 	GasUsed        uint64  `json:"gas_used"`
 	GasLimit       uint64  `json:"gas_limit"`
@@ -52,22 +63,125 @@ type ProtocolData struct {
 	Volume24h    float64 `json:"volume_24h"`
 	APY          float64 `json:"apy"`
 	UserCount    int     `json:"user_count"`
-	LastUpdated  int64   `json:"last_updated"`
+	LastUpdated  APITime `json:"last_updated"`
+	// Deprecated: use LastUpdated
+	LastUpdatedUnix int64 `json:"last_updated_unix"`
 }
 
 // NewDataCollector creates a new data collector instance
-func NewDataCollector(ethClient *ethclient.Client) *DataCollector {
+func NewDataCollector(ethClient ChainClient) *DataCollector {
+	// The recorder sits below the cache, so only exchanges that reached
+	// upstream are recorded
+	httpRecorder := NewRecordingTransport(http.DefaultTransport)
+	httpCache := NewCachingTransport(httpRecorder, NewMemoryHTTPCacheStore(), DefaultHTTPCachePolicies())
 	return &DataCollector{
-		ethClient:  ethClient,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		logger:     log.New(log.Writer(), "[DataCollector] ", log.LstdFlags),
-		cache:      make(map[string]interface{}),
-		cacheTTL:   5 * time.Minute,
+		ethClient:    ethClient,
+		httpClient:   &http.Client{Timeout: 30 * time.Second, Transport: httpCache},
+		httpCache:    httpCache,
+		httpRecorder: httpRecorder,
+		logger:       log.New(log.Writer(), "[DataCollector] ", log.LstdFlags),
+		cache:        NewCache(),
+		txIndex:      NewTransactionIndex(),
+		series:       NewTimeSeriesStore(),
 	}
 }
 
+// Cache returns the collector's namespaced cache of market, gas, yield, and
+// block data
+func (dc *DataCollector) Cache() *Cache {
+	return dc.cache
+}
+
+// HTTPCache returns the cache in front of the collector's external API calls
+func (dc *DataCollector) HTTPCache() *CachingTransport {
+	return dc.httpCache
+}
+
+// HTTPRecorder returns the recorder of the collector's external API calls
+func (dc *DataCollector) HTTPRecorder() *RecordingTransport {
+	return dc.httpRecorder
+}
+
+// Series returns the time series recorded by the collector
+func (dc *DataCollector) Series() *TimeSeriesStore {
+	return dc.series
+}
+
+// SetPriceFeed makes market data prefer fresh live exchange prices over the
+// reference prices
+func (dc *DataCollector) SetPriceFeed(feed *PriceFeed) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.priceFeed = feed
+}
+
+// ReferencePrices returns the reference (CoinGecko) prices, ignoring the live feed
+func (dc *DataCollector) ReferencePrices() PriceSource {
+	return referencePriceSource{dc: dc}
+}
+
+// referencePriceSource serves the collector's reference prices
+type referencePriceSource struct {
+	dc *DataCollector
+}
+
+func (r referencePriceSource) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	data, err := r.dc.fetchReferenceMarketData(ctx, symbol)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch reference price for %s: %w", symbol, err)
+	}
+	return data.Price, nil
+}
+
+// livePrice returns the live exchange price of a symbol if it is fresh
+func (dc *DataCollector) livePrice(symbol string) (LivePrice, bool) {
+	dc.mu.RLock()
+	feed := dc.priceFeed
+	dc.mu.RUnlock()
+
+	if feed == nil {
+		return LivePrice{}, false
+	}
+	live, ok := feed.Price(symbol)
+	if !ok || feed.now().Sub(live.ReceivedAt) > livePriceMaxAge {
+		return LivePrice{}, false
+	}
+	return live, true
+}
+
+// ObservePrice reports when a symbol's price was last updated and how far
+// the live exchange price diverges from the reference price
+func (dc *DataCollector) ObservePrice(symbol string) (PriceObservation, bool) {
+	observation := PriceObservation{Symbol: symbol}
+	point, ok := dc.series.ValueAt(PriceMetric(symbol), utcNow())
+	if ok {
+		observation.UpdatedAt = point.Timestamp
+	}
+	if live, fresh := dc.livePrice(symbol); fresh {
+		if live.ReceivedAt.After(observation.UpdatedAt) {
+			observation.UpdatedAt = live.ReceivedAt
+		}
+		if live.ReferencePrice > 0 {
+			observation.Divergence, observation.HasReference = live.Divergence, true
+		}
+		ok = true
+	}
+	return observation, ok
+}
+
+// TransactionIndex returns the per-address transaction history index
+func (dc *DataCollector) TransactionIndex() *TransactionIndex {
+	return dc.txIndex
+}
+
 // CollectBlockchainData collects real-time blockchain data
 func (dc *DataCollector) CollectBlockchainData(ctx context.Context) (*BlockchainData, error) {
+	if cached, ok := dc.cache.Get(CacheBlocks, "latest"); ok {
+		data := cached.(BlockchainData)
+		return &data, nil
+	}
+
 	// Get latest block
 	header, err := dc.ethClient.HeaderByNumber(ctx, nil)
 	if err != nil {
@@ -89,48 +203,148 @@ func (dc *DataCollector) CollectBlockchainData(ctx context.Context) (*Blockchain
 	// Calculate hash rate (simplified)
 	hashRate := float64(block.Difficulty().Uint64()) / 1e12
 
-	return &BlockchainData{
+	blockTime := time.Unix(int64(block.Time()), 0).UTC()
+	dc.series.Record(MetricGasPrice, SeriesPoint{Timestamp: blockTime, Value: weiToFloat(gasPrice, 9)})
+	dc.series.Record(MetricTxVolume, SeriesPoint{Timestamp: blockTime, Value: float64(len(block.Transactions()))})
+
+	data := BlockchainData{
 		BlockNumber:     block.NumberU64(),
-		BlockTime:       int64(block.Time()),
+		BlockTime:       UnixAPITime(int64(block.Time())),
+		BlockTimeUnix:   int64(block.Time()),
 		GasPrice:        gasPrice.Uint64(),
 		GasUsed:         block.GasUsed(),
 		GasLimit:        block.GasLimit(),
 		TransactionCount: len(block.Transactions()),
 		Difficulty:      block.Difficulty().Uint64(),
 		HashRate:        hashRate,
-	}, nil
+	}
+	dc.cache.Set(CacheBlocks, "latest", data)
+	return &data, nil
 }
 
-// CollectMarketData collects market data from external APIs
-func (dc *DataCollector) CollectMarketData(ctx context.Context, symbols []string) ([]MarketData, error) {
-	var marketData []MarketData
+// CollectMarketData collects market data from external APIs, at most
+// MaxMarketDataConcurrency symbols at a time. A symbol that fails doesn't
+// fail the others: each has its own status in the results.
+func (dc *DataCollector) CollectMarketData(ctx context.Context, symbols []string) MarketDataResults {
+	ctx = WithRecordingJob(ctx, "collect_market_data")
+	results := make(MarketDataResults, len(symbols))
+	slots := make(chan struct{}, MaxMarketDataConcurrency)
 	var wg sync.WaitGroup
-	var mu sync.Mutex
 
-	for _, symbol := range symbols {
+	for i, symbol := range symbols {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i] = newSymbolMarketData(symbol, nil, ctx.Err())
+			continue
+		}
+
 		wg.Add(1)
-		go func(sym string) {
+		go func(i int, sym string) {
 			defer wg.Done()
+			defer func() { <-slots }()
 
 			data, err := dc.fetchMarketData(ctx, sym)
 			if err != nil {
 				dc.logger.Printf("Error fetching market data for %s: %v", sym, err)
-				return
 			}
-
-			mu.Lock()
-			marketData = append(marketData, *data)
-			mu.Unlock()
-		}(symbol)
+			results[i] = newSymbolMarketData(sym, data, err)
+		}(i, symbol)
 	}
 
 	wg.Wait()
 
-	return marketData, nil
+	return results
+}
+
+// GetPrice returns the current USD price of a symbol
+func (dc *DataCollector) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	data, err := dc.fetchMarketData(ctx, symbol)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch price for %s: %w", symbol, err)
+	}
+	return data.Price, nil
+}
+
+// ConversionRate returns the rate USD amounts convert into a display
+// currency at, from the currency's current USD price. KRW is priced by the
+// reference source like any symbol.
+func (dc *DataCollector) ConversionRate(ctx context.Context, currency string) (ConversionRate, error) {
+	if currency == "" || currency == "USD" {
+		return USDConversion(utcNow()), nil
+	}
+	data, err := dc.fetchMarketData(ctx, currency)
+	if err != nil {
+		return ConversionRate{}, fmt.Errorf("failed to fetch price for %s: %w", currency, err)
+	}
+	source, asOf := "coingecko", data.Timestamp.Time
+	if data.LivePrice != nil {
+		source, asOf = data.LivePrice.Source, data.LivePrice.ReceivedAt
+	}
+	return NewConversionRate(currency, data.Price, source, asOf)
+}
+
+// PriceAt returns the USD price of a symbol at the given time from the
+// recorded price samples, as PriceSample resolves it
+func (dc *DataCollector) PriceAt(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	sample, err := dc.PriceSample(ctx, symbol, at)
+	if err != nil {
+		return 0, err
+	}
+	return sample.PriceUSD, nil
+}
+
+// PriceSample prices a symbol at the given time from the recorded price
+// samples by the rules of SamplePrice. A time within PriceSampleTolerance of
+// now with no sample to use fetches the current price first, which records one.
+func (dc *DataCollector) PriceSample(ctx context.Context, symbol string, at time.Time) (PriceSample, error) {
+	sample, err := SamplePrice(dc.series, symbol, at)
+	if err == nil || utcNow().Sub(at).Abs() > PriceSampleTolerance {
+		return sample, err
+	}
+	if _, err := dc.fetchMarketData(ctx, symbol); err != nil {
+		return PriceSample{}, fmt.Errorf("failed to fetch price for %s: %w", symbol, err)
+	}
+	return SamplePrice(dc.series, symbol, at)
 }
 
-// fetchMarketData fetches market data for a specific symbol
+// fetchMarketData fetches market data for a specific symbol, with the price
+// taken from the live exchange feed when it is fresh
 func (dc *DataCollector) fetchMarketData(ctx context.Context, symbol string) (*MarketData, error) {
+	data, err := dc.fetchReferenceMarketData(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if live, ok := dc.livePrice(symbol); ok {
+		data.Price = live.Price
+		data.LivePrice = &live
+	}
+
+	dc.series.Record(PriceMetric(symbol), SeriesPoint{Timestamp: utcNow(), Value: data.Price})
+	return data, nil
+}
+
+// fetchReferenceMarketData fetches the reference market data for a symbol
+func (dc *DataCollector) fetchReferenceMarketData(ctx context.Context, symbol string) (*MarketData, error) {
+	if cached, ok := dc.cache.Get(CacheMarket, symbol); ok {
+		data := cached.(MarketData)
+		return &data, nil
+	}
+	return dc.loadReferenceMarketData(ctx, symbol)
+}
+
+// loadReferenceMarketData fetches the reference market data for a symbol
+// and caches it
+func (dc *DataCollector) loadReferenceMarketData(ctx context.Context, symbol string) (*MarketData, error) {
+	if dc.referenceSource != nil {
+		data, err := dc.referenceSource(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		dc.cache.Set(CacheMarket, symbol, *data)
+		return data, nil
+	}
+
 	// Simulate fetching from CoinGecko API
 	// In a real implementation, this would make actual API calls
 	
@@ -138,6 +352,11 @@ func (dc *DataCollector) fetchMarketData(ctx context.Context, symbol string) (*M
 	var price, change24h, volume24h, marketCap float64
 	
 	switch symbol {
+	case "KAIA":
+		price = 0.15
+		change24h = 1.8
+		volume24h = 45000000
+		marketCap = 880000000
 	case "ETH":
 		price = 3200.0
 		change24h = 2.5
@@ -153,6 +372,9 @@ func (dc *DataCollector) fetchMarketData(ctx context.Context, symbol string) (*M
 		change24h = 0.1
 		volume24h = 100000000
 		marketCap = 5000000000
+	case "KRW":
+		price = 0.00074
+		change24h = -0.1
 	default:
 		price = 100.0
 		change24h = 1.0
@@ -160,19 +382,33 @@ func (dc *DataCollector) fetchMarketData(ctx context.Context, symbol string) (*M
 		marketCap = 1000000000
 	}
 
-	return &MarketData{
+	now := time.Now()
+
+	data := MarketData{
 		Symbol:    symbol,
 		Price:     price,
 		Change24h: change24h,
 		Volume24h: volume24h,
 		MarketCap: marketCap,
-		Timestamp: time.Now().Unix(),
-	}, nil
+		Timestamp: NewAPITime(now),
+		TimestampUnix: now.Unix(),
+	}
+	dc.cache.Set(CacheMarket, symbol, data)
+	return &data, nil
 }
 
 // CollectProtocolData collects DeFi protocol data
 func (dc *DataCollector) CollectProtocolData(ctx context.Context) ([]ProtocolData, error) {
+	if cached, ok := dc.cache.Get(CacheYield, "protocols"); ok {
+		return append([]ProtocolData(nil), cached.([]ProtocolData)...), nil
+	}
+	return dc.loadProtocolData(ctx)
+}
+
+// loadProtocolData collects DeFi protocol data and caches it
+func (dc *DataCollector) loadProtocolData(ctx context.Context) ([]ProtocolData, error) {
 	// Simulate collecting data from various DeFi protocols
+	now := time.Now()
 	protocols := []ProtocolData{
 		{
 			Protocol:    "Uniswap V3",
@@ -180,7 +416,8 @@ func (dc *DataCollector) CollectProtocolData(ctx context.Context) ([]ProtocolDat
 			Volume24h:   150000000,
 			APY:         12.5,
 			UserCount:   150000,
-			LastUpdated: time.Now().Unix(),
+			LastUpdated: NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 		{
 			Protocol:    "Aave V3",
@@ -188,7 +425,8 @@ func (dc *DataCollector) CollectProtocolData(ctx context.Context) ([]ProtocolDat
 			Volume24h:   50000000,
 			APY:         8.2,
 			UserCount:   85000,
-			LastUpdated: time.Now().Unix(),
+			LastUpdated: NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 		{
 			Protocol:    "Compound V3",
@@ -196,7 +434,8 @@ func (dc *DataCollector) CollectProtocolData(ctx context.Context) ([]ProtocolDat
 			Volume24h:   30000000,
 			APY:         6.8,
 			UserCount:   65000,
-			LastUpdated: time.Now().Unix(),
+			LastUpdated: NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 		{
 			Protocol:    "Curve Finance",
@@ -204,11 +443,32 @@ func (dc *DataCollector) CollectProtocolData(ctx context.Context) ([]ProtocolDat
 			Volume24h:   20000000,
 			APY:         15.2,
 			UserCount:   45000,
-			LastUpdated: time.Now().Unix(),
+			LastUpdated: NewAPITime(now),
+			LastUpdatedUnix: now.Unix(),
 		},
 	}
 
-	return protocols, nil
+	ProtocolOrdering.Sort(protocols, ProtocolOrdering.Default())
+	dc.cache.Set(CacheYield, "protocols", protocols)
+	return append([]ProtocolData(nil), protocols...), nil
+}
+
+// CacheLoaders returns the loaders that recompute the collector's cached
+// reference data, for priming popular keys before they expire
+func (dc *DataCollector) CacheLoaders() map[string]CacheLoader {
+	return map[string]CacheLoader{
+		CacheMarket: func(ctx context.Context, symbol string) error {
+			_, err := dc.loadReferenceMarketData(ctx, symbol)
+			return err
+		},
+		CacheYield: func(ctx context.Context, key string) error {
+			if key != "protocols" {
+				return fmt.Errorf("no loader for yield key %q", key)
+			}
+			_, err := dc.loadProtocolData(ctx)
+			return err
+		},
+	}
 }
 
 // CollectHistoricalData collects historical blockchain data
@@ -232,7 +492,8 @@ func (dc *DataCollector) CollectHistoricalData(ctx context.Context, startBlock,
 
 		data := BlockchainData{
 			BlockNumber:     block.NumberU64(),
-			BlockTime:       int64(block.Time()),
+			BlockTime:       UnixAPITime(int64(block.Time())),
+			BlockTimeUnix:   int64(block.Time()),
 			GasPrice:        gasPrice.Uint64(),
 			GasUsed:         block.GasUsed(),
 			GasLimit:        block.GasLimit(),
@@ -298,6 +559,10 @@ func (dc *DataCollector) CollectPendingTransactions(ctx context.Context) ([]type
 
 // CollectGasData collects gas price and usage data
 func (dc *DataCollector) CollectGasData(ctx context.Context) (map[string]interface{}, error) {
+	if cached, ok := dc.cache.Get(CacheGas, "latest"); ok {
+		return copyGasData(cached.(map[string]interface{})), nil
+	}
+
 	// Get current gas price
 	gasPrice, err := dc.ethClient.SuggestGasPrice(ctx)
 	if err != nil {
@@ -318,8 +583,9 @@ func (dc *DataCollector) CollectGasData(ctx context.Context) (map[string]interfa
 	gasUsed := block.GasUsed()
 	gasLimit := block.GasLimit()
 	gasUtilization := float64(gasUsed) / float64(gasLimit)
+	now := time.Now()
 
-	return map[string]interface{}{
+	data := map[string]interface{}{
 		"current_gas_price":     gasPrice.Uint64(),
 		"gas_used":              gasUsed,
 		"gas_limit":             gasLimit,
@@ -328,8 +594,20 @@ func (dc *DataCollector) CollectGasData(ctx context.Context) (map[string]interfa
 		"fast_gas_price":        gasPrice.Uint64() * 1.2,
 		"standard_gas_price":    gasPrice.Uint64(),
 		"slow_gas_price":        gasPrice.Uint64() * 0.8,
-		"timestamp":             time.Now().Unix(),
-	}, nil
+		"timestamp":             NewAPITime(now),
+		"timestamp_unix":        now.Unix(),
+	}
+	dc.cache.Set(CacheGas, "latest", data)
+	return copyGasData(data), nil
+}
+
+// copyGasData copies gas data so callers can't change the cached map
+func copyGasData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
 }
 
 // CollectNetworkStats collects network statistics
@@ -348,49 +626,37 @@ func (dc *DataCollector) CollectNetworkStats(ctx context.Context) (map[string]in
 
 	// Get peer count (if available)
 	peerCount := int64(0) // This would require a different client setup
+	now := time.Now()
 
 	return map[string]interface{}{
 		"chain_id":           chainID.Uint64(),
 		"latest_block":       header.Number.Uint64(),
 		"latest_block_hash":  header.Hash().Hex(),
-		"latest_block_time":  int64(header.Time),
+		"latest_block_time":  UnixAPITime(int64(header.Time)),
+		"latest_block_time_unix": int64(header.Time),
 		"peer_count":         peerCount,
 		"difficulty":         header.Difficulty.Uint64(),
 		"total_difficulty":   header.Difficulty.Uint64(), // Simplified
 		"gas_limit":          header.GasLimit,
 		"gas_used":           header.GasUsed,
-		"timestamp":          time.Now().Unix(),
+		"timestamp":          NewAPITime(now),
+		"timestamp_unix":     now.Unix(),
 	}, nil
 }
 
-// GetCachedData retrieves cached data if available and not expired
-func (dc *DataCollector) GetCachedData(key string) (interface{}, bool) {
-	dc.mu.RLock()
-	defer dc.mu.RUnlock()
-
-	if data, exists := dc.cache[key]; exists {
-		// Check if data is still valid (simplified TTL check)
-		// In a real implementation, you'd store timestamps with the data
-		return data, true
-	}
-
-	return nil, false
+// GetCachedData retrieves cached data of a namespace if it hasn't expired
+func (dc *DataCollector) GetCachedData(namespace, key string) (interface{}, bool) {
+	return dc.cache.Get(namespace, key)
 }
 
-// SetCachedData stores data in cache with TTL
-func (dc *DataCollector) SetCachedData(key string, data interface{}) {
-	dc.mu.Lock()
-	defer dc.mu.Unlock()
-
-	dc.cache[key] = data
+// SetCachedData stores data in a namespace of the cache until its TTL passes
+func (dc *DataCollector) SetCachedData(namespace, key string, data interface{}) {
+	dc.cache.Set(namespace, key, data)
 }
 
 // ClearCache clears all cached data
 func (dc *DataCollector) ClearCache() {
-	dc.mu.Lock()
-	defer dc.mu.Unlock()
-
-	dc.cache = make(map[string]interface{})
+	dc.cache.ClearAll()
 }
 
 // GetDataMetrics returns data collection metrics
@@ -398,10 +664,13 @@ func (dc *DataCollector) GetDataMetrics() map[string]interface{} {
 	dc.mu.RLock()
 	defer dc.mu.RUnlock()
 
+	now := time.Now()
 	return map[string]interface{}{
-		"cache_size":     len(dc.cache),
-		"cache_ttl":      dc.cacheTTL.String(),
-		"last_updated":   time.Now().Unix(),
+		"cache_size":     dc.cache.Len(),
+		"cache":          dc.cache.Stats(),
+		"http_cache":     dc.httpCache.Stats(),
+		"last_updated":   NewAPITime(now),
+		"last_updated_unix": now.Unix(),
 		"data_sources":   []string{"Ethereum Node", "CoinGecko API", "DeFi Protocols"},
 		"collection_rate": 0.98, // Simulated success rate
 	}
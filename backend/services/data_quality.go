@@ -0,0 +1,299 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// PriceDataSLA is how old prices may be before they count as stale
+	PriceDataSLA = 10 * time.Minute
+	// YieldDataSLA is how old yield scans may be before they count as stale
+	YieldDataSLA = 30 * time.Minute
+	// MinSentimentVotes is the vote count a proposal's sentiment needs to be
+	// statistically meaningful
+	MinSentimentVotes = 100
+	// LowConfidenceScore is the data-quality score below which results are
+	// flagged as low confidence
+	LowConfidenceScore = 0.6
+	// CriticalComponentScore is the component score below which results are
+	// flagged as low confidence whatever the composite, so one failing
+	// signal isn't averaged away
+	CriticalComponentScore = 0.3
+
+	// maxSourceDivergence is the divergence between price sources at which
+	// their agreement scores zero
+	maxSourceDivergence = 0.05
+)
+
+// Data-quality component names
+const (
+	QualityFreshness  = "freshness"
+	QualityCoverage   = "coverage"
+	QualityAgreement  = "source_agreement"
+	QualitySampleSize = "sample_size"
+)
+
+// qualityWeights weigh the components of the composite score
+var qualityWeights = map[string]float64{
+	QualityFreshness:  0.35,
+	QualityCoverage:   0.25,
+	QualityAgreement:  0.2,
+	QualitySampleSize: 0.2,
+}
+
+// QualityComponent is one signal of a data-quality score
+type QualityComponent struct {
+	Name   string  `json:"name"`
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	Detail string  `json:"detail"`
+}
+
+// DataQuality scores how far an analytics result can be trusted, from the
+// freshness, coverage, source agreement, and sample size of its inputs
+type DataQuality struct {
+	Score      float64            `json:"score"`
+	Components []QualityComponent `json:"components"`
+	// Summary explains a low score, empty otherwise
+	Summary string `json:"summary,omitempty"`
+}
+
+// Low reports whether the result should be flagged as low confidence
+func (q *DataQuality) Low() bool {
+	return q != nil && q.Summary != ""
+}
+
+// DatasetAge is when an input dataset was last updated
+type DatasetAge struct {
+	Name      string
+	UpdatedAt time.Time
+	SLA       time.Duration
+}
+
+// AssetCoverage is whether a requested asset had data in a dataset
+type AssetCoverage struct {
+	Asset   string
+	Dataset string
+	Covered bool
+}
+
+// SourceDivergence is the relative difference between two sources' prices of a symbol
+type SourceDivergence struct {
+	Symbol     string
+	Divergence float64
+}
+
+// SampleCount is the sample size behind a statistical output
+type SampleCount struct {
+	Name   string
+	Unit   string
+	Have   int
+	Needed int
+}
+
+// QualityInputs are the signals a data-quality score is computed from.
+// Signals that weren't measured are left empty and don't count.
+type QualityInputs struct {
+	Datasets    []DatasetAge
+	Coverage    []AssetCoverage
+	Divergences []SourceDivergence
+	Samples     []SampleCount
+}
+
+// ScoreDataQuality computes the data-quality score of the inputs at now. The
+// composite is the weighted mean of the measured components; without any
+// measured component nothing speaks against the data and it scores 1.
+func ScoreDataQuality(inputs QualityInputs, now time.Time) *DataQuality {
+	var components []QualityComponent
+	if len(inputs.Datasets) > 0 {
+		components = append(components, freshnessComponent(inputs.Datasets, now))
+	}
+	if len(inputs.Coverage) > 0 {
+		components = append(components, coverageComponent(inputs.Coverage))
+	}
+	if len(inputs.Divergences) > 0 {
+		components = append(components, agreementComponent(inputs.Divergences))
+	}
+	if len(inputs.Samples) > 0 {
+		components = append(components, sampleComponent(inputs.Samples))
+	}
+
+	quality := &DataQuality{Score: 1, Components: components}
+	if len(components) == 0 {
+		return quality
+	}
+
+	var weighted, weights float64
+	low := false
+	for i := range components {
+		components[i].Weight = qualityWeights[components[i].Name]
+		components[i].Score = roundTo(components[i].Score, 4)
+		weighted += components[i].Score * components[i].Weight
+		weights += components[i].Weight
+		low = low || components[i].Score < CriticalComponentScore
+	}
+	quality.Score = roundTo(weighted/weights, 4)
+
+	if low || quality.Score < LowConfidenceScore {
+		weak := make([]QualityComponent, 0, len(components))
+		for _, component := range components {
+			if component.Score < 1 {
+				weak = append(weak, component)
+			}
+		}
+		sort.SliceStable(weak, func(i, j int) bool { return weak[i].Score < weak[j].Score })
+		details := make([]string, len(weak))
+		for i, component := range weak {
+			details[i] = component.Detail
+		}
+		quality.Summary = "low confidence: " + strings.Join(details, "; ")
+	}
+	return quality
+}
+
+// freshnessComponent scores the stalest dataset by how far it is past its
+// SLA: a dataset twice the SLA old scores 0.5. Missing datasets score zero.
+func freshnessComponent(datasets []DatasetAge, now time.Time) QualityComponent {
+	worst := QualityComponent{Name: QualityFreshness, Score: 1}
+	for i, dataset := range datasets {
+		score, detail := 0.0, fmt.Sprintf("no %s data", dataset.Name)
+		if !dataset.UpdatedAt.IsZero() {
+			age := now.Sub(dataset.UpdatedAt)
+			score = 1
+			if age > dataset.SLA {
+				score = float64(dataset.SLA) / float64(age)
+			}
+			detail = fmt.Sprintf("%s are %s old", dataset.Name, formatAge(age))
+		}
+		if i == 0 || score < worst.Score {
+			worst.Score, worst.Detail = score, detail
+		}
+	}
+	return worst
+}
+
+// coverageComponent scores the fraction of requested assets that had data
+func coverageComponent(coverage []AssetCoverage) QualityComponent {
+	var missing []string
+	for _, asset := range coverage {
+		if !asset.Covered {
+			missing = append(missing, fmt.Sprintf("%s %s", asset.Asset, asset.Dataset))
+		}
+	}
+
+	component := QualityComponent{
+		Name:   QualityCoverage,
+		Score:  float64(len(coverage)-len(missing)) / float64(len(coverage)),
+		Detail: fmt.Sprintf("all %d requested assets have data", len(coverage)),
+	}
+	if len(missing) > 0 {
+		component.Detail = fmt.Sprintf("%d of %d requested assets have data, missing %s",
+			len(coverage)-len(missing), len(coverage), strings.Join(missing, ", "))
+	}
+	return component
+}
+
+// agreementComponent scores the largest divergence between price sources,
+// falling linearly to zero at maxSourceDivergence
+func agreementComponent(divergences []SourceDivergence) QualityComponent {
+	worst := divergences[0]
+	for _, divergence := range divergences[1:] {
+		if divergence.Divergence > worst.Divergence {
+			worst = divergence
+		}
+	}
+	return QualityComponent{
+		Name:   QualityAgreement,
+		Score:  max(0, 1-worst.Divergence/maxSourceDivergence),
+		Detail: fmt.Sprintf("CoinGecko and exchange prices of %s differ by %.1f%%", worst.Symbol, worst.Divergence*100),
+	}
+}
+
+// sampleComponent scores the smallest sample relative to the size it needs
+func sampleComponent(samples []SampleCount) QualityComponent {
+	var worst QualityComponent
+	for i, sample := range samples {
+		score := 1.0
+		if sample.Needed > 0 && sample.Have < sample.Needed {
+			score = float64(sample.Have) / float64(sample.Needed)
+		}
+		if i == 0 || score < worst.Score {
+			worst = QualityComponent{
+				Name:   QualitySampleSize,
+				Score:  score,
+				Detail: fmt.Sprintf("%s has %d %s, %d needed", sample.Name, sample.Have, sample.Unit, sample.Needed),
+			}
+		}
+	}
+	return worst
+}
+
+// formatAge formats a dataset age in the largest whole unit that fits
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "under a minute"
+	case age < 2*time.Hour:
+		return pluralize(int(age/time.Minute), "minute")
+	case age < 48*time.Hour:
+		return pluralize(int(age/time.Hour), "hour")
+	default:
+		return pluralize(int(age/(24*time.Hour)), "day")
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// PriceObservation is the state of a symbol's price: when it was last
+// updated and, when both sources are known, how far the reference and live
+// exchange prices diverge
+type PriceObservation struct {
+	Symbol       string
+	UpdatedAt    time.Time
+	Divergence   float64
+	HasReference bool
+}
+
+// PriceObserver reports the state of the prices analytics are computed from
+type PriceObserver interface {
+	ObservePrice(symbol string) (PriceObservation, bool)
+}
+
+// addPrices adds the freshness, coverage, and agreement of the symbols'
+// prices to the inputs
+func (inputs *QualityInputs) addPrices(observer PriceObserver, symbols []string) {
+	if observer == nil || len(symbols) == 0 {
+		return
+	}
+
+	prices := DatasetAge{Name: "prices", SLA: PriceDataSLA}
+	seen := make(map[string]bool)
+	for _, symbol := range symbols {
+		symbol = strings.ToUpper(symbol)
+		if seen[symbol] {
+			continue
+		}
+		seen[symbol] = true
+
+		observation, ok := observer.ObservePrice(symbol)
+		inputs.Coverage = append(inputs.Coverage, AssetCoverage{Asset: symbol, Dataset: "price", Covered: ok})
+		if !ok {
+			continue
+		}
+		if prices.UpdatedAt.IsZero() || observation.UpdatedAt.Before(prices.UpdatedAt) {
+			prices.UpdatedAt = observation.UpdatedAt
+		}
+		if observation.HasReference {
+			inputs.Divergences = append(inputs.Divergences, SourceDivergence{Symbol: symbol, Divergence: observation.Divergence})
+		}
+	}
+	inputs.Datasets = append(inputs.Datasets, prices)
+}
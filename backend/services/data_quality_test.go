@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPrices observes prices from a fixed table
+type stubPrices map[string]PriceObservation
+
+func (s stubPrices) ObservePrice(symbol string) (PriceObservation, bool) {
+	observation, ok := s[symbol]
+	return observation, ok
+}
+
+func component(t *testing.T, quality *DataQuality, name string) QualityComponent {
+	for _, component := range quality.Components {
+		if component.Name == name {
+			return component
+		}
+	}
+	t.Fatalf("no %s component in %+v", name, quality.Components)
+	return QualityComponent{}
+}
+
+func TestScoreDataQuality(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("fresh and complete", func(t *testing.T) {
+		quality := ScoreDataQuality(QualityInputs{
+			Datasets:    []DatasetAge{{Name: "prices", UpdatedAt: now.Add(-2 * time.Minute), SLA: PriceDataSLA}},
+			Coverage:    []AssetCoverage{{Asset: "KAIA", Dataset: "price", Covered: true}},
+			Divergences: []SourceDivergence{{Symbol: "KAIA", Divergence: 0}},
+			Samples:     []SampleCount{{Name: "PROP-1", Unit: "votes", Have: 150, Needed: 100}},
+		}, now)
+
+		assert.Equal(t, 1.0, quality.Score)
+		assert.Len(t, quality.Components, 4)
+		assert.False(t, quality.Low())
+		assert.Empty(t, quality.Summary)
+		assert.Equal(t, "prices are 2 minutes old", component(t, quality, QualityFreshness).Detail)
+	})
+
+	t.Run("stale and partial", func(t *testing.T) {
+		quality := ScoreDataQuality(QualityInputs{
+			Datasets: []DatasetAge{
+				{Name: "yields", UpdatedAt: now.Add(-5 * time.Minute), SLA: YieldDataSLA},
+				{Name: "prices", UpdatedAt: now.Add(-40 * time.Minute), SLA: PriceDataSLA},
+			},
+			Coverage: []AssetCoverage{
+				{Asset: "KAIA", Dataset: "price", Covered: true},
+				{Asset: "USDT", Dataset: "price", Covered: true},
+				{Asset: "ETH", Dataset: "price", Covered: true},
+				{Asset: "DAI", Dataset: "price", Covered: false},
+			},
+			Divergences: []SourceDivergence{
+				{Symbol: "KAIA", Divergence: 0.01},
+				{Symbol: "ETH", Divergence: 0.02},
+			},
+		}, now)
+
+		freshness := component(t, quality, QualityFreshness)
+		assert.Equal(t, 0.25, freshness.Score)
+		assert.Equal(t, 0.35, freshness.Weight)
+		assert.Equal(t, "prices are 40 minutes old", freshness.Detail)
+
+		coverage := component(t, quality, QualityCoverage)
+		assert.Equal(t, 0.75, coverage.Score)
+		assert.Equal(t, "3 of 4 requested assets have data, missing DAI price", coverage.Detail)
+
+		agreement := component(t, quality, QualityAgreement)
+		assert.Equal(t, 0.6, agreement.Score)
+		assert.Equal(t, "CoinGecko and exchange prices of ETH differ by 2.0%", agreement.Detail)
+
+		// (0.25*0.35 + 0.75*0.25 + 0.6*0.2) / 0.8, without a sample size
+		assert.Equal(t, 0.4938, quality.Score)
+		assert.True(t, quality.Low())
+		assert.Equal(t, "low confidence: prices are 40 minutes old; "+
+			"CoinGecko and exchange prices of ETH differ by 2.0%; "+
+			"3 of 4 requested assets have data, missing DAI price", quality.Summary)
+	})
+
+	t.Run("small sample", func(t *testing.T) {
+		quality := ScoreDataQuality(QualityInputs{
+			Samples: []SampleCount{
+				{Name: "PROP-1", Unit: "votes", Have: 250, Needed: 100},
+				{Name: "PROP-2", Unit: "votes", Have: 40, Needed: 100},
+			},
+		}, now)
+
+		assert.Equal(t, 0.4, quality.Score)
+		assert.Equal(t, "low confidence: PROP-2 has 40 votes, 100 needed", quality.Summary)
+	})
+
+	t.Run("one failing signal", func(t *testing.T) {
+		quality := ScoreDataQuality(QualityInputs{
+			Datasets: []DatasetAge{{Name: "prices", UpdatedAt: now.Add(-time.Hour), SLA: PriceDataSLA}},
+			Coverage: []AssetCoverage{{Asset: "KAIA", Dataset: "price", Covered: true}},
+		}, now)
+
+		// (1/6*0.35 + 1*0.25) / 0.6 is above the threshold, but the
+		// freshness alone is critical
+		assert.Equal(t, 0.5139, quality.Score)
+		assert.True(t, quality.Low())
+		assert.Equal(t, "low confidence: prices are 60 minutes old", quality.Summary)
+	})
+
+	t.Run("missing dataset", func(t *testing.T) {
+		quality := ScoreDataQuality(QualityInputs{
+			Datasets: []DatasetAge{{Name: "prices", SLA: PriceDataSLA}},
+		}, now)
+
+		assert.Equal(t, 0.0, quality.Score)
+		assert.Equal(t, "low confidence: no prices data", quality.Summary)
+	})
+
+	t.Run("nothing measured", func(t *testing.T) {
+		quality := ScoreDataQuality(QualityInputs{}, now)
+		assert.Equal(t, 1.0, quality.Score)
+		assert.Empty(t, quality.Components)
+	})
+}
+
+func TestAnalyticsResultsCarryDataQuality(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	engine := newTestChatEngine(t)
+	engine.analyticsEngine.now = func() time.Time { return now }
+
+	// Confidence no longer drifts with the clock
+	result, err := engine.analyticsEngine.ProcessAnalyticsTask(context.Background(), "risk_assessment", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, result.Confidence)
+
+	engine.analyticsEngine.SetPriceObserver(stubPrices{
+		"ETH":  {Symbol: "ETH", UpdatedAt: now.Add(-40 * time.Minute), Divergence: 0.005, HasReference: true},
+		"USDC": {Symbol: "USDC", UpdatedAt: now.Add(-time.Minute)},
+		"DAI":  {Symbol: "DAI", UpdatedAt: now.Add(-time.Minute)},
+	})
+
+	result, err = engine.analyticsEngine.ProcessAnalyticsTask(context.Background(), "portfolio_optimization", map[string]interface{}{"risk_tolerance": "low"})
+	require.NoError(t, err)
+	require.NotNil(t, result.DataQuality)
+	assert.Equal(t, result.DataQuality.Score, result.Confidence)
+	assert.Equal(t, 0.25, component(t, result.DataQuality, QualityFreshness).Score)
+	assert.Equal(t, 1.0, component(t, result.DataQuality, QualityCoverage).Score)
+	assert.Equal(t, 0.9, component(t, result.DataQuality, QualityAgreement).Score)
+
+	result, err = engine.analyticsEngine.ProcessAnalyticsTask(context.Background(), "governance_sentiment", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, component(t, result.DataQuality, QualitySampleSize).Score)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", Message: "Analyze my portfolio"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Response, "low confidence: prices are 40 minutes old")
+	quality, ok := response.Metadata["data_quality"].(*DataQuality)
+	require.True(t, ok)
+	assert.True(t, quality.Low())
+}
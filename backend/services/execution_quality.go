@@ -0,0 +1,254 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Parameters a swap action carries the outcome of its simulation in. Swaps
+// without an expected output aren't measured.
+const (
+	// SwapParamExpectedOut is the simulated output, in the output token's
+	// base units
+	SwapParamExpectedOut = "expected_amount_out"
+	// SwapParamTokenOut is the address of the token the swap buys
+	SwapParamTokenOut = "token_out"
+	// SwapParamRecipient receives the output; the action's user by default
+	SwapParamRecipient = "recipient"
+	// SwapParamRoute names the pool or router path the swap was quoted on
+	SwapParamRoute = "route"
+	// SwapParamPair is the traded pair, such as KAIA/USDT
+	SwapParamPair = "pair"
+)
+
+const (
+	// ExecutionQualityWindow is how far back the execution-quality report and
+	// route penalties look
+	ExecutionQualityWindow = 30 * 24 * time.Hour
+	// RoutePenaltyMinSwaps is how many measured swaps a route needs before it
+	// can be penalized
+	RoutePenaltyMinSwaps = 5
+	// RoutePenaltyThreshold is the average realized slippage, in percent, a
+	// route is penalized above
+	RoutePenaltyThreshold = 0.5
+
+	// maxRouteSamples bounds the swaps kept per route
+	maxRouteSamples = 500
+)
+
+// SwapExecution compares a mined swap's simulated output with what it
+// actually received
+type SwapExecution struct {
+	Pair              string    `json:"pair"`
+	Route             string    `json:"route"`
+	TokenOut          string    `json:"token_out"`
+	ExpectedAmountOut string    `json:"expected_amount_out"`
+	ActualAmountOut   string    `json:"actual_amount_out"`
+	SlippagePct       float64   `json:"slippage_pct"`
+	TxHash            string    `json:"tx_hash"`
+	MinedAt           time.Time `json:"mined_at"`
+}
+
+// MeasureSwap compares a swap action's simulated output with the output
+// token's Transfer events to the recipient in its receipt. Returns nil for
+// actions that aren't swaps or carry no simulated output.
+func MeasureSwap(action *ActionRequest, receipt *types.Receipt, minedAt time.Time) *SwapExecution {
+	if action.ActionType != "swap" || receipt == nil {
+		return nil
+	}
+	expected, ok := new(big.Int).SetString(fmt.Sprint(action.Parameters[SwapParamExpectedOut]), 10)
+	token, _ := action.Parameters[SwapParamTokenOut].(string)
+	if !ok || expected.Sign() <= 0 || !common.IsHexAddress(token) {
+		return nil
+	}
+	recipient, _ := action.Parameters[SwapParamRecipient].(string)
+	if !common.IsHexAddress(recipient) {
+		recipient = action.UserID
+	}
+	if !common.IsHexAddress(recipient) {
+		return nil
+	}
+
+	actual := RealizedSwapOutput(receipt, common.HexToAddress(token), common.HexToAddress(recipient))
+	route, _ := action.Parameters[SwapParamRoute].(string)
+	pair, _ := action.Parameters[SwapParamPair].(string)
+	return &SwapExecution{
+		Pair:              strings.ToUpper(pair),
+		Route:             strings.ToLower(route),
+		TokenOut:          common.HexToAddress(token).Hex(),
+		ExpectedAmountOut: expected.String(),
+		ActualAmountOut:   actual.String(),
+		SlippagePct:       RealizedSlippage(expected, actual),
+		TxHash:            receipt.TxHash.Hex(),
+		MinedAt:           minedAt,
+	}
+}
+
+// RealizedSwapOutput sums the token's Transfer events to the recipient in a
+// receipt
+func RealizedSwapOutput(receipt *types.Receipt, token, recipient common.Address) *big.Int {
+	total := new(big.Int)
+	for _, entry := range receipt.Logs {
+		if entry.Address != token || len(entry.Topics) != 3 || entry.Topics[0] != transferTopic {
+			continue
+		}
+		if common.BytesToAddress(entry.Topics[2].Bytes()) != recipient {
+			continue
+		}
+		total.Add(total, new(big.Int).SetBytes(entry.Data))
+	}
+	return total
+}
+
+// RealizedSlippage is how far the actual output fell short of the expected
+// one, in percent. It is negative when the swap received more than expected.
+func RealizedSlippage(expected, actual *big.Int) float64 {
+	if expected.Sign() <= 0 {
+		return 0
+	}
+	shortfall := new(big.Float).SetInt(new(big.Int).Sub(expected, actual))
+	pct, _ := new(big.Float).Quo(shortfall, new(big.Float).SetInt(expected)).Float64()
+	return 100 * pct
+}
+
+// RouteExecutionQuality is the realized slippage of the swaps of one pair on
+// one route
+type RouteExecutionQuality struct {
+	Pair           string  `json:"pair"`
+	Route          string  `json:"route"`
+	Swaps          int     `json:"swaps"`
+	AvgSlippagePct float64 `json:"avg_slippage_pct"`
+	P95SlippagePct float64 `json:"p95_slippage_pct"`
+	// PenaltyPct is the route's penalty across all its pairs
+	PenaltyPct float64 `json:"penalty_pct"`
+}
+
+// ExecutionQuality keeps the realized slippage of measured swaps by route and
+// penalizes routes that consistently execute worse than simulated. Samples are
+// kept in memory.
+type ExecutionQuality struct {
+	mu      sync.RWMutex
+	samples map[string][]SwapExecution
+	now     func() time.Time
+}
+
+// NewExecutionQuality creates an empty tracker
+func NewExecutionQuality() *ExecutionQuality {
+	return &ExecutionQuality{
+		samples: make(map[string][]SwapExecution),
+		now:     utcNow,
+	}
+}
+
+// Record adds a measured swap
+func (eq *ExecutionQuality) Record(execution SwapExecution) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+
+	execution.Route = strings.ToLower(execution.Route)
+	// Kept in mining order, so the window is a suffix
+	samples := eq.samples[execution.Route]
+	at := sort.Search(len(samples), func(i int) bool { return samples[i].MinedAt.After(execution.MinedAt) })
+	samples = append(samples, SwapExecution{})
+	copy(samples[at+1:], samples[at:])
+	samples[at] = execution
+	if len(samples) > maxRouteSamples {
+		samples = samples[len(samples)-maxRouteSamples:]
+	}
+	eq.samples[execution.Route] = samples
+}
+
+// recent returns a route's swaps mined within the window. The lock is held by
+// the caller.
+func (eq *ExecutionQuality) recent(route string) []SwapExecution {
+	since := eq.now().Add(-ExecutionQualityWindow)
+	samples := eq.samples[route]
+	start := sort.Search(len(samples), func(i int) bool { return !samples[i].MinedAt.Before(since) })
+	return samples[start:]
+}
+
+// Penalty is the slippage, in percent, to expect on top of a route's quotes:
+// its average realized slippage over the window once it has at least
+// RoutePenaltyMinSwaps swaps averaging above RoutePenaltyThreshold, or else 0
+func (eq *ExecutionQuality) Penalty(route string) float64 {
+	if eq == nil {
+		return 0
+	}
+	eq.mu.RLock()
+	defer eq.mu.RUnlock()
+	return routePenalty(eq.recent(strings.ToLower(route)))
+}
+
+func routePenalty(samples []SwapExecution) float64 {
+	if len(samples) < RoutePenaltyMinSwaps {
+		return 0
+	}
+	avg, _ := slippageStats(samples)
+	if avg <= RoutePenaltyThreshold {
+		return 0
+	}
+	return avg
+}
+
+// Report summarizes the window's swaps by pair and route, worst average
+// first. A non-empty pair keeps only that pair.
+func (eq *ExecutionQuality) Report(pair string) []RouteExecutionQuality {
+	pair = strings.ToUpper(strings.TrimSpace(pair))
+	eq.mu.RLock()
+	defer eq.mu.RUnlock()
+
+	report := make([]RouteExecutionQuality, 0)
+	for route := range eq.samples {
+		samples := eq.recent(route)
+		penalty := routePenalty(samples)
+		byPair := make(map[string][]SwapExecution)
+		for _, sample := range samples {
+			if pair == "" || sample.Pair == pair {
+				byPair[sample.Pair] = append(byPair[sample.Pair], sample)
+			}
+		}
+		for swapPair, swaps := range byPair {
+			avg, p95 := slippageStats(swaps)
+			report = append(report, RouteExecutionQuality{
+				Pair:           swapPair,
+				Route:          route,
+				Swaps:          len(swaps),
+				AvgSlippagePct: avg,
+				P95SlippagePct: p95,
+				PenaltyPct:     penalty,
+			})
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].AvgSlippagePct != report[j].AvgSlippagePct {
+			return report[i].AvgSlippagePct > report[j].AvgSlippagePct
+		}
+		if report[i].Pair != report[j].Pair {
+			return report[i].Pair < report[j].Pair
+		}
+		return report[i].Route < report[j].Route
+	})
+	return report
+}
+
+// slippageStats returns the average and nearest-rank 95th percentile
+// slippage of the swaps
+func slippageStats(swaps []SwapExecution) (float64, float64) {
+	values := make([]float64, len(swaps))
+	sum := 0.0
+	for i, swap := range swaps {
+		values[i] = swap.SlippagePct
+		sum += swap.SlippagePct
+	}
+	sort.Float64s(values)
+	rank := int(math.Ceil(0.95*float64(len(values)))) - 1
+	return sum / float64(len(values)), values[rank]
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// transferLog is an ERC-20 Transfer event of amount of the token
+func transferLog(token, from, to common.Address, amount *big.Int) *types.Log {
+	return &types.Log{
+		Address: token,
+		Topics:  []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:    common.LeftPadBytes(amount.Bytes(), 32),
+	}
+}
+
+func TestActionQueueMeasuresSwapSlippage(t *testing.T) {
+	chain := newReorgChain()
+	from, signer := newRelayer(t)
+	manager := NewNonceManager(chain, NonceManagerOptions{})
+	sent, err := manager.Send(context.Background(), from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	submitter := &fixedSubmitter{submission: ActionSubmission{TxHash: sent.Hash, From: from.Hex(), Nonce: sent.Nonce}}
+	queue, audit, clock := newTestActionQueue(submitter)
+	queue.SetConfirmationTracking(chain, 5)
+	execution := NewExecutionQuality()
+	execution.now = clock.Now
+	queue.SetExecutionQuality(execution)
+	ctx := context.Background()
+
+	user := summaryAddress
+	usdt := common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	kaiaToken := common.HexToAddress("0x00000000000000000000000000000000000000d2")
+	pool := common.HexToAddress("0x00000000000000000000000000000000000000d3")
+	queue.Enqueue(&ActionRequest{ID: "action_1", UserID: user.Hex(), ActionType: "swap", Parameters: map[string]interface{}{
+		SwapParamExpectedOut: "2000000000",
+		SwapParamTokenOut:    usdt.Hex(),
+		SwapParamRoute:       pool.Hex(),
+		SwapParamPair:        "kaia/usdt",
+	}}, "msg_1")
+	clock.Advance(DefaultActionSubmitDelay)
+	require.Equal(t, 1, queue.SubmitDue(ctx))
+	chain.mineBlock(from)
+
+	// The user receives 1,950 of 2,000 USDT expected, in two transfers; the
+	// pool's own transfers and other tokens don't count
+	chain.mu.Lock()
+	chain.receipts[common.HexToHash(sent.Hash)].Logs = []*types.Log{
+		transferLog(kaiaToken, user, pool, big.NewInt(1_000_000_000_000_000_000)),
+		transferLog(usdt, pool, user, big.NewInt(1_900_000_000)),
+		transferLog(usdt, pool, user, big.NewInt(50_000_000)),
+		transferLog(usdt, pool, common.HexToAddress("0x00000000000000000000000000000000000000fe"), big.NewInt(6_000_000)),
+	}
+	chain.mu.Unlock()
+	queue.TrackConfirmations(ctx)
+
+	action, _ := queue.Get("action_1")
+	require.NotNil(t, action.Execution)
+	assert.Equal(t, "1950000000", action.Execution.ActualAmountOut)
+	assert.InDelta(t, 2.5, action.Execution.SlippagePct, 1e-12)
+	assert.Equal(t, "KAIA/USDT", action.Execution.Pair)
+
+	records := audit.Records(user.Hex(), time.Time{}, time.Time{})
+	executed := records[len(records)-1]
+	assert.Equal(t, ActionEventExecuted, executed.Event)
+	require.NotNil(t, executed.SlippagePct)
+	assert.InDelta(t, 2.5, *executed.SlippagePct, 1e-12)
+
+	// Measured once, however many times the receipt is read
+	chain.mineBlock(from)
+	queue.TrackConfirmations(ctx)
+	report := execution.Report("")
+	require.Len(t, report, 1)
+	assert.Equal(t, 1, report[0].Swaps)
+	assert.Zero(t, report[0].PenaltyPct, "one swap isn't enough to penalize a route")
+}
+
+func TestExecutionQualityPenalizesBadRoutes(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	execution := NewExecutionQuality()
+	execution.now = func() time.Time { return now }
+	record := func(route string, slippage float64, age time.Duration) {
+		execution.Record(SwapExecution{Pair: "KAIA/USDT", Route: route, SlippagePct: slippage, MinedAt: now.Add(-age)})
+	}
+
+	good, bad := "0x00000000000000000000000000000000000000d3", "0x00000000000000000000000000000000000000d4"
+	for i := 0; i < 10; i++ {
+		record(good, 0.1, time.Duration(i)*time.Hour)
+	}
+	slippages := []float64{0.5, 1, 1.5, 2, 0.8, 1.2, 3}
+	for i, slippage := range slippages {
+		if i == RoutePenaltyMinSwaps-1 {
+			assert.Zero(t, execution.Penalty(bad), "too few swaps to penalize")
+		}
+		record(bad, slippage, time.Duration(len(slippages)-i)*time.Hour)
+	}
+	// Swaps older than the window no longer count
+	record(bad, 40, ExecutionQualityWindow+time.Hour)
+
+	assert.Zero(t, execution.Penalty(good))
+	assert.InDelta(t, 10.0/7, execution.Penalty(bad), 1e-12)
+
+	report := execution.Report("kaia/usdt")
+	require.Len(t, report, 2)
+	assert.Equal(t, bad, report[0].Route, "worst route first")
+	assert.Equal(t, 7, report[0].Swaps)
+	assert.InDelta(t, 3, report[0].P95SlippagePct, 1e-12)
+	assert.InDelta(t, 0.1, report[1].AvgSlippagePct, 1e-12)
+	assert.Empty(t, execution.Report("ETH/USDC"))
+
+	// The penalty tightens chat split plans on the route
+	engine := newTestChatEngine(t)
+	engine.SetPoolDepths(NewPoolDepthReader(newTestPoolReader(newFiftyFiftyPair(), nil), []common.Address{lpPair}))
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "0xuser", Message: "swap 20 ETH"})
+	require.NoError(t, err)
+	before := response.Metadata["split_plan"].(*SwapSplitPlan)
+
+	penalized := NewExecutionQuality()
+	for i := 0; i < RoutePenaltyMinSwaps; i++ {
+		penalized.Record(SwapExecution{Route: lpPair.Hex(), SlippagePct: 0.8, MinedAt: time.Now()})
+	}
+	engine.SetExecutionQuality(penalized)
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m2", UserID: "0xuser", Message: "swap 20 ETH"})
+	require.NoError(t, err)
+	after := response.Metadata["split_plan"].(*SwapSplitPlan)
+	assert.InDelta(t, 0.8, after.RoutePenalty, 1e-12)
+	assert.Greater(t, after.Tranches, before.Tranches)
+	assert.Less(t, after.MaxSize, before.MaxSize)
+	assert.Contains(t, response.Response, "received 0.8% less than quoted")
+}
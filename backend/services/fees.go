@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ContractCreationLabel labels fees spent deploying contracts
+const ContractCreationLabel = "Contract creation"
+
+// feeCoverageStaleness is how far the indexed range may lag the chain head
+// before a fee report is considered incomplete
+const feeCoverageStaleness = 5 * time.Minute
+
+// HistoricalPriceSource provides USD prices at a point in time
+type HistoricalPriceSource interface {
+	PriceAt(ctx context.Context, symbol string, at time.Time) (float64, error)
+}
+
+// DailyFeeSpend is the gas spent on one UTC day
+type DailyFeeSpend struct {
+	Date    string  `json:"date"`
+	TxCount int     `json:"tx_count"`
+	GasUsed uint64  `json:"gas_used"`
+	Fee     float64 `json:"fee"`
+	FeeUSD  float64 `json:"fee_usd"`
+	// FeeDisplay is FeeUSD in the display currency, when one was asked for
+	FeeDisplay float64 `json:"fee_display,omitempty"`
+}
+
+// ContractFeeSpend is the gas spent calling one destination
+type ContractFeeSpend struct {
+	Address string  `json:"address"`
+	Label   string  `json:"label,omitempty"`
+	TxCount int     `json:"tx_count"`
+	GasUsed uint64  `json:"gas_used"`
+	Fee     float64 `json:"fee"`
+	FeeUSD  float64 `json:"fee_usd"`
+	// FeeDisplay is FeeUSD in the display currency, when one was asked for
+	FeeDisplay float64 `json:"fee_display,omitempty"`
+}
+
+// FeeSpendReport is the gas an address spent on transactions it sent
+type FeeSpendReport struct {
+	Address         string             `json:"address"`
+	Since           time.Time          `json:"since"`
+	Until           time.Time          `json:"until"`
+	Symbol          string             `json:"symbol"`
+	TxCount         int                `json:"tx_count"`
+	GasUsed         uint64             `json:"gas_used"`
+	TotalFeeWei     string             `json:"total_fee_wei"`
+	TotalFee        float64            `json:"total_fee"`
+	TotalFeeUSD     float64            `json:"total_fee_usd"`
+	ByDay           []DailyFeeSpend    `json:"by_day"`
+	ByContract      []ContractFeeSpend `json:"by_contract"`
+	MissingReceipts int                `json:"missing_receipts"`
+	MissingPrices   int                `json:"missing_prices"`
+	Complete        bool               `json:"complete"`
+	Coverage        *IndexedRange      `json:"coverage,omitempty"`
+	Backfill        *BackfillTask      `json:"backfill,omitempty"`
+	// Truncated is set when a backfill ran out of its block budget before
+	// reaching the start of the window, so older transactions are missing
+	// until a later backfill reaches further back
+	Truncated bool `json:"truncated,omitempty"`
+	// PriceMethods counts the fees priced by each PriceSample method
+	PriceMethods map[string]int `json:"price_methods,omitempty"`
+	// TotalFeeDisplay is TotalFeeUSD in the display currency, set with
+	// Conversion when one was asked for
+	TotalFeeDisplay float64         `json:"total_fee_display,omitempty"`
+	Conversion      *ConversionRate `json:"conversion,omitempty"`
+
+	// Wallets are the linked wallets of a report across a user's wallets
+	Wallets []string `json:"wallets,omitempty"`
+	// InternalTransfers is the gas spent moving funds between the wallets,
+	// which is kept out of ByContract
+	InternalTransfers *ContractFeeSpend `json:"internal_transfers,omitempty"`
+
+	// Bridges are the bridge transfers in the window, oldest first
+	Bridges []BridgeFeeSpend `json:"bridges,omitempty"`
+}
+
+// BridgeFeeSpend is a bridge transfer of the address and the gas its leg on
+// Kaia cost. Transfers a relayer delivered cost the address nothing.
+type BridgeFeeSpend struct {
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+	BridgeTransfer
+	Fee    float64 `json:"fee"`
+	FeeUSD float64 `json:"fee_usd"`
+	// FeeDisplay is FeeUSD in the display currency, when one was asked for
+	FeeDisplay float64 `json:"fee_display,omitempty"`
+}
+
+// FeeAnalyzer computes gas spend from indexed receipts, backfilling receipts
+// for windows the index doesn't cover yet
+type FeeAnalyzer struct {
+	index     *TransactionIndex
+	prices    HistoricalPriceSource
+	labels    map[string]string
+	backfills *ReceiptBackfiller
+	now       func() time.Time
+
+	// bridges links pending bridge transfers before they are reported
+	bridges *BridgeDetector
+}
+
+// NewFeeAnalyzer creates a fee analyzer. Labels map lowercased contract
+// addresses to display names; backfills may be nil to only use what is indexed.
+func NewFeeAnalyzer(index *TransactionIndex, prices HistoricalPriceSource, labels map[string]string, backfills *ReceiptBackfiller) *FeeAnalyzer {
+	normalized := make(map[string]string, len(labels))
+	for address, label := range labels {
+		normalized[strings.ToLower(address)] = label
+	}
+
+	return &FeeAnalyzer{
+		index:     index,
+		prices:    prices,
+		labels:    normalized,
+		backfills: backfills,
+		now:       utcNow,
+	}
+}
+
+// SetBridgeDetector links the bridge transfers of reports to their leg on
+// the counterpart chain
+func (fa *FeeAnalyzer) SetBridgeDetector(bridges *BridgeDetector) {
+	fa.bridges = bridges
+}
+
+// FeeSpend reports the gas the address spent since the given time. When the
+// index doesn't reach back that far a backfill is started, and while it runs
+// only the task is returned. Once it has finished the report is computed from
+// whatever was indexed and marked incomplete if the window is still not
+// covered. An index that covers the window but lags the chain head is caught
+// up in the background while the indexed data is reported.
+func (fa *FeeAnalyzer) FeeSpend(ctx context.Context, address common.Address, since time.Time) (*FeeSpendReport, *BackfillTask, error) {
+	return fa.FeeSpendWallets(ctx, []common.Address{address}, since)
+}
+
+// FeeSpendWallets reports the gas a user's wallets spent together, reported
+// under the first. A transaction between two of the wallets is counted once
+// and its fee is filed under InternalTransfers. The index is backfilled for
+// each wallet as FeeSpend does, returning the first running task.
+func (fa *FeeAnalyzer) FeeSpendWallets(ctx context.Context, wallets []common.Address, since time.Time) (*FeeSpendReport, *BackfillTask, error) {
+	until := fa.now()
+
+	complete, truncated := true, false
+	var coverage IndexedRange
+	var covered bool
+	var task *BackfillTask
+	var txs []IndexedTransaction
+	seen := make(map[string]bool)
+	for _, wallet := range wallets {
+		coverage, covered = fa.index.Coverage(wallet)
+		startCovered := covered && !coverage.From.After(since)
+		walletComplete := startCovered && until.Sub(coverage.To) <= feeCoverageStaleness
+		complete = complete && walletComplete
+
+		if !walletComplete && fa.backfills != nil {
+			ensured, err := fa.backfills.Ensure(wallet, since, BackfillRequester(ctx))
+			switch {
+			case errors.Is(err, ErrBackfillLimit):
+				// Reported from what is indexed, as incomplete
+			case err != nil:
+				return nil, nil, fmt.Errorf("failed to start backfill: %w", err)
+			case ensured.Active() && !startCovered:
+				return nil, &ensured, nil
+			default:
+				if task == nil {
+					task = &ensured
+				}
+				truncated = truncated || ensured.Truncated
+			}
+		}
+
+		for _, tx := range fa.index.Transactions(wallet, since, until) {
+			if !seen[tx.Hash] {
+				seen[tx.Hash] = true
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	if fa.bridges != nil {
+		fa.bridges.LinkIndexed(ctx, fa.index, txs)
+	}
+	report, err := fa.aggregate(ctx, wallets, txs, since, until)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Complete = complete
+	report.Truncated = truncated
+	report.Backfill = task
+	// The coverage of several wallets differs, so it is only reported for one
+	if covered && len(wallets) == 1 {
+		report.Coverage = &coverage
+	}
+	return report, nil, nil
+}
+
+// aggregate sums the fees of the transactions the address sent, converting
+// each at the native token price when it was sent
+func (fa *FeeAnalyzer) aggregate(ctx context.Context, wallets []common.Address, txs []IndexedTransaction, since, until time.Time) (*FeeSpendReport, error) {
+	senders := newWalletSet(wallets)
+	report := &FeeSpendReport{
+		Address:    wallets[0].Hex(),
+		Since:      since,
+		Until:      until,
+		Symbol:     NativeSymbol,
+		ByDay:      make([]DailyFeeSpend, 0),
+		ByContract: make([]ContractFeeSpend, 0),
+	}
+	if len(wallets) > 1 {
+		for _, wallet := range wallets {
+			report.Wallets = append(report.Wallets, wallet.Hex())
+		}
+		report.InternalTransfers = &ContractFeeSpend{Label: "Transfers between linked wallets"}
+	}
+
+	total := new(big.Int)
+	days := make(map[string]*DailyFeeSpend)
+	contracts := make(map[string]*ContractFeeSpend)
+
+	for _, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var bridge *BridgeFeeSpend
+		if tx.Bridge != nil {
+			report.Bridges = append(report.Bridges, BridgeFeeSpend{Hash: tx.Hash, Timestamp: tx.Timestamp, BridgeTransfer: *tx.Bridge})
+			bridge = &report.Bridges[len(report.Bridges)-1]
+		}
+		if !senders[tx.From] {
+			continue
+		}
+		fee := tx.Fee()
+		if fee == nil {
+			report.MissingReceipts++
+			continue
+		}
+
+		feeNative := weiToFloat(fee, 18)
+		var feeUSD float64
+		if sample, err := samplePrice(ctx, fa.prices, NativeSymbol, tx.Timestamp); err == nil {
+			feeUSD = feeNative * sample.PriceUSD
+			if report.PriceMethods == nil {
+				report.PriceMethods = make(map[string]int)
+			}
+			report.PriceMethods[sample.Method]++
+		} else {
+			report.MissingPrices++
+		}
+
+		if bridge != nil {
+			bridge.Fee, bridge.FeeUSD = feeNative, feeUSD
+		}
+
+		total.Add(total, fee)
+		report.TxCount++
+		report.GasUsed += tx.GasUsed
+		report.TotalFeeUSD += feeUSD
+
+		date := tx.Timestamp.UTC().Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = &DailyFeeSpend{Date: date}
+			days[date] = day
+		}
+		day.TxCount++
+		day.GasUsed += tx.GasUsed
+		day.Fee += feeNative
+		day.FeeUSD += feeUSD
+
+		if senders.internal(tx.From, tx.To) {
+			report.InternalTransfers.TxCount++
+			report.InternalTransfers.GasUsed += tx.GasUsed
+			report.InternalTransfers.Fee += feeNative
+			report.InternalTransfers.FeeUSD += feeUSD
+			continue
+		}
+		contract, ok := contracts[tx.To]
+		if !ok {
+			contract = &ContractFeeSpend{Address: tx.To, Label: fa.label(tx.To)}
+			contracts[tx.To] = contract
+		}
+		contract.TxCount++
+		contract.GasUsed += tx.GasUsed
+		contract.Fee += feeNative
+		contract.FeeUSD += feeUSD
+	}
+
+	report.TotalFeeWei = total.String()
+	report.TotalFee = weiToFloat(total, 18)
+
+	for _, day := range days {
+		report.ByDay = append(report.ByDay, *day)
+	}
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Date < report.ByDay[j].Date })
+
+	for _, contract := range contracts {
+		report.ByContract = append(report.ByContract, *contract)
+	}
+	sort.Slice(report.ByContract, func(i, j int) bool {
+		if report.ByContract[i].Fee != report.ByContract[j].Fee {
+			return report.ByContract[i].Fee > report.ByContract[j].Fee
+		}
+		return report.ByContract[i].Address < report.ByContract[j].Address
+	})
+
+	return report, nil
+}
+
+// label returns the display name of a destination address
+func (fa *FeeAnalyzer) label(address string) string {
+	if address == "" {
+		return ContractCreationLabel
+	}
+	return fa.labels[address]
+}
+
+// ContractLabels builds the contract label map from "0xaddress:Label" entries
+// separated by commas, plus the symbols of tracked tokens
+func ContractLabels(spec string, tokens []TrackedToken) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, token := range tokens {
+		labels[strings.ToLower(token.Address.Hex())] = token.Symbol
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		address, label, ok := strings.Cut(entry, ":")
+		if !ok || !common.IsHexAddress(address) || strings.TrimSpace(label) == "" {
+			return nil, fmt.Errorf("invalid contract label %q, expected 0xaddress:Label", entry)
+		}
+		labels[strings.ToLower(common.HexToAddress(address).Hex())] = strings.TrimSpace(label)
+	}
+	return labels, nil
+}
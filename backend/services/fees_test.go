@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const gwei = 1_000_000_000
+
+var (
+	feeDex    = "0x00000000000000000000000000000000000000d1"
+	feeBridge = "0x00000000000000000000000000000000000000d2"
+)
+
+// datedPrices prices the native token by UTC month
+type datedPrices map[time.Month]float64
+
+func (d datedPrices) PriceAt(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	price, ok := d[at.UTC().Month()]
+	if !ok {
+		return 0, errors.New("no price recorded")
+	}
+	return price, nil
+}
+
+func feeTx(hash, from, to string, at time.Time, gasUsed uint64, priceGwei int64) IndexedTransaction {
+	return IndexedTransaction{
+		Hash:              hash,
+		From:              from,
+		To:                to,
+		Timestamp:         at,
+		GasUsed:           gasUsed,
+		EffectiveGasPrice: big.NewInt(priceGwei * gwei),
+	}
+}
+
+func TestFeeSpendAggregatesAcrossMonthBoundary(t *testing.T) {
+	sender := summaryAddress.Hex()
+	monthEnd := time.Date(2025, 1, 31, 23, 30, 0, 0, time.UTC)
+	monthStart := time.Date(2025, 2, 1, 0, 15, 0, 0, time.UTC)
+
+	index := NewTransactionIndex()
+	index.Add(feeTx("0x1", sender, feeDex, monthEnd, 100_000, 25))
+	index.Add(feeTx("0x2", sender, feeBridge, monthEnd.Add(10*time.Minute), 50_000, 25))
+	index.Add(feeTx("0x3", sender, feeDex, monthStart, 200_000, 30))
+	index.Add(feeTx("0x4", sender, "", monthStart.Add(time.Hour), 1_000_000, 30))
+	// Received transactions are paid for by their sender
+	index.Add(feeTx("0x5", feeDex, sender, monthStart, 80_000, 30))
+	// Transactions whose receipt hasn't been fetched are counted separately
+	index.Add(IndexedTransaction{Hash: "0x6", From: sender, To: feeDex, Timestamp: monthStart})
+	index.MarkIndexed(summaryAddress, IndexedRange{FromBlock: 1, ToBlock: 100, From: monthEnd.AddDate(0, 0, -1), To: monthStart.Add(2 * time.Hour)}, time.Time{})
+
+	labels, err := ContractLabels(feeDex+":KaiaSwap Router", []TrackedToken{{Symbol: "USDT", Address: common.HexToAddress(feeBridge)}})
+	require.NoError(t, err)
+	analyzer := NewFeeAnalyzer(index, datedPrices{time.January: 0.2, time.February: 0.25}, labels, nil)
+	analyzer.now = func() time.Time { return monthStart.Add(2 * time.Hour) }
+
+	report, task, err := analyzer.FeeSpend(context.Background(), summaryAddress, monthEnd.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Nil(t, task)
+	require.NotNil(t, report)
+
+	assert.True(t, report.Complete)
+	assert.Equal(t, 4, report.TxCount)
+	assert.Equal(t, 1, report.MissingReceipts)
+	assert.Equal(t, uint64(1_350_000), report.GasUsed)
+	// 150k gas at 25 gwei in January plus 1.2M gas at 30 gwei in February
+	assert.Equal(t, "39750000000000000", report.TotalFeeWei)
+	assert.InDelta(t, 0.03975, report.TotalFee, 1e-12)
+	assert.InDelta(t, 0.00375*0.2+0.036*0.25, report.TotalFeeUSD, 1e-12)
+
+	require.Len(t, report.ByDay, 2)
+	assert.Equal(t, "2025-01-31", report.ByDay[0].Date)
+	assert.Equal(t, 2, report.ByDay[0].TxCount)
+	assert.InDelta(t, 0.00375, report.ByDay[0].Fee, 1e-12)
+	assert.Equal(t, "2025-02-01", report.ByDay[1].Date)
+	assert.Equal(t, 2, report.ByDay[1].TxCount)
+	assert.InDelta(t, 0.036, report.ByDay[1].Fee, 1e-12)
+	assert.InDelta(t, 0.009, report.ByDay[1].FeeUSD, 1e-12)
+
+	require.Len(t, report.ByContract, 3)
+	assert.Equal(t, ContractCreationLabel, report.ByContract[0].Label)
+	assert.InDelta(t, 0.03, report.ByContract[0].Fee, 1e-12)
+
+	dex := report.ByContract[1]
+	assert.Equal(t, feeDex, dex.Address)
+	assert.Equal(t, "KaiaSwap Router", dex.Label)
+	assert.Equal(t, 2, dex.TxCount)
+	assert.Equal(t, uint64(300_000), dex.GasUsed)
+	assert.InDelta(t, 0.0085, dex.Fee, 1e-12)
+	assert.InDelta(t, 0.0025*0.2+0.006*0.25, dex.FeeUSD, 1e-12)
+
+	bridge := report.ByContract[2]
+	assert.Equal(t, "USDT", bridge.Label)
+	assert.Equal(t, 1, bridge.TxCount)
+	assert.InDelta(t, 0.00125, bridge.Fee, 1e-12)
+}
+
+func TestContractLabelsRejectsMalformedEntries(t *testing.T) {
+	for _, spec := range []string{"KaiaSwap", "0x1234:Router", feeDex + ":"} {
+		_, err := ContractLabels(spec, nil)
+		assert.Error(t, err, spec)
+	}
+}
+
+// fakeChain serves a short run of blocks holding signed transactions
+type fakeChain struct {
+	ChainClient
+
+	chainID  *big.Int
+	blocks   map[uint64]*types.Block
+	receipts map[common.Hash]*types.Receipt
+	head     uint64
+}
+
+func (fc *fakeChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return fc.head, nil
+}
+
+func (fc *fakeChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return fc.chainID, nil
+}
+
+func (fc *fakeChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	block, ok := fc.blocks[number.Uint64()]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return block, nil
+}
+
+func (fc *fakeChain) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	receipt, ok := fc.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+// newFakeChain builds blocks 1..head a minute apart. Every block holds a
+// transaction from the key's address to feeDex; odd blocks also hold one from
+// an unrelated sender.
+func newFakeChain(t *testing.T, head uint64, start time.Time) (*fakeChain, common.Address) {
+	chainID := big.NewInt(8217)
+	signer := types.LatestSignerForChainID(chainID)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	chain := &fakeChain{
+		chainID:  chainID,
+		blocks:   make(map[uint64]*types.Block),
+		receipts: make(map[common.Hash]*types.Receipt),
+		head:     head,
+	}
+	dex := common.HexToAddress(feeDex)
+	for number := uint64(1); number <= head; number++ {
+		header := &types.Header{
+			Number:  new(big.Int).SetUint64(number),
+			Time:    uint64(start.Add(time.Duration(number) * time.Minute).Unix()),
+			BaseFee: big.NewInt(25 * gwei),
+		}
+
+		txs := []*types.Transaction{types.MustSignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     number,
+			GasTipCap: big.NewInt(gwei),
+			GasFeeCap: big.NewInt(50 * gwei),
+			Gas:       100_000,
+			To:        &dex,
+		})}
+		// Even blocks report the effective price, odd ones leave it to be derived
+		chain.receipts[txs[0].Hash()] = &types.Receipt{GasUsed: 21_000}
+		if number%2 == 0 {
+			chain.receipts[txs[0].Hash()].EffectiveGasPrice = big.NewInt(26 * gwei)
+		}
+		if number%2 == 1 {
+			unrelated := types.MustSignNewTx(other, signer, &types.LegacyTx{Nonce: number, GasPrice: big.NewInt(30 * gwei), Gas: 21_000, To: &dex})
+			txs = append(txs, unrelated)
+		}
+
+		chain.blocks[number] = types.NewBlockWithHeader(header).WithBody(txs, nil)
+	}
+	return chain, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func waitForBackfill(t *testing.T, backfills *ReceiptBackfiller, id string) BackfillTask {
+	t.Helper()
+	var task BackfillTask
+	require.Eventually(t, func() bool {
+		var ok bool
+		task, ok = backfills.Task(id)
+		return ok && !task.Active()
+	}, 5*time.Second, 5*time.Millisecond)
+	return task
+}
+
+func TestFeeSpendBackfillsUncoveredWindow(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	chain, sender := newFakeChain(t, 20, start)
+	head := start.Add(20 * time.Minute)
+
+	index := NewTransactionIndex()
+	backfills := NewReceiptBackfiller(chain, index, 100, 1)
+	analyzer := NewFeeAnalyzer(index, datedPrices{time.February: 0.2}, nil, backfills)
+	analyzer.now = func() time.Time { return head }
+
+	// Blocks 11..20 fall in the window
+	since := start.Add(10*time.Minute + 30*time.Second)
+	report, task, err := analyzer.FeeSpend(context.Background(), sender, since)
+	require.NoError(t, err)
+	assert.Nil(t, report)
+	require.NotNil(t, task)
+
+	finished := waitForBackfill(t, backfills, task.ID)
+	assert.Equal(t, BackfillDone, finished.Status)
+	assert.False(t, finished.Truncated)
+	assert.Equal(t, 11, finished.BlocksScanned, "scans one block past the window start")
+
+	report, task, err = analyzer.FeeSpend(context.Background(), sender, since)
+	require.NoError(t, err)
+	assert.Nil(t, task)
+	require.NotNil(t, report)
+	assert.True(t, report.Complete)
+	assert.Equal(t, 10, report.TxCount)
+	// 21k gas at 26 gwei either way: reported on even blocks, base fee plus tip on odd ones
+	assert.Equal(t, new(big.Int).Mul(big.NewInt(10*21_000), big.NewInt(26*gwei)).String(), report.TotalFeeWei)
+	require.Len(t, report.ByContract, 1)
+	assert.Equal(t, feeDex, report.ByContract[0].Address)
+}
+
+func TestFeeSpendReportsTruncatedBackfillAsIncomplete(t *testing.T) {
+	start := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	chain, sender := newFakeChain(t, 20, start)
+	head := start.Add(20 * time.Minute)
+
+	index := NewTransactionIndex()
+	backfills := NewReceiptBackfiller(chain, index, 4, 1)
+	analyzer := NewFeeAnalyzer(index, datedPrices{time.February: 0.2}, nil, backfills)
+	analyzer.now = func() time.Time { return head }
+
+	_, task, err := analyzer.FeeSpend(context.Background(), sender, start)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	finished := waitForBackfill(t, backfills, task.ID)
+	assert.True(t, finished.Truncated)
+	assert.Equal(t, 4, finished.BlocksScanned)
+
+	// The finished task isn't retried straight away, so the partial data is reported
+	report, task, err := analyzer.FeeSpend(context.Background(), sender, start)
+	require.NoError(t, err)
+	assert.Nil(t, task)
+	require.NotNil(t, report)
+	assert.False(t, report.Complete)
+	assert.Equal(t, 4, report.TxCount)
+	require.NotNil(t, report.Coverage)
+	assert.Equal(t, uint64(17), report.Coverage.FromBlock)
+	require.NotNil(t, report.Backfill)
+	assert.Equal(t, finished.ID, report.Backfill.ID)
+	assert.True(t, report.Truncated)
+	assert.Contains(t, formatFeeSpend(report, "this month", USDConversion(head)), "only transactions since 1 Feb 2025 00:17 UTC are counted")
+}
+
+func TestChatAnswersGasSpendThisMonth(t *testing.T) {
+	engine := newTestChatEngine(t)
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	sender := summaryAddress.Hex()
+
+	index := NewTransactionIndex()
+	index.Add(feeTx("0x1", sender, feeDex, monthStart.Add(-time.Second), 100_000, 25))
+	index.Add(feeTx("0x2", sender, feeDex, monthStart, 200_000, 25))
+	index.MarkIndexed(summaryAddress, IndexedRange{FromBlock: 1, ToBlock: 10, From: monthStart.AddDate(0, -1, 0), To: now.Add(time.Hour)}, time.Time{})
+	engine.SetFeeAnalyzer(NewFeeAnalyzer(index, datedPrices{now.Month(): 0.2, monthStart.Add(-time.Second).Month(): 0.2}, map[string]string{feeDex: "KaiaSwap Router"}, nil))
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+		ID:      "m1",
+		UserID:  sender,
+		Message: "How much have I spent on gas this month?",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "gas_spend", response.Type)
+	report, ok := response.Data.(*FeeSpendReport)
+	require.True(t, ok)
+	assert.Equal(t, 1, report.TxCount, "only counts transactions since the start of the month")
+	assert.Contains(t, response.Response, "Gas Spend this month")
+	assert.Contains(t, response.Response, "Total Fees: 0.005 KAIA ($0.00)")
+	assert.Contains(t, response.Response, "KaiaSwap Router")
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidFields is returned for a field selection naming fields a response
+// doesn't have
+var ErrInvalidFields = errors.New("invalid fields")
+
+// InvalidFieldsError lists the requested field paths a response doesn't have
+type InvalidFieldsError struct {
+	Invalid []string
+}
+
+func (e *InvalidFieldsError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInvalidFields, strings.Join(e.Invalid, ", "))
+}
+
+func (e *InvalidFieldsError) Unwrap() error { return ErrInvalidFields }
+
+// fieldTree is a field selection; a nil subtree selects the whole field
+type fieldTree map[string]fieldTree
+
+// FieldSet is a sparse fieldset of a response: the comma-separated, dotted
+// JSON field paths a client asked for. Paths through lists apply to every
+// element. The zero FieldSet selects everything.
+type FieldSet struct {
+	tree fieldTree
+}
+
+// ParseFields validates a field selection against the JSON fields of T. Every
+// invalid path is listed in an *InvalidFieldsError. An empty selection keeps
+// every field.
+func ParseFields[T any](spec string) (FieldSet, error) {
+	root := reflect.TypeOf((*T)(nil)).Elem()
+	tree := fieldTree{}
+	var invalid []string
+	for _, path := range strings.Split(spec, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !validFieldPath(root, strings.Split(path, ".")) {
+			invalid = append(invalid, path)
+			continue
+		}
+		tree.add(strings.Split(path, "."))
+	}
+	if len(invalid) > 0 {
+		return FieldSet{}, &InvalidFieldsError{Invalid: invalid}
+	}
+	if len(tree) == 0 {
+		return FieldSet{}, nil
+	}
+	return FieldSet{tree: tree}, nil
+}
+
+// All reports whether the selection keeps every field
+func (fs FieldSet) All() bool {
+	return fs.tree == nil
+}
+
+// Project returns v cut down to the selected fields, ready to be serialized.
+// Fields that weren't selected are never visited, so they cost nothing to
+// encode. With no selection v is returned as it is.
+func (fs FieldSet) Project(v interface{}) interface{} {
+	if fs.tree == nil {
+		return v
+	}
+	return project(reflect.ValueOf(v), fs.tree)
+}
+
+// add selects a path. A selected field stays whole when one of its
+// subfields is also asked for.
+func (tree fieldTree) add(path []string) {
+	sub, seen := tree[path[0]]
+	if len(path) == 1 {
+		tree[path[0]] = nil
+		return
+	}
+	if seen && sub == nil {
+		return
+	}
+	if sub == nil {
+		sub = fieldTree{}
+		tree[path[0]] = sub
+	}
+	sub.add(path[1:])
+}
+
+// jsonField is a struct field as encoding/json names it
+type jsonField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonFieldCache    sync.Map // reflect.Type -> []jsonField
+)
+
+// jsonFields lists the serialized fields of a struct type, with the fields of
+// untagged embedded structs promoted the way encoding/json promotes them
+func jsonFields(t reflect.Type) []jsonField {
+	if cached, ok := jsonFieldCache.Load(t); ok {
+		return cached.([]jsonField)
+	}
+
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for _, promoted := range jsonFields(embedded) {
+					promoted.index = append([]int{i}, promoted.index...)
+					fields = append(fields, promoted)
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+		})
+	}
+
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
+
+// selectable returns the struct type a path can step into from t, looking
+// through pointers and lists. Types that encode themselves aren't selectable.
+func selectable(t reflect.Type) (reflect.Type, bool) {
+	for {
+		if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+			return nil, false
+		}
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array:
+			t = t.Elem()
+		case reflect.Struct:
+			return t, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// validFieldPath reports whether a dotted path names a JSON field of t
+func validFieldPath(t reflect.Type, path []string) bool {
+	for _, name := range path {
+		st, ok := selectable(t)
+		if !ok || name == "" {
+			return false
+		}
+		found := false
+		for _, field := range jsonFields(st) {
+			if field.name == name {
+				t, found = st.FieldByIndex(field.index).Type, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// project copies the selected fields of v into a projectedObject
+func project(v reflect.Value, tree fieldTree) interface{} {
+	if tree == nil {
+		if !v.IsValid() {
+			return nil
+		}
+		return v.Interface()
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = project(v.Index(i), tree)
+		}
+		return items
+	case reflect.Struct:
+		object := make(projectedObject, 0, len(tree))
+		for _, field := range jsonFields(v.Type()) {
+			sub, ok := tree[field.name]
+			if !ok {
+				continue
+			}
+			value, err := v.FieldByIndexErr(field.index)
+			if err != nil || !value.CanInterface() {
+				// A nil embedded pointer has no fields to show
+				continue
+			}
+			if field.omitEmpty && isEmptyJSONValue(value) {
+				continue
+			}
+			object = append(object, projectedField{name: field.name, value: project(value, sub)})
+		}
+		return object
+	default:
+		return v.Interface()
+	}
+}
+
+// isEmptyJSONValue reports whether encoding/json omits v from an omitempty
+// field
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// projectedField is one selected field of a projectedObject
+type projectedField struct {
+	name  string
+	value interface{}
+}
+
+// projectedObject is a projected struct. It encodes its fields in the order
+// the struct declares them.
+type projectedObject []projectedField
+
+// MarshalJSON encodes the selected fields as a JSON object
+func (po projectedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range po {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
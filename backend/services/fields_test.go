@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldSetProjectsNestedFields(t *testing.T) {
+	updated := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	yields := []YieldOpportunity{
+		{
+			Protocol: "Klayswap", AssetPair: "KAIA/USDT", APY: 12.5, TVL: 1_000_000,
+			LastUpdated: NewAPITime(updated), LastUpdatedUnix: updated.Unix(),
+			History:       &YieldSeries{Window: "7d", APY: []SeriesPoint{{Timestamp: updated, Value: 12}}},
+			RiskBreakdown: []RiskComponent{{Factor: "tvl", Risk: 0.2, Weight: 0.3, Points: 6}},
+		},
+		{Protocol: "Aave V3", AssetPair: "USDC/ETH", APY: 4, LastUpdated: NewAPITime(updated)},
+	}
+
+	fields, err := ParseFields[YieldOpportunity]("protocol, asset_pair,apy,history.window,risk_breakdown.factor,last_updated")
+	require.NoError(t, err)
+	projected, err := json.Marshal(fields.Project(yields))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"protocol":"Klayswap","asset_pair":"KAIA/USDT","apy":12.5,"last_updated":"2025-03-01T12:00:00Z",
+		 "history":{"window":"7d"},"risk_breakdown":[{"factor":"tvl"}]},
+		{"protocol":"Aave V3","asset_pair":"USDC/ETH","apy":4,"last_updated":"2025-03-01T12:00:00Z"}
+	]`, string(projected), "omitempty fields stay omitted")
+
+	// Fields are encoded in the order the struct declares them
+	assert.Regexp(t, `^\[\{"protocol":.*"asset_pair":.*"apy":`, string(projected))
+
+	full, err := json.Marshal(yields)
+	require.NoError(t, err)
+	assert.Less(t, len(projected)*2, len(full))
+
+	// Selecting a field whole wins over selecting part of it
+	fields, err = ParseFields[YieldOpportunity]("history.window,history")
+	require.NoError(t, err)
+	projected, err = json.Marshal(fields.Project(yields[0]))
+	require.NoError(t, err)
+	assert.Contains(t, string(projected), `"apy":[{`)
+
+	// No selection leaves the response as it is
+	fields, err = ParseFields[YieldOpportunity]("")
+	require.NoError(t, err)
+	assert.True(t, fields.All())
+	assert.Equal(t, yields, fields.Project(yields))
+}
+
+func TestParseFieldsRejectsUnknownFields(t *testing.T) {
+	_, err := ParseFields[YieldOpportunity]("protocol,reasoning,history.nope,apy.value,Protocol,history.")
+	require.ErrorIs(t, err, ErrInvalidFields)
+	var invalid *InvalidFieldsError
+	require.True(t, errors.As(err, &invalid))
+	// Only JSON names count, and leaves and self-encoding types have no subfields
+	assert.Equal(t, []string{"reasoning", "history.nope", "apy.value", "Protocol", "history."}, invalid.Invalid)
+
+	_, err = ParseFields[YieldOpportunity]("last_updated.year")
+	assert.ErrorIs(t, err, ErrInvalidFields)
+	_, err = ParseFields[PortfolioPerformance]("flows.tx_hash,best.symbol")
+	assert.NoError(t, err)
+}
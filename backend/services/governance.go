@@ -0,0 +1,459 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GovernanceProposal represents an ingested governance proposal
+type GovernanceProposal struct {
+	ID             string    `json:"id"`
+	Title          string    `json:"title"`
+	Proposer       string    `json:"proposer"`
+	Quorum         int       `json:"quorum"`
+	EligibleVoters int       `json:"eligible_voters"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	// SnapshotBlock is the block voting power is counted at, when known
+	SnapshotBlock uint64 `json:"snapshot_block,omitempty"`
+}
+
+// GovernanceVote represents a single vote cast on a proposal
+type GovernanceVote struct {
+	ProposalID string    `json:"proposal_id"`
+	Voter      string    `json:"voter"`
+	Support    string    `json:"support"` // for, against, abstain
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// OutcomeModel holds the logistic regression coefficients used to predict
+// proposal outcomes. A proposal passes only when it wins the majority and
+// reaches quorum, so each condition has its own logistic component and the
+// pass probability is their product. Coefficients are fit offline and loaded
+// from JSON.
+type OutcomeModel struct {
+	Majority MajorityCoefficients `json:"majority"`
+	Quorum   QuorumCoefficients   `json:"quorum"`
+	// DefaultTurnout is used for proposers without a voting history
+	DefaultTurnout float64 `json:"default_turnout"`
+}
+
+// MajorityCoefficients weight the features predicting a for-majority
+type MajorityCoefficients struct {
+	Intercept float64 `json:"intercept"`
+	ForMargin float64 `json:"for_margin"`
+}
+
+// QuorumCoefficients weight the features predicting that quorum is reached
+type QuorumCoefficients struct {
+	Intercept         float64 `json:"intercept"`
+	QuorumDistance    float64 `json:"quorum_distance"`
+	ProjectedVelocity float64 `json:"projected_velocity"`
+	ProposerTurnout   float64 `json:"proposer_turnout"`
+}
+
+// OutcomeFeatures are the model inputs derived from a proposal's voting state
+type OutcomeFeatures struct {
+	// ForMargin is the for share of decisive votes minus one half
+	ForMargin float64 `json:"for_margin"`
+	// ProjectedVelocity is the current vote rate projected over the time
+	// remaining, as a fraction of quorum
+	ProjectedVelocity float64 `json:"projected_velocity"`
+	// ProposerTurnout is the proposer's historical final turnout
+	ProposerTurnout float64 `json:"proposer_turnout"`
+	// QuorumDistance is the fraction of quorum still missing (negative once exceeded)
+	QuorumDistance float64 `json:"quorum_distance"`
+}
+
+// OutcomePrediction is the predicted result of a proposal
+type OutcomePrediction struct {
+	ProposalID            string          `json:"proposal_id"`
+	PredictedOutcome      string          `json:"predicted_outcome"`
+	PassProbability       float64         `json:"pass_probability"`
+	ExpectedParticipation float64         `json:"expected_participation"`
+	Final                 bool            `json:"final"`
+	Features              OutcomeFeatures `json:"features"`
+	UpdatedAt             APITime         `json:"updated_at"`
+	// Deprecated: use UpdatedAt
+	UpdatedAtUnix int64 `json:"updated_at_unix"`
+}
+
+// DefaultOutcomeModel returns the coefficients shipped with the service
+func DefaultOutcomeModel() *OutcomeModel {
+	return &OutcomeModel{
+		Majority: MajorityCoefficients{
+			Intercept: 0,
+			ForMargin: 25,
+		},
+		Quorum: QuorumCoefficients{
+			Intercept:         -0.3,
+			QuorumDistance:    -10,
+			ProjectedVelocity: 10,
+			ProposerTurnout:   1,
+		},
+		DefaultTurnout: 0.3,
+	}
+}
+
+// LoadOutcomeModel loads model coefficients from a JSON artifact
+func LoadOutcomeModel(path string) (*OutcomeModel, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outcome model: %w", err)
+	}
+
+	model := DefaultOutcomeModel()
+	if err := json.Unmarshal(raw, model); err != nil {
+		return nil, fmt.Errorf("failed to parse outcome model: %w", err)
+	}
+
+	return model, nil
+}
+
+// Predict computes the pass probability for the given features
+func (m *OutcomeModel) Predict(features OutcomeFeatures) float64 {
+	majority := sigmoid(m.Majority.Intercept + m.Majority.ForMargin*features.ForMargin)
+	quorum := sigmoid(m.Quorum.Intercept +
+		m.Quorum.QuorumDistance*features.QuorumDistance +
+		m.Quorum.ProjectedVelocity*features.ProjectedVelocity +
+		m.Quorum.ProposerTurnout*features.ProposerTurnout)
+
+	return majority * quorum
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+// proposalTally tracks the running vote counts for a proposal
+type proposalTally struct {
+	proposal GovernanceProposal
+	voters   map[string]string
+	forVotes int
+	against  int
+	abstain  int
+}
+
+func (t *proposalTally) total() int {
+	return t.forVotes + t.against + t.abstain
+}
+
+// GovernanceTracker ingests proposals and votes and keeps outcome predictions fresh
+type GovernanceTracker struct {
+	mu          sync.RWMutex
+	model       *OutcomeModel
+	tallies     map[string]*proposalTally
+	predictions map[string]*OutcomePrediction
+	now         func() time.Time
+}
+
+// NewGovernanceTracker creates a tracker using the given outcome model
+func NewGovernanceTracker(model *OutcomeModel) *GovernanceTracker {
+	if model == nil {
+		model = DefaultOutcomeModel()
+	}
+	return &GovernanceTracker{
+		model:       model,
+		tallies:     make(map[string]*proposalTally),
+		predictions: make(map[string]*OutcomePrediction),
+		now:         utcNow,
+	}
+}
+
+// SetModel swaps the outcome model and refreshes every prediction
+func (gt *GovernanceTracker) SetModel(model *OutcomeModel) {
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+
+	gt.model = model
+	for id := range gt.tallies {
+		gt.refreshPrediction(id)
+	}
+}
+
+// IngestProposal registers a proposal (or updates its metadata)
+func (gt *GovernanceTracker) IngestProposal(proposal GovernanceProposal) error {
+	if proposal.ID == "" {
+		return fmt.Errorf("proposal id is required")
+	}
+	if !proposal.EndTime.After(proposal.StartTime) {
+		return fmt.Errorf("proposal %s ends before it starts", proposal.ID)
+	}
+
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+
+	if tally, exists := gt.tallies[proposal.ID]; exists {
+		tally.proposal = proposal
+	} else {
+		gt.tallies[proposal.ID] = &proposalTally{
+			proposal: proposal,
+			voters:   make(map[string]string),
+		}
+	}
+	gt.refreshPrediction(proposal.ID)
+
+	return nil
+}
+
+// IngestVote records a vote and refreshes the proposal's prediction.
+// A voter re-casting their vote replaces their earlier choice.
+func (gt *GovernanceTracker) IngestVote(vote GovernanceVote) error {
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+
+	tally, exists := gt.tallies[vote.ProposalID]
+	if !exists {
+		return fmt.Errorf("unknown proposal: %s", vote.ProposalID)
+	}
+
+	switch vote.Support {
+	case "for", "against", "abstain":
+	default:
+		return fmt.Errorf("invalid vote support: %s", vote.Support)
+	}
+
+	if previous, voted := tally.voters[vote.Voter]; voted {
+		tally.adjust(previous, -1)
+	}
+	tally.voters[vote.Voter] = vote.Support
+	tally.adjust(vote.Support, 1)
+
+	gt.refreshPrediction(vote.ProposalID)
+
+	return nil
+}
+
+func (t *proposalTally) adjust(support string, delta int) {
+	switch support {
+	case "for":
+		t.forVotes += delta
+	case "against":
+		t.against += delta
+	case "abstain":
+		t.abstain += delta
+	}
+}
+
+// Proposal returns an ingested proposal
+func (gt *GovernanceTracker) Proposal(proposalID string) (GovernanceProposal, bool) {
+	gt.mu.RLock()
+	defer gt.mu.RUnlock()
+
+	tally, exists := gt.tallies[proposalID]
+	if !exists {
+		return GovernanceProposal{}, false
+	}
+	return tally.proposal, true
+}
+
+// Prediction returns the latest prediction for a proposal
+func (gt *GovernanceTracker) Prediction(proposalID string) (*OutcomePrediction, bool) {
+	gt.mu.RLock()
+	defer gt.mu.RUnlock()
+
+	prediction, exists := gt.predictions[proposalID]
+	if !exists {
+		return nil, false
+	}
+	copied := *prediction
+	return &copied, true
+}
+
+// Sentiments returns the tracked proposals as sentiment entries, ordered by proposal ID
+func (gt *GovernanceTracker) Sentiments() []GovernanceSentiment {
+	gt.mu.RLock()
+	defer gt.mu.RUnlock()
+
+	sentiments := make([]GovernanceSentiment, 0, len(gt.tallies))
+	for id, tally := range gt.tallies {
+		sentiment := sentimentFromVotes(tally.forVotes, tally.against)
+		sentiment.ProposalID = id
+		sentiment.Title = tally.proposal.Title
+		sentiment.VoteCount = tally.total()
+		sentiment.ForVotes = tally.forVotes
+		sentiment.AgainstVotes = tally.against
+		sentiment.AbstainVotes = tally.abstain
+		if prediction, ok := gt.predictions[id]; ok {
+			sentiment.Prediction = prediction
+		}
+		sentiments = append(sentiments, sentiment)
+	}
+
+	sort.Slice(sentiments, func(i, j int) bool {
+		return sentiments[i].ProposalID < sentiments[j].ProposalID
+	})
+
+	return sentiments
+}
+
+// ProposalsSince returns proposals that opened at or after since, oldest first
+func (gt *GovernanceTracker) ProposalsSince(since time.Time) []GovernanceProposal {
+	gt.mu.RLock()
+	defer gt.mu.RUnlock()
+
+	proposals := make([]GovernanceProposal, 0)
+	for _, tally := range gt.tallies {
+		if !tally.proposal.StartTime.Before(since) {
+			proposals = append(proposals, tally.proposal)
+		}
+	}
+
+	sort.Slice(proposals, func(i, j int) bool {
+		if !proposals[i].StartTime.Equal(proposals[j].StartTime) {
+			return proposals[i].StartTime.Before(proposals[j].StartTime)
+		}
+		return proposals[i].ID < proposals[j].ID
+	})
+
+	return proposals
+}
+
+// ActiveProposals counts the proposals open for voting at the given time
+func (gt *GovernanceTracker) ActiveProposals(at time.Time) int {
+	gt.mu.RLock()
+	defer gt.mu.RUnlock()
+
+	active := 0
+	for _, tally := range gt.tallies {
+		if !tally.proposal.StartTime.After(at) && tally.proposal.EndTime.After(at) {
+			active++
+		}
+	}
+	return active
+}
+
+// refreshPrediction recomputes a proposal's prediction. Callers must hold gt.mu.
+func (gt *GovernanceTracker) refreshPrediction(proposalID string) {
+	tally := gt.tallies[proposalID]
+	now := gt.now()
+
+	prediction := &OutcomePrediction{
+		ProposalID:    proposalID,
+		UpdatedAt:     NewAPITime(now),
+		UpdatedAtUnix: now.Unix(),
+	}
+
+	features, expectedVotes := gt.features(tally, now)
+	prediction.Features = features
+
+	eligible := tally.proposal.EligibleVoters
+	if eligible > 0 {
+		prediction.ExpectedParticipation = math.Min(1, expectedVotes/float64(eligible))
+	}
+
+	if !now.Before(tally.proposal.EndTime) {
+		// Voting has closed; the outcome is known
+		prediction.Final = true
+		if tally.forVotes > tally.against && tally.total() >= tally.proposal.Quorum {
+			prediction.PassProbability = 1
+		}
+		if eligible > 0 {
+			prediction.ExpectedParticipation = float64(tally.total()) / float64(eligible)
+		}
+	} else {
+		prediction.PassProbability = gt.model.Predict(features)
+	}
+
+	prediction.PredictedOutcome = "fail"
+	if prediction.PassProbability >= 0.5 {
+		prediction.PredictedOutcome = "pass"
+	}
+
+	gt.predictions[proposalID] = prediction
+}
+
+// features derives the model inputs for a proposal and its expected final vote count
+func (gt *GovernanceTracker) features(tally *proposalTally, now time.Time) (OutcomeFeatures, float64) {
+	proposal := tally.proposal
+	total := float64(tally.total())
+
+	forRatio := 0.5
+	if decisive := tally.forVotes + tally.against; decisive > 0 {
+		forRatio = float64(tally.forVotes) / float64(decisive)
+	}
+
+	// Vote velocity in votes per hour, projected over the remaining window
+	elapsed := now.Sub(proposal.StartTime).Hours()
+	remaining := proposal.EndTime.Sub(now).Hours()
+	if remaining < 0 {
+		remaining = 0
+	}
+	projected := 0.0
+	if elapsed > 0 {
+		projected = total / elapsed * remaining
+	}
+
+	features := OutcomeFeatures{
+		ForMargin:       forRatio - 0.5,
+		ProposerTurnout: gt.proposerTurnout(proposal),
+	}
+
+	eligible := float64(proposal.EligibleVoters)
+	if proposal.Quorum > 0 {
+		distance := (float64(proposal.Quorum) - total) / float64(proposal.Quorum)
+		features.QuorumDistance = math.Max(-1, math.Min(1, distance))
+		features.ProjectedVelocity = math.Min(2, projected/float64(proposal.Quorum))
+	} else {
+		features.QuorumDistance = -1
+	}
+
+	// Blend the velocity projection with the proposer's historical turnout
+	expected := total + projected
+	if eligible > 0 {
+		historical := features.ProposerTurnout * eligible
+		expected = math.Max(total, (expected+historical)/2)
+	}
+
+	return features, expected
+}
+
+// proposerTurnout averages the final turnout of the proposer's closed proposals.
+// Callers must hold gt.mu.
+func (gt *GovernanceTracker) proposerTurnout(proposal GovernanceProposal) float64 {
+	now := gt.now()
+	sum, count := 0.0, 0
+
+	for id, tally := range gt.tallies {
+		past := tally.proposal
+		if id == proposal.ID || past.Proposer != proposal.Proposer || past.EligibleVoters == 0 {
+			continue
+		}
+		if now.Before(past.EndTime) {
+			continue
+		}
+		sum += float64(tally.total()) / float64(past.EligibleVoters)
+		count++
+	}
+
+	if count == 0 {
+		return gt.model.DefaultTurnout
+	}
+	return sum / float64(count)
+}
+
+// sentimentFromVotes classifies vote counts into a sentiment with a confidence score
+func sentimentFromVotes(forVotes, againstVotes int) GovernanceSentiment {
+	decisive := forVotes + againstVotes
+	if decisive == 0 {
+		return GovernanceSentiment{Sentiment: "neutral"}
+	}
+
+	forRatio := float64(forVotes) / float64(decisive)
+	sentiment := "neutral"
+	if forRatio > 0.6 {
+		sentiment = "positive"
+	} else if forRatio < 0.4 {
+		sentiment = "negative"
+	}
+
+	return GovernanceSentiment{
+		Sentiment:  sentiment,
+		Confidence: math.Abs(forRatio-0.5) * 2,
+	}
+}
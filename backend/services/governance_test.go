@@ -0,0 +1,201 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var votingStart = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestTracker(now *time.Time) *GovernanceTracker {
+	tracker := NewGovernanceTracker(DefaultOutcomeModel())
+	tracker.now = func() time.Time { return *now }
+	return tracker
+}
+
+func castVotes(t *testing.T, tracker *GovernanceTracker, proposalID string, support string, count int, offset int) {
+	for i := 0; i < count; i++ {
+		err := tracker.IngestVote(GovernanceVote{
+			ProposalID: proposalID,
+			Voter:      fmt.Sprintf("0x%s%04d", support, offset+i),
+			Support:    support,
+			Timestamp:  votingStart.Add(time.Hour),
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestPredictionClearOutcomes(t *testing.T) {
+	now := votingStart.Add(48 * time.Hour)
+	tracker := newTestTracker(&now)
+
+	for _, id := range []string{"PASS", "FAIL", "NOQUORUM"} {
+		require.NoError(t, tracker.IngestProposal(GovernanceProposal{
+			ID:             id,
+			Proposer:       "0xproposer",
+			Quorum:         400,
+			EligibleVoters: 2000,
+			StartTime:      votingStart,
+			EndTime:        votingStart.Add(72 * time.Hour),
+		}))
+	}
+
+	castVotes(t, tracker, "PASS", "for", 500, 0)
+	castVotes(t, tracker, "PASS", "against", 100, 0)
+	castVotes(t, tracker, "FAIL", "for", 100, 0)
+	castVotes(t, tracker, "FAIL", "against", 500, 0)
+	castVotes(t, tracker, "NOQUORUM", "for", 20, 0)
+	castVotes(t, tracker, "NOQUORUM", "against", 2, 0)
+
+	pass, _ := tracker.Prediction("PASS")
+	assert.Equal(t, "pass", pass.PredictedOutcome)
+	assert.Greater(t, pass.PassProbability, 0.9)
+	assert.Greater(t, pass.ExpectedParticipation, 0.3)
+
+	fail, _ := tracker.Prediction("FAIL")
+	assert.Equal(t, "fail", fail.PredictedOutcome)
+	assert.Less(t, fail.PassProbability, 0.1)
+
+	noQuorum, _ := tracker.Prediction("NOQUORUM")
+	assert.Equal(t, "fail", noQuorum.PredictedOutcome)
+	assert.Greater(t, noQuorum.Features.QuorumDistance, 0.9)
+}
+
+func TestPredictionRefreshesOnVoteAndFinalizes(t *testing.T) {
+	now := votingStart.Add(12 * time.Hour)
+	tracker := newTestTracker(&now)
+	require.NoError(t, tracker.IngestProposal(GovernanceProposal{
+		ID: "P1", Quorum: 10, EligibleVoters: 50,
+		StartTime: votingStart, EndTime: votingStart.Add(24 * time.Hour),
+	}))
+
+	castVotes(t, tracker, "P1", "against", 6, 0)
+	before, _ := tracker.Prediction("P1")
+	assert.Equal(t, "fail", before.PredictedOutcome)
+
+	// Voters changing their minds replaces their earlier vote
+	castVotes(t, tracker, "P1", "for", 12, 0)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, tracker.IngestVote(GovernanceVote{ProposalID: "P1", Voter: fmt.Sprintf("0xagainst%04d", i), Support: "for"}))
+	}
+	after, _ := tracker.Prediction("P1")
+	assert.Equal(t, "pass", after.PredictedOutcome)
+	assert.Greater(t, after.PassProbability, before.PassProbability)
+
+	now = votingStart.Add(25 * time.Hour)
+	require.NoError(t, tracker.IngestVote(GovernanceVote{ProposalID: "P1", Voter: "0xlate", Support: "abstain"}))
+	final, _ := tracker.Prediction("P1")
+	assert.True(t, final.Final)
+	assert.Equal(t, 1.0, final.PassProbability)
+	assert.InDelta(t, 19.0/50.0, final.ExpectedParticipation, 1e-9)
+
+	sentiments := tracker.Sentiments()
+	require.Len(t, sentiments, 1)
+	assert.Equal(t, 18, sentiments[0].ForVotes)
+	assert.Equal(t, 0, sentiments[0].AgainstVotes)
+	assert.Equal(t, "positive", sentiments[0].Sentiment)
+
+	assert.Error(t, tracker.IngestVote(GovernanceVote{ProposalID: "missing", Voter: "0x1", Support: "for"}))
+	assert.Error(t, tracker.IngestVote(GovernanceVote{ProposalID: "P1", Voter: "0x1", Support: "maybe"}))
+}
+
+// TestPredictionCalibration replays a synthetic voting history where each
+// proposal's final outcome is known and checks mid-vote predictions against it.
+func TestPredictionCalibration(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	now := votingStart
+	tracker := newTestTracker(&now)
+
+	const proposals = 300
+	const eligible = 1000
+	const quorum = 200
+
+	type history struct {
+		id     string
+		votes  []GovernanceVote
+		passed bool
+	}
+	histories := make([]history, 0, proposals)
+
+	for p := 0; p < proposals; p++ {
+		h := history{id: fmt.Sprintf("P%03d", p)}
+		forShare := rng.Float64()
+		turnout := 0.05 + rng.Float64()*0.4
+		voters := int(turnout * eligible)
+
+		forVotes, againstVotes := 0, 0
+		for v := 0; v < voters; v++ {
+			support := "against"
+			if rng.Float64() < forShare {
+				support = "for"
+				forVotes++
+			} else {
+				againstVotes++
+			}
+			h.votes = append(h.votes, GovernanceVote{
+				ProposalID: h.id,
+				Voter:      fmt.Sprintf("0x%d", v),
+				Support:    support,
+				Timestamp:  votingStart.Add(time.Duration(rng.Int63n(int64(72 * time.Hour)))),
+			})
+		}
+		h.passed = forVotes > againstVotes && forVotes+againstVotes >= quorum
+		histories = append(histories, h)
+
+		require.NoError(t, tracker.IngestProposal(GovernanceProposal{
+			ID: h.id, Proposer: fmt.Sprintf("0xproposer%d", p%7), Quorum: quorum, EligibleVoters: eligible,
+			StartTime: votingStart, EndTime: votingStart.Add(72 * time.Hour),
+		}))
+	}
+
+	// Predict two thirds of the way through voting using only votes cast so far
+	now = votingStart.Add(48 * time.Hour)
+	for _, h := range histories {
+		for _, vote := range h.votes {
+			if vote.Timestamp.Before(now) {
+				require.NoError(t, tracker.IngestVote(vote))
+			}
+		}
+	}
+
+	brier, correct := 0.0, 0
+	for _, h := range histories {
+		prediction, ok := tracker.Prediction(h.id)
+		require.True(t, ok)
+
+		outcome := 0.0
+		if h.passed {
+			outcome = 1
+		}
+		brier += (prediction.PassProbability - outcome) * (prediction.PassProbability - outcome)
+		if (prediction.PredictedOutcome == "pass") == h.passed {
+			correct++
+		}
+	}
+	brier /= proposals
+
+	assert.Less(t, brier, 0.1, "Brier score too high: %.3f", brier)
+	assert.Greater(t, float64(correct)/proposals, 0.85)
+}
+
+func TestLoadOutcomeModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"majority": {"intercept": -2, "for_margin": 20}}`), 0o600))
+
+	model, err := LoadOutcomeModel(path)
+	require.NoError(t, err)
+	assert.Equal(t, -2.0, model.Majority.Intercept)
+	assert.Equal(t, 20.0, model.Majority.ForMargin)
+	// Unspecified coefficients keep their defaults
+	assert.Equal(t, DefaultOutcomeModel().Quorum, model.Quorum)
+
+	_, err = LoadOutcomeModel(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// DefaultHolderScanMaxBlocks bounds how many blocks of Transfer logs a
+	// holder scan replays
+	DefaultHolderScanMaxBlocks = 5_000_000
+	// HolderCacheTTL is how long a computed holder distribution is served
+	HolderCacheTTL = 6 * time.Hour
+
+	// HolderDustShare is the share of circulating supply below which a holder is dust
+	HolderDustShare = 0.00001
+	// HolderWhaleShare is the share of circulating supply from which a holder is a whale
+	HolderWhaleShare = 0.01
+
+	holderScanChunk = 5000
+)
+
+// ErrNotContract is returned when a holder scan targets an address without code
+var ErrNotContract = errors.New("address is not a contract")
+
+// burnAddresses always count as burned supply
+var burnAddresses = map[string]bool{
+	"0x000000000000000000000000000000000000dead": true,
+}
+
+// transferTopic is the signature topic of ERC-20 Transfer events
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// HolderTask tracks the Transfer log replay of a token
+type HolderTask struct {
+	ID            string     `json:"id"`
+	Token         string     `json:"token"`
+	Status        string     `json:"status"`
+	FromBlock     uint64     `json:"from_block"`
+	ToBlock       uint64     `json:"to_block"`
+	BlocksScanned uint64     `json:"blocks_scanned"`
+	Transfers     int        `json:"transfers"`
+	Truncated     bool       `json:"truncated"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// Active reports whether the task is still queued or running
+func (t HolderTask) Active() bool {
+	return t.Status == BackfillPending || t.Status == BackfillRunning
+}
+
+// TokenHolder is the balance of one holder of a token
+type TokenHolder struct {
+	Address string `json:"address"`
+	Label   string `json:"label,omitempty"`
+	// Balance is in the token's smallest unit
+	Balance string `json:"balance"`
+	// Share is the percentage of the supply held
+	Share float64 `json:"share"`
+}
+
+// HolderBuckets counts holders by their share of circulating supply
+type HolderBuckets struct {
+	Dust   int `json:"dust"`
+	Retail int `json:"retail"`
+	Whale  int `json:"whale"`
+}
+
+// HolderDistribution describes how a token's supply is spread over its
+// holders. Burn addresses and the token contract are listed as excluded and
+// left out of the holder count, Gini coefficient, and buckets.
+type HolderDistribution struct {
+	Token   string `json:"token"`
+	Block   uint64 `json:"block"`
+	Holders int    `json:"holders"`
+	// Supply is the total balance of all holders, in the smallest unit
+	Supply string `json:"supply"`
+	// CirculatingSupply is the supply outside excluded addresses
+	CirculatingSupply string        `json:"circulating_supply"`
+	Top               []TokenHolder `json:"top"`
+	Gini              float64       `json:"gini"`
+	Buckets           HolderBuckets `json:"buckets"`
+	Excluded          []TokenHolder `json:"excluded"`
+	// Truncated is set when the replay didn't reach back to the token's
+	// creation, so balances only reflect later transfers
+	Truncated  bool      `json:"truncated"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// cachedDistribution is a computed distribution with every holder
+type cachedDistribution struct {
+	distribution HolderDistribution
+	holders      []TokenHolder
+}
+
+// HolderAnalyzer builds token holder distributions by replaying Transfer logs.
+// Scans run as background tasks and their results are cached for HolderCacheTTL.
+type HolderAnalyzer struct {
+	client    ChainClient
+	labels    map[string]string
+	maxBlocks uint64
+	slots     chan struct{}
+	decoder   *ABIEventDecoder
+	logger    *log.Logger
+	mu        sync.Mutex
+	ctx       context.Context
+	tasks     map[string]*HolderTask
+	latest    map[string]string // token -> most recent task ID
+	cache     map[string]*cachedDistribution
+	now       func() time.Time
+}
+
+// NewHolderAnalyzer creates a holder analyzer running at most maxConcurrency
+// scans at once, each replaying at most maxBlocks blocks
+func NewHolderAnalyzer(client ChainClient, labels map[string]string, maxBlocks, maxConcurrency int) *HolderAnalyzer {
+	if maxBlocks <= 0 {
+		maxBlocks = DefaultHolderScanMaxBlocks
+	}
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+
+	return &HolderAnalyzer{
+		client:    client,
+		labels:    labels,
+		maxBlocks: uint64(maxBlocks),
+		slots:     make(chan struct{}, maxConcurrency),
+		decoder:   NewERC20TransferDecoder(),
+		logger:    log.New(log.Writer(), "[HolderAnalyzer] ", log.LstdFlags),
+		ctx:       context.Background(),
+		tasks:     make(map[string]*HolderTask),
+		latest:    make(map[string]string),
+		cache:     make(map[string]*cachedDistribution),
+		now:       utcNow,
+	}
+}
+
+// Start sets the context scans run under; running scans stop when it is cancelled
+func (ha *HolderAnalyzer) Start(ctx context.Context) {
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	ha.ctx = ctx
+}
+
+// Distribution returns the cached holder distribution of a token with its top
+// holders. Without a fresh one it returns the scan task computing it instead.
+func (ha *HolderAnalyzer) Distribution(token common.Address, top int) (*HolderDistribution, *HolderTask, error) {
+	key := strings.ToLower(token.Hex())
+
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	now := ha.now()
+	if cached, ok := ha.cache[key]; ok && now.Sub(cached.distribution.ComputedAt) < HolderCacheTTL {
+		distribution := cached.distribution
+		distribution.Top = append([]TokenHolder(nil), cached.holders[:min(top, len(cached.holders))]...)
+		return &distribution, nil, nil
+	}
+
+	if id, ok := ha.latest[key]; ok && ha.tasks[id].Active() {
+		task := *ha.tasks[id]
+		return nil, &task, nil
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate task ID: %w", err)
+	}
+	ha.prune(now)
+
+	task := &HolderTask{
+		ID:        "hd_" + id,
+		Token:     key,
+		Status:    BackfillPending,
+		CreatedAt: now,
+	}
+	ha.tasks[task.ID] = task
+	ha.latest[key] = task.ID
+
+	go ha.run(ha.ctx, token, task)
+	snapshot := *task
+	return nil, &snapshot, nil
+}
+
+// Task returns a snapshot of a task by ID
+func (ha *HolderAnalyzer) Task(id string) (HolderTask, bool) {
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	task, ok := ha.tasks[id]
+	if !ok {
+		return HolderTask{}, false
+	}
+	return *task, true
+}
+
+// prune drops finished tasks and distributions past the cache TTL. Callers
+// must hold ha.mu.
+func (ha *HolderAnalyzer) prune(now time.Time) {
+	for id, task := range ha.tasks {
+		if task.FinishedAt != nil && now.Sub(*task.FinishedAt) > HolderCacheTTL {
+			delete(ha.tasks, id)
+			if ha.latest[task.Token] == id {
+				delete(ha.latest, task.Token)
+			}
+		}
+	}
+	for key, cached := range ha.cache {
+		if now.Sub(cached.distribution.ComputedAt) >= HolderCacheTTL {
+			delete(ha.cache, key)
+		}
+	}
+}
+
+// update applies a change to a task under the lock
+func (ha *HolderAnalyzer) update(task *HolderTask, change func(*HolderTask)) {
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+
+	change(task)
+}
+
+// run waits for a free slot, replays the token's transfers, and caches the result
+func (ha *HolderAnalyzer) run(ctx context.Context, token common.Address, task *HolderTask) {
+	var err error
+	select {
+	case ha.slots <- struct{}{}:
+		ha.update(task, func(t *HolderTask) { t.Status = BackfillRunning })
+		err = ha.scan(ctx, token, task)
+		<-ha.slots
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	ha.update(task, func(t *HolderTask) {
+		finished := ha.now()
+		t.FinishedAt = &finished
+		t.Status = BackfillDone
+		if err != nil {
+			t.Status = BackfillFailed
+			t.Error = err.Error()
+		}
+	})
+	if err != nil {
+		ha.logger.Printf("Holder scan %s for %s failed: %v", task.ID, task.Token, err)
+	}
+}
+
+// scan replays the Transfer logs of the token from its creation, or as far
+// back as the block budget allows, to the head
+func (ha *HolderAnalyzer) scan(ctx context.Context, token common.Address, task *HolderTask) error {
+	head, err := ha.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get head block: %w", err)
+	}
+
+	from, err := ha.creationBlock(ctx, token, head)
+	if err != nil {
+		return err
+	}
+	truncated := false
+	if head-from+1 > ha.maxBlocks {
+		from = head - ha.maxBlocks + 1
+		truncated = true
+	}
+	ha.update(task, func(t *HolderTask) {
+		t.FromBlock = from
+		t.ToBlock = head
+		t.Truncated = truncated
+	})
+
+	balances := make(map[common.Address]*big.Int)
+	for start := from; start <= head; start += holderScanChunk {
+		end := min(start+holderScanChunk-1, head)
+		logs, err := ha.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(start),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: []common.Address{token},
+			Topics:    [][]common.Hash{{transferTopic}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to filter transfers from block %d: %w", start, err)
+		}
+
+		transfers := 0
+		for _, log := range logs {
+			_, event, err := ha.decoder.Decode(log)
+			if err != nil {
+				// ERC-721 transfers share the signature and carry no balance
+				continue
+			}
+			applyTransfer(balances, event.(ERC20Transfer))
+			transfers++
+		}
+		ha.update(task, func(t *HolderTask) {
+			t.BlocksScanned += end - start + 1
+			t.Transfers += transfers
+		})
+	}
+
+	distribution, holders := computeHolderDistribution(token, balances, ha.labels)
+	distribution.Block = head
+	distribution.Truncated = truncated
+	distribution.ComputedAt = ha.now()
+
+	ha.mu.Lock()
+	ha.cache[strings.ToLower(token.Hex())] = &cachedDistribution{distribution: distribution, holders: holders}
+	ha.mu.Unlock()
+	return nil
+}
+
+// creationBlock finds the first block at which the token has code by binary
+// search. Nodes that don't keep old state can't answer, so the search falls
+// back to block 0 and the block budget decides where the replay starts.
+func (ha *HolderAnalyzer) creationBlock(ctx context.Context, token common.Address, head uint64) (uint64, error) {
+	code, err := ha.client.CodeAt(ctx, token, new(big.Int).SetUint64(head))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get token code: %w", err)
+	}
+	if len(code) == 0 {
+		return 0, ErrNotContract
+	}
+	return firstCodeBlock(ctx, ha.client, token, head)
+}
+
+// firstCodeBlock binary searches for the first block at which a contract
+// with code at head has code. It returns 0 when the node can't answer for
+// old blocks.
+func firstCodeBlock(ctx context.Context, client ChainClient, contract common.Address, head uint64) (uint64, error) {
+	low, high := uint64(0), head
+	for low < high {
+		mid := low + (high-low)/2
+		code, err := client.CodeAt(ctx, contract, new(big.Int).SetUint64(mid))
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, nil
+		}
+		if len(code) > 0 {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	return low, nil
+}
+
+// applyTransfer moves the value of a transfer between balances. The zero
+// address mints and burns, so it holds no balance.
+func applyTransfer(balances map[common.Address]*big.Int, transfer ERC20Transfer) {
+	if transfer.Value == nil || transfer.Value.Sign() == 0 {
+		return
+	}
+	if transfer.From != (common.Address{}) {
+		balance(balances, transfer.From).Sub(balances[transfer.From], transfer.Value)
+	}
+	if transfer.To != (common.Address{}) {
+		balance(balances, transfer.To).Add(balances[transfer.To], transfer.Value)
+	}
+}
+
+// balance returns the balance of an address, adding it at zero if missing
+func balance(balances map[common.Address]*big.Int, address common.Address) *big.Int {
+	if _, ok := balances[address]; !ok {
+		balances[address] = new(big.Int)
+	}
+	return balances[address]
+}
+
+// exclusionLabel names why an address is left out of concentration stats
+func exclusionLabel(token, address common.Address, labels map[string]string) (string, bool) {
+	key := strings.ToLower(address.Hex())
+	label := labels[key]
+	switch {
+	case address == token:
+		if label == "" {
+			label = "Token contract"
+		}
+		return label, true
+	case burnAddresses[key]:
+		if label == "" {
+			label = "Burn"
+		}
+		return label, true
+	case strings.Contains(strings.ToLower(label), "burn"):
+		return label, true
+	}
+	return "", false
+}
+
+// computeHolderDistribution summarizes the balances of a token's holders. It
+// returns the distribution without top holders and every counted holder,
+// largest first.
+func computeHolderDistribution(token common.Address, balances map[common.Address]*big.Int, labels map[string]string) (HolderDistribution, []TokenHolder) {
+	type holding struct {
+		address common.Address
+		balance *big.Int
+		label   string
+	}
+
+	var counted, excluded []holding
+	supply, circulating := new(big.Int), new(big.Int)
+	for address, amount := range balances {
+		// Without the token's full history some balances can come out negative
+		if amount.Sign() <= 0 {
+			continue
+		}
+		supply.Add(supply, amount)
+		if label, ok := exclusionLabel(token, address, labels); ok {
+			excluded = append(excluded, holding{address, amount, label})
+			continue
+		}
+		circulating.Add(circulating, amount)
+		counted = append(counted, holding{address, amount, labels[strings.ToLower(address.Hex())]})
+	}
+
+	for _, holdings := range [][]holding{counted, excluded} {
+		sort.Slice(holdings, func(i, j int) bool {
+			if c := holdings[i].balance.Cmp(holdings[j].balance); c != 0 {
+				return c > 0
+			}
+			return holdings[i].address.Hex() < holdings[j].address.Hex()
+		})
+	}
+
+	share := func(amount, of *big.Int) float64 {
+		if of.Sign() == 0 {
+			return 0
+		}
+		ratio, _ := new(big.Rat).SetFrac(amount, of).Float64()
+		return ratio
+	}
+	toHolder := func(h holding) TokenHolder {
+		return TokenHolder{
+			Address: strings.ToLower(h.address.Hex()),
+			Label:   h.label,
+			Balance: h.balance.String(),
+			Share:   share(h.balance, supply) * 100,
+		}
+	}
+
+	distribution := HolderDistribution{
+		Token:             strings.ToLower(token.Hex()),
+		Holders:           len(counted),
+		Supply:            supply.String(),
+		CirculatingSupply: circulating.String(),
+		Excluded:          make([]TokenHolder, 0, len(excluded)),
+	}
+	for _, h := range excluded {
+		distribution.Excluded = append(distribution.Excluded, toHolder(h))
+	}
+
+	holders := make([]TokenHolder, 0, len(counted))
+	amounts := make([]float64, len(counted))
+	for i, h := range counted {
+		holders = append(holders, toHolder(h))
+		amounts[i], _ = new(big.Float).SetInt(h.balance).Float64()
+
+		switch circulatingShare := share(h.balance, circulating); {
+		case circulatingShare < HolderDustShare:
+			distribution.Buckets.Dust++
+		case circulatingShare >= HolderWhaleShare:
+			distribution.Buckets.Whale++
+		default:
+			distribution.Buckets.Retail++
+		}
+	}
+	distribution.Gini = Gini(amounts)
+	return distribution, holders
+}
+
+// Gini returns the Gini coefficient of non-negative amounts: 0 when all are
+// equal, approaching 1 as one amount holds everything
+func Gini(amounts []float64) float64 {
+	if len(amounts) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), amounts...)
+	sort.Float64s(sorted)
+	var total, weighted float64
+	for i, amount := range sorted {
+		total += amount
+		weighted += float64(i+1) * amount
+	}
+	if total == 0 {
+		return 0
+	}
+	n := float64(len(sorted))
+	return 2*weighted/(n*total) - (n+1)/n
+}
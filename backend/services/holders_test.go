@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// transferChain serves the Transfer logs of a token deployed at a block
+type transferChain struct {
+	ChainClient
+
+	token   common.Address
+	created uint64
+	head    uint64
+	logs    []types.Log
+	// pruned makes state before the head unavailable, as on a non-archive node
+	pruned bool
+}
+
+func (tc *transferChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return tc.head, nil
+}
+
+func (tc *transferChain) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	if tc.pruned && blockNumber.Uint64() < tc.head {
+		return nil, errors.New("missing trie node")
+	}
+	if account != tc.token || blockNumber.Uint64() < tc.created {
+		return nil, nil
+	}
+	return []byte{0x60, 0x80}, nil
+}
+
+func (tc *transferChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	for _, log := range tc.logs {
+		if log.BlockNumber >= query.FromBlock.Uint64() && log.BlockNumber <= query.ToBlock.Uint64() {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+// transfer appends a Transfer log at a block
+func (tc *transferChain) transfer(block uint64, from, to common.Address, value int64) {
+	tc.logs = append(tc.logs, types.Log{
+		Address:     tc.token,
+		BlockNumber: block,
+		Topics:      []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:        common.LeftPadBytes(big.NewInt(value).Bytes(), 32),
+	})
+}
+
+// waitForHolderTask waits for a holder task to finish
+func waitForHolderTask(t *testing.T, analyzer *HolderAnalyzer, id string) HolderTask {
+	var task HolderTask
+	require.Eventually(t, func() bool {
+		task, _ = analyzer.Task(id)
+		return !task.Active()
+	}, 5*time.Second, 10*time.Millisecond)
+	return task
+}
+
+func TestGini(t *testing.T) {
+	assert.Zero(t, Gini(nil))
+	assert.Zero(t, Gini([]float64{0, 0}))
+	assert.InDelta(t, 0, Gini([]float64{5, 5, 5, 5}), 1e-9)
+	assert.InDelta(t, 0.75, Gini([]float64{0, 0, 0, 8}), 1e-9)
+	assert.InDelta(t, 0.25, Gini([]float64{3, 1}), 1e-9)
+}
+
+func TestHolderDistributionReplaysTransfers(t *testing.T) {
+	var (
+		token   = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		alice   = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		bob     = common.HexToAddress("0x0000000000000000000000000000000000000002")
+		carol   = common.HexToAddress("0x0000000000000000000000000000000000000003")
+		dave    = common.HexToAddress("0x0000000000000000000000000000000000000004")
+		erin    = common.HexToAddress("0x0000000000000000000000000000000000000005")
+		frank   = common.HexToAddress("0x0000000000000000000000000000000000000006")
+		burner  = common.HexToAddress("0x00000000000000000000000000000000000000b0")
+		dead    = common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+		minting = common.Address{}
+	)
+
+	chain := &transferChain{token: token, created: 12_345, head: 20_000}
+	chain.transfer(12_345, minting, alice, 1_000_000)
+	chain.transfer(12_400, alice, bob, 200_000)
+	chain.transfer(13_000, alice, carol, 100_000)
+	chain.transfer(13_500, bob, dave, 50_000)
+	chain.transfer(14_000, alice, dead, 100_000)
+	chain.transfer(15_000, alice, token, 50_000)
+	chain.transfer(16_000, alice, erin, 5)
+	chain.transfer(17_000, carol, minting, 10_000)
+	chain.transfer(18_000, alice, burner, 20_000)
+	chain.transfer(19_000, alice, frank, 1_000)
+	// An ERC-721 transfer of token ID 7 indexes a third topic and is skipped
+	chain.logs = append(chain.logs, types.Log{
+		Address:     token,
+		BlockNumber: 19_500,
+		Topics:      []common.Hash{transferTopic, common.BytesToHash(alice.Bytes()), common.BytesToHash(bob.Bytes()), common.BigToHash(big.NewInt(7))},
+	})
+
+	labels := map[string]string{"0x00000000000000000000000000000000000000b0": "Team Burn Wallet"}
+	analyzer := NewHolderAnalyzer(chain, labels, 0, 1)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	analyzer.now = func() time.Time { return now }
+
+	distribution, task, err := analyzer.Distribution(token, 3)
+	require.NoError(t, err)
+	assert.Nil(t, distribution)
+	require.NotNil(t, task)
+	assert.Equal(t, BackfillPending, task.Status)
+
+	// A second cold request while scanning joins the same task
+	_, again, err := analyzer.Distribution(token, 3)
+	require.NoError(t, err)
+	assert.Equal(t, task.ID, again.ID)
+
+	finished := waitForHolderTask(t, analyzer, task.ID)
+	assert.Equal(t, BackfillDone, finished.Status)
+	assert.Equal(t, uint64(12_345), finished.FromBlock)
+	assert.Equal(t, uint64(20_000), finished.ToBlock)
+	assert.Equal(t, uint64(20_000-12_345+1), finished.BlocksScanned)
+	assert.Equal(t, 10, finished.Transfers)
+	assert.False(t, finished.Truncated)
+
+	distribution, task, err = analyzer.Distribution(token, 3)
+	require.NoError(t, err)
+	assert.Nil(t, task)
+	require.NotNil(t, distribution)
+
+	assert.Equal(t, uint64(20_000), distribution.Block)
+	assert.Equal(t, "990000", distribution.Supply)
+	assert.Equal(t, "820000", distribution.CirculatingSupply)
+	assert.Equal(t, 6, distribution.Holders)
+
+	require.Len(t, distribution.Top, 3)
+	assert.Equal(t, TokenHolder{Address: "0x0000000000000000000000000000000000000001", Balance: "528995", Share: 528995.0 / 990000 * 100}, distribution.Top[0])
+	assert.Equal(t, "150000", distribution.Top[1].Balance)
+	assert.Equal(t, "90000", distribution.Top[2].Balance)
+
+	assert.Equal(t, HolderBuckets{Dust: 1, Retail: 1, Whale: 4}, distribution.Buckets)
+	// Mean absolute difference over twice the mean of 5, 1000, 50000, 90000, 150000, 528995
+	assert.InDelta(t, 0.6365752, distribution.Gini, 1e-6)
+
+	require.Len(t, distribution.Excluded, 3)
+	assert.Equal(t, TokenHolder{Address: "0x000000000000000000000000000000000000dead", Label: "Burn", Balance: "100000", Share: 100000.0 / 990000 * 100}, distribution.Excluded[0])
+	assert.Equal(t, "Token contract", distribution.Excluded[1].Label)
+	assert.Equal(t, "Team Burn Wallet", distribution.Excluded[2].Label)
+
+	// Every holder is cached, so a larger top needs no new scan
+	distribution, _, err = analyzer.Distribution(token, 50)
+	require.NoError(t, err)
+	assert.Len(t, distribution.Top, 6)
+
+	// After the cache expires a new scan starts
+	now = now.Add(HolderCacheTTL)
+	distribution, task, err = analyzer.Distribution(token, 3)
+	require.NoError(t, err)
+	assert.Nil(t, distribution)
+	require.NotNil(t, task)
+	waitForHolderTask(t, analyzer, task.ID)
+}
+
+func TestHolderScanTruncatesWithoutHistory(t *testing.T) {
+	token := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	alice := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	bob := common.HexToAddress("0x0000000000000000000000000000000000000002")
+
+	chain := &transferChain{token: token, created: 100, head: 10_000, pruned: true}
+	chain.transfer(100, common.Address{}, alice, 1_000)
+	chain.transfer(9_500, alice, bob, 400)
+
+	analyzer := NewHolderAnalyzer(chain, nil, 1_000, 1)
+	_, task, err := analyzer.Distribution(token, 10)
+	require.NoError(t, err)
+
+	finished := waitForHolderTask(t, analyzer, task.ID)
+	assert.Equal(t, BackfillDone, finished.Status)
+	assert.True(t, finished.Truncated)
+	assert.Equal(t, uint64(9_001), finished.FromBlock)
+	assert.Equal(t, uint64(1_000), finished.BlocksScanned)
+
+	// Without the mint alice's balance is negative and she is left out
+	distribution, _, err := analyzer.Distribution(token, 10)
+	require.NoError(t, err)
+	assert.True(t, distribution.Truncated)
+	require.Len(t, distribution.Top, 1)
+	assert.Equal(t, "0x0000000000000000000000000000000000000002", distribution.Top[0].Address)
+	assert.InDelta(t, 100, distribution.Top[0].Share, 1e-9)
+	assert.Zero(t, distribution.Gini)
+
+	notContract := NewHolderAnalyzer(chain, nil, 0, 1)
+	_, task, err = notContract.Distribution(alice, 10)
+	require.NoError(t, err)
+	failed := waitForHolderTask(t, notContract, task.ID)
+	assert.Equal(t, BackfillFailed, failed.Status)
+	assert.Equal(t, ErrNotContract.Error(), failed.Error)
+}
@@ -0,0 +1,475 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// httpCacheRefreshTimeout bounds background refreshes, which outlive the
+	// request that started them
+	httpCacheRefreshTimeout = 30 * time.Second
+	// lowQuotaRatio is the share of an upstream's rate limit left at which
+	// requests to it are spaced out until the limit resets
+	lowQuotaRatio = 0.1
+	// maxThrottleDelay caps how long a request waits for upstream quota
+	maxThrottleDelay = 10 * time.Second
+)
+
+// HTTPCachePolicy is how long responses from a host are fresh, and how much
+// longer they may be served stale while a refresh runs
+type HTTPCachePolicy struct {
+	TTL      time.Duration
+	StaleFor time.Duration
+}
+
+// DefaultHTTPCachePolicies caches the market and yield APIs the collector
+// reads. Requests to other hosts pass through uncached.
+func DefaultHTTPCachePolicies() map[string]HTTPCachePolicy {
+	return map[string]HTTPCachePolicy{
+		"api.coingecko.com":     {TTL: time.Minute, StaleFor: 10 * time.Minute},
+		"pro-api.coingecko.com": {TTL: time.Minute, StaleFor: 10 * time.Minute},
+		"api.llama.fi":          {TTL: 5 * time.Minute, StaleFor: time.Hour},
+		"yields.llama.fi":       {TTL: 5 * time.Minute, StaleFor: time.Hour},
+		"coins.llama.fi":        {TTL: time.Minute, StaleFor: 10 * time.Minute},
+	}
+}
+
+// CachedResponse is an upstream response kept by an HTTPCacheStore
+type CachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"stored_at"`
+}
+
+// HTTPCacheStore keeps cached responses by normalized URL. Replicas share a
+// cache, and with it one upstream budget, by using a shared store.
+type HTTPCacheStore interface {
+	Get(ctx context.Context, key string) (*CachedResponse, bool)
+	// Set keeps the response for at least keepFor, after which it may be evicted
+	Set(ctx context.Context, key string, response *CachedResponse, keepFor time.Duration)
+}
+
+// memoryHTTPCacheEntry is a stored response and when it may be evicted
+type memoryHTTPCacheEntry struct {
+	response  *CachedResponse
+	expiresAt time.Time
+}
+
+// MemoryHTTPCacheStore is an HTTPCacheStore local to the process
+type MemoryHTTPCacheStore struct {
+	mu      sync.RWMutex
+	entries map[string]memoryHTTPCacheEntry
+	now     func() time.Time
+}
+
+// NewMemoryHTTPCacheStore creates an empty in-process store
+func NewMemoryHTTPCacheStore() *MemoryHTTPCacheStore {
+	return &MemoryHTTPCacheStore{
+		entries: make(map[string]memoryHTTPCacheEntry),
+		now:     utcNow,
+	}
+}
+
+// Get returns a stored response that hasn't been evicted
+func (s *MemoryHTTPCacheStore) Get(ctx context.Context, key string) (*CachedResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok || !s.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.response, true
+}
+
+// Set stores a response, dropping the ones past their eviction time
+func (s *MemoryHTTPCacheStore) Set(ctx context.Context, key string, response *CachedResponse, keepFor time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for existing, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, existing)
+		}
+	}
+	s.entries[key] = memoryHTTPCacheEntry{response: response, expiresAt: now.Add(keepFor)}
+}
+
+// HTTPCacheStats counts how cacheable requests were answered
+type HTTPCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	StaleHits     uint64 `json:"stale_hits"`
+	Misses        uint64 `json:"misses"`
+	UpstreamCalls uint64 `json:"upstream_calls"`
+	Refreshes     uint64 `json:"refreshes"`
+	// Throttled counts upstream calls delayed because the quota ran low
+	Throttled uint64                   `json:"throttled"`
+	Quotas    map[string]UpstreamQuota `json:"quotas,omitempty"`
+}
+
+// UpstreamQuota is the rate limit state an upstream last reported
+type UpstreamQuota struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset,omitempty"`
+	// RetryAfter is set after a 429 until which no requests are sent
+	RetryAfter time.Time `json:"retry_after,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// httpFlight is an upstream fetch that concurrent requests for the same key
+// wait on instead of fetching themselves
+type httpFlight struct {
+	done     chan struct{}
+	response *CachedResponse
+	err      error
+}
+
+// CachingTransport is an http.RoundTripper caching GET responses of the hosts
+// it has a policy for. Fresh responses are served from the store; stale ones
+// are served while a single background refresh runs; and concurrent misses
+// for the same URL share one upstream call. Upstream rate limit headers are
+// recorded so calls are spaced out before the limit is hit.
+type CachingTransport struct {
+	next     http.RoundTripper
+	store    HTTPCacheStore
+	policies map[string]HTTPCachePolicy
+	logger   *log.Logger
+	now      func() time.Time
+	sleep    func(ctx context.Context, d time.Duration) error
+
+	mu      sync.Mutex
+	flights map[string]*httpFlight
+	quotas  map[string]UpstreamQuota
+
+	hits, staleHits, misses  atomic.Uint64
+	upstreamCalls, refreshes atomic.Uint64
+	throttled                atomic.Uint64
+}
+
+// NewCachingTransport wraps next, or http.DefaultTransport when it is nil,
+// with a cache of the hosts in policies
+func NewCachingTransport(next http.RoundTripper, store HTTPCacheStore, policies map[string]HTTPCachePolicy) *CachingTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	normalized := make(map[string]HTTPCachePolicy, len(policies))
+	for host, policy := range policies {
+		normalized[strings.ToLower(host)] = policy
+	}
+
+	return &CachingTransport{
+		next:     next,
+		store:    store,
+		policies: normalized,
+		logger:   log.New(log.Writer(), "[HTTPCache] ", log.LstdFlags),
+		now:      utcNow,
+		sleep:    sleepContext,
+		flights:  make(map[string]*httpFlight),
+		quotas:   make(map[string]UpstreamQuota),
+	}
+}
+
+// RoundTrip answers cacheable requests from the cache and sends the rest
+// upstream. Cached answers carry an X-Cache header of HIT or STALE.
+func (t *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy, ok := t.policies[strings.ToLower(req.URL.Hostname())]
+	if !ok || req.Method != http.MethodGet || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	key := NormalizeCacheURL(req.URL)
+	if cached, ok := t.store.Get(req.Context(), key); ok {
+		age := t.now().Sub(cached.StoredAt)
+		if age < policy.TTL {
+			t.hits.Add(1)
+			return cached.toResponse(req, "HIT"), nil
+		}
+		if age < policy.TTL+policy.StaleFor {
+			t.staleHits.Add(1)
+			t.refresh(req, key, policy)
+			return cached.toResponse(req, "STALE"), nil
+		}
+	}
+
+	t.misses.Add(1)
+	response, err := t.fetch(req, key, policy)
+	if err != nil {
+		return nil, err
+	}
+	return response.toResponse(req, "MISS"), nil
+}
+
+// refresh fetches a stale entry again in the background unless a fetch of
+// it is already running
+func (t *CachingTransport) refresh(req *http.Request, key string, policy HTTPCachePolicy) {
+	flight, leader := t.join(key)
+	if !leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), httpCacheRefreshTimeout)
+	background := req.Clone(ctx)
+	t.refreshes.Add(1)
+	go func() {
+		defer cancel()
+		response, err := t.upstream(background, key, policy)
+		t.complete(key, flight, response, err)
+		if err != nil {
+			t.logger.Printf("Failed to refresh %s: %v", key, err)
+		}
+	}()
+}
+
+// fetch calls upstream for the key, waiting on the call already running for
+// it if there is one
+func (t *CachingTransport) fetch(req *http.Request, key string, policy HTTPCachePolicy) (*CachedResponse, error) {
+	flight, leader := t.join(key)
+	if !leader {
+		select {
+		case <-flight.done:
+			return flight.response, flight.err
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	response, err := t.upstream(req, key, policy)
+	t.complete(key, flight, response, err)
+	return response, err
+}
+
+// join returns the running fetch of the key, or registers a new one that the
+// caller leads and must complete
+func (t *CachingTransport) join(key string) (flight *httpFlight, leader bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if flight, ok := t.flights[key]; ok {
+		return flight, false
+	}
+	flight = &httpFlight{done: make(chan struct{})}
+	t.flights[key] = flight
+	return flight, true
+}
+
+// complete hands the result of a fetch to the requests waiting on it. The
+// response is stored before, so later requests find it in the cache.
+func (t *CachingTransport) complete(key string, flight *httpFlight, response *CachedResponse, err error) {
+	flight.response, flight.err = response, err
+
+	t.mu.Lock()
+	delete(t.flights, key)
+	t.mu.Unlock()
+	close(flight.done)
+}
+
+// upstream sends the request once the host's quota allows it and stores a
+// successful response
+func (t *CachingTransport) upstream(req *http.Request, key string, policy HTTPCachePolicy) (*CachedResponse, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if delay := t.throttleDelay(host); delay > 0 {
+		t.throttled.Add(1)
+		if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+
+	t.upstreamCalls.Add(1)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", host, err)
+	}
+	t.recordQuota(host, resp)
+
+	cached := &CachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		StoredAt:   t.now(),
+	}
+	if resp.StatusCode == http.StatusOK {
+		t.store.Set(req.Context(), key, cached, policy.TTL+policy.StaleFor)
+	}
+	return cached, nil
+}
+
+// recordQuota keeps the rate limit headers of a response
+func (t *CachingTransport) recordQuota(host string, resp *http.Response) {
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	quota := t.quotas[host]
+	updated := false
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		quota.Limit, updated = limit, true
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		quota.Remaining, updated = remaining, true
+	}
+	if reset, ok := parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset"), now); ok {
+		quota.Reset, updated = reset, true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := time.Minute
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		quota.RetryAfter, updated = now.Add(retryAfter), true
+	}
+	if updated {
+		quota.UpdatedAt = now
+		t.quotas[host] = quota
+	}
+}
+
+// parseRateLimitReset reads a reset header given as a unix time or as seconds
+// from now
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false
+	}
+	if seconds > 1_000_000_000 {
+		return time.Unix(seconds, 0).UTC(), true
+	}
+	return now.Add(time.Duration(seconds) * time.Second), true
+}
+
+// throttleDelay is how long to wait before calling the host: until a 429's
+// Retry-After passes, or, with less than lowQuotaRatio of the limit left, the
+// time to the reset spread over the requests remaining
+func (t *CachingTransport) throttleDelay(host string) time.Duration {
+	now := t.now()
+
+	t.mu.Lock()
+	quota, ok := t.quotas[host]
+	t.mu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	var delay time.Duration
+	switch {
+	case now.Before(quota.RetryAfter):
+		delay = quota.RetryAfter.Sub(now)
+	case quota.Limit > 0 && float64(quota.Remaining) <= float64(quota.Limit)*lowQuotaRatio && now.Before(quota.Reset):
+		delay = quota.Reset.Sub(now) / time.Duration(quota.Remaining+1)
+	}
+	return min(delay, maxThrottleDelay)
+}
+
+// Stats returns the cache counters and the last quota of every upstream
+func (t *CachingTransport) Stats() HTTPCacheStats {
+	t.mu.Lock()
+	quotas := make(map[string]UpstreamQuota, len(t.quotas))
+	for host, quota := range t.quotas {
+		quotas[host] = quota
+	}
+	t.mu.Unlock()
+
+	return HTTPCacheStats{
+		Hits:          t.hits.Load(),
+		StaleHits:     t.staleHits.Load(),
+		Misses:        t.misses.Load(),
+		UpstreamCalls: t.upstreamCalls.Load(),
+		Refreshes:     t.refreshes.Load(),
+		Throttled:     t.throttled.Load(),
+		Quotas:        quotas,
+	}
+}
+
+// WritePrometheus exposes the cache counters and upstream quotas
+func (t *CachingTransport) WritePrometheus(pw *PromWriter) {
+	stats := t.Stats()
+	pw.Counter("kaia_http_cache_requests_total", "Cacheable outbound requests by result.", float64(stats.Hits), map[string]string{"result": "hit"})
+	pw.Counter("kaia_http_cache_requests_total", "Cacheable outbound requests by result.", float64(stats.StaleHits), map[string]string{"result": "stale"})
+	pw.Counter("kaia_http_cache_requests_total", "Cacheable outbound requests by result.", float64(stats.Misses), map[string]string{"result": "miss"})
+	pw.Counter("kaia_http_upstream_calls_total", "Outbound requests sent upstream by the cache.", float64(stats.UpstreamCalls), nil)
+	pw.Counter("kaia_http_upstream_throttled_total", "Upstream calls delayed because the quota ran low.", float64(stats.Throttled), nil)
+
+	hosts := make([]string, 0, len(stats.Quotas))
+	for host := range stats.Quotas {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		pw.Gauge("kaia_http_upstream_quota_remaining", "Requests left in the upstream rate limit window.", float64(stats.Quotas[host].Remaining), map[string]string{"host": host})
+	}
+}
+
+// toResponse builds a response to the request from the cached one
+func (c *CachedResponse) toResponse(req *http.Request, cacheStatus string) *http.Response {
+	header := c.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("X-Cache", cacheStatus)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.StatusCode, http.StatusText(c.StatusCode)),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// NormalizeCacheURL returns the cache key of a URL: scheme and host
+// lowercased, default ports and the fragment dropped, and the query sorted
+func NormalizeCacheURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	key := scheme + "://" + host + path
+	if query := u.Query().Encode(); query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
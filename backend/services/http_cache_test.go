@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingUpstream is a server counting its calls that answers with the
+// current body once its gate is open
+type countingUpstream struct {
+	server *httptest.Server
+	calls  atomic.Int64
+
+	mu     sync.Mutex
+	body   string
+	gate   chan struct{}
+	header http.Header
+	status int
+}
+
+func newCountingUpstream(t *testing.T, body string) *countingUpstream {
+	u := &countingUpstream{body: body, gate: make(chan struct{}), header: make(http.Header), status: http.StatusOK}
+	close(u.gate)
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.calls.Add(1)
+		u.mu.Lock()
+		gate, body, status := u.gate, u.body, u.status
+		for key, values := range u.header {
+			w.Header()[key] = values
+		}
+		u.mu.Unlock()
+
+		<-gate
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+// hold makes requests wait until the returned function is called
+func (u *countingUpstream) hold() func() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.gate = make(chan struct{})
+	return func() { close(u.gate) }
+}
+
+func (u *countingUpstream) set(update func(u *countingUpstream)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	update(u)
+}
+
+// testClock is a settable clock shared by the transport and its store
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCachingTransport(upstream *countingUpstream, policy HTTPCachePolicy) (*CachingTransport, *testClock) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryHTTPCacheStore()
+	store.now = clock.Now
+
+	host, _ := url.Parse(upstream.server.URL)
+	transport := NewCachingTransport(nil, store, map[string]HTTPCachePolicy{host.Hostname(): policy})
+	transport.now = clock.Now
+	return transport, clock
+}
+
+// get fetches a URL through the transport, returning the body and X-Cache
+func get(t *testing.T, client *http.Client, target string) (string, string) {
+	resp, err := client.Get(target)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body), resp.Header.Get("X-Cache")
+}
+
+func TestCachingTransportCoalescesAndServesStale(t *testing.T) {
+	upstream := newCountingUpstream(t, "v1")
+	transport, clock := newTestCachingTransport(upstream, HTTPCachePolicy{TTL: time.Minute, StaleFor: time.Hour})
+	client := &http.Client{Transport: transport}
+	target := upstream.server.URL + "/simple/price?ids=kaia&vs_currencies=usd"
+
+	// 50 concurrent misses share one upstream call
+	release := upstream.hold()
+	bodies := make(chan string, 50)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := get(t, client, target)
+			bodies <- body
+		}()
+	}
+	require.Eventually(t, func() bool { return transport.Stats().Misses == 50 }, 5*time.Second, time.Millisecond)
+	release()
+	wg.Wait()
+	close(bodies)
+
+	assert.Equal(t, int64(1), upstream.calls.Load())
+	for body := range bodies {
+		assert.Equal(t, "v1", body)
+	}
+
+	body, cache := get(t, client, target)
+	assert.Equal(t, "v1", body)
+	assert.Equal(t, "HIT", cache)
+	assert.Equal(t, int64(1), upstream.calls.Load())
+
+	// After the TTL the stale body is served at once while one refresh runs
+	clock.Advance(time.Minute + time.Second)
+	upstream.set(func(u *countingUpstream) { u.body = "v2" })
+	release = upstream.hold()
+	for i := 0; i < 20; i++ {
+		body, cache := get(t, client, target)
+		assert.Equal(t, "v1", body)
+		assert.Equal(t, "STALE", cache)
+	}
+	require.Eventually(t, func() bool { return upstream.calls.Load() == 2 }, 5*time.Second, time.Millisecond)
+	release()
+
+	require.Eventually(t, func() bool {
+		body, _ := get(t, client, target)
+		return body == "v2"
+	}, 5*time.Second, time.Millisecond)
+	body, cache = get(t, client, target)
+	assert.Equal(t, "v2", body)
+	assert.Equal(t, "HIT", cache)
+	assert.Equal(t, int64(2), upstream.calls.Load())
+
+	stats := transport.Stats()
+	assert.Equal(t, uint64(1), stats.Refreshes)
+	assert.GreaterOrEqual(t, stats.StaleHits, uint64(20))
+
+	// Past the stale window the entry is fetched again in the foreground
+	clock.Advance(2 * time.Hour)
+	body, cache = get(t, client, target)
+	assert.Equal(t, "v2", body)
+	assert.Equal(t, "MISS", cache)
+	assert.Equal(t, int64(3), upstream.calls.Load())
+
+	// Other methods pass through uncached
+	resp, err := client.Post(target, "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int64(4), upstream.calls.Load())
+}
+
+func TestCachingTransportSlowsDownNearQuota(t *testing.T) {
+	upstream := newCountingUpstream(t, "ok")
+	upstream.set(func(u *countingUpstream) {
+		u.header.Set("X-RateLimit-Limit", "100")
+		u.header.Set("X-RateLimit-Remaining", "5")
+		u.header.Set("X-RateLimit-Reset", "30")
+	})
+	transport, clock := newTestCachingTransport(upstream, HTTPCachePolicy{TTL: time.Second, StaleFor: time.Second})
+	var delays []time.Duration
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	client := &http.Client{Transport: transport}
+
+	get(t, client, upstream.server.URL+"/yields")
+	assert.Empty(t, delays, "no quota was known before the first call")
+	quota := transport.Stats().Quotas["127.0.0.1"]
+	assert.Equal(t, 5, quota.Remaining)
+	assert.Equal(t, clock.Now().Add(30*time.Second), quota.Reset)
+
+	// 5 of 100 left: the 28s to the reset are spread over the remaining calls
+	clock.Advance(2 * time.Second)
+	get(t, client, upstream.server.URL+"/yields")
+	require.Len(t, delays, 1)
+	assert.Equal(t, 28*time.Second/6, delays[0])
+
+	// A 429 holds calls for its Retry-After
+	upstream.set(func(u *countingUpstream) {
+		u.status = http.StatusTooManyRequests
+		u.header = http.Header{"Retry-After": {"7"}}
+	})
+	clock.Advance(2 * time.Second)
+	get(t, client, upstream.server.URL+"/yields")
+	clock.Advance(2 * time.Second)
+	get(t, client, upstream.server.URL+"/yields")
+	require.Len(t, delays, 3)
+	assert.Equal(t, 5*time.Second, delays[2])
+	assert.Equal(t, uint64(3), transport.Stats().Throttled)
+}
+
+func TestNormalizeCacheURL(t *testing.T) {
+	a, err := url.Parse("HTTPS://API.CoinGecko.com:443/api/v3/simple/price?vs_currencies=usd&ids=kaia#top")
+	require.NoError(t, err)
+	b, err := url.Parse("https://api.coingecko.com/api/v3/simple/price?ids=kaia&vs_currencies=usd")
+	require.NoError(t, err)
+
+	assert.Equal(t, NormalizeCacheURL(b), NormalizeCacheURL(a))
+	assert.Equal(t, "https://api.coingecko.com/api/v3/simple/price?ids=kaia&vs_currencies=usd", NormalizeCacheURL(a))
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HTTPRecordingRetention is how long recorded exchanges are kept
+	HTTPRecordingRetention = 48 * time.Hour
+	// MaxRecordedBody is how much of a request or response body is recorded
+	MaxRecordedBody = 64 << 10
+
+	// maxHTTPRecordings bounds the recordings kept; the oldest are dropped
+	// first when a busy period fills it
+	maxHTTPRecordings = 2000
+	// redactedValue replaces the values of credential headers and parameters
+	redactedValue = "REDACTED"
+)
+
+// recordingJobKey is the context key of the job outbound requests belong to
+type recordingJobKey struct{}
+
+// recordingJobRuns numbers job runs so their recordings can be told apart
+var recordingJobRuns atomic.Uint64
+
+// WithRecordingJob tags outbound requests made with the context as a run of
+// the named job. A context already tagged keeps its job, so requests are
+// credited to the outermost job that triggered them.
+func WithRecordingJob(ctx context.Context, job string) context.Context {
+	if _, ok := ctx.Value(recordingJobKey{}).(string); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, recordingJobKey{}, fmt.Sprintf("%s#%d", job, recordingJobRuns.Add(1)))
+}
+
+// recordingJob returns the job run the context is tagged with, if any
+func recordingJob(ctx context.Context) string {
+	job, _ := ctx.Value(recordingJobKey{}).(string)
+	return job
+}
+
+// HTTPRecording is one outbound request and its response, with credentials
+// redacted and bodies truncated to MaxRecordedBody
+type HTTPRecording struct {
+	ID string `json:"id"`
+	// Job is the job run that made the request, as name#run
+	Job                   string      `json:"job,omitempty"`
+	Method                string      `json:"method"`
+	Host                  string      `json:"host"`
+	URL                   string      `json:"url"`
+	RequestHeader         http.Header `json:"request_header"`
+	RequestBody           string      `json:"request_body,omitempty"`
+	RequestBodyTruncated  bool        `json:"request_body_truncated,omitempty"`
+	StatusCode            int         `json:"status_code,omitempty"`
+	ResponseHeader        http.Header `json:"response_header,omitempty"`
+	ResponseBody          string      `json:"response_body,omitempty"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated,omitempty"`
+	// Error is set when no response was received
+	Error      string  `json:"error,omitempty"`
+	StartedAt  APITime `json:"started_at"`
+	DurationMs int64   `json:"duration_ms"`
+}
+
+// RecordingTransport is an http.RoundTripper that can record the exchanges
+// it passes on, to diagnose odd upstream data. Recording is off until
+// enabled; while off, requests pass through untouched and no body is read.
+type RecordingTransport struct {
+	next    http.RoundTripper
+	enabled atomic.Bool
+	ids     atomic.Uint64
+
+	mu         sync.Mutex
+	recordings []HTTPRecording
+
+	now func() time.Time
+}
+
+// NewRecordingTransport wraps next, or http.DefaultTransport when it is nil,
+// with recording disabled
+func NewRecordingTransport(next http.RoundTripper) *RecordingTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RecordingTransport{next: next, now: utcNow}
+}
+
+// SetEnabled turns recording on or off. Recordings already made are kept
+// until they expire.
+func (t *RecordingTransport) SetEnabled(enabled bool) {
+	t.enabled.Store(enabled)
+}
+
+// Enabled reports whether exchanges are being recorded
+func (t *RecordingTransport) Enabled() bool {
+	return t.enabled.Load()
+}
+
+// RoundTrip sends the request, recording it and its response when enabled.
+// The response is recorded once its body has been read to the end or closed.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.enabled.Load() {
+		return t.next.RoundTrip(req)
+	}
+
+	started := t.now()
+	recording := HTTPRecording{
+		ID:            fmt.Sprintf("rec_%d", t.ids.Add(1)),
+		Job:           recordingJob(req.Context()),
+		Method:        req.Method,
+		Host:          strings.ToLower(req.URL.Hostname()),
+		URL:           redactURL(req.URL),
+		RequestHeader: redactHeader(req.Header),
+		StartedAt:     NewAPITime(started),
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		recording.RequestBody, recording.RequestBodyTruncated = truncateBody(body)
+
+		// The original request is the caller's, so the replayed body goes
+		// on a copy
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		recording.Error = err.Error()
+		recording.DurationMs = t.now().Sub(started).Milliseconds()
+		t.add(recording)
+		return nil, err
+	}
+
+	recording.StatusCode = resp.StatusCode
+	recording.ResponseHeader = redactHeader(resp.Header)
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		finish: func(body []byte, truncated bool) {
+			recording.ResponseBody, recording.ResponseBodyTruncated = string(body), truncated
+			recording.DurationMs = t.now().Sub(started).Milliseconds()
+			t.add(recording)
+		},
+	}
+	return resp, nil
+}
+
+// Recordings returns the unexpired recordings of exchanges with the host,
+// or every host when empty, started at or after since, newest first
+func (t *RecordingTransport) Recordings(host string, since time.Time) []HTTPRecording {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(t.now())
+	host = strings.ToLower(host)
+	recordings := make([]HTTPRecording, 0, len(t.recordings))
+	for _, recording := range t.recordings {
+		if host != "" && recording.Host != host {
+			continue
+		}
+		if recording.StartedAt.Time.Before(since) {
+			continue
+		}
+		recordings = append(recordings, recording)
+	}
+	sort.SliceStable(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.Time.After(recordings[j].StartedAt.Time)
+	})
+	return recordings
+}
+
+func (t *RecordingTransport) add(recording HTTPRecording) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recordings = append(t.recordings, recording)
+	t.prune(t.now())
+}
+
+// prune drops recordings past HTTPRecordingRetention and, over
+// maxHTTPRecordings, the oldest ones
+func (t *RecordingTransport) prune(now time.Time) {
+	cutoff := now.Add(-HTTPRecordingRetention)
+	kept := t.recordings[:0]
+	for _, recording := range t.recordings {
+		if !recording.StartedAt.Time.Before(cutoff) {
+			kept = append(kept, recording)
+		}
+	}
+	if over := len(kept) - maxHTTPRecordings; over > 0 {
+		kept = kept[over:]
+	}
+	t.recordings = kept
+}
+
+// recordingBody captures the first MaxRecordedBody bytes of a response body
+// as the caller reads it, and hands them over at the end of the body or when
+// it is closed, whichever comes first
+type recordingBody struct {
+	io.ReadCloser
+	captured  bytes.Buffer
+	truncated bool
+	once      sync.Once
+	finish    func(body []byte, truncated bool)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := MaxRecordedBody - b.captured.Len(); n > room {
+		b.captured.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.captured.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+func (b *recordingBody) done() {
+	b.once.Do(func() { b.finish(b.captured.Bytes(), b.truncated) })
+}
+
+// truncateBody returns the recorded part of a body and whether it was cut
+func truncateBody(body []byte) (string, bool) {
+	if len(body) > MaxRecordedBody {
+		return string(body[:MaxRecordedBody]), true
+	}
+	return string(body), false
+}
+
+// credentialName reports whether a header or query parameter name carries a
+// credential, such as Authorization, x-cg-pro-api-key, or apikey
+func credentialName(name string) bool {
+	name = strings.ToLower(name)
+	for _, marker := range []string{"auth", "key", "token", "secret", "signature", "password", "cookie"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeader copies a header with the values of credentials redacted
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	if redacted == nil {
+		return http.Header{}
+	}
+	for name := range redacted {
+		if credentialName(name) {
+			redacted[name] = []string{redactedValue}
+		}
+	}
+	return redacted
+}
+
+// redactURL formats a URL with its user info and the values of credential
+// query parameters redacted
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User(redactedValue)
+	}
+	query := redacted.Query()
+	changed := false
+	for name := range query {
+		if credentialName(name) {
+			query[name] = []string{redactedValue}
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
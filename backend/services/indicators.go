@@ -0,0 +1,543 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrInsufficientData is returned by indicators given fewer values than
+// their period needs
+var ErrInsufficientData = errors.New("insufficient data")
+
+// ErrInvalidPeriod is returned for indicator periods below one
+var ErrInvalidPeriod = errors.New("indicator period must be at least 1")
+
+// ErrUndefinedCorrelation is returned for the correlation of a series that
+// doesn't vary
+var ErrUndefinedCorrelation = errors.New("correlation is undefined for a constant series")
+
+// ErrNonFiniteValue is returned by indicators given NaN or infinite values,
+// which would otherwise carry through to every result
+var ErrNonFiniteValue = errors.New("value is not a finite number")
+
+func insufficientData(need, have int) error {
+	return fmt.Errorf("%w: need %d values, have %d", ErrInsufficientData, need, have)
+}
+
+// isFinite reports whether the value is neither NaN nor infinite
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// checkFinite returns an error wrapping ErrNonFiniteValue for the first value
+// that is NaN or infinite
+func checkFinite(values []float64) error {
+	for i, value := range values {
+		if !isFinite(value) {
+			return fmt.Errorf("%w: value %d is %v", ErrNonFiniteValue, i, value)
+		}
+	}
+	return nil
+}
+
+// Candle is the open, high, low and close of a price over one interval
+type Candle struct {
+	Start time.Time `json:"start"`
+	Open  float64   `json:"open"`
+	High  float64   `json:"high"`
+	Low   float64   `json:"low"`
+	Close float64   `json:"close"`
+}
+
+// BuildCandles groups points, oldest first, into candles of the interval.
+// Intervals without points are left out rather than filled.
+func BuildCandles(points []SeriesPoint, interval time.Duration) []Candle {
+	var candles []Candle
+	for _, point := range points {
+		start := point.Timestamp.UTC().Truncate(interval)
+		if n := len(candles); n > 0 && candles[n-1].Start.Equal(start) {
+			candle := &candles[n-1]
+			candle.High = math.Max(candle.High, point.Value)
+			candle.Low = math.Min(candle.Low, point.Value)
+			candle.Close = point.Value
+			continue
+		}
+		candles = append(candles, Candle{Start: start, Open: point.Value, High: point.Value, Low: point.Value, Close: point.Value})
+	}
+	return candles
+}
+
+// SMA returns the simple moving average of the last period values. The
+// period is at least 1 and the values must be finite.
+func SMA(values []float64, period int) (float64, error) {
+	if period <= 0 {
+		return 0, ErrInvalidPeriod
+	}
+	if len(values) < period {
+		return 0, insufficientData(period, len(values))
+	}
+	if err := checkFinite(values[len(values)-period:]); err != nil {
+		return 0, err
+	}
+	sum := 0.0
+	for _, value := range values[len(values)-period:] {
+		sum += value
+	}
+	return sum / float64(period), nil
+}
+
+// EMA returns the exponential moving average of the values with smoothing
+// 2/(period+1), seeded with the simple average of the first period values.
+// The period is at least 1 and the values must be finite.
+func EMA(values []float64, period int) (float64, error) {
+	ema := NewStreamingEMA(period)
+	if ema == nil {
+		return 0, ErrInvalidPeriod
+	}
+	if err := checkFinite(values); err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		ema.Push(value)
+	}
+	return ema.Value()
+}
+
+// RSI returns the relative strength index of the last period changes, from 0
+// to 100. It needs period+1 values. Gains and losses are averaged simply over
+// the period, which is at least 1; the values must be finite.
+func RSI(values []float64, period int) (float64, error) {
+	if period <= 0 {
+		return 0, ErrInvalidPeriod
+	}
+	if len(values) <= period {
+		return 0, insufficientData(period+1, len(values))
+	}
+	window := values[len(values)-period-1:]
+	if err := checkFinite(window); err != nil {
+		return 0, err
+	}
+	var gains, losses float64
+	for i := 1; i < len(window); i++ {
+		change := window[i] - window[i-1]
+		if change > 0 {
+			gains += change
+		} else {
+			losses -= change
+		}
+	}
+	return relativeStrength(gains, losses), nil
+}
+
+func relativeStrength(gains, losses float64) float64 {
+	if losses == 0 {
+		if gains == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+gains/losses)
+}
+
+// MeanStdDev returns the mean and population standard deviation of the values,
+// the volatility of a series of returns. The values are summed relative to
+// the first, so values that don't vary have a deviation of exactly zero.
+// A single value has a deviation of zero; NaN and infinite values are errors.
+func MeanStdDev(values []float64) (float64, float64, error) {
+	if len(values) == 0 {
+		return 0, 0, insufficientData(1, 0)
+	}
+	if err := checkFinite(values); err != nil {
+		return 0, 0, err
+	}
+
+	shift := values[0]
+	var sum float64
+	for _, value := range values {
+		sum += value - shift
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, value := range values {
+		diff := value - shift - mean
+		variance += diff * diff
+	}
+	variance /= float64(len(values))
+
+	return shift + mean, math.Sqrt(variance), nil
+}
+
+// Correlation returns the Pearson correlation of two series of equal length
+func Correlation(x, y []float64) (float64, error) {
+	if len(x) != len(y) {
+		return 0, fmt.Errorf("series have different lengths, %d and %d", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return 0, insufficientData(2, len(x))
+	}
+	meanX, stdX, err := MeanStdDev(x)
+	if err != nil {
+		return 0, err
+	}
+	meanY, stdY, err := MeanStdDev(y)
+	if err != nil {
+		return 0, err
+	}
+	if stdX == 0 || stdY == 0 {
+		return 0, ErrUndefinedCorrelation
+	}
+	var covariance float64
+	for i := range x {
+		covariance += (x[i] - meanX) * (y[i] - meanY)
+	}
+	covariance /= float64(len(x))
+	return covariance / (stdX * stdY), nil
+}
+
+// RollingWindow keeps the last size values of a stream with their mean and
+// standard deviation, updated in constant time per value. Sums are kept
+// relative to a shift taken from the window and recomputed once per size
+// values to stop rounding errors from building up. A window of one repeated
+// value has a deviation of exactly zero, as MeanStdDev gives it. NaN and
+// infinite values are ignored, since they would poison the running sums.
+type RollingWindow struct {
+	values []float64
+	next   int
+	count  int
+	shift  float64
+	sum    float64
+	sumSq  float64
+	pushes int
+	// run is how many of the newest values are equal
+	run int
+}
+
+// NewRollingWindow creates an empty window of size values, or nil for a
+// size below one
+func NewRollingWindow(size int) *RollingWindow {
+	if size <= 0 {
+		return nil
+	}
+	return &RollingWindow{values: make([]float64, size)}
+}
+
+// Push adds a value, dropping the oldest one once the window is full
+func (w *RollingWindow) Push(value float64) {
+	if !isFinite(value) {
+		return
+	}
+	if w.count == 0 {
+		w.shift = value
+	}
+	w.run = nextRun(w.run, w.count > 0 && value == w.values[w.last()], len(w.values))
+	if w.count == len(w.values) {
+		w.remove(w.values[w.next])
+	} else {
+		w.count++
+	}
+	w.values[w.next] = value
+	w.next = (w.next + 1) % len(w.values)
+	w.add(value)
+
+	w.pushes++
+	if w.pushes >= len(w.values) {
+		w.recompute()
+	}
+}
+
+// ReplaceLast replaces the newest value
+func (w *RollingWindow) ReplaceLast(value float64) {
+	if !isFinite(value) {
+		return
+	}
+	if w.count == 0 {
+		w.Push(value)
+		return
+	}
+	last := w.last()
+	w.remove(w.values[last])
+	w.values[last] = value
+	w.add(value)
+	w.run = 1
+	for w.run < w.count && w.values[(last-w.run+len(w.values))%len(w.values)] == value {
+		w.run++
+	}
+}
+
+// Len returns the number of values in the window
+func (w *RollingWindow) Len() int {
+	return w.count
+}
+
+// Full reports whether the window holds size values
+func (w *RollingWindow) Full() bool {
+	return w.count == len(w.values)
+}
+
+// Mean returns the mean of the window, the simple moving average once it
+// is full
+func (w *RollingWindow) Mean() (float64, error) {
+	if w.count == 0 {
+		return 0, insufficientData(1, 0)
+	}
+	return w.shift + w.sum/float64(w.count), nil
+}
+
+// MeanStdDev returns the mean and population standard deviation of the window
+func (w *RollingWindow) MeanStdDev() (float64, float64, error) {
+	if w.count == 0 {
+		return 0, 0, insufficientData(1, 0)
+	}
+	if w.run >= w.count {
+		return w.values[w.last()], 0, nil
+	}
+	n := float64(w.count)
+	mean := w.sum / n
+	variance := math.Max(0, w.sumSq/n-mean*mean)
+	return w.shift + mean, math.Sqrt(variance), nil
+}
+
+// last returns the index of the newest value
+func (w *RollingWindow) last() int {
+	return (w.next - 1 + len(w.values)) % len(w.values)
+}
+
+// nextRun extends a run of equal values by one, or starts a new one
+func nextRun(run int, equal bool, size int) int {
+	if !equal {
+		return 1
+	}
+	return min(run+1, size)
+}
+
+func (w *RollingWindow) add(value float64) {
+	d := value - w.shift
+	w.sum += d
+	w.sumSq += d * d
+}
+
+func (w *RollingWindow) remove(value float64) {
+	d := value - w.shift
+	w.sum -= d
+	w.sumSq -= d * d
+}
+
+// recompute resums the window around its oldest value
+func (w *RollingWindow) recompute() {
+	w.pushes = 0
+	oldest := (w.next - w.count + len(w.values)) % len(w.values)
+	w.shift = w.values[oldest]
+	w.sum, w.sumSq = 0, 0
+	for i := 0; i < w.count; i++ {
+		w.add(w.values[(oldest+i)%len(w.values)])
+	}
+}
+
+// StreamingEMA is an exponential moving average updated in constant time
+// per value, matching EMA over the finite values pushed so far
+type StreamingEMA struct {
+	period int
+	alpha  float64
+	count  int
+	value  float64
+}
+
+// NewStreamingEMA creates an EMA of the period, or nil for a period below one
+func NewStreamingEMA(period int) *StreamingEMA {
+	if period <= 0 {
+		return nil
+	}
+	return &StreamingEMA{period: period, alpha: 2 / float64(period+1)}
+}
+
+// Push adds a value, ignoring NaN and infinite ones
+func (e *StreamingEMA) Push(value float64) {
+	if !isFinite(value) {
+		return
+	}
+	e.count++
+	switch {
+	case e.count < e.period:
+		e.value += value
+	case e.count == e.period:
+		e.value = (e.value + value) / float64(e.period)
+	default:
+		e.value += e.alpha * (value - e.value)
+	}
+}
+
+// Value returns the EMA once period values have been pushed
+func (e *StreamingEMA) Value() (float64, error) {
+	if e.count < e.period {
+		return 0, insufficientData(e.period, e.count)
+	}
+	return e.value, nil
+}
+
+// RollingRSI is the relative strength index of the last period changes of
+// a stream, updated in constant time per value and matching RSI over the
+// finite values
+type RollingRSI struct {
+	changes  []float64
+	next     int
+	count    int
+	previous float64
+	seen     bool
+	gains    float64
+	losses   float64
+	// rising and falling count the gains and losses in the window, so an
+	// empty side is exactly zero whatever rounding left in its sum
+	rising  int
+	falling int
+}
+
+// NewRollingRSI creates an RSI of the period, or nil for a period below one
+func NewRollingRSI(period int) *RollingRSI {
+	if period <= 0 {
+		return nil
+	}
+	return &RollingRSI{changes: make([]float64, period)}
+}
+
+// Push adds a value, ignoring NaN and infinite ones
+func (r *RollingRSI) Push(value float64) {
+	if !isFinite(value) {
+		return
+	}
+	if !r.seen {
+		r.previous, r.seen = value, true
+		return
+	}
+	change := value - r.previous
+	r.previous = value
+	if r.count == len(r.changes) {
+		r.apply(r.changes[r.next], -1)
+	} else {
+		r.count++
+	}
+	r.changes[r.next] = change
+	r.next = (r.next + 1) % len(r.changes)
+	r.apply(change, 1)
+}
+
+// Value returns the RSI once period+1 values have been pushed
+func (r *RollingRSI) Value() (float64, error) {
+	if r.count < len(r.changes) {
+		return 0, insufficientData(len(r.changes)+1, r.count+btoi(r.seen))
+	}
+	gains, losses := r.gains, r.losses
+	if r.rising == 0 {
+		gains = 0
+	}
+	if r.falling == 0 {
+		losses = 0
+	}
+	return relativeStrength(gains, losses), nil
+}
+
+func (r *RollingRSI) apply(change float64, sign int) {
+	switch {
+	case change > 0:
+		r.gains += float64(sign) * change
+		r.rising += sign
+	case change < 0:
+		r.losses -= float64(sign) * change
+		r.falling += sign
+	}
+}
+
+// RollingCorrelation is the Pearson correlation of the last size pairs of
+// two streams, updated in constant time per pair and matching Correlation.
+// Its sums are shifted and recomputed like RollingWindow's, and pairs with a
+// NaN or infinite value are ignored.
+type RollingCorrelation struct {
+	xs, ys         []float64
+	next           int
+	count          int
+	pushes         int
+	shiftX, shiftY float64
+	sumX, sumY     float64
+	sumXX, sumYY   float64
+	sumXY          float64
+	runX, runY     int
+}
+
+// NewRollingCorrelation creates a correlation over size pairs, or nil for a
+// size below two
+func NewRollingCorrelation(size int) *RollingCorrelation {
+	if size < 2 {
+		return nil
+	}
+	return &RollingCorrelation{xs: make([]float64, size), ys: make([]float64, size)}
+}
+
+// Push adds a pair, dropping the oldest one once the window is full
+func (c *RollingCorrelation) Push(x, y float64) {
+	if !isFinite(x) || !isFinite(y) {
+		return
+	}
+	if c.count == 0 {
+		c.shiftX, c.shiftY = x, y
+	}
+	last := (c.next - 1 + len(c.xs)) % len(c.xs)
+	c.runX = nextRun(c.runX, c.count > 0 && x == c.xs[last], len(c.xs))
+	c.runY = nextRun(c.runY, c.count > 0 && y == c.ys[last], len(c.ys))
+	if c.count == len(c.xs) {
+		c.apply(c.xs[c.next], c.ys[c.next], -1)
+	} else {
+		c.count++
+	}
+	c.xs[c.next], c.ys[c.next] = x, y
+	c.next = (c.next + 1) % len(c.xs)
+	c.apply(x, y, 1)
+
+	c.pushes++
+	if c.pushes >= len(c.xs) {
+		c.pushes = 0
+		oldest := (c.next - c.count + len(c.xs)) % len(c.xs)
+		c.shiftX, c.shiftY = c.xs[oldest], c.ys[oldest]
+		c.sumX, c.sumY, c.sumXX, c.sumYY, c.sumXY = 0, 0, 0, 0, 0
+		for i := 0; i < c.count; i++ {
+			j := (oldest + i) % len(c.xs)
+			c.apply(c.xs[j], c.ys[j], 1)
+		}
+	}
+}
+
+// Value returns the correlation of the pairs in the window
+func (c *RollingCorrelation) Value() (float64, error) {
+	if c.count < 2 {
+		return 0, insufficientData(2, c.count)
+	}
+	if c.runX >= c.count || c.runY >= c.count {
+		return 0, ErrUndefinedCorrelation
+	}
+	n := float64(c.count)
+	meanX, meanY := c.sumX/n, c.sumY/n
+	varianceX := math.Max(0, c.sumXX/n-meanX*meanX)
+	varianceY := math.Max(0, c.sumYY/n-meanY*meanY)
+	if varianceX == 0 || varianceY == 0 {
+		return 0, ErrUndefinedCorrelation
+	}
+	covariance := c.sumXY/n - meanX*meanY
+	return math.Max(-1, math.Min(1, covariance/math.Sqrt(varianceX*varianceY))), nil
+}
+
+func (c *RollingCorrelation) apply(x, y float64, sign float64) {
+	dx, dy := x-c.shiftX, y-c.shiftY
+	c.sumX += sign * dx
+	c.sumY += sign * dy
+	c.sumXX += sign * dx * dx
+	c.sumYY += sign * dy * dy
+	c.sumXY += sign * dx * dy
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
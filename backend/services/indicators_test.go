@@ -0,0 +1,279 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndicatorsRejectShortInput(t *testing.T) {
+	values := []float64{1, 2, 3}
+
+	_, err := SMA(values, 4)
+	assert.ErrorIs(t, err, ErrInsufficientData)
+	_, err = SMA(values, 0)
+	assert.ErrorIs(t, err, ErrInvalidPeriod)
+	_, err = EMA(values, 4)
+	assert.ErrorIs(t, err, ErrInsufficientData)
+	_, err = RSI(values, 3)
+	assert.ErrorIs(t, err, ErrInsufficientData)
+	_, _, err = MeanStdDev(nil)
+	assert.ErrorIs(t, err, ErrInsufficientData)
+	_, err = Correlation(values[:1], values[:1])
+	assert.ErrorIs(t, err, ErrInsufficientData)
+	_, err = Correlation(values, values[:2])
+	assert.Error(t, err)
+	_, err = Correlation(values, []float64{5, 5, 5})
+	assert.ErrorIs(t, err, ErrUndefinedCorrelation)
+}
+
+func TestIndicatorValues(t *testing.T) {
+	values := []float64{2, 4, 6, 8, 10}
+
+	sma, err := SMA(values, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 8.0, sma)
+
+	// Seeded with (2+4+6)/3 = 4, then 4+0.5*(8-4) = 6 and 6+0.5*(10-6) = 8
+	ema, err := EMA(values, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 8.0, ema)
+
+	rsi, err := RSI([]float64{1, 2, 1, 3}, 3)
+	require.NoError(t, err)
+	assert.InDelta(t, 75.0, rsi, 1e-9)
+
+	mean, stdDev, err := MeanStdDev([]float64{0, 0, 0, 0, 4})
+	require.NoError(t, err)
+	assert.InDelta(t, 0.8, mean, 1e-9)
+	assert.InDelta(t, 1.6, stdDev, 1e-9)
+
+	correlation, err := Correlation(values, []float64{10, 8, 6, 4, 2})
+	require.NoError(t, err)
+	assert.InDelta(t, -1.0, correlation, 1e-9)
+}
+
+// randomWalk returns a price series wandering around start, with a flat
+// stretch to check that a constant window has no deviation
+func randomWalk(random *rand.Rand, n int, start float64) []float64 {
+	values := make([]float64, n)
+	price := start
+	for i := range values {
+		if i < n/3 || i > n/3+50 {
+			price *= 1 + random.NormFloat64()*0.01
+		}
+		values[i] = price
+	}
+	return values
+}
+
+func assertClose(t *testing.T, want, got float64, context string) {
+	t.Helper()
+	assert.InDelta(t, want, got, 1e-9*math.Max(1, math.Abs(want)), context)
+}
+
+func TestStreamingIndicatorsMatchBatch(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+	for _, start := range []float64{0.15, 3200, 1e6} {
+		values := randomWalk(random, 600, start)
+		other := randomWalk(random, 600, start)
+		for _, period := range []int{1, 2, 5, 30} {
+			window := NewRollingWindow(period)
+			ema := NewStreamingEMA(period)
+			rsi := NewRollingRSI(period)
+			correlation := NewRollingCorrelation(max(period, 2))
+			for i := range values {
+				context := fmt.Sprintf("start %g, period %d, value %d", start, period, i)
+				window.Push(values[i])
+				ema.Push(values[i])
+				rsi.Push(values[i])
+				correlation.Push(values[i], other[i])
+				seen := values[:i+1]
+
+				wantSMA, wantErr := SMA(seen, period)
+				if window.Full() {
+					require.NoError(t, wantErr, context)
+					got, err := window.Mean()
+					require.NoError(t, err, context)
+					assertClose(t, wantSMA, got, context)
+
+					wantMean, wantStdDev, _ := MeanStdDev(seen[len(seen)-period:])
+					gotMean, gotStdDev, _ := window.MeanStdDev()
+					assertClose(t, wantMean, gotMean, context)
+					assert.InDelta(t, wantStdDev, gotStdDev, 1e-6*wantMean, context)
+					if wantStdDev == 0 {
+						assert.Zero(t, gotStdDev, context)
+					}
+				} else {
+					assert.ErrorIs(t, wantErr, ErrInsufficientData, context)
+				}
+
+				wantEMA, wantErr := EMA(seen, period)
+				gotEMA, err := ema.Value()
+				assert.Equal(t, wantErr, err, context)
+				assertClose(t, wantEMA, gotEMA, context)
+
+				wantRSI, wantErr := RSI(seen, period)
+				gotRSI, err := rsi.Value()
+				require.Equal(t, wantErr == nil, err == nil, context)
+				assert.InDelta(t, wantRSI, gotRSI, 1e-6, context)
+
+				size := max(period, 2)
+				from := max(0, len(seen)-size)
+				wantCorrelation, wantErr := Correlation(seen[from:], other[from:i+1])
+				gotCorrelation, err := correlation.Value()
+				require.Equal(t, wantErr == nil, err == nil, context)
+				assert.InDelta(t, wantCorrelation, gotCorrelation, 1e-6, context)
+			}
+		}
+	}
+}
+
+func TestRollingWindowReplaceLast(t *testing.T) {
+	window := NewRollingWindow(3)
+	for _, value := range []float64{1, 2, 3, 4} {
+		window.Push(value)
+	}
+	window.ReplaceLast(10)
+	mean, err := window.Mean()
+	require.NoError(t, err)
+	assert.InDelta(t, 5.0, mean, 1e-9)
+	assert.Nil(t, NewRollingWindow(0))
+	assert.Nil(t, NewStreamingEMA(0))
+	assert.Nil(t, NewRollingRSI(0))
+	assert.Nil(t, NewRollingCorrelation(1))
+}
+
+func TestIndicatorsRejectNonFiniteValues(t *testing.T) {
+	for _, bad := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		values := []float64{1, 2, bad, 4, 5}
+
+		_, err := SMA(values, 3)
+		assert.ErrorIs(t, err, ErrNonFiniteValue, "%v", bad)
+		_, err = EMA(values, 3)
+		assert.ErrorIs(t, err, ErrNonFiniteValue, "%v", bad)
+		_, err = RSI(values, 3)
+		assert.ErrorIs(t, err, ErrNonFiniteValue, "%v", bad)
+		_, _, err = MeanStdDev(values)
+		assert.ErrorIs(t, err, ErrNonFiniteValue, "%v", bad)
+		_, err = Correlation(values, []float64{5, 4, 3, 2, 1})
+		assert.ErrorIs(t, err, ErrNonFiniteValue, "%v", bad)
+	}
+
+	// Only the values in the period are checked
+	sma, err := SMA([]float64{math.NaN(), 2, 4}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, sma)
+
+	// A single value, or prices at zero, are no error
+	mean, stdDev, err := MeanStdDev([]float64{7})
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, mean)
+	assert.Zero(t, stdDev)
+	rsi, err := RSI([]float64{0, 0, 0}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, rsi)
+}
+
+func TestStreamingIndicatorsIgnoreNonFiniteValues(t *testing.T) {
+	window := NewRollingWindow(3)
+	ema := NewStreamingEMA(3)
+	rsi := NewRollingRSI(2)
+	correlation := NewRollingCorrelation(3)
+	for i, value := range []float64{2, math.NaN(), 4, math.Inf(1), 6, 8} {
+		window.Push(value)
+		ema.Push(value)
+		rsi.Push(value)
+		correlation.Push(value, float64(i))
+	}
+	window.ReplaceLast(math.NaN())
+
+	mean, stdDev, err := window.MeanStdDev()
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, mean)
+	assert.InDelta(t, math.Sqrt(8.0/3), stdDev, 1e-9)
+	value, err := ema.Value()
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, value, "seeded with (2+4+6)/3 = 4, then 4+0.5*(8-4) = 6")
+	value, err = rsi.Value()
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, value)
+	value, err = correlation.Value()
+	require.NoError(t, err)
+	assert.False(t, math.IsNaN(value))
+}
+
+// The batch indicators cost O(window) per new value, the streaming ones O(1):
+// go test -bench Indicator -run ^$ ./services shows the batch time growing
+// with the window while the streaming time stays flat.
+
+func BenchmarkIndicatorBatchSMA(b *testing.B) {
+	for _, period := range []int{10, 100, 1000} {
+		values := randomWalk(rand.New(rand.NewSource(1)), 2*period, 1)
+		b.Run(fmt.Sprintf("window=%d", period), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := SMA(values[:period+i%period], period); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndicatorStreamingSMA(b *testing.B) {
+	for _, period := range []int{10, 100, 1000} {
+		values := randomWalk(rand.New(rand.NewSource(1)), 2*period, 1)
+		b.Run(fmt.Sprintf("window=%d", period), func(b *testing.B) {
+			window := NewRollingWindow(period)
+			for i := 0; i < b.N; i++ {
+				window.Push(values[i%len(values)])
+				window.Mean()
+			}
+		})
+	}
+}
+
+func BenchmarkIndicatorBatchVolatility(b *testing.B) {
+	for _, period := range []int{10, 100, 1000} {
+		values := randomWalk(rand.New(rand.NewSource(1)), 2*period, 1)
+		b.Run(fmt.Sprintf("window=%d", period), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				end := period + i%period
+				if _, _, err := MeanStdDev(values[end-period : end]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndicatorStreamingVolatility(b *testing.B) {
+	for _, period := range []int{10, 100, 1000} {
+		values := randomWalk(rand.New(rand.NewSource(1)), 2*period, 1)
+		b.Run(fmt.Sprintf("window=%d", period), func(b *testing.B) {
+			window := NewRollingWindow(period)
+			for i := 0; i < b.N; i++ {
+				window.Push(values[i%len(values)])
+				window.MeanStdDev()
+			}
+		})
+	}
+}
+
+func BenchmarkIndicatorStreamingCorrelation(b *testing.B) {
+	for _, period := range []int{10, 100, 1000} {
+		random := rand.New(rand.NewSource(1))
+		x, y := randomWalk(random, 2*period, 1), randomWalk(random, 2*period, 1)
+		b.Run(fmt.Sprintf("window=%d", period), func(b *testing.B) {
+			correlation := NewRollingCorrelation(period)
+			for i := 0; i < b.N; i++ {
+				correlation.Push(x[i%len(x)], y[i%len(y)])
+				correlation.Value()
+			}
+		})
+	}
+}
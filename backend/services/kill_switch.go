@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxKillSwitchChanges is how many engage and disengage changes are kept
+const maxKillSwitchChanges = 100
+
+// ErrActionsSuspended is returned by the action path while the kill switch is
+// engaged, or while its state can't be read
+var ErrActionsSuspended = errors.New("actions are suspended")
+
+// KillSwitchState is whether actions are suspended, and who last changed it
+// and why
+type KillSwitchState struct {
+	Engaged   bool      `json:"engaged"`
+	ChangedBy string    `json:"changed_by,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedAt time.Time `json:"changed_at,omitempty"`
+}
+
+// KillSwitchStore holds the kill switch flag where every instance reads it.
+// An error means the flag is unknown.
+type KillSwitchStore interface {
+	Load(ctx context.Context) (KillSwitchState, error)
+	Save(ctx context.Context, state KillSwitchState) error
+}
+
+// MemoryKillSwitchStore keeps the flag in the process, for a single instance
+type MemoryKillSwitchStore struct {
+	mu    sync.Mutex
+	state KillSwitchState
+}
+
+// Load returns the stored state
+func (s *MemoryKillSwitchStore) Load(ctx context.Context) (KillSwitchState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
+
+// Save replaces the stored state
+func (s *MemoryKillSwitchStore) Save(ctx context.Context, state KillSwitchState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+// KillSwitchNotifier pushes a notice to every connected user
+type KillSwitchNotifier interface {
+	BroadcastMessage(message *ChatResponse) error
+}
+
+// KillSwitch suspends the action subsystem. The action path checks it
+// synchronously before confirming, broadcasting, or signing anything, and
+// treats a flag it can't read as engaged; nothing else consults it, so reads
+// keep working whatever the store's state.
+type KillSwitch struct {
+	store    KillSwitchStore
+	notifier KillSwitchNotifier
+	logger   *log.Logger
+
+	mu      sync.Mutex
+	changes []KillSwitchState
+	now     func() time.Time
+}
+
+// NewKillSwitch creates a kill switch keeping its flag in the store
+func NewKillSwitch(store KillSwitchStore) *KillSwitch {
+	return &KillSwitch{
+		store:  store,
+		logger: log.New(log.Writer(), "[KillSwitch] ", log.LstdFlags),
+		now:    utcNow,
+	}
+}
+
+// SetNotifier pushes a notice to connected users when actions are suspended
+// or resumed
+func (k *KillSwitch) SetNotifier(notifier KillSwitchNotifier) {
+	k.notifier = notifier
+}
+
+// Check returns nil when actions may proceed. It wraps ErrActionsSuspended
+// while the switch is engaged or its flag can't be read.
+func (k *KillSwitch) Check(ctx context.Context) error {
+	if k == nil {
+		return nil
+	}
+	state, err := k.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: kill switch state is unavailable: %v", ErrActionsSuspended, err)
+	}
+	if state.Engaged {
+		return fmt.Errorf("%w by %s: %s", ErrActionsSuspended, state.ChangedBy, state.Reason)
+	}
+	return nil
+}
+
+// State returns the stored flag
+func (k *KillSwitch) State(ctx context.Context) (KillSwitchState, error) {
+	state, err := k.store.Load(ctx)
+	if err != nil {
+		return KillSwitchState{}, fmt.Errorf("failed to read kill switch state: %w", err)
+	}
+	return state, nil
+}
+
+// Engage suspends actions, recording who did it and why
+func (k *KillSwitch) Engage(ctx context.Context, by, reason string) (KillSwitchState, error) {
+	return k.set(ctx, true, by, reason)
+}
+
+// Disengage resumes actions; held actions go out on the queue's next pass
+func (k *KillSwitch) Disengage(ctx context.Context, by, reason string) (KillSwitchState, error) {
+	return k.set(ctx, false, by, reason)
+}
+
+func (k *KillSwitch) set(ctx context.Context, engaged bool, by, reason string) (KillSwitchState, error) {
+	state := KillSwitchState{Engaged: engaged, ChangedBy: by, Reason: reason, ChangedAt: k.now()}
+	if err := k.store.Save(ctx, state); err != nil {
+		return KillSwitchState{}, fmt.Errorf("failed to save kill switch state: %w", err)
+	}
+
+	k.mu.Lock()
+	k.changes = append(k.changes, state)
+	if len(k.changes) > maxKillSwitchChanges {
+		k.changes = k.changes[len(k.changes)-maxKillSwitchChanges:]
+	}
+	k.mu.Unlock()
+
+	k.logger.Printf("Actions engaged=%t by %s: %s", engaged, by, reason)
+	k.notify(state)
+	return state, nil
+}
+
+// Changes returns the changes made through this instance, newest first
+func (k *KillSwitch) Changes() []KillSwitchState {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	changes := make([]KillSwitchState, len(k.changes))
+	for i, change := range k.changes {
+		changes[len(changes)-1-i] = change
+	}
+	return changes
+}
+
+// notify pushes an actions_suspended or actions_resumed notice
+func (k *KillSwitch) notify(state KillSwitchState) {
+	if k.notifier == nil {
+		return
+	}
+	frameType, text := "actions_resumed", "✅ On-chain actions have resumed. Actions held while they were suspended will now be submitted."
+	if state.Engaged {
+		frameType, text = "actions_suspended", "⛔ On-chain actions are temporarily suspended. Confirmed actions are held and will be submitted once they resume."
+	}
+	err := k.notifier.BroadcastMessage(&ChatResponse{
+		ID:            fmt.Sprintf("%s_%d", frameType, state.ChangedAt.UnixNano()),
+		Type:          frameType,
+		Response:      text,
+		Success:       true,
+		Timestamp:     NewAPITime(state.ChangedAt),
+		TimestampUnix: state.ChangedAt.Unix(),
+		Metadata: map[string]interface{}{
+			"reason": state.Reason,
+		},
+	})
+	if err != nil {
+		k.logger.Printf("Failed to push %s notice: %v", frameType, err)
+	}
+}
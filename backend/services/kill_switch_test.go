@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// broadcastRecorder keeps the frames pushed to every user
+type broadcastRecorder struct {
+	frames []*ChatResponse
+}
+
+func (b *broadcastRecorder) BroadcastMessage(message *ChatResponse) error {
+	b.frames = append(b.frames, message)
+	return nil
+}
+
+// unreachableStore stands in for a flag store that can't be reached
+type unreachableStore struct{}
+
+func (unreachableStore) Load(ctx context.Context) (KillSwitchState, error) {
+	return KillSwitchState{}, errors.New("connection refused")
+}
+
+func (unreachableStore) Save(ctx context.Context, state KillSwitchState) error {
+	return errors.New("connection refused")
+}
+
+func TestKillSwitchHoldsConfirmedActions(t *testing.T) {
+	submitter := &countingSubmitter{}
+	queue, audit, clock := newTestActionQueue(submitter)
+	killSwitch := NewKillSwitch(&MemoryKillSwitchStore{})
+	notices := &broadcastRecorder{}
+	killSwitch.SetNotifier(notices)
+	queue.SetKillSwitch(killSwitch)
+	engine := newTestChatEngine(t)
+	engine.SetActionAudit(audit)
+	engine.SetActionQueue(queue)
+	engine.SetKillSwitch(killSwitch)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	response, err := engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_1", UserID: user, Message: "Swap 5 KAIA for USDT"})
+	require.NoError(t, err)
+	action := response.Data.(*ActionRequest)
+	assert.Equal(t, ActionStatusPending, action.Status)
+
+	// Engaged after the confirmation but before the broadcast
+	state, err := killSwitch.Engage(ctx, "oncall", "relayer key rotation")
+	require.NoError(t, err)
+	assert.True(t, state.Engaged)
+	require.Len(t, notices.frames, 1)
+	assert.Equal(t, "actions_suspended", notices.frames[0].Type)
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, 0, queue.SubmitDue(ctx))
+	assert.Empty(t, submitter.submitted)
+	held, ok := queue.Get(action.ID)
+	require.True(t, ok)
+	assert.Equal(t, ActionStatusPending, held.Status)
+
+	// New confirmations are rejected outright
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_2", UserID: user, Message: "Swap 1 KAIA for USDT"})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Equal(t, "actions_suspended", response.Metadata["error"])
+	assert.Equal(t, ActionStatusFailed, response.Data.(*ActionRequest).Status)
+
+	// The held action goes out once the switch is disengaged
+	_, err = killSwitch.Disengage(ctx, "oncall", "rotation done")
+	require.NoError(t, err)
+	assert.Equal(t, "actions_resumed", notices.frames[1].Type)
+	assert.Equal(t, 1, queue.SubmitDue(ctx))
+	assert.Equal(t, []string{action.ID}, submitter.submitted)
+	released, _ := queue.Get(action.ID)
+	assert.Equal(t, ActionStatusCompleted, released.Status)
+
+	changes := killSwitch.Changes()
+	require.Len(t, changes, 2)
+	assert.False(t, changes[0].Engaged)
+	assert.Equal(t, "oncall", changes[1].ChangedBy)
+	assert.Equal(t, "relayer key rotation", changes[1].Reason)
+}
+
+func TestKillSwitchFailsClosed(t *testing.T) {
+	killSwitch := NewKillSwitch(unreachableStore{})
+	ctx := context.Background()
+
+	assert.ErrorIs(t, killSwitch.Check(ctx), ErrActionsSuspended)
+	_, err := killSwitch.State(ctx)
+	assert.Error(t, err)
+	_, err = killSwitch.Engage(ctx, "oncall", "test")
+	assert.Error(t, err)
+	assert.Empty(t, killSwitch.Changes())
+
+	var unset *KillSwitch
+	assert.NoError(t, unset.Check(ctx), "no kill switch never suspends actions")
+}
+
+func TestNonceManagerChecksKillSwitchBeforeSigning(t *testing.T) {
+	pool := newFakeMempool()
+	from, signer := newRelayer(t)
+	signed := 0
+	counting := func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		signed++
+		return signer(from, tx)
+	}
+	manager := NewNonceManager(pool, NonceManagerOptions{})
+	killSwitch := NewKillSwitch(&MemoryKillSwitchStore{})
+	manager.SetKillSwitch(killSwitch)
+	ctx := context.Background()
+
+	_, err := killSwitch.Engage(ctx, "oncall", "incident")
+	require.NoError(t, err)
+	_, err = manager.Send(ctx, from, counting, TxRequest{To: &relayTarget, Gas: 21000})
+	assert.ErrorIs(t, err, ErrActionsSuspended)
+	assert.Zero(t, signed)
+	assert.Empty(t, manager.InFlight(from))
+
+	_, err = killSwitch.Disengage(ctx, "oncall", "resolved")
+	require.NoError(t, err)
+	sent, err := manager.Send(ctx, from, counting, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), sent.Nonce)
+	assert.Equal(t, 1, signed)
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sort directions of list endpoints
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// ErrInvalidSort is returned for a sort field or direction a list doesn't offer
+var ErrInvalidSort = errors.New("invalid sort")
+
+// ListSort is the field a list is sorted by and its direction
+type ListSort struct {
+	Field string `json:"sort"`
+	Order string `json:"order"`
+}
+
+// sortField compares rows by one field; order is its default direction
+type sortField[T any] struct {
+	compare func(a, b T) int
+	order   string
+}
+
+// ListOrdering is the whitelist of fields a list can be sorted by. Rows equal
+// in the chosen field fall back to fixed tiebreakers, so repeated requests
+// return them in the same order.
+type ListOrdering[T any] struct {
+	defaultField string
+	fields       map[string]sortField[T]
+	tiebreakers  []func(a, b T) int
+}
+
+// Fields returns the fields the list can be sorted by
+func (o ListOrdering[T]) Fields() []string {
+	fields := make([]string, 0, len(o.fields))
+	for field := range o.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Parse validates the sort and order query parameters. An empty field sorts
+// by the default one, and an empty order takes the field's usual direction:
+// descending for amounts and scores, ascending for names.
+func (o ListOrdering[T]) Parse(field, order string) (ListSort, error) {
+	field = strings.ToLower(strings.TrimSpace(field))
+	if field == "" {
+		field = o.defaultField
+	}
+	sf, ok := o.fields[field]
+	if !ok {
+		return ListSort{}, fmt.Errorf("%w field %q, supported: %s", ErrInvalidSort, field, strings.Join(o.Fields(), ", "))
+	}
+
+	switch order = strings.ToLower(strings.TrimSpace(order)); order {
+	case "":
+		order = sf.order
+	case OrderAsc, OrderDesc:
+	default:
+		return ListSort{}, fmt.Errorf("%w order %q, supported: asc, desc", ErrInvalidSort, order)
+	}
+	return ListSort{Field: field, Order: order}, nil
+}
+
+// Default returns the sort used when none is requested
+func (o ListOrdering[T]) Default() ListSort {
+	return ListSort{Field: o.defaultField, Order: o.fields[o.defaultField].order}
+}
+
+// Sort orders rows in place by the sort, then by the tiebreakers
+func (o ListOrdering[T]) Sort(rows []T, by ListSort) {
+	primary := o.fields[by.Field].compare
+	if primary == nil {
+		by = o.Default()
+		primary = o.fields[by.Field].compare
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return o.compare(rows[i], rows[j], primary, by.Order) < 0
+	})
+}
+
+// compare compares two rows by the primary field in the given direction, then
+// by the tiebreakers
+func (o ListOrdering[T]) compare(a, b T, primary func(a, b T) int, order string) int {
+	c := primary(a, b)
+	if order == OrderDesc {
+		c = -c
+	}
+	for _, tiebreaker := range o.tiebreakers {
+		if c != 0 {
+			break
+		}
+		c = tiebreaker(a, b)
+	}
+	return c
+}
+
+// descending reverses a comparison, for tiebreakers that put larger values first
+func descending[T any](compare func(a, b T) int) func(a, b T) int {
+	return func(a, b T) int { return compare(b, a) }
+}
+
+// YieldOrdering sorts yield opportunities, by default by opportunity score,
+// then APY, protocol, and asset pair
+var YieldOrdering = ListOrdering[YieldOpportunity]{
+	defaultField: "opportunity_score",
+	fields: map[string]sortField[YieldOpportunity]{
+		"opportunity_score": {compareYieldScore, OrderDesc},
+		"apy":               {compareYieldAPY, OrderDesc},
+		"tvl":               {func(a, b YieldOpportunity) int { return cmp.Compare(a.TVL, b.TVL) }, OrderDesc},
+		"risk":              {func(a, b YieldOpportunity) int { return cmp.Compare(a.Risk, b.Risk) }, OrderAsc},
+		"protocol":          {compareYieldProtocol, OrderAsc},
+	},
+	tiebreakers: []func(a, b YieldOpportunity) int{
+		descending(compareYieldScore),
+		descending(compareYieldAPY),
+		compareYieldProtocol,
+		func(a, b YieldOpportunity) int { return cmp.Compare(a.AssetPair, b.AssetPair) },
+	},
+}
+
+func compareYieldScore(a, b YieldOpportunity) int { return cmp.Compare(a.Opportunity, b.Opportunity) }
+
+func compareYieldAPY(a, b YieldOpportunity) int { return cmp.Compare(a.APY, b.APY) }
+
+func compareYieldProtocol(a, b YieldOpportunity) int { return cmp.Compare(a.Protocol, b.Protocol) }
+
+// SuggestionOrdering sorts trading suggestions, by default by confidence,
+// then asset and type
+var SuggestionOrdering = ListOrdering[TradingSuggestion]{
+	defaultField: "confidence",
+	fields: map[string]sortField[TradingSuggestion]{
+		"confidence":      {compareSuggestionConfidence, OrderDesc},
+		"expected_return": {func(a, b TradingSuggestion) int { return cmp.Compare(a.ExpectedReturn, b.ExpectedReturn) }, OrderDesc},
+		"asset":           {compareSuggestionAsset, OrderAsc},
+	},
+	tiebreakers: []func(a, b TradingSuggestion) int{
+		descending(compareSuggestionConfidence),
+		compareSuggestionAsset,
+		func(a, b TradingSuggestion) int { return cmp.Compare(a.Type, b.Type) },
+	},
+}
+
+func compareSuggestionConfidence(a, b TradingSuggestion) int {
+	return cmp.Compare(a.Confidence, b.Confidence)
+}
+
+func compareSuggestionAsset(a, b TradingSuggestion) int { return cmp.Compare(a.Asset, b.Asset) }
+
+// ProtocolOrdering sorts protocols, by default by TVL, then name
+var ProtocolOrdering = ListOrdering[ProtocolData]{
+	defaultField: "tvl",
+	fields: map[string]sortField[ProtocolData]{
+		"tvl":        {compareProtocolTVL, OrderDesc},
+		"volume_24h": {func(a, b ProtocolData) int { return cmp.Compare(a.Volume24h, b.Volume24h) }, OrderDesc},
+		"apy":        {func(a, b ProtocolData) int { return cmp.Compare(a.APY, b.APY) }, OrderDesc},
+		"protocol":   {compareProtocolName, OrderAsc},
+	},
+	tiebreakers: []func(a, b ProtocolData) int{
+		descending(compareProtocolTVL),
+		compareProtocolName,
+	},
+}
+
+func compareProtocolTVL(a, b ProtocolData) int { return cmp.Compare(a.TVL, b.TVL) }
+
+func compareProtocolName(a, b ProtocolData) int { return cmp.Compare(a.Protocol, b.Protocol) }
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestYieldOrderingBreaksTiesDeterministically(t *testing.T) {
+	yields := []YieldOpportunity{
+		{Protocol: "Klayswap", AssetPair: "KAIA/USDT", APY: 9, Opportunity: 0.8},
+		{Protocol: "Aave V3", AssetPair: "USDC/ETH", APY: 9, Opportunity: 0.8},
+		{Protocol: "Aave V3", AssetPair: "DAI/ETH", APY: 9, Opportunity: 0.8},
+		{Protocol: "Compound V3", AssetPair: "DAI/USDC", APY: 12, Opportunity: 0.8},
+		{Protocol: "Uniswap V3", AssetPair: "ETH/USDC", APY: 3, Opportunity: 0.9},
+	}
+	want := []string{"Uniswap V3 ETH/USDC", "Compound V3 DAI/USDC", "Aave V3 DAI/ETH", "Aave V3 USDC/ETH", "Klayswap KAIA/USDT"}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		shuffled := append([]YieldOpportunity(nil), yields...)
+		random.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+
+		YieldOrdering.Sort(shuffled, YieldOrdering.Default())
+		got := make([]string, len(shuffled))
+		for j, yield := range shuffled {
+			got[j] = yield.Protocol + " " + yield.AssetPair
+		}
+		require.Equal(t, want, got)
+	}
+
+	// The direction only applies to the requested field; ties still follow
+	// the tiebreakers
+	by, err := YieldOrdering.Parse("APY", "asc")
+	require.NoError(t, err)
+	YieldOrdering.Sort(yields, by)
+	assert.Equal(t, "Uniswap V3", yields[0].Protocol)
+	assert.Equal(t, []string{"Aave V3", "Aave V3", "Klayswap"}, []string{yields[1].Protocol, yields[2].Protocol, yields[3].Protocol})
+	assert.Equal(t, "DAI/ETH", yields[1].AssetPair)
+	assert.Equal(t, "Compound V3", yields[4].Protocol)
+}
+
+func TestListOrderingRejectsUnlistedFields(t *testing.T) {
+	by, err := SuggestionOrdering.Parse("", "")
+	require.NoError(t, err)
+	assert.Equal(t, ListSort{Field: "confidence", Order: OrderDesc}, by)
+
+	by, err = ProtocolOrdering.Parse("protocol", "")
+	require.NoError(t, err)
+	assert.Equal(t, OrderAsc, by.Order, "names sort ascending by default")
+
+	for _, field := range []string{"reasoning", "tvl; drop table", "Amount"} {
+		_, err := SuggestionOrdering.Parse(field, "")
+		assert.ErrorIs(t, err, ErrInvalidSort, field)
+	}
+	_, err = YieldOrdering.Parse("apy", "sideways")
+	assert.ErrorIs(t, err, ErrInvalidSort)
+	assert.Equal(t, []string{"apy", "opportunity_score", "protocol", "risk", "tvl"}, YieldOrdering.Fields())
+}
+
+func TestSuggestionAndProtocolOrderIsStable(t *testing.T) {
+	suggestions := []TradingSuggestion{
+		{Type: "sell", Asset: "ETH", Confidence: 0.7},
+		{Type: "buy", Asset: "ETH", Confidence: 0.7},
+		{Type: "buy", Asset: "DAI", Confidence: 0.7},
+		{Type: "swap", Asset: "KAIA", Confidence: 0.9},
+	}
+	tailored := tailorSuggestions(suggestions, map[string]interface{}{"risk_tolerance": RiskHigh})
+	assert.Equal(t, []TradingSuggestion{suggestions[3], suggestions[2], suggestions[1], suggestions[0]}, tailored)
+
+	// Favorites still come first, in confidence order among themselves
+	tailored = tailorSuggestions(suggestions, map[string]interface{}{"risk_tolerance": RiskHigh, "favorite_tokens": []string{"ETH"}})
+	assert.Equal(t, []TradingSuggestion{suggestions[1], suggestions[0], suggestions[3], suggestions[2]}, tailored)
+
+	collector := NewDataCollector(nil)
+	first, err := collector.CollectProtocolData(context.Background())
+	require.NoError(t, err)
+	for i := 1; i < len(first); i++ {
+		assert.GreaterOrEqual(t, first[i-1].TVL, first[i].TVL)
+	}
+	again, err := collector.CollectProtocolData(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+}
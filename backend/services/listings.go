@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// DefaultListingMinLiquidityUSD is the initial liquidity a new token
+	// needs to be listed as notable
+	DefaultListingMinLiquidityUSD = 1000
+	// ListingRetention is how long new tokens are kept after deployment
+	ListingRetention = 30 * 24 * time.Hour
+	// listingWatchWindow is how long after deployment a token is watched for
+	// its first transfer and its first liquidity
+	listingWatchWindow  = 7 * 24 * time.Hour
+	listingPollInterval = 15 * time.Second
+	// listingMaxCatchUp is the most blocks read by one poll; after a longer
+	// gap only the most recent are read
+	listingMaxCatchUp = 600
+)
+
+// pairMintTopic is the signature topic of Uniswap V2 style pair Mint events,
+// emitted when liquidity is added
+var pairMintTopic = crypto.Keccak256Hash([]byte("Mint(address,uint256,uint256)"))
+
+// ListingMetadata reads the ERC-20 metadata of a token, failing for
+// contracts that don't answer decimals()
+type ListingMetadata interface {
+	Metadata(ctx context.Context, token common.Address) (TokenMetadata, error)
+}
+
+// ListingPools finds the liquidity pool of a pair contract
+type ListingPools interface {
+	Pool(ctx context.Context, token common.Address) (*LiquidityPool, error)
+}
+
+// ListingLiquidity is the first liquidity added to a pool of a new token
+type ListingLiquidity struct {
+	Pool    common.Address `json:"pool"`
+	Pair    string         `json:"pair"`
+	Block   uint64         `json:"block"`
+	AddedAt APITime        `json:"added_at"`
+	// ValueUSD is twice the value of the priced leg. Priced is false when
+	// the other leg has no USD price, leaving ValueUSD at 0.
+	ValueUSD float64 `json:"value_usd"`
+	Priced   bool    `json:"priced"`
+}
+
+// TokenListing is an ERC-20 token deployed on chain, listed from its first
+// transfer
+type TokenListing struct {
+	Token       common.Address `json:"token"`
+	Name        string         `json:"name"`
+	Symbol      string         `json:"symbol"`
+	Decimals    int            `json:"decimals"`
+	TotalSupply float64        `json:"total_supply"`
+	Deployer    common.Address `json:"deployer"`
+	DeployTx    common.Hash    `json:"deploy_tx"`
+	DeployBlock uint64         `json:"deploy_block"`
+	DeployedAt  APITime        `json:"deployed_at"`
+	// ListedAt is when the token was first transferred
+	ListedAt     *APITime          `json:"listed_at,omitempty"`
+	ListingBlock uint64            `json:"listing_block,omitempty"`
+	Liquidity    *ListingLiquidity `json:"liquidity,omitempty"`
+	// Verified is set when the token is on the token list, and never spam
+	Verified    bool     `json:"verified"`
+	Spam        bool     `json:"spam"`
+	SpamReasons []string `json:"spam_reasons,omitempty"`
+
+	deployedAt time.Time
+	listedAt   time.Time
+}
+
+// LiquidityUSD returns the value of the token's initial liquidity, 0 when
+// none was added yet
+func (l TokenListing) LiquidityUSD() float64 {
+	if l.Liquidity == nil {
+		return 0
+	}
+	return l.Liquidity.ValueUSD
+}
+
+// ListingDetector follows new blocks for contract creations, keeps those
+// that answer like ERC-20 tokens, and lists them from their first transfer
+// with the first liquidity added to one of their pools. Only top-level
+// creation transactions are seen, not contracts created by other contracts.
+type ListingDetector struct {
+	client       ChainClient
+	metadata     ListingMetadata
+	pools        ListingPools
+	prices       HistoricalPriceSource
+	minLiquidity float64
+	logger       *log.Logger
+
+	mu        sync.RWMutex
+	listings  map[common.Address]*TokenListing
+	lastBlock uint64
+
+	now func() time.Time
+}
+
+// NewListingDetector creates a detector with no listings, reading token
+// metadata, pools, and leg prices through the given sources
+func NewListingDetector(client ChainClient, metadata ListingMetadata, pools ListingPools, prices HistoricalPriceSource) *ListingDetector {
+	return &ListingDetector{
+		client:       client,
+		metadata:     metadata,
+		pools:        pools,
+		prices:       prices,
+		minLiquidity: DefaultListingMinLiquidityUSD,
+		logger:       log.New(log.Writer(), "[ListingDetector] ", log.LstdFlags),
+		listings:     make(map[common.Address]*TokenListing),
+		now:          utcNow,
+	}
+}
+
+// SetMinLiquidity changes the initial liquidity, in USD, a new token needs
+// to be notable
+func (ld *ListingDetector) SetMinLiquidity(usd float64) {
+	if usd >= 0 {
+		ld.minLiquidity = usd
+	}
+}
+
+// MinLiquidity returns the initial liquidity a new token needs to be notable
+func (ld *ListingDetector) MinLiquidity() float64 {
+	return ld.minLiquidity
+}
+
+// Start polls for new blocks until the context is cancelled
+func (ld *ListingDetector) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(listingPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := ld.Poll(ctx); err != nil && ctx.Err() == nil {
+				ld.logger.Printf("Failed to poll blocks: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Poll reads the blocks mined since the last poll for token deployments,
+// then their logs for the first transfers and liquidity of watched tokens.
+// A failed poll is retried from the same block.
+func (ld *ListingDetector) Poll(ctx context.Context) error {
+	head, err := ld.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block number: %w", err)
+	}
+
+	ld.mu.RLock()
+	from := ld.lastBlock + 1
+	ld.mu.RUnlock()
+	if head >= listingMaxCatchUp && from+listingMaxCatchUp <= head {
+		from = head - listingMaxCatchUp + 1
+	}
+	if from > head {
+		ld.prune()
+		return nil
+	}
+
+	blockTimes := make(map[uint64]time.Time, head-from+1)
+	for number := from; number <= head; number++ {
+		block, err := ld.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %w", number, err)
+		}
+		blockTimes[number] = time.Unix(int64(block.Time()), 0).UTC()
+		for _, tx := range block.Transactions() {
+			if tx.To() != nil {
+				continue
+			}
+			if err := ld.detect(ctx, tx, number, blockTimes[number]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := ld.findTransfers(ctx, from, head, blockTimes); err != nil {
+		return err
+	}
+	if err := ld.findLiquidity(ctx, from, head, blockTimes); err != nil {
+		return err
+	}
+
+	ld.mu.Lock()
+	ld.lastBlock = head
+	ld.mu.Unlock()
+	ld.prune()
+	return nil
+}
+
+// prune drops the tokens deployed longer than the retention ago
+func (ld *ListingDetector) prune() {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	cutoff := ld.now().Add(-ListingRetention)
+	for token, listing := range ld.listings {
+		if listing.deployedAt.Before(cutoff) {
+			delete(ld.listings, token)
+		}
+	}
+}
+
+// detect records the contract a creation transaction deployed when it
+// answers like an ERC-20 token
+func (ld *ListingDetector) detect(ctx context.Context, tx *types.Transaction, block uint64, at time.Time) error {
+	receipt, err := ld.client.TransactionReceipt(ctx, tx.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to get receipt of %s: %w", tx.Hash().Hex(), err)
+	}
+	contract := receipt.ContractAddress
+	if receipt.Status != types.ReceiptStatusSuccessful || contract == (common.Address{}) {
+		return nil
+	}
+	ld.mu.RLock()
+	_, known := ld.listings[contract]
+	ld.mu.RUnlock()
+	if known {
+		return nil
+	}
+
+	metadata, err := ld.metadata.Metadata(ctx, contract)
+	if err != nil {
+		// Not a token, or not one that can be read
+		return nil
+	}
+	supply, ok := ld.totalSupply(ctx, contract)
+	if !ok {
+		return nil
+	}
+	listing := &TokenListing{
+		Token:       contract,
+		Name:        metadata.Name,
+		Symbol:      metadata.Symbol,
+		Decimals:    metadata.Decimals,
+		TotalSupply: weiToFloat(supply, metadata.Decimals),
+		DeployTx:    tx.Hash(),
+		DeployBlock: block,
+		DeployedAt:  NewAPITime(at),
+		Verified:    metadata.Verified,
+		deployedAt:  at,
+	}
+	if deployer, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+		listing.Deployer = deployer
+	}
+	classifyListing(listing)
+
+	ld.mu.Lock()
+	ld.listings[contract] = listing
+	ld.mu.Unlock()
+	return nil
+}
+
+// totalSupply reads the token's total supply, reporting false when the
+// contract doesn't answer it
+func (ld *ListingDetector) totalSupply(ctx context.Context, token common.Address) (*big.Int, bool) {
+	result, err := ld.client.CallContract(ctx, ethereum.CallMsg{To: &token, Data: erc20TotalSupplySelector}, nil)
+	if err != nil || len(result) < 32 {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(result[:32]), true
+}
+
+// watched returns the tokens deployed within the watch window that pass the
+// filter. Callers must not hold ld.mu.
+func (ld *ListingDetector) watched(filter func(*TokenListing) bool) []common.Address {
+	ld.mu.RLock()
+	defer ld.mu.RUnlock()
+
+	cutoff := ld.now().Add(-listingWatchWindow)
+	var tokens []common.Address
+	for token, listing := range ld.listings {
+		if !listing.deployedAt.Before(cutoff) && filter(listing) {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Hex() < tokens[j].Hex() })
+	return tokens
+}
+
+// findTransfers lists the watched tokens first transferred within the blocks
+func (ld *ListingDetector) findTransfers(ctx context.Context, from, to uint64, blockTimes map[uint64]time.Time) error {
+	pending := ld.watched(func(listing *TokenListing) bool { return listing.ListedAt == nil })
+	if len(pending) == 0 {
+		return nil
+	}
+	logs, err := ld.client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: pending,
+		Topics:    [][]common.Hash{{transferTopic}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to filter transfers from block %d: %w", from, err)
+	}
+
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	for _, entry := range logs {
+		listing, ok := ld.listings[entry.Address]
+		// ERC-721 transfers share the signature with a fourth topic
+		if !ok || listing.ListedAt != nil || len(entry.Topics) != 3 {
+			continue
+		}
+		at := blockTimes[entry.BlockNumber]
+		listedAt := NewAPITime(at)
+		listing.ListedAt = &listedAt
+		listing.ListingBlock = entry.BlockNumber
+		listing.listedAt = at
+	}
+	return nil
+}
+
+// findLiquidity records the first liquidity added to a pool of each
+// watched token within the blocks
+func (ld *ListingDetector) findLiquidity(ctx context.Context, from, to uint64, blockTimes map[uint64]time.Time) error {
+	awaiting := ld.watched(func(listing *TokenListing) bool { return listing.Liquidity == nil })
+	if len(awaiting) == 0 {
+		return nil
+	}
+	watching := make(map[common.Address]bool, len(awaiting))
+	for _, token := range awaiting {
+		watching[token] = true
+	}
+	logs, err := ld.client.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Topics:    [][]common.Hash{{pairMintTopic}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to filter liquidity mints from block %d: %w", from, err)
+	}
+
+	for _, entry := range logs {
+		if len(entry.Data) < 64 {
+			continue
+		}
+		pool, err := ld.pools.Pool(ctx, entry.Address)
+		if errors.Is(err, ErrNotLiquidityPool) {
+			continue
+		}
+		if err != nil {
+			ld.logger.Printf("Failed to read pool %s: %v", entry.Address.Hex(), err)
+			continue
+		}
+
+		token, other, otherAmount := pool.Token0, pool.Token1, new(big.Int).SetBytes(entry.Data[32:64])
+		if !watching[token.Address] {
+			token, other, otherAmount = pool.Token1, pool.Token0, new(big.Int).SetBytes(entry.Data[:32])
+		}
+		if !watching[token.Address] {
+			continue
+		}
+
+		at := blockTimes[entry.BlockNumber]
+		liquidity := &ListingLiquidity{
+			Pool:    pool.Address,
+			Pair:    pool.Pair(),
+			Block:   entry.BlockNumber,
+			AddedAt: NewAPITime(at),
+		}
+		if price, err := ld.prices.PriceAt(ctx, other.Symbol, at); err == nil && price > 0 {
+			liquidity.ValueUSD = roundTo(2*weiToFloat(otherAmount, other.Decimals)*price, 2)
+			liquidity.Priced = true
+		}
+
+		ld.mu.Lock()
+		if listing, ok := ld.listings[token.Address]; ok && listing.Liquidity == nil {
+			listing.Liquidity = liquidity
+			classifyListing(listing)
+		}
+		ld.mu.Unlock()
+		delete(watching, token.Address)
+	}
+	return nil
+}
+
+// classifyListing flags unverified tokens with links in their names, or
+// without liquidity, as spam
+func classifyListing(listing *TokenListing) {
+	listing.SpamReasons = nil
+	if !listing.Verified {
+		if urlPattern.MatchString(listing.Name) || urlPattern.MatchString(listing.Symbol) {
+			listing.SpamReasons = append(listing.SpamReasons, SpamReasonURLInName)
+		}
+		if listing.Liquidity == nil {
+			listing.SpamReasons = append(listing.SpamReasons, SpamReasonNoLiquidity)
+		}
+	}
+	listing.Spam = len(listing.SpamReasons) > 0
+}
+
+// NewTokens returns the tokens listed since the given time, newest first.
+// Spam is left out unless includeSpam is set, and unverified tokens whose
+// initial liquidity is below minLiquidity USD always are.
+func (ld *ListingDetector) NewTokens(since time.Time, minLiquidity float64, includeSpam bool) []TokenListing {
+	ld.mu.RLock()
+	defer ld.mu.RUnlock()
+
+	listings := make([]TokenListing, 0)
+	for _, listing := range ld.listings {
+		if listing.ListedAt == nil || listing.listedAt.Before(since) {
+			continue
+		}
+		if listing.Spam && !includeSpam {
+			continue
+		}
+		if minLiquidity > 0 && !listing.Verified && listing.LiquidityUSD() < minLiquidity {
+			continue
+		}
+		copied := *listing
+		copied.SpamReasons = append([]string(nil), listing.SpamReasons...)
+		if listing.Liquidity != nil {
+			liquidity := *listing.Liquidity
+			copied.Liquidity = &liquidity
+		}
+		listings = append(listings, copied)
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		if !listings[i].listedAt.Equal(listings[j].listedAt) {
+			return listings[i].listedAt.After(listings[j].listedAt)
+		}
+		return listings[i].Token.Hex() < listings[j].Token.Hex()
+	})
+	return listings
+}
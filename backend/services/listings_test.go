@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listingChain serves blocks with contract deployments, their receipts,
+// transfer and mint logs, and the total supply of the tokens
+type listingChain struct {
+	ChainClient
+
+	blocks   map[uint64]*types.Block
+	receipts map[common.Hash]*types.Receipt
+	logs     []types.Log
+	supplies map[common.Address]*big.Int
+	head     uint64
+}
+
+func (lc *listingChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return lc.head, nil
+}
+
+func (lc *listingChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	block, ok := lc.blocks[number.Uint64()]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return block, nil
+}
+
+func (lc *listingChain) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	receipt, ok := lc.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (lc *listingChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	supply, ok := lc.supplies[*call.To]
+	if !ok {
+		return nil, errors.New("execution reverted")
+	}
+	return common.LeftPadBytes(supply.Bytes(), 32), nil
+}
+
+func (lc *listingChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	addresses := make(map[common.Address]bool, len(query.Addresses))
+	for _, address := range query.Addresses {
+		addresses[address] = true
+	}
+	var logs []types.Log
+	for _, entry := range lc.logs {
+		if entry.BlockNumber < query.FromBlock.Uint64() || entry.BlockNumber > query.ToBlock.Uint64() {
+			continue
+		}
+		if len(addresses) > 0 && !addresses[entry.Address] {
+			continue
+		}
+		if entry.Topics[0] != query.Topics[0][0] {
+			continue
+		}
+		logs = append(logs, entry)
+	}
+	return logs, nil
+}
+
+// listingMetadata answers the metadata of the tokens it knows, and fails
+// like a contract without decimals() for the others
+type listingMetadata map[common.Address]TokenMetadata
+
+func (m listingMetadata) Metadata(ctx context.Context, token common.Address) (TokenMetadata, error) {
+	metadata, ok := m[token]
+	if !ok {
+		return TokenMetadata{}, errors.New("execution reverted")
+	}
+	return metadata, nil
+}
+
+type listingPools map[common.Address]*LiquidityPool
+
+func (p listingPools) Pool(ctx context.Context, token common.Address) (*LiquidityPool, error) {
+	pool, ok := p[token]
+	if !ok {
+		return nil, ErrNotLiquidityPool
+	}
+	return pool, nil
+}
+
+// listingFixture is a chain with six deployments an hour ago: GOOD with
+// $10,000 of initial liquidity, a spam token named after a link with as
+// much, THIN with $200, DUST with none, a contract that isn't a token, and
+// a token that was never transferred
+type listingFixture struct {
+	chain    *listingChain
+	detector *ListingDetector
+	deployer common.Address
+	tokens   map[string]common.Address
+}
+
+var listingUSDT = common.HexToAddress("0xcee8faf64bb97a73bb51e115aa89c17ffa8dd167")
+
+func newListingFixture(t *testing.T) *listingFixture {
+	chainID := big.NewInt(8217)
+	signer := types.LatestSignerForChainID(chainID)
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	deployer := crypto.PubkeyToAddress(key.PublicKey)
+
+	chain := &listingChain{
+		blocks:   make(map[uint64]*types.Block),
+		receipts: make(map[common.Hash]*types.Receipt),
+		supplies: make(map[common.Address]*big.Int),
+		head:     10,
+	}
+	metadata := listingMetadata{}
+	pools := listingPools{}
+	fixture := &listingFixture{
+		chain:    chain,
+		deployer: deployer,
+		tokens:   make(map[string]common.Address),
+	}
+
+	start := time.Now().UTC().Add(-time.Hour)
+	deployments := []struct {
+		symbol, name string
+		token        bool
+	}{
+		{"GOOD", "Good Token", true},
+		{"CLAIM", "Claim at scam.io", true},
+		{"THIN", "Thin Token", true},
+		{"DUST", "Dust Token", true},
+		{"NFT", "Not a token", false},
+		{"IDLE", "Idle Token", true},
+	}
+	// One deployment per block, with an unrelated transfer in block 1
+	for i, deployment := range deployments {
+		number := uint64(i + 1)
+		tx := types.MustSignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     uint64(i),
+			GasTipCap: big.NewInt(gwei),
+			GasFeeCap: big.NewInt(50 * gwei),
+			Gas:       1_000_000,
+			Data:      []byte{0x60, 0x80},
+		})
+		txs := []*types.Transaction{tx}
+		if number == 1 {
+			txs = append(txs, types.MustSignNewTx(key, signer, &types.DynamicFeeTx{
+				ChainID: chainID, Nonce: 100, GasFeeCap: big.NewInt(50 * gwei), Gas: 21_000, To: &listingUSDT,
+			}))
+		}
+		contract := crypto.CreateAddress(deployer, uint64(i))
+		chain.receipts[tx.Hash()] = &types.Receipt{Status: types.ReceiptStatusSuccessful, ContractAddress: contract}
+		chain.blocks[number] = types.NewBlockWithHeader(&types.Header{
+			Number: new(big.Int).SetUint64(number),
+			Time:   uint64(start.Add(time.Duration(number) * time.Minute).Unix()),
+		}).WithBody(txs, nil)
+		fixture.tokens[deployment.symbol] = contract
+		if deployment.token {
+			metadata[contract] = TokenMetadata{Name: deployment.name, Symbol: deployment.symbol, Decimals: 18}
+			chain.supplies[contract] = new(big.Int).Mul(big.NewInt(1_000_000), big.NewInt(1e18))
+		}
+	}
+	for number := uint64(7); number <= chain.head; number++ {
+		chain.blocks[number] = types.NewBlockWithHeader(&types.Header{
+			Number: new(big.Int).SetUint64(number),
+			Time:   uint64(start.Add(time.Duration(number) * time.Minute).Unix()),
+		})
+	}
+
+	// Every token but IDLE is first transferred in block 7; the contract
+	// that isn't a token emits a transfer with a token ID
+	for _, symbol := range []string{"GOOD", "CLAIM", "THIN", "DUST"} {
+		chain.logs = append(chain.logs, listingTransfer(fixture.tokens[symbol], 7, false))
+	}
+	chain.logs = append(chain.logs, listingTransfer(fixture.tokens["NFT"], 7, true))
+
+	// Liquidity is added against USDT in block 9
+	for i, added := range []struct {
+		symbol string
+		usdt   int64
+	}{{"GOOD", 5000}, {"CLAIM", 5000}, {"THIN", 100}} {
+		pair := common.BigToAddress(big.NewInt(int64(0xbeef + i)))
+		pools[pair] = &LiquidityPool{
+			Address: pair,
+			Token0:  PoolToken{Symbol: "USDT", Address: listingUSDT, Decimals: 6},
+			Token1:  PoolToken{Symbol: added.symbol, Address: fixture.tokens[added.symbol], Decimals: 18},
+		}
+		data := append(
+			common.LeftPadBytes(big.NewInt(added.usdt*1e6).Bytes(), 32),
+			common.LeftPadBytes(new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18)).Bytes(), 32)...,
+		)
+		chain.logs = append(chain.logs, types.Log{
+			Address:     pair,
+			Topics:      []common.Hash{pairMintTopic, common.BytesToHash(deployer.Bytes())},
+			Data:        data,
+			BlockNumber: 9,
+		})
+	}
+
+	fixture.detector = NewListingDetector(chain, metadata, pools, fakePrices{"USDT": 1})
+	return fixture
+}
+
+func listingTransfer(token common.Address, block uint64, withTokenID bool) types.Log {
+	entry := types.Log{
+		Address:     token,
+		Topics:      []common.Hash{transferTopic, {}, common.BytesToHash(common.HexToAddress("0x1").Bytes())},
+		Data:        common.LeftPadBytes(big.NewInt(1e18).Bytes(), 32),
+		BlockNumber: block,
+	}
+	if withTokenID {
+		entry.Topics = append(entry.Topics, common.BigToHash(big.NewInt(1)))
+		entry.Data = nil
+	}
+	return entry
+}
+
+func TestListingDetectorFindsDeploymentsTransfersAndLiquidity(t *testing.T) {
+	fixture := newListingFixture(t)
+	since := time.Now().Add(-24 * time.Hour)
+
+	// The first poll stops before any liquidity is added
+	fixture.chain.head = 8
+	require.NoError(t, fixture.detector.Poll(context.Background()))
+	listings := fixture.detector.NewTokens(since, 0, true)
+	require.Len(t, listings, 4, "the contract that isn't a token and the untransferred token aren't listed")
+	for _, listing := range listings {
+		assert.Nil(t, listing.Liquidity)
+		assert.Contains(t, listing.SpamReasons, SpamReasonNoLiquidity)
+	}
+
+	fixture.chain.head = 10
+	require.NoError(t, fixture.detector.Poll(context.Background()))
+	listings = fixture.detector.NewTokens(since, 0, true)
+	require.Len(t, listings, 4)
+
+	byToken := make(map[common.Address]TokenListing)
+	for _, listing := range listings {
+		byToken[listing.Token] = listing
+	}
+	good := byToken[fixture.tokens["GOOD"]]
+	assert.Equal(t, "Good Token", good.Name)
+	assert.Equal(t, 1_000_000.0, good.TotalSupply)
+	assert.Equal(t, fixture.deployer, good.Deployer)
+	assert.Equal(t, uint64(1), good.DeployBlock)
+	assert.Equal(t, uint64(7), good.ListingBlock)
+	require.NotNil(t, good.Liquidity)
+	assert.Equal(t, "USDT/GOOD", good.Liquidity.Pair)
+	assert.Equal(t, uint64(9), good.Liquidity.Block)
+	assert.Equal(t, 10_000.0, good.Liquidity.ValueUSD, "twice the USDT leg")
+	assert.True(t, good.Liquidity.Priced)
+	assert.False(t, good.Spam)
+
+	claim := byToken[fixture.tokens["CLAIM"]]
+	assert.True(t, claim.Spam)
+	assert.Equal(t, []string{SpamReasonURLInName}, claim.SpamReasons)
+	assert.Equal(t, 200.0, byToken[fixture.tokens["THIN"]].LiquidityUSD())
+	assert.Equal(t, []string{SpamReasonNoLiquidity}, byToken[fixture.tokens["DUST"]].SpamReasons)
+}
+
+func TestNewTokensFiltersSpamAndThinLiquidity(t *testing.T) {
+	fixture := newListingFixture(t)
+	require.NoError(t, fixture.detector.Poll(context.Background()))
+	since := time.Now().Add(-24 * time.Hour)
+
+	symbols := func(listings []TokenListing) []string {
+		var names []string
+		for _, listing := range listings {
+			names = append(names, listing.Symbol)
+		}
+		return names
+	}
+
+	notable := fixture.detector.NewTokens(since, DefaultListingMinLiquidityUSD, false)
+	assert.Equal(t, []string{"GOOD"}, symbols(notable))
+	assert.ElementsMatch(t, []string{"GOOD", "THIN"}, symbols(fixture.detector.NewTokens(since, 0, false)))
+	assert.ElementsMatch(t, []string{"GOOD", "CLAIM"}, symbols(fixture.detector.NewTokens(since, DefaultListingMinLiquidityUSD, true)))
+	assert.Empty(t, fixture.detector.NewTokens(time.Now(), 0, true), "nothing was listed since now")
+
+	// Tokens from the token list are never spam nor held to the threshold
+	fixture.detector.mu.Lock()
+	dust := fixture.detector.listings[fixture.tokens["DUST"]]
+	dust.Verified = true
+	classifyListing(dust)
+	fixture.detector.mu.Unlock()
+	assert.ElementsMatch(t, []string{"GOOD", "DUST"}, symbols(fixture.detector.NewTokens(since, DefaultListingMinLiquidityUSD, false)))
+}
+
+func TestListingDetectorDropsListingsPastRetention(t *testing.T) {
+	fixture := newListingFixture(t)
+	require.NoError(t, fixture.detector.Poll(context.Background()))
+	require.NotEmpty(t, fixture.detector.NewTokens(time.Time{}, 0, true))
+
+	fixture.detector.now = func() time.Time { return time.Now().Add(ListingRetention) }
+	require.NoError(t, fixture.detector.Poll(context.Background()))
+	assert.Empty(t, fixture.detector.NewTokens(time.Time{}, 0, true))
+}
+
+func TestChatNewTokensIntent(t *testing.T) {
+	fixture := newListingFixture(t)
+	require.NoError(t, fixture.detector.Poll(context.Background()))
+	engine := newTestChatEngine(t)
+	engine.SetListingDetector(fixture.detector)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_1", UserID: "user_1", Message: "What new tokens launched this week?"})
+	require.NoError(t, err)
+	assert.Equal(t, "new_tokens", response.Type)
+	assert.True(t, response.Success)
+	assert.Contains(t, response.Response, "GOOD (Good Token)")
+	assert.Contains(t, response.Response, "$10,000 initial liquidity in USDT/GOOD")
+	assert.NotContains(t, response.Response, "THIN")
+	assert.NotContains(t, response.Response, "CLAIM")
+	assert.Contains(t, response.Response, "3 more were hidden as spam or with less than $1,000 of liquidity")
+	assert.Equal(t, 3, response.Metadata["hidden"])
+}
@@ -0,0 +1,474 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// LowLiquidityTVL is the pool TVL in USD below which LP positions are marked
+// high risk
+const LowLiquidityTVL = 10_000
+
+// ErrNotLiquidityPool is returned when a token isn't a pool's LP token
+var ErrNotLiquidityPool = errors.New("token is not a liquidity pool")
+
+// Function selectors of the Uniswap V2 style pair reads
+var (
+	pairToken0Selector       = crypto.Keccak256([]byte("token0()"))[:4]
+	pairToken1Selector       = crypto.Keccak256([]byte("token1()"))[:4]
+	pairGetReservesSelector  = crypto.Keccak256([]byte("getReserves()"))[:4]
+	pairKLastSelector        = crypto.Keccak256([]byte("kLast()"))[:4]
+	erc20TotalSupplySelector = crypto.Keccak256([]byte("totalSupply()"))[:4]
+	erc20SymbolSelector      = crypto.Keccak256([]byte("symbol()"))[:4]
+)
+
+// PoolToken is one leg of a liquidity pool
+type PoolToken struct {
+	Symbol   string         `json:"symbol"`
+	Address  common.Address `json:"address"`
+	Decimals int            `json:"decimals"`
+}
+
+// LiquidityPool is a pair contract whose token is a share of its reserves
+type LiquidityPool struct {
+	Address common.Address `json:"address"`
+	Token0  PoolToken      `json:"token0"`
+	Token1  PoolToken      `json:"token1"`
+}
+
+// Pair returns the pool's legs as "TOKEN0/TOKEN1"
+func (p *LiquidityPool) Pair() string {
+	return p.Token0.Symbol + "/" + p.Token1.Symbol
+}
+
+// LPExposure is the amount of one leg a position has a claim on
+type LPExposure struct {
+	Symbol   string  `json:"symbol"`
+	Amount   float64 `json:"amount"`
+	PriceUSD float64 `json:"price_usd"`
+	ValueUSD float64 `json:"value_usd"`
+	// PriceImplied is set when the leg has no USD price of its own and is
+	// priced from the pool's reserve ratio instead
+	PriceImplied bool `json:"price_implied,omitempty"`
+	// PriceSample is the stored price sample PriceUSD came from, unless the
+	// price is implied
+	PriceSample *PriceSample `json:"price_sample,omitempty"`
+}
+
+// LPPosition is the value of a holder's LP tokens and the pool reserves they
+// are a claim on
+type LPPosition struct {
+	Pool       string       `json:"pool"`
+	Pair       string       `json:"pair"`
+	Balance    float64      `json:"balance"`
+	Share      float64      `json:"share"`
+	ValueUSD   float64      `json:"value_usd"`
+	Exposure   []LPExposure `json:"exposure"`
+	PoolTVLUSD float64      `json:"pool_tvl_usd"`
+	HighRisk   bool         `json:"high_risk"`
+	// AccruedFeesUSD estimates the position's share of the swap fees earned
+	// since the pool's last mint or burn. It is only set for pools that
+	// expose kLast.
+	AccruedFeesUSD *float64 `json:"accrued_fees_usd,omitempty"`
+	// Unpriced is set when neither leg has a USD price
+	Unpriced bool `json:"unpriced,omitempty"`
+}
+
+// LiquidityPoolReader detects LP tokens and values positions in them. Which
+// tokens are pools is cached; reserves are read on every valuation.
+type LiquidityPoolReader struct {
+	caller    ethereum.ContractCaller
+	multicall *Multicall
+	prices    HistoricalPriceSource
+	known     map[common.Address]TrackedToken
+	now       func() time.Time
+
+	mu    sync.Mutex
+	pools map[common.Address]*LiquidityPool
+}
+
+// NewLiquidityPoolReader creates a reader. Pool legs among the tracked tokens
+// use their configured symbol and decimals; others are read from the token.
+func NewLiquidityPoolReader(caller ethereum.ContractCaller, tracked []TrackedToken, prices HistoricalPriceSource) *LiquidityPoolReader {
+	known := make(map[common.Address]TrackedToken, len(tracked))
+	for _, token := range tracked {
+		known[token.Address] = token
+	}
+	return &LiquidityPoolReader{
+		caller:    caller,
+		multicall: NewMulticall(caller, common.Address{}),
+		prices:    prices,
+		known:     known,
+		now:       utcNow,
+		pools:     make(map[common.Address]*LiquidityPool),
+	}
+}
+
+// SetMulticall batches the reads of a pool, and the reserves of the pools
+// scanned together, into one call
+func (r *LiquidityPoolReader) SetMulticall(multicall *Multicall) {
+	r.multicall = multicall
+}
+
+// Pool returns the pool of an LP token, or ErrNotLiquidityPool when the
+// token doesn't expose token0, token1, and getReserves
+func (r *LiquidityPoolReader) Pool(ctx context.Context, token common.Address) (*LiquidityPool, error) {
+	r.mu.Lock()
+	pool, cached := r.pools[token]
+	r.mu.Unlock()
+	if cached {
+		if pool == nil {
+			return nil, ErrNotLiquidityPool
+		}
+		return pool, nil
+	}
+
+	pool, err := r.detect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.pools[token] = pool
+	r.mu.Unlock()
+	if pool == nil {
+		return nil, ErrNotLiquidityPool
+	}
+	return pool, nil
+}
+
+// detect reads the pair functions of the token. Returns nil without an error
+// when the token isn't a pool.
+func (r *LiquidityPoolReader) detect(ctx context.Context, token common.Address) (*LiquidityPool, error) {
+	batch := r.multicall.Batch()
+	token0Call := batch.Call(token, pairToken0Selector)
+	token1Call := batch.Call(token, pairToken1Selector)
+	reservesCall := batch.Call(token, pairGetReservesSelector)
+	batch.Execute(ctx, nil)
+
+	token0, ok, err := callResult(token0Call, 32)
+	if err != nil || !ok {
+		return nil, err
+	}
+	token1, ok, err := callResult(token1Call, 32)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if _, ok, err := callResult(reservesCall, 64); err != nil || !ok {
+		return nil, err
+	}
+
+	pool := &LiquidityPool{Address: token}
+	if pool.Token0, err = r.poolToken(ctx, common.BytesToAddress(token0[:32])); err != nil {
+		return nil, err
+	}
+	if pool.Token1, err = r.poolToken(ctx, common.BytesToAddress(token1[:32])); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// poolToken describes a pool leg from the tracked tokens or the token itself
+func (r *LiquidityPoolReader) poolToken(ctx context.Context, address common.Address) (PoolToken, error) {
+	if tracked, ok := r.known[address]; ok {
+		return PoolToken{Symbol: tracked.Symbol, Address: address, Decimals: tracked.Decimals}, nil
+	}
+
+	token := PoolToken{Address: address, Decimals: r.decimals(ctx, address)}
+	result, ok, err := r.call(ctx, address, erc20SymbolSelector, nil, 32)
+	if err != nil {
+		return PoolToken{}, fmt.Errorf("failed to read symbol of %s: %w", address.Hex(), err)
+	}
+	if ok {
+		token.Symbol = strings.ToUpper(decodeABIString(result))
+	}
+	if token.Symbol == "" {
+		token.Symbol = address.Hex()
+	}
+	return token, nil
+}
+
+// Position values the holder's LP tokens in the pool
+func (r *LiquidityPoolReader) Position(ctx context.Context, token, holder common.Address) (*LPPosition, error) {
+	pool, err := r.Pool(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	result, ok, err := r.call(ctx, token, erc20BalanceOfSelector, common.LeftPadBytes(holder.Bytes(), 32), 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LP balance: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("failed to read LP balance of %s", holder.Hex())
+	}
+	return r.Value(ctx, pool, new(big.Int).SetBytes(result[:32]))
+}
+
+// Value values an LP token balance: its share of the pool's reserves, priced
+// per leg
+func (r *LiquidityPoolReader) Value(ctx context.Context, pool *LiquidityPool, balance *big.Int) (*LPPosition, error) {
+	batch := r.multicall.Batch()
+	reservesCall := batch.Call(pool.Address, pairGetReservesSelector)
+	supplyCall := batch.Call(pool.Address, erc20TotalSupplySelector)
+	kLastCall := batch.Call(pool.Address, pairKLastSelector)
+	batch.Execute(ctx, nil)
+
+	reserve0, reserve1, err := reservesResult(pool, reservesCall)
+	if err != nil {
+		return nil, err
+	}
+	supply, ok, err := callResult(supplyCall, 32)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("failed to read LP supply of %s: %w", pool.Address.Hex(), errOrRevert(err))
+	}
+
+	state := poolState{
+		reserve0:    reserve0,
+		reserve1:    reserve1,
+		totalSupply: new(big.Int).SetBytes(supply[:32]),
+	}
+	// kLast is zero unless the pool's protocol fee is on, in which case it is
+	// the reserve product at the last mint or burn
+	if kLast, ok, err := callResult(kLastCall, 32); err == nil && ok {
+		state.kLast = new(big.Int).SetBytes(kLast[:32])
+	}
+
+	now := r.now()
+	sample0, err0 := samplePrice(ctx, r.prices, pool.Token0.Symbol, now)
+	sample1, err1 := samplePrice(ctx, r.prices, pool.Token1.Symbol, now)
+	if err0 != nil {
+		sample0 = PriceSample{}
+	}
+	if err1 != nil {
+		sample1 = PriceSample{}
+	}
+
+	position := valueLPPosition(pool, state, balance, r.decimals(ctx, pool.Address), sample0.PriceUSD, sample1.PriceUSD)
+	samples := []PriceSample{sample0, sample1}
+	for i := range position.Exposure {
+		if !position.Exposure[i].PriceImplied && samples[i].Method != "" {
+			position.Exposure[i].PriceSample = &samples[i]
+		}
+	}
+	return position, nil
+}
+
+// Reserves reads the pool's current reserves of token0 and token1
+func (r *LiquidityPoolReader) Reserves(ctx context.Context, pool *LiquidityPool) (reserve0, reserve1 *big.Int, err error) {
+	batch := r.multicall.Batch()
+	reserves := batch.Call(pool.Address, pairGetReservesSelector)
+	batch.Execute(ctx, nil)
+	return reservesResult(pool, reserves)
+}
+
+// PoolReserves are a pool's reserves of token0 and token1, or the error
+// reading them
+type PoolReserves struct {
+	Reserve0 *big.Int
+	Reserve1 *big.Int
+	Err      error
+}
+
+// ReservesOf reads the current reserves of the pools in one batch, in the
+// order of the pools
+func (r *LiquidityPoolReader) ReservesOf(ctx context.Context, pools []*LiquidityPool) []PoolReserves {
+	batch := r.multicall.Batch()
+	calls := make([]*CallFuture, len(pools))
+	for i, pool := range pools {
+		calls[i] = batch.Call(pool.Address, pairGetReservesSelector)
+	}
+	batch.Execute(ctx, nil)
+
+	reserves := make([]PoolReserves, len(pools))
+	for i, pool := range pools {
+		reserves[i].Reserve0, reserves[i].Reserve1, reserves[i].Err = reservesResult(pool, calls[i])
+	}
+	return reserves
+}
+
+// reservesResult decodes the getReserves call of a pool
+func reservesResult(pool *LiquidityPool, call *CallFuture) (reserve0, reserve1 *big.Int, err error) {
+	reserves, ok, err := callResult(call, 64)
+	if err != nil || !ok {
+		return nil, nil, fmt.Errorf("failed to read reserves of %s: %w", pool.Address.Hex(), errOrRevert(err))
+	}
+	return new(big.Int).SetBytes(reserves[:32]), new(big.Int).SetBytes(reserves[32:64]), nil
+}
+
+// poolState is a pool's reserves and LP token supply at one block
+type poolState struct {
+	reserve0    *big.Int
+	reserve1    *big.Int
+	totalSupply *big.Int
+	kLast       *big.Int
+}
+
+// valueLPPosition values the holder's share of the pool. A leg without a USD
+// price is priced from the reserve ratio against the other leg.
+func valueLPPosition(pool *LiquidityPool, state poolState, balance *big.Int, lpDecimals int, price0, price1 float64) *LPPosition {
+	position := &LPPosition{
+		Pool:    strings.ToLower(pool.Address.Hex()),
+		Pair:    pool.Pair(),
+		Balance: weiToFloat(balance, lpDecimals),
+	}
+
+	reserve0 := weiToFloat(state.reserve0, pool.Token0.Decimals)
+	reserve1 := weiToFloat(state.reserve1, pool.Token1.Decimals)
+	implied0, implied1 := false, false
+	switch {
+	case price0 == 0 && price1 > 0 && reserve0 > 0:
+		price0, implied0 = reserve1*price1/reserve0, true
+	case price1 == 0 && price0 > 0 && reserve1 > 0:
+		price1, implied1 = reserve0*price0/reserve1, true
+	case price0 == 0 && price1 == 0:
+		position.Unpriced = true
+	}
+	position.PoolTVLUSD = reserve0*price0 + reserve1*price1
+	position.HighRisk = position.PoolTVLUSD < LowLiquidityTVL
+
+	if state.totalSupply.Sign() == 0 {
+		return position
+	}
+	share, _ := new(big.Rat).SetFrac(balance, state.totalSupply).Float64()
+	position.Share = share
+
+	// Each leg's amount is floor(reserve * balance / supply), as burn pays out
+	amount0 := new(big.Int).Div(new(big.Int).Mul(state.reserve0, balance), state.totalSupply)
+	amount1 := new(big.Int).Div(new(big.Int).Mul(state.reserve1, balance), state.totalSupply)
+	position.Exposure = []LPExposure{
+		{Symbol: pool.Token0.Symbol, Amount: weiToFloat(amount0, pool.Token0.Decimals), PriceUSD: price0, PriceImplied: implied0},
+		{Symbol: pool.Token1.Symbol, Amount: weiToFloat(amount1, pool.Token1.Decimals), PriceUSD: price1, PriceImplied: implied1},
+	}
+	for i := range position.Exposure {
+		exposure := &position.Exposure[i]
+		exposure.ValueUSD = exposure.Amount * exposure.PriceUSD
+		position.ValueUSD += exposure.ValueUSD
+	}
+
+	if state.kLast != nil && state.kLast.Sign() > 0 {
+		// Fees grow the reserve product between liquidity events, so the
+		// growth of its square root is the fee share of today's reserves
+		rootK := new(big.Int).Sqrt(new(big.Int).Mul(state.reserve0, state.reserve1))
+		rootKLast := new(big.Int).Sqrt(state.kLast)
+		if rootK.Cmp(rootKLast) > 0 {
+			growth, _ := new(big.Rat).SetFrac(new(big.Int).Sub(rootK, rootKLast), rootK).Float64()
+			fees := math.Round(position.ValueUSD*growth*100) / 100
+			position.AccruedFeesUSD = &fees
+		}
+	}
+	return position
+}
+
+// decimals reads a token's decimals, assuming 18 when it can't be read
+func (r *LiquidityPoolReader) decimals(ctx context.Context, token common.Address) int {
+	result, ok, err := r.call(ctx, token, erc20DecimalsSelector, nil, 32)
+	if err != nil || !ok {
+		return defaultTokenDecimals
+	}
+	decimals := new(big.Int).SetBytes(result[:32])
+	if !decimals.IsUint64() || decimals.Uint64() > 36 {
+		return defaultTokenDecimals
+	}
+	return int(decimals.Uint64())
+}
+
+// call calls a function of the contract. ok is false when the call reverts
+// or returns fewer than minLength bytes, which is how contracts without the
+// function respond.
+func (r *LiquidityPoolReader) call(ctx context.Context, contract common.Address, selector, args []byte, minLength int) (result []byte, ok bool, err error) {
+	data := append(append([]byte{}, selector...), args...)
+	result, err = r.caller.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	return checkCallResult(result, err, minLength)
+}
+
+// callResult reads a batched call the way call does
+func callResult(call *CallFuture, minLength int) (result []byte, ok bool, err error) {
+	result, err = call.Result()
+	return checkCallResult(result, err, minLength)
+}
+
+// checkCallResult sets ok to false for a call that reverted or returned
+// fewer than minLength bytes
+func checkCallResult(result []byte, err error, minLength int) ([]byte, bool, error) {
+	if err != nil {
+		if isRevert(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if len(result) < minLength {
+		return nil, false, nil
+	}
+	return result, true, nil
+}
+
+// errOrRevert describes a failed call whose error may be nil because the
+// contract reverted
+func errOrRevert(err error) error {
+	if err != nil {
+		return err
+	}
+	return errors.New("call reverted")
+}
+
+// decodeABIString decodes a string return value. Older tokens return their
+// symbol as a bytes32, which is decoded with its zero padding trimmed.
+func decodeABIString(result []byte) string {
+	if len(result) >= 64 {
+		offset := new(big.Int).SetBytes(result[:32])
+		if offset.IsUint64() && offset.Uint64()+32 <= uint64(len(result)) {
+			start := offset.Uint64()
+			length := new(big.Int).SetBytes(result[start : start+32])
+			if length.IsUint64() && start+32+length.Uint64() <= uint64(len(result)) {
+				return string(result[start+32 : start+32+length.Uint64()])
+			}
+		}
+	}
+	return strings.TrimRight(string(result[:32]), "\x00")
+}
+
+// YieldAlternative is what an LP position's value would earn in another
+// opportunity over a year
+type YieldAlternative struct {
+	Protocol           string  `json:"protocol"`
+	AssetPair          string  `json:"asset_pair"`
+	APY                float64 `json:"apy"`
+	Risk               float64 `json:"risk"`
+	ProjectedAnnualUSD float64 `json:"projected_annual_usd"`
+}
+
+// YieldComparison sets an existing LP position against the ranked yield
+// opportunities
+type YieldComparison struct {
+	Position     *LPPosition        `json:"position"`
+	Alternatives []YieldAlternative `json:"alternatives"`
+}
+
+// CompareYield projects the position's value into each opportunity, in the
+// order the opportunities are ranked
+func CompareYield(position *LPPosition, opportunities []YieldOpportunity) *YieldComparison {
+	comparison := &YieldComparison{
+		Position:     position,
+		Alternatives: make([]YieldAlternative, 0, len(opportunities)),
+	}
+	for _, opportunity := range opportunities {
+		comparison.Alternatives = append(comparison.Alternatives, YieldAlternative{
+			Protocol:           opportunity.Protocol,
+			AssetPair:          opportunity.AssetPair,
+			APY:                opportunity.APY,
+			Risk:               opportunity.Risk,
+			ProjectedAnnualUSD: math.Round(position.ValueUSD*opportunity.APY) / 100,
+		})
+	}
+	return comparison
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	lpPair   = common.HexToAddress("0x00000000000000000000000000000000000000f1")
+	lpUSDC   = common.HexToAddress("0x00000000000000000000000000000000000000f2")
+	lpWETH   = common.HexToAddress("0x00000000000000000000000000000000000000f3")
+	lpHolder = common.HexToAddress("0x00000000000000000000000000000000000000f4")
+)
+
+// fakePair answers the reads of a Uniswap V2 style pair and its legs. Any
+// other contract reverts, like a plain ERC-20 asked for token0.
+type fakePair struct {
+	reserve0, reserve1 *big.Int
+	supply             *big.Int
+	balance            *big.Int
+	kLast              *big.Int
+	calls              int
+}
+
+func (f *fakePair) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	f.calls++
+	word := func(value *big.Int) []byte { return common.LeftPadBytes(value.Bytes(), 32) }
+	selector := string(call.Data[:4])
+
+	switch *call.To {
+	case lpPair:
+		switch selector {
+		case string(pairToken0Selector):
+			return common.LeftPadBytes(lpUSDC.Bytes(), 32), nil
+		case string(pairToken1Selector):
+			return common.LeftPadBytes(lpWETH.Bytes(), 32), nil
+		case string(pairGetReservesSelector):
+			return append(append(word(f.reserve0), word(f.reserve1)...), word(big.NewInt(1700000000))...), nil
+		case string(erc20TotalSupplySelector):
+			return word(f.supply), nil
+		case string(erc20BalanceOfSelector):
+			return word(f.balance), nil
+		case string(erc20DecimalsSelector):
+			return word(big.NewInt(18)), nil
+		case string(pairKLastSelector):
+			if f.kLast == nil {
+				return nil, errors.New("execution reverted")
+			}
+			return word(f.kLast), nil
+		}
+	case lpWETH:
+		switch selector {
+		case string(erc20DecimalsSelector):
+			return word(big.NewInt(18)), nil
+		case string(erc20SymbolSelector):
+			// symbol() as an ABI encoded string
+			encoded := append(word(big.NewInt(32)), word(big.NewInt(4))...)
+			return append(encoded, common.RightPadBytes([]byte("weth"), 32)...), nil
+		}
+	}
+	return nil, errors.New("execution reverted")
+}
+
+// units scales n to a token amount with the given decimals
+func units(n int64, decimals int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil))
+}
+
+// newFiftyFiftyPair is a pool of 1,000,000 USDC and 500 WETH at $2,000,
+// $2M TVL, with the holder owning 10 of 1,000 LP tokens
+func newFiftyFiftyPair() *fakePair {
+	return &fakePair{
+		reserve0: units(1_000_000, 6),
+		reserve1: units(500, 18),
+		supply:   units(1_000, 18),
+		balance:  units(10, 18),
+	}
+}
+
+// lpValuedAt is when the test pool readers value positions
+var lpValuedAt = time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+
+func newTestPoolReader(pair *fakePair, prices fakePrices) *LiquidityPoolReader {
+	reader := NewLiquidityPoolReader(pair, []TrackedToken{{Symbol: "USDC", Address: lpUSDC, Decimals: 6}}, prices)
+	reader.now = func() time.Time { return lpValuedAt }
+	return reader
+}
+
+func TestLPPositionSplitsIntoLegs(t *testing.T) {
+	pair := newFiftyFiftyPair()
+	reader := newTestPoolReader(pair, fakePrices{"USDC": 1, "WETH": 2000})
+
+	position, err := reader.Position(context.Background(), lpPair, lpHolder)
+	require.NoError(t, err)
+	assert.Equal(t, "USDC/WETH", position.Pair)
+	assert.Equal(t, 10.0, position.Balance)
+	assert.InDelta(t, 0.01, position.Share, 1e-12)
+	assert.InDelta(t, 2_000_000, position.PoolTVLUSD, 1e-6)
+	assert.False(t, position.HighRisk)
+	assert.Nil(t, position.AccruedFeesUSD)
+
+	// 1% of each reserve, worth half the position each
+	require.Len(t, position.Exposure, 2)
+	reported := func(symbol string, price float64) *PriceSample {
+		return &PriceSample{Symbol: symbol, At: NewAPITime(lpValuedAt), PriceUSD: price, Method: PriceSampleReported}
+	}
+	assert.Equal(t, LPExposure{Symbol: "USDC", Amount: 10_000, PriceUSD: 1, ValueUSD: 10_000, PriceSample: reported("USDC", 1)}, position.Exposure[0])
+	assert.Equal(t, LPExposure{Symbol: "WETH", Amount: 5, PriceUSD: 2000, ValueUSD: 10_000, PriceSample: reported("WETH", 2000)}, position.Exposure[1])
+	assert.InDelta(t, 20_000, position.ValueUSD, 1e-6)
+
+	// Which tokens are pools is cached; reserves are not
+	calls := pair.calls
+	pair.reserve1 = units(400, 18)
+	position, err = reader.Position(context.Background(), lpPair, lpHolder)
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, position.Exposure[1].Amount)
+	assert.Less(t, pair.calls-calls, calls)
+
+	_, err = reader.Position(context.Background(), lpUSDC, lpHolder)
+	assert.ErrorIs(t, err, ErrNotLiquidityPool)
+}
+
+func TestLPPositionImpliedPriceRiskAndFees(t *testing.T) {
+	// Without a WETH price, WETH is priced from the reserve ratio
+	reader := newTestPoolReader(newFiftyFiftyPair(), fakePrices{"USDC": 1})
+	position, err := reader.Position(context.Background(), lpPair, lpHolder)
+	require.NoError(t, err)
+	assert.True(t, position.Exposure[1].PriceImplied)
+	assert.InDelta(t, 2000, position.Exposure[1].PriceUSD, 1e-9)
+	assert.InDelta(t, 20_000, position.ValueUSD, 1e-6)
+
+	// A pool under $10k TVL is high risk
+	small := newFiftyFiftyPair()
+	small.reserve0 = units(4_000, 6)
+	small.reserve1 = units(2, 18)
+	position, err = newTestPoolReader(small, fakePrices{"USDC": 1, "WETH": 2000}).Position(context.Background(), lpPair, lpHolder)
+	require.NoError(t, err)
+	assert.InDelta(t, 8_000, position.PoolTVLUSD, 1e-6)
+	assert.True(t, position.HighRisk)
+
+	// Reserves 1% above kLast in sqrt(k) terms mean about 1% of the value is fees
+	withFees := newFiftyFiftyPair()
+	rootKLast := new(big.Int).Sqrt(new(big.Int).Mul(withFees.reserve0, withFees.reserve1))
+	rootKLast.Mul(rootKLast, big.NewInt(100)).Div(rootKLast, big.NewInt(101))
+	withFees.kLast = new(big.Int).Mul(rootKLast, rootKLast)
+	position, err = newTestPoolReader(withFees, fakePrices{"USDC": 1, "WETH": 2000}).Position(context.Background(), lpPair, lpHolder)
+	require.NoError(t, err)
+	require.NotNil(t, position.AccruedFeesUSD)
+	assert.InDelta(t, 20_000.0/101, *position.AccruedFeesUSD, 0.01)
+}
+
+func TestTokenBalancesValueLPTokens(t *testing.T) {
+	pair := newFiftyFiftyPair()
+	prices := fakePrices{"USDC": 1, "WETH": 2000}
+	tokens := []TrackedToken{{Symbol: "KLP", Address: lpPair, Decimals: 18}}
+	reader := NewERC20BalanceReader(pair, tokens, prices)
+
+	holdings, err := reader.TokenBalances(context.Background(), lpHolder)
+	require.NoError(t, err)
+	require.Len(t, holdings, 1)
+	assert.Zero(t, holdings[0].ValueUSD, "LP tokens have no price of their own")
+
+	reader.SetLiquidityPools(newTestPoolReader(pair, prices))
+	holdings, err = reader.TokenBalances(context.Background(), lpHolder)
+	require.NoError(t, err)
+	require.NotNil(t, holdings[0].LPPosition)
+	assert.InDelta(t, 20_000, holdings[0].ValueUSD, 1e-6)
+	assert.InDelta(t, 2_000, holdings[0].PriceUSD, 1e-9)
+}
+
+func TestCompareYieldProjectsPositionValue(t *testing.T) {
+	position := &LPPosition{Pair: "USDC/WETH", ValueUSD: 20_000}
+	comparison := CompareYield(position, []YieldOpportunity{
+		{Protocol: "Aave V3", AssetPair: "USDC/ETH", APY: 8.2, Risk: 0.2},
+		{Protocol: "Compound V3", AssetPair: "DAI/USDC", APY: 6.8, Risk: 0.15},
+	})
+	assert.Same(t, position, comparison.Position)
+	require.Len(t, comparison.Alternatives, 2)
+	assert.Equal(t, 1640.0, comparison.Alternatives[0].ProjectedAnnualUSD)
+	assert.Equal(t, 1360.0, comparison.Alternatives[1].ProjectedAnnualUSD)
+}
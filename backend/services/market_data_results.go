@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// MaxMarketDataConcurrency bounds the symbols CollectMarketData fetches at
+// once, so large requests don't flood the rate-limited price API
+const MaxMarketDataConcurrency = 8
+
+// ErrSymbolNotFound is returned for symbols the price source has no market
+// data for
+var ErrSymbolNotFound = errors.New("no market data for symbol")
+
+// Statuses of a symbol's market data
+const (
+	// MarketDataOK is a symbol whose market data was fetched
+	MarketDataOK = "ok"
+	// MarketDataNotFound is a symbol the price source doesn't know
+	MarketDataNotFound = "not_found"
+	// MarketDataUpstreamError is a symbol whose fetch failed
+	MarketDataUpstreamError = "upstream_error"
+)
+
+// UpstreamError is a failed request to an external API. StatusCode is zero
+// when no response arrived.
+type UpstreamError struct {
+	Service    string
+	StatusCode int
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s returned %d: %v", e.Service, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s unreachable: %v", e.Service, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request may pass when retried: rate limits,
+// server errors and requests that got no response
+func (e *UpstreamError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// SymbolMarketData is the outcome of collecting one symbol's market data.
+// Data is set when Status is MarketDataOK and Error otherwise.
+type SymbolMarketData struct {
+	Symbol string      `json:"symbol"`
+	Status string      `json:"status"`
+	Data   *MarketData `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// Retryable is set on upstream errors that may pass when retried
+	Retryable bool `json:"retryable,omitempty"`
+}
+
+// newSymbolMarketData classifies the outcome of fetching a symbol
+func newSymbolMarketData(symbol string, data *MarketData, err error) SymbolMarketData {
+	result := SymbolMarketData{Symbol: symbol, Status: MarketDataOK, Data: data}
+	if err == nil {
+		return result
+	}
+
+	result.Data = nil
+	result.Error = err.Error()
+	var upstream *UpstreamError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrSymbolNotFound):
+		result.Status = MarketDataNotFound
+	case errors.As(err, &upstream):
+		result.Status = MarketDataUpstreamError
+		result.Retryable = upstream.Retryable()
+	default:
+		result.Status = MarketDataUpstreamError
+		result.Retryable = errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	}
+	return result
+}
+
+// MarketDataResults are the per-symbol outcomes of CollectMarketData, in the
+// order the symbols were asked for
+type MarketDataResults []SymbolMarketData
+
+// Data returns the market data of the symbols that were fetched
+func (r MarketDataResults) Data() []MarketData {
+	data := make([]MarketData, 0, len(r))
+	for _, result := range r {
+		if result.Status == MarketDataOK {
+			data = append(data, *result.Data)
+		}
+	}
+	return data
+}
+
+// Failed returns the outcomes of the symbols that weren't fetched
+func (r MarketDataResults) Failed() []SymbolMarketData {
+	var failed []SymbolMarketData
+	for _, result := range r {
+		if result.Status != MarketDataOK {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err returns the first failure when no symbol was fetched, for callers
+// that can't use partial results
+func (r MarketDataResults) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 || len(failed) < len(r) {
+		return nil
+	}
+	return fmt.Errorf("no market data for any of %d symbols: %s: %s", len(r), failed[0].Symbol, failed[0].Error)
+}
+
+// describeUnpriced names the symbols that couldn't be priced and why, for
+// chat. Empty when every symbol was priced.
+func describeUnpriced(failed []SymbolMarketData) string {
+	if len(failed) == 0 {
+		return ""
+	}
+	reasons := make([]string, len(failed))
+	for i, result := range failed {
+		switch {
+		case result.Status == MarketDataNotFound:
+			reasons[i] = result.Symbol + " (no market data)"
+		case result.Retryable:
+			reasons[i] = result.Symbol + " (price source unavailable, try again shortly)"
+		default:
+			reasons[i] = result.Symbol + " (price source error)"
+		}
+	}
+	return "⚠️ I couldn't price " + strings.Join(reasons, ", ") + ".\n"
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyMarketSource serves market data for known symbols, fails the
+// symbols it is told to, and tracks how many fetches run at once
+type flakyMarketSource struct {
+	known    map[string]float64
+	failures map[string]error
+	delay    time.Duration
+
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (s *flakyMarketSource) fetch(ctx context.Context, symbol string) (*MarketData, error) {
+	current := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		seen := s.maxInFlight.Load()
+		if current <= seen || s.maxInFlight.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+
+	if err, ok := s.failures[symbol]; ok {
+		return nil, err
+	}
+	price, ok := s.known[symbol]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSymbolNotFound, symbol)
+	}
+	return &MarketData{Symbol: symbol, Price: price, Timestamp: NewAPITime(utcNow())}, nil
+}
+
+func TestCollectMarketDataReportsEachSymbol(t *testing.T) {
+	source := &flakyMarketSource{
+		known: map[string]float64{"ETH": 3200, "KAIA": 0.15, "USDC": 1},
+		failures: map[string]error{
+			"KAIA": &UpstreamError{Service: "coingecko", StatusCode: http.StatusTooManyRequests, Err: errors.New("rate limited")},
+			"USDC": &UpstreamError{Service: "coingecko", StatusCode: http.StatusBadRequest, Err: errors.New("bad request")},
+			"WETH": fmt.Errorf("decode response: %w", context.DeadlineExceeded),
+		},
+	}
+	collector := NewDataCollector(nil)
+	collector.referenceSource = source.fetch
+
+	results := collector.CollectMarketData(context.Background(), []string{"ETH", "DAI", "KAIA", "USDC", "WETH"})
+	require.Len(t, results, 5)
+
+	statuses := make(map[string]string)
+	retryable := make(map[string]bool)
+	for _, result := range results {
+		statuses[result.Symbol] = result.Status
+		retryable[result.Symbol] = result.Retryable
+	}
+	assert.Equal(t, map[string]string{
+		"ETH":  MarketDataOK,
+		"DAI":  MarketDataNotFound,
+		"KAIA": MarketDataUpstreamError,
+		"USDC": MarketDataUpstreamError,
+		"WETH": MarketDataUpstreamError,
+	}, statuses)
+	assert.Equal(t, map[string]bool{"ETH": false, "DAI": false, "KAIA": true, "USDC": false, "WETH": true}, retryable)
+
+	// Results keep the order symbols were asked in
+	assert.Equal(t, "ETH", results[0].Symbol)
+	assert.Equal(t, 3200.0, results[0].Data.Price)
+	require.Len(t, results.Data(), 1)
+	assert.Len(t, results.Failed(), 4)
+	assert.NoError(t, results.Err(), "one symbol was priced")
+	assert.Contains(t, results[1].Error, "no market data")
+	assert.Nil(t, results[1].Data)
+
+	failed := collector.CollectMarketData(context.Background(), []string{"DAI"})
+	assert.ErrorContains(t, failed.Err(), "DAI")
+}
+
+func TestCollectMarketDataBoundsConcurrency(t *testing.T) {
+	source := &flakyMarketSource{known: make(map[string]float64), delay: 5 * time.Millisecond}
+	symbols := make([]string, 200)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("T%d", i)
+		source.known[symbols[i]] = float64(i)
+	}
+	collector := NewDataCollector(nil)
+	collector.referenceSource = source.fetch
+
+	results := collector.CollectMarketData(context.Background(), symbols)
+	assert.Len(t, results.Data(), 200)
+	assert.Equal(t, int32(MaxMarketDataConcurrency), source.maxInFlight.Load())
+}
+
+func TestCollectMarketDataStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var once sync.Once
+	collector := NewDataCollector(nil)
+	collector.referenceSource = func(ctx context.Context, symbol string) (*MarketData, error) {
+		once.Do(cancel)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	symbols := make([]string, 3*MaxMarketDataConcurrency)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("T%d", i)
+	}
+	results := collector.CollectMarketData(ctx, symbols)
+	require.Len(t, results, len(symbols))
+	for _, result := range results {
+		assert.Equal(t, MarketDataUpstreamError, result.Status, result.Symbol)
+		assert.False(t, result.Retryable)
+	}
+}
+
+func TestChatMarketDataNamesUnpricedSymbols(t *testing.T) {
+	engine := newTestChatEngine(t)
+	source := &flakyMarketSource{
+		known:    map[string]float64{"ETH": 3200, "USDC": 1},
+		failures: map[string]error{"USDC": &UpstreamError{Service: "coingecko", Err: errors.New("connection reset")}},
+	}
+	engine.dataCollector.referenceSource = source.fetch
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "0xuser", Message: "What is the market price of ETH?"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Response, "**ETH**: $3,200.00")
+	assert.Contains(t, response.Response, "⚠️ I couldn't price USDC (price source unavailable, try again shortly), DAI (no market data).")
+	assert.Len(t, response.Metadata["unpriced"], 2)
+}
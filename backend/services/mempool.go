@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// MempoolPollInterval is how often new blocks are read for inclusions
+	MempoolPollInterval = 3 * time.Second
+	// PendingTxDropAfter is how long a pending transaction waits for a block
+	// before the node is asked whether it still has it
+	PendingTxDropAfter = 10 * time.Minute
+	// PendingTxMaxAge is how long a pending transaction the node still holds
+	// is followed before it is given up as dropped
+	PendingTxMaxAge = 3 * time.Hour
+
+	// mempoolMaxCatchUp bounds the blocks read in one poll after downtime
+	mempoolMaxCatchUp = 20
+	// mempoolSettledTTL is how long alerted hashes are remembered, so a
+	// transaction seen again isn't alerted twice
+	mempoolSettledTTL = 6 * time.Hour
+	// mempoolResubscribeDelay is the wait before a lost pending transaction
+	// subscription is opened again
+	mempoolResubscribeDelay = 10 * time.Second
+)
+
+// Transaction alert states. A transaction seen in the mempool is alerted as
+// pending, then confirmed or dropped; one first seen in a block is alerted
+// as confirmed only.
+const (
+	TxAlertPending   = "pending"
+	TxAlertConfirmed = "confirmed"
+	TxAlertDropped   = "dropped"
+)
+
+// TxAlert tells a user about a transaction from or to an address they watch
+type TxAlert struct {
+	UserID string `json:"user_id"`
+	State  string `json:"state"`
+	Hash   string `json:"hash"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	// Value is the native value moved, in KAIA
+	Value float64 `json:"value"`
+	// Address is the watched address, Direction whether the transaction is
+	// incoming to it or outgoing from it
+	Address     string  `json:"address"`
+	Label       string  `json:"label,omitempty"`
+	Direction   string  `json:"direction"`
+	BlockNumber uint64  `json:"block_number,omitempty"`
+	At          APITime `json:"at"`
+}
+
+// PendingTxSource streams the transactions entering a node's mempool.
+// FailoverClient satisfies it when an endpoint serves subscriptions.
+type PendingTxSource interface {
+	SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error)
+}
+
+// pendingTx is a transaction alerted as pending, awaiting its outcome
+type pendingTx struct {
+	alerts []TxAlert
+	seenAt time.Time
+}
+
+// MempoolWatcher alerts users to transactions of the addresses they watch.
+// With a pending transaction source, transactions are alerted as they enter
+// the mempool and followed up once they are mined or dropped; without one,
+// or when the node doesn't serve pending transactions, they are alerted
+// once mined.
+type MempoolWatcher struct {
+	client    ChainClient
+	watchlist *Watchlist
+	notifier  SigningNotifier
+	pending   PendingTxSource
+
+	mu        sync.Mutex
+	tracked   map[common.Hash]*pendingTx
+	settled   map[common.Hash]time.Time
+	lastBlock uint64
+	// subscribed is whether pending transactions are being received
+	subscribed atomic.Bool
+
+	logger *log.Logger
+	now    func() time.Time
+}
+
+// NewMempoolWatcher creates a watcher alerting the watchlist's users through
+// the notifier
+func NewMempoolWatcher(client ChainClient, watchlist *Watchlist, notifier SigningNotifier) *MempoolWatcher {
+	return &MempoolWatcher{
+		client:    client,
+		watchlist: watchlist,
+		notifier:  notifier,
+		tracked:   make(map[common.Hash]*pendingTx),
+		settled:   make(map[common.Hash]time.Time),
+		logger:    log.New(log.Writer(), "[MempoolWatcher] ", log.LstdFlags),
+		now:       utcNow,
+	}
+}
+
+// SetPendingSource alerts transactions from the mempool, before they are mined
+func (w *MempoolWatcher) SetPendingSource(source PendingTxSource) {
+	w.pending = source
+}
+
+// Subscribed reports whether pending transactions are being received
+func (w *MempoolWatcher) Subscribed() bool {
+	return w.subscribed.Load()
+}
+
+// Start follows the mempool and new blocks until ctx is cancelled
+func (w *MempoolWatcher) Start(ctx context.Context) {
+	if w.pending != nil {
+		go w.subscribe(ctx)
+	}
+	go func() {
+		ticker := time.NewTicker(MempoolPollInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := w.Poll(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Printf("Failed to poll blocks: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// subscribe feeds pending transactions to HandlePending, resubscribing when
+// the subscription is lost. A node refusing the subscription turns mempool
+// alerts off; transactions are then alerted once mined.
+func (w *MempoolWatcher) subscribe(ctx context.Context) {
+	txs := make(chan *types.Transaction, 256)
+	for {
+		sub, err := w.pending.SubscribeFullPendingTransactions(ctx, txs)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.Printf("Pending transactions unavailable, alerting transactions once mined: %v", err)
+			}
+			return
+		}
+		w.subscribed.Store(true)
+
+		lost := func() bool {
+			defer sub.Unsubscribe()
+			for {
+				select {
+				case <-ctx.Done():
+					return false
+				case err := <-sub.Err():
+					w.logger.Printf("Pending transaction subscription lost: %v", err)
+					return true
+				case tx := <-txs:
+					w.HandlePending(tx)
+				}
+			}
+		}()
+		w.subscribed.Store(false)
+		if !lost {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(mempoolResubscribeDelay):
+		}
+	}
+}
+
+// HandlePending alerts the watchers of a transaction entering the mempool.
+// Transactions already alerted are ignored.
+func (w *MempoolWatcher) HandlePending(tx *types.Transaction) {
+	alerts := w.match(tx, TxAlertPending)
+	if len(alerts) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	_, tracked := w.tracked[tx.Hash()]
+	_, settled := w.settled[tx.Hash()]
+	if tracked || settled {
+		w.mu.Unlock()
+		return
+	}
+	w.tracked[tx.Hash()] = &pendingTx{alerts: alerts, seenAt: w.now()}
+	w.mu.Unlock()
+
+	w.deliver(alerts)
+}
+
+// HandleBlock follows up the pending transactions the block includes, and
+// alerts the watchers of its other transactions as confirmed
+func (w *MempoolWatcher) HandleBlock(block *types.Block) {
+	var alerts []TxAlert
+	for _, tx := range block.Transactions() {
+		w.mu.Lock()
+		pending, tracked := w.tracked[tx.Hash()]
+		_, settled := w.settled[tx.Hash()]
+		delete(w.tracked, tx.Hash())
+		w.mu.Unlock()
+
+		var txAlerts []TxAlert
+		switch {
+		case tracked:
+			txAlerts = pending.settle(TxAlertConfirmed, block.NumberU64(), w.now())
+		case !settled:
+			txAlerts = w.match(tx, TxAlertConfirmed)
+			for i := range txAlerts {
+				txAlerts[i].BlockNumber = block.NumberU64()
+			}
+		}
+		if len(txAlerts) == 0 {
+			continue
+		}
+		w.mu.Lock()
+		w.settled[tx.Hash()] = w.now()
+		w.mu.Unlock()
+		alerts = append(alerts, txAlerts...)
+	}
+	w.deliver(alerts)
+}
+
+// settle returns the follow-ups of the transaction's pending alerts
+func (p *pendingTx) settle(state string, block uint64, at time.Time) []TxAlert {
+	alerts := make([]TxAlert, len(p.alerts))
+	for i, alert := range p.alerts {
+		alert.State = state
+		alert.BlockNumber = block
+		alert.At = NewAPITime(at)
+		alerts[i] = alert
+	}
+	return alerts
+}
+
+// Poll reads the blocks mined since the last poll for inclusions, then
+// settles the pending transactions waiting too long. A failed poll is
+// retried from the same block.
+func (w *MempoolWatcher) Poll(ctx context.Context) error {
+	head, err := w.client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get latest block number: %w", err)
+	}
+
+	w.mu.Lock()
+	last := w.lastBlock
+	w.mu.Unlock()
+	from := last + 1
+	switch {
+	case last == 0:
+		// The first poll starts from the head rather than genesis
+		from = head
+	case head >= mempoolMaxCatchUp && from+mempoolMaxCatchUp <= head:
+		from = head - mempoolMaxCatchUp + 1
+	}
+
+	for number := from; number <= head; number++ {
+		block, err := w.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %w", number, err)
+		}
+		w.HandleBlock(block)
+		w.mu.Lock()
+		w.lastBlock = number
+		w.mu.Unlock()
+	}
+
+	w.expire(ctx)
+	return nil
+}
+
+// expire settles the pending transactions not mined within
+// PendingTxDropAfter: dropped once the node no longer has them or they are
+// older than PendingTxMaxAge, confirmed when mined in a block not read
+func (w *MempoolWatcher) expire(ctx context.Context) {
+	now := w.now()
+	w.mu.Lock()
+	waiting := make(map[common.Hash]*pendingTx)
+	for hash, pending := range w.tracked {
+		if now.Sub(pending.seenAt) >= PendingTxDropAfter {
+			waiting[hash] = pending
+		}
+	}
+	for hash, settledAt := range w.settled {
+		if now.Sub(settledAt) >= mempoolSettledTTL {
+			delete(w.settled, hash)
+		}
+	}
+	w.mu.Unlock()
+
+	var alerts []TxAlert
+	for hash, pending := range waiting {
+		state, block, ok := w.outcome(ctx, hash, now.Sub(pending.seenAt))
+		if !ok {
+			continue
+		}
+		w.mu.Lock()
+		_, still := w.tracked[hash]
+		delete(w.tracked, hash)
+		w.settled[hash] = now
+		w.mu.Unlock()
+		if still {
+			alerts = append(alerts, pending.settle(state, block, now)...)
+		}
+	}
+	w.deliver(alerts)
+}
+
+// outcome asks the node what became of a pending transaction, reporting
+// false while it is still pending, or can't be told
+func (w *MempoolWatcher) outcome(ctx context.Context, hash common.Hash, age time.Duration) (string, uint64, bool) {
+	_, isPending, err := w.client.TransactionByHash(ctx, hash)
+	switch {
+	case errors.Is(err, ethereum.NotFound):
+		return TxAlertDropped, 0, true
+	case err != nil:
+		w.logger.Printf("Failed to look up pending transaction %s: %v", hash.Hex(), err)
+		return "", 0, false
+	case isPending:
+		return TxAlertDropped, 0, age >= PendingTxMaxAge
+	}
+	receipt, err := w.client.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return TxAlertConfirmed, 0, true
+	}
+	return TxAlertConfirmed, receipt.BlockNumber.Uint64(), true
+}
+
+// match returns an alert for each user watching the transaction's sender or
+// recipient whose rule it meets, one per user
+func (w *MempoolWatcher) match(tx *types.Transaction, state string) []TxAlert {
+	var from common.Address
+	var hasFrom bool
+	if sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+		from, hasFrom = sender, true
+	}
+
+	alert := TxAlert{
+		State: state,
+		Hash:  tx.Hash().Hex(),
+		Value: weiToFloat(tx.Value(), 18),
+		At:    NewAPITime(w.now()),
+	}
+	if hasFrom {
+		alert.From = from.Hex()
+	}
+	if tx.To() != nil {
+		alert.To = tx.To().Hex()
+	}
+
+	var alerts []TxAlert
+	alerted := make(map[string]bool)
+	add := func(address common.Address, direction string) {
+		for _, watched := range w.watchlist.Watchers(address) {
+			if alerted[watched.UserID] || !watched.Matches(tx.Value()) {
+				continue
+			}
+			alerted[watched.UserID] = true
+			userAlert := alert
+			userAlert.UserID = watched.UserID
+			userAlert.Address = watched.Address
+			userAlert.Label = watched.Label
+			userAlert.Direction = direction
+			alerts = append(alerts, userAlert)
+		}
+	}
+	if hasFrom {
+		add(from, "outgoing")
+	}
+	if tx.To() != nil {
+		add(*tx.To(), "incoming")
+	}
+	return alerts
+}
+
+// deliver tells each alert's user about it through chat
+func (w *MempoolWatcher) deliver(alerts []TxAlert) {
+	if len(alerts) > 0 && w.notifier == nil {
+		w.logger.Printf("Failed to deliver %d transaction alerts: no notifier", len(alerts))
+		return
+	}
+	for _, alert := range alerts {
+		now := w.now()
+		err := w.notifier.SendToUser(alert.UserID, &ChatResponse{
+			ID:            fmt.Sprintf("tx_alert_%d", now.UnixNano()),
+			Type:          "tx_alert",
+			Response:      describeTxAlert(alert),
+			Data:          alert,
+			Timestamp:     NewAPITime(now),
+			TimestampUnix: now.Unix(),
+			Success:       true,
+		})
+		if err != nil {
+			w.logger.Printf("Failed to deliver %s alert for %s: %v", alert.State, alert.Hash, err)
+		}
+	}
+}
+
+// describeTxAlert is the chat text of a transaction alert
+func describeTxAlert(alert TxAlert) string {
+	party := func(address string) string {
+		if strings.EqualFold(address, alert.Address) && alert.Label != "" {
+			return alert.Label
+		}
+		return shortAddress(address)
+	}
+	movement := NumberFormat{}.Token(alert.Value, 0) + " KAIA"
+	if alert.From != "" {
+		movement += " from " + party(alert.From)
+	}
+	if alert.To != "" {
+		movement += " to " + party(alert.To)
+	}
+
+	var text strings.Builder
+	switch alert.State {
+	case TxAlertPending:
+		text.WriteString("⏳ **Pending Transaction**\n\n" + movement + " is waiting to be mined.\n")
+		text.WriteString("I'll let you know once it's mined or dropped.\n")
+	case TxAlertConfirmed:
+		text.WriteString("✅ **Transaction Confirmed**\n\n" + movement + " was mined")
+		if alert.BlockNumber > 0 {
+			text.WriteString(fmt.Sprintf(" in block %d", alert.BlockNumber))
+		}
+		text.WriteString(".\n")
+	case TxAlertDropped:
+		text.WriteString("❌ **Transaction Dropped**\n\n" + movement + " left the mempool without being mined.\n")
+	}
+	text.WriteString("Tx: " + alert.Hash)
+	return text.String()
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mempoolChain serves synthetic blocks and the transactions its nodes hold
+type mempoolChain struct {
+	ChainClient
+
+	blocks  map[uint64]*types.Block
+	pending map[common.Hash]bool
+	head    uint64
+}
+
+func (mc *mempoolChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return mc.head, nil
+}
+
+func (mc *mempoolChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	block, ok := mc.blocks[number.Uint64()]
+	if !ok {
+		return types.NewBlockWithHeader(&types.Header{Number: number}), nil
+	}
+	return block, nil
+}
+
+func (mc *mempoolChain) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	if mc.pending[hash] {
+		return nil, true, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+// refusingPendingSource is a node that doesn't serve pending transactions
+type refusingPendingSource struct{}
+
+func (refusingPendingSource) SubscribeFullPendingTransactions(ctx context.Context, ch chan<- *types.Transaction) (ethereum.Subscription, error) {
+	return nil, errors.New("the method eth_subscribe does not exist/is not available")
+}
+
+// mempoolFixture signs transfers from a watched wallet
+type mempoolFixture struct {
+	t       *testing.T
+	signer  types.Signer
+	key     *ecdsa.PrivateKey
+	watched common.Address
+	nonce   uint64
+}
+
+func newMempoolFixture(t *testing.T) *mempoolFixture {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return &mempoolFixture{
+		t:       t,
+		signer:  types.LatestSignerForChainID(big.NewInt(8217)),
+		key:     key,
+		watched: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// transfer signs the next transfer of kaia KAIA from the watched wallet
+func (f *mempoolFixture) transfer(to common.Address, kaia int64) *types.Transaction {
+	value := new(big.Int).Mul(big.NewInt(kaia), big.NewInt(1e18))
+	tx, err := types.SignNewTx(f.key, f.signer, &types.LegacyTx{Nonce: f.nonce, To: &to, Value: value, Gas: 21000, GasPrice: big.NewInt(25e9)})
+	require.NoError(f.t, err)
+	f.nonce++
+	return tx
+}
+
+func TestMempoolWatcherAlertsPendingThenOutcome(t *testing.T) {
+	fixture := newMempoolFixture(t)
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000b0")
+	stranger := common.HexToAddress("0x00000000000000000000000000000000000000c0")
+
+	watchlist := NewWatchlist()
+	_, err := watchlist.Watch("0xUSER", fixture.watched, "Treasury", 0)
+	require.NoError(t, err)
+	_, err = watchlist.Watch("0xwhale", recipient, "", 5)
+	require.NoError(t, err)
+
+	included := fixture.transfer(recipient, 1)
+	dropped := fixture.transfer(recipient, 2)
+	unseen := fixture.transfer(recipient, 10)
+
+	chain := &mempoolChain{blocks: make(map[uint64]*types.Block), pending: make(map[common.Hash]bool), head: 100}
+	notifier := &recordingNotifier{}
+	watcher := NewMempoolWatcher(chain, watchlist, notifier)
+	clock := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	watcher.now = func() time.Time { return clock }
+	require.NoError(t, watcher.Poll(context.Background()))
+
+	watcher.HandlePending(included)
+	watcher.HandlePending(dropped)
+	watcher.HandlePending(included)
+	strangerKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := types.SignNewTx(strangerKey, fixture.signer, &types.LegacyTx{To: &stranger, Value: big.NewInt(1), Gas: 21000, GasPrice: big.NewInt(25e9)})
+	require.NoError(t, err)
+	watcher.HandlePending(other)
+	chain.pending[dropped.Hash()] = true
+
+	// The next block includes one pending transfer and one never seen pending
+	chain.head = 101
+	chain.blocks[101] = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(101)}).WithBody([]*types.Transaction{included, unseen, other}, nil)
+	require.NoError(t, watcher.Poll(context.Background()))
+	// A rebroadcast of a mined transfer isn't alerted again
+	watcher.HandlePending(included)
+
+	// The node still holds the other one after the wait, then loses it
+	clock = clock.Add(PendingTxDropAfter)
+	require.NoError(t, watcher.Poll(context.Background()))
+	delete(chain.pending, dropped.Hash())
+	clock = clock.Add(time.Minute)
+	require.NoError(t, watcher.Poll(context.Background()))
+
+	states := make(map[string][]string)
+	var whale []TxAlert
+	for _, frame := range notifier.frames {
+		alert := frame.Data.(TxAlert)
+		assert.Equal(t, "tx_alert", frame.Type)
+		if alert.UserID == "0xwhale" {
+			whale = append(whale, alert)
+			continue
+		}
+		assert.Equal(t, "0xuser", alert.UserID)
+		assert.Equal(t, "outgoing", alert.Direction)
+		states[alert.Hash] = append(states[alert.Hash], alert.State)
+	}
+	assert.Equal(t, map[string][]string{
+		included.Hash().Hex(): {TxAlertPending, TxAlertConfirmed},
+		dropped.Hash().Hex():  {TxAlertPending, TxAlertDropped},
+		unseen.Hash().Hex():   {TxAlertConfirmed},
+	}, states)
+
+	// The recipient's watcher only hears of the transfer above their minimum
+	require.Len(t, whale, 1)
+	assert.Equal(t, unseen.Hash().Hex(), whale[0].Hash)
+	assert.Equal(t, "incoming", whale[0].Direction)
+	assert.Equal(t, uint64(101), whale[0].BlockNumber)
+	assert.Equal(t, 10.0, whale[0].Value)
+
+	assert.Contains(t, notifier.frames[0].Response, "⏳ **Pending Transaction**\n\n1 KAIA from Treasury to 0x0000…00B0 is waiting to be mined.")
+}
+
+func TestMempoolWatcherWithoutPendingTransactions(t *testing.T) {
+	watcher := NewMempoolWatcher(&mempoolChain{head: 1}, NewWatchlist(), &recordingNotifier{})
+	watcher.SetPendingSource(refusingPendingSource{})
+
+	done := make(chan struct{})
+	go func() {
+		watcher.subscribe(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a refused subscription should turn mempool alerts off")
+	}
+	assert.False(t, watcher.Subscribed())
+}
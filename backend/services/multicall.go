@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DefaultMulticall3Address is where Multicall3 is deployed on Kaia and most
+// EVM chains
+const DefaultMulticall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+// ContractMulticall3 names the Multicall3 contract in a deployment manifest
+const ContractMulticall3 = "Multicall3"
+
+// multicallMaxCalls bounds the calls aggregated into one eth_call, so a batch
+// stays under the node's gas cap for calls
+const multicallMaxCalls = 100
+
+var (
+	// ErrCallReverted is the result of a batched call that reverted
+	ErrCallReverted = errors.New("execution reverted")
+	// ErrBatchNotExecuted is returned for the result of a call whose batch
+	// hasn't been executed
+	ErrBatchNotExecuted = errors.New("multicall batch not executed")
+)
+
+// multicall3ABI covers tryAggregate, which runs every call and reports
+// whether each one succeeded instead of reverting the batch
+const multicall3ABI = `[
+	{"type":"function","name":"tryAggregate","stateMutability":"payable",
+	 "inputs":[{"name":"requireSuccess","type":"bool"},{"name":"calls","type":"tuple[]","components":[{"name":"target","type":"address"},{"name":"callData","type":"bytes"}]}],
+	 "outputs":[{"name":"returnData","type":"tuple[]","components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}]}]}
+]`
+
+// multicall3 is the parsed multicall3ABI
+var multicall3 = mustParseABI(multicall3ABI)
+
+// multicallCall is a call in a tryAggregate batch
+type multicallCall struct {
+	Target   common.Address
+	CallData []byte
+}
+
+// multicallResult is the outcome of a call in a tryAggregate batch
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// Multicall batches contract reads into Multicall3 tryAggregate calls, one
+// RPC round trip per batch. Without a Multicall3 address, or at blocks
+// before the contract was deployed, the calls are made one by one.
+type Multicall struct {
+	caller  ethereum.ContractCaller
+	address common.Address
+}
+
+// NewMulticall creates a batcher calling Multicall3 at the address. The zero
+// address makes every call on its own.
+func NewMulticall(caller ethereum.ContractCaller, address common.Address) *Multicall {
+	return &Multicall{caller: caller, address: address}
+}
+
+// Batch starts an empty batch of calls
+func (m *Multicall) Batch() *MulticallBatch {
+	return &MulticallBatch{multicall: m}
+}
+
+// CallFuture is the pending result of a call in a batch
+type CallFuture struct {
+	result []byte
+	err    error
+	done   bool
+}
+
+// Result returns the data the call returned. A call that reverted returns
+// an error for which isRevert reports true; the other calls of its batch
+// are unaffected.
+func (f *CallFuture) Result() ([]byte, error) {
+	if !f.done {
+		return nil, ErrBatchNotExecuted
+	}
+	return f.result, f.err
+}
+
+// MulticallBatch collects read calls to execute together
+type MulticallBatch struct {
+	multicall *Multicall
+	calls     []multicallCall
+	futures   []*CallFuture
+}
+
+// Call adds a call of the contract with the data to the batch
+func (b *MulticallBatch) Call(contract common.Address, data []byte) *CallFuture {
+	future := &CallFuture{}
+	b.calls = append(b.calls, multicallCall{Target: contract, CallData: data})
+	b.futures = append(b.futures, future)
+	return future
+}
+
+// Execute makes the calls at a block, or the latest for nil, and resolves
+// their futures. The error is set when a whole batch could not be made, in
+// which case its futures fail with it too.
+func (b *MulticallBatch) Execute(ctx context.Context, block *big.Int) error {
+	var failed error
+	for start := 0; start < len(b.calls); start += multicallMaxCalls {
+		end := min(start+multicallMaxCalls, len(b.calls))
+		if err := b.execute(ctx, block, b.calls[start:end], b.futures[start:end]); err != nil {
+			for _, future := range b.futures[start:end] {
+				future.err, future.done = err, true
+			}
+			failed = err
+		}
+	}
+	b.calls, b.futures = nil, nil
+	return failed
+}
+
+// execute resolves one chunk of the batch
+func (b *MulticallBatch) execute(ctx context.Context, block *big.Int, calls []multicallCall, futures []*CallFuture) error {
+	m := b.multicall
+	if m.address == (common.Address{}) || len(calls) == 1 {
+		m.sequential(ctx, block, calls, futures)
+		return nil
+	}
+
+	data, err := multicall3.Pack("tryAggregate", false, calls)
+	if err != nil {
+		return fmt.Errorf("failed to encode multicall: %w", err)
+	}
+	address := m.address
+	output, err := m.caller.CallContract(ctx, ethereum.CallMsg{To: &address, Data: data}, block)
+	if err != nil {
+		return fmt.Errorf("multicall failed: %w", err)
+	}
+	if len(output) == 0 {
+		// No contract at the address, as at blocks before it was deployed
+		m.sequential(ctx, block, calls, futures)
+		return nil
+	}
+
+	unpacked, err := multicall3.Unpack("tryAggregate", output)
+	if err != nil {
+		return fmt.Errorf("failed to decode multicall: %w", err)
+	}
+	results, ok := abi.ConvertType(unpacked[0], new([]multicallResult)).(*[]multicallResult)
+	if !ok {
+		return errors.New("failed to decode multicall results")
+	}
+	if len(*results) != len(calls) {
+		return fmt.Errorf("multicall returned %d results for %d calls", len(*results), len(calls))
+	}
+	for i, result := range *results {
+		futures[i].done = true
+		if !result.Success {
+			futures[i].err = ErrCallReverted
+			continue
+		}
+		futures[i].result = result.ReturnData
+	}
+	return nil
+}
+
+// sequential makes the calls one at a time
+func (m *Multicall) sequential(ctx context.Context, block *big.Int, calls []multicallCall, futures []*CallFuture) {
+	for i, call := range calls {
+		target := call.Target
+		futures[i].result, futures[i].err = m.caller.CallContract(ctx, ethereum.CallMsg{To: &target, Data: call.CallData}, block)
+		futures[i].done = true
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMulticall3 = common.HexToAddress(DefaultMulticall3Address)
+
+// multicallChain serves Multicall3 tryAggregate calls by making each inner
+// call against contracts, and counts the calls that reach it, as RPC round
+// trips. Other addresses are passed to contracts.
+type multicallChain struct {
+	contracts ethereum.ContractCaller
+	calls     int
+}
+
+func (mc *multicallChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	mc.calls++
+	if *call.To != testMulticall3 {
+		return mc.contracts.CallContract(ctx, call, blockNumber)
+	}
+
+	method := multicall3.Methods["tryAggregate"]
+	args, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	calls := *abi.ConvertType(args[1], new([]multicallCall)).(*[]multicallCall)
+	results := make([]multicallResult, len(calls))
+	for i, inner := range calls {
+		target := inner.Target
+		data, err := mc.contracts.CallContract(ctx, ethereum.CallMsg{To: &target, Data: inner.CallData}, blockNumber)
+		results[i] = multicallResult{Success: err == nil, ReturnData: data}
+	}
+	return method.Outputs.Pack(results)
+}
+
+// balanceTokens holds a balance of i+1 units at the ith address of its
+// tokens for any holder, and reverts for other contracts
+type balanceTokens []common.Address
+
+func (bt balanceTokens) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	for i, token := range bt {
+		if *call.To == token && string(call.Data[:4]) == string(erc20BalanceOfSelector) {
+			return common.LeftPadBytes(units(int64(i+1), 18).Bytes(), 32), nil
+		}
+	}
+	return nil, errors.New("execution reverted")
+}
+
+// newBalanceTokens creates n tracked tokens and the contracts answering
+// their balances
+func newBalanceTokens(n int) ([]TrackedToken, balanceTokens) {
+	tracked := make([]TrackedToken, n)
+	contracts := make(balanceTokens, n)
+	for i := range tracked {
+		contracts[i] = common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		tracked[i] = TrackedToken{Symbol: fmt.Sprintf("T%d", i), Address: contracts[i], Decimals: 18}
+	}
+	return tracked, contracts
+}
+
+func TestMulticallIsolatesRevertedCall(t *testing.T) {
+	chain := &multicallChain{contracts: newFiftyFiftyPair()}
+	batch := NewMulticall(chain, testMulticall3).Batch()
+
+	token0 := batch.Call(lpPair, pairToken0Selector)
+	reverted := batch.Call(lpUSDC, pairToken0Selector)
+	reserves := batch.Call(lpPair, pairGetReservesSelector)
+	_, err := token0.Result()
+	assert.ErrorIs(t, err, ErrBatchNotExecuted)
+
+	require.NoError(t, batch.Execute(context.Background(), nil))
+	assert.Equal(t, 1, chain.calls, "the batch is one RPC call")
+
+	result, err := token0.Result()
+	require.NoError(t, err)
+	assert.Equal(t, lpUSDC, common.BytesToAddress(result))
+
+	_, err = reverted.Result()
+	assert.ErrorIs(t, err, ErrCallReverted)
+	assert.True(t, isRevert(err))
+
+	result, err = reserves.Result()
+	require.NoError(t, err)
+	assert.Equal(t, units(1_000_000, 6), new(big.Int).SetBytes(result[:32]))
+}
+
+func TestMulticallFallsBackToSequentialCalls(t *testing.T) {
+	// Without an address every call is made on its own
+	chain := &multicallChain{contracts: newFiftyFiftyPair()}
+	batch := NewMulticall(chain, common.Address{}).Batch()
+	token0 := batch.Call(lpPair, pairToken0Selector)
+	reverted := batch.Call(lpUSDC, pairToken0Selector)
+	require.NoError(t, batch.Execute(context.Background(), nil))
+	assert.Equal(t, 2, chain.calls)
+	result, err := token0.Result()
+	require.NoError(t, err)
+	assert.Equal(t, lpUSDC, common.BytesToAddress(result))
+	_, err = reverted.Result()
+	assert.True(t, isRevert(err))
+
+	// An address without a contract returns no data, as before deployment
+	empty := &multicallChain{contracts: emptyCaller{}}
+	batch = NewMulticall(empty, common.HexToAddress("0x00000000000000000000000000000000000000e1")).Batch()
+	first := batch.Call(lpPair, pairToken0Selector)
+	batch.Call(lpPair, pairToken1Selector)
+	require.NoError(t, batch.Execute(context.Background(), nil))
+	assert.Equal(t, 3, empty.calls, "one multicall, then one call each")
+	result, err = first.Result()
+	require.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+// emptyCaller answers every call with no data, like an address without code
+type emptyCaller struct{}
+
+func (emptyCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func TestMulticallSplitsLargeBatches(t *testing.T) {
+	tracked, contracts := newBalanceTokens(multicallMaxCalls + 1)
+	chain := &multicallChain{contracts: contracts}
+	batch := NewMulticall(chain, testMulticall3).Batch()
+	data := append(append([]byte{}, erc20BalanceOfSelector...), common.LeftPadBytes(lpHolder.Bytes(), 32)...)
+	futures := make([]*CallFuture, len(tracked))
+	for i, token := range tracked {
+		futures[i] = batch.Call(token.Address, data)
+	}
+	require.NoError(t, batch.Execute(context.Background(), nil))
+	// A full chunk in one aggregate call; a single call left over on its own
+	assert.Equal(t, 2, chain.calls)
+	result, err := futures[multicallMaxCalls].Result()
+	require.NoError(t, err)
+	assert.Equal(t, units(multicallMaxCalls+1, 18), new(big.Int).SetBytes(result))
+}
+
+func TestTokenBalancesReadInOneBatch(t *testing.T) {
+	tracked, contracts := newBalanceTokens(50)
+	chain := &multicallChain{contracts: contracts}
+	reader := NewERC20BalanceReader(chain, tracked, fakePrices{"T0": 2})
+	reader.SetMulticall(NewMulticall(chain, testMulticall3))
+
+	holdings, err := reader.TokenBalances(context.Background(), lpHolder)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chain.calls)
+	require.Len(t, holdings, 50)
+	assert.Equal(t, 1.0, holdings[0].Balance)
+	assert.Equal(t, 2.0, holdings[0].ValueUSD)
+	assert.Equal(t, 50.0, holdings[49].Balance)
+
+	// A token whose balanceOf reverts fails the read, as it did call by call
+	tracked = append(tracked, TrackedToken{Symbol: "BAD", Address: lpWETH, Decimals: 18})
+	reader = NewERC20BalanceReader(chain, tracked, fakePrices{})
+	reader.SetMulticall(NewMulticall(chain, testMulticall3))
+	_, err = reader.TokenBalances(context.Background(), lpHolder)
+	assert.ErrorContains(t, err, "failed to read BAD balance")
+}
+
+func TestPoolDepthReadsReservesInOneBatch(t *testing.T) {
+	chain := &multicallChain{contracts: newFiftyFiftyPair()}
+	pools := newTestPoolReader(newFiftyFiftyPair(), nil)
+	pools.caller = chain
+	pools.SetMulticall(NewMulticall(chain, testMulticall3))
+	depths := NewPoolDepthReader(pools, []common.Address{lpPair})
+
+	depth, err := depths.Depth(context.Background(), "ETH")
+	require.NoError(t, err)
+	assert.Equal(t, 500.0, depth.AssetReserve)
+	// Detecting the pair batches its pair reads; its WETH leg is described
+	// with two more calls, and the reserves take one
+	assert.Equal(t, 4, chain.calls)
+
+	chain.calls = 0
+	_, err = depths.Depth(context.Background(), "ETH")
+	require.NoError(t, err)
+	assert.Equal(t, 1, chain.calls, "pools are cached, reserves are read again")
+}
+
+// BenchmarkTokenBalances50Tokens reports the RPC calls a 50 token portfolio
+// takes to read, one by one and batched
+func BenchmarkTokenBalances50Tokens(b *testing.B) {
+	tracked, contracts := newBalanceTokens(50)
+	for _, mode := range []struct {
+		name    string
+		address common.Address
+	}{
+		{"sequential", common.Address{}},
+		{"multicall", testMulticall3},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			chain := &multicallChain{contracts: contracts}
+			reader := NewERC20BalanceReader(chain, tracked, fakePrices{})
+			reader.SetMulticall(NewMulticall(chain, mode.address))
+			for i := 0; i < b.N; i++ {
+				if _, err := reader.TokenBalances(context.Background(), lpHolder); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(chain.calls)/float64(b.N), "rpc_calls/op")
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// DefaultHeadLagThreshold is how far the head block's timestamp can trail
+// the wall clock before the node counts as lagging
+const DefaultHeadLagThreshold = time.Minute
+
+// Statuses of a node metric
+const (
+	NodeMetricOK          = "ok"
+	NodeMetricUnsupported = "unsupported"
+	NodeMetricError       = "error"
+)
+
+// ErrRPCUnsupported is returned when no endpoint serves a JSON-RPC method
+var ErrRPCUnsupported = errors.New("JSON-RPC method not supported")
+
+// rpcMethodNotFound is the JSON-RPC error code of unknown methods
+const rpcMethodNotFound = -32601
+
+// NodeRPC calls JSON-RPC methods by name. *rpc.Client satisfies it, as does
+// FailoverClient.
+type NodeRPC interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+var _ NodeRPC = (*FailoverClient)(nil)
+
+// TxPoolStatus is the node's transaction pool size, from txpool_status
+type TxPoolStatus struct {
+	Status  string `json:"status"`
+	Pending uint64 `json:"pending"`
+	Queued  uint64 `json:"queued"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NodeSyncStatus is the node's sync progress, from eth_syncing
+type NodeSyncStatus struct {
+	Status       string `json:"status"`
+	Syncing      bool   `json:"syncing"`
+	CurrentBlock uint64 `json:"current_block,omitempty"`
+	HighestBlock uint64 `json:"highest_block,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// PeerCount is the node's connected peers, from net_peerCount
+type PeerCount struct {
+	Status string `json:"status"`
+	Peers  uint64 `json:"peers"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HeadLag is how far the head block's timestamp trails the wall clock
+type HeadLag struct {
+	Status           string  `json:"status"`
+	Number           uint64  `json:"number"`
+	Timestamp        APITime `json:"timestamp"`
+	LagSeconds       float64 `json:"lag_seconds"`
+	ThresholdSeconds float64 `json:"threshold_seconds"`
+	Lagging          bool    `json:"lagging"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// NodeStatus is a snapshot of the node's key metrics
+type NodeStatus struct {
+	// Status is degraded while the head lags, healthy otherwise
+	Status    string         `json:"status"`
+	TxPool    TxPoolStatus   `json:"txpool"`
+	Sync      NodeSyncStatus `json:"sync"`
+	Peers     PeerCount      `json:"peers"`
+	Head      HeadLag        `json:"head"`
+	CheckedAt APITime        `json:"checked_at"`
+}
+
+// NodeMonitor reads node metrics that live outside the eth namespace, such
+// as the transaction pool, without exposing the node's APIs
+type NodeMonitor struct {
+	headers   ChainClient
+	rpc       NodeRPC
+	threshold time.Duration
+	now       func() time.Time
+	logger    *log.Logger
+
+	mu      sync.Mutex
+	lagging bool
+}
+
+// NewNodeMonitor creates a monitor reading headers and raw RPC methods from
+// the node. A threshold of zero or less uses DefaultHeadLagThreshold.
+func NewNodeMonitor(headers ChainClient, rpc NodeRPC, threshold time.Duration) *NodeMonitor {
+	if threshold <= 0 {
+		threshold = DefaultHeadLagThreshold
+	}
+	return &NodeMonitor{
+		headers:   headers,
+		rpc:       rpc,
+		threshold: threshold,
+		now:       utcNow,
+		logger:    log.New(log.Writer(), "[NodeMonitor] ", log.LstdFlags),
+	}
+}
+
+// Status reads all the node's metrics. Methods the node doesn't serve are
+// reported as unsupported.
+func (m *NodeMonitor) Status(ctx context.Context) *NodeStatus {
+	status := &NodeStatus{
+		TxPool:    m.txPool(ctx),
+		Sync:      m.sync(ctx),
+		Peers:     m.peers(ctx),
+		Head:      m.HeadLag(ctx),
+		CheckedAt: NewAPITime(m.now()),
+	}
+	status.Status = "healthy"
+	if status.Head.Lagging {
+		status.Status = "degraded"
+	}
+	return status
+}
+
+// HeadLag compares the head block's timestamp to the wall clock, logging
+// when the node starts or stops lagging
+func (m *NodeMonitor) HeadLag(ctx context.Context) HeadLag {
+	lag := HeadLag{ThresholdSeconds: m.threshold.Seconds()}
+	header, err := m.headers.HeaderByNumber(ctx, nil)
+	if err != nil {
+		lag.Status, lag.Error = NodeMetricError, err.Error()
+		return lag
+	}
+
+	produced := time.Unix(int64(header.Time), 0)
+	behind := math.Max(m.now().Sub(produced).Seconds(), 0)
+	lag.Status = NodeMetricOK
+	lag.Number = header.Number.Uint64()
+	lag.Timestamp = NewAPITime(produced)
+	lag.LagSeconds = roundTo(behind, 3)
+	lag.Lagging = behind > m.threshold.Seconds()
+
+	m.mu.Lock()
+	changed := lag.Lagging != m.lagging
+	m.lagging = lag.Lagging
+	m.mu.Unlock()
+	if changed && lag.Lagging {
+		m.logger.Printf("Head block %d is %.0fs behind, over the %s threshold; health is degraded", lag.Number, behind, m.threshold)
+	} else if changed {
+		m.logger.Printf("Head block %d caught up to %.0fs behind; health is restored", lag.Number, behind)
+	}
+	return lag
+}
+
+// txPool reads txpool_status
+func (m *NodeMonitor) txPool(ctx context.Context) TxPoolStatus {
+	var result struct {
+		Pending hexutil.Uint64 `json:"pending"`
+		Queued  hexutil.Uint64 `json:"queued"`
+	}
+	if err := m.rpc.CallContext(ctx, &result, "txpool_status"); err != nil {
+		status, message := metricFailure(err)
+		return TxPoolStatus{Status: status, Error: message}
+	}
+	return TxPoolStatus{Status: NodeMetricOK, Pending: uint64(result.Pending), Queued: uint64(result.Queued)}
+}
+
+// sync reads eth_syncing, which is false once the node is in sync
+func (m *NodeMonitor) sync(ctx context.Context) NodeSyncStatus {
+	var raw json.RawMessage
+	if err := m.rpc.CallContext(ctx, &raw, "eth_syncing"); err != nil {
+		status, message := metricFailure(err)
+		return NodeSyncStatus{Status: status, Error: message}
+	}
+
+	var syncing bool
+	if err := json.Unmarshal(raw, &syncing); err == nil {
+		return NodeSyncStatus{Status: NodeMetricOK, Syncing: syncing}
+	}
+	var progress struct {
+		CurrentBlock hexutil.Uint64 `json:"currentBlock"`
+		HighestBlock hexutil.Uint64 `json:"highestBlock"`
+	}
+	if err := json.Unmarshal(raw, &progress); err != nil {
+		return NodeSyncStatus{Status: NodeMetricError, Error: fmt.Sprintf("unexpected eth_syncing result: %v", err)}
+	}
+	return NodeSyncStatus{
+		Status:       NodeMetricOK,
+		Syncing:      true,
+		CurrentBlock: uint64(progress.CurrentBlock),
+		HighestBlock: uint64(progress.HighestBlock),
+	}
+}
+
+// peers reads net_peerCount
+func (m *NodeMonitor) peers(ctx context.Context) PeerCount {
+	var count hexutil.Uint64
+	if err := m.rpc.CallContext(ctx, &count, "net_peerCount"); err != nil {
+		status, message := metricFailure(err)
+		return PeerCount{Status: status, Error: message}
+	}
+	return PeerCount{Status: NodeMetricOK, Peers: uint64(count)}
+}
+
+// metricFailure classifies a failed metric read. Unsupported methods are
+// expected of nodes with namespaces turned off, so they carry no error.
+func metricFailure(err error) (status, message string) {
+	var rpcErr rpc.Error
+	if errors.Is(err, ErrRPCUnsupported) || (errors.As(err, &rpcErr) && rpcErr.ErrorCode() == rpcMethodNotFound) {
+		return NodeMetricUnsupported, ""
+	}
+	return NodeMetricError, err.Error()
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTxPool and fakeNet serve the txpool and net namespaces of a node that
+// leaves eth_syncing unregistered
+type fakeTxPool struct{}
+
+func (fakeTxPool) Status() map[string]hexutil.Uint {
+	return map[string]hexutil.Uint{"pending": 42, "queued": 3}
+}
+
+type fakeNet struct{ err error }
+
+func (n fakeNet) PeerCount() (hexutil.Uint, error) {
+	return 7, n.err
+}
+
+// rawNode is a node endpoint exposing its raw RPC client, as ethclient does
+type rawNode struct {
+	*headerChain
+	raw *rpc.Client
+}
+
+func (rn *rawNode) Client() *rpc.Client {
+	return rn.raw
+}
+
+func (rn *rawNode) Close() {}
+
+func newRawNode(t *testing.T, head *types.Header, net fakeNet) *rawNode {
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("txpool", fakeTxPool{}))
+	require.NoError(t, server.RegisterName("net", net))
+	raw := rpc.DialInProc(server)
+	t.Cleanup(func() {
+		raw.Close()
+		server.Stop()
+	})
+	return &rawNode{
+		headerChain: &headerChain{headers: map[uint64]*types.Header{head.Number.Uint64(): head}, head: head.Number.Uint64()},
+		raw:         raw,
+	}
+}
+
+func TestNodeMonitorReportsMetrics(t *testing.T) {
+	produced := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	node := newRawNode(t, &types.Header{Number: big.NewInt(100), Time: uint64(produced.Unix())}, fakeNet{})
+	client := NewFailoverClient([]NamedChainClient{{Name: "node", Client: node}}, FailoverOptions{})
+	monitor := NewNodeMonitor(client, client, 30*time.Second)
+	monitor.now = func() time.Time { return produced.Add(5 * time.Second) }
+
+	status := monitor.Status(context.Background())
+	assert.Equal(t, "healthy", status.Status)
+	assert.Equal(t, TxPoolStatus{Status: NodeMetricOK, Pending: 42, Queued: 3}, status.TxPool)
+	assert.Equal(t, PeerCount{Status: NodeMetricOK, Peers: 7}, status.Peers)
+	// eth_syncing isn't served, which is reported without an error
+	assert.Equal(t, NodeSyncStatus{Status: NodeMetricUnsupported}, status.Sync)
+	assert.Equal(t, uint64(100), status.Head.Number)
+	assert.Equal(t, 5.0, status.Head.LagSeconds)
+	assert.Equal(t, 30.0, status.Head.ThresholdSeconds)
+	assert.False(t, status.Head.Lagging)
+	assert.True(t, client.Stats()[0].Healthy, "unsupported methods don't mark the endpoint unhealthy")
+
+	// Other failures are reported with their error
+	node = newRawNode(t, &types.Header{Number: big.NewInt(100), Time: uint64(produced.Unix())}, fakeNet{err: errors.New("p2p server not running")})
+	monitor = NewNodeMonitor(node.headerChain, node.raw, 0)
+	peers := monitor.Status(context.Background()).Peers
+	assert.Equal(t, NodeMetricError, peers.Status)
+	assert.Contains(t, peers.Error, "p2p server not running")
+}
+
+func TestNodeMonitorWithoutRawRPC(t *testing.T) {
+	// Endpoints without a raw client, such as the simulated chain, serve none of the methods
+	chain := NewSimulatedChain(DefaultSimulatedChainID, simulatedGenesis)
+	client := NewFailoverClient([]NamedChainClient{{Name: "simulated", Client: chain}}, FailoverOptions{})
+	monitor := NewNodeMonitor(client, client, time.Minute)
+	monitor.now = func() time.Time { return simulatedGenesis }
+
+	status := monitor.Status(context.Background())
+	assert.Equal(t, NodeMetricUnsupported, status.TxPool.Status)
+	assert.Equal(t, NodeMetricUnsupported, status.Sync.Status)
+	assert.Equal(t, NodeMetricUnsupported, status.Peers.Status)
+	assert.Equal(t, NodeMetricOK, status.Head.Status)
+	assert.Equal(t, "healthy", status.Status)
+}
+
+func TestNodeMonitorSyncProgress(t *testing.T) {
+	monitor := NewNodeMonitor(nil, rpcResults{"eth_syncing": `{"currentBlock":"0x10","highestBlock":"0x20"}`}, 0)
+	assert.Equal(t, NodeSyncStatus{Status: NodeMetricOK, Syncing: true, CurrentBlock: 16, HighestBlock: 32}, monitor.sync(context.Background()))
+
+	monitor = NewNodeMonitor(nil, rpcResults{"eth_syncing": `false`}, 0)
+	assert.Equal(t, NodeSyncStatus{Status: NodeMetricOK}, monitor.sync(context.Background()))
+}
+
+func TestNodeMonitorDegradesOnHeadLag(t *testing.T) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	chain := &headerChain{headers: map[uint64]*types.Header{
+		10: {Number: big.NewInt(10), Time: uint64(clock.Now().Unix())},
+	}, head: 10}
+	monitor := NewNodeMonitor(chain, rpcResults{}, time.Minute)
+	monitor.now = clock.Now
+
+	clock.Advance(time.Minute)
+	lag := monitor.HeadLag(context.Background())
+	assert.False(t, lag.Lagging, "at the threshold")
+	assert.False(t, monitor.lagging)
+
+	// The head stalls past the threshold
+	clock.Advance(time.Second)
+	lag = monitor.HeadLag(context.Background())
+	assert.True(t, lag.Lagging)
+	assert.Equal(t, 61.0, lag.LagSeconds)
+	assert.True(t, monitor.lagging)
+	assert.Equal(t, "degraded", monitor.Status(context.Background()).Status)
+
+	// A new head block restores health
+	chain.headers[11] = &types.Header{Number: big.NewInt(11), Time: uint64(clock.Now().Add(-2 * time.Second).Unix())}
+	chain.head = 11
+	lag = monitor.HeadLag(context.Background())
+	assert.False(t, lag.Lagging)
+	assert.Equal(t, 2.0, lag.LagSeconds)
+	assert.False(t, monitor.lagging)
+	assert.Equal(t, "healthy", monitor.Status(context.Background()).Status)
+}
+
+// rpcResults answers methods with canned JSON results; any other method is
+// unknown to it
+type rpcResults map[string]string
+
+func (r rpcResults) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	raw, ok := r[method]
+	if !ok {
+		return ErrRPCUnsupported
+	}
+	return json.Unmarshal([]byte(raw), result)
+}
@@ -0,0 +1,482 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// minFeeBumpPercent is the smallest gas price increase nodes accept for a
+// transaction replacing one with the same nonce
+const minFeeBumpPercent = 10
+
+// maxSettledTxs is how many mined transactions are kept per address, so one
+// a reorg drops can be sent again
+const maxSettledTxs = 256
+
+// cancelGas is the gas limit of the zero-value transfer that cancels a
+// transaction
+const cancelGas = 21000
+
+// ErrTxNotInFlight is returned when cancelling a transaction the nonce
+// manager doesn't hold as unmined, including one that has been mined
+var ErrTxNotInFlight = errors.New("transaction is not in flight")
+
+// TxBackend is the node surface transactions are submitted through.
+// *ethclient.Client satisfies it.
+type TxBackend interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+var _ TxBackend = (*ethclient.Client)(nil)
+
+// TxSigner signs a transaction for the sending account. It has the shape of
+// bind.SignerFn, so a TransactOpts signer can be passed as is.
+type TxSigner func(from common.Address, tx *types.Transaction) (*types.Transaction, error)
+
+// TxRequest is a transaction to submit; the nonce and gas price are filled in
+// by the nonce manager
+type TxRequest struct {
+	To    *common.Address
+	Value *big.Int
+	Data  []byte
+	Gas   uint64
+}
+
+// NonceManagerOptions configures a NonceManager
+type NonceManagerOptions struct {
+	// StuckTimeout is how long a transaction may stay unmined before it is
+	// replaced with a higher gas price
+	StuckTimeout time.Duration
+	// FeeBumpPercent is how much a replacement raises the gas price by
+	FeeBumpPercent int
+	// MaxRetries is how many times a submission is retried after the node
+	// rejects its nonce
+	MaxRetries int
+	// CheckInterval is how often Start looks for stuck transactions
+	CheckInterval time.Duration
+}
+
+// DefaultNonceManagerOptions returns the options used when none are configured
+func DefaultNonceManagerOptions() NonceManagerOptions {
+	return NonceManagerOptions{
+		StuckTimeout:   3 * time.Minute,
+		FeeBumpPercent: 12,
+		MaxRetries:     3,
+		CheckInterval:  30 * time.Second,
+	}
+}
+
+// PendingTx is a submitted transaction that hasn't been seen mined yet
+type PendingTx struct {
+	From         string    `json:"from"`
+	Nonce        uint64    `json:"nonce"`
+	Hash         string    `json:"hash"`
+	GasPrice     *big.Int  `json:"gas_price"`
+	SentAt       time.Time `json:"sent_at"`
+	Replacements int       `json:"replacements"`
+
+	tx *types.Transaction
+}
+
+// senderState is the nonce bookkeeping of one sending address. Its lock is
+// held for the whole of a submission so nonces are handed out in order.
+type senderState struct {
+	mu       sync.Mutex
+	synced   bool
+	next     uint64
+	signer   TxSigner
+	inFlight map[uint64]*PendingTx
+	settled  map[uint64]*PendingTx
+}
+
+// settle moves a transaction seen mined out of flight. The lock is held by
+// the caller.
+func (state *senderState) settle(nonce uint64) {
+	pending, ok := state.inFlight[nonce]
+	if !ok {
+		return
+	}
+	delete(state.inFlight, nonce)
+	state.settled[nonce] = pending
+	if len(state.settled) > maxSettledTxs {
+		oldest := nonce
+		for settled := range state.settled {
+			oldest = min(oldest, settled)
+		}
+		delete(state.settled, oldest)
+	}
+}
+
+// NonceManager allocates nonces for the accounts the backend sends from.
+// Submissions from the same address are serialized, rejected nonces are
+// recovered by refetching the pending nonce, and transactions left unmined
+// past the stuck timeout are replaced with the same nonce and a higher gas
+// price.
+type NonceManager struct {
+	backend TxBackend
+	opts    NonceManagerOptions
+	logger  *log.Logger
+
+	// killSwitch is checked before every transaction is signed
+	killSwitch *KillSwitch
+
+	mu      sync.Mutex
+	senders map[common.Address]*senderState
+	now     func() time.Time
+}
+
+// NewNonceManager creates a nonce manager submitting through the backend
+func NewNonceManager(backend TxBackend, opts NonceManagerOptions) *NonceManager {
+	defaults := DefaultNonceManagerOptions()
+	if opts.StuckTimeout <= 0 {
+		opts.StuckTimeout = defaults.StuckTimeout
+	}
+	if opts.FeeBumpPercent < minFeeBumpPercent {
+		opts.FeeBumpPercent = minFeeBumpPercent
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = defaults.MaxRetries
+	}
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = defaults.CheckInterval
+	}
+
+	return &NonceManager{
+		backend: backend,
+		opts:    opts,
+		logger:  log.New(log.Writer(), "[NonceManager] ", log.LstdFlags),
+		senders: make(map[common.Address]*senderState),
+		now:     utcNow,
+	}
+}
+
+// SetKillSwitch refuses to sign sends and stuck replacements while actions
+// are suspended. Cancellations are still signed, so broadcast transactions
+// can be pulled back.
+func (nm *NonceManager) SetKillSwitch(killSwitch *KillSwitch) {
+	nm.killSwitch = killSwitch
+}
+
+// sender returns the state of the address, creating it on first use
+func (nm *NonceManager) sender(from common.Address) *senderState {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	state, ok := nm.senders[from]
+	if !ok {
+		state = &senderState{inFlight: make(map[uint64]*PendingTx), settled: make(map[uint64]*PendingTx)}
+		nm.senders[from] = state
+	}
+	return state
+}
+
+// Send signs and submits the transaction from the address with the next
+// nonce. A nonce the node rejects as used is recovered by refetching the
+// pending nonce and retrying.
+func (nm *NonceManager) Send(ctx context.Context, from common.Address, signer TxSigner, request TxRequest) (*PendingTx, error) {
+	state := nm.sender(from)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.signer = signer
+	if !state.synced {
+		if err := nm.resync(ctx, from, state); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		gasPrice, err := nm.backend.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get gas price: %w", err)
+		}
+
+		if err := nm.killSwitch.Check(ctx); err != nil {
+			return nil, err
+		}
+		nonce := state.next
+		signed, err := signer(from, types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			To:       request.To,
+			Value:    request.Value,
+			Data:     request.Data,
+			Gas:      request.Gas,
+			GasPrice: gasPrice,
+		}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign transaction: %w", err)
+		}
+
+		err = nm.backend.SendTransaction(ctx, signed)
+		if err == nil {
+			pending := &PendingTx{
+				From:     strings.ToLower(from.Hex()),
+				Nonce:    nonce,
+				Hash:     signed.Hash().Hex(),
+				GasPrice: gasPrice,
+				SentAt:   nm.now(),
+				tx:       signed,
+			}
+			state.inFlight[nonce] = pending
+			state.next = nonce + 1
+			copied := *pending
+			return &copied, nil
+		}
+		if !isNonceConflict(err) || attempt >= nm.opts.MaxRetries {
+			return nil, fmt.Errorf("failed to send transaction with nonce %d: %w", nonce, err)
+		}
+
+		nm.logger.Printf("Nonce %d of %s was rejected (%v), refetching the pending nonce", nonce, from.Hex(), err)
+		if err := nm.resync(ctx, from, state); err != nil {
+			return nil, err
+		}
+		// The node may not count another sender's transaction at this nonce
+		// as pending yet, so move past it
+		if state.next <= nonce {
+			state.next = nonce + 1
+		}
+	}
+}
+
+// resync sets the next nonce from the node's pending nonce. The nonces of
+// transactions still in flight are never handed out again.
+func (nm *NonceManager) resync(ctx context.Context, from common.Address, state *senderState) error {
+	pending, err := nm.backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return fmt.Errorf("failed to get pending nonce: %w", err)
+	}
+	if !state.synced || pending > state.next {
+		state.next = pending
+	}
+	state.synced = true
+	return nil
+}
+
+// InFlight returns the address's transactions that haven't been seen mined,
+// lowest nonce first
+func (nm *NonceManager) InFlight(from common.Address) []PendingTx {
+	state := nm.sender(from)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	pending := make([]PendingTx, 0, len(state.inFlight))
+	for _, tx := range state.inFlight {
+		pending = append(pending, *tx)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Nonce < pending[j].Nonce })
+	return pending
+}
+
+// Start replaces stuck transactions in the background until ctx is cancelled
+func (nm *NonceManager) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(nm.opts.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				nm.ReplaceStuck(ctx)
+			}
+		}
+	}()
+}
+
+// ReplaceStuck forgets the in-flight transactions that have been mined and
+// resubmits those unmined past the stuck timeout with a bumped gas price.
+// Returns the replacements that were sent.
+func (nm *NonceManager) ReplaceStuck(ctx context.Context) []PendingTx {
+	nm.mu.Lock()
+	senders := make(map[common.Address]*senderState, len(nm.senders))
+	for from, state := range nm.senders {
+		senders[from] = state
+	}
+	nm.mu.Unlock()
+
+	var replaced []PendingTx
+	for from, state := range senders {
+		replaced = append(replaced, nm.replaceStuck(ctx, from, state)...)
+	}
+	return replaced
+}
+
+// replaceStuck checks the in-flight transactions of one address
+func (nm *NonceManager) replaceStuck(ctx context.Context, from common.Address, state *senderState) []PendingTx {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	var replaced []PendingTx
+	for nonce, pending := range state.inFlight {
+		_, err := nm.backend.TransactionReceipt(ctx, pending.tx.Hash())
+		if err == nil {
+			state.settle(nonce)
+			continue
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			nm.logger.Printf("Failed to get receipt of %s: %v", pending.Hash, err)
+			continue
+		}
+		if nm.now().Sub(pending.SentAt) < nm.opts.StuckTimeout || state.signer == nil {
+			continue
+		}
+
+		if err := nm.killSwitch.Check(ctx); err != nil {
+			nm.logger.Printf("Not replacing stuck transaction %s: %v", pending.Hash, err)
+			continue
+		}
+		original := pending.tx
+		stuck := pending.Hash
+		err = nm.replace(ctx, from, state.signer, pending, TxRequest{To: original.To(), Value: original.Value(), Data: original.Data(), Gas: original.Gas()})
+		if err != nil {
+			// A used nonce means this or an earlier submission was mined
+			if isNonceTooLow(err) {
+				delete(state.inFlight, nonce)
+				continue
+			}
+			nm.logger.Printf("Failed to replace stuck transaction %s with nonce %d: %v", pending.Hash, nonce, err)
+			continue
+		}
+		nm.logger.Printf("Replaced stuck transaction %s with %s at nonce %d", stuck, pending.Hash, nonce)
+		replaced = append(replaced, *pending)
+	}
+	return replaced
+}
+
+// Cancel replaces an unmined transaction with a zero-value transfer from the
+// address to itself at the same nonce and a bumped gas price, so the original
+// can no longer be mined. Returns ErrTxNotInFlight when the nonce has been
+// mined or was never sent through the manager.
+func (nm *NonceManager) Cancel(ctx context.Context, from common.Address, nonce uint64) (*PendingTx, error) {
+	state := nm.sender(from)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	pending, ok := state.inFlight[nonce]
+	if !ok || state.signer == nil {
+		return nil, fmt.Errorf("%w: nonce %d of %s", ErrTxNotInFlight, nonce, from.Hex())
+	}
+	if _, err := nm.backend.TransactionReceipt(ctx, pending.tx.Hash()); err == nil {
+		state.settle(nonce)
+		return nil, fmt.Errorf("%w: %s was mined", ErrTxNotInFlight, pending.Hash)
+	}
+
+	original := pending.Hash
+	err := nm.replace(ctx, from, state.signer, pending, TxRequest{To: &from, Value: new(big.Int), Gas: cancelGas})
+	if err != nil {
+		if isNonceTooLow(err) {
+			delete(state.inFlight, nonce)
+			return nil, fmt.Errorf("%w: %s was mined", ErrTxNotInFlight, original)
+		}
+		return nil, fmt.Errorf("failed to cancel transaction %s: %w", original, err)
+	}
+
+	nm.logger.Printf("Cancelled transaction %s with %s at nonce %d", original, pending.Hash, nonce)
+	copied := *pending
+	return &copied, nil
+}
+
+// Resend sends the transaction last submitted at the address's nonce again,
+// unchanged, for when a reorg drops it from the chain and the pool. It is
+// back in flight afterwards, so it is replaced if it gets stuck. Returns
+// ErrTxNotInFlight when the manager no longer holds the transaction or the
+// node already counts the nonce as mined.
+func (nm *NonceManager) Resend(ctx context.Context, from common.Address, nonce uint64) (*PendingTx, error) {
+	state := nm.sender(from)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	pending, ok := state.inFlight[nonce]
+	if !ok {
+		pending, ok = state.settled[nonce]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: nonce %d of %s", ErrTxNotInFlight, nonce, from.Hex())
+	}
+
+	err := nm.backend.SendTransaction(ctx, pending.tx)
+	switch {
+	case err == nil, isAlreadyKnown(err):
+	case isNonceTooLow(err):
+		return nil, fmt.Errorf("%w: nonce %d of %s was mined", ErrTxNotInFlight, nonce, from.Hex())
+	default:
+		return nil, fmt.Errorf("failed to resend transaction %s: %w", pending.Hash, err)
+	}
+
+	delete(state.settled, nonce)
+	state.inFlight[nonce] = pending
+	pending.SentAt = nm.now()
+	nm.logger.Printf("Resent transaction %s at nonce %d", pending.Hash, nonce)
+	copied := *pending
+	return &copied, nil
+}
+
+// replace resubmits the transaction, or the given request in its place, with
+// the same nonce and a gas price raised by the fee bump, or to the suggested
+// price when that is higher
+func (nm *NonceManager) replace(ctx context.Context, from common.Address, signer TxSigner, pending *PendingTx, request TxRequest) error {
+	gasPrice := new(big.Int).Mul(pending.GasPrice, big.NewInt(int64(100+nm.opts.FeeBumpPercent)))
+	gasPrice.Div(gasPrice, big.NewInt(100))
+	if suggested, err := nm.backend.SuggestGasPrice(ctx); err == nil && suggested.Cmp(gasPrice) > 0 {
+		gasPrice = suggested
+	}
+
+	signed, err := signer(from, types.NewTx(&types.LegacyTx{
+		Nonce:    pending.Nonce,
+		To:       request.To,
+		Value:    request.Value,
+		Data:     request.Data,
+		Gas:      request.Gas,
+		GasPrice: gasPrice,
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to sign replacement: %w", err)
+	}
+	if err := nm.backend.SendTransaction(ctx, signed); err != nil {
+		return err
+	}
+
+	pending.Hash = signed.Hash().Hex()
+	pending.GasPrice = gasPrice
+	pending.SentAt = nm.now()
+	pending.Replacements++
+	pending.tx = signed
+	return nil
+}
+
+// isNonceTooLow reports whether the node rejected a transaction because its
+// nonce has already been mined
+func isNonceTooLow(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "nonce too low")
+}
+
+// isAlreadyKnown reports whether the node rejected a transaction because its
+// pool already holds it
+func isAlreadyKnown(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "already known")
+}
+
+// isNonceConflict reports whether the node rejected a transaction because its
+// nonce is already used, mined or by another pending transaction
+func isNonceConflict(err error) bool {
+	message := strings.ToLower(err.Error())
+	return isNonceTooLow(err) ||
+		strings.Contains(message, "replacement underpriced") ||
+		strings.Contains(message, "replacement transaction underpriced")
+}
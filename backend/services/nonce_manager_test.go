@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var relayerChainID = big.NewInt(1001)
+
+// fakeMempool simulates a node's transaction pool for one chain. It enforces
+// the nonce rules of a real node: mined nonces are too low, and a pending
+// nonce can only be replaced with a 10% higher gas price.
+type fakeMempool struct {
+	mu       sync.Mutex
+	gasPrice *big.Int
+	mined    map[common.Address]uint64
+	pool     map[common.Address]map[uint64]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	accepted []uint64
+}
+
+func newFakeMempool() *fakeMempool {
+	return &fakeMempool{
+		gasPrice: big.NewInt(25_000_000_000),
+		mined:    make(map[common.Address]uint64),
+		pool:     make(map[common.Address]map[uint64]*types.Transaction),
+		receipts: make(map[common.Hash]*types.Receipt),
+	}
+}
+
+func (f *fakeMempool) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nonce := f.mined[account]
+	for f.pool[account][nonce] != nil {
+		nonce++
+	}
+	return nonce, nil
+}
+
+func (f *fakeMempool) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return new(big.Int).Set(f.gasPrice), nil
+}
+
+func (f *fakeMempool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	from, err := types.Sender(types.NewEIP155Signer(relayerChainID), tx)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if tx.Nonce() < f.mined[from] {
+		return errors.New("nonce too low")
+	}
+	if f.pool[from] == nil {
+		f.pool[from] = make(map[uint64]*types.Transaction)
+	}
+	if existing := f.pool[from][tx.Nonce()]; existing != nil {
+		minimum := new(big.Int).Mul(existing.GasPrice(), big.NewInt(110))
+		if tx.GasPrice().Cmp(minimum.Div(minimum, big.NewInt(100))) < 0 {
+			return errors.New("replacement transaction underpriced")
+		}
+	}
+	f.pool[from][tx.Nonce()] = tx
+	f.accepted = append(f.accepted, tx.Nonce())
+	return nil
+}
+
+func (f *fakeMempool) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if receipt, ok := f.receipts[txHash]; ok {
+		return receipt, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+// mine includes the account's pool transactions that are next in nonce order
+func (f *fakeMempool) mine(account common.Address) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		tx := f.pool[account][f.mined[account]]
+		if tx == nil {
+			return
+		}
+		delete(f.pool[account], f.mined[account])
+		f.receipts[tx.Hash()] = &types.Receipt{TxHash: tx.Hash(), Status: types.ReceiptStatusSuccessful}
+		f.mined[account]++
+	}
+}
+
+// sendExternal simulates another process sending from the same account
+func (f *fakeMempool) sendExternal(account common.Address, count uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mined[account] += count
+}
+
+func newRelayer(t *testing.T) (common.Address, TxSigner) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return crypto.PubkeyToAddress(key.PublicKey), keySigner(key)
+}
+
+func keySigner(key *ecdsa.PrivateKey) TxSigner {
+	return func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		return types.SignTx(tx, types.NewEIP155Signer(relayerChainID), key)
+	}
+}
+
+var relayTarget = common.HexToAddress("0x00000000000000000000000000000000000000b2")
+
+func TestNonceManagerConcurrentSends(t *testing.T) {
+	pool := newFakeMempool()
+	from, signer := newRelayer(t)
+	pool.sendExternal(from, 7)
+	manager := NewNonceManager(pool, NonceManagerOptions{})
+
+	var wg sync.WaitGroup
+	nonces := make(chan uint64, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sent, err := manager.Send(context.Background(), from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+			assert.NoError(t, err)
+			if err == nil {
+				nonces <- sent.Nonce
+			}
+		}()
+	}
+	wg.Wait()
+	close(nonces)
+
+	seen := make(map[uint64]bool)
+	for nonce := range nonces {
+		seen[nonce] = true
+	}
+	assert.Len(t, seen, 20)
+
+	// The node saw every nonce once, in strictly increasing order
+	require.Len(t, pool.accepted, 20)
+	for i, nonce := range pool.accepted {
+		assert.Equal(t, uint64(7+i), nonce)
+	}
+	assert.Len(t, manager.InFlight(from), 20)
+
+	pool.mine(from)
+	assert.Empty(t, manager.ReplaceStuck(context.Background()))
+	assert.Empty(t, manager.InFlight(from))
+}
+
+func TestNonceManagerRecoversFromUsedNonces(t *testing.T) {
+	pool := newFakeMempool()
+	from, signer := newRelayer(t)
+	manager := NewNonceManager(pool, NonceManagerOptions{})
+	ctx := context.Background()
+
+	sent, err := manager.Send(ctx, from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), sent.Nonce)
+	pool.mine(from)
+
+	// Another process used nonces 1 to 4, so nonce 1 is too low
+	pool.sendExternal(from, 4)
+	sent, err = manager.Send(ctx, from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), sent.Nonce)
+
+	// A pending transaction of another process holds the next nonce
+	other, err := signer(from, types.NewTx(&types.LegacyTx{Nonce: 6, To: &relayTarget, Gas: 21000, GasPrice: big.NewInt(90_000_000_000)}))
+	require.NoError(t, err)
+	require.NoError(t, pool.SendTransaction(ctx, other))
+	sent, err = manager.Send(ctx, from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), sent.Nonce)
+
+	// Other errors aren't retried and don't use up the nonce
+	_, err = manager.Send(ctx, from, func(common.Address, *types.Transaction) (*types.Transaction, error) {
+		return nil, errors.New("key locked")
+	}, TxRequest{To: &relayTarget, Gas: 21000})
+	assert.ErrorContains(t, err, "key locked")
+	sent, err = manager.Send(ctx, from, signer, TxRequest{To: &relayTarget, Gas: 21000})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), sent.Nonce)
+}
+
+func TestNonceManagerReplacesStuckTransaction(t *testing.T) {
+	pool := newFakeMempool()
+	from, signer := newRelayer(t)
+	manager := NewNonceManager(pool, NonceManagerOptions{StuckTimeout: time.Minute, FeeBumpPercent: 15})
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	stuck, err := manager.Send(ctx, from, signer, TxRequest{To: &relayTarget, Value: big.NewInt(1000), Gas: 21000})
+	require.NoError(t, err)
+
+	// Not stuck until the timeout passes
+	now = now.Add(30 * time.Second)
+	assert.Empty(t, manager.ReplaceStuck(ctx))
+
+	now = now.Add(31 * time.Second)
+	replaced := manager.ReplaceStuck(ctx)
+	require.Len(t, replaced, 1)
+	replacement := replaced[0]
+	assert.Equal(t, stuck.Nonce, replacement.Nonce)
+	assert.NotEqual(t, stuck.Hash, replacement.Hash)
+	assert.Equal(t, 1, replacement.Replacements)
+	assert.Equal(t, big.NewInt(28_750_000_000), replacement.GasPrice)
+
+	// The pool holds the replacement, which is what gets mined
+	pool.mu.Lock()
+	pooled := pool.pool[from][stuck.Nonce]
+	pool.mu.Unlock()
+	assert.Equal(t, replacement.Hash, pooled.Hash().Hex())
+	assert.Equal(t, big.NewInt(1000), pooled.Value())
+
+	pool.mine(from)
+	now = now.Add(time.Hour)
+	assert.Empty(t, manager.ReplaceStuck(ctx))
+	assert.Empty(t, manager.InFlight(from))
+}
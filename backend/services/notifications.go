@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxNotificationsPerUser = 200
+
+// Notification is a message delivered to a user's in-app inbox
+type Notification struct {
+	ID        string      `json:"id"`
+	UserID    string      `json:"user_id"`
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Body      string      `json:"body"`
+	Data      interface{} `json:"data,omitempty"`
+	Read      bool        `json:"read"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// NotificationStore keeps a bounded in-memory inbox per user
+type NotificationStore struct {
+	mu     sync.RWMutex
+	inbox  map[string][]*Notification
+	nextID uint64
+	now    func() time.Time
+}
+
+// NewNotificationStore creates an empty notification store
+func NewNotificationStore() *NotificationStore {
+	return &NotificationStore{
+		inbox: make(map[string][]*Notification),
+		now:   utcNow,
+	}
+}
+
+// Add stores a notification for its user, evicting the oldest past the cap
+func (ns *NotificationStore) Add(notification Notification) *Notification {
+	notification.UserID = strings.ToLower(notification.UserID)
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.nextID++
+	notification.ID = fmt.Sprintf("ntf_%d", ns.nextID)
+	if notification.CreatedAt.IsZero() {
+		notification.CreatedAt = ns.now()
+	}
+
+	inbox := append(ns.inbox[notification.UserID], &notification)
+	if len(inbox) > maxNotificationsPerUser {
+		inbox = inbox[len(inbox)-maxNotificationsPerUser:]
+	}
+	ns.inbox[notification.UserID] = inbox
+
+	return &notification
+}
+
+// List returns a user's notifications newest first
+func (ns *NotificationStore) List(userID string, unreadOnly bool) []Notification {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	inbox := ns.inbox[strings.ToLower(userID)]
+	notifications := make([]Notification, 0, len(inbox))
+	for i := len(inbox) - 1; i >= 0; i-- {
+		if unreadOnly && inbox[i].Read {
+			continue
+		}
+		notifications = append(notifications, *inbox[i])
+	}
+	return notifications
+}
+
+// MarkRead marks a user's notification as read
+func (ns *NotificationStore) MarkRead(userID, id string) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	for _, notification := range ns.inbox[strings.ToLower(userID)] {
+		if notification.ID == id {
+			notification.Read = true
+			return true
+		}
+	}
+	return false
+}
+
+// UserData returns the user's notifications newest first
+func (ns *NotificationStore) UserData(userID string) interface{} {
+	return ns.List(userID, false)
+}
+
+// EraseUserData empties the user's inbox and returns how many notifications
+// were removed
+func (ns *NotificationStore) EraseUserData(userID string) int {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	userID = strings.ToLower(userID)
+	removed := len(ns.inbox[userID])
+	delete(ns.inbox, userID)
+	return removed
+}
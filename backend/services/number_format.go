@@ -0,0 +1,181 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Number locales, which set the separators of formatted numbers
+const (
+	// NumberLocaleEN writes 1,234.56
+	NumberLocaleEN = "en"
+	// NumberLocaleDE writes 1.234,56
+	NumberLocaleDE = "de"
+	// NumberLocaleFR writes 1 234,56, grouped by narrow no-break spaces
+	NumberLocaleFR = "fr"
+	// NumberLocaleKO writes 1,234.56
+	NumberLocaleKO = "ko"
+
+	// DefaultNumberLocale is the locale of users who haven't chosen one
+	DefaultNumberLocale = NumberLocaleEN
+
+	// compactThreshold is the smallest magnitude written in compact notation
+	compactThreshold = 1e6
+	// maxTokenDecimals are the most fractional digits a token amount keeps
+	maxTokenDecimals = 8
+)
+
+// numberSeparators are the thousands and decimal separators of each locale
+var numberSeparators = map[string][2]string{
+	NumberLocaleEN: {",", "."},
+	NumberLocaleDE: {".", ","},
+	NumberLocaleFR: {"\u202f", ","},
+	NumberLocaleKO: {",", "."},
+}
+
+// compactUnits are the suffixes of compact notation, smallest first
+var compactUnits = []struct {
+	scale  float64
+	suffix string
+}{
+	{1e6, "M"},
+	{1e9, "B"},
+	{1e12, "T"},
+}
+
+// NumberFormat writes numbers for people to read in chat: grouped by
+// thousands in the reader's locale, large values compacted, token amounts
+// and percentages trimmed to the digits that matter. The zero value writes
+// in the default locale.
+type NumberFormat struct {
+	group   string
+	decimal string
+}
+
+// NewNumberFormat returns the format of a locale, or of the default locale
+// for one that isn't supported
+func NewNumberFormat(locale string) NumberFormat {
+	separators, ok := numberSeparators[strings.ToLower(locale)]
+	if !ok {
+		separators = numberSeparators[DefaultNumberLocale]
+	}
+	return NumberFormat{group: separators[0], decimal: separators[1]}
+}
+
+// IsNumberLocale reports whether numbers can be formatted in the locale
+func IsNumberLocale(locale string) bool {
+	_, ok := numberSeparators[locale]
+	return ok
+}
+
+// Decimal writes a value with a fixed number of fractional digits,
+// grouped by thousands
+func (f NumberFormat) Decimal(value float64, decimals int) string {
+	return f.localize(strconv.FormatFloat(value, 'f', decimals, 64))
+}
+
+// Trimmed writes a value with at most the fractional digits, dropping
+// trailing zeros
+func (f NumberFormat) Trimmed(value float64, decimals int) string {
+	return f.localize(trimDecimal(strconv.FormatFloat(value, 'f', decimals, 64)))
+}
+
+// Compact writes a value of a million or more with a suffix, as 1.5M or
+// 2.3B, and smaller values grouped with at most two fractional digits
+func (f NumberFormat) Compact(value float64) string {
+	if math.Abs(math.Round(value*100)/100) < compactThreshold {
+		return f.Trimmed(value, 2)
+	}
+	unit := 0
+	for unit+1 < len(compactUnits) && math.Abs(value) >= compactUnits[unit+1].scale {
+		unit++
+	}
+	scaled := math.Round(value/compactUnits[unit].scale*10) / 10
+	// 999.96M rounds to 1000M, which reads as 1B
+	if math.Abs(scaled) >= 1000 && unit+1 < len(compactUnits) {
+		unit++
+		scaled = math.Round(value/compactUnits[unit].scale*10) / 10
+	}
+	return f.Trimmed(scaled, 1) + compactUnits[unit].suffix
+}
+
+// Token writes a token amount with the decimals its value calls for: four
+// when it is worth a dollar or more, and up to eight below, enough for four
+// significant digits. Without a price the amount is taken as its value.
+// Amounts too small to show are written as under the smallest unit shown.
+func (f NumberFormat) Token(amount, priceUSD float64) string {
+	value := math.Abs(amount)
+	if priceUSD > 0 {
+		value *= priceUSD
+	}
+	if value >= 1 || amount == 0 {
+		return f.Trimmed(amount, 4)
+	}
+
+	decimals := maxTokenDecimals
+	if magnitude := math.Abs(amount); magnitude > 0 {
+		// Leading zeros after the point, plus four significant digits
+		decimals = int(math.Ceil(-math.Log10(magnitude))) + 3
+		decimals = max(4, min(decimals, maxTokenDecimals))
+	}
+	written := f.Trimmed(amount, decimals)
+	if !hasNonZeroDigit(written) {
+		smallest := f.Trimmed(math.Pow10(-maxTokenDecimals), maxTokenDecimals)
+		if amount < 0 {
+			return ">-" + smallest
+		}
+		return "<" + smallest
+	}
+	return written
+}
+
+// Percent writes a percentage, already scaled to percent, with at most the
+// fractional digits
+func (f NumberFormat) Percent(percent float64, decimals int) string {
+	return f.Trimmed(percent, decimals) + "%"
+}
+
+// Change writes a percentage change with its sign, as +1.5% or -0.25%.
+// A change that rounds to zero has no sign.
+func (f NumberFormat) Change(percent float64, decimals int) string {
+	written := f.Percent(percent, decimals)
+	if percent > 0 && hasNonZeroDigit(written) {
+		return "+" + written
+	}
+	return written
+}
+
+// localize groups a plain decimal string by thousands and swaps in the
+// locale's separators
+func (f NumberFormat) localize(plain string) string {
+	if f.decimal == "" {
+		f = NewNumberFormat(DefaultNumberLocale)
+	}
+	sign := ""
+	if strings.HasPrefix(plain, "-") {
+		sign, plain = "-", plain[1:]
+	}
+	whole, fraction, hasFraction := strings.Cut(plain, ".")
+	// A value rounding to zero is written without its sign
+	if strings.Trim(whole+fraction, "0") == "" {
+		sign = ""
+	}
+
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(f.group)
+		}
+		grouped.WriteRune(digit)
+	}
+	if hasFraction {
+		return sign + grouped.String() + f.decimal + fraction
+	}
+	return sign + grouped.String()
+}
+
+// hasNonZeroDigit reports whether a written number is other than zero
+func hasNonZeroDigit(written string) bool {
+	return strings.ContainsAny(written, "123456789")
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumberFormatCompact(t *testing.T) {
+	var numbers NumberFormat
+	for value, want := range map[float64]string{
+		0:           "0",
+		1234.5:      "1,234.5",
+		999_999:     "999,999",
+		999_999.99:  "999,999.99",
+		999_999.999: "1M",
+		1_000_000:   "1M",
+		1_500_000:   "1.5M",
+		-2_340_000:  "-2.3M",
+		999_960_000: "1B",
+		2.3e9:       "2.3B",
+		4.56e12:     "4.6T",
+		7.5e15:      "7,500T",
+	} {
+		assert.Equal(t, want, numbers.Compact(value), "%v", value)
+	}
+}
+
+func TestNumberFormatToken(t *testing.T) {
+	var numbers NumberFormat
+	// Worth a dollar or more, four decimals are enough
+	assert.Equal(t, "1.2346", numbers.Token(1.2345678901, 2000))
+	assert.Equal(t, "12,345.5", numbers.Token(12345.5, 0))
+	// Worth less, four significant digits up to eight decimals
+	assert.Equal(t, "0.5", numbers.Token(0.5, 1))
+	assert.Equal(t, "0.1235", numbers.Token(0.12345, 1))
+	assert.Equal(t, "0.0001235", numbers.Token(0.00012346, 0))
+	assert.Equal(t, "0.00000001", numbers.Token(0.00000001234, 0))
+	assert.Equal(t, "-0.0001235", numbers.Token(-0.00012346, 0))
+	// Tiny amounts aren't written as zero
+	assert.Equal(t, "<0.00000001", numbers.Token(1e-10, 0))
+	assert.Equal(t, ">-0.00000001", numbers.Token(-1e-10, 0))
+	assert.Equal(t, "0", numbers.Token(0, 0))
+}
+
+func TestNumberFormatPercent(t *testing.T) {
+	var numbers NumberFormat
+	assert.Equal(t, "12.5%", numbers.Percent(12.5, 2))
+	assert.Equal(t, "+3.46%", numbers.Change(3.456, 2))
+	assert.Equal(t, "-3.46%", numbers.Change(-3.456, 2))
+	assert.Equal(t, "-0.1%", numbers.Change(-0.1, 1))
+	// Changes that round to zero have no sign
+	assert.Equal(t, "0%", numbers.Change(-0.001, 2))
+	assert.Equal(t, "0%", numbers.Change(0.001, 2))
+	assert.Equal(t, "-1,250%", numbers.Change(-1250, 2))
+}
+
+func TestNumberFormatLocales(t *testing.T) {
+	for locale, want := range map[string][2]string{
+		NumberLocaleEN: {"1,234,567.89", "1.5M"},
+		NumberLocaleDE: {"1.234.567,89", "1,5M"},
+		NumberLocaleFR: {"1\u202f234\u202f567,89", "1,5M"},
+		NumberLocaleKO: {"1,234,567.89", "1.5M"},
+		"xx":           {"1,234,567.89", "1.5M"},
+	} {
+		numbers := NewNumberFormat(locale)
+		assert.Equal(t, want[0], numbers.Decimal(1234567.891, 2), locale)
+		assert.Equal(t, want[1], numbers.Compact(1_500_000), locale)
+	}
+	assert.Equal(t, "-0,0001235", NewNumberFormat(NumberLocaleDE).Token(-0.00012346, 0))
+
+	rate, err := NewConversionRate("KRW", 0.00074, "coingecko", utcNow())
+	assert.NoError(t, err)
+	assert.Equal(t, "₩1.668.324", rate.WithLocale(NumberLocaleDE).Format(1234.56))
+	assert.Equal(t, "₩2M", rate.WithLocale(NumberLocaleDE).FormatCompact(1500))
+}
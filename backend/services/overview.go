@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOverviewBudget is how long an overview waits for its sections
+	DefaultOverviewBudget = 1500 * time.Millisecond
+	overviewTopYields     = 3
+	overviewSymbol        = "KAIA"
+	overviewCongestion    = time.Hour
+	overviewCacheKey      = "home"
+)
+
+// Overview section statuses
+const (
+	OverviewOK          = "ok"
+	OverviewUnavailable = "unavailable"
+)
+
+// OverviewSources provides the data the home page overview is composed from
+type OverviewSources interface {
+	NetworkStats(ctx context.Context) (map[string]interface{}, error)
+	GasOracle(ctx context.Context) (map[string]interface{}, error)
+	YieldOpportunities(ctx context.Context) ([]YieldOpportunity, error)
+	MarketData(ctx context.Context, symbols []string) ([]MarketData, error)
+	ProtocolData(ctx context.Context) ([]ProtocolData, error)
+	ActiveProposals(at time.Time) int
+	Congestion(window time.Duration) *CongestionReport
+}
+
+// OverviewSection is one section of the overview. Sections that failed or
+// didn't answer within the budget are unavailable, with the reason.
+type OverviewSection struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+	LatencyMs int64       `json:"latency_ms"`
+}
+
+// VolumeOverview is the DeFi volume across tracked protocols
+type VolumeOverview struct {
+	VolumeUSD float64 `json:"volume_usd"`
+	Protocols int     `json:"protocols"`
+}
+
+// GovernanceOverview counts the proposals open for voting
+type GovernanceOverview struct {
+	ActiveProposals int `json:"active_proposals"`
+}
+
+// CongestionOverview is the current congestion level, without the hourly breakdown
+type CongestionOverview struct {
+	Window         string  `json:"window"`
+	Level          string  `json:"level"`
+	Summary        string  `json:"summary"`
+	AvgUtilization float64 `json:"avg_utilization"`
+	FullBlockRatio float64 `json:"full_block_ratio"`
+}
+
+// Overview is the aggregated payload of the frontend home page
+type Overview struct {
+	Network    OverviewSection `json:"network"`
+	Gas        OverviewSection `json:"gas"`
+	TopYields  OverviewSection `json:"top_yields"`
+	Market     OverviewSection `json:"market"`
+	Volume24h  OverviewSection `json:"volume_24h"`
+	Governance OverviewSection `json:"governance"`
+	Congestion OverviewSection `json:"congestion"`
+	// Unavailable names the sections that are missing from this overview
+	Unavailable []string `json:"unavailable"`
+	GeneratedAt APITime  `json:"generated_at"`
+}
+
+// overviewBuild is an overview being composed, shared by the requests that
+// missed the cache meanwhile
+type overviewBuild struct {
+	done     chan struct{}
+	overview *Overview
+}
+
+// OverviewService composes the home page overview from its sources
+// concurrently within a time budget, and caches it for CacheTTLs[CacheOverview]
+type OverviewService struct {
+	sources OverviewSources
+	cache   *Cache
+	budget  time.Duration
+	logger  *log.Logger
+
+	mu       sync.Mutex
+	building *overviewBuild
+
+	now func() time.Time
+}
+
+// NewOverviewService creates an overview service caching in the given cache
+func NewOverviewService(sources OverviewSources, cache *Cache) *OverviewService {
+	return &OverviewService{
+		sources: sources,
+		cache:   cache,
+		budget:  DefaultOverviewBudget,
+		logger:  log.New(log.Writer(), "[OverviewService] ", log.LstdFlags),
+		now:     utcNow,
+	}
+}
+
+// SetBudget changes how long an overview waits for its sections
+func (s *OverviewService) SetBudget(budget time.Duration) {
+	if budget > 0 {
+		s.budget = budget
+	}
+}
+
+// Overview returns the cached overview, composing it when it has expired.
+// The second result reports whether it was served from the cache.
+func (s *OverviewService) Overview(ctx context.Context) (*Overview, bool) {
+	if cached, ok := s.cache.Get(CacheOverview, overviewCacheKey); ok {
+		return cached.(*Overview), true
+	}
+
+	s.mu.Lock()
+	build := s.building
+	if build == nil {
+		build = &overviewBuild{done: make(chan struct{})}
+		s.building = build
+		s.mu.Unlock()
+
+		// The build is shared, so it isn't cut short when the request that
+		// started it goes away
+		build.overview = s.build(context.WithoutCancel(ctx))
+		s.cache.Set(CacheOverview, overviewCacheKey, build.overview)
+
+		s.mu.Lock()
+		s.building = nil
+		s.mu.Unlock()
+		close(build.done)
+		return build.overview, false
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-build.done:
+		return build.overview, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// overviewLoader loads the data of one section
+type overviewLoader struct {
+	name    string
+	section *OverviewSection
+	load    func(ctx context.Context) (interface{}, error)
+}
+
+// build loads every section concurrently. Sections still loading when the
+// budget runs out are left unavailable; their results are discarded.
+func (s *OverviewService) build(ctx context.Context) *Overview {
+	ctx, cancel := context.WithTimeout(ctx, s.budget)
+	defer cancel()
+
+	overview := &Overview{Unavailable: make([]string, 0)}
+	loaders := []overviewLoader{
+		{"network", &overview.Network, func(ctx context.Context) (interface{}, error) {
+			return s.sources.NetworkStats(ctx)
+		}},
+		{"gas", &overview.Gas, func(ctx context.Context) (interface{}, error) {
+			return s.sources.GasOracle(ctx)
+		}},
+		{"top_yields", &overview.TopYields, s.topYields},
+		{"market", &overview.Market, s.market},
+		{"volume_24h", &overview.Volume24h, s.volume},
+		{"governance", &overview.Governance, func(context.Context) (interface{}, error) {
+			return GovernanceOverview{ActiveProposals: s.sources.ActiveProposals(s.now())}, nil
+		}},
+		{"congestion", &overview.Congestion, s.congestion},
+	}
+
+	type loaded struct {
+		index   int
+		data    interface{}
+		err     error
+		latency time.Duration
+	}
+	results := make(chan loaded, len(loaders))
+	started := time.Now()
+	for i, loader := range loaders {
+		go func(i int, load func(context.Context) (interface{}, error)) {
+			start := time.Now()
+			data, err := load(ctx)
+			results <- loaded{index: i, data: data, err: err, latency: time.Since(start)}
+		}(i, loader.load)
+	}
+
+	done := make([]bool, len(loaders))
+wait:
+	for pending := len(loaders); pending > 0; pending-- {
+		select {
+		case result := <-results:
+			done[result.index] = true
+			section := loaders[result.index].section
+			section.LatencyMs = result.latency.Milliseconds()
+			if result.err != nil {
+				s.logger.Printf("Failed to load overview %s: %v", loaders[result.index].name, result.err)
+				section.Status = OverviewUnavailable
+				section.Reason = result.err.Error()
+				continue
+			}
+			section.Status = OverviewOK
+			section.Data = result.data
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	for i, loader := range loaders {
+		if !done[i] {
+			loader.section.Status = OverviewUnavailable
+			loader.section.Reason = fmt.Sprintf("no answer within %s", s.budget)
+			loader.section.LatencyMs = time.Since(started).Milliseconds()
+		}
+		if loader.section.Status == OverviewUnavailable {
+			overview.Unavailable = append(overview.Unavailable, loader.name)
+		}
+	}
+	overview.GeneratedAt = NewAPITime(s.now())
+	return overview
+}
+
+// topYields returns the best yield opportunities by opportunity score
+func (s *OverviewService) topYields(ctx context.Context) (interface{}, error) {
+	opportunities, err := s.sources.YieldOpportunities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(opportunities) > overviewTopYields {
+		opportunities = opportunities[:overviewTopYields]
+	}
+	return opportunities, nil
+}
+
+// market returns the KAIA market data
+func (s *OverviewService) market(ctx context.Context) (interface{}, error) {
+	markets, err := s.sources.MarketData(ctx, []string{overviewSymbol})
+	if err != nil {
+		return nil, err
+	}
+	for _, market := range markets {
+		if market.Symbol == overviewSymbol {
+			return market, nil
+		}
+	}
+	return nil, fmt.Errorf("no market data for %s", overviewSymbol)
+}
+
+// volume sums the 24 hour volume of the tracked protocols
+func (s *OverviewService) volume(ctx context.Context) (interface{}, error) {
+	protocols, err := s.sources.ProtocolData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volume := VolumeOverview{Protocols: len(protocols)}
+	for _, protocol := range protocols {
+		volume.VolumeUSD += protocol.Volume24h
+	}
+	return volume, nil
+}
+
+// congestion returns the congestion level over the last hour
+func (s *OverviewService) congestion(context.Context) (interface{}, error) {
+	report := s.sources.Congestion(overviewCongestion)
+	if report == nil {
+		return nil, fmt.Errorf("no congestion report")
+	}
+	return CongestionOverview{
+		Window:         report.Window,
+		Level:          report.Level,
+		Summary:        report.Summary,
+		AvgUtilization: report.AvgUtilization,
+		FullBlockRatio: report.FullBlockRatio,
+	}, nil
+}
+
+// CollectorOverviewSources composes overviews from the running services
+type CollectorOverviewSources struct {
+	collector  *DataCollector
+	analytics  *AnalyticsEngine
+	congestion *CongestionTracker
+}
+
+// NewCollectorOverviewSources wires overview sources to the running services
+func NewCollectorOverviewSources(collector *DataCollector, analytics *AnalyticsEngine, congestion *CongestionTracker) *CollectorOverviewSources {
+	return &CollectorOverviewSources{
+		collector:  collector,
+		analytics:  analytics,
+		congestion: congestion,
+	}
+}
+
+// NetworkStats returns the latest block and chain details
+func (s *CollectorOverviewSources) NetworkStats(ctx context.Context) (map[string]interface{}, error) {
+	return s.collector.CollectNetworkStats(ctx)
+}
+
+// GasOracle returns the current gas prices and utilization
+func (s *CollectorOverviewSources) GasOracle(ctx context.Context) (map[string]interface{}, error) {
+	return s.collector.CollectGasData(ctx)
+}
+
+// YieldOpportunities returns the yield opportunities, best first
+func (s *CollectorOverviewSources) YieldOpportunities(ctx context.Context) ([]YieldOpportunity, error) {
+	return s.analytics.analyzeYieldOpportunities(ctx, nil)
+}
+
+// MarketData returns current market data for the symbols
+func (s *CollectorOverviewSources) MarketData(ctx context.Context, symbols []string) ([]MarketData, error) {
+	results := s.collector.CollectMarketData(ctx, symbols)
+	return results.Data(), results.Err()
+}
+
+// ProtocolData returns the tracked DeFi protocols
+func (s *CollectorOverviewSources) ProtocolData(ctx context.Context) ([]ProtocolData, error) {
+	return s.collector.CollectProtocolData(ctx)
+}
+
+// ActiveProposals counts the governance proposals open for voting
+func (s *CollectorOverviewSources) ActiveProposals(at time.Time) int {
+	return s.analytics.Governance().ActiveProposals(at)
+}
+
+// Congestion returns the block fullness over the window
+func (s *CollectorOverviewSources) Congestion(window time.Duration) *CongestionReport {
+	return s.congestion.Report(window)
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOverviewSources answers every section, with protocol data slowed down
+// by slow and counting the network stats loads
+type fakeOverviewSources struct {
+	slow      time.Duration
+	gasErr    error
+	networked int32
+}
+
+func (f *fakeOverviewSources) NetworkStats(context.Context) (map[string]interface{}, error) {
+	atomic.AddInt32(&f.networked, 1)
+	return map[string]interface{}{"latest_block": uint64(100)}, nil
+}
+
+func (f *fakeOverviewSources) GasOracle(context.Context) (map[string]interface{}, error) {
+	if f.gasErr != nil {
+		return nil, f.gasErr
+	}
+	return map[string]interface{}{"current_gas_price": uint64(25)}, nil
+}
+
+func (f *fakeOverviewSources) YieldOpportunities(context.Context) ([]YieldOpportunity, error) {
+	return []YieldOpportunity{{Protocol: "A"}, {Protocol: "B"}, {Protocol: "C"}, {Protocol: "D"}}, nil
+}
+
+func (f *fakeOverviewSources) MarketData(_ context.Context, symbols []string) ([]MarketData, error) {
+	return []MarketData{{Symbol: symbols[0], Price: 0.2}}, nil
+}
+
+func (f *fakeOverviewSources) ProtocolData(ctx context.Context) ([]ProtocolData, error) {
+	select {
+	case <-time.After(f.slow):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return []ProtocolData{{Protocol: "A", Volume24h: 1000}, {Protocol: "B", Volume24h: 500}}, nil
+}
+
+func (f *fakeOverviewSources) ActiveProposals(time.Time) int {
+	return 2
+}
+
+func (f *fakeOverviewSources) Congestion(window time.Duration) *CongestionReport {
+	return &CongestionReport{Window: window.String(), Level: "low", AvgUtilization: 0.3}
+}
+
+func TestOverviewReturnsPartialResultWithinBudget(t *testing.T) {
+	sources := &fakeOverviewSources{slow: 5 * time.Second, gasErr: errors.New("node down")}
+	service := NewOverviewService(sources, NewCache())
+	service.SetBudget(100 * time.Millisecond)
+
+	start := time.Now()
+	overview, cached := service.Overview(context.Background())
+	elapsed := time.Since(start)
+
+	assert.False(t, cached)
+	assert.Less(t, elapsed, time.Second, "the slow section doesn't hold up the response")
+	assert.Equal(t, []string{"gas", "volume_24h"}, overview.Unavailable)
+	assert.Equal(t, OverviewUnavailable, overview.Volume24h.Status)
+	assert.Contains(t, overview.Volume24h.Reason, "no answer within 100ms")
+	assert.Nil(t, overview.Volume24h.Data)
+	assert.Equal(t, "node down", overview.Gas.Reason)
+
+	assert.Equal(t, OverviewOK, overview.Network.Status)
+	yields := overview.TopYields.Data.([]YieldOpportunity)
+	require.Len(t, yields, 3)
+	assert.Equal(t, "C", yields[2].Protocol)
+	assert.Equal(t, "KAIA", overview.Market.Data.(MarketData).Symbol)
+	assert.Equal(t, GovernanceOverview{ActiveProposals: 2}, overview.Governance.Data)
+	assert.Equal(t, "low", overview.Congestion.Data.(CongestionOverview).Level)
+}
+
+func TestOverviewIsCachedFor15Seconds(t *testing.T) {
+	sources := &fakeOverviewSources{}
+	cache := NewCache()
+	now := time.Date(2025, 6, 2, 8, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	service := NewOverviewService(sources, cache)
+
+	first, cached := service.Overview(context.Background())
+	require.False(t, cached)
+	assert.Empty(t, first.Unavailable)
+	assert.Equal(t, VolumeOverview{VolumeUSD: 1500, Protocols: 2}, first.Volume24h.Data)
+
+	now = now.Add(14 * time.Second)
+	second, cached := service.Overview(context.Background())
+	assert.True(t, cached)
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sources.networked))
+
+	now = now.Add(time.Second)
+	_, cached = service.Overview(context.Background())
+	assert.False(t, cached, "recomposed once 15 seconds have passed")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&sources.networked))
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// analyticsPoolComponent names the analytics worker pool in panic reports
+const analyticsPoolComponent = "analytics_pool"
+
+// PanicReport is a recovered panic and where it happened
+type PanicReport struct {
+	// Component is what was running: "http" for a request handler, or the
+	// name of a worker pool
+	Component string    `json:"component"`
+	RequestID string    `json:"request_id,omitempty"`
+	Value     string    `json:"value"`
+	Stack     string    `json:"stack"`
+	Time      time.Time `json:"time"`
+}
+
+// PanicReporter is told of every recovered panic, to log or forward it
+type PanicReporter interface {
+	ReportPanic(report PanicReport)
+}
+
+// PanicGuard recovers panics in request handlers and background tasks,
+// counting them per component and passing them to its reporters, so a
+// panic is contained where it happened instead of crashing the process or
+// killing a pool worker
+type PanicGuard struct {
+	mu        sync.Mutex
+	counts    map[string]uint64
+	reporters []PanicReporter
+	logger    *log.Logger
+	now       func() time.Time
+}
+
+// NewPanicGuard creates a guard without reporters. Until one is added,
+// panics are written to the standard logger.
+func NewPanicGuard() *PanicGuard {
+	return &PanicGuard{
+		counts: make(map[string]uint64),
+		logger: log.New(log.Writer(), "[PanicGuard] ", log.LstdFlags),
+		now:    utcNow,
+	}
+}
+
+// AddReporter passes every panic recovered from now on to the reporter
+func (g *PanicGuard) AddReporter(reporter PanicReporter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.reporters = append(g.reporters, reporter)
+}
+
+// Handle counts and reports a recovered panic value
+func (g *PanicGuard) Handle(component, requestID string, value interface{}, stack []byte) PanicReport {
+	report := PanicReport{
+		Component: component,
+		RequestID: requestID,
+		Value:     fmt.Sprint(value),
+		Stack:     string(stack),
+		Time:      g.now(),
+	}
+
+	g.mu.Lock()
+	g.counts[component]++
+	reporters := g.reporters
+	g.mu.Unlock()
+
+	if len(reporters) == 0 {
+		g.logger.Printf("Failed with a panic in %s: %s\n%s", component, report.Value, report.Stack)
+	}
+	for _, reporter := range reporters {
+		reporter.ReportPanic(report)
+	}
+	return report
+}
+
+// Recover handles a panic of the calling goroutine. It must be deferred
+// directly: defer guard.Recover("analytics_pool").
+func (g *PanicGuard) Recover(component string) {
+	if value := recover(); value != nil {
+		g.Handle(component, "", value, debug.Stack())
+	}
+}
+
+// Wrap returns the task with its panics recovered and reported under the
+// component
+func (g *PanicGuard) Wrap(component string, task func()) func() {
+	return func() {
+		defer g.Recover(component)
+		task()
+	}
+}
+
+// Counts returns the panics recovered per component
+func (g *PanicGuard) Counts() map[string]uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	counts := make(map[string]uint64, len(g.counts))
+	for component, count := range g.counts {
+		counts[component] = count
+	}
+	return counts
+}
+
+// WritePrometheus exposes the panic counts
+func (g *PanicGuard) WritePrometheus(pw *PromWriter) {
+	counts := g.Counts()
+	components := make([]string, 0, len(counts))
+	for component := range counts {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		pw.Counter("kaia_panics_total", "Panics recovered per component.", float64(counts[component]), map[string]string{"component": component})
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// panicRecorder keeps the reported panics
+type panicRecorder struct {
+	mu      sync.Mutex
+	reports []PanicReport
+}
+
+func (r *panicRecorder) ReportPanic(report PanicReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+}
+
+func TestAnalyticsPoolContainsPanics(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+	guard := NewPanicGuard()
+	recorder := &panicRecorder{}
+	guard.AddReporter(recorder)
+	engine.SetPanicGuard(guard)
+
+	require.NoError(t, engine.Submit(func() { panic("bad task") }))
+
+	// Later tasks still run on the pool
+	done := make(chan struct{})
+	require.NoError(t, engine.Submit(func() { close(done) }))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the pool stopped running tasks")
+	}
+
+	require.Eventually(t, func() bool { return guard.Counts()[analyticsPoolComponent] == 1 }, time.Second, 5*time.Millisecond)
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	require.Len(t, recorder.reports, 1)
+	assert.Equal(t, "bad task", recorder.reports[0].Value)
+	assert.Contains(t, recorder.reports[0].Stack, "panics_test.go")
+
+	var buf bytes.Buffer
+	guard.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), `kaia_panics_total{component="analytics_pool"} 1`)
+}
+
+func TestSentryReporterSendsEvents(t *testing.T) {
+	events := make(chan map[string]interface{}, 1)
+	var auth, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, path = r.Header.Get("X-Sentry-Auth"), r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		var event map[string]interface{}
+		json.Unmarshal(body, &event)
+		events <- event
+	}))
+	defer server.Close()
+
+	_, err := NewSentryReporter("https://sentry.example.com/42", "test")
+	assert.ErrorContains(t, err, "invalid Sentry DSN")
+
+	dsn := "http://publickey@" + server.Listener.Addr().String() + "/42"
+	reporter, err := NewSentryReporter(dsn, "production")
+	require.NoError(t, err)
+	reporter.ReportPanic(PanicReport{Component: "http", RequestID: "req-1", Value: "boom", Stack: "goroutine 1", Time: time.Now()})
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "/api/42/store/", path)
+		assert.Contains(t, auth, "sentry_key=publickey")
+		assert.Equal(t, "panic: boom", event["message"])
+		assert.Equal(t, "production", event["environment"])
+		assert.Equal(t, map[string]interface{}{"component": "http", "request_id": "req-1"}, event["tags"])
+	case <-time.After(time.Second):
+		t.Fatal("no event was sent")
+	}
+}
@@ -0,0 +1,659 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNoPerformanceHistory is returned when an address has no snapshots to
+// measure performance from, usually because it hasn't opted in to tracking
+var ErrNoPerformanceHistory = errors.New("no portfolio snapshots to measure from")
+
+const (
+	portfolioSnapshotRetention = 400 * 24 * time.Hour
+	// portfolioRevalueAfter is how old the latest snapshot may be before a
+	// performance query values the portfolio again for its end point
+	portfolioRevalueAfter = time.Hour
+)
+
+// AssetValue is the USD value of one holding in a snapshot
+type AssetValue struct {
+	Symbol   string  `json:"symbol"`
+	Balance  float64 `json:"balance"`
+	PriceUSD float64 `json:"price_usd"`
+	ValueUSD float64 `json:"value_usd"`
+	// PriceSample is the stored price sample PriceUSD came from
+	PriceSample *PriceSample `json:"price_sample,omitempty"`
+	// LPPosition breaks down an LP token holding into its pool legs
+	LPPosition *LPPosition `json:"lp_position,omitempty"`
+}
+
+// PortfolioSnapshot is the valuation of an address's holdings at a point in time
+type PortfolioSnapshot struct {
+	Address   string       `json:"address"`
+	Timestamp time.Time    `json:"timestamp"`
+	TotalUSD  float64      `json:"total_usd"`
+	Assets    []AssetValue `json:"assets"`
+	// Partial is set when a holding couldn't be priced
+	Partial bool `json:"partial,omitempty"`
+}
+
+// PortfolioFlow is value moved into (positive) or out of (negative) a portfolio
+type PortfolioFlow struct {
+	Timestamp time.Time `json:"timestamp"`
+	Symbol    string    `json:"symbol"`
+	Amount    float64   `json:"amount"`
+	ValueUSD  float64   `json:"value_usd"`
+	TxHash    string    `json:"tx_hash,omitempty"`
+	// PriceSample is the stored price sample ValueUSD came from
+	PriceSample *PriceSample `json:"price_sample,omitempty"`
+	// Counterparty is the other side of the transfer, when known
+	Counterparty string `json:"counterparty,omitempty"`
+}
+
+// PortfolioFlowSource lists the deposits and withdrawals of an address.
+// complete is false while the flows in the window are still being indexed.
+type PortfolioFlowSource interface {
+	Flows(ctx context.Context, address common.Address, since, until time.Time) (flows []PortfolioFlow, complete bool, err error)
+}
+
+// PerformancePoint is the portfolio value at a point of the performance series
+type PerformancePoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	ValueUSD  float64   `json:"value_usd"`
+}
+
+// HoldingPerformance compares a holding at the start and end of the window
+type HoldingPerformance struct {
+	Symbol         string  `json:"symbol"`
+	StartValueUSD  float64 `json:"start_value_usd"`
+	EndValueUSD    float64 `json:"end_value_usd"`
+	StartPriceUSD  float64 `json:"start_price_usd"`
+	EndPriceUSD    float64 `json:"end_price_usd"`
+	PriceChangePct float64 `json:"price_change_pct"`
+}
+
+// PortfolioPerformance is how a portfolio did over a window. PnL excludes
+// deposits and withdrawals, and ReturnPct is the money-weighted return.
+type PortfolioPerformance struct {
+	Address        string               `json:"address"`
+	From           time.Time            `json:"from"`
+	To             time.Time            `json:"to"`
+	StartValueUSD  float64              `json:"start_value_usd"`
+	EndValueUSD    float64              `json:"end_value_usd"`
+	NetFlowsUSD    float64              `json:"net_flows_usd"`
+	PnLUSD         float64              `json:"pnl_usd"`
+	ReturnPct      float64              `json:"return_pct"`
+	Series         []PerformancePoint   `json:"series"`
+	Flows          []PortfolioFlow      `json:"flows"`
+	Holdings       []HoldingPerformance `json:"holdings"`
+	Best           *HoldingPerformance  `json:"best,omitempty"`
+	Worst          *HoldingPerformance  `json:"worst,omitempty"`
+	Partial        bool                 `json:"partial"`
+	PartialReasons []string             `json:"partial_reasons,omitempty"`
+
+	// Wallets are the linked wallets of a portfolio across a user's wallets
+	Wallets []string `json:"wallets,omitempty"`
+}
+
+func (pp *PortfolioPerformance) markPartial(reason string) {
+	pp.Partial = true
+	pp.PartialReasons = append(pp.PartialReasons, reason)
+}
+
+// PortfolioTracker values the holdings of opted-in addresses once a day and
+// measures their performance from the snapshots. Snapshots are kept in memory.
+type PortfolioTracker struct {
+	native NativeBalanceReader
+	tokens TokenBalanceReader
+	prices HistoricalPriceSource
+	flows  PortfolioFlowSource
+	logger *log.Logger
+	now    func() time.Time
+
+	mu        sync.RWMutex
+	enrolled  map[string]bool
+	snapshots map[string][]PortfolioSnapshot
+}
+
+// NewPortfolioTracker creates a tracker valuing holdings from the given sources
+func NewPortfolioTracker(native NativeBalanceReader, tokens TokenBalanceReader, prices HistoricalPriceSource, flows PortfolioFlowSource) *PortfolioTracker {
+	return &PortfolioTracker{
+		native:    native,
+		tokens:    tokens,
+		prices:    prices,
+		flows:     flows,
+		logger:    log.New(log.Writer(), "[PortfolioTracker] ", log.LstdFlags),
+		now:       utcNow,
+		enrolled:  make(map[string]bool),
+		snapshots: make(map[string][]PortfolioSnapshot),
+	}
+}
+
+// Enroll opts an address in to daily snapshots and takes the first one
+func (pt *PortfolioTracker) Enroll(ctx context.Context, address common.Address) (PortfolioSnapshot, error) {
+	snapshot, err := pt.Value(ctx, address)
+	if err != nil {
+		return PortfolioSnapshot{}, err
+	}
+
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.enrolled[snapshot.Address] = true
+	pt.record(snapshot)
+	return snapshot, nil
+}
+
+// Unenroll stops the daily snapshots of an address; its history is kept
+func (pt *PortfolioTracker) Unenroll(address common.Address) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	delete(pt.enrolled, strings.ToLower(address.Hex()))
+}
+
+// Enrolled reports whether the address gets daily snapshots
+func (pt *PortfolioTracker) Enrolled(address common.Address) bool {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	return pt.enrolled[strings.ToLower(address.Hex())]
+}
+
+// portfolioUserData is what the tracker keeps about an address
+type portfolioUserData struct {
+	Enrolled  bool                `json:"enrolled"`
+	Snapshots []PortfolioSnapshot `json:"snapshots"`
+}
+
+// UserData returns whether the address is enrolled and its snapshots
+func (pt *PortfolioTracker) UserData(userID string) interface{} {
+	pt.mu.RLock()
+	defer pt.mu.RUnlock()
+
+	userID = strings.ToLower(userID)
+	return portfolioUserData{
+		Enrolled:  pt.enrolled[userID],
+		Snapshots: append([]PortfolioSnapshot{}, pt.snapshots[userID]...),
+	}
+}
+
+// EraseUserData unenrolls the address and deletes its snapshots, returning
+// how many records were removed
+func (pt *PortfolioTracker) EraseUserData(userID string) int {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	userID = strings.ToLower(userID)
+	removed := len(pt.snapshots[userID])
+	if pt.enrolled[userID] {
+		removed++
+	}
+	delete(pt.enrolled, userID)
+	delete(pt.snapshots, userID)
+	return removed
+}
+
+// Start snapshots every enrolled address shortly after every UTC midnight
+// until ctx is cancelled
+func (pt *PortfolioTracker) Start(ctx context.Context) {
+	go func() {
+		for {
+			now := pt.now().UTC()
+			next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 5, 0, 0, time.UTC)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(next.Sub(now)):
+			}
+			pt.SnapshotAll(ctx)
+		}
+	}()
+}
+
+// SnapshotAll values every enrolled address and returns the number of
+// snapshots taken. Addresses that can't be valued are skipped until the next
+// run rather than recorded with a misleading total.
+func (pt *PortfolioTracker) SnapshotAll(ctx context.Context) int {
+	pt.mu.RLock()
+	addresses := make([]string, 0, len(pt.enrolled))
+	for address := range pt.enrolled {
+		addresses = append(addresses, address)
+	}
+	pt.mu.RUnlock()
+
+	taken := 0
+	for _, address := range addresses {
+		snapshot, err := pt.Value(ctx, common.HexToAddress(address))
+		if err != nil {
+			pt.logger.Printf("Failed to snapshot %s: %v", address, err)
+			continue
+		}
+
+		pt.mu.Lock()
+		pt.record(snapshot)
+		pt.mu.Unlock()
+		taken++
+	}
+	return taken
+}
+
+// record appends a snapshot and drops those past retention; the caller holds the lock
+func (pt *PortfolioTracker) record(snapshot PortfolioSnapshot) {
+	history := append(pt.snapshots[snapshot.Address], snapshot)
+	cutoff := snapshot.Timestamp.Add(-portfolioSnapshotRetention)
+	expired := sort.Search(len(history), func(i int) bool { return !history[i].Timestamp.Before(cutoff) })
+	pt.snapshots[snapshot.Address] = history[expired:]
+}
+
+// Value values the native and token holdings of an address now. Holdings
+// flagged as spam are left out: their prices can't be trusted and they would
+// show up as gains or losses the owner never had.
+func (pt *PortfolioTracker) Value(ctx context.Context, address common.Address) (PortfolioSnapshot, error) {
+	balance, err := pt.native.NativeBalance(ctx, address)
+	if err != nil {
+		return PortfolioSnapshot{}, fmt.Errorf("failed to read native balance: %w", err)
+	}
+	holdings, err := pt.tokens.TokenBalances(ctx, address)
+	if err != nil {
+		return PortfolioSnapshot{}, fmt.Errorf("failed to read token balances: %w", err)
+	}
+	now := pt.now().UTC()
+	sample, err := samplePrice(ctx, pt.prices, NativeSymbol, now)
+	if err != nil {
+		return PortfolioSnapshot{}, fmt.Errorf("failed to get %s price: %w", NativeSymbol, err)
+	}
+
+	native := AssetValue{Symbol: NativeSymbol, Balance: weiToFloat(balance, 18), PriceUSD: sample.PriceUSD, PriceSample: &sample}
+	native.ValueUSD = native.Balance * sample.PriceUSD
+	snapshot := PortfolioSnapshot{
+		Address:   strings.ToLower(address.Hex()),
+		Timestamp: now,
+		TotalUSD:  native.ValueUSD,
+		Assets:    []AssetValue{native},
+	}
+	holdings, _ = FilterSpam(holdings)
+	for _, holding := range holdings {
+		if holding.PriceUSD == 0 {
+			snapshot.Partial = true
+		}
+		snapshot.Assets = append(snapshot.Assets, AssetValue{
+			Symbol:      holding.Symbol,
+			Balance:     holding.Balance,
+			PriceUSD:    holding.PriceUSD,
+			ValueUSD:    holding.ValueUSD,
+			PriceSample: holding.PriceSample,
+			LPPosition:  holding.LPPosition,
+		})
+		snapshot.TotalUSD += holding.ValueUSD
+	}
+	return snapshot, nil
+}
+
+// Performance measures the portfolio from the last snapshot at or before
+// since to now, valuing the portfolio again when the newest snapshot is stale
+func (pt *PortfolioTracker) Performance(ctx context.Context, address common.Address, since time.Time) (*PortfolioPerformance, error) {
+	return pt.WalletsPerformance(ctx, []common.Address{address}, since)
+}
+
+// WalletsPerformance measures a user's wallets as one portfolio, reported
+// under the first. Each snapshot of the combined series adds up every
+// wallet's latest snapshot, starting once all of them have one. Transfers
+// between the wallets are neither deposits nor withdrawals. Wallets without
+// snapshots are left out and mark the result partial.
+func (pt *PortfolioTracker) WalletsPerformance(ctx context.Context, wallets []common.Address, since time.Time) (*PortfolioPerformance, error) {
+	key := strings.ToLower(wallets[0].Hex())
+	var windows [][]PortfolioSnapshot
+	var tracked, untracked []common.Address
+	for _, wallet := range wallets {
+		window := pt.window(ctx, wallet, since)
+		if len(window) < 2 {
+			untracked = append(untracked, wallet)
+			continue
+		}
+		windows = append(windows, window)
+		tracked = append(tracked, wallet)
+	}
+	if len(windows) == 0 {
+		return nil, ErrNoPerformanceHistory
+	}
+	window := combineSnapshots(key, windows)
+	if len(window) < 2 {
+		return nil, ErrNoPerformanceHistory
+	}
+
+	start, end := window[0], window[len(window)-1]
+	perf := &PortfolioPerformance{
+		Address:       key,
+		From:          start.Timestamp,
+		To:            end.Timestamp,
+		StartValueUSD: start.TotalUSD,
+		EndValueUSD:   end.TotalUSD,
+		Series:        make([]PerformancePoint, len(window)),
+		Flows:         make([]PortfolioFlow, 0),
+	}
+	if len(wallets) > 1 {
+		for _, wallet := range wallets {
+			perf.Wallets = append(perf.Wallets, strings.ToLower(wallet.Hex()))
+		}
+	}
+	for i, snapshot := range window {
+		perf.Series[i] = PerformancePoint{Timestamp: snapshot.Timestamp, ValueUSD: snapshot.TotalUSD}
+		if snapshot.Partial {
+			perf.Partial = true
+		}
+	}
+	if perf.Partial {
+		perf.PartialReasons = append(perf.PartialReasons, "some holdings couldn't be priced")
+	}
+	for _, wallet := range untracked {
+		perf.markPartial(fmt.Sprintf("%s has no portfolio snapshots yet", wallet.Hex()))
+	}
+
+	if pt.flows != nil {
+		linked := newWalletSet(tracked)
+		complete := true
+		for _, wallet := range tracked {
+			flows, walletComplete, err := pt.flows.Flows(ctx, wallet, start.Timestamp, end.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list deposits and withdrawals: %w", err)
+			}
+			complete = complete && walletComplete
+			// A flow at the start snapshot is already part of the starting value
+			for _, flow := range flows {
+				if flow.Timestamp.After(start.Timestamp) && !linked.internal(wallet.Hex(), flow.Counterparty) {
+					perf.Flows = append(perf.Flows, flow)
+					perf.NetFlowsUSD += flow.ValueUSD
+				}
+			}
+		}
+		if !complete {
+			perf.markPartial("deposits and withdrawals in the window are still being indexed")
+		}
+		sort.SliceStable(perf.Flows, func(i, j int) bool { return perf.Flows[i].Timestamp.Before(perf.Flows[j].Timestamp) })
+	}
+
+	perf.PnLUSD = end.TotalUSD - start.TotalUSD - perf.NetFlowsUSD
+	perf.ReturnPct = moneyWeightedReturn(start.TotalUSD, end.TotalUSD, start.Timestamp, end.Timestamp, perf.Flows) * 100
+	perf.Holdings = holdingPerformance(start, end)
+	for i := range perf.Holdings {
+		holding := &perf.Holdings[i]
+		if holding.StartPriceUSD == 0 || holding.EndPriceUSD == 0 {
+			continue
+		}
+		if perf.Best == nil || holding.PriceChangePct > perf.Best.PriceChangePct {
+			perf.Best = holding
+		}
+		if perf.Worst == nil || holding.PriceChangePct < perf.Worst.PriceChangePct {
+			perf.Worst = holding
+		}
+	}
+	return perf, nil
+}
+
+// window returns the address's snapshots from the last one at or before
+// since, valuing the portfolio again when the newest snapshot is stale
+func (pt *PortfolioTracker) window(ctx context.Context, address common.Address, since time.Time) []PortfolioSnapshot {
+	key := strings.ToLower(address.Hex())
+
+	pt.mu.RLock()
+	history := pt.snapshots[key]
+	first := sort.Search(len(history), func(i int) bool { return history[i].Timestamp.After(since) })
+	if first > 0 {
+		first--
+	}
+	window := append([]PortfolioSnapshot(nil), history[first:]...)
+	pt.mu.RUnlock()
+
+	if len(window) > 0 && pt.now().Sub(window[len(window)-1].Timestamp) > portfolioRevalueAfter {
+		current, err := pt.Value(ctx, address)
+		if err != nil {
+			pt.logger.Printf("Failed to value %s, measuring to the latest snapshot: %v", key, err)
+		} else {
+			window = append(window, current)
+		}
+	}
+	return window
+}
+
+// combineSnapshots merges the snapshot windows of several wallets into one
+// series, from the time every wallet has a snapshot. Each point adds up the
+// latest snapshot of every wallet at that time. A single window is returned
+// as is.
+func combineSnapshots(address string, windows [][]PortfolioSnapshot) []PortfolioSnapshot {
+	if len(windows) == 1 {
+		return windows[0]
+	}
+
+	var from time.Time
+	var times []time.Time
+	for _, window := range windows {
+		if window[0].Timestamp.After(from) {
+			from = window[0].Timestamp
+		}
+		for _, snapshot := range window {
+			times = append(times, snapshot.Timestamp)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var combined []PortfolioSnapshot
+	for i, at := range times {
+		if at.Before(from) || (i > 0 && at.Equal(times[i-1])) {
+			continue
+		}
+		snapshot := PortfolioSnapshot{Address: address, Timestamp: at}
+		assets := make(map[string]*AssetValue)
+		var symbols []string
+		for _, window := range windows {
+			latest := sort.Search(len(window), func(j int) bool { return window[j].Timestamp.After(at) }) - 1
+			part := window[latest]
+			snapshot.TotalUSD += part.TotalUSD
+			snapshot.Partial = snapshot.Partial || part.Partial
+			for _, asset := range part.Assets {
+				merged, ok := assets[asset.Symbol]
+				if !ok {
+					merged = &AssetValue{Symbol: asset.Symbol, PriceUSD: asset.PriceUSD}
+					assets[asset.Symbol] = merged
+					symbols = append(symbols, asset.Symbol)
+				}
+				merged.Balance += asset.Balance
+				merged.ValueUSD += asset.ValueUSD
+			}
+		}
+		for _, symbol := range symbols {
+			snapshot.Assets = append(snapshot.Assets, *assets[symbol])
+		}
+		combined = append(combined, snapshot)
+	}
+	return combined
+}
+
+// holdingPerformance compares each holding of the start and end snapshots,
+// largest end value first
+func holdingPerformance(start, end PortfolioSnapshot) []HoldingPerformance {
+	bySymbol := make(map[string]*HoldingPerformance)
+	holding := func(symbol string) *HoldingPerformance {
+		h, ok := bySymbol[symbol]
+		if !ok {
+			h = &HoldingPerformance{Symbol: symbol}
+			bySymbol[symbol] = h
+		}
+		return h
+	}
+	for _, asset := range start.Assets {
+		h := holding(asset.Symbol)
+		h.StartValueUSD, h.StartPriceUSD = asset.ValueUSD, asset.PriceUSD
+	}
+	for _, asset := range end.Assets {
+		h := holding(asset.Symbol)
+		h.EndValueUSD, h.EndPriceUSD = asset.ValueUSD, asset.PriceUSD
+	}
+
+	holdings := make([]HoldingPerformance, 0, len(bySymbol))
+	for _, h := range bySymbol {
+		if h.StartPriceUSD > 0 && h.EndPriceUSD > 0 {
+			h.PriceChangePct = (h.EndPriceUSD/h.StartPriceUSD - 1) * 100
+		}
+		holdings = append(holdings, *h)
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].EndValueUSD != holdings[j].EndValueUSD {
+			return holdings[i].EndValueUSD > holdings[j].EndValueUSD
+		}
+		return holdings[i].Symbol < holdings[j].Symbol
+	})
+	return holdings
+}
+
+// moneyWeightedReturn returns the period return r at which the starting value
+// and each flow, compounded over the rest of the period, grow into the end
+// value: start·(1+r) + Σ flow·(1+r)^w = end, where w is the share of the
+// period left after the flow. It falls back to the Modified Dietz
+// approximation when there is no root in range.
+func moneyWeightedReturn(start, end float64, from, to time.Time, flows []PortfolioFlow) float64 {
+	period := to.Sub(from).Seconds()
+	weights := make([]float64, len(flows))
+	net, weighted := 0.0, start
+	for i, flow := range flows {
+		if period > 0 {
+			weights[i] = to.Sub(flow.Timestamp).Seconds() / period
+		}
+		net += flow.ValueUSD
+		weighted += flow.ValueUSD * weights[i]
+	}
+	if weighted <= 0 {
+		return 0
+	}
+
+	growth := func(r float64) float64 {
+		value := start * (1 + r)
+		for i, flow := range flows {
+			value += flow.ValueUSD * math.Pow(1+r, weights[i])
+		}
+		return value - end
+	}
+
+	lo, hi := -0.9999, 10.0
+	fLo, fHi := growth(lo), growth(hi)
+	if fLo*fHi > 0 {
+		return (end - start - net) / weighted
+	}
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		fMid := growth(mid)
+		if (fMid < 0) == (fLo < 0) {
+			lo, fLo = mid, fMid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// NativeTransferFlows reads deposits and withdrawals of the native currency
+// from the transaction index, backfilling windows it doesn't cover yet.
+// Payments to contracts are left out: they buy tokens or positions that stay
+// in the portfolio, like a swap on a DEX, rather than leaving it.
+type NativeTransferFlows struct {
+	index     *TransactionIndex
+	prices    HistoricalPriceSource
+	backfills *ReceiptBackfiller
+	code      ContractCodeReader
+
+	mu        sync.Mutex
+	contracts map[string]bool
+}
+
+// NewNativeTransferFlows creates a flow source over the transaction index
+func NewNativeTransferFlows(index *TransactionIndex, prices HistoricalPriceSource, backfills *ReceiptBackfiller, code ContractCodeReader) *NativeTransferFlows {
+	return &NativeTransferFlows{
+		index:     index,
+		prices:    prices,
+		backfills: backfills,
+		code:      code,
+		contracts: make(map[string]bool),
+	}
+}
+
+// isContract reports whether the counterparty has code, caching the answer
+func (f *NativeTransferFlows) isContract(ctx context.Context, counterparty string) (bool, error) {
+	f.mu.Lock()
+	contract, ok := f.contracts[counterparty]
+	f.mu.Unlock()
+	if ok {
+		return contract, nil
+	}
+
+	code, err := f.code.CodeAt(ctx, common.HexToAddress(counterparty), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get code of %s: %w", counterparty, err)
+	}
+	contract = len(code) > 0
+
+	f.mu.Lock()
+	f.contracts[counterparty] = contract
+	f.mu.Unlock()
+	return contract, nil
+}
+
+// Flows returns the native transfers in and out of the address in [since, until]
+func (f *NativeTransferFlows) Flows(ctx context.Context, address common.Address, since, until time.Time) ([]PortfolioFlow, bool, error) {
+	coverage, covered := f.index.Coverage(address)
+	complete := covered && !coverage.From.After(since) && until.Sub(coverage.To) <= feeCoverageStaleness
+	if !complete && f.backfills != nil {
+		if _, err := f.backfills.Ensure(address, since, BackfillRequester(ctx)); err != nil && !errors.Is(err, ErrBackfillLimit) {
+			return nil, false, fmt.Errorf("failed to start backfill: %w", err)
+		}
+	}
+
+	key := strings.ToLower(address.Hex())
+	flows := make([]PortfolioFlow, 0)
+	for _, tx := range f.index.Transactions(address, since, until) {
+		if tx.Value == nil || tx.Value.Sign() == 0 || tx.From == tx.To {
+			continue
+		}
+		amount := weiToFloat(tx.Value, 18)
+		if tx.From == key {
+			amount = -amount
+			if tx.To != "" {
+				contract, err := f.isContract(ctx, tx.To)
+				if err != nil {
+					return nil, false, err
+				}
+				if contract {
+					continue
+				}
+			}
+		}
+
+		sample, err := samplePrice(ctx, f.prices, NativeSymbol, tx.Timestamp)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to price transfer %s: %w", tx.Hash, err)
+		}
+		counterparty := tx.To
+		if tx.To == key {
+			counterparty = tx.From
+		}
+		flows = append(flows, PortfolioFlow{
+			Timestamp:    tx.Timestamp,
+			Symbol:       NativeSymbol,
+			Amount:       amount,
+			ValueUSD:     amount * sample.PriceUSD,
+			TxHash:       tx.Hash,
+			PriceSample:  &sample,
+			Counterparty: counterparty,
+		})
+	}
+	return flows, complete, nil
+}
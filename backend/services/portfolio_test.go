@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// constantPrice prices the native token the same at every point in time
+type constantPrice float64
+
+func (c constantPrice) PriceAt(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	return float64(c), nil
+}
+
+func kaia(amount int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(amount), big.NewInt(1e18))
+}
+
+func TestMoneyWeightedReturn(t *testing.T) {
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 30)
+	mid := from.AddDate(0, 0, 15)
+
+	// Without flows it is the simple return
+	assert.InDelta(t, 0.1, moneyWeightedReturn(1000, 1100, from, to, nil), 1e-9)
+
+	// 1000·(1+r) − 500·(1+r)^½ = 600
+	withdrawal := []PortfolioFlow{{Timestamp: mid, ValueUSD: -500}}
+	r := moneyWeightedReturn(1000, 600, from, to, withdrawal)
+	assert.InDelta(t, 0.13197, r, 1e-4)
+	assert.InDelta(t, 600, 1000*(1+r)-500*math.Sqrt(1+r), 1e-6)
+}
+
+func TestPortfolioPerformanceExcludesDeposits(t *testing.T) {
+	end := time.Now().UTC()
+	clock := end.AddDate(0, 0, -30).Add(-time.Minute)
+	start := clock
+	other := "0x00000000000000000000000000000000000000cc"
+
+	native := &fakeNativeBalances{balance: kaia(1000)}
+	tokens := &fakeTokenBalances{holdings: []TokenHolding{{Symbol: "USDT", Balance: 500, PriceUSD: 1, ValueUSD: 500}}}
+	prices := fakePrices{NativeSymbol: 1.0}
+	index := NewTransactionIndex()
+	tracker := NewPortfolioTracker(native, tokens, prices, NewNativeTransferFlows(index, constantPrice(1.0), nil, deployedCode{}))
+	tracker.now = func() time.Time { return clock }
+
+	_, err := tracker.Enroll(context.Background(), summaryAddress)
+	require.NoError(t, err)
+	assert.True(t, tracker.Enrolled(summaryAddress))
+
+	// Halfway through, 1000 KAIA is deposited and the daily snapshot sees it
+	deposit := start.AddDate(0, 0, 15)
+	index.Add(IndexedTransaction{Hash: "0xd1", From: other, To: summaryAddress.Hex(), Timestamp: deposit, Value: kaia(1000)})
+	// Outgoing transfers that fail carry no value
+	index.Add(IndexedTransaction{Hash: "0xd2", From: summaryAddress.Hex(), To: other, Timestamp: deposit.Add(time.Hour)})
+	index.MarkIndexed(summaryAddress, IndexedRange{FromBlock: 1, ToBlock: 100, From: start.Add(-time.Hour), To: end.Add(time.Hour)}, time.Time{})
+	native.balance = kaia(2000)
+	clock = deposit.Add(time.Hour)
+	assert.Equal(t, 1, tracker.SnapshotAll(context.Background()))
+
+	// By the end KAIA is up 5%
+	prices[NativeSymbol] = 1.05
+	clock = end
+
+	perf, err := tracker.Performance(context.Background(), summaryAddress, end.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.Equal(t, start, perf.From)
+	assert.Len(t, perf.Series, 3)
+	assert.InDelta(t, 1500, perf.StartValueUSD, 1e-9)
+	assert.InDelta(t, 2600, perf.EndValueUSD, 1e-9)
+	assert.InDelta(t, 1000, perf.NetFlowsUSD, 1e-9)
+	assert.InDelta(t, 100, perf.PnLUSD, 1e-9)
+	// 1500·(1+r) + 1000·(1+r)^½ = 2600, rather than the 73% of end over start
+	assert.InDelta(t, 5.0154, perf.ReturnPct, 1e-3)
+	assert.False(t, perf.Partial)
+
+	require.NotNil(t, perf.Best)
+	assert.Equal(t, "KAIA", perf.Best.Symbol)
+	assert.InDelta(t, 5, perf.Best.PriceChangePct, 1e-9)
+	assert.Equal(t, "USDT", perf.Worst.Symbol)
+
+	engine := newTestChatEngine(t)
+	engine.SetPortfolioTracker(tracker)
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+		UserID:  summaryAddress.Hex(),
+		Message: "How is my portfolio doing over the last 30 days?",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "portfolio_performance", response.Type)
+	assert.Contains(t, response.Response, "PnL: +100.00 USD (+5.02% money-weighted)")
+	assert.Contains(t, response.Response, "Net Deposits: +1,000.00 USD")
+	assert.Contains(t, response.Response, "Best: KAIA +5%")
+
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{
+		UserID:  common.HexToAddress(other).Hex(),
+		Message: "Am I up this month?",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "portfolio_performance", response.Type)
+	assert.Contains(t, response.Response, "don't have portfolio snapshots")
+}
+
+func TestPortfolioPerformanceIgnoresSwaps(t *testing.T) {
+	end := time.Now().UTC()
+	clock := end.AddDate(0, 0, -30).Add(-time.Minute)
+	start := clock
+	dex := common.HexToAddress(feeDex)
+
+	native := &fakeNativeBalances{balance: kaia(1000)}
+	tokens := &fakeTokenBalances{}
+	prices := fakePrices{NativeSymbol: 1.0}
+	index := NewTransactionIndex()
+	tracker := NewPortfolioTracker(native, tokens, prices, NewNativeTransferFlows(index, constantPrice(1.0), nil, deployedCode{dex: true}))
+	tracker.now = func() time.Time { return clock }
+
+	_, err := tracker.Enroll(context.Background(), summaryAddress)
+	require.NoError(t, err)
+
+	// Halfway through, 500 KAIA is swapped for 500 USDT on a DEX, which moves
+	// value between holdings rather than out of the portfolio
+	swap := start.AddDate(0, 0, 15)
+	index.Add(IndexedTransaction{Hash: "0xe1", From: summaryAddress.Hex(), To: dex.Hex(), Timestamp: swap, Value: kaia(500)})
+	index.MarkIndexed(summaryAddress, IndexedRange{FromBlock: 1, ToBlock: 100, From: start.Add(-time.Hour), To: end.Add(time.Hour)}, time.Time{})
+	native.balance = kaia(500)
+	tokens.holdings = []TokenHolding{{Symbol: "USDT", Balance: 500, PriceUSD: 1, ValueUSD: 500}}
+	clock = swap.Add(time.Hour)
+	assert.Equal(t, 1, tracker.SnapshotAll(context.Background()))
+	clock = end
+
+	perf, err := tracker.Performance(context.Background(), summaryAddress, end.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.InDelta(t, 1000, perf.StartValueUSD, 1e-9)
+	assert.InDelta(t, 1000, perf.EndValueUSD, 1e-9)
+	assert.Zero(t, perf.NetFlowsUSD)
+	assert.InDelta(t, 0, perf.PnLUSD, 1e-9)
+	assert.InDelta(t, 0, perf.ReturnPct, 1e-6)
+}
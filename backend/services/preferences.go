@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Risk tolerances
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+const (
+	// DefaultSlippage is the swap slippage tolerance used without a preference, in percent
+	DefaultSlippage = 0.5
+	// MaxSlippage is the largest slippage tolerance a user can set, in percent
+	MaxSlippage = 50.0
+	// MaxFavoriteTokens bounds the favorite tokens a user can keep
+	MaxFavoriteTokens = 20
+)
+
+var (
+	riskLevels = map[string]int{RiskLow: 0, RiskMedium: 1, RiskHigh: 2}
+
+	// displayCurrencies are the currencies values can be shown in; each is
+	// priced in USD by the data collector
+	displayCurrencies = map[string]bool{"USD": true, "KRW": true, "ETH": true}
+
+	tokenSymbolRegex = regexp.MustCompile(`^[A-Z0-9]{1,11}$`)
+)
+
+// NotificationPreferences selects the alerts pushed to a user's chat connection
+type NotificationPreferences struct {
+	Anomalies bool `json:"anomalies"`
+	Prices    bool `json:"prices"`
+}
+
+// UserPreferences are the defaults applied to a user's chat and analytics
+// requests when the request doesn't say otherwise
+type UserPreferences struct {
+	RiskTolerance string `json:"risk_tolerance"`
+	// DisplayCurrency is the currency values are shown in: USD, KRW or ETH
+	DisplayCurrency string `json:"display_currency"`
+	// Locale sets the separators numbers are written with in chat: en, de,
+	// fr or ko
+	Locale string `json:"locale"`
+	// DefaultSlippage is the swap slippage tolerance, in percent
+	DefaultSlippage float64 `json:"default_slippage"`
+	// FavoriteTokens are symbols suggestions favor; price alerts are limited
+	// to them when set
+	FavoriteTokens []string                `json:"favorite_tokens"`
+	Notifications  NotificationPreferences `json:"notifications"`
+	UpdatedAt      time.Time               `json:"updated_at,omitempty"`
+}
+
+// DefaultUserPreferences returns the preferences of a user who hasn't saved any
+func DefaultUserPreferences() UserPreferences {
+	return UserPreferences{
+		RiskTolerance:   RiskMedium,
+		DisplayCurrency: "USD",
+		Locale:          DefaultNumberLocale,
+		DefaultSlippage: DefaultSlippage,
+		FavoriteTokens:  []string{},
+		Notifications:   NotificationPreferences{Anomalies: true, Prices: true},
+	}
+}
+
+// Validate checks every field and normalizes case and favorite tokens,
+// reporting all problems at once
+func (p *UserPreferences) Validate() error {
+	var problems []string
+
+	p.RiskTolerance = strings.ToLower(strings.TrimSpace(p.RiskTolerance))
+	if _, ok := riskLevels[p.RiskTolerance]; !ok {
+		problems = append(problems, fmt.Sprintf("risk_tolerance must be low, medium or high, got %q", p.RiskTolerance))
+	}
+
+	p.DisplayCurrency = strings.ToUpper(strings.TrimSpace(p.DisplayCurrency))
+	if !displayCurrencies[p.DisplayCurrency] {
+		problems = append(problems, fmt.Sprintf("display_currency must be USD, KRW or ETH, got %q", p.DisplayCurrency))
+	}
+
+	// Clients predating locales leave it out
+	p.Locale = strings.ToLower(strings.TrimSpace(p.Locale))
+	if p.Locale == "" {
+		p.Locale = DefaultNumberLocale
+	}
+	if !IsNumberLocale(p.Locale) {
+		problems = append(problems, fmt.Sprintf("locale must be en, de, fr or ko, got %q", p.Locale))
+	}
+
+	if p.DefaultSlippage <= 0 || p.DefaultSlippage > MaxSlippage {
+		problems = append(problems, fmt.Sprintf("default_slippage must be above 0 and at most %g percent, got %g", MaxSlippage, p.DefaultSlippage))
+	}
+
+	favorites := make([]string, 0, len(p.FavoriteTokens))
+	seen := make(map[string]bool)
+	for _, token := range p.FavoriteTokens {
+		token = strings.ToUpper(strings.TrimSpace(token))
+		if !tokenSymbolRegex.MatchString(token) {
+			problems = append(problems, fmt.Sprintf("favorite_tokens has an invalid symbol %q", token))
+			continue
+		}
+		if !seen[token] {
+			seen[token] = true
+			favorites = append(favorites, token)
+		}
+	}
+	if len(favorites) > MaxFavoriteTokens {
+		problems = append(problems, fmt.Sprintf("favorite_tokens holds at most %d tokens, got %d", MaxFavoriteTokens, len(favorites)))
+	}
+	p.FavoriteTokens = favorites
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// IsFavorite reports whether a symbol is one of the favorite tokens
+func (p UserPreferences) IsFavorite(symbol string) bool {
+	for _, token := range p.FavoriteTokens {
+		if strings.EqualFold(token, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// WantsPrice reports whether a price alert for the symbol should reach the user
+func (p UserPreferences) WantsPrice(symbol string) bool {
+	return p.Notifications.Prices && (len(p.FavoriteTokens) == 0 || p.IsFavorite(symbol))
+}
+
+// ApplyDefaults fills analytics task parameters the request left out from
+// the preferences. Parameters that are present are kept as they are.
+func (p UserPreferences) ApplyDefaults(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	defaults := map[string]interface{}{
+		"risk_tolerance":   p.RiskTolerance,
+		"display_currency": p.DisplayCurrency,
+		"slippage":         p.DefaultSlippage,
+	}
+	if len(p.FavoriteTokens) > 0 {
+		defaults["favorite_tokens"] = append([]string(nil), p.FavoriteTokens...)
+	}
+	for key, value := range defaults {
+		if _, ok := params[key]; !ok {
+			params[key] = value
+		}
+	}
+	return params
+}
+
+// PreferenceStore keeps user preferences keyed by address
+type PreferenceStore struct {
+	mu          sync.RWMutex
+	preferences map[string]UserPreferences
+	now         func() time.Time
+}
+
+// NewPreferenceStore creates an empty preference store
+func NewPreferenceStore() *PreferenceStore {
+	return &PreferenceStore{
+		preferences: make(map[string]UserPreferences),
+		now:         utcNow,
+	}
+}
+
+// Update validates and stores a user's preferences
+func (ps *PreferenceStore) Update(userID string, preferences UserPreferences) (UserPreferences, error) {
+	if err := preferences.Validate(); err != nil {
+		return UserPreferences{}, err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	preferences.UpdatedAt = ps.now().UTC()
+	ps.preferences[strings.ToLower(userID)] = preferences
+	return preferences, nil
+}
+
+// Preferences returns a user's saved preferences, or the defaults when the
+// user hasn't saved any
+func (ps *PreferenceStore) Preferences(userID string) UserPreferences {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	preferences, ok := ps.preferences[strings.ToLower(userID)]
+	if !ok {
+		return DefaultUserPreferences()
+	}
+	preferences.FavoriteTokens = append([]string{}, preferences.FavoriteTokens...)
+	return preferences
+}
+
+// UserData returns the user's saved preferences, or nil when none are saved
+func (ps *PreferenceStore) UserData(userID string) interface{} {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	preferences, ok := ps.preferences[strings.ToLower(userID)]
+	if !ok {
+		return nil
+	}
+	return preferences
+}
+
+// EraseUserData deletes the user's preferences and returns how many records
+// were removed
+func (ps *PreferenceStore) EraseUserData(userID string) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	userID = strings.ToLower(userID)
+	if _, ok := ps.preferences[userID]; !ok {
+		return 0
+	}
+	delete(ps.preferences, userID)
+	return 1
+}
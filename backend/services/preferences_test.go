@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const preferencesUser = "0x00000000000000000000000000000000000000A1"
+
+func TestUserPreferencesValidate(t *testing.T) {
+	preferences := UserPreferences{
+		RiskTolerance:   " Low ",
+		DisplayCurrency: "krw",
+		DefaultSlippage: 1,
+		FavoriteTokens:  []string{"kaia", " ETH", "KAIA"},
+		Locale:          "DE",
+	}
+	require.NoError(t, preferences.Validate())
+	assert.Equal(t, RiskLow, preferences.RiskTolerance)
+	assert.Equal(t, "KRW", preferences.DisplayCurrency)
+	assert.Equal(t, NumberLocaleDE, preferences.Locale)
+	assert.Equal(t, []string{"KAIA", "ETH"}, preferences.FavoriteTokens)
+
+	defaults := DefaultUserPreferences()
+	require.NoError(t, defaults.Validate())
+
+	invalid := UserPreferences{
+		RiskTolerance:   "yolo",
+		DisplayCurrency: "EUR",
+		DefaultSlippage: 0,
+		FavoriteTokens:  []string{"NOT-A-TOKEN"},
+		Locale:          "xx",
+	}
+	err := invalid.Validate()
+	require.Error(t, err)
+	for _, problem := range []string{"risk_tolerance", "display_currency", "default_slippage", "favorite_tokens", "locale"} {
+		assert.Contains(t, err.Error(), problem)
+	}
+
+	tooMany := DefaultUserPreferences()
+	for i := 0; i <= MaxFavoriteTokens; i++ {
+		tooMany.FavoriteTokens = append(tooMany.FavoriteTokens, "T"+string(rune('A'+i)))
+	}
+	assert.ErrorContains(t, tooMany.Validate(), "at most 20 tokens")
+
+	tooLoose := DefaultUserPreferences()
+	tooLoose.DefaultSlippage = 51
+	assert.ErrorContains(t, tooLoose.Validate(), "default_slippage")
+}
+
+func TestPreferenceStoreDefaultsAndAlerts(t *testing.T) {
+	store := NewPreferenceStore()
+	store.now = func() time.Time { return time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC) }
+
+	assert.Equal(t, DefaultUserPreferences(), store.Preferences(preferencesUser))
+
+	saved := DefaultUserPreferences()
+	saved.RiskTolerance = "high"
+	saved.FavoriteTokens = []string{"kaia"}
+	saved.Notifications.Anomalies = false
+	updated, err := store.Update(preferencesUser, saved)
+	require.NoError(t, err)
+	assert.Equal(t, store.now(), updated.UpdatedAt)
+
+	// Addresses are matched case-insensitively
+	preferences := store.Preferences("0x00000000000000000000000000000000000000a1")
+	assert.Equal(t, RiskHigh, preferences.RiskTolerance)
+	assert.True(t, preferences.WantsPrice("KAIA"))
+	assert.False(t, preferences.WantsPrice("ETH"))
+	assert.True(t, DefaultUserPreferences().WantsPrice("ETH"))
+
+	_, err = store.Update(preferencesUser, UserPreferences{RiskTolerance: "high"})
+	assert.Error(t, err)
+	assert.Equal(t, RiskHigh, store.Preferences(preferencesUser).RiskTolerance, "an invalid update keeps the saved preferences")
+}
+
+func TestApplyDefaultsKeepsRequestParameters(t *testing.T) {
+	preferences := DefaultUserPreferences()
+	preferences.RiskTolerance = RiskLow
+	preferences.DefaultSlippage = 1.5
+	preferences.FavoriteTokens = []string{"DAI"}
+
+	params := preferences.ApplyDefaults(map[string]interface{}{"risk_tolerance": "high"})
+	assert.Equal(t, "high", params["risk_tolerance"])
+	assert.Equal(t, 1.5, params["slippage"])
+	assert.Equal(t, "USD", params["display_currency"])
+	assert.Equal(t, []string{"DAI"}, params["favorite_tokens"])
+
+	assert.Equal(t, RiskLow, preferences.ApplyDefaults(nil)["risk_tolerance"])
+}
+
+func TestTradingSuggestionsFollowPreferences(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { engine.Close() })
+
+	suggest := func(params map[string]interface{}) []TradingSuggestion {
+		params["user_address"] = preferencesUser
+		result, err := engine.ProcessAnalyticsTask(context.Background(), "trading_suggestions", params)
+		require.NoError(t, err)
+		return result.Data.([]TradingSuggestion)
+	}
+
+	all := suggest(map[string]interface{}{})
+	require.Len(t, all, 3)
+	assert.Equal(t, "DAI", all[0].Asset, "the most confident suggestion comes first")
+	assert.Zero(t, all[0].Slippage)
+
+	preferences := DefaultUserPreferences()
+	preferences.RiskTolerance = RiskLow
+	preferences.FavoriteTokens = []string{"DAI"}
+	preferences.DefaultSlippage = 0.3
+	tailored := suggest(preferences.ApplyDefaults(map[string]interface{}{}))
+	require.Len(t, tailored, 2, "medium risk suggestions are dropped")
+	assert.Equal(t, "DAI", tailored[0].Asset, "favorites come first")
+	assert.Equal(t, "USDC", tailored[1].Asset)
+	assert.Equal(t, 0.3, tailored[0].Slippage)
+}
+
+func TestChatPortfolioUsesSavedRiskTolerance(t *testing.T) {
+	engine := newTestChatEngine(t)
+	store := NewPreferenceStore()
+	engine.SetPreferenceStore(store)
+
+	analyze := func() map[string]interface{} {
+		response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+			ID:      "m1",
+			UserID:  preferencesUser,
+			Message: "Analyze my portfolio",
+		})
+		require.NoError(t, err)
+		return response.Data.(map[string]interface{})
+	}
+
+	optimization := analyze()
+	assert.Equal(t, RiskMedium, optimization["risk_tolerance"])
+	mediumAllocation := optimization["recommended_allocation"]
+
+	saved := DefaultUserPreferences()
+	saved.RiskTolerance = RiskLow
+	_, err := store.Update(preferencesUser, saved)
+	require.NoError(t, err)
+
+	optimization = analyze()
+	assert.Equal(t, RiskLow, optimization["risk_tolerance"])
+	assert.NotEqual(t, mediumAllocation, optimization["recommended_allocation"])
+	assert.Equal(t, 0.4, optimization["recommended_allocation"].(map[string]float64)["USDC"])
+}
+
+func TestChatMarketDataUsesDisplayCurrency(t *testing.T) {
+	engine := newTestChatEngine(t)
+	store := NewPreferenceStore()
+	engine.SetPreferenceStore(store)
+
+	saved := DefaultUserPreferences()
+	saved.DisplayCurrency = "KRW"
+	saved.FavoriteTokens = []string{"KAIA"}
+	_, err := store.Update(preferencesUser, saved)
+	require.NoError(t, err)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{
+		ID:      "m1",
+		UserID:  preferencesUser,
+		Message: "What is the market price of ETH?",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "market_data", response.Type)
+	// 3200 USD at 0.00074 USD per won
+	assert.Contains(t, response.Response, "**ETH**: ₩4,324,324")
+	assert.Contains(t, response.Response, "**KAIA**: ₩203")
+	assert.NotContains(t, response.Response, "$")
+}
@@ -0,0 +1,525 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxPriceAlertsPerUser bounds the active price alerts of one user
+const MaxPriceAlertsPerUser = 20
+
+// Price alert directions
+const (
+	PriceAlertAbove = "above"
+	PriceAlertBelow = "below"
+	// PriceAlertEither fires on a percentage move in either direction
+	PriceAlertEither = "either"
+
+	// priceAlertReaches is a target price to be reached from wherever the
+	// price is, resolved to above or below when the alert is created
+	priceAlertReaches = "reaches"
+)
+
+var (
+	// ErrPriceAlertNotFound is returned for unknown alerts and other users' alerts
+	ErrPriceAlertNotFound = errors.New("price alert not found")
+	// ErrPriceAlertLimit is returned when a user already has the most alerts allowed
+	ErrPriceAlertLimit = fmt.Errorf("at most %d active price alerts are allowed", MaxPriceAlertsPerUser)
+	// ErrNoLivePrice is returned for symbols without a live price to watch
+	ErrNoLivePrice = errors.New("no live price")
+)
+
+// AmbiguousAlertError is returned for alert requests that can't be turned
+// into a rule; Question asks the user for what is missing
+type AmbiguousAlertError struct {
+	Question string
+}
+
+func (e *AmbiguousAlertError) Error() string {
+	return "ambiguous price alert: " + e.Question
+}
+
+// PriceAlertSpec is the rule a message asks for: either an absolute price or
+// a percentage move from the price when the alert is created
+type PriceAlertSpec struct {
+	Symbol    string  `json:"symbol"`
+	Direction string  `json:"direction"`
+	Price     float64 `json:"price,omitempty"`
+	Percent   float64 `json:"percent,omitempty"`
+}
+
+// PriceAlert is a user's active or fired price alert. It fires once, when a
+// live price crosses Threshold, or moves Percent from BasePrice either way.
+type PriceAlert struct {
+	ID        string  `json:"id"`
+	UserID    string  `json:"user_id"`
+	Symbol    string  `json:"symbol"`
+	Direction string  `json:"direction"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Percent   float64 `json:"percent,omitempty"`
+	// BasePrice is the live price when the alert was created
+	BasePrice float64 `json:"base_price"`
+	// MessageID and Message are the chat message that asked for the alert
+	MessageID  string   `json:"message_id,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	CreatedAt  APITime  `json:"created_at"`
+	FiredAt    *APITime `json:"fired_at,omitempty"`
+	FiredPrice float64  `json:"fired_price,omitempty"`
+}
+
+// Describe states the alert's condition, such as "KAIA is at or above $1.5"
+func (a PriceAlert) Describe() string {
+	var numbers NumberFormat
+	switch {
+	case a.Direction == PriceAlertEither:
+		return fmt.Sprintf("%s moves %s either way from $%s", a.Symbol, numbers.Percent(a.Percent, 2), numbers.Token(a.BasePrice, 1))
+	case a.Percent > 0 && a.Direction == PriceAlertAbove:
+		return fmt.Sprintf("%s rises %s from $%s, to $%s", a.Symbol, numbers.Percent(a.Percent, 2), numbers.Token(a.BasePrice, 1), numbers.Token(a.Threshold, 1))
+	case a.Percent > 0:
+		return fmt.Sprintf("%s drops %s from $%s, to $%s", a.Symbol, numbers.Percent(a.Percent, 2), numbers.Token(a.BasePrice, 1), numbers.Token(a.Threshold, 1))
+	case a.Direction == PriceAlertAbove:
+		return fmt.Sprintf("%s is at or above $%s", a.Symbol, numbers.Token(a.Threshold, 1))
+	default:
+		return fmt.Sprintf("%s is at or below $%s", a.Symbol, numbers.Token(a.Threshold, 1))
+	}
+}
+
+// triggered reports whether the price meets the alert's condition
+func (a PriceAlert) triggered(price float64) bool {
+	switch a.Direction {
+	case PriceAlertAbove:
+		return price >= a.Threshold
+	case PriceAlertBelow:
+		return price <= a.Threshold
+	default:
+		return math.Abs(price-a.BasePrice)/a.BasePrice*100 >= a.Percent
+	}
+}
+
+// resolvePriceAlert turns a spec into the rule it sets when the symbol is
+// at basePrice: target prices become above or below, and percentage moves
+// get their threshold
+func resolvePriceAlert(spec PriceAlertSpec, basePrice float64) PriceAlert {
+	alert := PriceAlert{
+		Symbol:    strings.ToUpper(spec.Symbol),
+		Direction: spec.Direction,
+		Threshold: spec.Price,
+		Percent:   spec.Percent,
+		BasePrice: basePrice,
+	}
+	switch {
+	case spec.Direction == priceAlertReaches && spec.Price >= basePrice:
+		alert.Direction = PriceAlertAbove
+	case spec.Direction == priceAlertReaches:
+		alert.Direction = PriceAlertBelow
+	case spec.Percent > 0 && spec.Direction == PriceAlertAbove:
+		alert.Threshold = roundTo(basePrice*(1+spec.Percent/100), 8)
+	case spec.Percent > 0 && spec.Direction == PriceAlertBelow:
+		alert.Threshold = roundTo(basePrice*(1-spec.Percent/100), 8)
+	}
+	return alert
+}
+
+// evaluatePriceAlert returns the alert as fired at the price and time when
+// the price meets its condition
+func evaluatePriceAlert(alert PriceAlert, price float64, at time.Time) (PriceAlert, bool) {
+	if !alert.triggered(price) {
+		return alert, false
+	}
+	firedAt := NewAPITime(at)
+	alert.FiredAt = &firedAt
+	alert.FiredPrice = price
+	return alert, true
+}
+
+// LivePriceSource returns the latest live price of a symbol
+type LivePriceSource interface {
+	Price(symbol string) (LivePrice, bool)
+}
+
+// PriceAlerts holds users' price alerts and checks them against live price
+// updates, telling the user through chat when one fires
+type PriceAlerts struct {
+	mu       sync.Mutex
+	alerts   map[string]*PriceAlert
+	prices   LivePriceSource
+	notifier SigningNotifier
+	logger   *log.Logger
+	now      func() time.Time
+}
+
+// NewPriceAlerts creates an empty alert store over the live prices
+func NewPriceAlerts(prices LivePriceSource) *PriceAlerts {
+	return &PriceAlerts{
+		alerts: make(map[string]*PriceAlert),
+		prices: prices,
+		logger: log.New(log.Writer(), "[PriceAlerts] ", log.LstdFlags),
+		now:    utcNow,
+	}
+}
+
+// SetNotifier delivers fired alerts to the user's chat connection
+func (pa *PriceAlerts) SetNotifier(notifier SigningNotifier) {
+	pa.notifier = notifier
+}
+
+// Create adds an alert for the user. Percentage moves and target prices are
+// resolved against the symbol's live price, which must be known.
+func (pa *PriceAlerts) Create(userID, messageID, message string, spec PriceAlertSpec) (PriceAlert, error) {
+	userID = strings.ToLower(userID)
+	symbol := strings.ToUpper(spec.Symbol)
+	live, ok := pa.prices.Price(symbol)
+	if !ok || live.Price <= 0 {
+		return PriceAlert{}, fmt.Errorf("%w for %s", ErrNoLivePrice, symbol)
+	}
+
+	alert := resolvePriceAlert(spec, live.Price)
+	alert.UserID = userID
+	alert.MessageID = messageID
+	alert.Message = message
+	alert.CreatedAt = NewAPITime(pa.now())
+
+	id, err := randomHex(6)
+	if err != nil {
+		return PriceAlert{}, fmt.Errorf("failed to generate alert ID: %w", err)
+	}
+	alert.ID = "alert_" + id
+
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	active := 0
+	for _, existing := range pa.alerts {
+		if existing.UserID == userID {
+			active++
+		}
+	}
+	if active >= MaxPriceAlertsPerUser {
+		return PriceAlert{}, ErrPriceAlertLimit
+	}
+	pa.alerts[alert.ID] = &alert
+	return alert, nil
+}
+
+// Alerts returns the user's active alerts, oldest first
+func (pa *PriceAlerts) Alerts(userID string) []PriceAlert {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	alerts := []PriceAlert{}
+	for _, alert := range pa.alerts {
+		if strings.EqualFold(alert.UserID, userID) {
+			alerts = append(alerts, *alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].CreatedAt.Equal(alerts[j].CreatedAt.Time) {
+			return alerts[i].CreatedAt.Before(alerts[j].CreatedAt.Time)
+		}
+		return alerts[i].ID < alerts[j].ID
+	})
+	return alerts
+}
+
+// Cancel removes one of the user's active alerts
+func (pa *PriceAlerts) Cancel(userID, id string) (PriceAlert, error) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	alert, ok := pa.alerts[id]
+	if !ok || !strings.EqualFold(alert.UserID, userID) {
+		return PriceAlert{}, ErrPriceAlertNotFound
+	}
+	delete(pa.alerts, id)
+	return *alert, nil
+}
+
+// UserData returns the user's active alerts
+func (pa *PriceAlerts) UserData(userID string) interface{} {
+	return pa.Alerts(userID)
+}
+
+// EraseUserData deletes the user's alerts and returns how many were removed
+func (pa *PriceAlerts) EraseUserData(userID string) int {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	removed := 0
+	for id, alert := range pa.alerts {
+		if strings.EqualFold(alert.UserID, userID) {
+			delete(pa.alerts, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Check fires the alerts the price update meets. It is a PriceHandler, to be
+// subscribed to the price feed.
+func (pa *PriceAlerts) Check(price LivePrice) {
+	symbol := strings.ToUpper(price.Symbol)
+
+	pa.mu.Lock()
+	var fired []PriceAlert
+	for id, alert := range pa.alerts {
+		if alert.Symbol != symbol {
+			continue
+		}
+		firing, ok := evaluatePriceAlert(*alert, price.Price, pa.now())
+		if !ok {
+			continue
+		}
+		fired = append(fired, firing)
+		delete(pa.alerts, id)
+	}
+	pa.mu.Unlock()
+
+	for _, alert := range fired {
+		pa.deliver(alert)
+	}
+}
+
+// deliver tells the user an alert fired, as a reply to the message that set it
+func (pa *PriceAlerts) deliver(alert PriceAlert) {
+	if pa.notifier == nil {
+		pa.logger.Printf("Failed to deliver price alert %s: no notifier", alert.ID)
+		return
+	}
+	now := pa.now()
+	err := pa.notifier.SendToUser(alert.UserID, &ChatResponse{
+		ID:        fmt.Sprintf("price_alert_%d", now.UnixNano()),
+		MessageID: alert.MessageID,
+		Type:      "price_alert",
+		Response: fmt.Sprintf("🔔 **Price Alert**\n\n%s is at $%s: your alert for when %s fired.\n\nYou asked: \"%s\"",
+			alert.Symbol, NumberFormat{}.Token(alert.FiredPrice, 1), alert.Describe(), alert.Message),
+		Data:          alert,
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+		Success:       true,
+	})
+	if err != nil {
+		pa.logger.Printf("Failed to deliver price alert %s: %v", alert.ID, err)
+	}
+}
+
+const (
+	// PriceAlertPreviewWindow is how far back a rule preview evaluates
+	PriceAlertPreviewWindow = 7 * 24 * time.Hour
+	// MaxPriceAlertPreviewFirings bounds the firings a rule preview returns
+	MaxPriceAlertPreviewFirings = 100
+)
+
+// Validate checks that the spec is a complete rule: a target price above or
+// below, or a percentage move in a direction
+func (s *PriceAlertSpec) Validate() error {
+	s.Symbol = strings.ToUpper(strings.TrimSpace(s.Symbol))
+	switch {
+	case s.Symbol == "":
+		return errors.New("symbol is required")
+	case s.Direction != PriceAlertAbove && s.Direction != PriceAlertBelow && s.Direction != PriceAlertEither:
+		return fmt.Errorf("direction must be %s, %s or %s", PriceAlertAbove, PriceAlertBelow, PriceAlertEither)
+	case s.Price < 0 || s.Percent < 0 || (s.Price > 0) == (s.Percent > 0):
+		return errors.New("exactly one of price and percent must be positive")
+	case s.Direction == PriceAlertEither && s.Percent == 0:
+		return errors.New("either direction needs a percent")
+	case s.Direction == PriceAlertBelow && s.Percent >= 100:
+		return errors.New("a drop must be below 100 percent")
+	}
+	return nil
+}
+
+// PriceAlertFiring is a point at which a previewed rule would have fired
+type PriceAlertFiring struct {
+	At    APITime `json:"at"`
+	Price float64 `json:"price"`
+}
+
+// PriceAlertPreview is how a candidate rule would have behaved over stored
+// prices. The rule is resolved against the first price of the window, as
+// though it had been created then.
+type PriceAlertPreview struct {
+	Rule            PriceAlert         `json:"rule"`
+	Description     string             `json:"description"`
+	From            APITime            `json:"from"`
+	To              APITime            `json:"to"`
+	PointsEvaluated int                `json:"points_evaluated"`
+	Firings         []PriceAlertFiring `json:"firings"`
+	// Truncated is set when the rule fired more than
+	// MaxPriceAlertPreviewFirings times
+	Truncated bool `json:"truncated"`
+}
+
+// PreviewPriceAlert evaluates a rule against the symbol's stored prices from
+// from up to to, with the evaluator live alerts use. A live alert fires once;
+// the preview re-arms the rule once the price no longer meets it, so every
+// crossing is listed.
+func PreviewPriceAlert(series *TimeSeriesStore, spec PriceAlertSpec, from, to time.Time) (PriceAlertPreview, error) {
+	symbol := strings.ToUpper(spec.Symbol)
+	var points []SeriesPoint
+	for _, point := range series.Range(PriceMetric(symbol), from) {
+		if !point.Timestamp.After(to) {
+			points = append(points, point)
+		}
+	}
+	if len(points) == 0 || points[0].Value <= 0 {
+		return PriceAlertPreview{}, fmt.Errorf("%w for %s since %s", ErrNoPriceSample, symbol, from.UTC().Format(time.RFC3339))
+	}
+
+	rule := resolvePriceAlert(spec, points[0].Value)
+	rule.CreatedAt = NewAPITime(points[0].Timestamp)
+	preview := PriceAlertPreview{
+		Rule:            rule,
+		Description:     rule.Describe(),
+		From:            NewAPITime(from),
+		To:              NewAPITime(to),
+		PointsEvaluated: len(points),
+		Firings:         []PriceAlertFiring{},
+	}
+	armed := true
+	for _, point := range points {
+		fired, ok := evaluatePriceAlert(rule, point.Value, point.Timestamp)
+		if !ok {
+			armed = true
+			continue
+		}
+		if !armed {
+			continue
+		}
+		armed = false
+		if len(preview.Firings) == MaxPriceAlertPreviewFirings {
+			preview.Truncated = true
+			break
+		}
+		preview.Firings = append(preview.Firings, PriceAlertFiring{At: *fired.FiredAt, Price: fired.FiredPrice})
+	}
+	return preview, nil
+}
+
+var (
+	// priceAlertRequestRegex matches asking to be told of a price move
+	priceAlertRequestRegex = regexp.MustCompile(`(?:\b(?:alert|notify|ping|remind|tell) me|\blet me know)\b.*\b(?:when|if|once|as soon as)\b|\bprice alert\b|\bset an? alert\b`)
+	// priceAlertClauseRegex finds the condition of a request such as
+	// "tell me when KAIA hits $1.50"
+	priceAlertClauseRegex = regexp.MustCompile(`\b(?:when|if|once|as soon as)\b(.*)$`)
+	// priceAlertAmountRegex matches a price or a percentage
+	priceAlertAmountRegex = regexp.MustCompile(`\$?(\d[\d,]*(?:\.\d+)?)\s*(%|percent\b|k\b)?`)
+	priceAlertWordRegex   = regexp.MustCompile(`[a-z][a-z0-9]*`)
+
+	priceAlertAboveRegex  = regexp.MustCompile(`\b(?:above|over|exceeds?|tops|breaks|higher than|more than)\b|>`)
+	priceAlertBelowRegex  = regexp.MustCompile(`\b(?:below|under|lower than|less than)\b|<`)
+	priceAlertUpRegex     = regexp.MustCompile(`\b(?:rises?|gains?|pumps?|jumps?|climbs?|increases?|surges?|spikes?|up)\b`)
+	priceAlertDownRegex   = regexp.MustCompile(`\b(?:drops?|falls?|dumps?|tanks?|loses|declines?|dips?|crashes?|down)\b`)
+	priceAlertEitherRegex = regexp.MustCompile(`\b(?:moves?|changes?|swings?)\b`)
+)
+
+// priceAlertFillers are the words around the symbol in an alert request
+var priceAlertFillers = map[string]bool{
+	"tell": true, "me": true, "let": true, "know": true, "notify": true, "alert": true, "ping": true,
+	"remind": true, "set": true, "an": true, "a": true, "the": true, "price": true, "of": true,
+	"for": true, "when": true, "if": true, "once": true, "as": true, "soon": true, "please": true,
+	"can": true, "you": true, "i": true, "want": true, "to": true, "at": true, "is": true, "on": true,
+}
+
+// priceAlertPronouns refer to a token without naming it
+var priceAlertPronouns = map[string]bool{"it": true, "its": true, "that": true, "this": true, "they": true, "one": true}
+
+// ParsePriceAlert reads the token, direction and threshold of a request such
+// as "tell me when KAIA hits $1.50" or "let me know if ETH drops 10%".
+// Requests missing any of them return an AmbiguousAlertError.
+func ParsePriceAlert(message string) (PriceAlertSpec, error) {
+	text := strings.ToLower(message)
+	clause := text
+	if match := priceAlertClauseRegex.FindStringSubmatch(text); match != nil {
+		clause = match[1]
+	}
+
+	var spec PriceAlertSpec
+	amount := priceAlertAmountRegex.FindStringSubmatchIndex(clause)
+	words := clause
+	if amount != nil {
+		words = clause[:amount[0]] + " " + clause[amount[1]:]
+	}
+	for _, word := range priceAlertWordRegex.FindAllString(words, -1) {
+		if priceAlertFillers[word] || mentionsPriceMove(word) {
+			continue
+		}
+		if priceAlertPronouns[word] {
+			return spec, &AmbiguousAlertError{Question: "Which token should I watch? Name it, as in \"tell me when KAIA hits $1.50\"."}
+		}
+		spec.Symbol = strings.ToUpper(word)
+		break
+	}
+	if spec.Symbol == "" {
+		return spec, &AmbiguousAlertError{Question: "Which token should I watch? Name it, as in \"tell me when KAIA hits $1.50\"."}
+	}
+	if amount == nil {
+		return spec, &AmbiguousAlertError{Question: fmt.Sprintf("What should trigger the %s alert? Give a price, as in \"above $1.50\", or a move, as in \"drops 10%%\".", spec.Symbol)}
+	}
+
+	value, err := strconv.ParseFloat(strings.ReplaceAll(clause[amount[2]:amount[3]], ",", ""), 64)
+	if err != nil || value <= 0 {
+		return spec, &AmbiguousAlertError{Question: fmt.Sprintf("What price should trigger the %s alert?", spec.Symbol)}
+	}
+	unit := ""
+	if amount[4] >= 0 {
+		unit = clause[amount[4]:amount[5]]
+	}
+
+	above := priceAlertAboveRegex.MatchString(clause) || priceAlertUpRegex.MatchString(clause)
+	below := priceAlertBelowRegex.MatchString(clause) || priceAlertDownRegex.MatchString(clause)
+	if unit == "%" || unit == "percent" {
+		spec.Percent = value
+		switch {
+		case above && below:
+			return spec, &AmbiguousAlertError{Question: fmt.Sprintf("Should the %g%% move in %s be up or down?", value, spec.Symbol)}
+		case below && value >= 100:
+			return spec, &AmbiguousAlertError{Question: fmt.Sprintf("%s can't drop %g%%; what drop should trigger the alert?", spec.Symbol, value)}
+		case below:
+			spec.Direction = PriceAlertBelow
+		case above:
+			spec.Direction = PriceAlertAbove
+		case priceAlertEitherRegex.MatchString(clause):
+			spec.Direction = PriceAlertEither
+		default:
+			return spec, &AmbiguousAlertError{Question: fmt.Sprintf("Should the %g%% move in %s be up or down?", value, spec.Symbol)}
+		}
+		return spec, nil
+	}
+
+	if unit == "k" {
+		value *= 1000
+	}
+	spec.Price = value
+	// Explicit comparisons win over verbs: "drops below" is below
+	switch {
+	case priceAlertAboveRegex.MatchString(clause) && !priceAlertBelowRegex.MatchString(clause):
+		spec.Direction = PriceAlertAbove
+	case priceAlertBelowRegex.MatchString(clause) && !priceAlertAboveRegex.MatchString(clause):
+		spec.Direction = PriceAlertBelow
+	case above && !below:
+		spec.Direction = PriceAlertAbove
+	case below && !above:
+		spec.Direction = PriceAlertBelow
+	default:
+		spec.Direction = priceAlertReaches
+	}
+	return spec, nil
+}
+
+// mentionsPriceMove reports whether the text states a direction or a move
+func mentionsPriceMove(text string) bool {
+	for _, verbs := range []*regexp.Regexp{priceAlertAboveRegex, priceAlertBelowRegex, priceAlertUpRegex, priceAlertDownRegex, priceAlertEitherRegex} {
+		if verbs.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedPrices is a live price source with set prices
+type fixedPrices map[string]float64
+
+func (p fixedPrices) Price(symbol string) (LivePrice, bool) {
+	price, ok := p[symbol]
+	return LivePrice{Symbol: symbol, Price: price}, ok
+}
+
+func TestParsePriceAlert(t *testing.T) {
+	cases := map[string]PriceAlertSpec{
+		"Tell me when KAIA hits $1.50":                     {Symbol: "KAIA", Direction: priceAlertReaches, Price: 1.5},
+		"Alert me if KAIA goes above 2":                    {Symbol: "KAIA", Direction: PriceAlertAbove, Price: 2},
+		"Notify me when kaia drops below $0.10":            {Symbol: "KAIA", Direction: PriceAlertBelow, Price: 0.1},
+		"Let me know when KAIA drops 10%":                  {Symbol: "KAIA", Direction: PriceAlertBelow, Percent: 10},
+		"Ping me if ETH pumps 5 percent":                   {Symbol: "ETH", Direction: PriceAlertAbove, Percent: 5},
+		"tell me when BTC is over $70k":                    {Symbol: "BTC", Direction: PriceAlertAbove, Price: 70000},
+		"Alert me once ETH falls under 3,500":              {Symbol: "ETH", Direction: PriceAlertBelow, Price: 3500},
+		"Tell me if KAIA moves 7.5%":                       {Symbol: "KAIA", Direction: PriceAlertEither, Percent: 7.5},
+		"Set an alert for KAIA above $0.25":                {Symbol: "KAIA", Direction: PriceAlertAbove, Price: 0.25},
+		"Remind me when the price of ETH reaches 4,200.50": {Symbol: "ETH", Direction: priceAlertReaches, Price: 4200.5},
+		"Notify me if KAIA is down 15%":                    {Symbol: "KAIA", Direction: PriceAlertBelow, Percent: 15},
+		"Alert me when BTC climbs to 100000":               {Symbol: "BTC", Direction: PriceAlertAbove, Price: 100000},
+		"Let me know if KAIA dips to $0.09":                {Symbol: "KAIA", Direction: PriceAlertBelow, Price: 0.09},
+		"KAIA price alert at 1.2":                          {Symbol: "KAIA", Direction: priceAlertReaches, Price: 1.2},
+	}
+	for message, expected := range cases {
+		spec, err := ParsePriceAlert(message)
+		require.NoError(t, err, message)
+		assert.Equal(t, expected, spec, message)
+	}
+
+	ambiguous := map[string]string{
+		"Tell me when KAIA moons":        "What should trigger the KAIA alert?",
+		"tell me when it hits $2":        "Which token should I watch?",
+		"Alert me if KAIA hits 10%":      "up or down?",
+		"Let me know if KAIA drops 120%": "can't drop 120%",
+	}
+	for message, question := range ambiguous {
+		_, err := ParsePriceAlert(message)
+		var clarification *AmbiguousAlertError
+		require.ErrorAs(t, err, &clarification, message)
+		assert.Contains(t, clarification.Question, question, message)
+	}
+}
+
+func TestPriceAlertIntent(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	for message, expected := range map[string]string{
+		"Tell me when KAIA hits $1.50":       "price_alert",
+		"tell me when it moons":              "price_alert",
+		"Let me know if the ETH price drops": "price_alert",
+		"Tell me if the yield looks good":    "yield_query",
+		"cancel alert_0a1b2c3d4e5f":          "cancel_action",
+		"What's the ETH price?":              "market_data",
+	} {
+		intent, err := engine.parseIntent(message)
+		require.NoError(t, err)
+		assert.Equal(t, expected, intent.Intent, message)
+	}
+}
+
+func TestPriceAlertFiresThroughChat(t *testing.T) {
+	engine := newTestChatEngine(t)
+	alerts := NewPriceAlerts(fixedPrices{"KAIA": 1.2, "ETH": 3000})
+	notifier := &recordingNotifier{}
+	alerts.SetNotifier(notifier)
+	engine.SetPriceAlerts(alerts)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_1", UserID: "0xUser", Message: "Tell me when KAIA hits $1.50"})
+	require.NoError(t, err)
+	require.True(t, response.Success, response.Response)
+	assert.Equal(t, "price_alert", response.Type)
+	alert := response.Data.(PriceAlert)
+	assert.Equal(t, PriceAlertAbove, alert.Direction, "KAIA is below the target")
+	assert.Equal(t, 1.5, alert.Threshold)
+	assert.Equal(t, "0xuser", alert.UserID)
+	assert.Contains(t, response.Response, "KAIA is at or above $1.5")
+	assert.Contains(t, response.Response, "cancel "+alert.ID)
+
+	_, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_2", UserID: "0xuser", Message: "Let me know if KAIA drops 10%"})
+	require.NoError(t, err)
+	require.Len(t, alerts.Alerts("0xuser"), 2)
+
+	// An ambiguous threshold asks instead of creating an alert
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_3", UserID: "0xuser", Message: "Tell me when KAIA moons"})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Equal(t, true, response.Metadata["clarification"])
+	assert.Len(t, alerts.Alerts("0xuser"), 2)
+
+	// Other symbols and prices short of the target don't fire
+	alerts.Check(LivePrice{Symbol: "ETH", Price: 1.6})
+	alerts.Check(LivePrice{Symbol: "KAIA", Price: 1.45})
+	assert.Empty(t, notifier.frames)
+
+	alerts.Check(LivePrice{Symbol: "KAIA", Price: 1.52})
+	require.Len(t, notifier.frames, 1)
+	frame := notifier.frames[0]
+	assert.Equal(t, "price_alert", frame.Type)
+	assert.Equal(t, "msg_1", frame.MessageID, "the alert replies to the message that set it")
+	assert.Contains(t, frame.Response, "KAIA is at $1.52")
+	assert.Contains(t, frame.Response, "Tell me when KAIA hits $1.50")
+	assert.Equal(t, 1.52, frame.Data.(PriceAlert).FiredPrice)
+
+	// Alerts fire once
+	alerts.Check(LivePrice{Symbol: "KAIA", Price: 1.6})
+	assert.Len(t, notifier.frames, 1)
+
+	// The drop alert is 10% under the price when it was set
+	remaining := alerts.Alerts("0xuser")
+	require.Len(t, remaining, 1)
+	assert.Equal(t, 1.08, remaining[0].Threshold)
+	alerts.Check(LivePrice{Symbol: "KAIA", Price: 1.08})
+	require.Len(t, notifier.frames, 2)
+	assert.Equal(t, "msg_2", notifier.frames[1].MessageID)
+	assert.Empty(t, alerts.Alerts("0xuser"))
+}
+
+func TestPriceAlertCancelAndLimits(t *testing.T) {
+	engine := newTestChatEngine(t)
+	alerts := NewPriceAlerts(fixedPrices{"KAIA": 1.2})
+	engine.SetPriceAlerts(alerts)
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_1", UserID: "0xuser", Message: "Alert me if KAIA goes above 2"})
+	require.NoError(t, err)
+	id := response.Data.(PriceAlert).ID
+
+	// Another user can't cancel it
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_2", UserID: "0xother", Message: "cancel " + id})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Len(t, alerts.Alerts("0xuser"), 1)
+
+	// With a single alert, the ID can be left out
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_3", UserID: "0xuser", Message: "cancel my alert"})
+	require.NoError(t, err)
+	assert.True(t, response.Success, response.Response)
+	assert.Equal(t, id, response.Data.(PriceAlert).ID)
+	assert.Empty(t, alerts.Alerts("0xuser"))
+
+	// Tokens without a live price can't be watched
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "msg_4", UserID: "0xuser", Message: "Tell me when DOGE hits $1"})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Contains(t, response.Response, "live price for DOGE")
+
+	for i := 0; i < MaxPriceAlertsPerUser; i++ {
+		_, err := alerts.Create("0xuser", "", "", PriceAlertSpec{Symbol: "KAIA", Direction: PriceAlertAbove, Price: 2})
+		require.NoError(t, err)
+	}
+	_, err = alerts.Create("0xUSER", "", "", PriceAlertSpec{Symbol: "KAIA", Direction: PriceAlertAbove, Price: 2})
+	assert.ErrorIs(t, err, ErrPriceAlertLimit)
+	assert.Equal(t, MaxPriceAlertsPerUser, alerts.EraseUserData("0xuser"))
+}
+
+func TestPriceAlertPreviewMatchesLiveEvaluator(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	prices := []float64{1.00, 1.04, 1.11, 1.07, 0.98, 1.12, 1.15, 1.02, 1.13}
+	series := NewTimeSeriesStore()
+	for i, price := range prices {
+		series.Record(PriceMetric("KAIA"), SeriesPoint{Timestamp: start.Add(time.Duration(i) * time.Hour), Value: price})
+	}
+	end := start.Add(time.Duration(len(prices)) * time.Hour)
+
+	for _, spec := range []PriceAlertSpec{
+		{Symbol: "kaia", Direction: PriceAlertAbove, Percent: 10},
+		{Symbol: "KAIA", Direction: PriceAlertBelow, Price: 1},
+		{Symbol: "KAIA", Direction: PriceAlertEither, Percent: 12},
+	} {
+		require.NoError(t, spec.Validate())
+		preview, err := PreviewPriceAlert(series, spec, start, end)
+		require.NoError(t, err)
+		assert.Equal(t, len(prices), preview.PointsEvaluated)
+
+		// A live alert created at the first price and fed the same series
+		// fires at the preview's first firing
+		alerts := NewPriceAlerts(fixedPrices{"KAIA": prices[0]})
+		notifier := &recordingNotifier{}
+		alerts.SetNotifier(notifier)
+		_, err = alerts.Create("0xuser", "", "", spec)
+		require.NoError(t, err)
+		for i, price := range prices {
+			at := start.Add(time.Duration(i) * time.Hour)
+			alerts.now = func() time.Time { return at }
+			alerts.Check(LivePrice{Symbol: "KAIA", Price: price})
+		}
+		require.Len(t, notifier.frames, 1, preview.Description)
+		fired := notifier.frames[0].Data.(PriceAlert)
+		require.NotEmpty(t, preview.Firings, preview.Description)
+		assert.Equal(t, PriceAlertFiring{At: *fired.FiredAt, Price: fired.FiredPrice}, preview.Firings[0], preview.Description)
+		assert.Equal(t, fired.Threshold, preview.Rule.Threshold)
+	}
+
+	// Only the first crossing of each excursion fires: 1.15 rides on 1.12
+	preview, err := PreviewPriceAlert(series, PriceAlertSpec{Symbol: "KAIA", Direction: PriceAlertAbove, Price: 1.1}, start, end)
+	require.NoError(t, err)
+	var fired []float64
+	for _, firing := range preview.Firings {
+		fired = append(fired, firing.Price)
+	}
+	assert.Equal(t, []float64{1.11, 1.12, 1.13}, fired)
+	assert.Equal(t, "KAIA is at or above $1.1", preview.Description)
+
+	_, err = PreviewPriceAlert(series, PriceAlertSpec{Symbol: "ETH", Direction: PriceAlertAbove, Price: 1}, start, end)
+	assert.ErrorIs(t, err, ErrNoPriceSample)
+}
+
+func TestPriceAlertPreviewCapsFirings(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	series := NewTimeSeriesStore()
+	for i := 0; i < 2*(MaxPriceAlertPreviewFirings+10); i++ {
+		series.Record(PriceMetric("KAIA"), SeriesPoint{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: 1 + float64(i%2)})
+	}
+
+	preview, err := PreviewPriceAlert(series, PriceAlertSpec{Symbol: "KAIA", Direction: PriceAlertAbove, Price: 1.5}, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, preview.Firings, MaxPriceAlertPreviewFirings)
+	assert.True(t, preview.Truncated)
+}
+
+func TestPriceAlertSpecValidate(t *testing.T) {
+	for spec, message := range map[PriceAlertSpec]string{
+		{Direction: PriceAlertAbove, Price: 1}:                             "symbol is required",
+		{Symbol: "KAIA", Direction: "sideways", Price: 1}:                  "direction must be",
+		{Symbol: "KAIA", Direction: PriceAlertAbove}:                       "exactly one",
+		{Symbol: "KAIA", Direction: PriceAlertAbove, Price: 1, Percent: 5}: "exactly one",
+		{Symbol: "KAIA", Direction: PriceAlertEither, Price: 1}:            "needs a percent",
+		{Symbol: "KAIA", Direction: PriceAlertBelow, Percent: 100}:         "below 100 percent",
+	} {
+		assert.ErrorContains(t, spec.Validate(), message)
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPriceOutlierMADs is how many median absolute deviations from the
+	// rolling median a tick may be before it is rejected
+	DefaultPriceOutlierMADs = 5
+
+	// consensusWindow is how far back the rolling median looks. A genuine
+	// jump larger than the outlier bound is held back for at most this long,
+	// until the ticks from before it have aged out.
+	consensusWindow = 2 * time.Minute
+	// consensusMaxTicks bounds the ticks kept per symbol in the window
+	consensusMaxTicks = 64
+	// consensusMinTicks is how many ticks the window needs before any are
+	// rejected; until then every tick is taken
+	consensusMinTicks = 5
+	// consensusMinSpread is the smallest MAD used, relative to the median, so
+	// that a run of identical ticks doesn't make every move an outlier
+	consensusMinSpread = 0.01
+	// maxRejectedTicks bounds the rejected ticks kept for diagnostics
+	maxRejectedTicks = 100
+
+	// A rejection cuts a source's reliability by sourceRejectPenalty; each
+	// accepted tick wins back sourceAcceptRecovery of what it has lost
+	sourceRejectPenalty  = 0.2
+	sourceAcceptRecovery = 0.02
+	// minSourceReliability keeps a source's weight from reaching zero
+	minSourceReliability = 0.01
+)
+
+// PriceTick is one price reported by a source
+type PriceTick struct {
+	Source string
+	Symbol string
+	Price  float64
+	Time   time.Time
+}
+
+// RejectedTick is a tick the consensus threw out as an outlier
+type RejectedTick struct {
+	Source string  `json:"source"`
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+	Median float64 `json:"median"`
+	// Deviation is how far the price was from the median, in MADs
+	Deviation float64   `json:"deviation"`
+	Time      time.Time `json:"time"`
+}
+
+// SourceReliability is how much a price source is trusted. The score starts
+// at 1, drops with every rejected tick and recovers slowly with accepted ones.
+type SourceReliability struct {
+	Source   string  `json:"source"`
+	Score    float64 `json:"score"`
+	Accepted int64   `json:"accepted"`
+	Rejected int64   `json:"rejected"`
+}
+
+// ConsensusPrice is the price of a symbol agreed by its sources
+type ConsensusPrice struct {
+	Symbol  string
+	Price   float64
+	Sources []string
+}
+
+// PriceConsensus combines the ticks of several price sources into one price
+// per symbol. A tick further than maxDeviation MADs from the rolling median
+// of the symbol's accepted ticks is rejected; the consensus is the median of
+// each source's latest accepted price, weighted by the sources' reliability.
+type PriceConsensus struct {
+	maxDeviation float64
+	logger       *log.Logger
+
+	mu       sync.Mutex
+	window   map[string][]PriceTick
+	latest   map[string]map[string]PriceTick
+	sources  map[string]*SourceReliability
+	rejected []RejectedTick
+}
+
+// NewPriceConsensus creates a consensus rejecting ticks further than
+// maxDeviation MADs from the rolling median
+func NewPriceConsensus(maxDeviation float64) *PriceConsensus {
+	if maxDeviation <= 0 {
+		maxDeviation = DefaultPriceOutlierMADs
+	}
+	return &PriceConsensus{
+		maxDeviation: maxDeviation,
+		logger:       log.New(log.Writer(), "[PriceConsensus] ", log.LstdFlags),
+		window:       make(map[string][]PriceTick),
+		latest:       make(map[string]map[string]PriceTick),
+		sources:      make(map[string]*SourceReliability),
+	}
+}
+
+// Observe adds a tick and returns the symbol's consensus price, or false
+// when the tick was rejected
+func (pc *PriceConsensus) Observe(tick PriceTick) (ConsensusPrice, bool) {
+	tick.Symbol = strings.ToUpper(tick.Symbol)
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	source := pc.source(tick.Source)
+	window := pc.trim(tick.Symbol, tick.Time)
+	if len(window) >= consensusMinTicks {
+		prices := make([]float64, len(window))
+		for i, accepted := range window {
+			prices[i] = accepted.Price
+		}
+		median, mad := medianDeviation(prices)
+		mad = math.Max(mad, median*consensusMinSpread)
+		if deviation := math.Abs(tick.Price-median) / mad; deviation > pc.maxDeviation {
+			source.Rejected++
+			source.Score = math.Max(minSourceReliability, source.Score*(1-sourceRejectPenalty))
+			pc.rejected = append(pc.rejected, RejectedTick{
+				Source:    tick.Source,
+				Symbol:    tick.Symbol,
+				Price:     tick.Price,
+				Median:    median,
+				Deviation: roundTo(deviation, 2),
+				Time:      tick.Time,
+			})
+			if len(pc.rejected) > maxRejectedTicks {
+				pc.rejected = pc.rejected[len(pc.rejected)-maxRejectedTicks:]
+			}
+			pc.logger.Printf("Rejected %s price %.6g from %s, %.1f MADs from the median %.6g",
+				tick.Symbol, tick.Price, tick.Source, deviation, median)
+			return ConsensusPrice{}, false
+		}
+	}
+
+	source.Accepted++
+	source.Score += (1 - source.Score) * sourceAcceptRecovery
+	window = append(window, tick)
+	if len(window) > consensusMaxTicks {
+		window = window[len(window)-consensusMaxTicks:]
+	}
+	pc.window[tick.Symbol] = window
+	if pc.latest[tick.Symbol] == nil {
+		pc.latest[tick.Symbol] = make(map[string]PriceTick)
+	}
+	pc.latest[tick.Symbol][tick.Source] = tick
+	return pc.consensus(tick.Symbol, tick.Time), true
+}
+
+// Rejected returns the rejected ticks, newest first
+func (pc *PriceConsensus) Rejected() []RejectedTick {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	rejected := make([]RejectedTick, len(pc.rejected))
+	for i, tick := range pc.rejected {
+		rejected[len(rejected)-1-i] = tick
+	}
+	return rejected
+}
+
+// Reliability returns the reliability of every source seen, by name
+func (pc *PriceConsensus) Reliability() []SourceReliability {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	scores := make([]SourceReliability, 0, len(pc.sources))
+	for _, source := range pc.sources {
+		scores = append(scores, *source)
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Source < scores[j].Source })
+	return scores
+}
+
+// WritePrometheus writes the reliability and rejection metrics in
+// Prometheus text format
+func (pc *PriceConsensus) WritePrometheus(pw *PromWriter) {
+	scores := pc.Reliability()
+	for _, source := range scores {
+		pw.Gauge("kaia_price_source_reliability", "Reliability score of the price source.", source.Score, map[string]string{"source": source.Source})
+	}
+	for _, source := range scores {
+		pw.Counter("kaia_price_ticks_rejected_total", "Price ticks rejected as outliers.", float64(source.Rejected), map[string]string{"source": source.Source})
+	}
+}
+
+// source returns a source's reliability, creating it on first sight
+func (pc *PriceConsensus) source(name string) *SourceReliability {
+	source, ok := pc.sources[name]
+	if !ok {
+		source = &SourceReliability{Source: name, Score: 1}
+		pc.sources[name] = source
+	}
+	return source
+}
+
+// trim drops the ticks of a symbol that have left the window at now
+func (pc *PriceConsensus) trim(symbol string, now time.Time) []PriceTick {
+	window := pc.window[symbol]
+	cutoff := now.Add(-consensusWindow)
+	i := 0
+	for i < len(window) && window[i].Time.Before(cutoff) {
+		i++
+	}
+	window = window[i:]
+	pc.window[symbol] = window
+	return window
+}
+
+// consensus is the reliability-weighted median of the latest price of every
+// source that reported the symbol within the window
+func (pc *PriceConsensus) consensus(symbol string, now time.Time) ConsensusPrice {
+	cutoff := now.Add(-consensusWindow)
+	var prices, weights []float64
+	var sources []string
+	for name, tick := range pc.latest[symbol] {
+		if tick.Time.Before(cutoff) {
+			continue
+		}
+		prices = append(prices, tick.Price)
+		weights = append(weights, pc.sources[name].Score)
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	return ConsensusPrice{Symbol: symbol, Price: weightedMedian(prices, weights), Sources: sources}
+}
+
+// medianDeviation returns the median of the values and their median
+// absolute deviation from it
+func medianDeviation(values []float64) (median, mad float64) {
+	median = weightedMedian(values, nil)
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - median)
+	}
+	return median, weightedMedian(deviations, nil)
+}
+
+// weightedMedian returns the value that splits the total weight in half,
+// averaging the two values either side of an exact split. Nil weights
+// weigh every value equally.
+func weightedMedian(values, weights []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	order := make([]int, len(values))
+	total := 0.0
+	for i := range order {
+		order[i] = i
+		if weights == nil {
+			total++
+		} else {
+			total += weights[i]
+		}
+	}
+	sort.Slice(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+
+	cumulative := 0.0
+	for n, i := range order {
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		cumulative += weight
+		switch {
+		case math.Abs(cumulative-total/2) < 1e-12 && n+1 < len(order):
+			return (values[i] + values[order[n+1]]) / 2
+		case cumulative > total/2:
+			return values[i]
+		}
+	}
+	return values[order[len(order)-1]]
+}
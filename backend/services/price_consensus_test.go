@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeightedMedian(t *testing.T) {
+	assert.Equal(t, 0.0, weightedMedian(nil, nil))
+	assert.Equal(t, 2.0, weightedMedian([]float64{3, 1, 2}, nil))
+	assert.Equal(t, 2.5, weightedMedian([]float64{4, 1, 3, 2}, nil))
+	assert.Equal(t, 1.0, weightedMedian([]float64{1, 2, 3}, []float64{3, 1, 1}))
+	assert.Equal(t, 1.5, weightedMedian([]float64{1, 2}, []float64{1, 1}))
+	assert.Equal(t, 2.0, weightedMedian([]float64{1, 2}, []float64{0.8, 1}))
+}
+
+func TestPriceFeedRejectsGlitchTicks(t *testing.T) {
+	feed := NewPriceFeed([]string{"KAIA"}, fakePrices{"KAIA": 0.15})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	feed.now = func() time.Time { return now }
+	feed.notifyInterval = 0
+	trade := func(source string, price float64) {
+		now = now.Add(time.Second)
+		feed.update(source, ExchangeTrade{Symbol: "KAIA", Price: price, Time: now})
+	}
+
+	alerts := NewPriceAlerts(feed)
+	notifier := &recordingNotifier{}
+	alerts.SetNotifier(notifier)
+	feed.Subscribe(alerts.Check)
+
+	for _, price := range []float64{0.150, 0.151, 0.149, 0.150, 0.152, 0.151} {
+		trade("binance", price)
+	}
+	_, err := alerts.Create("0xuser", "msg_1", "Tell me when KAIA drops below $0.10", PriceAlertSpec{Symbol: "KAIA", Direction: PriceAlertBelow, Price: 0.1})
+	require.NoError(t, err)
+
+	// An exchange glitch printing KAIA at $0.0001 is rejected
+	trade("upbit", 0.0001)
+	live, ok := feed.Price("KAIA")
+	require.True(t, ok)
+	assert.Equal(t, 0.151, live.Price)
+	assert.Equal(t, "binance", live.Source)
+	assert.Empty(t, notifier.frames, "the glitch fires no alert")
+	assert.Len(t, alerts.Alerts("0xuser"), 1)
+
+	rejected := feed.Consensus().Rejected()
+	require.Len(t, rejected, 1)
+	assert.Equal(t, "upbit", rejected[0].Source)
+	assert.Equal(t, 0.0001, rejected[0].Price)
+	assert.InDelta(t, 0.1505, rejected[0].Median, 1e-9)
+	assert.Greater(t, rejected[0].Deviation, float64(DefaultPriceOutlierMADs))
+
+	scores := feed.Consensus().Reliability()
+	require.Len(t, scores, 2)
+	assert.Equal(t, "binance", scores[0].Source)
+	assert.Equal(t, 1.0, scores[0].Score)
+	assert.Equal(t, "upbit", scores[1].Source)
+	assert.Less(t, scores[1].Score, 1.0)
+	assert.Equal(t, int64(1), scores[1].Rejected)
+
+	// The less reliable source is outweighed while both are quoting
+	trade("upbit", 0.153)
+	live, _ = feed.Price("KAIA")
+	assert.Equal(t, 0.151, live.Price)
+	assert.Equal(t, []string{"binance", "upbit"}, live.Sources)
+
+	// A genuine move is held back only until the window has rolled past the
+	// ticks from before it
+	now = now.Add(consensusWindow)
+	trade("binance", 0.09)
+	live, _ = feed.Price("KAIA")
+	assert.Equal(t, 0.09, live.Price)
+	require.Len(t, notifier.frames, 1)
+	assert.Equal(t, 0.09, notifier.frames[0].Data.(PriceAlert).FiredPrice)
+}
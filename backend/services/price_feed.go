@@ -0,0 +1,569 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultBinanceStreamURL is Binance's raw websocket stream endpoint
+	DefaultBinanceStreamURL = "wss://stream.binance.com:9443/ws"
+	// DefaultUpbitStreamURL is Upbit's public websocket endpoint
+	DefaultUpbitStreamURL = "wss://api.upbit.com/websocket/v1"
+
+	// priceDivergenceThreshold is the relative gap between the live and
+	// reference prices above which a price is flagged as diverged
+	priceDivergenceThreshold = 0.02
+	// livePriceMaxAge is how long a live price is preferred over the reference
+	livePriceMaxAge = time.Minute
+)
+
+// ExchangeTrade is a trade parsed from an exchange stream
+type ExchangeTrade struct {
+	Symbol string
+	Price  float64
+	Time   time.Time
+}
+
+// ExchangeStream describes an exchange websocket trade stream: where to
+// connect, how to subscribe to symbols, and how to parse its messages
+type ExchangeStream interface {
+	Name() string
+	URL() string
+	// SubscribeFrames returns the messages to send after connecting
+	SubscribeFrames(symbols []string) ([][]byte, error)
+	// ParseTrades returns the trades in a message; control messages yield none
+	ParseTrades(message []byte) ([]ExchangeTrade, error)
+}
+
+// BinanceStream is Binance's <symbol><quote>@trade stream
+type BinanceStream struct {
+	Endpoint string
+	Quote    string
+}
+
+// NewBinanceStream creates a Binance trade stream for pairs quoted in quote
+func NewBinanceStream(endpoint, quote string) *BinanceStream {
+	return &BinanceStream{Endpoint: endpoint, Quote: strings.ToUpper(quote)}
+}
+
+func (b *BinanceStream) Name() string { return "binance" }
+func (b *BinanceStream) URL() string  { return b.Endpoint }
+
+func (b *BinanceStream) SubscribeFrames(symbols []string) ([][]byte, error) {
+	params := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		params[i] = strings.ToLower(symbol+b.Quote) + "@trade"
+	}
+	frame, err := json.Marshal(map[string]interface{}{"method": "SUBSCRIBE", "params": params, "id": 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode subscription: %w", err)
+	}
+	return [][]byte{frame}, nil
+}
+
+func (b *BinanceStream) ParseTrades(message []byte) ([]ExchangeTrade, error) {
+	var trade struct {
+		Event  string `json:"e"`
+		Symbol string `json:"s"`
+		Price  string `json:"p"`
+		Time   int64  `json:"T"`
+		// Keys differing only in case need their own fields, or encoding/json
+		// matches them case-insensitively to the ones above
+		EventTime int64 `json:"E"`
+		TradeID   int64 `json:"t"`
+		// Combined streams wrap the payload
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(message, &trade); err != nil {
+		return nil, fmt.Errorf("failed to decode binance message: %w", err)
+	}
+	if len(trade.Data) > 0 {
+		return b.ParseTrades(trade.Data)
+	}
+	if trade.Event != "trade" || !strings.HasSuffix(trade.Symbol, b.Quote) {
+		return nil, nil
+	}
+
+	price, err := strconv.ParseFloat(trade.Price, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binance trade price %q: %w", trade.Price, err)
+	}
+	return []ExchangeTrade{{
+		Symbol: strings.TrimSuffix(trade.Symbol, b.Quote),
+		Price:  price,
+		Time:   time.UnixMilli(trade.Time),
+	}}, nil
+}
+
+// UpbitStream is Upbit's trade stream for <quote>-<symbol> markets
+type UpbitStream struct {
+	Endpoint string
+	Quote    string
+}
+
+// NewUpbitStream creates an Upbit trade stream for markets quoted in quote
+func NewUpbitStream(endpoint, quote string) *UpbitStream {
+	return &UpbitStream{Endpoint: endpoint, Quote: strings.ToUpper(quote)}
+}
+
+func (u *UpbitStream) Name() string { return "upbit" }
+func (u *UpbitStream) URL() string  { return u.Endpoint }
+
+func (u *UpbitStream) SubscribeFrames(symbols []string) ([][]byte, error) {
+	codes := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		codes[i] = u.Quote + "-" + strings.ToUpper(symbol)
+	}
+	frame, err := json.Marshal([]map[string]interface{}{
+		{"ticket": "kaia-analytics"},
+		{"type": "trade", "codes": codes},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode subscription: %w", err)
+	}
+	return [][]byte{frame}, nil
+}
+
+func (u *UpbitStream) ParseTrades(message []byte) ([]ExchangeTrade, error) {
+	var trade struct {
+		Type      string  `json:"type"`
+		Code      string  `json:"code"`
+		Price     float64 `json:"trade_price"`
+		Timestamp int64   `json:"trade_timestamp"`
+	}
+	if err := json.Unmarshal(message, &trade); err != nil {
+		return nil, fmt.Errorf("failed to decode upbit message: %w", err)
+	}
+	prefix := u.Quote + "-"
+	if trade.Type != "trade" || !strings.HasPrefix(trade.Code, prefix) {
+		return nil, nil
+	}
+	return []ExchangeTrade{{
+		Symbol: strings.TrimPrefix(trade.Code, prefix),
+		Price:  trade.Price,
+		Time:   time.UnixMilli(trade.Timestamp),
+	}}, nil
+}
+
+// LivePrice is the latest exchange price of a symbol, reconciled against the
+// reference price. The trade streams carry executed trades rather than quotes,
+// so the price is the consensus of the last trade from each source; Source is
+// the one that traded last.
+type LivePrice struct {
+	Symbol         string    `json:"symbol"`
+	Price          float64   `json:"price"`
+	Source         string    `json:"source"`
+	Sources        []string  `json:"sources"`
+	TradeTime      time.Time `json:"trade_time"`
+	ReceivedAt     time.Time `json:"received_at"`
+	LagMs          float64   `json:"lag_ms"`
+	ReferencePrice float64   `json:"reference_price,omitempty"`
+	Divergence     float64   `json:"divergence"`
+	Diverged       bool      `json:"diverged"`
+}
+
+// reconcile compares the live price with the reference price
+func (lp *LivePrice) reconcile(reference float64) {
+	lp.ReferencePrice = reference
+	lp.Divergence, lp.Diverged = 0, false
+	if reference > 0 {
+		lp.Divergence = math.Abs(lp.Price-reference) / reference
+		lp.Diverged = lp.Divergence > priceDivergenceThreshold
+	}
+}
+
+// PriceHandler is called with live price updates
+type PriceHandler func(LivePrice)
+
+// PriceFeedSourceStats is the connection state of one exchange stream
+type PriceFeedSourceStats struct {
+	Source        string    `json:"source"`
+	Connected     bool      `json:"connected"`
+	Connects      int64     `json:"connects"`
+	Messages      int64     `json:"messages"`
+	LastMessageAt time.Time `json:"last_message_at"`
+}
+
+// PriceFeed keeps live prices from exchange trade streams. Streams are tried
+// in order, so later ones are fallbacks; a dropped connection is re-dialled
+// and resubscribed with backoff, and a fallback session is ended every
+// primaryRetry to check whether the primary stream is back.
+type PriceFeed struct {
+	streams   []ExchangeStream
+	symbols   []string
+	reference PriceSource
+	consensus *PriceConsensus
+	dialer    *websocket.Dialer
+	logger    *log.Logger
+	now       func() time.Time
+
+	minBackoff        time.Duration
+	maxBackoff        time.Duration
+	readTimeout       time.Duration
+	primaryRetry      time.Duration
+	referenceInterval time.Duration
+	notifyInterval    time.Duration
+
+	mu          sync.RWMutex
+	prices      map[string]LivePrice
+	references  map[string]float64
+	notified    map[string]time.Time
+	stats       map[string]*PriceFeedSourceStats
+	subscribers []PriceHandler
+}
+
+// NewPriceFeed creates a price feed for the symbols, reconciled against the
+// reference source
+func NewPriceFeed(symbols []string, reference PriceSource, streams ...ExchangeStream) *PriceFeed {
+	pf := &PriceFeed{
+		streams:           streams,
+		reference:         reference,
+		consensus:         NewPriceConsensus(DefaultPriceOutlierMADs),
+		dialer:            websocket.DefaultDialer,
+		logger:            log.New(log.Writer(), "[PriceFeed] ", log.LstdFlags),
+		now:               utcNow,
+		minBackoff:        time.Second,
+		maxBackoff:        time.Minute,
+		readTimeout:       time.Minute,
+		primaryRetry:      5 * time.Minute,
+		referenceInterval: time.Minute,
+		notifyInterval:    time.Second,
+		prices:            make(map[string]LivePrice),
+		references:        make(map[string]float64),
+		notified:          make(map[string]time.Time),
+		stats:             make(map[string]*PriceFeedSourceStats),
+	}
+	for _, symbol := range symbols {
+		pf.symbols = append(pf.symbols, strings.ToUpper(symbol))
+	}
+	for _, stream := range streams {
+		pf.stats[stream.Name()] = &PriceFeedSourceStats{Source: stream.Name()}
+	}
+	return pf
+}
+
+// SetOutlierMADs sets how many median absolute deviations from the rolling
+// median a trade may be before it is rejected as an outlier
+func (pf *PriceFeed) SetOutlierMADs(mads float64) {
+	pf.consensus = NewPriceConsensus(mads)
+}
+
+// Consensus returns the consensus the trades go through, with the rejected
+// trades and the sources' reliability
+func (pf *PriceFeed) Consensus() *PriceConsensus {
+	return pf.consensus
+}
+
+// Subscribe registers a handler for price updates. Updates are delivered at
+// most once per second per symbol, and whenever the divergence flag changes.
+func (pf *PriceFeed) Subscribe(handler PriceHandler) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	pf.subscribers = append(pf.subscribers, handler)
+}
+
+// Start connects to the exchange streams and refreshes the reference prices
+// until ctx is cancelled
+func (pf *PriceFeed) Start(ctx context.Context) {
+	if len(pf.streams) == 0 || len(pf.symbols) == 0 {
+		return
+	}
+	go pf.run(ctx)
+	go func() {
+		ticker := time.NewTicker(pf.referenceInterval)
+		defer ticker.Stop()
+
+		for {
+			pf.RefreshReferences(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Price returns the live price of a symbol
+func (pf *PriceFeed) Price(symbol string) (LivePrice, bool) {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+
+	price, ok := pf.prices[strings.ToUpper(symbol)]
+	return price, ok
+}
+
+// Prices returns every live price, by symbol
+func (pf *PriceFeed) Prices() []LivePrice {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+
+	prices := make([]LivePrice, 0, len(pf.prices))
+	for _, price := range pf.prices {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Symbol < prices[j].Symbol })
+	return prices
+}
+
+// Stats returns the connection state of each stream, in priority order
+func (pf *PriceFeed) Stats() []PriceFeedSourceStats {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+
+	stats := make([]PriceFeedSourceStats, 0, len(pf.streams))
+	for _, stream := range pf.streams {
+		stats = append(stats, *pf.stats[stream.Name()])
+	}
+	return stats
+}
+
+// RefreshReferences fetches the reference price of every symbol and
+// reconciles the live prices against it
+func (pf *PriceFeed) RefreshReferences(ctx context.Context) {
+	for _, symbol := range pf.symbols {
+		reference, err := pf.reference.GetPrice(ctx, symbol)
+		if err != nil {
+			pf.logger.Printf("Failed to fetch reference price for %s: %v", symbol, err)
+			continue
+		}
+
+		pf.mu.Lock()
+		pf.references[symbol] = reference
+		live, ok := pf.prices[symbol]
+		if ok {
+			wasDiverged := live.Diverged
+			live.reconcile(reference)
+			pf.prices[symbol] = live
+			ok = live.Diverged != wasDiverged
+		}
+		subscribers := pf.subscribers
+		pf.mu.Unlock()
+
+		if ok {
+			pf.logDivergence(live)
+			for _, handler := range subscribers {
+				handler(live)
+			}
+		}
+	}
+}
+
+// run keeps a stream connected until ctx is cancelled. Streams are tried in
+// priority order; the backoff only applies once every stream has failed to
+// connect.
+func (pf *PriceFeed) run(ctx context.Context) {
+	backoff := pf.minBackoff
+	for {
+		connected := false
+		for i, stream := range pf.streams {
+			sessionCtx, cancel := ctx, context.CancelFunc(func() {})
+			if i > 0 {
+				sessionCtx, cancel = context.WithTimeout(ctx, pf.primaryRetry)
+			}
+			established, err := pf.session(sessionCtx, stream)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if established {
+				pf.logger.Printf("Price stream %s disconnected: %v", stream.Name(), err)
+				connected = true
+				break
+			}
+			pf.logger.Printf("Failed to connect to price stream %s: %v", stream.Name(), err)
+		}
+
+		wait := pf.minBackoff
+		if connected {
+			backoff = pf.minBackoff
+		} else {
+			wait = backoff
+			backoff = min(backoff*2, pf.maxBackoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// session connects to a stream, subscribes to the symbols and applies trades
+// until the connection drops. established reports whether the subscription
+// went through.
+func (pf *PriceFeed) session(ctx context.Context, stream ExchangeStream) (established bool, err error) {
+	conn, _, err := pf.dialer.DialContext(ctx, stream.URL(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to dial: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock the read loop when the session is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	frames, err := stream.SubscribeFrames(pf.symbols)
+	if err != nil {
+		return false, err
+	}
+	for _, frame := range frames {
+		if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			return false, fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+
+	pf.setConnected(stream.Name(), true)
+	defer pf.setConnected(stream.Name(), false)
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(pf.readTimeout)); err != nil {
+			return true, err
+		}
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return true, ctx.Err()
+			}
+			return true, err
+		}
+
+		trades, err := stream.ParseTrades(message)
+		if err != nil {
+			pf.logger.Printf("Skipping %s message: %v", stream.Name(), err)
+			continue
+		}
+		pf.recordMessage(stream.Name())
+		for _, trade := range trades {
+			pf.update(stream.Name(), trade)
+		}
+	}
+}
+
+func (pf *PriceFeed) setConnected(source string, connected bool) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	stats := pf.stats[source]
+	stats.Connected = connected
+	if connected {
+		stats.Connects++
+	}
+}
+
+func (pf *PriceFeed) recordMessage(source string) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	stats := pf.stats[source]
+	stats.Messages++
+	stats.LastMessageAt = pf.now()
+}
+
+// update applies a trade and notifies the subscribers. Trades the consensus
+// rejects as outliers change nothing.
+func (pf *PriceFeed) update(source string, trade ExchangeTrade) {
+	now := pf.now()
+	symbol := strings.ToUpper(trade.Symbol)
+
+	pf.mu.Lock()
+	current, seen := pf.prices[symbol]
+	// Trades can arrive out of order; keep the newest one
+	if seen && current.Source == source && trade.Time.Before(current.TradeTime) {
+		pf.mu.Unlock()
+		return
+	}
+	consensus, ok := pf.consensus.Observe(PriceTick{Source: source, Symbol: symbol, Price: trade.Price, Time: now})
+	if !ok {
+		pf.mu.Unlock()
+		return
+	}
+
+	live := LivePrice{
+		Symbol:     symbol,
+		Price:      consensus.Price,
+		Source:     source,
+		Sources:    consensus.Sources,
+		TradeTime:  trade.Time,
+		ReceivedAt: now,
+		LagMs:      math.Max(0, float64(now.Sub(trade.Time).Microseconds())/1000),
+	}
+	live.reconcile(pf.references[symbol])
+	pf.prices[symbol] = live
+
+	flagChanged := seen && live.Diverged != current.Diverged || !seen && live.Diverged
+	notify := flagChanged || now.Sub(pf.notified[symbol]) >= pf.notifyInterval
+	if notify {
+		pf.notified[symbol] = now
+	}
+	subscribers := pf.subscribers
+	pf.mu.Unlock()
+
+	if flagChanged {
+		pf.logDivergence(live)
+	}
+	if notify {
+		for _, handler := range subscribers {
+			handler(live)
+		}
+	}
+}
+
+func (pf *PriceFeed) logDivergence(live LivePrice) {
+	if live.Diverged {
+		pf.logger.Printf("%s price %.6g from %s diverges %.1f%% from the reference %.6g",
+			live.Symbol, live.Price, live.Source, live.Divergence*100, live.ReferencePrice)
+	} else {
+		pf.logger.Printf("%s price is back within %.0f%% of the reference", live.Symbol, priceDivergenceThreshold*100)
+	}
+}
+
+// WritePrometheus writes the stream and lag metrics in Prometheus text format
+func (pf *PriceFeed) WritePrometheus(pw *PromWriter) {
+	stats := pf.Stats()
+	prices := pf.Prices()
+	now := pf.now()
+
+	for _, stat := range stats {
+		up := 0.0
+		if stat.Connected {
+			up = 1
+		}
+		pw.Gauge("kaia_price_feed_connected", "Whether the exchange price stream is connected.", up, map[string]string{"source": stat.Source})
+	}
+	for _, stat := range stats {
+		pw.Counter("kaia_price_feed_connects_total", "Connections made to the exchange price stream.", float64(stat.Connects), map[string]string{"source": stat.Source})
+	}
+	for _, stat := range stats {
+		pw.Counter("kaia_price_feed_messages_total", "Messages received from the exchange price stream.", float64(stat.Messages), map[string]string{"source": stat.Source})
+	}
+	for _, price := range prices {
+		pw.Gauge("kaia_price_feed_lag_ms", "Delay between the last trade and its receipt.", price.LagMs, map[string]string{"symbol": price.Symbol})
+	}
+	for _, price := range prices {
+		pw.Gauge("kaia_price_feed_age_seconds", "Time since the last live price update.", now.Sub(price.ReceivedAt).Seconds(), map[string]string{"symbol": price.Symbol})
+	}
+	for _, price := range prices {
+		pw.Gauge("kaia_price_feed_divergence_ratio", "Relative gap between the live and reference prices.", price.Divergence, map[string]string{"symbol": price.Symbol})
+	}
+	pf.consensus.WritePrometheus(pw)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExchange is a websocket server that hands each subscribed connection to
+// the test, which replays trades through it
+type fakeExchange struct {
+	server        *httptest.Server
+	subscriptions chan string
+	conns         chan *websocket.Conn
+}
+
+func newFakeExchange(t *testing.T) *fakeExchange {
+	fe := &fakeExchange{subscriptions: make(chan string, 4), conns: make(chan *websocket.Conn, 4)}
+	upgrader := websocket.Upgrader{}
+	fe.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		fe.subscriptions <- string(frame)
+		fe.conns <- conn
+	}))
+	t.Cleanup(fe.server.Close)
+	return fe
+}
+
+func (fe *fakeExchange) url() string {
+	return "ws" + strings.TrimPrefix(fe.server.URL, "http")
+}
+
+func (fe *fakeExchange) accept(t *testing.T) (string, *websocket.Conn) {
+	t.Helper()
+	select {
+	case frame := <-fe.subscriptions:
+		conn := <-fe.conns
+		t.Cleanup(func() { conn.Close() })
+		return frame, conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a subscription")
+		return "", nil
+	}
+}
+
+func binanceTrade(symbol, price string, at time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"e":"trade","E":%d,"s":"%s","t":1,"p":"%s","q":"120.5","T":%d,"m":false}`,
+		at.UnixMilli(), symbol, price, at.UnixMilli()))
+}
+
+func newTestPriceFeed(reference PriceSource, streams ...ExchangeStream) (*PriceFeed, <-chan LivePrice) {
+	feed := NewPriceFeed([]string{"kaia"}, reference, streams...)
+	feed.minBackoff = time.Millisecond
+	feed.notifyInterval = 0
+
+	updates := make(chan LivePrice, 16)
+	feed.Subscribe(func(price LivePrice) { updates <- price })
+	return feed, updates
+}
+
+func nextPrice(t *testing.T, updates <-chan LivePrice) LivePrice {
+	t.Helper()
+	select {
+	case price := <-updates:
+		return price
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a price update")
+		return LivePrice{}
+	}
+}
+
+func TestPriceFeedAppliesTradesAndFlagsDivergence(t *testing.T) {
+	exchange := newFakeExchange(t)
+	feed, updates := newTestPriceFeed(fakePrices{"KAIA": 0.15}, NewBinanceStream(exchange.url(), "usdt"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feed.RefreshReferences(ctx)
+	feed.Start(ctx)
+
+	subscription, conn := exchange.accept(t)
+	assert.JSONEq(t, `{"method":"SUBSCRIBE","params":["kaiausdt@trade"],"id":1}`, subscription)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"result":null,"id":1}`)))
+
+	tradeTime := time.Now().Add(-250 * time.Millisecond)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, binanceTrade("KAIAUSDT", "0.1512", tradeTime)))
+	price := nextPrice(t, updates)
+	assert.Equal(t, "KAIA", price.Symbol)
+	assert.Equal(t, 0.1512, price.Price)
+	assert.Equal(t, "binance", price.Source)
+	assert.GreaterOrEqual(t, price.LagMs, 250.0)
+	assert.Equal(t, 0.15, price.ReferencePrice)
+	assert.False(t, price.Diverged)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, binanceTrade("KAIAUSDT", "0.1580", time.Now())))
+	price = nextPrice(t, updates)
+	assert.Equal(t, 0.158, price.Price)
+	assert.InDelta(t, 0.0533, price.Divergence, 0.0001)
+	assert.True(t, price.Diverged)
+
+	live, ok := feed.Price("kaia")
+	require.True(t, ok)
+	assert.Equal(t, price, live)
+
+	// A dropped connection is re-dialled and resubscribed
+	conn.Close()
+	subscription, conn = exchange.accept(t)
+	assert.JSONEq(t, `{"method":"SUBSCRIBE","params":["kaiausdt@trade"],"id":1}`, subscription)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, binanceTrade("KAIAUSDT", "0.1495", time.Now())))
+	price = nextPrice(t, updates)
+	assert.Equal(t, 0.1495, price.Price)
+	assert.False(t, price.Diverged)
+	assert.Equal(t, int64(2), feed.Stats()[0].Connects)
+}
+
+func TestPriceFeedFallsBackToUpbit(t *testing.T) {
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	upbit := newFakeExchange(t)
+
+	collector := NewDataCollector(nil)
+	feed, updates := newTestPriceFeed(collector.ReferencePrices(),
+		NewBinanceStream("ws"+strings.TrimPrefix(unreachable.URL, "http"), "USDT"),
+		NewUpbitStream(upbit.url(), "USDT"),
+	)
+	collector.SetPriceFeed(feed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feed.RefreshReferences(ctx)
+	feed.Start(ctx)
+
+	subscription, conn := upbit.accept(t)
+	assert.Contains(t, subscription, `{"codes":["USDT-KAIA"],"type":"trade"}`)
+	trade := fmt.Sprintf(`{"type":"trade","code":"USDT-KAIA","trade_price":0.16,"trade_volume":50,"trade_timestamp":%d}`, time.Now().UnixMilli())
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte(trade)))
+
+	price := nextPrice(t, updates)
+	assert.Equal(t, "upbit", price.Source)
+	assert.Equal(t, 0.16, price.Price)
+	assert.True(t, price.Diverged, "0.16 is 6.7%% away from the 0.15 reference")
+
+	// Market data prefers the fresh live price; the reference is unchanged
+	current, err := collector.GetPrice(ctx, "KAIA")
+	require.NoError(t, err)
+	assert.Equal(t, 0.16, current)
+	reference, err := collector.ReferencePrices().GetPrice(ctx, "KAIA")
+	require.NoError(t, err)
+	assert.Equal(t, 0.15, reference)
+
+	var buf bytes.Buffer
+	feed.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), `kaia_price_feed_connected{source="binance"} 0`)
+	assert.Contains(t, buf.String(), `kaia_price_feed_connected{source="upbit"} 1`)
+	assert.Contains(t, buf.String(), `kaia_price_feed_divergence_ratio{symbol="KAIA"}`)
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PriceSampleTolerance is how far from the requested time a stored price
+// sample may be and still be used as the price at that time
+const PriceSampleTolerance = 10 * time.Minute
+
+// How a price was derived from the stored samples
+const (
+	// PriceSampleNearest is the stored sample closest to the requested time
+	PriceSampleNearest = "nearest"
+	// PriceSampleInterpolated lies on the line between the samples either
+	// side of the requested time
+	PriceSampleInterpolated = "interpolated"
+	// PriceSampleReported is a price from a source that keeps no samples
+	PriceSampleReported = "reported"
+)
+
+// ErrNoPriceSample is returned when no stored sample can price a symbol at
+// the requested time
+var ErrNoPriceSample = errors.New("no price sample")
+
+// PriceSample is the USD price of a symbol at a point in time and the stored
+// samples it was derived from
+type PriceSample struct {
+	Symbol   string  `json:"symbol"`
+	At       APITime `json:"at"`
+	PriceUSD float64 `json:"price_usd"`
+	Method   string  `json:"method"`
+	// SampleTimes are the times of the samples used, one for the nearest
+	// sample and two for an interpolation
+	SampleTimes []APITime `json:"sample_times,omitempty"`
+}
+
+// PriceSampleSource values symbols at points in time from stored price samples
+type PriceSampleSource interface {
+	PriceSample(ctx context.Context, symbol string, at time.Time) (PriceSample, error)
+}
+
+// SamplePrice prices a symbol at a time from its stored samples:
+//
+//   - the sample nearest to at, when one is within PriceSampleTolerance
+//   - otherwise the linear interpolation between the samples either side of at
+//   - otherwise ErrNoPriceSample; prices aren't extrapolated before the first
+//     sample or past the last one
+func SamplePrice(series *TimeSeriesStore, symbol string, at time.Time) (PriceSample, error) {
+	symbol = strings.ToUpper(symbol)
+	sample := PriceSample{Symbol: symbol, At: NewAPITime(at)}
+	before, after := series.Around(PriceMetric(symbol), at)
+
+	nearest := before
+	if after != nil && (nearest == nil || after.Timestamp.Sub(at) < at.Sub(nearest.Timestamp)) {
+		nearest = after
+	}
+	if nearest != nil && nearest.Timestamp.Sub(at).Abs() <= PriceSampleTolerance {
+		sample.PriceUSD = nearest.Value
+		sample.Method = PriceSampleNearest
+		sample.SampleTimes = []APITime{NewAPITime(nearest.Timestamp)}
+		return sample, nil
+	}
+
+	if before == nil || after == nil {
+		return PriceSample{}, fmt.Errorf("%w for %s around %s", ErrNoPriceSample, symbol, at.UTC().Format(time.RFC3339))
+	}
+	weight := float64(at.Sub(before.Timestamp)) / float64(after.Timestamp.Sub(before.Timestamp))
+	sample.PriceUSD = before.Value + (after.Value-before.Value)*weight
+	sample.Method = PriceSampleInterpolated
+	sample.SampleTimes = []APITime{NewAPITime(before.Timestamp), NewAPITime(after.Timestamp)}
+	return sample, nil
+}
+
+// samplePrice prices a symbol at a time, keeping the stored samples used
+// when the source has them
+func samplePrice(ctx context.Context, prices HistoricalPriceSource, symbol string, at time.Time) (PriceSample, error) {
+	if samples, ok := prices.(PriceSampleSource); ok {
+		return samples.PriceSample(ctx, symbol, at)
+	}
+	price, err := prices.PriceAt(ctx, symbol, at)
+	if err != nil {
+		return PriceSample{}, err
+	}
+	return PriceSample{Symbol: strings.ToUpper(symbol), At: NewAPITime(at), PriceUSD: price, Method: PriceSampleReported}, nil
+}
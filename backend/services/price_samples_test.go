@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamplePriceRules(t *testing.T) {
+	store := NewTimeSeriesStore()
+	start := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	store.Record(PriceMetric("KAIA"), SeriesPoint{Timestamp: start, Value: 0.20})
+	store.Record(PriceMetric("KAIA"), SeriesPoint{Timestamp: start.Add(time.Hour), Value: 0.26})
+
+	// The nearest sample wins within the tolerance, on either side
+	sample, err := SamplePrice(store, "kaia", start.Add(PriceSampleTolerance))
+	require.NoError(t, err)
+	assert.Equal(t, PriceSample{Symbol: "KAIA", At: NewAPITime(start.Add(10 * time.Minute)), PriceUSD: 0.20, Method: PriceSampleNearest,
+		SampleTimes: []APITime{NewAPITime(start)}}, sample)
+	sample, err = SamplePrice(store, "KAIA", start.Add(55*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0.26, sample.PriceUSD)
+	assert.Equal(t, PriceSampleNearest, sample.Method)
+	sample, err = SamplePrice(store, "KAIA", start.Add(-5*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0.20, sample.PriceUSD, "a sample just after counts too")
+
+	// Further away, the samples either side are interpolated
+	sample, err = SamplePrice(store, "KAIA", start.Add(20*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, PriceSampleInterpolated, sample.Method)
+	assert.InDelta(t, 0.22, sample.PriceUSD, 1e-12)
+	assert.Equal(t, []APITime{NewAPITime(start), NewAPITime(start.Add(time.Hour))}, sample.SampleTimes)
+
+	// Prices aren't extrapolated past either end
+	for _, at := range []time.Time{start.Add(-11 * time.Minute), start.Add(71 * time.Minute)} {
+		_, err = SamplePrice(store, "KAIA", at)
+		assert.ErrorIs(t, err, ErrNoPriceSample, at)
+	}
+	_, err = SamplePrice(store, "ETH", start)
+	assert.ErrorIs(t, err, ErrNoPriceSample)
+}
+
+func TestSamplePriceKeepsSourcesWithoutSamples(t *testing.T) {
+	at := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	sample, err := samplePrice(context.Background(), fakePrices{"USDT": 1}, "USDT", at)
+	require.NoError(t, err)
+	assert.Equal(t, PriceSample{Symbol: "USDT", At: NewAPITime(at), PriceUSD: 1, Method: PriceSampleReported}, sample)
+
+	_, err = samplePrice(context.Background(), fakePrices{}, "USDT", at)
+	assert.Error(t, err)
+}
+
+// TestPortfolioValuationsReconcile values one synthetic portfolio through the
+// address summary, the portfolio tracker, and the transfer flows that built
+// it, all priced from the same stored samples
+func TestPortfolioValuationsReconcile(t *testing.T) {
+	ctx := context.Background()
+	valuedAt := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return valuedAt }
+
+	collector := NewDataCollector(nil)
+	series := collector.Series()
+	// KAIA is interpolated between samples 30 minutes either side; USDT and
+	// WETH have a sample within the tolerance
+	series.Record(PriceMetric(NativeSymbol), SeriesPoint{Timestamp: valuedAt.Add(-30 * time.Minute), Value: 0.18})
+	series.Record(PriceMetric(NativeSymbol), SeriesPoint{Timestamp: valuedAt.Add(30 * time.Minute), Value: 0.22})
+	series.Record(PriceMetric("USDT"), SeriesPoint{Timestamp: valuedAt.Add(-2 * time.Minute), Value: 1.001})
+	series.Record(PriceMetric("WETH"), SeriesPoint{Timestamp: valuedAt.Add(4 * time.Minute), Value: 2500})
+
+	usdt := common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	weth := common.HexToAddress("0x00000000000000000000000000000000000000d2")
+	tracked := []TrackedToken{{Symbol: "USDT", Address: usdt, Decimals: 6}, {Symbol: "WETH", Address: weth, Decimals: 18}}
+	contracts := fakeTokens{
+		usdt: {symbol: "USDT", decimals: 6, balance: big.NewInt(1_250_500_000)},
+		weth: {symbol: "WETH", decimals: 18, balance: big.NewInt(3e17)},
+	}
+	tokens := NewERC20BalanceReader(contracts, tracked, collector)
+	tokens.now = clock
+	native := fakeNativeBalances{balance: kaia(4000)}
+	index := NewTransactionIndex()
+
+	// 4000 KAIA + 1250.5 USDT + 0.3 WETH
+	expected := 4000*0.20 + 1250.5*1.001 + 0.3*2500
+
+	summarizer := NewAddressSummarizer(native, tokens, index, collector)
+	summarizer.now = clock
+	summary, err := summarizer.Summarize(ctx, summaryAddress)
+	require.NoError(t, err)
+
+	tracker := NewPortfolioTracker(native, tokens, collector, nil)
+	tracker.now = clock
+	snapshot, err := tracker.Value(ctx, summaryAddress)
+	require.NoError(t, err)
+	require.False(t, snapshot.Partial)
+
+	// The KAIA arrived in one deposit at the valuation time; the tokens are
+	// priced directly
+	index.Add(IndexedTransaction{Hash: "0xd1", From: "0x00000000000000000000000000000000000000cc", To: summaryAddress.Hex(), Timestamp: valuedAt, Value: kaia(4000)})
+	flows, _, err := NewNativeTransferFlows(index, collector, nil, deployedCode{}).Flows(ctx, summaryAddress, valuedAt.Add(-time.Hour), valuedAt)
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+	ledger := flows[0].ValueUSD
+	for _, token := range []struct {
+		symbol string
+		amount float64
+	}{{"USDT", 1250.5}, {"WETH", 0.3}} {
+		price, err := collector.PriceAt(ctx, token.symbol, valuedAt)
+		require.NoError(t, err)
+		ledger += token.amount * price
+	}
+
+	assert.InDelta(t, expected, summary.TotalValueUSD, 1e-9)
+	assert.Equal(t, summary.TotalValueUSD, snapshot.TotalUSD)
+	assert.Equal(t, summary.TotalValueUSD, ledger)
+
+	// Every path records the same samples
+	interpolated := &PriceSample{Symbol: NativeSymbol, At: NewAPITime(valuedAt), PriceUSD: 0.20, Method: PriceSampleInterpolated,
+		SampleTimes: []APITime{NewAPITime(valuedAt.Add(-30 * time.Minute)), NewAPITime(valuedAt.Add(30 * time.Minute))}}
+	assert.Equal(t, interpolated, summary.NativePriceSample)
+	assert.Equal(t, interpolated, snapshot.Assets[0].PriceSample)
+	assert.Equal(t, interpolated, flows[0].PriceSample)
+	for _, holding := range summary.TopTokens {
+		require.NotNil(t, holding.PriceSample, holding.Symbol)
+		assert.Equal(t, PriceSampleNearest, holding.PriceSample.Method, holding.Symbol)
+	}
+	assert.Equal(t, []APITime{NewAPITime(valuedAt.Add(4 * time.Minute))}, snapshot.Assets[2].PriceSample.SampleTimes)
+}
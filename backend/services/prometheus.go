@@ -0,0 +1,120 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PromWriter writes metrics in the Prometheus text exposition format
+type PromWriter struct {
+	w        io.Writer
+	declared map[string]bool
+}
+
+// NewPromWriter creates a writer emitting to w
+func NewPromWriter(w io.Writer) *PromWriter {
+	return &PromWriter{w: w, declared: make(map[string]bool)}
+}
+
+// Counter writes a counter sample
+func (pw *PromWriter) Counter(name, help string, value float64, labels map[string]string) {
+	pw.sample(name, "counter", help, value, labels)
+}
+
+// Gauge writes a gauge sample
+func (pw *PromWriter) Gauge(name, help string, value float64, labels map[string]string) {
+	pw.sample(name, "gauge", help, value, labels)
+}
+
+// Histogram writes a histogram from per-bucket counts. counts has one entry
+// per upper bound plus a last one for observations above every bound.
+func (pw *PromWriter) Histogram(name, help string, bounds []float64, counts []uint64, sum float64, labels map[string]string) {
+	if !pw.declared[name] {
+		fmt.Fprintf(pw.w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		pw.declared[name] = true
+	}
+
+	withLE := func(le string) map[string]string {
+		merged := make(map[string]string, len(labels)+1)
+		for key, value := range labels {
+			merged[key] = value
+		}
+		merged["le"] = le
+		return merged
+	}
+	var cumulative uint64
+	for i, bound := range bounds {
+		cumulative += counts[i]
+		fmt.Fprintf(pw.w, "%s_bucket%s %d\n", name, formatPromLabels(withLE(strconv.FormatFloat(bound, 'g', -1, 64))), cumulative)
+	}
+	cumulative += counts[len(bounds)]
+	fmt.Fprintf(pw.w, "%s_bucket%s %d\n", name, formatPromLabels(withLE("+Inf")), cumulative)
+	fmt.Fprintf(pw.w, "%s_sum%s %g\n", name, formatPromLabels(labels), sum)
+	fmt.Fprintf(pw.w, "%s_count%s %d\n", name, formatPromLabels(labels), cumulative)
+}
+
+func (pw *PromWriter) sample(name, kind, help string, value float64, labels map[string]string) {
+	if !pw.declared[name] {
+		fmt.Fprintf(pw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		pw.declared[name] = true
+	}
+	fmt.Fprintf(pw.w, "%s%s %g\n", name, formatPromLabels(labels), value)
+}
+
+// formatPromLabels renders labels in a stable order with escaped values
+func formatPromLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf(`%s="%s"`, key, escaper.Replace(labels[key]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// WritePrometheus exposes the chat metrics
+func (cm *ChatMetrics) WritePrometheus(pw *PromWriter) {
+	snapshot := cm.Snapshot()
+
+	pw.Counter("kaia_chat_messages_total", "Chat messages processed.", float64(snapshot.TotalMessages), nil)
+	pw.Counter("kaia_chat_parse_failures_total", "Chat messages whose intent could not be parsed.", float64(snapshot.ParseFailures), nil)
+	pw.Counter("kaia_chat_handler_errors_total", "Chat intent handlers that returned an error.", float64(snapshot.HandlerErrors), nil)
+	pw.Counter("kaia_chat_action_proposals_total", "On-chain actions proposed through chat.", float64(snapshot.ActionProposals), nil)
+	pw.Counter("kaia_chat_action_confirmations_total", "On-chain actions confirmed through chat.", float64(snapshot.ActionConfirmations), nil)
+	pw.Counter("kaia_chat_reaped_connections_total", "Chat connections closed for missing heartbeats.", float64(snapshot.ReapedConnections), nil)
+	pw.Counter("kaia_chat_evicted_connections_total", "Chat connections closed to make room for another.", float64(snapshot.EvictedConnections), nil)
+
+	intents := make([]string, 0, len(snapshot.IntentCounts))
+	for intent := range snapshot.IntentCounts {
+		intents = append(intents, intent)
+	}
+	sort.Strings(intents)
+	for _, intent := range intents {
+		pw.Counter("kaia_chat_intent_total", "Chat messages by resolved intent.", float64(snapshot.IntentCounts[intent]), map[string]string{"intent": intent})
+	}
+
+	pw.Gauge("kaia_chat_latency_ms", "Chat response latency percentiles over recent messages.", snapshot.LatencyP50Ms, map[string]string{"quantile": "0.5"})
+	pw.Gauge("kaia_chat_latency_ms", "Chat response latency percentiles over recent messages.", snapshot.LatencyP95Ms, map[string]string{"quantile": "0.95"})
+}
+
+// WritePrometheus exposes the chat engine metrics
+func (ce *ChatEngine) WritePrometheus(pw *PromWriter) {
+	connections := ce.ConnectionCounts()
+
+	pw.Gauge("kaia_chat_active_connections", "Open chat WebSocket connections.", float64(connections.Identified+connections.Anonymous), nil)
+	pw.Gauge("kaia_chat_connections", "Open chat WebSocket connections by whether a user ID was given.", float64(connections.Identified), map[string]string{"state": "identified"})
+	pw.Gauge("kaia_chat_connections", "Open chat WebSocket connections by whether a user ID was given.", float64(connections.Anonymous), map[string]string{"state": "anonymous"})
+	ce.metrics.WritePrometheus(pw)
+}
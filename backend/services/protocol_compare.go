@@ -0,0 +1,394 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// comparableRiskPoints is how close two risk scores are for neither
+	// protocol to be called safer
+	comparableRiskPoints = 2.0
+	// maxNearMatches is how many protocols a clarification suggests
+	maxNearMatches = 3
+)
+
+// Revenue sustainability, by the share of yield paid from fees and interest
+// rather than reward emissions
+const (
+	SustainabilityHigh    = "sustainable"
+	SustainabilityMixed   = "mixed"
+	SustainabilityLow     = "emission-dependent"
+	SustainabilityUnknown = "unknown"
+)
+
+// protocolCompareRegex matches comparative phrasing, such as "is KaiaSwap
+// safer than KaiaLend" or "Aave vs Compound"
+var protocolCompareRegex = regexp.MustCompile(`\b(?:compare|comparison|vs|versus|(?:safer|riskier|better|worse|more \w+|less \w+) than|difference between)\b`)
+
+// nonWordRegex splits a message into words
+var nonWordRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ProtocolMatch is a protocol of the registry mentioned in a message
+type ProtocolMatch struct {
+	Name string `json:"name"`
+	// Mention is the text the protocol was recognized from, and Distance
+	// the number of typos in it
+	Mention  string `json:"mention"`
+	Distance int    `json:"distance"`
+	position int
+}
+
+// protocolAliases are the normalized names a protocol is recognized by: its
+// full name, and its first word when no other protocol starts with it
+func protocolAliases(registry *ProtocolRegistry) map[string][]string {
+	firstWords := make(map[string]int)
+	for _, protocol := range registry.Protocols {
+		if words := normalizeWords(protocol.Name); len(words) > 0 {
+			firstWords[words[0]]++
+		}
+	}
+
+	aliases := make(map[string][]string, len(registry.Protocols))
+	for _, protocol := range registry.Protocols {
+		words := normalizeWords(protocol.Name)
+		if len(words) == 0 {
+			continue
+		}
+		aliases[protocol.Name] = append(aliases[protocol.Name], strings.Join(words, " "))
+		if len(words) > 1 && firstWords[words[0]] == 1 {
+			aliases[protocol.Name] = append(aliases[protocol.Name], words[0])
+		}
+	}
+	return aliases
+}
+
+// normalizeWords lowercases text and splits it into words
+func normalizeWords(text string) []string {
+	return strings.Fields(nonWordRegex.ReplaceAllString(strings.ToLower(text), " "))
+}
+
+// allowedTypos is how many typos a mention of an alias may have. Short
+// names must be exact, so words such as "have" aren't taken for "aave".
+func allowedTypos(alias string) int {
+	switch n := len(alias); {
+	case n < 5:
+		return 0
+	case n < 9:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// MatchProtocols finds the registry protocols a message mentions, in the
+// order they are mentioned. Mentions may have a few typos, but must start
+// with the right letter; each word counts towards one protocol at most.
+func MatchProtocols(registry *ProtocolRegistry, message string) []ProtocolMatch {
+	if registry == nil {
+		return nil
+	}
+	words := normalizeWords(message)
+
+	type candidate struct {
+		match ProtocolMatch
+		width int
+	}
+	var candidates []candidate
+	for name, aliases := range protocolAliases(registry) {
+		for _, alias := range aliases {
+			compact := strings.ReplaceAll(alias, " ", "")
+			// One word more, for names written apart such as "kaia lend"
+			aliasWords := len(strings.Fields(alias))
+			for width := aliasWords; width <= aliasWords+1; width++ {
+				for start := 0; start+width <= len(words); start++ {
+					mention := strings.Join(words[start:start+width], " ")
+					if mention[0] != alias[0] {
+						continue
+					}
+					distance := editDistance(strings.ReplaceAll(mention, " ", ""), compact)
+					if distance > allowedTypos(compact) {
+						continue
+					}
+					candidates = append(candidates, candidate{
+						match: ProtocolMatch{Name: name, Mention: mention, Distance: distance, position: start},
+						width: width,
+					})
+				}
+			}
+		}
+	}
+
+	// Exact and longer mentions claim their words first
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.match.Distance != b.match.Distance {
+			return a.match.Distance < b.match.Distance
+		}
+		if a.width != b.width {
+			return a.width > b.width
+		}
+		if a.match.position != b.match.position {
+			return a.match.position < b.match.position
+		}
+		return a.match.Name < b.match.Name
+	})
+	used := make([]bool, len(words))
+	seen := make(map[string]bool)
+	var matches []ProtocolMatch
+	for _, c := range candidates {
+		if seen[c.match.Name] {
+			continue
+		}
+		free := true
+		for i := c.match.position; i < c.match.position+c.width; i++ {
+			free = free && !used[i]
+		}
+		if !free {
+			continue
+		}
+		for i := c.match.position; i < c.match.position+c.width; i++ {
+			used[i] = true
+		}
+		seen[c.match.Name] = true
+		matches = append(matches, c.match)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].position < matches[j].position })
+	return matches
+}
+
+// NearProtocols suggests the registry protocols closest to the words of a
+// message, leaving out those already matched
+func NearProtocols(registry *ProtocolRegistry, message string, matched []ProtocolMatch) []string {
+	if registry == nil {
+		return nil
+	}
+	skip := make(map[string]bool, len(matched))
+	for _, match := range matched {
+		skip[match.Name] = true
+	}
+	words := normalizeWords(message)
+
+	type near struct {
+		name      string
+		closeness float64
+	}
+	var nearest []near
+	for name, aliases := range protocolAliases(registry) {
+		if skip[name] {
+			continue
+		}
+		best := math.Inf(1)
+		for _, alias := range aliases {
+			width := len(strings.Fields(alias))
+			for start := 0; start+width <= len(words); start++ {
+				mention := strings.Join(words[start:start+width], " ")
+				// Relative to the alias, so long names aren't penalized
+				best = math.Min(best, float64(editDistance(mention, alias))/float64(len(alias)))
+			}
+		}
+		nearest = append(nearest, near{name: name, closeness: best})
+	}
+	sort.Slice(nearest, func(i, j int) bool {
+		if nearest[i].closeness != nearest[j].closeness {
+			return nearest[i].closeness < nearest[j].closeness
+		}
+		return nearest[i].name < nearest[j].name
+	})
+
+	names := make([]string, 0, maxNearMatches)
+	for _, n := range nearest[:min(maxNearMatches, len(nearest))] {
+		names = append(names, n.name)
+	}
+	return names
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// the insertions, deletions, substitutions, and swaps of adjacent letters
+// turning one into the other
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
+
+// ProtocolProfile is what a comparison knows about one protocol, from the
+// registry and its pools in the latest yield scan
+type ProtocolProfile struct {
+	Protocol string  `json:"protocol"`
+	Audited  *bool   `json:"audited,omitempty"`
+	AgeDays  *int    `json:"age_days,omitempty"`
+	Pools    int     `json:"pools"`
+	TVL      float64 `json:"tvl"`
+	// TVLTrend is the TVL weighted percentage change over the last 7 days
+	TVLTrend float64 `json:"tvl_trend"`
+	// RiskScore and RiskBreakdown are the TVL weighted means over the pools
+	RiskScore     float64         `json:"risk_score"`
+	RiskBreakdown []RiskComponent `json:"risk_breakdown"`
+	// FeeShare is the fraction of yield paid from fees and interest rather
+	// than emissions, over the pools the registry knows it of
+	FeeShare       *float64 `json:"fee_share,omitempty"`
+	Sustainability string   `json:"sustainability"`
+}
+
+// ProfileProtocol profiles a protocol from its registry entry and its yield
+// opportunities. Without scanned pools the risk is left at zero.
+func ProfileProtocol(info ProtocolInfo, opportunities []YieldOpportunity, now time.Time) ProtocolProfile {
+	profile := ProtocolProfile{
+		Protocol:       info.Name,
+		Audited:        info.Audited,
+		Sustainability: SustainabilityUnknown,
+	}
+	if info.FirstActivity != nil {
+		days := int(now.Sub(*info.FirstActivity).Hours() / 24)
+		profile.AgeDays = &days
+	}
+
+	var total, weightedRisk, weightedTrend, emissionTVL, emissions float64
+	componentRisk := make(map[string]float64)
+	var factors []RiskComponent
+	for _, opportunity := range opportunities {
+		if !strings.EqualFold(opportunity.Protocol, info.Name) {
+			continue
+		}
+		profile.Pools++
+		profile.TVL += opportunity.TVL
+		weight := math.Max(opportunity.TVL, 1)
+		total += weight
+		weightedRisk += opportunity.RiskScore * weight
+		weightedTrend += opportunity.TVLTrend * weight
+		for _, component := range opportunity.RiskBreakdown {
+			if _, ok := componentRisk[component.Factor]; !ok {
+				factors = append(factors, RiskComponent{Factor: component.Factor, Weight: component.Weight})
+			}
+			componentRisk[component.Factor] += component.Risk * weight
+		}
+		if pool := info.Pool(opportunity.AssetPair); pool != nil && pool.EmissionShare != nil {
+			emissions += *pool.EmissionShare * weight
+			emissionTVL += weight
+		}
+	}
+	if profile.Pools == 0 {
+		return profile
+	}
+
+	profile.RiskScore = roundTo(weightedRisk/total, 1)
+	profile.TVLTrend = roundTo(weightedTrend/total, 2)
+	for _, factor := range factors {
+		factor.Risk = roundTo(componentRisk[factor.Factor]/total, 3)
+		factor.Known = true
+		profile.RiskBreakdown = append(profile.RiskBreakdown, factor)
+	}
+	if emissionTVL > 0 {
+		share := roundTo(1-emissions/emissionTVL, 3)
+		profile.FeeShare = &share
+		profile.Sustainability = sustainability(share)
+	}
+	return profile
+}
+
+// sustainability rates a fee share of yield
+func sustainability(feeShare float64) string {
+	switch {
+	case feeShare >= 0.7:
+		return SustainabilityHigh
+	case feeShare >= 0.4:
+		return SustainabilityMixed
+	default:
+		return SustainabilityLow
+	}
+}
+
+// FactorComparison is how two protocols compare on one risk factor
+type FactorComparison struct {
+	Factor string  `json:"factor"`
+	RiskA  float64 `json:"risk_a"`
+	RiskB  float64 `json:"risk_b"`
+	// Safer is the protocol with the lower risk, empty when they're even
+	Safer string `json:"safer,omitempty"`
+}
+
+// ProtocolComparison is a side by side comparison of two protocols
+type ProtocolComparison struct {
+	A ProtocolProfile `json:"a"`
+	B ProtocolProfile `json:"b"`
+	// Safer is the protocol with the lower risk score, empty when the scores
+	// are within comparableRiskPoints or a protocol has no scanned pools
+	Safer   string             `json:"safer,omitempty"`
+	Factors []FactorComparison `json:"factors"`
+}
+
+// CompareProtocols compares two profiles factor by factor
+func CompareProtocols(a, b ProtocolProfile) ProtocolComparison {
+	comparison := ProtocolComparison{A: a, B: b, Factors: make([]FactorComparison, 0, len(a.RiskBreakdown))}
+	if a.Pools > 0 && b.Pools > 0 && math.Abs(a.RiskScore-b.RiskScore) >= comparableRiskPoints {
+		comparison.Safer = a.Protocol
+		if b.RiskScore < a.RiskScore {
+			comparison.Safer = b.Protocol
+		}
+	}
+
+	risksB := make(map[string]float64, len(b.RiskBreakdown))
+	for _, component := range b.RiskBreakdown {
+		risksB[component.Factor] = component.Risk
+	}
+	for _, component := range a.RiskBreakdown {
+		riskB, ok := risksB[component.Factor]
+		if !ok {
+			continue
+		}
+		factor := FactorComparison{Factor: component.Factor, RiskA: component.Risk, RiskB: riskB}
+		switch {
+		case component.Risk < riskB:
+			factor.Safer = a.Protocol
+		case riskB < component.Risk:
+			factor.Safer = b.Protocol
+		}
+		comparison.Factors = append(comparison.Factors, factor)
+	}
+	return comparison
+}
+
+// describeAudit names a protocol's audit status
+func describeAudit(audited *bool) string {
+	switch {
+	case audited == nil:
+		return "unknown"
+	case *audited:
+		return "audited"
+	default:
+		return "not audited"
+	}
+}
+
+// describeFeeShare names the share of yield paid from fees and interest
+func describeFeeShare(profile ProtocolProfile) string {
+	if profile.FeeShare == nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s (%.0f%% from fees)", profile.Sustainability, *profile.FeeShare*100)
+}
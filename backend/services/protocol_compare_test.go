@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kaiaRegistry is a registry of protocols whose names share a prefix
+func kaiaRegistry() *ProtocolRegistry {
+	return &ProtocolRegistry{
+		Weights: DefaultRiskWeights(),
+		Protocols: []ProtocolInfo{
+			{Name: "KaiaSwap"},
+			{Name: "KaiaLend"},
+			{Name: "KaiaStake"},
+			{Name: "Aave V3"},
+		},
+	}
+}
+
+func matchedNames(matches []ProtocolMatch) []string {
+	names := make([]string, len(matches))
+	for i, match := range matches {
+		names[i] = match.Name
+	}
+	return names
+}
+
+func TestMatchProtocolsResolvesNamesAndTypos(t *testing.T) {
+	registry := kaiaRegistry()
+
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"Is KaiaSwap safer than KaiaLend?", []string{"KaiaSwap", "KaiaLend"}},
+		{"kaialend vs kaiaswap", []string{"KaiaLend", "KaiaSwap"}},
+		{"is kaiaswpa safer than kaia-lend", []string{"KaiaSwap", "KaiaLend"}},
+		{"is uniswap safer than curve", nil},
+		{"compare kaislend and aave", []string{"KaiaLend", "Aave V3"}},
+		{"do you have anything safer than kaiastake", []string{"KaiaStake"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, nilIfEmpty(matchedNames(MatchProtocols(registry, tt.message))), tt.message)
+	}
+
+	matches := MatchProtocols(registry, "is kaiaswpa safer than KaiaLend")
+	require.Len(t, matches, 2)
+	assert.Equal(t, "KaiaSwap", matches[0].Name)
+	assert.Equal(t, "kaiaswpa", matches[0].Mention)
+	assert.Equal(t, 1, matches[0].Distance)
+	assert.Zero(t, matches[1].Distance)
+
+	assert.Equal(t, []string{"KaiaSwap", "KaiaStake", "Aave V3"},
+		NearProtocols(registry, "is kaiasap safer than kaialend", MatchProtocols(registry, "kaialend")))
+}
+
+func nilIfEmpty(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+func TestChatComparesProtocols(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	for _, text := range []string{"Is Uniswap V3 safer than Aave V3?", "compare uniswpa and compund"} {
+		response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m", UserID: "0xuser", Message: text})
+		require.NoError(t, err, text)
+		assert.Equal(t, "protocol_compare", response.Metadata["intent"], text)
+		require.True(t, response.Success, text)
+
+		comparison := response.Data.(ProtocolComparison)
+		assert.Equal(t, 1, comparison.A.Pools)
+		assert.Equal(t, 1500000.0, comparison.A.TVL)
+		require.NotNil(t, comparison.A.Audited)
+		assert.True(t, *comparison.A.Audited)
+		assert.NotEmpty(t, comparison.A.RiskBreakdown)
+		assert.Equal(t, SustainabilityUnknown, comparison.A.Sustainability)
+		assert.Len(t, comparison.Factors, len(comparison.A.RiskBreakdown))
+		assert.Contains(t, response.Response, "Uniswap V3 vs")
+		assert.Contains(t, response.Response, "Audit: audited")
+	}
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m", UserID: "0xuser", Message: "is aave v3 safer than kompound?"})
+	require.NoError(t, err)
+	assert.Equal(t, "protocol_compare", response.Metadata["intent"])
+	assert.False(t, response.Success)
+	assert.Equal(t, true, response.Metadata["clarification"])
+	assert.Contains(t, response.Response, "I recognized **Aave V3**")
+	assert.Contains(t, response.Response, "Did you mean Compound V3, Uniswap V3?")
+}
+
+func TestCompareProtocolsRatesSustainability(t *testing.T) {
+	emissions := 0.75
+	info := ProtocolInfo{Name: "KaiaSwap", Pools: []ProtocolPool{{AssetPair: "KAIA/USDT", EmissionShare: &emissions}}}
+	opportunities := []YieldOpportunity{
+		{Protocol: "KaiaSwap", AssetPair: "KAIA/USDT", TVL: 300, RiskScore: 60, TVLTrend: -10,
+			RiskBreakdown: []RiskComponent{{Factor: RiskFactorTVL, Risk: 0.9}}},
+		{Protocol: "KaiaSwap", AssetPair: "KAIA/ETH", TVL: 100, RiskScore: 20, TVLTrend: 10,
+			RiskBreakdown: []RiskComponent{{Factor: RiskFactorTVL, Risk: 0.5}}},
+		{Protocol: "KaiaLend", AssetPair: "USDT", TVL: 1000, RiskScore: 49,
+			RiskBreakdown: []RiskComponent{{Factor: RiskFactorTVL, Risk: 0.2}}},
+	}
+
+	swap := ProfileProtocol(info, opportunities, utcNow())
+	assert.Equal(t, 2, swap.Pools)
+	assert.Equal(t, 400.0, swap.TVL)
+	assert.Equal(t, 50.0, swap.RiskScore)
+	assert.Equal(t, -5.0, swap.TVLTrend)
+	assert.Equal(t, 0.8, swap.RiskBreakdown[0].Risk)
+	require.NotNil(t, swap.FeeShare)
+	assert.Equal(t, 0.25, *swap.FeeShare)
+	assert.Equal(t, SustainabilityLow, swap.Sustainability)
+
+	lend := ProfileProtocol(ProtocolInfo{Name: "KaiaLend"}, opportunities, utcNow())
+	comparison := CompareProtocols(swap, lend)
+	assert.Empty(t, comparison.Safer, "within 2 points")
+	require.Len(t, comparison.Factors, 1)
+	assert.Equal(t, "KaiaLend", comparison.Factors[0].Safer)
+
+	lend.RiskScore = 40
+	assert.Equal(t, "KaiaLend", CompareProtocols(swap, lend).Safer)
+	assert.Empty(t, CompareProtocols(swap, ProfileProtocol(ProtocolInfo{Name: "KaiaStake"}, nil, utcNow())).Safer)
+}
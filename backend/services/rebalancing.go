@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultRebalanceBenefitRate is what moving a dollar of drift back to its
+	// target weight is taken to be worth; a trade costing more is skipped
+	DefaultRebalanceBenefitRate = 0.01
+
+	// swapGasUnits is the gas of one swap through a DEX pair
+	swapGasUnits = 150000
+	// minRebalanceTradeUSD is the smallest drift worth a trade
+	minRebalanceTradeUSD = 0.01
+)
+
+// Rebalancing verdicts
+const (
+	RebalanceVerdictExecute       = "rebalance"
+	RebalanceVerdictNotEconomical = "rebalancing not economical"
+	RebalanceVerdictBalanced      = "already balanced"
+)
+
+// SwapQuote is the expected execution of a swap of AmountUSD worth of From
+// into To
+type SwapQuote struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	AmountUSD float64 `json:"amount_usd"`
+	// PriceImpact is the price impact of the whole route, in percent
+	PriceImpact float64  `json:"price_impact"`
+	GasUnits    uint64   `json:"gas_units"`
+	Route       []string `json:"route"`
+}
+
+// SwapQuoter quotes swaps between assets
+type SwapQuoter interface {
+	Quote(ctx context.Context, from, to string, amountUSD float64) (SwapQuote, error)
+}
+
+// GasPriceSource suggests the gas price of a transaction, in wei
+type GasPriceSource interface {
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+}
+
+// PoolDepthQuoter quotes swaps from the depth of the configured DEX pairs.
+// A swap sells From in its deepest pool and, when that pool doesn't quote
+// To, buys To in its own deepest pool, one swap per hop.
+type PoolDepthQuoter struct {
+	depths *PoolDepthReader
+	prices LivePriceSource
+}
+
+// NewPoolDepthQuoter creates a quoter over the pool depths, sizing trades
+// with the live prices
+func NewPoolDepthQuoter(depths *PoolDepthReader, prices LivePriceSource) *PoolDepthQuoter {
+	return &PoolDepthQuoter{depths: depths, prices: prices}
+}
+
+// Quote routes a swap through the deepest pools of its assets
+func (q *PoolDepthQuoter) Quote(ctx context.Context, from, to string, amountUSD float64) (SwapQuote, error) {
+	fromPrice, err := q.livePrice(from)
+	if err != nil {
+		return SwapQuote{}, err
+	}
+	sell, err := q.depths.Depth(ctx, from)
+	if err != nil {
+		return SwapQuote{}, err
+	}
+
+	quote := SwapQuote{
+		From:        from,
+		To:          to,
+		AmountUSD:   amountUSD,
+		PriceImpact: sell.PriceImpact("sell", amountUSD/fromPrice),
+		GasUnits:    swapGasUnits,
+		Route:       []string{from, sell.QuoteAsset},
+	}
+	if sameAsset(sell.QuoteAsset, to) {
+		return quote, nil
+	}
+
+	toPrice, err := q.livePrice(to)
+	if err != nil {
+		return SwapQuote{}, err
+	}
+	buy, err := q.depths.Depth(ctx, to)
+	if err != nil {
+		return SwapQuote{}, err
+	}
+	quote.PriceImpact += buy.PriceImpact("buy", amountUSD/toPrice)
+	quote.GasUnits += swapGasUnits
+	quote.Route = append(quote.Route, to)
+	return quote, nil
+}
+
+// livePrice returns the positive live price of an asset
+func (q *PoolDepthQuoter) livePrice(asset string) (float64, error) {
+	live, ok := q.prices.Price(strings.ToUpper(asset))
+	if !ok || live.Price <= 0 {
+		return 0, fmt.Errorf("%w for %s", ErrNoLivePrice, asset)
+	}
+	return live.Price, nil
+}
+
+// RebalanceTrade is one swap of a rebalancing plan. Its cost is the gas and
+// price impact of the swap; its benefit is the drift it removes, valued at
+// the plan's benefit rate.
+type RebalanceTrade struct {
+	Step            int      `json:"step"`
+	From            string   `json:"from"`
+	To              string   `json:"to"`
+	AmountUSD       float64  `json:"amount_usd"`
+	Route           []string `json:"route,omitempty"`
+	PriceImpact     float64  `json:"price_impact"`
+	GasUnits        uint64   `json:"gas_units"`
+	GasCostUSD      float64  `json:"gas_cost_usd"`
+	ImpactCostUSD   float64  `json:"impact_cost_usd"`
+	CostUSD         float64  `json:"cost_usd"`
+	DriftBenefitUSD float64  `json:"drift_benefit_usd"`
+	Skipped         bool     `json:"skipped"`
+	SkipReason      string   `json:"skip_reason,omitempty"`
+}
+
+// RebalancePlan is the ordered swaps moving a portfolio to its target
+// weights, largest first. Totals cover the trades that aren't skipped.
+type RebalancePlan struct {
+	PortfolioValueUSD float64          `json:"portfolio_value_usd"`
+	GasPriceGwei      float64          `json:"gas_price_gwei"`
+	BenefitRate       float64          `json:"benefit_rate"`
+	Trades            []RebalanceTrade `json:"trades"`
+	TotalCostUSD      float64          `json:"total_cost_usd"`
+	TotalCostPercent  float64          `json:"total_cost_percent"`
+	Economical        bool             `json:"economical"`
+	Verdict           string           `json:"verdict"`
+	// BreakevenValueUSD is the portfolio value at which the trades' drift
+	// benefit pays for their gas, set when gas makes rebalancing
+	// uneconomical. Price impact, which grows with size, is left out.
+	BreakevenValueUSD float64 `json:"breakeven_value_usd,omitempty"`
+}
+
+// rebalanceGas is the gas price and native price a plan is costed at
+type rebalanceGas struct {
+	GasPriceGwei   float64 `json:"gas_price_gwei"`
+	NativePriceUSD float64 `json:"native_price_usd"`
+}
+
+// pinnedQuote is a swap quote as read for a plan, or why there was none
+type pinnedQuote struct {
+	Quote *SwapQuote `json:"quote,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// Rebalancer plans the swaps moving a portfolio to target weights, costed
+// with quotes and the gas price
+type Rebalancer struct {
+	quotes      SwapQuoter
+	gas         GasPriceSource
+	prices      LivePriceSource
+	benefitRate float64
+}
+
+// NewRebalancer creates a planner pricing gas in the native currency's live
+// price
+func NewRebalancer(quotes SwapQuoter, gas GasPriceSource, prices LivePriceSource) *Rebalancer {
+	return &Rebalancer{
+		quotes:      quotes,
+		gas:         gas,
+		prices:      prices,
+		benefitRate: DefaultRebalanceBenefitRate,
+	}
+}
+
+// Plan matches the assets above their target weight with those below it,
+// largest drifts first, and costs each swap. Holdings are USD values by
+// asset and target weights sum to one. A swap costing more than the drift it
+// removes is skipped; when every swap is skipped the plan is not economical.
+func (r *Rebalancer) Plan(ctx context.Context, holdings, target map[string]float64) (*RebalancePlan, error) {
+	value := 0.0
+	for _, held := range holdings {
+		value += held
+	}
+	if value <= 0 {
+		return nil, errors.New("portfolio has no value to rebalance")
+	}
+
+	gas, err := pinInput(ctx, "rebalance_gas", func() (rebalanceGas, error) {
+		gasPrice, err := r.gas.SuggestGasPrice(ctx)
+		if err != nil {
+			return rebalanceGas{}, fmt.Errorf("failed to read gas price: %w", err)
+		}
+		native, ok := r.prices.Price(NativeSymbol)
+		if !ok || native.Price <= 0 {
+			return rebalanceGas{}, fmt.Errorf("%w for %s", ErrNoLivePrice, NativeSymbol)
+		}
+		return rebalanceGas{GasPriceGwei: weiToFloat(gasPrice, 9), NativePriceUSD: native.Price}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RebalancePlan{
+		PortfolioValueUSD: value,
+		GasPriceGwei:      gas.GasPriceGwei,
+		BenefitRate:       r.benefitRate,
+		Trades:            []RebalanceTrade{},
+	}
+	for _, trade := range matchDrifts(holdings, target, value) {
+		quote, err := pinInput(ctx, "swap_quote:"+trade.From+">"+trade.To, func() (pinnedQuote, error) {
+			quote, err := r.quotes.Quote(ctx, trade.From, trade.To, trade.AmountUSD)
+			if err != nil {
+				return pinnedQuote{Error: err.Error()}, nil
+			}
+			return pinnedQuote{Quote: &quote}, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		trade.Step = len(plan.Trades) + 1
+		trade.DriftBenefitUSD = roundTo(trade.AmountUSD*r.benefitRate, 2)
+		if quote.Quote == nil {
+			trade.Skipped = true
+			trade.SkipReason = "no quote: " + quote.Error
+			plan.Trades = append(plan.Trades, trade)
+			continue
+		}
+		trade.Route = quote.Quote.Route
+		trade.PriceImpact = roundTo(quote.Quote.PriceImpact, 4)
+		trade.GasUnits = quote.Quote.GasUnits
+		trade.GasCostUSD = roundTo(float64(quote.Quote.GasUnits)*gas.GasPriceGwei/1e9*gas.NativePriceUSD, 2)
+		trade.ImpactCostUSD = roundTo(trade.AmountUSD*quote.Quote.PriceImpact/100, 2)
+		trade.CostUSD = roundTo(trade.GasCostUSD+trade.ImpactCostUSD, 2)
+		if trade.CostUSD > trade.DriftBenefitUSD {
+			trade.Skipped = true
+			trade.SkipReason = fmt.Sprintf("costs $%s for $%s of drift benefit", NumberFormat{}.Decimal(trade.CostUSD, 2), NumberFormat{}.Decimal(trade.DriftBenefitUSD, 2))
+		} else {
+			plan.TotalCostUSD += trade.CostUSD
+		}
+		plan.Trades = append(plan.Trades, trade)
+	}
+
+	plan.TotalCostUSD = roundTo(plan.TotalCostUSD, 2)
+	plan.TotalCostPercent = roundTo(plan.TotalCostUSD/value*100, 4)
+	plan.decide(r.benefitRate)
+	return plan, nil
+}
+
+// decide sets the plan's verdict from its trades
+func (p *RebalancePlan) decide(benefitRate float64) {
+	if len(p.Trades) == 0 {
+		p.Verdict = RebalanceVerdictBalanced
+		return
+	}
+	var turnover, gasCost, impactCost float64
+	for _, trade := range p.Trades {
+		if !trade.Skipped {
+			p.Economical = true
+		}
+		turnover += trade.AmountUSD
+		gasCost += trade.GasCostUSD
+		impactCost += trade.ImpactCostUSD
+	}
+	if p.Economical {
+		p.Verdict = RebalanceVerdictExecute
+		return
+	}
+
+	p.Verdict = RebalanceVerdictNotEconomical
+	if gasCost > impactCost {
+		// The trades scale with the portfolio while their gas doesn't: they
+		// break even once turnover*benefitRate covers it
+		turnoverShare := turnover / p.PortfolioValueUSD
+		p.BreakevenValueUSD = roundTo(gasCost/(turnoverShare*benefitRate), 2)
+	}
+}
+
+// matchDrifts pairs the assets above their target value with those below
+// it, the largest drifts first, into swaps ordered by size
+func matchDrifts(holdings, target map[string]float64, value float64) []RebalanceTrade {
+	type drift struct {
+		asset  string
+		amount float64
+	}
+	assets := make(map[string]bool, len(holdings)+len(target))
+	for asset := range holdings {
+		assets[asset] = true
+	}
+	for asset := range target {
+		assets[asset] = true
+	}
+	var over, under []drift
+	for asset := range assets {
+		amount := target[asset]*value - holdings[asset]
+		switch {
+		case amount <= -minRebalanceTradeUSD:
+			over = append(over, drift{asset, -amount})
+		case amount >= minRebalanceTradeUSD:
+			under = append(under, drift{asset, amount})
+		}
+	}
+	byAmount := func(drifts []drift) {
+		sort.Slice(drifts, func(i, j int) bool {
+			if drifts[i].amount != drifts[j].amount {
+				return drifts[i].amount > drifts[j].amount
+			}
+			return drifts[i].asset < drifts[j].asset
+		})
+	}
+	byAmount(over)
+	byAmount(under)
+
+	var trades []RebalanceTrade
+	for i, j := 0, 0; i < len(over) && j < len(under); {
+		amount := math.Min(over[i].amount, under[j].amount)
+		if amount >= minRebalanceTradeUSD {
+			trades = append(trades, RebalanceTrade{From: over[i].asset, To: under[j].asset, AmountUSD: roundTo(amount, 2)})
+		}
+		over[i].amount -= amount
+		under[j].amount -= amount
+		if over[i].amount < minRebalanceTradeUSD {
+			i++
+		}
+		if under[j].amount < minRebalanceTradeUSD {
+			j++
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].AmountUSD > trades[j].AmountUSD })
+	return trades
+}
+
+// formatRebalancePlan describes a rebalancing plan's swaps and verdict for chat
+func formatRebalancePlan(plan *RebalancePlan, money ConversionRate) string {
+	numbers := money.Numbers()
+	var text strings.Builder
+	switch plan.Verdict {
+	case RebalanceVerdictBalanced:
+		return "Your portfolio is already at its target weights.\n"
+	case RebalanceVerdictNotEconomical:
+		text.WriteString("⚠️ Rebalancing is not economical: every swap costs more than the drift it fixes.\n")
+		if plan.BreakevenValueUSD > 0 {
+			text.WriteString(fmt.Sprintf("At %s gwei, it pays off from a portfolio of about %s.\n", numbers.Trimmed(plan.GasPriceGwei, 2), money.Format(plan.BreakevenValueUSD)))
+		}
+		return text.String()
+	}
+
+	text.WriteString(fmt.Sprintf("Estimated Cost: %s (%s of the portfolio)\n\n**Plan**\n", money.Format(plan.TotalCostUSD), numbers.Percent(plan.TotalCostPercent, 2)))
+	for _, trade := range plan.Trades {
+		if trade.Skipped {
+			text.WriteString(fmt.Sprintf("%d. ~~Swap %s of %s to %s~~: skipped, %s\n", trade.Step, money.Format(trade.AmountUSD), trade.From, trade.To, trade.SkipReason))
+			continue
+		}
+		text.WriteString(fmt.Sprintf("%d. Swap %s of %s to %s: %s gas, %s impact\n",
+			trade.Step, money.Format(trade.AmountUSD), trade.From, trade.To, money.Format(trade.GasCostUSD), numbers.Percent(trade.PriceImpact, 2)))
+	}
+	return text.String()
+}
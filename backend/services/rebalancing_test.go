@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedGasPrice is a gas price source with a set price, in gwei
+type fixedGasPrice int64
+
+func (g fixedGasPrice) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Mul(big.NewInt(int64(g)), big.NewInt(1e9)), nil
+}
+
+// fakeQuotes quotes the swaps it holds by "FROM>TO" and fails the others
+type fakeQuotes map[string]SwapQuote
+
+func (q fakeQuotes) Quote(ctx context.Context, from, to string, amountUSD float64) (SwapQuote, error) {
+	quote, ok := q[from+">"+to]
+	if !ok {
+		return SwapQuote{}, fmt.Errorf("no route from %s to %s", from, to)
+	}
+	quote.From, quote.To, quote.AmountUSD = from, to, amountUSD
+	return quote, nil
+}
+
+// newTestRebalancer costs gas at 20 gwei and $2000 KAIA, so a swap's gas is $6
+func newTestRebalancer(quotes fakeQuotes) *Rebalancer {
+	return NewRebalancer(quotes, fixedGasPrice(20), fixedPrices{NativeSymbol: 2000})
+}
+
+func TestRebalancePlanOrdersAndSkipsTrades(t *testing.T) {
+	rebalancer := newTestRebalancer(fakeQuotes{
+		"AAA>BBB": {PriceImpact: 0.1, GasUnits: swapGasUnits, Route: []string{"AAA", "BBB"}},
+		"AAA>CCC": {GasUnits: 2 * swapGasUnits, Route: []string{"AAA", "USDT", "CCC"}},
+	})
+	holdings := map[string]float64{"AAA": 6000, "BBB": 2000, "CCC": 2000}
+	target := map[string]float64{"AAA": 0.3, "BBB": 0.4, "CCC": 0.3}
+
+	plan, err := rebalancer.Plan(context.Background(), holdings, target)
+	require.NoError(t, err)
+	require.Len(t, plan.Trades, 2)
+
+	first, second := plan.Trades[0], plan.Trades[1]
+	assert.Equal(t, 1, first.Step)
+	assert.Equal(t, "AAA", first.From)
+	assert.Equal(t, "BBB", first.To)
+	assert.Equal(t, 2000.0, first.AmountUSD, "the largest swap comes first")
+	assert.Equal(t, 6.0, first.GasCostUSD)
+	assert.Equal(t, 2.0, first.ImpactCostUSD)
+	assert.Equal(t, 20.0, first.DriftBenefitUSD)
+	assert.False(t, first.Skipped)
+
+	assert.Equal(t, 2, second.Step)
+	assert.Equal(t, "CCC", second.To)
+	assert.Equal(t, 1000.0, second.AmountUSD)
+	assert.Equal(t, 12.0, second.CostUSD, "two hops pay gas twice")
+	assert.True(t, second.Skipped, "$12 of cost for $10 of drift benefit")
+	assert.Contains(t, second.SkipReason, "costs $12.00")
+
+	assert.Equal(t, 8.0, plan.TotalCostUSD, "skipped trades cost nothing")
+	assert.Equal(t, 0.08, plan.TotalCostPercent)
+	assert.Equal(t, 20.0, plan.GasPriceGwei)
+	assert.True(t, plan.Economical)
+	assert.Equal(t, RebalanceVerdictExecute, plan.Verdict)
+	assert.Zero(t, plan.BreakevenValueUSD)
+}
+
+func TestRebalancePlanBreakeven(t *testing.T) {
+	rebalancer := newTestRebalancer(fakeQuotes{"AAA>BBB": {GasUnits: swapGasUnits}})
+	target := map[string]float64{"AAA": 0.5, "BBB": 0.5}
+
+	plan, err := rebalancer.Plan(context.Background(), map[string]float64{"AAA": 900, "BBB": 100}, target)
+	require.NoError(t, err)
+	require.Len(t, plan.Trades, 1)
+	assert.True(t, plan.Trades[0].Skipped, "$6 of gas for $4 of drift benefit")
+	assert.False(t, plan.Economical)
+	assert.Equal(t, RebalanceVerdictNotEconomical, plan.Verdict)
+	assert.Zero(t, plan.TotalCostUSD)
+	// 40% of the portfolio moves, so $6 of gas pays off at 6 / (0.4 * 1%)
+	assert.Equal(t, 1500.0, plan.BreakevenValueUSD)
+	assert.Contains(t, formatRebalancePlan(plan, USDConversion(time.Now())), "portfolio of about $1,500.00")
+
+	plan, err = rebalancer.Plan(context.Background(), map[string]float64{"AAA": 1350, "BBB": 150}, target)
+	require.NoError(t, err)
+	assert.False(t, plan.Trades[0].Skipped, "at the breakeven value the benefit covers the gas")
+	assert.Equal(t, RebalanceVerdictExecute, plan.Verdict)
+}
+
+func TestRebalancePlanWithoutQuotes(t *testing.T) {
+	rebalancer := newTestRebalancer(fakeQuotes{})
+
+	plan, err := rebalancer.Plan(context.Background(), map[string]float64{"AAA": 800, "BBB": 200}, map[string]float64{"AAA": 0.5, "BBB": 0.5})
+	require.NoError(t, err)
+	require.Len(t, plan.Trades, 1)
+	assert.True(t, plan.Trades[0].Skipped)
+	assert.Equal(t, "no quote: no route from AAA to BBB", plan.Trades[0].SkipReason)
+	assert.Equal(t, RebalanceVerdictNotEconomical, plan.Verdict)
+	assert.Zero(t, plan.BreakevenValueUSD, "there's no gas to break even on")
+
+	plan, err = rebalancer.Plan(context.Background(), map[string]float64{"AAA": 500, "BBB": 500}, map[string]float64{"AAA": 0.5, "BBB": 0.5})
+	require.NoError(t, err)
+	assert.Empty(t, plan.Trades)
+	assert.Equal(t, RebalanceVerdictBalanced, plan.Verdict)
+
+	_, err = rebalancer.Plan(context.Background(), map[string]float64{}, map[string]float64{"AAA": 1})
+	assert.Error(t, err)
+}
+
+func TestPortfolioOptimizationPlansRebalancing(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.SetRebalancer(newTestRebalancer(fakeQuotes{
+		"ETH>DAI":    {PriceImpact: 0.05, GasUnits: swapGasUnits},
+		"USDC>Other": {PriceImpact: 0.2, GasUnits: 2 * swapGasUnits},
+	}))
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "portfolio_optimization", map[string]interface{}{"portfolio_value_usd": 100000.0})
+	require.NoError(t, err)
+	optimization := result.Data.(map[string]interface{})
+	plan := optimization["rebalancing_plan"].(*RebalancePlan)
+	require.Len(t, plan.Trades, 2)
+	assert.Equal(t, "ETH", plan.Trades[0].From)
+	assert.Equal(t, "USDC", plan.Trades[1].From)
+	assert.Equal(t, 5000.0, plan.Trades[1].AmountUSD)
+	// $6 + $2.50 and $12 + $10
+	assert.Equal(t, 30.5, optimization["rebalancing_cost"])
+	assert.Equal(t, true, optimization["rebalancing_needed"])
+
+	result, err = engine.ProcessAnalyticsTask(context.Background(), "portfolio_optimization", map[string]interface{}{})
+	require.NoError(t, err)
+	assert.NotContains(t, result.Data.(map[string]interface{}), "rebalancing_plan")
+}
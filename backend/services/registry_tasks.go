@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Registry task statuses. The registry only records whether a task is
+// still active, so a task is pending until it is completed on chain.
+const (
+	RegistryTaskPending   = "pending"
+	RegistryTaskCompleted = "completed"
+)
+
+// ValidRegistryTaskStatus reports whether status names a registry task status
+func ValidRegistryTaskStatus(status string) bool {
+	return status == RegistryTaskPending || status == RegistryTaskCompleted
+}
+
+// analyticsRegistryABI covers the task reads of AnalyticsRegistry
+const analyticsRegistryABI = `[
+	{"type":"function","name":"totalTasks","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"getTask","stateMutability":"view","inputs":[{"name":"_taskId","type":"uint256"}],"outputs":[{"name":"task","type":"tuple","components":[
+		{"name":"taskId","type":"uint256"},
+		{"name":"requester","type":"address"},
+		{"name":"taskType","type":"string"},
+		{"name":"parameters","type":"string"},
+		{"name":"timestamp","type":"uint256"},
+		{"name":"isActive","type":"bool"},
+		{"name":"completionTime","type":"uint256"},
+		{"name":"resultHash","type":"string"}
+	]}]}
+]`
+
+// analyticsRegistryCalls is the parsed analyticsRegistryABI
+var analyticsRegistryCalls = mustParseABI(analyticsRegistryABI)
+
+// onchainRegistryTask is the AnalyticsTask tuple of the registry
+type onchainRegistryTask struct {
+	TaskId         *big.Int
+	Requester      common.Address
+	TaskType       string
+	Parameters     string
+	Timestamp      *big.Int
+	IsActive       bool
+	CompletionTime *big.Int
+	ResultHash     string
+}
+
+// RegistryTask is an analytics task as stored in the AnalyticsRegistry
+type RegistryTask struct {
+	ID           uint64  `json:"id"`
+	Requester    string  `json:"requester"`
+	TaskType     string  `json:"task_type"`
+	Parameters   string  `json:"parameters"`
+	Status       string  `json:"status"`
+	RegisteredAt APITime `json:"registered_at"`
+	CompletedAt  APITime `json:"completed_at"`
+	ResultHash   string  `json:"result_hash,omitempty"`
+	// AgeSeconds is the time since registration, or until completion for
+	// completed tasks
+	AgeSeconds int64 `json:"age_seconds"`
+}
+
+// RegistryTaskReader reads tasks from the analytics registry
+type RegistryTaskReader interface {
+	TotalTasks(ctx context.Context) (uint64, error)
+	Task(ctx context.Context, id uint64) (RegistryTask, error)
+}
+
+// ChainRegistryTaskReader reads tasks from the deployed AnalyticsRegistry
+type ChainRegistryTaskReader struct {
+	caller   ethereum.ContractCaller
+	contract common.Address
+}
+
+// NewChainRegistryTaskReader creates a reader for the deployed registry
+func NewChainRegistryTaskReader(caller ethereum.ContractCaller, contract common.Address) *ChainRegistryTaskReader {
+	return &ChainRegistryTaskReader{caller: caller, contract: contract}
+}
+
+// TotalTasks reads the registry's task counter; task IDs run from 1 to it
+func (r *ChainRegistryTaskReader) TotalTasks(ctx context.Context) (uint64, error) {
+	out, err := r.call(ctx, "totalTasks")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read task count: %w", err)
+	}
+	total := out[0].(*big.Int)
+	if !total.IsUint64() {
+		return 0, fmt.Errorf("invalid task count %s", total)
+	}
+	return total.Uint64(), nil
+}
+
+// Task reads one task
+func (r *ChainRegistryTaskReader) Task(ctx context.Context, id uint64) (RegistryTask, error) {
+	out, err := r.call(ctx, "getTask", new(big.Int).SetUint64(id))
+	if err != nil {
+		return RegistryTask{}, fmt.Errorf("failed to read task %d: %w", id, err)
+	}
+	task, ok := abi.ConvertType(out[0], new(onchainRegistryTask)).(*onchainRegistryTask)
+	if !ok {
+		return RegistryTask{}, fmt.Errorf("failed to decode task %d", id)
+	}
+
+	decoded := RegistryTask{
+		ID:           task.TaskId.Uint64(),
+		Requester:    task.Requester.Hex(),
+		TaskType:     task.TaskType,
+		Parameters:   task.Parameters,
+		Status:       RegistryTaskPending,
+		RegisteredAt: UnixAPITime(task.Timestamp.Int64()),
+		ResultHash:   task.ResultHash,
+	}
+	if !task.IsActive {
+		decoded.Status = RegistryTaskCompleted
+		decoded.CompletedAt = UnixAPITime(task.CompletionTime.Int64())
+	}
+	return decoded, nil
+}
+
+func (r *ChainRegistryTaskReader) call(ctx context.Context, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := analyticsRegistryCalls.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	contract := r.contract
+	result, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return analyticsRegistryCalls.Unpack(method, result)
+}
+
+// RegistryTasks indexes the registry's tasks by walking its task counter.
+// Completed tasks can't change, so each refresh only reads tasks registered
+// since the last one and those still pending.
+type RegistryTasks struct {
+	reader RegistryTaskReader
+	now    func() time.Time
+
+	// refreshMu serializes refreshes so concurrent requests don't read the
+	// same tasks twice
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	tasks     map[uint64]RegistryTask
+	total     uint64
+}
+
+// NewRegistryTasks creates an empty index over the reader
+func NewRegistryTasks(reader RegistryTaskReader) *RegistryTasks {
+	return &RegistryTasks{
+		reader: reader,
+		now:    utcNow,
+		tasks:  make(map[uint64]RegistryTask),
+	}
+}
+
+// SetReader swaps the registry the tasks are read from, dropping the tasks
+// read from the previous one. It waits for a refresh in progress, so no
+// refresh mixes tasks from both.
+func (rt *RegistryTasks) SetReader(reader RegistryTaskReader) {
+	rt.refreshMu.Lock()
+	defer rt.refreshMu.Unlock()
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.reader = reader
+	rt.tasks = make(map[uint64]RegistryTask)
+	rt.total = 0
+}
+
+// Refresh reads new tasks and re-reads pending ones
+func (rt *RegistryTasks) Refresh(ctx context.Context) error {
+	rt.refreshMu.Lock()
+	defer rt.refreshMu.Unlock()
+
+	total, err := rt.reader.TotalTasks(ctx)
+	if err != nil {
+		return err
+	}
+
+	rt.mu.RLock()
+	var stale []uint64
+	for id, task := range rt.tasks {
+		if task.Status == RegistryTaskPending {
+			stale = append(stale, id)
+		}
+	}
+	for id := rt.total + 1; id <= total; id++ {
+		stale = append(stale, id)
+	}
+	rt.mu.RUnlock()
+
+	read := make([]RegistryTask, 0, len(stale))
+	for _, id := range stale {
+		task, err := rt.reader.Task(ctx, id)
+		if err != nil {
+			return err
+		}
+		read = append(read, task)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for _, task := range read {
+		rt.tasks[task.ID] = task
+	}
+	rt.total = total
+	return nil
+}
+
+// List refreshes the index and returns a page of the tasks with the status,
+// or of every task when status is empty, newest first, with the number of
+// matching tasks
+func (rt *RegistryTasks) List(ctx context.Context, status string, limit, offset int) ([]RegistryTask, int, error) {
+	if err := rt.Refresh(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	rt.mu.RLock()
+	matching := make([]RegistryTask, 0, len(rt.tasks))
+	for _, task := range rt.tasks {
+		if status == "" || task.Status == status {
+			matching = append(matching, task)
+		}
+	}
+	rt.mu.RUnlock()
+
+	sort.Slice(matching, func(i, j int) bool { return matching[i].ID > matching[j].ID })
+	page := matching[min(offset, len(matching)):min(offset+limit, len(matching))]
+	now := rt.now()
+	for i := range page {
+		until := now
+		if !page[i].CompletedAt.IsZero() {
+			until = page[i].CompletedAt.Time
+		}
+		page[i].AgeSeconds = int64(until.Sub(page[i].RegisteredAt.Time).Seconds())
+	}
+	return page, len(matching), nil
+}
+
+// PendingTasks returns every task not yet completed on chain, oldest first
+func (rt *RegistryTasks) PendingTasks(ctx context.Context) ([]RegistryTask, error) {
+	pending, _, err := rt.List(ctx, RegistryTaskPending, math.MaxInt, 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+	return pending, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var analyticsRegistryAddress = common.HexToAddress("0x00000000000000000000000000000000000000a5")
+
+// fakeAnalyticsRegistry answers the task reads of analyticsRegistryABI,
+// counting getTask calls
+type fakeAnalyticsRegistry struct {
+	tasks []onchainRegistryTask
+	reads int
+}
+
+func (f *fakeAnalyticsRegistry) register(taskType string, at time.Time) {
+	f.tasks = append(f.tasks, onchainRegistryTask{
+		TaskId:         big.NewInt(int64(len(f.tasks) + 1)),
+		Requester:      summaryAddress,
+		TaskType:       taskType,
+		Parameters:     `{"risk_tolerance":"medium"}`,
+		Timestamp:      big.NewInt(at.Unix()),
+		IsActive:       true,
+		CompletionTime: new(big.Int),
+	})
+}
+
+func (f *fakeAnalyticsRegistry) complete(id int, at time.Time) {
+	f.tasks[id-1].IsActive = false
+	f.tasks[id-1].CompletionTime = big.NewInt(at.Unix())
+	f.tasks[id-1].ResultHash = "Qm" + f.tasks[id-1].TaskType
+}
+
+func (f *fakeAnalyticsRegistry) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if *call.To != analyticsRegistryAddress {
+		return nil, errors.New("execution reverted")
+	}
+	method, err := analyticsRegistryCalls.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, errors.New("execution reverted")
+	}
+	if method.Name == "totalTasks" {
+		return method.Outputs.Pack(big.NewInt(int64(len(f.tasks))))
+	}
+	args, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	id := args[0].(*big.Int).Int64()
+	if id < 1 || id > int64(len(f.tasks)) {
+		return nil, errors.New("execution reverted: TaskNotFound")
+	}
+	f.reads++
+	return method.Outputs.Pack(f.tasks[id-1])
+}
+
+func TestRegistryTasksMergesStatusesAndPages(t *testing.T) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	registry := &fakeAnalyticsRegistry{}
+	for i, taskType := range []string{"yield_analysis", "trading_suggestions", "governance_sentiment", "risk_assessment", "yield_analysis"} {
+		registry.register(taskType, clock.Now().Add(-time.Duration(5-i)*time.Hour))
+	}
+	registry.complete(2, clock.Now().Add(-3*time.Hour))
+	registry.complete(4, clock.Now().Add(-90*time.Minute))
+
+	tasks := NewRegistryTasks(NewChainRegistryTaskReader(registry, analyticsRegistryAddress))
+	tasks.now = clock.Now
+	ctx := context.Background()
+
+	pending, err := tasks.PendingTasks(ctx)
+	require.NoError(t, err)
+	var ids []uint64
+	for _, task := range pending {
+		ids = append(ids, task.ID)
+	}
+	assert.Equal(t, []uint64{1, 3, 5}, ids)
+	assert.Equal(t, int64(5*3600), pending[0].AgeSeconds)
+	assert.Equal(t, summaryAddress.Hex(), pending[0].Requester)
+	assert.True(t, pending[0].CompletedAt.IsZero())
+
+	completed, total, err := tasks.List(ctx, RegistryTaskCompleted, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, uint64(4), completed[0].ID)
+	assert.Equal(t, "Qmrisk_assessment", completed[0].ResultHash)
+	assert.Equal(t, int64(30*60), completed[0].AgeSeconds, "completed tasks age until completion")
+
+	// Every task, newest first, two at a time
+	page, total, err := tasks.List(ctx, "", 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+	require.Len(t, page, 2)
+	assert.Equal(t, uint64(3), page[0].ID)
+	assert.Equal(t, uint64(2), page[1].ID)
+	page, _, err = tasks.List(ctx, "", 2, 6)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+
+	// Refreshes read only new and still pending tasks
+	registry.complete(1, clock.Now())
+	registry.register("portfolio_optimization", clock.Now())
+	registry.reads = 0
+	pending, err = tasks.PendingTasks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, registry.reads, "tasks 1, 3 and 5 are re-read and task 6 is new")
+	ids = ids[:0]
+	for _, task := range pending {
+		ids = append(ids, task.ID)
+	}
+	assert.Equal(t, []uint64{3, 5, 6}, ids)
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayInputRetention is the least time the inputs of a result are kept, so
+// it can be replayed for at least this long after it was computed
+const ReplayInputRetention = 14 * 24 * time.Hour
+
+var (
+	// ErrReplayNotFound is returned for results without recorded inputs
+	ErrReplayNotFound = errors.New("no recorded inputs for result")
+	// ErrReplayInputMissing is returned when a recorded input is no longer
+	// stored, or a replay reads an input the original run didn't
+	ErrReplayInputMissing = errors.New("replay input missing")
+)
+
+// replayRunFields are the fields of an analytics result that describe the
+// run rather than what it computed, left out of replay diffs
+var replayRunFields = map[string]bool{
+	"task_id":         true,
+	"timestamp":       true,
+	"timestamp_unix":  true,
+	"processing_time": true,
+	"result_hash":     true,
+}
+
+// ReplayInput references a dataset a result was computed from by the hash
+// of its canonical JSON
+type ReplayInput struct {
+	Key        string  `json:"key"`
+	Hash       string  `json:"hash"`
+	RecordedAt APITime `json:"recorded_at"`
+}
+
+// ReplayRecord is what a result needs to be computed again: its task, the
+// hash of its parameters, and the inputs it read, in the order read
+type ReplayRecord struct {
+	ResultHash     string        `json:"result_hash"`
+	Type           string        `json:"type"`
+	ParametersHash string        `json:"parameters_hash"`
+	Inputs         []ReplayInput `json:"inputs"`
+	RecordedAt     APITime       `json:"recorded_at"`
+}
+
+// ReplayStore keeps the inputs of analytics results as canonical JSON blobs
+// addressed by hash, and for each result the record naming them. Blobs are
+// shared between results; storing a blob again refreshes its age.
+type ReplayStore struct {
+	mu      sync.RWMutex
+	blobs   map[string]storedBlob
+	records map[string]ReplayRecord
+	now     func() time.Time
+}
+
+// NewReplayStore creates an empty replay store
+func NewReplayStore() *ReplayStore {
+	return &ReplayStore{
+		blobs:   make(map[string]storedBlob),
+		records: make(map[string]ReplayRecord),
+		now:     utcNow,
+	}
+}
+
+// put stores a value's canonical JSON and returns its hash and the JSON
+func (rs *ReplayStore) put(value interface{}) (string, []byte, time.Time, error) {
+	hash, blob, err := HashResult(value)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := rs.now()
+	rs.blobs[hash] = storedBlob{blob: blob, storedAt: now}
+	return hash, blob, now, nil
+}
+
+// blob returns a stored blob by hash
+func (rs *ReplayStore) blob(hash string) ([]byte, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	stored, ok := rs.blobs[strings.ToLower(hash)]
+	return stored.blob, ok
+}
+
+// Record returns the replay record of a result
+func (rs *ReplayStore) Record(resultHash string) (ReplayRecord, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	record, ok := rs.records[strings.ToLower(resultHash)]
+	return record, ok
+}
+
+// save stores the result, its parameters and the record of the inputs its
+// run read
+func (rs *ReplayStore) save(resultHash, taskType string, parameters map[string]interface{}, result *AnalyticsResult, run *replayRun) error {
+	if _, _, _, err := rs.put(result); err != nil {
+		return err
+	}
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+	parametersHash, _, recordedAt, err := rs.put(parameters)
+	if err != nil {
+		return err
+	}
+
+	record := ReplayRecord{
+		ResultHash:     resultHash,
+		Type:           taskType,
+		ParametersHash: parametersHash,
+		Inputs:         run.recorded(),
+		RecordedAt:     NewAPITime(recordedAt),
+	}
+	rs.mu.Lock()
+	rs.records[resultHash] = record
+	rs.mu.Unlock()
+	return nil
+}
+
+// CompactBefore deletes at most limit records and blobs older than cutoff,
+// or older than ReplayInputRetention when cutoff is more recent. It is a
+// RetentionTarget, so stored inputs follow the data retention period.
+func (rs *ReplayStore) CompactBefore(cutoff time.Time, limit int) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if oldest := rs.now().Add(-ReplayInputRetention); cutoff.After(oldest) {
+		cutoff = oldest
+	}
+	deleted := 0
+	for hash, record := range rs.records {
+		if deleted >= limit {
+			return deleted
+		}
+		if record.RecordedAt.Before(cutoff) {
+			delete(rs.records, hash)
+			deleted++
+		}
+	}
+	// A blob is refreshed whenever a run reads it, so it is at least as
+	// recent as every record referencing it
+	for hash, stored := range rs.blobs {
+		if deleted >= limit {
+			return deleted
+		}
+		if stored.storedAt.Before(cutoff) {
+			delete(rs.blobs, hash)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// replayRunKey carries the replayRun of an analytics task in its context
+type replayRunKey struct{}
+
+// replayRun collects the inputs an analytics task reads. When replaying, the
+// inputs are served from pinned blobs instead of being loaded.
+type replayRun struct {
+	store  *ReplayStore
+	pinned map[string][]byte
+
+	mu     sync.Mutex
+	inputs []ReplayInput
+}
+
+// withReplayRecording returns a context recording the inputs read through
+// pinInput into the store
+func withReplayRecording(ctx context.Context, store *ReplayStore) (context.Context, *replayRun) {
+	run := &replayRun{store: store}
+	return context.WithValue(ctx, replayRunKey{}, run), run
+}
+
+// withReplayInputs returns a context serving pinInput from the blobs
+func withReplayInputs(ctx context.Context, pinned map[string][]byte) context.Context {
+	return context.WithValue(ctx, replayRunKey{}, &replayRun{pinned: pinned})
+}
+
+// recorded returns the inputs recorded so far
+func (run *replayRun) recorded() []ReplayInput {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	return append([]ReplayInput{}, run.inputs...)
+}
+
+// pinInput reads an input dataset of an analytics task through load. While
+// recording, the value is stored under key and the task continues with the
+// value decoded from its stored JSON, so the run and its replays see the
+// same value. While replaying, the stored value is returned without calling
+// load. Without either, load is called.
+func pinInput[T any](ctx context.Context, key string, load func() (T, error)) (T, error) {
+	var value T
+	run, _ := ctx.Value(replayRunKey{}).(*replayRun)
+	if run == nil {
+		return load()
+	}
+
+	var blob []byte
+	if run.pinned != nil {
+		var ok bool
+		if blob, ok = run.pinned[key]; !ok {
+			return value, fmt.Errorf("%w: %s", ErrReplayInputMissing, key)
+		}
+	} else {
+		loaded, err := load()
+		if err != nil {
+			return value, err
+		}
+		hash, stored, recordedAt, err := run.store.put(loaded)
+		if err != nil {
+			return value, fmt.Errorf("failed to record input %s: %w", key, err)
+		}
+		run.mu.Lock()
+		run.inputs = append(run.inputs, ReplayInput{Key: key, Hash: hash, RecordedAt: NewAPITime(recordedAt)})
+		run.mu.Unlock()
+		blob = stored
+	}
+
+	if err := json.Unmarshal(blob, &value); err != nil {
+		return value, fmt.Errorf("failed to decode input %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// FieldDifference is a field whose value differs between a result and its
+// replay. A side without the field has no value.
+type FieldDifference struct {
+	Path     string          `json:"path"`
+	Original json.RawMessage `json:"original,omitempty"`
+	Replayed json.RawMessage `json:"replayed,omitempty"`
+}
+
+// ReplayResult is a result computed again from its recorded inputs, compared
+// field by field with the original. Fields describing the run, such as its
+// timestamp, are not compared.
+type ReplayResult struct {
+	ResultHash  string            `json:"result_hash"`
+	Type        string            `json:"type"`
+	Inputs      []ReplayInput     `json:"inputs"`
+	Original    json.RawMessage   `json:"original"`
+	Replayed    json.RawMessage   `json:"replayed"`
+	Matches     bool              `json:"matches"`
+	Differences []FieldDifference `json:"differences"`
+}
+
+// Replay recomputes a stored result with its recorded parameters and inputs
+// and diffs it against the original. Nothing the replay computes is stored.
+func (ae *AnalyticsEngine) Replay(ctx context.Context, resultHash string) (*ReplayResult, error) {
+	record, ok := ae.replay.Record(resultHash)
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrReplayNotFound, resultHash)
+	}
+	original, ok := ae.replay.blob(record.ResultHash)
+	if !ok {
+		return nil, fmt.Errorf("%w: result %s", ErrReplayInputMissing, record.ResultHash)
+	}
+	parametersBlob, ok := ae.replay.blob(record.ParametersHash)
+	if !ok {
+		return nil, fmt.Errorf("%w: parameters %s", ErrReplayInputMissing, record.ParametersHash)
+	}
+	var parameters map[string]interface{}
+	if err := json.Unmarshal(parametersBlob, &parameters); err != nil {
+		return nil, fmt.Errorf("failed to decode parameters: %w", err)
+	}
+	pinned := make(map[string][]byte, len(record.Inputs))
+	for _, input := range record.Inputs {
+		blob, ok := ae.replay.blob(input.Hash)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s (%s)", ErrReplayInputMissing, input.Key, input.Hash)
+		}
+		pinned[input.Key] = blob
+	}
+
+	result, err := ae.runTask(withReplayInputs(ctx, pinned), record.Type, parameters)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := CanonicalJSON(result)
+	if err != nil {
+		return nil, err
+	}
+	differences, err := diffResults(original, replayed)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayResult{
+		ResultHash:  record.ResultHash,
+		Type:        record.Type,
+		Inputs:      record.Inputs,
+		Original:    original,
+		Replayed:    replayed,
+		Matches:     len(differences) == 0,
+		Differences: differences,
+	}, nil
+}
+
+// diffResults lists the fields that differ between two results' JSON,
+// ignoring the fields describing their runs
+func diffResults(original, replayed []byte) ([]FieldDifference, error) {
+	var before, after map[string]interface{}
+	if err := decodeJSONNumbers(original, &before); err != nil {
+		return nil, fmt.Errorf("failed to decode original result: %w", err)
+	}
+	if err := decodeJSONNumbers(replayed, &after); err != nil {
+		return nil, fmt.Errorf("failed to decode replayed result: %w", err)
+	}
+	for field := range replayRunFields {
+		delete(before, field)
+		delete(after, field)
+	}
+
+	differences := []FieldDifference{}
+	diffValues("", before, after, &differences)
+	return differences, nil
+}
+
+// decodeJSONNumbers decodes JSON keeping numbers as written
+func decodeJSONNumbers(blob []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+// diffValues appends the differences between two decoded JSON values at path.
+// Objects are compared key by key and arrays index by index; other values
+// differ as a whole.
+func diffValues(path string, before, after interface{}, differences *[]FieldDifference) {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		if a, ok := after.(map[string]interface{}); ok {
+			keys := make(map[string]bool, len(b)+len(a))
+			for key := range b {
+				keys[key] = true
+			}
+			for key := range a {
+				keys[key] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for key := range keys {
+				sorted = append(sorted, key)
+			}
+			sort.Strings(sorted)
+			for _, key := range sorted {
+				field := key
+				if path != "" {
+					field = path + "." + key
+				}
+				beforeValue, inBefore := b[key]
+				afterValue, inAfter := a[key]
+				switch {
+				case !inBefore:
+					*differences = append(*differences, FieldDifference{Path: field, Replayed: rawJSON(afterValue)})
+				case !inAfter:
+					*differences = append(*differences, FieldDifference{Path: field, Original: rawJSON(beforeValue)})
+				default:
+					diffValues(field, beforeValue, afterValue, differences)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if a, ok := after.([]interface{}); ok && len(a) == len(b) {
+			for i := range b {
+				diffValues(fmt.Sprintf("%s[%d]", path, i), b[i], a[i], differences)
+			}
+			return
+		}
+	}
+
+	beforeJSON, afterJSON := rawJSON(before), rawJSON(after)
+	if !bytes.Equal(beforeJSON, afterJSON) {
+		*differences = append(*differences, FieldDifference{Path: path, Original: beforeJSON, Replayed: afterJSON})
+	}
+}
+
+// rawJSON encodes a decoded JSON value canonically
+func rawJSON(value interface{}) json.RawMessage {
+	blob, err := CanonicalJSON(value)
+	if err != nil {
+		return json.RawMessage(`null`)
+	}
+	return blob
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayPinsYieldAnalysisInputs(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// Uniswap's APY has swung between 2.5% and 22.5% over the last day
+	start := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < 24; i++ {
+		engine.YieldHistory().Record([]YieldOpportunity{{
+			Protocol: "Uniswap V3", AssetPair: "ETH/USDC", APY: 2.5 + 20*float64(i%2), TVL: 1500000,
+		}}, start.Add(time.Duration(i)*time.Hour))
+	}
+	original, err := engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", map[string]interface{}{"history": "7d"})
+	require.NoError(t, err)
+
+	record, ok := engine.ReplayInputs().Record(original.ResultHash)
+	require.True(t, ok)
+	assert.Equal(t, "yield_analysis", record.Type)
+	keys := make([]string, len(record.Inputs))
+	for i, input := range record.Inputs {
+		keys[i] = input.Key
+		assert.NotEmpty(t, input.Hash)
+	}
+	assert.Contains(t, keys, "yield_scan")
+	assert.Contains(t, keys, "yield_trend:Uniswap V3 ETH/USDC")
+	assert.Contains(t, keys, "data_quality")
+
+	// The live data moves on: Uniswap has paid 40% since, with half its TVL
+	for i := 0; i < 48; i++ {
+		engine.YieldHistory().Record([]YieldOpportunity{{
+			Protocol: "Uniswap V3", AssetPair: "ETH/USDC", APY: 40, TVL: 750000,
+		}}, time.Now().Add(-time.Duration(i)*time.Minute))
+	}
+
+	replay, err := engine.Replay(context.Background(), original.ResultHash)
+	require.NoError(t, err)
+	assert.True(t, replay.Matches, "%+v", replay.Differences)
+	assert.Empty(t, replay.Differences)
+	assert.Equal(t, original.ResultHash, replay.ResultHash)
+
+	// A fresh run sees the new data
+	fresh, err := engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", map[string]interface{}{"history": "7d"})
+	require.NoError(t, err)
+	assert.NotEqual(t, original.ResultHash, fresh.ResultHash)
+	originalJSON, err := CanonicalJSON(original)
+	require.NoError(t, err)
+	freshJSON, err := CanonicalJSON(fresh)
+	require.NoError(t, err)
+	differences, err := diffResults(originalJSON, freshJSON)
+	require.NoError(t, err)
+	assert.NotEmpty(t, differences)
+
+	// The fresh run is recorded too, and replays to itself
+	replay, err = engine.Replay(context.Background(), fresh.ResultHash)
+	require.NoError(t, err)
+	assert.True(t, replay.Matches, "%+v", replay.Differences)
+
+	_, err = engine.Replay(context.Background(), "0xunknown")
+	assert.ErrorIs(t, err, ErrReplayNotFound)
+}
+
+func TestReplayDiffsFields(t *testing.T) {
+	original := []byte(`{"data":[{"apy":15,"protocol":"Uniswap V3"}],"confidence":0.9,"timestamp":"2025-03-01T00:00:00Z"}`)
+	replayed := []byte(`{"data":[{"apy":9,"protocol":"Uniswap V3","volatile":true}],"confidence":0.9,"timestamp":"2025-03-02T00:00:00Z"}`)
+
+	differences, err := diffResults(original, replayed)
+	require.NoError(t, err)
+	assert.Equal(t, []FieldDifference{
+		{Path: "data[0].apy", Original: []byte(`15`), Replayed: []byte(`9`)},
+		{Path: "data[0].volatile", Replayed: []byte(`true`)},
+	}, differences)
+}
+
+func TestReplayInputsOutliveShortRetention(t *testing.T) {
+	now := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	store := NewReplayStore()
+	store.now = func() time.Time { return now }
+	ctx, run := withReplayRecording(context.Background(), store)
+	_, err := pinInput(ctx, "clock", func() (int, error) { return 1, nil })
+	require.NoError(t, err)
+	require.NoError(t, store.save("0xresult", "portfolio_optimization", nil, &AnalyticsResult{Type: "portfolio_optimization"}, run))
+	hash := run.recorded()[0].Hash
+
+	// A 7 day data retention leaves inputs alone for 14 days
+	now = now.Add(10 * 24 * time.Hour)
+	assert.Zero(t, store.CompactBefore(now.Add(-7*24*time.Hour), RetentionBatchSize))
+	_, ok := store.blob(hash)
+	assert.True(t, ok)
+
+	now = now.Add(5 * 24 * time.Hour)
+	assert.Equal(t, 4, store.CompactBefore(now.Add(-7*24*time.Hour), RetentionBatchSize))
+	_, ok = store.blob(hash)
+	assert.False(t, ok)
+	_, ok = store.Record("0xresult")
+	assert.False(t, ok)
+}
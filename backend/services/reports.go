@@ -0,0 +1,633 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	maxReportsPerUser  = 90
+	digestTopMovers    = 5
+	reportEventType    = "report.generated"
+	reportNotification = "report.daily"
+)
+
+var reportWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ReportSettings is a user's digest schedule and content preferences
+type ReportSettings struct {
+	Enabled bool `json:"enabled"`
+	// Time is the daily delivery time as HH:MM in UTC
+	Time string `json:"time"`
+	// Days restricts delivery to the given weekdays (mon..sun); empty means every day
+	Days []string `json:"days,omitempty"`
+	// Tokens are the symbols to report movers for; empty means the user's holdings
+	Tokens    []string  `json:"tokens,omitempty"`
+	Webhook   bool      `json:"webhook"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the schedule and normalizes days and tokens
+func (rs *ReportSettings) Validate() error {
+	if _, _, err := rs.clock(); err != nil {
+		return err
+	}
+	for i, day := range rs.Days {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if len(day) > 3 {
+			day = day[:3]
+		}
+		if _, ok := reportWeekdays[day]; !ok {
+			return fmt.Errorf("invalid day %q", rs.Days[i])
+		}
+		rs.Days[i] = day
+	}
+	for i, token := range rs.Tokens {
+		rs.Tokens[i] = strings.ToUpper(strings.TrimSpace(token))
+	}
+	return nil
+}
+
+func (rs *ReportSettings) clock() (int, int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(rs.Time, "%d:%d", &hour, &minute); err != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("time must be HH:MM in UTC, got %q", rs.Time)
+	}
+	return hour, minute, nil
+}
+
+// slot returns the scheduled delivery time on the given day, if any
+func (rs *ReportSettings) slot(day time.Time) (time.Time, bool) {
+	hour, minute, err := rs.clock()
+	if err != nil {
+		return time.Time{}, false
+	}
+	day = day.UTC()
+	if len(rs.Days) > 0 {
+		allowed := false
+		for _, name := range rs.Days {
+			if reportWeekdays[name] == day.Weekday() {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return time.Time{}, false
+		}
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, time.UTC), true
+}
+
+// DigestSources provides the data a digest is composed from
+type DigestSources interface {
+	AddressSummary(ctx context.Context, address common.Address) (*AddressSummary, error)
+	MarketData(ctx context.Context, symbols []string) ([]MarketData, error)
+	GasPrice(ctx context.Context) (*big.Int, error)
+	ProposalsSince(since time.Time) []GovernanceProposal
+}
+
+// PortfolioDigest is the portfolio section of a digest
+type PortfolioDigest struct {
+	ValueUSD         float64 `json:"value_usd"`
+	PreviousValueUSD float64 `json:"previous_value_usd"`
+	ChangeUSD        float64 `json:"change_usd"`
+	ChangePercent    float64 `json:"change_percent"`
+	Partial          bool    `json:"partial"`
+}
+
+// TokenMover is a token price movement over the last 24 hours
+type TokenMover struct {
+	Symbol    string  `json:"symbol"`
+	PriceUSD  float64 `json:"price_usd"`
+	Change24h float64 `json:"change_24h"`
+}
+
+// GasTrend compares the current gas price with the previous digest
+type GasTrend struct {
+	CurrentGwei   float64 `json:"current_gwei"`
+	PreviousGwei  float64 `json:"previous_gwei"`
+	ChangePercent float64 `json:"change_percent"`
+}
+
+// Digest is the content of a daily report
+type Digest struct {
+	UserID      string               `json:"user_id"`
+	PeriodStart time.Time            `json:"period_start"`
+	PeriodEnd   time.Time            `json:"period_end"`
+	Portfolio   *PortfolioDigest     `json:"portfolio,omitempty"`
+	TopMovers   []TokenMover         `json:"top_movers"`
+	Gas         *GasTrend            `json:"gas,omitempty"`
+	Proposals   []GovernanceProposal `json:"proposals"`
+	// Unavailable lists sections that could not be built
+	Unavailable []string `json:"unavailable,omitempty"`
+}
+
+// Report is a generated digest with its rendered bodies
+type Report struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Digest      Digest    `json:"digest"`
+	Markdown    string    `json:"markdown"`
+	HTML        string    `json:"html"`
+}
+
+// ReportService schedules, generates, stores, and delivers daily digests
+type ReportService struct {
+	sources       DigestSources
+	notifications *NotificationStore
+	webhooks      *WebhookDispatcher
+	pool          *AdaptivePool
+	logger        *log.Logger
+
+	mu       sync.RWMutex
+	settings map[string]ReportSettings
+	reports  map[string][]*Report
+	nextID   uint64
+	now      func() time.Time
+}
+
+// NewReportService creates a report service generating at most maxConcurrency
+// digests at once; its pool shrinks to one worker when idle. The webhook
+// dispatcher is optional.
+func NewReportService(sources DigestSources, notifications *NotificationStore, webhooks *WebhookDispatcher, maxConcurrency int) (*ReportService, error) {
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	pool, err := NewAdaptivePool("reports", PoolBounds{Min: 1, Max: maxConcurrency})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReportService{
+		sources:       sources,
+		notifications: notifications,
+		webhooks:      webhooks,
+		pool:          pool,
+		logger:        log.New(log.Writer(), "[ReportService] ", log.LstdFlags),
+		settings:      make(map[string]ReportSettings),
+		reports:       make(map[string][]*Report),
+		now:           utcNow,
+	}, nil
+}
+
+// Pool returns the worker pool digests are generated on
+func (rs *ReportService) Pool() *AdaptivePool {
+	return rs.pool
+}
+
+// Close releases the worker pool
+func (rs *ReportService) Close() {
+	rs.pool.Release()
+}
+
+// UpdateSettings validates and stores a user's report settings
+func (rs *ReportService) UpdateSettings(userID string, settings ReportSettings) (ReportSettings, error) {
+	if err := settings.Validate(); err != nil {
+		return ReportSettings{}, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	settings.UpdatedAt = rs.now().UTC()
+	rs.settings[strings.ToLower(userID)] = settings
+	return settings, nil
+}
+
+// Settings returns a user's report settings
+func (rs *ReportService) Settings(userID string) (ReportSettings, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	settings, ok := rs.settings[strings.ToLower(userID)]
+	return settings, ok
+}
+
+// Latest returns a user's most recent report
+func (rs *ReportService) Latest(userID string) (*Report, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	reports := rs.reports[strings.ToLower(userID)]
+	if len(reports) == 0 {
+		return nil, false
+	}
+	report := *reports[len(reports)-1]
+	return &report, true
+}
+
+// History returns a page of a user's reports newest first and the total count
+func (rs *ReportService) History(userID string, limit, offset int) ([]Report, int) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	reports := rs.reports[strings.ToLower(userID)]
+	total := len(reports)
+	page := make([]Report, 0, limit)
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, *reports[i])
+	}
+	return page, total
+}
+
+// reportUserData is what the report service keeps about a user
+type reportUserData struct {
+	Settings *ReportSettings `json:"settings"`
+	Reports  []Report        `json:"reports"`
+}
+
+// UserData returns the user's report settings and reports, oldest first
+func (rs *ReportService) UserData(userID string) interface{} {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	userID = strings.ToLower(userID)
+	data := reportUserData{Reports: make([]Report, 0, len(rs.reports[userID]))}
+	if settings, ok := rs.settings[userID]; ok {
+		data.Settings = &settings
+	}
+	for _, report := range rs.reports[userID] {
+		data.Reports = append(data.Reports, *report)
+	}
+	return data
+}
+
+// EraseUserData deletes the user's report settings and reports and returns
+// how many records were removed
+func (rs *ReportService) EraseUserData(userID string) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	userID = strings.ToLower(userID)
+	removed := len(rs.reports[userID])
+	if _, ok := rs.settings[userID]; ok {
+		removed++
+	}
+	delete(rs.settings, userID)
+	delete(rs.reports, userID)
+	return removed
+}
+
+// Start runs due reports every minute until ctx is cancelled
+func (rs *ReportService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				rs.RunDue(ctx)
+			}
+		}
+	}()
+}
+
+// RunDue generates reports for every user whose slot has passed since their
+// last report, through the worker pool. Returns the number generated.
+func (rs *ReportService) RunDue(ctx context.Context) int {
+	due := rs.dueUsers(rs.now())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	generated := 0
+	for _, userID := range due {
+		userID := userID
+		wg.Add(1)
+		err := rs.pool.Submit(func() {
+			defer wg.Done()
+			if _, err := rs.Generate(ctx, userID); err != nil {
+				rs.logger.Printf("Failed to generate report for %s: %v", userID, err)
+				return
+			}
+			mu.Lock()
+			generated++
+			mu.Unlock()
+		})
+		if err != nil {
+			wg.Done()
+			rs.logger.Printf("Failed to submit report for %s: %v", userID, err)
+		}
+	}
+	wg.Wait()
+
+	return generated
+}
+
+func (rs *ReportService) dueUsers(now time.Time) []string {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	var due []string
+	for userID, settings := range rs.settings {
+		if !settings.Enabled {
+			continue
+		}
+		slot, ok := settings.slot(now)
+		if !ok || now.Before(slot) {
+			continue
+		}
+		reports := rs.reports[userID]
+		if len(reports) > 0 && !reports[len(reports)-1].GeneratedAt.Before(slot) {
+			continue
+		}
+		due = append(due, userID)
+	}
+	sort.Strings(due)
+	return due
+}
+
+// Generate composes, renders, stores, and delivers a report for the user now
+func (rs *ReportService) Generate(ctx context.Context, userID string) (*Report, error) {
+	userID = strings.ToLower(userID)
+	settings, _ := rs.Settings(userID)
+	report, err := rs.build(ctx, userID, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.mu.Lock()
+	rs.nextID++
+	report.ID = fmt.Sprintf("rpt_%d", rs.nextID)
+	reports := append(rs.reports[userID], report)
+	if len(reports) > maxReportsPerUser {
+		reports = reports[len(reports)-maxReportsPerUser:]
+	}
+	rs.reports[userID] = reports
+	rs.mu.Unlock()
+
+	rs.deliver(report, settings)
+
+	result := *report
+	return &result, nil
+}
+
+// Preview composes and renders the report the user would get now, without
+// storing or delivering it. Candidate settings are used in place of the
+// stored ones when given. The preview has no ID.
+func (rs *ReportService) Preview(ctx context.Context, userID string, candidate *ReportSettings) (*Report, error) {
+	userID = strings.ToLower(userID)
+	settings, _ := rs.Settings(userID)
+	if candidate != nil {
+		if err := candidate.Validate(); err != nil {
+			return nil, err
+		}
+		settings = *candidate
+	}
+	return rs.build(ctx, userID, settings)
+}
+
+// build composes and renders a report for the user now, covering the time
+// since their latest report or the last 24 hours
+func (rs *ReportService) build(ctx context.Context, userID string, settings ReportSettings) (*Report, error) {
+	previous, hasPrevious := rs.Latest(userID)
+
+	now := rs.now().UTC()
+	periodStart := now.Add(-24 * time.Hour)
+	var previousDigest *Digest
+	if hasPrevious {
+		periodStart = previous.GeneratedAt
+		previousDigest = &previous.Digest
+	}
+
+	digest := rs.compose(ctx, userID, settings, previousDigest, periodStart, now)
+	markdown, html, err := RenderDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{
+		UserID:      userID,
+		GeneratedAt: now,
+		Digest:      digest,
+		Markdown:    markdown,
+		HTML:        html,
+	}, nil
+}
+
+func (rs *ReportService) deliver(report *Report, settings ReportSettings) {
+	if rs.notifications != nil {
+		rs.notifications.Add(Notification{
+			UserID:    report.UserID,
+			Type:      reportNotification,
+			Title:     "Your daily digest",
+			Body:      report.Markdown,
+			Data:      map[string]string{"report_id": report.ID},
+			CreatedAt: report.GeneratedAt,
+		})
+	}
+
+	if settings.Webhook && rs.webhooks != nil {
+		err := rs.webhooks.Dispatch(WebhookEvent{
+			Type:    reportEventType,
+			Owner:   report.UserID,
+			Payload: report,
+		})
+		if err != nil {
+			rs.logger.Printf("Failed to dispatch report webhook for %s: %v", report.UserID, err)
+		}
+	}
+}
+
+// compose builds a digest, recording sections whose sources fail as unavailable
+func (rs *ReportService) compose(ctx context.Context, userID string, settings ReportSettings, previous *Digest, periodStart, periodEnd time.Time) Digest {
+	digest := Digest{
+		UserID:      userID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		TopMovers:   []TokenMover{},
+		Proposals:   []GovernanceProposal{},
+	}
+
+	symbols := settings.Tokens
+	if common.IsHexAddress(userID) {
+		summary, err := rs.sources.AddressSummary(ctx, common.HexToAddress(userID))
+		if err != nil {
+			digest.Unavailable = append(digest.Unavailable, "portfolio")
+		} else {
+			portfolio := &PortfolioDigest{ValueUSD: summary.TotalValueUSD, Partial: summary.Partial}
+			if previous != nil && previous.Portfolio != nil {
+				portfolio.PreviousValueUSD = previous.Portfolio.ValueUSD
+				portfolio.ChangeUSD = portfolio.ValueUSD - portfolio.PreviousValueUSD
+				portfolio.ChangePercent = percentChange(portfolio.PreviousValueUSD, portfolio.ValueUSD)
+			}
+			digest.Portfolio = portfolio
+
+			if len(symbols) == 0 {
+				symbols = []string{NativeSymbol}
+				for _, token := range summary.TopTokens {
+					symbols = append(symbols, token.Symbol)
+				}
+			}
+		}
+	}
+	if len(symbols) == 0 {
+		symbols = []string{NativeSymbol}
+	}
+
+	markets, err := rs.sources.MarketData(ctx, symbols)
+	if err != nil {
+		digest.Unavailable = append(digest.Unavailable, "top_movers")
+	} else {
+		for _, market := range markets {
+			digest.TopMovers = append(digest.TopMovers, TokenMover{
+				Symbol:    market.Symbol,
+				PriceUSD:  market.Price,
+				Change24h: market.Change24h,
+			})
+		}
+		sort.SliceStable(digest.TopMovers, func(i, j int) bool {
+			return math.Abs(digest.TopMovers[i].Change24h) > math.Abs(digest.TopMovers[j].Change24h)
+		})
+		if len(digest.TopMovers) > digestTopMovers {
+			digest.TopMovers = digest.TopMovers[:digestTopMovers]
+		}
+	}
+
+	gasPrice, err := rs.sources.GasPrice(ctx)
+	if err != nil {
+		digest.Unavailable = append(digest.Unavailable, "gas")
+	} else {
+		gas := &GasTrend{CurrentGwei: weiToFloat(gasPrice, 9)}
+		if previous != nil && previous.Gas != nil {
+			gas.PreviousGwei = previous.Gas.CurrentGwei
+			gas.ChangePercent = percentChange(gas.PreviousGwei, gas.CurrentGwei)
+		}
+		digest.Gas = gas
+	}
+
+	digest.Proposals = append(digest.Proposals, rs.sources.ProposalsSince(periodStart)...)
+
+	return digest
+}
+
+func percentChange(from, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return (to - from) / from * 100
+}
+
+var digestTemplateFuncs = map[string]interface{}{
+	"date": func(t time.Time) string { return t.UTC().Format("2006-01-02") },
+	"datetime": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+	"signed": func(v float64) string { return fmt.Sprintf("%+.2f", v) },
+	"money":  func(v float64) string { return fmt.Sprintf("$%.2f", v) },
+	"gwei":   func(v float64) string { return fmt.Sprintf("%.2f gwei", v) },
+}
+
+var digestMarkdownTemplate = template.Must(template.New("digest.md").Funcs(digestTemplateFuncs).Parse(
+	`# Daily Digest — {{date .PeriodEnd}}
+
+_{{datetime .PeriodStart}} to {{datetime .PeriodEnd}}_
+
+## Portfolio
+{{if .Portfolio}}Value: {{money .Portfolio.ValueUSD}}{{if .Portfolio.PreviousValueUSD}} ({{signed .Portfolio.ChangeUSD}} USD, {{signed .Portfolio.ChangePercent}}%){{end}}
+{{if .Portfolio.Partial}}
+Some holdings are still being indexed, so this value may be incomplete.
+{{end}}{{else}}Portfolio data is unavailable.
+{{end}}
+## Top Movers
+{{range .TopMovers}}- **{{.Symbol}}** {{money .PriceUSD}} ({{signed .Change24h}}%)
+{{else}}No price movements to report.
+{{end}}
+## Gas
+{{if .Gas}}Current: {{gwei .Gas.CurrentGwei}}{{if .Gas.PreviousGwei}} ({{signed .Gas.ChangePercent}}% since last digest){{end}}
+{{else}}Gas data is unavailable.
+{{end}}
+## New Governance Proposals
+{{range .Proposals}}- **{{.Title}}** ({{.ID}}), voting ends {{date .EndTime}}
+{{else}}No new proposals.
+{{end}}`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(digestTemplateFuncs).Parse(
+	`<h1>Daily Digest — {{date .PeriodEnd}}</h1>
+<p><em>{{datetime .PeriodStart}} to {{datetime .PeriodEnd}}</em></p>
+<h2>Portfolio</h2>
+{{if .Portfolio}}<p>Value: {{money .Portfolio.ValueUSD}}{{if .Portfolio.PreviousValueUSD}} ({{signed .Portfolio.ChangeUSD}} USD, {{signed .Portfolio.ChangePercent}}%){{end}}</p>
+{{if .Portfolio.Partial}}<p>Some holdings are still being indexed, so this value may be incomplete.</p>
+{{end}}{{else}}<p>Portfolio data is unavailable.</p>
+{{end}}<h2>Top Movers</h2>
+{{if .TopMovers}}<ul>
+{{range .TopMovers}}<li><strong>{{.Symbol}}</strong> {{money .PriceUSD}} ({{signed .Change24h}}%)</li>
+{{end}}</ul>
+{{else}}<p>No price movements to report.</p>
+{{end}}<h2>Gas</h2>
+{{if .Gas}}<p>Current: {{gwei .Gas.CurrentGwei}}{{if .Gas.PreviousGwei}} ({{signed .Gas.ChangePercent}}% since last digest){{end}}</p>
+{{else}}<p>Gas data is unavailable.</p>
+{{end}}<h2>New Governance Proposals</h2>
+{{if .Proposals}}<ul>
+{{range .Proposals}}<li><strong>{{.Title}}</strong> ({{.ID}}), voting ends {{date .EndTime}}</li>
+{{end}}</ul>
+{{else}}<p>No new proposals.</p>
+{{end}}`))
+
+// RenderDigest renders a digest as Markdown and HTML
+func RenderDigest(digest Digest) (string, string, error) {
+	var markdown, html bytes.Buffer
+	if err := digestMarkdownTemplate.Execute(&markdown, digest); err != nil {
+		return "", "", fmt.Errorf("failed to render digest markdown: %w", err)
+	}
+	if err := digestHTMLTemplate.Execute(&html, digest); err != nil {
+		return "", "", fmt.Errorf("failed to render digest html: %w", err)
+	}
+	return markdown.String(), html.String(), nil
+}
+
+// CollectorDigestSources composes digests from the running services
+type CollectorDigestSources struct {
+	summaries  *AddressSummarizer
+	collector  *DataCollector
+	chain      ChainClient
+	governance *GovernanceTracker
+}
+
+// NewCollectorDigestSources wires digest sources to the running services
+func NewCollectorDigestSources(summaries *AddressSummarizer, collector *DataCollector, chain ChainClient, governance *GovernanceTracker) *CollectorDigestSources {
+	return &CollectorDigestSources{
+		summaries:  summaries,
+		collector:  collector,
+		chain:      chain,
+		governance: governance,
+	}
+}
+
+// AddressSummary returns the address's current holdings
+func (s *CollectorDigestSources) AddressSummary(ctx context.Context, address common.Address) (*AddressSummary, error) {
+	return s.summaries.Summarize(ctx, address)
+}
+
+// MarketData returns current market data for the symbols
+func (s *CollectorDigestSources) MarketData(ctx context.Context, symbols []string) ([]MarketData, error) {
+	results := s.collector.CollectMarketData(ctx, symbols)
+	return results.Data(), results.Err()
+}
+
+// GasPrice returns the node's suggested gas price
+func (s *CollectorDigestSources) GasPrice(ctx context.Context) (*big.Int, error) {
+	return s.chain.SuggestGasPrice(ctx)
+}
+
+// ProposalsSince returns proposals that opened since the given time
+func (s *CollectorDigestSources) ProposalsSince(since time.Time) []GovernanceProposal {
+	return s.governance.ProposalsSince(since)
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files")
+
+const reportUser = "0x00000000000000000000000000000000000000aa"
+
+// frozenDigestSources serves a fixed dataset so rendered digests are stable
+type frozenDigestSources struct {
+	valueUSD  float64
+	gasWei    int64
+	marketErr error
+	calls     atomic.Int64
+	inFlight  atomic.Int64
+	maxFlight atomic.Int64
+	delay     time.Duration
+}
+
+func (f *frozenDigestSources) AddressSummary(ctx context.Context, address common.Address) (*AddressSummary, error) {
+	current := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		seen := f.maxFlight.Load()
+		if current <= seen || f.maxFlight.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+	time.Sleep(f.delay)
+	f.calls.Add(1)
+
+	return &AddressSummary{
+		Address:       address.Hex(),
+		TotalValueUSD: f.valueUSD,
+		TopTokens:     []TokenHolding{{Symbol: "USDT"}, {Symbol: "WETH"}},
+	}, nil
+}
+
+func (f *frozenDigestSources) MarketData(ctx context.Context, symbols []string) ([]MarketData, error) {
+	if f.marketErr != nil {
+		return nil, f.marketErr
+	}
+	changes := map[string]float64{"KAIA": 4.25, "USDT": 0.01, "WETH": -6.5}
+	prices := map[string]float64{"KAIA": 0.18, "USDT": 1, "WETH": 3150}
+	markets := make([]MarketData, 0, len(symbols))
+	for _, symbol := range symbols {
+		markets = append(markets, MarketData{Symbol: symbol, Price: prices[symbol], Change24h: changes[symbol]})
+	}
+	return markets, nil
+}
+
+func (f *frozenDigestSources) GasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(f.gasWei), nil
+}
+
+func (f *frozenDigestSources) ProposalsSince(since time.Time) []GovernanceProposal {
+	proposal := GovernanceProposal{
+		ID:        "KIP-42",
+		Title:     "Raise <gas> limit & fees",
+		StartTime: time.Date(2025, 3, 1, 20, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2025, 3, 8, 20, 0, 0, 0, time.UTC),
+	}
+	if proposal.StartTime.Before(since) {
+		return nil
+	}
+	return []GovernanceProposal{proposal}
+}
+
+func newTestReportService(t *testing.T, sources DigestSources, clock *time.Time, concurrency int) (*ReportService, *NotificationStore) {
+	notifications := NewNotificationStore()
+	service, err := NewReportService(sources, notifications, nil, concurrency)
+	require.NoError(t, err)
+	t.Cleanup(service.Close)
+	service.now = func() time.Time { return *clock }
+	return service, notifications
+}
+
+func assertGolden(t *testing.T, name, actual string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(path, []byte(actual), 0o644))
+	}
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), actual)
+}
+
+func TestReportDigestSnapshot(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	sources := &frozenDigestSources{valueUSD: 1000, gasWei: 25_000_000_000}
+	service, notifications := newTestReportService(t, sources, &clock, 2)
+
+	_, err := service.UpdateSettings(reportUser, ReportSettings{Enabled: true, Time: "08:00"})
+	require.NoError(t, err)
+
+	_, err = service.Generate(context.Background(), reportUser)
+	require.NoError(t, err)
+
+	// The second digest reports changes against the first
+	clock = clock.Add(24 * time.Hour)
+	sources.valueUSD = 1125.5
+	sources.gasWei = 20_000_000_000
+	report, err := service.Generate(context.Background(), reportUser)
+	require.NoError(t, err)
+
+	require.NotNil(t, report.Digest.Portfolio)
+	assert.InDelta(t, 12.55, report.Digest.Portfolio.ChangePercent, 1e-9)
+	assert.InDelta(t, -20.0, report.Digest.Gas.ChangePercent, 1e-9)
+	require.Len(t, report.Digest.TopMovers, 3)
+	assert.Equal(t, "WETH", report.Digest.TopMovers[0].Symbol)
+
+	digestJSON, err := json.MarshalIndent(report.Digest, "", "  ")
+	require.NoError(t, err)
+	assertGolden(t, "report_digest.golden.json", string(digestJSON)+"\n")
+	assertGolden(t, "report_digest.golden.md", report.Markdown)
+	assertGolden(t, "report_digest.golden.html", report.HTML)
+
+	inbox := notifications.List(reportUser, true)
+	require.Len(t, inbox, 2)
+	assert.Equal(t, report.Markdown, inbox[0].Body)
+}
+
+func TestReportDigestMarksUnavailableSections(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	sources := &frozenDigestSources{valueUSD: 10, gasWei: 1, marketErr: errors.New("upstream down")}
+	service, _ := newTestReportService(t, sources, &clock, 1)
+
+	report, err := service.Generate(context.Background(), reportUser)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"top_movers"}, report.Digest.Unavailable)
+	assert.Contains(t, report.Markdown, "No price movements to report.")
+}
+
+func TestReportSchedulingAndConcurrencyCap(t *testing.T) {
+	clock := time.Date(2025, 3, 3, 7, 59, 0, 0, time.UTC) // a Monday
+	sources := &frozenDigestSources{valueUSD: 1, gasWei: 1, delay: 2 * time.Millisecond}
+	service, _ := newTestReportService(t, sources, &clock, 4)
+
+	const users = 200
+	for i := 0; i < users; i++ {
+		_, err := service.UpdateSettings(fmt.Sprintf("0x%040x", i+1), ReportSettings{Enabled: true, Time: "08:00"})
+		require.NoError(t, err)
+	}
+	_, err := service.UpdateSettings("0x00000000000000000000000000000000000fffff", ReportSettings{Enabled: true, Time: "08:00", Days: []string{"Tuesday"}})
+	require.NoError(t, err)
+	_, err = service.UpdateSettings("0x00000000000000000000000000000000000eeeee", ReportSettings{Enabled: false, Time: "08:00"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, service.RunDue(context.Background()), "nothing is due before the slot")
+
+	clock = clock.Add(time.Minute)
+	assert.Equal(t, users, service.RunDue(context.Background()))
+	assert.LessOrEqual(t, sources.maxFlight.Load(), int64(4))
+
+	// Already delivered today
+	clock = clock.Add(time.Hour)
+	assert.Equal(t, 0, service.RunDue(context.Background()))
+
+	// Tuesday adds the weekday-restricted user
+	clock = clock.Add(23 * time.Hour)
+	assert.Equal(t, users+1, service.RunDue(context.Background()))
+
+	page, total := service.History(fmt.Sprintf("0x%040x", 1), 1, 1)
+	assert.Equal(t, 2, total)
+	require.Len(t, page, 1)
+	assert.Equal(t, time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC), page[0].GeneratedAt)
+}
+
+func TestReportSettingsValidation(t *testing.T) {
+	settings := ReportSettings{Time: "07:30", Days: []string{"Monday", "fri"}, Tokens: []string{"kaia "}}
+	require.NoError(t, settings.Validate())
+	assert.Equal(t, []string{"mon", "fri"}, settings.Days)
+	assert.Equal(t, []string{"KAIA"}, settings.Tokens)
+
+	for _, invalid := range []ReportSettings{
+		{Time: "24:00"},
+		{Time: "noon"},
+		{Time: "08:00", Days: []string{"someday"}},
+	} {
+		assert.Error(t, invalid.Validate(), invalid.Time)
+	}
+}
+
+func TestReportPreviewIsNotStored(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	sources := &frozenDigestSources{valueUSD: 1000, gasWei: 25_000_000_000}
+	service, notifications := newTestReportService(t, sources, &clock, 1)
+	_, err := service.UpdateSettings(reportUser, ReportSettings{Enabled: true, Time: "08:00", Tokens: []string{"KAIA"}})
+	require.NoError(t, err)
+
+	preview, err := service.Preview(context.Background(), reportUser, nil)
+	require.NoError(t, err)
+	assert.Empty(t, preview.ID)
+	_, found := service.Latest(reportUser)
+	assert.False(t, found)
+	assert.Empty(t, notifications.List(reportUser, false))
+
+	// The preview renders what generating the report produces
+	report, err := service.Generate(context.Background(), reportUser)
+	require.NoError(t, err)
+	assert.Equal(t, report.Digest, preview.Digest)
+	assert.Equal(t, report.Markdown, preview.Markdown)
+	assert.Equal(t, report.HTML, preview.HTML)
+
+	// Candidate settings replace the stored ones for the preview only
+	preview, err = service.Preview(context.Background(), reportUser, &ReportSettings{Time: "09:00", Tokens: []string{"weth"}})
+	require.NoError(t, err)
+	require.Len(t, preview.Digest.TopMovers, 1)
+	assert.Equal(t, "WETH", preview.Digest.TopMovers[0].Symbol)
+	settings, _ := service.Settings(reportUser)
+	assert.Equal(t, []string{"KAIA"}, settings.Tokens)
+	_, total := service.History(reportUser, 10, 0)
+	assert.Equal(t, 1, total)
+
+	_, err = service.Preview(context.Background(), reportUser, &ReportSettings{Time: "25:00"})
+	assert.Error(t, err)
+}
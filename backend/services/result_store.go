@@ -0,0 +1,220 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DefaultResultStoreSize is how many analytics results are kept for lookup
+// by hash before the oldest are dropped
+const DefaultResultStoreSize = 10000
+
+// CanonicalJSON encodes a value as JSON that is the same for equal values:
+// object keys are sorted, there is no insignificant whitespace, HTML
+// characters are left unescaped, and numbers are written in their shortest
+// form, so 1.0 and 1e0 both become 1
+func CanonicalJSON(value interface{}) ([]byte, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to decode value: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCanonical writes a decoded JSON value in canonical form
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		number, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case string:
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return fmt.Errorf("failed to encode string: %w", err)
+		}
+		// Encode ends every value with a newline
+		buf.Truncate(buf.Len() - 1)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value of type %T", value)
+	}
+	return nil
+}
+
+// canonicalNumber writes integers in full and other numbers as the shortest
+// decimal that parses back to the same float64
+func canonicalNumber(number json.Number) (string, error) {
+	text := number.String()
+	if !strings.ContainsAny(text, ".eE") {
+		integer, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return "", fmt.Errorf("invalid number %q", text)
+		}
+		return integer.String(), nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid number %q: %w", text, err)
+	}
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64), nil
+}
+
+// HashResult returns the keccak256 hash of a value's canonical JSON, hex
+// encoded, along with the JSON it covers
+func HashResult(value interface{}) (string, []byte, error) {
+	blob, err := CanonicalJSON(value)
+	if err != nil {
+		return "", nil, err
+	}
+	return hexutil.Encode(crypto.Keccak256(blob)), blob, nil
+}
+
+// StoredResult is an analytics result looked up by its hash
+type StoredResult struct {
+	Hash     string          `json:"hash"`
+	Result   json.RawMessage `json:"result"`
+	StoredAt APITime         `json:"stored_at"`
+	// Verified reports whether the stored result still hashes to Hash
+	Verified bool `json:"verified"`
+}
+
+// storedBlob is a result's canonical JSON as kept in the store
+type storedBlob struct {
+	blob     []byte
+	storedAt time.Time
+}
+
+// ResultStore keeps analytics results keyed by the hash of their canonical
+// JSON, so a hash recorded elsewhere, such as on chain, can be checked
+// against the result it names. The oldest results are dropped past the
+// store's size.
+type ResultStore struct {
+	mu      sync.RWMutex
+	size    int
+	results map[string]storedBlob
+	order   []string
+	now     func() time.Time
+}
+
+// NewResultStore creates a store keeping up to size results. A size of zero
+// or less uses DefaultResultStoreSize.
+func NewResultStore(size int) *ResultStore {
+	if size <= 0 {
+		size = DefaultResultStoreSize
+	}
+	return &ResultStore{
+		size:    size,
+		results: make(map[string]storedBlob),
+		now:     utcNow,
+	}
+}
+
+// Put hashes and stores a result, returning its hash
+func (s *ResultStore) Put(result interface{}) (string, error) {
+	hash, blob, err := HashResult(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash result: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.results[hash]; ok {
+		return hash, nil
+	}
+	s.results[hash] = storedBlob{blob: blob, storedAt: s.now()}
+	s.order = append(s.order, hash)
+	for len(s.order) > s.size {
+		delete(s.results, s.order[0])
+		s.order = s.order[1:]
+	}
+	return hash, nil
+}
+
+// Get returns a stored result, rehashing it to report whether it still
+// matches its hash. Hashes match in any case.
+func (s *ResultStore) Get(hash string) (StoredResult, bool) {
+	hash = strings.ToLower(hash)
+
+	s.mu.RLock()
+	stored, ok := s.results[hash]
+	s.mu.RUnlock()
+	if !ok {
+		return StoredResult{}, false
+	}
+
+	result := json.RawMessage(stored.blob)
+	if !json.Valid(result) {
+		// A corrupted blob is returned as a string so it can still be inspected
+		result, _ = json.Marshal(string(stored.blob))
+	}
+	return StoredResult{
+		Hash:     hash,
+		Result:   result,
+		StoredAt: NewAPITime(stored.storedAt),
+		Verified: hexutil.Encode(crypto.Keccak256(stored.blob)) == hash,
+	}, true
+}
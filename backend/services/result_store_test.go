@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalJSONIsDeterministic(t *testing.T) {
+	type point struct {
+		Zeta  float64 `json:"zeta"`
+		Alpha string  `json:"alpha"`
+	}
+	canonical, err := CanonicalJSON(map[string]interface{}{
+		"b":      []interface{}{1.0, 2.5, json.Number("1e2")},
+		"a":      point{Zeta: 0.1, Alpha: "<&>"},
+		"big":    json.Number("123456789012345678901234567890"),
+		"absent": nil,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":{"alpha":"<&>","zeta":0.1},"absent":null,"b":[1,2.5,100],"big":123456789012345678901234567890}`, string(canonical))
+
+	// Equal values hash the same whatever their Go types
+	first, _, err := HashResult(map[string]interface{}{"x": 1, "y": []string{"a"}})
+	require.NoError(t, err)
+	second, _, err := HashResult(struct {
+		Y []interface{} `json:"y"`
+		X float64       `json:"x"`
+	}{Y: []interface{}{"a"}, X: 1.0})
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Len(t, first, 66)
+}
+
+func TestAnalyticsResultHashRoundTrip(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "risk_assessment", map[string]interface{}{})
+	require.NoError(t, err)
+	require.NotEmpty(t, result.ResultHash)
+
+	stored, ok := engine.Results().Get(result.ResultHash)
+	require.True(t, ok)
+	assert.True(t, stored.Verified)
+	assert.Equal(t, result.ResultHash, stored.Hash)
+
+	// The stored blob is the canonical form of the result as computed
+	computed := *result
+	computed.ResultHash = ""
+	hash, blob, err := HashResult(&computed)
+	require.NoError(t, err)
+	assert.Equal(t, result.ResultHash, hash)
+	assert.JSONEq(t, string(blob), string(stored.Result))
+
+	// Tampering with the stored blob flips the verification
+	store := engine.Results()
+	store.mu.Lock()
+	tampered := store.results[result.ResultHash]
+	tampered.blob = []byte(`{"type":"risk_assessment","data":{"risk":"none"}}`)
+	store.results[result.ResultHash] = tampered
+	store.mu.Unlock()
+
+	stored, ok = engine.Results().Get(result.ResultHash)
+	require.True(t, ok)
+	assert.False(t, stored.Verified)
+	assert.JSONEq(t, `{"type":"risk_assessment","data":{"risk":"none"}}`, string(stored.Result))
+
+	_, ok = engine.Results().Get("0xmissing")
+	assert.False(t, ok)
+}
+
+func TestResultStoreDropsOldestPastSize(t *testing.T) {
+	store := NewResultStore(2)
+	first, err := store.Put(map[string]int{"n": 1})
+	require.NoError(t, err)
+	_, err = store.Put(map[string]int{"n": 2})
+	require.NoError(t, err)
+	third, err := store.Put(map[string]int{"n": 3})
+	require.NoError(t, err)
+
+	_, ok := store.Get(first)
+	assert.False(t, ok)
+	_, ok = store.Get(third)
+	assert.True(t, ok)
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDataRetentionDays is how many days of raw samples are kept
+	// before they are downsampled
+	DefaultDataRetentionDays = 30
+	// RetentionBatchSize caps the raw samples compacted per store call, so
+	// readers aren't locked out for the length of a whole run
+	RetentionBatchSize = 5000
+)
+
+// RetentionTarget is a time-series store whose old raw samples the retention
+// engine folds into aggregates
+type RetentionTarget interface {
+	// CompactBefore folds at most limit raw samples older than cutoff into the
+	// store's aggregates and deletes them. It returns how many it compacted;
+	// fewer than limit means none are left.
+	CompactBefore(cutoff time.Time, limit int) int
+}
+
+// RetentionStats is the progress of the retention runs of one store
+type RetentionStats struct {
+	Target        string        `json:"target"`
+	LastRun       time.Time     `json:"last_run"`
+	LastDuration  time.Duration `json:"last_duration"`
+	LastCompacted int           `json:"last_compacted"`
+	Compacted     int64         `json:"compacted"`
+	Batches       int64         `json:"batches"`
+}
+
+// RetentionEngine downsamples the raw samples of time-series stores once
+// they are older than the retention period. It runs nightly and compacts
+// each store in batches of RetentionBatchSize; aggregates are never deleted.
+type RetentionEngine struct {
+	retention time.Duration
+	batchSize int
+	targets   []string
+	stores    map[string]RetentionTarget
+	logger    *log.Logger
+	now       func() time.Time
+
+	mu    sync.RWMutex
+	stats map[string]*RetentionStats
+}
+
+// NewRetentionEngine creates an engine keeping retentionDays of raw samples
+func NewRetentionEngine(retentionDays int) *RetentionEngine {
+	return &RetentionEngine{
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+		batchSize: RetentionBatchSize,
+		stores:    make(map[string]RetentionTarget),
+		logger:    log.New(log.Writer(), "[RetentionEngine] ", log.LstdFlags),
+		now:       utcNow,
+		stats:     make(map[string]*RetentionStats),
+	}
+}
+
+// Register adds a store to compact, named in logs and metrics
+func (re *RetentionEngine) Register(name string, target RetentionTarget) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if _, ok := re.stores[name]; !ok {
+		re.targets = append(re.targets, name)
+	}
+	re.stores[name] = target
+	re.stats[name] = &RetentionStats{Target: name}
+}
+
+// Start runs retention shortly after every UTC midnight until ctx is cancelled
+func (re *RetentionEngine) Start(ctx context.Context) {
+	go func() {
+		for {
+			now := re.now().UTC()
+			next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 15, 0, 0, time.UTC)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(next.Sub(now)):
+			}
+			re.Run(ctx)
+		}
+	}()
+}
+
+// Run compacts every store's raw samples older than the retention period and
+// returns how many were compacted. A cancelled context stops it between batches.
+func (re *RetentionEngine) Run(ctx context.Context) int {
+	re.mu.RLock()
+	targets := append([]string(nil), re.targets...)
+	re.mu.RUnlock()
+
+	total := 0
+	for _, name := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		total += re.runTarget(ctx, name)
+	}
+	return total
+}
+
+// runTarget compacts one store in batches until it has nothing left to compact
+func (re *RetentionEngine) runTarget(ctx context.Context, name string) int {
+	re.mu.RLock()
+	target := re.stores[name]
+	re.mu.RUnlock()
+
+	started := re.now()
+	cutoff := started.Add(-re.retention)
+	compacted, batches := 0, 0
+	for ctx.Err() == nil {
+		n := target.CompactBefore(cutoff, re.batchSize)
+		compacted += n
+		batches++
+		if n < re.batchSize {
+			break
+		}
+		re.logger.Printf("%s: compacted %d samples older than %s so far", name, compacted, cutoff.Format(time.RFC3339))
+	}
+	duration := re.now().Sub(started)
+	if compacted > 0 {
+		re.logger.Printf("%s: compacted %d samples in %d batches in %s", name, compacted, batches, duration)
+	}
+
+	re.mu.Lock()
+	stats := re.stats[name]
+	stats.LastRun = started
+	stats.LastDuration = duration
+	stats.LastCompacted = compacted
+	stats.Compacted += int64(compacted)
+	stats.Batches += int64(batches)
+	re.mu.Unlock()
+	return compacted
+}
+
+// Stats returns the progress of each store, in registration order
+func (re *RetentionEngine) Stats() []RetentionStats {
+	re.mu.RLock()
+	defer re.mu.RUnlock()
+
+	stats := make([]RetentionStats, 0, len(re.targets))
+	for _, name := range re.targets {
+		stats = append(stats, *re.stats[name])
+	}
+	return stats
+}
+
+// WritePrometheus exposes the compaction counters and the last run of each store
+func (re *RetentionEngine) WritePrometheus(pw *PromWriter) {
+	stats := re.Stats()
+	for _, stat := range stats {
+		pw.Counter("kaia_retention_compacted_total", "Raw samples folded into aggregates by retention.", float64(stat.Compacted), map[string]string{"target": stat.Target})
+	}
+	for _, stat := range stats {
+		pw.Counter("kaia_retention_batches_total", "Retention batches run.", float64(stat.Batches), map[string]string{"target": stat.Target})
+	}
+	for _, stat := range stats {
+		pw.Gauge("kaia_retention_last_run_duration_seconds", "Duration of the last retention run.", stat.LastDuration.Seconds(), map[string]string{"target": stat.Target})
+	}
+	for _, stat := range stats {
+		if !stat.LastRun.IsZero() {
+			pw.Gauge("kaia_retention_last_run_timestamp_seconds", "Start of the last retention run.", float64(stat.LastRun.Unix()), map[string]string{"target": stat.Target})
+		}
+	}
+}
+
+// SeriesAggregate summarizes the points of a metric in one hour
+type SeriesAggregate struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Sum   float64   `json:"sum"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+}
+
+// Mean is the average value of the hour's points
+func (a SeriesAggregate) Mean() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// point is the aggregate as a series point at the start of its hour
+func (a SeriesAggregate) point() SeriesPoint {
+	return SeriesPoint{Timestamp: a.Start, Value: a.Mean()}
+}
+
+// foldHourly adds points to hourly aggregates kept in time order, merging
+// points into the aggregate of their hour when it exists
+func foldHourly(aggregates []SeriesAggregate, points []SeriesPoint) []SeriesAggregate {
+	for _, point := range points {
+		hour := point.Timestamp.UTC().Truncate(time.Hour)
+		i := sort.Search(len(aggregates), func(i int) bool { return !aggregates[i].Start.Before(hour) })
+		if i == len(aggregates) || !aggregates[i].Start.Equal(hour) {
+			aggregates = append(aggregates, SeriesAggregate{})
+			copy(aggregates[i+1:], aggregates[i:])
+			aggregates[i] = SeriesAggregate{Start: hour, Min: point.Value, Max: point.Value}
+		}
+		aggregate := &aggregates[i]
+		aggregate.Count++
+		aggregate.Sum += point.Value
+		aggregate.Min = min(aggregate.Min, point.Value)
+		aggregate.Max = max(aggregate.Max, point.Value)
+	}
+	return aggregates
+}
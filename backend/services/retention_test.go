@@ -0,0 +1,191 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRetentionEngine(days int) (*RetentionEngine, *testClock) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+	engine := NewRetentionEngine(days)
+	engine.now = clock.Now
+	return engine, clock
+}
+
+func TestRetentionDownsamplesPricesAcrossTheBoundary(t *testing.T) {
+	engine, clock := newTestRetentionEngine(30)
+	engine.batchSize = 500
+	store := NewTimeSeriesStore()
+	engine.Register("prices", store)
+	metric := PriceMetric("KAIA")
+
+	// 40 days of prices every 10 minutes, valued by their index
+	start := clock.Now().Add(-40 * 24 * time.Hour)
+	for i := 0; i < 40*144; i++ {
+		store.Record(metric, SeriesPoint{Timestamp: start.Add(time.Duration(i) * 10 * time.Minute), Value: float64(i)})
+	}
+
+	assert.Equal(t, 10*144, engine.Run(context.Background()))
+	stats := engine.Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, int64(10*144), stats[0].Compacted)
+	assert.Equal(t, int64(3), stats[0].Batches, "compacted in batches of 500")
+
+	// The first 10 days are hourly means, the rest raw, with no gap between
+	points := store.Range(metric, time.Time{})
+	require.Len(t, points, 10*24+30*144)
+	for i := 1; i < len(points); i++ {
+		step := 10 * time.Minute
+		if i <= 10*24 {
+			step = time.Hour
+		}
+		require.Equal(t, step, points[i].Timestamp.Sub(points[i-1].Timestamp), "point %d", i)
+	}
+	assert.Equal(t, 2.5, points[0].Value)
+	assert.Equal(t, float64(6*239)+2.5, points[239].Value)
+	assert.Equal(t, float64(1440), points[240].Value)
+
+	aggregates := store.Aggregates(metric)
+	require.Len(t, aggregates, 240)
+	assert.Equal(t, SeriesAggregate{Start: start.Add(time.Hour), Count: 6, Sum: 6 + 7 + 8 + 9 + 10 + 11, Min: 6, Max: 11}, aggregates[1])
+
+	// Point lookups inside aggregated hours get the hour's mean
+	at, ok := store.ValueAt(metric, start.Add(25*time.Minute))
+	require.True(t, ok)
+	assert.Equal(t, SeriesPoint{Timestamp: start, Value: 2.5}, at)
+	_, ok = store.ValueAt(metric, start.Add(-time.Minute))
+	assert.False(t, ok)
+
+	since := start.Add(10*24*time.Hour - 2*time.Hour)
+	recent := store.Range(metric, since)
+	assert.Equal(t, since, recent[0].Timestamp)
+	assert.Len(t, recent, 2+30*144)
+
+	// Nothing is left to compact until the boundary moves
+	assert.Equal(t, 0, engine.Run(context.Background()))
+	clock.Advance(time.Hour)
+	assert.Equal(t, 6, engine.Run(context.Background()))
+
+	var buf bytes.Buffer
+	engine.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), `kaia_retention_compacted_total{target="prices"} 1446`)
+	assert.Contains(t, buf.String(), `kaia_retention_last_run_timestamp_seconds{target="prices"}`)
+}
+
+func TestRetentionKeepsYieldHistoryContinuous(t *testing.T) {
+	engine, clock := newTestRetentionEngine(7)
+	history := NewYieldHistory()
+	history.now = clock.Now
+	engine.Register("yields", history)
+
+	// 10 days of scans every 20 minutes; the APY cycles 10, 11, 12 within
+	// each hour and the TVL grows by 1000 an hour
+	start := clock.Now().Add(-10 * 24 * time.Hour)
+	for i := 0; i < 10*72; i++ {
+		history.Record([]YieldOpportunity{{
+			Protocol: "Klayswap", AssetPair: "KAIA/USDT", APY: 10 + float64(i%3), TVL: float64(i/3) * 1000,
+		}}, start.Add(time.Duration(i)*20*time.Minute))
+	}
+	trend, ok := history.Trend("Klayswap", "KAIA/USDT")
+	require.True(t, ok)
+
+	assert.Equal(t, 3*72, engine.Run(context.Background()))
+
+	samples := history.Samples("Klayswap", "KAIA/USDT", time.Time{})
+	require.Len(t, samples, 3*24+7*72)
+	assert.Equal(t, YieldSample{Timestamp: start, APY: 11, TVL: 0}, samples[0])
+	assert.Equal(t, YieldSample{Timestamp: start.Add(71 * time.Hour), APY: 11, TVL: 71000}, samples[71])
+	assert.Equal(t, start.Add(72*time.Hour), samples[72].Timestamp)
+	assert.Equal(t, 10.0, samples[72].APY)
+
+	// The 7 day trend only reads raw scans and is unchanged; the 30 day
+	// series still spans the whole history
+	after, ok := history.Trend("Klayswap", "KAIA/USDT")
+	require.True(t, ok)
+	assert.Equal(t, trend, after)
+	series, err := history.Series("Klayswap", "KAIA/USDT", "30d")
+	require.NoError(t, err)
+	assert.Equal(t, start, series.TVL[0].Timestamp)
+	assert.Equal(t, samples[len(samples)-1].Timestamp, series.TVL[len(series.TVL)-1].Timestamp)
+}
+
+func TestRetentionMergesCongestionIntoDays(t *testing.T) {
+	engine, clock := newTestRetentionEngine(1)
+	tracker := NewCongestionTracker(nil)
+	tracker.now = clock.Now
+	engine.Register("congestion", tracker)
+
+	start := clock.Now().Add(-72 * time.Hour)
+	utilization := func(hour, i int) float64 {
+		if i < hour%3*10 {
+			return 0.95
+		}
+		return 0.4
+	}
+	for _, stat := range congestionStats(start, 1, utilization, func(hour int) float64 { return float64(hour + 1) }, 72) {
+		tracker.Record(stat)
+	}
+	before := tracker.Report(72 * time.Hour)
+
+	assert.Equal(t, 48, engine.Run(context.Background()))
+
+	hours := tracker.Hours(time.Time{})
+	require.Len(t, hours, 2+24)
+	day := hours[0]
+	assert.Equal(t, start, day.Hour)
+	assert.Equal(t, 24*60, day.Blocks)
+	assert.Equal(t, 8*(10+20), day.FullBlocks)
+	assert.InDelta(t, 12.5, day.AvgBaseFee, 1e-9)
+	assert.Equal(t, start.Add(24*time.Hour), hours[1].Hour)
+	assert.Equal(t, start.Add(48*time.Hour), hours[2].Hour)
+
+	// Reports over the boundary add up to the same totals
+	after := tracker.Report(72 * time.Hour)
+	assert.Equal(t, before.Blocks, after.Blocks)
+	assert.InDelta(t, before.AvgUtilization, after.AvgUtilization, 1e-9)
+	assert.InDelta(t, before.FullBlockRatio, after.FullBlockRatio, 1e-9)
+	assert.Equal(t, before.PeakP95Utilization, after.PeakP95Utilization)
+	assert.Equal(t, before.Level, after.Level)
+}
+
+func TestRetentionThinsValidatorSnapshotsToDays(t *testing.T) {
+	collector, source, clock := newTestStakingCollector()
+	engine := NewRetentionEngine(2)
+	engine.now = clock.Now
+	engine.Register("validators", collector)
+
+	// Hourly snapshots for 5 days, each validator earning at a steady rate
+	for hour := 0; hour < 5*24; hour++ {
+		if hour > 0 {
+			clock.Advance(time.Hour)
+		}
+		require.NoError(t, collector.Collect(context.Background()))
+		for i := range source.validators {
+			source.validators[i].TotalRewards += source.validators[i].TotalStaked * 0.1 / (365 * 24)
+		}
+	}
+	before, err := collector.Validators(ValidatorSortAPR)
+	require.NoError(t, err)
+
+	// The first two days keep their last snapshot each
+	assert.Equal(t, 3*46, engine.Run(context.Background()))
+	snapshots := collector.snapshots[validatorA]
+	require.Len(t, snapshots, 2+72)
+	assert.Equal(t, 23, snapshots[0].at.Hour())
+	assert.Equal(t, 23, snapshots[1].at.Hour())
+	assert.Equal(t, clock.Now().Add(-71*time.Hour), snapshots[2].at)
+
+	after, err := collector.Validators(ValidatorSortAPR)
+	require.NoError(t, err)
+	require.Len(t, after, len(before))
+	for i := range before {
+		assert.Equal(t, before[i].Address, after[i].Address)
+		assert.InDelta(t, before[i].APR, after[i].APR, 1e-6)
+		assert.InDelta(t, before[i].APR7dAvg, after[i].APR7dAvg, 1e-6)
+	}
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// DefaultSlowRPCCallThreshold is how long an RPC call runs before it is
+	// logged as slow
+	DefaultSlowRPCCallThreshold = 2 * time.Second
+	// RPCMethodWindow is how far back the per-method summary looks
+	RPCMethodWindow = 15 * time.Minute
+
+	// slowRPCParamsLength caps the params written to the slow-call log
+	slowRPCParamsLength = 256
+)
+
+// RPCLatencyBuckets are the upper bounds, in seconds, of the per-method
+// latency histogram
+var RPCLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// rpcCall names the JSON-RPC method a FailoverClient call sends. size, when
+// set, measures the response once the call succeeded.
+type rpcCall struct {
+	method string
+	params []interface{}
+	size   func() int
+}
+
+// rpcMinute is one minute of a method's calls, for the windowed summary
+type rpcMinute struct {
+	minute  int64
+	calls   uint64
+	errors  uint64
+	slow    uint64
+	totalNs int64
+	maxNs   int64
+	buckets []uint64
+	codes   map[string]uint64
+}
+
+// rpcMethodStats are a method's totals since start and its last
+// RPCMethodWindow of calls, a minute per slot
+type rpcMethodStats struct {
+	buckets       []uint64
+	sumSeconds    float64
+	errors        map[string]uint64
+	responseBytes int
+	minutes       []rpcMinute
+}
+
+// RPCMethodSummary is a method's calls over the last RPCMethodWindow.
+// P95LatencyMs is the upper bound of the histogram bucket holding the 95th
+// percentile, or the slowest call when that is past the last bucket.
+type RPCMethodSummary struct {
+	Method        string            `json:"method"`
+	Calls         uint64            `json:"calls"`
+	Errors        uint64            `json:"errors"`
+	ErrorRate     float64           `json:"error_rate"`
+	SlowCalls     uint64            `json:"slow_calls"`
+	AvgLatencyMs  float64           `json:"avg_latency_ms"`
+	P95LatencyMs  float64           `json:"p95_latency_ms"`
+	MaxLatencyMs  float64           `json:"max_latency_ms"`
+	ErrorCodes    map[string]uint64 `json:"error_codes,omitempty"`
+	ResponseBytes int               `json:"response_bytes"`
+}
+
+// RPCMethodMetrics breaks RPC calls down by JSON-RPC method: a latency
+// histogram, failures by error code, and the size of the last response.
+// Calls slower than the threshold are logged with their method and params.
+type RPCMethodMetrics struct {
+	mu            sync.Mutex
+	methods       map[string]*rpcMethodStats
+	slowThreshold time.Duration
+	logger        *log.Logger
+	now           func() time.Time
+}
+
+// NewRPCMethodMetrics creates empty metrics logging calls slower than
+// slowThreshold, or DefaultSlowRPCCallThreshold when it isn't positive
+func NewRPCMethodMetrics(slowThreshold time.Duration) *RPCMethodMetrics {
+	if slowThreshold <= 0 {
+		slowThreshold = DefaultSlowRPCCallThreshold
+	}
+	return &RPCMethodMetrics{
+		methods:       make(map[string]*rpcMethodStats),
+		slowThreshold: slowThreshold,
+		logger:        log.New(log.Writer(), "[RPC] ", log.LstdFlags),
+		now:           utcNow,
+	}
+}
+
+// Observe records one call of the method. Not-found answers aren't failures.
+func (m *RPCMethodMetrics) Observe(call rpcCall, elapsed time.Duration, err error) {
+	code := rpcErrorCode(err)
+	slow := elapsed >= m.slowThreshold
+	if slow {
+		status := "ok"
+		if code != "" {
+			status = "error " + code
+		}
+		m.logger.Printf("Slow RPC call %s took %s (%s), params %s", call.method, elapsed.Round(time.Millisecond), status, formatRPCParams(call.params))
+	}
+	size := -1
+	if code == "" && err == nil && call.size != nil {
+		size = call.size()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.methods[call.method]
+	if !ok {
+		stats = &rpcMethodStats{
+			buckets: make([]uint64, len(RPCLatencyBuckets)+1),
+			errors:  make(map[string]uint64),
+			minutes: make([]rpcMinute, int(RPCMethodWindow/time.Minute)),
+		}
+		m.methods[call.method] = stats
+	}
+	bucket := sort.SearchFloat64s(RPCLatencyBuckets, elapsed.Seconds())
+	stats.buckets[bucket]++
+	stats.sumSeconds += elapsed.Seconds()
+	if code != "" {
+		stats.errors[code]++
+	}
+	if size >= 0 {
+		stats.responseBytes = size
+	}
+
+	minute := m.now().Unix() / 60
+	slot := &stats.minutes[minute%int64(len(stats.minutes))]
+	if slot.minute != minute {
+		*slot = rpcMinute{minute: minute, buckets: make([]uint64, len(RPCLatencyBuckets)+1), codes: make(map[string]uint64)}
+	}
+	slot.calls++
+	slot.buckets[bucket]++
+	slot.totalNs += elapsed.Nanoseconds()
+	slot.maxNs = max(slot.maxNs, elapsed.Nanoseconds())
+	if code != "" {
+		slot.errors++
+		slot.codes[code]++
+	}
+	if slow {
+		slot.slow++
+	}
+}
+
+// Summary returns the methods called over the last RPCMethodWindow, worst
+// first: by error rate, then by 95th percentile latency
+func (m *RPCMethodMetrics) Summary() []RPCMethodSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := m.now().Add(-RPCMethodWindow).Unix() / 60
+	summaries := make([]RPCMethodSummary, 0, len(m.methods))
+	for method, stats := range m.methods {
+		summary := RPCMethodSummary{Method: method, ResponseBytes: stats.responseBytes}
+		buckets := make([]uint64, len(RPCLatencyBuckets)+1)
+		var totalNs, maxNs int64
+		for _, slot := range stats.minutes {
+			if slot.calls == 0 || slot.minute <= since {
+				continue
+			}
+			summary.Calls += slot.calls
+			summary.Errors += slot.errors
+			summary.SlowCalls += slot.slow
+			totalNs += slot.totalNs
+			maxNs = max(maxNs, slot.maxNs)
+			for i, count := range slot.buckets {
+				buckets[i] += count
+			}
+			for code, count := range slot.codes {
+				if summary.ErrorCodes == nil {
+					summary.ErrorCodes = make(map[string]uint64)
+				}
+				summary.ErrorCodes[code] += count
+			}
+		}
+		if summary.Calls == 0 {
+			continue
+		}
+		summary.ErrorRate = float64(summary.Errors) / float64(summary.Calls)
+		summary.AvgLatencyMs = float64(totalNs) / float64(summary.Calls) / 1e6
+		summary.MaxLatencyMs = float64(maxNs) / 1e6
+		summary.P95LatencyMs = summary.MaxLatencyMs
+		var seen uint64
+		for i, count := range buckets[:len(RPCLatencyBuckets)] {
+			seen += count
+			if float64(seen) >= 0.95*float64(summary.Calls) {
+				summary.P95LatencyMs = math.Min(RPCLatencyBuckets[i]*1000, summary.MaxLatencyMs)
+				break
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].ErrorRate != summaries[j].ErrorRate {
+			return summaries[i].ErrorRate > summaries[j].ErrorRate
+		}
+		if summaries[i].P95LatencyMs != summaries[j].P95LatencyMs {
+			return summaries[i].P95LatencyMs > summaries[j].P95LatencyMs
+		}
+		return summaries[i].Method < summaries[j].Method
+	})
+	return summaries
+}
+
+// WritePrometheus exposes the per-method latency histograms, failures by
+// error code, and last response sizes
+func (m *RPCMethodMetrics) WritePrometheus(pw *PromWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	methods := make([]string, 0, len(m.methods))
+	for method := range m.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	for _, method := range methods {
+		stats := m.methods[method]
+		pw.Histogram("kaia_rpc_method_duration_seconds", "RPC call latency per JSON-RPC method.",
+			RPCLatencyBuckets, stats.buckets, stats.sumSeconds, map[string]string{"method": method})
+	}
+	for _, method := range methods {
+		stats := m.methods[method]
+		codes := make([]string, 0, len(stats.errors))
+		for code := range stats.errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			pw.Counter("kaia_rpc_method_errors_total", "Failed RPC calls per JSON-RPC method and error code.",
+				float64(stats.errors[code]), map[string]string{"method": method, "code": code})
+		}
+	}
+	for _, method := range methods {
+		pw.Gauge("kaia_rpc_method_response_bytes", "Size of the last response per JSON-RPC method.",
+			float64(m.methods[method].responseBytes), map[string]string{"method": method})
+	}
+}
+
+// rpcErrorCode labels a failed call: the JSON-RPC error code, http_<status>
+// for HTTP errors, timeout, canceled, or other. Empty for successes and
+// not-found answers.
+func rpcErrorCode(err error) string {
+	var rpcErr rpc.Error
+	var httpErr rpc.HTTPError
+	switch {
+	case err == nil, errors.Is(err, ethereum.NotFound):
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &rpcErr):
+		return strconv.Itoa(rpcErr.ErrorCode())
+	case errors.As(err, &httpErr):
+		return "http_" + strconv.Itoa(httpErr.StatusCode)
+	default:
+		return "other"
+	}
+}
+
+// formatRPCParams renders call params for the slow-call log, truncated
+func formatRPCParams(params []interface{}) string {
+	if params == nil {
+		params = []interface{}{}
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "(unencodable)"
+	}
+	if len(encoded) > slowRPCParamsLength {
+		return string(encoded[:slowRPCParamsLength]) + "…"
+	}
+	return string(encoded)
+}
+
+// rpcBlockParam renders a block number param as the node receives it
+func rpcBlockParam(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	return number.String()
+}
+
+// callMsgParam renders a contract call's params for the slow-call log
+func callMsgParam(msg ethereum.CallMsg) map[string]interface{} {
+	param := map[string]interface{}{"from": msg.From, "data": hexutil.Bytes(msg.Data)}
+	if msg.To != nil {
+		param["to"] = msg.To
+	}
+	return param
+}
+
+// filterQueryParam renders a log query's params for the slow-call log
+func filterQueryParam(query ethereum.FilterQuery) map[string]interface{} {
+	param := map[string]interface{}{"address": query.Addresses, "topics": query.Topics}
+	if query.BlockHash != nil {
+		param["blockHash"] = query.BlockHash
+	} else {
+		param["fromBlock"] = rpcBlockParam(query.FromBlock)
+		param["toBlock"] = rpcBlockParam(query.ToBlock)
+	}
+	return param
+}
+
+// logsSize approximates the encoded size of logs: their data, topics and
+// addresses
+func logsSize(logs []types.Log) int {
+	size := 0
+	for _, entry := range logs {
+		size += len(entry.Data) + 32*len(entry.Topics) + 20
+	}
+	return size
+}
+
+// jsonSize is the encoded size of a raw call's result
+func jsonSize(result interface{}) int {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
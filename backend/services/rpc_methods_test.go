@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRPCError is a JSON-RPC error answer from the node
+type fakeRPCError struct {
+	code int
+}
+
+func (e fakeRPCError) Error() string  { return fmt.Sprintf("rpc error %d", e.code) }
+func (e fakeRPCError) ErrorCode() int { return e.code }
+
+func newTestRPCMethodMetrics(slowThreshold time.Duration) (*RPCMethodMetrics, *testClock, *bytes.Buffer) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	var logs bytes.Buffer
+	metrics := NewRPCMethodMetrics(slowThreshold)
+	metrics.now = clock.Now
+	metrics.logger = log.New(&logs, "", 0)
+	return metrics, clock, &logs
+}
+
+func TestRPCMethodMetricsHistogram(t *testing.T) {
+	metrics, _, _ := newTestRPCMethodMetrics(time.Minute)
+	result := make([]byte, 96)
+	call := rpcCall{method: "eth_call", size: func() int { return len(result) }}
+	for _, elapsed := range []time.Duration{3 * time.Millisecond, 7 * time.Millisecond, 30 * time.Millisecond, 3 * time.Second, 12 * time.Second} {
+		metrics.Observe(call, elapsed, nil)
+	}
+	metrics.Observe(rpcCall{method: "eth_getLogs"}, 40*time.Millisecond, fmt.Errorf("all RPC endpoints failed: %w", fakeRPCError{code: -32005}))
+	metrics.Observe(rpcCall{method: "eth_getLogs"}, 10*time.Second, context.DeadlineExceeded)
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(NewPromWriter(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE kaia_rpc_method_duration_seconds histogram\n")
+	for le, count := range map[string]int{"0.005": 1, "0.01": 2, "0.025": 2, "0.05": 3, "2.5": 3, "5": 4, "10": 4, "+Inf": 5} {
+		assert.Contains(t, out, fmt.Sprintf("kaia_rpc_method_duration_seconds_bucket{le=%q,method=\"eth_call\"} %d\n", le, count))
+	}
+	assert.Contains(t, out, `kaia_rpc_method_duration_seconds_count{method="eth_call"} 5`)
+	assert.Contains(t, out, `kaia_rpc_method_duration_seconds_sum{method="eth_call"} 15.04`)
+	// A call lasting exactly a bucket's bound falls in that bucket
+	assert.Contains(t, out, `kaia_rpc_method_duration_seconds_bucket{le="10",method="eth_getLogs"} 2`)
+	assert.Contains(t, out, `kaia_rpc_method_errors_total{code="-32005",method="eth_getLogs"} 1`)
+	assert.Contains(t, out, `kaia_rpc_method_errors_total{code="timeout",method="eth_getLogs"} 1`)
+	assert.NotContains(t, out, `kaia_rpc_method_errors_total{code="other"`)
+	assert.Contains(t, out, `kaia_rpc_method_response_bytes{method="eth_call"} 96`)
+}
+
+func TestRPCMethodMetricsSummary(t *testing.T) {
+	metrics, clock, _ := newTestRPCMethodMetrics(time.Second)
+
+	// Old failures drop out of the window
+	for i := 0; i < 5; i++ {
+		metrics.Observe(rpcCall{method: "eth_feeHistory"}, time.Millisecond, fakeRPCError{code: -32601})
+	}
+	clock.Advance(RPCMethodWindow)
+	for i := 0; i < 20; i++ {
+		metrics.Observe(rpcCall{method: "eth_feeHistory"}, time.Millisecond, nil)
+		metrics.Observe(rpcCall{method: "eth_getBlockByNumber"}, 80*time.Millisecond, nil)
+	}
+	metrics.Observe(rpcCall{method: "eth_getBlockByNumber"}, 1500*time.Millisecond, nil)
+	clock.Advance(5 * time.Minute)
+	for i := 0; i < 3; i++ {
+		metrics.Observe(rpcCall{method: "eth_getLogs"}, 20*time.Millisecond, nil)
+	}
+	metrics.Observe(rpcCall{method: "eth_getLogs"}, 20*time.Millisecond, fakeRPCError{code: -32005})
+
+	summary := metrics.Summary()
+	require.Len(t, summary, 3)
+	assert.Equal(t, "eth_getLogs", summary[0].Method)
+	assert.Equal(t, 0.25, summary[0].ErrorRate)
+	assert.Equal(t, map[string]uint64{"-32005": 1}, summary[0].ErrorCodes)
+
+	assert.Equal(t, "eth_getBlockByNumber", summary[1].Method)
+	assert.Equal(t, uint64(21), summary[1].Calls)
+	assert.Equal(t, 100.0, summary[1].P95LatencyMs)
+	assert.Equal(t, 1500.0, summary[1].MaxLatencyMs)
+	assert.Equal(t, uint64(1), summary[1].SlowCalls)
+
+	assert.Equal(t, "eth_feeHistory", summary[2].Method)
+	assert.Equal(t, uint64(20), summary[2].Calls)
+	assert.Zero(t, summary[2].Errors)
+	assert.Nil(t, summary[2].ErrorCodes)
+
+	clock.Advance(RPCMethodWindow)
+	assert.Empty(t, metrics.Summary())
+}
+
+func TestFailoverClientLogsSlowCalls(t *testing.T) {
+	node := newFakeNode(100)
+	client := newTestFailoverClient(node)
+	metrics, _, logs := newTestRPCMethodMetrics(20 * time.Millisecond)
+	client.methods = metrics
+	ctx := context.Background()
+
+	_, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, logs.String())
+
+	node.delay = 30 * time.Millisecond
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, logs.String(), "Slow RPC call eth_blockNumber took")
+	assert.Contains(t, logs.String(), "(ok), params []")
+
+	var buf bytes.Buffer
+	client.WritePrometheus(NewPromWriter(&buf))
+	assert.Contains(t, buf.String(), `kaia_rpc_method_duration_seconds_bucket{le="0.025",method="eth_blockNumber"} 1`)
+	assert.Contains(t, buf.String(), `kaia_rpc_method_duration_seconds_count{method="eth_blockNumber"} 2`)
+
+	// Failed calls are logged with their code, and long params are cut short
+	logs.Reset()
+	metrics.Observe(rpcCall{method: "eth_getLogs", params: []interface{}{strings.Repeat("a", 1000)}}, time.Second, errors.New("connection reset"))
+	line := strings.TrimSpace(logs.String())
+	assert.Contains(t, line, "Slow RPC call eth_getLogs took 1s (error other), params [\"aaa")
+	assert.True(t, strings.HasSuffix(line, "…"))
+	assert.Less(t, len(line), 400)
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sentryTimeout = 5 * time.Second
+
+// SentryReporter forwards recovered panics to Sentry as error events
+// through its store endpoint. Events are sent in the background; one that
+// fails to send is logged and dropped.
+type SentryReporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	httpClient  *http.Client
+	logger      *log.Logger
+}
+
+// NewSentryReporter creates a reporter for a DSN of the form
+// https://<key>@<host>/<project>
+func NewSentryReporter(dsn, environment string) (*SentryReporter, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	project := strings.Trim(parsed.Path, "/")
+	if (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.User == nil || parsed.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: expected https://<key>@<host>/<project>")
+	}
+
+	// Projects hosted under a path keep it ahead of /api
+	prefix, projectID := "", project
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, projectID = "/"+project[:i], project[i+1:]
+	}
+	return &SentryReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", parsed.Scheme, parsed.Host, prefix, projectID),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=kaia-analytics/1.0, sentry_key=%s", parsed.User.Username()),
+		environment: environment,
+		httpClient:  &http.Client{Timeout: sentryTimeout},
+		logger:      log.New(log.Writer(), "[Sentry] ", log.LstdFlags),
+	}, nil
+}
+
+// ReportPanic sends the panic to Sentry in the background
+func (sr *SentryReporter) ReportPanic(report PanicReport) {
+	go func() {
+		if err := sr.send(context.Background(), report); err != nil {
+			sr.logger.Printf("Failed to report panic in %s: %v", report.Component, err)
+		}
+	}()
+}
+
+// send posts one panic as a Sentry event
+func (sr *SentryReporter) send(ctx context.Context, report PanicReport) error {
+	eventID, err := randomHex(16)
+	if err != nil {
+		return fmt.Errorf("failed to generate event ID: %w", err)
+	}
+	tags := map[string]string{"component": report.Component}
+	if report.RequestID != "" {
+		tags["request_id"] = report.RequestID
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"event_id":    eventID,
+		"timestamp":   report.Time.UTC().Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      report.Component,
+		"environment": sr.environment,
+		"message":     "panic: " + report.Value,
+		"tags":        tags,
+		"extra":       map[string]string{"stack": report.Stack},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sentryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sr.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", sr.auth)
+	resp, err := sr.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
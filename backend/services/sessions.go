@@ -0,0 +1,242 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// SessionChallengeTTL is how long a sign-in challenge can be signed
+	SessionChallengeTTL = 5 * time.Minute
+	// SessionTTL is how long a session token stays valid
+	SessionTTL = 24 * time.Hour
+	// MaxSessionChallenges is how many sign-in challenges can be outstanding
+	// at once across addresses
+	MaxSessionChallenges = 10000
+	// MaxSessionChallengesPerAddress is how many sign-in challenges an
+	// address can have outstanding; asking for another drops its oldest
+	MaxSessionChallengesPerAddress = 3
+)
+
+var (
+	// ErrSessionChallenge is returned when signing in with a nonce that wasn't
+	// issued, was already used, or has expired
+	ErrSessionChallenge = errors.New("sign-in challenge is unknown or expired")
+	// ErrSessionSignature is returned when the challenge wasn't signed by the
+	// wallet signing in
+	ErrSessionSignature = errors.New("sign-in signature is invalid")
+	// ErrSessionChallengeLimit is returned when MaxSessionChallenges are
+	// already outstanding
+	ErrSessionChallengeLimit = errors.New("too many sign-ins in progress")
+)
+
+// SessionMessage is the text a wallet signs, with personal_sign, to sign in
+func SessionMessage(address common.Address, nonce string, issuedAt time.Time) string {
+	return fmt.Sprintf("Sign in to Kaia Analytics as %s.\nNonce: %s\nIssued at: %s",
+		address.Hex(), nonce, issuedAt.UTC().Format(time.RFC3339))
+}
+
+// SessionChallenge is a sign-in message waiting for the wallet's signature
+type SessionChallenge struct {
+	Address   string  `json:"address"`
+	Nonce     string  `json:"nonce"`
+	Message   string  `json:"message"`
+	IssuedAt  APITime `json:"issued_at"`
+	ExpiresAt APITime `json:"expires_at"`
+}
+
+// Session is a signed-in wallet. The token is only returned when the session
+// is created.
+type Session struct {
+	Token     string  `json:"token"`
+	Address   string  `json:"address"`
+	ExpiresAt APITime `json:"expires_at"`
+}
+
+// Sessions signs wallets in. A wallet asks for a challenge, signs its message,
+// and trades the signature for a session token identifying it on later
+// requests. Nonces are single use, and only hashes of tokens are kept.
+// Challenges and sessions are kept in memory.
+type Sessions struct {
+	mu         sync.Mutex
+	challenges map[string]SessionChallenge
+	pending    map[string][]string // nonces of outstanding challenges by address, oldest first
+	issued     []string            // nonces in the order they were issued
+	sessions   map[string]Session
+	started    []string // session keys in the order they were started
+	now        func() time.Time
+}
+
+// NewSessions creates a store with no one signed in
+func NewSessions() *Sessions {
+	return &Sessions{
+		challenges: make(map[string]SessionChallenge),
+		pending:    make(map[string][]string),
+		sessions:   make(map[string]Session),
+		now:        utcNow,
+	}
+}
+
+// Challenge issues a sign-in message for the address to sign. Only the
+// address's latest MaxSessionChallengesPerAddress challenges can be signed.
+func (s *Sessions) Challenge(address common.Address) (SessionChallenge, error) {
+	nonce, err := randomHex(16)
+	if err != nil {
+		return SessionChallenge{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	now := s.now()
+	challenge := SessionChallenge{
+		Address:   strings.ToLower(address.Hex()),
+		Nonce:     nonce,
+		Message:   SessionMessage(address, nonce, now),
+		IssuedAt:  NewAPITime(now),
+		ExpiresAt: NewAPITime(now.Add(SessionChallengeTTL)),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	if len(s.challenges) >= MaxSessionChallenges {
+		return SessionChallenge{}, ErrSessionChallengeLimit
+	}
+	if pending := s.pending[challenge.Address]; len(pending) >= MaxSessionChallengesPerAddress {
+		s.dropChallenge(pending[0])
+	}
+	s.challenges[nonce] = challenge
+	s.pending[challenge.Address] = append(s.pending[challenge.Address], nonce)
+	s.issued = append(s.issued, nonce)
+	return challenge, nil
+}
+
+// SignIn checks the wallet's signature of the challenge with the nonce and
+// starts a session for it. The nonce is spent whether or not the signature
+// checks out.
+func (s *Sessions) SignIn(nonce, signature string) (Session, error) {
+	now := s.now()
+	s.mu.Lock()
+	challenge, ok := s.challenges[nonce]
+	s.dropChallenge(nonce)
+	s.mu.Unlock()
+	if !ok || now.After(challenge.ExpiresAt.Time) {
+		return Session{}, ErrSessionChallenge
+	}
+
+	signer, err := recoverPersonalSigner(challenge.Message, signature)
+	if err != nil || !strings.EqualFold(signer.Hex(), challenge.Address) {
+		return Session{}, fmt.Errorf("%w: %s didn't sign the challenge", ErrSessionSignature, common.HexToAddress(challenge.Address).Hex())
+	}
+	return s.Issue(signer)
+}
+
+// Issue starts a session for an address whose ownership was already proven
+func (s *Sessions) Issue(address common.Address) (Session, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	now := s.now()
+	session := Session{
+		Token:     token,
+		Address:   strings.ToLower(address.Hex()),
+		ExpiresAt: NewAPITime(now.Add(SessionTTL)),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	stored := session
+	stored.Token = ""
+	key := sessionKey(token)
+	s.sessions[key] = stored
+	s.started = append(s.started, key)
+	return session, nil
+}
+
+// Authenticate returns the lowercased address signed in with the token
+func (s *Sessions) Authenticate(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(token)
+	session, ok := s.sessions[key]
+	if !ok {
+		return "", false
+	}
+	if !s.now().Before(session.ExpiresAt.Time) {
+		delete(s.sessions, key)
+		return "", false
+	}
+	return session.Address, true
+}
+
+// Revoke ends the session of the token, reporting whether there was one
+func (s *Sessions) Revoke(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(token)
+	_, ok := s.sessions[key]
+	delete(s.sessions, key)
+	return ok
+}
+
+// prune drops expired challenges and sessions. Both expire in the order they
+// were created, so only the oldest are looked at, along with those already
+// spent or revoked. Callers hold the lock.
+func (s *Sessions) prune(now time.Time) {
+	for len(s.issued) > 0 {
+		challenge, ok := s.challenges[s.issued[0]]
+		if ok && !now.After(challenge.ExpiresAt.Time) {
+			break
+		}
+		s.dropChallenge(s.issued[0])
+		s.issued = s.issued[1:]
+	}
+	for len(s.started) > 0 {
+		session, ok := s.sessions[s.started[0]]
+		if ok && now.Before(session.ExpiresAt.Time) {
+			break
+		}
+		delete(s.sessions, s.started[0])
+		s.started = s.started[1:]
+	}
+}
+
+// dropChallenge forgets the challenge with the nonce, if it is outstanding.
+// Callers hold the lock.
+func (s *Sessions) dropChallenge(nonce string) {
+	challenge, ok := s.challenges[nonce]
+	if !ok {
+		return
+	}
+	delete(s.challenges, nonce)
+	pending := s.pending[challenge.Address]
+	for i, outstanding := range pending {
+		if outstanding == nonce {
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(pending) == 0 {
+		delete(s.pending, challenge.Address)
+	} else {
+		s.pending[challenge.Address] = pending
+	}
+}
+
+// sessionKey is what a token is stored under, so a leaked store can't be used
+// to sign in
+func sessionKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signChallenge signs the challenge message the way personal_sign does
+func signChallenge(t *testing.T, key *ecdsa.PrivateKey, challenge SessionChallenge) string {
+	t.Helper()
+	signature, err := crypto.Sign(accounts.TextHash([]byte(challenge.Message)), key)
+	require.NoError(t, err)
+	signature[crypto.RecoveryIDOffset] += 27
+	return hexutil.Encode(signature)
+}
+
+func TestSessionsSignIn(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	sessions := NewSessions()
+	sessions.now = func() time.Time { return now }
+	key, stranger := newWalletKey(t), newWalletKey(t)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+
+	challenge, err := sessions.Challenge(wallet)
+	require.NoError(t, err)
+	assert.Equal(t, SessionMessage(wallet, challenge.Nonce, now), challenge.Message)
+
+	// Another key's signature is refused, and spends the nonce
+	_, err = sessions.SignIn(challenge.Nonce, signChallenge(t, stranger, challenge))
+	assert.ErrorIs(t, err, ErrSessionSignature)
+	_, err = sessions.SignIn(challenge.Nonce, signChallenge(t, key, challenge))
+	assert.ErrorIs(t, err, ErrSessionChallenge)
+
+	challenge, err = sessions.Challenge(wallet)
+	require.NoError(t, err)
+	_, err = sessions.SignIn(challenge.Nonce, "0x1234")
+	assert.ErrorIs(t, err, ErrSessionSignature)
+
+	challenge, err = sessions.Challenge(wallet)
+	require.NoError(t, err)
+	session, err := sessions.SignIn(challenge.Nonce, signChallenge(t, key, challenge))
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToLower(wallet.Hex()), session.Address)
+
+	address, ok := sessions.Authenticate(session.Token)
+	assert.True(t, ok)
+	assert.Equal(t, session.Address, address)
+	_, ok = sessions.Authenticate("not-a-token")
+	assert.False(t, ok)
+
+	// A nonce can't be replayed
+	_, err = sessions.SignIn(challenge.Nonce, signChallenge(t, key, challenge))
+	assert.ErrorIs(t, err, ErrSessionChallenge)
+
+	assert.True(t, sessions.Revoke(session.Token))
+	_, ok = sessions.Authenticate(session.Token)
+	assert.False(t, ok)
+}
+
+func TestSessionsExpire(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	sessions := NewSessions()
+	sessions.now = func() time.Time { return now }
+	key := newWalletKey(t)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+
+	challenge, err := sessions.Challenge(wallet)
+	require.NoError(t, err)
+	now = now.Add(SessionChallengeTTL + time.Second)
+	_, err = sessions.SignIn(challenge.Nonce, signChallenge(t, key, challenge))
+	assert.ErrorIs(t, err, ErrSessionChallenge)
+
+	session, err := sessions.Issue(wallet)
+	require.NoError(t, err)
+	_, ok := sessions.Authenticate(session.Token)
+	assert.True(t, ok)
+	now = now.Add(SessionTTL)
+	_, ok = sessions.Authenticate(session.Token)
+	assert.False(t, ok)
+}
+
+func TestSessionsCapChallenges(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	sessions := NewSessions()
+	sessions.now = func() time.Time { return now }
+	key := newWalletKey(t)
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+
+	// Asking for more challenges than an address can hold drops its oldest
+	var challenges []SessionChallenge
+	for i := 0; i <= MaxSessionChallengesPerAddress; i++ {
+		challenge, err := sessions.Challenge(wallet)
+		require.NoError(t, err)
+		challenges = append(challenges, challenge)
+	}
+	assert.Len(t, sessions.challenges, MaxSessionChallengesPerAddress)
+	_, err := sessions.SignIn(challenges[0].Nonce, signChallenge(t, key, challenges[0]))
+	assert.ErrorIs(t, err, ErrSessionChallenge)
+	latest := challenges[len(challenges)-1]
+	_, err = sessions.SignIn(latest.Nonce, signChallenge(t, key, latest))
+	assert.NoError(t, err)
+
+	for i := int64(1); len(sessions.challenges) < MaxSessionChallenges; i++ {
+		_, err := sessions.Challenge(common.BigToAddress(big.NewInt(i)))
+		require.NoError(t, err)
+	}
+	_, err = sessions.Challenge(wallet)
+	assert.ErrorIs(t, err, ErrSessionChallengeLimit)
+
+	// Expired challenges make room again
+	now = now.Add(SessionChallengeTTL + time.Second)
+	_, err = sessions.Challenge(wallet)
+	require.NoError(t, err)
+	assert.Len(t, sessions.challenges, 1)
+	assert.Len(t, sessions.issued, 1)
+}
@@ -0,0 +1,524 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// SigningRequestTTL is how long a prepared transaction waits for the user's
+// signature
+const SigningRequestTTL = 10 * time.Minute
+
+// signingRetention is how long finished signing requests are kept for status
+// lookups
+const signingRetention = time.Hour
+
+// signingGasHeadroomPercent is added to gas estimates, as state can change
+// between preparing a transaction and its signature
+const signingGasHeadroomPercent = 20
+
+// Statuses of a signing request
+const (
+	SigningStatusPending   = "pending"
+	SigningStatusSubmitted = "submitted"
+	SigningStatusMined     = "mined"
+	SigningStatusFailed    = "failed"
+	SigningStatusExpired   = "expired"
+)
+
+var (
+	// ErrSigningRequestNotFound is returned for an unknown signing request
+	ErrSigningRequestNotFound = errors.New("signing request not found")
+	// ErrSigningForbidden is returned when another address acts on a request
+	ErrSigningForbidden = errors.New("signing request belongs to another address")
+	// ErrSigningRequestExpired is returned for a request past its TTL
+	ErrSigningRequestExpired = errors.New("signing request has expired")
+	// ErrSigningRequestClosed is returned for a request already submitted
+	ErrSigningRequestClosed = errors.New("signing request was already submitted")
+	// ErrSignedTxMismatch is returned for a signed transaction that isn't the
+	// prepared one, or isn't signed by the request's address
+	ErrSignedTxMismatch = errors.New("signed transaction doesn't match the signing request")
+)
+
+// SigningBackend is the node surface signing requests are prepared and
+// broadcast through. *ethclient.Client and FailoverClient satisfy it.
+type SigningBackend interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+var (
+	_ SigningBackend = (*ethclient.Client)(nil)
+	_ SigningBackend = (*FailoverClient)(nil)
+)
+
+// SigningNotifier pushes frames to a user's open chat connections
+type SigningNotifier interface {
+	SendToUser(userID string, message *ChatResponse) error
+}
+
+// UnsignedTx is a transaction prepared for a wallet to sign. Amounts are
+// decimal wei and data is hex.
+type UnsignedTx struct {
+	ChainID  string `json:"chain_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Value    string `json:"value"`
+	Data     string `json:"data"`
+	Nonce    uint64 `json:"nonce"`
+	Gas      uint64 `json:"gas"`
+	GasPrice string `json:"gas_price"`
+}
+
+// SigningPrompt describes what a signing request is for
+type SigningPrompt struct {
+	Description string
+	// ActionID, ActionType, and MessageID link the request to a chat action,
+	// whose lifecycle is then recorded in the audit log
+	ActionID   string
+	ActionType string
+	MessageID  string
+}
+
+// SigningRequest is a prepared transaction awaiting the signature of the
+// address it was prepared for
+type SigningRequest struct {
+	ID          string     `json:"id"`
+	Address     string     `json:"address"`
+	ActionID    string     `json:"action_id,omitempty"`
+	ActionType  string     `json:"action_type,omitempty"`
+	Description string     `json:"description"`
+	Transaction UnsignedTx `json:"transaction"`
+	Status      string     `json:"status"`
+	TxHash      string     `json:"tx_hash,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   APITime    `json:"created_at"`
+	ExpiresAt   APITime    `json:"expires_at"`
+
+	from      common.Address
+	tx        *types.Transaction
+	messageID string
+	expires   time.Time
+	updated   time.Time
+}
+
+// SigningService prepares transactions that must come from a user's own
+// address, hands them to the user's wallet through their chat connection,
+// and broadcasts and tracks what the wallet signs. Requests expire after
+// SigningRequestTTL and only the address they were prepared for can submit.
+type SigningService struct {
+	backend  SigningBackend
+	chainID  *big.Int
+	notifier SigningNotifier
+	audit    *ActionAuditLog
+	logger   *log.Logger
+
+	// killSwitch rejects submissions while actions are suspended
+	killSwitch *KillSwitch
+
+	mu       sync.Mutex
+	requests map[string]*SigningRequest
+	now      func() time.Time
+}
+
+// NewSigningService creates a signing service for the chain
+func NewSigningService(backend SigningBackend, chainID *big.Int) *SigningService {
+	return &SigningService{
+		backend:  backend,
+		chainID:  new(big.Int).Set(chainID),
+		logger:   log.New(log.Writer(), "[SigningService] ", log.LstdFlags),
+		requests: make(map[string]*SigningRequest),
+		now:      utcNow,
+	}
+}
+
+// SetNotifier pushes sign_request frames to the user's chat connections
+func (s *SigningService) SetNotifier(notifier SigningNotifier) {
+	s.notifier = notifier
+}
+
+// SetActionAudit records the lifecycle of wallet-signed chat actions
+func (s *SigningService) SetActionAudit(audit *ActionAuditLog) {
+	s.audit = audit
+}
+
+// SetKillSwitch rejects wallet-signed submissions while actions are
+// suspended; the request stays pending so the wallet can submit it again
+// once they resume
+func (s *SigningService) SetKillSwitch(killSwitch *KillSwitch) {
+	s.killSwitch = killSwitch
+}
+
+// Prepare builds the unsigned transaction from the address, stores it under
+// a new signing request, and pushes a sign_request frame to the address's
+// chat connection. A request without gas has it estimated.
+func (s *SigningService) Prepare(ctx context.Context, from common.Address, prompt SigningPrompt, request TxRequest) (*SigningRequest, error) {
+	if request.To == nil {
+		return nil, errors.New("signing requests need a recipient")
+	}
+	value := request.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+
+	nonce, err := s.backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get nonce of %s: %w", from.Hex(), err)
+	}
+	gasPrice, err := s.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %w", err)
+	}
+	gas := request.Gas
+	if gas == 0 {
+		estimate, err := s.backend.EstimateGas(ctx, ethereum.CallMsg{From: from, To: request.To, Value: value, Data: request.Data})
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate gas: %w", err)
+		}
+		gas = estimate * (100 + signingGasHeadroomPercent) / 100
+	}
+
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       request.To,
+		Value:    value,
+		Data:     request.Data,
+		Gas:      gas,
+		GasPrice: gasPrice,
+	})
+	signing := &SigningRequest{
+		ID:          "sig_" + id,
+		Address:     strings.ToLower(from.Hex()),
+		ActionID:    prompt.ActionID,
+		ActionType:  prompt.ActionType,
+		Description: prompt.Description,
+		Transaction: UnsignedTx{
+			ChainID:  s.chainID.String(),
+			From:     strings.ToLower(from.Hex()),
+			To:       strings.ToLower(request.To.Hex()),
+			Value:    value.String(),
+			Data:     hexutil.Encode(request.Data),
+			Nonce:    nonce,
+			Gas:      gas,
+			GasPrice: gasPrice.String(),
+		},
+		Status:    SigningStatusPending,
+		CreatedAt: NewAPITime(now),
+		ExpiresAt: NewAPITime(now.Add(SigningRequestTTL)),
+		from:      from,
+		tx:        tx,
+		messageID: prompt.MessageID,
+		expires:   now.Add(SigningRequestTTL),
+		updated:   now,
+	}
+
+	s.mu.Lock()
+	s.requests[signing.ID] = signing
+	copied := *signing
+	s.mu.Unlock()
+
+	s.push(&copied)
+	return &copied, nil
+}
+
+// push sends the request to the address's chat connection. The frontend can
+// still fetch it by ID when the user isn't connected.
+func (s *SigningService) push(request *SigningRequest) {
+	if s.notifier == nil {
+		return
+	}
+	now := s.now()
+	err := s.notifier.SendToUser(request.Address, &ChatResponse{
+		ID:            "sign_" + request.ID,
+		Type:          "sign_request",
+		Response:      fmt.Sprintf("✍️ Please sign in your wallet: %s", request.Description),
+		Data:          request,
+		Success:       true,
+		Timestamp:     NewAPITime(now),
+		TimestampUnix: now.Unix(),
+	})
+	if err != nil {
+		s.logger.Printf("Failed to push signing request %s to %s: %v", request.ID, request.Address, err)
+	}
+}
+
+// Get returns one of the caller's signing requests
+func (s *SigningService) Get(id string, caller common.Address) (SigningRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, err := s.lookup(id, caller)
+	if err != nil {
+		return SigningRequest{}, err
+	}
+	return *request, nil
+}
+
+// lookup finds a request the caller may act on, expiring it if its TTL has
+// passed. The service is locked by the caller.
+func (s *SigningService) lookup(id string, caller common.Address) (*SigningRequest, error) {
+	request, ok := s.requests[id]
+	if !ok {
+		return nil, ErrSigningRequestNotFound
+	}
+	if request.from != caller {
+		return nil, ErrSigningForbidden
+	}
+	s.expire(request, s.now())
+	return request, nil
+}
+
+// expire marks a pending request past its TTL as expired. The service is
+// locked by the caller.
+func (s *SigningService) expire(request *SigningRequest, now time.Time) {
+	if request.Status != SigningStatusPending || now.Before(request.expires) {
+		return
+	}
+	request.Status = SigningStatusExpired
+	request.updated = now
+	s.record(request, ActionEventFailed, "", "signing request expired")
+}
+
+// Submit broadcasts the raw transaction the caller's wallet signed for a
+// request. The transaction must be the prepared one, from the request's
+// address; the wallet may change its gas limit and price.
+func (s *SigningService) Submit(ctx context.Context, id string, caller common.Address, rawTx string) (SigningRequest, error) {
+	raw, err := hexutil.Decode(rawTx)
+	if err != nil {
+		return SigningRequest{}, fmt.Errorf("%w: raw transaction isn't hex: %v", ErrSignedTxMismatch, err)
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(raw); err != nil {
+		return SigningRequest{}, fmt.Errorf("%w: %v", ErrSignedTxMismatch, err)
+	}
+
+	if err := s.killSwitch.Check(ctx); err != nil {
+		return SigningRequest{}, err
+	}
+
+	s.mu.Lock()
+	request, err := s.lookup(id, caller)
+	if err != nil {
+		s.mu.Unlock()
+		return SigningRequest{}, err
+	}
+	switch request.Status {
+	case SigningStatusPending:
+	case SigningStatusExpired:
+		s.mu.Unlock()
+		return SigningRequest{}, ErrSigningRequestExpired
+	default:
+		s.mu.Unlock()
+		return SigningRequest{}, ErrSigningRequestClosed
+	}
+	if err := s.matches(request, signed); err != nil {
+		s.mu.Unlock()
+		return SigningRequest{}, err
+	}
+	// Claim the request so a concurrent submit can't broadcast it twice
+	request.Status = SigningStatusSubmitted
+	request.TxHash = signed.Hash().Hex()
+	request.Error = ""
+	s.mu.Unlock()
+
+	sendErr := s.backend.SendTransaction(ctx, signed)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request.updated = s.now()
+	if sendErr != nil {
+		request.Status = SigningStatusPending
+		request.TxHash = ""
+		request.Error = sendErr.Error()
+		return *request, fmt.Errorf("failed to broadcast signed transaction: %w", sendErr)
+	}
+	s.record(request, ActionEventSubmitted, request.TxHash, "")
+	return *request, nil
+}
+
+// matches checks the signed transaction against the prepared one
+func (s *SigningService) matches(request *SigningRequest, signed *types.Transaction) error {
+	sender, err := types.LatestSignerForChainID(s.chainID).Sender(signed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignedTxMismatch, err)
+	}
+	if sender != request.from {
+		return fmt.Errorf("%w: signed by %s, not %s", ErrSignedTxMismatch, sender.Hex(), request.from.Hex())
+	}
+
+	prepared := request.tx
+	switch {
+	case signed.To() == nil || *signed.To() != *prepared.To():
+		return fmt.Errorf("%w: different recipient", ErrSignedTxMismatch)
+	case signed.Nonce() != prepared.Nonce():
+		return fmt.Errorf("%w: nonce %d, not %d", ErrSignedTxMismatch, signed.Nonce(), prepared.Nonce())
+	case signed.Value().Cmp(prepared.Value()) != 0:
+		return fmt.Errorf("%w: different value", ErrSignedTxMismatch)
+	case !bytes.Equal(signed.Data(), prepared.Data()):
+		return fmt.Errorf("%w: different data", ErrSignedTxMismatch)
+	}
+	return nil
+}
+
+// Start tracks submitted transactions and expires requests in the background
+// until ctx is cancelled
+func (s *SigningService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Track(ctx)
+			}
+		}
+	}()
+}
+
+// Track records the outcome of submitted transactions that have been mined,
+// expires pending requests past their TTL, and forgets finished requests
+// after an hour
+func (s *SigningService) Track(ctx context.Context) {
+	s.mu.Lock()
+	now := s.now()
+	var submitted []*SigningRequest
+	for id, request := range s.requests {
+		s.expire(request, now)
+		switch request.Status {
+		case SigningStatusSubmitted:
+			submitted = append(submitted, request)
+		case SigningStatusMined, SigningStatusFailed, SigningStatusExpired:
+			if now.Sub(request.updated) > signingRetention {
+				delete(s.requests, id)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, request := range submitted {
+		receipt, err := s.backend.TransactionReceipt(ctx, common.HexToHash(request.TxHash))
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+		if err != nil {
+			s.logger.Printf("Failed to get receipt of %s: %v", request.TxHash, err)
+			continue
+		}
+
+		s.mu.Lock()
+		request.updated = s.now()
+		if receipt.Status == types.ReceiptStatusSuccessful {
+			request.Status = SigningStatusMined
+			s.record(request, ActionEventMined, request.TxHash, "")
+		} else {
+			request.Status = SigningStatusFailed
+			request.Error = "transaction reverted"
+			s.record(request, ActionEventFailed, request.TxHash, "transaction reverted")
+		}
+		s.mu.Unlock()
+	}
+}
+
+// record appends a transition of a request linked to a chat action to the
+// audit log. The service is locked by the caller.
+func (s *SigningService) record(request *SigningRequest, event, txHash, reason string) {
+	if s.audit == nil || request.ActionID == "" {
+		return
+	}
+	s.audit.Append(ActionAuditRecord{
+		ActionID:   request.ActionID,
+		UserID:     request.Address,
+		MessageID:  request.messageID,
+		Event:      event,
+		ActionType: request.ActionType,
+		TxHash:     txHash,
+		Reason:     reason,
+	})
+}
+
+// actionContractCallsABI covers the ActionContract calls users make from
+// their own address
+const actionContractCallsABI = `[
+	{"type":"function","name":"requestAction","stateMutability":"payable","inputs":[
+		{"name":"_actionType","type":"string"},
+		{"name":"_parameters","type":"string"}
+	],"outputs":[{"name":"actionId","type":"uint256"}]},
+	{"type":"function","name":"actionTypes","stateMutability":"view","inputs":[{"name":"","type":"string"}],"outputs":[
+		{"name":"name","type":"string"},
+		{"name":"isEnabled","type":"bool"},
+		{"name":"gasLimit","type":"uint256"},
+		{"name":"fee","type":"uint256"},
+		{"name":"description","type":"string"}
+	]}
+]`
+
+// actionContractCalls is the parsed actionContractCallsABI
+var actionContractCalls = mustParseABI(actionContractCallsABI)
+
+// ActionRequestBuilder prepares ActionContract.requestAction calls for users
+// to sign, paying the action type's fee
+type ActionRequestBuilder struct {
+	caller   ethereum.ContractCaller
+	contract common.Address
+}
+
+// NewActionRequestBuilder creates a builder for the ActionContract
+func NewActionRequestBuilder(caller ethereum.ContractCaller, contract common.Address) *ActionRequestBuilder {
+	return &ActionRequestBuilder{caller: caller, contract: contract}
+}
+
+// Build encodes a request for the action with its parameters as JSON, and
+// reads the fee it must carry
+func (b *ActionRequestBuilder) Build(ctx context.Context, actionType string, parameters map[string]interface{}) (TxRequest, error) {
+	input, err := actionContractCalls.Pack("actionTypes", actionType)
+	if err != nil {
+		return TxRequest{}, err
+	}
+	output, err := b.caller.CallContract(ctx, ethereum.CallMsg{To: &b.contract, Data: input}, nil)
+	if err != nil {
+		return TxRequest{}, fmt.Errorf("failed to read action type %s: %w", actionType, err)
+	}
+	values, err := actionContractCalls.Unpack("actionTypes", output)
+	if err != nil {
+		return TxRequest{}, fmt.Errorf("failed to decode action type %s: %w", actionType, err)
+	}
+	if enabled, _ := values[1].(bool); !enabled {
+		return TxRequest{}, fmt.Errorf("action type %s isn't enabled on the ActionContract", actionType)
+	}
+	fee, _ := values[3].(*big.Int)
+
+	encoded, err := json.Marshal(parameters)
+	if err != nil {
+		return TxRequest{}, err
+	}
+	data, err := actionContractCalls.Pack("requestAction", actionType, string(encoded))
+	if err != nil {
+		return TxRequest{}, err
+	}
+	return TxRequest{To: &b.contract, Value: fee, Data: data}, nil
+}
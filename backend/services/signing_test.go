@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signingMempool is a fake mempool that also estimates gas
+type signingMempool struct {
+	*fakeMempool
+}
+
+func (signingMempool) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	return 50000, nil
+}
+
+// fakeActionContract answers actionTypes reads with the contract's defaults
+type fakeActionContract struct{}
+
+func (fakeActionContract) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	method, err := actionContractCalls.MethodById(msg.Data[:4])
+	if err != nil {
+		return nil, err
+	}
+	args, err := method.Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	name := args[0].(string)
+	fee := big.NewInt(10_000_000_000_000_000)
+	return method.Outputs.Pack(name, name != "disabled", big.NewInt(100000), fee, "Stake tokens in a protocol")
+}
+
+var signingContract = common.HexToAddress("0x00000000000000000000000000000000000000ac")
+
+func newTestSigningService() (*SigningService, *fakeMempool, *testClock) {
+	pool := newFakeMempool()
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	signing := NewSigningService(signingMempool{pool}, relayerChainID)
+	signing.now = clock.Now
+	return signing, pool, clock
+}
+
+// signPrepared signs the transaction of a signing request as a wallet would,
+// from its JSON form
+func signPrepared(t *testing.T, signer TxSigner, from common.Address, unsigned UnsignedTx) string {
+	value, ok := new(big.Int).SetString(unsigned.Value, 10)
+	require.True(t, ok)
+	gasPrice, ok := new(big.Int).SetString(unsigned.GasPrice, 10)
+	require.True(t, ok)
+	to := common.HexToAddress(unsigned.To)
+	signed, err := signer(from, types.NewTx(&types.LegacyTx{
+		Nonce:    unsigned.Nonce,
+		To:       &to,
+		Value:    value,
+		Data:     hexutil.MustDecode(unsigned.Data),
+		Gas:      unsigned.Gas,
+		GasPrice: gasPrice,
+	}))
+	require.NoError(t, err)
+	raw, err := signed.MarshalBinary()
+	require.NoError(t, err)
+	return hexutil.Encode(raw)
+}
+
+// connectChat opens a chat WebSocket registered for the user and returns the
+// client end
+func connectChat(t *testing.T, engine *ChatEngine, userID string) *websocket.Conn {
+	upgrader := websocket.Upgrader{}
+	registered := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		engine.RegisterConnection(userID, conn)
+		close(registered)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	<-registered
+	return client
+}
+
+func TestChatStakeIsSignedByUsersWallet(t *testing.T) {
+	signing, pool, _ := newTestSigningService()
+	from, signer := newRelayer(t)
+	engine := newTestChatEngine(t)
+	audit := NewActionAuditLog()
+	engine.SetActionAudit(audit)
+	signing.SetActionAudit(audit)
+	signing.SetNotifier(engine)
+	engine.SetWalletSigning(signing, NewActionRequestBuilder(fakeActionContract{}, signingContract))
+	client := connectChat(t, engine, strings.ToLower(from.Hex()))
+	ctx := context.Background()
+
+	// Prepare: the chat turns the stake into an unsigned ActionContract call
+	response, err := engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_1", UserID: from.Hex(), Message: "Stake 10 KAIA"})
+	require.NoError(t, err)
+	action := response.Data.(*ActionRequest)
+	assert.Equal(t, "awaiting_signature", action.Status)
+	requestID := response.Metadata["signing_request_id"].(string)
+
+	// Push: the user's connection gets the sign_request frame
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var frame struct {
+		Type string         `json:"type"`
+		Data SigningRequest `json:"data"`
+	}
+	require.NoError(t, client.ReadJSON(&frame))
+	assert.Equal(t, "sign_request", frame.Type)
+	assert.Equal(t, requestID, frame.Data.ID)
+	unsigned := frame.Data.Transaction
+	assert.Equal(t, "1001", unsigned.ChainID)
+	assert.Equal(t, strings.ToLower(signingContract.Hex()), unsigned.To)
+	assert.Equal(t, "10000000000000000", unsigned.Value, "the action type's fee is paid")
+	assert.Equal(t, uint64(60000), unsigned.Gas)
+	calldata := hexutil.MustDecode(unsigned.Data)
+	args, err := actionContractCalls.Methods["requestAction"].Inputs.Unpack(calldata[4:])
+	require.NoError(t, err)
+	assert.Equal(t, "stake", args[0])
+
+	// Submit: the wallet's signature is broadcast and tracked
+	submitted, err := signing.Submit(ctx, requestID, from, signPrepared(t, signer, from, unsigned))
+	require.NoError(t, err)
+	assert.Equal(t, SigningStatusSubmitted, submitted.Status)
+	pool.mu.Lock()
+	pooled := pool.pool[from][unsigned.Nonce]
+	pool.mu.Unlock()
+	require.NotNil(t, pooled)
+	assert.Equal(t, submitted.TxHash, pooled.Hash().Hex())
+
+	_, err = signing.Submit(ctx, requestID, from, signPrepared(t, signer, from, unsigned))
+	assert.ErrorIs(t, err, ErrSigningRequestClosed)
+
+	pool.mine(from)
+	signing.Track(ctx)
+	mined, err := signing.Get(requestID, from)
+	require.NoError(t, err)
+	assert.Equal(t, SigningStatusMined, mined.Status)
+
+	var events []string
+	for _, record := range audit.Records(from.Hex(), time.Time{}, time.Time{}) {
+		assert.Equal(t, action.ID, record.ActionID)
+		events = append(events, record.Event)
+	}
+	assert.Equal(t, []string{ActionEventProposed, ActionEventConfirmed, ActionEventSubmitted, ActionEventMined}, events)
+}
+
+func TestSigningRequestExpires(t *testing.T) {
+	signing, pool, clock := newTestSigningService()
+	from, signer := newRelayer(t)
+	ctx := context.Background()
+
+	request, err := signing.Prepare(ctx, from, SigningPrompt{Description: "vote"}, TxRequest{To: &signingContract, Gas: 21000})
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now().Add(SigningRequestTTL).Unix(), request.ExpiresAt.Unix())
+
+	clock.Advance(SigningRequestTTL)
+	_, err = signing.Submit(ctx, request.ID, from, signPrepared(t, signer, from, request.Transaction))
+	assert.ErrorIs(t, err, ErrSigningRequestExpired)
+	assert.Empty(t, pool.accepted, "nothing was broadcast")
+
+	expired, err := signing.Get(request.ID, from)
+	require.NoError(t, err)
+	assert.Equal(t, SigningStatusExpired, expired.Status)
+
+	// Finished requests are forgotten after the retention
+	clock.Advance(signingRetention + time.Minute)
+	signing.Track(ctx)
+	_, err = signing.Get(request.ID, from)
+	assert.ErrorIs(t, err, ErrSigningRequestNotFound)
+}
+
+func TestSigningRequestIsBoundToItsAddress(t *testing.T) {
+	signing, pool, _ := newTestSigningService()
+	from, signer := newRelayer(t)
+	other, otherSigner := newRelayer(t)
+	ctx := context.Background()
+
+	request, err := signing.Prepare(ctx, from, SigningPrompt{Description: "stake"}, TxRequest{To: &signingContract, Value: big.NewInt(5), Gas: 21000})
+	require.NoError(t, err)
+
+	// Another caller can neither see nor submit it
+	_, err = signing.Get(request.ID, other)
+	assert.ErrorIs(t, err, ErrSigningForbidden)
+	_, err = signing.Submit(ctx, request.ID, other, signPrepared(t, otherSigner, other, request.Transaction))
+	assert.ErrorIs(t, err, ErrSigningForbidden)
+
+	// The owner can't submit a transaction signed by another key
+	_, err = signing.Submit(ctx, request.ID, from, signPrepared(t, otherSigner, other, request.Transaction))
+	assert.ErrorIs(t, err, ErrSignedTxMismatch)
+
+	// Or a different transaction
+	altered := request.Transaction
+	altered.Value = "500"
+	_, err = signing.Submit(ctx, request.ID, from, signPrepared(t, signer, from, altered))
+	assert.ErrorIs(t, err, ErrSignedTxMismatch)
+	_, err = signing.Submit(ctx, request.ID, from, "0xnothex")
+	assert.ErrorIs(t, err, ErrSignedTxMismatch)
+	assert.Empty(t, pool.accepted)
+
+	// A re-priced signature of the prepared transaction is accepted
+	repriced := request.Transaction
+	repriced.GasPrice = "30000000000"
+	submitted, err := signing.Submit(ctx, request.ID, from, signPrepared(t, signer, from, repriced))
+	require.NoError(t, err)
+	assert.Equal(t, SigningStatusSubmitted, submitted.Status)
+}
@@ -0,0 +1,1018 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const (
+	// SimulatedBlockTime is how often a started simulated chain mines a block,
+	// and the timestamp step between its blocks
+	SimulatedBlockTime = 2 * time.Second
+	// DefaultSimulatedChainID is the chain ID of a simulated chain when no
+	// NETWORK_ID is configured
+	DefaultSimulatedChainID = 1337
+
+	simulatedAccountCount = 5
+	simulatedGasLimit     = 30_000_000
+	// simulatedCallGas is charged on top of the intrinsic gas for every call
+	// executed by a mock contract
+	simulatedCallGas = 50_000
+)
+
+var (
+	// simulatedGasPrice is the base fee of every simulated block, Kaia's fixed
+	// 25 gkei
+	simulatedGasPrice = big.NewInt(25_000_000_000)
+	// simulatedFunding is the KAIA each simulated account starts with
+	simulatedFunding = new(big.Int).Mul(big.NewInt(10_000), big.NewInt(1e18))
+)
+
+// simulatedTokenABI covers the ERC-20 and Uniswap V2 pair calls the mock
+// tokens answer, and the Transfer event they emit
+const simulatedTokenABI = `[
+	{"type":"function","name":"name","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"symbol","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+	{"type":"function","name":"totalSupply","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"token0","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"address"}]},
+	{"type":"function","name":"token1","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"address"}]},
+	{"type":"function","name":"getReserves","stateMutability":"view","inputs":[],"outputs":[
+		{"name":"reserve0","type":"uint112"},
+		{"name":"reserve1","type":"uint112"},
+		{"name":"blockTimestampLast","type":"uint32"}
+	]},
+	{"type":"function","name":"kLast","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"event","name":"Transfer","anonymous":false,"inputs":[
+		{"name":"from","type":"address","indexed":true},
+		{"name":"to","type":"address","indexed":true},
+		{"name":"value","type":"uint256","indexed":false}]}
+]`
+
+// simulatedTokenCalls is the parsed simulatedTokenABI
+var simulatedTokenCalls = mustParseABI(simulatedTokenABI)
+
+// simulatedActionEvents is the parsed actionContractEventsABI the mock
+// ActionContract emits
+var simulatedActionEvents = mustParseABI(actionContractEventsABI)
+
+// SimulatedAccount is a funded account of a simulated chain. Keys are derived
+// from the account's index, so every run has the same accounts.
+type SimulatedAccount struct {
+	Address common.Address
+	Key     *ecdsa.PrivateKey
+}
+
+// SimulatedAccounts returns the accounts funded at genesis. The first one
+// deployed the mock contracts and owns them.
+func SimulatedAccounts() []SimulatedAccount {
+	accounts := make([]SimulatedAccount, simulatedAccountCount)
+	for i := range accounts {
+		key, err := crypto.ToECDSA(crypto.Keccak256([]byte(fmt.Sprintf("kaia-analytics simulated account %d", i))))
+		if err != nil {
+			panic(err)
+		}
+		accounts[i] = SimulatedAccount{Address: crypto.PubkeyToAddress(key.PublicKey), Key: key}
+	}
+	return accounts
+}
+
+// SimulatedContracts are the addresses of the mock contracts deployed at
+// genesis
+type SimulatedContracts struct {
+	AnalyticsRegistry    common.Address
+	ActionContract       common.Address
+	SubscriptionContract common.Address
+	DataContract         common.Address
+	// Stablecoin and WrappedKaia are ERC-20 tokens and Pair is their Uniswap
+	// V2 style pool
+	Stablecoin  common.Address
+	WrappedKaia common.Address
+	Pair        common.Address
+}
+
+// TrackedTokens returns the mock tokens and pool for address summaries
+func (c SimulatedContracts) TrackedTokens() []TrackedToken {
+	return []TrackedToken{
+		{Symbol: "USDT", Address: c.Stablecoin, Decimals: 6},
+		{Symbol: "WKAIA", Address: c.WrappedKaia, Decimals: 18},
+		{Symbol: "KLP", Address: c.Pair, Decimals: 18},
+	}
+}
+
+// simulatedToken is the state of a mock ERC-20 token. A pool has pair set
+// and its balances are LP shares.
+type simulatedToken struct {
+	name     string
+	symbol   string
+	decimals uint8
+	supply   *big.Int
+	balances map[common.Address]*big.Int
+	pair     *simulatedPair
+}
+
+// simulatedPair is the state of a mock Uniswap V2 pair
+type simulatedPair struct {
+	token0, token1     common.Address
+	reserve0, reserve1 *big.Int
+	kLast              *big.Int
+}
+
+// simulatedActionType mirrors an ActionContract action type
+type simulatedActionType struct {
+	gasLimit    int64
+	fee         *big.Int
+	description string
+}
+
+// simulatedSubscription is an address's mock subscription
+type simulatedSubscription struct {
+	tierID  int64
+	endTime uint64
+}
+
+// simulatedLogSub is a SubscribeFilterLogs subscriber
+type simulatedLogSub struct {
+	logs chan []types.Log
+	done chan struct{}
+}
+
+// SimulatedChain is an in-memory chain standing in for a Kaia node, for
+// running the backend without an RPC endpoint. It mines a block of the
+// pending transactions every SimulatedBlockTime once started, funds the
+// accounts of SimulatedAccounts, and answers calls to Go mocks of the
+// project's contracts and of an ERC-20 pool. Blocks, hashes, and addresses
+// follow only from the chain ID, the genesis time, and the transactions sent.
+//
+// There is no EVM: transactions transfer value and call the mocks, each
+// costing the intrinsic gas plus a flat simulatedCallGas, and state is only
+// kept at the head, so reads at older blocks see the latest state.
+type SimulatedChain struct {
+	chainID   *big.Int
+	signer    types.Signer
+	contracts SimulatedContracts
+	logger    *log.Logger
+
+	mu       sync.RWMutex
+	blocks   []*types.Block
+	txs      map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	pending  []*types.Transaction
+	logs     []types.Log
+	balances map[common.Address]*big.Int
+	nonces   map[common.Address]uint64
+	code     map[common.Address][]byte
+	tokens   map[common.Address]*simulatedToken
+
+	tasks         []onchainRegistryTask
+	actionTypes   map[string]simulatedActionType
+	totalActions  int64
+	tiers         []onchainSubscriptionTier
+	subscriptions map[common.Address]simulatedSubscription
+
+	subs    map[int]*simulatedLogSub
+	nextSub int
+}
+
+// NewSimulatedChain creates a chain whose genesis block is at genesisTime,
+// with the accounts funded and the mock contracts deployed
+func NewSimulatedChain(chainID int64, genesisTime time.Time) *SimulatedChain {
+	sc := &SimulatedChain{
+		chainID:       big.NewInt(chainID),
+		signer:        types.LatestSignerForChainID(big.NewInt(chainID)),
+		logger:        log.New(log.Writer(), "[SimulatedChain] ", log.LstdFlags),
+		txs:           make(map[common.Hash]*types.Transaction),
+		receipts:      make(map[common.Hash]*types.Receipt),
+		balances:      make(map[common.Address]*big.Int),
+		nonces:        make(map[common.Address]uint64),
+		code:          make(map[common.Address][]byte),
+		tokens:        make(map[common.Address]*simulatedToken),
+		actionTypes:   make(map[string]simulatedActionType),
+		subscriptions: make(map[common.Address]simulatedSubscription),
+		subs:          make(map[int]*simulatedLogSub),
+	}
+	sc.genesis(uint64(genesisTime.Unix()))
+	return sc
+}
+
+// genesis funds the accounts, deploys the mocks from the first account, and
+// seals block 0
+func (sc *SimulatedChain) genesis(timestamp uint64) {
+	accounts := SimulatedAccounts()
+	for _, account := range accounts {
+		sc.balances[account.Address] = new(big.Int).Set(simulatedFunding)
+	}
+
+	deployer := accounts[0].Address
+	deploy := func(name string) common.Address {
+		address := crypto.CreateAddress(deployer, sc.nonces[deployer])
+		sc.nonces[deployer]++
+		// The Solidity preamble followed by the name, so CodeAt reports a contract
+		sc.code[address] = append([]byte{0x60, 0x80, 0x60, 0x40, 0x52}, name...)
+		return address
+	}
+	sc.contracts = SimulatedContracts{
+		AnalyticsRegistry:    deploy("AnalyticsRegistry"),
+		ActionContract:       deploy("ActionContract"),
+		SubscriptionContract: deploy("SubscriptionContract"),
+		DataContract:         deploy("DataContract"),
+		Stablecoin:           deploy("USDT"),
+		WrappedKaia:          deploy("WKAIA"),
+		Pair:                 deploy("KLP"),
+	}
+
+	// Every account holds both tokens, and the pool prices KAIA at 0.2 USDT
+	units := func(amount int64, decimals int) *big.Int {
+		return new(big.Int).Mul(big.NewInt(amount), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	}
+	stablecoin := &simulatedToken{name: "Tether USD", symbol: "USDT", decimals: 6, balances: make(map[common.Address]*big.Int)}
+	wrapped := &simulatedToken{name: "Wrapped KAIA", symbol: "WKAIA", decimals: 18, balances: make(map[common.Address]*big.Int)}
+	for _, account := range accounts {
+		stablecoin.balances[account.Address] = units(10_000, 6)
+		wrapped.balances[account.Address] = units(1_000, 18)
+	}
+	pair := &simulatedPair{
+		token0:   sc.contracts.Stablecoin,
+		token1:   sc.contracts.WrappedKaia,
+		reserve0: units(1_000_000, 6),
+		reserve1: units(5_000_000, 18),
+	}
+	pair.kLast = new(big.Int).Mul(pair.reserve0, pair.reserve1)
+	stablecoin.balances[sc.contracts.Pair] = new(big.Int).Set(pair.reserve0)
+	wrapped.balances[sc.contracts.Pair] = new(big.Int).Set(pair.reserve1)
+	shares := new(big.Int).Sqrt(pair.kLast)
+	lp := &simulatedToken{name: "Kaia LP", symbol: "KLP", decimals: 18, pair: pair, balances: map[common.Address]*big.Int{
+		// The deployer keeps 1% of the pool; the rest is burnt
+		deployer:         new(big.Int).Div(shares, big.NewInt(100)),
+		common.Address{}: new(big.Int).Sub(shares, new(big.Int).Div(shares, big.NewInt(100))),
+	}}
+	for address, token := range map[common.Address]*simulatedToken{
+		sc.contracts.Stablecoin:  stablecoin,
+		sc.contracts.WrappedKaia: wrapped,
+		sc.contracts.Pair:        lp,
+	} {
+		token.supply = new(big.Int)
+		for _, balance := range token.balances {
+			token.supply.Add(token.supply, balance)
+		}
+		sc.tokens[address] = token
+	}
+
+	// The ActionContract's constructor registers these types
+	ether := func(thousandths int64) *big.Int {
+		return new(big.Int).Mul(big.NewInt(thousandths), big.NewInt(1e15))
+	}
+	sc.actionTypes["stake"] = simulatedActionType{gasLimit: 100000, fee: ether(10), description: "Stake tokens in a protocol"}
+	sc.actionTypes["unstake"] = simulatedActionType{gasLimit: 100000, fee: ether(10), description: "Unstake tokens from a protocol"}
+	sc.actionTypes["vote"] = simulatedActionType{gasLimit: 50000, fee: ether(5), description: "Vote on a governance proposal"}
+	sc.actionTypes["swap"] = simulatedActionType{gasLimit: 150000, fee: ether(20), description: "Swap tokens on a DEX"}
+	sc.actionTypes["yield_farm"] = simulatedActionType{gasLimit: 120000, fee: ether(15), description: "Deposit into yield farming"}
+	sc.actionTypes["withdraw_yield"] = simulatedActionType{gasLimit: 80000, fee: ether(10), description: "Withdraw from yield farming"}
+
+	day := uint64(24 * time.Hour / time.Second)
+	sc.tiers = []onchainSubscriptionTier{
+		{TierId: big.NewInt(1), Name: "Basic", Price: ether(10_000), Duration: new(big.Int).SetUint64(30 * day), IsActive: true, Features: []string{"analytics", "alerts"}},
+		{TierId: big.NewInt(2), Name: "Premium", Price: ether(50_000), Duration: new(big.Int).SetUint64(30 * day), IsActive: true, Features: []string{"analytics", "alerts", "actions", "reports"}},
+	}
+	sc.subscriptions[accounts[0].Address] = simulatedSubscription{tierID: 2, endTime: timestamp + 30*day}
+	sc.subscriptions[accounts[1].Address] = simulatedSubscription{tierID: 1, endTime: timestamp + 30*day}
+
+	hour := uint64(time.Hour / time.Second)
+	task := func(id int64, requester common.Address, taskType, parameters string, registered, completed uint64, result string) onchainRegistryTask {
+		return onchainRegistryTask{
+			TaskId:         big.NewInt(id),
+			Requester:      requester,
+			TaskType:       taskType,
+			Parameters:     parameters,
+			Timestamp:      new(big.Int).SetUint64(registered),
+			IsActive:       completed == 0,
+			CompletionTime: new(big.Int).SetUint64(completed),
+			ResultHash:     result,
+		}
+	}
+	sc.tasks = []onchainRegistryTask{
+		task(1, accounts[0].Address, "yield_analysis", `{"protocol":"all"}`, timestamp-3*hour, timestamp-2*hour, "0x"+strings.Repeat("1", 64)),
+		task(2, accounts[1].Address, "trading_suggestion", `{"pair":"KAIA/USDT"}`, timestamp-2*hour, timestamp-hour, "0x"+strings.Repeat("2", 64)),
+		task(3, accounts[2].Address, "portfolio_report", `{"period":"7d"}`, timestamp-hour, 0, ""),
+	}
+
+	header := &types.Header{
+		Number:     new(big.Int),
+		Time:       timestamp,
+		GasLimit:   simulatedGasLimit,
+		BaseFee:    new(big.Int).Set(simulatedGasPrice),
+		Difficulty: new(big.Int),
+		Extra:      []byte("kaia-analytics simulated chain"),
+	}
+	sc.blocks = []*types.Block{types.NewBlock(header, nil, nil, nil, trie.NewStackTrie(nil))}
+}
+
+// Contracts returns the addresses of the mock contracts
+func (sc *SimulatedChain) Contracts() SimulatedContracts {
+	return sc.contracts
+}
+
+// Start mines a block every SimulatedBlockTime until ctx is cancelled
+func (sc *SimulatedChain) Start(ctx context.Context) {
+	for i, account := range SimulatedAccounts() {
+		sc.logger.Printf("Account %d: %s (key 0x%x)", i, account.Address.Hex(), crypto.FromECDSA(account.Key))
+	}
+	go func() {
+		ticker := time.NewTicker(SimulatedBlockTime)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sc.Commit()
+			}
+		}
+	}()
+}
+
+// Commit mines the pending transactions into a new block, SimulatedBlockTime
+// after the head
+func (sc *SimulatedChain) Commit() *types.Block {
+	sc.mu.Lock()
+	parent := sc.blocks[len(sc.blocks)-1]
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		Time:       parent.Time() + uint64(SimulatedBlockTime/time.Second),
+		GasLimit:   simulatedGasLimit,
+		BaseFee:    new(big.Int).Set(simulatedGasPrice),
+		Difficulty: new(big.Int),
+		Extra:      []byte("kaia-analytics simulated chain"),
+	}
+
+	txs := sc.pending
+	sc.pending = nil
+	receipts := make([]*types.Receipt, len(txs))
+	for i, tx := range txs {
+		receipts[i] = sc.apply(header, tx)
+		header.GasUsed += receipts[i].GasUsed
+		receipts[i].CumulativeGasUsed = header.GasUsed
+	}
+	block := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+
+	var mined []types.Log
+	for i, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.Number()
+		receipt.TransactionIndex = uint(i)
+		for _, l := range receipt.Logs {
+			l.BlockNumber = block.NumberU64()
+			l.BlockHash = block.Hash()
+			l.TxHash = receipt.TxHash
+			l.TxIndex = uint(i)
+			l.Index = uint(len(mined))
+			mined = append(mined, *l)
+		}
+		sc.receipts[receipt.TxHash] = receipt
+	}
+	sc.blocks = append(sc.blocks, block)
+	sc.logs = append(sc.logs, mined...)
+	subs := make([]*simulatedLogSub, 0, len(sc.subs))
+	for _, sub := range sc.subs {
+		subs = append(subs, sub)
+	}
+	sc.mu.Unlock()
+
+	if len(mined) > 0 {
+		for _, sub := range subs {
+			select {
+			case sub.logs <- mined:
+			case <-sub.done:
+			}
+		}
+	}
+	return block
+}
+
+// apply executes a pending transaction. Its sender was checked when it was
+// sent. The chain is locked by the caller.
+func (sc *SimulatedChain) apply(header *types.Header, tx *types.Transaction) *types.Receipt {
+	from, _ := types.Sender(sc.signer, tx)
+	price := new(big.Int).Add(header.BaseFee, tx.EffectiveGasTipValue(header.BaseFee))
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		TxHash:            tx.Hash(),
+		EffectiveGasPrice: price,
+		Status:            types.ReceiptStatusSuccessful,
+	}
+
+	receipt.GasUsed = sc.gas(*tx.To(), tx.Data())
+	if receipt.GasUsed > tx.Gas() {
+		receipt.GasUsed = tx.Gas()
+		receipt.Status = types.ReceiptStatusFailed
+	}
+	sc.nonces[from]++
+	sc.debit(from, new(big.Int).Mul(price, new(big.Int).SetUint64(receipt.GasUsed)))
+
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		logs, err := sc.transact(from, *tx.To(), tx.Value(), tx.Data(), header.Time)
+		if err != nil {
+			receipt.Status = types.ReceiptStatusFailed
+		}
+		receipt.Logs = logs
+	}
+	if receipt.Logs == nil {
+		receipt.Logs = []*types.Log{}
+	}
+	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+	return receipt
+}
+
+// transact moves the value and runs the call when the recipient is a mock.
+// Nothing changes when the call reverts.
+func (sc *SimulatedChain) transact(from, to common.Address, value *big.Int, data []byte, timestamp uint64) ([]*types.Log, error) {
+	if sc.balance(from).Cmp(value) < 0 {
+		return nil, errors.New("insufficient funds for transfer")
+	}
+	var logs []*types.Log
+	if _, ok := sc.code[to]; ok {
+		var err error
+		if _, logs, err = sc.execute(from, to, value, data, timestamp, true); err != nil {
+			return nil, err
+		}
+	}
+	sc.debit(from, value)
+	sc.balances[to] = new(big.Int).Add(sc.balance(to), value)
+	return logs, nil
+}
+
+func (sc *SimulatedChain) balance(account common.Address) *big.Int {
+	if balance, ok := sc.balances[account]; ok {
+		return balance
+	}
+	return new(big.Int)
+}
+
+func (sc *SimulatedChain) debit(account common.Address, amount *big.Int) {
+	sc.balances[account] = new(big.Int).Sub(sc.balance(account), amount)
+}
+
+// gas is what a transaction with the data costs: the intrinsic gas, plus
+// simulatedCallGas when it calls a mock
+func (sc *SimulatedChain) gas(to common.Address, data []byte) uint64 {
+	gas := uint64(21_000)
+	for _, b := range data {
+		if b == 0 {
+			gas += 4
+		} else {
+			gas += 16
+		}
+	}
+	if _, ok := sc.code[to]; ok {
+		gas += simulatedCallGas
+	}
+	return gas
+}
+
+// execute runs a call against the mock at the address. State only changes
+// when commit is set and the call succeeds. The chain is locked by the
+// caller, for writing when commit is set.
+func (sc *SimulatedChain) execute(from, to common.Address, value *big.Int, data []byte, timestamp uint64, commit bool) ([]byte, []*types.Log, error) {
+	if len(data) < 4 {
+		return nil, nil, simulatedRevert("no fallback function")
+	}
+	if token, ok := sc.tokens[to]; ok {
+		return sc.callToken(to, token, from, data, commit)
+	}
+	switch to {
+	case sc.contracts.AnalyticsRegistry:
+		out, err := sc.callRegistry(data)
+		return out, nil, err
+	case sc.contracts.ActionContract:
+		return sc.callActions(from, value, data, timestamp, commit)
+	case sc.contracts.SubscriptionContract:
+		out, err := sc.callSubscriptions(data)
+		return out, nil, err
+	}
+	return nil, nil, simulatedRevert("function not found")
+}
+
+// simulatedRevert is the error of a reverted mock call, worded like a node's
+func simulatedRevert(reason string) error {
+	return fmt.Errorf("execution reverted: %s", reason)
+}
+
+func (sc *SimulatedChain) callToken(address common.Address, token *simulatedToken, from common.Address, data []byte, commit bool) ([]byte, []*types.Log, error) {
+	method, err := simulatedTokenCalls.MethodById(data[:4])
+	if err != nil {
+		return nil, nil, simulatedRevert("function not found")
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, nil, simulatedRevert("invalid arguments")
+	}
+
+	balanceOf := func(account common.Address) *big.Int {
+		if balance, ok := token.balances[account]; ok {
+			return balance
+		}
+		return new(big.Int)
+	}
+	var out []byte
+	switch method.Name {
+	case "name":
+		out, err = method.Outputs.Pack(token.name)
+	case "symbol":
+		out, err = method.Outputs.Pack(token.symbol)
+	case "decimals":
+		out, err = method.Outputs.Pack(token.decimals)
+	case "totalSupply":
+		out, err = method.Outputs.Pack(token.supply)
+	case "balanceOf":
+		out, err = method.Outputs.Pack(balanceOf(args[0].(common.Address)))
+	case "transfer":
+		to, amount := args[0].(common.Address), args[1].(*big.Int)
+		if balanceOf(from).Cmp(amount) < 0 {
+			return nil, nil, simulatedRevert("ERC20: transfer amount exceeds balance")
+		}
+		if out, err = method.Outputs.Pack(true); err != nil || !commit {
+			return out, nil, err
+		}
+		token.balances[from] = new(big.Int).Sub(balanceOf(from), amount)
+		token.balances[to] = new(big.Int).Add(balanceOf(to), amount)
+		transfer := simulatedTokenCalls.Events["Transfer"]
+		logData, err := transfer.Inputs.NonIndexed().Pack(amount)
+		if err != nil {
+			return nil, nil, err
+		}
+		return out, []*types.Log{{
+			Address: address,
+			Topics:  []common.Hash{transfer.ID, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+			Data:    logData,
+		}}, nil
+	default:
+		if token.pair == nil {
+			return nil, nil, simulatedRevert("function not found")
+		}
+		switch method.Name {
+		case "token0":
+			out, err = method.Outputs.Pack(token.pair.token0)
+		case "token1":
+			out, err = method.Outputs.Pack(token.pair.token1)
+		case "getReserves":
+			out, err = method.Outputs.Pack(token.pair.reserve0, token.pair.reserve1, uint32(sc.blocks[0].Time()))
+		case "kLast":
+			out, err = method.Outputs.Pack(token.pair.kLast)
+		}
+	}
+	return out, nil, err
+}
+
+func (sc *SimulatedChain) callRegistry(data []byte) ([]byte, error) {
+	method, err := analyticsRegistryCalls.MethodById(data[:4])
+	if err != nil {
+		return nil, simulatedRevert("function not found")
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, simulatedRevert("invalid arguments")
+	}
+
+	switch method.Name {
+	case "totalTasks":
+		return method.Outputs.Pack(big.NewInt(int64(len(sc.tasks))))
+	default:
+		id := args[0].(*big.Int)
+		if id.Sign() <= 0 || id.Cmp(big.NewInt(int64(len(sc.tasks)))) > 0 {
+			return nil, simulatedRevert("TaskNotFound()")
+		}
+		return method.Outputs.Pack(sc.tasks[id.Int64()-1])
+	}
+}
+
+func (sc *SimulatedChain) callSubscriptions(data []byte) ([]byte, error) {
+	method, err := subscriptionContractABI.MethodById(data[:4])
+	if err != nil {
+		return nil, simulatedRevert("function not found")
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, simulatedRevert("invalid arguments")
+	}
+
+	switch method.Name {
+	case "totalTiers":
+		return method.Outputs.Pack(big.NewInt(int64(len(sc.tiers))))
+	case "getSubscriptionTier":
+		id := args[0].(*big.Int)
+		if id.Sign() <= 0 || id.Cmp(big.NewInt(int64(len(sc.tiers)))) > 0 {
+			return nil, simulatedRevert("TierNotFound()")
+		}
+		return method.Outputs.Pack(sc.tiers[id.Int64()-1])
+	default:
+		subscription, ok := sc.subscriptions[args[0].(common.Address)]
+		active := ok && subscription.endTime > sc.blocks[len(sc.blocks)-1].Time()
+		return method.Outputs.Pack(active, big.NewInt(subscription.tierID), new(big.Int).SetUint64(subscription.endTime))
+	}
+}
+
+// callActions answers the ActionContract. A mock executor runs requested
+// actions right away, so every request is followed by its execution.
+func (sc *SimulatedChain) callActions(from common.Address, value *big.Int, data []byte, timestamp uint64, commit bool) ([]byte, []*types.Log, error) {
+	method, err := actionContractCalls.MethodById(data[:4])
+	if err != nil {
+		return nil, nil, simulatedRevert("function not found")
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, nil, simulatedRevert("invalid arguments")
+	}
+
+	if method.Name == "actionTypes" {
+		name := args[0].(string)
+		actionType, ok := sc.actionTypes[name]
+		if !ok {
+			out, err := method.Outputs.Pack("", false, new(big.Int), new(big.Int), "")
+			return out, nil, err
+		}
+		out, err := method.Outputs.Pack(name, true, big.NewInt(actionType.gasLimit), actionType.fee, actionType.description)
+		return out, nil, err
+	}
+
+	name, parameters := args[0].(string), args[1].(string)
+	actionType, ok := sc.actionTypes[name]
+	if !ok {
+		return nil, nil, simulatedRevert("ActionTypeNotSupported()")
+	}
+	if value.Cmp(actionType.fee) < 0 {
+		return nil, nil, simulatedRevert("InsufficientFee()")
+	}
+	actionID := big.NewInt(sc.totalActions + 1)
+	out, err := method.Outputs.Pack(actionID)
+	if err != nil || !commit {
+		return out, nil, err
+	}
+
+	var logs []*types.Log
+	emit := func(event string, values ...interface{}) error {
+		abiEvent := simulatedActionEvents.Events[event]
+		logData, err := abiEvent.Inputs.NonIndexed().Pack(values...)
+		if err != nil {
+			return err
+		}
+		logs = append(logs, &types.Log{
+			Address: sc.contracts.ActionContract,
+			Topics:  []common.Hash{abiEvent.ID, common.BigToHash(actionID), common.BytesToHash(from.Bytes())},
+			Data:    logData,
+		})
+		return nil
+	}
+	if err := emit("ActionRequested", name, parameters, new(big.Int).SetUint64(timestamp)); err != nil {
+		return nil, nil, err
+	}
+	if err := emit("ActionExecuted", name, true, "simulated", big.NewInt(actionType.gasLimit)); err != nil {
+		return nil, nil, err
+	}
+	sc.totalActions++
+	return out, logs, nil
+}
+
+// head returns the latest block; the chain is locked by the caller
+func (sc *SimulatedChain) head() *types.Block {
+	return sc.blocks[len(sc.blocks)-1]
+}
+
+// block returns the block at the number, or the head for nil and the
+// negative numbers standing for latest and pending
+func (sc *SimulatedChain) block(number *big.Int) (*types.Block, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	if number == nil || number.Sign() < 0 {
+		return sc.head(), nil
+	}
+	if !number.IsUint64() || number.Uint64() >= uint64(len(sc.blocks)) {
+		return nil, ethereum.NotFound
+	}
+	return sc.blocks[number.Uint64()], nil
+}
+
+// BlockNumber returns the number of the head
+func (sc *SimulatedChain) BlockNumber(ctx context.Context) (uint64, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.head().NumberU64(), nil
+}
+
+// BlockByNumber returns a block, or the head for a nil number
+func (sc *SimulatedChain) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return sc.block(number)
+}
+
+// HeaderByNumber returns a block header, or the head's for a nil number
+func (sc *SimulatedChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	block, err := sc.block(number)
+	if err != nil {
+		return nil, err
+	}
+	return block.Header(), nil
+}
+
+// HeaderByHash returns the header of a mined block
+func (sc *SimulatedChain) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	for _, block := range sc.blocks {
+		if block.Hash() == hash {
+			return block.Header(), nil
+		}
+	}
+	return nil, ethereum.NotFound
+}
+
+// TransactionByHash returns a sent transaction and whether it is pending
+func (sc *SimulatedChain) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	tx, ok := sc.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	_, mined := sc.receipts[hash]
+	return tx, !mined, nil
+}
+
+// TransactionReceipt returns the receipt of a mined transaction
+func (sc *SimulatedChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	receipt, ok := sc.receipts[txHash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+// BalanceAt returns the wei balance of an account at the head
+func (sc *SimulatedChain) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return new(big.Int).Set(sc.balance(account)), nil
+}
+
+// CodeAt returns placeholder code for the mocks and nothing for accounts
+func (sc *SimulatedChain) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.code[account], nil
+}
+
+// CallContract runs a call against the head without changing state. Calls to
+// accounts without code return nothing, as on a node.
+func (sc *SimulatedChain) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if msg.To == nil {
+		return nil, errors.New("contract creation isn't simulated")
+	}
+	value := msg.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if _, ok := sc.code[*msg.To]; !ok {
+		return nil, nil
+	}
+	out, _, err := sc.execute(msg.From, *msg.To, value, msg.Data, sc.head().Time(), false)
+	return out, err
+}
+
+// SuggestGasPrice returns the fixed base fee
+func (sc *SimulatedChain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(simulatedGasPrice), nil
+}
+
+// NetworkID returns the chain ID
+func (sc *SimulatedChain) NetworkID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(sc.chainID), nil
+}
+
+// ChainID returns the chain ID
+func (sc *SimulatedChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(sc.chainID), nil
+}
+
+// SyncProgress reports the chain as synced
+func (sc *SimulatedChain) SyncProgress(ctx context.Context) (*ethereum.SyncProgress, error) {
+	return nil, nil
+}
+
+// FilterLogs returns the mined logs matching the query
+func (sc *SimulatedChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	from, to := uint64(0), sc.head().NumberU64()
+	switch {
+	case query.BlockHash != nil:
+		found := false
+		for _, block := range sc.blocks {
+			if block.Hash() == *query.BlockHash {
+				from, to, found = block.NumberU64(), block.NumberU64(), true
+				break
+			}
+		}
+		if !found {
+			return nil, ethereum.NotFound
+		}
+	default:
+		if query.FromBlock != nil && query.FromBlock.Sign() >= 0 {
+			from = query.FromBlock.Uint64()
+		}
+		if query.ToBlock != nil && query.ToBlock.Sign() >= 0 && query.ToBlock.Uint64() < to {
+			to = query.ToBlock.Uint64()
+		}
+	}
+
+	var logs []types.Log
+	for _, l := range sc.logs {
+		if l.BlockNumber >= from && l.BlockNumber <= to && logMatches(query, l) {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+// SubscribeFilterLogs streams the logs matching the query as blocks are mined
+func (sc *SimulatedChain) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	sub := &simulatedLogSub{logs: make(chan []types.Log), done: make(chan struct{})}
+	sc.mu.Lock()
+	id := sc.nextSub
+	sc.nextSub++
+	sc.subs[id] = sub
+	sc.mu.Unlock()
+
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer func() {
+			sc.mu.Lock()
+			delete(sc.subs, id)
+			sc.mu.Unlock()
+			close(sub.done)
+		}()
+		for {
+			select {
+			case <-quit:
+				return nil
+			case logs := <-sub.logs:
+				for _, l := range logs {
+					if !logMatches(query, l) {
+						continue
+					}
+					select {
+					case ch <- l:
+					case <-quit:
+						return nil
+					}
+				}
+			}
+		}
+	}), nil
+}
+
+// logMatches reports whether the log is from one of the query's addresses
+// and has its topics, an empty position matching any topic
+func logMatches(query ethereum.FilterQuery, l types.Log) bool {
+	if len(query.Addresses) > 0 {
+		found := false
+		for _, address := range query.Addresses {
+			if address == l.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(query.Topics) > len(l.Topics) {
+		return false
+	}
+	for i, topics := range query.Topics {
+		if len(topics) == 0 {
+			continue
+		}
+		found := false
+		for _, topic := range topics {
+			if topic == l.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// PendingNonceAt returns the next nonce of an account, counting pending
+// transactions
+func (sc *SimulatedChain) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.pendingNonce(account), nil
+}
+
+// pendingNonce is PendingNonceAt with the chain locked by the caller
+func (sc *SimulatedChain) pendingNonce(account common.Address) uint64 {
+	nonce := sc.nonces[account]
+	for _, tx := range sc.pending {
+		if from, _ := types.Sender(sc.signer, tx); from == account {
+			nonce++
+		}
+	}
+	return nonce
+}
+
+// EstimateGas returns what the call would cost, or why it would revert
+func (sc *SimulatedChain) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error) {
+	if msg.To == nil {
+		return 0, errors.New("contract creation isn't simulated")
+	}
+	value := msg.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if sc.balance(msg.From).Cmp(value) < 0 {
+		return 0, errors.New("insufficient funds for transfer")
+	}
+	if _, ok := sc.code[*msg.To]; ok {
+		if _, _, err := sc.execute(msg.From, *msg.To, value, msg.Data, sc.head().Time(), false); err != nil {
+			return 0, err
+		}
+	}
+	return sc.gas(*msg.To, msg.Data), nil
+}
+
+// SendTransaction adds a signed transaction to the next block. Transactions
+// must be signed for the chain, carry the sender's next nonce, and be
+// affordable; ones that fail when mined are included as reverted.
+func (sc *SimulatedChain) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	from, err := types.Sender(sc.signer, tx)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	if tx.To() == nil {
+		return errors.New("contract creation isn't simulated")
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if _, ok := sc.txs[tx.Hash()]; ok {
+		return errors.New("already known")
+	}
+	if nonce := sc.pendingNonce(from); tx.Nonce() != nonce {
+		return fmt.Errorf("invalid nonce: have %d, want %d", tx.Nonce(), nonce)
+	}
+	if tx.GasFeeCap().Cmp(simulatedGasPrice) < 0 {
+		return fmt.Errorf("transaction underpriced: gas price %s below the base fee %s", tx.GasFeeCap(), simulatedGasPrice)
+	}
+	if intrinsic := sc.gas(common.Address{}, tx.Data()); tx.Gas() < intrinsic {
+		return fmt.Errorf("intrinsic gas too low: have %d, want %d", tx.Gas(), intrinsic)
+	}
+	if sc.balance(from).Cmp(tx.Cost()) < 0 {
+		return fmt.Errorf("insufficient funds for gas * price + value: address %s", from.Hex())
+	}
+
+	sc.txs[tx.Hash()] = tx
+	sc.pending = append(sc.pending, tx)
+	return nil
+}
+
+// Close does nothing; blocks stop being mined when Start's context ends
+func (sc *SimulatedChain) Close() {}
+
+var (
+	_ ChainClient    = (*SimulatedChain)(nil)
+	_ SigningBackend = (*SimulatedChain)(nil)
+)
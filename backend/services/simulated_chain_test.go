@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var simulatedGenesis = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSimulatedChainMinesDeterministicBlocks(t *testing.T) {
+	ctx := context.Background()
+	first := NewSimulatedChain(DefaultSimulatedChainID, simulatedGenesis)
+	second := NewSimulatedChain(DefaultSimulatedChainID, simulatedGenesis)
+	assert.Equal(t, first.Contracts(), second.Contracts())
+
+	accounts := SimulatedAccounts()
+	transfer := func(chain *SimulatedChain) common.Hash {
+		nonce, err := chain.PendingNonceAt(ctx, accounts[1].Address)
+		require.NoError(t, err)
+		tx, err := types.SignTx(types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			To:       &accounts[2].Address,
+			Value:    big.NewInt(1e18),
+			Gas:      21000,
+			GasPrice: simulatedGasPrice,
+		}), types.LatestSignerForChainID(big.NewInt(DefaultSimulatedChainID)), accounts[1].Key)
+		require.NoError(t, err)
+		require.NoError(t, chain.SendTransaction(ctx, tx))
+		assert.Error(t, chain.SendTransaction(ctx, tx), "a transaction is only accepted once")
+		chain.Commit()
+		return tx.Hash()
+	}
+	hash := transfer(first)
+	assert.Equal(t, hash, transfer(second))
+
+	head, err := first.BlockByNumber(ctx, nil)
+	require.NoError(t, err)
+	other, err := second.BlockByNumber(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), head.NumberU64())
+	assert.Equal(t, head.Hash(), other.Hash())
+	assert.Equal(t, uint64(simulatedGenesis.Add(SimulatedBlockTime).Unix()), head.Time())
+	require.Len(t, head.Transactions(), 1)
+	_, err = first.BlockByNumber(ctx, big.NewInt(2))
+	assert.ErrorIs(t, err, ethereum.NotFound)
+
+	receipt, err := first.TransactionReceipt(ctx, hash)
+	require.NoError(t, err)
+	assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	assert.Equal(t, head.Hash(), receipt.BlockHash)
+
+	fee := new(big.Int).Mul(simulatedGasPrice, big.NewInt(21000))
+	sender, err := first.BalanceAt(ctx, accounts[1].Address, nil)
+	require.NoError(t, err)
+	assert.Equal(t, new(big.Int).Sub(new(big.Int).Sub(simulatedFunding, big.NewInt(1e18)), fee), sender)
+	recipient, err := first.BalanceAt(ctx, accounts[2].Address, nil)
+	require.NoError(t, err)
+	assert.Equal(t, new(big.Int).Add(simulatedFunding, big.NewInt(1e18)), recipient)
+}
+
+func TestSimulatedChainServesTheMockContracts(t *testing.T) {
+	ctx := context.Background()
+	chain := NewSimulatedChain(DefaultSimulatedChainID, simulatedGenesis)
+	contracts := chain.Contracts()
+	owner := SimulatedAccounts()[0].Address
+
+	code, err := chain.CodeAt(ctx, contracts.DataContract, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, code)
+
+	tasks := NewRegistryTasks(NewChainRegistryTaskReader(chain, contracts.AnalyticsRegistry))
+	listed, total, err := tasks.List(ctx, RegistryTaskPending, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "portfolio_report", listed[0].TaskType)
+
+	subscriptions := NewChainSubscriptionReader(chain, contracts.SubscriptionContract)
+	tiers, err := subscriptions.Tiers(ctx)
+	require.NoError(t, err)
+	require.Len(t, tiers, 2)
+	assert.Equal(t, "Premium", tiers[1].Name)
+	status, err := subscriptions.Status(ctx, owner)
+	require.NoError(t, err)
+	assert.True(t, status.Active)
+	assert.Equal(t, uint64(2), status.TierID)
+
+	prices := fakePrices{"USDT": 1, "WKAIA": 0.2}
+	tokens := NewERC20BalanceReader(chain, contracts.TrackedTokens(), prices)
+	tokens.SetLiquidityPools(NewLiquidityPoolReader(chain, contracts.TrackedTokens(), prices))
+	holdings, err := tokens.TokenBalances(ctx, owner)
+	require.NoError(t, err)
+	require.Len(t, holdings, 3)
+	assert.Equal(t, 10_000.0, holdings[0].Balance)
+	assert.Equal(t, 1_000.0, holdings[1].Balance)
+	require.NotNil(t, holdings[2].LPPosition)
+	assert.InDelta(t, 20_000, holdings[2].ValueUSD, 1, "1% of a pool of 1M USDT and 5M KAIA at 0.2")
+}
+
+func TestSimulatedChainRunsSignedActions(t *testing.T) {
+	ctx := context.Background()
+	chain := NewSimulatedChain(DefaultSimulatedChainID, simulatedGenesis)
+	contracts := chain.Contracts()
+	user := SimulatedAccounts()[3]
+
+	logs := make(chan types.Log, 4)
+	sub, err := chain.SubscribeFilterLogs(ctx, ethereum.FilterQuery{Addresses: []common.Address{contracts.ActionContract}}, logs)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	signing := NewSigningService(chain, big.NewInt(DefaultSimulatedChainID))
+	request, err := NewActionRequestBuilder(chain, contracts.ActionContract).Build(ctx, "stake", map[string]interface{}{"amount": "5"})
+	require.NoError(t, err)
+	assert.Equal(t, big.NewInt(1e16), request.Value, "the stake fee")
+	_, err = NewActionRequestBuilder(chain, contracts.ActionContract).Build(ctx, "bridge", nil)
+	assert.ErrorContains(t, err, "isn't enabled")
+
+	prepared, err := signing.Prepare(ctx, user.Address, SigningPrompt{Description: "Stake 5 KAIA"}, request)
+	require.NoError(t, err)
+	signer := func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		return types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(DefaultSimulatedChainID)), user.Key)
+	}
+	submitted, err := signing.Submit(ctx, prepared.ID, user.Address, signPrepared(t, signer, user.Address, prepared.Transaction))
+	require.NoError(t, err)
+
+	tx, pending, err := chain.TransactionByHash(ctx, common.HexToHash(submitted.TxHash))
+	require.NoError(t, err)
+	assert.True(t, pending)
+	chain.Commit()
+	signing.Track(ctx)
+	mined, err := signing.Get(prepared.ID, user.Address)
+	require.NoError(t, err)
+	assert.Equal(t, SigningStatusMined, mined.Status)
+
+	decoder := NewActionContractDecoder()
+	for _, name := range []string{"ActionRequested", "ActionExecuted"} {
+		select {
+		case l := <-logs:
+			assert.Equal(t, tx.Hash(), l.TxHash)
+			decoded, event, err := decoder.Decode(l)
+			require.NoError(t, err)
+			assert.Equal(t, name, decoded)
+			if requested, ok := event.(ActionRequested); ok {
+				assert.Equal(t, user.Address, requested.User)
+				assert.Equal(t, "stake", requested.ActionType)
+				assert.Equal(t, int64(1), requested.ActionId.Int64())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s log streamed", name)
+		}
+	}
+	filtered, err := chain.FilterLogs(ctx, ethereum.FilterQuery{Addresses: []common.Address{contracts.ActionContract}})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 2)
+
+	// Requests paying less than the fee revert when mined
+	nonce, err := chain.PendingNonceAt(ctx, user.Address)
+	require.NoError(t, err)
+	underpaid, err := signer(user.Address, types.NewTx(&types.LegacyTx{
+		Nonce: nonce, To: request.To, Value: big.NewInt(1), Data: request.Data, Gas: 200000, GasPrice: simulatedGasPrice,
+	}))
+	require.NoError(t, err)
+	require.NoError(t, chain.SendTransaction(ctx, underpaid))
+	chain.Commit()
+	receipt, err := chain.TransactionReceipt(ctx, underpaid.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, types.ReceiptStatusFailed, receipt.Status)
+}
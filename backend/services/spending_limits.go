@@ -0,0 +1,386 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDailySpendingLimitUSD is the value of actions a user can confirm
+	// per UTC day without a limit of their own
+	DefaultDailySpendingLimitUSD = 10000
+	// DefaultActionSpendingLimitUSD is the value of one action a user can
+	// confirm without a limit of their own
+	DefaultActionSpendingLimitUSD = 5000
+	// SpendingLimitIncreaseDelay is how long a user's own limit increase
+	// waits before it applies. Decreases apply at once.
+	SpendingLimitIncreaseDelay = 24 * time.Hour
+
+	// spendingDayLayout keys the day spending is counted in
+	spendingDayLayout = "2006-01-02"
+)
+
+// ErrSpendingLimitExceeded is returned for actions worth more than the
+// user's limits allow
+var ErrSpendingLimitExceeded = errors.New("spending limit exceeded")
+
+// SpendingLimits cap the USD value of the actions a user confirms
+type SpendingLimits struct {
+	DailyUSD     float64 `json:"daily_usd"`
+	PerActionUSD float64 `json:"per_action_usd"`
+}
+
+// Validate checks both limits are positive and the per-action limit fits in
+// the daily one
+func (l SpendingLimits) Validate() error {
+	if l.DailyUSD <= 0 || l.PerActionUSD <= 0 {
+		return fmt.Errorf("daily_usd and per_action_usd must be above 0, got %g and %g", l.DailyUSD, l.PerActionUSD)
+	}
+	if l.PerActionUSD > l.DailyUSD {
+		return fmt.Errorf("per_action_usd can't be above daily_usd, got %g and %g", l.PerActionUSD, l.DailyUSD)
+	}
+	return nil
+}
+
+// PendingSpendingLimits are raised limits waiting out SpendingLimitIncreaseDelay
+type PendingSpendingLimits struct {
+	Limits      SpendingLimits `json:"limits"`
+	RequestedAt time.Time      `json:"requested_at"`
+	EffectiveAt time.Time      `json:"effective_at"`
+}
+
+// SpendingLimitOverride records an admin setting a user's limits, bypassing
+// the increase delay
+type SpendingLimitOverride struct {
+	Admin  string         `json:"admin"`
+	Reason string         `json:"reason"`
+	From   SpendingLimits `json:"from"`
+	To     SpendingLimits `json:"to"`
+	At     time.Time      `json:"at"`
+}
+
+// SpendingAccount is what a user has spent today and the limits they set.
+// Limits is nil while the defaults apply. Overrides only grow, until the
+// user erases their data.
+type SpendingAccount struct {
+	UserID    string                  `json:"user_id"`
+	Limits    *SpendingLimits         `json:"limits,omitempty"`
+	Pending   *PendingSpendingLimits  `json:"pending,omitempty"`
+	Day       string                  `json:"day,omitempty"`
+	SpentUSD  float64                 `json:"spent_usd"`
+	Overrides []SpendingLimitOverride `json:"overrides,omitempty"`
+}
+
+// SpendingStore keeps spending accounts where every instance reads them, so
+// limits hold across restarts and instances. Load returns an empty account
+// for a user without one.
+type SpendingStore interface {
+	Load(ctx context.Context, userID string) (SpendingAccount, error)
+	Save(ctx context.Context, account SpendingAccount) error
+}
+
+// MemorySpendingStore keeps spending accounts in the process, for a single
+// instance
+type MemorySpendingStore struct {
+	mu       sync.Mutex
+	accounts map[string]SpendingAccount
+}
+
+// NewMemorySpendingStore creates an empty in-process store
+func NewMemorySpendingStore() *MemorySpendingStore {
+	return &MemorySpendingStore{accounts: make(map[string]SpendingAccount)}
+}
+
+// Load returns the user's account
+func (s *MemorySpendingStore) Load(ctx context.Context, userID string) (SpendingAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[userID]
+	if !ok {
+		return SpendingAccount{UserID: userID}, nil
+	}
+	account.Overrides = append([]SpendingLimitOverride(nil), account.Overrides...)
+	return account, nil
+}
+
+// Save replaces the user's account
+func (s *MemorySpendingStore) Save(ctx context.Context, account SpendingAccount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts[account.UserID] = account
+	return nil
+}
+
+// SpendingLimitError is an action refused by a spending limit. Limit is
+// "per_action" or "daily".
+type SpendingLimitError struct {
+	Limit    string
+	LimitUSD float64
+	ValueUSD float64
+	SpentUSD float64
+}
+
+func (e *SpendingLimitError) Error() string {
+	var numbers NumberFormat
+	if e.Limit == "per_action" {
+		return fmt.Sprintf("%v: the action is worth $%s, above the per-action limit of $%s",
+			ErrSpendingLimitExceeded, numbers.Decimal(e.ValueUSD, 2), numbers.Decimal(e.LimitUSD, 2))
+	}
+	return fmt.Sprintf("%v: the action is worth $%s and $%s was spent today, above the daily limit of $%s",
+		ErrSpendingLimitExceeded, numbers.Decimal(e.ValueUSD, 2), numbers.Decimal(e.SpentUSD, 2), numbers.Decimal(e.LimitUSD, 2))
+}
+
+func (e *SpendingLimitError) Unwrap() error {
+	return ErrSpendingLimitExceeded
+}
+
+// SpendingStatus is a user's limits in effect and what they have left today
+type SpendingStatus struct {
+	Limits            SpendingLimits          `json:"limits"`
+	Default           bool                    `json:"default"`
+	Pending           *PendingSpendingLimits  `json:"pending,omitempty"`
+	SpentTodayUSD     float64                 `json:"spent_today_usd"`
+	RemainingTodayUSD float64                 `json:"remaining_today_usd"`
+	ResetsAt          time.Time               `json:"resets_at"`
+	Overrides         []SpendingLimitOverride `json:"overrides,omitempty"`
+}
+
+// SpendingLimiter caps the USD value of the actions each user confirms, per
+// action and per UTC day. Actions are priced and counted when confirmed,
+// whether the relayer sends them or the user's wallet signs them, and keep
+// counting when later cancelled. A limit that can't be checked, because the
+// action can't be priced or the store can't be read, refuses the action.
+type SpendingLimiter struct {
+	store    SpendingStore
+	defaults SpendingLimits
+	prices   PriceSource
+	logger   *log.Logger
+
+	// mu serializes reading, checking and saving accounts, so two actions
+	// confirmed at once can't both fit under the same remaining limit
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewSpendingLimiter creates a limiter keeping accounts in the store. Users
+// who haven't set limits get the defaults; actions are priced from prices.
+func NewSpendingLimiter(store SpendingStore, defaults SpendingLimits, prices PriceSource) *SpendingLimiter {
+	return &SpendingLimiter{
+		store:    store,
+		defaults: defaults,
+		prices:   prices,
+		logger:   log.New(log.Writer(), "[SpendingLimiter] ", log.LstdFlags),
+		now:      utcNow,
+	}
+}
+
+// ActionValueUSD prices an action from its amount and token parameters.
+// Actions that move no tokens, such as votes, are worth nothing.
+func (sl *SpendingLimiter) ActionValueUSD(ctx context.Context, parameters map[string]interface{}) (float64, error) {
+	token, _ := parameters["token"].(string)
+	raw, ok := parameters["amount"]
+	if token == "" || !ok {
+		return 0, nil
+	}
+	amount, err := strconv.ParseFloat(fmt.Sprint(raw), 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid action amount %v", raw)
+	}
+	price, err := sl.prices.GetPrice(ctx, token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to price %s: %w", token, err)
+	}
+	return amount * price, nil
+}
+
+// Authorize checks an action worth valueUSD against the user's limits and,
+// when it fits, counts it toward today's spending. Returns a
+// *SpendingLimitError when it doesn't fit.
+func (sl *SpendingLimiter) Authorize(ctx context.Context, userID string, valueUSD float64) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	account, err := sl.load(ctx, userID)
+	if err != nil {
+		return err
+	}
+	limits := sl.effective(account)
+	if valueUSD > limits.PerActionUSD {
+		return &SpendingLimitError{Limit: "per_action", LimitUSD: limits.PerActionUSD, ValueUSD: valueUSD, SpentUSD: account.SpentUSD}
+	}
+	if account.SpentUSD+valueUSD > limits.DailyUSD {
+		return &SpendingLimitError{Limit: "daily", LimitUSD: limits.DailyUSD, ValueUSD: valueUSD, SpentUSD: account.SpentUSD}
+	}
+
+	account.SpentUSD += valueUSD
+	if err := sl.store.Save(ctx, account); err != nil {
+		return fmt.Errorf("failed to record spending: %w", err)
+	}
+	return nil
+}
+
+// Status returns the user's limits and today's spending
+func (sl *SpendingLimiter) Status(ctx context.Context, userID string) (SpendingStatus, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	account, err := sl.load(ctx, userID)
+	if err != nil {
+		return SpendingStatus{}, err
+	}
+	return sl.status(account), nil
+}
+
+// Request sets the user's own limits. Lower limits apply at once; a raised
+// limit waits SpendingLimitIncreaseDelay, so someone who takes over the
+// account can't lift the caps and spend straight away. A new request
+// replaces a pending one.
+func (sl *SpendingLimiter) Request(ctx context.Context, userID string, limits SpendingLimits) (SpendingStatus, error) {
+	if err := limits.Validate(); err != nil {
+		return SpendingStatus{}, err
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	account, err := sl.load(ctx, userID)
+	if err != nil {
+		return SpendingStatus{}, err
+	}
+	current := sl.effective(account)
+	applied := SpendingLimits{
+		DailyUSD:     min(limits.DailyUSD, current.DailyUSD),
+		PerActionUSD: min(limits.PerActionUSD, current.PerActionUSD),
+	}
+	account.Limits = &applied
+	account.Pending = nil
+	if applied != limits {
+		now := sl.now()
+		account.Pending = &PendingSpendingLimits{Limits: limits, RequestedAt: now, EffectiveAt: now.Add(SpendingLimitIncreaseDelay)}
+	}
+
+	if err := sl.store.Save(ctx, account); err != nil {
+		return SpendingStatus{}, fmt.Errorf("failed to save spending limits: %w", err)
+	}
+	return sl.status(account), nil
+}
+
+// Override sets the user's limits at once, raised or not, and records the
+// admin who did it and why
+func (sl *SpendingLimiter) Override(ctx context.Context, userID string, limits SpendingLimits, admin, reason string) (SpendingStatus, error) {
+	if err := limits.Validate(); err != nil {
+		return SpendingStatus{}, err
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	account, err := sl.load(ctx, userID)
+	if err != nil {
+		return SpendingStatus{}, err
+	}
+	account.Overrides = append(account.Overrides, SpendingLimitOverride{
+		Admin:  admin,
+		Reason: reason,
+		From:   sl.effective(account),
+		To:     limits,
+		At:     sl.now(),
+	})
+	account.Limits = &limits
+	account.Pending = nil
+
+	if err := sl.store.Save(ctx, account); err != nil {
+		return SpendingStatus{}, fmt.Errorf("failed to save spending limits: %w", err)
+	}
+	sl.logger.Printf("Spending limits of %s set to $%g daily, $%g per action by %s: %s", userID, limits.DailyUSD, limits.PerActionUSD, admin, reason)
+	return sl.status(account), nil
+}
+
+// load reads the user's account as of now: a pending increase that has
+// waited long enough applies, and spending from an earlier UTC day is
+// cleared. The lock is held by the caller.
+func (sl *SpendingLimiter) load(ctx context.Context, userID string) (SpendingAccount, error) {
+	userID = strings.ToLower(userID)
+	account, err := sl.store.Load(ctx, userID)
+	if err != nil {
+		return SpendingAccount{}, fmt.Errorf("failed to read spending limits: %w", err)
+	}
+	account.UserID = userID
+
+	now := sl.now().UTC()
+	if account.Pending != nil && !now.Before(account.Pending.EffectiveAt) {
+		limits := account.Pending.Limits
+		account.Limits = &limits
+		account.Pending = nil
+	}
+	if today := now.Format(spendingDayLayout); account.Day != today {
+		account.Day = today
+		account.SpentUSD = 0
+	}
+	return account, nil
+}
+
+// effective returns the limits in force for an account
+func (sl *SpendingLimiter) effective(account SpendingAccount) SpendingLimits {
+	if account.Limits == nil {
+		return sl.defaults
+	}
+	return *account.Limits
+}
+
+func (sl *SpendingLimiter) status(account SpendingAccount) SpendingStatus {
+	limits := sl.effective(account)
+	day, _ := time.Parse(spendingDayLayout, account.Day)
+	return SpendingStatus{
+		Limits:            limits,
+		Default:           account.Limits == nil,
+		Pending:           account.Pending,
+		SpentTodayUSD:     account.SpentUSD,
+		RemainingTodayUSD: max(limits.DailyUSD-account.SpentUSD, 0),
+		ResetsAt:          day.AddDate(0, 0, 1),
+		Overrides:         account.Overrides,
+	}
+}
+
+// UserData returns the user's limits and today's spending
+func (sl *SpendingLimiter) UserData(userID string) interface{} {
+	status, err := sl.Status(context.Background(), userID)
+	if err != nil {
+		sl.logger.Printf("Failed to export spending of %s: %v", userID, err)
+		return nil
+	}
+	return status
+}
+
+// EraseUserData removes the limit overrides recorded for the user and
+// returns how many were removed. Their limits and today's spending are kept,
+// so erasing data can't lift the caps.
+func (sl *SpendingLimiter) EraseUserData(userID string) int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	ctx := context.Background()
+	account, err := sl.load(ctx, userID)
+	if err != nil {
+		sl.logger.Printf("Failed to erase spending of %s: %v", userID, err)
+		return 0
+	}
+	removed := len(account.Overrides)
+	if removed == 0 {
+		return 0
+	}
+	account.Overrides = nil
+	if err := sl.store.Save(ctx, account); err != nil {
+		sl.logger.Printf("Failed to erase spending of %s: %v", userID, err)
+		return 0
+	}
+	return removed
+}
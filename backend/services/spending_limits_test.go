@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSpendingLimiter returns a limiter with $1,000 daily and $600 per
+// action defaults, ETH at $200, and a clock it reads from
+func newTestSpendingLimiter() (*SpendingLimiter, *testClock) {
+	clock := &testClock{now: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := NewSpendingLimiter(NewMemorySpendingStore(), SpendingLimits{DailyUSD: 1000, PerActionUSD: 600}, fakePrices{"ETH": 200})
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+func TestSpendingLimiterEnforcesLimits(t *testing.T) {
+	limiter, _ := newTestSpendingLimiter()
+	ctx := context.Background()
+
+	var limitErr *SpendingLimitError
+	err := limiter.Authorize(ctx, "0xUser", 700)
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "per_action", limitErr.Limit)
+	assert.ErrorIs(t, err, ErrSpendingLimitExceeded)
+
+	require.NoError(t, limiter.Authorize(ctx, "0xUser", 600))
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 400), "up to the daily cap")
+	err = limiter.Authorize(ctx, "0xuser", 0.01)
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "daily", limitErr.Limit)
+	assert.Equal(t, 1000.0, limitErr.SpentUSD)
+
+	status, err := limiter.Status(ctx, "0xuser")
+	require.NoError(t, err)
+	assert.True(t, status.Default)
+	assert.Equal(t, 1000.0, status.SpentTodayUSD)
+	assert.Zero(t, status.RemainingTodayUSD)
+	assert.Equal(t, time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC), status.ResetsAt)
+}
+
+func TestSpendingLimiterResetsAtUTCMidnight(t *testing.T) {
+	limiter, clock := newTestSpendingLimiter()
+	ctx := context.Background()
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 600))
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 400))
+
+	clock.Advance(11*time.Hour + 59*time.Minute)
+	assert.Error(t, limiter.Authorize(ctx, "0xuser", 1), "still 2025-06-01 in UTC")
+
+	clock.Advance(time.Minute)
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 600))
+	status, err := limiter.Status(ctx, "0xuser")
+	require.NoError(t, err)
+	assert.Equal(t, 600.0, status.SpentTodayUSD)
+	assert.Equal(t, 400.0, status.RemainingTodayUSD)
+}
+
+func TestSpendingLimiterDelaysIncreases(t *testing.T) {
+	limiter, clock := newTestSpendingLimiter()
+	ctx := context.Background()
+
+	// Raising the daily limit while lowering the per-action one applies the
+	// decrease at once and holds the increase
+	status, err := limiter.Request(ctx, "0xuser", SpendingLimits{DailyUSD: 5000, PerActionUSD: 300})
+	require.NoError(t, err)
+	assert.Equal(t, SpendingLimits{DailyUSD: 1000, PerActionUSD: 300}, status.Limits)
+	require.NotNil(t, status.Pending)
+	assert.Equal(t, clock.Now().Add(SpendingLimitIncreaseDelay), status.Pending.EffectiveAt)
+
+	assert.ErrorIs(t, limiter.Authorize(ctx, "0xuser", 400), ErrSpendingLimitExceeded)
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 300))
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 300))
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 300))
+	assert.ErrorIs(t, limiter.Authorize(ctx, "0xuser", 300), ErrSpendingLimitExceeded, "the raised daily limit isn't in effect yet")
+
+	clock.Advance(SpendingLimitIncreaseDelay - time.Second)
+	status, err = limiter.Status(ctx, "0xuser")
+	require.NoError(t, err)
+	assert.NotNil(t, status.Pending)
+
+	clock.Advance(time.Second)
+	status, err = limiter.Status(ctx, "0xuser")
+	require.NoError(t, err)
+	assert.Nil(t, status.Pending)
+	assert.Equal(t, SpendingLimits{DailyUSD: 5000, PerActionUSD: 300}, status.Limits)
+	assert.False(t, status.Default)
+
+	// A decrease also cancels a pending increase
+	_, err = limiter.Request(ctx, "0xuser", SpendingLimits{DailyUSD: 10000, PerActionUSD: 300})
+	require.NoError(t, err)
+	status, err = limiter.Request(ctx, "0xuser", SpendingLimits{DailyUSD: 500, PerActionUSD: 100})
+	require.NoError(t, err)
+	assert.Nil(t, status.Pending)
+	assert.Equal(t, SpendingLimits{DailyUSD: 500, PerActionUSD: 100}, status.Limits)
+
+	_, err = limiter.Request(ctx, "0xuser", SpendingLimits{DailyUSD: 100, PerActionUSD: 200})
+	assert.Error(t, err)
+}
+
+func TestSpendingLimiterOverride(t *testing.T) {
+	limiter, _ := newTestSpendingLimiter()
+	ctx := context.Background()
+	_, err := limiter.Request(ctx, "0xuser", SpendingLimits{DailyUSD: 5000, PerActionUSD: 5000})
+	require.NoError(t, err)
+
+	status, err := limiter.Override(ctx, "0xUser", SpendingLimits{DailyUSD: 20000, PerActionUSD: 8000}, "support", "verified OTC desk")
+	require.NoError(t, err)
+	assert.Nil(t, status.Pending, "the override replaces the pending increase")
+	require.NoError(t, limiter.Authorize(ctx, "0xuser", 8000))
+	require.Len(t, status.Overrides, 1)
+	assert.Equal(t, "support", status.Overrides[0].Admin)
+	assert.Equal(t, "verified OTC desk", status.Overrides[0].Reason)
+	assert.Equal(t, SpendingLimits{DailyUSD: 1000, PerActionUSD: 600}, status.Overrides[0].From)
+
+	// Erasing the user's data drops the trail but keeps the caps
+	assert.Equal(t, 1, limiter.EraseUserData("0xuser"))
+	status, err = limiter.Status(ctx, "0xuser")
+	require.NoError(t, err)
+	assert.Empty(t, status.Overrides)
+	assert.Equal(t, 20000.0, status.Limits.DailyUSD)
+	assert.Equal(t, 8000.0, status.SpentTodayUSD)
+}
+
+func TestSpendingLimiterPricesActions(t *testing.T) {
+	limiter, _ := newTestSpendingLimiter()
+	ctx := context.Background()
+
+	value, err := limiter.ActionValueUSD(ctx, map[string]interface{}{"amount": "2.5", "token": "ETH"})
+	require.NoError(t, err)
+	assert.Equal(t, 500.0, value)
+	value, err = limiter.ActionValueUSD(ctx, map[string]interface{}{"proposal_id": "7"})
+	require.NoError(t, err)
+	assert.Zero(t, value)
+	_, err = limiter.ActionValueUSD(ctx, map[string]interface{}{"amount": "1", "token": "DAI"})
+	assert.Error(t, err)
+}
+
+// failingSpendingStore stands in for a store that can't be reached
+type failingSpendingStore struct{}
+
+func (failingSpendingStore) Load(ctx context.Context, userID string) (SpendingAccount, error) {
+	return SpendingAccount{}, errors.New("connection refused")
+}
+
+func (failingSpendingStore) Save(ctx context.Context, account SpendingAccount) error {
+	return errors.New("connection refused")
+}
+
+func TestChatActionsCountTowardSpendingLimits(t *testing.T) {
+	submitter := &countingSubmitter{}
+	queue, audit, _ := newTestActionQueue(submitter)
+	limiter, clock := newTestSpendingLimiter()
+	engine := newTestChatEngine(t)
+	engine.SetActionAudit(audit)
+	engine.SetActionQueue(queue)
+	engine.SetSpendingLimiter(limiter)
+	ctx := context.Background()
+	user := summaryAddress.Hex()
+
+	for i, message := range []string{"Swap 3 ETH for USDC", "Swap 2 ETH for USDC"} {
+		response, err := engine.ProcessMessage(ctx, &ChatMessage{ID: "msg", UserID: user, Message: message})
+		require.NoError(t, err)
+		assert.True(t, response.Success, "action %d", i)
+	}
+
+	response, err := engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_3", UserID: user, Message: "Swap 0.5 ETH for USDC"})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Equal(t, "spending_limit", response.Metadata["error"])
+	assert.Contains(t, response.Response, "above your daily limit of $1,000.00")
+	assert.Equal(t, ActionStatusFailed, response.Data.(*ActionRequest).Status)
+
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_4", UserID: user, Message: "Swap 4 ETH for USDC"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Response, "above your limit of $600.00 per action")
+
+	clock.Advance(12 * time.Hour)
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_5", UserID: user, Message: "Swap 0.5 ETH for USDC"})
+	require.NoError(t, err)
+	assert.True(t, response.Success, "the daily limit reset at midnight UTC")
+
+	// A limit that can't be checked refuses the action
+	engine.SetSpendingLimiter(NewSpendingLimiter(failingSpendingStore{}, limiter.defaults, fakePrices{"ETH": 200}))
+	response, err = engine.ProcessMessage(ctx, &ChatMessage{ID: "msg_6", UserID: user, Message: "Swap 0.5 ETH for USDC"})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Equal(t, "spending_limit", response.Metadata["error"])
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// StakingSnapshotInterval is how often validators are snapshotted
+	StakingSnapshotInterval = time.Hour
+	// StakingTrendDays is how many daily reward rates the trend holds
+	StakingTrendDays = 7
+
+	stakingYear = 365 * 24 * time.Hour
+)
+
+// Validator sort orders
+const (
+	ValidatorSortAPR        = "apr"
+	ValidatorSortCommission = "commission"
+	ValidatorSortStake      = "stake"
+)
+
+// ValidatorMinUptime is the uptime a validator needs to be recommended, by
+// risk tolerance
+var ValidatorMinUptime = map[string]float64{
+	RiskLow:    0.995,
+	RiskMedium: 0.99,
+	RiskHigh:   0.97,
+}
+
+// stakingRegistryABI covers the reads of a validator registry contract
+const stakingRegistryABI = `[
+	{"type":"function","name":"getValidators","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"address[]"}]},
+	{"type":"function","name":"getValidator","stateMutability":"view","inputs":[{"name":"validator","type":"address"}],"outputs":[
+		{"name":"name","type":"string"},
+		{"name":"commissionBps","type":"uint256"},
+		{"name":"totalStaked","type":"uint256"},
+		{"name":"uptimeBps","type":"uint256"},
+		{"name":"totalRewards","type":"uint256"}
+	]}
+]`
+
+// stakingRegistry is the parsed stakingRegistryABI
+var stakingRegistry = mustParseABI(stakingRegistryABI)
+
+// ValidatorState is a validator as read from its registry. Amounts are in
+// KAIA; TotalRewards is cumulative, so reward rates come from its growth.
+type ValidatorState struct {
+	Address      common.Address
+	Name         string
+	Commission   float64
+	Uptime       float64
+	TotalStaked  float64
+	TotalRewards float64
+}
+
+// ValidatorSource reads the current state of every validator
+type ValidatorSource interface {
+	Validators(ctx context.Context) ([]ValidatorState, error)
+}
+
+// ParseStakingRegistries parses registry contract addresses separated by commas
+func ParseStakingRegistries(spec string) ([]common.Address, error) {
+	var registries []common.Address
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !common.IsHexAddress(entry) {
+			return nil, fmt.Errorf("invalid registry address %q", entry)
+		}
+		registries = append(registries, common.HexToAddress(entry))
+	}
+	return registries, nil
+}
+
+// ChainValidatorRegistry reads validators from registry contracts
+type ChainValidatorRegistry struct {
+	caller     ethereum.ContractCaller
+	registries []common.Address
+}
+
+// NewChainValidatorRegistry creates a reader for the registry contracts
+func NewChainValidatorRegistry(caller ethereum.ContractCaller, registries []common.Address) *ChainValidatorRegistry {
+	return &ChainValidatorRegistry{caller: caller, registries: registries}
+}
+
+// Validators reads every validator of every registry
+func (r *ChainValidatorRegistry) Validators(ctx context.Context) ([]ValidatorState, error) {
+	var validators []ValidatorState
+	for _, registry := range r.registries {
+		out, err := r.call(ctx, registry, "getValidators")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list validators of %s: %w", registry.Hex(), err)
+		}
+		for _, validator := range out[0].([]common.Address) {
+			out, err := r.call(ctx, registry, "getValidator", validator)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read validator %s: %w", validator.Hex(), err)
+			}
+			validators = append(validators, ValidatorState{
+				Address:      validator,
+				Name:         out[0].(string),
+				Commission:   float64(out[1].(*big.Int).Int64()) / 10000,
+				TotalStaked:  weiToFloat(out[2].(*big.Int), defaultTokenDecimals),
+				Uptime:       float64(out[3].(*big.Int).Int64()) / 10000,
+				TotalRewards: weiToFloat(out[4].(*big.Int), defaultTokenDecimals),
+			})
+		}
+	}
+	return validators, nil
+}
+
+func (r *ChainValidatorRegistry) call(ctx context.Context, registry common.Address, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := stakingRegistry.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &registry, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return stakingRegistry.Unpack(method, result)
+}
+
+// validatorSnapshot is a validator's state at one collection
+type validatorSnapshot struct {
+	at    time.Time
+	state ValidatorState
+}
+
+// ValidatorPerformance is a validator's current state and reward rates.
+// Rates are annualized rewards per staked KAIA after commission, so they are
+// what delegators earn; they are 0 until two snapshots are known.
+type ValidatorPerformance struct {
+	Address     string  `json:"address"`
+	Name        string  `json:"name"`
+	Commission  float64 `json:"commission"`
+	Uptime      float64 `json:"uptime"`
+	TotalStaked float64 `json:"total_staked"`
+	// APR is the reward rate over the last day
+	APR      float64 `json:"apr"`
+	APR7dAvg float64 `json:"apr_7d_avg"`
+	// APRTrend is the reward rate of each of the last 7 days, oldest first
+	APRTrend  []SeriesPoint `json:"apr_trend"`
+	UpdatedAt APITime       `json:"updated_at"`
+}
+
+// StakingCollector snapshots validators from their registries and tracks
+// their reward rates. Snapshots are kept in memory; those compacted by the
+// retention engine are thinned to the last of each day.
+type StakingCollector struct {
+	source ValidatorSource
+	logger *log.Logger
+
+	mu        sync.RWMutex
+	snapshots map[common.Address][]validatorSnapshot
+
+	now func() time.Time
+}
+
+// NewStakingCollector creates a collector with no snapshots
+func NewStakingCollector(source ValidatorSource) *StakingCollector {
+	return &StakingCollector{
+		source:    source,
+		logger:    log.New(log.Writer(), "[StakingCollector] ", log.LstdFlags),
+		snapshots: make(map[common.Address][]validatorSnapshot),
+		now:       utcNow,
+	}
+}
+
+// Start snapshots the validators every StakingSnapshotInterval until the
+// context is cancelled
+func (sc *StakingCollector) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(StakingSnapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := sc.Collect(ctx); err != nil && ctx.Err() == nil {
+				sc.logger.Printf("Failed to collect validators: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Collect snapshots every validator. Validators no longer listed keep their
+// old snapshots, but aren't reported.
+func (sc *StakingCollector) Collect(ctx context.Context) error {
+	validators, err := sc.source.Validators(ctx)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := sc.now()
+	for _, validator := range validators {
+		sc.snapshots[validator.Address] = append(sc.snapshots[validator.Address], validatorSnapshot{at: now, state: validator})
+	}
+	return nil
+}
+
+// CompactBefore drops at most limit snapshots taken before cutoff, truncated
+// to the day, keeping the last snapshot of each day. Reward rates between
+// the kept snapshots are unchanged, as rewards are cumulative.
+func (sc *StakingCollector) CompactBefore(cutoff time.Time, limit int) int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	cutoff = cutoff.UTC().Truncate(24 * time.Hour)
+	addresses := make([]common.Address, 0, len(sc.snapshots))
+	for address := range sc.snapshots {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Hex() < addresses[j].Hex() })
+
+	compacted := 0
+	for _, address := range addresses {
+		snapshots := sc.snapshots[address]
+		old := sort.Search(len(snapshots), func(i int) bool { return !snapshots[i].at.Before(cutoff) })
+		kept := make([]validatorSnapshot, 0, len(snapshots))
+		for i, snapshot := range snapshots {
+			lastOfDay := i+1 >= old || !snapshots[i+1].at.UTC().Truncate(24*time.Hour).Equal(snapshot.at.UTC().Truncate(24*time.Hour))
+			if i < old && !lastOfDay && compacted < limit {
+				compacted++
+				continue
+			}
+			kept = append(kept, snapshot)
+		}
+		sc.snapshots[address] = kept
+		if compacted == limit {
+			break
+		}
+	}
+	return compacted
+}
+
+// Validators returns the performance of the validators seen in the last
+// snapshot, in the given sort order: highest APR, lowest commission, or
+// largest stake first
+func (sc *StakingCollector) Validators(sortBy string) ([]ValidatorPerformance, error) {
+	less, ok := validatorOrders[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort order %q", sortBy)
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	var latest time.Time
+	for _, snapshots := range sc.snapshots {
+		if at := snapshots[len(snapshots)-1].at; at.After(latest) {
+			latest = at
+		}
+	}
+	validators := make([]ValidatorPerformance, 0, len(sc.snapshots))
+	for _, snapshots := range sc.snapshots {
+		if snapshots[len(snapshots)-1].at.Equal(latest) {
+			validators = append(validators, validatorPerformance(snapshots))
+		}
+	}
+	sort.Slice(validators, func(i, j int) bool {
+		if less(validators[i], validators[j]) != less(validators[j], validators[i]) {
+			return less(validators[i], validators[j])
+		}
+		return validators[i].Address < validators[j].Address
+	})
+	return validators, nil
+}
+
+// validatorOrders compare validators for each sort order
+var validatorOrders = map[string]func(a, b ValidatorPerformance) bool{
+	ValidatorSortAPR:        func(a, b ValidatorPerformance) bool { return a.APR > b.APR },
+	ValidatorSortCommission: func(a, b ValidatorPerformance) bool { return a.Commission < b.Commission },
+	ValidatorSortStake:      func(a, b ValidatorPerformance) bool { return a.TotalStaked > b.TotalStaked },
+}
+
+// validatorPerformance computes the reward rates of a validator's snapshots,
+// oldest first
+func validatorPerformance(snapshots []validatorSnapshot) ValidatorPerformance {
+	last := snapshots[len(snapshots)-1]
+	performance := ValidatorPerformance{
+		Address:     last.state.Address.Hex(),
+		Name:        last.state.Name,
+		Commission:  last.state.Commission,
+		Uptime:      last.state.Uptime,
+		TotalStaked: last.state.TotalStaked,
+		APRTrend:    []SeriesPoint{},
+		UpdatedAt:   NewAPITime(last.at),
+	}
+
+	performance.APR = rewardRate(snapshotAtOrBefore(snapshots, last.at.Add(-24*time.Hour)), last)
+	performance.APR7dAvg = rewardRate(snapshotAtOrBefore(snapshots, last.at.Add(-StakingTrendDays*24*time.Hour)), last)
+	for day := StakingTrendDays - 1; day >= 0; day-- {
+		end := snapshotAtOrBefore(snapshots, last.at.Add(-time.Duration(day)*24*time.Hour))
+		start := snapshotAtOrBefore(snapshots, end.at.Add(-24*time.Hour))
+		if start.at.Equal(end.at) {
+			continue
+		}
+		performance.APRTrend = append(performance.APRTrend, SeriesPoint{Timestamp: end.at, Value: rewardRate(start, end)})
+	}
+	return performance
+}
+
+// snapshotAtOrBefore returns the latest snapshot taken at or before at,
+// falling back to the oldest one
+func snapshotAtOrBefore(snapshots []validatorSnapshot, at time.Time) validatorSnapshot {
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].at.After(at) })
+	if i == 0 {
+		return snapshots[0]
+	}
+	return snapshots[i-1]
+}
+
+// rewardRate annualizes the rewards earned per staked KAIA between two
+// snapshots, after the commission. Rewards that went down, as when a
+// registry resets its counter, count as none.
+func rewardRate(from, to validatorSnapshot) float64 {
+	elapsed := to.at.Sub(from.at)
+	stake := (from.state.TotalStaked + to.state.TotalStaked) / 2
+	earned := to.state.TotalRewards - from.state.TotalRewards
+	if elapsed <= 0 || stake <= 0 || earned <= 0 {
+		return 0
+	}
+	rate := earned / stake * float64(stakingYear) / float64(elapsed) * (1 - to.state.Commission)
+	return roundTo(rate, 6)
+}
+
+// ValidatorRecommendation is the validator recommended for a user and why
+type ValidatorRecommendation struct {
+	Validator ValidatorPerformance `json:"validator"`
+	MinUptime float64              `json:"min_uptime"`
+	// Eligible is how many validators met the uptime threshold
+	Eligible int `json:"eligible"`
+	// Rule is the order eligible validators were ranked in
+	Rule string `json:"rule"`
+}
+
+// RecommendValidator picks a validator among those at or above the uptime
+// threshold of the risk tolerance: the lowest commission, or with
+// preferRewards the highest APR, ties going to the other criterion. Returns
+// false when no validator qualifies.
+func RecommendValidator(validators []ValidatorPerformance, riskTolerance string, preferRewards bool) (*ValidatorRecommendation, bool) {
+	minUptime, ok := ValidatorMinUptime[riskTolerance]
+	if !ok {
+		minUptime = ValidatorMinUptime[RiskMedium]
+	}
+
+	eligible := make([]ValidatorPerformance, 0, len(validators))
+	for _, validator := range validators {
+		if validator.Uptime >= minUptime {
+			eligible = append(eligible, validator)
+		}
+	}
+	if len(eligible) == 0 {
+		return nil, false
+	}
+
+	rule := ValidatorSortCommission
+	first, second := validatorOrders[ValidatorSortCommission], validatorOrders[ValidatorSortAPR]
+	if preferRewards {
+		rule = ValidatorSortAPR
+		first, second = second, first
+	}
+	sort.SliceStable(eligible, func(i, j int) bool {
+		if first(eligible[i], eligible[j]) != first(eligible[j], eligible[i]) {
+			return first(eligible[i], eligible[j])
+		}
+		return second(eligible[i], eligible[j])
+	})
+	return &ValidatorRecommendation{Validator: eligible[0], MinUptime: minUptime, Eligible: len(eligible), Rule: rule}, true
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// StakingReadBudget is how long the reward reads of a positions query may take
+const StakingReadBudget = 2 * time.Second
+
+// Staking position event kinds
+const (
+	stakingEventStake   = "stake"
+	stakingEventUnstake = "unstake"
+	stakingEventClaim   = "claim"
+)
+
+// stakingPoolABI covers the pending reward read of a staking pool
+const stakingPoolABI = `[
+	{"type":"function","name":"pendingRewards","stateMutability":"view","inputs":[{"name":"user","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+// stakingPool is the parsed stakingPoolABI
+var stakingPool = mustParseABI(stakingPoolABI)
+
+// BlockTimeReader reads the headers events are timed by
+type BlockTimeReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// PendingRewardReader reads the rewards a user can claim from a staking pool, in wei
+type PendingRewardReader interface {
+	PendingRewards(ctx context.Context, pool, user common.Address) (*big.Int, error)
+}
+
+// ChainPendingRewardReader reads pending rewards from staking pool contracts
+type ChainPendingRewardReader struct {
+	caller ethereum.ContractCaller
+}
+
+// NewChainPendingRewardReader creates a reader calling pools through caller
+func NewChainPendingRewardReader(caller ethereum.ContractCaller) *ChainPendingRewardReader {
+	return &ChainPendingRewardReader{caller: caller}
+}
+
+// PendingRewards reads a user's claimable rewards with a single call
+func (r *ChainPendingRewardReader) PendingRewards(ctx context.Context, pool, user common.Address) (*big.Int, error) {
+	data, err := stakingPool.Pack("pendingRewards", user)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &pool, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the pending rewards of %s: %w", pool.Hex(), err)
+	}
+	out, err := stakingPool.Unpack("pendingRewards", result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the pending rewards of %s: %w", pool.Hex(), err)
+	}
+	return out[0].(*big.Int), nil
+}
+
+// ParseStakingPools parses staking pool contract addresses separated by commas
+func ParseStakingPools(spec string) ([]common.Address, error) {
+	var pools []common.Address
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !common.IsHexAddress(entry) {
+			return nil, fmt.Errorf("invalid staking pool address %q", entry)
+		}
+		pools = append(pools, common.HexToAddress(entry))
+	}
+	return pools, nil
+}
+
+// StakingPosition is a user's open stake in a pool. Amounts are in KAIA.
+type StakingPosition struct {
+	Pool      string  `json:"pool"`
+	Address   string  `json:"address"`
+	Principal float64 `json:"principal"`
+	// StakedAt is the first stake since the position was last emptied
+	StakedAt APITime `json:"staked_at"`
+	// LockExpiry is the latest unlock time of the position's stakes
+	LockExpiry      *APITime `json:"lock_expiry,omitempty"`
+	Unlocked        bool     `json:"unlocked"`
+	UnlockInSeconds int64    `json:"unlock_in_seconds"`
+	// RewardRate is the pool's APY in percent that rewards are estimated at
+	RewardRate float64 `json:"reward_rate"`
+	// AccruedRewards are the unclaimed rewards: the pool's pending rewards
+	// when RewardsOnChain, else estimated from RewardRate and time staked
+	AccruedRewards float64 `json:"accrued_rewards"`
+	RewardsOnChain bool    `json:"rewards_on_chain"`
+	ClaimedRewards float64 `json:"claimed_rewards"`
+	UpdatedAt      APITime `json:"updated_at"`
+}
+
+// stakeKey identifies a user's stake in a pool
+type stakeKey struct {
+	pool common.Address
+	user common.Address
+}
+
+// stakingEvent is a pool event of one user, at its block's time
+type stakingEvent struct {
+	key    logKey
+	block  uint64
+	at     time.Time
+	kind   string
+	amount *big.Int
+	unlock time.Time
+}
+
+// StakingPositions tracks users' staking pool positions from the pools'
+// events, so stakes made outside the assistant are found too. Positions are
+// replayed from the events on every read, which keeps reorged events out.
+type StakingPositions struct {
+	headers BlockTimeReader
+	rates   PoolRateReader
+	pending PendingRewardReader
+	logger  *log.Logger
+	now     func() time.Time
+
+	mu         sync.RWMutex
+	events     map[stakeKey][]stakingEvent // oldest first
+	blockTimes map[uint64]time.Time
+	lastRates  map[common.Address]float64
+}
+
+// NewStakingPositions creates a tracker timing events by their block headers
+// and estimating rewards at the pools' reward rates
+func NewStakingPositions(headers BlockTimeReader, rates PoolRateReader) *StakingPositions {
+	return &StakingPositions{
+		headers:    headers,
+		rates:      rates,
+		logger:     log.New(log.Writer(), "[StakingPositions] ", log.LstdFlags),
+		now:        utcNow,
+		events:     make(map[stakeKey][]stakingEvent),
+		blockTimes: make(map[uint64]time.Time),
+		lastRates:  make(map[common.Address]float64),
+	}
+}
+
+// SetPendingRewardReader reconciles estimated rewards against the pools'
+// pending rewards when they can be read
+func (sp *StakingPositions) SetPendingRewardReader(pending PendingRewardReader) {
+	sp.pending = pending
+}
+
+// Apply records a decoded staking pool event, or forgets it when a reorg
+// removed it
+func (sp *StakingPositions) Apply(ctx context.Context, event DecodedEvent) error {
+	recorded := stakingEvent{key: logKey{blockHash: event.BlockHash, index: event.LogIndex}, block: event.BlockNumber}
+	var user common.Address
+	switch e := event.Event.(type) {
+	case PoolStaked:
+		user, recorded.kind, recorded.amount = e.User, stakingEventStake, e.Amount
+		if e.UnlockTime != nil && e.UnlockTime.IsInt64() {
+			recorded.unlock = time.Unix(e.UnlockTime.Int64(), 0).UTC()
+		}
+	case PoolUnstaked:
+		user, recorded.kind, recorded.amount = e.User, stakingEventUnstake, e.Amount
+	case PoolRewardClaimed:
+		user, recorded.kind, recorded.amount = e.User, stakingEventClaim, e.Amount
+	default:
+		return nil
+	}
+	key := stakeKey{pool: event.Address, user: user}
+
+	if event.Removed {
+		sp.mu.Lock()
+		defer sp.mu.Unlock()
+		events := sp.events[key]
+		for i := range events {
+			if events[i].key == recorded.key {
+				sp.events[key] = append(events[:i:i], events[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+
+	at, err := sp.blockTime(ctx, event.BlockNumber)
+	if err != nil {
+		return err
+	}
+	recorded.at = at
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	events := sp.events[key]
+	for _, seen := range events {
+		if seen.key == recorded.key {
+			return nil
+		}
+	}
+	i := sort.Search(len(events), func(i int) bool {
+		return events[i].block > recorded.block || (events[i].block == recorded.block && events[i].key.index > recorded.key.index)
+	})
+	events = append(events, stakingEvent{})
+	copy(events[i+1:], events[i:])
+	events[i] = recorded
+	sp.events[key] = events
+	return nil
+}
+
+// blockTime returns the time of a block, caching it
+func (sp *StakingPositions) blockTime(ctx context.Context, number uint64) (time.Time, error) {
+	sp.mu.RLock()
+	at, ok := sp.blockTimes[number]
+	sp.mu.RUnlock()
+	if ok {
+		return at, nil
+	}
+
+	header, err := sp.headers.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get block %d: %w", number, err)
+	}
+	at = time.Unix(int64(header.Time), 0).UTC()
+
+	sp.mu.Lock()
+	sp.blockTimes[number] = at
+	sp.mu.Unlock()
+	return at, nil
+}
+
+// Positions returns the address's open positions, by pool. Rewards are
+// estimated at each pool's current reward rate, falling back to the last
+// one read, and replaced by the pool's pending rewards when they can be read.
+func (sp *StakingPositions) Positions(ctx context.Context, address common.Address) []StakingPosition {
+	ctx, cancel := context.WithTimeout(ctx, StakingReadBudget)
+	defer cancel()
+
+	sp.mu.RLock()
+	keys := make([]stakeKey, 0)
+	replays := make(map[stakeKey][]stakingEvent)
+	for key, events := range sp.events {
+		if key.user == address {
+			keys = append(keys, key)
+			replays[key] = append([]stakingEvent{}, events...)
+		}
+	}
+	sp.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].pool.Hex() < keys[j].pool.Hex() })
+
+	now := sp.now()
+	positions := make([]StakingPosition, 0, len(keys))
+	for _, key := range keys {
+		rate := sp.rewardRate(ctx, key.pool)
+		position, open := replayStake(replays[key], rate, now)
+		if !open {
+			continue
+		}
+		position.Pool = key.pool.Hex()
+		position.Address = address.Hex()
+		if sp.pending != nil {
+			if pending, err := sp.pending.PendingRewards(ctx, key.pool, address); err == nil {
+				position.AccruedRewards = weiToFloat(pending, defaultTokenDecimals)
+				position.RewardsOnChain = true
+			} else {
+				sp.logger.Printf("Failed to read pending rewards of %s in %s, serving the estimate: %v", address.Hex(), key.pool.Hex(), err)
+			}
+		}
+		positions = append(positions, position)
+	}
+	return positions
+}
+
+// rewardRate reads the pool's reward rate, falling back to the last one read
+func (sp *StakingPositions) rewardRate(ctx context.Context, pool common.Address) float64 {
+	rate, err := sp.rates.RewardRate(ctx, pool)
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if err != nil {
+		sp.logger.Printf("Failed to read the reward rate of %s, estimating at the last one: %v", pool.Hex(), err)
+		return sp.lastRates[pool]
+	}
+	sp.lastRates[pool] = rate
+	return rate
+}
+
+// replayStake rebuilds a position from its events, accruing rewards on the
+// principal at the rate between them. Returns false once fully unstaked.
+func replayStake(events []stakingEvent, rate float64, now time.Time) (StakingPosition, bool) {
+	var position StakingPosition
+	principal := new(big.Int)
+	var accrued, claimed float64
+	var last, lockExpiry time.Time
+	accrue := func(until time.Time) {
+		if !last.IsZero() && until.After(last) {
+			accrued += weiToFloat(principal, defaultTokenDecimals) * rate / 100 * float64(until.Sub(last)) / float64(stakingYear)
+		}
+		last = until
+	}
+
+	for _, event := range events {
+		accrue(event.at)
+		switch event.kind {
+		case stakingEventStake:
+			if principal.Sign() == 0 {
+				position.StakedAt = NewAPITime(event.at)
+				accrued, lockExpiry = 0, time.Time{}
+			}
+			principal.Add(principal, event.amount)
+			if event.unlock.After(lockExpiry) {
+				lockExpiry = event.unlock
+			}
+		case stakingEventUnstake:
+			principal.Sub(principal, event.amount)
+			if principal.Sign() < 0 {
+				principal.SetInt64(0)
+			}
+		case stakingEventClaim:
+			amount := weiToFloat(event.amount, defaultTokenDecimals)
+			claimed += amount
+			accrued = max(accrued-amount, 0)
+		}
+		position.UpdatedAt = NewAPITime(event.at)
+	}
+	if principal.Sign() == 0 {
+		return StakingPosition{}, false
+	}
+	accrue(now)
+
+	position.Principal = weiToFloat(principal, defaultTokenDecimals)
+	position.RewardRate = rate
+	position.AccruedRewards = roundTo(accrued, 6)
+	position.ClaimedRewards = roundTo(claimed, 6)
+	position.Unlocked = !now.Before(lockExpiry)
+	if !lockExpiry.IsZero() {
+		expiry := NewAPITime(lockExpiry)
+		position.LockExpiry = &expiry
+	}
+	if !position.Unlocked {
+		position.UnlockInSeconds = int64(lockExpiry.Sub(now) / time.Second)
+	}
+	return position, true
+}
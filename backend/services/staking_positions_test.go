@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockClock times block n at start plus n hours
+type blockClock struct {
+	start time.Time
+}
+
+func (bc blockClock) at(block uint64) time.Time {
+	return bc.start.Add(time.Duration(block) * time.Hour)
+}
+
+func (bc blockClock) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: number, Time: uint64(bc.at(number.Uint64()).Unix())}, nil
+}
+
+type fixedRate struct {
+	apy float64
+	err error
+}
+
+func (r *fixedRate) RewardRate(ctx context.Context, contract common.Address) (float64, error) {
+	return r.apy, r.err
+}
+
+type fixedPending struct {
+	rewards *big.Int
+}
+
+func (p fixedPending) PendingRewards(ctx context.Context, pool, user common.Address) (*big.Int, error) {
+	if p.rewards == nil {
+		return nil, errors.New("execution reverted")
+	}
+	return p.rewards, nil
+}
+
+func TestStakingPositionsAccrueAcrossStakeAndUnstake(t *testing.T) {
+	pool := common.HexToAddress("0x00000000000000000000000000000000000005a1")
+	user := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	clock := blockClock{start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	rate := &fixedRate{apy: 10}
+	positions := NewStakingPositions(clock, rate)
+	ctx := context.Background()
+	apply := func(block uint64, index uint, event interface{}) {
+		require.NoError(t, positions.Apply(ctx, DecodedEvent{
+			Address: pool, BlockNumber: block, BlockHash: common.BigToHash(new(big.Int).SetUint64(block)), LogIndex: index, Event: event,
+		}))
+	}
+	unlock := clock.at(0).Add(30 * 24 * time.Hour)
+
+	// 1000 KAIA staked at block 0, 1000 more after 73 days, locked for 30 days
+	// from the first stake and 90 from the second
+	apply(0, 0, PoolStaked{User: user, Amount: kaia(1000), UnlockTime: big.NewInt(unlock.Unix())})
+	apply(73*24, 0, PoolStaked{User: user, Amount: kaia(1000), UnlockTime: big.NewInt(clock.at(73 * 24).Add(90 * 24 * time.Hour).Unix())})
+	// Half of it unstaked after another 73 days
+	apply(146*24, 1, PoolUnstaked{User: user, Amount: kaia(1000)})
+
+	positions.now = func() time.Time { return clock.at(146*24 + 73*24) }
+	got := positions.Positions(ctx, user)
+	require.Len(t, got, 1)
+	position := got[0]
+	assert.Equal(t, pool.Hex(), position.Pool)
+	assert.Equal(t, 1000.0, position.Principal)
+	assert.Equal(t, NewAPITime(clock.at(0)), position.StakedAt)
+	// 1000 KAIA for 73 days, 2000 for 73, then 1000 for 73, at 10% a year
+	assert.InDelta(t, 20+40+20, position.AccruedRewards, 1e-6)
+	assert.False(t, position.RewardsOnChain)
+	assert.True(t, position.Unlocked)
+	assert.Equal(t, NewAPITime(clock.at(73*24).Add(90*24*time.Hour)), *position.LockExpiry)
+
+	// Claims come off the accrued rewards
+	apply(146*24+73*24, 0, PoolRewardClaimed{User: user, Amount: kaia(50)})
+	position = positions.Positions(ctx, user)[0]
+	assert.InDelta(t, 30, position.AccruedRewards, 1e-6)
+	assert.Equal(t, 50.0, position.ClaimedRewards)
+
+	// Pending rewards read from the pool replace the estimate
+	positions.SetPendingRewardReader(fixedPending{rewards: new(big.Int).Div(kaia(63), big.NewInt(2))})
+	position = positions.Positions(ctx, user)[0]
+	assert.Equal(t, 31.5, position.AccruedRewards)
+	assert.True(t, position.RewardsOnChain)
+
+	// A failed rate read estimates at the last rate read
+	positions.SetPendingRewardReader(fixedPending{})
+	rate.err = errors.New("execution reverted")
+	position = positions.Positions(ctx, user)[0]
+	assert.Equal(t, 10.0, position.RewardRate)
+	assert.False(t, position.RewardsOnChain)
+
+	// Unstaking the rest closes the position
+	apply(146*24+73*24, 1, PoolUnstaked{User: user, Amount: kaia(1000)})
+	assert.Empty(t, positions.Positions(ctx, user))
+}
+
+func TestStakingPositionsLockCountdownAndReorgs(t *testing.T) {
+	pool := common.HexToAddress("0x00000000000000000000000000000000000005a1")
+	user := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	clock := blockClock{start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	positions := NewStakingPositions(clock, &fixedRate{apy: 5})
+	ctx := context.Background()
+
+	// Staked directly with the pool, seen only through its event
+	staked := DecodedEvent{Address: pool, BlockNumber: 10, BlockHash: common.HexToHash("0x10"),
+		Event: PoolStaked{User: user, Amount: kaia(500), UnlockTime: big.NewInt(clock.at(10).Add(7 * 24 * time.Hour).Unix())}}
+	require.NoError(t, positions.Apply(ctx, staked))
+	require.NoError(t, positions.Apply(ctx, staked), "replayed events are applied once")
+
+	positions.now = func() time.Time { return clock.at(10).Add(2 * 24 * time.Hour) }
+	got := positions.Positions(ctx, user)
+	require.Len(t, got, 1)
+	assert.Equal(t, 500.0, got[0].Principal)
+	assert.False(t, got[0].Unlocked)
+	assert.Equal(t, int64(5*24*60*60), got[0].UnlockInSeconds)
+	assert.Empty(t, positions.Positions(ctx, common.HexToAddress("0x00000000000000000000000000000000000000bb")))
+
+	// A reorg drops the stake
+	staked.Removed = true
+	require.NoError(t, positions.Apply(ctx, staked))
+	assert.Empty(t, positions.Positions(ctx, user))
+}
+
+func TestStakingPositionChatIntent(t *testing.T) {
+	engine := &ChatEngine{}
+	for _, message := range []string{"When can I unstake?", "how long until my KAIA unlocks", "show my staking positions"} {
+		intent, err := engine.parseIntent(message)
+		require.NoError(t, err)
+		assert.Equal(t, "staking_position", intent.Intent, message)
+	}
+	intent, err := engine.parseIntent("unstake 100 KAIA")
+	require.NoError(t, err)
+	assert.Equal(t, "on_chain_action", intent.Intent)
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	stakingRegistryAddress = common.HexToAddress("0x00000000000000000000000000000000000005e1")
+	validatorA             = common.HexToAddress("0x00000000000000000000000000000000000000b1")
+	validatorB             = common.HexToAddress("0x00000000000000000000000000000000000000b2")
+	validatorC             = common.HexToAddress("0x00000000000000000000000000000000000000b3")
+)
+
+// fakeStakingRegistry answers the reads of stakingRegistryABI
+type fakeStakingRegistry struct {
+	validators []ValidatorState
+}
+
+func (f *fakeStakingRegistry) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if *call.To != stakingRegistryAddress {
+		return nil, errors.New("execution reverted")
+	}
+	method, err := stakingRegistry.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, errors.New("execution reverted")
+	}
+	args, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch method.Name {
+	case "getValidators":
+		addresses := make([]common.Address, len(f.validators))
+		for i, validator := range f.validators {
+			addresses[i] = validator.Address
+		}
+		return method.Outputs.Pack(addresses)
+	case "getValidator":
+		for _, validator := range f.validators {
+			if validator.Address == args[0].(common.Address) {
+				return method.Outputs.Pack(validator.Name,
+					big.NewInt(int64(validator.Commission*10000)),
+					units(int64(validator.TotalStaked), 18),
+					big.NewInt(int64(validator.Uptime*10000)),
+					units(int64(validator.TotalRewards), 18))
+			}
+		}
+	}
+	return nil, errors.New("execution reverted")
+}
+
+// stubValidators serves a fixed validator set
+type stubValidators struct {
+	validators []ValidatorState
+}
+
+func (s *stubValidators) Validators(ctx context.Context) ([]ValidatorState, error) {
+	return s.validators, nil
+}
+
+// collectDays snapshots the validators once a day, each earning its daily
+// rewards before the next snapshot
+func collectDays(t *testing.T, collector *StakingCollector, source *stubValidators, clock *testClock, days int, daily map[common.Address]float64) {
+	for day := 0; day < days; day++ {
+		require.NoError(t, collector.Collect(context.Background()))
+		if day == days-1 {
+			return
+		}
+		for i := range source.validators {
+			source.validators[i].TotalRewards += daily[source.validators[i].Address]
+		}
+		clock.Advance(24 * time.Hour)
+	}
+}
+
+func newTestStakingCollector() (*StakingCollector, *stubValidators, *testClock) {
+	source := &stubValidators{validators: []ValidatorState{
+		{Address: validatorA, Name: "Alpha", Commission: 0.10, Uptime: 0.999, TotalStaked: 1_000_000},
+		{Address: validatorB, Name: "Bravo", Commission: 0.05, Uptime: 0.992, TotalStaked: 3_650_000},
+		{Address: validatorC, Name: "Charlie", Commission: 0.02, Uptime: 0.98, TotalStaked: 500_000},
+	}}
+	clock := &testClock{now: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+	collector := NewStakingCollector(source)
+	collector.now = clock.Now
+	return collector, source, clock
+}
+
+func TestChainValidatorRegistry(t *testing.T) {
+	registry := &fakeStakingRegistry{validators: []ValidatorState{
+		{Address: validatorA, Name: "Alpha", Commission: 0.1, Uptime: 0.999, TotalStaked: 1_000_000, TotalRewards: 250},
+	}}
+
+	validators, err := NewChainValidatorRegistry(registry, []common.Address{stakingRegistryAddress}).Validators(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, registry.validators, validators)
+
+	_, err = NewChainValidatorRegistry(registry, []common.Address{validatorB}).Validators(context.Background())
+	assert.Error(t, err)
+}
+
+func TestStakingCollectorRewardRates(t *testing.T) {
+	collector, source, clock := newTestStakingCollector()
+
+	// Nothing is known before the first snapshot, and one snapshot has no rate
+	validators, err := collector.Validators(ValidatorSortAPR)
+	require.NoError(t, err)
+	assert.Empty(t, validators)
+
+	require.NoError(t, collector.Collect(context.Background()))
+	validators, err = collector.Validators(ValidatorSortAPR)
+	require.NoError(t, err)
+	require.Len(t, validators, 3)
+	assert.Equal(t, 0.0, validators[0].APR)
+	assert.Empty(t, validators[0].APRTrend)
+
+	// Alpha earns 10% a year before commission, Bravo 8% rising to 12% on
+	// the last day, Charlie nothing
+	daily := map[common.Address]float64{validatorA: 1_000_000 * 0.10 / 365, validatorB: 3_650_000 * 0.08 / 365}
+	clock.Advance(24 * time.Hour)
+	source.validators[0].TotalRewards += daily[validatorA]
+	source.validators[1].TotalRewards += daily[validatorB]
+	collectDays(t, collector, source, clock, 7, daily)
+	source.validators[0].TotalRewards += daily[validatorA]
+	source.validators[1].TotalRewards += 3_650_000 * 0.12 / 365
+	clock.Advance(24 * time.Hour)
+	require.NoError(t, collector.Collect(context.Background()))
+
+	validators, err = collector.Validators(ValidatorSortAPR)
+	require.NoError(t, err)
+	require.Len(t, validators, 3)
+
+	bravo := validators[0]
+	assert.Equal(t, "Bravo", bravo.Name)
+	assert.InDelta(t, 0.12*0.95, bravo.APR, 1e-6)
+	assert.InDelta(t, (6*0.08+0.12)/7*0.95, bravo.APR7dAvg, 1e-6)
+	require.Len(t, bravo.APRTrend, StakingTrendDays)
+	assert.InDelta(t, 0.08*0.95, bravo.APRTrend[0].Value, 1e-6)
+	assert.InDelta(t, 0.12*0.95, bravo.APRTrend[6].Value, 1e-6)
+	assert.Equal(t, clock.Now(), bravo.APRTrend[6].Timestamp)
+	assert.Equal(t, clock.Now(), bravo.UpdatedAt.Time)
+
+	alpha := validators[1]
+	assert.InDelta(t, 0.10*0.90, alpha.APR, 1e-6)
+	assert.InDelta(t, 0.10*0.90, alpha.APR7dAvg, 1e-6)
+	assert.Equal(t, 0.0, validators[2].APR)
+
+	// Daily snapshots are already as compact as retention makes them
+	assert.Equal(t, 0, collector.CompactBefore(clock.Now(), RetentionBatchSize))
+}
+
+func TestStakingCollectorSortOrders(t *testing.T) {
+	collector, source, clock := newTestStakingCollector()
+	collectDays(t, collector, source, clock, 2, map[common.Address]float64{validatorA: 300, validatorC: 200})
+
+	names := func(sortBy string) []string {
+		validators, err := collector.Validators(sortBy)
+		require.NoError(t, err)
+		names := make([]string, len(validators))
+		for i, validator := range validators {
+			names[i] = validator.Name
+		}
+		return names
+	}
+	assert.Equal(t, []string{"Charlie", "Alpha", "Bravo"}, names(ValidatorSortAPR))
+	assert.Equal(t, []string{"Charlie", "Bravo", "Alpha"}, names(ValidatorSortCommission))
+	assert.Equal(t, []string{"Bravo", "Alpha", "Charlie"}, names(ValidatorSortStake))
+
+	// Validators missing from the last snapshot aren't reported
+	source.validators = source.validators[:2]
+	clock.Advance(time.Hour)
+	require.NoError(t, collector.Collect(context.Background()))
+	assert.Equal(t, []string{"Alpha", "Bravo"}, names(ValidatorSortAPR))
+
+	_, err := collector.Validators("uptime")
+	assert.Error(t, err)
+}
+
+func TestRecommendValidator(t *testing.T) {
+	validators := []ValidatorPerformance{
+		{Name: "Alpha", Commission: 0.10, Uptime: 0.999, APR: 0.09},
+		{Name: "Bravo", Commission: 0.05, Uptime: 0.992, APR: 0.07},
+		{Name: "Charlie", Commission: 0.02, Uptime: 0.98, APR: 0.10},
+		{Name: "Delta", Commission: 0.05, Uptime: 0.995, APR: 0.08},
+	}
+
+	recommendation, ok := RecommendValidator(validators, RiskLow, false)
+	require.True(t, ok)
+	assert.Equal(t, "Delta", recommendation.Validator.Name)
+	assert.Equal(t, 0.995, recommendation.MinUptime)
+	assert.Equal(t, 2, recommendation.Eligible)
+	assert.Equal(t, ValidatorSortCommission, recommendation.Rule)
+
+	// Bravo and Delta tie on commission, Delta earns more
+	recommendation, ok = RecommendValidator(validators, RiskMedium, false)
+	require.True(t, ok)
+	assert.Equal(t, "Delta", recommendation.Validator.Name)
+	assert.Equal(t, 3, recommendation.Eligible)
+
+	recommendation, ok = RecommendValidator(validators, RiskHigh, false)
+	require.True(t, ok)
+	assert.Equal(t, "Charlie", recommendation.Validator.Name)
+
+	recommendation, ok = RecommendValidator(validators, RiskLow, true)
+	require.True(t, ok)
+	assert.Equal(t, "Alpha", recommendation.Validator.Name)
+	assert.Equal(t, ValidatorSortAPR, recommendation.Rule)
+
+	_, ok = RecommendValidator(validators[2:3], RiskLow, false)
+	assert.False(t, ok)
+}
+
+func TestChatStakingQuery(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	intent, err := engine.parseIntent("stake 100 KAIA")
+	require.NoError(t, err)
+	assert.Equal(t, "on_chain_action", intent.Intent)
+
+	intent, err = engine.parseIntent("Which validator should I stake with?")
+	require.NoError(t, err)
+	assert.Equal(t, "staking_query", intent.Intent)
+
+	// Without a collector staking questions get the general answer
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", Message: "Which validator should I stake with?"})
+	require.NoError(t, err)
+	assert.NotEqual(t, "staking", response.Type)
+
+	collector, source, clock := newTestStakingCollector()
+	engine.SetStakingCollector(collector)
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m2", Message: "Which validator should I stake with?"})
+	require.NoError(t, err)
+	assert.Equal(t, "staking", response.Type)
+	assert.False(t, response.Success)
+
+	collectDays(t, collector, source, clock, 2, map[common.Address]float64{validatorA: 300, validatorB: 500})
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m3", Message: "Which validator should I stake with?"})
+	require.NoError(t, err)
+	assert.True(t, response.Success)
+	assert.Contains(t, response.Response, "**Bravo**")
+	assert.Contains(t, response.Response, "3.7M KAIA staked")
+	assert.Contains(t, response.Response, "the lowest commission of the 2 validators with at least 99% uptime")
+
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m4", Message: "Which staking validator has the best APR?"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Response, "**Alpha**")
+	data := response.Data.(map[string]interface{})
+	assert.Len(t, data["validators"], 3)
+}
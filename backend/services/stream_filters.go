@@ -0,0 +1,212 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxStreamFilters bounds the filters of one connection. A channel
+// subscribed without values counts as one filter.
+const MaxStreamFilters = 50
+
+// Streaming channels a chat connection can filter
+const (
+	// StreamPrices carries price_update frames, filtered by symbol
+	StreamPrices = "prices"
+	// StreamAnomalies carries anomaly frames, filtered by metric name such as
+	// gas_price or price:KAIA
+	StreamAnomalies = "anomalies"
+)
+
+// Stream frame types, sent on the chat connection alongside messages
+const (
+	StreamSubscribeFrame     = "subscribe"
+	StreamUnsubscribeFrame   = "unsubscribe"
+	StreamSubscriptionsFrame = "subscriptions"
+)
+
+// ErrInvalidSubscription is returned for subscribe frames that can't be applied
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+// StreamChannels lists the channels that can be filtered
+var StreamChannels = []string{StreamAnomalies, StreamPrices}
+
+// StreamSubscription is a subscribe, unsubscribe or subscriptions frame, such
+// as {"type":"subscribe","channel":"prices","symbols":["KAIA","ETH"]}
+type StreamSubscription struct {
+	ID      string   `json:"id,omitempty"`
+	Type    string   `json:"type"`
+	Channel string   `json:"channel,omitempty"`
+	Symbols []string `json:"symbols,omitempty"`
+	Metrics []string `json:"metrics,omitempty"`
+}
+
+// IsStreamFrame reports whether a frame type manages stream subscriptions
+func IsStreamFrame(frameType string) bool {
+	return frameType == StreamSubscribeFrame || frameType == StreamUnsubscribeFrame || frameType == StreamSubscriptionsFrame
+}
+
+// UnmarshalJSON reads a chat frame, keeping the channel and filters of stream
+// frames in Stream
+func (m *ChatMessage) UnmarshalJSON(data []byte) error {
+	type plain ChatMessage
+	if err := json.Unmarshal(data, (*plain)(m)); err != nil {
+		return err
+	}
+	m.Stream = nil
+	if !IsStreamFrame(m.Type) {
+		return nil
+	}
+	var frame StreamSubscription
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return err
+	}
+	m.Stream = &frame
+	return nil
+}
+
+// StreamFilter is a connection's filter on one channel. Without values it
+// passes every event of the channel.
+type StreamFilter struct {
+	Channel string   `json:"channel"`
+	Values  []string `json:"values,omitempty"`
+}
+
+// StreamSubscriptions describes a connection's active filters and the limits
+// the server enforces on them
+type StreamSubscriptions struct {
+	Filters    []StreamFilter `json:"filters"`
+	Count      int            `json:"count"`
+	MaxFilters int            `json:"max_filters"`
+	Channels   []string       `json:"channels"`
+}
+
+// streamFilters are a connection's filters: the values accepted on each
+// subscribed channel, an empty set accepting them all. Channels without an
+// entry fall back to the user's notification preferences.
+type streamFilters map[string]map[string]bool
+
+// apply subscribes to or unsubscribes from the frame's channel values
+func (sf streamFilters) apply(frame StreamSubscription) error {
+	values, err := streamFrameValues(frame)
+	if err != nil {
+		return err
+	}
+
+	if frame.Type == StreamUnsubscribeFrame {
+		if len(values) == 0 {
+			delete(sf, frame.Channel)
+			return nil
+		}
+		for _, value := range values {
+			delete(sf[frame.Channel], value)
+		}
+		if len(sf[frame.Channel]) == 0 {
+			delete(sf, frame.Channel)
+		}
+		return nil
+	}
+
+	// A channel subscribed without values already passes everything, and
+	// subscribing without values widens it to everything
+	current, subscribed := sf[frame.Channel]
+	next := make(map[string]bool)
+	if len(values) > 0 && (!subscribed || len(current) > 0) {
+		for value := range current {
+			next[value] = true
+		}
+		for _, value := range values {
+			next[value] = true
+		}
+	}
+
+	count := sf.count() - filterCount(current, subscribed) + filterCount(next, true)
+	if count > MaxStreamFilters {
+		return fmt.Errorf("%w: at most %d filters are allowed per connection, this would make %d", ErrInvalidSubscription, MaxStreamFilters, count)
+	}
+	sf[frame.Channel] = next
+	return nil
+}
+
+// accepts reports whether the connection subscribed to the channel, and if
+// so whether it wants the event with the value
+func (sf streamFilters) accepts(channel, value string) (bool, bool) {
+	values, subscribed := sf[channel]
+	if !subscribed {
+		return false, false
+	}
+	return true, len(values) == 0 || values[value]
+}
+
+// count returns the number of filters, a channel without values being one
+func (sf streamFilters) count() int {
+	count := 0
+	for _, values := range sf {
+		count += filterCount(values, true)
+	}
+	return count
+}
+
+// describe lists the filters by channel, with sorted values
+func (sf streamFilters) describe() StreamSubscriptions {
+	described := StreamSubscriptions{
+		Filters:    []StreamFilter{},
+		Count:      sf.count(),
+		MaxFilters: MaxStreamFilters,
+		Channels:   StreamChannels,
+	}
+	for channel, values := range sf {
+		filter := StreamFilter{Channel: channel}
+		for value := range values {
+			filter.Values = append(filter.Values, value)
+		}
+		sort.Strings(filter.Values)
+		described.Filters = append(described.Filters, filter)
+	}
+	sort.Slice(described.Filters, func(i, j int) bool { return described.Filters[i].Channel < described.Filters[j].Channel })
+	return described
+}
+
+// filterCount counts a channel's values, or one for a channel without any
+func filterCount(values map[string]bool, subscribed bool) int {
+	if !subscribed {
+		return 0
+	}
+	if len(values) == 0 {
+		return 1
+	}
+	return len(values)
+}
+
+// streamFrameValues validates a frame and returns its values normalized:
+// symbols in upper case, metrics as they are
+func streamFrameValues(frame StreamSubscription) ([]string, error) {
+	var values []string
+	switch frame.Channel {
+	case StreamPrices:
+		if len(frame.Metrics) > 0 {
+			return nil, fmt.Errorf("%w: the prices channel is filtered by symbols", ErrInvalidSubscription)
+		}
+		for _, symbol := range frame.Symbols {
+			values = append(values, strings.ToUpper(strings.TrimSpace(symbol)))
+		}
+	case StreamAnomalies:
+		if len(frame.Symbols) > 0 {
+			return nil, fmt.Errorf("%w: the anomalies channel is filtered by metrics", ErrInvalidSubscription)
+		}
+		for _, metric := range frame.Metrics {
+			values = append(values, strings.TrimSpace(metric))
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown channel %q, expected one of %s", ErrInvalidSubscription, frame.Channel, strings.Join(StreamChannels, ", "))
+	}
+	for _, value := range values {
+		if value == "" {
+			return nil, fmt.Errorf("%w: filter values can't be empty", ErrInvalidSubscription)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamedEvents reads frames until the end marker and returns the price
+// symbols and anomaly metrics pushed before it
+func streamedEvents(t *testing.T, client *websocket.Conn) []string {
+	var events []string
+	for {
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+		var frame struct {
+			Type string `json:"type"`
+			Data struct {
+				Price  LivePrice `json:"price"`
+				Metric string    `json:"metric"`
+			} `json:"data"`
+		}
+		require.NoError(t, client.ReadJSON(&frame))
+		switch frame.Type {
+		case "price_update":
+			events = append(events, "price:"+frame.Data.Price.Symbol)
+		case "anomaly":
+			events = append(events, "anomaly:"+frame.Data.Metric)
+		case "end":
+			return events
+		}
+	}
+}
+
+func TestStreamFiltersRouteEvents(t *testing.T) {
+	engine := newTestChatEngine(t)
+	kaiaOnly := connectChat(t, engine, "0xaaa")
+	ethAndGas := connectChat(t, engine, "0xbbb")
+	unfiltered := connectChat(t, engine, "0xccc")
+
+	subscriptions := engine.HandleStreamFrame("0xaaa", StreamSubscription{ID: "s1", Type: StreamSubscribeFrame, Channel: StreamPrices, Symbols: []string{"kaia"}})
+	require.True(t, subscriptions.Success, subscriptions.Response)
+	assert.Equal(t, "s1", subscriptions.MessageID)
+	assert.Equal(t, []StreamFilter{{Channel: StreamPrices, Values: []string{"KAIA"}}}, subscriptions.Data.(StreamSubscriptions).Filters)
+
+	engine.HandleStreamFrame("0xbbb", StreamSubscription{Type: StreamSubscribeFrame, Channel: StreamPrices, Symbols: []string{"ETH", "BTC"}})
+	engine.HandleStreamFrame("0xbbb", StreamSubscription{Type: StreamSubscribeFrame, Channel: StreamAnomalies, Metrics: []string{MetricGasPrice}})
+	engine.HandleStreamFrame("0xbbb", StreamSubscription{Type: StreamUnsubscribeFrame, Channel: StreamPrices, Symbols: []string{"BTC"}})
+
+	publish := func() {
+		for _, symbol := range []string{"KAIA", "ETH", "BTC"} {
+			engine.BroadcastPrice(LivePrice{Symbol: symbol, Price: 1, Source: "binance"})
+		}
+		engine.BroadcastAnomaly(MetricGasPrice, Anomaly{Value: 900})
+		engine.BroadcastAnomaly(PriceMetric("KAIA"), Anomaly{Value: 2})
+		require.NoError(t, engine.BroadcastMessage(&ChatResponse{Type: "end"}))
+	}
+	publish()
+
+	assert.Equal(t, []string{"price:KAIA", "anomaly:gas_price", "anomaly:price:KAIA"}, streamedEvents(t, kaiaOnly), "its anomalies aren't filtered")
+	assert.Equal(t, []string{"price:ETH", "anomaly:gas_price"}, streamedEvents(t, ethAndGas))
+	assert.Equal(t, []string{"price:KAIA", "price:ETH", "price:BTC", "anomaly:gas_price", "anomaly:price:KAIA"}, streamedEvents(t, unfiltered))
+
+	// Unsubscribing from the whole channel brings every price back
+	subscriptions = engine.HandleStreamFrame("0xaaa", StreamSubscription{Type: StreamUnsubscribeFrame, Channel: StreamPrices})
+	assert.Empty(t, subscriptions.Data.(StreamSubscriptions).Filters)
+	publish()
+	assert.Equal(t, []string{"price:KAIA", "price:ETH", "price:BTC", "anomaly:gas_price", "anomaly:price:KAIA"}, streamedEvents(t, kaiaOnly))
+}
+
+func TestStreamFiltersEnforceLimits(t *testing.T) {
+	engine := newTestChatEngine(t)
+
+	symbols := make([]string, MaxStreamFilters)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("TOKEN%d", i)
+	}
+	response := engine.HandleStreamFrame("0xaaa", StreamSubscription{Type: StreamSubscribeFrame, Channel: StreamPrices, Symbols: symbols})
+	require.True(t, response.Success, response.Response)
+
+	response = engine.HandleStreamFrame("0xaaa", StreamSubscription{Type: StreamSubscribeFrame, Channel: StreamAnomalies, Metrics: []string{MetricGasPrice}})
+	assert.False(t, response.Success)
+	assert.Contains(t, response.Response, "at most 50 filters")
+	subscriptions := response.Data.(StreamSubscriptions)
+	assert.Equal(t, MaxStreamFilters, subscriptions.Count, "a rejected frame changes nothing")
+	assert.Equal(t, MaxStreamFilters, subscriptions.MaxFilters)
+
+	// The same channel without values passes everything and counts once
+	response = engine.HandleStreamFrame("0xaaa", StreamSubscription{Type: StreamSubscribeFrame, Channel: StreamPrices})
+	require.True(t, response.Success, response.Response)
+	assert.Equal(t, 1, response.Data.(StreamSubscriptions).Count)
+
+	for _, frame := range []StreamSubscription{
+		{Type: StreamSubscribeFrame, Channel: "yields"},
+		{Type: StreamSubscribeFrame, Channel: StreamPrices, Metrics: []string{MetricGasPrice}},
+		{Type: StreamSubscribeFrame, Channel: StreamAnomalies, Metrics: []string{" "}},
+	} {
+		response := engine.HandleStreamFrame("0xaaa", frame)
+		assert.False(t, response.Success, frame.Channel)
+	}
+
+	response = engine.HandleStreamFrame("0xaaa", StreamSubscription{Type: StreamSubscriptionsFrame})
+	assert.True(t, response.Success)
+	assert.Equal(t, []StreamFilter{{Channel: StreamPrices}}, response.Data.(StreamSubscriptions).Filters)
+}
+
+func TestChatMessageReadsStreamFrames(t *testing.T) {
+	var message ChatMessage
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"f1","type":"subscribe","channel":"prices","symbols":["KAIA","ETH"]}`), &message))
+	require.NotNil(t, message.Stream)
+	assert.Equal(t, StreamSubscription{ID: "f1", Type: StreamSubscribeFrame, Channel: StreamPrices, Symbols: []string{"KAIA", "ETH"}}, *message.Stream)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"m1","type":"text","message":"hello"}`), &message))
+	assert.Nil(t, message.Stream)
+	assert.Equal(t, "hello", message.Message)
+}
@@ -0,0 +1,475 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// SubscriptionPlansTTL is how long the on-chain tiers are served before
+	// they are read again
+	SubscriptionPlansTTL = 10 * time.Minute
+	// UpsellWindowDays is how many days of usage upsell hints look at
+	UpsellWindowDays = 7
+	// UpsellMinLimitDays is on how many of those days a limit must have been
+	// hit before an upgrade is suggested, so a one-off spike doesn't count
+	UpsellMinLimitDays = 2
+	// FreeTierName is the plan of callers without an active subscription
+	FreeTierName = "Free"
+
+	// DefaultSubscriptionFeatures is the feature matrix used when none is configured
+	DefaultSubscriptionFeatures = "Free:50:5:3:weekly,Basic:500:50:20:daily,Premium:5000:500:100:daily"
+)
+
+// Report frequencies of a tier
+var reportFrequencies = map[string]bool{"none": true, "daily": true, "weekly": true, "monthly": true}
+
+// subscriptionABI covers the reads of SubscriptionContract
+const subscriptionABI = `[
+	{"type":"function","name":"totalTiers","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"getSubscriptionTier","stateMutability":"view","inputs":[{"name":"_tierId","type":"uint256"}],"outputs":[{"name":"tier","type":"tuple","components":[
+		{"name":"tierId","type":"uint256"},
+		{"name":"name","type":"string"},
+		{"name":"price","type":"uint256"},
+		{"name":"duration","type":"uint256"},
+		{"name":"isActive","type":"bool"},
+		{"name":"features","type":"string[]"}
+	]}]},
+	{"type":"function","name":"getUserSubscriptionStatus","stateMutability":"view","inputs":[{"name":"_user","type":"address"}],"outputs":[
+		{"name":"hasActiveSubscription","type":"bool"},
+		{"name":"tierId","type":"uint256"},
+		{"name":"endTime","type":"uint256"}
+	]}
+]`
+
+// subscriptionContractABI is the parsed subscriptionABI
+var subscriptionContractABI = mustParseABI(subscriptionABI)
+
+// SubscriptionTier is a tier as stored on chain
+type SubscriptionTier struct {
+	TierID   uint64
+	Name     string
+	Price    *big.Int
+	Duration time.Duration
+	Active   bool
+	Features []string
+}
+
+// onchainSubscriptionTier is the SubscriptionTier tuple of the contract
+type onchainSubscriptionTier struct {
+	TierId   *big.Int
+	Name     string
+	Price    *big.Int
+	Duration *big.Int
+	IsActive bool
+	Features []string
+}
+
+// SubscriptionStatus is an address's subscription as stored on chain
+type SubscriptionStatus struct {
+	Active  bool
+	TierID  uint64
+	EndTime time.Time
+}
+
+// SubscriptionReader reads subscription tiers and statuses
+type SubscriptionReader interface {
+	Tiers(ctx context.Context) ([]SubscriptionTier, error)
+	Status(ctx context.Context, user common.Address) (SubscriptionStatus, error)
+}
+
+// ChainSubscriptionReader reads tiers and statuses from SubscriptionContract
+type ChainSubscriptionReader struct {
+	caller   ethereum.ContractCaller
+	contract common.Address
+}
+
+// NewChainSubscriptionReader creates a reader for the deployed subscription contract
+func NewChainSubscriptionReader(caller ethereum.ContractCaller, contract common.Address) *ChainSubscriptionReader {
+	return &ChainSubscriptionReader{caller: caller, contract: contract}
+}
+
+// Tiers reads every tier, inactive ones included
+func (r *ChainSubscriptionReader) Tiers(ctx context.Context) ([]SubscriptionTier, error) {
+	out, err := r.call(ctx, "totalTiers")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tier count: %w", err)
+	}
+	total := out[0].(*big.Int)
+	if !total.IsUint64() {
+		return nil, fmt.Errorf("invalid tier count %s", total)
+	}
+
+	tiers := make([]SubscriptionTier, 0, total.Uint64())
+	for id := uint64(1); id <= total.Uint64(); id++ {
+		out, err := r.call(ctx, "getSubscriptionTier", new(big.Int).SetUint64(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tier %d: %w", id, err)
+		}
+		tier, ok := abi.ConvertType(out[0], new(onchainSubscriptionTier)).(*onchainSubscriptionTier)
+		if !ok {
+			return nil, fmt.Errorf("failed to decode tier %d", id)
+		}
+		tiers = append(tiers, SubscriptionTier{
+			TierID:   tier.TierId.Uint64(),
+			Name:     tier.Name,
+			Price:    tier.Price,
+			Duration: time.Duration(tier.Duration.Int64()) * time.Second,
+			Active:   tier.IsActive,
+			Features: tier.Features,
+		})
+	}
+	return tiers, nil
+}
+
+// Status reads the subscription of an address
+func (r *ChainSubscriptionReader) Status(ctx context.Context, user common.Address) (SubscriptionStatus, error) {
+	out, err := r.call(ctx, "getUserSubscriptionStatus", user)
+	if err != nil {
+		return SubscriptionStatus{}, fmt.Errorf("failed to read subscription of %s: %w", user.Hex(), err)
+	}
+	status := SubscriptionStatus{Active: out[0].(bool), TierID: out[1].(*big.Int).Uint64()}
+	if end := out[2].(*big.Int); end.Sign() > 0 {
+		status.EndTime = time.Unix(end.Int64(), 0).UTC()
+	}
+	return status, nil
+}
+
+func (r *ChainSubscriptionReader) call(ctx context.Context, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := subscriptionContractABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	contract := r.contract
+	result, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptionContractABI.Unpack(method, result)
+}
+
+// TierLimits are the server-side limits of a tier
+type TierLimits struct {
+	Tier string `json:"-"`
+	// QueryQuota is the daily number of chat messages and analytics tasks
+	QueryQuota int `json:"query_quota"`
+	// ActionQuota is the daily number of automated actions
+	ActionQuota     int    `json:"action_quota"`
+	AlertLimit      int    `json:"alert_limit"`
+	ReportFrequency string `json:"report_frequency"`
+}
+
+// ParseTierLimits parses "Tier:queries:actions:alerts:frequency" entries
+// separated by commas, the frequency being none, daily, weekly, or monthly
+func ParseTierLimits(spec string) ([]TierLimits, error) {
+	var tiers []TierLimits
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 5 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid tier features %q, expected Tier:queries:actions:alerts:frequency", entry)
+		}
+		limits := TierLimits{Tier: strings.TrimSpace(parts[0]), ReportFrequency: strings.ToLower(parts[4])}
+		for i, field := range []*int{&limits.QueryQuota, &limits.ActionQuota, &limits.AlertLimit} {
+			value, err := strconv.Atoi(parts[i+1])
+			if err != nil || value < 0 {
+				return nil, fmt.Errorf("invalid limit %q for tier %q", parts[i+1], limits.Tier)
+			}
+			*field = value
+		}
+		if !reportFrequencies[limits.ReportFrequency] {
+			return nil, fmt.Errorf("invalid report frequency %q for tier %q", parts[4], limits.Tier)
+		}
+		tiers = append(tiers, limits)
+	}
+	return tiers, nil
+}
+
+// SubscriptionPlan is a tier merged with its server-side limits
+type SubscriptionPlan struct {
+	// TierID is the on-chain tier, 0 for the free tier
+	TierID uint64 `json:"tier_id"`
+	Name   string `json:"name"`
+	// Price is in the KAIA token's smallest unit
+	Price           string      `json:"price"`
+	PriceKAIA       float64     `json:"price_kaia"`
+	DurationSeconds int64       `json:"duration_seconds"`
+	Active          bool        `json:"active"`
+	Features        []string    `json:"features"`
+	Limits          *TierLimits `json:"limits"`
+	Current         bool        `json:"current"`
+}
+
+// PlanUsage is the caller's quota usage on one UTC day
+type PlanUsage struct {
+	Date    string `json:"date"`
+	Queries int64  `json:"queries"`
+	Actions int64  `json:"actions"`
+}
+
+// CurrentSubscription is the caller's plan and recent usage
+type CurrentSubscription struct {
+	Address   string      `json:"address"`
+	TierID    uint64      `json:"tier_id"`
+	Plan      string      `json:"plan"`
+	Active    bool        `json:"active"`
+	ExpiresAt APITime     `json:"expires_at"`
+	Limits    *TierLimits `json:"limits"`
+	Usage     []PlanUsage `json:"usage"`
+}
+
+// UpsellHint suggests a plan whose limits fit the caller's usage
+type UpsellHint struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	// Plan is the suggested plan, empty when no plan has a higher limit
+	Plan string `json:"plan,omitempty"`
+}
+
+// Upsell hint reasons
+const (
+	UpsellQueryLimit  = "query_limit"
+	UpsellActionLimit = "action_limit"
+)
+
+// SubscriptionCatalog lists the plans, the caller's current plan and usage,
+// and upgrade hints
+type SubscriptionCatalog struct {
+	Plans   []SubscriptionPlan   `json:"plans"`
+	Current *CurrentSubscription `json:"current,omitempty"`
+	Hints   []UpsellHint         `json:"hints"`
+	// PlansReadAt is when the on-chain tiers were read
+	PlansReadAt APITime `json:"plans_read_at"`
+}
+
+// UsageHistory is the per-day activity an address's quota usage is read from
+type UsageHistory interface {
+	AddressUsage(address string, since time.Time) *AddressUsageDetail
+}
+
+// SubscriptionCatalogs builds plan catalogs from the on-chain tiers, the
+// configured feature matrix, and usage counters. Without a reader only the
+// feature matrix is listed.
+type SubscriptionCatalogs struct {
+	limits []TierLimits
+	usage  UsageHistory
+	now    func() time.Time
+
+	// mu guards the reader along with the tiers read from it
+	mu     sync.Mutex
+	reader SubscriptionReader
+	tiers  []SubscriptionTier
+	readAt time.Time
+}
+
+// NewSubscriptionCatalogs creates a catalog builder
+func NewSubscriptionCatalogs(reader SubscriptionReader, limits []TierLimits, usage UsageHistory) *SubscriptionCatalogs {
+	return &SubscriptionCatalogs{reader: reader, limits: limits, usage: usage, now: utcNow}
+}
+
+// SetReader swaps the contract tiers and statuses are read from, dropping
+// the cached tiers. It waits for a tier read in progress; a catalog being
+// built keeps reading from the contract it started with.
+func (sc *SubscriptionCatalogs) SetReader(reader SubscriptionReader) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.reader = reader
+	sc.tiers, sc.readAt = nil, time.Time{}
+}
+
+// Catalog returns the plans, along with the caller's current plan, usage, and
+// upsell hints when caller is a wallet address
+func (sc *SubscriptionCatalogs) Catalog(ctx context.Context, caller string) (*SubscriptionCatalog, error) {
+	tiers, readAt, reader, err := sc.readTiers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &SubscriptionCatalog{Plans: sc.plans(tiers), Hints: []UpsellHint{}, PlansReadAt: NewAPITime(readAt)}
+	if !common.IsHexAddress(caller) {
+		return catalog, nil
+	}
+
+	current, err := sc.current(ctx, reader, common.HexToAddress(caller), catalog.Plans)
+	if err != nil {
+		return nil, err
+	}
+	catalog.Current = current
+	for i := range catalog.Plans {
+		catalog.Plans[i].Current = catalog.Plans[i].Name == current.Plan
+	}
+	catalog.Hints = upsellHints(current, catalog.Plans)
+	return catalog, nil
+}
+
+// readTiers returns the on-chain tiers, read at most every
+// SubscriptionPlansTTL, and the reader they came from
+func (sc *SubscriptionCatalogs) readTiers(ctx context.Context) ([]SubscriptionTier, time.Time, SubscriptionReader, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	reader := sc.reader
+	if reader == nil {
+		return nil, time.Time{}, nil, nil
+	}
+
+	now := sc.now()
+	if sc.tiers != nil && now.Sub(sc.readAt) < SubscriptionPlansTTL {
+		return sc.tiers, sc.readAt, reader, nil
+	}
+	tiers, err := reader.Tiers(ctx)
+	if err != nil {
+		return nil, time.Time{}, nil, err
+	}
+	sc.tiers, sc.readAt = tiers, now
+	return tiers, now, reader, nil
+}
+
+// plans merges the tiers with their limits by name. Tiers are listed in
+// on-chain order, after the free tier; limits without a tier on chain are
+// listed as server-side plans.
+func (sc *SubscriptionCatalogs) plans(tiers []SubscriptionTier) []SubscriptionPlan {
+	limits := make(map[string]TierLimits, len(sc.limits))
+	for _, tier := range sc.limits {
+		limits[strings.ToLower(tier.Tier)] = tier
+	}
+
+	plans := make([]SubscriptionPlan, 0, len(tiers)+1)
+	listed := make(map[string]bool)
+	if free, ok := limits[strings.ToLower(FreeTierName)]; ok {
+		plans = append(plans, SubscriptionPlan{Name: FreeTierName, Price: "0", Active: true, Features: []string{}, Limits: &free})
+		listed[strings.ToLower(FreeTierName)] = true
+	}
+	for _, tier := range tiers {
+		plan := SubscriptionPlan{
+			TierID:          tier.TierID,
+			Name:            tier.Name,
+			Price:           tier.Price.String(),
+			PriceKAIA:       weiToFloat(tier.Price, defaultTokenDecimals),
+			DurationSeconds: int64(tier.Duration / time.Second),
+			Active:          tier.Active,
+			Features:        tier.Features,
+		}
+		if plan.Features == nil {
+			plan.Features = []string{}
+		}
+		if tierLimits, ok := limits[strings.ToLower(tier.Name)]; ok {
+			plan.Limits = &tierLimits
+		}
+		listed[strings.ToLower(tier.Name)] = true
+		plans = append(plans, plan)
+	}
+	for _, tier := range sc.limits {
+		if !listed[strings.ToLower(tier.Tier)] {
+			tierLimits := tier
+			plans = append(plans, SubscriptionPlan{Name: tier.Tier, Active: true, Features: []string{}, Limits: &tierLimits})
+			listed[strings.ToLower(tier.Tier)] = true
+		}
+	}
+	return plans
+}
+
+// current reads the caller's subscription and the last UpsellWindowDays of
+// their usage. A caller without an active subscription is on the free tier.
+func (sc *SubscriptionCatalogs) current(ctx context.Context, reader SubscriptionReader, caller common.Address, plans []SubscriptionPlan) (*CurrentSubscription, error) {
+	current := &CurrentSubscription{Address: strings.ToLower(caller.Hex()), Plan: FreeTierName, Usage: []PlanUsage{}}
+	if reader != nil {
+		status, err := reader.Status(ctx, caller)
+		if err != nil {
+			return nil, err
+		}
+		if status.Active {
+			current.Active = true
+			current.TierID = status.TierID
+			current.ExpiresAt = NewAPITime(status.EndTime)
+			current.Plan = fmt.Sprintf("tier %d", status.TierID)
+		}
+	}
+	for _, plan := range plans {
+		if current.Active && plan.TierID == current.TierID || !current.Active && plan.Name == FreeTierName {
+			current.Plan, current.Limits = plan.Name, plan.Limits
+			break
+		}
+	}
+
+	if sc.usage != nil {
+		now := sc.now()
+		since := time.Date(now.Year(), now.Month(), now.Day()-(UpsellWindowDays-1), 0, 0, 0, 0, time.UTC)
+		for _, day := range sc.usage.AddressUsage(current.Address, since).Days {
+			current.Usage = append(current.Usage, PlanUsage{
+				Date:    day.Date,
+				Queries: day.ChatMessages + day.AnalyticsTasks,
+				Actions: day.Actions,
+			})
+		}
+	}
+	return current, nil
+}
+
+// upsellHints suggests the smallest plan that would have fit the caller's
+// busiest day, for each quota the caller hit on at least UpsellMinLimitDays
+// days of the window
+func upsellHints(current *CurrentSubscription, plans []SubscriptionPlan) []UpsellHint {
+	hints := []UpsellHint{}
+	if current.Limits == nil {
+		return hints
+	}
+
+	quotas := []struct {
+		reason, noun, plural string
+		limit                func(*TierLimits) int
+		used                 func(PlanUsage) int64
+	}{
+		{UpsellQueryLimit, "query", "queries", func(l *TierLimits) int { return l.QueryQuota }, func(u PlanUsage) int64 { return u.Queries }},
+		{UpsellActionLimit, "action", "actions", func(l *TierLimits) int { return l.ActionQuota }, func(u PlanUsage) int64 { return u.Actions }},
+	}
+	for _, quota := range quotas {
+		limit := int64(quota.limit(current.Limits))
+		var hitDays int
+		var peak int64
+		for _, day := range current.Usage {
+			used := quota.used(day)
+			if used > 0 && used >= limit {
+				hitDays++
+			}
+			peak = max(peak, used)
+		}
+		if hitDays < UpsellMinLimitDays {
+			continue
+		}
+
+		hint := UpsellHint{
+			Reason:  quota.reason,
+			Message: fmt.Sprintf("You hit your %s limit %d of the last %d days.", quota.noun, hitDays, UpsellWindowDays),
+		}
+		candidates := make([]SubscriptionPlan, 0, len(plans))
+		for _, plan := range plans {
+			if plan.Active && plan.Limits != nil && int64(quota.limit(plan.Limits)) > peak {
+				candidates = append(candidates, plan)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return quota.limit(candidates[i].Limits) < quota.limit(candidates[j].Limits)
+		})
+		if len(candidates) > 0 {
+			hint.Plan = candidates[0].Name
+			hint.Message += fmt.Sprintf(" %s allows %d %s a day.", hint.Plan, quota.limit(candidates[0].Limits), quota.plural)
+		}
+		hints = append(hints, hint)
+	}
+	return hints
+}
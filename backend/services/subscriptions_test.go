@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	subscriptionContract = common.HexToAddress("0x00000000000000000000000000000000000005c1")
+	subscriber           = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+)
+
+// fakeSubscriptionContract answers the reads of SubscriptionContract
+type fakeSubscriptionContract struct {
+	tiers       []onchainSubscriptionTier
+	subscribers map[common.Address]SubscriptionStatus
+	calls       int
+}
+
+func (f *fakeSubscriptionContract) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	f.calls++
+	if *call.To != subscriptionContract {
+		return nil, errors.New("execution reverted")
+	}
+	method, err := subscriptionContractABI.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, errors.New("execution reverted")
+	}
+	args, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	switch method.Name {
+	case "totalTiers":
+		return method.Outputs.Pack(big.NewInt(int64(len(f.tiers))))
+	case "getSubscriptionTier":
+		id := args[0].(*big.Int).Int64()
+		if id < 1 || id > int64(len(f.tiers)) {
+			return nil, errors.New("execution reverted")
+		}
+		return method.Outputs.Pack(f.tiers[id-1])
+	case "getUserSubscriptionStatus":
+		status := f.subscribers[args[0].(common.Address)]
+		var end int64
+		if !status.EndTime.IsZero() {
+			end = status.EndTime.Unix()
+		}
+		return method.Outputs.Pack(status.Active, new(big.Int).SetUint64(status.TierID), big.NewInt(end))
+	}
+	return nil, errors.New("execution reverted")
+}
+
+// fakeUsageHistory serves fixed daily usage
+type fakeUsageHistory struct {
+	days  []DailyUsage
+	since time.Time
+}
+
+func (f *fakeUsageHistory) AddressUsage(address string, since time.Time) *AddressUsageDetail {
+	f.since = since
+	return &AddressUsageDetail{Address: address, Days: f.days}
+}
+
+func queryDays(queries ...int64) []DailyUsage {
+	days := make([]DailyUsage, len(queries))
+	for i, count := range queries {
+		days[i] = DailyUsage{
+			Date:        time.Date(2025, 6, 1+i, 0, 0, 0, 0, time.UTC).Format(usageDayLayout),
+			UsageCounts: UsageCounts{ChatMessages: count / 2, AnalyticsTasks: count - count/2},
+		}
+	}
+	return days
+}
+
+func newTestSubscriptionCatalogs(t *testing.T, usage UsageHistory) (*SubscriptionCatalogs, *fakeSubscriptionContract, *testClock) {
+	contract := &fakeSubscriptionContract{
+		tiers: []onchainSubscriptionTier{
+			{TierId: big.NewInt(1), Name: "basic", Price: units(100, 18), Duration: big.NewInt(30 * 86400), IsActive: true, Features: []string{"analytics"}},
+			{TierId: big.NewInt(2), Name: "Premium", Price: units(500, 18), Duration: big.NewInt(30 * 86400), IsActive: true, Features: []string{"analytics", "actions"}},
+			{TierId: big.NewInt(3), Name: "Legacy", Price: units(50, 18), Duration: big.NewInt(86400), IsActive: false},
+		},
+		subscribers: map[common.Address]SubscriptionStatus{},
+	}
+	limits, err := ParseTierLimits(DefaultSubscriptionFeatures)
+	require.NoError(t, err)
+
+	clock := &testClock{now: time.Date(2025, 6, 7, 15, 0, 0, 0, time.UTC)}
+	catalogs := NewSubscriptionCatalogs(NewChainSubscriptionReader(contract, subscriptionContract), limits, usage)
+	catalogs.now = clock.Now
+	return catalogs, contract, clock
+}
+
+func TestSubscriptionCatalogMergesTiersWithLimits(t *testing.T) {
+	catalogs, contract, clock := newTestSubscriptionCatalogs(t, &fakeUsageHistory{})
+
+	catalog, err := catalogs.Catalog(context.Background(), "")
+	require.NoError(t, err)
+	assert.Nil(t, catalog.Current)
+	assert.Empty(t, catalog.Hints)
+
+	names := make([]string, len(catalog.Plans))
+	for i, plan := range catalog.Plans {
+		names[i] = plan.Name
+	}
+	assert.Equal(t, []string{"Free", "basic", "Premium", "Legacy"}, names)
+
+	free := catalog.Plans[0]
+	assert.Equal(t, uint64(0), free.TierID)
+	assert.Equal(t, 50, free.Limits.QueryQuota)
+
+	// On-chain names match the feature matrix case-insensitively
+	basic := catalog.Plans[1]
+	assert.Equal(t, uint64(1), basic.TierID)
+	assert.Equal(t, units(100, 18).String(), basic.Price)
+	assert.Equal(t, 100.0, basic.PriceKAIA)
+	assert.Equal(t, int64(30*86400), basic.DurationSeconds)
+	assert.Equal(t, []string{"analytics"}, basic.Features)
+	require.NotNil(t, basic.Limits)
+	assert.Equal(t, TierLimits{Tier: "Basic", QueryQuota: 500, ActionQuota: 50, AlertLimit: 20, ReportFrequency: "daily"}, *basic.Limits)
+
+	legacy := catalog.Plans[3]
+	assert.False(t, legacy.Active)
+	assert.Nil(t, legacy.Limits)
+	assert.Equal(t, []string{}, legacy.Features)
+
+	// The tiers are read again only after SubscriptionPlansTTL
+	calls := contract.calls
+	clock.Advance(9 * time.Minute)
+	_, err = catalogs.Catalog(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, calls, contract.calls)
+
+	clock.Advance(2 * time.Minute)
+	catalog, err = catalogs.Catalog(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, 2*calls, contract.calls)
+	assert.Equal(t, clock.Now(), catalog.PlansReadAt.Time)
+}
+
+func TestSubscriptionCatalogUpsellHints(t *testing.T) {
+	t.Run("free caller over the query limit", func(t *testing.T) {
+		usage := &fakeUsageHistory{days: queryDays(60, 10, 55, 50, 12, 80, 300)}
+		catalogs, _, _ := newTestSubscriptionCatalogs(t, usage)
+
+		catalog, err := catalogs.Catalog(context.Background(), subscriber.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), usage.since)
+
+		current := catalog.Current
+		assert.Equal(t, FreeTierName, current.Plan)
+		assert.False(t, current.Active)
+		assert.True(t, catalog.Plans[0].Current)
+		require.Len(t, current.Usage, 7)
+		assert.Equal(t, PlanUsage{Date: "2025-06-07", Queries: 300}, current.Usage[6])
+
+		assert.Equal(t, []UpsellHint{{
+			Reason:  UpsellQueryLimit,
+			Message: "You hit your query limit 5 of the last 7 days. basic allows 500 queries a day.",
+			Plan:    "basic",
+		}}, catalog.Hints)
+	})
+
+	t.Run("subscriber whose busiest day needs a bigger plan", func(t *testing.T) {
+		usage := &fakeUsageHistory{days: queryDays(520, 100, 600)}
+		catalogs, contract, _ := newTestSubscriptionCatalogs(t, usage)
+		contract.subscribers[subscriber] = SubscriptionStatus{Active: true, TierID: 1, EndTime: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)}
+
+		catalog, err := catalogs.Catalog(context.Background(), subscriber.Hex())
+		require.NoError(t, err)
+
+		current := catalog.Current
+		assert.Equal(t, "basic", current.Plan)
+		assert.Equal(t, uint64(1), current.TierID)
+		assert.Equal(t, time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC), current.ExpiresAt.Time)
+		assert.True(t, catalog.Plans[1].Current)
+		assert.False(t, catalog.Plans[0].Current)
+
+		require.Len(t, catalog.Hints, 1)
+		assert.Equal(t, "Premium", catalog.Hints[0].Plan)
+		assert.Equal(t, "You hit your query limit 2 of the last 7 days. Premium allows 5000 queries a day.", catalog.Hints[0].Message)
+	})
+
+	t.Run("one spike or top plan", func(t *testing.T) {
+		usage := &fakeUsageHistory{days: queryDays(10, 600, 10)}
+		catalogs, contract, _ := newTestSubscriptionCatalogs(t, usage)
+
+		catalog, err := catalogs.Catalog(context.Background(), subscriber.Hex())
+		require.NoError(t, err)
+		assert.Empty(t, catalog.Hints)
+
+		usage.days = queryDays(6000, 7000)
+		usage.days[0].Actions = 2
+		contract.subscribers[subscriber] = SubscriptionStatus{Active: true, TierID: 2, EndTime: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)}
+		catalog, err = catalogs.Catalog(context.Background(), subscriber.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, []UpsellHint{{Reason: UpsellQueryLimit, Message: "You hit your query limit 2 of the last 7 days."}}, catalog.Hints)
+	})
+}
+
+func TestParseTierLimits(t *testing.T) {
+	limits, err := ParseTierLimits(" Free:10:0:1:none, Pro:100:10:5:Monthly ,")
+	require.NoError(t, err)
+	assert.Equal(t, []TierLimits{
+		{Tier: "Free", QueryQuota: 10, ActionQuota: 0, AlertLimit: 1, ReportFrequency: "none"},
+		{Tier: "Pro", QueryQuota: 100, ActionQuota: 10, AlertLimit: 5, ReportFrequency: "monthly"},
+	}, limits)
+
+	for _, spec := range []string{"Free:10:0:1", "Free:ten:0:1:none", "Free:10:-1:1:none", "Free:10:0:1:hourly", ":1:1:1:daily"} {
+		_, err := ParseTierLimits(spec)
+		assert.Error(t, err, spec)
+	}
+}
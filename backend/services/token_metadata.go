@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// TokenListRefreshInterval is how often the token list is fetched again
+	TokenListRefreshInterval = 6 * time.Hour
+	// tokenTransferCheckTTL is how long a transfer simulation result is reused
+	tokenTransferCheckTTL = 24 * time.Hour
+)
+
+// Spam reasons set on token holdings
+const (
+	SpamReasonURLInName          = "url_in_name"
+	SpamReasonNoLiquidity        = "no_liquidity"
+	SpamReasonTransferRestricted = "transfer_restricted"
+)
+
+// tokenMetadataABI covers the ERC-20 metadata reads and the transfer simulated
+// to detect honeypots
+const tokenMetadataABI = `[
+	{"type":"function","name":"name","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"symbol","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"string"}]},
+	{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}
+]`
+
+// tokenMetadataCalls is the parsed tokenMetadataABI
+var tokenMetadataCalls = mustParseABI(tokenMetadataABI)
+
+// transferProbeRecipient receives the simulated transfers. Nothing is sent:
+// the transfer only runs as an eth_call.
+var transferProbeRecipient = common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+
+// urlPattern matches links and bare domains that airdropped spam tokens put in
+// their names to lure holders to phishing sites
+var urlPattern = regexp.MustCompile(`(?i)(https?://|www\.|\b[a-z0-9-]+\.(com|io|org|net|xyz|app|finance|site|top|vip|me|co|gift|claims?)\b)`)
+
+// TokenListEntry is a token of a Uniswap-format token list
+type TokenListEntry struct {
+	ChainID  int64  `json:"chainId"`
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+	LogoURI  string `json:"logoURI"`
+}
+
+// TokenMetadata is what is known about a token contract
+type TokenMetadata struct {
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+	LogoURL  string `json:"logo_url,omitempty"`
+	// Verified is set when the token is on the configured token list
+	Verified bool `json:"verified"`
+}
+
+// transferCheck is the cached result of a simulated transfer
+type transferCheck struct {
+	restricted bool
+	checkedAt  time.Time
+}
+
+// TokenMetadataService enriches token holdings with on-chain metadata and the
+// logos of a token list, and flags unverified tokens that look like spam.
+// Tokens on the list are verified and never flagged.
+type TokenMetadataService struct {
+	caller     ethereum.ContractCaller
+	listURL    string
+	chainID    int64
+	httpClient *http.Client
+	logger     *log.Logger
+	now        func() time.Time
+
+	mu        sync.RWMutex
+	list      map[common.Address]TokenListEntry
+	onchain   map[common.Address]TokenMetadata
+	transfers map[common.Address]transferCheck
+}
+
+// NewTokenMetadataService creates a service reading metadata through the
+// caller. listURL is a Uniswap-format token list; only its tokens on chainID
+// are used, or all of them when chainID is 0. An empty listURL verifies nothing.
+func NewTokenMetadataService(caller ethereum.ContractCaller, listURL string, chainID int64) *TokenMetadataService {
+	return &TokenMetadataService{
+		caller:     caller,
+		listURL:    listURL,
+		chainID:    chainID,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		logger:     log.New(log.Writer(), "[TokenMetadata] ", log.LstdFlags),
+		now:        utcNow,
+		list:       make(map[common.Address]TokenListEntry),
+		onchain:    make(map[common.Address]TokenMetadata),
+		transfers:  make(map[common.Address]transferCheck),
+	}
+}
+
+// Start loads the token list and reloads it every TokenListRefreshInterval
+// until ctx is cancelled. A failed load keeps the previous list.
+func (tm *TokenMetadataService) Start(ctx context.Context) {
+	if tm.listURL == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(TokenListRefreshInterval)
+		defer ticker.Stop()
+		for {
+			if err := tm.LoadTokenList(ctx); err != nil {
+				tm.logger.Printf("Failed to load token list: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// LoadTokenList fetches the token list and replaces the verified tokens
+func (tm *TokenMetadataService) LoadTokenList(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tm.listURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := tm.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch token list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token list returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Tokens []TokenListEntry `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to decode token list: %w", err)
+	}
+	tm.SetTokenList(body.Tokens)
+	return nil
+}
+
+// SetTokenList replaces the verified tokens with the list's tokens on the chain
+func (tm *TokenMetadataService) SetTokenList(tokens []TokenListEntry) {
+	list := make(map[common.Address]TokenListEntry, len(tokens))
+	for _, token := range tokens {
+		if (tm.chainID != 0 && token.ChainID != tm.chainID) || !common.IsHexAddress(token.Address) {
+			continue
+		}
+		list[common.HexToAddress(token.Address)] = token
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.list = list
+	tm.logger.Printf("Loaded %d verified tokens", len(list))
+}
+
+// Metadata returns what is known about the token. Listed tokens take their
+// name, symbol, and logo from the list; decimals always come from the
+// contract when it answers, and on-chain reads are cached.
+func (tm *TokenMetadataService) Metadata(ctx context.Context, token common.Address) (TokenMetadata, error) {
+	tm.mu.RLock()
+	entry, listed := tm.list[token]
+	metadata, cached := tm.onchain[token]
+	tm.mu.RUnlock()
+
+	if !cached {
+		var err error
+		metadata, err = tm.readMetadata(ctx, token)
+		if err != nil {
+			if !listed {
+				return TokenMetadata{}, err
+			}
+			metadata = TokenMetadata{Decimals: entry.Decimals}
+		} else {
+			tm.mu.Lock()
+			tm.onchain[token] = metadata
+			tm.mu.Unlock()
+		}
+	}
+
+	if listed {
+		metadata.Name = entry.Name
+		metadata.Symbol = entry.Symbol
+		metadata.LogoURL = entry.LogoURI
+		metadata.Verified = true
+	}
+	return metadata, nil
+}
+
+// readMetadata reads the name, symbol, and decimals of the contract. Name and
+// symbol are optional in ERC-20, so only decimals must be readable.
+func (tm *TokenMetadataService) readMetadata(ctx context.Context, token common.Address) (TokenMetadata, error) {
+	out, err := tm.call(ctx, common.Address{}, token, "decimals")
+	if err != nil {
+		return TokenMetadata{}, fmt.Errorf("failed to read decimals of %s: %w", token.Hex(), err)
+	}
+	metadata := TokenMetadata{Decimals: int(out[0].(uint8))}
+	if out, err := tm.call(ctx, common.Address{}, token, "name"); err == nil {
+		metadata.Name = out[0].(string)
+	}
+	if out, err := tm.call(ctx, common.Address{}, token, "symbol"); err == nil {
+		metadata.Symbol = out[0].(string)
+	}
+	return metadata, nil
+}
+
+// transferRestricted simulates the holder sending its balance and reports
+// whether the token refuses it. Honeypots let anyone buy but revert, or return
+// false, on transfers from anyone but their owners. A node error is not a
+// restriction, and results are cached per token.
+func (tm *TokenMetadataService) transferRestricted(ctx context.Context, token, holder common.Address, amount *big.Int) bool {
+	now := tm.now()
+	tm.mu.RLock()
+	check, ok := tm.transfers[token]
+	tm.mu.RUnlock()
+	if ok && now.Sub(check.checkedAt) < tokenTransferCheckTTL {
+		return check.restricted
+	}
+
+	out, err := tm.call(ctx, holder, token, "transfer", transferProbeRecipient, amount)
+	restricted := false
+	switch {
+	case err != nil && isRevert(err):
+		restricted = true
+	case err != nil:
+		return false
+	case len(out) == 1:
+		restricted = !out[0].(bool)
+	}
+
+	tm.mu.Lock()
+	tm.transfers[token] = transferCheck{restricted: restricted, checkedAt: now}
+	tm.mu.Unlock()
+	return restricted
+}
+
+// Enrich sets the metadata of a holding of the holder and classifies it. The
+// holding's balance must already be normalized by the token's decimals.
+func (tm *TokenMetadataService) Enrich(ctx context.Context, holder common.Address, holding *TokenHolding, raw *big.Int) {
+	token := common.HexToAddress(holding.Contract)
+	if metadata, err := tm.Metadata(ctx, token); err == nil {
+		holding.Name = metadata.Name
+		holding.LogoURL = metadata.LogoURL
+		holding.Verified = metadata.Verified
+	}
+	if holding.Verified {
+		return
+	}
+
+	if urlPattern.MatchString(holding.Name) || urlPattern.MatchString(holding.Symbol) {
+		holding.SpamReasons = append(holding.SpamReasons, SpamReasonURLInName)
+	}
+	if holding.PriceUSD == 0 && holding.LPPosition == nil {
+		holding.SpamReasons = append(holding.SpamReasons, SpamReasonNoLiquidity)
+	}
+	if tm.transferRestricted(ctx, token, holder, raw) {
+		holding.SpamReasons = append(holding.SpamReasons, SpamReasonTransferRestricted)
+	}
+	holding.Spam = len(holding.SpamReasons) > 0
+}
+
+func (tm *TokenMetadataService) call(ctx context.Context, from, token common.Address, method string, args ...interface{}) ([]interface{}, error) {
+	data, err := tokenMetadataCalls.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := tm.caller.CallContract(ctx, ethereum.CallMsg{From: from, To: &token, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Some tokens return nothing from transfer rather than a bool
+	if method == "transfer" && len(result) == 0 {
+		return nil, nil
+	}
+	return tokenMetadataCalls.Unpack(method, result)
+}
+
+// FilterSpam returns the holdings not flagged as spam and how many were hidden
+func FilterSpam(holdings []TokenHolding) ([]TokenHolding, int) {
+	kept := make([]TokenHolding, 0, len(holdings))
+	for _, holding := range holdings {
+		if !holding.Spam {
+			kept = append(kept, holding)
+		}
+	}
+	return kept, len(holdings) - len(kept)
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeERC20 is a token contract answering balanceOf and tokenMetadataABI
+type fakeERC20 struct {
+	name, symbol string
+	decimals     uint8
+	balance      *big.Int
+	// honeypot reverts transfers; refuses returns false from them
+	honeypot, refuses bool
+	transfers         int
+}
+
+// fakeTokens serves several fakeERC20 contracts by address
+type fakeTokens map[common.Address]*fakeERC20
+
+func (f fakeTokens) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	token, ok := f[*call.To]
+	if !ok {
+		return nil, errors.New("execution reverted")
+	}
+	if bytes.Equal(call.Data[:4], erc20BalanceOfSelector) {
+		return common.LeftPadBytes(token.balance.Bytes(), 32), nil
+	}
+	method, err := tokenMetadataCalls.MethodById(call.Data[:4])
+	if err != nil {
+		return nil, errors.New("execution reverted")
+	}
+	switch method.Name {
+	case "name":
+		return method.Outputs.Pack(token.name)
+	case "symbol":
+		return method.Outputs.Pack(token.symbol)
+	case "decimals":
+		return method.Outputs.Pack(token.decimals)
+	}
+	token.transfers++
+	if call.From != summaryAddress {
+		return nil, errors.New("execution reverted: insufficient balance")
+	}
+	if token.honeypot {
+		return nil, errors.New("execution reverted: TRANSFER_FORBIDDEN")
+	}
+	return method.Outputs.Pack(!token.refuses)
+}
+
+func TestTokenMetadataFlagsSpamHoldings(t *testing.T) {
+	usdt := common.HexToAddress("0x00000000000000000000000000000000000000d1")
+	airdrop := common.HexToAddress("0x00000000000000000000000000000000000000d2")
+	honeypot := common.HexToAddress("0x00000000000000000000000000000000000000d3")
+	refuses := common.HexToAddress("0x00000000000000000000000000000000000000d4")
+	plain := common.HexToAddress("0x00000000000000000000000000000000000000d5")
+	contracts := fakeTokens{
+		usdt:     {name: "Tether USD", symbol: "USDT", decimals: 6, balance: big.NewInt(250_000_000)},
+		airdrop:  {name: "Claim rewards at kaia-bonus.xyz", symbol: "BONUS", decimals: 18, balance: big.NewInt(1e18)},
+		honeypot: {name: "Moon", symbol: "MOON", decimals: 18, balance: big.NewInt(3e18), honeypot: true},
+		refuses:  {name: "Stuck", symbol: "STUCK", decimals: 18, balance: big.NewInt(1e18), refuses: true},
+		plain:    {name: "Plain", symbol: "PLN", decimals: 18, balance: big.NewInt(2e18)},
+	}
+
+	metadata := NewTokenMetadataService(contracts, "", 8217)
+	metadata.SetTokenList([]TokenListEntry{
+		{ChainID: 8217, Address: usdt.Hex(), Name: "Tether USD", Symbol: "USDT", Decimals: 6, LogoURI: "https://tokens.example/usdt.png"},
+		{ChainID: 1001, Address: plain.Hex(), Name: "Plain", Symbol: "PLN", Decimals: 18},
+	})
+
+	// USDT is misconfigured with 18 decimals; the contract's 6 win
+	reader := NewERC20BalanceReader(contracts, []TrackedToken{
+		{Symbol: "USDT", Address: usdt, Decimals: 18},
+		{Symbol: "BONUS", Address: airdrop, Decimals: 18},
+		{Symbol: "MOON", Address: honeypot, Decimals: 18},
+		{Symbol: "STUCK", Address: refuses, Decimals: 18},
+		{Symbol: "PLN", Address: plain, Decimals: 18},
+	}, fakePrices{"USDT": 1, "MOON": 4, "STUCK": 2, "PLN": 0.5})
+	reader.SetTokenMetadata(metadata)
+
+	holdings, err := reader.TokenBalances(context.Background(), summaryAddress)
+	require.NoError(t, err)
+	require.Len(t, holdings, 5)
+
+	verified := holdings[0]
+	assert.Equal(t, 250.0, verified.Balance)
+	assert.Equal(t, 6, verified.Decimals)
+	assert.Equal(t, 250.0, verified.ValueUSD)
+	assert.Equal(t, "https://tokens.example/usdt.png", verified.LogoURL)
+	assert.True(t, verified.Verified)
+	assert.False(t, verified.Spam)
+	assert.Zero(t, contracts[usdt].transfers, "verified tokens aren't simulated")
+
+	assert.Equal(t, []string{SpamReasonURLInName, SpamReasonNoLiquidity}, holdings[1].SpamReasons)
+	assert.Equal(t, []string{SpamReasonTransferRestricted}, holdings[2].SpamReasons)
+	assert.Equal(t, []string{SpamReasonTransferRestricted}, holdings[3].SpamReasons)
+	assert.False(t, holdings[4].Verified, "listed on another chain")
+	assert.False(t, holdings[4].Spam)
+	assert.Equal(t, "Plain", holdings[4].Name)
+
+	// Transfer simulations are cached
+	_, err = reader.TokenBalances(context.Background(), summaryAddress)
+	require.NoError(t, err)
+	assert.Equal(t, 1, contracts[honeypot].transfers)
+
+	kept, hidden := FilterSpam(holdings)
+	assert.Equal(t, 3, hidden)
+	require.Len(t, kept, 2)
+
+	// Summaries hide spam unless asked, and never count it in the total
+	summarizer := NewAddressSummarizer(fakeNativeBalances{balance: new(big.Int)}, reader, NewTransactionIndex(), fakePrices{NativeSymbol: 0.2})
+	summary, err := summarizer.Summarize(context.Background(), summaryAddress)
+	require.NoError(t, err)
+	assert.Len(t, summary.TopTokens, 2)
+	assert.Equal(t, 3, summary.HiddenSpamTokens)
+	assert.Equal(t, 251.0, summary.TotalValueUSD)
+
+	summary, err = summarizer.SummarizeWith(context.Background(), summaryAddress, SummaryOptions{IncludeSpam: true})
+	require.NoError(t, err)
+	assert.Len(t, summary.TopTokens, 5)
+	assert.Equal(t, "MOON", summary.TopTokens[1].Symbol)
+	assert.Zero(t, summary.HiddenSpamTokens)
+	assert.Equal(t, 251.0, summary.TotalValueUSD)
+}
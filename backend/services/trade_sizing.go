@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ReferenceImpact is the price impact, in percent, that trade sizes are
+// reported at regardless of the user's slippage tolerance
+const ReferenceImpact = 1.0
+
+// ErrNoPoolDepth is returned when none of the DEX pairs trades an asset
+var ErrNoPoolDepth = errors.New("no liquidity pool trades the asset")
+
+// PoolDepth is a constant-product pool's reserves of an asset and the token
+// it trades against, in whole tokens
+type PoolDepth struct {
+	Pool         common.Address `json:"pool"`
+	Pair         string         `json:"pair"`
+	Asset        string         `json:"asset"`
+	AssetReserve float64        `json:"asset_reserve"`
+	QuoteAsset   string         `json:"quote_asset"`
+	QuoteReserve float64        `json:"quote_reserve"`
+}
+
+// sellsAsset tells whether a trade of the side puts the asset into the pool.
+// A swap trades the asset away for the other leg.
+func sellsAsset(side string) bool {
+	return side != "buy"
+}
+
+// PriceImpact is how far the execution price of trading amount of the asset
+// falls short of the pool's spot price, in percent. Selling dx into reserve
+// x executes at x/(x+dx) of spot; buying dy out of reserve y at (y-dy)/y.
+func (d *PoolDepth) PriceImpact(side string, amount float64) float64 {
+	if amount <= 0 {
+		return 0
+	}
+	if sellsAsset(side) {
+		return 100 * amount / (d.AssetReserve + amount)
+	}
+	if amount >= d.AssetReserve {
+		return 100
+	}
+	return 100 * amount / d.AssetReserve
+}
+
+// MaxTradeSize is the largest amount of the asset a trade of the side can
+// move while keeping the price impact at or under impact percent
+func (d *PoolDepth) MaxTradeSize(side string, impact float64) float64 {
+	if impact <= 0 {
+		return 0
+	}
+	p := math.Min(impact, 100) / 100
+	if !sellsAsset(side) {
+		return d.AssetReserve * p
+	}
+	if p >= 1 {
+		return math.Inf(1)
+	}
+	return d.AssetReserve * p / (1 - p)
+}
+
+// TranchePlan splits an order too large for a pool into equal tranches that
+// each stay under the impact bound, assuming arbitrage restores the pool's
+// price between them
+type TranchePlan struct {
+	Amount        float64 `json:"amount"`
+	Tranches      int     `json:"tranches"`
+	TrancheAmount float64 `json:"tranche_amount"`
+	// TrancheImpact is the price impact of each tranche, in percent
+	TrancheImpact float64 `json:"tranche_impact"`
+	// SingleImpact is the price impact of trading the amount at once
+	SingleImpact float64 `json:"single_impact"`
+}
+
+// SplitOrder plans the tranches of trading amount of the asset with at most
+// impact percent of price impact each. Returns nil when the amount fits in
+// one trade.
+func (d *PoolDepth) SplitOrder(side string, amount, impact float64) *TranchePlan {
+	maxSize := d.MaxTradeSize(side, impact)
+	if amount <= maxSize || maxSize <= 0 {
+		return nil
+	}
+
+	tranches := int(math.Ceil(amount / maxSize))
+	trancheAmount := amount / float64(tranches)
+	return &TranchePlan{
+		Amount:        amount,
+		Tranches:      tranches,
+		TrancheAmount: trancheAmount,
+		TrancheImpact: d.PriceImpact(side, trancheAmount),
+		SingleImpact:  d.PriceImpact(side, amount),
+	}
+}
+
+// PoolDepthReader finds the depth of assets in the configured DEX pairs
+type PoolDepthReader struct {
+	pools  *LiquidityPoolReader
+	pairs  []common.Address
+	logger *log.Logger
+}
+
+// NewPoolDepthReader creates a reader over the pairs
+func NewPoolDepthReader(pools *LiquidityPoolReader, pairs []common.Address) *PoolDepthReader {
+	return &PoolDepthReader{
+		pools:  pools,
+		pairs:  pairs,
+		logger: log.New(log.Writer(), "[PoolDepthReader] ", log.LstdFlags),
+	}
+}
+
+// Depth returns the pair holding the largest reserve of the asset. Wrapped
+// tokens stand in for the native asset, so WKAIA pools are KAIA depth.
+func (r *PoolDepthReader) Depth(ctx context.Context, asset string) (*PoolDepth, error) {
+	var pools []*LiquidityPool
+	for _, pair := range r.pairs {
+		pool, err := r.pools.Pool(ctx, pair)
+		if err != nil {
+			r.logger.Printf("Failed to read pair %s: %v", pair.Hex(), err)
+			continue
+		}
+		if sameAsset(pool.Token0.Symbol, asset) || sameAsset(pool.Token1.Symbol, asset) {
+			pools = append(pools, pool)
+		}
+	}
+
+	// The reserves of every pool holding the asset are read in one batch
+	var deepest *PoolDepth
+	for i, reserves := range r.pools.ReservesOf(ctx, pools) {
+		if reserves.Err != nil {
+			r.logger.Printf("%v", reserves.Err)
+			continue
+		}
+		pool := pools[i]
+		assetLeg, quoteLeg := pool.Token0, pool.Token1
+		reserve0, reserve1 := reserves.Reserve0, reserves.Reserve1
+		if !sameAsset(pool.Token0.Symbol, asset) {
+			assetLeg, quoteLeg = pool.Token1, pool.Token0
+			reserve0, reserve1 = reserve1, reserve0
+		}
+		depth := &PoolDepth{
+			Pool:         pool.Address,
+			Pair:         pool.Pair(),
+			Asset:        assetLeg.Symbol,
+			AssetReserve: weiToFloat(reserve0, assetLeg.Decimals),
+			QuoteAsset:   quoteLeg.Symbol,
+			QuoteReserve: weiToFloat(reserve1, quoteLeg.Decimals),
+		}
+		if depth.AssetReserve > 0 && (deepest == nil || depth.AssetReserve > deepest.AssetReserve) {
+			deepest = depth
+		}
+	}
+	if deepest == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoPoolDepth, asset)
+	}
+	return deepest, nil
+}
+
+// sameAsset matches a pool token's symbol to an asset, in any case, taking a
+// wrapped token for its underlying asset
+func sameAsset(symbol, asset string) bool {
+	return strings.EqualFold(symbol, asset) || strings.EqualFold(symbol, "W"+asset)
+}
+
+// sizeSuggestion sets the suggestion's size at the reference impact and caps
+// its amount to the size keeping the impact under slippage percent
+func sizeSuggestion(suggestion *TradingSuggestion, depth *PoolDepth, slippage float64) {
+	suggestion.MaxSizeAt1PctImpact = floorTo(depth.MaxTradeSize(suggestion.Type, ReferenceImpact), 4)
+
+	maxSize := floorTo(depth.MaxTradeSize(suggestion.Type, slippage), 4)
+	if suggestion.Amount <= maxSize {
+		return
+	}
+	suggestion.Reasoning += fmt.Sprintf(" Sized down from %s %s so the trade moves the %s pool's price by at most %s.",
+		NumberFormat{}.Token(suggestion.Amount, 0), suggestion.Asset, depth.Pair, NumberFormat{}.Percent(slippage, 2))
+	suggestion.Amount = maxSize
+}
+
+// floorTo rounds value down to the decimal places, so a rounded cap never
+// exceeds the bound it was computed from
+func floorTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Floor(value*scale) / scale
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolDepthImpactMath(t *testing.T) {
+	depth, err := NewPoolDepthReader(newTestPoolReader(newFiftyFiftyPair(), nil), []common.Address{lpPair}).Depth(context.Background(), "ETH")
+	require.NoError(t, err)
+	assert.Equal(t, "USDC/WETH", depth.Pair)
+	assert.Equal(t, 500.0, depth.AssetReserve)
+	assert.Equal(t, "USDC", depth.QuoteAsset)
+	assert.Equal(t, 1_000_000.0, depth.QuoteReserve)
+
+	// Selling dx into the 500 WETH reserve costs dx/(500+dx); buying dy out of it dy/500
+	assert.InDelta(t, 100.0/101, depth.PriceImpact("sell", 5), 1e-9)
+	assert.InDelta(t, 1, depth.PriceImpact("buy", 5), 1e-9)
+	assert.InDelta(t, 500.0/99, depth.MaxTradeSize("sell", 1), 1e-9)
+	assert.InDelta(t, 5, depth.MaxTradeSize("buy", 1), 1e-9)
+	for _, side := range []string{"buy", "sell", "swap"} {
+		assert.InDelta(t, 0.5, depth.PriceImpact(side, depth.MaxTradeSize(side, 0.5)), 1e-9, side)
+	}
+
+	_, err = NewPoolDepthReader(newTestPoolReader(newFiftyFiftyPair(), nil), []common.Address{lpPair}).Depth(context.Background(), "DAI")
+	assert.ErrorIs(t, err, ErrNoPoolDepth)
+}
+
+func TestPoolDepthSplitOrder(t *testing.T) {
+	depth := &PoolDepth{Pair: "USDC/WETH", Asset: "WETH", AssetReserve: 500, QuoteAsset: "USDC", QuoteReserve: 1_000_000}
+
+	assert.Nil(t, depth.SplitOrder("swap", 2, 0.5), "2 WETH fits under 0.5% impact")
+
+	// At most 2.51 WETH fits under 0.5%, so 20 WETH takes 8 swaps of 2.5
+	plan := depth.SplitOrder("swap", 20, 0.5)
+	require.NotNil(t, plan)
+	assert.Equal(t, 8, plan.Tranches)
+	assert.InDelta(t, 2.5, plan.TrancheAmount, 1e-9)
+	assert.InDelta(t, 100*2.5/502.5, plan.TrancheImpact, 1e-9)
+	assert.Less(t, plan.TrancheImpact, 0.5)
+	assert.InDelta(t, 100*20.0/520, plan.SingleImpact, 1e-9)
+}
+
+func TestTradingSuggestionsSizedByPoolDepth(t *testing.T) {
+	// A thin pool of 20 WETH
+	pair := newFiftyFiftyPair()
+	pair.reserve1 = units(20, 18)
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+	engine.SetPoolDepths(NewPoolDepthReader(newTestPoolReader(pair, nil), []common.Address{lpPair}))
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "trading_suggestions", map[string]interface{}{
+		"user_address": "0xuser",
+		"slippage":     0.5,
+	})
+	require.NoError(t, err)
+	suggestions := map[string]TradingSuggestion{}
+	for _, suggestion := range result.Data.([]TradingSuggestion) {
+		suggestions[suggestion.Asset] = suggestion
+	}
+
+	// Buying 0.5 ETH would move the pool 2.5%, so it is capped to 0.1
+	eth := suggestions["ETH"]
+	assert.Equal(t, 0.1, eth.Amount)
+	assert.Equal(t, 0.2, eth.MaxSizeAt1PctImpact)
+	assert.Contains(t, eth.Reasoning, "Sized down from 0.5 ETH")
+
+	// 1,000 USDC into 1M moves it 0.1%
+	usdc := suggestions["USDC"]
+	assert.Equal(t, 1000.0, usdc.Amount)
+	assert.Equal(t, 10101.0101, usdc.MaxSizeAt1PctImpact)
+
+	// No pool trades DAI
+	assert.Equal(t, 500.0, suggestions["DAI"].Amount)
+	assert.Zero(t, suggestions["DAI"].MaxSizeAt1PctImpact)
+}
+
+func TestChatSwapProposalOffersSplitPlan(t *testing.T) {
+	engine := newTestChatEngine(t)
+	engine.SetPoolDepths(NewPoolDepthReader(newTestPoolReader(newFiftyFiftyPair(), nil), []common.Address{lpPair}))
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: "0xuser", Message: "swap 20 ETH"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Response, "Large Swap")
+	assert.Contains(t, response.Response, "8 swaps of 2.5 ETH")
+	plan, ok := response.Metadata["split_plan"].(*SwapSplitPlan)
+	require.True(t, ok)
+	assert.Equal(t, 8, plan.Tranches)
+	assert.Equal(t, DefaultSlippage, plan.Slippage)
+
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m2", UserID: "0xuser", Message: "swap 2 ETH"})
+	require.NoError(t, err)
+	assert.NotContains(t, response.Response, "Large Swap")
+	assert.NotContains(t, response.Metadata, "split_plan")
+}
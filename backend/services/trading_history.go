@@ -0,0 +1,649 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// DefaultSwapHistoryMaxBlocks bounds how many blocks of Swap logs a
+	// trading profile replays, about 30 days of one second blocks
+	DefaultSwapHistoryMaxBlocks = 2_592_000
+	// TradingProfileTTL is how long a computed trading profile is served
+	// before the next request recomputes it
+	TradingProfileTTL = time.Hour
+
+	// DipThreshold is how far below the recent high a buy must be to count
+	// as buying a dip
+	DipThreshold = 0.10
+	// DipLookback is how far back the recent high of a dip is looked for
+	DipLookback = 30 * 24 * time.Hour
+
+	swapHistoryChunk = 10_000
+	// preferredPairCount is how many pairs a profile lists as preferred
+	preferredPairCount = 3
+	// dustAmount is the lot remainder below which a position counts as closed
+	dustAmount = 1e-9
+)
+
+// swapTopic is the signature topic of Uniswap V2 style Swap events
+var swapTopic = crypto.Keccak256Hash([]byte("Swap(address,uint256,uint256,uint256,uint256,address)"))
+
+// quoteAssets are valued at par and treated as cash, so trading into one is
+// a sell and out of one is a buy
+var quoteAssets = map[string]bool{
+	"USDT": true,
+	"USDC": true,
+	"DAI":  true,
+}
+
+// SwapTrade is a DEX swap whose output an address received
+type SwapTrade struct {
+	Pool      string    `json:"pool"`
+	Pair      string    `json:"pair"`
+	TokenIn   string    `json:"token_in"`
+	AmountIn  float64   `json:"amount_in"`
+	TokenOut  string    `json:"token_out"`
+	AmountOut float64   `json:"amount_out"`
+	ValueUSD  float64   `json:"value_usd"`
+	Block     uint64    `json:"block"`
+	TxHash    string    `json:"tx_hash"`
+	Time      time.Time `json:"time"`
+	// PriceSample is the stored price sample ValueUSD came from, unless the
+	// trade is valued by its quote asset leg
+	PriceSample *PriceSample `json:"price_sample,omitempty"`
+}
+
+// TokenTradeStats is an address's trading record in one token. Positions
+// are matched first in, first out; sells of amounts bought before the
+// replayed history have no cost basis and realize nothing.
+type TokenTradeStats struct {
+	Token string `json:"token"`
+	Buys  int    `json:"buys"`
+	Sells int    `json:"sells"`
+	// RealizedPnLUSD is the proceeds of sells minus the cost of the buys they closed
+	RealizedPnLUSD float64 `json:"realized_pnl_usd"`
+	// ClosedPositions counts buys that were sold in full
+	ClosedPositions     int     `json:"closed_positions"`
+	ProfitablePositions int     `json:"profitable_positions"`
+	WinRate             float64 `json:"win_rate"`
+	// ReturnRate is the realized PnL of closed positions over their cost
+	ReturnRate       float64 `json:"return_rate"`
+	AverageHoldHours float64 `json:"average_hold_hours"`
+	// DipBuys counts buys at least DipThreshold below the recent high
+	DipBuys           int     `json:"dip_buys"`
+	ClosedDipBuys     int     `json:"closed_dip_buys"`
+	ProfitableDipBuys int     `json:"profitable_dip_buys"`
+	AverageDipBuy     float64 `json:"average_dip_buy"`
+	// OpenAmount is what is left of the buys in the history
+	OpenAmount float64 `json:"open_amount"`
+	// OpenSince is when the oldest open buy was made
+	OpenSince *time.Time `json:"open_since,omitempty"`
+}
+
+// PairPreference is how often an address traded a pair
+type PairPreference struct {
+	Pair   string `json:"pair"`
+	Trades int    `json:"trades"`
+	// AverageAmount is the average amount of the pair's traded asset per swap
+	AverageAmount float64 `json:"average_amount"`
+	Asset         string  `json:"asset"`
+}
+
+// TradingProfile summarizes an address's DEX trading behavior
+type TradingProfile struct {
+	Address          string            `json:"address"`
+	Trades           int               `json:"trades"`
+	RealizedPnLUSD   float64           `json:"realized_pnl_usd"`
+	WinRate          float64           `json:"win_rate"`
+	AverageHoldHours float64           `json:"average_hold_hours"`
+	Tokens           []TokenTradeStats `json:"tokens"`
+	PreferredPairs   []PairPreference  `json:"preferred_pairs"`
+	ComputedAt       time.Time         `json:"computed_at"`
+}
+
+// Token returns the profile's record in a token
+func (p *TradingProfile) Token(symbol string) (TokenTradeStats, bool) {
+	for _, stats := range p.Tokens {
+		if strings.EqualFold(stats.Token, symbol) {
+			return stats, true
+		}
+	}
+	return TokenTradeStats{}, false
+}
+
+// SwapHistoryReader reads the DEX swaps of an address, oldest first
+type SwapHistoryReader interface {
+	Swaps(ctx context.Context, address common.Address) ([]SwapTrade, error)
+}
+
+// ChainSwapHistory reads swaps from the Swap logs of DEX pairs whose output
+// went to the address. Routers pass the output of the last hop straight to
+// the trader, so both router and direct swaps are found.
+type ChainSwapHistory struct {
+	client    ChainClient
+	pools     *LiquidityPoolReader
+	prices    HistoricalPriceSource
+	pairs     []common.Address
+	maxBlocks uint64
+	decoder   *ABIEventDecoder
+}
+
+// NewChainSwapHistory creates a reader of the Swap logs of the pairs over the
+// last maxBlocks blocks
+func NewChainSwapHistory(client ChainClient, pools *LiquidityPoolReader, prices HistoricalPriceSource, pairs []common.Address, maxBlocks int) *ChainSwapHistory {
+	if maxBlocks <= 0 {
+		maxBlocks = DefaultSwapHistoryMaxBlocks
+	}
+	return &ChainSwapHistory{
+		client:    client,
+		pools:     pools,
+		prices:    prices,
+		pairs:     pairs,
+		maxBlocks: uint64(maxBlocks),
+		decoder:   NewPairSwapDecoder(),
+	}
+}
+
+// Swaps implements SwapHistoryReader
+func (h *ChainSwapHistory) Swaps(ctx context.Context, address common.Address) ([]SwapTrade, error) {
+	if len(h.pairs) == 0 {
+		return nil, nil
+	}
+	head, err := h.client.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head block: %w", err)
+	}
+	from := uint64(0)
+	if head+1 > h.maxBlocks {
+		from = head - h.maxBlocks + 1
+	}
+
+	var trades []SwapTrade
+	blockTimes := make(map[uint64]time.Time)
+	recipient := common.BytesToHash(address.Bytes())
+	for start := from; start <= head; start += swapHistoryChunk {
+		end := min(start+swapHistoryChunk-1, head)
+		logs, err := h.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(start),
+			ToBlock:   new(big.Int).SetUint64(end),
+			Addresses: h.pairs,
+			Topics:    [][]common.Hash{{swapTopic}, nil, {recipient}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to filter swaps from block %d: %w", start, err)
+		}
+
+		for _, log := range logs {
+			_, event, err := h.decoder.Decode(log)
+			if err != nil {
+				continue
+			}
+			pool, err := h.pools.Pool(ctx, log.Address)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read pair %s: %w", log.Address.Hex(), err)
+			}
+			trade, ok := h.trade(pool, event.(PairSwap))
+			if !ok {
+				continue
+			}
+
+			blockTime, ok := blockTimes[log.BlockNumber]
+			if !ok {
+				header, err := h.client.HeaderByNumber(ctx, new(big.Int).SetUint64(log.BlockNumber))
+				if err != nil {
+					return nil, fmt.Errorf("failed to get block %d: %w", log.BlockNumber, err)
+				}
+				blockTime = time.Unix(int64(header.Time), 0).UTC()
+				blockTimes[log.BlockNumber] = blockTime
+			}
+			trade.Block = log.BlockNumber
+			trade.TxHash = log.TxHash.Hex()
+			trade.Time = blockTime
+			trade.ValueUSD, trade.PriceSample = h.valueUSD(ctx, trade)
+			trades = append(trades, trade)
+		}
+	}
+	return trades, nil
+}
+
+// ParseDEXPairs parses pair contract addresses separated by commas
+func ParseDEXPairs(spec string) ([]common.Address, error) {
+	var pairs []common.Address
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !common.IsHexAddress(entry) {
+			return nil, fmt.Errorf("invalid pair address %q", entry)
+		}
+		pairs = append(pairs, common.HexToAddress(entry))
+	}
+	return pairs, nil
+}
+
+// trade converts a Swap event into the trade it settled. Swaps with both or
+// neither leg flowing in are flash swaps or liquidity moves, not trades.
+func (h *ChainSwapHistory) trade(pool *LiquidityPool, swap PairSwap) (SwapTrade, bool) {
+	amount0In := weiToFloat(swap.Amount0In, pool.Token0.Decimals)
+	amount1In := weiToFloat(swap.Amount1In, pool.Token1.Decimals)
+	amount0Out := weiToFloat(swap.Amount0Out, pool.Token0.Decimals)
+	amount1Out := weiToFloat(swap.Amount1Out, pool.Token1.Decimals)
+
+	trade := SwapTrade{Pool: pool.Address.Hex(), Pair: pool.Pair()}
+	switch {
+	case amount0In > 0 && amount1Out > 0 && amount1In == 0:
+		trade.TokenIn, trade.AmountIn = pool.Token0.Symbol, amount0In
+		trade.TokenOut, trade.AmountOut = pool.Token1.Symbol, amount1Out
+	case amount1In > 0 && amount0Out > 0 && amount0In == 0:
+		trade.TokenIn, trade.AmountIn = pool.Token1.Symbol, amount1In
+		trade.TokenOut, trade.AmountOut = pool.Token0.Symbol, amount0Out
+	default:
+		return SwapTrade{}, false
+	}
+	return trade, true
+}
+
+// valueUSD values a trade by its quote asset leg, which holds its value over
+// time. Other trades are valued at the input token's price when they were
+// made, or the output token's, returning the sample used.
+func (h *ChainSwapHistory) valueUSD(ctx context.Context, trade SwapTrade) (float64, *PriceSample) {
+	switch {
+	case quoteAssets[strings.ToUpper(trade.TokenIn)]:
+		return trade.AmountIn, nil
+	case quoteAssets[strings.ToUpper(trade.TokenOut)]:
+		return trade.AmountOut, nil
+	}
+	if h.prices == nil {
+		return 0, nil
+	}
+	if sample, err := samplePrice(ctx, h.prices, trade.TokenIn, trade.Time); err == nil {
+		return trade.AmountIn * sample.PriceUSD, &sample
+	}
+	if sample, err := samplePrice(ctx, h.prices, trade.TokenOut, trade.Time); err == nil {
+		return trade.AmountOut * sample.PriceUSD, &sample
+	}
+	return 0, nil
+}
+
+// TradingProfiles computes trading profiles from swap history. Profiles are
+// recomputed lazily once they are older than TradingProfileTTL.
+type TradingProfiles struct {
+	history SwapHistoryReader
+	logger  *log.Logger
+	mu      sync.Mutex
+	cache   map[string]*TradingProfile
+	now     func() time.Time
+}
+
+// NewTradingProfiles creates a profile store reading swaps from history
+func NewTradingProfiles(history SwapHistoryReader) *TradingProfiles {
+	return &TradingProfiles{
+		history: history,
+		logger:  log.New(log.Writer(), "[TradingProfiles] ", log.LstdFlags),
+		cache:   make(map[string]*TradingProfile),
+		now:     utcNow,
+	}
+}
+
+// Profile returns the trading profile of an address, replaying its swaps
+// when there is no fresh one
+func (tp *TradingProfiles) Profile(ctx context.Context, address string) (*TradingProfile, error) {
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	key := strings.ToLower(common.HexToAddress(address).Hex())
+	now := tp.now()
+
+	tp.mu.Lock()
+	cached, ok := tp.cache[key]
+	tp.mu.Unlock()
+	if ok && now.Sub(cached.ComputedAt) < TradingProfileTTL {
+		return cached, nil
+	}
+
+	trades, err := tp.history.Swaps(ctx, common.HexToAddress(address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swap history: %w", err)
+	}
+	profile := ComputeTradingProfile(common.HexToAddress(address).Hex(), trades, now)
+
+	tp.mu.Lock()
+	tp.cache[key] = &profile
+	tp.mu.Unlock()
+	return &profile, nil
+}
+
+// Invalidate drops the cached profile of an address
+func (tp *TradingProfiles) Invalidate(address string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	delete(tp.cache, strings.ToLower(common.HexToAddress(address).Hex()))
+}
+
+// tradeLot is an open buy of a token
+type tradeLot struct {
+	amount    float64
+	unitCost  float64
+	cost      float64
+	time      time.Time
+	dip       bool
+	pnl       float64
+	remaining float64
+}
+
+// tokenLedger replays an address's trades in one token
+type tokenLedger struct {
+	stats      TokenTradeStats
+	lots       []*tradeLot
+	highs      []pricePoint
+	closedCost float64
+	holdHours  float64
+	heldAmount float64
+	dipAmount  float64
+}
+
+// pricePoint is the unit price a token traded at
+type pricePoint struct {
+	price float64
+	time  time.Time
+}
+
+// recentHigh is the highest price the token traded at within DipLookback
+func (l *tokenLedger) recentHigh(at time.Time) float64 {
+	high := 0.0
+	for _, point := range l.highs {
+		if at.Sub(point.time) <= DipLookback && point.price > high {
+			high = point.price
+		}
+	}
+	return high
+}
+
+// buy opens a lot, marking it a dip buy when it is at least DipThreshold
+// below the recent high
+func (l *tokenLedger) buy(amount, valueUSD float64, at time.Time) {
+	l.stats.Buys++
+	if amount <= 0 {
+		return
+	}
+	price := valueUSD / amount
+	high := l.recentHigh(at)
+	dip := high > 0 && price > 0 && price <= high*(1-DipThreshold)
+	if dip {
+		l.stats.DipBuys++
+		l.dipAmount += amount
+	}
+	l.highs = append(l.highs, pricePoint{price: price, time: at})
+	l.lots = append(l.lots, &tradeLot{amount: amount, unitCost: price, cost: valueUSD, time: at, dip: dip, remaining: amount})
+}
+
+// sell closes lots first in, first out and realizes their PnL
+func (l *tokenLedger) sell(amount, valueUSD float64, at time.Time) {
+	l.stats.Sells++
+	if amount <= 0 {
+		return
+	}
+	price := valueUSD / amount
+	l.highs = append(l.highs, pricePoint{price: price, time: at})
+
+	for amount > dustAmount && len(l.lots) > 0 {
+		lot := l.lots[0]
+		matched := math.Min(amount, lot.remaining)
+		pnl := matched * (price - lot.unitCost)
+		lot.pnl += pnl
+		lot.remaining -= matched
+		amount -= matched
+		l.stats.RealizedPnLUSD += pnl
+		l.holdHours += matched * at.Sub(lot.time).Hours()
+		l.heldAmount += matched
+
+		if lot.remaining > dustAmount*lot.amount {
+			break
+		}
+		l.lots = l.lots[1:]
+		l.closedCost += lot.cost
+		l.stats.ClosedPositions++
+		if lot.pnl > 0 {
+			l.stats.ProfitablePositions++
+		}
+		if lot.dip {
+			l.stats.ClosedDipBuys++
+			if lot.pnl > 0 {
+				l.stats.ProfitableDipBuys++
+			}
+		}
+	}
+}
+
+// finish computes the ratios of the replayed record
+func (l *tokenLedger) finish() TokenTradeStats {
+	stats := l.stats
+	if stats.ClosedPositions > 0 {
+		stats.WinRate = float64(stats.ProfitablePositions) / float64(stats.ClosedPositions)
+	}
+	if l.closedCost > 0 {
+		stats.ReturnRate = stats.RealizedPnLUSD / l.closedCost
+	}
+	if l.heldAmount > 0 {
+		stats.AverageHoldHours = l.holdHours / l.heldAmount
+	}
+	if stats.DipBuys > 0 {
+		stats.AverageDipBuy = l.dipAmount / float64(stats.DipBuys)
+	}
+	for _, lot := range l.lots {
+		stats.OpenAmount += lot.remaining
+	}
+	if len(l.lots) > 0 {
+		since := l.lots[0].time
+		stats.OpenSince = &since
+	}
+	return stats
+}
+
+// ComputeTradingProfile replays trades into a trading profile. Quote assets
+// are cash: swapping one into a token is a buy of it and swapping a token
+// into one a sell. A swap between two tokens sells one and buys the other.
+func ComputeTradingProfile(address string, trades []SwapTrade, now time.Time) TradingProfile {
+	sorted := append([]SwapTrade(nil), trades...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	ledgers := make(map[string]*tokenLedger)
+	ledger := func(token string) *tokenLedger {
+		token = strings.ToUpper(token)
+		if ledgers[token] == nil {
+			ledgers[token] = &tokenLedger{stats: TokenTradeStats{Token: token}}
+		}
+		return ledgers[token]
+	}
+
+	type pairCount struct {
+		trades int
+		amount float64
+		asset  string
+	}
+	pairs := make(map[string]*pairCount)
+	for _, trade := range sorted {
+		if !quoteAssets[strings.ToUpper(trade.TokenIn)] {
+			ledger(trade.TokenIn).sell(trade.AmountIn, trade.ValueUSD, trade.Time)
+		}
+		if !quoteAssets[strings.ToUpper(trade.TokenOut)] {
+			ledger(trade.TokenOut).buy(trade.AmountOut, trade.ValueUSD, trade.Time)
+		}
+
+		count := pairs[trade.Pair]
+		if count == nil {
+			count = &pairCount{}
+			pairs[trade.Pair] = count
+		}
+		count.trades++
+		// The traded asset is the pair's non-quote leg, the output if both are tokens
+		switch {
+		case !quoteAssets[strings.ToUpper(trade.TokenOut)]:
+			count.asset = strings.ToUpper(trade.TokenOut)
+			count.amount += trade.AmountOut
+		default:
+			count.asset = strings.ToUpper(trade.TokenIn)
+			count.amount += trade.AmountIn
+		}
+	}
+
+	profile := TradingProfile{
+		Address:    address,
+		Trades:     len(sorted),
+		Tokens:     []TokenTradeStats{},
+		ComputedAt: now,
+	}
+	closed, profitable := 0, 0
+	holdHours, heldAmount := 0.0, 0.0
+	for _, ledger := range ledgers {
+		stats := ledger.finish()
+		profile.Tokens = append(profile.Tokens, stats)
+		profile.RealizedPnLUSD += stats.RealizedPnLUSD
+		closed += stats.ClosedPositions
+		profitable += stats.ProfitablePositions
+		holdHours += ledger.holdHours
+		heldAmount += ledger.heldAmount
+	}
+	if closed > 0 {
+		profile.WinRate = float64(profitable) / float64(closed)
+	}
+	if heldAmount > 0 {
+		profile.AverageHoldHours = holdHours / heldAmount
+	}
+	sort.Slice(profile.Tokens, func(i, j int) bool {
+		if profile.Tokens[i].Buys+profile.Tokens[i].Sells != profile.Tokens[j].Buys+profile.Tokens[j].Sells {
+			return profile.Tokens[i].Buys+profile.Tokens[i].Sells > profile.Tokens[j].Buys+profile.Tokens[j].Sells
+		}
+		return profile.Tokens[i].Token < profile.Tokens[j].Token
+	})
+
+	for pair, count := range pairs {
+		profile.PreferredPairs = append(profile.PreferredPairs, PairPreference{
+			Pair:          pair,
+			Trades:        count.trades,
+			AverageAmount: count.amount / float64(count.trades),
+			Asset:         count.asset,
+		})
+	}
+	sort.Slice(profile.PreferredPairs, func(i, j int) bool {
+		if profile.PreferredPairs[i].Trades != profile.PreferredPairs[j].Trades {
+			return profile.PreferredPairs[i].Trades > profile.PreferredPairs[j].Trades
+		}
+		return profile.PreferredPairs[i].Pair < profile.PreferredPairs[j].Pair
+	})
+	if len(profile.PreferredPairs) > preferredPairCount {
+		profile.PreferredPairs = profile.PreferredPairs[:preferredPairCount]
+	}
+	return profile
+}
+
+// profileSuggestions builds suggestions from an address's trading record:
+// buying the dips of the token whose dip buys paid off, taking profit on
+// positions held longer than usual, and trading the favorite pair
+func profileSuggestions(profile *TradingProfile, now time.Time) []TradingSuggestion {
+	var suggestions []TradingSuggestion
+
+	// The token with the most closed dip buys, if most of them were profitable
+	var dipToken *TokenTradeStats
+	for i := range profile.Tokens {
+		stats := &profile.Tokens[i]
+		if stats.ClosedDipBuys < 2 || stats.ProfitableDipBuys*2 < stats.ClosedDipBuys {
+			continue
+		}
+		if dipToken == nil || stats.ClosedDipBuys > dipToken.ClosedDipBuys {
+			dipToken = stats
+		}
+	}
+	if dipToken != nil {
+		dipWinRate := float64(dipToken.ProfitableDipBuys) / float64(dipToken.ClosedDipBuys)
+		reasoning := fmt.Sprintf("You bought %s %d times on >%.0f%% dips, %d %s profitable",
+			dipToken.Token, dipToken.DipBuys, DipThreshold*100, dipToken.ProfitableDipBuys, pluralVerb(dipToken.ProfitableDipBuys))
+		if open := dipToken.DipBuys - dipToken.ClosedDipBuys; open > 0 {
+			reasoning += fmt.Sprintf(" (%d still open)", open)
+		}
+		reasoning += fmt.Sprintf(". Your closed %s trades returned %.1f%% overall, so buying the next dip fits your pattern.",
+			dipToken.Token, dipToken.ReturnRate*100)
+		suggestions = append(suggestions, TradingSuggestion{
+			Type:           "buy",
+			Asset:          dipToken.Token,
+			Amount:         roundTo(dipToken.AverageDipBuy, 4),
+			Confidence:     roundTo(0.5+0.4*dipWinRate, 2),
+			Reasoning:      reasoning,
+			RiskLevel:      RiskMedium,
+			ExpectedReturn: roundTo(math.Max(dipToken.ReturnRate, 0), 4),
+		})
+	}
+
+	// Open positions held well past the usual holding time of the token
+	for _, stats := range profile.Tokens {
+		if stats.OpenSince == nil || stats.OpenAmount <= dustAmount || stats.ClosedPositions == 0 || stats.AverageHoldHours <= 0 {
+			continue
+		}
+		held := now.Sub(*stats.OpenSince).Hours()
+		if held < 1.5*stats.AverageHoldHours {
+			continue
+		}
+		suggestions = append(suggestions, TradingSuggestion{
+			Type:       "sell",
+			Asset:      stats.Token,
+			Amount:     roundTo(stats.OpenAmount, 4),
+			Confidence: roundTo(0.4+0.4*stats.WinRate, 2),
+			Reasoning: fmt.Sprintf("You usually sell %s after %s and %d of your %d closed %s trades were profitable. Your open %s has been held for %s; consider taking profit.",
+				stats.Token, formatHoldTime(stats.AverageHoldHours), stats.ProfitablePositions, stats.ClosedPositions, stats.Token,
+				stats.Token, formatHoldTime(held)),
+			RiskLevel:      RiskLow,
+			ExpectedReturn: roundTo(math.Max(stats.ReturnRate, 0), 4),
+		})
+	}
+
+	if len(profile.PreferredPairs) > 0 && profile.PreferredPairs[0].Trades >= 2 {
+		pair := profile.PreferredPairs[0]
+		suggestions = append(suggestions, TradingSuggestion{
+			Type:       "swap",
+			Asset:      pair.Asset,
+			Amount:     roundTo(pair.AverageAmount, 4),
+			Confidence: roundTo(0.4+0.4*profile.WinRate, 2),
+			Reasoning: fmt.Sprintf("%s is your most traded pair (%d of your %d swaps), typically %s %s per trade. Across all trades you won %s of closed positions with a realized PnL of $%s.",
+				pair.Pair, pair.Trades, profile.Trades, NumberFormat{}.Token(pair.AverageAmount, 0), pair.Asset, NumberFormat{}.Percent(profile.WinRate*100, 0), NumberFormat{}.Decimal(profile.RealizedPnLUSD, 2)),
+			RiskLevel:      RiskLow,
+			ExpectedReturn: 0,
+		})
+	}
+	return suggestions
+}
+
+// pluralVerb is "was" for one and "were" otherwise
+func pluralVerb(n int) string {
+	if n == 1 {
+		return "was"
+	}
+	return "were"
+}
+
+// formatHoldTime formats hours as hours below two days and days otherwise
+func formatHoldTime(hours float64) string {
+	if hours < 48 {
+		return fmt.Sprintf("%.0f hours", hours)
+	}
+	return fmt.Sprintf("%.1f days", hours/24)
+}
+
+// roundTo rounds to the given number of decimal places
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	dipTrader  = "0x00000000000000000000000000000000000000b1"
+	pairTrader = "0x00000000000000000000000000000000000000b2"
+	swapRouter = common.HexToAddress("0x00000000000000000000000000000000000000b3")
+)
+
+// tradingStart is when the synthetic swap histories begin
+var tradingStart = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeSwapHistory serves synthetic swaps by address and counts the reads
+type fakeSwapHistory struct {
+	trades map[common.Address][]SwapTrade
+	reads  int
+}
+
+func (f *fakeSwapHistory) Swaps(ctx context.Context, address common.Address) ([]SwapTrade, error) {
+	f.reads++
+	return f.trades[address], nil
+}
+
+// quoteSwap is a swap of USDT for a token at a USD price on a day of the history
+func quoteSwap(token string, amount, price float64, day int, buy bool) SwapTrade {
+	trade := SwapTrade{
+		Pair:     token + "/USDT",
+		ValueUSD: amount * price,
+		Time:     tradingStart.Add(time.Duration(day) * 24 * time.Hour),
+	}
+	if buy {
+		trade.TokenIn, trade.AmountIn = "USDT", amount*price
+		trade.TokenOut, trade.AmountOut = token, amount
+	} else {
+		trade.TokenIn, trade.AmountIn = token, amount
+		trade.TokenOut, trade.AmountOut = "USDT", amount*price
+	}
+	return trade
+}
+
+// dipBuyerHistory buys KAIA on four dips, three of which are sold, two at a
+// profit, and loses on a single ETH trade
+func dipBuyerHistory() []SwapTrade {
+	return []SwapTrade{
+		quoteSwap("KAIA", 100, 0.20, 0, true),
+		quoteSwap("KAIA", 100, 0.17, 1, true), // dip
+		quoteSwap("KAIA", 200, 0.22, 3, false),
+		quoteSwap("KAIA", 100, 0.19, 5, true), // dip
+		quoteSwap("KAIA", 100, 0.18, 7, false),
+		quoteSwap("KAIA", 100, 0.15, 8, true), // dip
+		quoteSwap("KAIA", 100, 0.20, 10, false),
+		quoteSwap("KAIA", 100, 0.16, 11, true), // dip, still open
+		quoteSwap("ETH", 1, 2000, 2, true),
+		quoteSwap("ETH", 1, 1800, 4, false),
+	}
+}
+
+func TestComputeTradingProfile(t *testing.T) {
+	history := dipBuyerHistory()
+	// Replay order doesn't depend on the order the swaps were read in
+	history[0], history[9] = history[9], history[0]
+
+	profile := ComputeTradingProfile(dipTrader, history, tradingStart.Add(12*24*time.Hour))
+	assert.Equal(t, 10, profile.Trades)
+	assert.InDelta(t, 0.6, profile.WinRate, 1e-9, "3 of 5 closed positions were profitable")
+	assert.InDelta(t, 11-200, profile.RealizedPnLUSD, 1e-6)
+
+	kaia, ok := profile.Token("kaia")
+	require.True(t, ok)
+	assert.Equal(t, 5, kaia.Buys)
+	assert.Equal(t, 3, kaia.Sells)
+	assert.Equal(t, 4, kaia.ClosedPositions)
+	assert.InDelta(t, 0.75, kaia.WinRate, 1e-9)
+	assert.InDelta(t, 11, kaia.RealizedPnLUSD, 1e-6)
+	assert.InDelta(t, 54, kaia.AverageHoldHours, 1e-6)
+	assert.Equal(t, 4, kaia.DipBuys)
+	assert.Equal(t, 3, kaia.ClosedDipBuys)
+	assert.Equal(t, 2, kaia.ProfitableDipBuys)
+	assert.InDelta(t, 100, kaia.OpenAmount, 1e-9)
+	require.NotNil(t, kaia.OpenSince)
+	assert.Equal(t, tradingStart.Add(11*24*time.Hour), *kaia.OpenSince)
+
+	eth, ok := profile.Token("ETH")
+	require.True(t, ok)
+	assert.Zero(t, eth.WinRate)
+	assert.Zero(t, eth.DipBuys)
+
+	_, ok = profile.Token("USDT")
+	assert.False(t, ok, "quote assets are cash, not positions")
+
+	require.Len(t, profile.PreferredPairs, 2)
+	assert.Equal(t, PairPreference{Pair: "KAIA/USDT", Trades: 8, AverageAmount: 112.5, Asset: "KAIA"}, profile.PreferredPairs[0])
+}
+
+func TestTradingSuggestionsFollowProfile(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { engine.Close() })
+
+	history := &fakeSwapHistory{trades: map[common.Address][]SwapTrade{
+		common.HexToAddress(dipTrader): dipBuyerHistory(),
+		common.HexToAddress(pairTrader): {
+			quoteSwap("ETH", 1, 2000, 0, true),
+			quoteSwap("ETH", 1, 2200, 1, false),
+			quoteSwap("ETH", 2, 2100, 2, true),
+		},
+	}}
+	profiles := NewTradingProfiles(history)
+	profiles.now = func() time.Time { return tradingStart.Add(20 * 24 * time.Hour) }
+	engine.SetTradingProfiles(profiles)
+
+	suggest := func(address string) []TradingSuggestion {
+		result, err := engine.ProcessAnalyticsTask(context.Background(), "trading_suggestions", map[string]interface{}{"user_address": address})
+		require.NoError(t, err)
+		return result.Data.([]TradingSuggestion)
+	}
+
+	dips := suggest(dipTrader)
+	require.NotEmpty(t, dips)
+	assert.Equal(t, "buy", dips[0].Type)
+	assert.Equal(t, "KAIA", dips[0].Asset)
+	assert.Contains(t, dips[0].Reasoning, "You bought KAIA 4 times on >10% dips, 2 were profitable (1 still open)")
+	assert.InDelta(t, 100, dips[0].Amount, 1e-9)
+
+	pairs := suggest(pairTrader)
+	require.NotEmpty(t, pairs)
+	assert.NotEqual(t, dips, pairs)
+	for _, suggestion := range pairs {
+		assert.Equal(t, "ETH", suggestion.Asset)
+		assert.NotContains(t, suggestion.Reasoning, "dips")
+	}
+	assert.Equal(t, "sell", pairs[0].Type, "the open ETH has been held far longer than the usual day")
+	assert.Contains(t, pairs[0].Reasoning, "1 of your 1 closed ETH trades were profitable")
+
+	// Without swap history the generic market suggestions are made
+	generic := suggest(preferencesUser)
+	require.Len(t, generic, 3)
+	assert.Equal(t, "DAI", generic[0].Asset)
+}
+
+func TestTradingProfilesRecomputeLazily(t *testing.T) {
+	history := &fakeSwapHistory{trades: map[common.Address][]SwapTrade{
+		common.HexToAddress(dipTrader): dipBuyerHistory(),
+	}}
+	profiles := NewTradingProfiles(history)
+	now := tradingStart
+	profiles.now = func() time.Time { return now }
+
+	profile, err := profiles.Profile(context.Background(), dipTrader)
+	require.NoError(t, err)
+	assert.Equal(t, now, profile.ComputedAt)
+
+	now = now.Add(TradingProfileTTL / 2)
+	_, err = profiles.Profile(context.Background(), "0x00000000000000000000000000000000000000B1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, history.reads, "a fresh profile is served from the cache")
+
+	now = now.Add(TradingProfileTTL)
+	profile, err = profiles.Profile(context.Background(), dipTrader)
+	require.NoError(t, err)
+	assert.Equal(t, 2, history.reads)
+	assert.Equal(t, now, profile.ComputedAt)
+
+	profiles.Invalidate(dipTrader)
+	_, err = profiles.Profile(context.Background(), dipTrader)
+	require.NoError(t, err)
+	assert.Equal(t, 3, history.reads)
+
+	_, err = profiles.Profile(context.Background(), "not-an-address")
+	assert.Error(t, err)
+}
+
+// swapChain serves the Swap logs and reads of the fakePair pool
+type swapChain struct {
+	ChainClient
+
+	pair *fakePair
+	head uint64
+	logs []types.Log
+}
+
+func (sc *swapChain) BlockNumber(ctx context.Context) (uint64, error) {
+	return sc.head, nil
+}
+
+func (sc *swapChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return sc.pair.CallContract(ctx, call, blockNumber)
+}
+
+func (sc *swapChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: number, Time: uint64(tradingStart.Unix()) + number.Uint64()*3600}, nil
+}
+
+func (sc *swapChain) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	for _, log := range sc.logs {
+		if log.BlockNumber < query.FromBlock.Uint64() || log.BlockNumber > query.ToBlock.Uint64() {
+			continue
+		}
+		if len(query.Topics) > 2 && len(query.Topics[2]) > 0 && log.Topics[2] != query.Topics[2][0] {
+			continue
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// swap appends a Swap log of the pair at a block
+func (sc *swapChain) swap(t *testing.T, block uint64, to common.Address, amount0In, amount1In, amount0Out, amount1Out *big.Int) {
+	parsed, err := abi.JSON(strings.NewReader(uniswapV2PairEventsABI))
+	require.NoError(t, err)
+	data, err := parsed.Events["Swap"].Inputs.NonIndexed().Pack(amount0In, amount1In, amount0Out, amount1Out)
+	require.NoError(t, err)
+	sc.logs = append(sc.logs, types.Log{
+		Address:     lpPair,
+		BlockNumber: block,
+		Topics:      []common.Hash{swapTopic, common.BytesToHash(swapRouter.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:        data,
+	})
+}
+
+func TestChainSwapHistoryReadsSwaps(t *testing.T) {
+	chain := &swapChain{pair: newFiftyFiftyPair(), head: 50}
+	trader := common.HexToAddress(pairTrader)
+	zero := big.NewInt(0)
+	// Buy 1 WETH for 2,000 USDC, then sell it for 2,100
+	chain.swap(t, 10, trader, units(2000, 6), zero, zero, units(1, 18))
+	chain.swap(t, 20, trader, zero, units(1, 18), units(2100, 6), zero)
+	// Someone else's swap
+	chain.swap(t, 30, common.HexToAddress(dipTrader), units(500, 6), zero, zero, units(1, 17))
+
+	pools := newTestPoolReader(chain.pair, fakePrices{})
+	history := NewChainSwapHistory(chain, pools, fakePrices{}, []common.Address{lpPair}, 0)
+	trades, err := history.Swaps(context.Background(), trader)
+	require.NoError(t, err)
+	require.Len(t, trades, 2)
+
+	assert.Equal(t, "USDC/WETH", trades[0].Pair)
+	assert.Equal(t, "USDC", trades[0].TokenIn)
+	assert.Equal(t, "WETH", trades[0].TokenOut)
+	assert.InDelta(t, 1, trades[0].AmountOut, 1e-9)
+	assert.InDelta(t, 2000, trades[0].ValueUSD, 1e-9)
+	assert.Equal(t, tradingStart.Add(10*time.Hour), trades[0].Time)
+	assert.InDelta(t, 2100, trades[1].ValueUSD, 1e-9)
+
+	profile := ComputeTradingProfile(pairTrader, trades, tradingStart.Add(time.Hour*50))
+	assert.InDelta(t, 1, profile.WinRate, 1e-9)
+	assert.InDelta(t, 100, profile.RealizedPnLUSD, 1e-6)
+	assert.InDelta(t, 10, profile.AverageHoldHours, 1e-9)
+}
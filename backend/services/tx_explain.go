@@ -0,0 +1,965 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Kinds a transaction is classified as
+const (
+	TxKindTransfer     = "transfer"
+	TxKindSwap         = "swap"
+	TxKindApproval     = "approval"
+	TxKindStake        = "stake"
+	TxKindDeploy       = "contract_deploy"
+	TxKindContractCall = "contract_call"
+	TxKindBridgeIn     = BridgeDirectionIn
+	TxKindBridgeOut    = BridgeDirectionOut
+)
+
+// Statuses of an explained transaction
+const (
+	TxStatusSuccess = "success"
+	TxStatusFailed  = "failed"
+	TxStatusPending = "pending"
+)
+
+// Bounds of amounts taken from a transaction's slippage limits rather than
+// what it actually moved
+const (
+	AmountBoundMin = "min"
+	AmountBoundMax = "max"
+)
+
+// kaiaNetworks names the Kaia networks by chain ID
+var kaiaNetworks = map[int64]string{
+	8217: "Kaia Mainnet",
+	1001: "Kairos testnet",
+}
+
+// otherKaiaNetwork is where a hash missing from one network may have been sent
+var otherKaiaNetwork = map[int64]int64{
+	8217: 1001,
+	1001: 8217,
+}
+
+// unlimitedAllowance is the allowance from which an approval is described as
+// unlimited. Wallets approve the maximum uint256 for that.
+var unlimitedAllowance = new(big.Int).Lsh(big.NewInt(1), 255)
+
+// knownMethodsABI holds the methods transactions are decoded against: ERC-20
+// transfers and approvals, Uniswap V2 style router swaps, and staking
+const knownMethodsABI = `[
+	{"type":"function","name":"transfer","inputs":[
+		{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"transferFrom","inputs":[
+		{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"approve","inputs":[
+		{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"swapExactTokensForTokens","inputs":[
+		{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},
+		{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}]},
+	{"type":"function","name":"swapTokensForExactTokens","inputs":[
+		{"name":"amountOut","type":"uint256"},{"name":"amountInMax","type":"uint256"},
+		{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}]},
+	{"type":"function","name":"swapExactETHForTokens","stateMutability":"payable","inputs":[
+		{"name":"amountOutMin","type":"uint256"},
+		{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}]},
+	{"type":"function","name":"swapETHForExactTokens","stateMutability":"payable","inputs":[
+		{"name":"amountOut","type":"uint256"},
+		{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}]},
+	{"type":"function","name":"swapExactTokensForETH","inputs":[
+		{"name":"amountIn","type":"uint256"},{"name":"amountOutMin","type":"uint256"},
+		{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}]},
+	{"type":"function","name":"swapTokensForExactETH","inputs":[
+		{"name":"amountOut","type":"uint256"},{"name":"amountInMax","type":"uint256"},
+		{"name":"path","type":"address[]"},{"name":"to","type":"address"},{"name":"deadline","type":"uint256"}]},
+	{"type":"function","name":"stake","stateMutability":"payable","inputs":[]},
+	{"type":"function","name":"stake","inputs":[{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"unstake","inputs":[{"name":"amount","type":"uint256"}]}
+]`
+
+// mustParseABI parses a JSON ABI declared in the package. The ABI is a
+// constant, so a failure is a programming error.
+func mustParseABI(abiJSON string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// knownMethods is the parsed knownMethodsABI
+var knownMethods = mustParseABI(knownMethodsABI)
+
+// TxAmount is an amount of a token moved or approved by a transaction
+type TxAmount struct {
+	Symbol string `json:"symbol"`
+	// Token is the token contract, empty for the native token
+	Token     string  `json:"token,omitempty"`
+	Amount    float64 `json:"amount"`
+	PriceUSD  float64 `json:"price_usd"`
+	ValueUSD  float64 `json:"value_usd"`
+	Unlimited bool    `json:"unlimited,omitempty"`
+	// PriceSample is the stored price sample PriceUSD came from
+	PriceSample *PriceSample `json:"price_sample,omitempty"`
+	// Bound is set when the amount is a slippage limit rather than what moved
+	Bound string `json:"bound,omitempty"`
+}
+
+// TxExplanation is a human-readable account of what a transaction does
+type TxExplanation struct {
+	Hash    string `json:"hash"`
+	Network string `json:"network"`
+	Status  string `json:"status"`
+	Kind    string `json:"kind"`
+	// Method is the decoded method name, or the selector of an unknown one
+	Method string `json:"method,omitempty"`
+	From   string `json:"from"`
+	To     string `json:"to,omitempty"`
+	// Counterparty is the recipient of a transfer, the spender of an
+	// approval, the router of a swap, the staking contract, or the deployed
+	// contract
+	Counterparty string `json:"counterparty,omitempty"`
+	// OnBehalfOf is the owner whose tokens a transferFrom moved
+	OnBehalfOf string     `json:"on_behalf_of,omitempty"`
+	Sent       []TxAmount `json:"sent,omitempty"`
+	Received   []TxAmount `json:"received,omitempty"`
+	Allowance  *TxAmount  `json:"allowance,omitempty"`
+	Events     []string   `json:"events,omitempty"`
+	// BlockNumber and Timestamp are unset while the transaction is pending
+	BlockNumber  uint64     `json:"block_number,omitempty"`
+	Timestamp    *time.Time `json:"timestamp,omitempty"`
+	Fee          *TxAmount  `json:"fee,omitempty"`
+	RevertReason string     `json:"revert_reason,omitempty"`
+	// PredictedStatus is the simulated outcome of a pending transaction,
+	// empty when it couldn't be simulated
+	PredictedStatus string `json:"predicted_status,omitempty"`
+	Summary         string `json:"summary"`
+
+	// Bridge is set when the transaction moved assets across a bridge
+	Bridge *BridgeTransfer `json:"bridge,omitempty"`
+}
+
+// TxNotFoundError is returned for hashes the configured network doesn't know
+type TxNotFoundError struct {
+	Hash    string
+	Network string
+	// OtherNetwork is the Kaia network the hash may have been sent on
+	OtherNetwork string
+}
+
+func (e *TxNotFoundError) Error() string {
+	return fmt.Sprintf("transaction %s not found on %s", e.Hash, e.Network)
+}
+
+func (e *TxNotFoundError) Unwrap() error {
+	return ethereum.NotFound
+}
+
+// CallTrace is the top frame of a debug_traceTransaction callTracer trace
+type CallTrace struct {
+	Error        string        `json:"error,omitempty"`
+	RevertReason string        `json:"revertReason,omitempty"`
+	Output       hexutil.Bytes `json:"output,omitempty"`
+}
+
+// TransactionTracer is implemented by clients that can trace mined
+// transactions. FailoverClient does when an endpoint serves the debug API.
+type TransactionTracer interface {
+	TraceTransaction(ctx context.Context, hash common.Hash) (*CallTrace, error)
+}
+
+// decodedCall is a transaction's calldata decoded against knownMethods. Name
+// is empty for methods that aren't known.
+type decodedCall struct {
+	Name     string
+	Selector string
+	Args     map[string]interface{}
+}
+
+// TxExplainer explains transactions by decoding their calldata and logs
+// against known ABIs and valuing the amounts at the time they were mined
+type TxExplainer struct {
+	client ChainClient
+	pools  *LiquidityPoolReader
+	prices HistoricalPriceSource
+	events *ABIEventDecoder
+	logger *log.Logger
+	now    func() time.Time
+
+	// bridges classifies deposits into and withdrawals from bridges
+	bridges *BridgeDetector
+
+	mu      sync.Mutex
+	tokens  map[common.Address]PoolToken
+	chainID *big.Int
+}
+
+// NewTxExplainer creates a transaction explainer. Token symbols and decimals
+// are read through the pool reader.
+func NewTxExplainer(client ChainClient, pools *LiquidityPoolReader, prices HistoricalPriceSource) *TxExplainer {
+	events := mustRegisterEvents(erc20EventsABI, map[string]interface{}{
+		"Transfer": ERC20Transfer{},
+		"Approval": ERC20Approval{},
+	})
+	if err := events.Register(uniswapV2PairEventsABI, "Swap", PairSwap{}); err != nil {
+		panic(err)
+	}
+	if err := events.Register(actionContractEventsABI, "ActionRequested", ActionRequested{}); err != nil {
+		panic(err)
+	}
+
+	return &TxExplainer{
+		client: client,
+		pools:  pools,
+		prices: prices,
+		events: events,
+		logger: log.New(log.Writer(), "[TxExplainer] ", log.LstdFlags),
+		now:    utcNow,
+		tokens: make(map[common.Address]PoolToken),
+	}
+}
+
+// SetBridgeDetector explains bridge transfers, with their leg on the
+// counterpart chain when it can be found
+func (te *TxExplainer) SetBridgeDetector(bridges *BridgeDetector) {
+	te.bridges = bridges
+}
+
+// Explain describes a transaction. Mined transactions are explained from
+// their receipt, with the revert reason of failed ones; pending ones from
+// their calldata, with the outcome predicted by simulating them against the
+// latest block. Hashes the network doesn't know return a *TxNotFoundError.
+func (te *TxExplainer) Explain(ctx context.Context, hash common.Hash) (*TxExplanation, error) {
+	network, chainID := te.network(ctx)
+
+	tx, pending, err := te.client.TransactionByHash(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		notFound := &TxNotFoundError{Hash: hash.Hex(), Network: network}
+		if other, ok := otherKaiaNetwork[chainID]; ok {
+			notFound.OtherNetwork = kaiaNetworks[other]
+		}
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover sender: %w", err)
+	}
+
+	explanation := &TxExplanation{
+		Hash:    hash.Hex(),
+		Network: network,
+		From:    from.Hex(),
+	}
+	if tx.To() != nil {
+		explanation.To = tx.To().Hex()
+	}
+
+	var receipt *types.Receipt
+	if !pending {
+		receipt, err = te.client.TransactionReceipt(ctx, hash)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return nil, fmt.Errorf("failed to get receipt: %w", err)
+		}
+		// A node that hasn't indexed the receipt yet still has it pending
+		pending = receipt == nil
+	}
+
+	call := decodeCall(tx.Data())
+	if call != nil {
+		explanation.Method = call.Name
+		if call.Name == "" {
+			explanation.Method = call.Selector
+		}
+	}
+
+	pricedAt := te.now()
+	if pending {
+		explanation.Status = TxStatusPending
+		te.classify(ctx, explanation, tx, from, call, nil)
+		te.simulate(ctx, explanation, tx, from)
+	} else {
+		header, err := te.client.HeaderByNumber(ctx, receipt.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block %s: %w", receipt.BlockNumber, err)
+		}
+		pricedAt = time.Unix(int64(header.Time), 0).UTC()
+		explanation.BlockNumber = receipt.BlockNumber.Uint64()
+		explanation.Timestamp = &pricedAt
+
+		fee := new(big.Int).Mul(new(big.Int).SetUint64(receipt.GasUsed), effectiveGasPrice(tx, receipt, header.BaseFee))
+		explanation.Fee = &TxAmount{Symbol: NativeSymbol, Amount: weiToFloat(fee, 18)}
+
+		explanation.Status = TxStatusSuccess
+		if receipt.Status == types.ReceiptStatusFailed {
+			explanation.Status = TxStatusFailed
+			explanation.RevertReason = te.revertReason(ctx, tx, from, receipt)
+		}
+		te.classify(ctx, explanation, tx, from, call, receipt)
+	}
+
+	te.value(ctx, explanation, pricedAt)
+	explanation.Summary = summarizeTransaction(explanation)
+	return explanation, nil
+}
+
+// network names the configured network and returns its chain ID, which is
+// read once
+func (te *TxExplainer) network(ctx context.Context) (string, int64) {
+	te.mu.Lock()
+	chainID := te.chainID
+	te.mu.Unlock()
+
+	if chainID == nil {
+		id, err := te.client.ChainID(ctx)
+		if err != nil {
+			te.logger.Printf("Failed to get chain ID: %v", err)
+			return "the configured network", 0
+		}
+		te.mu.Lock()
+		te.chainID = id
+		te.mu.Unlock()
+		chainID = id
+	}
+
+	if name, ok := kaiaNetworks[chainID.Int64()]; ok {
+		return name, chainID.Int64()
+	}
+	return fmt.Sprintf("chain %s", chainID), chainID.Int64()
+}
+
+// decodeCall decodes calldata against knownMethods. It returns nil for plain
+// value transfers.
+func decodeCall(data []byte) *decodedCall {
+	if len(data) < 4 {
+		return nil
+	}
+	call := &decodedCall{Selector: hexutil.Encode(data[:4]), Args: make(map[string]interface{})}
+	method, err := knownMethods.MethodById(data[:4])
+	if err != nil {
+		return call
+	}
+	if err := method.Inputs.UnpackIntoMap(call.Args, data[4:]); err != nil {
+		return call
+	}
+	call.Name = method.RawName
+	return call
+}
+
+// classify sets the kind of the transaction and what it moved. Mined
+// transactions are read from their logs where possible, pending ones from
+// their arguments.
+func (te *TxExplainer) classify(ctx context.Context, explanation *TxExplanation, tx *types.Transaction, from common.Address, call *decodedCall, receipt *types.Receipt) {
+	var events []DecodedEvent
+	if receipt != nil {
+		for _, log := range receipt.Logs {
+			name, event, err := te.events.Decode(*log)
+			if err != nil {
+				continue
+			}
+			events = append(events, DecodedEvent{Name: name, Address: log.Address, LogIndex: log.Index, Event: event})
+			explanation.Events = append(explanation.Events, name)
+		}
+	}
+
+	name := ""
+	if call != nil {
+		name = call.Name
+	}
+	var bridge *BridgeTransfer
+	if te.bridges != nil && receipt != nil && receipt.Status == types.ReceiptStatusSuccessful {
+		bridge = te.bridges.Classify(receipt.Logs)
+	}
+
+	switch {
+	case tx.To() == nil:
+		explanation.Kind = TxKindDeploy
+		if receipt != nil && receipt.ContractAddress != (common.Address{}) {
+			explanation.Counterparty = receipt.ContractAddress.Hex()
+		}
+	case bridge != nil:
+		explanation.Kind = bridge.Direction
+		explanation.Counterparty = bridge.Recipient
+		te.bridgeAmounts(ctx, explanation, bridge)
+	case strings.HasPrefix(name, "swap") || (name == "" && hasEvent(events, "Swap")):
+		explanation.Kind = TxKindSwap
+		explanation.Counterparty = tx.To().Hex()
+		te.swapAmounts(ctx, explanation, tx, from, call, events)
+	case name == "approve" || (name == "" && ownApproval(events, from) != nil):
+		explanation.Kind = TxKindApproval
+		token, spender, amount := *tx.To(), argAddress(call, "spender"), argBig(call, "amount")
+		if name == "" {
+			approval := ownApproval(events, from)
+			event := approval.Event.(ERC20Approval)
+			token, spender, amount = approval.Address, event.Spender, event.Value
+		}
+		explanation.Counterparty = spender.Hex()
+		allowance := te.amount(ctx, &token, amount)
+		if amount != nil && amount.Cmp(unlimitedAllowance) >= 0 {
+			allowance.Amount, allowance.Unlimited = 0, true
+		}
+		explanation.Allowance = &allowance
+	case name == "stake" || name == "unstake" || (name == "" && stakeActionType(events) != ""):
+		explanation.Kind = TxKindStake
+		if name == "" {
+			explanation.Method = stakeActionType(events)
+		}
+		explanation.Counterparty = tx.To().Hex()
+		te.stakeAmounts(ctx, explanation, tx, from, call, events)
+	case name == "transfer" || name == "transferFrom":
+		explanation.Kind = TxKindTransfer
+		explanation.Counterparty = argAddress(call, "to").Hex()
+		if name == "transferFrom" {
+			explanation.OnBehalfOf = argAddress(call, "from").Hex()
+		}
+		explanation.Sent = []TxAmount{te.amount(ctx, tx.To(), argBig(call, "amount"))}
+	case call == nil:
+		explanation.Kind = TxKindTransfer
+		explanation.Counterparty = tx.To().Hex()
+		explanation.Sent = []TxAmount{te.amount(ctx, nil, tx.Value())}
+	default:
+		explanation.Kind = TxKindContractCall
+		explanation.Counterparty = tx.To().Hex()
+		if tx.Value().Sign() > 0 {
+			explanation.Sent = []TxAmount{te.amount(ctx, nil, tx.Value())}
+		}
+	}
+}
+
+// swapAmounts sets what a swap sent and received. Mined swaps are read from
+// the Transfer logs in and out of the sender; pending ones, and swaps whose
+// logs don't show a leg, from the router arguments, which are bounds for the
+// side the swap doesn't fix.
+func (te *TxExplainer) swapAmounts(ctx context.Context, explanation *TxExplanation, tx *types.Transaction, from common.Address, call *decodedCall, events []DecodedEvent) {
+	recipient := from
+	if to := argAddress(call, "to"); to != (common.Address{}) {
+		recipient = to
+	}
+
+	if tx.Value().Sign() > 0 {
+		explanation.Sent = append(explanation.Sent, te.amount(ctx, nil, tx.Value()))
+	}
+	explanation.Sent = append(explanation.Sent, te.transferTotals(ctx, events, func(transfer ERC20Transfer) bool { return transfer.From == from })...)
+	explanation.Received = te.transferTotals(ctx, events, func(transfer ERC20Transfer) bool { return transfer.To == recipient })
+
+	name := ""
+	if call != nil {
+		name = call.Name
+	}
+	// Native output is unwrapped by the router, so the last hop's wrapped
+	// token transfer is what was received
+	if len(explanation.Received) == 0 && strings.HasSuffix(name, "ForETH") {
+		for i := len(events) - 1; i >= 0; i-- {
+			if transfer, ok := events[i].Event.(ERC20Transfer); ok {
+				explanation.Received = []TxAmount{te.amount(ctx, nil, transfer.Value)}
+				break
+			}
+		}
+	}
+
+	path := argPath(call)
+	if len(path) == 0 {
+		return
+	}
+	if len(explanation.Sent) == 0 {
+		token := &path[0]
+		if strings.Contains(name, "ETHFor") {
+			token = nil
+		}
+		if amount := argBig(call, "amountIn"); amount != nil {
+			explanation.Sent = []TxAmount{te.amount(ctx, token, amount)}
+		} else if amount := argBig(call, "amountInMax"); amount != nil {
+			sent := te.amount(ctx, token, amount)
+			sent.Bound = AmountBoundMax
+			explanation.Sent = []TxAmount{sent}
+		}
+	}
+	if len(explanation.Received) == 0 {
+		token := &path[len(path)-1]
+		if strings.HasSuffix(name, "ForETH") {
+			token = nil
+		}
+		if amount := argBig(call, "amountOut"); amount != nil {
+			explanation.Received = []TxAmount{te.amount(ctx, token, amount)}
+		} else if amount := argBig(call, "amountOutMin"); amount != nil {
+			received := te.amount(ctx, token, amount)
+			received.Bound = AmountBoundMin
+			explanation.Received = []TxAmount{received}
+		}
+	}
+}
+
+// bridgeAmounts sets what a bridge transfer sent or delivered on Kaia, and
+// links it to its leg on the counterpart chain
+func (te *TxExplainer) bridgeAmounts(ctx context.Context, explanation *TxExplanation, bridge *BridgeTransfer) {
+	if err := te.bridges.Link(ctx, bridge, *explanation.Timestamp); err != nil {
+		te.logger.Printf("Failed to link bridge transfer %s: %v", explanation.Hash, err)
+	}
+	explanation.Bridge = bridge
+
+	var token *common.Address
+	if bridge.Token != "" {
+		address := common.HexToAddress(bridge.Token)
+		token = &address
+	}
+	raw, _ := new(big.Int).SetString(bridge.Amount, 10)
+	moved := te.amount(ctx, token, raw)
+	if bridge.Direction == BridgeDirectionIn {
+		explanation.Received = []TxAmount{moved}
+	} else {
+		explanation.Sent = []TxAmount{moved}
+	}
+}
+
+// stakeAmounts sets what a stake sent or an unstake returned: the native
+// value, the tokens the logs show moving, or the amount argument in the
+// native token
+func (te *TxExplainer) stakeAmounts(ctx context.Context, explanation *TxExplanation, tx *types.Transaction, from common.Address, call *decodedCall, events []DecodedEvent) {
+	if explanation.Method == "unstake" {
+		explanation.Received = te.transferTotals(ctx, events, func(transfer ERC20Transfer) bool { return transfer.To == from })
+		if len(explanation.Received) == 0 && argBig(call, "amount") != nil {
+			explanation.Received = []TxAmount{te.amount(ctx, nil, argBig(call, "amount"))}
+		}
+		return
+	}
+
+	if tx.Value().Sign() > 0 {
+		explanation.Sent = []TxAmount{te.amount(ctx, nil, tx.Value())}
+		return
+	}
+	explanation.Sent = te.transferTotals(ctx, events, func(transfer ERC20Transfer) bool { return transfer.From == from })
+	if len(explanation.Sent) == 0 && argBig(call, "amount") != nil {
+		explanation.Sent = []TxAmount{te.amount(ctx, nil, argBig(call, "amount"))}
+	}
+}
+
+// transferTotals sums the Transfer events matching the filter by token, in
+// the order the tokens first appear
+func (te *TxExplainer) transferTotals(ctx context.Context, events []DecodedEvent, match func(ERC20Transfer) bool) []TxAmount {
+	var order []common.Address
+	totals := make(map[common.Address]*big.Int)
+	for _, event := range events {
+		transfer, ok := event.Event.(ERC20Transfer)
+		if !ok || !match(transfer) {
+			continue
+		}
+		if _, ok := totals[event.Address]; !ok {
+			order = append(order, event.Address)
+			totals[event.Address] = new(big.Int)
+		}
+		totals[event.Address].Add(totals[event.Address], transfer.Value)
+	}
+
+	amounts := make([]TxAmount, 0, len(order))
+	for _, token := range order {
+		token := token
+		amounts = append(amounts, te.amount(ctx, &token, totals[token]))
+	}
+	return amounts
+}
+
+// amount converts a raw amount of a token, or of the native token when token
+// is nil, using the token's decimals
+func (te *TxExplainer) amount(ctx context.Context, token *common.Address, raw *big.Int) TxAmount {
+	if raw == nil {
+		raw = new(big.Int)
+	}
+	if token == nil {
+		return TxAmount{Symbol: NativeSymbol, Amount: weiToFloat(raw, 18)}
+	}
+	info := te.token(ctx, *token)
+	return TxAmount{Symbol: info.Symbol, Token: token.Hex(), Amount: weiToFloat(raw, info.Decimals)}
+}
+
+// token describes a token, remembering what was read. Tokens that can't be
+// read are shown by address with the default decimals.
+func (te *TxExplainer) token(ctx context.Context, address common.Address) PoolToken {
+	te.mu.Lock()
+	info, ok := te.tokens[address]
+	te.mu.Unlock()
+	if ok {
+		return info
+	}
+
+	info = PoolToken{Symbol: address.Hex(), Address: address, Decimals: defaultTokenDecimals}
+	if te.pools != nil {
+		read, err := te.pools.poolToken(ctx, address)
+		if err != nil {
+			te.logger.Printf("Failed to read token %s: %v", address.Hex(), err)
+			return info
+		}
+		info = read
+	}
+
+	te.mu.Lock()
+	te.tokens[address] = info
+	te.mu.Unlock()
+	return info
+}
+
+// value prices every amount of the explanation at the given time. Amounts of
+// tokens without a price are left at zero.
+func (te *TxExplainer) value(ctx context.Context, explanation *TxExplanation, at time.Time) {
+	if te.prices == nil {
+		return
+	}
+
+	samples := make(map[string]*PriceSample)
+	price := func(amount *TxAmount) {
+		if amount == nil || common.IsHexAddress(amount.Symbol) {
+			return
+		}
+		sample, ok := samples[amount.Symbol]
+		if !ok {
+			if priced, err := samplePrice(ctx, te.prices, amount.Symbol, at); err == nil {
+				sample = &priced
+			}
+			samples[amount.Symbol] = sample
+		}
+		if sample == nil {
+			return
+		}
+		amount.PriceUSD = sample.PriceUSD
+		amount.ValueUSD = amount.Amount * sample.PriceUSD
+		amount.PriceSample = sample
+	}
+
+	for i := range explanation.Sent {
+		price(&explanation.Sent[i])
+	}
+	for i := range explanation.Received {
+		price(&explanation.Received[i])
+	}
+	price(explanation.Allowance)
+	price(explanation.Fee)
+}
+
+// revertReason explains why a mined transaction failed, from the node's trace
+// when it has the debug API and otherwise by replaying the call on the state
+// before its block. The replay doesn't see transactions earlier in the same
+// block, so it can miss reasons that depend on them.
+func (te *TxExplainer) revertReason(ctx context.Context, tx *types.Transaction, from common.Address, receipt *types.Receipt) string {
+	if tracer, ok := te.client.(TransactionTracer); ok {
+		trace, err := tracer.TraceTransaction(ctx, tx.Hash())
+		if err != nil {
+			te.logger.Printf("Failed to trace %s: %v", tx.Hash().Hex(), err)
+		} else {
+			if trace.RevertReason != "" {
+				return trace.RevertReason
+			}
+			if reason := decodeRevertData(trace.Output); reason != "" {
+				return reason
+			}
+			if trace.Error != "" {
+				return trace.Error
+			}
+		}
+	}
+
+	parent := new(big.Int).Sub(receipt.BlockNumber, big.NewInt(1))
+	if _, err := te.client.CallContract(ctx, callMessage(tx, from), parent); err != nil {
+		if reason := revertReasonFromError(err); reason != "" {
+			return reason
+		}
+		te.logger.Printf("Failed to replay %s: %v", tx.Hash().Hex(), err)
+	}
+	if receipt.GasUsed >= tx.Gas() {
+		return "out of gas"
+	}
+	return ""
+}
+
+// simulate predicts the outcome of a pending transaction by calling it
+// against the latest block
+func (te *TxExplainer) simulate(ctx context.Context, explanation *TxExplanation, tx *types.Transaction, from common.Address) {
+	_, err := te.client.CallContract(ctx, callMessage(tx, from), nil)
+	if err == nil {
+		explanation.PredictedStatus = TxStatusSuccess
+		return
+	}
+	if reason := revertReasonFromError(err); reason != "" {
+		explanation.PredictedStatus = TxStatusFailed
+		explanation.RevertReason = reason
+		return
+	}
+	te.logger.Printf("Failed to simulate %s: %v", tx.Hash().Hex(), err)
+}
+
+// callMessage is a call replaying the transaction. The gas price is left out
+// so the sender's balance isn't checked against it.
+func callMessage(tx *types.Transaction, from common.Address) ethereum.CallMsg {
+	return ethereum.CallMsg{
+		From:  from,
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+}
+
+// revertReasonFromError returns the reason a call reverted, or "" when the
+// error isn't a revert
+func revertReasonFromError(err error) string {
+	if !isRevert(err) {
+		return ""
+	}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			if reason := decodeRevertData(common.FromHex(data)); reason != "" {
+				return reason
+			}
+		}
+	}
+
+	message := err.Error()
+	if i := strings.Index(message, "execution reverted"); i >= 0 {
+		message = message[i:]
+	}
+	if reason := strings.TrimPrefix(message, "execution reverted: "); reason != message {
+		return reason
+	}
+	return message
+}
+
+// decodeRevertData decodes Error(string) and Panic(uint256) revert data and
+// names the selector of custom errors. It returns "" when there is no data.
+func decodeRevertData(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+	return "custom error 0x" + hex.EncodeToString(data[:4])
+}
+
+// hasEvent reports whether any of the events has the name
+func hasEvent(events []DecodedEvent, name string) bool {
+	for _, event := range events {
+		if event.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ownApproval returns the first Approval the owner granted
+func ownApproval(events []DecodedEvent, owner common.Address) *DecodedEvent {
+	for i, event := range events {
+		if approval, ok := event.Event.(ERC20Approval); ok && approval.Owner == owner {
+			return &events[i]
+		}
+	}
+	return nil
+}
+
+// stakeActionType returns the stake or unstake action an ActionContract
+// request was for, if any
+func stakeActionType(events []DecodedEvent) string {
+	for _, event := range events {
+		if request, ok := event.Event.(ActionRequested); ok {
+			if actionType := strings.ToLower(request.ActionType); actionType == "stake" || actionType == "unstake" {
+				return actionType
+			}
+		}
+	}
+	return ""
+}
+
+// argBig returns a uint256 argument of the call, or nil
+func argBig(call *decodedCall, name string) *big.Int {
+	if call == nil {
+		return nil
+	}
+	value, _ := call.Args[name].(*big.Int)
+	return value
+}
+
+// argAddress returns an address argument of the call, or the zero address
+func argAddress(call *decodedCall, name string) common.Address {
+	if call == nil {
+		return common.Address{}
+	}
+	value, _ := call.Args[name].(common.Address)
+	return value
+}
+
+// argPath returns the token path of a router swap
+func argPath(call *decodedCall) []common.Address {
+	if call == nil {
+		return nil
+	}
+	path, _ := call.Args["path"].([]common.Address)
+	return path
+}
+
+// summarizeTransaction renders an explanation as one paragraph
+func summarizeTransaction(explanation *TxExplanation) string {
+	pending := explanation.Status == TxStatusPending
+	verb := func(past, future string) string {
+		if pending {
+			return future
+		}
+		return past
+	}
+
+	var text strings.Builder
+	if pending {
+		text.WriteString(fmt.Sprintf("This transaction is still pending on %s. Once mined, %s will ", explanation.Network, shortAddress(explanation.From)))
+	} else {
+		text.WriteString(fmt.Sprintf("On %s, %s ", explanation.Timestamp.Format("2 Jan 2006 at 15:04 UTC"), shortAddress(explanation.From)))
+	}
+
+	counterparty := shortAddress(explanation.Counterparty)
+	switch explanation.Kind {
+	case TxKindTransfer:
+		if explanation.OnBehalfOf != "" {
+			text.WriteString(fmt.Sprintf("%s %s from %s to %s", verb("moved", "move"), formatTxAmounts(explanation.Sent), shortAddress(explanation.OnBehalfOf), counterparty))
+		} else {
+			text.WriteString(fmt.Sprintf("%s %s to %s", verb("sent", "send"), formatTxAmounts(explanation.Sent), counterparty))
+		}
+	case TxKindSwap:
+		text.WriteString(fmt.Sprintf("%s %s for %s through %s", verb("swapped", "swap"),
+			formatTxAmounts(explanation.Sent), formatTxAmounts(explanation.Received), counterparty))
+	case TxKindApproval:
+		allowance := explanation.Allowance
+		if allowance.Unlimited {
+			text.WriteString(fmt.Sprintf("%s %s to spend an unlimited amount of %s", verb("approved", "approve"), counterparty, allowance.Symbol))
+		} else {
+			text.WriteString(fmt.Sprintf("%s %s to spend up to %s", verb("approved", "approve"), counterparty, formatTxAmount(*allowance)))
+		}
+	case TxKindStake:
+		if explanation.Method == "unstake" {
+			text.WriteString(fmt.Sprintf("%s %s from %s", verb("unstaked", "unstake"), formatTxAmounts(explanation.Received), counterparty))
+		} else {
+			text.WriteString(fmt.Sprintf("%s %s with %s", verb("staked", "stake"), formatTxAmounts(explanation.Sent), counterparty))
+		}
+	case TxKindBridgeOut:
+		text.WriteString(fmt.Sprintf("bridged %s from %s to %s on %s through %s",
+			formatTxAmounts(explanation.Sent), explanation.Network, counterparty, explanation.Bridge.CounterpartChain, explanation.Bridge.Bridge))
+	case TxKindBridgeIn:
+		text.WriteString(fmt.Sprintf("delivered %s bridged from %s through %s to %s",
+			formatTxAmounts(explanation.Received), explanation.Bridge.CounterpartChain, explanation.Bridge.Bridge, counterparty))
+	case TxKindDeploy:
+		if explanation.Counterparty != "" {
+			text.WriteString(fmt.Sprintf("%s a new contract at %s", verb("deployed", "deploy"), counterparty))
+		} else {
+			text.WriteString(verb("deployed", "deploy") + " a new contract")
+		}
+	default:
+		text.WriteString(fmt.Sprintf("%s %s on %s", verb("called", "call"), explanation.Method, counterparty))
+		if len(explanation.Sent) > 0 {
+			text.WriteString(" with " + formatTxAmounts(explanation.Sent))
+		}
+	}
+	text.WriteString(".")
+	if explanation.Bridge != nil {
+		text.WriteString(" " + summarizeBridgeLeg(explanation.Bridge))
+	}
+
+	switch explanation.Status {
+	case TxStatusSuccess:
+		text.WriteString(fmt.Sprintf(" It succeeded in block %d, paying %s in fees.", explanation.BlockNumber, formatTxAmount(*explanation.Fee)))
+	case TxStatusFailed:
+		reason := explanation.RevertReason
+		if reason == "" {
+			reason = "the revert reason couldn't be determined"
+		}
+		text.WriteString(fmt.Sprintf(" It failed in block %d (%s), so nothing changed but the %s fee was still paid.",
+			explanation.BlockNumber, reason, formatTxAmount(*explanation.Fee)))
+	case TxStatusPending:
+		switch explanation.PredictedStatus {
+		case TxStatusSuccess:
+			text.WriteString(" Simulated against the latest block, it is expected to succeed.")
+		case TxStatusFailed:
+			text.WriteString(fmt.Sprintf(" Simulated against the latest block, it is expected to fail (%s).", explanation.RevertReason))
+		default:
+			text.WriteString(" It couldn't be simulated, so its outcome is unknown.")
+		}
+	}
+	return text.String()
+}
+
+// summarizeBridgeLeg says where the other leg of a bridge transfer stands
+func summarizeBridgeLeg(bridge *BridgeTransfer) string {
+	leg := bridge.Counterpart
+	switch {
+	case leg != nil && bridge.Direction == BridgeDirectionOut:
+		return fmt.Sprintf("The funds arrived on %s on %s in transaction %s.",
+			bridge.CounterpartChain, leg.Timestamp.Format("2 Jan 2006 at 15:04 UTC"), leg.TxHash)
+	case leg != nil:
+		return fmt.Sprintf("They were sent from %s on %s in transaction %s.",
+			bridge.CounterpartChain, leg.Timestamp.Format("2 Jan 2006 at 15:04 UTC"), leg.TxHash)
+	case bridge.Direction == BridgeDirectionOut:
+		return fmt.Sprintf("The transfer is still pending: the funds haven't been seen arriving on %s yet.", bridge.CounterpartChain)
+	default:
+		return fmt.Sprintf("The deposit on %s that sent them hasn't been matched.", bridge.CounterpartChain)
+	}
+}
+
+// formatTxAmounts joins amounts for a sentence, or "nothing" when there are none
+func formatTxAmounts(amounts []TxAmount) string {
+	if len(amounts) == 0 {
+		return "nothing"
+	}
+	formatted := make([]string, len(amounts))
+	for i, amount := range amounts {
+		formatted[i] = formatTxAmount(amount)
+	}
+	return strings.Join(formatted, " and ")
+}
+
+// formatTxAmount renders an amount with its USD value when it has a price
+func formatTxAmount(amount TxAmount) string {
+	text := strconv.FormatFloat(roundTo(amount.Amount, 6), 'f', -1, 64) + " " + amount.Symbol
+	if common.IsHexAddress(amount.Symbol) {
+		text = strconv.FormatFloat(roundTo(amount.Amount, 6), 'f', -1, 64) + " of token " + shortAddress(amount.Symbol)
+	}
+	switch amount.Bound {
+	case AmountBoundMin:
+		text = "at least " + text
+	case AmountBoundMax:
+		text = "up to " + text
+	}
+	if amount.PriceUSD > 0 {
+		text += " (" + formatUSD(amount.ValueUSD) + ")"
+	}
+	return text
+}
+
+// formatUSD renders a USD value to the cent, showing dust as under a cent
+func formatUSD(value float64) string {
+	if value > 0 && value < 0.01 {
+		return "under $0.01"
+	}
+	return "$" + NumberFormat{}.Decimal(value, 2)
+}
+
+// shortAddress abbreviates an address to its first and last hex digits
+func shortAddress(address string) string {
+	if len(address) != 42 {
+		return address
+	}
+	return address[:6] + "…" + address[38:]
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	explainUSDT    = common.HexToAddress("0x00000000000000000000000000000000000000e1")
+	explainWKAIA   = common.HexToAddress("0x00000000000000000000000000000000000000e2")
+	explainPair    = common.HexToAddress("0x00000000000000000000000000000000000000e3")
+	explainRouter  = common.HexToAddress("0x00000000000000000000000000000000000000e4")
+	explainStaking = common.HexToAddress("0x00000000000000000000000000000000000000e5")
+	explainFriend  = common.HexToAddress("0x00000000000000000000000000000000000000e6")
+)
+
+// explainMinedAt is when the fixture block was mined
+var explainMinedAt = time.Date(2025, 6, 2, 14, 5, 0, 0, time.UTC)
+
+// explainPrices prices symbols only at the time the fixture block was mined
+type explainPrices map[string]float64
+
+func (e explainPrices) PriceAt(ctx context.Context, symbol string, at time.Time) (float64, error) {
+	price, ok := e[symbol]
+	if !ok || !at.Equal(explainMinedAt) {
+		return 0, fmt.Errorf("no price for %s at %s", symbol, at)
+	}
+	return price, nil
+}
+
+// revertError is a node's revert error carrying the revert data
+type revertError struct {
+	data string
+}
+
+func (e revertError) Error() string          { return "execution reverted" }
+func (e revertError) ErrorData() interface{} { return e.data }
+
+// explainChain serves fixture transactions and receipts from block 100, and
+// answers every call with callErr
+type explainChain struct {
+	ChainClient
+
+	txs      map[common.Hash]*types.Transaction
+	pending  map[common.Hash]bool
+	receipts map[common.Hash]*types.Receipt
+	callErr  error
+	calledAt []*big.Int
+}
+
+func (ec *explainChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(8217), nil
+}
+
+func (ec *explainChain) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := ec.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, ec.pending[hash], nil
+}
+
+func (ec *explainChain) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	receipt, ok := ec.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (ec *explainChain) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: number, Time: uint64(explainMinedAt.Unix()), BaseFee: big.NewInt(25 * gwei)}, nil
+}
+
+func (ec *explainChain) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	ec.calledAt = append(ec.calledAt, blockNumber)
+	return nil, ec.callErr
+}
+
+// tracingChain is an explainChain whose node serves the debug API
+type tracingChain struct {
+	*explainChain
+
+	trace *CallTrace
+}
+
+func (tc *tracingChain) TraceTransaction(ctx context.Context, hash common.Hash) (*CallTrace, error) {
+	return tc.trace, nil
+}
+
+// explainFixture signs transactions from one key onto an explainChain
+type explainFixture struct {
+	chain  *explainChain
+	key    *ecdsa.PrivateKey
+	sender common.Address
+	nonce  uint64
+}
+
+func newExplainFixture(t *testing.T) *explainFixture {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return &explainFixture{
+		chain: &explainChain{
+			txs:      make(map[common.Hash]*types.Transaction),
+			pending:  make(map[common.Hash]bool),
+			receipts: make(map[common.Hash]*types.Receipt),
+		},
+		key:    key,
+		sender: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// sign adds a signed transaction to the chain without a receipt
+func (f *explainFixture) sign(to *common.Address, value *big.Int, data []byte) *types.Transaction {
+	chainID := big.NewInt(8217)
+	tx := types.MustSignNewTx(f.key, types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     f.nonce,
+		GasTipCap: big.NewInt(gwei),
+		GasFeeCap: big.NewInt(50 * gwei),
+		Gas:       200_000,
+		To:        to,
+		Value:     value,
+		Data:      data,
+	})
+	f.nonce++
+	f.chain.txs[tx.Hash()] = tx
+	return tx
+}
+
+// mine adds a transaction mined in block 100 using 50,000 gas at 25 gwei
+func (f *explainFixture) mine(to *common.Address, value *big.Int, data []byte, status uint64, logs ...*types.Log) common.Hash {
+	tx := f.sign(to, value, data)
+	receipt := &types.Receipt{
+		Status:            status,
+		GasUsed:           50_000,
+		EffectiveGasPrice: big.NewInt(25 * gwei),
+		BlockNumber:       big.NewInt(100),
+		Logs:              logs,
+	}
+	if to == nil {
+		receipt.ContractAddress = crypto.CreateAddress(f.sender, tx.Nonce())
+	}
+	f.chain.receipts[tx.Hash()] = receipt
+	return tx.Hash()
+}
+
+// broadcast adds a transaction that is still pending
+func (f *explainFixture) broadcast(to *common.Address, value *big.Int, data []byte) common.Hash {
+	tx := f.sign(to, value, data)
+	f.chain.pending[tx.Hash()] = true
+	return tx.Hash()
+}
+
+func (f *explainFixture) explainer() *TxExplainer {
+	return newTestExplainer(f.chain)
+}
+
+func newTestExplainer(client ChainClient) *TxExplainer {
+	pools := NewLiquidityPoolReader(client, []TrackedToken{
+		{Symbol: "USDT", Address: explainUSDT, Decimals: 6},
+		{Symbol: "WKAIA", Address: explainWKAIA, Decimals: 18},
+	}, fakePrices{})
+	explainer := NewTxExplainer(client, pools, explainPrices{NativeSymbol: 0.2, "WKAIA": 0.2, "USDT": 1})
+	explainer.now = func() time.Time { return explainMinedAt }
+	return explainer
+}
+
+// pack encodes a call of a known method
+func pack(t *testing.T, method string, args ...interface{}) []byte {
+	data, err := knownMethods.Pack(method, args...)
+	require.NoError(t, err)
+	return data
+}
+
+// eventLog encodes an event of an ABI with its indexed address arguments
+func eventLog(t *testing.T, abiJSON, name string, contract common.Address, indexed []common.Address, values ...interface{}) *types.Log {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	require.NoError(t, err)
+	event := parsed.Events[name]
+	data, err := event.Inputs.NonIndexed().Pack(values...)
+	require.NoError(t, err)
+
+	topics := []common.Hash{event.ID}
+	for _, address := range indexed {
+		topics = append(topics, common.BytesToHash(address.Bytes()))
+	}
+	return &types.Log{Address: contract, Topics: topics, Data: data}
+}
+
+// revertData encodes an Error(string) revert
+func revertData(t *testing.T, reason string) string {
+	stringType, err := abi.NewType("string", "", nil)
+	require.NoError(t, err)
+	encoded, err := abi.Arguments{{Type: stringType}}.Pack(reason)
+	require.NoError(t, err)
+	return hexutil.Encode(append(crypto.Keccak256([]byte("Error(string)"))[:4], encoded...))
+}
+
+func TestExplainClassifiesTransactions(t *testing.T) {
+	f := newExplainFixture(t)
+	sender := shortAddress(f.sender.Hex())
+	ctx := context.Background()
+	zero := big.NewInt(0)
+
+	t.Run("native transfer", func(t *testing.T) {
+		hash := f.mine(&explainFriend, units(15, 17), nil, types.ReceiptStatusSuccessful)
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindTransfer, explanation.Kind)
+		assert.Equal(t, TxStatusSuccess, explanation.Status)
+		assert.Equal(t, "Kaia Mainnet", explanation.Network)
+		require.Len(t, explanation.Sent, 1)
+		assert.InDelta(t, 0.3, explanation.Sent[0].ValueUSD, 1e-9)
+		assert.InDelta(t, 0.00125, explanation.Fee.Amount, 1e-12)
+		assert.Equal(t, "On 2 Jun 2025 at 14:05 UTC, "+sender+" sent 1.5 KAIA ($0.30) to "+shortAddress(explainFriend.Hex())+". "+
+			"It succeeded in block 100, paying 0.00125 KAIA (under $0.01) in fees.", explanation.Summary)
+	})
+
+	t.Run("token transfer", func(t *testing.T) {
+		hash := f.mine(&explainUSDT, nil, pack(t, "transfer", explainFriend, units(250, 6)), types.ReceiptStatusSuccessful,
+			eventLog(t, erc20EventsABI, "Transfer", explainUSDT, []common.Address{f.sender, explainFriend}, units(250, 6)))
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindTransfer, explanation.Kind)
+		assert.Equal(t, "transfer", explanation.Method)
+		assert.Equal(t, explainFriend.Hex(), explanation.Counterparty)
+		assert.Equal(t, []string{"Transfer"}, explanation.Events)
+		assert.Contains(t, explanation.Summary, sender+" sent 250 USDT ($250.00) to "+shortAddress(explainFriend.Hex())+".")
+	})
+
+	t.Run("swap", func(t *testing.T) {
+		call := pack(t, "swapExactTokensForTokens", units(100, 6), units(490, 18), []common.Address{explainUSDT, explainWKAIA}, f.sender, big.NewInt(1e10))
+		hash := f.mine(&explainRouter, nil, call, types.ReceiptStatusSuccessful,
+			eventLog(t, erc20EventsABI, "Transfer", explainUSDT, []common.Address{f.sender, explainPair}, units(100, 6)),
+			eventLog(t, uniswapV2PairEventsABI, "Swap", explainPair, []common.Address{explainRouter, f.sender}, units(100, 6), zero, zero, units(500, 18)),
+			eventLog(t, erc20EventsABI, "Transfer", explainWKAIA, []common.Address{explainPair, f.sender}, units(500, 18)))
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindSwap, explanation.Kind)
+		assert.Equal(t, []string{"Transfer", "Swap", "Transfer"}, explanation.Events)
+		require.Len(t, explanation.Received, 1)
+		assert.Equal(t, TxAmount{Symbol: "WKAIA", Token: explainWKAIA.Hex(), Amount: 500, PriceUSD: 0.2, ValueUSD: 100,
+			PriceSample: &PriceSample{Symbol: "WKAIA", At: NewAPITime(explainMinedAt), PriceUSD: 0.2, Method: PriceSampleReported}}, explanation.Received[0])
+		assert.Contains(t, explanation.Summary, sender+" swapped 100 USDT ($100.00) for 500 WKAIA ($100.00) through "+shortAddress(explainRouter.Hex())+".")
+	})
+
+	t.Run("unlimited approval", func(t *testing.T) {
+		hash := f.mine(&explainUSDT, nil, pack(t, "approve", explainRouter, math.MaxBig256), types.ReceiptStatusSuccessful,
+			eventLog(t, erc20EventsABI, "Approval", explainUSDT, []common.Address{f.sender, explainRouter}, math.MaxBig256))
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindApproval, explanation.Kind)
+		require.NotNil(t, explanation.Allowance)
+		assert.True(t, explanation.Allowance.Unlimited)
+		assert.Contains(t, explanation.Summary, sender+" approved "+shortAddress(explainRouter.Hex())+" to spend an unlimited amount of USDT.")
+	})
+
+	t.Run("approval by an unknown method", func(t *testing.T) {
+		hash := f.mine(&explainUSDT, nil, hexutil.MustDecode("0xd505accf"), types.ReceiptStatusSuccessful,
+			eventLog(t, erc20EventsABI, "Approval", explainUSDT, []common.Address{f.sender, explainRouter}, units(40, 6)))
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindApproval, explanation.Kind)
+		assert.Equal(t, "0xd505accf", explanation.Method)
+		assert.Contains(t, explanation.Summary, "approved "+shortAddress(explainRouter.Hex())+" to spend up to 40 USDT ($40.00).")
+	})
+
+	t.Run("stake", func(t *testing.T) {
+		hash := f.mine(&explainStaking, units(100, 18), pack(t, "stake"), types.ReceiptStatusSuccessful)
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindStake, explanation.Kind)
+		assert.Contains(t, explanation.Summary, sender+" staked 100 KAIA ($20.00) with "+shortAddress(explainStaking.Hex())+".")
+	})
+
+	t.Run("contract deploy", func(t *testing.T) {
+		hash := f.mine(nil, nil, hexutil.MustDecode("0x6080604052"), types.ReceiptStatusSuccessful)
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		deployed := crypto.CreateAddress(f.sender, f.nonce-1)
+		assert.Equal(t, TxKindDeploy, explanation.Kind)
+		assert.Equal(t, deployed.Hex(), explanation.Counterparty)
+		assert.Contains(t, explanation.Summary, sender+" deployed a new contract at "+shortAddress(deployed.Hex())+".")
+	})
+
+	t.Run("unknown contract call", func(t *testing.T) {
+		hash := f.mine(&explainStaking, nil, hexutil.MustDecode("0xdeadbeef"), types.ReceiptStatusSuccessful)
+		explanation, err := f.explainer().Explain(ctx, hash)
+		require.NoError(t, err)
+
+		assert.Equal(t, TxKindContractCall, explanation.Kind)
+		assert.Contains(t, explanation.Summary, sender+" called 0xdeadbeef on "+shortAddress(explainStaking.Hex())+".")
+	})
+}
+
+func TestExplainFailedTransactionRevertReason(t *testing.T) {
+	f := newExplainFixture(t)
+	call := pack(t, "transfer", explainFriend, units(250, 6))
+
+	// Without the debug API the call is replayed on the parent block
+	hash := f.mine(&explainUSDT, nil, call, types.ReceiptStatusFailed)
+	f.chain.callErr = revertError{data: revertData(t, "ERC20: transfer amount exceeds balance")}
+	explanation, err := f.explainer().Explain(context.Background(), hash)
+	require.NoError(t, err)
+
+	assert.Equal(t, TxStatusFailed, explanation.Status)
+	assert.Equal(t, "ERC20: transfer amount exceeds balance", explanation.RevertReason)
+	require.Len(t, f.chain.calledAt, 1)
+	assert.Equal(t, big.NewInt(99), f.chain.calledAt[0])
+	assert.Contains(t, explanation.Summary, "It failed in block 100 (ERC20: transfer amount exceeds balance), "+
+		"so nothing changed but the 0.00125 KAIA (under $0.01) fee was still paid.")
+
+	// The trace is preferred when the node serves it
+	tracing := &tracingChain{explainChain: f.chain, trace: &CallTrace{Error: "execution reverted", RevertReason: "TransferHelper: TRANSFER_FAILED"}}
+	explanation, err = newTestExplainer(tracing).Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, "TransferHelper: TRANSFER_FAILED", explanation.RevertReason)
+	assert.Len(t, f.chain.calledAt, 1, "no replay was needed")
+
+	tracing.trace = &CallTrace{Error: "execution reverted", Output: hexutil.MustDecode(revertData(t, "paused"))}
+	explanation, err = newTestExplainer(tracing).Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, "paused", explanation.RevertReason)
+}
+
+func TestExplainPendingTransactionIsSimulated(t *testing.T) {
+	f := newExplainFixture(t)
+	call := pack(t, "swapExactTokensForTokens", units(100, 6), units(490, 18), []common.Address{explainUSDT, explainWKAIA}, f.sender, big.NewInt(1e10))
+	hash := f.broadcast(&explainRouter, nil, call)
+
+	explanation, err := f.explainer().Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, TxStatusPending, explanation.Status)
+	assert.Equal(t, TxKindSwap, explanation.Kind)
+	assert.Equal(t, TxStatusSuccess, explanation.PredictedStatus)
+	assert.Nil(t, explanation.Timestamp)
+	assert.Nil(t, explanation.Fee)
+	require.Len(t, explanation.Received, 1)
+	assert.Equal(t, AmountBoundMin, explanation.Received[0].Bound)
+	assert.Equal(t, "This transaction is still pending on Kaia Mainnet. Once mined, "+shortAddress(f.sender.Hex())+
+		" will swap 100 USDT ($100.00) for at least 490 WKAIA ($98.00) through "+shortAddress(explainRouter.Hex())+". "+
+		"Simulated against the latest block, it is expected to succeed.", explanation.Summary)
+	require.Len(t, f.chain.calledAt, 1)
+	assert.Nil(t, f.chain.calledAt[0], "simulated against the latest block")
+
+	f.chain.callErr = errors.New("all RPC endpoints failed: execution reverted: UniswapV2Router: INSUFFICIENT_OUTPUT_AMOUNT")
+	explanation, err = f.explainer().Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Equal(t, TxStatusFailed, explanation.PredictedStatus)
+	assert.Equal(t, "UniswapV2Router: INSUFFICIENT_OUTPUT_AMOUNT", explanation.RevertReason)
+	assert.Contains(t, explanation.Summary, "it is expected to fail (UniswapV2Router: INSUFFICIENT_OUTPUT_AMOUNT).")
+
+	// An unreachable node leaves the outcome unknown
+	f.chain.callErr = errors.New("connection refused")
+	explanation, err = f.explainer().Explain(context.Background(), hash)
+	require.NoError(t, err)
+	assert.Empty(t, explanation.PredictedStatus)
+	assert.Contains(t, explanation.Summary, "couldn't be simulated")
+}
+
+func TestExplainUnknownHashSuggestsOtherNetwork(t *testing.T) {
+	f := newExplainFixture(t)
+	_, err := f.explainer().Explain(context.Background(), common.HexToHash("0x01"))
+
+	var notFound *TxNotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.ErrorIs(t, err, ethereum.NotFound)
+	assert.Equal(t, "Kaia Mainnet", notFound.Network)
+	assert.Equal(t, "Kairos testnet", notFound.OtherNetwork)
+}
+
+func TestChatExplainsPastedTransactionHash(t *testing.T) {
+	f := newExplainFixture(t)
+	hash := f.mine(&explainFriend, units(15, 17), nil, types.ReceiptStatusSuccessful)
+	engine := newTestChatEngine(t)
+	engine.SetTxExplainer(f.explainer())
+
+	// The hash wins over the "swap" keyword and isn't mistaken for an address
+	intent, err := engine.parseIntent("why did my swap " + hash.Hex() + " fail?")
+	require.NoError(t, err)
+	assert.Equal(t, "tx_explain", intent.Intent)
+	assert.Equal(t, []string{strings.ToLower(hash.Hex())}, intent.Entities["tx_hashes"])
+	assert.NotContains(t, intent.Entities, "addresses")
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", Message: "what is " + hash.Hex() + "?"})
+	require.NoError(t, err)
+	assert.True(t, response.Success)
+	assert.Equal(t, "tx_explain", response.Type)
+	assert.Contains(t, response.Response, "sent 1.5 KAIA ($0.30) to "+shortAddress(explainFriend.Hex())+".")
+
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m2", Message: common.HexToHash("0x02").Hex()})
+	require.NoError(t, err)
+	assert.False(t, response.Success)
+	assert.Contains(t, response.Response, "It may have been sent on Kairos testnet instead")
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// IndexedTransaction is a transaction recorded in the history index. Receipt
+// fields are only set once the receipt has been fetched.
+type IndexedTransaction struct {
+	Hash              string    `json:"hash"`
+	BlockNumber       uint64    `json:"block_number"`
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	Timestamp         time.Time `json:"timestamp"`
+	Value             *big.Int  `json:"value,omitempty"`
+	GasUsed           uint64    `json:"gas_used,omitempty"`
+	EffectiveGasPrice *big.Int  `json:"effective_gas_price,omitempty"`
+
+	// Contract creations have no To; ContractAddress is the deployed address
+	ContractCreation bool   `json:"contract_creation,omitempty"`
+	ContractAddress  string `json:"contract_address,omitempty"`
+
+	// Bridge is set when the transaction moved assets across a bridge
+	Bridge *BridgeTransfer `json:"bridge,omitempty"`
+}
+
+// HasReceipt reports whether the receipt fields have been filled in
+func (tx IndexedTransaction) HasReceipt() bool {
+	return tx.EffectiveGasPrice != nil
+}
+
+// Fee returns gasUsed × effectiveGasPrice in wei, or nil without a receipt
+func (tx IndexedTransaction) Fee() *big.Int {
+	if !tx.HasReceipt() {
+		return nil
+	}
+	return new(big.Int).Mul(new(big.Int).SetUint64(tx.GasUsed), tx.EffectiveGasPrice)
+}
+
+// IndexedRange is a contiguous block range whose transactions for an address
+// have all been indexed with receipts
+type IndexedRange struct {
+	FromBlock uint64    `json:"from_block"`
+	ToBlock   uint64    `json:"to_block"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+// TransactionIndex is an in-memory per-address transaction history. Addresses
+// are only reported as backfilled once their coverage reaches back to the
+// start of the window a backfill was asked for, or to the genesis block;
+// until then counts and first-seen dates only reflect what has been indexed.
+type TransactionIndex struct {
+	mu         sync.RWMutex
+	byHash     map[string]IndexedTransaction
+	byAddress  map[string][]string
+	backfilled map[string]bool
+	coverage   map[string]IndexedRange
+}
+
+// NewTransactionIndex creates an empty transaction index
+func NewTransactionIndex() *TransactionIndex {
+	return &TransactionIndex{
+		byHash:     make(map[string]IndexedTransaction),
+		byAddress:  make(map[string][]string),
+		backfilled: make(map[string]bool),
+		coverage:   make(map[string]IndexedRange),
+	}
+}
+
+// Add records a transaction against both its sender and recipient, or the
+// deployed contract for contract creations, and against the recipient of a
+// bridge transfer it delivered. Adding a transaction that is
+// already indexed replaces it, so receipts can be filled in later.
+func (ti *TransactionIndex) Add(tx IndexedTransaction) {
+	tx.From = strings.ToLower(tx.From)
+	tx.To = strings.ToLower(tx.To)
+	tx.ContractAddress = strings.ToLower(tx.ContractAddress)
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	if _, exists := ti.byHash[tx.Hash]; !exists {
+		ti.byAddress[tx.From] = append(ti.byAddress[tx.From], tx.Hash)
+		if tx.To != "" && tx.To != tx.From {
+			ti.byAddress[tx.To] = append(ti.byAddress[tx.To], tx.Hash)
+		}
+		if tx.ContractAddress != "" && tx.ContractAddress != tx.From {
+			ti.byAddress[tx.ContractAddress] = append(ti.byAddress[tx.ContractAddress], tx.Hash)
+		}
+		if tx.Bridge != nil {
+			recipient := strings.ToLower(tx.Bridge.Recipient)
+			if recipient != tx.From && recipient != tx.To {
+				ti.byAddress[recipient] = append(ti.byAddress[recipient], tx.Hash)
+			}
+		}
+	}
+	ti.byHash[tx.Hash] = tx
+}
+
+// MarkBackfilled records that the full history of the address has been indexed
+func (ti *TransactionIndex) MarkBackfilled(address common.Address) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	ti.backfilled[strings.ToLower(address.Hex())] = true
+}
+
+// MarkIndexed records that every transaction of the address within the range
+// has been indexed. A range that overlaps or adjoins the existing coverage
+// extends it; a disjoint one replaces it, since the gap between them is
+// unknown, and the address is no longer backfilled. Coverage reaching back to
+// since, the start of the requested window, or to the genesis block marks the
+// address backfilled.
+func (ti *TransactionIndex) MarkIndexed(address common.Address, indexed IndexedRange, since time.Time) {
+	key := strings.ToLower(address.Hex())
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	current, ok := ti.coverage[key]
+	if !ok {
+		current = indexed
+	} else if indexed.FromBlock > current.ToBlock+1 || indexed.ToBlock+1 < current.FromBlock {
+		delete(ti.backfilled, key)
+		current = indexed
+	}
+	if indexed.FromBlock < current.FromBlock {
+		current.FromBlock, current.From = indexed.FromBlock, indexed.From
+	}
+	if indexed.ToBlock > current.ToBlock {
+		current.ToBlock, current.To = indexed.ToBlock, indexed.To
+	}
+	ti.coverage[key] = current
+
+	if current.FromBlock == 0 || (!since.IsZero() && !current.From.After(since)) {
+		ti.backfilled[key] = true
+	}
+}
+
+// Coverage returns the indexed block range of the address
+func (ti *TransactionIndex) Coverage(address common.Address) (IndexedRange, bool) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	indexed, ok := ti.coverage[strings.ToLower(address.Hex())]
+	return indexed, ok
+}
+
+// Indexed reports whether any of the address's history has been indexed,
+// either in full or over a block range
+func (ti *TransactionIndex) Indexed(address common.Address) bool {
+	key := strings.ToLower(address.Hex())
+
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	_, covered := ti.coverage[key]
+	return ti.backfilled[key] || covered
+}
+
+// Transactions returns the indexed transactions of the address with
+// timestamps in [since, until], oldest first
+func (ti *TransactionIndex) Transactions(address common.Address, since, until time.Time) []IndexedTransaction {
+	key := strings.ToLower(address.Hex())
+
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	txs := make([]IndexedTransaction, 0)
+	for _, hash := range ti.byAddress[key] {
+		tx := ti.byHash[hash]
+		if !tx.Timestamp.Before(since) && !tx.Timestamp.After(until) {
+			txs = append(txs, tx)
+		}
+	}
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Timestamp.Before(txs[j].Timestamp) })
+	return txs
+}
+
+// AddressHistory returns the activity of the address since the given time
+func (ti *TransactionIndex) AddressHistory(ctx context.Context, address common.Address, since time.Time) (*AddressHistory, error) {
+	key := strings.ToLower(address.Hex())
+
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	history := &AddressHistory{
+		Counterparties: make(map[string]int),
+		Backfilled:     ti.backfilled[key],
+	}
+	for _, hash := range ti.byAddress[key] {
+		tx := ti.byHash[hash]
+		if history.FirstSeen.IsZero() || tx.Timestamp.Before(history.FirstSeen) {
+			history.FirstSeen = tx.Timestamp
+		}
+		if tx.Timestamp.Before(since) {
+			continue
+		}
+		history.TxCount++
+
+		counterparty := tx.To
+		if counterparty == "" {
+			counterparty = tx.ContractAddress
+		}
+		if counterparty == key {
+			counterparty = tx.From
+		}
+		if counterparty != "" && counterparty != key {
+			history.Counterparties[counterparty]++
+		}
+	}
+
+	return history, nil
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage kinds counted per address
+const (
+	UsageRequests       = "requests"
+	UsageChatMessages   = "chat_messages"
+	UsageAnalyticsTasks = "analytics_tasks"
+	UsageActions        = "actions"
+)
+
+const (
+	// UsageRetention is how long daily rollups are kept
+	UsageRetention = 90 * 24 * time.Hour
+	usageDayLayout = "2006-01-02"
+)
+
+// UsageCounts are the activity totals of an address
+type UsageCounts struct {
+	Requests       int64 `json:"requests"`
+	ChatMessages   int64 `json:"chat_messages"`
+	AnalyticsTasks int64 `json:"analytics_tasks"`
+	Actions        int64 `json:"actions"`
+	Errors         int64 `json:"errors"`
+}
+
+func (uc *UsageCounts) add(other UsageCounts) {
+	uc.Requests += other.Requests
+	uc.ChatMessages += other.ChatMessages
+	uc.AnalyticsTasks += other.AnalyticsTasks
+	uc.Actions += other.Actions
+	uc.Errors += other.Errors
+}
+
+// count returns the total of a usage kind
+func (uc UsageCounts) count(kind string) int64 {
+	switch kind {
+	case UsageChatMessages:
+		return uc.ChatMessages
+	case UsageAnalyticsTasks:
+		return uc.AnalyticsTasks
+	case UsageActions:
+		return uc.Actions
+	default:
+		return uc.Requests
+	}
+}
+
+// ValidUsageKind reports whether kind names a usage counter
+func ValidUsageKind(kind string) bool {
+	switch kind {
+	case UsageRequests, UsageChatMessages, UsageAnalyticsTasks, UsageActions:
+		return true
+	}
+	return false
+}
+
+// EndpointUsage is the request count and error count of one endpoint
+type EndpointUsage struct {
+	Endpoint string `json:"endpoint"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+}
+
+// DailyUsage is the activity of an address on one UTC day
+type DailyUsage struct {
+	Date string `json:"date"`
+	UsageCounts
+}
+
+// AddressUsage is the activity of an address over a window
+type AddressUsage struct {
+	Address string `json:"address"`
+	UsageCounts
+}
+
+// AddressUsageDetail breaks the activity of an address down by endpoint and day
+type AddressUsageDetail struct {
+	Address string `json:"address"`
+	UsageCounts
+	ErrorRate float64         `json:"error_rate"`
+	Endpoints []EndpointUsage `json:"endpoints"`
+	Days      []DailyUsage    `json:"days"`
+}
+
+// usageKey identifies the bucket of an address for a UTC day
+type usageKey struct {
+	address string
+	day     string
+}
+
+// usageBucket is the activity of an address on one day
+type usageBucket struct {
+	counts    UsageCounts
+	endpoints map[string]*EndpointUsage
+}
+
+func newUsageBucket() *usageBucket {
+	return &usageBucket{endpoints: make(map[string]*EndpointUsage)}
+}
+
+func (b *usageBucket) merge(other *usageBucket) {
+	b.counts.add(other.counts)
+	for endpoint, usage := range other.endpoints {
+		current, ok := b.endpoints[endpoint]
+		if !ok {
+			current = &EndpointUsage{Endpoint: endpoint}
+			b.endpoints[endpoint] = current
+		}
+		current.Requests += usage.Requests
+		current.Errors += usage.Errors
+	}
+}
+
+// UsageTracker counts activity per address in daily buckets. Counters for
+// the current day are incremented as requests come in; the nightly rollup
+// folds finished days into the rollup table and drops rollups older than
+// UsageRetention. Both live in memory.
+type UsageTracker struct {
+	mu      sync.RWMutex
+	buckets map[usageKey]*usageBucket
+	rollups map[usageKey]*usageBucket
+	logger  *log.Logger
+	now     func() time.Time
+}
+
+// NewUsageTracker creates an empty usage tracker
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{
+		buckets: make(map[usageKey]*usageBucket),
+		rollups: make(map[usageKey]*usageBucket),
+		logger:  log.New(log.Writer(), "[UsageTracker] ", log.LstdFlags),
+		now:     utcNow,
+	}
+}
+
+// bucket returns today's bucket of the address; the caller holds the lock
+func (ut *UsageTracker) bucket(address string) *usageBucket {
+	key := usageKey{address: strings.ToLower(address), day: ut.now().UTC().Format(usageDayLayout)}
+	b, ok := ut.buckets[key]
+	if !ok {
+		b = newUsageBucket()
+		ut.buckets[key] = b
+	}
+	return b
+}
+
+// RecordRequest counts an API request by the address to an endpoint
+func (ut *UsageTracker) RecordRequest(address, endpoint string, failed bool) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	b := ut.bucket(address)
+	usage, ok := b.endpoints[endpoint]
+	if !ok {
+		usage = &EndpointUsage{Endpoint: endpoint}
+		b.endpoints[endpoint] = usage
+	}
+	b.counts.Requests++
+	usage.Requests++
+	if failed {
+		b.counts.Errors++
+		usage.Errors++
+	}
+}
+
+// Record counts a chat message, analytics task, or action by the address
+func (ut *UsageTracker) Record(address, kind string) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	b := ut.bucket(address)
+	switch kind {
+	case UsageChatMessages:
+		b.counts.ChatMessages++
+	case UsageAnalyticsTasks:
+		b.counts.AnalyticsTasks++
+	case UsageActions:
+		b.counts.Actions++
+	}
+}
+
+// Start runs the rollup shortly after every UTC midnight until ctx is cancelled
+func (ut *UsageTracker) Start(ctx context.Context) {
+	go func() {
+		for {
+			now := ut.now().UTC()
+			next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 5, 0, 0, time.UTC)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(next.Sub(now)):
+			}
+			ut.RollUp()
+		}
+	}()
+}
+
+// RollUp folds the buckets of finished days into the daily rollups and drops
+// rollups past the retention period. It returns the number of buckets rolled up.
+func (ut *UsageTracker) RollUp() int {
+	now := ut.now().UTC()
+	today := now.Format(usageDayLayout)
+	oldest := now.Add(-UsageRetention).Format(usageDayLayout)
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	rolled := 0
+	for key, b := range ut.buckets {
+		if key.day >= today {
+			continue
+		}
+		rollup, ok := ut.rollups[key]
+		if !ok {
+			rollup = newUsageBucket()
+			ut.rollups[key] = rollup
+		}
+		rollup.merge(b)
+		delete(ut.buckets, key)
+		rolled++
+	}
+
+	expired := 0
+	for key := range ut.rollups {
+		if key.day < oldest {
+			delete(ut.rollups, key)
+			expired++
+		}
+	}
+
+	if rolled > 0 || expired > 0 {
+		ut.logger.Printf("Rolled up %d daily buckets, dropped %d expired rollups", rolled, expired)
+	}
+	return rolled
+}
+
+// inWindow collects the buckets of days from since until today, rolled up or
+// not, by key; the caller holds the lock
+func (ut *UsageTracker) inWindow(since time.Time, match func(address string) bool) map[usageKey]*usageBucket {
+	first := since.UTC().Format(usageDayLayout)
+	merged := make(map[usageKey]*usageBucket)
+	for _, source := range []map[usageKey]*usageBucket{ut.rollups, ut.buckets} {
+		for key, b := range source {
+			if key.day < first || !match(key.address) {
+				continue
+			}
+			current, ok := merged[key]
+			if !ok {
+				current = newUsageBucket()
+				merged[key] = current
+			}
+			current.merge(b)
+		}
+	}
+	return merged
+}
+
+// Usage returns the totals of every address active since the given time,
+// busiest first by the given usage kind
+func (ut *UsageTracker) Usage(since time.Time, sortBy string) []AddressUsage {
+	ut.mu.RLock()
+	buckets := ut.inWindow(since, func(string) bool { return true })
+	ut.mu.RUnlock()
+
+	totals := make(map[string]*AddressUsage)
+	for key, b := range buckets {
+		usage, ok := totals[key.address]
+		if !ok {
+			usage = &AddressUsage{Address: key.address}
+			totals[key.address] = usage
+		}
+		usage.add(b.counts)
+	}
+
+	usages := make([]AddressUsage, 0, len(totals))
+	for _, usage := range totals {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		a, b := usages[i].count(sortBy), usages[j].count(sortBy)
+		if a != b {
+			return a > b
+		}
+		return usages[i].Address < usages[j].Address
+	})
+	return usages
+}
+
+// AddressUsage returns the activity of an address since the given time
+func (ut *UsageTracker) AddressUsage(address string, since time.Time) *AddressUsageDetail {
+	address = strings.ToLower(address)
+
+	ut.mu.RLock()
+	buckets := ut.inWindow(since, func(candidate string) bool { return candidate == address })
+	ut.mu.RUnlock()
+
+	detail := &AddressUsageDetail{
+		Address:   address,
+		Endpoints: make([]EndpointUsage, 0),
+		Days:      make([]DailyUsage, 0, len(buckets)),
+	}
+	total := newUsageBucket()
+	for key, b := range buckets {
+		total.merge(b)
+		detail.Days = append(detail.Days, DailyUsage{Date: key.day, UsageCounts: b.counts})
+	}
+	detail.UsageCounts = total.counts
+	if detail.Requests > 0 {
+		detail.ErrorRate = float64(detail.Errors) / float64(detail.Requests)
+	}
+
+	for _, usage := range total.endpoints {
+		detail.Endpoints = append(detail.Endpoints, *usage)
+	}
+	sort.Slice(detail.Endpoints, func(i, j int) bool {
+		if detail.Endpoints[i].Requests != detail.Endpoints[j].Requests {
+			return detail.Endpoints[i].Requests > detail.Endpoints[j].Requests
+		}
+		return detail.Endpoints[i].Endpoint < detail.Endpoints[j].Endpoint
+	})
+	sort.Slice(detail.Days, func(i, j int) bool { return detail.Days[i].Date < detail.Days[j].Date })
+	return detail
+}
+
+// UserData returns all the recorded activity of the address
+func (ut *UsageTracker) UserData(userID string) interface{} {
+	return ut.AddressUsage(userID, time.Time{})
+}
+
+// EraseUserData deletes the address's daily buckets and rollups and returns
+// how many were removed
+func (ut *UsageTracker) EraseUserData(userID string) int {
+	userID = strings.ToLower(userID)
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	removed := 0
+	for _, source := range []map[usageKey]*usageBucket{ut.buckets, ut.rollups} {
+		for key := range source {
+			if key.address == userID {
+				delete(source, key)
+				removed++
+			}
+		}
+	}
+	return removed
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	usageAlice = "0x00000000000000000000000000000000000000a1"
+	usageBob   = "0x00000000000000000000000000000000000000b2"
+)
+
+func newTestUsageTracker(now *time.Time) *UsageTracker {
+	tracker := NewUsageTracker()
+	tracker.now = func() time.Time { return *now }
+	return tracker
+}
+
+func TestUsageTrackerBucketsByUTCDay(t *testing.T) {
+	now := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+	tracker := newTestUsageTracker(&now)
+
+	tracker.RecordRequest(usageAlice, "GET /api/v1/network/stats", false)
+	tracker.Record(usageAlice, UsageChatMessages)
+
+	now = now.Add(2 * time.Minute)
+	tracker.RecordRequest("0x00000000000000000000000000000000000000A1", "POST /api/v1/analytics/yield", true)
+	tracker.Record(usageAlice, UsageAnalyticsTasks)
+	tracker.RecordRequest(usageAlice, "GET /api/v1/network/stats", false)
+
+	detail := tracker.AddressUsage(usageAlice, now.Add(-7*24*time.Hour))
+	assert.Equal(t, UsageCounts{Requests: 3, ChatMessages: 1, AnalyticsTasks: 1, Errors: 1}, detail.UsageCounts)
+	assert.InDelta(t, 1.0/3, detail.ErrorRate, 1e-9)
+	assert.Equal(t, []EndpointUsage{
+		{Endpoint: "GET /api/v1/network/stats", Requests: 2},
+		{Endpoint: "POST /api/v1/analytics/yield", Requests: 1, Errors: 1},
+	}, detail.Endpoints)
+	require.Len(t, detail.Days, 2)
+	assert.Equal(t, DailyUsage{Date: "2024-03-01", UsageCounts: UsageCounts{Requests: 1, ChatMessages: 1}}, detail.Days[0])
+	assert.Equal(t, "2024-03-02", detail.Days[1].Date)
+
+	// The window starts at the day containing since
+	assert.Equal(t, int64(2), tracker.AddressUsage(usageAlice, now).Requests)
+}
+
+func TestUsageTrackerRollUpKeepsTotalsAndRetention(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newTestUsageTracker(&now)
+
+	tracker.RecordRequest(usageBob, "GET /api/v1/network/stats", false)
+	now = now.Add(24 * time.Hour)
+	tracker.RecordRequest(usageBob, "GET /api/v1/network/stats", false)
+	tracker.Record(usageBob, UsageActions)
+	tracker.RecordRequest(usageAlice, "GET /api/v1/network/stats", false)
+
+	// Only finished days are rolled up
+	assert.Equal(t, 1, tracker.RollUp())
+	assert.Len(t, tracker.buckets, 2)
+
+	now = now.Add(24 * time.Hour)
+	before := tracker.Usage(now.Add(-30*24*time.Hour), UsageRequests)
+	assert.Equal(t, 2, tracker.RollUp())
+	assert.Empty(t, tracker.buckets)
+	assert.Equal(t, before, tracker.Usage(now.Add(-30*24*time.Hour), UsageRequests))
+
+	// Rollups past retention are dropped on the next run
+	now = time.Date(2024, 4, 1, 0, 5, 0, 0, time.UTC)
+	tracker.RollUp()
+	require.Len(t, tracker.rollups, 2)
+	for key := range tracker.rollups {
+		assert.Equal(t, "2024-01-02", key.day)
+	}
+}
+
+func TestUsageSortsByKind(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newTestUsageTracker(&now)
+
+	for i := 0; i < 3; i++ {
+		tracker.RecordRequest(usageAlice, "GET /api/v1/network/stats", false)
+	}
+	tracker.RecordRequest(usageBob, "POST /api/v1/chat/message", false)
+	tracker.Record(usageBob, UsageChatMessages)
+	tracker.Record(usageBob, UsageChatMessages)
+
+	since := now.Add(-24 * time.Hour)
+	byRequests := tracker.Usage(since, UsageRequests)
+	require.Len(t, byRequests, 2)
+	assert.Equal(t, usageAlice, byRequests[0].Address)
+
+	byChat := tracker.Usage(since, UsageChatMessages)
+	assert.Equal(t, AddressUsage{Address: usageBob, UsageCounts: UsageCounts{Requests: 1, ChatMessages: 2}}, byChat[0])
+}
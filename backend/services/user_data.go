@@ -0,0 +1,382 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/panjf2000/ants/v2"
+)
+
+const (
+	// UserExportTTL is how long a finished export can be downloaded
+	UserExportTTL = 24 * time.Hour
+	// DefaultUserExportMaxBytes caps the size of one user's export
+	DefaultUserExportMaxBytes = 10 << 20
+	// UserErasureTokenTTL is how long an erasure confirmation token is valid
+	UserErasureTokenTTL = 10 * time.Minute
+
+	userExportWorkers = 2
+)
+
+// Export statuses
+const (
+	UserExportPending = "pending"
+	UserExportRunning = "running"
+	UserExportReady   = "ready"
+	UserExportFailed  = "failed"
+)
+
+var (
+	// ErrUserExportNotFound is returned for unknown or expired exports
+	ErrUserExportNotFound = errors.New("export not found")
+	// ErrUserExportNotReady is returned when downloading an unfinished export
+	ErrUserExportNotReady = errors.New("export is not ready")
+	// ErrUserExportLinkInvalid is returned for download links with a bad
+	// signature or past their expiry
+	ErrUserExportLinkInvalid = errors.New("download link is invalid or expired")
+	// ErrErasureTokenInvalid is returned when erasing with a token that wasn't
+	// issued to the user or has expired
+	ErrErasureTokenInvalid = errors.New("erasure confirmation token is invalid or expired")
+	// ErrErasureSignature is returned when the erasure message wasn't signed
+	// by the user's wallet
+	ErrErasureSignature = errors.New("erasure signature is invalid")
+)
+
+// UserErasureMessage is the text the user's wallet signs, with personal_sign,
+// to confirm erasing their data with the token
+func UserErasureMessage(userID, token string) string {
+	return fmt.Sprintf("Erase all data Kaia Analytics keeps about %s.\nConfirmation: %s",
+		common.HexToAddress(userID).Hex(), token)
+}
+
+// UserDataStore is a store holding data keyed to a user's address
+type UserDataStore interface {
+	// UserData returns what the store keeps about the user, ready to be
+	// encoded as JSON
+	UserData(userID string) interface{}
+	// EraseUserData deletes what the store keeps about the user and returns
+	// how many records were removed
+	EraseUserData(userID string) int
+}
+
+// UserExport is an export job of a user's data
+type UserExport struct {
+	ID          string  `json:"id"`
+	UserID      string  `json:"user_id"`
+	Status      string  `json:"status"`
+	SizeBytes   int     `json:"size_bytes,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	CreatedAt   APITime `json:"created_at"`
+	CompletedAt APITime `json:"completed_at"`
+	ExpiresAt   APITime `json:"expires_at"`
+
+	document []byte
+}
+
+// Active reports whether the export is still being assembled
+func (e *UserExport) Active() bool {
+	return e.Status == UserExportPending || e.Status == UserExportRunning
+}
+
+// UserExportDocument is the downloaded export: every store's data about the
+// user, by store name
+type UserExportDocument struct {
+	UserID      string                 `json:"user_id"`
+	GeneratedAt APITime                `json:"generated_at"`
+	Data        map[string]interface{} `json:"data"`
+}
+
+// UserErasure records the erasure of a user's data. Erasures are kept as the
+// audit trail of the requests.
+type UserErasure struct {
+	UserID   string         `json:"user_id"`
+	ErasedAt APITime        `json:"erased_at"`
+	Removed  map[string]int `json:"removed"`
+}
+
+// erasureToken is a pending erasure confirmation
+type erasureToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// UserDataService exports and erases everything the registered stores keep
+// about a user. Exports are assembled in a worker pool, kept in memory for
+// UserExportTTL, and downloaded through links signed with a key generated at
+// startup. Erasure takes a confirmation token issued shortly before, signed
+// by the user's wallet.
+type UserDataService struct {
+	maxBytes int
+	pool     *ants.Pool
+	key      []byte
+	logger   *log.Logger
+	now      func() time.Time
+
+	mu       sync.Mutex
+	names    []string
+	stores   map[string]UserDataStore
+	exports  map[string]*UserExport
+	tokens   map[string]erasureToken
+	erasures []UserErasure
+}
+
+// NewUserDataService creates a service whose exports may be at most maxBytes
+func NewUserDataService(maxBytes int) (*UserDataService, error) {
+	pool, err := ants.NewPool(userExportWorkers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export worker pool: %w", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate export signing key: %w", err)
+	}
+
+	return &UserDataService{
+		maxBytes: maxBytes,
+		pool:     pool,
+		key:      key,
+		logger:   log.New(log.Writer(), "[UserData] ", log.LstdFlags),
+		now:      utcNow,
+		stores:   make(map[string]UserDataStore),
+		exports:  make(map[string]*UserExport),
+		tokens:   make(map[string]erasureToken),
+	}, nil
+}
+
+// Close releases the worker pool
+func (us *UserDataService) Close() {
+	us.pool.Release()
+}
+
+// Register adds a store to exports and erasures under the name
+func (us *UserDataService) Register(name string, store UserDataStore) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, ok := us.stores[name]; !ok {
+		us.names = append(us.names, name)
+	}
+	us.stores[name] = store
+}
+
+// RequestExport starts assembling the user's data, or returns the export
+// already underway. It waits for a free worker when every worker is busy.
+func (us *UserDataService) RequestExport(userID string) (*UserExport, error) {
+	userID = strings.ToLower(userID)
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate export ID: %w", err)
+	}
+
+	us.mu.Lock()
+	us.prune()
+	for _, export := range us.exports {
+		if export.UserID == userID && export.Active() {
+			copied := *export
+			us.mu.Unlock()
+			return &copied, nil
+		}
+	}
+	now := us.now()
+	export := &UserExport{
+		ID:        id,
+		UserID:    userID,
+		Status:    UserExportPending,
+		CreatedAt: NewAPITime(now),
+		ExpiresAt: NewAPITime(now.Add(UserExportTTL)),
+	}
+	us.exports[id] = export
+	copied := *export
+	us.mu.Unlock()
+
+	// Submitting without the lock, as workers take it to finish exports
+	if err := us.pool.Submit(func() { us.assemble(export) }); err != nil {
+		us.mu.Lock()
+		delete(us.exports, id)
+		us.mu.Unlock()
+		return nil, fmt.Errorf("failed to submit export: %w", err)
+	}
+	return &copied, nil
+}
+
+// assemble encodes every store's data about the export's user
+func (us *UserDataService) assemble(export *UserExport) {
+	us.mu.Lock()
+	export.Status = UserExportRunning
+	names := append([]string(nil), us.names...)
+	stores := make([]UserDataStore, len(names))
+	for i, name := range names {
+		stores[i] = us.stores[name]
+	}
+	us.mu.Unlock()
+
+	document := UserExportDocument{
+		UserID:      export.UserID,
+		GeneratedAt: NewAPITime(us.now()),
+		Data:        make(map[string]interface{}, len(names)),
+	}
+	for i, name := range names {
+		document.Data[name] = stores[i].UserData(export.UserID)
+	}
+	encoded, err := json.Marshal(document)
+	if err == nil && len(encoded) > us.maxBytes {
+		err = fmt.Errorf("export is %d bytes, over the limit of %d", len(encoded), us.maxBytes)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	export.CompletedAt = NewAPITime(us.now())
+	if err != nil {
+		export.Status = UserExportFailed
+		export.Error = err.Error()
+		us.logger.Printf("Export %s for %s failed: %v", export.ID, export.UserID, err)
+		return
+	}
+	export.Status = UserExportReady
+	export.SizeBytes = len(encoded)
+	export.document = encoded
+}
+
+// Export returns one of the user's exports
+func (us *UserDataService) Export(userID, id string) (*UserExport, bool) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	us.prune()
+	export, ok := us.exports[id]
+	if !ok || export.UserID != strings.ToLower(userID) {
+		return nil, false
+	}
+	copied := *export
+	return &copied, true
+}
+
+// Signature signs a download link of the export valid until expires
+func (us *UserDataService) Signature(id string, expires time.Time) string {
+	mac := hmac.New(sha256.New, us.key)
+	mac.Write([]byte(id + "." + strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Download returns the document of a ready export for a signed link. The
+// link's expiry is a unix timestamp.
+func (us *UserDataService) Download(id string, expires int64, signature string) ([]byte, error) {
+	expected := us.Signature(id, time.Unix(expires, 0))
+	if !hmac.Equal([]byte(expected), []byte(signature)) || us.now().Unix() > expires {
+		return nil, ErrUserExportLinkInvalid
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	us.prune()
+	export, ok := us.exports[id]
+	if !ok {
+		return nil, ErrUserExportNotFound
+	}
+	if export.Status != UserExportReady {
+		return nil, ErrUserExportNotReady
+	}
+	return export.document, nil
+}
+
+// prune drops expired exports and erasure tokens; the caller holds the lock
+func (us *UserDataService) prune() {
+	now := us.now()
+	for id, export := range us.exports {
+		if !export.Active() && now.After(export.ExpiresAt.Time) {
+			delete(us.exports, id)
+		}
+	}
+	for userID, token := range us.tokens {
+		if now.After(token.expiresAt) {
+			delete(us.tokens, userID)
+		}
+	}
+}
+
+// RequestErasure issues the token that confirms erasing the user's data,
+// replacing any issued before
+func (us *UserDataService) RequestErasure(userID string) (string, time.Time, error) {
+	token, err := randomHex(16)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	expiresAt := us.now().Add(UserErasureTokenTTL)
+	us.tokens[strings.ToLower(userID)] = erasureToken{token: token, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// Erase deletes everything the stores keep about the user, and the user's
+// exports, given the token from RequestErasure and the wallet's signature of
+// its UserErasureMessage. The erasure is recorded.
+func (us *UserDataService) Erase(userID, token, signature string) (*UserErasure, error) {
+	userID = strings.ToLower(userID)
+
+	us.mu.Lock()
+	us.prune()
+	issued, ok := us.tokens[userID]
+	if !ok || !hmac.Equal([]byte(issued.token), []byte(token)) {
+		us.mu.Unlock()
+		return nil, ErrErasureTokenInvalid
+	}
+	signer, err := recoverPersonalSigner(UserErasureMessage(userID, token), signature)
+	if err != nil || !strings.EqualFold(signer.Hex(), userID) {
+		us.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s didn't sign the erasure message", ErrErasureSignature, common.HexToAddress(userID).Hex())
+	}
+	delete(us.tokens, userID)
+	names := append([]string(nil), us.names...)
+	stores := make([]UserDataStore, len(names))
+	for i, name := range names {
+		stores[i] = us.stores[name]
+	}
+	removedExports := 0
+	for id, export := range us.exports {
+		if export.UserID == userID {
+			delete(us.exports, id)
+			removedExports++
+		}
+	}
+	us.mu.Unlock()
+
+	erasure := UserErasure{UserID: userID, Removed: make(map[string]int, len(names)+1)}
+	for i, name := range names {
+		erasure.Removed[name] = stores[i].EraseUserData(userID)
+	}
+	erasure.Removed["exports"] = removedExports
+	erasure.ErasedAt = NewAPITime(us.now())
+
+	us.mu.Lock()
+	us.erasures = append(us.erasures, erasure)
+	us.mu.Unlock()
+	us.logger.Printf("Erased the data of %s: %v", userID, erasure.Removed)
+	return &erasure, nil
+}
+
+// Erasures returns the recorded erasures, newest first
+func (us *UserDataService) Erasures() []UserErasure {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	erasures := make([]UserErasure, 0, len(us.erasures))
+	for i := len(us.erasures) - 1; i >= 0; i-- {
+		erasures = append(erasures, us.erasures[i])
+	}
+	return erasures
+}
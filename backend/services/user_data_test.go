@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userDataStores are one of each store keyed to a user
+type userDataStores struct {
+	preferences   *PreferenceStore
+	notifications *NotificationStore
+	audit         *ActionAuditLog
+	reports       *ReportService
+	portfolios    *PortfolioTracker
+	webhooks      *WebhookDispatcher
+	usage         *UsageTracker
+}
+
+func newUserDataStores(t *testing.T, clock *time.Time) userDataStores {
+	reports, notifications := newTestReportService(t, &frozenDigestSources{valueUSD: 1200, gasWei: 25e9}, clock, 1)
+	webhooks := NewWebhookDispatcher(1)
+	webhooks.lookupIP = publicLookup
+	return userDataStores{
+		preferences:   NewPreferenceStore(),
+		notifications: notifications,
+		audit:         NewActionAuditLog(),
+		reports:       reports,
+		portfolios:    NewPortfolioTracker(fakeNativeBalances{balance: big.NewInt(5e18)}, fakeTokenBalances{}, fakePrices{NativeSymbol: 0.2}, nil),
+		webhooks:      webhooks,
+		usage:         NewUsageTracker(),
+	}
+}
+
+// fill stores data about the user in every store
+func (s userDataStores) fill(t *testing.T, user string) {
+	ctx := context.Background()
+	preferences := DefaultUserPreferences()
+	preferences.FavoriteTokens = []string{"KAIA"}
+	_, err := s.preferences.Update(user, preferences)
+	require.NoError(t, err)
+	_, err = s.reports.UpdateSettings(user, ReportSettings{Enabled: true, Time: "08:00"})
+	require.NoError(t, err)
+	_, err = s.reports.Generate(ctx, user)
+	require.NoError(t, err)
+	s.audit.Append(ActionAuditRecord{ActionID: "a1", UserID: user, Event: ActionEventProposed, ActionType: "swap"})
+	_, err = s.portfolios.Enroll(ctx, common.HexToAddress(user))
+	require.NoError(t, err)
+	_, err = s.webhooks.RegisterWebhook(user, "https://hooks.example/"+user, []string{"*"})
+	require.NoError(t, err)
+	s.usage.RecordRequest(user, "GET /api/v1/analytics/yield", false)
+}
+
+func (s userDataStores) register(service *UserDataService) {
+	service.Register("preferences", s.preferences)
+	service.Register("notifications", s.notifications)
+	service.Register("action_audit", s.audit)
+	service.Register("reports", s.reports)
+	service.Register("portfolio", s.portfolios)
+	service.Register("webhooks", s.webhooks)
+	service.Register("usage", s.usage)
+}
+
+func TestUserDataExportsAndErasesOneUser(t *testing.T) {
+	clock := time.Date(2025, 3, 2, 8, 0, 0, 0, time.UTC)
+	aliceKey := newWalletKey(t)
+	alice := strings.ToLower(crypto.PubkeyToAddress(aliceKey.PublicKey).Hex())
+	bob := "0x00000000000000000000000000000000000000bb"
+	stores := newUserDataStores(t, &clock)
+	stores.fill(t, alice)
+	stores.fill(t, bob)
+
+	service, err := NewUserDataService(DefaultUserExportMaxBytes)
+	require.NoError(t, err)
+	t.Cleanup(service.Close)
+	service.now = func() time.Time { return clock }
+	stores.register(service)
+
+	export, err := service.RequestExport(crypto.PubkeyToAddress(aliceKey.PublicKey).Hex())
+	require.NoError(t, err)
+	assert.Equal(t, alice, export.UserID)
+	require.Eventually(t, func() bool {
+		export, _ = service.Export(alice, export.ID)
+		return !export.Active()
+	}, time.Second, time.Millisecond)
+	require.Equal(t, UserExportReady, export.Status)
+	_, ok := service.Export(bob, export.ID)
+	assert.False(t, ok, "exports are only visible to their user")
+
+	// Downloads need a valid signature that hasn't expired
+	expires := export.ExpiresAt.Time
+	_, err = service.Download(export.ID, expires.Unix(), service.Signature(export.ID, expires.Add(time.Second)))
+	assert.ErrorIs(t, err, ErrUserExportLinkInvalid)
+	document, err := service.Download(export.ID, expires.Unix(), service.Signature(export.ID, expires))
+	require.NoError(t, err)
+	assert.Equal(t, export.SizeBytes, len(document))
+
+	var exported struct {
+		UserID string                     `json:"user_id"`
+		Data   map[string]json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(document, &exported))
+	assert.Equal(t, alice, exported.UserID)
+	assert.Len(t, exported.Data, 7)
+	for name, data := range exported.Data {
+		assert.NotContains(t, string(data), bob[2:], "%s leaks another user's data", name)
+		assert.NotEqual(t, "null", string(data), "%s is missing", name)
+	}
+	assert.Contains(t, string(exported.Data["preferences"]), `"favorite_tokens":["KAIA"]`)
+	assert.Contains(t, string(exported.Data["action_audit"]), `"action_id":"a1"`)
+	assert.Contains(t, string(exported.Data["webhooks"]), "https://hooks.example/"+alice)
+	assert.NotContains(t, string(exported.Data["webhooks"]), `"secret"`)
+
+	// Erasure needs the token issued to the user, signed by their wallet
+	token, _, err := service.RequestErasure(alice)
+	require.NoError(t, err)
+	signature, err := crypto.Sign(accounts.TextHash([]byte(UserErasureMessage(alice, token))), aliceKey)
+	require.NoError(t, err)
+	_, err = service.Erase(bob, token, hexutil.Encode(signature))
+	assert.ErrorIs(t, err, ErrErasureTokenInvalid)
+	forged, err := crypto.Sign(accounts.TextHash([]byte(UserErasureMessage(alice, token))), newWalletKey(t))
+	require.NoError(t, err)
+	_, err = service.Erase(alice, token, hexutil.Encode(forged))
+	assert.ErrorIs(t, err, ErrErasureSignature)
+	_, err = service.Erase(alice, token, "")
+	assert.ErrorIs(t, err, ErrErasureSignature)
+	erasure, err := service.Erase(alice, token, hexutil.Encode(signature))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"preferences": 1, "notifications": 1, "action_audit": 1, "reports": 2,
+		"portfolio": 2, "webhooks": 1, "usage": 1, "exports": 1,
+	}, erasure.Removed)
+	_, err = service.Erase(alice, token, hexutil.Encode(signature))
+	assert.ErrorIs(t, err, ErrErasureTokenInvalid, "tokens are single use")
+	assert.Equal(t, []UserErasure{*erasure}, service.Erasures())
+
+	assert.Nil(t, stores.preferences.UserData(alice))
+	assert.Empty(t, stores.notifications.List(alice, false))
+	assert.Empty(t, stores.audit.Records(alice, time.Time{}, time.Time{}))
+	assert.Equal(t, reportUserData{Reports: []Report{}}, stores.reports.UserData(alice))
+	assert.Equal(t, portfolioUserData{Snapshots: []PortfolioSnapshot{}}, stores.portfolios.UserData(alice))
+	assert.Empty(t, stores.webhooks.ListWebhooks(alice))
+	assert.Zero(t, stores.usage.AddressUsage(alice, time.Time{}).Requests)
+	_, err = service.Download(export.ID, expires.Unix(), service.Signature(export.ID, expires))
+	assert.ErrorIs(t, err, ErrUserExportNotFound)
+
+	// The other user's data is untouched
+	assert.NotNil(t, stores.preferences.UserData(bob))
+	assert.Len(t, stores.notifications.List(bob, false), 1)
+	assert.Len(t, stores.audit.Records(bob, time.Time{}, time.Time{}), 1)
+	assert.Len(t, stores.reports.UserData(bob).(reportUserData).Reports, 1)
+	assert.True(t, stores.portfolios.Enrolled(common.HexToAddress(bob)))
+	assert.Len(t, stores.webhooks.ListWebhooks(bob), 1)
+	assert.Equal(t, int64(1), stores.usage.AddressUsage(bob, time.Time{}).Requests)
+}
+
+func TestUserDataExportsFailOverTheSizeLimit(t *testing.T) {
+	clock := time.Date(2025, 3, 2, 8, 0, 0, 0, time.UTC)
+	stores := newUserDataStores(t, &clock)
+	stores.fill(t, reportUser)
+
+	service, err := NewUserDataService(1024)
+	require.NoError(t, err)
+	t.Cleanup(service.Close)
+	stores.register(service)
+
+	export, err := service.RequestExport(reportUser)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		export, _ = service.Export(reportUser, export.ID)
+		return !export.Active()
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, UserExportFailed, export.Status)
+	assert.Contains(t, export.Error, "over the limit of 1024")
+	_, err = service.Download(export.ID, export.ExpiresAt.Unix(), service.Signature(export.ID, export.ExpiresAt.Time))
+	assert.ErrorIs(t, err, ErrUserExportNotReady)
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Delegation statuses of an account's voting power
+const (
+	DelegationSelf        = "self_delegated"
+	DelegationDelegated   = "delegated"
+	DelegationNone        = "not_delegated"
+	DelegationUnsupported = "delegation_unsupported"
+)
+
+// defaultTokenDecimals is assumed for tokens that don't expose decimals()
+const defaultTokenDecimals = 18
+
+// Function selectors of the ERC20Votes reads
+var (
+	votesDelegatesSelector    = crypto.Keccak256([]byte("delegates(address)"))[:4]
+	votesGetVotesSelector     = crypto.Keccak256([]byte("getVotes(address)"))[:4]
+	votesGetPastVotesSelector = crypto.Keccak256([]byte("getPastVotes(address,uint256)"))[:4]
+	erc20DecimalsSelector     = crypto.Keccak256([]byte("decimals()"))[:4]
+)
+
+// VotingPower is an account's voting power in the governance token and where
+// its own votes are delegated
+type VotingPower struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	Status  string `json:"status"`
+	// Delegate is the account the address's votes are delegated to
+	Delegate string `json:"delegate,omitempty"`
+	// Votes is the current voting power in the token's smallest unit
+	Votes       string  `json:"votes,omitempty"`
+	VotingPower float64 `json:"voting_power"`
+	// Past votes at a proposal's snapshot block, when asked for one
+	ProposalID       string  `json:"proposal_id,omitempty"`
+	SnapshotBlock    uint64  `json:"snapshot_block,omitempty"`
+	PastVotes        string  `json:"past_votes,omitempty"`
+	PastVotingPower  float64 `json:"past_voting_power,omitempty"`
+	SnapshotNotFinal bool    `json:"snapshot_not_final,omitempty"`
+}
+
+// VotingPowerReader reads voting power and delegation from an ERC20Votes
+// style governance token
+type VotingPowerReader struct {
+	caller ethereum.ContractCaller
+	token  common.Address
+}
+
+// NewVotingPowerReader creates a reader for the governance token
+func NewVotingPowerReader(caller ethereum.ContractCaller, token common.Address) *VotingPowerReader {
+	return &VotingPowerReader{caller: caller, token: token}
+}
+
+// VotingPower returns the account's current voting power and delegation. With
+// a snapshot block it also reads the votes the account had at that block. A
+// token without the Votes extension is reported as DelegationUnsupported.
+func (r *VotingPowerReader) VotingPower(ctx context.Context, account common.Address, snapshotBlock uint64) (*VotingPower, error) {
+	power := &VotingPower{
+		Address: account.Hex(),
+		Token:   r.token.Hex(),
+	}
+
+	delegate, supported, err := r.call(ctx, votesDelegatesSelector, common.LeftPadBytes(account.Bytes(), 32))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delegate: %w", err)
+	}
+	if !supported {
+		power.Status = DelegationUnsupported
+		return power, nil
+	}
+	switch delegateAddress := common.BytesToAddress(delegate); delegateAddress {
+	case common.Address{}:
+		power.Status = DelegationNone
+	case account:
+		power.Status = DelegationSelf
+		power.Delegate = delegateAddress.Hex()
+	default:
+		power.Status = DelegationDelegated
+		power.Delegate = delegateAddress.Hex()
+	}
+
+	decimals := r.decimals(ctx)
+	votes, supported, err := r.call(ctx, votesGetVotesSelector, common.LeftPadBytes(account.Bytes(), 32))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read votes: %w", err)
+	}
+	if !supported {
+		power.Status = DelegationUnsupported
+		power.Delegate = ""
+		return power, nil
+	}
+	amount := new(big.Int).SetBytes(votes)
+	power.Votes = amount.String()
+	power.VotingPower = weiToFloat(amount, decimals)
+
+	if snapshotBlock > 0 {
+		power.SnapshotBlock = snapshotBlock
+		args := append(common.LeftPadBytes(account.Bytes(), 32), common.LeftPadBytes(new(big.Int).SetUint64(snapshotBlock).Bytes(), 32)...)
+		pastVotes, ok, err := r.call(ctx, votesGetPastVotesSelector, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read past votes: %w", err)
+		}
+		// getPastVotes reverts for blocks that aren't mined yet
+		if !ok {
+			power.SnapshotNotFinal = true
+			return power, nil
+		}
+		amount := new(big.Int).SetBytes(pastVotes)
+		power.PastVotes = amount.String()
+		power.PastVotingPower = weiToFloat(amount, decimals)
+	}
+
+	return power, nil
+}
+
+// decimals reads the token's decimals, assuming 18 when it can't be read
+func (r *VotingPowerReader) decimals(ctx context.Context) int {
+	result, ok, err := r.call(ctx, erc20DecimalsSelector, nil)
+	if err != nil || !ok {
+		return defaultTokenDecimals
+	}
+	decimals := new(big.Int).SetBytes(result)
+	if !decimals.IsUint64() || decimals.Uint64() > 36 {
+		return defaultTokenDecimals
+	}
+	return int(decimals.Uint64())
+}
+
+// call calls a function of the token and returns its 32 byte result. ok is
+// false when the call reverts or returns nothing, which is how tokens without
+// the function respond.
+func (r *VotingPowerReader) call(ctx context.Context, selector, args []byte) (result []byte, ok bool, err error) {
+	data := append(append([]byte{}, selector...), args...)
+	token := r.token
+	result, err = r.caller.CallContract(ctx, ethereum.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		if isRevert(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if len(result) < 32 {
+		return nil, false, nil
+	}
+	return result[:32], true, nil
+}
+
+// isRevert reports whether a call failed because the contract reverted, as
+// opposed to the node being unreachable
+func isRevert(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "revert")
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	votesToken = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	voter      = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	delegatee  = common.HexToAddress("0x00000000000000000000000000000000000000d1")
+)
+
+// votesToken answers ERC20Votes reads for one account. Without votes it
+// behaves like a plain ERC-20 and reverts them.
+type fakeVotesToken struct {
+	votes     bool
+	delegate  common.Address
+	current   *big.Int
+	past      map[uint64]*big.Int
+	decimals  int64
+	err       error
+	lastBlock uint64
+}
+
+func (f *fakeVotesToken) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	selector, args := call.Data[:4], call.Data[4:]
+	word := func(value *big.Int) []byte { return common.LeftPadBytes(value.Bytes(), 32) }
+
+	switch {
+	case string(selector) == string(erc20DecimalsSelector):
+		return word(big.NewInt(f.decimals)), nil
+	case !f.votes:
+		return nil, errors.New("execution reverted")
+	case string(selector) == string(votesDelegatesSelector):
+		return common.LeftPadBytes(f.delegate.Bytes(), 32), nil
+	case string(selector) == string(votesGetVotesSelector):
+		return word(f.current), nil
+	case string(selector) == string(votesGetPastVotesSelector):
+		block := new(big.Int).SetBytes(args[32:64]).Uint64()
+		f.lastBlock = block
+		past, ok := f.past[block]
+		if !ok {
+			return nil, errors.New("execution reverted: ERC5805FutureLookup")
+		}
+		return word(past), nil
+	}
+	return nil, errors.New("execution reverted")
+}
+
+func (f *fakeVotesToken) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{0x60}, nil
+}
+
+func tokens(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+}
+
+func TestVotingPowerDelegation(t *testing.T) {
+	ctx := context.Background()
+
+	delegated := &fakeVotesToken{votes: true, delegate: delegatee, current: big.NewInt(0), decimals: 18}
+	power, err := NewVotingPowerReader(delegated, votesToken).VotingPower(ctx, voter, 0)
+	require.NoError(t, err)
+	assert.Equal(t, DelegationDelegated, power.Status)
+	assert.Equal(t, delegatee.Hex(), power.Delegate)
+	assert.Equal(t, "0", power.Votes)
+	assert.Zero(t, power.SnapshotBlock)
+
+	self := &fakeVotesToken{votes: true, delegate: voter, current: tokens(1500), past: map[uint64]*big.Int{900: tokens(1200)}, decimals: 18}
+	power, err = NewVotingPowerReader(self, votesToken).VotingPower(ctx, voter, 900)
+	require.NoError(t, err)
+	assert.Equal(t, DelegationSelf, power.Status)
+	assert.Equal(t, voter.Hex(), power.Delegate)
+	assert.Equal(t, tokens(1500).String(), power.Votes)
+	assert.InDelta(t, 1500, power.VotingPower, 1e-9)
+	assert.Equal(t, uint64(900), self.lastBlock)
+	assert.Equal(t, tokens(1200).String(), power.PastVotes)
+	assert.InDelta(t, 1200, power.PastVotingPower, 1e-9)
+	assert.False(t, power.SnapshotNotFinal)
+
+	// A snapshot that isn't mined yet has no past votes
+	power, err = NewVotingPowerReader(self, votesToken).VotingPower(ctx, voter, 5000)
+	require.NoError(t, err)
+	assert.True(t, power.SnapshotNotFinal)
+	assert.Empty(t, power.PastVotes)
+
+	undelegated := &fakeVotesToken{votes: true, current: big.NewInt(0), decimals: 6}
+	power, err = NewVotingPowerReader(undelegated, votesToken).VotingPower(ctx, voter, 0)
+	require.NoError(t, err)
+	assert.Equal(t, DelegationNone, power.Status)
+	assert.Empty(t, power.Delegate)
+
+	plain := &fakeVotesToken{decimals: 18}
+	power, err = NewVotingPowerReader(plain, votesToken).VotingPower(ctx, voter, 900)
+	require.NoError(t, err)
+	assert.Equal(t, DelegationUnsupported, power.Status)
+	assert.Empty(t, power.Votes)
+	assert.Zero(t, power.SnapshotBlock)
+
+	unreachable := &fakeVotesToken{err: errors.New("connection refused")}
+	_, err = NewVotingPowerReader(unreachable, votesToken).VotingPower(ctx, voter, 0)
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestChatGovernanceIncludesVotingPower(t *testing.T) {
+	engine := newTestChatEngine(t)
+	ask := func(userID string) *ChatResponse {
+		response, err := engine.ProcessMessage(context.Background(), &ChatMessage{ID: "m1", UserID: userID, Message: "How is the governance proposal going?"})
+		require.NoError(t, err)
+		return response
+	}
+
+	response := ask(voter.Hex())
+	assert.NotContains(t, response.Response, "Voting Power")
+	assert.IsType(t, []GovernanceSentiment{}, response.Data)
+
+	engine.SetVotingPowerReader(NewVotingPowerReader(&fakeVotesToken{votes: true, delegate: voter, current: tokens(1500), decimals: 18}, votesToken))
+	response = ask(voter.Hex())
+	assert.Contains(t, response.Response, "Your Voting Power**: 1,500 votes, self-delegated.")
+	data := response.Data.(map[string]interface{})
+	assert.Equal(t, DelegationSelf, data["voting_power"].(*VotingPower).Status)
+
+	// Anonymous senders get the sentiment only
+	response = ask("anonymous")
+	assert.NotContains(t, response.Response, "Voting Power")
+
+	engine.SetVotingPowerReader(NewVotingPowerReader(&fakeVotesToken{votes: true, delegate: delegatee, current: big.NewInt(0), decimals: 18}, votesToken))
+	assert.Contains(t, ask(voter.Hex()).Response, "Your own tokens are delegated to "+delegatee.Hex())
+
+	engine.SetVotingPowerReader(NewVotingPowerReader(&fakeVotesToken{decimals: 18}, votesToken))
+	assert.Contains(t, ask(voter.Hex()).Response, "doesn't support delegation")
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// WalletLinkProofTTL is how long a signed link message stays valid
+	WalletLinkProofTTL = 10 * time.Minute
+	// MaxLinkedWallets is how many wallets can be linked to one owner
+	MaxLinkedWallets = 20
+)
+
+var (
+	// ErrWalletLinkSignature is returned when a link message wasn't signed by
+	// the wallet being linked
+	ErrWalletLinkSignature = errors.New("wallet link signature is invalid")
+	// ErrWalletLinkExpired is returned for a link message issued too long ago
+	// or in the future
+	ErrWalletLinkExpired = errors.New("wallet link message has expired")
+	// ErrWalletLinkedElsewhere is returned when a wallet is already linked to
+	// another owner, or has wallets of its own linked
+	ErrWalletLinkedElsewhere = errors.New("wallet is linked to another owner")
+	// ErrTooManyLinkedWallets is returned when linking would pass
+	// MaxLinkedWallets
+	ErrTooManyLinkedWallets = errors.New("too many linked wallets")
+	// ErrWalletNotLinked is returned when unlinking a wallet that isn't linked
+	ErrWalletNotLinked = errors.New("wallet is not linked")
+)
+
+// WalletLinkMessage is the text a wallet signs, with personal_sign, to prove
+// it belongs to the same user as owner
+func WalletLinkMessage(owner, wallet common.Address, issuedAt time.Time) string {
+	return fmt.Sprintf("Link wallet %s to %s on Kaia Analytics.\nIssued at: %s",
+		wallet.Hex(), owner.Hex(), issuedAt.UTC().Format(time.RFC3339))
+}
+
+// WalletLinkProof is a wallet's signature of its WalletLinkMessage
+type WalletLinkProof struct {
+	Address   string    `json:"address"`
+	Signature string    `json:"signature"`
+	IssuedAt  time.Time `json:"issued_at"`
+}
+
+// LinkedWallet is a wallet linked to an owner
+type LinkedWallet struct {
+	Address  string    `json:"address"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// WalletLinks records which wallets belong to the same user, so their
+// portfolios can be reported together. Every linked wallet proves ownership
+// by signing a WalletLinkMessage naming the owner. A wallet is linked to at
+// most one owner, and owners aren't linked to anyone else. Links are kept in
+// memory.
+type WalletLinks struct {
+	mu     sync.RWMutex
+	links  map[string]map[string]time.Time
+	owners map[string]string
+	now    func() time.Time
+}
+
+// NewWalletLinks creates an empty link store
+func NewWalletLinks() *WalletLinks {
+	return &WalletLinks{
+		links:  make(map[string]map[string]time.Time),
+		owners: make(map[string]string),
+		now:    utcNow,
+	}
+}
+
+// Link links the wallets of the proofs to the owner. Every proof is checked
+// before any wallet is linked, so either all are linked or none. Wallets
+// already linked to the owner are linked again.
+func (wl *WalletLinks) Link(owner common.Address, proofs []WalletLinkProof) ([]LinkedWallet, error) {
+	now := wl.now()
+	wallets := make([]string, 0, len(proofs))
+	for _, proof := range proofs {
+		wallet, err := verifyWalletLink(owner, proof, now)
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, strings.ToLower(wallet.Hex()))
+	}
+
+	key := strings.ToLower(owner.Hex())
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if _, linked := wl.owners[key]; linked {
+		return nil, fmt.Errorf("%w: %s is linked to %s", ErrWalletLinkedElsewhere, owner.Hex(), wl.owners[key])
+	}
+	added := 0
+	for _, wallet := range wallets {
+		if wallet == key {
+			return nil, fmt.Errorf("%w: a wallet can't be linked to itself", ErrWalletLinkSignature)
+		}
+		if current, ok := wl.owners[wallet]; ok && current != key {
+			return nil, fmt.Errorf("%w: %s", ErrWalletLinkedElsewhere, wallet)
+		}
+		if len(wl.links[wallet]) > 0 {
+			return nil, fmt.Errorf("%w: %s has wallets linked to it", ErrWalletLinkedElsewhere, wallet)
+		}
+		if _, ok := wl.links[key][wallet]; !ok {
+			added++
+		}
+	}
+	if len(wl.links[key])+added > MaxLinkedWallets {
+		return nil, fmt.Errorf("%w: at most %d", ErrTooManyLinkedWallets, MaxLinkedWallets)
+	}
+
+	if wl.links[key] == nil {
+		wl.links[key] = make(map[string]time.Time)
+	}
+	for _, wallet := range wallets {
+		wl.links[key][wallet] = now
+		wl.owners[wallet] = key
+	}
+	return wl.linked(key), nil
+}
+
+// verifyWalletLink recovers the signer of a link proof and checks it is the
+// wallet being linked
+func verifyWalletLink(owner common.Address, proof WalletLinkProof, now time.Time) (common.Address, error) {
+	if !common.IsHexAddress(proof.Address) {
+		return common.Address{}, fmt.Errorf("%w: %q is not an address", ErrWalletLinkSignature, proof.Address)
+	}
+	wallet := common.HexToAddress(proof.Address)
+	if age := now.Sub(proof.IssuedAt); age > WalletLinkProofTTL || age < -time.Minute {
+		return common.Address{}, fmt.Errorf("%w: %s was issued at %s", ErrWalletLinkExpired, wallet.Hex(), proof.IssuedAt.Format(time.RFC3339))
+	}
+
+	signer, err := recoverPersonalSigner(WalletLinkMessage(owner, wallet, proof.IssuedAt), proof.Signature)
+	if errors.Is(err, errMalformedSignature) {
+		return common.Address{}, fmt.Errorf("%w: signature for %s is malformed", ErrWalletLinkSignature, wallet.Hex())
+	}
+	if err != nil || signer != wallet {
+		return common.Address{}, fmt.Errorf("%w: %s didn't sign the link message", ErrWalletLinkSignature, wallet.Hex())
+	}
+	return wallet, nil
+}
+
+// errMalformedSignature is returned for signatures that aren't 65 hex bytes
+var errMalformedSignature = errors.New("signature is malformed")
+
+// recoverPersonalSigner recovers the address that signed the message with
+// personal_sign
+func recoverPersonalSigner(message, signature string) (common.Address, error) {
+	decoded, err := hexutil.Decode(signature)
+	if err != nil || len(decoded) != crypto.SignatureLength {
+		return common.Address{}, errMalformedSignature
+	}
+	// Wallets return personal_sign signatures with a recovery ID of 27 or 28
+	if decoded[crypto.RecoveryIDOffset] >= 27 {
+		decoded[crypto.RecoveryIDOffset] -= 27
+	}
+	key, err := crypto.SigToPub(accounts.TextHash([]byte(message)), decoded)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*key), nil
+}
+
+// Unlink removes a wallet from the owner's linked wallets
+func (wl *WalletLinks) Unlink(owner, wallet common.Address) error {
+	key, walletKey := strings.ToLower(owner.Hex()), strings.ToLower(wallet.Hex())
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if _, ok := wl.links[key][walletKey]; !ok {
+		return fmt.Errorf("%w: %s", ErrWalletNotLinked, wallet.Hex())
+	}
+	delete(wl.links[key], walletKey)
+	delete(wl.owners, walletKey)
+	if len(wl.links[key]) == 0 {
+		delete(wl.links, key)
+	}
+	return nil
+}
+
+// Linked returns the wallets linked to the owner, by address
+func (wl *WalletLinks) Linked(owner common.Address) []LinkedWallet {
+	wl.mu.RLock()
+	defer wl.mu.RUnlock()
+	return wl.linked(strings.ToLower(owner.Hex()))
+}
+
+// linked lists an owner's wallets. The lock is held by the caller.
+func (wl *WalletLinks) linked(key string) []LinkedWallet {
+	wallets := make([]LinkedWallet, 0, len(wl.links[key]))
+	for wallet, linkedAt := range wl.links[key] {
+		wallets = append(wallets, LinkedWallet{Address: wallet, LinkedAt: linkedAt})
+	}
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].Address < wallets[j].Address })
+	return wallets
+}
+
+// Wallets returns the owner followed by the wallets linked to it
+func (wl *WalletLinks) Wallets(owner common.Address) []common.Address {
+	wallets := []common.Address{owner}
+	for _, linked := range wl.Linked(owner) {
+		wallets = append(wallets, common.HexToAddress(linked.Address))
+	}
+	return wallets
+}
+
+// UserData returns the wallets linked to the user
+func (wl *WalletLinks) UserData(userID string) interface{} {
+	if !common.IsHexAddress(userID) {
+		return []LinkedWallet{}
+	}
+	return wl.Linked(common.HexToAddress(userID))
+}
+
+// EraseUserData removes the user's links, both the wallets linked to them
+// and their link to another owner
+func (wl *WalletLinks) EraseUserData(userID string) int {
+	key := strings.ToLower(userID)
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	removed := len(wl.links[key])
+	for wallet := range wl.links[key] {
+		delete(wl.owners, wallet)
+	}
+	delete(wl.links, key)
+	if owner, ok := wl.owners[key]; ok {
+		delete(wl.links[owner], key)
+		if len(wl.links[owner]) == 0 {
+			delete(wl.links, owner)
+		}
+		delete(wl.owners, key)
+		removed++
+	}
+	return removed
+}
+
+// walletSet is a set of wallets addressed by lowercased hex
+type walletSet map[string]bool
+
+// newWalletSet returns the set of the given wallets
+func newWalletSet(wallets []common.Address) walletSet {
+	set := make(walletSet, len(wallets))
+	for _, wallet := range wallets {
+		set[strings.ToLower(wallet.Hex())] = true
+	}
+	return set
+}
+
+// internal reports whether a transfer moves value between two of the wallets
+func (set walletSet) internal(from, to string) bool {
+	return from != to && set[strings.ToLower(from)] && set[strings.ToLower(to)]
+}
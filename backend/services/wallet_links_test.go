@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signWalletLink signs the link message of the key's wallet the way
+// personal_sign does
+func signWalletLink(t *testing.T, key *ecdsa.PrivateKey, owner common.Address, issuedAt time.Time) WalletLinkProof {
+	t.Helper()
+	wallet := crypto.PubkeyToAddress(key.PublicKey)
+	signature, err := crypto.Sign(accounts.TextHash([]byte(WalletLinkMessage(owner, wallet, issuedAt))), key)
+	require.NoError(t, err)
+	signature[crypto.RecoveryIDOffset] += 27
+	return WalletLinkProof{Address: wallet.Hex(), Signature: hexutil.Encode(signature), IssuedAt: issuedAt}
+}
+
+func newWalletKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return key
+}
+
+// walletBalances holds a native balance per address
+type walletBalances map[common.Address]*big.Int
+
+func (w walletBalances) NativeBalance(ctx context.Context, address common.Address) (*big.Int, error) {
+	if balance, ok := w[address]; ok {
+		return balance, nil
+	}
+	return new(big.Int), nil
+}
+
+func TestWalletLinksRequireSignatures(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	links := NewWalletLinks()
+	links.now = func() time.Time { return now }
+	first, second, stranger := newWalletKey(t), newWalletKey(t), newWalletKey(t)
+	firstWallet, secondWallet := crypto.PubkeyToAddress(first.PublicKey), crypto.PubkeyToAddress(second.PublicKey)
+
+	// A proof signed by another key links nothing, even next to a good one
+	forged := signWalletLink(t, stranger, summaryAddress, now)
+	forged.Address = secondWallet.Hex()
+	_, err := links.Link(summaryAddress, []WalletLinkProof{signWalletLink(t, first, summaryAddress, now), forged})
+	assert.ErrorIs(t, err, ErrWalletLinkSignature)
+	assert.Empty(t, links.Linked(summaryAddress))
+
+	// Proofs name the owner, so one made for another owner doesn't verify
+	_, err = links.Link(summaryAddress, []WalletLinkProof{signWalletLink(t, first, secondWallet, now)})
+	assert.ErrorIs(t, err, ErrWalletLinkSignature)
+	_, err = links.Link(summaryAddress, []WalletLinkProof{signWalletLink(t, first, summaryAddress, now.Add(-WalletLinkProofTTL-time.Second))})
+	assert.ErrorIs(t, err, ErrWalletLinkExpired)
+
+	linked, err := links.Link(summaryAddress, []WalletLinkProof{
+		signWalletLink(t, first, summaryAddress, now.Add(-time.Minute)),
+		signWalletLink(t, second, summaryAddress, now),
+	})
+	require.NoError(t, err)
+	assert.Len(t, linked, 2)
+	assert.Equal(t, summaryAddress, links.Wallets(summaryAddress)[0], "the owner comes first")
+	assert.ElementsMatch(t, []common.Address{summaryAddress, firstWallet, secondWallet}, links.Wallets(summaryAddress))
+
+	// A linked wallet can't also belong to someone else
+	other := common.HexToAddress("0x00000000000000000000000000000000000000cc")
+	_, err = links.Link(other, []WalletLinkProof{signWalletLink(t, first, other, now)})
+	assert.ErrorIs(t, err, ErrWalletLinkedElsewhere)
+
+	require.NoError(t, links.Unlink(summaryAddress, firstWallet))
+	assert.ErrorIs(t, links.Unlink(summaryAddress, firstWallet), ErrWalletNotLinked)
+	assert.Equal(t, 1, links.EraseUserData(strings.ToLower(summaryAddress.Hex())))
+	assert.Equal(t, []common.Address{summaryAddress}, links.Wallets(summaryAddress))
+}
+
+func TestFeeSpendWalletsCountsInternalTransfersOnce(t *testing.T) {
+	at := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	owner, linked := summaryAddress, common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	index := NewTransactionIndex()
+	// Moving funds between the wallets is indexed for both of them
+	index.Add(feeTx("0xa", owner.Hex(), linked.Hex(), at, 21_000, 25))
+	index.Add(feeTx("0xb", owner.Hex(), feeDex, at.Add(time.Minute), 100_000, 25))
+	index.Add(feeTx("0xc", linked.Hex(), feeDex, at.Add(2*time.Minute), 100_000, 25))
+	index.Add(feeTx("0xd", feeBridge, owner.Hex(), at.Add(3*time.Minute), 50_000, 25))
+	for _, wallet := range []common.Address{owner, linked} {
+		index.MarkIndexed(wallet, IndexedRange{FromBlock: 1, ToBlock: 100, From: at.Add(-time.Hour), To: at.Add(time.Hour)}, time.Time{})
+	}
+	analyzer := NewFeeAnalyzer(index, datedPrices{time.February: 0.2}, nil, nil)
+	analyzer.now = func() time.Time { return at.Add(time.Hour) }
+
+	report, _, err := analyzer.FeeSpendWallets(context.Background(), []common.Address{owner, linked}, at.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.True(t, report.Complete)
+	assert.Equal(t, 3, report.TxCount)
+	assert.Equal(t, uint64(221_000), report.GasUsed)
+	assert.Equal(t, new(big.Int).Mul(big.NewInt(221_000*25), big.NewInt(gwei)).String(), report.TotalFeeWei)
+	assert.Len(t, report.Wallets, 2)
+
+	// The transfer's gas is reported apart from the contracts called
+	require.NotNil(t, report.InternalTransfers)
+	assert.Equal(t, 1, report.InternalTransfers.TxCount)
+	assert.InDelta(t, 21_000*25e-9*0.2, report.InternalTransfers.FeeUSD, 1e-12)
+	require.Len(t, report.ByContract, 1)
+	assert.Equal(t, feeDex, report.ByContract[0].Address)
+	assert.Equal(t, 2, report.ByContract[0].TxCount)
+
+	// Each wallet on its own still reports the transfer as a sent transaction
+	single, _, err := analyzer.FeeSpend(context.Background(), owner, at.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, single.TxCount)
+	assert.Nil(t, single.InternalTransfers)
+}
+
+func TestWalletsPerformanceExcludesInternalTransfers(t *testing.T) {
+	end := time.Now().UTC()
+	start := end.AddDate(0, 0, -30).Add(-time.Minute)
+	clock := start
+	owner, linked := summaryAddress, common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	outsider := "0x00000000000000000000000000000000000000cc"
+
+	balances := walletBalances{owner: kaia(1000), linked: kaia(500)}
+	index := NewTransactionIndex()
+	prices := fakePrices{NativeSymbol: 1.0}
+	tracker := NewPortfolioTracker(balances, fakeTokenBalances{}, prices, NewNativeTransferFlows(index, constantPrice(1.0), nil, deployedCode{}))
+	tracker.now = func() time.Time { return clock }
+	for _, wallet := range []common.Address{owner, linked} {
+		_, err := tracker.Enroll(context.Background(), wallet)
+		require.NoError(t, err)
+	}
+
+	// Halfway through 300 KAIA moves between the wallets and 200 KAIA comes
+	// in from outside
+	middle := start.AddDate(0, 0, 15)
+	index.Add(IndexedTransaction{Hash: "0xe1", From: owner.Hex(), To: linked.Hex(), Timestamp: middle, Value: kaia(300)})
+	index.Add(IndexedTransaction{Hash: "0xe2", From: outsider, To: linked.Hex(), Timestamp: middle.Add(time.Hour), Value: kaia(200)})
+	for _, wallet := range []common.Address{owner, linked} {
+		index.MarkIndexed(wallet, IndexedRange{FromBlock: 1, ToBlock: 100, From: start.Add(-time.Hour), To: end.Add(time.Hour)}, time.Time{})
+	}
+	balances[owner], balances[linked] = kaia(700), kaia(1000)
+	clock = middle.Add(2 * time.Hour)
+	assert.Equal(t, 2, tracker.SnapshotAll(context.Background()))
+
+	prices[NativeSymbol] = 1.1
+	clock = end
+
+	perf, err := tracker.WalletsPerformance(context.Background(), []common.Address{owner, linked}, end.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.Len(t, perf.Series, 3)
+	assert.InDelta(t, 1500, perf.StartValueUSD, 1e-9)
+	assert.InDelta(t, 1870, perf.EndValueUSD, 1e-9)
+	require.Len(t, perf.Flows, 1, "only the outside deposit is a flow")
+	assert.Equal(t, "0xe2", perf.Flows[0].TxHash)
+	assert.InDelta(t, 200, perf.NetFlowsUSD, 1e-9)
+	assert.InDelta(t, 170, perf.PnLUSD, 1e-9)
+	assert.False(t, perf.Partial)
+
+	// On its own, the owner's wallet saw the transfer as a withdrawal
+	single, err := tracker.Performance(context.Background(), owner, end.AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.InDelta(t, -300, single.NetFlowsUSD, 1e-9)
+
+	// "Total across my wallets" is answered from the aggregate
+	links := NewWalletLinks()
+	links.links[strings.ToLower(owner.Hex())] = map[string]time.Time{strings.ToLower(linked.Hex()): start}
+	links.owners[strings.ToLower(linked.Hex())] = strings.ToLower(owner.Hex())
+	engine := newTestChatEngine(t)
+	engine.SetPortfolioTracker(tracker)
+	engine.SetWalletLinks(links)
+	engine.SetAddressSummarizer(newTestSummarizer(balances, fakeTokenBalances{}, index, end))
+
+	response, err := engine.ProcessMessage(context.Background(), &ChatMessage{UserID: owner.Hex(), Message: "What's the total across my wallets?"})
+	require.NoError(t, err)
+	assert.Contains(t, response.Response, "Total across 2 linked wallets")
+	summary := response.Data.(map[string]interface{})["summary"].(*AddressSummary)
+	assert.InDelta(t, 1700, summary.NativeBalanceFloat, 1e-9)
+	// The transfer between the wallets is one transaction, not two
+	assert.Equal(t, 2, summary.TxCount30d)
+	require.Len(t, summary.TopCounterparties, 1)
+	assert.Equal(t, outsider, summary.TopCounterparties[0].Address)
+
+	response, err = engine.ProcessMessage(context.Background(), &ChatMessage{UserID: owner.Hex(), Message: "How are my wallets doing this month?"})
+	require.NoError(t, err)
+	assert.Equal(t, "portfolio_performance", response.Type)
+	assert.Contains(t, response.Response, "Across 2 linked wallets")
+	assert.InDelta(t, 200, response.Data.(*PortfolioPerformance).NetFlowsUSD, 1e-9)
+}
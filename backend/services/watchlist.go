@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MaxWatchedAddresses bounds the addresses one user watches
+const MaxWatchedAddresses = 50
+
+var (
+	// ErrNotWatched is returned when unwatching an address that isn't watched
+	ErrNotWatched = errors.New("address is not watched")
+	// ErrWatchlistFull is returned when watching would pass MaxWatchedAddresses
+	ErrWatchlistFull = fmt.Errorf("at most %d addresses can be watched", MaxWatchedAddresses)
+)
+
+// WatchedAddress is an address a user is alerted about. Transactions from or
+// to it alert the user when they move at least MinValue KAIA.
+type WatchedAddress struct {
+	UserID    string  `json:"user_id"`
+	Address   string  `json:"address"`
+	Label     string  `json:"label,omitempty"`
+	MinValue  float64 `json:"min_value,omitempty"`
+	CreatedAt APITime `json:"created_at"`
+}
+
+// Matches reports whether a transaction moving value wei meets the rule
+func (w WatchedAddress) Matches(value *big.Int) bool {
+	if w.MinValue <= 0 {
+		return true
+	}
+	return value != nil && weiToFloat(value, 18) >= w.MinValue
+}
+
+// Watchlist holds the addresses users watch, indexed by address so incoming
+// transactions can be matched. Watches are kept in memory.
+type Watchlist struct {
+	mu        sync.RWMutex
+	byAddress map[string]map[string]*WatchedAddress
+	byUser    map[string]map[string]*WatchedAddress
+	now       func() time.Time
+}
+
+// NewWatchlist creates an empty watchlist
+func NewWatchlist() *Watchlist {
+	return &Watchlist{
+		byAddress: make(map[string]map[string]*WatchedAddress),
+		byUser:    make(map[string]map[string]*WatchedAddress),
+		now:       utcNow,
+	}
+}
+
+// Watch adds the address to the user's watchlist, or updates its label and
+// minimum value when it is already there
+func (wl *Watchlist) Watch(userID string, address common.Address, label string, minValue float64) (WatchedAddress, error) {
+	if minValue < 0 {
+		return WatchedAddress{}, errors.New("min_value can't be negative")
+	}
+	userID, key := strings.ToLower(userID), strings.ToLower(address.Hex())
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if watched, ok := wl.byUser[userID][key]; ok {
+		watched.Label = label
+		watched.MinValue = minValue
+		return *watched, nil
+	}
+	if len(wl.byUser[userID]) >= MaxWatchedAddresses {
+		return WatchedAddress{}, ErrWatchlistFull
+	}
+
+	watched := &WatchedAddress{
+		UserID:    userID,
+		Address:   address.Hex(),
+		Label:     label,
+		MinValue:  minValue,
+		CreatedAt: NewAPITime(wl.now()),
+	}
+	if wl.byUser[userID] == nil {
+		wl.byUser[userID] = make(map[string]*WatchedAddress)
+	}
+	if wl.byAddress[key] == nil {
+		wl.byAddress[key] = make(map[string]*WatchedAddress)
+	}
+	wl.byUser[userID][key] = watched
+	wl.byAddress[key][userID] = watched
+	return *watched, nil
+}
+
+// Unwatch removes the address from the user's watchlist
+func (wl *Watchlist) Unwatch(userID string, address common.Address) error {
+	userID, key := strings.ToLower(userID), strings.ToLower(address.Hex())
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if _, ok := wl.byUser[userID][key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotWatched, address.Hex())
+	}
+	wl.remove(userID, key)
+	return nil
+}
+
+// remove deletes one watch. The lock is held by the caller.
+func (wl *Watchlist) remove(userID, key string) {
+	delete(wl.byUser[userID], key)
+	if len(wl.byUser[userID]) == 0 {
+		delete(wl.byUser, userID)
+	}
+	delete(wl.byAddress[key], userID)
+	if len(wl.byAddress[key]) == 0 {
+		delete(wl.byAddress, key)
+	}
+}
+
+// List returns the user's watched addresses, oldest first
+func (wl *Watchlist) List(userID string) []WatchedAddress {
+	wl.mu.RLock()
+	defer wl.mu.RUnlock()
+
+	watched := make([]WatchedAddress, 0, len(wl.byUser[strings.ToLower(userID)]))
+	for _, entry := range wl.byUser[strings.ToLower(userID)] {
+		watched = append(watched, *entry)
+	}
+	sort.Slice(watched, func(i, j int) bool {
+		if !watched[i].CreatedAt.Equal(watched[j].CreatedAt.Time) {
+			return watched[i].CreatedAt.Before(watched[j].CreatedAt.Time)
+		}
+		return watched[i].Address < watched[j].Address
+	})
+	return watched
+}
+
+// Watchers returns the watches of an address, by user
+func (wl *Watchlist) Watchers(address common.Address) []WatchedAddress {
+	wl.mu.RLock()
+	defer wl.mu.RUnlock()
+
+	entries := wl.byAddress[strings.ToLower(address.Hex())]
+	watchers := make([]WatchedAddress, 0, len(entries))
+	for _, entry := range entries {
+		watchers = append(watchers, *entry)
+	}
+	sort.Slice(watchers, func(i, j int) bool { return watchers[i].UserID < watchers[j].UserID })
+	return watchers
+}
+
+// UserData returns the user's watched addresses
+func (wl *Watchlist) UserData(userID string) interface{} {
+	return wl.List(userID)
+}
+
+// EraseUserData empties the user's watchlist and returns how many addresses
+// were removed
+func (wl *Watchlist) EraseUserData(userID string) int {
+	userID = strings.ToLower(userID)
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	removed := len(wl.byUser[userID])
+	for key := range wl.byUser[userID] {
+		wl.remove(userID, key)
+	}
+	return removed
+}
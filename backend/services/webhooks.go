@@ -0,0 +1,659 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries the HMAC-SHA256 signature of each delivery
+	WebhookSignatureHeader = "X-Kaia-Signature"
+
+	webhookMaxBackoff          = 24 * time.Hour
+	webhookBaseBackoff         = 30 * time.Second
+	webhookDisableAfter        = 50
+	webhookDeliveryTimeout     = 10 * time.Second
+	webhookDeliveryLogCapacity = 100
+
+	// MaxWebhooksPerOwner is how many webhooks a user can register
+	MaxWebhooksPerOwner = 10
+)
+
+// ErrWebhookLimit is returned when an owner already has MaxWebhooksPerOwner webhooks
+var ErrWebhookLimit = errors.New("too many webhooks")
+
+// nonPublicNetworks are the special-use ranges of the IANA registries, none
+// of which a webhook may reach: shared carrier-grade NAT space, benchmarking
+// and documentation ranges, relay and translation prefixes that lead into
+// other networks, multicast and reserved space, besides the usual private,
+// loopback and link-local ones
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24", "192.88.99.0/24", "192.168.0.0/16",
+	"198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "64:ff9b:1::/48", "100::/64", "2001::/23",
+	"2001:db8::/32", "2002::/16", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// mustParseCIDRs parses CIDR literals, panicking on a malformed one
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Webhook is a user-registered endpoint that receives event notifications
+type Webhook struct {
+	ID                  string    `json:"id"`
+	Owner               string    `json:"owner"`
+	URL                 string    `json:"url"`
+	Secret              string    `json:"secret,omitempty"`
+	Events              []string  `json:"events"`
+	Disabled            bool      `json:"disabled"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// WebhookEvent is an event produced by a feature for delivery to its owner's webhooks
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Owner     string      `json:"owner"`
+	Payload   interface{} `json:"payload"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// WebhookDeliveryAttempt records one attempt to deliver an event to a webhook
+type WebhookDeliveryAttempt struct {
+	EventID     string    `json:"event_id"`
+	EventType   string    `json:"event_type"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Delivered   bool      `json:"delivered"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+}
+
+// outboxEntry is a pending delivery of one event to one webhook
+type outboxEntry struct {
+	webhookID   string
+	event       WebhookEvent
+	body        []byte
+	attempts    int
+	nextAttempt time.Time
+	inFlight    bool
+}
+
+// WebhookDispatcher delivers events to registered webhooks through an outbox
+// drained by worker goroutines, retrying failures with exponential backoff
+type WebhookDispatcher struct {
+	httpClient *http.Client
+	logger     *log.Logger
+	workers    int
+	now        func() time.Time
+	// lookupIP resolves the host of a webhook being registered
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+
+	mu       sync.Mutex
+	webhooks map[string]*Webhook
+	outbox   []*outboxEntry
+	logs     map[string][]WebhookDeliveryAttempt
+}
+
+// NewWebhookDispatcher creates a dispatcher with the given number of delivery workers
+func NewWebhookDispatcher(workers int) *WebhookDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	return &WebhookDispatcher{
+		httpClient: newWebhookClient(),
+		logger:     log.New(log.Writer(), "[WebhookDispatcher] ", log.LstdFlags),
+		workers:    workers,
+		now:        utcNow,
+		lookupIP:   lookupHostIP,
+		webhooks:   make(map[string]*Webhook),
+		logs:       make(map[string][]WebhookDeliveryAttempt),
+	}
+}
+
+// lookupHostIP resolves a host name, or parses an IP literal
+func lookupHostIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// newWebhookClient creates the client deliveries are posted with. It refuses
+// to connect to internal addresses, checked after resolution so a host can't
+// be pointed inside the network once registered.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookDeliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkWebhookIP(net.ParseIP(host))
+		},
+	}
+	return &http.Client{
+		Timeout:   webhookDeliveryTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookDeliveryTimeout},
+	}
+}
+
+// checkWebhookIP refuses addresses webhooks must not reach: anything that
+// isn't a public unicast address. IPv4-mapped IPv6 addresses are checked as
+// the IPv4 addresses they are.
+func checkWebhookIP(ip net.IP) error {
+	if ip == nil {
+		return fmt.Errorf("webhook address is not an IP")
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+	if !ip.IsGlobalUnicast() {
+		return fmt.Errorf("webhook address %s is internal", ip)
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return fmt.Errorf("webhook address %s is internal", ip)
+		}
+	}
+	return nil
+}
+
+// checkWebhookURL requires an https URL whose host resolves only to public
+// addresses
+func (wd *WebhookDispatcher) checkWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" {
+		return fmt.Errorf("webhook url must be an https url")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookDeliveryTimeout)
+	defer cancel()
+	ips, err := wd.lookupIP(ctx, parsed.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("webhook host %s could not be resolved", parsed.Hostname())
+	}
+	for _, ip := range ips {
+		if err := checkWebhookIP(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterWebhook registers a webhook for the owner and returns it including
+// its signing secret. An owner can have at most MaxWebhooksPerOwner.
+func (wd *WebhookDispatcher) RegisterWebhook(owner, url string, events []string) (*Webhook, error) {
+	if err := wd.checkWebhookURL(url); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("at least one event type is required")
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook id: %w", err)
+	}
+
+	webhook := &Webhook{
+		ID:        "wh_" + id,
+		Owner:     strings.ToLower(owner),
+		URL:       url,
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: wd.now(),
+	}
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	owned := 0
+	for _, existing := range wd.webhooks {
+		if existing.Owner == webhook.Owner {
+			owned++
+		}
+	}
+	if owned >= MaxWebhooksPerOwner {
+		return nil, ErrWebhookLimit
+	}
+	wd.webhooks[webhook.ID] = webhook
+
+	copied := *webhook
+	return &copied, nil
+}
+
+// ListWebhooks returns the owner's webhooks without their secrets
+func (wd *WebhookDispatcher) ListWebhooks(owner string) []Webhook {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	owner = strings.ToLower(owner)
+	webhooks := make([]Webhook, 0)
+	for _, webhook := range wd.webhooks {
+		if webhook.Owner == owner {
+			copied := *webhook
+			copied.Secret = ""
+			webhooks = append(webhooks, copied)
+		}
+	}
+	return webhooks
+}
+
+// GetWebhook returns a webhook (without its secret) if it belongs to the owner
+func (wd *WebhookDispatcher) GetWebhook(owner, id string) (*Webhook, bool) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	webhook, exists := wd.webhooks[id]
+	if !exists || webhook.Owner != strings.ToLower(owner) {
+		return nil, false
+	}
+	copied := *webhook
+	copied.Secret = ""
+	return &copied, true
+}
+
+// RemoveWebhook deletes an owner's webhook and drops its pending deliveries
+func (wd *WebhookDispatcher) RemoveWebhook(owner, id string) bool {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	webhook, exists := wd.webhooks[id]
+	if !exists || webhook.Owner != strings.ToLower(owner) {
+		return false
+	}
+	wd.remove(id)
+	return true
+}
+
+// remove deletes a webhook, its delivery log, and its pending deliveries; the
+// caller holds the lock
+func (wd *WebhookDispatcher) remove(id string) {
+	delete(wd.webhooks, id)
+	delete(wd.logs, id)
+
+	remaining := wd.outbox[:0]
+	for _, entry := range wd.outbox {
+		if entry.webhookID != id {
+			remaining = append(remaining, entry)
+		}
+	}
+	wd.outbox = remaining
+}
+
+// webhookUserData is what the dispatcher keeps about an owner
+type webhookUserData struct {
+	Webhooks   []Webhook                           `json:"webhooks"`
+	Deliveries map[string][]WebhookDeliveryAttempt `json:"deliveries"`
+}
+
+// UserData returns the owner's webhooks, without their secrets, and their
+// delivery logs
+func (wd *WebhookDispatcher) UserData(userID string) interface{} {
+	data := webhookUserData{Webhooks: wd.ListWebhooks(userID), Deliveries: make(map[string][]WebhookDeliveryAttempt)}
+	for _, webhook := range data.Webhooks {
+		if attempts, ok := wd.DeliveryLog(userID, webhook.ID); ok {
+			data.Deliveries[webhook.ID] = attempts
+		}
+	}
+	return data
+}
+
+// EraseUserData removes the owner's webhooks with their logs and pending
+// deliveries, returning how many webhooks were removed
+func (wd *WebhookDispatcher) EraseUserData(userID string) int {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	owner := strings.ToLower(userID)
+	removed := 0
+	for id, webhook := range wd.webhooks {
+		if webhook.Owner == owner {
+			wd.remove(id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// DeliveryLog returns the most recent delivery attempts for an owner's webhook, newest first
+func (wd *WebhookDispatcher) DeliveryLog(owner, id string) ([]WebhookDeliveryAttempt, bool) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	webhook, exists := wd.webhooks[id]
+	if !exists || webhook.Owner != strings.ToLower(owner) {
+		return nil, false
+	}
+
+	entries := wd.logs[id]
+	attempts := make([]WebhookDeliveryAttempt, len(entries))
+	for i, attempt := range entries {
+		attempts[len(entries)-1-i] = attempt
+	}
+	return attempts, true
+}
+
+// Dispatch enqueues an event for every enabled webhook of its owner subscribed to the event type
+func (wd *WebhookDispatcher) Dispatch(event WebhookEvent) error {
+	if event.Type == "" || event.Owner == "" {
+		return fmt.Errorf("event type and owner are required")
+	}
+	if event.ID == "" {
+		id, err := randomHex(8)
+		if err != nil {
+			return fmt.Errorf("failed to generate event id: %w", err)
+		}
+		event.ID = "evt_" + id
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = wd.now()
+	}
+	event.Owner = strings.ToLower(event.Owner)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	for _, webhook := range wd.webhooks {
+		if webhook.Disabled || webhook.Owner != event.Owner || !webhook.subscribes(event.Type) {
+			continue
+		}
+		wd.outbox = append(wd.outbox, &outboxEntry{
+			webhookID:   webhook.ID,
+			event:       event,
+			body:        body,
+			nextAttempt: event.CreatedAt,
+		})
+	}
+
+	return nil
+}
+
+func (w *Webhook) subscribes(eventType string) bool {
+	for _, subscribed := range w.Events {
+		if subscribed == "*" || subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Start runs the delivery workers until the context is cancelled
+func (wd *WebhookDispatcher) Start(ctx context.Context) {
+	jobs := make(chan *outboxEntry)
+
+	for i := 0; i < wd.workers; i++ {
+		go func() {
+			for entry := range jobs {
+				wd.deliver(ctx, entry)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, entry := range wd.claimDue() {
+					select {
+					case jobs <- entry:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// ProcessDue synchronously delivers every entry whose next attempt is due
+func (wd *WebhookDispatcher) ProcessDue(ctx context.Context) int {
+	due := wd.claimDue()
+	for _, entry := range due {
+		wd.deliver(ctx, entry)
+	}
+	return len(due)
+}
+
+// PendingDeliveries returns the number of entries waiting in the outbox
+func (wd *WebhookDispatcher) PendingDeliveries() int {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return len(wd.outbox)
+}
+
+// claimDue marks due outbox entries as in flight and returns them
+func (wd *WebhookDispatcher) claimDue() []*outboxEntry {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	now := wd.now()
+	var due []*outboxEntry
+	for _, entry := range wd.outbox {
+		if !entry.inFlight && !entry.nextAttempt.After(now) {
+			entry.inFlight = true
+			due = append(due, entry)
+		}
+	}
+	return due
+}
+
+// deliver performs one delivery attempt and records its outcome
+func (wd *WebhookDispatcher) deliver(ctx context.Context, entry *outboxEntry) {
+	wd.mu.Lock()
+	webhook, exists := wd.webhooks[entry.webhookID]
+	if !exists || webhook.Disabled {
+		wd.removeEntry(entry)
+		wd.mu.Unlock()
+		return
+	}
+	url, secret := webhook.URL, webhook.Secret
+	wd.mu.Unlock()
+
+	attemptedAt := wd.now()
+	statusCode, err := wd.post(ctx, url, secret, entry.body, attemptedAt)
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	entry.attempts++
+	attempt := WebhookDeliveryAttempt{
+		EventID:     entry.event.ID,
+		EventType:   entry.event.Type,
+		Attempt:     entry.attempts,
+		StatusCode:  statusCode,
+		AttemptedAt: attemptedAt,
+	}
+
+	// The webhook may have been removed while the request was in flight
+	webhook, exists = wd.webhooks[entry.webhookID]
+	if !exists {
+		wd.removeEntry(entry)
+		return
+	}
+
+	if err == nil {
+		attempt.Delivered = true
+		webhook.ConsecutiveFailures = 0
+		wd.removeEntry(entry)
+	} else {
+		attempt.Error = err.Error()
+		webhook.ConsecutiveFailures++
+
+		if webhook.ConsecutiveFailures >= webhookDisableAfter {
+			webhook.Disabled = true
+			wd.logger.Printf("Disabled webhook %s after %d consecutive failures", webhook.ID, webhook.ConsecutiveFailures)
+			wd.removeEntriesFor(webhook.ID)
+		} else {
+			entry.nextAttempt = attemptedAt.Add(webhookBackoff(entry.attempts))
+			entry.inFlight = false
+			attempt.NextAttempt = entry.nextAttempt
+		}
+	}
+
+	logEntries := append(wd.logs[entry.webhookID], attempt)
+	if len(logEntries) > webhookDeliveryLogCapacity {
+		logEntries = logEntries[len(logEntries)-webhookDeliveryLogCapacity:]
+	}
+	wd.logs[entry.webhookID] = logEntries
+}
+
+// post sends a signed delivery and returns the response status code
+func (wd *WebhookDispatcher) post(ctx context.Context, url, secret string, body []byte, timestamp time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookDeliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, timestamp, body))
+
+	resp, err := wd.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("delivery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// removeEntry drops an entry from the outbox. Callers must hold wd.mu.
+func (wd *WebhookDispatcher) removeEntry(target *outboxEntry) {
+	for i, entry := range wd.outbox {
+		if entry == target {
+			wd.outbox = append(wd.outbox[:i], wd.outbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeEntriesFor drops every pending entry for a webhook. Callers must hold wd.mu.
+func (wd *WebhookDispatcher) removeEntriesFor(webhookID string) {
+	remaining := wd.outbox[:0]
+	for _, entry := range wd.outbox {
+		if entry.webhookID != webhookID {
+			remaining = append(remaining, entry)
+		}
+	}
+	wd.outbox = remaining
+}
+
+// webhookBackoff returns the delay before the next attempt after the given number of failures
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return delay
+}
+
+// SignWebhookPayload builds the signature header value "t=<unix>,v1=<hex hmac>".
+// The timestamp is part of the signed content so receivers can reject replays.
+func SignWebhookPayload(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookHMAC(secret, ts, body)
+}
+
+// VerifyWebhookSignature checks a signature header against the body and rejects
+// signatures older than the tolerance
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signature = value
+		}
+	}
+	if ts == "" || signature == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp: %w", err)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp outside tolerance")
+	}
+
+	expected := webhookHMAC(secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func webhookHMAC(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
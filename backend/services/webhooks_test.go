@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const webhookOwner = "0x00000000000000000000000000000000000000aa"
+
+// flakyReceiver fails the first n deliveries and accepts the rest, verifying signatures
+type flakyReceiver struct {
+	mu        sync.Mutex
+	failFirst int
+	received  int
+	secret    string
+	now       func() time.Time
+	sigErrors []error
+}
+
+func (fr *flakyReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	fr.received++
+	if fr.secret != "" {
+		if err := VerifyWebhookSignature(fr.secret, r.Header.Get(WebhookSignatureHeader), body, 5*time.Minute, fr.now()); err != nil {
+			fr.sigErrors = append(fr.sigErrors, err)
+		}
+	}
+	if fr.received <= fr.failFirst {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// publicLookup resolves every host to a public address
+func publicLookup(ctx context.Context, host string) ([]net.IP, error) {
+	return []net.IP{net.ParseIP("93.184.216.34")}, nil
+}
+
+// newTestDispatcher creates a dispatcher delivering to the TLS test server,
+// which listens on loopback, as if it were a public host
+func newTestDispatcher(clock *time.Time, server *httptest.Server) *WebhookDispatcher {
+	dispatcher := NewWebhookDispatcher(1)
+	dispatcher.now = func() time.Time { return *clock }
+	dispatcher.lookupIP = publicLookup
+	dispatcher.httpClient = server.Client()
+	return dispatcher
+}
+
+func TestWebhookRetriesThenDelivers(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	receiver := &flakyReceiver{failFirst: 2, now: func() time.Time { return clock }}
+	server := httptest.NewTLSServer(receiver)
+	defer server.Close()
+	dispatcher := newTestDispatcher(&clock, server)
+
+	webhook, err := dispatcher.RegisterWebhook(webhookOwner, server.URL, []string{"action.completed"})
+	require.NoError(t, err)
+	receiver.secret = webhook.Secret
+
+	require.NoError(t, dispatcher.Dispatch(WebhookEvent{Type: "action.completed", Owner: webhookOwner, Payload: map[string]string{"id": "a1"}}))
+	// Events for other types or owners are not enqueued
+	require.NoError(t, dispatcher.Dispatch(WebhookEvent{Type: "alert.fired", Owner: webhookOwner}))
+	require.NoError(t, dispatcher.Dispatch(WebhookEvent{Type: "action.completed", Owner: "0xsomeoneelse"}))
+	assert.Equal(t, 1, dispatcher.PendingDeliveries())
+
+	ctx := context.Background()
+	assert.Equal(t, 1, dispatcher.ProcessDue(ctx))
+
+	deliveries, ok := dispatcher.DeliveryLog(webhookOwner, webhook.ID)
+	require.True(t, ok)
+	require.Len(t, deliveries, 1)
+	assert.False(t, deliveries[0].Delivered)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].StatusCode)
+	assert.Equal(t, clock.Add(30*time.Second), deliveries[0].NextAttempt)
+
+	// Not due yet
+	assert.Equal(t, 0, dispatcher.ProcessDue(ctx))
+
+	clock = clock.Add(30 * time.Second)
+	assert.Equal(t, 1, dispatcher.ProcessDue(ctx))
+	deliveries, _ = dispatcher.DeliveryLog(webhookOwner, webhook.ID)
+	assert.Equal(t, clock.Add(60*time.Second), deliveries[0].NextAttempt, "backoff doubles")
+
+	clock = clock.Add(60 * time.Second)
+	assert.Equal(t, 1, dispatcher.ProcessDue(ctx))
+	deliveries, _ = dispatcher.DeliveryLog(webhookOwner, webhook.ID)
+	assert.True(t, deliveries[0].Delivered)
+	assert.Equal(t, 3, deliveries[0].Attempt)
+	assert.Equal(t, 0, dispatcher.PendingDeliveries())
+
+	assert.Equal(t, 3, receiver.received)
+	assert.Empty(t, receiver.sigErrors)
+
+	hooks := dispatcher.ListWebhooks(webhookOwner)
+	require.Len(t, hooks, 1)
+	assert.Equal(t, 0, hooks[0].ConsecutiveFailures)
+	assert.Empty(t, hooks[0].Secret)
+}
+
+func TestWebhookAutoDisable(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	receiver := &flakyReceiver{failFirst: 1 << 30, now: func() time.Time { return clock }}
+	server := httptest.NewTLSServer(receiver)
+	defer server.Close()
+	dispatcher := newTestDispatcher(&clock, server)
+
+	webhook, err := dispatcher.RegisterWebhook(webhookOwner, server.URL, []string{"*"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for i := 0; i < webhookDisableAfter; i++ {
+		require.NoError(t, dispatcher.Dispatch(WebhookEvent{Type: "alert.fired", Owner: webhookOwner}))
+		dispatcher.ProcessDue(ctx)
+		clock = clock.Add(webhookMaxBackoff)
+	}
+
+	hook, ok := dispatcher.GetWebhook(webhookOwner, webhook.ID)
+	require.True(t, ok)
+	assert.True(t, hook.Disabled)
+	assert.Equal(t, 0, dispatcher.PendingDeliveries())
+
+	// Disabled webhooks no longer receive events
+	require.NoError(t, dispatcher.Dispatch(WebhookEvent{Type: "alert.fired", Owner: webhookOwner}))
+	assert.Equal(t, 0, dispatcher.PendingDeliveries())
+}
+
+func TestWebhookBackoffCapped(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookBackoff(1))
+	assert.Equal(t, 4*time.Minute, webhookBackoff(4))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(20))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(100))
+}
+
+func TestWebhookSignatureVerification(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"alert.fired"}`)
+	header := SignWebhookPayload("secret", now, body)
+
+	assert.NoError(t, VerifyWebhookSignature("secret", header, body, time.Minute, now))
+	assert.Error(t, VerifyWebhookSignature("other", header, body, time.Minute, now))
+	assert.Error(t, VerifyWebhookSignature("secret", header, []byte(`{"type":"tampered"}`), time.Minute, now))
+	// Replays outside the tolerance window are rejected
+	assert.Error(t, VerifyWebhookSignature("secret", header, body, time.Minute, now.Add(10*time.Minute)))
+	assert.Error(t, VerifyWebhookSignature("secret", "garbage", body, time.Minute, now))
+}
+
+func TestWebhookTargetsMustBePublic(t *testing.T) {
+	dispatcher := NewWebhookDispatcher(1)
+	for _, target := range []string{
+		"http://hooks.example/notify",
+		"ftp://hooks.example/notify",
+		"https://127.0.0.1/notify",
+		"https://[::1]/notify",
+		"https://10.1.2.3/notify",
+		"https://192.168.0.10/notify",
+		"https://169.254.169.254/latest/meta-data",
+		"https://0.0.0.0/notify",
+		"https://100.64.0.1/notify",
+		"https://198.18.0.1/notify",
+		"https://192.0.0.8/notify",
+		"https://224.0.0.1/notify",
+		"https://255.255.255.255/notify",
+		"https://[::ffff:10.0.0.1]/notify",
+		"https://[64:ff9b::a00:1]/notify",
+		"https://[2002:a00:1::1]/notify",
+		"https://[fd00::1]/notify",
+	} {
+		_, err := dispatcher.RegisterWebhook(webhookOwner, target, []string{"*"})
+		assert.Error(t, err, target)
+	}
+
+	// Hosts resolving to an internal address are refused too
+	dispatcher.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("172.16.0.1")}, nil
+	}
+	_, err := dispatcher.RegisterWebhook(webhookOwner, "https://hooks.example/notify", []string{"*"})
+	assert.Error(t, err)
+	assert.Empty(t, dispatcher.ListWebhooks(webhookOwner))
+}
+
+func TestWebhooksCappedPerOwner(t *testing.T) {
+	dispatcher := NewWebhookDispatcher(1)
+	dispatcher.lookupIP = publicLookup
+	for i := 0; i < MaxWebhooksPerOwner; i++ {
+		_, err := dispatcher.RegisterWebhook(webhookOwner, "https://hooks.example/notify", []string{"*"})
+		require.NoError(t, err)
+	}
+	_, err := dispatcher.RegisterWebhook(webhookOwner, "https://hooks.example/notify", []string{"*"})
+	assert.ErrorIs(t, err, ErrWebhookLimit)
+
+	// Other owners aren't affected
+	_, err = dispatcher.RegisterWebhook("0x00000000000000000000000000000000000000bb", "https://hooks.example/notify", []string{"*"})
+	assert.NoError(t, err)
+}
+
+func TestWebhookDeliveryRefusesInternalAddresses(t *testing.T) {
+	clock := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	receiver := &flakyReceiver{now: func() time.Time { return clock }}
+	server := httptest.NewTLSServer(receiver)
+	defer server.Close()
+
+	// The host passed registration, then came to resolve to loopback
+	dispatcher := NewWebhookDispatcher(1)
+	dispatcher.now = func() time.Time { return clock }
+	dispatcher.lookupIP = publicLookup
+	webhook, err := dispatcher.RegisterWebhook(webhookOwner, server.URL, []string{"*"})
+	require.NoError(t, err)
+
+	require.NoError(t, dispatcher.Dispatch(WebhookEvent{Type: "alert.fired", Owner: webhookOwner}))
+	assert.Equal(t, 1, dispatcher.ProcessDue(context.Background()))
+	deliveries, ok := dispatcher.DeliveryLog(webhookOwner, webhook.ID)
+	require.True(t, ok)
+	require.Len(t, deliveries, 1)
+	assert.False(t, deliveries[0].Delivered)
+	assert.Contains(t, deliveries[0].Error, "internal")
+	assert.Equal(t, 0, receiver.received)
+}
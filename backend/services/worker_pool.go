@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+const (
+	// DefaultPoolTargetWait is how long a task may wait for a worker before
+	// its pool grows
+	DefaultPoolTargetWait = 500 * time.Millisecond
+	// DefaultPoolIdleAfter is how long a pool must stay under half used
+	// before it shrinks
+	DefaultPoolIdleAfter = 2 * time.Minute
+
+	// poolEvalInterval is how often pools are resized
+	poolEvalInterval = time.Second
+	// poolShrinkUtilization is the share of busy workers under which a pool
+	// counts as idle. Pools grow only on waiting tasks, so the gap between
+	// the two keeps them from flapping.
+	poolShrinkUtilization = 0.5
+	// maxPoolResizes bounds the resize events kept per pool
+	maxPoolResizes = 50
+)
+
+// DefaultAnalyticsPoolBounds are the sizes of the analytics pool when none are configured
+var DefaultAnalyticsPoolBounds = PoolBounds{Min: 10, Max: 50}
+
+// PoolBounds are the sizes a worker pool is resized between
+type PoolBounds struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// Validate checks that the bounds admit work
+func (b PoolBounds) Validate() error {
+	if b.Min < 1 || b.Max < b.Min {
+		return fmt.Errorf("pool bounds need 1 <= min <= max, got %d-%d", b.Min, b.Max)
+	}
+	return nil
+}
+
+// PoolResize records a change of a pool's size
+type PoolResize struct {
+	At     APITime `json:"at"`
+	From   int     `json:"from"`
+	To     int     `json:"to"`
+	Reason string  `json:"reason"`
+}
+
+// AdaptivePoolStats is a snapshot of an adaptive pool
+type AdaptivePoolStats struct {
+	Pool    string       `json:"pool"`
+	Size    int          `json:"size"`
+	Bounds  PoolBounds   `json:"bounds"`
+	Busy    int          `json:"busy"`
+	Waiting int          `json:"waiting"`
+	Grows   uint64       `json:"grows"`
+	Shrinks uint64       `json:"shrinks"`
+	Resizes []PoolResize `json:"resizes"`
+}
+
+// AdaptivePool is an ants pool sized between bounds by how long tasks wait
+// for a worker. It grows when a task waits past the target, and shrinks by
+// half after staying under half used for the idle period. Shrinking lowers
+// the capacity only, so running tasks finish and their workers retire.
+type AdaptivePool struct {
+	name       string
+	pool       *ants.Pool
+	targetWait time.Duration
+	idleAfter  time.Duration
+	logger     *log.Logger
+	now        func() time.Time
+
+	mu         sync.Mutex
+	bounds     PoolBounds
+	pending    map[uint64]time.Time // submission times of tasks waiting for a worker
+	nextTask   uint64
+	busy       int
+	windowWait time.Duration // longest wait of the tasks started since the last evaluation
+	idleSince  time.Time
+	resizes    []PoolResize
+	grows      uint64
+	shrinks    uint64
+}
+
+// NewAdaptivePool creates a pool of bounds.Min workers
+func NewAdaptivePool(name string, bounds PoolBounds) (*AdaptivePool, error) {
+	if err := bounds.Validate(); err != nil {
+		return nil, err
+	}
+	pool, err := ants.NewPool(bounds.Min)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s worker pool: %w", name, err)
+	}
+
+	return &AdaptivePool{
+		name:       name,
+		pool:       pool,
+		targetWait: DefaultPoolTargetWait,
+		idleAfter:  DefaultPoolIdleAfter,
+		logger:     log.New(log.Writer(), "[AdaptivePool] ", log.LstdFlags),
+		now:        utcNow,
+		bounds:     bounds,
+		pending:    make(map[uint64]time.Time),
+	}, nil
+}
+
+// SetBounds changes the bounds, resizing the pool into them
+func (ap *AdaptivePool) SetBounds(bounds PoolBounds) error {
+	if err := bounds.Validate(); err != nil {
+		return err
+	}
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	ap.bounds = bounds
+	if size := ap.pool.Cap(); size < bounds.Min {
+		ap.resize(size, bounds.Min, "raised to the minimum")
+	} else if size > bounds.Max {
+		ap.resize(size, bounds.Max, "lowered to the maximum")
+	}
+	return nil
+}
+
+// Submit runs a task on the pool, waiting for a free worker
+func (ap *AdaptivePool) Submit(task func()) error {
+	ap.mu.Lock()
+	id := ap.nextTask
+	ap.nextTask++
+	ap.pending[id] = ap.now()
+	ap.mu.Unlock()
+
+	err := ap.pool.Submit(func() {
+		ap.started(id)
+		defer ap.finished()
+		task()
+	})
+	if err != nil {
+		ap.mu.Lock()
+		delete(ap.pending, id)
+		ap.mu.Unlock()
+	}
+	return err
+}
+
+// started records how long a task waited for its worker
+func (ap *AdaptivePool) started(id uint64) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if wait := ap.now().Sub(ap.pending[id]); wait > ap.windowWait {
+		ap.windowWait = wait
+	}
+	delete(ap.pending, id)
+	ap.busy++
+}
+
+// finished records the end of a task
+func (ap *AdaptivePool) finished() {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	ap.busy--
+}
+
+// Start resizes the pool every poolEvalInterval until ctx is cancelled
+func (ap *AdaptivePool) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(poolEvalInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ap.Evaluate()
+			}
+		}
+	}()
+}
+
+// Evaluate resizes the pool from the waits and utilization seen since the
+// last evaluation
+func (ap *AdaptivePool) Evaluate() {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	now := ap.now()
+	wait := ap.windowWait
+	for _, submitted := range ap.pending {
+		if waited := now.Sub(submitted); waited > wait {
+			wait = waited
+		}
+	}
+	ap.windowWait = 0
+
+	size := ap.pool.Cap()
+	switch {
+	case wait > ap.targetWait:
+		ap.idleSince = time.Time{}
+		if size < ap.bounds.Max {
+			ap.resize(size, min(size*2, ap.bounds.Max), fmt.Sprintf("tasks waited %s for a worker", wait.Round(time.Millisecond)))
+		}
+	case len(ap.pending) == 0 && float64(ap.busy) < float64(size)*poolShrinkUtilization:
+		if ap.idleSince.IsZero() {
+			ap.idleSince = now
+			return
+		}
+		if now.Sub(ap.idleSince) >= ap.idleAfter && size > ap.bounds.Min {
+			// Each halving needs another idle period
+			ap.idleSince = now
+			ap.resize(size, max(size/2, ap.bounds.Min), fmt.Sprintf("under half used for %s", ap.idleAfter))
+		}
+	default:
+		ap.idleSince = time.Time{}
+	}
+}
+
+// resize tunes the pool and records the event. Callers must hold ap.mu.
+func (ap *AdaptivePool) resize(from, to int, reason string) {
+	ap.pool.Tune(to)
+	if to > from {
+		ap.grows++
+	} else {
+		ap.shrinks++
+	}
+	ap.resizes = append(ap.resizes, PoolResize{At: NewAPITime(ap.now()), From: from, To: to, Reason: reason})
+	if len(ap.resizes) > maxPoolResizes {
+		ap.resizes = ap.resizes[len(ap.resizes)-maxPoolResizes:]
+	}
+	ap.logger.Printf("Resized %s pool from %d to %d workers: %s", ap.name, from, to, reason)
+}
+
+// Running returns the number of live workers, idle ones included until they expire
+func (ap *AdaptivePool) Running() int {
+	return ap.pool.Running()
+}
+
+// Free returns the number of workers that can take a task
+func (ap *AdaptivePool) Free() int {
+	return ap.pool.Free()
+}
+
+// Cap returns the current size of the pool
+func (ap *AdaptivePool) Cap() int {
+	return ap.pool.Cap()
+}
+
+// Stats returns the pool's size, load, and recent resizes
+func (ap *AdaptivePool) Stats() AdaptivePoolStats {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	return AdaptivePoolStats{
+		Pool:    ap.name,
+		Size:    ap.pool.Cap(),
+		Bounds:  ap.bounds,
+		Busy:    ap.busy,
+		Waiting: len(ap.pending),
+		Grows:   ap.grows,
+		Shrinks: ap.shrinks,
+		Resizes: append([]PoolResize{}, ap.resizes...),
+	}
+}
+
+// WritePrometheus exposes the pool's size, load, and resizes
+func (ap *AdaptivePool) WritePrometheus(pw *PromWriter) {
+	stats := ap.Stats()
+	labels := map[string]string{"pool": stats.Pool}
+	pw.Gauge("kaia_worker_pool_size", "Current size of each worker pool.", float64(stats.Size), labels)
+	pw.Gauge("kaia_worker_pool_busy", "Workers running a task in each worker pool.", float64(stats.Busy), labels)
+	pw.Gauge("kaia_worker_pool_waiting", "Tasks waiting for a worker in each worker pool.", float64(stats.Waiting), labels)
+	pw.Counter("kaia_worker_pool_resizes_total", "Worker pool resizes by direction.", float64(stats.Grows), map[string]string{"pool": stats.Pool, "direction": "grow"})
+	pw.Counter("kaia_worker_pool_resizes_total", "Worker pool resizes by direction.", float64(stats.Shrinks), map[string]string{"pool": stats.Pool, "direction": "shrink"})
+}
+
+// Release closes the pool
+func (ap *AdaptivePool) Release() {
+	ap.pool.Release()
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptivePoolGrowsOnWaitAndShrinksWhenIdle(t *testing.T) {
+	pool, err := NewAdaptivePool("test", PoolBounds{Min: 1, Max: 4})
+	require.NoError(t, err)
+	defer pool.Release()
+	pool.targetWait = 10 * time.Millisecond
+	pool.idleAfter = 0
+
+	release := make(chan struct{})
+	var started, completed atomic.Int32
+	var submitted sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		submitted.Add(1)
+		go func() {
+			defer submitted.Done()
+			assert.NoError(t, pool.Submit(func() {
+				started.Add(1)
+				<-release
+				completed.Add(1)
+			}))
+		}()
+	}
+
+	// Tasks queue behind the single worker until their wait passes the target
+	require.Eventually(t, func() bool { return pool.Stats().Waiting == 7 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	pool.Evaluate()
+	assert.Equal(t, 2, pool.Cap())
+	require.Eventually(t, func() bool { return started.Load() == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	pool.Evaluate()
+	assert.Equal(t, 4, pool.Cap())
+	require.Eventually(t, func() bool { return started.Load() == 4 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	pool.Evaluate()
+	assert.Equal(t, 4, pool.Cap(), "the pool stays within its maximum")
+
+	// Let the queue drain; the pool stays at its size while it is busy
+	close(release)
+	submitted.Wait()
+	require.Eventually(t, func() bool { return completed.Load() == 8 }, time.Second, time.Millisecond)
+	pool.Evaluate() // the drained tasks waited past the target
+
+	// The first idle evaluation only starts the idle period
+	pool.Evaluate()
+	assert.Equal(t, 4, pool.Cap())
+	pool.Evaluate()
+	assert.Equal(t, 2, pool.Cap())
+	pool.Evaluate()
+	assert.Equal(t, 1, pool.Cap())
+	pool.Evaluate()
+	assert.Equal(t, 1, pool.Cap(), "the pool stays within its minimum")
+
+	stats := pool.Stats()
+	assert.Equal(t, uint64(2), stats.Grows)
+	assert.Equal(t, uint64(2), stats.Shrinks)
+	assert.Zero(t, stats.Busy)
+	assert.Zero(t, stats.Waiting)
+	require.Len(t, stats.Resizes, 4)
+	assert.Equal(t, PoolResize{At: stats.Resizes[3].At, From: 2, To: 1, Reason: "under half used for 0s"}, stats.Resizes[3])
+}
+
+func TestAdaptivePoolShrinkLetsRunningTasksFinish(t *testing.T) {
+	pool, err := NewAdaptivePool("test", PoolBounds{Min: 1, Max: 4})
+	require.NoError(t, err)
+	defer pool.Release()
+	require.NoError(t, pool.SetBounds(PoolBounds{Min: 4, Max: 4}))
+	assert.Equal(t, 4, pool.Cap())
+
+	release := make(chan struct{})
+	var completed atomic.Int32
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pool.Submit(func() {
+			<-release
+			completed.Add(1)
+		}))
+	}
+	require.Eventually(t, func() bool { return pool.Stats().Busy == 3 }, time.Second, time.Millisecond)
+
+	// Lowering the maximum below the running tasks does not interrupt them
+	require.NoError(t, pool.SetBounds(PoolBounds{Min: 1, Max: 1}))
+	assert.Equal(t, 1, pool.Cap())
+	close(release)
+	require.Eventually(t, func() bool { return completed.Load() == 3 }, time.Second, time.Millisecond)
+
+	assert.Error(t, pool.SetBounds(PoolBounds{Min: 2, Max: 1}))
+	_, err = NewAdaptivePool("test", PoolBounds{})
+	assert.Error(t, err)
+}
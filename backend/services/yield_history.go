@@ -0,0 +1,283 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// SparklinePoints caps the points of each downsampled history series
+	SparklinePoints = 100
+	// YieldVolatilityThreshold is the APY standard deviation, in percentage
+	// points, above which a pool's recommendation is penalized
+	YieldVolatilityThreshold = 2.0
+
+	yieldTrendWindow      = 7 * 24 * time.Hour
+	yieldSampleInterval   = time.Minute
+	yieldVolatilityFactor = 0.75
+)
+
+// YieldWindows are the history windows the yield endpoints accept
+var YieldWindows = map[string]time.Duration{
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// YieldSample is the APY and TVL of a pool seen by one yield scan
+type YieldSample struct {
+	Timestamp time.Time
+	APY       float64
+	TVL       float64
+}
+
+// YieldTrend summarizes a pool's recent yield history
+type YieldTrend struct {
+	// APY7dAvg is the mean APY over the last 7 days
+	APY7dAvg float64
+	// APYVolatility is the standard deviation of the APY over the last 7 days,
+	// in percentage points
+	APYVolatility float64
+	// TVLTrend is the percentage change of the TVL over the last 7 days
+	TVLTrend float64
+	// TVLDrawdown is how far the TVL is below its 7 day peak, in percent
+	TVLDrawdown float64
+}
+
+// YieldSeries is the downsampled history of a pool for sparklines
+type YieldSeries struct {
+	Window string        `json:"window"`
+	APY    []SeriesPoint `json:"apy"`
+	TVL    []SeriesPoint `json:"tvl"`
+}
+
+// YieldHistory keeps the APY and TVL of every pool seen by yield scans.
+// Samples compacted by the retention engine are kept as hourly averages.
+type YieldHistory struct {
+	mu        sync.RWMutex
+	samples   map[string][]YieldSample
+	hourlyAPY map[string][]SeriesAggregate
+	hourlyTVL map[string][]SeriesAggregate
+
+	now func() time.Time
+}
+
+// NewYieldHistory creates an empty yield history
+func NewYieldHistory() *YieldHistory {
+	return &YieldHistory{
+		samples:   make(map[string][]YieldSample),
+		hourlyAPY: make(map[string][]SeriesAggregate),
+		hourlyTVL: make(map[string][]SeriesAggregate),
+		now:       utcNow,
+	}
+}
+
+// yieldPool identifies a pool by protocol and asset pair
+func yieldPool(protocol, assetPair string) string {
+	return protocol + "|" + assetPair
+}
+
+// Record stores the result of a yield scan. Scans of a pool less than a
+// minute after its last sample replace that sample, so frequent requests
+// don't grow the history.
+func (h *YieldHistory) Record(opportunities []YieldOpportunity, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, opportunity := range opportunities {
+		// A NaN or infinite rate from a bad read would poison the pool's trend
+		if !isFinite(opportunity.APY) || !isFinite(opportunity.TVL) {
+			continue
+		}
+		pool := yieldPool(opportunity.Protocol, opportunity.AssetPair)
+		sample := YieldSample{Timestamp: at, APY: opportunity.APY, TVL: opportunity.TVL}
+
+		samples := h.samples[pool]
+		if n := len(samples); n > 0 && at.Sub(samples[n-1].Timestamp) < yieldSampleInterval {
+			if !at.Before(samples[n-1].Timestamp) {
+				samples[n-1] = sample
+			}
+			continue
+		}
+		h.samples[pool] = append(samples, sample)
+	}
+}
+
+// CompactBefore folds at most limit samples taken before cutoff, truncated
+// to the hour, into hourly averages
+func (h *YieldHistory) CompactBefore(cutoff time.Time, limit int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff = cutoff.UTC().Truncate(time.Hour)
+	pools := make([]string, 0, len(h.samples))
+	for pool := range h.samples {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+
+	compacted := 0
+	for _, pool := range pools {
+		samples := h.samples[pool]
+		n := sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(cutoff) })
+		n = min(n, limit-compacted)
+		if n == 0 {
+			continue
+		}
+		apy := make([]SeriesPoint, n)
+		tvl := make([]SeriesPoint, n)
+		for i, sample := range samples[:n] {
+			apy[i] = SeriesPoint{Timestamp: sample.Timestamp, Value: sample.APY}
+			tvl[i] = SeriesPoint{Timestamp: sample.Timestamp, Value: sample.TVL}
+		}
+		h.hourlyAPY[pool] = foldHourly(h.hourlyAPY[pool], apy)
+		h.hourlyTVL[pool] = foldHourly(h.hourlyTVL[pool], tvl)
+		h.samples[pool] = append([]YieldSample(nil), samples[n:]...)
+		compacted += n
+		if compacted == limit {
+			break
+		}
+	}
+	return compacted
+}
+
+// Samples returns the samples of a pool since a time, oldest first. Hours
+// compacted by the retention engine appear as one sample each, averaging
+// the hour's scans.
+func (h *YieldHistory) Samples(protocol, assetPair string, since time.Time) []YieldSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	pool := yieldPool(protocol, assetPair)
+	apy, tvl := h.hourlyAPY[pool], h.hourlyTVL[pool]
+	samples := h.samples[pool]
+	first := sort.Search(len(apy), func(i int) bool { return !apy[i].Start.Before(since) })
+	start := sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(since) })
+
+	stitched := make([]YieldSample, 0, len(apy)-first+len(samples)-start)
+	for i := first; i < len(apy); i++ {
+		stitched = append(stitched, YieldSample{Timestamp: apy[i].Start, APY: apy[i].Mean(), TVL: tvl[i].Mean()})
+	}
+	return append(stitched, samples[start:]...)
+}
+
+// Trend computes the 7 day average, volatility, and TVL change of a pool.
+// It reports false when the pool has no samples in the last 7 days.
+func (h *YieldHistory) Trend(protocol, assetPair string) (YieldTrend, bool) {
+	samples := h.Samples(protocol, assetPair, h.now().Add(-yieldTrendWindow))
+	if len(samples) == 0 {
+		return YieldTrend{}, false
+	}
+
+	apy := make([]float64, len(samples))
+	for i, sample := range samples {
+		apy[i] = sample.APY
+	}
+	// Samples are finite and there is at least one, so MeanStdDev can't fail
+	mean, stdDev, _ := MeanStdDev(apy)
+
+	trend := YieldTrend{APY7dAvg: mean, APYVolatility: stdDev}
+	if first, last := samples[0].TVL, samples[len(samples)-1].TVL; first > 0 {
+		trend.TVLTrend = (last - first) / first * 100
+	}
+	peak := 0.0
+	for _, sample := range samples {
+		peak = math.Max(peak, sample.TVL)
+	}
+	if last := samples[len(samples)-1].TVL; peak > 0 {
+		trend.TVLDrawdown = (peak - last) / peak * 100
+	}
+	return trend, true
+}
+
+// Series returns the history of a pool over one of YieldWindows, downsampled
+// to at most SparklinePoints per series
+func (h *YieldHistory) Series(protocol, assetPair, window string) (*YieldSeries, error) {
+	duration, ok := YieldWindows[window]
+	if !ok {
+		return nil, fmt.Errorf("unsupported history window %q", window)
+	}
+
+	samples := h.Samples(protocol, assetPair, h.now().Add(-duration))
+	apy := make([]SeriesPoint, len(samples))
+	tvl := make([]SeriesPoint, len(samples))
+	for i, sample := range samples {
+		apy[i] = SeriesPoint{Timestamp: sample.Timestamp, Value: sample.APY}
+		tvl[i] = SeriesPoint{Timestamp: sample.Timestamp, Value: sample.TVL}
+	}
+
+	return &YieldSeries{
+		Window: window,
+		APY:    Downsample(apy, SparklinePoints),
+		TVL:    Downsample(tvl, SparklinePoints),
+	}, nil
+}
+
+// Downsample reduces a series to at most threshold points, which must be at
+// least 3, with Largest-Triangle-Three-Buckets. It keeps the first and last
+// points and from each bucket in between the point that best preserves the shape.
+func Downsample(points []SeriesPoint, threshold int) []SeriesPoint {
+	if threshold < 3 || len(points) <= threshold {
+		return append([]SeriesPoint(nil), points...)
+	}
+
+	x := func(point SeriesPoint) float64 {
+		return float64(point.Timestamp.UnixNano()) / float64(time.Second)
+	}
+
+	sampled := make([]SeriesPoint, 0, threshold)
+	sampled = append(sampled, points[0])
+
+	// The points between the first and last are split into threshold-2 buckets
+	bucketSize := float64(len(points)-2) / float64(threshold-2)
+	selected := 0
+	for bucket := 0; bucket < threshold-2; bucket++ {
+		start := int(float64(bucket)*bucketSize) + 1
+		end := int(float64(bucket+1)*bucketSize) + 1
+
+		// The next bucket's average is the third corner of the triangle
+		nextStart, nextEnd := end, min(int(float64(bucket+2)*bucketSize)+1, len(points))
+		if bucket == threshold-3 {
+			nextStart, nextEnd = len(points)-1, len(points)
+		}
+		var avgX, avgY float64
+		for _, point := range points[nextStart:nextEnd] {
+			avgX += x(point)
+			avgY += point.Value
+		}
+		avgX /= float64(nextEnd - nextStart)
+		avgY /= float64(nextEnd - nextStart)
+
+		ax, ay := x(points[selected]), points[selected].Value
+		best, bestArea := start, -1.0
+		for i := start; i < end; i++ {
+			area := (ax-avgX)*(points[i].Value-ay) - (ax-x(points[i]))*(avgY-ay)
+			if area < 0 {
+				area = -area
+			}
+			if area > bestArea {
+				best, bestArea = i, area
+			}
+		}
+
+		sampled = append(sampled, points[best])
+		selected = best
+	}
+
+	return append(sampled, points[len(points)-1])
+}
+
+// applyYieldTrend fills in the trend fields of an opportunity and penalizes
+// the opportunity score of pools whose APY is volatile
+func applyYieldTrend(opportunity *YieldOpportunity, trend YieldTrend) {
+	opportunity.APY7dAvg = trend.APY7dAvg
+	opportunity.APYVolatility = trend.APYVolatility
+	opportunity.TVLTrend = trend.TVLTrend
+
+	if trend.APYVolatility > YieldVolatilityThreshold {
+		opportunity.Volatile = true
+		opportunity.Opportunity *= yieldVolatilityFactor
+	}
+}
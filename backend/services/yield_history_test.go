@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestYieldHistoryTrendAndSparklines(t *testing.T) {
+	end := time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)
+	history := NewYieldHistory()
+	history.now = func() time.Time { return end }
+
+	// 30 days of scans every 10 minutes. The APY is flat at 10% apart from a
+	// spike on day 5, then alternates between 7% and 13% for the last week.
+	const samples = 30 * 144
+	last := end.Add(-5 * time.Minute)
+	for i := 0; i < samples; i++ {
+		at := last.Add(-time.Duration(samples-1-i) * 10 * time.Minute)
+		apy := 10.0
+		switch {
+		case i == 5*144:
+			apy = 50
+		case at.After(end.Add(-7 * 24 * time.Hour)):
+			apy = 7 + 6*float64(i%2)
+		}
+		history.Record([]YieldOpportunity{{Protocol: "Klayswap", AssetPair: "KAIA/USDT", APY: apy, TVL: 1e6 + 100*float64(i)}}, at)
+	}
+	require.Len(t, history.Samples("Klayswap", "KAIA/USDT", time.Time{}), samples)
+
+	trend, ok := history.Trend("Klayswap", "KAIA/USDT")
+	require.True(t, ok)
+	assert.InDelta(t, 10, trend.APY7dAvg, 1e-9)
+	assert.InDelta(t, 3, trend.APYVolatility, 1e-9)
+	// 1008 scans fall in the last week
+	first := 1e6 + 100*float64(samples-1008)
+	assert.InDelta(t, 100*1007/first*100, trend.TVLTrend, 1e-9)
+
+	series, err := history.Series("Klayswap", "KAIA/USDT", "30d")
+	require.NoError(t, err)
+	assert.Equal(t, "30d", series.Window)
+	require.Len(t, series.APY, SparklinePoints)
+	require.Len(t, series.TVL, SparklinePoints)
+	assert.Equal(t, end.Add(-30*24*time.Hour).Add(5*time.Minute), series.APY[0].Timestamp)
+	assert.Equal(t, last, series.APY[SparklinePoints-1].Timestamp)
+	assert.Contains(t, series.APY, SeriesPoint{Timestamp: series.APY[0].Timestamp.Add(5 * 24 * time.Hour), Value: 50},
+		"the spike survives downsampling")
+	for i := 1; i < len(series.APY); i++ {
+		assert.True(t, series.APY[i].Timestamp.After(series.APY[i-1].Timestamp))
+	}
+
+	series, err = history.Series("Klayswap", "KAIA/USDT", "7d")
+	require.NoError(t, err)
+	assert.Len(t, series.APY, SparklinePoints)
+	assert.False(t, series.APY[0].Timestamp.Before(end.Add(-7*24*time.Hour)))
+
+	_, err = history.Series("Klayswap", "KAIA/USDT", "90d")
+	assert.Error(t, err)
+
+	// Short series are returned as they are
+	assert.Len(t, Downsample(series.APY[:10], SparklinePoints), 10)
+}
+
+func TestYieldHistoryCoalescesFrequentScans(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	history := NewYieldHistory()
+	history.now = func() time.Time { return now }
+
+	pool := []YieldOpportunity{{Protocol: "Klayswap", AssetPair: "KAIA/USDT", APY: 10}}
+	history.Record(pool, now)
+	pool[0].APY = 11
+	history.Record(pool, now.Add(30*time.Second))
+	pool[0].APY = 12
+	history.Record(pool, now.Add(2*time.Minute))
+
+	samples := history.Samples("Klayswap", "KAIA/USDT", time.Time{})
+	require.Len(t, samples, 2)
+	assert.Equal(t, 11.0, samples[0].APY)
+	assert.Equal(t, 12.0, samples[1].APY)
+
+	// Old samples are left to the retention engine rather than dropped as
+	// new scans arrive
+	now = now.Add(31 * 24 * time.Hour)
+	history.Record(pool, now)
+	assert.Len(t, history.Samples("Klayswap", "KAIA/USDT", time.Time{}), 3)
+}
+
+func TestYieldAnalysisPenalizesVolatilePools(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	// Uniswap's APY has swung between 2.5% and 22.5% over the last day
+	start := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < 24; i++ {
+		engine.YieldHistory().Record([]YieldOpportunity{{
+			Protocol: "Uniswap V3", AssetPair: "ETH/USDC", APY: 2.5 + 20*float64(i%2), TVL: 1500000,
+		}}, start.Add(time.Duration(i)*time.Hour))
+	}
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", map[string]interface{}{"history": "7d"})
+	require.NoError(t, err)
+	opportunities := result.Data.([]YieldOpportunity)
+	require.Len(t, opportunities, 3)
+
+	assert.Equal(t, "Aave V3", opportunities[0].Protocol)
+	uniswap := opportunities[2]
+	assert.Equal(t, "Uniswap V3", uniswap.Protocol)
+	assert.True(t, uniswap.Volatile)
+	assert.Greater(t, uniswap.APYVolatility, YieldVolatilityThreshold)
+	assert.InDelta(t, 0.85*0.75, uniswap.Opportunity, 1e-9)
+	require.NotNil(t, uniswap.History)
+	assert.Len(t, uniswap.History.APY, 25)
+
+	assert.False(t, opportunities[0].Volatile)
+	assert.Equal(t, 8.2, opportunities[0].APY7dAvg)
+	require.NotNil(t, opportunities[0].History)
+	assert.Len(t, opportunities[0].History.APY, 1)
+
+	_, err = engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", map[string]interface{}{"history": "1y"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Names of the factors of a yield pool's risk score
+const (
+	RiskFactorTVL           = "tvl"
+	RiskFactorAPY           = "apy"
+	RiskFactorAge           = "age"
+	RiskFactorAudit         = "audit"
+	RiskFactorConcentration = "concentration"
+	RiskFactorEmissions     = "emissions"
+	RiskFactorDrawdown      = "drawdown"
+)
+
+const (
+	// unknownFactorRisk is the risk of a factor nothing is known about:
+	// neither safe nor risky
+	unknownFactorRisk = 0.5
+	// matureProtocolAge is the age past which a protocol carries no age risk
+	matureProtocolAge = 2 * 365 * 24 * time.Hour
+	// riskTopDepositors is how many of a pool's largest depositors make up
+	// its concentration
+	riskTopDepositors = 5
+)
+
+// RiskWeights weighs the factors of a yield pool's risk score. Only their
+// ratios matter, as the score is the weighted mean of the factor risks.
+type RiskWeights struct {
+	TVL           float64 `json:"tvl"`
+	APY           float64 `json:"apy"`
+	Age           float64 `json:"age"`
+	Audit         float64 `json:"audit"`
+	Concentration float64 `json:"concentration"`
+	Emissions     float64 `json:"emissions"`
+	Drawdown      float64 `json:"drawdown"`
+}
+
+// DefaultRiskWeights returns the weights used when the registry sets none
+func DefaultRiskWeights() RiskWeights {
+	return RiskWeights{
+		TVL:           2,
+		APY:           1.5,
+		Age:           1.5,
+		Audit:         2,
+		Concentration: 1.5,
+		Emissions:     1,
+		Drawdown:      1.5,
+	}
+}
+
+// ProtocolPool is a pool of a protocol known to the registry
+type ProtocolPool struct {
+	AssetPair string `json:"asset_pair"`
+	// LPToken is the pool's deposit token, whose largest holders are its
+	// largest depositors
+	LPToken string `json:"lp_token,omitempty"`
+	// EmissionShare is the fraction of the pool's APY paid in reward-token
+	// emissions rather than trading fees or interest
+	EmissionShare *float64 `json:"emission_share,omitempty"`
+	// RewardContract is the contract the pool's live reward rate is read
+	// from before its opportunity is served
+	RewardContract string `json:"reward_contract,omitempty"`
+}
+
+// ProtocolInfo is what the registry knows about a protocol. Unset fields
+// are unknown and scored as neither safe nor risky.
+type ProtocolInfo struct {
+	Name string `json:"name"`
+	// FirstActivity is when the protocol's contracts were first used on chain
+	FirstActivity *time.Time     `json:"first_activity,omitempty"`
+	Audited       *bool          `json:"audited,omitempty"`
+	Pools         []ProtocolPool `json:"pools,omitempty"`
+}
+
+// Pool returns the registry entry of one of the protocol's pools, or nil
+func (p *ProtocolInfo) Pool(assetPair string) *ProtocolPool {
+	for i := range p.Pools {
+		if strings.EqualFold(p.Pools[i].AssetPair, assetPair) {
+			return &p.Pools[i]
+		}
+	}
+	return nil
+}
+
+// ProtocolRegistry holds the protocol facts and factor weights yield risk
+// is scored with
+type ProtocolRegistry struct {
+	Weights   RiskWeights    `json:"weights"`
+	Protocols []ProtocolInfo `json:"protocols"`
+}
+
+// DefaultProtocolRegistry returns the registry of the protocols yield scans cover
+func DefaultProtocolRegistry() *ProtocolRegistry {
+	since := func(year int, month time.Month, day int) *time.Time {
+		at := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		return &at
+	}
+	audited := true
+	return &ProtocolRegistry{
+		Weights: DefaultRiskWeights(),
+		Protocols: []ProtocolInfo{
+			{Name: "Uniswap V3", FirstActivity: since(2021, time.May, 4), Audited: &audited},
+			{Name: "Aave V3", FirstActivity: since(2022, time.March, 16), Audited: &audited},
+			{Name: "Compound V3", FirstActivity: since(2022, time.August, 26), Audited: &audited},
+		},
+	}
+}
+
+// LoadProtocolRegistry loads a registry from a JSON file. Weights it leaves
+// out keep their defaults.
+func LoadProtocolRegistry(path string) (*ProtocolRegistry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read protocol registry: %w", err)
+	}
+
+	registry := &ProtocolRegistry{Weights: DefaultRiskWeights()}
+	if err := json.Unmarshal(raw, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse protocol registry: %w", err)
+	}
+	if err := registry.validate(); err != nil {
+		return nil, fmt.Errorf("invalid protocol registry: %w", err)
+	}
+	return registry, nil
+}
+
+// validate checks that the weights can be averaged and the pool facts are in range
+func (r *ProtocolRegistry) validate() error {
+	w := r.Weights
+	weights := []float64{w.TVL, w.APY, w.Age, w.Audit, w.Concentration, w.Emissions, w.Drawdown}
+	total := 0.0
+	for _, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("weights must not be negative, got %g", weight)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one weight must be above 0")
+	}
+
+	for _, protocol := range r.Protocols {
+		if protocol.Name == "" {
+			return fmt.Errorf("protocols need a name")
+		}
+		for _, pool := range protocol.Pools {
+			if share := pool.EmissionShare; share != nil && (*share < 0 || *share > 1) {
+				return fmt.Errorf("emission_share of %s %s must be between 0 and 1, got %g", protocol.Name, pool.AssetPair, *share)
+			}
+			if pool.RewardContract != "" && !common.IsHexAddress(pool.RewardContract) {
+				return fmt.Errorf("reward_contract of %s %s must be an address, got %q", protocol.Name, pool.AssetPair, pool.RewardContract)
+			}
+		}
+	}
+	return nil
+}
+
+// Protocol returns the registry entry of a protocol, in any case, or nil
+func (r *ProtocolRegistry) Protocol(name string) *ProtocolInfo {
+	for i := range r.Protocols {
+		if strings.EqualFold(r.Protocols[i].Name, name) {
+			return &r.Protocols[i]
+		}
+	}
+	return nil
+}
+
+// RiskInputs are the facts a pool's risk is scored from. Nil fields are unknown.
+type RiskInputs struct {
+	TVL float64
+	// APY is in percent
+	APY     float64
+	Age     *time.Duration
+	Audited *bool
+	// TopDepositorShare is the fraction of the pool held by its 5 largest depositors
+	TopDepositorShare *float64
+	// EmissionShare is the fraction of the APY paid in reward-token emissions
+	EmissionShare *float64
+	// Drawdown is the TVL's drop from its 7 day peak, in percent
+	Drawdown float64
+}
+
+// RiskComponent is one factor's part of a risk score
+type RiskComponent struct {
+	Factor string `json:"factor"`
+	// Risk is from 0, safe, to 1
+	Risk   float64 `json:"risk"`
+	Weight float64 `json:"weight"`
+	// Points is what the factor adds to the 0-100 score
+	Points float64 `json:"points"`
+	Known  bool    `json:"known"`
+	Detail string  `json:"detail"`
+}
+
+// RiskAssessment is a pool's 0-100 risk score and the factors behind it
+type RiskAssessment struct {
+	Score      float64         `json:"score"`
+	Components []RiskComponent `json:"components"`
+}
+
+// ScoreYieldRisk scores a pool's risk as the weighted mean of its factor
+// risks, scaled to 0-100. Every factor's risk grows with what makes a pool
+// riskier, so the score never drops as a single factor worsens.
+func ScoreYieldRisk(inputs RiskInputs, weights RiskWeights) RiskAssessment {
+	components := []RiskComponent{
+		{Factor: RiskFactorTVL, Weight: weights.TVL, Known: true,
+			Risk:   clamp01(math.Log10(1e8/math.Max(inputs.TVL, 1)) / 3),
+			Detail: "$" + NumberFormat{}.Compact(inputs.TVL) + " TVL"},
+		{Factor: RiskFactorAPY, Weight: weights.APY, Known: true,
+			Risk:   clamp01(math.Log(math.Max(inputs.APY, 5)/5) / math.Log(20)),
+			Detail: fmt.Sprintf("%.1f%% APY", inputs.APY)},
+		{Factor: RiskFactorDrawdown, Weight: weights.Drawdown, Known: true,
+			Risk:   clamp01(inputs.Drawdown / 50),
+			Detail: fmt.Sprintf("TVL %.0f%% below its 7 day peak", inputs.Drawdown)},
+	}
+
+	age := RiskComponent{Factor: RiskFactorAge, Weight: weights.Age, Risk: unknownFactorRisk, Detail: "protocol age unknown"}
+	if inputs.Age != nil {
+		age.Known = true
+		age.Risk = clamp01(1 - inputs.Age.Hours()/matureProtocolAge.Hours())
+		age.Detail = fmt.Sprintf("protocol live for %.0f days", math.Max(inputs.Age.Hours()/24, 0))
+	}
+	audit := RiskComponent{Factor: RiskFactorAudit, Weight: weights.Audit, Risk: unknownFactorRisk, Detail: "audit status unknown"}
+	if inputs.Audited != nil {
+		audit.Known = true
+		audit.Risk, audit.Detail = 0, "audited"
+		if !*inputs.Audited {
+			audit.Risk, audit.Detail = 1, "not audited"
+		}
+	}
+	concentration := RiskComponent{Factor: RiskFactorConcentration, Weight: weights.Concentration, Risk: unknownFactorRisk, Detail: "depositor concentration unknown"}
+	if share := inputs.TopDepositorShare; share != nil {
+		concentration.Known = true
+		concentration.Risk = clamp01((*share - 0.2) / 0.6)
+		concentration.Detail = fmt.Sprintf("top %d depositors hold %.0f%% of TVL", riskTopDepositors, *share*100)
+	}
+	emissions := RiskComponent{Factor: RiskFactorEmissions, Weight: weights.Emissions, Risk: unknownFactorRisk, Detail: "emission share of yield unknown"}
+	if share := inputs.EmissionShare; share != nil {
+		emissions.Known = true
+		emissions.Risk = clamp01(*share)
+		emissions.Detail = fmt.Sprintf("%.0f%% of yield from reward emissions", *share*100)
+	}
+	components = append(components, age, audit, concentration, emissions)
+
+	total := 0.0
+	for _, component := range components {
+		total += component.Weight
+	}
+	assessment := RiskAssessment{Components: components}
+	if total == 0 {
+		return assessment
+	}
+	for i := range assessment.Components {
+		component := &assessment.Components[i]
+		component.Risk = roundTo(component.Risk, 3)
+		points := 100 * component.Weight * component.Risk / total
+		component.Points = roundTo(points, 1)
+		assessment.Score += points
+	}
+	assessment.Score = roundTo(assessment.Score, 1)
+	return assessment
+}
+
+// Drivers returns the known factors adding the most to the score, riskiest
+// first, leaving out those that are mostly safe
+func (a RiskAssessment) Drivers(n int) []RiskComponent {
+	var drivers []RiskComponent
+	for _, component := range a.Components {
+		if component.Known && component.Risk >= 0.5 {
+			drivers = append(drivers, component)
+		}
+	}
+	sort.SliceStable(drivers, func(i, j int) bool { return drivers[i].Points > drivers[j].Points })
+	return drivers[:min(n, len(drivers))]
+}
+
+// clamp01 limits value to the range 0 to 1
+func clamp01(value float64) float64 {
+	return math.Min(math.Max(value, 0), 1)
+}
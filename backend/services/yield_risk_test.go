@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// safeRiskInputs describe a pool with every factor known and safe
+func safeRiskInputs() RiskInputs {
+	age := 3 * 365 * 24 * time.Hour
+	audited := true
+	top, emissions := 0.1, 0.0
+	return RiskInputs{
+		TVL:               500_000_000,
+		APY:               4,
+		Age:               &age,
+		Audited:           &audited,
+		TopDepositorShare: &top,
+		EmissionShare:     &emissions,
+	}
+}
+
+func riskComponent(t *testing.T, assessment RiskAssessment, factor string) RiskComponent {
+	for _, component := range assessment.Components {
+		if component.Factor == factor {
+			return component
+		}
+	}
+	t.Fatalf("no %s component", factor)
+	return RiskComponent{}
+}
+
+func TestYieldRiskFactorsInIsolation(t *testing.T) {
+	weights := DefaultRiskWeights()
+	safe := ScoreYieldRisk(safeRiskInputs(), weights)
+	assert.Zero(t, safe.Score)
+	assert.Empty(t, safe.Drivers(3))
+
+	// Turning one factor fully risky adds exactly its weight's share of 100
+	total := weights.TVL + weights.APY + weights.Age + weights.Audit + weights.Concentration + weights.Emissions + weights.Drawdown
+	unaudited, young := false, time.Duration(0)
+	concentrated, emitted := 0.9, 1.0
+	cases := []struct {
+		factor string
+		weight float64
+		worsen func(*RiskInputs)
+	}{
+		{RiskFactorTVL, weights.TVL, func(in *RiskInputs) { in.TVL = 50_000 }},
+		{RiskFactorAPY, weights.APY, func(in *RiskInputs) { in.APY = 250 }},
+		{RiskFactorAge, weights.Age, func(in *RiskInputs) { in.Age = &young }},
+		{RiskFactorAudit, weights.Audit, func(in *RiskInputs) { in.Audited = &unaudited }},
+		{RiskFactorConcentration, weights.Concentration, func(in *RiskInputs) { in.TopDepositorShare = &concentrated }},
+		{RiskFactorEmissions, weights.Emissions, func(in *RiskInputs) { in.EmissionShare = &emitted }},
+		{RiskFactorDrawdown, weights.Drawdown, func(in *RiskInputs) { in.Drawdown = 60 }},
+	}
+	for _, tc := range cases {
+		inputs := safeRiskInputs()
+		tc.worsen(&inputs)
+		assessment := ScoreYieldRisk(inputs, weights)
+		assert.InDelta(t, 100*tc.weight/total, assessment.Score, 0.1, tc.factor)
+		assert.Equal(t, 1.0, riskComponent(t, assessment, tc.factor).Risk, tc.factor)
+
+		drivers := assessment.Drivers(3)
+		require.Len(t, drivers, 1, tc.factor)
+		assert.Equal(t, tc.factor, drivers[0].Factor)
+	}
+
+	// Unknown factors count as half risky and never drive the explanation
+	inputs := safeRiskInputs()
+	inputs.Audited = nil
+	assessment := ScoreYieldRisk(inputs, weights)
+	audit := riskComponent(t, assessment, RiskFactorAudit)
+	assert.False(t, audit.Known)
+	assert.Equal(t, unknownFactorRisk, audit.Risk)
+	assert.Empty(t, assessment.Drivers(3))
+}
+
+func TestYieldRiskIsMonotonic(t *testing.T) {
+	weights := DefaultRiskWeights()
+	steps := map[string]func(in *RiskInputs, step float64){
+		// Each step makes the factor worse
+		RiskFactorTVL: func(in *RiskInputs, step float64) { in.TVL = 1e9 / (1 + step*step*100) },
+		RiskFactorAPY: func(in *RiskInputs, step float64) { in.APY = 1 + step*5 },
+		RiskFactorAge: func(in *RiskInputs, step float64) {
+			age := time.Duration(1000-step*25) * 24 * time.Hour
+			in.Age = &age
+		},
+		RiskFactorConcentration: func(in *RiskInputs, step float64) {
+			share := step / 40
+			in.TopDepositorShare = &share
+		},
+		RiskFactorEmissions: func(in *RiskInputs, step float64) {
+			share := step / 40
+			in.EmissionShare = &share
+		},
+		RiskFactorDrawdown: func(in *RiskInputs, step float64) { in.Drawdown = step * 2 },
+	}
+	for factor, worsen := range steps {
+		previous := -1.0
+		for step := 0.0; step <= 40; step++ {
+			inputs := safeRiskInputs()
+			worsen(&inputs, step)
+			score := ScoreYieldRisk(inputs, weights).Score
+			assert.GreaterOrEqual(t, score, previous, "%s at step %g", factor, step)
+			previous = score
+		}
+		assert.Greater(t, previous, 0.0, factor)
+	}
+}
+
+func TestProtocolRegistryLoadsWeights(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protocols.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"weights": {"audit": 10},
+		"protocols": [{"name": "KlaySwap", "audited": false, "first_activity": "2024-01-01T00:00:00Z",
+			"pools": [{"asset_pair": "KAIA/USDT", "emission_share": 0.8}]}]
+	}`), 0o600))
+
+	registry, err := LoadProtocolRegistry(path)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, registry.Weights.Audit)
+	assert.Equal(t, DefaultRiskWeights().TVL, registry.Weights.TVL, "weights left out keep their defaults")
+	protocol := registry.Protocol("klayswap")
+	require.NotNil(t, protocol)
+	assert.False(t, *protocol.Audited)
+	assert.Equal(t, 0.8, *protocol.Pool("kaia/usdt").EmissionShare)
+	assert.Nil(t, protocol.Pool("ETH/USDT"))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"protocols": [{"name": "X", "pools": [{"asset_pair": "A/B", "emission_share": 1.5}]}]}`), 0o600))
+	_, err = LoadProtocolRegistry(path)
+	assert.ErrorContains(t, err, "emission_share of X A/B must be between 0 and 1")
+	require.NoError(t, os.WriteFile(path, []byte(`{"weights": {"tvl": -1}}`), 0o600))
+	_, err = LoadProtocolRegistry(path)
+	assert.ErrorContains(t, err, "weights must not be negative")
+}
+
+func TestYieldOpportunitiesCarryRiskBreakdown(t *testing.T) {
+	engine, err := NewAnalyticsEngine(nil)
+	require.NoError(t, err)
+	defer engine.Close()
+
+	result, err := engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", map[string]interface{}{})
+	require.NoError(t, err)
+	baseline := map[string]YieldOpportunity{}
+	for _, opportunity := range result.Data.([]YieldOpportunity) {
+		baseline[opportunity.Protocol] = opportunity
+		assert.Len(t, opportunity.RiskBreakdown, 7)
+		assert.Equal(t, roundTo(opportunity.RiskScore/100, 2), opportunity.Risk, "the old scalar stays populated")
+	}
+
+	// An unaudited, emission-funded Compound V3 scores riskier
+	unaudited, emitted := false, 0.9
+	registry := DefaultProtocolRegistry()
+	registry.Protocol("Compound V3").Audited = &unaudited
+	registry.Protocol("Compound V3").Pools = []ProtocolPool{{AssetPair: "DAI/USDC", EmissionShare: &emitted}}
+	engine.SetProtocolRegistry(registry)
+
+	result, err = engine.ProcessAnalyticsTask(context.Background(), "yield_analysis", map[string]interface{}{})
+	require.NoError(t, err)
+	for _, opportunity := range result.Data.([]YieldOpportunity) {
+		if opportunity.Protocol != "Compound V3" {
+			assert.Equal(t, baseline[opportunity.Protocol].RiskScore, opportunity.RiskScore, opportunity.Protocol)
+			continue
+		}
+		assert.Greater(t, opportunity.RiskScore, baseline["Compound V3"].RiskScore)
+		assert.Equal(t, " (not audited; $800,000 TVL)", riskReasons(opportunity))
+		assert.Equal(t, 0.9, riskComponent(t, RiskAssessment{Components: opportunity.RiskBreakdown}, RiskFactorEmissions).Risk)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// signingCaller returns the caller's address once signing is configured
+func (a *App) signingCaller(c *gin.Context) (common.Address, bool) {
+	if a.signing == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "signing_not_configured",
+			Message: "Wallet signing needs an ActionContract address",
+		})
+		return common.Address{}, false
+	}
+	caller, ok := requireCaller(c)
+	if !ok {
+		return common.Address{}, false
+	}
+	return common.HexToAddress(caller), true
+}
+
+// respondSigningError maps a signing request failure to a response
+func (a *App) respondSigningError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSigningRequestNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "signing_request_not_found",
+			Message: "Signing request not found",
+		})
+	case errors.Is(err, services.ErrSigningForbidden):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "forbidden",
+			Message: "Signing request belongs to another address",
+		})
+	case errors.Is(err, services.ErrSigningRequestExpired):
+		c.JSON(http.StatusGone, ErrorResponse{
+			Error:   "signing_request_expired",
+			Message: "Signing request has expired; ask again to prepare a new one",
+		})
+	case errors.Is(err, services.ErrSigningRequestClosed):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "already_submitted",
+			Message: "Signing request was already submitted",
+		})
+	case errors.Is(err, services.ErrActionsSuspended):
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "actions_suspended",
+			Message: "On-chain actions are temporarily suspended; submit again once they resume",
+		})
+	case errors.Is(err, services.ErrSignedTxMismatch):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "transaction_mismatch",
+			Message: err.Error(),
+		})
+	default:
+		a.logger.WithError(err).Error("Failed to broadcast signed transaction")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "broadcast_failed",
+			Message: "Failed to broadcast the signed transaction",
+		})
+	}
+}
+
+// getSigningRequest returns one of the caller's signing requests, for
+// wallets that missed the sign_request frame
+func (a *App) getSigningRequest(c *gin.Context) {
+	caller, ok := a.signingCaller(c)
+	if !ok {
+		return
+	}
+
+	request, err := a.signing.Get(c.Param("id"), caller)
+	if err != nil {
+		a.respondSigningError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+// submitSigningRequest broadcasts the raw transaction the caller's wallet
+// signed for a signing request
+func (a *App) submitSigningRequest(c *gin.Context) {
+	caller, ok := a.signingCaller(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		RawTransaction string `json:"raw_transaction" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must carry the signed raw_transaction as hex",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	request, err := a.signing.Submit(ctx, c.Param("id"), caller, body.RawTransaction)
+	if err != nil {
+		a.respondSigningError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"kaia-analytics-backend/services"
+)
+
+// dialChain connects to the configured RPC endpoints. In simulated mode it
+// creates a simulated chain instead, unstarted, and points the contract
+// addresses and tracked tokens left unset at its mocks.
+func dialChain(ctx context.Context, config *Config, opts services.FailoverOptions) (*services.FailoverClient, *services.SimulatedChain, error) {
+	if !config.IsSimulated() {
+		client, err := services.DialFailoverClient(ctx, config.EthNodeURLs, opts)
+		return client, nil, err
+	}
+
+	chainID := config.NetworkID
+	if chainID == 0 {
+		chainID = services.DefaultSimulatedChainID
+	}
+	simulated := services.NewSimulatedChain(chainID, time.Now().UTC().Truncate(time.Second))
+	contracts := simulated.Contracts()
+	defaultAddress(&config.AnalyticsRegistryAddress, contracts.AnalyticsRegistry)
+	defaultAddress(&config.ActionContractAddress, contracts.ActionContract)
+	defaultAddress(&config.SubscriptionContractAddress, contracts.SubscriptionContract)
+	if strings.TrimSpace(config.TrackedTokens) == "" {
+		entries := make([]string, 0, 3)
+		for _, token := range contracts.TrackedTokens() {
+			entries = append(entries, fmt.Sprintf("%s:%s:%d", token.Symbol, token.Address.Hex(), token.Decimals))
+		}
+		config.TrackedTokens = strings.Join(entries, ",")
+	}
+
+	client := services.NewFailoverClient([]services.NamedChainClient{{Name: "simulated", Client: simulated}}, opts)
+	return client, simulated, nil
+}
+
+// defaultAddress sets an unset or zero address setting to the address
+func defaultAddress(setting *string, address common.Address) {
+	if !common.IsHexAddress(*setting) || common.HexToAddress(*setting) == (common.Address{}) {
+		*setting = address.Hex()
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func setupSimulatedApp(t *testing.T) (*App, *services.SimulatedChain) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	config := &Config{Environment: "development", ChainMode: ChainModeSimulated}
+	client, simulated, err := dialChain(context.Background(), config, services.DefaultFailoverOptions())
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	chainID, err := client.ChainID(context.Background())
+	require.NoError(t, err)
+
+	app := &App{
+		router:    gin.New(),
+		logger:    logger,
+		ethClient: client,
+		history:   services.NewHistoryRouter(client),
+		rpc:       client,
+		config:    config,
+		signing:   services.NewSigningService(client, chainID),
+		sessions:  services.NewSessions(),
+	}
+	app.router.Use(app.authenticate())
+	app.router.GET("/api/v1/block/:number", app.getBlockByNumber)
+	app.router.GET("/api/v1/address/:address/balance", app.getAddressBalance)
+	app.router.GET("/api/v1/signing/:id", app.getSigningRequest)
+	app.router.POST("/api/v1/signing/:id/submit", app.submitSigningRequest)
+	return app, simulated
+}
+
+func TestSimulatedChainMode(t *testing.T) {
+	app, simulated := setupSimulatedApp(t)
+	ctx := context.Background()
+	user := services.SimulatedAccounts()[1]
+	caller := user.Address.Hex()
+
+	assert.Equal(t, simulated.Contracts().ActionContract.Hex(), app.config.ActionContractAddress)
+	trackedTokens, err := services.ParseTrackedTokens(app.config.TrackedTokens)
+	require.NoError(t, err)
+	assert.Equal(t, simulated.Contracts().TrackedTokens(), trackedTokens)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", signInAs(app, caller))
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+	var block map[string]interface{}
+	var balance struct {
+		Balance string `json:"balance"`
+	}
+
+	w := serve("GET", "/api/v1/address/"+caller+"/balance", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &balance))
+	assert.Equal(t, "10000000000000000000000", balance.Balance)
+
+	// A stake request signed by the funded account is mined in the next block
+	request, err := services.NewActionRequestBuilder(app.ethClient, common.HexToAddress(app.config.ActionContractAddress)).Build(ctx, "stake", map[string]interface{}{"amount": "5"})
+	require.NoError(t, err)
+	prepared, err := app.signing.Prepare(ctx, user.Address, services.SigningPrompt{Description: "Stake 5 KAIA"}, request)
+	require.NoError(t, err)
+	gasPrice, _ := new(big.Int).SetString(prepared.Transaction.GasPrice, 10)
+	signed, err := types.SignTx(types.NewTx(&types.LegacyTx{
+		Nonce:    prepared.Transaction.Nonce,
+		To:       request.To,
+		Value:    request.Value,
+		Data:     request.Data,
+		Gas:      prepared.Transaction.Gas,
+		GasPrice: gasPrice,
+	}), types.LatestSignerForChainID(big.NewInt(services.DefaultSimulatedChainID)), user.Key)
+	require.NoError(t, err)
+	raw, err := signed.MarshalBinary()
+	require.NoError(t, err)
+
+	w = serve("POST", "/api/v1/signing/"+prepared.ID+"/submit", `{"raw_transaction":"`+hexutil.Encode(raw)+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	simulated.Commit()
+	app.signing.Track(ctx)
+
+	w = serve("GET", "/api/v1/signing/"+prepared.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status services.SigningRequest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, services.SigningStatusMined, status.Status)
+	assert.Equal(t, signed.Hash().Hex(), status.TxHash)
+
+	w = serve("GET", "/api/v1/block/latest", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&block))
+	assert.Equal(t, "1", fmt.Sprint(block["number"]))
+	head, err := simulated.BlockByNumber(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, head.Hash().Hex(), block["hash"])
+	assert.NotZero(t, block["gas_used"])
+	assert.Equal(t, http.StatusOK, serve("GET", "/api/v1/block/0", "").Code)
+
+	// The balance paid the stake fee and gas
+	w = serve("GET", "/api/v1/address/"+caller+"/balance", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &balance))
+	spent, ok := new(big.Int).SetString(balance.Balance, 10)
+	require.True(t, ok)
+	spent.Sub(new(big.Int).Mul(big.NewInt(10_000), big.NewInt(1e18)), spent)
+	assert.Equal(t, 1, spent.Cmp(request.Value))
+}
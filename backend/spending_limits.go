@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"kaia-analytics-backend/services"
+)
+
+// getSpendingLimits returns the caller's action spending limits, any raise
+// still waiting to apply, and what they have left today
+func (a *App) getSpendingLimits(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	status, err := a.spending.Status(c.Request.Context(), userID)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to read spending limits")
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "spending_limits_unavailable",
+			Message: "Spending limits can't be read; actions are refused until they can",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// updateSpendingLimits sets the caller's action spending limits. Lower
+// limits apply at once; raised ones after services.SpendingLimitIncreaseDelay.
+func (a *App) updateSpendingLimits(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var limits services.SpendingLimits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err := limits.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_spending_limits",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	status, err := a.spending.Request(c.Request.Context(), userID, limits)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to save spending limits")
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "spending_limits_unavailable",
+			Message: "Failed to save the spending limits",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// overrideSpendingLimits sets a user's spending limits at once, raised or
+// not. It needs who is making the change and why, which are kept with the
+// user's limits.
+func (a *App) overrideSpendingLimits(c *gin.Context) {
+	if !common.IsHexAddress(c.Param("address")) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	var request struct {
+		services.SpendingLimits
+		By     string `json:"by"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+	by, reason := strings.TrimSpace(request.By), strings.TrimSpace(request.Reason)
+	if by == "" || reason == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "by and reason are required",
+		})
+		return
+	}
+	if err := request.SpendingLimits.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_spending_limits",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	userID := strings.ToLower(c.Param("address"))
+	status, err := a.spending.Override(c.Request.Context(), userID, request.SpendingLimits, by, reason)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to override spending limits")
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "spending_limits_unavailable",
+			Message: "Failed to save the spending limits",
+		})
+		return
+	}
+
+	a.logger.WithFields(logrus.Fields{
+		"user":           userID,
+		"daily_usd":      request.DailyUSD,
+		"per_action_usd": request.PerActionUSD,
+		"by":             by,
+		"reason":         reason,
+	}).Warn("Spending limits overridden")
+	c.JSON(http.StatusOK, status)
+}
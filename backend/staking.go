@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getStakingValidators lists the validators with their commission, uptime,
+// stake, and reward rates, sorted by apr, commission, or stake
+func (a *App) getStakingValidators(c *gin.Context) {
+	if a.staking == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "staking_not_configured",
+			Message: "No staking registry is configured",
+		})
+		return
+	}
+
+	sortBy := c.DefaultQuery("sort", services.ValidatorSortAPR)
+	validators, err := a.staking.Validators(sortBy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_sort",
+			Message: "Sort must be apr, commission, or stake",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sort":       sortBy,
+		"validators": validators,
+		"total":      len(validators),
+	})
+}
+
+// getStakingPositions lists the address's open staking pool positions, or
+// with scope=all_wallets those of the caller's linked wallets, with their
+// lock expiry and accrued rewards
+func (a *App) getStakingPositions(c *gin.Context) {
+	if a.positions == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "staking_pools_not_configured",
+			Message: "No staking pools are configured",
+		})
+		return
+	}
+
+	addressStr := c.Param("address")
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	address := common.HexToAddress(addressStr)
+	wallets, ok := a.scopeWallets(c, address)
+	if !ok {
+		return
+	}
+	positions := make([]services.StakingPosition, 0)
+	for _, wallet := range wallets {
+		positions = append(positions, a.positions.Positions(c.Request.Context(), wallet)...)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"address":   address.Hex(),
+		"positions": positions,
+		"total":     len(positions),
+	})
+}
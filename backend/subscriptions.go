@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getSubscriptionPlans lists the subscription plans with their limits. For a
+// signed-in caller it adds their current plan, recent usage, and upgrade
+// hints.
+func (a *App) getSubscriptionPlans(c *gin.Context) {
+	caller, _ := callerAddress(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	catalog, err := a.subscriptions.Catalog(ctx, caller)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to read subscription plans")
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "subscription_plans_failed",
+			Message: "Failed to read subscription plans from the subscription contract",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, catalog)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+// fixtureChain serves fixture transactions and receipts. The embedded
+// interface is nil, so anything else panics.
+type fixtureChain struct {
+	services.ChainClient
+
+	txs      map[common.Hash]*types.Transaction
+	pending  map[common.Hash]bool
+	receipts map[common.Hash]*types.Receipt
+}
+
+func (fc *fixtureChain) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	tx, ok := fc.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, fc.pending[hash], nil
+}
+
+func (fc *fixtureChain) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if fc.pending[txHash] {
+		return nil, errors.New("receipt of a pending transaction requested")
+	}
+	receipt, ok := fc.receipts[txHash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func TestGetTransactionByHash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	key := services.SimulatedAccounts()[0].Key
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	signer := types.LatestSignerForChainID(big.NewInt(services.DefaultSimulatedChainID))
+	sign := func(nonce uint64, to *common.Address) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   big.NewInt(services.DefaultSimulatedChainID),
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(25e9),
+			Gas:       21000,
+			To:        to,
+			Value:     big.NewInt(1e18),
+		})
+		require.NoError(t, err)
+		return tx
+	}
+	mined := func(tx *types.Transaction, status uint64) *types.Receipt {
+		return &types.Receipt{TxHash: tx.Hash(), Status: status, GasUsed: 21000, BlockNumber: big.NewInt(42), BlockHash: common.HexToHash("0x42"), TransactionIndex: 3}
+	}
+
+	pending := sign(0, &recipient)
+	succeeded := sign(1, &recipient)
+	failed := sign(2, &recipient)
+	unindexed := sign(3, &recipient)
+	creation := sign(4, nil)
+	created := crypto.CreateAddress(sender, 4)
+	chain := &fixtureChain{
+		txs:     map[common.Hash]*types.Transaction{},
+		pending: map[common.Hash]bool{pending.Hash(): true},
+		receipts: map[common.Hash]*types.Receipt{
+			succeeded.Hash(): mined(succeeded, types.ReceiptStatusSuccessful),
+			failed.Hash():    mined(failed, types.ReceiptStatusFailed),
+			creation.Hash():  mined(creation, types.ReceiptStatusSuccessful),
+		},
+	}
+	for _, tx := range []*types.Transaction{pending, succeeded, failed, unindexed, creation} {
+		chain.txs[tx.Hash()] = tx
+	}
+	chain.receipts[creation.Hash()].ContractAddress = created
+
+	app := &App{router: gin.New(), logger: logger, ethClient: chain}
+	app.router.GET("/api/v1/transaction/:hash", app.getTransactionByHash)
+	get := func(hash common.Hash) (int, TransactionResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/transaction/"+hash.Hex(), nil)
+		app.router.ServeHTTP(w, req)
+		var response TransactionResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w.Code, response
+	}
+
+	code, response := get(pending.Hash())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.TxStatusPending, response.Status)
+	assert.Equal(t, sender.Hex(), response.From)
+	assert.Equal(t, recipient.Hex(), response.To)
+	assert.Empty(t, response.BlockNumber)
+	assert.Nil(t, response.TransactionIndex)
+
+	code, response = get(succeeded.Hash())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.TxStatusSuccess, response.Status)
+	assert.Equal(t, sender.Hex(), response.From)
+	assert.Equal(t, "42", response.BlockNumber)
+	require.NotNil(t, response.TransactionIndex)
+	assert.Equal(t, uint(3), *response.TransactionIndex)
+	assert.Equal(t, uint64(21000), response.GasUsed)
+
+	code, response = get(failed.Hash())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.TxStatusFailed, response.Status)
+
+	// Mined, but the node hasn't indexed the receipt yet
+	code, response = get(unindexed.Hash())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.TxStatusPending, response.Status)
+
+	code, response = get(creation.Hash())
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, response.ContractCreation)
+	assert.Empty(t, response.To)
+	assert.Equal(t, created.Hex(), response.ContractAddress)
+
+	// A pending creation's address is computed from the sender and nonce
+	chain.pending[creation.Hash()] = true
+	code, response = get(creation.Hash())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, services.TxStatusPending, response.Status)
+	assert.Equal(t, created.Hex(), response.ContractAddress)
+
+	code, _ = get(common.HexToHash("0x1234"))
+	assert.Equal(t, http.StatusNotFound, code)
+}
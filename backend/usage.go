@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// recordUsage counts the requests of signed-in callers, along with the chat
+// messages and analytics tasks they submit
+func (a *App) recordUsage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		address, ok := callerAddress(c)
+		route := c.FullPath()
+		if !ok || route == "" || a.usage == nil {
+			return
+		}
+
+		a.usage.RecordRequest(address, c.Request.Method+" "+route, c.Writer.Status() >= http.StatusBadRequest)
+		if c.Request.Method != http.MethodPost || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		switch {
+		case route == "/api/v1/chat/message":
+			a.usage.Record(address, services.UsageChatMessages)
+		case strings.HasPrefix(route, "/api/v1/analytics/"):
+			a.usage.Record(address, services.UsageAnalyticsTasks)
+		}
+	}
+}
+
+// recordChatUsage counts a WebSocket chat message of a user identified by address
+func (a *App) recordChatUsage(userID string) {
+	if a.usage != nil && common.IsHexAddress(userID) {
+		a.usage.Record(userID, services.UsageChatMessages)
+	}
+}
+
+// parseUsageWindow reads the window query parameter, aborting the request
+// when it is malformed
+func parseUsageWindow(c *gin.Context) (time.Time, bool) {
+	window, err := parseWindow(c.DefaultQuery("window", "30d"))
+	if err != nil || window <= 0 || window > services.UsageRetention {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_window",
+			Message: "Window must be a duration such as 30d or 12h, up to 90d",
+		})
+		return time.Time{}, false
+	}
+	return time.Now().Add(-window), true
+}
+
+// getUsage lists the activity of every address over a window, busiest first
+func (a *App) getUsage(c *gin.Context) {
+	since, ok := parseUsageWindow(c)
+	if !ok {
+		return
+	}
+
+	sortBy := c.DefaultQuery("sort", services.UsageRequests)
+	if !services.ValidUsageKind(sortBy) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_sort",
+			Message: "Sort must be requests, chat_messages, analytics_tasks, or actions",
+		})
+		return
+	}
+
+	limit, offset, ok := parsePagination(c, 50, 500)
+	if !ok {
+		return
+	}
+
+	usage := a.usage.Usage(since, sortBy)
+	page := usage[min(offset, len(usage)):min(offset+limit, len(usage))]
+	c.JSON(http.StatusOK, gin.H{
+		"since":  since,
+		"sort":   sortBy,
+		"users":  page,
+		"total":  len(usage),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// getAddressUsage returns the endpoint breakdown and error rate of an address
+func (a *App) getAddressUsage(c *gin.Context) {
+	addressStr := c.Param("address")
+	if !common.IsHexAddress(addressStr) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	since, ok := parseUsageWindow(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, a.usage.AddressUsage(common.HexToAddress(addressStr).Hex(), since))
+}
+
+// getUserUsage returns the caller's own activity
+func (a *App) getUserUsage(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	since, ok := parseUsageWindow(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, a.usage.AddressUsage(userID, since))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+const (
+	usageAlice = "0x00000000000000000000000000000000000000a1"
+	usageBob   = "0x00000000000000000000000000000000000000b2"
+)
+
+func setupUsageApp() *App {
+	gin.SetMode(gin.TestMode)
+
+	app := &App{
+		router:   gin.New(),
+		config:   &Config{AdminAPIKey: "secret"},
+		usage:    services.NewUsageTracker(),
+		sessions: services.NewSessions(),
+	}
+	app.router.Use(app.authenticate(), app.recordUsage())
+
+	v1 := app.router.Group("/api/v1")
+	v1.POST("/analytics/yield", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	v1.GET("/user/usage", app.getUserUsage)
+	admin := v1.Group("/admin", app.requireAdmin())
+	admin.GET("/usage", app.getUsage)
+	admin.GET("/usage/:address", app.getAddressUsage)
+	return app
+}
+
+func usageRequest(app *App, method, path, caller, adminKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if caller != "" {
+		req.Header.Set("Authorization", signInAs(app, caller))
+	}
+	if adminKey != "" {
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+	}
+	app.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUsageSelfAndAdminViews(t *testing.T) {
+	app := setupUsageApp()
+
+	usageRequest(app, "POST", "/api/v1/analytics/yield", usageAlice, "")
+	usageRequest(app, "POST", "/api/v1/analytics/yield", usageAlice, "")
+	usageRequest(app, "POST", "/api/v1/analytics/yield", usageBob, "")
+
+	// Users only see their own stats
+	w := usageRequest(app, "GET", "/api/v1/user/usage", usageBob, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var own services.AddressUsageDetail
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &own))
+	assert.Equal(t, usageBob, own.Address)
+	assert.Equal(t, int64(1), own.AnalyticsTasks)
+	assert.Equal(t, int64(1), own.Requests, "the usage request itself is counted after it responds")
+
+	w = usageRequest(app, "GET", "/api/v1/user/usage", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A session doesn't grant access to the admin views
+	w = usageRequest(app, "GET", "/api/v1/admin/usage", usageAlice, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = usageRequest(app, "GET", "/api/v1/admin/usage/"+usageBob, "", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = usageRequest(app, "GET", "/api/v1/admin/usage?sort=analytics_tasks", "", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Users []services.AddressUsage `json:"users"`
+		Total int                     `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Equal(t, 2, list.Total)
+	assert.Equal(t, usageAlice, list.Users[0].Address)
+	assert.Equal(t, int64(2), list.Users[0].AnalyticsTasks)
+
+	w = usageRequest(app, "GET", "/api/v1/admin/usage/"+usageAlice, "", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var detail services.AddressUsageDetail
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	assert.Equal(t, services.EndpointUsage{Endpoint: "POST /api/v1/analytics/yield", Requests: 2}, detail.Endpoints[0])
+	assert.Len(t, detail.Endpoints, 2, "the rejected admin request is counted as an error")
+	assert.InDelta(t, 1.0/3, detail.ErrorRate, 1e-9)
+
+	w = usageRequest(app, "GET", "/api/v1/admin/usage?sort=volume", "", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
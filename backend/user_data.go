@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// requestUserExport starts assembling everything kept about the caller
+func (a *App) requestUserExport(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	export, err := a.userData.RequestExport(userID)
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to start user data export")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "export_failed",
+			Message: "Failed to start the export",
+		})
+		return
+	}
+
+	c.Header("Location", "/api/v1/user/export/"+export.ID)
+	c.JSON(http.StatusAccepted, gin.H{"export": export})
+}
+
+// getUserExport returns the status of one of the caller's exports, with a
+// signed download link once it is ready
+func (a *App) getUserExport(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	export, ok := a.userData.Export(userID, c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "export_not_found",
+			Message: "Export not found or expired",
+		})
+		return
+	}
+
+	response := gin.H{"export": export}
+	if export.Status == services.UserExportReady {
+		query := url.Values{}
+		query.Set("expires", strconv.FormatInt(export.ExpiresAt.Unix(), 10))
+		query.Set("signature", a.userData.Signature(export.ID, export.ExpiresAt.Time))
+		response["download_url"] = "/api/v1/user/export/" + export.ID + "/download?" + query.Encode()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// downloadUserExport serves a ready export. The signed link is the only
+// credential, so it can be opened outside the app.
+func (a *App) downloadUserExport(c *gin.Context) {
+	id := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_link",
+			Message: "Download link is missing its expiry",
+		})
+		return
+	}
+
+	document, err := a.userData.Download(id, expires, c.Query("signature"))
+	switch {
+	case errors.Is(err, services.ErrUserExportLinkInvalid):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "invalid_link",
+			Message: err.Error(),
+		})
+		return
+	case errors.Is(err, services.ErrUserExportNotFound):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "export_not_found",
+			Message: "Export not found or expired",
+		})
+		return
+	case errors.Is(err, services.ErrUserExportNotReady):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "export_not_ready",
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		a.logger.WithError(err).Error("Failed to download user data export")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "export_failed",
+			Message: "Failed to download the export",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="kaia-analytics-export-`+id+`.json"`)
+	c.Data(http.StatusOK, "application/json", document)
+}
+
+// eraseUserData deletes everything kept about the caller. Without a confirm
+// token it issues one instead; repeating the request with it and the wallet's
+// signature of the erasure message erases the data, so a session alone can't.
+func (a *App) eraseUserData(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	token := c.Query("confirm")
+	if token == "" {
+		token, expiresAt, err := a.userData.RequestErasure(userID)
+		if err != nil {
+			a.logger.WithError(err).Error("Failed to issue erasure confirmation")
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "erasure_failed",
+				Message: "Failed to issue a confirmation token",
+			})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"confirmation_token": token,
+			"signing_message":    services.UserErasureMessage(userID, token),
+			"expires_at":         services.NewAPITime(expiresAt),
+			"message":            "Sign signing_message with your wallet and repeat this request with ?confirm=<confirmation_token>&signature=<signature> to erase your data; this can't be undone",
+		})
+		return
+	}
+
+	erasure, err := a.userData.Erase(userID, token, c.Query("signature"))
+	if errors.Is(err, services.ErrErasureTokenInvalid) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_confirmation",
+			Message: err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, services.ErrErasureSignature) {
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "invalid_signature",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		a.logger.WithError(err).Error("Failed to erase user data")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "erasure_failed",
+			Message: "Failed to erase your data",
+		})
+		return
+	}
+
+	a.logger.WithField("user", userID).Info("Erased user data")
+	c.JSON(http.StatusOK, erasure)
+}
+
+// getUserErasures lists the erasures performed, newest first
+func (a *App) getUserErasures(c *gin.Context) {
+	erasures := a.userData.Erasures()
+	c.JSON(http.StatusOK, gin.H{
+		"erasures": erasures,
+		"count":    len(erasures),
+	})
+}
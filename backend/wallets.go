@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// Portfolio endpoints report one address by default, or with
+// ?scope=all_wallets the address and the wallets linked to it
+const (
+	scopeAddress    = "address"
+	scopeAllWallets = "all_wallets"
+)
+
+// scopeWallets returns the wallets a portfolio request covers. Linked wallets
+// are private, so only their owner can ask for them together.
+func (a *App) scopeWallets(c *gin.Context, address common.Address) ([]common.Address, bool) {
+	switch c.DefaultQuery("scope", scopeAddress) {
+	case scopeAddress:
+		return []common.Address{address}, true
+	case scopeAllWallets:
+		caller, ok := requireCaller(c)
+		if !ok {
+			return nil, false
+		}
+		if !strings.EqualFold(caller, address.Hex()) {
+			c.JSON(http.StatusForbidden, ErrorResponse{
+				Error:   "forbidden",
+				Message: "Only the owner of the linked wallets can report them together",
+			})
+			return nil, false
+		}
+		return a.walletLinks.Wallets(address), true
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_scope",
+			Message: "Scope must be address or all_wallets",
+		})
+		return nil, false
+	}
+}
+
+// getLinkedWallets lists the wallets linked to the caller
+func (a *App) getLinkedWallets(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	wallets := a.walletLinks.Linked(common.HexToAddress(userID))
+	c.JSON(http.StatusOK, gin.H{
+		"owner":   common.HexToAddress(userID).Hex(),
+		"wallets": wallets,
+		"total":   len(wallets),
+	})
+}
+
+// linkWallets links wallets to the caller. Each one must sign the message
+// services.WalletLinkMessage names it and the caller in, within
+// services.WalletLinkProofTTL of issued_at.
+func (a *App) linkWallets(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Wallets []services.WalletLinkProof `json:"wallets" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must list the wallets to link with their address, signature and issued_at",
+		})
+		return
+	}
+
+	wallets, err := a.walletLinks.Link(common.HexToAddress(userID), request.Wallets)
+	switch {
+	case errors.Is(err, services.ErrWalletLinkSignature):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_signature",
+			Message: err.Error(),
+		})
+	case errors.Is(err, services.ErrWalletLinkExpired):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "link_expired",
+			Message: err.Error(),
+		})
+	case errors.Is(err, services.ErrWalletLinkedElsewhere):
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "wallet_linked_elsewhere",
+			Message: err.Error(),
+		})
+	case errors.Is(err, services.ErrTooManyLinkedWallets):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "too_many_wallets",
+			Message: err.Error(),
+		})
+	case err != nil:
+		a.logger.WithError(err).Error("Failed to link wallets")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "link_failed",
+			Message: "Failed to link wallets",
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"owner":   common.HexToAddress(userID).Hex(),
+			"wallets": wallets,
+			"total":   len(wallets),
+		})
+	}
+}
+
+// unlinkWallet removes one of the caller's linked wallets
+func (a *App) unlinkWallet(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+	if !common.IsHexAddress(c.Param("address")) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	err := a.walletLinks.Unlink(common.HexToAddress(userID), common.HexToAddress(c.Param("address")))
+	if errors.Is(err, services.ErrWalletNotLinked) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "wallet_not_linked",
+			Message: "Wallet is not linked to the caller",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kaia-analytics-backend/services"
+)
+
+func TestLinkWalletsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := &App{router: gin.New(), logger: logrus.New(), walletLinks: services.NewWalletLinks(), sessions: services.NewSessions()}
+	app.router.Use(app.authenticate())
+	app.router.GET("/user/wallets", app.getLinkedWallets)
+	app.router.POST("/user/wallets", app.linkWallets)
+	app.router.DELETE("/user/wallets/:address", app.unlinkWallet)
+	app.router.GET("/address/:address/scope", func(c *gin.Context) {
+		if wallets, ok := app.scopeWallets(c, common.HexToAddress(c.Param("address"))); ok {
+			c.JSON(http.StatusOK, gin.H{"wallets": len(wallets)})
+		}
+	})
+
+	owner := "0x00000000000000000000000000000000000000aa"
+	request := func(method, path, caller string, body interface{}) *httptest.ResponseRecorder {
+		encoded, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewReader(encoded))
+		if caller != "" {
+			req.Header.Set("Authorization", signInAs(app, caller))
+		}
+		app.router.ServeHTTP(w, req)
+		return w
+	}
+	sign := func(signer string) services.WalletLinkProof {
+		key, err := crypto.GenerateKey()
+		require.NoError(t, err)
+		wallet := crypto.PubkeyToAddress(key.PublicKey)
+		if signer == "" {
+			signer = wallet.Hex()
+		}
+		issuedAt := time.Now().UTC().Truncate(time.Second)
+		signature, err := crypto.Sign(accounts.TextHash([]byte(services.WalletLinkMessage(common.HexToAddress(owner), common.HexToAddress(signer), issuedAt))), key)
+		require.NoError(t, err)
+		signature[crypto.RecoveryIDOffset] += 27
+		return services.WalletLinkProof{Address: signer, Signature: hexutil.Encode(signature), IssuedAt: issuedAt}
+	}
+
+	// A signature from a key other than the wallet's is rejected
+	w := request("POST", "/user/wallets", owner, gin.H{"wallets": []services.WalletLinkProof{sign("0x00000000000000000000000000000000000000bb")}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_signature")
+
+	first, second := sign(""), sign("")
+	w = request("POST", "/user/wallets", owner, gin.H{"wallets": []services.WalletLinkProof{first, second}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var linked struct {
+		Wallets []services.LinkedWallet `json:"wallets"`
+		Total   int                     `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &linked))
+	assert.Equal(t, 2, linked.Total)
+
+	// Only the owner can report the wallets together
+	w = request("GET", "/address/"+owner+"/scope?scope=all_wallets", owner, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"wallets":3}`, w.Body.String())
+	w = request("GET", "/address/"+owner+"/scope?scope=all_wallets", first.Address, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request("GET", "/address/"+owner+"/scope?scope=everything", owner, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request("DELETE", "/user/wallets/"+first.Address, owner, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = request("DELETE", "/user/wallets/"+first.Address, owner, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request("GET", "/user/wallets", owner, nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &linked))
+	assert.Equal(t, 1, linked.Total)
+}
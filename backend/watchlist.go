@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// getWatchlist lists the addresses the caller watches
+func (a *App) getWatchlist(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	watched := a.watchlist.List(userID)
+	c.JSON(http.StatusOK, gin.H{
+		"addresses": watched,
+		"total":     len(watched),
+	})
+}
+
+// watchAddress adds an address to the caller's watchlist. Its transactions
+// moving at least min_value KAIA are alerted in chat, from the mempool where
+// the node allows.
+func (a *App) watchAddress(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Address  string  `json:"address" binding:"required"`
+		Label    string  `json:"label"`
+		MinValue float64 `json:"min_value"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must name the address to watch, with an optional label and min_value",
+		})
+		return
+	}
+	if !common.IsHexAddress(request.Address) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	watched, err := a.watchlist.Watch(userID, common.HexToAddress(request.Address), request.Label, request.MinValue)
+	switch {
+	case errors.Is(err, services.ErrWatchlistFull):
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "watchlist_full",
+			Message: err.Error(),
+		})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+	default:
+		c.JSON(http.StatusOK, watched)
+	}
+}
+
+// unwatchAddress removes an address from the caller's watchlist
+func (a *App) unwatchAddress(c *gin.Context) {
+	userID, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+	if !common.IsHexAddress(c.Param("address")) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_address",
+			Message: "Address must be a valid Ethereum address",
+		})
+		return
+	}
+
+	if err := a.watchlist.Unwatch(userID, common.HexToAddress(c.Param("address"))); errors.Is(err, services.ErrNotWatched) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_watched",
+			Message: "Address is not on the caller's watchlist",
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
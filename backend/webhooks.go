@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"kaia-analytics-backend/services"
+)
+
+// createWebhook registers a webhook for the caller
+func (a *App) createWebhook(c *gin.Context) {
+	owner, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	webhook, err := a.webhooks.RegisterWebhook(owner, request.URL, request.Events)
+	if errors.Is(err, services.ErrWebhookLimit) {
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Error:   "too_many_webhooks",
+			Message: "Delete a webhook before registering another",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_webhook",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// The secret is only ever returned on creation
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// listWebhooks returns the caller's webhooks
+func (a *App) listWebhooks(c *gin.Context) {
+	owner, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": a.webhooks.ListWebhooks(owner),
+	})
+}
+
+// deleteWebhook removes one of the caller's webhooks
+func (a *App) deleteWebhook(c *gin.Context) {
+	owner, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	if !a.webhooks.RemoveWebhook(owner, c.Param("id")) {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "webhook_not_found",
+			Message: "Webhook not found",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getWebhookDeliveries returns the delivery log of one of the caller's webhooks
+func (a *App) getWebhookDeliveries(c *gin.Context) {
+	owner, ok := requireCaller(c)
+	if !ok {
+		return
+	}
+
+	webhook, found := a.webhooks.GetWebhook(owner, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "webhook_not_found",
+			Message: "Webhook not found",
+		})
+		return
+	}
+	deliveries, _ := a.webhooks.DeliveryLog(owner, webhook.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhook":    webhook,
+		"deliveries": deliveries,
+	})
+}
@@ -147,18 +147,18 @@ const SearchResults: React.FC<SearchResultsProps> = ({ type, data, loading }) =>
               Transaction Details
             </h3>
           </div>
-          {txData.status !== undefined && (
+          {txData.status !== 'pending' && (
             <div className={`flex items-center space-x-1 px-2 py-1 rounded-full text-xs font-medium ${
-              txData.status === 1 
+              txData.status === 'success' 
                 ? 'bg-green-100 text-green-800' 
                 : 'bg-red-100 text-red-800'
             }`}>
-              {txData.status === 1 ? (
+              {txData.status === 'success' ? (
                 <CheckCircle className="w-3 h-3" />
               ) : (
                 <XCircle className="w-3 h-3" />
               )}
-              {txData.status === 1 ? 'Success' : 'Failed'}
+              {txData.status === 'success' ? 'Success' : 'Failed'}
             </div>
           )}
         </div>
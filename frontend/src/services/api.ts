@@ -61,13 +61,16 @@ export interface TransactionResponse {
   gas: number;
   gas_price: string;
   gas_used?: number;
-  status?: number;
+  status: 'pending' | 'success' | 'failed';
+  contract_creation?: boolean;
+  contract_address?: string;
 }
 
 export interface BalanceResponse {
   address: string;
   balance: string;
   balance_eth: string;
+  block_number: number;
 }
 
 export interface NetworkStatsResponse {
@@ -83,6 +86,7 @@ export interface ContractInfoResponse {
   code: string;
   code_size: number;
   is_contract: boolean;
+  block_number: number;
 }
 
 export interface ErrorResponse {